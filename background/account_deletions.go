@@ -0,0 +1,96 @@
+package background
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/riverqueue/river"
+
+	authServices "github.com/cavenine/queryops/features/auth/services"
+	"github.com/cavenine/queryops/internal/notify"
+)
+
+// accountDeletionBatch caps how many accounts one run deletes; the rest wait
+// for the next run.
+const accountDeletionBatch = 100
+
+// DeleteAccountsArgs deletes the accounts whose confirmed deletion is due.
+type DeleteAccountsArgs struct{}
+
+func (DeleteAccountsArgs) Kind() string {
+	return "delete_accounts"
+}
+
+func (DeleteAccountsArgs) InsertOpts() river.InsertOpts {
+	return river.InsertOpts{Queue: QueueMaintenance}
+}
+
+func init() {
+	Periodic.Register(PeriodicJob{
+		Name:     "delete_accounts",
+		Schedule: "@hourly",
+		Args:     func() river.JobArgs { return DeleteAccountsArgs{} },
+		Jitter:   5 * time.Minute,
+	})
+}
+
+type accountDeleter interface {
+	ListDue(ctx context.Context, now time.Time, limit int) ([]int, error)
+	Delete(ctx context.Context, userID int, now time.Time) (*authServices.DeletedAccount, error)
+}
+
+type DeleteAccountsWorker struct {
+	river.WorkerDefaults[DeleteAccountsArgs]
+
+	accounts accountDeleter
+	mailer   notify.Mailer
+}
+
+func NewDeleteAccountsWorker(accounts accountDeleter, mailer notify.Mailer) *DeleteAccountsWorker {
+	return &DeleteAccountsWorker{accounts: accounts, mailer: mailer}
+}
+
+func (w *DeleteAccountsWorker) Work(ctx context.Context, _ *river.Job[DeleteAccountsArgs]) error {
+	now := time.Now()
+	ids, err := w.accounts.ListDue(ctx, now, accountDeletionBatch)
+	if err != nil {
+		return err
+	}
+
+	var failed int
+	for _, id := range ids {
+		deleted, err := w.accounts.Delete(ctx, id, now)
+		switch {
+		case errors.Is(err, authServices.ErrAccountDeletionNotFound):
+			// Cancelled since it was listed.
+			continue
+		case errors.Is(err, authServices.ErrSoleOwner):
+			// An owner left after the user confirmed. The deletion waits
+			// until the organization has another owner or is deleted.
+			slog.WarnContext(ctx, "account deletion is waiting for an organization to get another owner", "user_id", id)
+			continue
+		case err != nil:
+			slog.ErrorContext(ctx, "failed to delete account", "user_id", id, "error", err)
+			failed++
+			continue
+		}
+		slog.InfoContext(ctx, "account deleted",
+			"user_id", deleted.UserID,
+			"campaigns_reassigned", deleted.CampaignsReassigned,
+			"campaigns_orphaned", deleted.CampaignsOrphaned,
+		)
+
+		body := "Your QueryOps account has been deleted, as you asked. " +
+			"Your credentials, sessions, and organization memberships are gone.\n"
+		if err := w.mailer.Send(ctx, []string{deleted.Email}, "Your QueryOps account has been deleted", body); err != nil {
+			slog.WarnContext(ctx, "failed to send account deletion notice", "user_id", deleted.UserID, "error", err)
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("failed to delete %d of %d accounts", failed, len(ids))
+	}
+	return nil
+}
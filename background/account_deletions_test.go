@@ -0,0 +1,72 @@
+package background
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/riverqueue/river"
+
+	authServices "github.com/cavenine/queryops/features/auth/services"
+)
+
+type fakeAccountDeleter struct {
+	due     []int
+	errs    map[int]error
+	deleted []int
+}
+
+func (f *fakeAccountDeleter) ListDue(context.Context, time.Time, int) ([]int, error) {
+	return f.due, nil
+}
+
+func (f *fakeAccountDeleter) Delete(_ context.Context, userID int, _ time.Time) (*authServices.DeletedAccount, error) {
+	if err := f.errs[userID]; err != nil {
+		return nil, err
+	}
+	f.deleted = append(f.deleted, userID)
+	return &authServices.DeletedAccount{UserID: userID, Email: "gone@example.com"}, nil
+}
+
+type fakeMailer struct {
+	sent []string
+}
+
+func (m *fakeMailer) Send(_ context.Context, to []string, _, _ string) error {
+	m.sent = append(m.sent, to...)
+	return nil
+}
+
+func TestDeleteAccountsWorker(t *testing.T) {
+	accounts := &fakeAccountDeleter{
+		due: []int{1, 2, 3},
+		errs: map[int]error{
+			2: authServices.ErrAccountDeletionNotFound,
+			3: authServices.ErrSoleOwner,
+		},
+	}
+	mailer := &fakeMailer{}
+	w := NewDeleteAccountsWorker(accounts, mailer)
+
+	if err := w.Work(context.Background(), &river.Job[DeleteAccountsArgs]{}); err != nil {
+		t.Fatalf("Work: %v", err)
+	}
+	if !slices.Equal(accounts.deleted, []int{1}) {
+		t.Fatalf("deleted = %v", accounts.deleted)
+	}
+	if !slices.Equal(mailer.sent, []string{"gone@example.com"}) {
+		t.Fatalf("sent = %v", mailer.sent)
+	}
+
+	// Other failures don't stop the run, but fail the job.
+	accounts.due, accounts.deleted = []int{4, 5}, nil
+	accounts.errs[4] = errors.New("boom")
+	if err := w.Work(context.Background(), &river.Job[DeleteAccountsArgs]{}); err == nil {
+		t.Fatal("Work succeeded despite a failed deletion")
+	}
+	if !slices.Equal(accounts.deleted, []int{5}) {
+		t.Fatalf("deleted = %v", accounts.deleted)
+	}
+}
@@ -0,0 +1,315 @@
+package background
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/riverqueue/river"
+)
+
+// QueueStats counts a queue's unfinished jobs by state.
+type QueueStats struct {
+	Name      string `json:"name"`
+	Available int64  `json:"available"`
+	Scheduled int64  `json:"scheduled"`
+	Running   int64  `json:"running"`
+	Retryable int64  `json:"retryable"`
+	Discarded int64  `json:"discarded"`
+}
+
+// RunningJob is a job currently being worked by any client.
+type RunningJob struct {
+	ID          int64     `json:"id"`
+	Kind        string    `json:"kind"`
+	Queue       string    `json:"queue"`
+	Attempt     int       `json:"attempt"`
+	AttemptedAt time.Time `json:"attempted_at"`
+	AttemptedBy []string  `json:"attempted_by"`
+}
+
+// Leader is the River client elected to insert periodic jobs and run queue
+// maintenance.
+type Leader struct {
+	ID        string    `json:"id"`
+	ElectedAt time.Time `json:"elected_at"`
+	ExpiresAt time.Time `json:"expires_at"`
+	// Self reports whether the worker serving the stats is the leader.
+	Self bool `json:"self"`
+}
+
+// WorkerStats is a snapshot of River's job and leader tables.
+type WorkerStats struct {
+	Queues  []QueueStats `json:"queues"`
+	Running []RunningJob `json:"running"`
+	// Leader is nil while no client holds leadership, such as just after
+	// the previous leader stopped.
+	Leader   *Leader `json:"leader"`
+	ClientID string  `json:"client_id,omitempty"`
+	Draining bool    `json:"draining"`
+}
+
+const maxRunningJobsListed = 100
+
+// LoadWorkerStats queries River's job table directly. Completed and cancelled
+// jobs are excluded so the scan stays proportional to outstanding work.
+func LoadWorkerStats(ctx context.Context, pool *pgxpool.Pool) (*WorkerStats, error) {
+	rows, err := pool.Query(ctx, `
+		SELECT queue, state::text, COUNT(*)
+		FROM river_job
+		WHERE state IN ('available', 'scheduled', 'running', 'retryable', 'discarded')
+		GROUP BY queue, state
+		ORDER BY queue
+	`)
+	if err != nil {
+		return nil, fmt.Errorf("querying queue stats: %w", err)
+	}
+
+	stats := &WorkerStats{}
+	byQueue := make(map[string]*QueueStats)
+	for rows.Next() {
+		var (
+			queue, state string
+			n            int64
+		)
+		if err := rows.Scan(&queue, &state, &n); err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("scanning queue stats: %w", err)
+		}
+		q, ok := byQueue[queue]
+		if !ok {
+			stats.Queues = append(stats.Queues, QueueStats{Name: queue})
+			q = &stats.Queues[len(stats.Queues)-1]
+			byQueue[queue] = q
+		}
+		switch state {
+		case "available":
+			q.Available = n
+		case "scheduled":
+			q.Scheduled = n
+		case "running":
+			q.Running = n
+		case "retryable":
+			q.Retryable = n
+		case "discarded":
+			q.Discarded = n
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("querying queue stats: %w", err)
+	}
+
+	rows, err = pool.Query(ctx, `
+		SELECT id, kind, queue, attempt, attempted_at, COALESCE(attempted_by, '{}')
+		FROM river_job
+		WHERE state = 'running'
+		ORDER BY attempted_at
+		LIMIT $1
+	`, maxRunningJobsListed)
+	if err != nil {
+		return nil, fmt.Errorf("querying running jobs: %w", err)
+	}
+	stats.Running, err = pgx.CollectRows(rows, func(row pgx.CollectableRow) (RunningJob, error) {
+		var j RunningJob
+		err := row.Scan(&j.ID, &j.Kind, &j.Queue, &j.Attempt, &j.AttemptedAt, &j.AttemptedBy)
+		return j, err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("scanning running jobs: %w", err)
+	}
+
+	var leader Leader
+	err = pool.QueryRow(ctx, `
+		SELECT leader_id, elected_at, expires_at
+		FROM river_leader
+		WHERE expires_at > NOW()
+	`).Scan(&leader.ID, &leader.ElectedAt, &leader.ExpiresAt)
+	switch {
+	case err == nil:
+		stats.Leader = &leader
+	case !errors.Is(err, pgx.ErrNoRows):
+		return nil, fmt.Errorf("querying river leader: %w", err)
+	}
+
+	return stats, nil
+}
+
+// workerAdmin serves health, stats, and drain endpoints for a worker process.
+// None of them authenticate, and /drain stops the worker, so the listener
+// must only be reachable from the host or a private network.
+type workerAdmin struct {
+	clientID  string
+	ping      func(context.Context) error
+	loadStats func(context.Context) (*WorkerStats, error)
+	stop      func(context.Context) error
+	draining  atomic.Bool
+}
+
+func newWorkerAdmin(pool *pgxpool.Pool, client *river.Client[pgx.Tx]) *workerAdmin {
+	return &workerAdmin{
+		clientID: client.ID(),
+		ping:     pool.Ping,
+		loadStats: func(ctx context.Context) (*WorkerStats, error) {
+			return LoadWorkerStats(ctx, pool)
+		},
+		stop: client.Stop,
+	}
+}
+
+func (a *workerAdmin) routes() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /healthz", a.health)
+	mux.HandleFunc("GET /stats", a.stats)
+	mux.HandleFunc("POST /drain", a.drain)
+	return mux
+}
+
+func (a *workerAdmin) health(w http.ResponseWriter, r *http.Request) {
+	if a.draining.Load() {
+		http.Error(w, "draining", http.StatusServiceUnavailable)
+		return
+	}
+	if err := a.ping(r.Context()); err != nil {
+		http.Error(w, "database not ready", http.StatusServiceUnavailable)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write([]byte("OK"))
+}
+
+func (a *workerAdmin) stats(w http.ResponseWriter, r *http.Request) {
+	stats, err := a.loadStats(r.Context())
+	if err != nil {
+		slog.ErrorContext(r.Context(), "failed to load worker stats", "error", err)
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+	stats.Draining = a.draining.Load()
+	stats.ClientID = a.clientID
+	if stats.Leader != nil {
+		stats.Leader.Self = stats.Leader.ID == a.clientID
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(stats); err != nil {
+		slog.ErrorContext(r.Context(), "failed to encode worker stats", "error", err)
+	}
+}
+
+// drain stops the client from fetching new jobs and lets in-flight jobs
+// finish. RunWorker returns once the client has stopped.
+func (a *workerAdmin) drain(w http.ResponseWriter, r *http.Request) {
+	if !a.draining.CompareAndSwap(false, true) {
+		w.WriteHeader(http.StatusAccepted)
+		return
+	}
+
+	slog.InfoContext(r.Context(), "draining river workers")
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), workerStopTimeout)
+		defer cancel()
+		if err := a.stop(ctx); err != nil && !errors.Is(err, context.Canceled) {
+			slog.Error("error draining river workers", "error", err)
+		}
+	}()
+
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// serve runs the admin listener until ctx is cancelled.
+func (a *workerAdmin) serve(ctx context.Context, addr string) error {
+	const readHeaderTimeout = 5 * time.Second
+	srv := &http.Server{
+		Addr:              addr,
+		Handler:           a.routes(),
+		ReadHeaderTimeout: readHeaderTimeout,
+	}
+
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		_ = srv.Shutdown(shutdownCtx)
+	}()
+
+	if !isLoopbackAddr(addr) {
+		slog.WarnContext(ctx, "worker admin listener is not bound to loopback; its endpoints are unauthenticated, so keep it off public networks", "addr", addr)
+	}
+	slog.InfoContext(ctx, "worker admin listener started", "addr", addr)
+	if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return fmt.Errorf("worker admin listener: %w", err)
+	}
+	return nil
+}
+
+// isLoopbackAddr reports whether addr listens only on a loopback interface.
+// An empty host listens on every interface.
+func isLoopbackAddr(addr string) bool {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return false
+	}
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// logStats writes a queue summary every interval until ctx is cancelled,
+// and the leader whenever it changes.
+func logStats(ctx context.Context, pool *pgxpool.Pool, clientID string, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	var leaderID string
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			stats, err := LoadWorkerStats(ctx, pool)
+			if err != nil {
+				if ctx.Err() == nil {
+					slog.WarnContext(ctx, "failed to load worker stats", "error", err)
+				}
+				continue
+			}
+			for _, q := range stats.Queues {
+				slog.InfoContext(ctx, "river queue summary",
+					"queue", q.Name,
+					"available", q.Available,
+					"scheduled", q.Scheduled,
+					"running", q.Running,
+					"retryable", q.Retryable,
+					"discarded", q.Discarded,
+				)
+			}
+			if len(stats.Queues) == 0 {
+				slog.InfoContext(ctx, "river queue summary: no outstanding jobs")
+			}
+			switch {
+			case stats.Leader == nil && leaderID != "":
+				slog.WarnContext(ctx, "no river leader elected")
+				leaderID = ""
+			case stats.Leader != nil && stats.Leader.ID != leaderID:
+				slog.InfoContext(ctx, "river leader elected",
+					"leader", stats.Leader.ID,
+					"self", stats.Leader.ID == clientID,
+					"elected_at", stats.Leader.ElectedAt,
+				)
+				leaderID = stats.Leader.ID
+			}
+		}
+	}
+}
@@ -0,0 +1,185 @@
+package background
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func newTestAdmin() *workerAdmin {
+	return &workerAdmin{
+		ping: func(context.Context) error { return nil },
+		loadStats: func(context.Context) (*WorkerStats, error) {
+			return &WorkerStats{}, nil
+		},
+		stop: func(context.Context) error { return nil },
+	}
+}
+
+func serveAdmin(a *workerAdmin, method, path string) *httptest.ResponseRecorder {
+	rec := httptest.NewRecorder()
+	a.routes().ServeHTTP(rec, httptest.NewRequest(method, path, nil))
+	return rec
+}
+
+func TestWorkerAdmin_Health(t *testing.T) {
+	a := newTestAdmin()
+	if rec := serveAdmin(a, http.MethodGet, "/healthz"); rec.Code != http.StatusOK {
+		t.Fatalf("healthy status = %d, want 200", rec.Code)
+	}
+
+	a.ping = func(context.Context) error { return errors.New("db down") }
+	if rec := serveAdmin(a, http.MethodGet, "/healthz"); rec.Code != http.StatusServiceUnavailable {
+		t.Fatalf("database down status = %d, want 503", rec.Code)
+	}
+
+	a.ping = func(context.Context) error { return nil }
+	a.draining.Store(true)
+	rec := serveAdmin(a, http.MethodGet, "/healthz")
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Fatalf("draining status = %d, want 503", rec.Code)
+	}
+}
+
+func TestWorkerAdmin_DrainStopsOnce(t *testing.T) {
+	stops := make(chan struct{}, 2)
+	a := newTestAdmin()
+	a.stop = func(context.Context) error {
+		stops <- struct{}{}
+		return nil
+	}
+
+	for i := range 2 {
+		if rec := serveAdmin(a, http.MethodPost, "/drain"); rec.Code != http.StatusAccepted {
+			t.Fatalf("drain %d status = %d, want 202", i+1, rec.Code)
+		}
+	}
+
+	select {
+	case <-stops:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("client not stopped after drain")
+	}
+	select {
+	case <-stops:
+		t.Fatalf("client stopped twice")
+	case <-time.After(50 * time.Millisecond):
+	}
+	if !a.draining.Load() {
+		t.Fatalf("draining not set")
+	}
+}
+
+func TestWorkerAdmin_DrainRequiresPost(t *testing.T) {
+	a := newTestAdmin()
+	if rec := serveAdmin(a, http.MethodGet, "/drain"); rec.Code != http.StatusMethodNotAllowed {
+		t.Fatalf("GET /drain status = %d, want 405", rec.Code)
+	}
+	if a.draining.Load() {
+		t.Fatalf("GET /drain started draining")
+	}
+}
+
+func TestWorkerAdmin_Stats(t *testing.T) {
+	attemptedAt := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	a := newTestAdmin()
+	a.loadStats = func(context.Context) (*WorkerStats, error) {
+		return &WorkerStats{
+			Queues: []QueueStats{{Name: "default", Available: 3, Running: 1}},
+			Running: []RunningJob{{
+				ID: 7, Kind: "send_digests", Queue: "default", Attempt: 1,
+				AttemptedAt: attemptedAt, AttemptedBy: []string{"worker-1"},
+			}},
+		}, nil
+	}
+	a.draining.Store(true)
+
+	rec := serveAdmin(a, http.MethodGet, "/stats")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want 200", rec.Code)
+	}
+	if ct := rec.Header().Get("Content-Type"); ct != "application/json" {
+		t.Fatalf("Content-Type = %q", ct)
+	}
+
+	var got map[string]any
+	if err := json.Unmarshal(rec.Body.Bytes(), &got); err != nil {
+		t.Fatalf("decoding stats: %v", err)
+	}
+	want := map[string]any{
+		"draining": true,
+		"leader":   nil,
+		"queues": []any{map[string]any{
+			"name": "default", "available": 3.0, "scheduled": 0.0,
+			"running": 1.0, "retryable": 0.0, "discarded": 0.0,
+		}},
+		"running": []any{map[string]any{
+			"id": 7.0, "kind": "send_digests", "queue": "default", "attempt": 1.0,
+			"attempted_at": "2026-01-02T03:04:05Z", "attempted_by": []any{"worker-1"},
+		}},
+	}
+	gotJSON, _ := json.Marshal(got)
+	wantJSON, _ := json.Marshal(want)
+	if string(gotJSON) != string(wantJSON) {
+		t.Fatalf("stats = %s\nwant    %s", gotJSON, wantJSON)
+	}
+}
+
+func TestWorkerAdmin_StatsLeader(t *testing.T) {
+	a := newTestAdmin()
+	a.clientID = "worker-2"
+	leader := func(id string) func(context.Context) (*WorkerStats, error) {
+		return func(context.Context) (*WorkerStats, error) {
+			return &WorkerStats{Leader: &Leader{ID: id}}, nil
+		}
+	}
+
+	for _, tc := range []struct {
+		leader string
+		self   bool
+	}{
+		{"worker-1", false},
+		{"worker-2", true},
+	} {
+		a.loadStats = leader(tc.leader)
+		rec := serveAdmin(a, http.MethodGet, "/stats")
+		var got WorkerStats
+		if err := json.Unmarshal(rec.Body.Bytes(), &got); err != nil {
+			t.Fatalf("decoding stats: %v", err)
+		}
+		if got.ClientID != "worker-2" || got.Leader == nil || got.Leader.ID != tc.leader || got.Leader.Self != tc.self {
+			t.Fatalf("stats with leader %s = %+v (leader %+v)", tc.leader, got, got.Leader)
+		}
+	}
+}
+
+func TestWorkerAdmin_StatsError(t *testing.T) {
+	a := newTestAdmin()
+	a.loadStats = func(context.Context) (*WorkerStats, error) {
+		return nil, errors.New("db down")
+	}
+	if rec := serveAdmin(a, http.MethodGet, "/stats"); rec.Code != http.StatusInternalServerError {
+		t.Fatalf("status = %d, want 500", rec.Code)
+	}
+}
+
+func TestIsLoopbackAddr(t *testing.T) {
+	tests := map[string]bool{
+		"127.0.0.1:9091": true,
+		"localhost:9091": true,
+		"[::1]:9091":     true,
+		":9091":          false,
+		"0.0.0.0:9091":   false,
+		"10.0.0.5:9091":  false,
+		"bad":            false,
+	}
+	for addr, want := range tests {
+		if got := isLoopbackAddr(addr); got != want {
+			t.Errorf("isLoopbackAddr(%q) = %v, want %v", addr, got, want)
+		}
+	}
+}
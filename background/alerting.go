@@ -0,0 +1,182 @@
+package background
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/riverqueue/river"
+
+	notificationServices "github.com/cavenine/queryops/features/notification/services"
+	orgServices "github.com/cavenine/queryops/features/organization/services"
+	"github.com/cavenine/queryops/internal/notify"
+)
+
+const (
+	// alertStatusQuietFor is how long a status alert rule must stay quiet
+	// about a host before its incident resolves. Rules re-alert at most once
+	// per statusAlertCooldown, so anything shorter would flap.
+	alertStatusQuietFor = 2 * statusAlertCooldown
+	// alertBatch caps the triggers and resolves one run sends; the rest are
+	// sent by the next run.
+	alertBatch = 200
+	// alertRetryAfter is how long a failed send waits before its first
+	// retry. The wait doubles with each attempt.
+	alertRetryAfter = time.Minute
+	// alertMaxAttempts is how many times a send is tried before it's given up
+	// on and the organization is told.
+	alertMaxAttempts = 5
+)
+
+// EvaluateAlertDestinationsArgs opens and resolves incidents for
+// organizations' paging destinations and sends them to PagerDuty or Opsgenie.
+type EvaluateAlertDestinationsArgs struct{}
+
+func (EvaluateAlertDestinationsArgs) Kind() string {
+	return "evaluate_alert_destinations"
+}
+
+func (EvaluateAlertDestinationsArgs) InsertOpts() river.InsertOpts {
+	return river.InsertOpts{Queue: QueueNotifications}
+}
+
+func init() {
+	Periodic.Register(PeriodicJob{
+		Name:       "evaluate_alert_destinations",
+		Schedule:   "* * * * *",
+		Args:       func() river.JobArgs { return EvaluateAlertDestinationsArgs{} },
+		Jitter:     10 * time.Second,
+		RunOnStart: true,
+	})
+}
+
+type alertIncidentQueue interface {
+	EvaluateIncidents(ctx context.Context, statusQuietFor time.Duration) (opened, resolved int, err error)
+	ClaimIncidents(ctx context.Context, limit int, retryAfter time.Duration, maxAttempts int) ([]orgServices.AlertDelivery, error)
+	MarkIncidentSent(ctx context.Context, id int64, resolve bool) error
+	MarkIncidentFailed(ctx context.Context, id int64, reason string) error
+}
+
+type EvaluateAlertDestinationsWorker struct {
+	river.WorkerDefaults[EvaluateAlertDestinationsArgs]
+
+	repo    alertIncidentQueue
+	webhook *notify.Webhook
+	// origin is prefixed to incidents' links, e.g. "https://queryops.example.com";
+	// empty leaves links out.
+	origin   string
+	notifier organizationNotifier // nil disables failure notifications
+}
+
+func NewEvaluateAlertDestinationsWorker(repo alertIncidentQueue, webhook *notify.Webhook, origin string, notifier organizationNotifier) *EvaluateAlertDestinationsWorker {
+	return &EvaluateAlertDestinationsWorker{
+		repo:     repo,
+		webhook:  webhook,
+		origin:   strings.TrimSuffix(origin, "/"),
+		notifier: notifier,
+	}
+}
+
+// Work brings incidents in line with the conditions that hold, then sends
+// the triggers and resolves that are due. A send that fails is retried by
+// later runs until it runs out of attempts, when the organization is told.
+func (w *EvaluateAlertDestinationsWorker) Work(ctx context.Context, _ *river.Job[EvaluateAlertDestinationsArgs]) error {
+	opened, resolved, err := w.repo.EvaluateIncidents(ctx, alertStatusQuietFor)
+	if err != nil {
+		return fmt.Errorf("evaluating alert destinations: %w", err)
+	}
+	if opened > 0 || resolved > 0 {
+		slog.InfoContext(ctx, "evaluated alert incidents", "opened", opened, "resolved", resolved)
+	}
+
+	deliveries, err := w.repo.ClaimIncidents(ctx, alertBatch, alertRetryAfter, alertMaxAttempts)
+	if err != nil {
+		return fmt.Errorf("evaluating alert destinations: %w", err)
+	}
+
+	// A destination that fails once is skipped for the rest of the run, so an
+	// outage costs one request and is reported once rather than per incident.
+	var (
+		failed   = make(map[int64]error)
+		reported = make(map[int64]bool)
+		sent     int
+	)
+	for _, d := range deliveries {
+		sendErr, skipped := failed[d.DestinationID]
+		if !skipped {
+			sendErr = w.send(ctx, d)
+		}
+		if sendErr == nil {
+			if err := w.repo.MarkIncidentSent(ctx, d.ID, d.Resolve); err != nil {
+				return fmt.Errorf("evaluating alert destinations: %w", err)
+			}
+			sent++
+			continue
+		}
+
+		if err := w.repo.MarkIncidentFailed(ctx, d.ID, sendErr.Error()); err != nil {
+			return fmt.Errorf("evaluating alert destinations: %w", err)
+		}
+		if !skipped {
+			failed[d.DestinationID] = sendErr
+			slog.ErrorContext(ctx, "failed to send alert", "error", sendErr, "organization_id", d.OrganizationID, "destination", d.DestinationName, "resolve", d.Resolve, "attempt", d.Attempts)
+		}
+		if d.Attempts >= alertMaxAttempts && !reported[d.DestinationID] {
+			reported[d.DestinationID] = true
+			w.notifyAlertFailed(ctx, d, sendErr)
+		}
+	}
+
+	if sent > 0 {
+		slog.InfoContext(ctx, "sent alerts", "alerts", sent)
+	}
+	return nil
+}
+
+func (w *EvaluateAlertDestinationsWorker) send(ctx context.Context, d orgServices.AlertDelivery) error {
+	var alerter notify.Alerter
+	switch d.Provider {
+	case orgServices.AlertProviderPagerDuty:
+		alerter = notify.NewPagerDuty(w.webhook, "", d.Key)
+	case orgServices.AlertProviderOpsgenie:
+		alerter = notify.NewOpsgenie(w.webhook, "", d.Key)
+	case orgServices.AlertProviderOpsgenieEU:
+		alerter = notify.NewOpsgenie(w.webhook, notify.OpsgenieEUURL, d.Key)
+	default:
+		return fmt.Errorf("sending alert: unknown provider %q", d.Provider)
+	}
+
+	if d.Resolve {
+		return alerter.Resolve(ctx, d.IncidentKey())
+	}
+	alert := notify.Alert{
+		DedupKey: d.IncidentKey(),
+		Summary:  d.Summary,
+		Source:   d.Source,
+		Severity: d.Severity,
+		Details:  map[string]string{"kind": d.Kind, "destination": d.DestinationName},
+	}
+	if d.Link != nil && w.origin != "" {
+		alert.URL = w.origin + *d.Link
+	}
+	return alerter.Trigger(ctx, alert)
+}
+
+// notifyAlertFailed tells the organization's members that a trigger or
+// resolve was given up on. It only logs its own errors.
+func (w *EvaluateAlertDestinationsWorker) notifyAlertFailed(ctx context.Context, d orgServices.AlertDelivery, sendErr error) {
+	if w.notifier == nil {
+		return
+	}
+
+	_, err := w.notifier.NotifyOrganization(ctx, d.OrganizationID, notificationServices.Notification{
+		Kind:  notificationServices.KindWebhookFailed,
+		Title: "Paging destination " + d.DestinationName + " failed",
+		Body:  sendErr.Error(),
+	})
+	if err != nil {
+		slog.ErrorContext(ctx, "failed to notify about alert failure", "error", err, "organization_id", d.OrganizationID)
+	}
+}
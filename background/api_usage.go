@@ -0,0 +1,56 @@
+package background
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/riverqueue/river"
+)
+
+// RollupAPIUsageArgs folds the API usage samples written by web processes
+// into hourly totals and prunes expired ones.
+type RollupAPIUsageArgs struct{}
+
+func (RollupAPIUsageArgs) Kind() string {
+	return "rollup_api_usage"
+}
+
+func (RollupAPIUsageArgs) InsertOpts() river.InsertOpts {
+	return river.InsertOpts{Queue: QueueMaintenance}
+}
+
+func init() {
+	Periodic.Register(PeriodicJob{
+		Name:     "rollup_api_usage",
+		Schedule: "*/5 * * * *",
+		Args:     func() river.JobArgs { return RollupAPIUsageArgs{} },
+		Jitter:   30 * time.Second,
+	})
+}
+
+type apiUsageRollup interface {
+	RollupAPIUsage(ctx context.Context, now time.Time) (int, error)
+}
+
+type RollupAPIUsageWorker struct {
+	river.WorkerDefaults[RollupAPIUsageArgs]
+
+	repo apiUsageRollup
+}
+
+func NewRollupAPIUsageWorker(repo apiUsageRollup) *RollupAPIUsageWorker {
+	return &RollupAPIUsageWorker{repo: repo}
+}
+
+func (w *RollupAPIUsageWorker) Work(ctx context.Context, _ *river.Job[RollupAPIUsageArgs]) error {
+	n, err := w.repo.RollupAPIUsage(ctx, time.Now())
+	if err != nil {
+		return fmt.Errorf("rolling up api usage: %w", err)
+	}
+	if n > 0 {
+		slog.DebugContext(ctx, "rolled up api usage", "samples", n)
+	}
+	return nil
+}
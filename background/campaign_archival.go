@@ -0,0 +1,56 @@
+package background
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/riverqueue/river"
+)
+
+// ArchiveOldCampaignsArgs archives finished campaigns older than their
+// organization's automatic archival period.
+type ArchiveOldCampaignsArgs struct{}
+
+func (ArchiveOldCampaignsArgs) Kind() string {
+	return "archive_old_campaigns"
+}
+
+func (ArchiveOldCampaignsArgs) InsertOpts() river.InsertOpts {
+	return river.InsertOpts{Queue: QueueMaintenance}
+}
+
+func init() {
+	Periodic.Register(PeriodicJob{
+		Name:     "archive_old_campaigns",
+		Schedule: "@hourly",
+		Args:     func() river.JobArgs { return ArchiveOldCampaignsArgs{} },
+		Jitter:   5 * time.Minute,
+	})
+}
+
+type oldCampaignArchiver interface {
+	ArchiveOldCampaigns(ctx context.Context, now time.Time) (int, error)
+}
+
+type ArchiveOldCampaignsWorker struct {
+	river.WorkerDefaults[ArchiveOldCampaignsArgs]
+
+	repo oldCampaignArchiver
+}
+
+func NewArchiveOldCampaignsWorker(repo oldCampaignArchiver) *ArchiveOldCampaignsWorker {
+	return &ArchiveOldCampaignsWorker{repo: repo}
+}
+
+func (w *ArchiveOldCampaignsWorker) Work(ctx context.Context, _ *river.Job[ArchiveOldCampaignsArgs]) error {
+	n, err := w.repo.ArchiveOldCampaigns(ctx, time.Now())
+	if err != nil {
+		return fmt.Errorf("archiving old campaigns: %w", err)
+	}
+	if n > 0 {
+		slog.InfoContext(ctx, "archived old campaigns", "count", n)
+	}
+	return nil
+}
@@ -0,0 +1,44 @@
+package background
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseSchedule_Next(t *testing.T) {
+	from := time.Date(2026, 1, 15, 10, 30, 45, 0, time.UTC) // Thursday
+
+	tests := []struct {
+		spec string
+		want time.Time
+	}{
+		{"* * * * *", time.Date(2026, 1, 15, 10, 31, 0, 0, time.UTC)},
+		{"*/15 * * * *", time.Date(2026, 1, 15, 10, 45, 0, 0, time.UTC)},
+		{"0 * * * *", time.Date(2026, 1, 15, 11, 0, 0, 0, time.UTC)},
+		{"@daily", time.Date(2026, 1, 16, 0, 0, 0, 0, time.UTC)},
+		{"30 2 * * 1", time.Date(2026, 1, 19, 2, 30, 0, 0, time.UTC)},
+		{"0 0 1 2 *", time.Date(2026, 2, 1, 0, 0, 0, 0, time.UTC)},
+		{"0 9-17/4 * * *", time.Date(2026, 1, 15, 13, 0, 0, 0, time.UTC)},
+		{"@every 90s", from.Add(90 * time.Second)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.spec, func(t *testing.T) {
+			s, err := parseSchedule(tt.spec)
+			if err != nil {
+				t.Fatalf("parseSchedule: %v", err)
+			}
+			if got := s.Next(from); !got.Equal(tt.want) {
+				t.Fatalf("Next = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseSchedule_Invalid(t *testing.T) {
+	for _, spec := range []string{"", "* * * *", "60 * * * *", "* 24 * * *", "*/0 * * * *", "5-1 * * * *", "@every 10ms", "@yearly"} {
+		if _, err := parseSchedule(spec); err == nil {
+			t.Errorf("parseSchedule(%q): expected error", spec)
+		}
+	}
+}
@@ -0,0 +1,52 @@
+package background
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/riverqueue/river"
+)
+
+// RefreshDashboardViewsArgs recomputes the materialized views that large
+// organizations' dashboards read from.
+type RefreshDashboardViewsArgs struct{}
+
+func (RefreshDashboardViewsArgs) Kind() string {
+	return "refresh_dashboard_views"
+}
+
+func (RefreshDashboardViewsArgs) InsertOpts() river.InsertOpts {
+	return river.InsertOpts{Queue: QueueMaintenance}
+}
+
+func init() {
+	Periodic.Register(PeriodicJob{
+		Name:       "refresh_dashboard_views",
+		Schedule:   "*/5 * * * *",
+		Args:       func() river.JobArgs { return RefreshDashboardViewsArgs{} },
+		Jitter:     30 * time.Second,
+		RunOnStart: true,
+	})
+}
+
+type dashboardViewRefresher interface {
+	RefreshViews(ctx context.Context) error
+}
+
+type RefreshDashboardViewsWorker struct {
+	river.WorkerDefaults[RefreshDashboardViewsArgs]
+
+	repo dashboardViewRefresher
+}
+
+func NewRefreshDashboardViewsWorker(repo dashboardViewRefresher) *RefreshDashboardViewsWorker {
+	return &RefreshDashboardViewsWorker{repo: repo}
+}
+
+func (w *RefreshDashboardViewsWorker) Work(ctx context.Context, _ *river.Job[RefreshDashboardViewsArgs]) error {
+	if err := w.repo.RefreshViews(ctx); err != nil {
+		return fmt.Errorf("refreshing dashboard views: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,184 @@
+package background
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/riverqueue/river"
+
+	notificationServices "github.com/cavenine/queryops/features/notification/services"
+	orgServices "github.com/cavenine/queryops/features/organization/services"
+	"github.com/cavenine/queryops/internal/notify"
+)
+
+// digestOfflineAfter is how long a host must be silent to be listed as
+// offline in a digest.
+const digestOfflineAfter = 24 * time.Hour
+
+// SendDigestsArgs delivers enrollment/activity digests to every organization
+// whose daily or weekly digest is due.
+type SendDigestsArgs struct{}
+
+func (SendDigestsArgs) Kind() string {
+	return "send_digests"
+}
+
+func (SendDigestsArgs) InsertOpts() river.InsertOpts {
+	return river.InsertOpts{Queue: QueueNotifications}
+}
+
+func init() {
+	// Due digests are checked hourly; each organization's own daily or weekly
+	// cadence is tracked through last_sent_at.
+	Periodic.Register(PeriodicJob{
+		Name:       "send_digests",
+		Schedule:   "@hourly",
+		Args:       func() river.JobArgs { return SendDigestsArgs{} },
+		Jitter:     time.Minute,
+		RunOnStart: true,
+	})
+}
+
+type digestRepository interface {
+	ListDue(ctx context.Context, now time.Time) ([]*orgServices.DigestSettings, error)
+	Build(ctx context.Context, organizationID uuid.UUID, since, until time.Time, offlineAfter time.Duration) (*orgServices.Digest, error)
+	MarkSent(ctx context.Context, organizationID uuid.UUID, sentAt time.Time) error
+}
+
+type organizationNotifier interface {
+	NotifyOrganization(ctx context.Context, organizationID uuid.UUID, n notificationServices.Notification) (int, error)
+}
+
+type SendDigestsWorker struct {
+	river.WorkerDefaults[SendDigestsArgs]
+
+	repo     digestRepository
+	mailer   notify.Mailer
+	webhook  *notify.Webhook
+	notifier organizationNotifier // nil disables failure notifications
+}
+
+func NewSendDigestsWorker(repo digestRepository, mailer notify.Mailer, webhook *notify.Webhook, notifier organizationNotifier) *SendDigestsWorker {
+	return &SendDigestsWorker{
+		repo:     repo,
+		mailer:   mailer,
+		webhook:  webhook,
+		notifier: notifier,
+	}
+}
+
+func (w *SendDigestsWorker) Work(ctx context.Context, _ *river.Job[SendDigestsArgs]) error {
+	now := time.Now().UTC()
+
+	due, err := w.repo.ListDue(ctx, now)
+	if err != nil {
+		return fmt.Errorf("sending digests: %w", err)
+	}
+
+	var failed int
+	for _, s := range due {
+		if err := w.send(ctx, s, now); err != nil {
+			failed++
+			slog.ErrorContext(ctx, "failed to send digest", "error", err, "organization_id", s.OrganizationID)
+		}
+	}
+
+	if failed > 0 {
+		// Returning an error retries the job; orgs already sent were marked and
+		// won't be sent twice.
+		return fmt.Errorf("sending digests: %d of %d failed", failed, len(due))
+	}
+	return nil
+}
+
+func (w *SendDigestsWorker) send(ctx context.Context, s *orgServices.DigestSettings, now time.Time) error {
+	since := now.Add(-s.Period())
+	if s.LastSentAt != nil && s.LastSentAt.After(since) {
+		since = *s.LastSentAt
+	}
+
+	digest, err := w.repo.Build(ctx, s.OrganizationID, since, now, digestOfflineAfter)
+	if err != nil {
+		return err
+	}
+
+	if !digest.Empty() {
+		if s.Email != nil && *s.Email != "" {
+			subject := fmt.Sprintf("QueryOps %s digest for %s", s.Frequency, digest.OrganizationName)
+			if err := w.mailer.Send(ctx, []string{*s.Email}, subject, renderDigestText(digest)); err != nil {
+				return err
+			}
+		}
+		if s.WebhookURL != nil && *s.WebhookURL != "" {
+			payload := struct {
+				Event string `json:"event"`
+				*orgServices.Digest
+			}{Event: "digest", Digest: digest}
+			if err := w.webhook.PostJSON(ctx, *s.WebhookURL, payload); err != nil {
+				w.notifyWebhookFailed(ctx, s.OrganizationID, err)
+				return err
+			}
+		}
+	}
+
+	return w.repo.MarkSent(ctx, s.OrganizationID, now)
+}
+
+// notifyWebhookFailed tells the organization's members that their digest
+// webhook is failing. It only logs its own errors; the digest is retried
+// regardless.
+func (w *SendDigestsWorker) notifyWebhookFailed(ctx context.Context, organizationID uuid.UUID, webhookErr error) {
+	if w.notifier == nil {
+		return
+	}
+
+	_, err := w.notifier.NotifyOrganization(ctx, organizationID, notificationServices.Notification{
+		Kind:  notificationServices.KindWebhookFailed,
+		Title: "Digest webhook failed",
+		Body:  webhookErr.Error(),
+	})
+	if err != nil {
+		slog.ErrorContext(ctx, "failed to notify about webhook failure", "error", err, "organization_id", organizationID)
+	}
+}
+
+func renderDigestText(d *orgServices.Digest) string {
+	var b strings.Builder
+	const layout = "2006-01-02 15:04 MST"
+
+	fmt.Fprintf(&b, "Activity for %s from %s to %s\n", d.OrganizationName, d.Since.Format(layout), d.Until.Format(layout))
+
+	fmt.Fprintf(&b, "\nNewly enrolled hosts: %d\n", d.NewHostCount)
+	for _, h := range d.NewHosts {
+		fmt.Fprintf(&b, "  - %s (enrolled %s)\n", h.HostIdentifier, h.At.Format(layout))
+	}
+	writeMore(&b, d.NewHostCount, len(d.NewHosts))
+
+	fmt.Fprintf(&b, "\nOffline hosts: %d\n", d.OfflineHostCount)
+	for _, h := range d.OfflineHosts {
+		fmt.Fprintf(&b, "  - %s (last seen %s)\n", h.HostIdentifier, h.At.Format(layout))
+	}
+	writeMore(&b, d.OfflineHostCount, len(d.OfflineHosts))
+
+	fmt.Fprintf(&b, "\nCompleted campaigns: %d\n", d.CampaignCount)
+	for _, c := range d.Campaigns {
+		name := c.ID.String()
+		if c.Name != nil && *c.Name != "" {
+			name = *c.Name
+		}
+		fmt.Fprintf(&b, "  - %s: %s, %d/%d hosts responded\n", name, c.Status, c.ResultCount, c.TargetCount)
+	}
+	writeMore(&b, d.CampaignCount, len(d.Campaigns))
+
+	return b.String()
+}
+
+func writeMore(b *strings.Builder, total, shown int) {
+	if total > shown {
+		fmt.Fprintf(b, "  ...and %d more\n", total-shown)
+	}
+}
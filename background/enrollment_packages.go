@@ -0,0 +1,112 @@
+package background
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+
+	"github.com/google/uuid"
+	"github.com/riverqueue/river"
+
+	orgServices "github.com/cavenine/queryops/features/organization/services"
+	"github.com/cavenine/queryops/internal/osqueryinstall"
+	"github.com/cavenine/queryops/internal/osquerypkg"
+)
+
+type enrollmentPackageStore interface {
+	Get(ctx context.Context, id uuid.UUID) (*orgServices.EnrollmentPackage, error)
+	Complete(ctx context.Context, id uuid.UUID, filename string, content []byte) error
+	Fail(ctx context.Context, id uuid.UUID, message string) error
+}
+
+type enrollSecretReader interface {
+	GetActiveEnrollSecret(ctx context.Context, organizationID uuid.UUID) (*orgServices.OrganizationEnrollSecret, error)
+}
+
+// BuildEnrollmentPackageWorker builds packages requested from organization
+// settings. Errors the admin can fix, such as a missing build tool, fail the
+// package at once; others are retried.
+type BuildEnrollmentPackageWorker struct {
+	river.WorkerDefaults[orgServices.BuildEnrollmentPackageArgs]
+
+	packages enrollmentPackageStore
+	secrets  enrollSecretReader
+}
+
+func NewBuildEnrollmentPackageWorker(packages enrollmentPackageStore, secrets enrollSecretReader) *BuildEnrollmentPackageWorker {
+	return &BuildEnrollmentPackageWorker{packages: packages, secrets: secrets}
+}
+
+func (w *BuildEnrollmentPackageWorker) Work(ctx context.Context, job *river.Job[orgServices.BuildEnrollmentPackageArgs]) error {
+	pkg, err := w.packages.Get(ctx, job.Args.PackageID)
+	if err != nil {
+		if errors.Is(err, orgServices.ErrEnrollmentPackageNotFound) {
+			// Its organization was deleted.
+			return nil
+		}
+		return fmt.Errorf("loading enrollment package: %w", err)
+	}
+	if pkg.Status != orgServices.PackagePending {
+		return nil
+	}
+
+	built, err := w.build(ctx, pkg)
+	if err != nil {
+		var permanent permanentBuildError
+		if !errors.As(err, &permanent) && job.Attempt < job.MaxAttempts {
+			return err
+		}
+		slog.WarnContext(ctx, "enrollment package build failed",
+			"package_id", pkg.ID,
+			"organization_id", pkg.OrganizationID,
+			"format", pkg.Format,
+			"error", err,
+		)
+		if failErr := w.packages.Fail(ctx, pkg.ID, err.Error()); failErr != nil {
+			return failErr
+		}
+		return nil
+	}
+
+	if err := w.packages.Complete(ctx, pkg.ID, built.Filename, built.Content); err != nil {
+		return err
+	}
+	slog.InfoContext(ctx, "enrollment package built",
+		"package_id", pkg.ID,
+		"organization_id", pkg.OrganizationID,
+		"format", pkg.Format,
+		"build", pkg.Build,
+		"size", len(built.Content),
+	)
+	return nil
+}
+
+// permanentBuildError is a build failure retrying won't fix.
+type permanentBuildError struct {
+	err error
+}
+
+func (e permanentBuildError) Error() string { return e.err.Error() }
+func (e permanentBuildError) Unwrap() error { return e.err }
+
+func (w *BuildEnrollmentPackageWorker) build(ctx context.Context, pkg *orgServices.EnrollmentPackage) (*osquerypkg.Package, error) {
+	secret, err := w.secrets.GetActiveEnrollSecret(ctx, pkg.OrganizationID)
+	if err != nil {
+		return nil, fmt.Errorf("loading enroll secret: %w", err)
+	}
+	if secret == nil {
+		return nil, permanentBuildError{errors.New("organization has no active enroll secret")}
+	}
+
+	params := osqueryinstall.NewParams(pkg.Hostname, secret.Secret)
+	built, err := osquerypkg.Build(ctx, pkg.Format, params, pkg.Build)
+	if err != nil {
+		if errors.Is(err, osquerypkg.ErrUnknownFormat) || errors.Is(err, osquerypkg.ErrFormatUnavailable) ||
+			errors.Is(err, osquerypkg.ErrInvalidBuild) || errors.Is(err, osqueryinstall.ErrInvalidParams) {
+			return nil, permanentBuildError{err}
+		}
+		return nil, err
+	}
+	return built, nil
+}
@@ -0,0 +1,77 @@
+package background
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync/atomic"
+	"time"
+
+	"github.com/riverqueue/river"
+)
+
+// GenerateNotificationsArgs creates in-app notifications for campaigns that
+// finished and hosts that went offline since the last run, and queues Slack
+// messages for hosts that enrolled.
+type GenerateNotificationsArgs struct{}
+
+func (GenerateNotificationsArgs) Kind() string {
+	return "generate_notifications"
+}
+
+func (GenerateNotificationsArgs) InsertOpts() river.InsertOpts {
+	return river.InsertOpts{Queue: QueueNotifications}
+}
+
+func init() {
+	Periodic.Register(PeriodicJob{
+		Name:       "generate_notifications",
+		Schedule:   "* * * * *",
+		Args:       func() river.JobArgs { return GenerateNotificationsArgs{} },
+		Jitter:     10 * time.Second,
+		RunOnStart: true,
+	})
+}
+
+type notificationGenerator interface {
+	NotifyFinishedCampaigns(ctx context.Context) (int, error)
+	NotifyOfflineHosts(ctx context.Context, offlineBefore time.Time) (int, error)
+	NotifyEnrolledHosts(ctx context.Context) (int, error)
+}
+
+type GenerateNotificationsWorker struct {
+	river.WorkerDefaults[GenerateNotificationsArgs]
+
+	repo         notificationGenerator
+	offlineAfter atomic.Int64 // time.Duration
+}
+
+// NewGenerateNotificationsWorker creates a worker that reports hosts silent
+// for longer than offlineAfter.
+func NewGenerateNotificationsWorker(repo notificationGenerator, offlineAfter time.Duration) *GenerateNotificationsWorker {
+	w := &GenerateNotificationsWorker{repo: repo}
+	w.SetOfflineAfter(offlineAfter)
+	return w
+}
+
+// SetOfflineAfter changes how long hosts must be silent before runs that
+// start from now on report them.
+func (w *GenerateNotificationsWorker) SetOfflineAfter(d time.Duration) {
+	w.offlineAfter.Store(int64(d))
+}
+
+func (w *GenerateNotificationsWorker) Work(ctx context.Context, _ *river.Job[GenerateNotificationsArgs]) error {
+	campaigns, campaignErr := w.repo.NotifyFinishedCampaigns(ctx)
+	hosts, hostErr := w.repo.NotifyOfflineHosts(ctx, time.Now().Add(-time.Duration(w.offlineAfter.Load())))
+	enrolled, enrollErr := w.repo.NotifyEnrolledHosts(ctx)
+
+	if campaigns > 0 || hosts > 0 || enrolled > 0 {
+		slog.InfoContext(ctx, "generated notifications", "campaign_notifications", campaigns, "host_notifications", hosts, "enrolled_hosts", enrolled)
+	}
+
+	if err := errors.Join(campaignErr, hostErr, enrollErr); err != nil {
+		return fmt.Errorf("generating notifications: %w", err)
+	}
+	return nil
+}
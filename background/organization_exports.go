@@ -0,0 +1,127 @@
+package background
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/riverqueue/river"
+
+	orgServices "github.com/cavenine/queryops/features/organization/services"
+)
+
+// organizationExportTimeout bounds one attempt at an organization export,
+// which reads every host, campaign, and stored result of the organization.
+const organizationExportTimeout = 30 * time.Minute
+
+type organizationExportStore interface {
+	Get(ctx context.Context, id uuid.UUID) (*orgServices.OrganizationExport, error)
+	WriteArchive(ctx context.Context, organizationID uuid.UUID, w io.Writer, now time.Time) error
+	Complete(ctx context.Context, id uuid.UUID, filename string, content []byte) error
+	Fail(ctx context.Context, id uuid.UUID, message string) error
+}
+
+// BuildOrganizationExportWorker builds the archives requested from
+// organization settings.
+type BuildOrganizationExportWorker struct {
+	river.WorkerDefaults[orgServices.BuildOrganizationExportArgs]
+
+	exports organizationExportStore
+}
+
+func NewBuildOrganizationExportWorker(exports organizationExportStore) *BuildOrganizationExportWorker {
+	return &BuildOrganizationExportWorker{exports: exports}
+}
+
+func (w *BuildOrganizationExportWorker) Timeout(*river.Job[orgServices.BuildOrganizationExportArgs]) time.Duration {
+	return organizationExportTimeout
+}
+
+func (w *BuildOrganizationExportWorker) Work(ctx context.Context, job *river.Job[orgServices.BuildOrganizationExportArgs]) error {
+	export, err := w.exports.Get(ctx, job.Args.ExportID)
+	if err != nil {
+		if errors.Is(err, orgServices.ErrOrganizationExportNotFound) {
+			// Its organization was deleted.
+			return nil
+		}
+		return fmt.Errorf("loading organization export: %w", err)
+	}
+	if export.Status != orgServices.ExportPending {
+		return nil
+	}
+
+	now := time.Now()
+	var archive bytes.Buffer
+	if err := w.exports.WriteArchive(ctx, export.OrganizationID, &archive, now); err != nil {
+		if job.Attempt < job.MaxAttempts {
+			return err
+		}
+		slog.WarnContext(ctx, "organization export failed",
+			"export_id", export.ID,
+			"organization_id", export.OrganizationID,
+			"error", err,
+		)
+		return w.exports.Fail(ctx, export.ID, err.Error())
+	}
+
+	filename := "queryops-export-" + now.UTC().Format("20060102-150405") + ".zip"
+	if err := w.exports.Complete(ctx, export.ID, filename, archive.Bytes()); err != nil {
+		return err
+	}
+	slog.InfoContext(ctx, "organization export built",
+		"export_id", export.ID,
+		"organization_id", export.OrganizationID,
+		"size", archive.Len(),
+	)
+	return nil
+}
+
+// PurgeOrganizationExportsArgs deletes expired organization exports.
+type PurgeOrganizationExportsArgs struct{}
+
+func (PurgeOrganizationExportsArgs) Kind() string {
+	return "purge_organization_exports"
+}
+
+func (PurgeOrganizationExportsArgs) InsertOpts() river.InsertOpts {
+	return river.InsertOpts{Queue: QueueMaintenance}
+}
+
+func init() {
+	Periodic.Register(PeriodicJob{
+		Name:     "purge_organization_exports",
+		Schedule: "@hourly",
+		Args:     func() river.JobArgs { return PurgeOrganizationExportsArgs{} },
+		Jitter:   5 * time.Minute,
+	})
+}
+
+type organizationExportPurger interface {
+	PurgeExpired(ctx context.Context, now time.Time) (int64, error)
+}
+
+type PurgeOrganizationExportsWorker struct {
+	river.WorkerDefaults[PurgeOrganizationExportsArgs]
+
+	exports organizationExportPurger
+}
+
+func NewPurgeOrganizationExportsWorker(exports organizationExportPurger) *PurgeOrganizationExportsWorker {
+	return &PurgeOrganizationExportsWorker{exports: exports}
+}
+
+func (w *PurgeOrganizationExportsWorker) Work(ctx context.Context, _ *river.Job[PurgeOrganizationExportsArgs]) error {
+	n, err := w.exports.PurgeExpired(ctx, time.Now())
+	if err != nil {
+		return err
+	}
+	if n > 0 {
+		slog.InfoContext(ctx, "purged expired organization exports", "count", n)
+	}
+	return nil
+}
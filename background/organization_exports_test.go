@@ -0,0 +1,107 @@
+package background
+
+import (
+	"context"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/riverqueue/river"
+	"github.com/riverqueue/river/rivertype"
+
+	orgServices "github.com/cavenine/queryops/features/organization/services"
+)
+
+type fakeOrganizationExports struct {
+	export   orgServices.OrganizationExport
+	err      error
+	filename string
+	content  []byte
+	failure  string
+}
+
+func (f *fakeOrganizationExports) Get(_ context.Context, id uuid.UUID) (*orgServices.OrganizationExport, error) {
+	if id != f.export.ID {
+		return nil, orgServices.ErrOrganizationExportNotFound
+	}
+	export := f.export
+	return &export, nil
+}
+
+func (f *fakeOrganizationExports) WriteArchive(_ context.Context, _ uuid.UUID, w io.Writer, _ time.Time) error {
+	if f.err != nil {
+		return f.err
+	}
+	_, err := io.WriteString(w, "PK archive")
+	return err
+}
+
+func (f *fakeOrganizationExports) Complete(_ context.Context, _ uuid.UUID, filename string, content []byte) error {
+	f.export.Status = orgServices.ExportReady
+	f.filename, f.content = filename, content
+	return nil
+}
+
+func (f *fakeOrganizationExports) Fail(_ context.Context, _ uuid.UUID, message string) error {
+	f.export.Status = orgServices.ExportFailed
+	f.failure = message
+	return nil
+}
+
+func organizationExportJob(id uuid.UUID, attempt int) *river.Job[orgServices.BuildOrganizationExportArgs] {
+	return &river.Job[orgServices.BuildOrganizationExportArgs]{
+		JobRow: &rivertype.JobRow{Attempt: attempt, MaxAttempts: 3},
+		Args:   orgServices.BuildOrganizationExportArgs{ExportID: id},
+	}
+}
+
+func TestBuildOrganizationExportWorker(t *testing.T) {
+	exports := &fakeOrganizationExports{
+		export: orgServices.OrganizationExport{ID: uuid.New(), OrganizationID: uuid.New(), Status: orgServices.ExportPending},
+	}
+	w := NewBuildOrganizationExportWorker(exports)
+
+	if err := w.Work(context.Background(), organizationExportJob(exports.export.ID, 1)); err != nil {
+		t.Fatalf("Work: %v", err)
+	}
+	if exports.export.Status != orgServices.ExportReady || string(exports.content) != "PK archive" {
+		t.Fatalf("export = %+v, content %q", exports.export, exports.content)
+	}
+	if !strings.HasPrefix(exports.filename, "queryops-export-") || !strings.HasSuffix(exports.filename, ".zip") {
+		t.Fatalf("filename = %q", exports.filename)
+	}
+
+	// Finished exports aren't rebuilt.
+	exports.content = nil
+	if err := w.Work(context.Background(), organizationExportJob(exports.export.ID, 1)); err != nil || exports.content != nil {
+		t.Fatalf("rerun: %v, content %q", err, exports.content)
+	}
+
+	if err := w.Work(context.Background(), organizationExportJob(uuid.New(), 1)); err != nil {
+		t.Fatalf("deleted export: %v", err)
+	}
+}
+
+func TestBuildOrganizationExportWorker_Failures(t *testing.T) {
+	exports := &fakeOrganizationExports{
+		export: orgServices.OrganizationExport{ID: uuid.New(), Status: orgServices.ExportPending},
+		err:    errors.New("statement timeout"),
+	}
+	w := NewBuildOrganizationExportWorker(exports)
+
+	if err := w.Work(context.Background(), organizationExportJob(exports.export.ID, 1)); err == nil {
+		t.Fatalf("first attempt: want the error returned for a retry")
+	}
+	if exports.export.Status != orgServices.ExportPending {
+		t.Fatalf("status after first attempt = %s, want pending", exports.export.Status)
+	}
+	if err := w.Work(context.Background(), organizationExportJob(exports.export.ID, 3)); err != nil {
+		t.Fatalf("last attempt: %v", err)
+	}
+	if exports.export.Status != orgServices.ExportFailed || exports.failure != "statement timeout" {
+		t.Fatalf("after last attempt: status = %s, error = %q", exports.export.Status, exports.failure)
+	}
+}
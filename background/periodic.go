@@ -0,0 +1,140 @@
+package background
+
+import (
+	"fmt"
+	"log/slog"
+	"math/rand/v2"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/riverqueue/river"
+)
+
+// PeriodicJob declares a job that River inserts on a schedule.
+//
+// Only the elected leader among River clients sharing the database inserts
+// periodic jobs, so running several workers doesn't run them several times.
+// Each insert is also unique within the schedule's period, so a run isn't
+// repeated when leadership moves between clients mid-period.
+type PeriodicJob struct {
+	// Name identifies the job in config (PERIODIC_JOBS_DISABLED) and is used
+	// as River's periodic job ID, so it must be unique.
+	Name string
+
+	// Schedule is a five-field cron expression (UTC) or a descriptor such as
+	// "@hourly" or "@every 5m".
+	Schedule string
+
+	// Args builds the job arguments for each run.
+	Args func() river.JobArgs
+
+	// Jitter delays each run by a random amount up to this duration, to
+	// spread load when many instances share a schedule.
+	Jitter time.Duration
+
+	// RunOnStart also inserts a job when the client starts.
+	RunOnStart bool
+}
+
+// PeriodicJobs is a registry of scheduled jobs.
+type PeriodicJobs struct {
+	mu   sync.Mutex
+	jobs []PeriodicJob
+}
+
+// Periodic is the registry wired into every River client. Features register
+// their schedules here, typically from an init function.
+var Periodic = &PeriodicJobs{}
+
+// Register adds job to the registry. It panics on a duplicate name or an
+// invalid schedule, since both are programming errors.
+func (p *PeriodicJobs) Register(job PeriodicJob) {
+	if job.Name == "" || job.Args == nil {
+		panic("background: periodic job requires a name and args")
+	}
+	if _, err := parseSchedule(job.Schedule); err != nil {
+		panic(fmt.Sprintf("background: periodic job %q: %v", job.Name, err))
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, existing := range p.jobs {
+		if existing.Name == job.Name {
+			panic(fmt.Sprintf("background: periodic job %q registered twice", job.Name))
+		}
+	}
+	p.jobs = append(p.jobs, job)
+}
+
+// RiverJobs converts registered jobs to River periodic jobs, skipping any
+// whose name appears in disabled.
+func (p *PeriodicJobs) RiverJobs(disabled map[string]bool) []*river.PeriodicJob {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	out := make([]*river.PeriodicJob, 0, len(p.jobs))
+	for _, job := range p.jobs {
+		if disabled[job.Name] {
+			slog.Info("periodic job disabled by config", "job", job.Name)
+			continue
+		}
+
+		// Validated in Register.
+		schedule, _ := parseSchedule(job.Schedule)
+		if job.Jitter > 0 {
+			schedule = jitterSchedule{inner: schedule, jitter: job.Jitter}
+		}
+
+		args := job.Args
+		opts := &river.InsertOpts{UniqueOpts: river.UniqueOpts{ByPeriod: schedulePeriod(schedule)}}
+		out = append(out, river.NewPeriodicJob(
+			schedule,
+			func() (river.JobArgs, *river.InsertOpts) {
+				return args(), opts
+			},
+			&river.PeriodicJobOpts{ID: job.Name, RunOnStart: job.RunOnStart},
+		))
+	}
+	return out
+}
+
+// ParseDisabledJobs parses a comma-separated list of periodic job names.
+func ParseDisabledJobs(list string) map[string]bool {
+	disabled := make(map[string]bool)
+	for name := range strings.SplitSeq(list, ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			disabled[name] = true
+		}
+	}
+	return disabled
+}
+
+// schedulePeriod returns the shortest gap between a few consecutive runs of
+// schedule, ignoring jitter. Jobs are unique within it, so a new leader
+// taking over doesn't insert a run the old one already did.
+func schedulePeriod(schedule river.PeriodicSchedule) time.Duration {
+	if s, ok := schedule.(jitterSchedule); ok {
+		schedule = s.inner
+	}
+	const samples = 4
+	var period time.Duration
+	t := schedule.Next(time.Date(2000, time.January, 1, 0, 0, 0, 0, time.UTC))
+	for range samples {
+		next := schedule.Next(t)
+		if gap := next.Sub(t); period == 0 || gap < period {
+			period = gap
+		}
+		t = next
+	}
+	return period
+}
+
+type jitterSchedule struct {
+	inner  river.PeriodicSchedule
+	jitter time.Duration
+}
+
+func (s jitterSchedule) Next(t time.Time) time.Time {
+	return s.inner.Next(t).Add(rand.N(s.jitter))
+}
@@ -0,0 +1,78 @@
+package background
+
+import (
+	"testing"
+	"time"
+
+	"github.com/riverqueue/river"
+)
+
+type testPeriodicArgs struct{}
+
+func (testPeriodicArgs) Kind() string { return "test_periodic" }
+
+func TestPeriodicJobs_RiverJobs(t *testing.T) {
+	p := &PeriodicJobs{}
+	p.Register(PeriodicJob{Name: "a", Schedule: "@hourly", Args: func() river.JobArgs { return testPeriodicArgs{} }})
+	p.Register(PeriodicJob{Name: "b", Schedule: "*/5 * * * *", Args: func() river.JobArgs { return testPeriodicArgs{} }})
+
+	if got := len(p.RiverJobs(nil)); got != 2 {
+		t.Fatalf("RiverJobs = %d, want 2", got)
+	}
+	if got := len(p.RiverJobs(ParseDisabledJobs(" b ,"))); got != 1 {
+		t.Fatalf("RiverJobs with b disabled = %d, want 1", got)
+	}
+}
+
+func TestSchedulePeriod(t *testing.T) {
+	for spec, want := range map[string]time.Duration{
+		"* * * * *":       time.Minute,
+		"*/5 * * * *":     5 * time.Minute,
+		"5 * * * *":       time.Hour,
+		"@daily":          24 * time.Hour,
+		"0 9,17 * * *":    8 * time.Hour,
+		"@every 90s":      90 * time.Second,
+		"0 0 * * 1-5":     24 * time.Hour,
+		"*/15 8-18 * * *": 15 * time.Minute,
+	} {
+		schedule, err := parseSchedule(spec)
+		if err != nil {
+			t.Fatalf("parseSchedule(%q): %v", spec, err)
+		}
+		if got := schedulePeriod(schedule); got != want {
+			t.Errorf("schedulePeriod(%q) = %v, want %v", spec, got, want)
+		}
+		if got := schedulePeriod(jitterSchedule{inner: schedule, jitter: time.Minute}); got != want {
+			t.Errorf("schedulePeriod(%q with jitter) = %v, want %v", spec, got, want)
+		}
+	}
+}
+
+func TestPeriodicJobs_RegisterPanics(t *testing.T) {
+	args := func() river.JobArgs { return testPeriodicArgs{} }
+
+	for name, job := range map[string]PeriodicJob{
+		"bad schedule": {Name: "x", Schedule: "nope", Args: args},
+		"no args":      {Name: "x", Schedule: "@hourly"},
+	} {
+		t.Run(name, func(t *testing.T) {
+			defer func() {
+				if recover() == nil {
+					t.Fatalf("Register did not panic")
+				}
+			}()
+			(&PeriodicJobs{}).Register(job)
+		})
+	}
+
+	t.Run("duplicate", func(t *testing.T) {
+		p := &PeriodicJobs{}
+		p.Register(PeriodicJob{Name: "x", Schedule: "@hourly", Args: args})
+		defer func() {
+			if recover() == nil {
+				t.Fatalf("Register did not panic")
+			}
+		}()
+		p.Register(PeriodicJob{Name: "x", Schedule: "@hourly", Args: args})
+	})
+}
@@ -0,0 +1,71 @@
+package background
+
+import (
+	"fmt"
+	"log/slog"
+	"strconv"
+	"strings"
+
+	"github.com/riverqueue/river"
+)
+
+// Queue names. Heavy or bulk jobs get their own queues so a backlog there
+// can't starve lightweight jobs on the default queue.
+const (
+	// QueueDefault is for short, latency-sensitive jobs.
+	QueueDefault = river.QueueDefault
+	// QueueMaintenance is for periodic sweeps such as stale-target expiry.
+	QueueMaintenance = "maintenance"
+	// QueueIngest is for bulk data jobs: log ingestion, retention purges,
+	// exports.
+	QueueIngest = "ingest"
+	// QueueNotifications is for outbound email and webhook delivery.
+	QueueNotifications = "notifications"
+)
+
+// DefaultQueueSpec is used when RIVER_QUEUES is unset or invalid.
+const DefaultQueueSpec = "default:10,maintenance:2,ingest:4,notifications:5"
+
+// requiredQueues must be worked by every client because registered jobs are
+// inserted into them.
+var requiredQueues = []string{QueueDefault, QueueMaintenance, QueueIngest, QueueNotifications}
+
+// ParseQueues parses a comma-separated list of name:max_workers pairs, e.g.
+// "default:10,ingest:2".
+func ParseQueues(spec string) (map[string]river.QueueConfig, error) {
+	queues := make(map[string]river.QueueConfig)
+	for part := range strings.SplitSeq(spec, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		name, workers, ok := strings.Cut(part, ":")
+		name = strings.TrimSpace(name)
+		if !ok || name == "" {
+			return nil, fmt.Errorf("parsing queue %q: want name:max_workers", part)
+		}
+		n, err := strconv.Atoi(strings.TrimSpace(workers))
+		if err != nil || n < 1 {
+			return nil, fmt.Errorf("parsing queue %q: max_workers must be a positive integer", part)
+		}
+		if _, dup := queues[name]; dup {
+			return nil, fmt.Errorf("parsing queues: duplicate queue %q", name)
+		}
+		queues[name] = river.QueueConfig{MaxWorkers: n}
+	}
+	if len(queues) == 0 {
+		return nil, fmt.Errorf("parsing queues: no queues defined")
+	}
+	return queues, nil
+}
+
+// ensureRequiredQueues adds any required queue missing from queues with a
+// single worker, so jobs inserted there are never stranded.
+func ensureRequiredQueues(queues map[string]river.QueueConfig) {
+	for _, name := range requiredQueues {
+		if _, ok := queues[name]; !ok {
+			slog.Warn("river queue not configured; running with one worker", "queue", name)
+			queues[name] = river.QueueConfig{MaxWorkers: 1}
+		}
+	}
+}
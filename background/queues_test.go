@@ -0,0 +1,39 @@
+package background
+
+import "testing"
+
+func TestParseQueues(t *testing.T) {
+	queues, err := ParseQueues(" default:10, ingest:2 ")
+	if err != nil {
+		t.Fatalf("ParseQueues: %v", err)
+	}
+	if len(queues) != 2 {
+		t.Fatalf("queues = %d, want 2", len(queues))
+	}
+	if got := queues[QueueDefault].MaxWorkers; got != 10 {
+		t.Fatalf("default MaxWorkers = %d, want 10", got)
+	}
+	if got := queues[QueueIngest].MaxWorkers; got != 2 {
+		t.Fatalf("ingest MaxWorkers = %d, want 2", got)
+	}
+}
+
+func TestParseQueues_Invalid(t *testing.T) {
+	for _, spec := range []string{"", "default", "default:0", "default:x", ":3", "a:1,a:2"} {
+		if _, err := ParseQueues(spec); err == nil {
+			t.Errorf("ParseQueues(%q): expected error", spec)
+		}
+	}
+}
+
+func TestDefaultQueueSpec_CoversRequiredQueues(t *testing.T) {
+	queues, err := ParseQueues(DefaultQueueSpec)
+	if err != nil {
+		t.Fatalf("ParseQueues(DefaultQueueSpec): %v", err)
+	}
+	for _, name := range requiredQueues {
+		if _, ok := queues[name]; !ok {
+			t.Errorf("DefaultQueueSpec missing required queue %q", name)
+		}
+	}
+}
@@ -0,0 +1,74 @@
+package background
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/riverqueue/river"
+
+	notificationServices "github.com/cavenine/queryops/features/notification/services"
+)
+
+const (
+	// resultBaselineWindow is how much history a host's results are
+	// compared with.
+	resultBaselineWindow = 7 * 24 * time.Hour
+	// resultBaselineMinSamples is how many hours in the window a host must
+	// have reported a query before its results are compared, so a newly
+	// scheduled query or enrolled host isn't flagged while it has no history.
+	resultBaselineMinSamples = 24
+)
+
+// DetectResultAnomaliesArgs compares the last full hour of scheduled query
+// results with each host's baseline, for every organization's baseline rules.
+type DetectResultAnomaliesArgs struct{}
+
+func (DetectResultAnomaliesArgs) Kind() string {
+	return "detect_result_anomalies"
+}
+
+func (DetectResultAnomaliesArgs) InsertOpts() river.InsertOpts {
+	return river.InsertOpts{Queue: QueueNotifications}
+}
+
+func init() {
+	// A few minutes past the hour, so logs buffered by osquery for the last
+	// hour have arrived.
+	Periodic.Register(PeriodicJob{
+		Name:       "detect_result_anomalies",
+		Schedule:   "5 * * * *",
+		Args:       func() river.JobArgs { return DetectResultAnomaliesArgs{} },
+		Jitter:     time.Minute,
+		RunOnStart: true,
+	})
+}
+
+type resultAnomalyNotifier interface {
+	NotifyResultAnomalies(ctx context.Context, hour time.Time, window time.Duration, minSamples int) ([]notificationServices.ResultAnomaly, error)
+}
+
+type DetectResultAnomaliesWorker struct {
+	river.WorkerDefaults[DetectResultAnomaliesArgs]
+
+	repo resultAnomalyNotifier
+}
+
+func NewDetectResultAnomaliesWorker(repo resultAnomalyNotifier) *DetectResultAnomaliesWorker {
+	return &DetectResultAnomaliesWorker{repo: repo}
+}
+
+// Work evaluates the last full hour. Running again in the same hour, as on a
+// restart, flags nothing new.
+func (w *DetectResultAnomaliesWorker) Work(ctx context.Context, _ *river.Job[DetectResultAnomaliesArgs]) error {
+	hour := time.Now().UTC().Truncate(time.Hour).Add(-time.Hour)
+	anomalies, err := w.repo.NotifyResultAnomalies(ctx, hour, resultBaselineWindow, resultBaselineMinSamples)
+	if err != nil {
+		return fmt.Errorf("detecting result anomalies: %w", err)
+	}
+	if len(anomalies) > 0 {
+		slog.InfoContext(ctx, "flagged result anomalies", "anomalies", len(anomalies), "hour", hour)
+	}
+	return nil
+}
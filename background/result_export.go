@@ -0,0 +1,221 @@
+package background
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/url"
+	"path/filepath"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/riverqueue/river"
+
+	"github.com/cavenine/queryops/config"
+	"github.com/cavenine/queryops/features/osquery/services"
+	"github.com/cavenine/queryops/internal/objectstore"
+	"github.com/cavenine/queryops/internal/parquet"
+)
+
+const (
+	// exportFileRows and exportFileBytes bound one Parquet file, which is
+	// built in memory before it's written.
+	exportFileRows  = 100_000
+	exportFileBytes = 64 << 20
+
+	// exportTimeout bounds one attempt at an export. A year of results
+	// takes far longer than River's default job timeout.
+	exportTimeout = time.Hour
+
+	parquetContentType = "application/vnd.apache.parquet"
+)
+
+// exportColumns are the columns of every exported Parquet file. The date and
+// query name are also encoded in each file's key, Hive-style.
+var exportColumns = []parquet.Column{
+	{Name: "host_id", Type: parquet.String},
+	{Name: "host_identifier", Type: parquet.String},
+	{Name: "name", Type: parquet.String},
+	{Name: "action", Type: parquet.String},
+	{Name: "columns", Type: parquet.JSON},
+	{Name: "timestamp", Type: parquet.Timestamp, Optional: true},
+	{Name: "created_at", Type: parquet.Timestamp},
+	{Name: "decorations", Type: parquet.JSON, Optional: true},
+}
+
+type resultExportStore interface {
+	Get(ctx context.Context, id uuid.UUID) (*services.ResultExport, error)
+	EachResult(ctx context.Context, organizationID uuid.UUID, start, end time.Time, fn func(services.ExportedResult) error) error
+	Complete(ctx context.Context, id uuid.UUID, destination string, files int, rows int64) error
+	Fail(ctx context.Context, id uuid.UUID, message string) error
+}
+
+type exportWriter interface {
+	Put(ctx context.Context, key string, body []byte, contentType string) error
+}
+
+// ExportResultsWorker writes the results of a requested export to Parquet
+// files under
+//
+//	<prefix>org=<organization>/date=<YYYY-MM-DD>/query=<name>/<export>-<n>.parquet
+//
+// Keys depend only on the export and its rows, so a retried attempt
+// overwrites the files of the one before it.
+type ExportResultsWorker struct {
+	river.WorkerDefaults[services.ExportResultsArgs]
+
+	exports resultExportStore
+	// files is nil when no export destination is configured.
+	files exportWriter
+	// location names where files puts objects, such as "s3://bucket/",
+	// for the destination recorded on the export.
+	location string
+	prefix   string
+}
+
+func NewExportResultsWorker(exports resultExportStore, files exportWriter, location, prefix string) *ExportResultsWorker {
+	return &ExportResultsWorker{exports: exports, files: files, location: location, prefix: prefix}
+}
+
+// resultExportFiles returns where result exports are written, as configured
+// by RESULT_EXPORT_DIR or RESULT_EXPORT_BUCKET, and a location naming it. It
+// returns a nil writer if neither is set.
+func resultExportFiles() (exportWriter, string, error) {
+	if dir := config.Global.ResultExportDir; dir != "" {
+		return objectstore.NewDir(dir), filepath.ToSlash(filepath.Clean(dir)) + "/", nil
+	}
+	bucket := config.Global.ResultExportBucket
+	if bucket == "" {
+		return nil, "", nil
+	}
+	store, err := objectstore.New(objectstore.Config{
+		Endpoint:        config.Global.LogArchiveEndpoint,
+		Region:          config.Global.LogArchiveRegion,
+		Bucket:          bucket,
+		AccessKeyID:     config.Global.LogArchiveAccessKeyID,
+		SecretAccessKey: config.Global.LogArchiveSecretAccessKey,
+		PathStyle:       config.Global.LogArchivePathStyle,
+	}, nil)
+	if err != nil {
+		return nil, "", fmt.Errorf("configuring result export bucket: %w", err)
+	}
+	return store, "s3://" + bucket + "/", nil
+}
+
+func (w *ExportResultsWorker) Timeout(*river.Job[services.ExportResultsArgs]) time.Duration {
+	return exportTimeout
+}
+
+func (w *ExportResultsWorker) Work(ctx context.Context, job *river.Job[services.ExportResultsArgs]) error {
+	export, err := w.exports.Get(ctx, job.Args.ExportID)
+	if err != nil {
+		if errors.Is(err, services.ErrResultExportNotFound) {
+			// Its organization was deleted.
+			return nil
+		}
+		return fmt.Errorf("loading result export: %w", err)
+	}
+	if export.Status != services.ExportPending {
+		return nil
+	}
+	if w.files == nil {
+		return w.exports.Fail(ctx, export.ID, "result exports are not configured")
+	}
+
+	files, rows, err := w.export(ctx, export)
+	if err != nil {
+		if job.Attempt < job.MaxAttempts {
+			return err
+		}
+		slog.WarnContext(ctx, "result export failed",
+			"export_id", export.ID,
+			"organization_id", export.OrganizationID,
+			"error", err,
+		)
+		return w.exports.Fail(ctx, export.ID, err.Error())
+	}
+
+	destination := w.location + w.orgPrefix(export.OrganizationID)
+	if err := w.exports.Complete(ctx, export.ID, destination, files, rows); err != nil {
+		return err
+	}
+	slog.InfoContext(ctx, "result export completed",
+		"export_id", export.ID,
+		"organization_id", export.OrganizationID,
+		"files", files,
+		"rows", rows,
+	)
+	return nil
+}
+
+func (w *ExportResultsWorker) orgPrefix(organizationID uuid.UUID) string {
+	return w.prefix + "org=" + organizationID.String() + "/"
+}
+
+// export writes the export's results, starting a new file for each date and
+// query name and whenever one grows past the size limits. It returns how many
+// files and rows it wrote.
+func (w *ExportResultsWorker) export(ctx context.Context, export *services.ResultExport) (int, int64, error) {
+	var (
+		files     int
+		rows      int64
+		file      *parquet.Writer
+		partition string
+		part      int
+	)
+	flush := func() error {
+		if file == nil || file.Rows() == 0 {
+			return nil
+		}
+		body, err := file.Bytes()
+		if err != nil {
+			return err
+		}
+		key := fmt.Sprintf("%s%s/%s-%d.parquet", w.orgPrefix(export.OrganizationID), partition, export.ID, part)
+		if err := w.files.Put(ctx, key, body, parquetContentType); err != nil {
+			return fmt.Errorf("writing %s: %w", key, err)
+		}
+		files++
+		part++
+		file = parquet.NewWriter(exportColumns)
+		return nil
+	}
+
+	err := w.exports.EachResult(ctx, export.OrganizationID, export.Start, export.End, func(r services.ExportedResult) error {
+		if p := exportPartition(r); p != partition {
+			if err := flush(); err != nil {
+				return err
+			}
+			file, partition, part = parquet.NewWriter(exportColumns), p, 0
+		} else if file.Rows() >= exportFileRows || file.Size() >= exportFileBytes {
+			if err := flush(); err != nil {
+				return err
+			}
+		}
+		// A nil slice in an any isn't nil, so results without decorations
+		// are passed a bare nil to be null.
+		var decorations any
+		if len(r.Decorations) > 0 {
+			decorations = []byte(r.Decorations)
+		}
+		if err := file.Append(r.HostID.String(), r.HostIdentifier, r.Name, r.Action, []byte(r.Columns), r.Timestamp, r.CreatedAt, decorations); err != nil {
+			return err
+		}
+		rows++
+		return nil
+	})
+	if err != nil {
+		return 0, 0, err
+	}
+	if err := flush(); err != nil {
+		return 0, 0, err
+	}
+	return files, rows, nil
+}
+
+// exportPartition returns the key segment of the file r belongs in. It
+// matches the order EachResult returns results in.
+func exportPartition(r services.ExportedResult) string {
+	return "date=" + r.CreatedAt.UTC().Format(time.DateOnly) + "/query=" + url.PathEscape(r.Name)
+}
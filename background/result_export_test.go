@@ -0,0 +1,168 @@
+package background
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/riverqueue/river"
+	"github.com/riverqueue/river/rivertype"
+
+	"github.com/cavenine/queryops/features/osquery/services"
+)
+
+type fakeResultExports struct {
+	export      services.ResultExport
+	results     []services.ExportedResult
+	destination string
+	files       int
+	rows        int64
+	failure     string
+}
+
+func (f *fakeResultExports) Get(_ context.Context, id uuid.UUID) (*services.ResultExport, error) {
+	if id != f.export.ID {
+		return nil, services.ErrResultExportNotFound
+	}
+	export := f.export
+	return &export, nil
+}
+
+func (f *fakeResultExports) EachResult(_ context.Context, _ uuid.UUID, _, _ time.Time, fn func(services.ExportedResult) error) error {
+	for _, r := range f.results {
+		if err := fn(r); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (f *fakeResultExports) Complete(_ context.Context, _ uuid.UUID, destination string, files int, rows int64) error {
+	f.export.Status = services.ExportCompleted
+	f.destination, f.files, f.rows = destination, files, rows
+	return nil
+}
+
+func (f *fakeResultExports) Fail(_ context.Context, _ uuid.UUID, message string) error {
+	f.export.Status = services.ExportFailed
+	f.failure = message
+	return nil
+}
+
+type fakeExportFiles struct {
+	objects map[string][]byte
+	err     error
+}
+
+func (f *fakeExportFiles) Put(_ context.Context, key string, body []byte, _ string) error {
+	if f.err != nil {
+		return f.err
+	}
+	f.objects[key] = body
+	return nil
+}
+
+func exportJob(id uuid.UUID, attempt int) *river.Job[services.ExportResultsArgs] {
+	return &river.Job[services.ExportResultsArgs]{
+		JobRow: &rivertype.JobRow{Attempt: attempt, MaxAttempts: 3},
+		Args:   services.ExportResultsArgs{ExportID: id},
+	}
+}
+
+func TestExportResultsWorker(t *testing.T) {
+	orgID := uuid.New()
+	hostID := uuid.New()
+	day := time.Date(2026, 2, 9, 0, 0, 0, 0, time.UTC)
+	result := func(name string, at time.Time) services.ExportedResult {
+		return services.ExportedResult{
+			HostID: hostID, HostIdentifier: "host-a", Name: name, Action: "added",
+			Columns: json.RawMessage(`{"pid":"1"}`), CreatedAt: at,
+		}
+	}
+	decorated := result("users", day.Add(25*time.Hour))
+	decorated.Decorations = json.RawMessage(`{"hostname":"host-a","org_tag":"acme"}`)
+	exports := &fakeResultExports{
+		export: services.ResultExport{ID: uuid.New(), OrganizationID: orgID, Status: services.ExportPending},
+		results: []services.ExportedResult{
+			result("pack/procs", day.Add(time.Hour)),
+			result("pack/procs", day.Add(2*time.Hour)),
+			result("users", day.Add(time.Hour)),
+			decorated,
+		},
+	}
+	files := &fakeExportFiles{objects: map[string][]byte{}}
+	w := NewExportResultsWorker(exports, files, "s3://bucket/", "results/")
+
+	if err := w.Work(context.Background(), exportJob(exports.export.ID, 1)); err != nil {
+		t.Fatalf("Work: %v", err)
+	}
+
+	org := "results/org=" + orgID.String() + "/"
+	id := exports.export.ID.String()
+	want := []string{
+		org + "date=2026-02-09/query=pack%2Fprocs/" + id + "-0.parquet",
+		org + "date=2026-02-09/query=users/" + id + "-0.parquet",
+		org + "date=2026-02-10/query=users/" + id + "-0.parquet",
+	}
+	var keys []string
+	for key, body := range files.objects {
+		keys = append(keys, key)
+		if !bytes.HasPrefix(body, []byte("PAR1")) {
+			t.Fatalf("%s is not a Parquet file", key)
+		}
+	}
+	slices.Sort(keys)
+	if !slices.Equal(keys, want) {
+		t.Fatalf("wrote %v, want %v", keys, want)
+	}
+	if exports.export.Status != services.ExportCompleted || exports.files != 3 || exports.rows != 4 || exports.destination != "s3://bucket/"+org {
+		t.Fatalf("export = %+v, completed with %d files, %d rows, at %s", exports.export, exports.files, exports.rows, exports.destination)
+	}
+
+	// Finished exports aren't rerun.
+	clear(files.objects)
+	if err := w.Work(context.Background(), exportJob(exports.export.ID, 1)); err != nil || len(files.objects) != 0 {
+		t.Fatalf("rerun: %v, wrote %d files", err, len(files.objects))
+	}
+}
+
+func TestExportResultsWorker_Failures(t *testing.T) {
+	newExports := func() *fakeResultExports {
+		return &fakeResultExports{
+			export:  services.ResultExport{ID: uuid.New(), Status: services.ExportPending},
+			results: []services.ExportedResult{{Name: "users", Columns: json.RawMessage(`{}`), CreatedAt: time.Now()}},
+		}
+	}
+
+	exports := newExports()
+	if err := NewExportResultsWorker(exports, nil, "", "").Work(context.Background(), exportJob(exports.export.ID, 1)); err != nil {
+		t.Fatalf("unconfigured: %v", err)
+	}
+	if exports.export.Status != services.ExportFailed {
+		t.Fatalf("unconfigured export status = %s, want failed", exports.export.Status)
+	}
+
+	exports = newExports()
+	w := NewExportResultsWorker(exports, &fakeExportFiles{err: errors.New("bucket unavailable")}, "", "")
+	if err := w.Work(context.Background(), exportJob(exports.export.ID, 1)); err == nil {
+		t.Fatalf("first attempt: want the error returned for a retry")
+	}
+	if exports.export.Status != services.ExportPending {
+		t.Fatalf("status after first attempt = %s, want pending", exports.export.Status)
+	}
+	if err := w.Work(context.Background(), exportJob(exports.export.ID, 3)); err != nil {
+		t.Fatalf("last attempt: %v", err)
+	}
+	if exports.export.Status != services.ExportFailed || exports.failure == "" {
+		t.Fatalf("after last attempt: status = %s, error = %q", exports.export.Status, exports.failure)
+	}
+
+	if err := w.Work(context.Background(), exportJob(uuid.New(), 1)); err != nil {
+		t.Fatalf("deleted export: %v", err)
+	}
+}
@@ -0,0 +1,69 @@
+package background
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/riverqueue/river"
+)
+
+// retentionBatchSize bounds each delete the retention purge runs, so it
+// doesn't hold long locks on the log tables.
+const retentionBatchSize = 5000
+
+// PurgeExpiredLogsArgs deletes scheduled query results and status logs older
+// than their organization's retention period, and host timeline events older
+// than services.HostEventRetention.
+type PurgeExpiredLogsArgs struct{}
+
+func (PurgeExpiredLogsArgs) Kind() string {
+	return "purge_expired_logs"
+}
+
+func (PurgeExpiredLogsArgs) InsertOpts() river.InsertOpts {
+	return river.InsertOpts{Queue: QueueIngest}
+}
+
+func init() {
+	Periodic.Register(PeriodicJob{
+		Name:     "purge_expired_logs",
+		Schedule: "@hourly",
+		Args:     func() river.JobArgs { return PurgeExpiredLogsArgs{} },
+		Jitter:   5 * time.Minute,
+	})
+}
+
+type expiredLogPurger interface {
+	PurgeExpiredLogs(ctx context.Context, now time.Time, batchSize int) (int, error)
+}
+
+type PurgeExpiredLogsWorker struct {
+	river.WorkerDefaults[PurgeExpiredLogsArgs]
+
+	repo expiredLogPurger
+}
+
+func NewPurgeExpiredLogsWorker(repo expiredLogPurger) *PurgeExpiredLogsWorker {
+	return &PurgeExpiredLogsWorker{repo: repo}
+}
+
+func (w *PurgeExpiredLogsWorker) Work(ctx context.Context, _ *river.Job[PurgeExpiredLogsArgs]) error {
+	now := time.Now()
+	var total int
+	for {
+		n, err := w.repo.PurgeExpiredLogs(ctx, now, retentionBatchSize)
+		if err != nil {
+			return fmt.Errorf("purging expired logs: %w", err)
+		}
+		total += n
+		if n == 0 {
+			break
+		}
+	}
+	if total > 0 {
+		slog.InfoContext(ctx, "purged expired logs", "count", total)
+	}
+	return nil
+}
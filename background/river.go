@@ -8,6 +8,7 @@ import (
 	"sort"
 	"time"
 
+	"github.com/ThreeDotsLabs/watermill/message"
 	"github.com/jackc/pgx/v5"
 	"github.com/jackc/pgx/v5/pgxpool"
 	"github.com/riverqueue/river"
@@ -16,22 +17,38 @@ import (
 
 	"github.com/cavenine/queryops/config"
 	"github.com/cavenine/queryops/db"
+	authServices "github.com/cavenine/queryops/features/auth/services"
+	dashboardServices "github.com/cavenine/queryops/features/dashboard/services"
+	notificationServices "github.com/cavenine/queryops/features/notification/services"
+	orgServices "github.com/cavenine/queryops/features/organization/services"
+	"github.com/cavenine/queryops/features/osquery/services"
+	"github.com/cavenine/queryops/internal/crypto"
+	"github.com/cavenine/queryops/internal/notify"
 )
 
 // ClientConfig configures River queues for a client.
 type ClientConfig struct {
 	Queues map[string]river.QueueConfig
+
+	// AdminAddr, if set, is the listen address for the worker admin endpoints
+	// (/healthz, /stats, /drain).
+	AdminAddr string
+
+	// StatsInterval, if positive, logs a queue summary at this interval.
+	StatsInterval time.Duration
 }
 
-// DefaultClientConfig returns a sensible default client configuration.
+const workerStopTimeout = 30 * time.Second
+
+// DefaultClientConfig returns a client configuration with queues taken from
+// config.Global.RiverQueues, falling back to DefaultQueueSpec if it is invalid.
 func DefaultClientConfig() *ClientConfig {
-	return &ClientConfig{
-		Queues: map[string]river.QueueConfig{
-			river.QueueDefault: {
-				MaxWorkers: 10,
-			},
-		},
+	queues, err := ParseQueues(config.Global.RiverQueues)
+	if err != nil {
+		slog.Warn("invalid RIVER_QUEUES; using defaults", "error", err, "default", DefaultQueueSpec)
+		queues, _ = ParseQueues(DefaultQueueSpec)
 	}
+	return &ClientConfig{Queues: queues}
 }
 
 type SortArgs struct {
@@ -55,14 +72,92 @@ func (w *SortWorker) Work(ctx context.Context, job *river.Job[SortArgs]) error {
 }
 
 // NewWorkers constructs a Workers bundle and registers all workers.
-// New workers should be added here.
-func NewWorkers() *river.Workers {
+// New workers should be added here. publisher may be nil.
+func NewWorkers(pool *pgxpool.Pool, publisher message.Publisher, keys *crypto.Keyring) *river.Workers {
+	hostRepo := services.NewHostRepository(pool)
+	notifications := notificationServices.NewNotificationRepository(pool)
+
 	workers := river.NewWorkers()
-	// TODO: register workers with river.AddWorker(workers, &YourWorker{})
 	river.AddWorker(workers, &SortWorker{})
+	river.AddWorker(workers, NewExpireStaleTargetsWorker(
+		hostRepo,
+		publisher,
+		time.Duration(config.Global.CampaignTargetTimeoutMs)*time.Millisecond,
+	))
+	mailer := notify.NewMailer(notify.SMTPConfig{
+		Addr:     config.Global.SMTPAddr,
+		Username: config.Global.SMTPUsername,
+		Password: config.Global.SMTPPassword,
+		From:     config.Global.SMTPFrom,
+	})
+	river.AddWorker(workers, NewSendDigestsWorker(
+		orgServices.NewDigestRepository(pool),
+		mailer,
+		notify.NewWebhook(nil),
+		notifications,
+	))
+	generateNotifications := NewGenerateNotificationsWorker(
+		notifications,
+		time.Duration(config.Global.NotifyHostOfflineMs)*time.Millisecond,
+	)
+	config.OnReload(func(c *config.Config) {
+		generateNotifications.SetOfflineAfter(time.Duration(c.NotifyHostOfflineMs) * time.Millisecond)
+	})
+	river.AddWorker(workers, generateNotifications)
+	river.AddWorker(workers, NewEvaluateStatusAlertsWorker(notifications, notify.NewWebhook(nil), notifications))
+	river.AddWorker(workers, NewDetectResultAnomaliesWorker(notifications))
+	river.AddWorker(workers, NewDeliverSlackMessagesWorker(
+		orgServices.NewSlackRepository(pool, keys),
+		notify.NewSlack(notify.NewWebhook(nil), ""),
+		config.Global.WebAuthnRPOrigin,
+		notifications,
+	))
+	river.AddWorker(workers, NewEvaluateAlertDestinationsWorker(
+		orgServices.NewAlertDestinationRepository(pool, keys),
+		notify.NewWebhook(nil),
+		config.Global.WebAuthnRPOrigin,
+		notifications,
+	))
+	river.AddWorker(workers, NewPurgeExpiredLogsWorker(hostRepo))
+	river.AddWorker(workers, NewArchiveOldCampaignsWorker(hostRepo))
+	river.AddWorker(workers, NewRollupAPIUsageWorker(orgServices.NewAPIUsageRepository(pool)))
+	river.AddWorker(workers, NewRefreshDashboardViewsWorker(
+		dashboardServices.NewDashboardRepository(pool, config.Global.DashboardLargeOrgHosts),
+	))
+	river.AddWorker(workers, NewBuildEnrollmentPackageWorker(
+		orgServices.NewEnrollmentPackageRepository(pool, keys, nil),
+		orgServices.NewOrganizationRepository(pool, keys),
+	))
+	river.AddWorker(workers, NewDeleteAccountsWorker(authServices.NewAccountDeletionRepository(pool), mailer))
+	organizationExports := orgServices.NewOrganizationExportRepository(pool, nil)
+	river.AddWorker(workers, NewBuildOrganizationExportWorker(organizationExports))
+	river.AddWorker(workers, NewPurgeOrganizationExportsWorker(organizationExports))
+	exportFiles, exportLocation, err := resultExportFiles()
+	if err != nil {
+		slog.Error("result exports will fail until fixed", "error", err)
+	}
+	river.AddWorker(workers, NewExportResultsWorker(
+		services.NewResultExportRepository(pool, nil),
+		exportFiles,
+		exportLocation,
+		config.Global.ResultExportPrefix,
+	))
 	return workers
 }
 
+// NewInsertClient constructs a River client that only inserts jobs, for
+// processes that enqueue work but don't run workers.
+func NewInsertClient(pool *pgxpool.Pool) (*river.Client[pgx.Tx], error) {
+	if pool == nil {
+		return nil, errors.New("nil pool provided to NewInsertClient")
+	}
+	client, err := river.NewClient(riverpgxv5.New(pool), &river.Config{})
+	if err != nil {
+		return nil, fmt.Errorf("creating river insert client: %w", err)
+	}
+	return client, nil
+}
+
 // NewClient constructs a River client using the provided pool, workers, and config.
 func NewClient(pool *pgxpool.Pool, workers *river.Workers, cfg *ClientConfig) (*river.Client[pgx.Tx], error) {
 	if pool == nil {
@@ -72,9 +167,16 @@ func NewClient(pool *pgxpool.Pool, workers *river.Workers, cfg *ClientConfig) (*
 		cfg = DefaultClientConfig()
 	}
 
+	queues := make(map[string]river.QueueConfig, len(cfg.Queues))
+	for name, q := range cfg.Queues {
+		queues[name] = q
+	}
+	ensureRequiredQueues(queues)
+
 	riverCfg := &river.Config{
-		Queues:  cfg.Queues,
-		Workers: workers,
+		Queues:       queues,
+		Workers:      workers,
+		PeriodicJobs: Periodic.RiverJobs(ParseDisabledJobs(config.Global.PeriodicJobsDisabled)),
 	}
 
 	client, err := river.NewClient(riverpgxv5.New(pool), riverCfg)
@@ -92,7 +194,7 @@ func MigrateRiver(ctx context.Context, cfg *config.Config) error {
 		return errors.New("config is nil")
 	}
 
-	pool, err := db.NewPool(ctx, cfg)
+	pool, err := db.NewPool(ctx, cfg, nil)
 	if err != nil {
 		return fmt.Errorf("creating pool for river migrations: %w", err)
 	}
@@ -112,9 +214,14 @@ func MigrateRiver(ctx context.Context, cfg *config.Config) error {
 }
 
 // RunWorker starts a River client and works jobs until the context is cancelled.
-// It is intended for use by the dedicated worker command.
-func RunWorker(ctx context.Context, pool *pgxpool.Pool, cfg *ClientConfig) error {
-	workers := NewWorkers()
+// It is intended for use by the dedicated worker command. publisher may be nil,
+// in which case jobs skip publishing real-time events.
+func RunWorker(ctx context.Context, pool *pgxpool.Pool, publisher message.Publisher, cfg *ClientConfig) error {
+	keys, err := crypto.ParseKeyring(config.Global.EncryptionKeys)
+	if err != nil {
+		return fmt.Errorf("loading ENCRYPTION_KEYS: %w", err)
+	}
+	workers := NewWorkers(pool, publisher, keys)
 
 	client, err := NewClient(pool, workers, cfg)
 	if err != nil {
@@ -128,10 +235,31 @@ func RunWorker(ctx context.Context, pool *pgxpool.Pool, cfg *ClientConfig) error
 		return fmt.Errorf("running river workers: %w", err)
 	}
 
-	<-ctx.Done()
+	if cfg != nil && cfg.StatsInterval > 0 {
+		go logStats(ctx, pool, client.ID(), cfg.StatsInterval)
+	}
+
+	adminErr := make(chan error, 1)
+	if cfg != nil && cfg.AdminAddr != "" {
+		admin := newWorkerAdmin(pool, client)
+		go func() {
+			adminErr <- admin.serve(ctx, cfg.AdminAddr)
+		}()
+	}
+
+	select {
+	case <-ctx.Done():
+	case <-client.Stopped():
+		// Drained through the admin endpoint.
+		return nil
+	case err := <-adminErr:
+		if err != nil {
+			return err
+		}
+		<-ctx.Done()
+	}
 
-	const stopTimeout = 30 * time.Second
-	stopCtx, cancel := context.WithTimeout(context.Background(), stopTimeout)
+	stopCtx, cancel := context.WithTimeout(context.Background(), workerStopTimeout)
 	defer cancel()
 
 	if err := client.Stop(stopCtx); err != nil && !errors.Is(err, context.Canceled) {
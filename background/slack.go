@@ -0,0 +1,177 @@
+package background
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/riverqueue/river"
+
+	notificationServices "github.com/cavenine/queryops/features/notification/services"
+	orgServices "github.com/cavenine/queryops/features/organization/services"
+	"github.com/cavenine/queryops/internal/notify"
+)
+
+const (
+	// slackBatch caps the messages one run posts; the rest are posted by the
+	// next run.
+	slackBatch = 200
+	// slackRetryAfter is how long a failed message waits before its first
+	// retry. The wait doubles with each attempt.
+	slackRetryAfter = time.Minute
+	// slackMaxAttempts is how many times a message is tried before it's
+	// given up on and the organization is told.
+	slackMaxAttempts = 5
+)
+
+// DeliverSlackMessagesArgs posts queued Slack messages to organizations'
+// webhooks and channels.
+type DeliverSlackMessagesArgs struct{}
+
+func (DeliverSlackMessagesArgs) Kind() string {
+	return "deliver_slack_messages"
+}
+
+func (DeliverSlackMessagesArgs) InsertOpts() river.InsertOpts {
+	return river.InsertOpts{Queue: QueueNotifications}
+}
+
+func init() {
+	Periodic.Register(PeriodicJob{
+		Name:       "deliver_slack_messages",
+		Schedule:   "* * * * *",
+		Args:       func() river.JobArgs { return DeliverSlackMessagesArgs{} },
+		Jitter:     10 * time.Second,
+		RunOnStart: true,
+	})
+}
+
+type slackMessageQueue interface {
+	ClaimMessages(ctx context.Context, limit int, retryAfter time.Duration, maxAttempts int) ([]orgServices.SlackDelivery, error)
+	MarkMessageSent(ctx context.Context, id int64) error
+	MarkMessageFailed(ctx context.Context, id int64, reason string) error
+}
+
+type DeliverSlackMessagesWorker struct {
+	river.WorkerDefaults[DeliverSlackMessagesArgs]
+
+	repo  slackMessageQueue
+	slack *notify.Slack
+	// origin is prefixed to messages' links, e.g. "https://queryops.example.com";
+	// empty leaves links out.
+	origin   string
+	notifier organizationNotifier // nil disables failure notifications
+}
+
+func NewDeliverSlackMessagesWorker(repo slackMessageQueue, slack *notify.Slack, origin string, notifier organizationNotifier) *DeliverSlackMessagesWorker {
+	return &DeliverSlackMessagesWorker{
+		repo:     repo,
+		slack:    slack,
+		origin:   strings.TrimSuffix(origin, "/"),
+		notifier: notifier,
+	}
+}
+
+// slackDestination identifies where a message is posted: a channel through
+// the bot token, or the webhook's channel if channel is empty.
+type slackDestination struct {
+	organizationID uuid.UUID
+	channel        string
+}
+
+// Work posts the queued messages that are due. A message that fails is
+// retried by later runs until it runs out of attempts, when the organization
+// is told.
+func (w *DeliverSlackMessagesWorker) Work(ctx context.Context, _ *river.Job[DeliverSlackMessagesArgs]) error {
+	deliveries, err := w.repo.ClaimMessages(ctx, slackBatch, slackRetryAfter, slackMaxAttempts)
+	if err != nil {
+		return fmt.Errorf("delivering slack messages: %w", err)
+	}
+
+	// A destination that fails once is skipped for the rest of the run, so an
+	// outage costs one request and is reported once rather than per message.
+	var (
+		failed   = make(map[slackDestination]error)
+		reported = make(map[slackDestination]bool)
+		sent     int
+	)
+	for _, d := range deliveries {
+		dest := slackDestination{organizationID: d.OrganizationID, channel: d.Channel}
+		postErr, skipped := failed[dest]
+		if !skipped {
+			postErr = w.post(ctx, d)
+		}
+		if postErr == nil {
+			if err := w.repo.MarkMessageSent(ctx, d.ID); err != nil {
+				return fmt.Errorf("delivering slack messages: %w", err)
+			}
+			sent++
+			continue
+		}
+
+		if err := w.repo.MarkMessageFailed(ctx, d.ID, postErr.Error()); err != nil {
+			return fmt.Errorf("delivering slack messages: %w", err)
+		}
+		if !skipped {
+			failed[dest] = postErr
+			slog.ErrorContext(ctx, "failed to post slack message", "error", postErr, "organization_id", d.OrganizationID, "channel", d.Channel, "attempt", d.Attempts)
+		}
+		if d.Attempts >= slackMaxAttempts && !reported[dest] {
+			reported[dest] = true
+			w.notifySlackFailed(ctx, d, postErr)
+		}
+	}
+
+	if sent > 0 {
+		slog.InfoContext(ctx, "posted slack messages", "messages", sent)
+	}
+	return nil
+}
+
+func (w *DeliverSlackMessagesWorker) post(ctx context.Context, d orgServices.SlackDelivery) error {
+	msg := orgServices.SlackMessage{Event: d.Event, Title: d.Title, Body: d.Body}
+	if d.Link != nil && w.origin != "" {
+		msg.URL = w.origin + *d.Link
+	}
+	text, err := orgServices.RenderSlackTemplate(d.Template, msg)
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case d.Channel != "":
+		if d.BotToken == "" {
+			return fmt.Errorf("posting to %s: no bot token saved", d.Channel)
+		}
+		return w.slack.PostMessage(ctx, d.BotToken, d.Channel, text)
+	case d.WebhookURL != nil:
+		return w.slack.PostWebhook(ctx, *d.WebhookURL, text)
+	default:
+		return errors.New("posting slack message: no webhook saved")
+	}
+}
+
+// notifySlackFailed tells the organization's members that a Slack message
+// was given up on. It only logs its own errors.
+func (w *DeliverSlackMessagesWorker) notifySlackFailed(ctx context.Context, d orgServices.SlackDelivery, postErr error) {
+	if w.notifier == nil {
+		return
+	}
+
+	title := "Slack webhook failed"
+	if d.Channel != "" {
+		title = "Slack message to " + d.Channel + " failed"
+	}
+	_, err := w.notifier.NotifyOrganization(ctx, d.OrganizationID, notificationServices.Notification{
+		Kind:  notificationServices.KindWebhookFailed,
+		Title: title,
+		Body:  postErr.Error(),
+	})
+	if err != nil {
+		slog.ErrorContext(ctx, "failed to notify about slack failure", "error", err, "organization_id", d.OrganizationID)
+	}
+}
@@ -0,0 +1,114 @@
+package background
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/ThreeDotsLabs/watermill/message"
+	"github.com/riverqueue/river"
+
+	"github.com/cavenine/queryops/features/osquery/services"
+	"github.com/cavenine/queryops/internal/pubsub"
+)
+
+// ExpireStaleTargetsArgs fails campaign targets whose host picked up the query
+// but never returned results, so campaigns don't stay running forever, and
+// the outstanding targets of campaigns past their own timeout.
+type ExpireStaleTargetsArgs struct{}
+
+func (ExpireStaleTargetsArgs) Kind() string {
+	return "expire_stale_campaign_targets"
+}
+
+func (ExpireStaleTargetsArgs) InsertOpts() river.InsertOpts {
+	return river.InsertOpts{Queue: QueueMaintenance}
+}
+
+func init() {
+	Periodic.Register(PeriodicJob{
+		Name:       "expire_stale_campaign_targets",
+		Schedule:   "* * * * *",
+		Args:       func() river.JobArgs { return ExpireStaleTargetsArgs{} },
+		Jitter:     10 * time.Second,
+		RunOnStart: true,
+	})
+}
+
+type staleTargetRepository interface {
+	FailStaleSentTargets(ctx context.Context, sentBefore time.Time) ([]services.ExpiredTarget, error)
+	FailTimedOutCampaignTargets(ctx context.Context) ([]services.ExpiredTarget, error)
+}
+
+type ExpireStaleTargetsWorker struct {
+	river.WorkerDefaults[ExpireStaleTargetsArgs]
+
+	repo      staleTargetRepository
+	publisher message.Publisher // nil disables event publishing
+	timeout   time.Duration
+}
+
+// NewExpireStaleTargetsWorker creates a worker that fails targets sent more
+// than timeout ago.
+func NewExpireStaleTargetsWorker(repo staleTargetRepository, publisher message.Publisher, timeout time.Duration) *ExpireStaleTargetsWorker {
+	return &ExpireStaleTargetsWorker{
+		repo:      repo,
+		publisher: publisher,
+		timeout:   timeout,
+	}
+}
+
+func (w *ExpireStaleTargetsWorker) Work(ctx context.Context, _ *river.Job[ExpireStaleTargetsArgs]) error {
+	// Campaign timeouts first: their targets then carry the campaign's
+	// error rather than the generic one.
+	timedOut, err := w.repo.FailTimedOutCampaignTargets(ctx)
+	if err != nil {
+		return fmt.Errorf("expiring timed out campaign targets: %w", err)
+	}
+	if len(timedOut) > 0 {
+		slog.InfoContext(ctx, "expired campaign targets past their campaign's timeout", "count", len(timedOut))
+	}
+
+	stale, err := w.repo.FailStaleSentTargets(ctx, time.Now().Add(-w.timeout))
+	if err != nil {
+		return fmt.Errorf("expiring stale campaign targets: %w", err)
+	}
+	if len(stale) > 0 {
+		slog.InfoContext(ctx, "expired stale campaign targets", "count", len(stale), "timeout", w.timeout)
+	}
+
+	if w.publisher == nil {
+		return nil
+	}
+
+	now := time.Now().UTC()
+	for _, t := range append(timedOut, stale...) {
+		errText := t.Error
+		// Open campaign and host detail pages refresh on these events.
+		campaignEvent := pubsub.CampaignResultEvent{
+			CampaignID:     t.CampaignID,
+			HostID:         t.HostID,
+			HostIdentifier: t.HostIdentifier,
+			Status:         pubsub.QueryResultStatusFailed,
+			OccurredAt:     now,
+			Error:          &errText,
+		}
+		if err := w.publisher.Publish(pubsub.TopicCampaign(t.CampaignID), campaignEvent.ToMessage()); err != nil {
+			slog.ErrorContext(ctx, "failed to publish campaign result event", "error", err, "campaign_id", t.CampaignID, "host_id", t.HostID)
+		}
+
+		resultEvent := pubsub.QueryResultEvent{
+			HostID:     t.HostID,
+			QueryID:    t.CampaignID,
+			Status:     pubsub.QueryResultStatusFailed,
+			OccurredAt: now,
+			Error:      &errText,
+		}
+		if err := w.publisher.Publish(pubsub.TopicQueryResults(t.HostID), resultEvent.ToMessage()); err != nil {
+			slog.ErrorContext(ctx, "failed to publish query result event", "error", err, "campaign_id", t.CampaignID, "host_id", t.HostID)
+		}
+	}
+
+	return nil
+}
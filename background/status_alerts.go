@@ -0,0 +1,108 @@
+package background
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/riverqueue/river"
+
+	notificationServices "github.com/cavenine/queryops/features/notification/services"
+	"github.com/cavenine/queryops/internal/notify"
+)
+
+const (
+	// statusAlertCooldown is how long a rule stays quiet about a host after
+	// alerting, so a crash-looping agent alerts hourly rather than every
+	// minute.
+	statusAlertCooldown = time.Hour
+	// statusAlertBatch caps the status log lines one sweep reads; the rest
+	// are read by the next run.
+	statusAlertBatch = 10000
+)
+
+// EvaluateStatusAlertsArgs checks newly saved osquery status logs against
+// organizations' alert rules.
+type EvaluateStatusAlertsArgs struct{}
+
+func (EvaluateStatusAlertsArgs) Kind() string {
+	return "evaluate_status_alerts"
+}
+
+func (EvaluateStatusAlertsArgs) InsertOpts() river.InsertOpts {
+	return river.InsertOpts{Queue: QueueNotifications}
+}
+
+func init() {
+	Periodic.Register(PeriodicJob{
+		Name:       "evaluate_status_alerts",
+		Schedule:   "* * * * *",
+		Args:       func() river.JobArgs { return EvaluateStatusAlertsArgs{} },
+		Jitter:     10 * time.Second,
+		RunOnStart: true,
+	})
+}
+
+type statusAlertNotifier interface {
+	NotifyStatusAlerts(ctx context.Context, cooldown time.Duration, limit int) ([]notificationServices.StatusAlert, error)
+}
+
+type EvaluateStatusAlertsWorker struct {
+	river.WorkerDefaults[EvaluateStatusAlertsArgs]
+
+	repo     statusAlertNotifier
+	webhook  *notify.Webhook
+	notifier organizationNotifier // nil disables failure notifications
+}
+
+func NewEvaluateStatusAlertsWorker(repo statusAlertNotifier, webhook *notify.Webhook, notifier organizationNotifier) *EvaluateStatusAlertsWorker {
+	return &EvaluateStatusAlertsWorker{
+		repo:     repo,
+		webhook:  webhook,
+		notifier: notifier,
+	}
+}
+
+// Work notifies about matching status logs and posts each alert to its
+// rule's webhook. A webhook that fails is reported to the organization but
+// not retried: the sweep has already moved past those logs.
+func (w *EvaluateStatusAlertsWorker) Work(ctx context.Context, _ *river.Job[EvaluateStatusAlertsArgs]) error {
+	alerts, err := w.repo.NotifyStatusAlerts(ctx, statusAlertCooldown, statusAlertBatch)
+	if err != nil {
+		return fmt.Errorf("evaluating status alerts: %w", err)
+	}
+	if len(alerts) > 0 {
+		slog.InfoContext(ctx, "generated status alerts", "alerts", len(alerts))
+	}
+
+	// A webhook that fails once is skipped for the rest of the run, so an
+	// outage is reported once rather than per alert.
+	failed := make(map[string]bool)
+	for _, a := range alerts {
+		if a.WebhookURL == nil || failed[*a.WebhookURL] {
+			continue
+		}
+		if err := w.webhook.PostJSON(ctx, *a.WebhookURL, a); err != nil {
+			failed[*a.WebhookURL] = true
+			slog.ErrorContext(ctx, "failed to post status alert", "error", err, "organization_id", a.OrganizationID, "rule_id", a.RuleID)
+			w.notifyWebhookFailed(ctx, a, err)
+		}
+	}
+	return nil
+}
+
+func (w *EvaluateStatusAlertsWorker) notifyWebhookFailed(ctx context.Context, a notificationServices.StatusAlert, webhookErr error) {
+	if w.notifier == nil {
+		return
+	}
+
+	_, err := w.notifier.NotifyOrganization(ctx, a.OrganizationID, notificationServices.Notification{
+		Kind:  notificationServices.KindWebhookFailed,
+		Title: "Status alert webhook failed",
+		Body:  webhookErr.Error(),
+	})
+	if err != nil {
+		slog.ErrorContext(ctx, "failed to notify about webhook failure", "error", err, "organization_id", a.OrganizationID)
+	}
+}
@@ -0,0 +1,198 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"maps"
+	"slices"
+	"text/tabwriter"
+
+	"github.com/ThreeDotsLabs/watermill/message"
+	"github.com/google/uuid"
+
+	"github.com/cavenine/queryops/config"
+	"github.com/cavenine/queryops/db"
+	adminServices "github.com/cavenine/queryops/features/admin/services"
+	"github.com/cavenine/queryops/internal/featureflags"
+	"github.com/cavenine/queryops/internal/pubsub"
+
+	"github.com/spf13/cobra"
+)
+
+func NewAdminCommand() *cobra.Command {
+	root := &cobra.Command{
+		Use:   "admin",
+		Short: "Manage access to the /admin console and feature flags",
+	}
+
+	root.AddCommand(
+		newSetSuperuserCmd("grant-superuser", "Let a user reach the /admin console", true),
+		newSetSuperuserCmd("revoke-superuser", "Remove a user's access to the /admin console", false),
+		newFlagsCmd(),
+	)
+
+	return root
+}
+
+func newSetSuperuserCmd(use, short string, superuser bool) *cobra.Command {
+	return &cobra.Command{
+		Use:   use + " <email>",
+		Short: short,
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			ctx := cmd.Context()
+
+			if config.Global.DatabaseURL == "" {
+				return errors.New("DATABASE_URL must be set")
+			}
+
+			pool, err := db.NewPool(ctx, config.Global, nil)
+			if err != nil {
+				return fmt.Errorf("creating database pool: %w", err)
+			}
+			defer pool.Close()
+
+			if err := adminServices.NewAdminRepository(pool).SetSuperuser(ctx, args[0], superuser); err != nil {
+				return err
+			}
+			fmt.Fprintf(cmd.OutOrStdout(), "%s: superuser=%t\n", args[0], superuser)
+			return nil
+		},
+	}
+}
+
+func newFlagsCmd() *cobra.Command {
+	root := &cobra.Command{
+		Use:   "flags",
+		Short: "List and set feature flags",
+	}
+
+	root.AddCommand(
+		&cobra.Command{
+			Use:   "list",
+			Short: "Show each flag's default and organization overrides",
+			Args:  cobra.NoArgs,
+			RunE: func(cmd *cobra.Command, _ []string) error {
+				return withFeatureFlags(cmd.Context(), func(store *featureflags.Store, _ *featureflags.Service) error {
+					snap, err := store.Load(cmd.Context())
+					if err != nil {
+						return err
+					}
+					w := tabwriter.NewWriter(cmd.OutOrStdout(), 0, 0, 2, ' ', 0)
+					fmt.Fprintln(w, "FLAG\tORGANIZATION\tENABLED\tDESCRIPTION")
+					orgIDs := slices.SortedFunc(maps.Keys(snap.Overrides), func(a, b uuid.UUID) int {
+						return bytes.Compare(a[:], b[:])
+					})
+					for _, flag := range featureflags.Names() {
+						fmt.Fprintf(w, "%s\t(default)\t%t\t%s\n", flag, snap.Defaults[flag], featureflags.Known[flag])
+						for _, orgID := range orgIDs {
+							if enabled, ok := snap.Overrides[orgID][flag]; ok {
+								fmt.Fprintf(w, "%s\t%s\t%t\t\n", flag, orgID, enabled)
+							}
+						}
+					}
+					return w.Flush()
+				})
+			},
+		},
+		newSetFlagCmd("enable", "Turn a flag on by default, or for one organization", true),
+		newSetFlagCmd("disable", "Turn a flag off by default, or for one organization", false),
+		newResetFlagCmd(),
+	)
+
+	return root
+}
+
+func newSetFlagCmd(use, short string, enabled bool) *cobra.Command {
+	var orgID string
+	cmd := &cobra.Command{
+		Use:   use + " <flag>",
+		Short: short,
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			flag := args[0]
+			return withFeatureFlags(cmd.Context(), func(_ *featureflags.Store, flags *featureflags.Service) error {
+				if orgID == "" {
+					if err := flags.SetDefault(cmd.Context(), flag, enabled); err != nil {
+						return err
+					}
+					fmt.Fprintf(cmd.OutOrStdout(), "%s: enabled=%t by default\n", flag, enabled)
+					return nil
+				}
+				id, err := uuid.Parse(orgID)
+				if err != nil {
+					return fmt.Errorf("--org: %w", err)
+				}
+				if err := flags.SetOverride(cmd.Context(), flag, id, enabled); err != nil {
+					return err
+				}
+				fmt.Fprintf(cmd.OutOrStdout(), "%s: enabled=%t for %s\n", flag, enabled, id)
+				return nil
+			})
+		},
+	}
+	cmd.Flags().StringVar(&orgID, "org", "", "organization ID to override the flag for")
+	return cmd
+}
+
+func newResetFlagCmd() *cobra.Command {
+	var orgID string
+	cmd := &cobra.Command{
+		Use:   "reset <flag>",
+		Short: "Return an organization to a flag's default",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			id, err := uuid.Parse(orgID)
+			if err != nil {
+				return fmt.Errorf("--org: %w", err)
+			}
+			return withFeatureFlags(cmd.Context(), func(_ *featureflags.Store, flags *featureflags.Service) error {
+				if err := flags.ClearOverride(cmd.Context(), args[0], id); err != nil {
+					return err
+				}
+				fmt.Fprintf(cmd.OutOrStdout(), "%s: default for %s\n", args[0], id)
+				return nil
+			})
+		},
+	}
+	cmd.Flags().StringVar(&orgID, "org", "", "organization ID to reset")
+	_ = cmd.MarkFlagRequired("org")
+	return cmd
+}
+
+// withFeatureFlags opens the database and, when NATS_URL is set, pubsub, so
+// running web instances pick up changes at once rather than by TTL.
+func withFeatureFlags(ctx context.Context, fn func(*featureflags.Store, *featureflags.Service) error) error {
+	if config.Global.DatabaseURL == "" {
+		return errors.New("DATABASE_URL must be set")
+	}
+
+	pool, err := db.NewPool(ctx, config.Global, nil)
+	if err != nil {
+		return fmt.Errorf("creating database pool: %w", err)
+	}
+	defer pool.Close()
+
+	// An embedded NATS server would be private to this process, so only
+	// publish changes when an external server is configured.
+	var publisher message.Publisher
+	if config.Global.PubSubEnabled && config.Global.NATSUrl != "" {
+		ps, err := pubsub.New(ctx, &pubsub.Config{NATSUrl: config.Global.NATSUrl})
+		if err != nil {
+			slog.WarnContext(ctx, "pubsub initialization failed; web instances pick up flag changes by TTL", "error", err)
+		} else {
+			defer func() {
+				if closeErr := ps.Close(); closeErr != nil {
+					slog.WarnContext(ctx, "error closing pubsub", "error", closeErr)
+				}
+			}()
+			publisher = ps.Publisher()
+		}
+	}
+
+	store := featureflags.NewStore(pool)
+	return fn(store, featureflags.NewService(store, publisher))
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"text/tabwriter"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/cavenine/queryops/config"
+	"github.com/cavenine/queryops/db"
+	orgServices "github.com/cavenine/queryops/features/organization/services"
+
+	"github.com/spf13/cobra"
+)
+
+func NewAPITokensCommand() *cobra.Command {
+	root := &cobra.Command{
+		Use:   "api-tokens",
+		Short: "Manage organizations' gRPC API tokens",
+		Long: `gRPC API clients send a token as "authorization: Bearer <token>", and are
+scoped to the token's organization. A token's plaintext is printed once, when
+it's created; only its hash is stored.`,
+	}
+
+	root.AddCommand(newAPITokensCreateCmd(), newAPITokensListCmd(), newAPITokensRevokeCmd())
+
+	return root
+}
+
+func newAPITokensCreateCmd() *cobra.Command {
+	var orgID, name string
+	cmd := &cobra.Command{
+		Use:   "create",
+		Short: "Create a token and print it",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			id, err := uuid.Parse(orgID)
+			if err != nil {
+				return fmt.Errorf("--org: %w", err)
+			}
+			return withAPITokenRepository(cmd.Context(), func(repo *orgServices.APITokenRepository) error {
+				created, token, err := repo.Create(cmd.Context(), id, name, nil)
+				if err != nil {
+					return err
+				}
+				fmt.Fprintf(cmd.ErrOrStderr(), "created token %d (%s); it won't be shown again\n", created.ID, created.Name)
+				fmt.Fprintln(cmd.OutOrStdout(), token)
+				return nil
+			})
+		},
+	}
+	cmd.Flags().StringVar(&orgID, "org", "", "organization ID")
+	cmd.Flags().StringVar(&name, "name", "", "what the token is for")
+	_ = cmd.MarkFlagRequired("org")
+	_ = cmd.MarkFlagRequired("name")
+	return cmd
+}
+
+func newAPITokensListCmd() *cobra.Command {
+	var orgID string
+	cmd := &cobra.Command{
+		Use:   "list",
+		Short: "List an organization's tokens",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			id, err := uuid.Parse(orgID)
+			if err != nil {
+				return fmt.Errorf("--org: %w", err)
+			}
+			return withAPITokenRepository(cmd.Context(), func(repo *orgServices.APITokenRepository) error {
+				tokens, err := repo.List(cmd.Context(), id)
+				if err != nil {
+					return err
+				}
+				w := tabwriter.NewWriter(cmd.OutOrStdout(), 0, 0, 2, ' ', 0)
+				fmt.Fprintln(w, "ID\tNAME\tCREATED\tLAST USED\tREVOKED")
+				for _, t := range tokens {
+					fmt.Fprintf(w, "%d\t%s\t%s\t%s\t%s\n", t.ID, t.Name, t.CreatedAt.Format(time.RFC3339), formatOptionalTime(t.LastUsedAt), formatOptionalTime(t.RevokedAt))
+				}
+				return w.Flush()
+			})
+		},
+	}
+	cmd.Flags().StringVar(&orgID, "org", "", "organization ID")
+	_ = cmd.MarkFlagRequired("org")
+	return cmd
+}
+
+func newAPITokensRevokeCmd() *cobra.Command {
+	var orgID string
+	cmd := &cobra.Command{
+		Use:   "revoke <token-id>",
+		Short: "Stop a token working",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			id, err := uuid.Parse(orgID)
+			if err != nil {
+				return fmt.Errorf("--org: %w", err)
+			}
+			tokenID, err := strconv.ParseInt(args[0], 10, 64)
+			if err != nil {
+				return fmt.Errorf("token ID: %w", err)
+			}
+			return withAPITokenRepository(cmd.Context(), func(repo *orgServices.APITokenRepository) error {
+				if err := repo.Revoke(cmd.Context(), id, tokenID); err != nil {
+					return err
+				}
+				fmt.Fprintf(cmd.OutOrStdout(), "%d: revoked\n", tokenID)
+				return nil
+			})
+		},
+	}
+	cmd.Flags().StringVar(&orgID, "org", "", "organization ID")
+	_ = cmd.MarkFlagRequired("org")
+	return cmd
+}
+
+func formatOptionalTime(t *time.Time) string {
+	if t == nil {
+		return "-"
+	}
+	return t.Format(time.RFC3339)
+}
+
+func withAPITokenRepository(ctx context.Context, fn func(*orgServices.APITokenRepository) error) error {
+	if config.Global.DatabaseURL == "" {
+		return errors.New("DATABASE_URL must be set")
+	}
+
+	pool, err := db.NewPool(ctx, config.Global, nil)
+	if err != nil {
+		return fmt.Errorf("creating database pool: %w", err)
+	}
+	defer pool.Close()
+
+	return fn(orgServices.NewAPITokenRepository(pool))
+}
@@ -5,11 +5,14 @@ import (
 	"errors"
 	"fmt"
 	"log/slog"
+	"time"
 
 	"github.com/cavenine/queryops/background"
 	"github.com/cavenine/queryops/config"
 	"github.com/cavenine/queryops/db"
+	"github.com/cavenine/queryops/internal/pubsub"
 
+	"github.com/ThreeDotsLabs/watermill/message"
 	"github.com/spf13/cobra"
 )
 
@@ -24,15 +27,36 @@ func NewWorkerCommand() *cobra.Command {
 				return errors.New("DATABASE_URL must be set")
 			}
 
-			pool, err := db.NewPool(ctx, config.Global)
+			pool, err := db.NewPool(ctx, config.Global, nil)
 			if err != nil {
 				return fmt.Errorf("creating database pool: %w", err)
 			}
 			defer pool.Close()
 
+			// An embedded NATS server would be private to this process, so only
+			// publish events when an external server is configured.
+			var publisher message.Publisher
+			if config.Global.PubSubEnabled && config.Global.NATSUrl != "" {
+				ps, err := pubsub.New(ctx, &pubsub.Config{NATSUrl: config.Global.NATSUrl})
+				if err != nil {
+					slog.WarnContext(ctx, "pubsub initialization failed; jobs will not publish events", "error", err)
+				} else {
+					defer func() {
+						if closeErr := ps.Close(); closeErr != nil {
+							slog.WarnContext(ctx, "error closing pubsub", "error", closeErr)
+						}
+					}()
+					publisher = ps.Publisher()
+				}
+			}
+
+			go config.ReloadOnSIGHUP(ctx)
+
 			clientCfg := background.DefaultClientConfig()
+			clientCfg.AdminAddr = config.Global.WorkerAdminAddr
+			clientCfg.StatsInterval = time.Duration(config.Global.WorkerStatsIntervalMs) * time.Millisecond
 			slog.InfoContext(ctx, "starting dedicated river worker process")
-			if err := background.RunWorker(ctx, pool, clientCfg); err != nil && !errors.Is(err, context.Canceled) {
+			if err := background.RunWorker(ctx, pool, publisher, clientCfg); err != nil && !errors.Is(err, context.Canceled) {
 				return err
 			}
 
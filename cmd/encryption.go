@@ -0,0 +1,138 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/cavenine/queryops/config"
+	"github.com/cavenine/queryops/db"
+	orgServices "github.com/cavenine/queryops/features/organization/services"
+	"github.com/cavenine/queryops/internal/crypto"
+
+	"github.com/spf13/cobra"
+)
+
+func NewEncryptionCommand() *cobra.Command {
+	root := &cobra.Command{
+		Use:   "encryption",
+		Short: "Manage encryption of sensitive columns",
+	}
+
+	root.AddCommand(
+		newGenerateKeyCmd(),
+		newRotateCmd(),
+	)
+
+	return root
+}
+
+func newGenerateKeyCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:   "generate-key <id>",
+		Short: "Print a new key to prepend to ENCRYPTION_KEYS",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			key, err := crypto.GenerateKey(args[0])
+			if err != nil {
+				return err
+			}
+			fmt.Fprintln(cmd.OutOrStdout(), key)
+			return nil
+		},
+	}
+}
+
+func newRotateCmd() *cobra.Command {
+	var (
+		batchSize int
+		pause     time.Duration
+	)
+
+	cmd := &cobra.Command{
+		Use:   "rotate",
+		Short: "Re-encrypt rows under the primary key in ENCRYPTION_KEYS",
+		Long: `Re-encrypts, in batches, every encrypted value stored under a key other
+than the first in ENCRYPTION_KEYS, and encrypts values still stored in
+plaintext. Once it finishes, older keys can be removed from ENCRYPTION_KEYS.
+It is safe to interrupt and rerun, and to run while the server is up.`,
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			ctx := cmd.Context()
+
+			if config.Global.DatabaseURL == "" {
+				return errors.New("DATABASE_URL must be set")
+			}
+			if batchSize <= 0 {
+				return errors.New("--batch-size must be positive")
+			}
+			keys, err := crypto.ParseKeyring(config.Global.EncryptionKeys)
+			if err != nil {
+				return fmt.Errorf("loading ENCRYPTION_KEYS: %w", err)
+			}
+
+			pool, err := db.NewPool(ctx, config.Global, nil)
+			if err != nil {
+				return fmt.Errorf("creating database pool: %w", err)
+			}
+			defer pool.Close()
+
+			orgs := orgServices.NewOrganizationRepository(pool, keys)
+			packages := orgServices.NewEnrollmentPackageRepository(pool, keys, nil)
+			secrets, err := rotateBatches(ctx, "enroll secrets", batchSize, pause, orgs.RotateEnrollSecrets)
+			if err != nil {
+				return err
+			}
+			pkgs, err := rotateBatches(ctx, "enrollment packages", batchSize, pause, packages.RotatePackages)
+			if err != nil {
+				return err
+			}
+			tokens, err := rotateBatches(ctx, "slack bot tokens", batchSize, pause, orgServices.NewSlackRepository(pool, keys).RotateBotTokens)
+			if err != nil {
+				return err
+			}
+			alertKeys, err := rotateBatches(ctx, "alert destination keys", batchSize, pause, orgServices.NewAlertDestinationRepository(pool, keys).RotateKeys)
+			if err != nil {
+				return err
+			}
+
+			slog.InfoContext(ctx, "encryption rotation complete",
+				"primary_key", keys.PrimaryID(),
+				"enroll_secrets", secrets,
+				"enrollment_packages", pkgs,
+				"slack_bot_tokens", tokens,
+				"alert_destination_keys", alertKeys,
+			)
+			return nil
+		},
+	}
+
+	cmd.Flags().IntVar(&batchSize, "batch-size", 500, "rows re-encrypted per transaction")
+	cmd.Flags().DurationVar(&pause, "pause", 100*time.Millisecond, "pause between batches")
+
+	return cmd
+}
+
+// rotateBatches calls rotate until it re-encrypts nothing, pausing between
+// batches, and returns the total re-encrypted.
+func rotateBatches(ctx context.Context, what string, batchSize int, pause time.Duration, rotate func(context.Context, int) (int, error)) (int, error) {
+	total := 0
+	for {
+		n, err := rotate(ctx, batchSize)
+		if err != nil {
+			return total, fmt.Errorf("rotating %s: %w", what, err)
+		}
+		if n == 0 {
+			return total, nil
+		}
+		total += n
+		slog.InfoContext(ctx, "re-encrypted "+what, "batch", n, "total", total)
+
+		select {
+		case <-ctx.Done():
+			return total, ctx.Err()
+		case <-time.After(pause):
+		}
+	}
+}
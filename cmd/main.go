@@ -17,8 +17,12 @@ func main() {
 	ctx, cancel := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
 	defer cancel()
 
+	level := new(slog.LevelVar)
+	level.Set(config.Global.LogLevel)
+	config.OnReload(func(c *config.Config) { level.Set(c.LogLevel) })
+
 	logger := slog.New(slog.NewJSONHandler(os.Stdout, &slog.HandlerOptions{
-		Level: config.Global.LogLevel,
+		Level: level,
 	}))
 	slog.SetDefault(logger)
 
@@ -31,6 +35,14 @@ func main() {
 		web.NewWebCommand(),
 		NewMigrationCommand(),
 		NewWorkerCommand(),
+		NewSimulateCommand(),
+		NewEncryptionCommand(),
+		NewAdminCommand(),
+		NewLogsCommand(),
+		NewPacksCommand(),
+		NewRequestSigningCommand(),
+		NewPubSubCommand(),
+		NewAPITokensCommand(),
 	)
 
 	if err := root.ExecuteContext(ctx); err != nil {
@@ -4,7 +4,11 @@ import (
 	"errors"
 	"fmt"
 	"log/slog"
+	"os"
+	"os/exec"
 	"strconv"
+	"strings"
+	"time"
 
 	"github.com/cavenine/queryops/background"
 	"github.com/cavenine/queryops/config"
@@ -31,6 +35,9 @@ func NewMigrationCommand() *cobra.Command {
 		newVersionCmd(),
 		newForceCmd(),
 		newToCmd(),
+		newCreateCmd(),
+		newStatusCmd(),
+		newPlanCmd(),
 	)
 
 	return root
@@ -118,3 +125,117 @@ func newToCmd() *cobra.Command {
 		},
 	}
 }
+
+func newCreateCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "create [name]",
+		Short: "Create timestamped up/down migration files",
+		Args:  cobra.ExactArgs(1),
+		// Authoring doesn't touch the database.
+		PersistentPreRunE: func(_ *cobra.Command, _ []string) error { return nil },
+		RunE: func(cmd *cobra.Command, args []string) error {
+			dir, _ := cmd.Flags().GetString("dir")
+			edit, _ := cmd.Flags().GetBool("edit")
+
+			up, down, err := migrations.Create(dir, args[0], time.Now())
+			if err != nil {
+				return err
+			}
+			fmt.Fprintln(cmd.OutOrStdout(), up)
+			fmt.Fprintln(cmd.OutOrStdout(), down)
+
+			if !edit {
+				return nil
+			}
+			editor := os.Getenv("EDITOR")
+			if editor == "" {
+				return errors.New("--edit requires $EDITOR to be set")
+			}
+			c := exec.CommandContext(cmd.Context(), editor, up, down)
+			c.Stdin, c.Stdout, c.Stderr = os.Stdin, os.Stdout, os.Stderr
+			if err := c.Run(); err != nil {
+				return fmt.Errorf("running $EDITOR: %w", err)
+			}
+			return nil
+		},
+	}
+
+	cmd.Flags().String("dir", "migrations/sql", "directory containing migration files")
+	cmd.Flags().Bool("edit", false, "open the new files in $EDITOR")
+	return cmd
+}
+
+func newStatusCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:   "status",
+		Short: "List embedded migrations and whether each is applied",
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			migs, err := migrations.Embedded()
+			if err != nil {
+				return err
+			}
+			current, dirty, err := migrations.Version(config.Global.DatabaseURL)
+			if err != nil {
+				return err
+			}
+
+			// golang-migrate records only the latest applied version; everything
+			// at or below it has been applied.
+			out := cmd.OutOrStdout()
+			for _, m := range migs {
+				state := "pending"
+				switch {
+				case uint64(current) == m.Version && dirty:
+					state = "dirty"
+				case m.Version <= uint64(current):
+					state = "applied"
+				}
+				fmt.Fprintf(out, "%-8s %d %s\n", state, m.Version, m.Name)
+			}
+			if dirty {
+				fmt.Fprintf(out, "\nversion %d is dirty: fix the schema, then run `migrate force`\n", current)
+			}
+			return nil
+		},
+	}
+}
+
+func newPlanCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:   "plan",
+		Short: "Print the SQL that `migrate up` would apply, without running it",
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			migs, err := migrations.Embedded()
+			if err != nil {
+				return err
+			}
+			current, dirty, err := migrations.Version(config.Global.DatabaseURL)
+			if err != nil {
+				return err
+			}
+			if dirty {
+				return fmt.Errorf("version %d is dirty; resolve it before planning", current)
+			}
+
+			out := cmd.OutOrStdout()
+			pending := 0
+			for _, m := range migs {
+				if m.Version <= uint64(current) {
+					continue
+				}
+				pending++
+				upSQL, err := migrations.UpSQL(m)
+				if err != nil {
+					return err
+				}
+				fmt.Fprintf(out, "-- %s\n%s\n", m.Up, strings.TrimSpace(upSQL))
+				fmt.Fprintln(out)
+			}
+			if pending == 0 {
+				fmt.Fprintln(out, "-- no pending migrations")
+			}
+			// River's own migrations also run with `up`; they are not listed here.
+			return nil
+		},
+	}
+}
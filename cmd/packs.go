@@ -0,0 +1,118 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/cavenine/queryops/config"
+	"github.com/cavenine/queryops/db"
+	"github.com/cavenine/queryops/features/osquery"
+	"github.com/cavenine/queryops/features/osquery/services"
+
+	"github.com/spf13/cobra"
+)
+
+func NewPacksCommand() *cobra.Command {
+	root := &cobra.Command{
+		Use:   "packs",
+		Short: "Export and import the scheduled queries and packs of osquery configs",
+	}
+
+	root.AddCommand(newPacksExportCmd(), newPacksImportCmd())
+
+	return root
+}
+
+func newPacksExportCmd() *cobra.Command {
+	var configName, out string
+
+	cmd := &cobra.Command{
+		Use:   "export",
+		Short: "Write a config's scheduled queries and packs as YAML",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			return withHostRepository(cmd.Context(), func(repo *services.HostRepository) error {
+				cfg, err := repo.GetConfig(cmd.Context(), configName)
+				if err != nil {
+					return err
+				}
+				data, err := osquery.ExportPackFile(cfg)
+				if err != nil {
+					return err
+				}
+				if out == "" || out == "-" {
+					_, err = cmd.OutOrStdout().Write(data)
+					return err
+				}
+				return os.WriteFile(out, data, 0o644)
+			})
+		},
+	}
+
+	cmd.Flags().StringVar(&configName, "config", "default", "name of the osquery config")
+	cmd.Flags().StringVarP(&out, "output", "o", "", "file to write, or - for stdout (the default)")
+
+	return cmd
+}
+
+func newPacksImportCmd() *cobra.Command {
+	var configName, packName string
+
+	cmd := &cobra.Command{
+		Use:   "import <file>",
+		Short: "Load a pack file, or a single osquery pack, into a config",
+		Long: `Reads a file written by "packs export", or a single osquery pack such as
+those osquery loads from its packs directory, in YAML or JSON, and loads it
+into --config, which is created if it doesn't exist.
+
+An exported file replaces the config's scheduled queries and packs. A single
+pack replaces only the pack of its name: --pack, or the file's name without
+its extension. The config's options and decorators are left alone. Hosts
+pick up the change on their next config refresh.`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			data, err := os.ReadFile(args[0])
+			if err != nil {
+				return err
+			}
+			if packName == "" {
+				packName = strings.TrimSuffix(filepath.Base(args[0]), filepath.Ext(args[0]))
+			}
+			f, err := osquery.ParsePackFile(data, packName)
+			if err != nil {
+				return err
+			}
+
+			return withHostRepository(cmd.Context(), func(repo *services.HostRepository) error {
+				if err := repo.UpdateConfig(cmd.Context(), configName, f.Apply); err != nil {
+					return err
+				}
+				fmt.Fprintf(cmd.OutOrStdout(), "%s: imported %s\n", configName, f.Summary())
+				return nil
+			})
+		},
+	}
+
+	cmd.Flags().StringVar(&configName, "config", "default", "name of the osquery config")
+	cmd.Flags().StringVar(&packName, "pack", "", "name for a single pack (default: the file's name)")
+
+	return cmd
+}
+
+func withHostRepository(ctx context.Context, fn func(*services.HostRepository) error) error {
+	if config.Global.DatabaseURL == "" {
+		return errors.New("DATABASE_URL must be set")
+	}
+
+	pool, err := db.NewPool(ctx, config.Global, nil)
+	if err != nil {
+		return fmt.Errorf("creating database pool: %w", err)
+	}
+	defer pool.Close()
+
+	return fn(services.NewHostRepository(pool))
+}
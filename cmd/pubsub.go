@@ -0,0 +1,166 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"text/tabwriter"
+	"time"
+
+	"github.com/cavenine/queryops/config"
+	"github.com/cavenine/queryops/db"
+	"github.com/cavenine/queryops/internal/outbox"
+
+	"github.com/spf13/cobra"
+)
+
+func NewPubSubCommand() *cobra.Command {
+	root := &cobra.Command{
+		Use:   "pubsub",
+		Short: "Inspect pub/sub consumers",
+	}
+
+	deadLetters := &cobra.Command{
+		Use:   "dead-letters",
+		Short: "Inspect, requeue, or discard messages consumers gave up on",
+		Long: `Consumers retry a message they fail to handle a few times, then move it to
+the dead letters instead of dropping it or blocking the messages behind it.
+Messages that can't be parsed are moved there at once.
+
+Requeueing writes a message back to the outbox on its topic, so every
+subscriber to the topic receives it again, not only the consumer that gave
+up on it.`,
+	}
+	deadLetters.AddCommand(newDeadLettersListCmd(), newDeadLettersShowCmd(), newDeadLettersRequeueCmd(), newDeadLettersDeleteCmd())
+	root.AddCommand(deadLetters)
+
+	return root
+}
+
+func newDeadLettersListCmd() *cobra.Command {
+	var (
+		consumer string
+		limit    int
+	)
+	cmd := &cobra.Command{
+		Use:   "list",
+		Short: "List dead letters, most recently failed first",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			return withDeadLetters(cmd.Context(), func(store *outbox.DeadLetters) error {
+				letters, err := store.List(cmd.Context(), consumer, limit)
+				if err != nil {
+					return err
+				}
+				w := tabwriter.NewWriter(cmd.OutOrStdout(), 0, 0, 2, ' ', 0)
+				fmt.Fprintln(w, "ID\tCONSUMER\tTOPIC\tATTEMPTS\tFAILED\tERROR")
+				for _, l := range letters {
+					fmt.Fprintf(w, "%d\t%s\t%s\t%d\t%s\t%s\n", l.ID, l.Consumer, l.Topic, l.Attempts, l.UpdatedAt.Format(time.RFC3339), l.Error)
+				}
+				return w.Flush()
+			})
+		},
+	}
+	cmd.Flags().StringVar(&consumer, "consumer", "", "only list this consumer's dead letters")
+	cmd.Flags().IntVar(&limit, "limit", 50, "maximum dead letters to list")
+	return cmd
+}
+
+func newDeadLettersShowCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:   "show <id>",
+		Short: "Show a dead letter's message",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			id, err := parseDeadLetterID(args[0])
+			if err != nil {
+				return err
+			}
+			return withDeadLetters(cmd.Context(), func(store *outbox.DeadLetters) error {
+				l, err := store.Get(cmd.Context(), id)
+				if err != nil {
+					return err
+				}
+				out := cmd.OutOrStdout()
+				fmt.Fprintf(out, "ID:         %d\n", l.ID)
+				fmt.Fprintf(out, "Consumer:   %s\n", l.Consumer)
+				fmt.Fprintf(out, "Topic:      %s\n", l.Topic)
+				fmt.Fprintf(out, "Message ID: %s\n", l.MessageID)
+				fmt.Fprintf(out, "Attempts:   %d\n", l.Attempts)
+				fmt.Fprintf(out, "First:      %s\n", l.CreatedAt.Format(time.RFC3339))
+				fmt.Fprintf(out, "Last:       %s\n", l.UpdatedAt.Format(time.RFC3339))
+				fmt.Fprintf(out, "Error:      %s\n", l.Error)
+				for k, v := range l.Metadata {
+					fmt.Fprintf(out, "Metadata:   %s=%s\n", k, v)
+				}
+				fmt.Fprintf(out, "\n%s\n", l.Payload)
+				return nil
+			})
+		},
+	}
+}
+
+func newDeadLettersRequeueCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:   "requeue <id>",
+		Short: "Publish a dead letter's message again and remove it",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			id, err := parseDeadLetterID(args[0])
+			if err != nil {
+				return err
+			}
+			return withDeadLetters(cmd.Context(), func(store *outbox.DeadLetters) error {
+				if err := store.Requeue(cmd.Context(), id); err != nil {
+					return err
+				}
+				fmt.Fprintf(cmd.OutOrStdout(), "%d: requeued\n", id)
+				return nil
+			})
+		},
+	}
+}
+
+func newDeadLettersDeleteCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:   "delete <id>",
+		Short: "Discard a dead letter",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			id, err := parseDeadLetterID(args[0])
+			if err != nil {
+				return err
+			}
+			return withDeadLetters(cmd.Context(), func(store *outbox.DeadLetters) error {
+				if err := store.Delete(cmd.Context(), id); err != nil {
+					return err
+				}
+				fmt.Fprintf(cmd.OutOrStdout(), "%d: deleted\n", id)
+				return nil
+			})
+		},
+	}
+}
+
+func parseDeadLetterID(s string) (int64, error) {
+	id, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("dead letter ID: %w", err)
+	}
+	return id, nil
+}
+
+func withDeadLetters(ctx context.Context, fn func(*outbox.DeadLetters) error) error {
+	if config.Global.DatabaseURL == "" {
+		return errors.New("DATABASE_URL must be set")
+	}
+
+	pool, err := db.NewPool(ctx, config.Global, nil)
+	if err != nil {
+		return fmt.Errorf("creating database pool: %w", err)
+	}
+	defer pool.Close()
+
+	return fn(outbox.NewDeadLetters(pool))
+}
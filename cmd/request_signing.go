@@ -0,0 +1,118 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/google/uuid"
+
+	"github.com/cavenine/queryops/features/osquery/services"
+
+	"github.com/spf13/cobra"
+)
+
+func NewRequestSigningCommand() *cobra.Command {
+	root := &cobra.Command{
+		Use:   "request-signing",
+		Short: "Roll out signing of osquery agent requests per organization",
+		Long: `Hosts are issued a signing key when they enroll, and agents that keep it
+sign their requests with it. Each organization's mode decides what happens to
+requests that aren't signed with the host's key:
+
+  off      signatures aren't checked (the default)
+  monitor  they're logged, and hosts that sign are counted in "status"
+  enforce  they're rejected, as are re-enrollments of hosts with a key
+
+Roll out by setting monitor, waiting for "status" to show every host signing,
+then setting enforce.`,
+	}
+
+	root.AddCommand(newRequestSigningStatusCmd(), newRequestSigningSetCmd(), newRequestSigningResetHostCmd())
+
+	return root
+}
+
+func newRequestSigningStatusCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:   "status",
+		Short: "Show each organization's mode and how many of its hosts sign",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			return withHostRepository(cmd.Context(), func(repo *services.HostRepository) error {
+				statuses, err := repo.ListRequestSigningStatus(cmd.Context())
+				if err != nil {
+					return err
+				}
+				w := tabwriter.NewWriter(cmd.OutOrStdout(), 0, 0, 2, ' ', 0)
+				fmt.Fprintln(w, "ORGANIZATION\tID\tMODE\tHOSTS\tKEYED\tSIGNING")
+				for _, s := range statuses {
+					fmt.Fprintf(w, "%s\t%s\t%s\t%d\t%d\t%d\n", s.Name, s.OrganizationID, s.Mode, s.Hosts, s.Keyed, s.Signing)
+				}
+				return w.Flush()
+			})
+		},
+	}
+}
+
+func newRequestSigningSetCmd() *cobra.Command {
+	var orgID string
+	cmd := &cobra.Command{
+		Use:   "set <" + strings.Join(services.RequestSigningModes, "|") + ">",
+		Short: "Set an organization's request signing mode",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			id, err := uuid.Parse(orgID)
+			if err != nil {
+				return fmt.Errorf("--org: %w", err)
+			}
+			return withHostRepository(cmd.Context(), func(repo *services.HostRepository) error {
+				if err := repo.SetRequestSigningMode(cmd.Context(), id, args[0]); err != nil {
+					return err
+				}
+				fmt.Fprintf(cmd.OutOrStdout(), "%s: request signing %s\n", id, args[0])
+				return nil
+			})
+		},
+	}
+	cmd.Flags().StringVar(&orgID, "org", "", "organization ID")
+	_ = cmd.MarkFlagRequired("org")
+	return cmd
+}
+
+func newRequestSigningResetHostCmd() *cobra.Command {
+	var orgID string
+	cmd := &cobra.Command{
+		Use:   "reset-host <host-id>",
+		Short: "Make a host that lost its signing key enroll again for a new one",
+		Long: `Drops the host's signing key and node key. The host's next request is
+answered node_invalid, and it enrolls again unsigned and is issued a new key.
+Only reset hosts you know lost their key, such as after a reinstall: until
+the host enrolls again, anyone with the enroll secret can enroll as it.`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			id, err := uuid.Parse(orgID)
+			if err != nil {
+				return fmt.Errorf("--org: %w", err)
+			}
+			hostID, err := uuid.Parse(args[0])
+			if err != nil {
+				return fmt.Errorf("host ID: %w", err)
+			}
+			return withHostRepository(cmd.Context(), func(repo *services.HostRepository) error {
+				found, err := repo.ResetSigningKey(cmd.Context(), id, hostID)
+				if err != nil {
+					return err
+				}
+				if !found {
+					return fmt.Errorf("host %s not found in organization %s", hostID, id)
+				}
+				fmt.Fprintf(cmd.OutOrStdout(), "%s: signing key reset; the host will enroll again\n", hostID)
+				return nil
+			})
+		},
+	}
+	cmd.Flags().StringVar(&orgID, "org", "", "organization ID")
+	_ = cmd.MarkFlagRequired("org")
+	return cmd
+}
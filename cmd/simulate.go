@@ -0,0 +1,53 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+
+	"github.com/cavenine/queryops/internal/simulator"
+
+	"github.com/spf13/cobra"
+)
+
+func NewSimulateCommand() *cobra.Command {
+	defaults := simulator.DefaultConfig()
+	cfg := defaults
+
+	cmd := &cobra.Command{
+		Use:   "simulate",
+		Short: "Run synthetic osquery agents against a server for load testing",
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			ctx := cmd.Context()
+
+			slog.InfoContext(ctx, "starting simulated agents", "server", cfg.ServerURL, "hosts", cfg.Hosts)
+			stats, err := simulator.Run(ctx, cfg)
+			if err != nil && !errors.Is(err, context.Canceled) {
+				return err
+			}
+			if stats != nil {
+				slog.InfoContext(ctx, "simulation finished",
+					"enrolled", stats.Enrolled.Load(),
+					"requests", stats.Requests.Load(),
+					"errors", stats.Errors.Load(),
+					"queries_answered", stats.QueriesAnswered.Load(),
+				)
+			}
+			return nil
+		},
+	}
+
+	flags := cmd.Flags()
+	flags.StringVar(&cfg.ServerURL, "server", "http://localhost:8080", "base URL of the QueryOps server")
+	flags.StringVar(&cfg.EnrollSecret, "enroll-secret", "", "organization enroll secret")
+	flags.IntVar(&cfg.Hosts, "hosts", defaults.Hosts, "number of simulated hosts")
+	flags.StringVar(&cfg.HostPrefix, "host-prefix", defaults.HostPrefix, "prefix for simulated host identifiers")
+	flags.DurationVar(&cfg.ConfigInterval, "config-interval", defaults.ConfigInterval, "config refresh interval")
+	flags.DurationVar(&cfg.DistributedInterval, "distributed-interval", defaults.DistributedInterval, "distributed query poll interval")
+	flags.DurationVar(&cfg.LoggerInterval, "logger-interval", defaults.LoggerInterval, "status log interval")
+	flags.DurationVar(&cfg.RampUp, "ramp-up", defaults.RampUp, "spread host enrollment over this duration")
+	flags.DurationVar(&cfg.StatsInterval, "stats-interval", defaults.StatsInterval, "progress log interval (0 disables)")
+	_ = cmd.MarkFlagRequired("enroll-secret")
+
+	return cmd
+}
@@ -6,16 +6,18 @@ import (
 	"flag"
 	"fmt"
 	"log/slog"
-	"net"
 	"net/http"
 	"os"
 	"os/signal"
+	"path/filepath"
 	"syscall"
+	"time"
 
 	"github.com/evanw/esbuild/pkg/api"
 	"golang.org/x/sync/errgroup"
 
 	"github.com/cavenine/queryops/config"
+	"github.com/cavenine/queryops/internal/livereload"
 	"github.com/cavenine/queryops/web/resources"
 )
 
@@ -40,20 +42,65 @@ func runMain() error {
 func run(ctx context.Context, watch bool) error {
 	eg, egctx := errgroup.WithContext(ctx)
 
+	var broker *livereload.Broker
+	if watch {
+		broker = livereload.NewBroker()
+
+		eg.Go(func() error {
+			return serveLiveReload(egctx, broker)
+		})
+
+		// Tailwind writes index.css from its own watcher; tell pages to swap
+		// stylesheets rather than reload.
+		eg.Go(func() error {
+			const pollInterval = 250 * time.Millisecond
+			livereload.WatchFile(egctx, filepath.Join(resources.StaticDirectoryPath, "index.css"), pollInterval, func() {
+				broker.Publish(livereload.EventCSS)
+			})
+			return nil
+		})
+	}
+
 	eg.Go(func() error {
-		return build(egctx, watch)
+		return build(egctx, watch, broker)
 	})
 
 	return eg.Wait()
 }
 
-func build(ctx context.Context, watch bool) error {
+// serveLiveReload serves broker on config.Global.LiveReloadAddr until ctx is
+// done. The web server proxies pages to it.
+func serveLiveReload(ctx context.Context, broker *livereload.Broker) error {
+	srv := &http.Server{
+		Addr:              config.Global.LiveReloadAddr,
+		Handler:           broker,
+		ReadHeaderTimeout: 5 * time.Second,
+	}
+
+	go func() {
+		<-ctx.Done()
+		_ = srv.Close()
+	}()
+
+	slog.InfoContext(ctx, "serving live reload", "addr", srv.Addr)
+	if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return fmt.Errorf("serving live reload: %w", err)
+	}
+	return nil
+}
+
+func build(ctx context.Context, watch bool, broker *livereload.Broker) error {
 	opts := api.BuildOptions{
 		EntryPointsAdvanced: []api.EntryPoint{
 			{
 				InputPath:  resources.LibsDirectoryPath + "/web-components/reverse-component/index.ts",
 				OutputPath: "libs/reverse-component",
 			},
+			// Needs npm install in resources.LibsDirectoryPath + /sql-editor; see the build:wc task.
+			{
+				InputPath:  resources.LibsDirectoryPath + "/sql-editor/src/index.ts",
+				OutputPath: "libs/sql-editor",
+			},
 			/*
 				uncomment the entrypoint below after running pnpm install in the resources.LibsDirectoryPath + /lit directory
 				esbuild will only be able to find the lit + sortable libraries after doing so
@@ -78,7 +125,7 @@ func build(ctx context.Context, watch bool) error {
 	if watch {
 		slog.InfoContext(ctx, "watching...")
 
-		opts.Plugins = append(opts.Plugins, hotReloadPlugin())
+		opts.Plugins = append(opts.Plugins, liveReloadPlugin(broker))
 
 		buildCtx, err := api.Context(opts)
 		if err != nil {
@@ -96,6 +143,14 @@ func build(ctx context.Context, watch bool) error {
 
 	slog.InfoContext(ctx, "building...")
 
+	// Production builds are fingerprinted; see writeManifest. Clear out the
+	// previous build's hashed outputs first.
+	opts.EntryNames = "[dir]/[name]-[hash]"
+	opts.Metafile = true
+	if err := os.RemoveAll(filepath.Join(resources.StaticDirectoryPath, "libs")); err != nil {
+		return fmt.Errorf("removing previous build: %w", err)
+	}
+
 	result := api.Build(opts)
 
 	if len(result.Errors) > 0 {
@@ -106,30 +161,27 @@ func build(ctx context.Context, watch bool) error {
 		return errors.Join(errs...)
 	}
 
+	manifest, err := buildManifest(resources.StaticDirectoryPath, result.Metafile, opts.EntryPointsAdvanced)
+	if err != nil {
+		return err
+	}
+	if err := writeManifest(resources.StaticDirectoryPath, manifest); err != nil {
+		return err
+	}
+	slog.InfoContext(ctx, "wrote asset manifest", "assets", len(manifest))
+
 	return nil
 }
 
-func hotReloadPlugin() api.Plugin {
+// liveReloadPlugin tells pages to reload after every successful rebuild.
+func liveReloadPlugin(broker *livereload.Broker) api.Plugin {
 	return api.Plugin{
-		Name: "hotreload",
+		Name: "livereload",
 		Setup: func(build api.PluginBuild) {
 			build.OnEnd(func(result *api.BuildResult) (api.OnEndResult, error) {
 				slog.Info("build complete", "errors", len(result.Errors), "warnings", len(result.Warnings))
 				if len(result.Errors) == 0 {
-					hostPort := net.JoinHostPort(config.Global.Host, config.Global.Port)
-					url := fmt.Sprintf("http://%s/hotreload", hostPort)
-					// #nosec G107
-					req, err := http.NewRequestWithContext(context.Background(), http.MethodGet, url, nil)
-					if err != nil {
-						slog.Warn("failed to create hotreload request", "error", err)
-						return api.OnEndResult{}, nil
-					}
-					resp, err := http.DefaultClient.Do(req)
-					if err != nil {
-						slog.Warn("failed to trigger hotreload", "error", err)
-					} else {
-						_ = resp.Body.Close()
-					}
+					broker.Publish(livereload.EventReload)
 				}
 				return api.OnEndResult{}, nil
 			})
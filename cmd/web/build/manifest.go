@@ -0,0 +1,114 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/evanw/esbuild/pkg/api"
+
+	"github.com/cavenine/queryops/web/resources"
+)
+
+// buildManifest fingerprints every file in the static directory. esbuild
+// outputs already carry their hash, so they map from the entry point's
+// logical name; every other file gets a hashed name that the server resolves
+// back to it. Source maps are left out: they are fetched relative to the
+// script that references them.
+func buildManifest(staticDir, metafile string, entryPoints []api.EntryPoint) (resources.Manifest, error) {
+	built, err := esbuildOutputs(staticDir, metafile, entryPoints)
+	if err != nil {
+		return nil, err
+	}
+
+	manifest := resources.Manifest{}
+	err = fs.WalkDir(os.DirFS(staticDir), ".", func(name string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() || name == resources.ManifestFileName || strings.HasSuffix(name, ".map") {
+			return nil
+		}
+
+		if logical, ok := built[name]; ok {
+			manifest[logical] = name
+			return nil
+		}
+
+		content, err := os.ReadFile(filepath.Join(staticDir, filepath.FromSlash(name)))
+		if err != nil {
+			return err
+		}
+		manifest[name] = resources.HashedName(name, content)
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("fingerprinting static assets: %w", err)
+	}
+	return manifest, nil
+}
+
+// esbuildOutputs maps each hashed esbuild output, relative to staticDir, to
+// its logical name: the entry point's OutputPath plus the output's
+// extension.
+func esbuildOutputs(staticDir, metafile string, entryPoints []api.EntryPoint) (map[string]string, error) {
+	var meta struct {
+		Outputs map[string]struct {
+			EntryPoint string `json:"entryPoint"`
+			CSSBundle  string `json:"cssBundle"`
+		} `json:"outputs"`
+	}
+	if err := json.Unmarshal([]byte(metafile), &meta); err != nil {
+		return nil, fmt.Errorf("parsing esbuild metafile: %w", err)
+	}
+
+	logicalNames := make(map[string]string, len(entryPoints))
+	for _, ep := range entryPoints {
+		logicalNames[filepath.ToSlash(ep.InputPath)] = ep.OutputPath
+	}
+
+	relative := func(output string) (string, error) {
+		rel, err := filepath.Rel(staticDir, filepath.FromSlash(output))
+		if err != nil {
+			return "", fmt.Errorf("locating esbuild output %s: %w", output, err)
+		}
+		return filepath.ToSlash(rel), nil
+	}
+
+	built := map[string]string{}
+	for output, o := range meta.Outputs {
+		logical, ok := logicalNames[o.EntryPoint]
+		if !ok {
+			continue
+		}
+		rel, err := relative(output)
+		if err != nil {
+			return nil, err
+		}
+		built[rel] = logical + path.Ext(rel)
+
+		if o.CSSBundle != "" {
+			css, err := relative(o.CSSBundle)
+			if err != nil {
+				return nil, err
+			}
+			built[css] = logical + ".css"
+		}
+	}
+	return built, nil
+}
+
+func writeManifest(staticDir string, manifest resources.Manifest) error {
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return fmt.Errorf("encoding asset manifest: %w", err)
+	}
+	if err := os.WriteFile(filepath.Join(staticDir, resources.ManifestFileName), append(data, '\n'), 0o644); err != nil {
+		return fmt.Errorf("writing asset manifest: %w", err)
+	}
+	return nil
+}
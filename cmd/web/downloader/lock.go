@@ -0,0 +1,87 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// lockFilePath is the lockfile, relative to the repository root.
+const lockFilePath = "web/vendor.lock.json"
+
+// Lock pins the client libraries the downloader fetches.
+type Lock struct {
+	Libraries []Library `json:"libraries"`
+}
+
+// Library is one pinned client library.
+type Library struct {
+	Name string `json:"name"`
+	// Repository is the GitHub "owner/name" that --update looks up releases
+	// in.
+	Repository string `json:"repository"`
+	Version    string `json:"version"`
+	Files      []File `json:"files"`
+}
+
+// File is one file of a library and the checksum it must match.
+type File struct {
+	// URL may contain "{version}", which is replaced by the library version.
+	URL string `json:"url"`
+	// Path is where the file is written, relative to the repository root.
+	Path   string `json:"path"`
+	SHA256 string `json:"sha256"`
+}
+
+func (l Library) url(f File) string {
+	return strings.ReplaceAll(f.URL, "{version}", l.Version)
+}
+
+func loadLock(path string) (*Lock, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading lockfile: %w", err)
+	}
+
+	var lock Lock
+	if err := json.Unmarshal(data, &lock); err != nil {
+		return nil, fmt.Errorf("parsing lockfile [%s]: %w", path, err)
+	}
+	return &lock, nil
+}
+
+func saveLock(path string, lock *Lock) error {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(lock); err != nil {
+		return fmt.Errorf("encoding lockfile: %w", err)
+	}
+
+	const filePerms = 0o644
+	if err := os.WriteFile(path, buf.Bytes(), filePerms); err != nil {
+		return fmt.Errorf("writing lockfile: %w", err)
+	}
+	return nil
+}
+
+func checksum(content []byte) string {
+	sum := sha256.Sum256(content)
+	return hex.EncodeToString(sum[:])
+}
+
+// verify checks content against the checksum pinned for f. A file with no
+// checksum fails, so a hand-added entry can't skip verification.
+func verify(f File, content []byte) error {
+	if f.SHA256 == "" {
+		return fmt.Errorf("no checksum for [%s] in lockfile; run with --update to pin one", f.Path)
+	}
+	if got := checksum(content); got != f.SHA256 {
+		return fmt.Errorf("checksum mismatch for [%s]: lockfile has %s, got %s", f.Path, f.SHA256, got)
+	}
+	return nil
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestVerify(t *testing.T) {
+	content := []byte("console.log('datastar')")
+	f := File{Path: "web/datastar.js", SHA256: checksum(content)}
+
+	if err := verify(f, content); err != nil {
+		t.Fatalf("verify matching content: %v", err)
+	}
+
+	err := verify(f, []byte("tampered"))
+	if err == nil || !strings.Contains(err.Error(), "checksum mismatch for [web/datastar.js]") {
+		t.Fatalf("verify tampered content = %v, want checksum mismatch", err)
+	}
+
+	f.SHA256 = ""
+	err = verify(f, content)
+	if err == nil || !strings.Contains(err.Error(), "no checksum for [web/datastar.js]") {
+		t.Fatalf("verify without checksum = %v, want missing checksum", err)
+	}
+}
+
+func TestSaveLoadLock(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "vendor.lock.json")
+	want := &Lock{Libraries: []Library{{
+		Name:       "datastar",
+		Repository: "starfederation/datastar",
+		Version:    "v1.0.0",
+		Files:      []File{{URL: "https://example.com/{version}/datastar.js", Path: "web/datastar.js", SHA256: "abc"}},
+	}}}
+
+	if err := saveLock(path, want); err != nil {
+		t.Fatalf("saveLock: %v", err)
+	}
+	got, err := loadLock(path)
+	if err != nil {
+		t.Fatalf("loadLock: %v", err)
+	}
+	if len(got.Libraries) != 1 || len(got.Libraries[0].Files) != 1 || got.Libraries[0].Files[0] != want.Libraries[0].Files[0] {
+		t.Fatalf("loaded lock = %+v, want %+v", got, want)
+	}
+	if u := got.Libraries[0].url(got.Libraries[0].Files[0]); u != "https://example.com/v1.0.0/datastar.js" {
+		t.Fatalf("url = %q", u)
+	}
+}
@@ -2,7 +2,9 @@ package main
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
+	"flag"
 	"fmt"
 	"io"
 	"log/slog"
@@ -10,31 +12,76 @@ import (
 	"os"
 	"path/filepath"
 	"sync"
-
-	"github.com/cavenine/queryops/web/resources"
 )
 
+// githubAPI is where --update looks up releases.
+var githubAPI = "https://api.github.com"
+
 func main() {
-	if err := run(); err != nil {
+	if err := runMain(); err != nil {
 		slog.Error("failure", "error", err)
 		os.Exit(1)
 	}
 }
 
-func run() error {
-	files := map[string]string{
-		"https://raw.githubusercontent.com/starfederation/datastar/develop/bundles/datastar.js":     resources.StaticDirectoryPath + "/datastar/datastar.js",
-		"https://raw.githubusercontent.com/starfederation/datastar/develop/bundles/datastar.js.map": resources.StaticDirectoryPath + "/datastar/datastar.js.map",
-		"https://github.com/saadeghi/daisyui/releases/latest/download/daisyui.js":                   resources.StylesDirectoryPath + "/daisyui/daisyui.js",
-		"https://github.com/saadeghi/daisyui/releases/latest/download/daisyui-theme.js":             resources.StylesDirectoryPath + "/daisyui/daisyui-theme.js",
+func runMain() error {
+	var update, verifyOnly bool
+	flag.BoolVar(&update, "update", false, "Bump every library to its latest release and rewrite the lockfile")
+	flag.BoolVar(&verifyOnly, "verify", false, "Check the files on disk against the lockfile without downloading")
+	flag.Parse()
+
+	lock, err := loadLock(lockFilePath)
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case verifyOnly:
+		return verifyFiles(lock)
+	case update:
+		if err := updateVersions(context.Background(), lock); err != nil {
+			return err
+		}
+	}
+
+	return run(lock, update)
+}
+
+// run downloads every file in the lock. Unless update is set, each file must
+// match its pinned checksum, and nothing is written if any does not.
+// With update, the lock takes the checksums of what was downloaded.
+func run(lock *Lock, update bool) error {
+	files := map[string]string{}
+	for _, lib := range lock.Libraries {
+		for _, f := range lib.Files {
+			files[lib.url(f)] = f.Path
+		}
+	}
+
+	contents, err := download(files)
+	if err != nil {
+		return err
 	}
 
-	directories := []string{
-		resources.StaticDirectoryPath + "/datastar",
-		resources.StylesDirectoryPath + "/daisyui",
-		resources.StaticDirectoryPath + "/libs",
+	var errs []error
+	for i, lib := range lock.Libraries {
+		for j, f := range lib.Files {
+			content := contents[f.Path]
+			if update {
+				lock.Libraries[i].Files[j].SHA256 = checksum(content)
+				continue
+			}
+			if err := verify(f, content); err != nil {
+				errs = append(errs, err)
+			}
+		}
+	}
+	if len(errs) > 0 {
+		return errors.Join(errs...)
 	}
 
+	directories := lockDirectories(lock)
+
 	if err := removeDirectories(directories); err != nil {
 		return err
 	}
@@ -43,13 +90,104 @@ func run() error {
 		return err
 	}
 
-	if err := download(files); err != nil {
+	if err := writeFiles(contents); err != nil {
 		return err
 	}
 
+	if update {
+		if err := saveLock(lockFilePath, lock); err != nil {
+			return err
+		}
+		slog.Info("updated lockfile", "path", lockFilePath)
+	}
+
+	return nil
+}
+
+// verifyFiles checks the files already on disk against the lock.
+func verifyFiles(lock *Lock) error {
+	var errs []error
+	for _, lib := range lock.Libraries {
+		for _, f := range lib.Files {
+			content, err := os.ReadFile(f.Path)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("failed to read [%s]: %w", f.Path, err))
+				continue
+			}
+			if err := verify(f, content); err != nil {
+				errs = append(errs, err)
+			}
+		}
+	}
+	if len(errs) > 0 {
+		return errors.Join(errs...)
+	}
+
+	slog.Info("client libraries match the lockfile", "path", lockFilePath)
+	return nil
+}
+
+// updateVersions sets each library to the tag of its newest GitHub release.
+func updateVersions(ctx context.Context, lock *Lock) error {
+	for i, lib := range lock.Libraries {
+		version, err := latestRelease(ctx, lib.Repository)
+		if err != nil {
+			return fmt.Errorf("failed to look up latest release of [%s]: %w", lib.Name, err)
+		}
+		if version != lib.Version {
+			slog.Info("updating", "library", lib.Name, "from", lib.Version, "to", version)
+		}
+		lock.Libraries[i].Version = version
+	}
 	return nil
 }
 
+func latestRelease(ctx context.Context, repository string) (string, error) {
+	// The list includes pre-releases, which /releases/latest skips.
+	url := githubAPI + "/repos/" + repository + "/releases?per_page=1"
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return "", fmt.Errorf("failed to create request for [%s]: %w", url, err)
+	}
+	req.Header.Set("Accept", "application/vnd.github+json")
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("failed to fetch releases [%s]: %w", url, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("http status was not OK fetching releases [%s]: %s", url, resp.Status)
+	}
+
+	var releases []struct {
+		TagName string `json:"tag_name"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&releases); err != nil {
+		return "", fmt.Errorf("failed to decode releases [%s]: %w", url, err)
+	}
+	if len(releases) == 0 {
+		return "", fmt.Errorf("no releases found [%s]", url)
+	}
+	return releases[0].TagName, nil
+}
+
+func lockDirectories(lock *Lock) []string {
+	seen := map[string]bool{}
+	var dirs []string
+	for _, lib := range lock.Libraries {
+		for _, f := range lib.Files {
+			dir := filepath.Dir(f.Path)
+			if !seen[dir] {
+				seen[dir] = true
+				dirs = append(dirs, dir)
+			}
+		}
+	}
+	return dirs
+}
+
 func removeDirectories(dirs []string) error {
 	var wg sync.WaitGroup
 	errCh := make(chan error, len(dirs))
@@ -109,8 +247,14 @@ func createDirectories(dirs []string) error {
 	return nil
 }
 
-func download(files map[string]string) error {
-	var wg sync.WaitGroup
+// download fetches each URL in files and returns the contents keyed by the
+// file's path.
+func download(files map[string]string) (map[string][]byte, error) {
+	var (
+		wg       sync.WaitGroup
+		mu       sync.Mutex
+		contents = make(map[string][]byte, len(files))
+	)
 	errCh := make(chan error, len(files))
 
 	for url, filename := range files {
@@ -119,11 +263,15 @@ func download(files map[string]string) error {
 			defer wg.Done()
 			base := filepath.Base(f)
 			slog.Info("downloading...", "file", base, "url", u)
-			if err := downloadFile(u, f); err != nil {
+			content, err := downloadFile(u)
+			if err != nil {
 				errCh <- fmt.Errorf("failed to download [%s]: %w", base, err)
-			} else {
-				slog.Info("finished", "file", base)
+				return
 			}
+			mu.Lock()
+			contents[f] = content
+			mu.Unlock()
+			slog.Info("finished", "file", base)
 		}(url, filename)
 	}
 
@@ -136,37 +284,43 @@ func download(files map[string]string) error {
 	}
 
 	if len(errs) > 0 {
-		return errors.Join(errs...)
+		return nil, errors.Join(errs...)
 	}
 
-	return nil
+	return contents, nil
 }
 
-func downloadFile(url, filename string) error {
+func downloadFile(url string) ([]byte, error) {
 	req, err := http.NewRequestWithContext(context.Background(), http.MethodGet, url, nil)
 	if err != nil {
-		return fmt.Errorf("failed to create request for [%s]: %w", url, err)
+		return nil, fmt.Errorf("failed to create request for [%s]: %w", url, err)
 	}
 
 	// #nosec G107
 	resp, err := http.DefaultClient.Do(req)
 	if err != nil {
-		return fmt.Errorf("failed to download file [%s]: %w", url, err)
+		return nil, fmt.Errorf("failed to download file [%s]: %w", url, err)
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
-		return fmt.Errorf("http status was not OK downloading file [%s]: %s", url, resp.Status)
+		return nil, fmt.Errorf("http status was not OK downloading file [%s]: %s", url, resp.Status)
 	}
 
-	out, err := os.Create(filename)
+	content, err := io.ReadAll(resp.Body)
 	if err != nil {
-		return fmt.Errorf("failed to create file [%s]: %w", filename, err)
+		return nil, fmt.Errorf("failed to read file [%s]: %w", url, err)
 	}
-	defer out.Close()
 
-	if _, copyErr := io.Copy(out, resp.Body); copyErr != nil {
-		return fmt.Errorf("failed to write file [%s]: %w", filename, copyErr)
+	return content, nil
+}
+
+func writeFiles(contents map[string][]byte) error {
+	for filename, content := range contents {
+		const filePerms = 0o644
+		if err := os.WriteFile(filename, content, filePerms); err != nil {
+			return fmt.Errorf("failed to write file [%s]: %w", filename, err)
+		}
 	}
 
 	return nil
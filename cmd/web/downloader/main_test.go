@@ -0,0 +1,117 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// newLibraryServer serves files by path and the newest release of any
+// repository as version.
+func newLibraryServer(t *testing.T, version string, files map[string]string) *httptest.Server {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasSuffix(r.URL.Path, "/releases") {
+			_, _ = w.Write([]byte(`[{"tag_name":"` + version + `"}]`))
+			return
+		}
+		content, ok := files[r.URL.Path]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		_, _ = w.Write([]byte(content))
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+// testLock pins one library whose single file is served from srv.
+func testLock(srv *httptest.Server, sha string) *Lock {
+	return &Lock{Libraries: []Library{{
+		Name:       "datastar",
+		Repository: "starfederation/datastar",
+		Version:    "v1",
+		Files: []File{{
+			URL:    srv.URL + "/{version}/datastar.js",
+			Path:   "web/static/datastar.js",
+			SHA256: sha,
+		}},
+	}}}
+}
+
+func TestRun_WritesVerifiedFiles(t *testing.T) {
+	t.Chdir(t.TempDir())
+	srv := newLibraryServer(t, "v1", map[string]string{"/v1/datastar.js": "v1 bundle"})
+
+	if err := run(testLock(srv, checksum([]byte("v1 bundle"))), false); err != nil {
+		t.Fatalf("run: %v", err)
+	}
+	got, err := os.ReadFile("web/static/datastar.js")
+	if err != nil || string(got) != "v1 bundle" {
+		t.Fatalf("written file = %q, %v", got, err)
+	}
+}
+
+func TestRun_HashMismatchWritesNothing(t *testing.T) {
+	t.Chdir(t.TempDir())
+	srv := newLibraryServer(t, "v1", map[string]string{"/v1/datastar.js": "tampered bundle"})
+
+	err := run(testLock(srv, checksum([]byte("v1 bundle"))), false)
+	if err == nil || !strings.Contains(err.Error(), "checksum mismatch") {
+		t.Fatalf("run = %v, want checksum mismatch", err)
+	}
+	if _, err := os.Stat("web/static/datastar.js"); !os.IsNotExist(err) {
+		t.Fatalf("file written despite mismatch: %v", err)
+	}
+}
+
+func TestRun_MissingChecksumWritesNothing(t *testing.T) {
+	t.Chdir(t.TempDir())
+	srv := newLibraryServer(t, "v1", map[string]string{"/v1/datastar.js": "v1 bundle"})
+
+	err := run(testLock(srv, ""), false)
+	if err == nil || !strings.Contains(err.Error(), "no checksum") {
+		t.Fatalf("run = %v, want missing checksum", err)
+	}
+	if _, err := os.Stat("web/static/datastar.js"); !os.IsNotExist(err) {
+		t.Fatalf("file written without a checksum: %v", err)
+	}
+}
+
+func TestUpdate_RegeneratesLock(t *testing.T) {
+	t.Chdir(t.TempDir())
+	srv := newLibraryServer(t, "v2", map[string]string{"/v2/datastar.js": "v2 bundle"})
+	api := githubAPI
+	githubAPI = srv.URL
+	t.Cleanup(func() { githubAPI = api })
+
+	if err := os.MkdirAll(filepath.Dir(lockFilePath), 0o750); err != nil {
+		t.Fatalf("MkdirAll: %v", err)
+	}
+	lock := testLock(srv, checksum([]byte("v1 bundle")))
+	if err := updateVersions(t.Context(), lock); err != nil {
+		t.Fatalf("updateVersions: %v", err)
+	}
+	if err := run(lock, true); err != nil {
+		t.Fatalf("run: %v", err)
+	}
+
+	saved, err := loadLock(lockFilePath)
+	if err != nil {
+		t.Fatalf("loadLock: %v", err)
+	}
+	lib := saved.Libraries[0]
+	if lib.Version != "v2" {
+		t.Fatalf("version = %q, want v2", lib.Version)
+	}
+	if lib.Files[0].SHA256 != checksum([]byte("v2 bundle")) {
+		t.Fatalf("checksum not regenerated: %s", lib.Files[0].SHA256)
+	}
+	if err := verifyFiles(saved); err != nil {
+		t.Fatalf("verifyFiles after update: %v", err)
+	}
+}
@@ -12,18 +12,28 @@ import (
 	"github.com/cavenine/queryops/background"
 	"github.com/cavenine/queryops/config"
 	"github.com/cavenine/queryops/db"
+	"github.com/cavenine/queryops/internal/app"
+	"github.com/cavenine/queryops/internal/compression"
+	"github.com/cavenine/queryops/internal/grpcapi"
+	"github.com/cavenine/queryops/internal/lifecycle"
+	"github.com/cavenine/queryops/internal/outbox"
 	"github.com/cavenine/queryops/internal/pubsub"
+	"github.com/cavenine/queryops/internal/realip"
+	"github.com/cavenine/queryops/internal/security"
 	"github.com/cavenine/queryops/migrations"
 	"github.com/cavenine/queryops/router"
 
-	"github.com/alexedwards/scs/pgxstore"
-	"github.com/alexedwards/scs/v2"
+	"github.com/ThreeDotsLabs/watermill/message"
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/chi/v5/middleware"
 	"github.com/spf13/cobra"
-	"golang.org/x/sync/errgroup"
 )
 
+// shutdownTimeout bounds stopping the web server and everything it runs.
+// Deployments should give the process at least this long to exit after
+// SIGTERM.
+const shutdownTimeout = 20 * time.Second
+
 func NewWebCommand() *cobra.Command {
 	cmd := &cobra.Command{
 		Use:   "web",
@@ -59,99 +69,247 @@ func run(ctx context.Context) error {
 		}
 	}
 
-	eg, egctx := errgroup.WithContext(ctx)
+	// Components stop in the reverse of the order they're added: the HTTP
+	// server first, so its requests finish with everything behind them still
+	// running, and pub/sub last, after everything that publishes.
+	components := lifecycle.New(shutdownTimeout)
+	go config.ReloadOnSIGHUP(ctx)
 
-	pool, err := db.NewPool(egctx, config.Global)
+	var breaker *db.Breaker
+	if config.Global.DatabaseBreakerFailures > 0 {
+		breaker = db.NewBreaker(config.Global.DatabaseBreakerFailures, time.Duration(config.Global.DatabaseBreakerProbeMs)*time.Millisecond)
+	}
+	pool, err := db.NewPool(ctx, config.Global, breaker)
 	if err != nil {
 		return fmt.Errorf("creating database pool: %w", err)
 	}
 	defer pool.Close()
+	if breaker != nil {
+		go breaker.Run(ctx, pool)
+	}
 
 	var ps *pubsub.PubSub
 	if config.Global.PubSubEnabled {
-		ps, err = pubsub.New(egctx, &pubsub.Config{
-			NATSUrl: config.Global.NATSUrl,
+		ps, err = pubsub.New(ctx, &pubsub.Config{
+			NATSUrl:     config.Global.NATSUrl,
+			Replicas:    config.Global.WebReplicas,
+			DeadLetters: outbox.NewDeadLetters(pool),
 		})
+		if errors.Is(err, pubsub.ErrEmbeddedReplicas) {
+			// Falling back to polling would hide the misconfiguration.
+			return err
+		}
 		if err != nil {
-			slog.WarnContext(egctx, "pubsub initialization failed; SSE will use polling", "error", err)
+			slog.WarnContext(ctx, "pubsub initialization failed; SSE will use polling", "error", err)
 			ps = nil
 		} else {
-			defer func() {
-				if closeErr := ps.Close(); closeErr != nil {
-					slog.WarnContext(egctx, "error closing pubsub", "error", closeErr)
-				}
-			}()
+			components.Append(lifecycle.Hook{
+				Name: "pubsub",
+				Stop: func(context.Context) error { return ps.Close() },
+			})
 		}
 	}
 
 	if config.Global.BackgroundProcessing && config.Global.Environment == config.Dev {
 		clientCfg := background.DefaultClientConfig()
-		eg.Go(func() error {
-			slog.InfoContext(egctx, "starting in-process river workers")
-			if runErr := background.RunWorker(egctx, pool, clientCfg); runErr != nil && !errors.Is(runErr, context.Canceled) {
-				return fmt.Errorf("river client error: %w", runErr)
-			}
-			return nil
+		var publisher message.Publisher
+		if ps != nil {
+			publisher = ps.Publisher()
+		}
+		// Workers finish their jobs at shutdown rather than when the signal
+		// arrives, so they get a context of their own.
+		workerCtx, stopWorkers := context.WithCancel(context.WithoutCancel(ctx))
+		defer stopWorkers()
+		workersDone := make(chan struct{})
+		components.Append(lifecycle.Hook{
+			Name: "river workers",
+			Start: func(context.Context) error {
+				components.Go(func() error {
+					defer close(workersDone)
+					slog.InfoContext(workerCtx, "starting in-process river workers")
+					if runErr := background.RunWorker(workerCtx, pool, publisher, clientCfg); runErr != nil && !errors.Is(runErr, context.Canceled) {
+						return fmt.Errorf("river client error: %w", runErr)
+					}
+					return nil
+				})
+				return nil
+			},
+			Stop: func(stopCtx context.Context) error {
+				stopWorkers()
+				return waitFor(stopCtx, workersDone)
+			},
 		})
 	}
 
+	compress, err := compression.Middleware()
+	if err != nil {
+		return fmt.Errorf("error setting up compression: %w", err)
+	}
+
+	trustedProxies, err := realip.ParseTrusted(config.Global.TrustedProxies)
+	if err != nil {
+		return fmt.Errorf("error parsing TRUSTED_PROXIES: %w", err)
+	}
+
+	streams := lifecycle.NewStreams()
 	r := chi.NewMux()
 	r.Use(
+		realip.Middleware(trustedProxies),
 		middleware.Logger,
 		middleware.Recoverer,
+		streams.Middleware,
+		compress,
+		security.Headers(security.Config{
+			CSPEnabled:        config.Global.CSPEnabled,
+			CSPReportOnly:     config.Global.CSPReportOnly,
+			FrameAncestors:    config.Global.CSPFrameAncestors,
+			HSTSMaxAgeSeconds: config.Global.HSTSMaxAgeSeconds,
+			ReferrerPolicy:    config.Global.ReferrerPolicy,
+		}),
 	)
 
-	// Initialize SCS session manager with PostgreSQL backend
-	sessionManager := scs.New()
-	sessionManager.Store = pgxstore.New(pool)
-	const sessionLifetime = 30 * 24 * time.Hour
-	sessionManager.Lifetime = sessionLifetime
-	sessionManager.Cookie.Name = "session"
-	sessionManager.Cookie.Path = "/"
-	sessionManager.Cookie.HttpOnly = true
-	sessionManager.Cookie.Secure = config.Global.Environment == config.Prod
-	sessionManager.Cookie.SameSite = http.SameSiteLaxMode
-
-	if setupErr := router.SetupRoutes(egctx, r, sessionManager, pool, ps); setupErr != nil {
+	a, err := app.New(ctx, app.Deps{Pool: pool, Breaker: breaker, PubSub: ps})
+	if err != nil {
+		return fmt.Errorf("error setting up app: %w", err)
+	}
+	if setupErr := router.SetupRoutes(r, a); setupErr != nil {
 		return fmt.Errorf("error setting up routes: %w", setupErr)
 	}
+	logs := a.Osquery.Logs()
+
+	// The ingester stops after the server so batches already acknowledged to
+	// osquery are written before the pool closes.
+	logsDone := make(chan struct{})
+	components.Append(lifecycle.Hook{
+		Name: "osquery log ingester",
+		Start: func(context.Context) error {
+			components.Go(func() error {
+				defer close(logsDone)
+				logs.Run()
+				return nil
+			})
+			return nil
+		},
+		Stop: func(stopCtx context.Context) error {
+			logs.Close()
+			return waitFor(stopCtx, logsDone)
+		},
+	})
+
+	// The meter also stops after the server, so in-flight API requests are
+	// counted.
+	apiUsage := a.Organizations.APIUsage()
+	apiUsageDone := make(chan struct{})
+	components.Append(lifecycle.Hook{
+		Name: "api usage meter",
+		Start: func(context.Context) error {
+			components.Go(func() error {
+				defer close(apiUsageDone)
+				apiUsage.Run()
+				return nil
+			})
+			return nil
+		},
+		Stop: func(stopCtx context.Context) error {
+			apiUsage.Close()
+			return waitFor(stopCtx, apiUsageDone)
+		},
+	})
 
 	const readHeaderTimeout = 5 * time.Second
 	srv := &http.Server{
 		Addr:              addr,
 		Handler:           r,
 		ReadHeaderTimeout: readHeaderTimeout,
-		BaseContext: func(_ net.Listener) context.Context {
-			return egctx
-		},
 		ErrorLog: slog.NewLogLogger(
 			slog.Default().Handler(),
 			slog.LevelError,
 		),
 	}
 
-	eg.Go(func() error {
-		if serveErr := srv.ListenAndServe(); serveErr != nil && !errors.Is(serveErr, http.ErrServerClosed) {
-			return fmt.Errorf("server error: %w", serveErr)
-		}
-		return nil
+	components.Append(lifecycle.Hook{
+		Name: "http server",
+		Start: func(context.Context) error {
+			ln, listenErr := net.Listen("tcp", addr)
+			if listenErr != nil {
+				return listenErr
+			}
+			components.Go(func() error {
+				if serveErr := srv.Serve(ln); serveErr != nil && !errors.Is(serveErr, http.ErrServerClosed) {
+					return fmt.Errorf("server error: %w", serveErr)
+				}
+				return nil
+			})
+			return nil
+		},
+		// Shutdown stops accepting connections and waits for requests, such
+		// as in-flight distributed writes, to finish. Streams never would,
+		// so they're ended alongside.
+		Stop: func(stopCtx context.Context) error {
+			drained := make(chan error, 1)
+			go func() { drained <- streams.Drain(stopCtx) }()
+			shutdownErr := srv.Shutdown(stopCtx)
+			if shutdownErr != nil {
+				_ = srv.Close()
+			}
+			return errors.Join(shutdownErr, <-drained)
+		},
 	})
 
-	eg.Go(func() error {
-		<-egctx.Done()
-		const shutdownTimeout = 5 * time.Second
-		shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
-		defer cancel()
-
-		slog.DebugContext(egctx, "shutting down server...")
+	if config.Global.GRPCAddr != "" {
+		appendGRPCServer(ctx, components, a)
+	}
 
-		if shutdownErr := srv.Shutdown(shutdownCtx); shutdownErr != nil {
-			slog.ErrorContext(egctx, "error during shutdown", "error", shutdownErr)
-			return shutdownErr
-		}
+	return components.Run(ctx)
+}
 
-		return nil
+// appendGRPCServer serves the gRPC API on GRPC_ADDR. It's added after the
+// HTTP server, so it stops first.
+func appendGRPCServer(ctx context.Context, components *lifecycle.Manager, a *app.App) {
+	addr := config.Global.GRPCAddr
+	srv := grpcapi.NewGRPCServer(a.GRPC, a.APITokens, config.Global.Environment == config.Dev)
+	components.Append(lifecycle.Hook{
+		Name: "grpc server",
+		Start: func(context.Context) error {
+			ln, listenErr := net.Listen("tcp", addr)
+			if listenErr != nil {
+				return listenErr
+			}
+			slog.InfoContext(ctx, "grpc server started", "addr", addr)
+			components.Go(func() error {
+				if serveErr := srv.Serve(ln); serveErr != nil {
+					return fmt.Errorf("grpc server error: %w", serveErr)
+				}
+				return nil
+			})
+			return nil
+		},
+		// GracefulStop waits for calls to finish. Streams would run until
+		// their campaigns do, so they're ended first.
+		Stop: func(stopCtx context.Context) error {
+			a.GRPC.Close()
+			stopped := make(chan struct{})
+			go func() {
+				defer close(stopped)
+				srv.GracefulStop()
+			}()
+			select {
+			case <-stopped:
+				return nil
+			case <-stopCtx.Done():
+				srv.Stop()
+				return stopCtx.Err()
+			}
+		},
 	})
+}
 
-	return eg.Wait()
+// waitFor waits until done is closed or ctx is done.
+func waitFor(ctx context.Context, done <-chan struct{}) error {
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
 }
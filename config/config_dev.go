@@ -4,6 +4,10 @@ package config
 
 import "os"
 
+// devEncryptionKey lets a dev database be used without configuring keys. It
+// is public, so never use it for real data.
+const devEncryptionKey = "dev:ZGV2LW9ubHktZW5jcnlwdGlvbi1rZXktMzItYnl0ZXM="
+
 func Load() *Config {
 	cfg := loadBase()
 	cfg.Environment = Dev
@@ -12,5 +16,13 @@ func Load() *Config {
 		cfg.PubSubAutoInitSchema = true
 	}
 
+	if _, ok := os.LookupEnv("HSTS_MAX_AGE_SECONDS"); !ok {
+		cfg.HSTSMaxAgeSeconds = 0
+	}
+
+	if cfg.EncryptionKeys == "" {
+		cfg.EncryptionKeys = devEncryptionKey
+	}
+
 	return cfg
 }
@@ -0,0 +1,166 @@
+package config
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/signal"
+	"reflect"
+	"slices"
+	"sync"
+	"syscall"
+)
+
+// liveSettings are the settings Reload applies to a running process. The
+// rest are read once at startup and only take effect after a restart.
+var liveSettings = []string{
+	"LOG_LEVEL",
+	"QUOTA_MAX_HOSTS",
+	"QUOTA_MAX_CAMPAIGNS_PER_DAY",
+	"QUOTA_MAX_RESULT_LOG_BYTES_PER_DAY",
+	"NOTIFY_HOST_OFFLINE_MS",
+}
+
+var (
+	reloadMu    sync.Mutex
+	reloadHooks []func(*Config)
+)
+
+// Change is a setting that differs after a reload.
+type Change struct {
+	Name string
+	// Live reports whether the change was applied. Old and New are only set
+	// for live settings, since the others include secrets.
+	Live     bool
+	Old, New any
+
+	field int
+}
+
+func (c Change) String() string {
+	if !c.Live {
+		return c.Name
+	}
+	return fmt.Sprintf("%s: %v -> %v", c.Name, c.Old, c.New)
+}
+
+// OnReload registers fn to be called with the configuration each time a
+// reload changes a live setting. Code that uses a live setting after
+// startup registers here rather than reading Global again.
+func OnReload(fn func(*Config)) {
+	reloadMu.Lock()
+	defer reloadMu.Unlock()
+	reloadHooks = append(reloadHooks, fn)
+}
+
+// Reload reads the configuration again and applies its live settings to
+// Global. It returns every setting that changed, live or not, and applies
+// nothing if a live setting is invalid.
+//
+// The environment of a running process is fixed, so only settings that
+// come from the .env file can change.
+func Reload() ([]Change, error) {
+	reloadMu.Lock()
+	defer reloadMu.Unlock()
+
+	next := Load()
+	if err := next.validateLive(); err != nil {
+		return nil, err
+	}
+
+	changes := diff(Global, next)
+	current, updated := reflect.ValueOf(Global).Elem(), reflect.ValueOf(next).Elem()
+	applied := false
+	for _, c := range changes {
+		if c.Live {
+			current.Field(c.field).Set(updated.Field(c.field))
+			applied = true
+		}
+	}
+	if applied {
+		snapshot := *Global
+		for _, fn := range reloadHooks {
+			fn(&snapshot)
+		}
+	}
+	return changes, nil
+}
+
+// ReloadOnSIGHUP reloads the configuration each time the process receives
+// SIGHUP, logging what changed, until ctx is cancelled.
+func ReloadOnSIGHUP(ctx context.Context) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-hup:
+			changes, err := Reload()
+			if err != nil {
+				slog.ErrorContext(ctx, "config reload rejected; keeping current settings", "error", err)
+				continue
+			}
+			var applied, restart []string
+			for _, c := range changes {
+				if c.Live {
+					applied = append(applied, c.String())
+				} else {
+					restart = append(restart, c.String())
+				}
+			}
+			if len(restart) > 0 {
+				slog.WarnContext(ctx, "config reloaded; some changes need a restart", "applied", applied, "needs_restart", restart)
+			} else {
+				slog.InfoContext(ctx, "config reloaded", "applied", applied)
+			}
+		}
+	}
+}
+
+// validateLive checks the live settings, which are applied without a
+// restart to catch a bad value.
+func (c *Config) validateLive() error {
+	var errs []error
+	if c.logLevelErr != nil {
+		errs = append(errs, c.logLevelErr)
+	}
+	if c.QuotaMaxHosts < 0 || c.QuotaMaxCampaignsPerDay < 0 || c.QuotaMaxResultLogBytesPerDay < 0 {
+		errs = append(errs, errors.New("QUOTA_* must not be negative"))
+	}
+	if c.NotifyHostOfflineMs <= 0 {
+		errs = append(errs, errors.New("NOTIFY_HOST_OFFLINE_MS must be positive"))
+	}
+	return errors.Join(errs...)
+}
+
+// diff returns the settings that differ between a and b, in declaration
+// order.
+func diff(a, b *Config) []Change {
+	av, bv := reflect.ValueOf(a).Elem(), reflect.ValueOf(b).Elem()
+	var changes []Change
+	for i := range av.NumField() {
+		field := av.Type().Field(i)
+		name := field.Tag.Get("mapstructure")
+		if field.Name == "LogLevel" {
+			name = "LOG_LEVEL"
+		}
+		if name == "" || name == "-" || !field.IsExported() {
+			continue
+		}
+		old, updated := av.Field(i).Interface(), bv.Field(i).Interface()
+		if reflect.DeepEqual(old, updated) {
+			continue
+		}
+		c := Change{Name: name, Live: slices.Contains(liveSettings, name), field: i}
+		if c.Live {
+			c.Old, c.New = old, updated
+		}
+		changes = append(changes, c)
+	}
+	return changes
+}
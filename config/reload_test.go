@@ -0,0 +1,66 @@
+package config
+
+import (
+	"log/slog"
+	"testing"
+)
+
+func TestReload(t *testing.T) {
+	saved := *Global
+	t.Cleanup(func() { *Global = saved })
+
+	var reloaded *Config
+	OnReload(func(c *Config) { reloaded = c })
+
+	t.Setenv("LOG_LEVEL", "debug")
+	t.Setenv("QUOTA_MAX_HOSTS", "25")
+	t.Setenv("SESSION_SECRET", "rotated")
+
+	changes, err := Reload()
+	if err != nil {
+		t.Fatalf("Reload: %v", err)
+	}
+	got := map[string]string{}
+	for _, c := range changes {
+		got[c.Name] = c.String()
+	}
+	want := map[string]string{
+		"LOG_LEVEL":       "LOG_LEVEL: " + saved.LogLevel.String() + " -> DEBUG",
+		"QUOTA_MAX_HOSTS": "QUOTA_MAX_HOSTS: 0 -> 25",
+		// Not live, and its values are never reported.
+		"SESSION_SECRET": "SESSION_SECRET",
+	}
+	if len(got) != len(want) {
+		t.Fatalf("changes = %v, want %v", got, want)
+	}
+	for name, s := range want {
+		if got[name] != s {
+			t.Errorf("change %s = %q, want %q", name, got[name], s)
+		}
+	}
+
+	if Global.LogLevel != slog.LevelDebug || Global.QuotaMaxHosts != 25 || Global.SessionSecret != saved.SessionSecret {
+		t.Fatalf("Global after reload = level %v, hosts %d, secret %q", Global.LogLevel, Global.QuotaMaxHosts, Global.SessionSecret)
+	}
+	if reloaded == nil || reloaded.QuotaMaxHosts != 25 {
+		t.Fatalf("hook called with %+v", reloaded)
+	}
+
+	// Nothing is applied when a live setting is invalid.
+	reloaded = nil
+	t.Setenv("QUOTA_MAX_HOSTS", "50")
+	t.Setenv("LOG_LEVEL", "loud")
+	if _, err := Reload(); err == nil {
+		t.Fatalf("Reload accepted LOG_LEVEL=loud")
+	}
+	if Global.QuotaMaxHosts != 25 || reloaded != nil {
+		t.Fatalf("invalid reload applied: hosts %d, hook %v", Global.QuotaMaxHosts, reloaded)
+	}
+
+	// Reloading unchanged settings reports nothing and skips the hooks.
+	t.Setenv("LOG_LEVEL", "debug")
+	t.Setenv("QUOTA_MAX_HOSTS", "25")
+	if changes, err := Reload(); err != nil || len(changes) != 1 || changes[0].Name != "SESSION_SECRET" || reloaded != nil {
+		t.Fatalf("unchanged reload = %v, %v (hook %v)", changes, err, reloaded)
+	}
+}
@@ -0,0 +1,175 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"net"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// defaultBreakerProbe is how often an open Breaker pings the database if
+// NewBreaker isn't given an interval.
+const defaultBreakerProbe = 2 * time.Second
+
+// Breaker is a circuit breaker for a database pool. It watches the pool's
+// connections and opens after enough failures in a row that say Postgres is
+// unreachable, rather than that a query was wrong. While it's open, handlers
+// that check Open answer without touching the database, and Run pings
+// Postgres until it answers, which closes the breaker again.
+//
+// A nil Breaker is never open.
+type Breaker struct {
+	failures int
+	probe    time.Duration
+
+	open atomic.Bool
+
+	mu          sync.Mutex
+	consecutive int
+	openedAt    time.Time
+}
+
+// NewBreaker returns a Breaker that opens after failures connection failures
+// in a row and, while open, pings the database every probe.
+func NewBreaker(failures int, probe time.Duration) *Breaker {
+	if probe <= 0 {
+		probe = defaultBreakerProbe
+	}
+	return &Breaker{failures: failures, probe: probe}
+}
+
+// Open reports whether the database is considered unreachable.
+func (b *Breaker) Open() bool {
+	return b != nil && b.open.Load()
+}
+
+// Watch makes the pool built from cfg report its connection failures to b.
+// It must be called before the pool is created.
+func (b *Breaker) Watch(cfg *pgxpool.Config) {
+	cfg.ConnConfig.Tracer = breakerTracer{b}
+}
+
+// Run pings pool every probe interval while b is open, closing it once a
+// ping succeeds, until ctx is done.
+func (b *Breaker) Run(ctx context.Context, pool *pgxpool.Pool) {
+	ticker := time.NewTicker(b.probe)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		if !b.Open() {
+			continue
+		}
+		pingCtx, cancel := context.WithTimeout(ctx, b.probe)
+		err := pool.Ping(pingCtx)
+		cancel()
+		if err == nil {
+			b.close(ctx)
+		}
+	}
+}
+
+// record counts err, the outcome of acquiring a connection or running a
+// query with one. Only an open-breaker probe closes it again, so a query
+// that slips through while Postgres flaps doesn't flip it back and forth.
+func (b *Breaker) record(ctx context.Context, err error) {
+	if b.failures <= 0 || ctx.Err() != nil {
+		// A caller that gave up says nothing about the database.
+		return
+	}
+	unavailable := isUnavailable(err)
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if !unavailable {
+		b.consecutive = 0
+		return
+	}
+	b.consecutive++
+	if b.consecutive < b.failures || b.open.Load() {
+		return
+	}
+	b.openedAt = time.Now()
+	b.open.Store(true)
+	slog.ErrorContext(ctx, "database unreachable; serving degraded responses until it answers", "failures", b.consecutive, "error", err)
+}
+
+func (b *Breaker) close(ctx context.Context) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if !b.open.Load() {
+		return
+	}
+	b.consecutive = 0
+	b.open.Store(false)
+	slog.InfoContext(ctx, "database reachable again", "down_for", time.Since(b.openedAt).Round(time.Second))
+}
+
+// isUnavailable reports whether err means Postgres couldn't be reached or
+// is refusing work, as opposed to a query failing.
+func isUnavailable(err error) bool {
+	if err == nil {
+		return false
+	}
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) {
+		// Class 08 is connection exceptions; the rest are the server shutting
+		// down, starting up, or out of connection slots.
+		switch pgErr.Code {
+		case "57P01", "57P02", "57P03", "53300":
+			return true
+		}
+		return strings.HasPrefix(pgErr.Code, "08")
+	}
+	var connectErr *pgconn.ConnectError
+	if errors.As(err, &connectErr) {
+		return true
+	}
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr) ||
+		errors.Is(err, net.ErrClosed) ||
+		errors.Is(err, io.EOF) ||
+		errors.Is(err, io.ErrUnexpectedEOF)
+}
+
+// breakerTracer reports acquired connections and finished queries to a
+// Breaker.
+type breakerTracer struct {
+	b *Breaker
+}
+
+var (
+	_ pgx.QueryTracer       = breakerTracer{}
+	_ pgxpool.AcquireTracer = breakerTracer{}
+)
+
+func (t breakerTracer) TraceQueryStart(ctx context.Context, _ *pgx.Conn, _ pgx.TraceQueryStartData) context.Context {
+	return ctx
+}
+
+func (t breakerTracer) TraceQueryEnd(ctx context.Context, _ *pgx.Conn, data pgx.TraceQueryEndData) {
+	t.b.record(ctx, data.Err)
+}
+
+func (t breakerTracer) TraceAcquireStart(ctx context.Context, _ *pgxpool.Pool, _ pgxpool.TraceAcquireStartData) context.Context {
+	return ctx
+}
+
+func (t breakerTracer) TraceAcquireEnd(ctx context.Context, _ *pgxpool.Pool, data pgxpool.TraceAcquireEndData) {
+	t.b.record(ctx, data.Err)
+}
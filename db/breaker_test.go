@@ -0,0 +1,76 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"testing"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+func TestIsUnavailable(t *testing.T) {
+	for _, tt := range []struct {
+		err  error
+		want bool
+	}{
+		{nil, false},
+		{pgx.ErrNoRows, false},
+		{&pgconn.PgError{Code: "23505"}, false},
+		{context.Canceled, false},
+		{&pgconn.PgError{Code: "57P01"}, true},
+		{&pgconn.PgError{Code: "08006"}, true},
+		{fmt.Errorf("reading: %w", io.ErrUnexpectedEOF), true},
+		{&pgconn.ConnectError{}, true},
+	} {
+		if got := isUnavailable(tt.err); got != tt.want {
+			t.Errorf("isUnavailable(%v) = %v, want %v", tt.err, got, tt.want)
+		}
+	}
+}
+
+func TestBreaker(t *testing.T) {
+	ctx := context.Background()
+	down := &pgconn.PgError{Code: "57P03"}
+	b := NewBreaker(3, 0)
+
+	b.record(ctx, down)
+	b.record(ctx, down)
+	b.record(ctx, errors.New("syntax error"))
+	b.record(ctx, down)
+	b.record(ctx, down)
+	if b.Open() {
+		t.Fatal("opened without 3 failures in a row")
+	}
+	b.record(ctx, down)
+	if !b.Open() {
+		t.Fatal("still closed after 3 failures in a row")
+	}
+
+	// Only a probe closes it.
+	b.record(ctx, nil)
+	if !b.Open() {
+		t.Fatal("closed by a query")
+	}
+	b.close(ctx)
+	if b.Open() {
+		t.Fatal("still open after a successful probe")
+	}
+
+	// Callers giving up don't count.
+	cancelled, cancel := context.WithCancel(ctx)
+	cancel()
+	for range 3 {
+		b.record(cancelled, down)
+	}
+	if b.Open() {
+		t.Fatal("opened by cancelled requests")
+	}
+
+	var nilBreaker *Breaker
+	if nilBreaker.Open() {
+		t.Fatal("nil breaker is open")
+	}
+}
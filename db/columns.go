@@ -0,0 +1,44 @@
+package db
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+// Columns returns the select list for T: the db tag of each of its fields, in
+// order, with the fields of embedded structs inlined and fields tagged "-"
+// left out. Rows selected with it scan into T with
+// pgx.RowToAddrOfStructByName, so adding a field and its column is one edit
+// rather than one per query.
+//
+// Columns panics if an exported field has no db tag. Call it when the package
+// is initialized so a missing tag fails at startup.
+func Columns[T any]() string {
+	return strings.Join(columns(reflect.TypeFor[T]()), ", ")
+}
+
+func columns(t reflect.Type) []string {
+	if t.Kind() != reflect.Struct {
+		panic(fmt.Sprintf("db.Columns: %s is not a struct", t))
+	}
+	var cols []string
+	for i := range t.NumField() {
+		f := t.Field(i)
+		if f.Anonymous && f.Type.Kind() == reflect.Struct {
+			cols = append(cols, columns(f.Type)...)
+			continue
+		}
+		if !f.IsExported() {
+			continue
+		}
+		name, ok := f.Tag.Lookup("db")
+		if !ok || name == "" {
+			panic(fmt.Sprintf("db.Columns: %s.%s has no db tag", t, f.Name))
+		}
+		if name != "-" {
+			cols = append(cols, name)
+		}
+	}
+	return cols
+}
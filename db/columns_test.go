@@ -0,0 +1,34 @@
+package db_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/cavenine/queryops/db"
+)
+
+func TestColumns(t *testing.T) {
+	type base struct {
+		ID        int       `db:"id"`
+		CreatedAt time.Time `db:"created_at"`
+	}
+	type row struct {
+		base
+		Name    string   `db:"name"`
+		Derived []string `db:"-"`
+	}
+
+	if got, want := db.Columns[row](), "id, created_at, name"; got != want {
+		t.Fatalf("Columns = %q, want %q", got, want)
+	}
+
+	type untagged struct {
+		Name string
+	}
+	defer func() {
+		if recover() == nil {
+			t.Fatalf("Columns accepted a field with no db tag")
+		}
+	}()
+	db.Columns[untagged]()
+}
@@ -11,7 +11,9 @@ import (
 	"github.com/jackc/pgx/v5/pgxpool"
 )
 
-func NewPool(ctx context.Context, cfg *config.Config) (*pgxpool.Pool, error) {
+// NewPool connects to cfg.DatabaseURL. If breaker isn't nil, it watches the
+// pool's connections; the caller runs it.
+func NewPool(ctx context.Context, cfg *config.Config, breaker *Breaker) (*pgxpool.Pool, error) {
 	if cfg.DatabaseURL == "" {
 		return nil, errors.New("database url is empty; set DATABASE_URL")
 	}
@@ -27,6 +29,9 @@ func NewPool(ctx context.Context, cfg *config.Config) (*pgxpool.Pool, error) {
 	if cfg.DatabaseMaxConns > 0 {
 		poolConfig.MaxConns = cfg.DatabaseMaxConns
 	}
+	if breaker != nil {
+		breaker.Watch(poolConfig)
+	}
 
 	pool, err := pgxpool.NewWithConfig(ctx, poolConfig)
 	if err != nil {
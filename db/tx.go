@@ -0,0 +1,34 @@
+package db
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// TxStarter begins transactions. *pgxpool.Pool is one, and so is pgx.Tx,
+// whose Begin starts a savepoint, so WithTx nests.
+type TxStarter interface {
+	Begin(ctx context.Context) (pgx.Tx, error)
+}
+
+// WithTx runs fn in a transaction, committing it if fn returns nil and
+// rolling it back if fn fails or panics. Repository methods ending in Tx take
+// the transaction, so fn can combine writes to several repositories into one
+// commit instead of one each.
+func WithTx(ctx context.Context, db TxStarter, fn func(tx pgx.Tx) error) error {
+	tx, err := db.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("starting transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	if err := fn(tx); err != nil {
+		return err
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return fmt.Errorf("committing transaction: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,86 @@
+package db_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/jackc/pgx/v5"
+
+	"github.com/cavenine/queryops/db"
+	"github.com/cavenine/queryops/internal/testdb"
+)
+
+func TestMain(m *testing.M) {
+	testdb.RunWithPostgres(m)
+}
+
+func TestWithTx(t *testing.T) {
+	tdb := testdb.SetupTestDB(t)
+	ctx := context.Background()
+
+	if _, err := tdb.Pool.Exec(ctx, `CREATE TABLE tx_test (name text PRIMARY KEY)`); err != nil {
+		t.Fatalf("creating table: %v", err)
+	}
+	insert := func(tx pgx.Tx, name string) error {
+		_, err := tx.Exec(ctx, `INSERT INTO tx_test (name) VALUES ($1)`, name)
+		return err
+	}
+	names := func() []string {
+		t.Helper()
+		rows, _ := tdb.Pool.Query(ctx, `SELECT name FROM tx_test ORDER BY name`)
+		got, err := pgx.CollectRows(rows, pgx.RowTo[string])
+		if err != nil {
+			t.Fatalf("listing names: %v", err)
+		}
+		return got
+	}
+
+	err := db.WithTx(ctx, tdb.Pool, func(tx pgx.Tx) error {
+		if err := insert(tx, "a"); err != nil {
+			return err
+		}
+		return insert(tx, "b")
+	})
+	if err != nil {
+		t.Fatalf("WithTx: %v", err)
+	}
+
+	failed := errors.New("second write failed")
+	err = db.WithTx(ctx, tdb.Pool, func(tx pgx.Tx) error {
+		if err := insert(tx, "c"); err != nil {
+			return err
+		}
+		return failed
+	})
+	if !errors.Is(err, failed) {
+		t.Fatalf("WithTx err = %v, want %v", err, failed)
+	}
+
+	func() {
+		defer func() { _ = recover() }()
+		_ = db.WithTx(ctx, tdb.Pool, func(tx pgx.Tx) error {
+			_ = insert(tx, "d")
+			panic("boom")
+		})
+	}()
+
+	// Nested, a failure rolls back to the savepoint only.
+	err = db.WithTx(ctx, tdb.Pool, func(tx pgx.Tx) error {
+		if err := insert(tx, "e"); err != nil {
+			return err
+		}
+		_ = db.WithTx(ctx, tx, func(tx pgx.Tx) error {
+			_ = insert(tx, "f")
+			return failed
+		})
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("nested WithTx: %v", err)
+	}
+
+	if got := names(); len(got) != 3 || got[0] != "a" || got[1] != "b" || got[2] != "e" {
+		t.Fatalf("committed names = %v, want [a b e]", got)
+	}
+}
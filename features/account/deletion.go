@@ -0,0 +1,135 @@
+package account
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/cavenine/queryops/features/auth"
+	"github.com/cavenine/queryops/features/auth/services"
+	"github.com/cavenine/queryops/internal/validate"
+)
+
+// RequestAccountDeletion emails the user a link that confirms deleting their
+// account. Owners must hand over or delete their organizations first.
+func (h *Handlers) RequestAccountDeletion(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	user := auth.GetUserFromContext(ctx)
+	if user == nil {
+		http.Redirect(w, r, "/login", http.StatusSeeOther)
+		return
+	}
+	if user.ImpersonatedBy != nil {
+		h.renderAccount(w, r, http.StatusForbidden, accountErrors{deletion: "You can't delete an account while impersonating its user"})
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		h.renderAccount(w, r, http.StatusUnprocessableEntity, accountErrors{deletion: "Invalid form data"})
+		return
+	}
+
+	token, err := h.deletions.Request(ctx, user, h.reauth(r))
+	if fields, ok := validate.As(err); ok {
+		h.renderAccount(w, r, http.StatusUnprocessableEntity, accountErrors{deletionFields: fields})
+		return
+	}
+	if errors.Is(err, services.ErrAccountDeletionScheduled) {
+		h.renderAccount(w, r, http.StatusConflict, accountErrors{deletion: "Your account is already scheduled for deletion."})
+		return
+	}
+	if err != nil {
+		slog.ErrorContext(ctx, "failed to request account deletion", "error", err)
+		h.renderAccount(w, r, http.StatusInternalServerError, accountErrors{deletion: "Failed to request account deletion"})
+		return
+	}
+
+	link := h.linkURL(r, "/account/delete/confirm", token)
+	body := fmt.Sprintf("Someone asked to delete your QueryOps account.\n\n"+
+		"To confirm, sign in to QueryOps and open this link within %d hours:\n\n%s\n\n"+
+		"Your account will be deleted %s after you confirm, and you can cancel until then. "+
+		"If you didn't ask for this, ignore this email and change your password.\n",
+		int(services.AccountDeletionLinkTTL.Hours()), link, gracePeriodLabel(h.deletions.GracePeriod()))
+	if err := h.mailer.Send(ctx, []string{user.Email}, "Confirm deleting your QueryOps account", body); err != nil {
+		slog.ErrorContext(ctx, "failed to send account deletion confirmation", "error", err)
+		h.renderAccount(w, r, http.StatusBadGateway, accountErrors{deletion: "Failed to send the confirmation email. Try again later."})
+		return
+	}
+	auth.RecordSecurityEvent(r, h.events, user.ID, services.EventAccountDeletionRequested)
+
+	h.sessionManager.Put(ctx, noticeKey, "We sent a link to "+user.Email+". Open it to confirm deleting your account.")
+	http.Redirect(w, r, "/account", http.StatusSeeOther)
+}
+
+// ConfirmAccountDeletion schedules the account for deletion from the link
+// emailed to the user. The user must be signed in to the account.
+func (h *Handlers) ConfirmAccountDeletion(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	user := auth.GetUserFromContext(ctx)
+	if user == nil {
+		http.Redirect(w, r, "/login", http.StatusSeeOther)
+		return
+	}
+
+	deletion, err := h.deletions.Confirm(ctx, user.ID, r.URL.Query().Get("token"))
+	if errors.Is(err, services.ErrAccountDeletionNotFound) {
+		h.renderAccount(w, r, http.StatusBadRequest, accountErrors{deletion: "This link is invalid or has expired. Request a new one below."})
+		return
+	}
+	if err != nil {
+		slog.ErrorContext(ctx, "failed to confirm account deletion", "error", err)
+		h.renderAccount(w, r, http.StatusInternalServerError, accountErrors{deletion: "Failed to confirm account deletion"})
+		return
+	}
+	auth.RecordSecurityEvent(r, h.events, user.ID, services.EventAccountDeletionConfirmed)
+	slog.InfoContext(ctx, "account deletion confirmed", "user_id", user.ID, "delete_after", *deletion.DeleteAfter)
+
+	when := deletion.DeleteAfter.UTC().Format("January 2, 2006 15:04 MST")
+	notice := fmt.Sprintf("Your QueryOps account will be deleted on %s.\n\n"+
+		"To keep it, sign in and cancel the deletion from your account settings before then.\n", when)
+	if err := h.mailer.Send(ctx, []string{user.Email}, "Your QueryOps account will be deleted", notice); err != nil {
+		slog.WarnContext(ctx, "failed to send account deletion notice", "error", err)
+	}
+
+	h.sessionManager.Put(ctx, noticeKey, "Your account will be deleted on "+when+". You can cancel until then.")
+	http.Redirect(w, r, "/account", http.StatusSeeOther)
+}
+
+// CancelAccountDeletion keeps the account, whether or not its deletion was
+// confirmed.
+func (h *Handlers) CancelAccountDeletion(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	user := auth.GetUserFromContext(ctx)
+	if user == nil {
+		http.Redirect(w, r, "/login", http.StatusSeeOther)
+		return
+	}
+
+	err := h.deletions.Cancel(ctx, user.ID)
+	if errors.Is(err, services.ErrAccountDeletionNotFound) {
+		http.Redirect(w, r, "/account", http.StatusSeeOther)
+		return
+	}
+	if err != nil {
+		slog.ErrorContext(ctx, "failed to cancel account deletion", "error", err)
+		h.renderAccount(w, r, http.StatusInternalServerError, accountErrors{deletion: "Failed to cancel account deletion"})
+		return
+	}
+	auth.RecordSecurityEvent(r, h.events, user.ID, services.EventAccountDeletionCancelled)
+	slog.InfoContext(ctx, "account deletion cancelled", "user_id", user.ID)
+
+	h.sessionManager.Put(ctx, noticeKey, "Your account will not be deleted.")
+	http.Redirect(w, r, "/account", http.StatusSeeOther)
+}
+
+// gracePeriodLabel describes d in days, or in hours if it's under two days.
+func gracePeriodLabel(d time.Duration) string {
+	if d < 48*time.Hour {
+		return fmt.Sprintf("%d hours", int(d.Hours()))
+	}
+	return fmt.Sprintf("%d days", int(d.Hours()/24))
+}
@@ -3,30 +3,83 @@ package account
 import (
 	"encoding/base64"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"log/slog"
 	"net/http"
+	"net/url"
+	"strings"
 
 	"github.com/cavenine/queryops/features/account/pages"
 	"github.com/cavenine/queryops/features/auth"
 	"github.com/cavenine/queryops/features/auth/services"
+	"github.com/cavenine/queryops/internal/notify"
+	"github.com/cavenine/queryops/internal/validate"
 
+	"github.com/alexedwards/scs/v2"
 	"github.com/go-chi/chi/v5"
 )
 
+// noticeKey holds the confirmation shown once on the next account page load.
+const noticeKey = "account_notice"
+
+// securityEventLimit is how many security events the account page lists.
+const securityEventLimit = 20
+
+// impersonatingMessage explains why credentials can't be changed while a
+// superuser is impersonating the user.
+const impersonatingMessage = "You can't change credentials while impersonating a user"
+
+// accountErrors are the form errors shown on the account page: a message
+// above a form, or problems with its fields below it.
+type accountErrors struct {
+	password       string
+	passwordFields validate.Errors
+	email          string
+	emailFields    validate.Errors
+	deletion       string
+	deletionFields validate.Errors
+}
+
 // Handlers contains the HTTP handlers for account management.
 type Handlers struct {
 	credentialRepo *services.CredentialRepository
+	userService    *services.UserService
+	events         *services.SecurityEventRepository
+	deletions      *services.AccountDeletionService
+	sessionManager *scs.SessionManager
+	mailer         notify.Mailer
+	// secureLinks makes emailed links https even when the request reached
+	// this server over plain http, as behind a TLS-terminating proxy.
+	secureLinks bool
 }
 
 // NewHandlers creates a new Handlers instance.
-func NewHandlers(credentialRepo *services.CredentialRepository) *Handlers {
+func NewHandlers(
+	credentialRepo *services.CredentialRepository,
+	userService *services.UserService,
+	events *services.SecurityEventRepository,
+	sessionManager *scs.SessionManager,
+	mailer notify.Mailer,
+) *Handlers {
 	return &Handlers{
 		credentialRepo: credentialRepo,
+		userService:    userService,
+		events:         events,
+		sessionManager: sessionManager,
+		mailer:         mailer,
 	}
 }
 
 // AccountPage renders the account settings page.
 func (h *Handlers) AccountPage(w http.ResponseWriter, r *http.Request) {
+	h.renderAccount(w, r, http.StatusOK, accountErrors{})
+}
+
+// ChangePassword sets a new password for the user once they have entered
+// their current one or recently signed in with a passkey, then signs out
+// their other sessions.
+func (h *Handlers) ChangePassword(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
 
 	user := auth.GetUserFromContext(ctx)
@@ -34,16 +87,118 @@ func (h *Handlers) AccountPage(w http.ResponseWriter, r *http.Request) {
 		http.Redirect(w, r, "/login", http.StatusSeeOther)
 		return
 	}
+	if user.ImpersonatedBy != nil {
+		h.renderAccount(w, r, http.StatusForbidden, accountErrors{password: impersonatingMessage})
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		h.renderAccount(w, r, http.StatusUnprocessableEntity, accountErrors{password: "Invalid form data"})
+		return
+	}
 
-	passkeys, err := h.credentialRepo.GetPasskeysByUserID(ctx, user.ID)
+	validAfter, err := h.userService.ChangePassword(ctx, user, h.reauth(r), r.FormValue("new_password"))
+	if fields, ok := validate.As(err); ok {
+		h.renderAccount(w, r, http.StatusUnprocessableEntity, accountErrors{passwordFields: fields})
+		return
+	}
 	if err != nil {
-		http.Error(w, "Failed to load passkeys", http.StatusInternalServerError)
+		slog.ErrorContext(ctx, "failed to change password", "error", err)
+		h.renderAccount(w, r, http.StatusInternalServerError, accountErrors{password: "Failed to change password"})
+		return
+	}
+	if err := auth.KeepSessionAfterPasswordChange(ctx, h.sessionManager, validAfter); err != nil {
+		slog.ErrorContext(ctx, "failed to renew session after password change", "error", err)
+		http.Redirect(w, r, "/login", http.StatusSeeOther)
 		return
 	}
+	auth.RecordSecurityEvent(r, h.events, user.ID, services.EventPasswordChanged)
+	slog.InfoContext(ctx, "password changed", "user_id", user.ID)
 
-	if err := pages.AccountPage(user.Email, passkeys).Render(ctx, w); err != nil {
-		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+	h.sessionManager.Put(ctx, noticeKey, "Password changed. Your other sessions have been signed out.")
+	http.Redirect(w, r, "/account", http.StatusSeeOther)
+}
+
+// RequestEmailChange sends a link to the new address that completes the
+// change, and tells the current address about it.
+func (h *Handlers) RequestEmailChange(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	user := auth.GetUserFromContext(ctx)
+	if user == nil {
+		http.Redirect(w, r, "/login", http.StatusSeeOther)
+		return
+	}
+	if user.ImpersonatedBy != nil {
+		h.renderAccount(w, r, http.StatusForbidden, accountErrors{email: impersonatingMessage})
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		h.renderAccount(w, r, http.StatusUnprocessableEntity, accountErrors{email: "Invalid form data"})
+		return
+	}
+
+	newEmail := strings.TrimSpace(r.FormValue("email"))
+	token, err := h.userService.RequestEmailChange(ctx, user, h.reauth(r), newEmail)
+	if fields, ok := validate.As(err); ok {
+		h.renderAccount(w, r, http.StatusUnprocessableEntity, accountErrors{emailFields: fields})
+		return
+	}
+	if err != nil {
+		slog.ErrorContext(ctx, "failed to request email change", "error", err)
+		h.renderAccount(w, r, http.StatusInternalServerError, accountErrors{email: "Failed to change email"})
+		return
+	}
+
+	link := h.linkURL(r, "/account/email/verify", token)
+	body := fmt.Sprintf("Someone asked to move a QueryOps account to this email address.\n\n"+
+		"To confirm, sign in to QueryOps and open this link within %d hours:\n\n%s\n\n"+
+		"If you didn't ask for this, ignore this email.\n",
+		int(services.EmailChangeTTL.Hours()), link)
+	if err := h.mailer.Send(ctx, []string{newEmail}, "Confirm your new QueryOps email address", body); err != nil {
+		slog.ErrorContext(ctx, "failed to send email change verification", "error", err)
+		h.renderAccount(w, r, http.StatusBadGateway, accountErrors{email: "Failed to send the confirmation email. Try again later."})
+		return
+	}
+	notice := fmt.Sprintf("Your QueryOps account is being moved to %s. It moves once that address confirms.\n\n"+
+		"If you didn't ask for this, change your password.\n", newEmail)
+	if err := h.mailer.Send(ctx, []string{user.Email}, "Your QueryOps email address is changing", notice); err != nil {
+		slog.WarnContext(ctx, "failed to tell old address about email change", "error", err)
 	}
+	auth.RecordSecurityEvent(r, h.events, user.ID, services.EventEmailChangeRequested)
+
+	h.sessionManager.Put(ctx, noticeKey, "We sent a link to "+newEmail+". Open it to finish changing your email.")
+	http.Redirect(w, r, "/account", http.StatusSeeOther)
+}
+
+// VerifyEmailChange completes an email change from the link sent to the new
+// address. The user must be signed in to the account being changed.
+func (h *Handlers) VerifyEmailChange(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	user := auth.GetUserFromContext(ctx)
+	if user == nil {
+		http.Redirect(w, r, "/login", http.StatusSeeOther)
+		return
+	}
+
+	email, err := h.userService.ConfirmEmailChange(ctx, user.ID, r.URL.Query().Get("token"))
+	switch {
+	case errors.Is(err, services.ErrEmailChangeNotFound):
+		h.renderAccount(w, r, http.StatusBadRequest, accountErrors{email: "This link is invalid or has expired. Request a new one below."})
+		return
+	case errors.Is(err, services.ErrEmailTaken):
+		h.renderAccount(w, r, http.StatusConflict, accountErrors{email: "Another account registered that address in the meantime."})
+		return
+	case err != nil:
+		slog.ErrorContext(ctx, "failed to confirm email change", "error", err)
+		h.renderAccount(w, r, http.StatusInternalServerError, accountErrors{email: "Failed to change email"})
+		return
+	}
+	auth.RecordSecurityEvent(r, h.events, user.ID, services.EventEmailChanged)
+	slog.InfoContext(ctx, "email changed", "user_id", user.ID)
+
+	h.sessionManager.Put(ctx, noticeKey, "Your email is now "+email+".")
+	http.Redirect(w, r, "/account", http.StatusSeeOther)
 }
 
 // DeletePasskey removes a passkey.
@@ -89,10 +244,90 @@ func (h *Handlers) DeletePasskey(w http.ResponseWriter, r *http.Request) {
 		jsonError(w, "Passkey not found", http.StatusNotFound)
 		return
 	}
+	auth.RecordSecurityEvent(r, h.events, user.ID, services.EventPasskeyRemoved)
 
 	jsonSuccess(w, map[string]bool{"success": true})
 }
 
+// reauth is how the request proves it's the user: the current_password form
+// field, or a recent passkey sign-in.
+func (h *Handlers) reauth(r *http.Request) services.Reauth {
+	return services.Reauth{
+		CurrentPassword: r.FormValue("current_password"),
+		RecentPasskey:   auth.RecentPasskeyAuth(r.Context(), h.sessionManager),
+	}
+}
+
+// linkURL is the emailed link to path on this server that passes token.
+func (h *Handlers) linkURL(r *http.Request, path, token string) string {
+	scheme := "http"
+	if r.TLS != nil || h.secureLinks {
+		scheme = "https"
+	}
+	u := url.URL{
+		Scheme:   scheme,
+		Host:     r.Host,
+		Path:     path,
+		RawQuery: url.Values{"token": {token}}.Encode(),
+	}
+	return u.String()
+}
+
+func (h *Handlers) renderAccount(w http.ResponseWriter, r *http.Request, status int, formErrors accountErrors) {
+	ctx := r.Context()
+
+	user := auth.GetUserFromContext(ctx)
+	if user == nil {
+		http.Redirect(w, r, "/login", http.StatusSeeOther)
+		return
+	}
+
+	passkeys, err := h.credentialRepo.GetPasskeysByUserID(ctx, user.ID)
+	if err != nil {
+		http.Error(w, "Failed to load passkeys", http.StatusInternalServerError)
+		return
+	}
+	pendingEmail, err := h.userService.GetPendingEmail(ctx, user.ID)
+	if err != nil {
+		slog.ErrorContext(ctx, "failed to load pending email change", "error", err)
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+	events, err := h.events.ListRecent(ctx, user.ID, securityEventLimit)
+	if err != nil {
+		slog.ErrorContext(ctx, "failed to load security events", "error", err)
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+	deletion, err := h.deletions.Get(ctx, user.ID)
+	if err != nil {
+		slog.ErrorContext(ctx, "failed to load account deletion", "error", err)
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(status)
+	if err := pages.AccountPage(pages.AccountProps{
+		Email:             user.Email,
+		Passkeys:          passkeys,
+		HasPassword:       user.HasPassword(),
+		RecentPasskeyAuth: auth.RecentPasskeyAuth(ctx, h.sessionManager),
+		PendingEmail:      pendingEmail,
+		Events:            events,
+		Notice:            h.sessionManager.PopString(ctx, noticeKey),
+		PasswordError:     formErrors.password,
+		PasswordFields:    formErrors.passwordFields,
+		EmailError:        formErrors.email,
+		EmailFields:       formErrors.emailFields,
+		Deletion:          deletion,
+		DeletionGrace:     gracePeriodLabel(h.deletions.GracePeriod()),
+		DeletionError:     formErrors.deletion,
+		DeletionFields:    formErrors.deletionFields,
+	}).Render(ctx, w); err != nil {
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+	}
+}
+
 // jsonError writes an error response as JSON.
 func jsonError(w http.ResponseWriter, message string, status int) {
 	w.Header().Set("Content-Type", "application/json")
@@ -1,16 +1,47 @@
 package account
 
 import (
-	"github.com/cavenine/queryops/features/auth/services"
+	"github.com/cavenine/queryops/config"
+	"github.com/cavenine/queryops/features/auth"
+	"github.com/cavenine/queryops/internal/notify"
 
+	"github.com/alexedwards/scs/v2"
 	"github.com/go-chi/chi/v5"
 )
 
+type Feature struct {
+	handlers *Handlers
+}
+
+// NewFeature wires the account feature from the auth feature's services.
+func NewFeature(authFeature *auth.Feature, sessionManager *scs.SessionManager) *Feature {
+	handlers := NewHandlers(
+		authFeature.CredentialRepo(),
+		authFeature.UserService(),
+		authFeature.SecurityEvents(),
+		sessionManager,
+		notify.NewMailer(notify.SMTPConfig{
+			Addr:     config.Global.SMTPAddr,
+			Username: config.Global.SMTPUsername,
+			Password: config.Global.SMTPPassword,
+			From:     config.Global.SMTPFrom,
+		}),
+	)
+	handlers.deletions = authFeature.AccountDeletions()
+	handlers.secureLinks = config.Global.Environment == config.Prod
+
+	return &Feature{handlers: handlers}
+}
+
 // SetupRoutes registers account routes.
 // These routes require authentication and should be mounted in the protected group.
-func SetupRoutes(router chi.Router, credentialRepo *services.CredentialRepository) {
-	handlers := NewHandlers(credentialRepo)
-
-	router.Get("/account", handlers.AccountPage)
-	router.Delete("/account/passkey/{id}", handlers.DeletePasskey)
+func (f *Feature) SetupRoutes(router chi.Router) {
+	router.Get("/account", f.handlers.AccountPage)
+	router.Post("/account/password", f.handlers.ChangePassword)
+	router.Post("/account/email", f.handlers.RequestEmailChange)
+	router.Get("/account/email/verify", f.handlers.VerifyEmailChange)
+	router.Delete("/account/passkey/{id}", f.handlers.DeletePasskey)
+	router.Post("/account/delete", f.handlers.RequestAccountDeletion)
+	router.Get("/account/delete/confirm", f.handlers.ConfirmAccountDeletion)
+	router.Post("/account/delete/cancel", f.handlers.CancelAccountDeletion)
 }
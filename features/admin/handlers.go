@@ -0,0 +1,343 @@
+package admin
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/alexedwards/scs/v2"
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+
+	"github.com/cavenine/queryops/features/admin/pages"
+	"github.com/cavenine/queryops/features/admin/services"
+	"github.com/cavenine/queryops/features/auth"
+	orgServices "github.com/cavenine/queryops/features/organization/services"
+	osqueryPages "github.com/cavenine/queryops/features/osquery/pages"
+	"github.com/cavenine/queryops/internal/validate"
+)
+
+type adminStore interface {
+	ListOrganizations(ctx context.Context) ([]services.OrganizationSummary, error)
+	SetOrganizationDisabled(ctx context.Context, actorID int, organizationID uuid.UUID, disabled bool) error
+	ListPlans(ctx context.Context) ([]services.PlanSummary, error)
+	SetOrganizationPlan(ctx context.Context, actorID int, organizationID uuid.UUID, planID string, extraFeatures []string) error
+	ListAuditLog(ctx context.Context, limit int) ([]services.AuditEntry, error)
+	Health(ctx context.Context, onlineSince time.Time) (*services.SystemHealth, error)
+	StartImpersonation(ctx context.Context, actorID int, email string) (int, error)
+	StopImpersonation(ctx context.Context, actorID, impersonatedID int) error
+	RecordImpersonatedRequest(ctx context.Context, actorID, impersonatedID int, method, path string) error
+}
+
+type Handlers struct {
+	store          adminStore
+	sessionManager *scs.SessionManager
+
+	// liveStreams are the route patterns of the GET routes marked
+	// auth.LiveStream; see SkipLiveStreams.
+	liveStreams map[string]bool
+}
+
+func NewHandlers(store adminStore, sessionManager *scs.SessionManager) *Handlers {
+	return &Handlers{store: store, sessionManager: sessionManager}
+}
+
+// AdminPage lists every organization alongside system health and the most
+// recent admin actions.
+func (h *Handlers) AdminPage(w http.ResponseWriter, r *http.Request) {
+	h.renderAdmin(w, r, http.StatusOK, "", nil)
+}
+
+// renderAdmin renders the admin page. impersonateErr is shown above the
+// impersonation form and fields below it.
+func (h *Handlers) renderAdmin(w http.ResponseWriter, r *http.Request, status int, impersonateErr string, fields validate.Errors) {
+	ctx := r.Context()
+
+	orgs, err := h.store.ListOrganizations(ctx)
+	if err != nil {
+		slog.ErrorContext(ctx, "failed to list organizations", "error", err)
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+	plans, err := h.store.ListPlans(ctx)
+	if err != nil {
+		slog.ErrorContext(ctx, "failed to list plans", "error", err)
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+	audit, err := h.store.ListAuditLog(ctx, services.AuditLogLimit)
+	if err != nil {
+		slog.ErrorContext(ctx, "failed to list audit log", "error", err)
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	// An unhealthy system is what the page is for, so a failed health check
+	// is shown rather than failing the page.
+	health, err := h.store.Health(ctx, time.Now().Add(-osqueryPages.HostOnlineWindow))
+	healthErr := ""
+	if err != nil {
+		slog.ErrorContext(ctx, "failed to check system health", "error", err)
+		healthErr = err.Error()
+	}
+
+	props := pages.AdminProps{
+		Organizations:     orgs,
+		Plans:             plans,
+		Features:          orgServices.Features,
+		Health:            health,
+		HealthError:       healthErr,
+		AuditLog:          audit,
+		ImpersonateError:  impersonateErr,
+		ImpersonateFields: fields,
+	}
+	w.WriteHeader(status)
+	if err := pages.AdminPage(props).Render(ctx, w); err != nil {
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+	}
+}
+
+// DisableOrganization hides an organization from its members and stops new
+// hosts enrolling in it.
+func (h *Handlers) DisableOrganization(w http.ResponseWriter, r *http.Request) {
+	h.setDisabled(w, r, true)
+}
+
+// EnableOrganization reverses DisableOrganization.
+func (h *Handlers) EnableOrganization(w http.ResponseWriter, r *http.Request) {
+	h.setDisabled(w, r, false)
+}
+
+func (h *Handlers) setDisabled(w http.ResponseWriter, r *http.Request, disabled bool) {
+	ctx := r.Context()
+	user := auth.GetUserFromContext(ctx)
+	if user == nil {
+		slog.ErrorContext(ctx, "missing user in context")
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+	orgID, err := uuid.Parse(chi.URLParam(r, "id"))
+	if err != nil {
+		http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+		return
+	}
+
+	if err := h.store.SetOrganizationDisabled(ctx, user.ID, orgID, disabled); err != nil {
+		if errors.Is(err, services.ErrOrganizationNotFound) {
+			http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+			return
+		}
+		slog.ErrorContext(ctx, "failed to update organization", "error", err)
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+	slog.InfoContext(ctx, "organization disabled state changed",
+		"organization_id", orgID,
+		"disabled", disabled,
+		"actor_user_id", user.ID,
+	)
+
+	http.Redirect(w, r, "/admin", http.StatusSeeOther)
+}
+
+// SetOrganizationPlan puts an organization on a plan and grants it features
+// beyond the plan's.
+func (h *Handlers) SetOrganizationPlan(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	user := auth.GetUserFromContext(ctx)
+	if user == nil {
+		slog.ErrorContext(ctx, "missing user in context")
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+	orgID, err := uuid.Parse(chi.URLParam(r, "id"))
+	if err != nil {
+		http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "Invalid form data", http.StatusUnprocessableEntity)
+		return
+	}
+
+	planID := r.FormValue("plan")
+	features := r.Form["features"]
+	for _, f := range features {
+		if !slices.Contains(orgServices.Features, f) {
+			http.Error(w, "Unknown feature "+f, http.StatusUnprocessableEntity)
+			return
+		}
+	}
+
+	if err := h.store.SetOrganizationPlan(ctx, user.ID, orgID, planID, features); err != nil {
+		switch {
+		case errors.Is(err, services.ErrOrganizationNotFound):
+			http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+			return
+		case errors.Is(err, services.ErrPlanNotFound):
+			http.Error(w, "Unknown plan", http.StatusUnprocessableEntity)
+			return
+		}
+		slog.ErrorContext(ctx, "failed to set organization plan", "error", err)
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+	slog.InfoContext(ctx, "organization plan set",
+		"organization_id", orgID,
+		"plan", planID,
+		"extra_features", features,
+		"actor_user_id", user.ID,
+	)
+
+	http.Redirect(w, r, "/admin", http.StatusSeeOther)
+}
+
+// StartImpersonation signs the superuser in as another user so support can
+// see what they see. Every request until StopImpersonation is audited; see
+// AuditImpersonation.
+func (h *Handlers) StartImpersonation(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	user := auth.GetUserFromContext(ctx)
+	if user == nil {
+		slog.ErrorContext(ctx, "missing user in context")
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		h.renderAdmin(w, r, http.StatusUnprocessableEntity, "Invalid form data", nil)
+		return
+	}
+
+	email := strings.TrimSpace(r.FormValue("email"))
+	fields := validate.Errors{}
+	fields.Field("email", email, validate.Required(), validate.Email())
+	if len(fields) > 0 {
+		h.renderAdmin(w, r, http.StatusUnprocessableEntity, "", fields)
+		return
+	}
+
+	targetID, err := h.store.StartImpersonation(ctx, user.ID, email)
+	if err != nil {
+		switch {
+		case errors.Is(err, services.ErrUserNotFound):
+			h.renderAdmin(w, r, http.StatusUnprocessableEntity, "", validate.Errors{"email": "No user has that email"})
+			return
+		case errors.Is(err, services.ErrCannotImpersonate):
+			h.renderAdmin(w, r, http.StatusUnprocessableEntity, err.Error(), nil)
+			return
+		}
+		slog.ErrorContext(ctx, "failed to start impersonation", "error", err)
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+	if err := auth.StartImpersonation(ctx, h.sessionManager, user.ID, targetID); err != nil {
+		slog.ErrorContext(ctx, "failed to start impersonation session", "error", err)
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+	slog.InfoContext(ctx, "impersonation started",
+		"actor_user_id", user.ID,
+		"impersonated_user_id", targetID,
+	)
+
+	http.Redirect(w, r, "/", http.StatusSeeOther)
+}
+
+// StopImpersonation returns an impersonating superuser to their own session.
+// It is reachable by the impersonated user, since that is who the session is
+// signed in as.
+func (h *Handlers) StopImpersonation(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	user := auth.GetUserFromContext(ctx)
+	if user == nil || user.ImpersonatedBy == nil {
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+		return
+	}
+
+	if err := h.store.StopImpersonation(ctx, user.ImpersonatedBy.ID, user.ID); err != nil {
+		slog.ErrorContext(ctx, "failed to record end of impersonation", "error", err)
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+	if err := auth.StopImpersonation(ctx, h.sessionManager); err != nil {
+		slog.ErrorContext(ctx, "failed to stop impersonation session", "error", err)
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+	slog.InfoContext(ctx, "impersonation stopped",
+		"actor_user_id", user.ImpersonatedBy.ID,
+		"impersonated_user_id", user.ID,
+	)
+
+	http.Redirect(w, r, "/admin", http.StatusSeeOther)
+}
+
+// stopImpersonationPath is audited by StopImpersonation itself.
+const stopImpersonationPath = "/admin/impersonation/stop"
+
+// AuditImpersonation records every request made while a superuser
+// impersonates a user, naming both: changes and page loads alike, so the log
+// shows what they saw as well as what they did. The live streams behind
+// pages and CORS preflights aren't recorded; a page holds its streams open
+// and reconnects them, and they'd bury the rest. A request that can't be
+// recorded is refused. It must run after auth.RequireAuth.
+func (h *Handlers) AuditImpersonation(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user := auth.GetUserFromContext(r.Context())
+		if user == nil || user.ImpersonatedBy == nil || !h.audited(r) || r.URL.Path == stopImpersonationPath {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if err := h.store.RecordImpersonatedRequest(r.Context(), user.ImpersonatedBy.ID, user.ID, r.Method, r.URL.Path); err != nil {
+			slog.ErrorContext(r.Context(), "failed to audit impersonated request", "error", err)
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// audited reports whether AuditImpersonation records r. Reads of a route
+// marked auth.LiveStream are the streams behind a page, whose load has
+// already been recorded. Which routes those are is decided here, by the
+// route r matches, rather than by anything the client sends.
+func (h *Handlers) audited(r *http.Request) bool {
+	switch r.Method {
+	case http.MethodOptions:
+		return false
+	case http.MethodGet, http.MethodHead:
+		return !h.liveStreams[routePattern(r)]
+	default:
+		return true
+	}
+}
+
+// SkipLiveStreams has AuditImpersonation skip reads of routes registered on
+// routes with auth.LiveStream. Call it once every route is registered.
+func (h *Handlers) SkipLiveStreams(routes chi.Routes) error {
+	h.liveStreams = make(map[string]bool)
+	return chi.Walk(routes, func(method, route string, handler http.Handler, _ ...func(http.Handler) http.Handler) error {
+		if method == http.MethodGet && auth.IsLiveStream(handler) {
+			h.liveStreams[strings.TrimSuffix(route, "/")] = true
+		}
+		return nil
+	})
+}
+
+// routePattern returns the pattern of the route r matches, or "" if none.
+func routePattern(r *http.Request) string {
+	rctx := chi.RouteContext(r.Context())
+	if rctx == nil || rctx.Routes == nil {
+		return ""
+	}
+	match := chi.NewRouteContext()
+	if !rctx.Routes.Match(match, http.MethodGet, r.URL.Path) {
+		return ""
+	}
+	return strings.TrimSuffix(match.RoutePattern(), "/")
+}
@@ -0,0 +1,204 @@
+package admin_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+
+	"github.com/cavenine/queryops/features/admin"
+	"github.com/cavenine/queryops/features/admin/services"
+	"github.com/cavenine/queryops/features/auth"
+	authServices "github.com/cavenine/queryops/features/auth/services"
+)
+
+type recordedRequest struct {
+	actorID, impersonatedID int
+	method, path            string
+}
+
+type stubStore struct {
+	requests []recordedRequest
+	err      error
+}
+
+func (s *stubStore) ListOrganizations(context.Context) ([]services.OrganizationSummary, error) {
+	return nil, nil
+}
+
+func (s *stubStore) SetOrganizationDisabled(context.Context, int, uuid.UUID, bool) error {
+	return nil
+}
+
+func (s *stubStore) ListPlans(context.Context) ([]services.PlanSummary, error) {
+	return nil, nil
+}
+
+func (s *stubStore) SetOrganizationPlan(context.Context, int, uuid.UUID, string, []string) error {
+	return nil
+}
+
+func (s *stubStore) ListAuditLog(context.Context, int) ([]services.AuditEntry, error) {
+	return nil, nil
+}
+
+func (s *stubStore) Health(context.Context, time.Time) (*services.SystemHealth, error) {
+	return &services.SystemHealth{}, nil
+}
+
+func (s *stubStore) StartImpersonation(context.Context, int, string) (int, error) {
+	return 0, nil
+}
+
+func (s *stubStore) StopImpersonation(context.Context, int, int) error {
+	return nil
+}
+
+func (s *stubStore) RecordImpersonatedRequest(_ context.Context, actorID, impersonatedID int, method, path string) error {
+	if s.err != nil {
+		return s.err
+	}
+	s.requests = append(s.requests, recordedRequest{actorID, impersonatedID, method, path})
+	return nil
+}
+
+func TestAuditImpersonation(t *testing.T) {
+	superuser := &authServices.User{ID: 1, Email: "admin@example.com", IsSuperuser: true}
+	impersonated := &authServices.User{ID: 2, Email: "customer@example.com", ImpersonatedBy: superuser}
+	regular := &authServices.User{ID: 3, Email: "user@example.com"}
+
+	tests := []struct {
+		name     string
+		user     *authServices.User
+		method   string
+		path     string
+		header   http.Header
+		storeErr error
+		want     []recordedRequest
+		wantCode int
+	}{
+		{
+			name:     "impersonated write",
+			user:     impersonated,
+			method:   http.MethodPost,
+			path:     "/campaigns",
+			want:     []recordedRequest{{1, 2, http.MethodPost, "/campaigns"}},
+			wantCode: http.StatusNoContent,
+		},
+		{
+			name:     "impersonated page load",
+			user:     impersonated,
+			method:   http.MethodGet,
+			path:     "/hosts",
+			want:     []recordedRequest{{1, 2, http.MethodGet, "/hosts"}},
+			wantCode: http.StatusNoContent,
+		},
+		{
+			name:     "impersonated live stream",
+			user:     impersonated,
+			method:   http.MethodGet,
+			path:     "/hosts/live",
+			header:   http.Header{"Datastar-Request": {"true"}},
+			wantCode: http.StatusNoContent,
+		},
+		{
+			name:     "impersonated nested live stream",
+			user:     impersonated,
+			method:   http.MethodGet,
+			path:     "/incidents/7/live",
+			header:   http.Header{"Connection": {"Upgrade"}, "Upgrade": {"websocket"}},
+			wantCode: http.StatusNoContent,
+		},
+		{
+			name:     "datastar header on a page is audited",
+			user:     impersonated,
+			method:   http.MethodGet,
+			path:     "/hosts",
+			header:   http.Header{"Datastar-Request": {"true"}},
+			want:     []recordedRequest{{1, 2, http.MethodGet, "/hosts"}},
+			wantCode: http.StatusNoContent,
+		},
+		{
+			name:     "event stream header on a page is audited",
+			user:     impersonated,
+			method:   http.MethodGet,
+			path:     "/incidents/7",
+			header:   http.Header{"Accept": {"text/event-stream"}},
+			want:     []recordedRequest{{1, 2, http.MethodGet, "/incidents/7"}},
+			wantCode: http.StatusNoContent,
+		},
+		{
+			name:     "stopping is audited by its handler",
+			user:     impersonated,
+			method:   http.MethodPost,
+			path:     "/admin/impersonation/stop",
+			wantCode: http.StatusNoContent,
+		},
+		{
+			name:     "regular write",
+			user:     regular,
+			method:   http.MethodDelete,
+			path:     "/hosts/x",
+			wantCode: http.StatusNoContent,
+		},
+		{
+			name:     "audit failure refuses the request",
+			user:     impersonated,
+			method:   http.MethodPost,
+			path:     "/campaigns",
+			storeErr: errors.New("db down"),
+			wantCode: http.StatusInternalServerError,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := &stubStore{err: tt.storeErr}
+			handlers := admin.NewHandlers(store, nil)
+			next := func(w http.ResponseWriter, _ *http.Request) {
+				w.WriteHeader(http.StatusNoContent)
+			}
+
+			router := chi.NewRouter()
+			router.Use(handlers.AuditImpersonation)
+			router.Get("/hosts", next)
+			router.Method(http.MethodGet, "/hosts/live", auth.LiveStream(next))
+			router.Delete("/hosts/{id}", next)
+			router.Post("/campaigns", next)
+			router.Post("/admin/impersonation/stop", next)
+			router.Route("/incidents/{id}", func(r chi.Router) {
+				r.Get("/", next)
+				r.Method(http.MethodGet, "/live", auth.LiveStream(next))
+			})
+			if err := handlers.SkipLiveStreams(router); err != nil {
+				t.Fatalf("SkipLiveStreams: %v", err)
+			}
+
+			req := httptest.NewRequest(tt.method, tt.path, nil)
+			for name, values := range tt.header {
+				req.Header[name] = values
+			}
+			req = req.WithContext(auth.SetUserInContext(req.Context(), tt.user))
+			rec := httptest.NewRecorder()
+
+			router.ServeHTTP(rec, req)
+
+			if rec.Code != tt.wantCode {
+				t.Fatalf("status = %d, want %d", rec.Code, tt.wantCode)
+			}
+			if len(store.requests) != len(tt.want) {
+				t.Fatalf("recorded %+v, want %+v", store.requests, tt.want)
+			}
+			for i := range tt.want {
+				if store.requests[i] != tt.want[i] {
+					t.Fatalf("recorded[%d] = %+v, want %+v", i, store.requests[i], tt.want[i])
+				}
+			}
+		})
+	}
+}
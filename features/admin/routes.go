@@ -0,0 +1,47 @@
+package admin
+
+import (
+	"net/http"
+
+	"github.com/alexedwards/scs/v2"
+	"github.com/go-chi/chi/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+
+	"github.com/cavenine/queryops/features/admin/services"
+)
+
+type Feature struct {
+	handlers *Handlers
+}
+
+func NewFeature(pool *pgxpool.Pool, sessionManager *scs.SessionManager) *Feature {
+	return &Feature{handlers: NewHandlers(services.NewAdminRepository(pool), sessionManager)}
+}
+
+// SetupRoutes registers the admin console. The router must only let
+// superusers through; see auth.RequireSuperuser.
+func (f *Feature) SetupRoutes(router chi.Router) {
+	router.Get("/admin", f.handlers.AdminPage)
+	router.Post("/admin/organizations/{id}/disable", f.handlers.DisableOrganization)
+	router.Post("/admin/organizations/{id}/enable", f.handlers.EnableOrganization)
+	router.Post("/admin/organizations/{id}/plan", f.handlers.SetOrganizationPlan)
+	router.Post("/admin/impersonate", f.handlers.StartImpersonation)
+}
+
+// SetupImpersonationRoutes registers the way out of an impersonation session.
+// It must be reachable by any signed-in user.
+func (f *Feature) SetupImpersonationRoutes(router chi.Router) {
+	router.Post(stopImpersonationPath, f.handlers.StopImpersonation)
+}
+
+// SkipLiveStreams has AuditImpersonation skip the live streams among
+// routes; see Handlers.SkipLiveStreams.
+func (f *Feature) SkipLiveStreams(routes chi.Routes) error {
+	return f.handlers.SkipLiveStreams(routes)
+}
+
+// AuditImpersonation is middleware that audits requests made while
+// impersonating; see Handlers.AuditImpersonation.
+func (f *Feature) AuditImpersonation(next http.Handler) http.Handler {
+	return f.handlers.AuditImpersonation(next)
+}
@@ -0,0 +1,403 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// Audit log actions.
+const (
+	ActionOrganizationDisabled = "organization.disabled"
+	ActionOrganizationEnabled  = "organization.enabled"
+	ActionOrganizationPlanSet  = "organization.plan_set"
+	ActionSuperuserGranted     = "user.superuser_granted"
+	ActionSuperuserRevoked     = "user.superuser_revoked"
+	ActionImpersonationStarted = "impersonation.started"
+	ActionImpersonationStopped = "impersonation.stopped"
+	ActionImpersonatedRequest  = "impersonation.request"
+)
+
+// AuditLogLimit is how many of the most recent audit log entries the console
+// lists.
+const AuditLogLimit = 50
+
+var (
+	ErrOrganizationNotFound = errors.New("organization not found")
+	ErrPlanNotFound         = errors.New("plan not found")
+	ErrUserNotFound         = errors.New("user not found")
+	ErrCannotImpersonate    = errors.New("superusers can't be impersonated")
+)
+
+// OrganizationSummary is one organization as the admin console lists it.
+type OrganizationSummary struct {
+	ID         uuid.UUID  `json:"id"`
+	Name       string     `json:"name"`
+	CreatedAt  time.Time  `json:"created_at"`
+	DisabledAt *time.Time `json:"disabled_at,omitempty"`
+	Users      int        `json:"users"`
+	Hosts      int        `json:"hosts"`
+
+	// PlanID is the organization's plan and ExtraFeatures those granted on
+	// top of it.
+	PlanID        string   `json:"plan_id"`
+	ExtraFeatures []string `json:"extra_features"`
+
+	// StorageBytes approximates the stored size of the organization's result
+	// logs, status logs, and campaign results. Indexes and table overhead
+	// aren't counted.
+	StorageBytes int64 `json:"storage_bytes"`
+}
+
+// PlanSummary is a plan an organization can be put on.
+type PlanSummary struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+}
+
+// AuditEntry is one action taken from the admin console or the admin
+// command. ActorEmail is empty for the command, and for deleted users.
+// ImpersonatedEmail is set for actions taken while impersonating a user.
+type AuditEntry struct {
+	ID                int64           `json:"id"`
+	ActorEmail        string          `json:"actor_email"`
+	ImpersonatedEmail string          `json:"impersonated_email,omitempty"`
+	Action            string          `json:"action"`
+	OrganizationID    *uuid.UUID      `json:"organization_id,omitempty"`
+	OrganizationName  string          `json:"organization_name,omitempty"`
+	Details           json.RawMessage `json:"details"`
+	CreatedAt         time.Time       `json:"created_at"`
+}
+
+type AdminRepository struct {
+	pool *pgxpool.Pool
+}
+
+func NewAdminRepository(pool *pgxpool.Pool) *AdminRepository {
+	return &AdminRepository{pool: pool}
+}
+
+// ListOrganizations summarizes every organization, largest by host count
+// first. Storage is summed from the rows themselves, so the query scans the
+// results tables; it is meant for the occasional operator, not for polling.
+func (r *AdminRepository) ListOrganizations(ctx context.Context) ([]OrganizationSummary, error) {
+	rows, err := r.pool.Query(ctx, `
+		WITH members AS (
+			SELECT organization_id, COUNT(*) AS n
+			FROM organization_members
+			GROUP BY organization_id
+		), host_counts AS (
+			SELECT organization_id, COUNT(*) AS n
+			FROM hosts
+			GROUP BY organization_id
+		), result_logs AS (
+			SELECT h.organization_id, SUM(pg_column_size(r.*)) AS bytes
+			FROM osquery_results r
+			JOIN hosts h ON h.id = r.host_id
+			GROUP BY h.organization_id
+		), status_logs AS (
+			SELECT h.organization_id, SUM(pg_column_size(s.*)) AS bytes
+			FROM osquery_status_logs s
+			JOIN hosts h ON h.id = s.host_id
+			GROUP BY h.organization_id
+		), campaign_results AS (
+			SELECT c.organization_id, SUM(COALESCE(pg_column_size(t.results), 0) + COALESCE(pg_column_size(t.diff), 0)) AS bytes
+			FROM campaign_targets t
+			JOIN campaigns c ON c.id = t.campaign_id
+			GROUP BY c.organization_id
+		)
+		SELECT o.id, o.name, o.created_at, o.disabled_at,
+			COALESCE(m.n, 0), COALESCE(h.n, 0), o.plan_id, o.extra_features,
+			(COALESCE(rl.bytes, 0) + COALESCE(sl.bytes, 0) + COALESCE(cr.bytes, 0))::bigint
+		FROM organizations o
+		LEFT JOIN members m ON m.organization_id = o.id
+		LEFT JOIN host_counts h ON h.organization_id = o.id
+		LEFT JOIN result_logs rl ON rl.organization_id = o.id
+		LEFT JOIN status_logs sl ON sl.organization_id = o.id
+		LEFT JOIN campaign_results cr ON cr.organization_id = o.id
+		ORDER BY COALESCE(h.n, 0) DESC, o.name
+	`)
+	if err != nil {
+		return nil, fmt.Errorf("querying organizations: %w", err)
+	}
+	orgs, err := pgx.CollectRows(rows, func(row pgx.CollectableRow) (OrganizationSummary, error) {
+		var o OrganizationSummary
+		err := row.Scan(&o.ID, &o.Name, &o.CreatedAt, &o.DisabledAt, &o.Users, &o.Hosts, &o.PlanID, &o.ExtraFeatures, &o.StorageBytes)
+		return o, err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("scanning organizations: %w", err)
+	}
+	return orgs, nil
+}
+
+// ListPlans returns every plan, cheapest first.
+func (r *AdminRepository) ListPlans(ctx context.Context) ([]PlanSummary, error) {
+	rows, err := r.pool.Query(ctx, `SELECT id, name FROM plans ORDER BY rank`)
+	if err != nil {
+		return nil, fmt.Errorf("querying plans: %w", err)
+	}
+	plans, err := pgx.CollectRows(rows, func(row pgx.CollectableRow) (PlanSummary, error) {
+		var p PlanSummary
+		err := row.Scan(&p.ID, &p.Name)
+		return p, err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("scanning plans: %w", err)
+	}
+	return plans, nil
+}
+
+// SetOrganizationPlan puts an organization on planID, granting extraFeatures
+// on top of the plan's, and records actorID doing so in the audit log.
+func (r *AdminRepository) SetOrganizationPlan(ctx context.Context, actorID int, organizationID uuid.UUID, planID string, extraFeatures []string) error {
+	if extraFeatures == nil {
+		extraFeatures = []string{}
+	}
+	tx, err := r.pool.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("starting transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	var name string
+	err = tx.QueryRow(ctx, `
+		UPDATE organizations
+		SET plan_id = $2, extra_features = $3, updated_at = NOW()
+		WHERE id = $1
+		RETURNING name
+	`, organizationID, planID, extraFeatures).Scan(&name)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return ErrOrganizationNotFound
+		}
+		var pgErr *pgconn.PgError
+		if errors.As(err, &pgErr) && pgErr.Code == "23503" {
+			return ErrPlanNotFound
+		}
+		return fmt.Errorf("updating organization plan: %w", err)
+	}
+
+	err = recordAudit(ctx, tx, auditRecord{
+		actorID:        &actorID,
+		action:         ActionOrganizationPlanSet,
+		organizationID: &organizationID,
+		details:        map[string]any{"name": name, "plan": planID, "extra_features": extraFeatures},
+	})
+	if err != nil {
+		return err
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return fmt.Errorf("committing organization plan: %w", err)
+	}
+	return nil
+}
+
+// SetOrganizationDisabled disables or re-enables an organization and records
+// actorID doing so in the audit log. Disabling an already disabled
+// organization keeps its original disabled_at.
+func (r *AdminRepository) SetOrganizationDisabled(ctx context.Context, actorID int, organizationID uuid.UUID, disabled bool) error {
+	tx, err := r.pool.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("starting transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	var name string
+	err = tx.QueryRow(ctx, `
+		UPDATE organizations
+		SET disabled_at = CASE WHEN $2 THEN COALESCE(disabled_at, NOW()) END,
+			updated_at = NOW()
+		WHERE id = $1
+		RETURNING name
+	`, organizationID, disabled).Scan(&name)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return ErrOrganizationNotFound
+		}
+		return fmt.Errorf("updating organization: %w", err)
+	}
+
+	action := ActionOrganizationEnabled
+	if disabled {
+		action = ActionOrganizationDisabled
+	}
+	err = recordAudit(ctx, tx, auditRecord{
+		actorID:        &actorID,
+		action:         action,
+		organizationID: &organizationID,
+		details:        map[string]string{"name": name},
+	})
+	if err != nil {
+		return err
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return fmt.Errorf("committing organization update: %w", err)
+	}
+	return nil
+}
+
+// SetSuperuser grants or revokes superuser for the user with the given email.
+// It is only reachable from the command line, so the audit log entry has no
+// actor.
+func (r *AdminRepository) SetSuperuser(ctx context.Context, email string, superuser bool) error {
+	tx, err := r.pool.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("starting transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	var userID int
+	err = tx.QueryRow(ctx, `
+		UPDATE users SET is_superuser = $2, updated_at = NOW()
+		WHERE email = $1
+		RETURNING id
+	`, email, superuser).Scan(&userID)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return ErrUserNotFound
+		}
+		return fmt.Errorf("updating user: %w", err)
+	}
+
+	action := ActionSuperuserRevoked
+	if superuser {
+		action = ActionSuperuserGranted
+	}
+	err = recordAudit(ctx, tx, auditRecord{
+		action:  action,
+		details: map[string]any{"user_id": userID, "email": email},
+	})
+	if err != nil {
+		return err
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return fmt.Errorf("committing user update: %w", err)
+	}
+	return nil
+}
+
+// ListAuditLog returns the most recent admin actions, newest first.
+func (r *AdminRepository) ListAuditLog(ctx context.Context, limit int) ([]AuditEntry, error) {
+	rows, err := r.pool.Query(ctx, `
+		SELECT a.id, COALESCE(u.email, ''), COALESCE(iu.email, ''), a.action, a.organization_id, COALESCE(o.name, ''), a.details, a.created_at
+		FROM admin_audit_log a
+		LEFT JOIN users u ON u.id = a.actor_user_id
+		LEFT JOIN users iu ON iu.id = a.impersonated_user_id
+		LEFT JOIN organizations o ON o.id = a.organization_id
+		ORDER BY a.created_at DESC, a.id DESC
+		LIMIT $1
+	`, limit)
+	if err != nil {
+		return nil, fmt.Errorf("querying audit log: %w", err)
+	}
+	entries, err := pgx.CollectRows(rows, func(row pgx.CollectableRow) (AuditEntry, error) {
+		var e AuditEntry
+		err := row.Scan(&e.ID, &e.ActorEmail, &e.ImpersonatedEmail, &e.Action, &e.OrganizationID, &e.OrganizationName, &e.Details, &e.CreatedAt)
+		return e, err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("scanning audit log: %w", err)
+	}
+	return entries, nil
+}
+
+// StartImpersonation looks up the user a superuser wants to act as and
+// records that they started. Superusers, including actorID itself, can't be
+// impersonated.
+func (r *AdminRepository) StartImpersonation(ctx context.Context, actorID int, email string) (int, error) {
+	tx, err := r.pool.Begin(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("starting transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	var (
+		targetID    int
+		isSuperuser bool
+	)
+	err = tx.QueryRow(ctx, `SELECT id, is_superuser FROM users WHERE email = $1`, email).Scan(&targetID, &isSuperuser)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return 0, ErrUserNotFound
+		}
+		return 0, fmt.Errorf("querying user: %w", err)
+	}
+	if isSuperuser || targetID == actorID {
+		return 0, ErrCannotImpersonate
+	}
+
+	err = recordAudit(ctx, tx, auditRecord{
+		actorID:        &actorID,
+		impersonatedID: &targetID,
+		action:         ActionImpersonationStarted,
+		details:        map[string]string{"email": email},
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return 0, fmt.Errorf("committing impersonation: %w", err)
+	}
+	return targetID, nil
+}
+
+// StopImpersonation records actorID no longer acting as impersonatedID.
+func (r *AdminRepository) StopImpersonation(ctx context.Context, actorID, impersonatedID int) error {
+	return recordAudit(ctx, r.pool, auditRecord{
+		actorID:        &actorID,
+		impersonatedID: &impersonatedID,
+		action:         ActionImpersonationStopped,
+		details:        map[string]string{},
+	})
+}
+
+// RecordImpersonatedRequest records a request actorID made while acting as
+// impersonatedID.
+func (r *AdminRepository) RecordImpersonatedRequest(ctx context.Context, actorID, impersonatedID int, method, path string) error {
+	return recordAudit(ctx, r.pool, auditRecord{
+		actorID:        &actorID,
+		impersonatedID: &impersonatedID,
+		action:         ActionImpersonatedRequest,
+		details:        map[string]string{"method": method, "path": path},
+	})
+}
+
+// execer is satisfied by *pgxpool.Pool and pgx.Tx.
+type execer interface {
+	Exec(ctx context.Context, sql string, args ...any) (pgconn.CommandTag, error)
+}
+
+type auditRecord struct {
+	actorID        *int
+	impersonatedID *int
+	action         string
+	organizationID *uuid.UUID
+	details        any
+}
+
+func recordAudit(ctx context.Context, db execer, rec auditRecord) error {
+	raw, err := json.Marshal(rec.details)
+	if err != nil {
+		return fmt.Errorf("encoding audit details: %w", err)
+	}
+	_, err = db.Exec(ctx, `
+		INSERT INTO admin_audit_log (actor_user_id, impersonated_user_id, action, organization_id, details)
+		VALUES ($1, $2, $3, $4, $5)
+	`, rec.actorID, rec.impersonatedID, rec.action, rec.organizationID, raw)
+	if err != nil {
+		return fmt.Errorf("recording audit log entry: %w", err)
+	}
+	return nil
+}
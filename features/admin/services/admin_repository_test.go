@@ -0,0 +1,292 @@
+package services_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/cavenine/queryops/features/admin/services"
+	"github.com/cavenine/queryops/internal/testdb"
+	"github.com/cavenine/queryops/internal/testdb/fixtures"
+)
+
+func TestAdminRepository_ListOrganizations(t *testing.T) {
+	tdb := testdb.SetupTestDB(t)
+	ctx := context.Background()
+
+	big := fixtures.CreateOrg(t, tdb.Pool, "big-org")
+	empty := fixtures.CreateOrg(t, tdb.Pool, "empty-org")
+
+	alice := fixtures.CreateUser(t, tdb.Pool, "alice@example.com").ID
+	bob := fixtures.CreateUser(t, tdb.Pool, "bob@example.com").ID
+	fixtures.AddMember(t, tdb.Pool, big.ID, alice, "owner")
+	fixtures.AddMember(t, tdb.Pool, big.ID, bob, "member")
+
+	host := fixtures.CreateHost(t, tdb.Pool, big.ID, "host-1")
+	fixtures.CreateHost(t, tdb.Pool, big.ID, "host-2")
+	_, err := tdb.Pool.Exec(ctx, `
+		INSERT INTO osquery_results (host_id, name, action, columns)
+		VALUES ($1, 'pack/processes', 'added', '{"pid":"1","name":"init"}')
+	`, host.ID)
+	if err != nil {
+		t.Fatalf("inserting result: %v", err)
+	}
+
+	orgs, err := services.NewAdminRepository(tdb.Pool).ListOrganizations(ctx)
+	if err != nil {
+		t.Fatalf("ListOrganizations: %v", err)
+	}
+	if len(orgs) != 2 {
+		t.Fatalf("len(orgs) = %d, want 2", len(orgs))
+	}
+	if got := orgs[0]; got.ID != big.ID || got.Users != 2 || got.Hosts != 2 || got.StorageBytes <= 0 {
+		t.Fatalf("orgs[0] = %+v, want big-org with 2 users, 2 hosts, and storage", got)
+	}
+	if got := orgs[1]; got.ID != empty.ID || got.Users != 0 || got.Hosts != 0 || got.StorageBytes != 0 {
+		t.Fatalf("orgs[1] = %+v, want empty-org with nothing", got)
+	}
+}
+
+func TestAdminRepository_SetOrganizationDisabled(t *testing.T) {
+	tdb := testdb.SetupTestDB(t)
+	ctx := context.Background()
+	repo := services.NewAdminRepository(tdb.Pool)
+
+	org := fixtures.CreateOrg(t, tdb.Pool, "acme")
+	admin := fixtures.CreateUser(t, tdb.Pool, "admin@example.com").ID
+
+	disabledAt := func(t *testing.T) *time.Time {
+		t.Helper()
+		var at *time.Time
+		if err := tdb.Pool.QueryRow(ctx, `SELECT disabled_at FROM organizations WHERE id = $1`, org.ID).Scan(&at); err != nil {
+			t.Fatalf("selecting disabled_at: %v", err)
+		}
+		return at
+	}
+
+	if err := repo.SetOrganizationDisabled(ctx, admin, org.ID, true); err != nil {
+		t.Fatalf("SetOrganizationDisabled(true): %v", err)
+	}
+	first := disabledAt(t)
+	if first == nil {
+		t.Fatal("disabled_at is NULL after disabling")
+	}
+
+	if err := repo.SetOrganizationDisabled(ctx, admin, org.ID, true); err != nil {
+		t.Fatalf("SetOrganizationDisabled(true) again: %v", err)
+	}
+	if again := disabledAt(t); again == nil || !again.Equal(*first) {
+		t.Fatalf("disabled_at = %v after disabling twice, want %v", again, first)
+	}
+
+	if err := repo.SetOrganizationDisabled(ctx, admin, org.ID, false); err != nil {
+		t.Fatalf("SetOrganizationDisabled(false): %v", err)
+	}
+	if at := disabledAt(t); at != nil {
+		t.Fatalf("disabled_at = %v after enabling, want NULL", at)
+	}
+
+	err := repo.SetOrganizationDisabled(ctx, admin, uuid.New(), true)
+	if !errors.Is(err, services.ErrOrganizationNotFound) {
+		t.Fatalf("SetOrganizationDisabled(unknown) error = %v, want ErrOrganizationNotFound", err)
+	}
+
+	entries, err := repo.ListAuditLog(ctx, services.AuditLogLimit)
+	if err != nil {
+		t.Fatalf("ListAuditLog: %v", err)
+	}
+	wantActions := []string{services.ActionOrganizationEnabled, services.ActionOrganizationDisabled, services.ActionOrganizationDisabled}
+	if len(entries) != len(wantActions) {
+		t.Fatalf("len(entries) = %d, want %d", len(entries), len(wantActions))
+	}
+	for i, e := range entries {
+		if e.Action != wantActions[i] || e.ActorEmail != "admin@example.com" || e.OrganizationID == nil || *e.OrganizationID != org.ID || e.OrganizationName != "acme" {
+			t.Fatalf("entries[%d] = %+v", i, e)
+		}
+		var details map[string]string
+		if err := json.Unmarshal(e.Details, &details); err != nil || details["name"] != "acme" {
+			t.Fatalf("entries[%d].Details = %s, %v", i, e.Details, err)
+		}
+	}
+}
+
+func TestAdminRepository_SetOrganizationPlan(t *testing.T) {
+	tdb := testdb.SetupTestDB(t)
+	ctx := context.Background()
+	repo := services.NewAdminRepository(tdb.Pool)
+
+	org := fixtures.CreateOrg(t, tdb.Pool, "acme")
+	admin := fixtures.CreateUser(t, tdb.Pool, "admin@example.com").ID
+
+	plans, err := repo.ListPlans(ctx)
+	if err != nil {
+		t.Fatalf("ListPlans: %v", err)
+	}
+	if len(plans) != 3 || plans[0].ID != "free" || plans[2].ID != "enterprise" {
+		t.Fatalf("plans = %+v, want free, team, enterprise", plans)
+	}
+
+	if err := repo.SetOrganizationPlan(ctx, admin, org.ID, "free", []string{"result_exports"}); err != nil {
+		t.Fatalf("SetOrganizationPlan: %v", err)
+	}
+	orgs, err := repo.ListOrganizations(ctx)
+	if err != nil {
+		t.Fatalf("ListOrganizations: %v", err)
+	}
+	if got := orgs[0]; got.PlanID != "free" || len(got.ExtraFeatures) != 1 || got.ExtraFeatures[0] != "result_exports" {
+		t.Fatalf("orgs[0] = %+v, want free with result_exports", got)
+	}
+
+	if err := repo.SetOrganizationPlan(ctx, admin, org.ID, "platinum", nil); !errors.Is(err, services.ErrPlanNotFound) {
+		t.Fatalf("SetOrganizationPlan(unknown plan) error = %v, want ErrPlanNotFound", err)
+	}
+	if err := repo.SetOrganizationPlan(ctx, admin, uuid.New(), "free", nil); !errors.Is(err, services.ErrOrganizationNotFound) {
+		t.Fatalf("SetOrganizationPlan(unknown org) error = %v, want ErrOrganizationNotFound", err)
+	}
+
+	entries, err := repo.ListAuditLog(ctx, services.AuditLogLimit)
+	if err != nil {
+		t.Fatalf("ListAuditLog: %v", err)
+	}
+	if len(entries) != 1 || entries[0].Action != services.ActionOrganizationPlanSet {
+		t.Fatalf("entries = %+v, want one %s", entries, services.ActionOrganizationPlanSet)
+	}
+	var details struct {
+		Plan          string   `json:"plan"`
+		ExtraFeatures []string `json:"extra_features"`
+	}
+	if err := json.Unmarshal(entries[0].Details, &details); err != nil || details.Plan != "free" || len(details.ExtraFeatures) != 1 {
+		t.Fatalf("entries[0].Details = %s, %v", entries[0].Details, err)
+	}
+}
+
+func TestAdminRepository_Health(t *testing.T) {
+	tdb := testdb.SetupTestDB(t)
+	ctx := context.Background()
+
+	org := fixtures.CreateOrg(t, tdb.Pool, "acme")
+	online := fixtures.CreateHost(t, tdb.Pool, org.ID, "online")
+	fixtures.CreateHost(t, tdb.Pool, org.ID, "offline")
+	if _, err := tdb.Pool.Exec(ctx, `UPDATE hosts SET last_logger_at = NOW() WHERE id = $1`, online.ID); err != nil {
+		t.Fatalf("updating host: %v", err)
+	}
+	if _, err := tdb.Pool.Exec(ctx, `INSERT INTO pubsub_outbox (topic, message_id, payload) VALUES ('t', 'm', '')`); err != nil {
+		t.Fatalf("inserting outbox message: %v", err)
+	}
+
+	h, err := services.NewAdminRepository(tdb.Pool).Health(ctx, time.Now().Add(-5*time.Minute))
+	if err != nil {
+		t.Fatalf("Health: %v", err)
+	}
+	if h.Hosts != 2 || h.OnlineHosts != 1 {
+		t.Fatalf("Hosts = %d, OnlineHosts = %d; want 2, 1", h.Hosts, h.OnlineHosts)
+	}
+	if h.OutboxPending != 1 || h.OutboxOldest == nil {
+		t.Fatalf("OutboxPending = %d, OutboxOldest = %v; want 1 and a time", h.OutboxPending, h.OutboxOldest)
+	}
+	if h.DatabaseBytes <= 0 || h.Pool.Max <= 0 {
+		t.Fatalf("DatabaseBytes = %d, Pool = %+v", h.DatabaseBytes, h.Pool)
+	}
+}
+
+func TestAdminRepository_SetSuperuser(t *testing.T) {
+	tdb := testdb.SetupTestDB(t)
+	ctx := context.Background()
+	repo := services.NewAdminRepository(tdb.Pool)
+
+	user := fixtures.CreateUser(t, tdb.Pool, "ops@example.com")
+
+	isSuperuser := func(t *testing.T) bool {
+		t.Helper()
+		var ok bool
+		if err := tdb.Pool.QueryRow(ctx, `SELECT is_superuser FROM users WHERE id = $1`, user.ID).Scan(&ok); err != nil {
+			t.Fatalf("selecting is_superuser: %v", err)
+		}
+		return ok
+	}
+
+	if err := repo.SetSuperuser(ctx, user.Email, true); err != nil {
+		t.Fatalf("SetSuperuser(true): %v", err)
+	}
+	if !isSuperuser(t) {
+		t.Fatal("is_superuser = false after granting")
+	}
+	if err := repo.SetSuperuser(ctx, user.Email, false); err != nil {
+		t.Fatalf("SetSuperuser(false): %v", err)
+	}
+	if isSuperuser(t) {
+		t.Fatal("is_superuser = true after revoking")
+	}
+	if err := repo.SetSuperuser(ctx, "nobody@example.com", true); !errors.Is(err, services.ErrUserNotFound) {
+		t.Fatalf("SetSuperuser(unknown) error = %v, want ErrUserNotFound", err)
+	}
+
+	entries, err := repo.ListAuditLog(ctx, services.AuditLogLimit)
+	if err != nil {
+		t.Fatalf("ListAuditLog: %v", err)
+	}
+	if len(entries) != 2 || entries[0].Action != services.ActionSuperuserRevoked || entries[1].Action != services.ActionSuperuserGranted {
+		t.Fatalf("entries = %+v, want revoke then grant", entries)
+	}
+	if entries[0].ActorEmail != "" || entries[0].OrganizationID != nil {
+		t.Fatalf("entries[0] = %+v, want no actor or organization", entries[0])
+	}
+}
+
+func TestAdminRepository_Impersonation(t *testing.T) {
+	tdb := testdb.SetupTestDB(t)
+	ctx := context.Background()
+	repo := services.NewAdminRepository(tdb.Pool)
+
+	admin := fixtures.CreateUser(t, tdb.Pool, "admin@example.com")
+	otherAdmin := fixtures.CreateUser(t, tdb.Pool, "other-admin@example.com")
+	customer := fixtures.CreateUser(t, tdb.Pool, "customer@example.com")
+	if _, err := tdb.Pool.Exec(ctx, `UPDATE users SET is_superuser = true WHERE id = ANY($1)`, []int{admin.ID, otherAdmin.ID}); err != nil {
+		t.Fatalf("granting superuser: %v", err)
+	}
+
+	for _, email := range []string{admin.Email, otherAdmin.Email} {
+		if _, err := repo.StartImpersonation(ctx, admin.ID, email); !errors.Is(err, services.ErrCannotImpersonate) {
+			t.Fatalf("StartImpersonation(%s) error = %v, want ErrCannotImpersonate", email, err)
+		}
+	}
+	if _, err := repo.StartImpersonation(ctx, admin.ID, "nobody@example.com"); !errors.Is(err, services.ErrUserNotFound) {
+		t.Fatalf("StartImpersonation(unknown) error = %v, want ErrUserNotFound", err)
+	}
+
+	targetID, err := repo.StartImpersonation(ctx, admin.ID, customer.Email)
+	if err != nil {
+		t.Fatalf("StartImpersonation: %v", err)
+	}
+	if targetID != customer.ID {
+		t.Fatalf("StartImpersonation = %d, want %d", targetID, customer.ID)
+	}
+	if err := repo.RecordImpersonatedRequest(ctx, admin.ID, customer.ID, "POST", "/campaigns"); err != nil {
+		t.Fatalf("RecordImpersonatedRequest: %v", err)
+	}
+	if err := repo.StopImpersonation(ctx, admin.ID, customer.ID); err != nil {
+		t.Fatalf("StopImpersonation: %v", err)
+	}
+
+	entries, err := repo.ListAuditLog(ctx, services.AuditLogLimit)
+	if err != nil {
+		t.Fatalf("ListAuditLog: %v", err)
+	}
+	wantActions := []string{services.ActionImpersonationStopped, services.ActionImpersonatedRequest, services.ActionImpersonationStarted}
+	if len(entries) != len(wantActions) {
+		t.Fatalf("len(entries) = %d, want %d", len(entries), len(wantActions))
+	}
+	for i, e := range entries {
+		if e.Action != wantActions[i] || e.ActorEmail != admin.Email || e.ImpersonatedEmail != customer.Email {
+			t.Fatalf("entries[%d] = %+v", i, e)
+		}
+	}
+	var details map[string]string
+	if err := json.Unmarshal(entries[1].Details, &details); err != nil || details["method"] != "POST" || details["path"] != "/campaigns" {
+		t.Fatalf("request details = %s, %v", entries[1].Details, err)
+	}
+}
@@ -0,0 +1,74 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/cavenine/queryops/background"
+)
+
+// PoolStats describes this process's database connection pool.
+type PoolStats struct {
+	Total    int32 `json:"total"`
+	Idle     int32 `json:"idle"`
+	Acquired int32 `json:"acquired"`
+	Max      int32 `json:"max"`
+}
+
+// SystemHealth is a snapshot of the state shared by every organization.
+type SystemHealth struct {
+	DatabaseLatency time.Duration `json:"database_latency"`
+	DatabaseBytes   int64         `json:"database_bytes"`
+	Pool            PoolStats     `json:"pool"`
+
+	// Hosts and OnlineHosts count hosts across all organizations.
+	Hosts       int `json:"hosts"`
+	OnlineHosts int `json:"online_hosts"`
+
+	// OutboxPending is how many pub/sub messages are waiting to be published,
+	// and OutboxOldest when the oldest of them was written.
+	OutboxPending int64      `json:"outbox_pending"`
+	OutboxOldest  *time.Time `json:"outbox_oldest,omitempty"`
+
+	Queues []background.QueueStats `json:"queues"`
+}
+
+// Health reports database, pool, fleet, outbox, and job queue health. Hosts
+// that logged at or after onlineSince count as online.
+func (r *AdminRepository) Health(ctx context.Context, onlineSince time.Time) (*SystemHealth, error) {
+	h := &SystemHealth{}
+
+	start := time.Now()
+	if err := r.pool.Ping(ctx); err != nil {
+		return nil, fmt.Errorf("pinging database: %w", err)
+	}
+	h.DatabaseLatency = time.Since(start)
+
+	stat := r.pool.Stat()
+	h.Pool = PoolStats{
+		Total:    stat.TotalConns(),
+		Idle:     stat.IdleConns(),
+		Acquired: stat.AcquiredConns(),
+		Max:      stat.MaxConns(),
+	}
+
+	err := r.pool.QueryRow(ctx, `
+		SELECT pg_database_size(current_database()),
+			(SELECT COUNT(*) FROM hosts),
+			(SELECT COUNT(*) FROM hosts WHERE GREATEST(last_logger_at, last_seen_at) >= $1),
+			(SELECT COUNT(*) FROM pubsub_outbox WHERE published_at IS NULL),
+			(SELECT MIN(created_at) FROM pubsub_outbox WHERE published_at IS NULL)
+	`, onlineSince).Scan(&h.DatabaseBytes, &h.Hosts, &h.OnlineHosts, &h.OutboxPending, &h.OutboxOldest)
+	if err != nil {
+		return nil, fmt.Errorf("querying system health: %w", err)
+	}
+
+	stats, err := background.LoadWorkerStats(ctx, r.pool)
+	if err != nil {
+		return nil, err
+	}
+	h.Queues = stats.Queues
+
+	return h, nil
+}
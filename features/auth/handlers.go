@@ -4,15 +4,29 @@ import (
 	"context"
 	"log/slog"
 	"net/http"
+	"time"
 
 	"github.com/cavenine/queryops/features/auth/pages"
 	"github.com/cavenine/queryops/features/auth/services"
 	"github.com/cavenine/queryops/internal/antibot"
+	"github.com/cavenine/queryops/internal/validate"
 
 	"github.com/alexedwards/scs/v2"
+	"github.com/go-chi/chi/v5"
 )
 
-const registerFormID = "register"
+// Public forms protected by antibot.
+var (
+	registerForm = antibot.Form{ID: "register"}
+	// Password managers fill and submit the login form quickly.
+	loginForm = antibot.Form{ID: "login", MinDelay: time.Second}
+	// Passkey login starts from a button click rather than a form.
+	passkeyLoginForm = antibot.Form{ID: "passkey_login", MinDelay: 500 * time.Millisecond}
+)
+
+// blockedMessage is shown for every blocked submission; the reason is only
+// logged.
+const blockedMessage = "Unable to submit form. Please refresh and try again."
 
 type userService interface {
 	Authenticate(ctx context.Context, email, password string) (*services.User, error)
@@ -24,6 +38,7 @@ type Handlers struct {
 	userService    userService
 	sessionManager *scs.SessionManager
 	antibot        *antibot.Protector
+	events         securityEventRecorder
 }
 
 // NewHandlers creates a new Handlers instance.
@@ -35,113 +50,135 @@ func NewHandlers(userService userService, sessionManager *scs.SessionManager) *H
 	}
 }
 
-// SetAntibot sets the antibot protector (used in tests).
+// SetAntibot replaces the default antibot protector.
 func (h *Handlers) SetAntibot(protector *antibot.Protector) {
 	h.antibot = protector
 }
 
+// Routes registers the login, registration and logout routes, with the
+// antibot check in front of the form submissions.
+func (h *Handlers) Routes(router chi.Router) {
+	router.Get("/login", h.LoginPage)
+	router.With(h.antibot.Protect(loginForm, h.loginBlocked)).Post("/login", h.LoginSubmit)
+	router.Get("/register", h.RegisterPage)
+	router.With(h.antibot.Protect(registerForm, h.registerBlocked)).Post("/register", h.RegisterSubmit)
+	router.Post("/logout", h.Logout)
+}
+
 // LoginPage renders the login form.
 func (h *Handlers) LoginPage(w http.ResponseWriter, r *http.Request) {
-	if err := pages.LoginPage("", "").Render(r.Context(), w); err != nil {
-		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
-	}
+	h.renderLoginForm(w, r, "", "", nil)
 }
 
 // LoginSubmit handles the login form submission.
 func (h *Handlers) LoginSubmit(w http.ResponseWriter, r *http.Request) {
 	if err := r.ParseForm(); err != nil {
-		h.renderLoginError(w, r, "", "Invalid form data")
+		h.renderLoginError(w, r, "", "Invalid form data", nil)
 		return
 	}
 
 	email := r.FormValue("email")
 	password := r.FormValue("password")
 
-	if email == "" || password == "" {
-		h.renderLoginError(w, r, email, "Email and password are required")
+	fields := validate.Errors{}
+	fields.Field("email", email, validate.Required())
+	fields.Field("password", password, validate.Required())
+	if len(fields) > 0 {
+		h.renderLoginError(w, r, email, "", fields)
 		return
 	}
 
 	user, err := h.userService.Authenticate(r.Context(), email, password)
 	if err != nil {
-		h.renderLoginError(w, r, email, "Invalid email or password")
+		h.renderLoginError(w, r, email, "Invalid email or password", nil)
 		return
 	}
 
 	if err := SetSessionUserID(r.Context(), h.sessionManager, user.ID); err != nil {
-		h.renderLoginError(w, r, email, "Failed to create session")
+		h.renderLoginError(w, r, email, "Failed to create session", nil)
 		return
 	}
+	RecordSecurityEvent(r, h.events, user.ID, services.EventPasswordLogin)
 
 	http.Redirect(w, r, "/", http.StatusSeeOther)
 }
 
-func (h *Handlers) renderLoginError(w http.ResponseWriter, r *http.Request, email, errorMsg string) {
+func (h *Handlers) loginBlocked(w http.ResponseWriter, r *http.Request, _ antibot.Result) {
+	h.renderLoginError(w, r, r.FormValue("email"), blockedMessage, nil)
+}
+
+func (h *Handlers) renderLoginError(w http.ResponseWriter, r *http.Request, email, errorMsg string, fields validate.Errors) {
 	w.WriteHeader(http.StatusUnprocessableEntity)
-	if err := pages.LoginPage(email, errorMsg).Render(r.Context(), w); err != nil {
+	h.renderLoginForm(w, r, email, errorMsg, fields)
+}
+
+func (h *Handlers) renderLoginForm(w http.ResponseWriter, r *http.Request, email, errorMsg string, fields validate.Errors) {
+	login, err := h.antibot.Challenge(r.Context(), loginForm)
+	if err != nil {
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+	passkey, err := h.antibot.Challenge(r.Context(), passkeyLoginForm)
+	if err != nil {
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+	if err := pages.LoginPage(email, errorMsg, fields, login, passkey).Render(r.Context(), w); err != nil {
 		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
 	}
 }
 
 // RegisterPage renders the registration form.
 func (h *Handlers) RegisterPage(w http.ResponseWriter, r *http.Request) {
-	h.renderRegisterForm(w, r, "", "")
+	h.renderRegisterForm(w, r, "", "", nil)
 }
 
 // RegisterSubmit handles the registration form submission.
 func (h *Handlers) RegisterSubmit(w http.ResponseWriter, r *http.Request) {
 	if err := r.ParseForm(); err != nil {
-		h.renderRegisterError(w, r, "", "Invalid form data")
-		return
-	}
-
-	ab := h.antibot.Validate(r, registerFormID, r.FormValue("js_token"), r.FormValue("website"))
-	if !ab.Allowed {
-		slog.Warn(
-			"antibot blocked register",
-			"reason", ab.Reason,
-			"ip", antibot.ClientIP(r),
-			"ua", r.UserAgent(),
-		)
-		h.renderRegisterError(w, r, r.FormValue("email"), "Unable to submit form. Please refresh and try again.")
+		h.renderRegisterError(w, r, "", "Invalid form data", nil)
 		return
 	}
 
 	email := r.FormValue("email")
 	password := r.FormValue("password")
 
-	if email == "" || password == "" {
-		h.renderRegisterError(w, r, email, "Email and password are required")
+	user, err := h.userService.Register(r.Context(), email, password)
+	if fields, ok := validate.As(err); ok {
+		h.renderRegisterError(w, r, email, "", fields)
 		return
 	}
-
-	user, err := h.userService.Register(r.Context(), email, password)
 	if err != nil {
-		h.renderRegisterError(w, r, email, err.Error())
+		slog.Error("failed to register user", "error", err)
+		h.renderRegisterError(w, r, email, "Failed to create account", nil)
 		return
 	}
 
 	// Auto-login after registration
 	if err := SetSessionUserID(r.Context(), h.sessionManager, user.ID); err != nil {
-		h.renderRegisterError(w, r, email, "Account created but failed to log in")
+		h.renderRegisterError(w, r, email, "Account created but failed to log in", nil)
 		return
 	}
 
 	http.Redirect(w, r, "/", http.StatusSeeOther)
 }
 
-func (h *Handlers) renderRegisterError(w http.ResponseWriter, r *http.Request, email, errorMsg string) {
+func (h *Handlers) registerBlocked(w http.ResponseWriter, r *http.Request, _ antibot.Result) {
+	h.renderRegisterError(w, r, r.FormValue("email"), blockedMessage, nil)
+}
+
+func (h *Handlers) renderRegisterError(w http.ResponseWriter, r *http.Request, email, errorMsg string, fields validate.Errors) {
 	w.WriteHeader(http.StatusUnprocessableEntity)
-	h.renderRegisterForm(w, r, email, errorMsg)
+	h.renderRegisterForm(w, r, email, errorMsg, fields)
 }
 
-func (h *Handlers) renderRegisterForm(w http.ResponseWriter, r *http.Request, email, errorMsg string) {
-	token, err := h.antibot.Issue(r.Context(), registerFormID)
+func (h *Handlers) renderRegisterForm(w http.ResponseWriter, r *http.Request, email, errorMsg string, fields validate.Errors) {
+	challenge, err := h.antibot.Challenge(r.Context(), registerForm)
 	if err != nil {
 		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
 		return
 	}
-	if err := pages.RegisterPage(email, errorMsg, token).Render(r.Context(), w); err != nil {
+	if err := pages.RegisterPage(email, errorMsg, fields, challenge).Render(r.Context(), w); err != nil {
 		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
 	}
 }
@@ -20,10 +20,12 @@ import (
 )
 
 type stubUserService struct {
-	registerCalls int
+	registerCalls     int
+	authenticateCalls int
 }
 
 func (s *stubUserService) Authenticate(_ context.Context, _, _ string) (*services.User, error) {
+	s.authenticateCalls++
 	return nil, services.ErrUserNotFound
 }
 
@@ -50,8 +52,7 @@ func TestRegisterSubmit_AntibotBlocked_NoSideEffects(t *testing.T) {
 
 	r := chi.NewRouter()
 	r.Use(sm.LoadAndSave)
-	r.Get("/register", h.RegisterPage)
-	r.Post("/register", h.RegisterSubmit)
+	h.Routes(r)
 
 	getRec := httptest.NewRecorder()
 	r.ServeHTTP(getRec, httptest.NewRequest(http.MethodGet, "/register", nil))
@@ -109,8 +110,7 @@ func TestRegisterSubmit_AntibotAllowed_TriggersRegister(t *testing.T) {
 
 	r := chi.NewRouter()
 	r.Use(sm.LoadAndSave)
-	r.Get("/register", h.RegisterPage)
-	r.Post("/register", h.RegisterSubmit)
+	h.Routes(r)
 
 	getRec := httptest.NewRecorder()
 	r.ServeHTTP(getRec, httptest.NewRequest(http.MethodGet, "/register", nil))
@@ -149,3 +149,56 @@ func TestRegisterSubmit_AntibotAllowed_TriggersRegister(t *testing.T) {
 		t.Fatalf("expected redirect to /, got %q", loc)
 	}
 }
+
+func TestLoginSubmit_AntibotTooFast_NoAuthenticate(t *testing.T) {
+	now := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	sm := scs.New()
+	sm.Store = memstore.New()
+
+	us := &stubUserService{}
+	h := auth.NewHandlers(us, sm)
+	h.SetAntibot(antibot.New(sm, antibot.Config{
+		MinDelay:  2 * time.Second,
+		MaxTokens: 5,
+		Now: func() time.Time {
+			return now
+		},
+	}))
+
+	r := chi.NewRouter()
+	r.Use(sm.LoadAndSave)
+	h.Routes(r)
+
+	getRec := httptest.NewRecorder()
+	r.ServeHTTP(getRec, httptest.NewRequest(http.MethodGet, "/login", nil))
+	cookie := getRec.Result().Cookies()[0]
+
+	m := regexp.MustCompile(`<form[^>]*data-antibot-token="([^"]+)"`).FindStringSubmatch(getRec.Body.String())
+	if len(m) != 2 {
+		t.Fatalf("expected antibot token on login form")
+	}
+
+	// The login form allows a quicker submit than the default, but not an
+	// instant one.
+	now = now.Add(500 * time.Millisecond)
+
+	form := url.Values{}
+	form.Set("email", "a@example.com")
+	form.Set("password", "password")
+	form.Set("js_token", m[1])
+
+	postReq := httptest.NewRequest(http.MethodPost, "/login", strings.NewReader(form.Encode()))
+	postReq.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	postReq.AddCookie(cookie)
+
+	postRec := httptest.NewRecorder()
+	r.ServeHTTP(postRec, postReq)
+
+	if postRec.Code != http.StatusUnprocessableEntity {
+		t.Fatalf("POST /login status = %d", postRec.Code)
+	}
+	if us.authenticateCalls != 0 {
+		t.Fatalf("expected no Authenticate calls, got %d", us.authenticateCalls)
+	}
+}
@@ -3,9 +3,12 @@ package auth
 import (
 	"context"
 	"errors"
+	"log/slog"
 	"net/http"
+	"time"
 
 	"github.com/cavenine/queryops/features/auth/services"
+	"github.com/cavenine/queryops/internal/realip"
 
 	"github.com/alexedwards/scs/v2"
 )
@@ -13,10 +16,21 @@ import (
 type contextKey string
 
 const (
-	userContextKey contextKey = "user"
-	userIDKey      string     = "user_id"
+	userContextKey    contextKey = "user"
+	userIDKey         string     = services.SessionUserIDKey
+	impersonatorIDKey string     = services.SessionImpersonatorIDKey
+	// authAtKey is when the session signed in, in Unix microseconds, to
+	// compare with the user's SessionsValidAfter.
+	authAtKey string = "auth_at"
+	// passkeyAuthAtKey is when the session last signed in with a passkey, in
+	// Unix microseconds.
+	passkeyAuthAtKey string = "passkey_auth_at"
 )
 
+// PasskeyReauthWindow is how long after signing in with a passkey a user can
+// change their password or email without entering their current password.
+const PasskeyReauthWindow = 15 * time.Minute
+
 // GetUserFromContext retrieves the authenticated user from the request context.
 // Returns nil if no user is authenticated.
 func GetUserFromContext(ctx context.Context) *services.User {
@@ -56,6 +70,25 @@ func RequireAuth(userService *services.UserService, sessionManager *scs.SessionM
 				return
 			}
 
+			if user.SessionsValidAfter != nil && sessionManager.GetInt64(r.Context(), authAtKey) < user.SessionsValidAfter.UnixMicro() {
+				// The password changed after this session signed in.
+				_ = sessionManager.Destroy(r.Context())
+				http.Redirect(w, r, "/login", http.StatusSeeOther)
+				return
+			}
+
+			if impersonatorID := sessionManager.GetInt(r.Context(), impersonatorIDKey); impersonatorID != 0 {
+				impersonator, err := userService.GetByID(r.Context(), impersonatorID)
+				if err != nil || !impersonator.IsSuperuser {
+					// The superuser is gone or lost the role; don't leave
+					// the session signed in as someone else.
+					_ = sessionManager.Destroy(r.Context())
+					http.Redirect(w, r, "/login", http.StatusSeeOther)
+					return
+				}
+				user.ImpersonatedBy = impersonator
+			}
+
 			// Store user in context and continue
 			ctx := SetUserInContext(r.Context(), user)
 			next.ServeHTTP(w, r.WithContext(ctx))
@@ -63,6 +96,39 @@ func RequireAuth(userService *services.UserService, sessionManager *scs.SessionM
 	}
 }
 
+// RequireSuperuser is middleware that lets only superusers through. It must
+// run after RequireAuth. Other users get a 404 so the admin console's routes
+// aren't advertised.
+func RequireSuperuser(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user := GetUserFromContext(r.Context())
+		if user == nil || !user.IsSuperuser {
+			http.NotFound(w, r)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// LiveStream marks h as an event stream behind a page: a GET the page opens
+// once it has loaded, and reopens whenever it drops. Register it with the
+// router's Method, since Get would unwrap the mark.
+func LiveStream(h http.HandlerFunc) http.Handler {
+	return liveStream(h)
+}
+
+type liveStream http.HandlerFunc
+
+func (s liveStream) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s(w, r)
+}
+
+// IsLiveStream reports whether h was marked by LiveStream.
+func IsLiveStream(h http.Handler) bool {
+	_, ok := h.(liveStream)
+	return ok
+}
+
 // SetSessionUserID stores the user ID in the session and regenerates the token.
 func SetSessionUserID(ctx context.Context, sessionManager *scs.SessionManager, userID int) error {
 	// Renew token to prevent session fixation attacks
@@ -70,6 +136,57 @@ func SetSessionUserID(ctx context.Context, sessionManager *scs.SessionManager, u
 		return err
 	}
 	sessionManager.Put(ctx, userIDKey, userID)
+	sessionManager.Put(ctx, authAtKey, time.Now().UnixMicro())
+	return nil
+}
+
+// SetSessionPasskeyAuth records that the session just signed in with a
+// passkey. Call it after SetSessionUserID.
+func SetSessionPasskeyAuth(ctx context.Context, sessionManager *scs.SessionManager) {
+	sessionManager.Put(ctx, passkeyAuthAtKey, time.Now().UnixMicro())
+}
+
+// RecentPasskeyAuth reports whether the session signed in with a passkey
+// within PasskeyReauthWindow.
+func RecentPasskeyAuth(ctx context.Context, sessionManager *scs.SessionManager) bool {
+	at := sessionManager.GetInt64(ctx, passkeyAuthAtKey)
+	return at != 0 && time.Since(time.UnixMicro(at)) < PasskeyReauthWindow
+}
+
+// KeepSessionAfterPasswordChange renews the current session's token and marks
+// it signed in at validAfter, the user's new SessionsValidAfter, so it
+// survives the password change that signs out the user's other sessions.
+func KeepSessionAfterPasswordChange(ctx context.Context, sessionManager *scs.SessionManager, validAfter time.Time) error {
+	if err := sessionManager.RenewToken(ctx); err != nil {
+		return err
+	}
+	sessionManager.Put(ctx, authAtKey, validAfter.UnixMicro())
+	return nil
+}
+
+// StartImpersonation signs the session in as targetID on behalf of the
+// superuser impersonatorID. The token is renewed as on login.
+func StartImpersonation(ctx context.Context, sessionManager *scs.SessionManager, impersonatorID, targetID int) error {
+	if err := sessionManager.RenewToken(ctx); err != nil {
+		return err
+	}
+	sessionManager.Put(ctx, impersonatorIDKey, impersonatorID)
+	sessionManager.Put(ctx, userIDKey, targetID)
+	sessionManager.Put(ctx, authAtKey, time.Now().UnixMicro())
+	return nil
+}
+
+// StopImpersonation signs the session back in as the superuser who started
+// impersonating. It is a no-op for a session that isn't impersonating.
+func StopImpersonation(ctx context.Context, sessionManager *scs.SessionManager) error {
+	impersonatorID := sessionManager.PopInt(ctx, impersonatorIDKey)
+	if impersonatorID == 0 {
+		return nil
+	}
+	if err := sessionManager.RenewToken(ctx); err != nil {
+		return err
+	}
+	sessionManager.Put(ctx, userIDKey, impersonatorID)
 	return nil
 }
 
@@ -77,3 +194,25 @@ func SetSessionUserID(ctx context.Context, sessionManager *scs.SessionManager, u
 func ClearSession(ctx context.Context, sessionManager *scs.SessionManager) error {
 	return sessionManager.Destroy(ctx)
 }
+
+// securityEventRecorder records sign-ins and credential changes for the
+// account page.
+type securityEventRecorder interface {
+	Record(ctx context.Context, userID int, kind, ip, userAgent string) error
+}
+
+// RecordSecurityEvent records an event of kind for userID, made by the client
+// of r. A failure is logged rather than returned, since the sign-in or change
+// it describes has already happened. A nil events records nothing.
+func RecordSecurityEvent(r *http.Request, events securityEventRecorder, userID int, kind string) {
+	if events == nil {
+		return
+	}
+	ip := ""
+	if addr := realip.FromRequest(r); addr.IsValid() {
+		ip = addr.String()
+	}
+	if err := events.Record(r.Context(), userID, kind, ip, r.UserAgent()); err != nil {
+		slog.WarnContext(r.Context(), "failed to record security event", "kind", kind, "user_id", userID, "error", err)
+	}
+}
@@ -0,0 +1,124 @@
+package auth_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/cavenine/queryops/features/auth"
+	"github.com/cavenine/queryops/features/auth/services"
+
+	"github.com/alexedwards/scs/v2"
+	"github.com/alexedwards/scs/v2/memstore"
+	"github.com/go-chi/chi/v5"
+)
+
+func TestRequireSuperuser(t *testing.T) {
+	next := http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	})
+
+	tests := []struct {
+		name string
+		user *services.User
+		want int
+	}{
+		{name: "no user", user: nil, want: http.StatusNotFound},
+		{name: "regular user", user: &services.User{ID: 1}, want: http.StatusNotFound},
+		{name: "superuser", user: &services.User{ID: 2, IsSuperuser: true}, want: http.StatusNoContent},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/admin", nil)
+			if tt.user != nil {
+				req = req.WithContext(auth.SetUserInContext(req.Context(), tt.user))
+			}
+			rec := httptest.NewRecorder()
+
+			auth.RequireSuperuser(next).ServeHTTP(rec, req)
+
+			if rec.Code != tt.want {
+				t.Fatalf("status = %d, want %d", rec.Code, tt.want)
+			}
+		})
+	}
+}
+
+// passwordChangedRepo serves one user whose password changed at validAfter.
+type passwordChangedRepo struct {
+	validAfter *time.Time
+}
+
+func (s *passwordChangedRepo) GetByID(_ context.Context, id int) (*services.User, error) {
+	return &services.User{ID: id, SessionsValidAfter: s.validAfter}, nil
+}
+
+func (s *passwordChangedRepo) EmailExists(context.Context, string) (bool, error) { return false, nil }
+func (s *passwordChangedRepo) Create(context.Context, string, string) (*services.User, error) {
+	return nil, nil
+}
+func (s *passwordChangedRepo) GetByEmail(context.Context, string) (*services.User, error) {
+	return nil, services.ErrUserNotFound
+}
+func (s *passwordChangedRepo) UpdatePassword(context.Context, int, string) (time.Time, error) {
+	return time.Time{}, nil
+}
+func (s *passwordChangedRepo) SaveEmailChange(context.Context, int, string, []byte, time.Time) error {
+	return nil
+}
+func (s *passwordChangedRepo) GetPendingEmail(context.Context, int) (string, error) { return "", nil }
+func (s *passwordChangedRepo) ConfirmEmailChange(context.Context, int, []byte) (string, error) {
+	return "", nil
+}
+
+func TestRequireAuth_SignsOutSessionsBeforePasswordChange(t *testing.T) {
+	sm := scs.New()
+	sm.Store = memstore.New()
+	repo := &passwordChangedRepo{}
+
+	r := chi.NewRouter()
+	r.Use(sm.LoadAndSave)
+	r.Post("/login", func(_ http.ResponseWriter, r *http.Request) {
+		if err := auth.SetSessionUserID(r.Context(), sm, 1); err != nil {
+			t.Fatal(err)
+		}
+	})
+	r.Post("/keep", func(_ http.ResponseWriter, r *http.Request) {
+		if err := auth.KeepSessionAfterPasswordChange(r.Context(), sm, *repo.validAfter); err != nil {
+			t.Fatal(err)
+		}
+	})
+	r.With(auth.RequireAuth(services.NewUserService(repo), sm)).Get("/protected", func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	})
+
+	do := func(method, path string, cookie *http.Cookie) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, nil)
+		if cookie != nil {
+			req.AddCookie(cookie)
+		}
+		rec := httptest.NewRecorder()
+		r.ServeHTTP(rec, req)
+		return rec
+	}
+
+	older := do(http.MethodPost, "/login", nil).Result().Cookies()[0]
+	current := do(http.MethodPost, "/login", nil).Result().Cookies()[0]
+	if rec := do(http.MethodGet, "/protected", older); rec.Code != http.StatusNoContent {
+		t.Fatalf("before the change: status = %d, want %d", rec.Code, http.StatusNoContent)
+	}
+
+	changed := time.Now().Add(time.Second)
+	repo.validAfter = &changed
+	kept := do(http.MethodPost, "/keep", current).Result().Cookies()[0]
+
+	if rec := do(http.MethodGet, "/protected", older); rec.Code != http.StatusSeeOther {
+		t.Errorf("other session: status = %d, want %d", rec.Code, http.StatusSeeOther)
+	}
+	if rec := do(http.MethodGet, "/protected", kept); rec.Code != http.StatusNoContent {
+		t.Errorf("session that changed the password: status = %d, want %d", rec.Code, http.StatusNoContent)
+	}
+}
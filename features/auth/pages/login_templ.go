@@ -9,11 +9,18 @@ import "github.com/a-h/templ"
 import templruntime "github.com/a-h/templ/runtime"
 
 import (
+	"github.com/cavenine/queryops/features/common/components"
 	"github.com/cavenine/queryops/features/common/components/icon"
 	"github.com/cavenine/queryops/features/common/layouts"
+	"github.com/cavenine/queryops/internal/antibot"
+	"github.com/cavenine/queryops/internal/validate"
 )
 
-func LoginPage(email, errorMsg string) templ.Component {
+// LoginPage renders the login form and the passkey login button, each
+// protected by its own antibot challenge. fields holds the form's invalid
+// inputs, if any.
+
+func LoginPage(email, errorMsg string, fields validate.Errors, login, passkey antibot.Challenge) templ.Component {
 	return templruntime.GeneratedTemplate(func(templ_7745c5c3_Input templruntime.GeneratedComponentInput) (templ_7745c5c3_Err error) {
 		templ_7745c5c3_W, ctx := templ_7745c5c3_Input.Writer, templ_7745c5c3_Input.Context
 		if templ_7745c5c3_CtxErr := ctx.Err(); templ_7745c5c3_CtxErr != nil {
@@ -66,7 +73,7 @@ func LoginPage(email, errorMsg string) templ.Component {
 				var templ_7745c5c3_Var3 string
 				templ_7745c5c3_Var3, templ_7745c5c3_Err = templ.JoinStringErrs(errorMsg)
 				if templ_7745c5c3_Err != nil {
-					return templ.Error{Err: templ_7745c5c3_Err, FileName: `features/auth/pages/login.templ`, Line: 20, Col: 23}
+					return templ.Error{Err: templ_7745c5c3_Err, FileName: `features/auth/pages/login.templ`, Line: 26, Col: 23}
 				}
 				_, templ_7745c5c3_Err = templ_7745c5c3_Buffer.WriteString(templ.EscapeString(templ_7745c5c3_Var3))
 				if templ_7745c5c3_Err != nil {
@@ -77,7 +84,15 @@ func LoginPage(email, errorMsg string) templ.Component {
 					return templ_7745c5c3_Err
 				}
 			}
-			templ_7745c5c3_Err = templruntime.WriteString(templ_7745c5c3_Buffer, 5, "<!-- Passkey error message (populated by JS) --><div id=\"passkey-error\" class=\"alert alert-error hidden\" role=\"alert\"><span id=\"passkey-error-message\"></span></div><!-- Passkey login button --><button type=\"button\" id=\"passkey-login-btn\" class=\"btn btn-outline btn-primary w-full gap-2\" onclick=\"loginWithPasskey()\"><span id=\"passkey-login-spinner\" class=\"loading loading-spinner loading-sm hidden\"></span> <span id=\"passkey-login-icon\">")
+			templ_7745c5c3_Err = templruntime.WriteString(templ_7745c5c3_Buffer, 5, "<!-- Passkey error message (populated by JS) --><div id=\"passkey-error\" class=\"alert alert-error hidden\" role=\"alert\"><span id=\"passkey-error-message\"></span></div><!-- Passkey login button --><button type=\"button\" id=\"passkey-login-btn\" class=\"btn btn-outline btn-primary w-full gap-2\" data-on:click=\"loginWithPasskey()\"")
+			if templ_7745c5c3_Err != nil {
+				return templ_7745c5c3_Err
+			}
+			templ_7745c5c3_Err = templ.RenderAttributes(ctx, templ_7745c5c3_Buffer, antibot.Attrs(passkey))
+			if templ_7745c5c3_Err != nil {
+				return templ_7745c5c3_Err
+			}
+			templ_7745c5c3_Err = templruntime.WriteString(templ_7745c5c3_Buffer, 6, "><span id=\"passkey-login-spinner\" class=\"loading loading-spinner loading-sm hidden\"></span> <span id=\"passkey-login-icon\">")
 			if templ_7745c5c3_Err != nil {
 				return templ_7745c5c3_Err
 			}
@@ -85,20 +100,122 @@ func LoginPage(email, errorMsg string) templ.Component {
 			if templ_7745c5c3_Err != nil {
 				return templ_7745c5c3_Err
 			}
-			templ_7745c5c3_Err = templruntime.WriteString(templ_7745c5c3_Buffer, 6, "</span> <span id=\"passkey-login-text\">Sign in with Passkey</span></button><div class=\"divider\">OR</div><form method=\"POST\" action=\"/login\" class=\"space-y-4\"><div class=\"form-control\"><label class=\"label\" for=\"email\"><span class=\"label-text\">Email</span></label> <input type=\"email\" id=\"email\" name=\"email\" value=\"")
+			templ_7745c5c3_Err = templruntime.WriteString(templ_7745c5c3_Buffer, 7, "</span> <span id=\"passkey-login-text\">Sign in with Passkey</span></button><div class=\"divider\">OR</div><form method=\"POST\" action=\"/login\" class=\"space-y-4\"")
+			if templ_7745c5c3_Err != nil {
+				return templ_7745c5c3_Err
+			}
+			templ_7745c5c3_Err = templ.RenderAttributes(ctx, templ_7745c5c3_Buffer, antibot.Attrs(login))
+			if templ_7745c5c3_Err != nil {
+				return templ_7745c5c3_Err
+			}
+			templ_7745c5c3_Err = templruntime.WriteString(templ_7745c5c3_Buffer, 8, ">")
+			if templ_7745c5c3_Err != nil {
+				return templ_7745c5c3_Err
+			}
+			templ_7745c5c3_Err = antibot.Fields().Render(ctx, templ_7745c5c3_Buffer)
+			if templ_7745c5c3_Err != nil {
+				return templ_7745c5c3_Err
+			}
+			templ_7745c5c3_Err = templruntime.WriteString(templ_7745c5c3_Buffer, 9, "<div class=\"form-control\"><label class=\"label\" for=\"email\"><span class=\"label-text\">Email</span></label>")
+			if templ_7745c5c3_Err != nil {
+				return templ_7745c5c3_Err
+			}
+			var templ_7745c5c3_Var4 = []any{"input input-bordered w-full", templ.KV("input-error", fields.Has("email"))}
+			templ_7745c5c3_Err = templ.RenderCSSItems(ctx, templ_7745c5c3_Buffer, templ_7745c5c3_Var4...)
+			if templ_7745c5c3_Err != nil {
+				return templ_7745c5c3_Err
+			}
+			templ_7745c5c3_Err = templruntime.WriteString(templ_7745c5c3_Buffer, 10, "<input type=\"email\" id=\"email\" name=\"email\" value=\"")
+			if templ_7745c5c3_Err != nil {
+				return templ_7745c5c3_Err
+			}
+			var templ_7745c5c3_Var5 string
+			templ_7745c5c3_Var5, templ_7745c5c3_Err = templ.JoinStringErrs(email)
+			if templ_7745c5c3_Err != nil {
+				return templ.Error{Err: templ_7745c5c3_Err, FileName: `features/auth/pages/login.templ`, Line: 58, Col: 21}
+			}
+			_, templ_7745c5c3_Err = templ_7745c5c3_Buffer.WriteString(templ.EscapeString(templ_7745c5c3_Var5))
+			if templ_7745c5c3_Err != nil {
+				return templ_7745c5c3_Err
+			}
+			templ_7745c5c3_Err = templruntime.WriteString(templ_7745c5c3_Buffer, 11, "\" class=\"")
+			if templ_7745c5c3_Err != nil {
+				return templ_7745c5c3_Err
+			}
+			var templ_7745c5c3_Var6 string
+			templ_7745c5c3_Var6, templ_7745c5c3_Err = templ.JoinStringErrs(templ.CSSClasses(templ_7745c5c3_Var4).String())
+			if templ_7745c5c3_Err != nil {
+				return templ.Error{Err: templ_7745c5c3_Err, FileName: `features/auth/pages/login.templ`, Line: 1, Col: 0}
+			}
+			_, templ_7745c5c3_Err = templ_7745c5c3_Buffer.WriteString(templ.EscapeString(templ_7745c5c3_Var6))
+			if templ_7745c5c3_Err != nil {
+				return templ_7745c5c3_Err
+			}
+			templ_7745c5c3_Err = templruntime.WriteString(templ_7745c5c3_Buffer, 12, "\" placeholder=\"you@example.com\" required>")
+			if templ_7745c5c3_Err != nil {
+				return templ_7745c5c3_Err
+			}
+			templ_7745c5c3_Err = components.FieldError(fields, "email").Render(ctx, templ_7745c5c3_Buffer)
+			if templ_7745c5c3_Err != nil {
+				return templ_7745c5c3_Err
+			}
+			templ_7745c5c3_Err = templruntime.WriteString(templ_7745c5c3_Buffer, 13, "</div><div class=\"form-control\"><label class=\"label\" for=\"password\"><span class=\"label-text\">Password</span></label>")
+			if templ_7745c5c3_Err != nil {
+				return templ_7745c5c3_Err
+			}
+			var templ_7745c5c3_Var7 = []any{"input input-bordered w-full", templ.KV("input-error", fields.Has("password"))}
+			templ_7745c5c3_Err = templ.RenderCSSItems(ctx, templ_7745c5c3_Buffer, templ_7745c5c3_Var7...)
+			if templ_7745c5c3_Err != nil {
+				return templ_7745c5c3_Err
+			}
+			templ_7745c5c3_Err = templruntime.WriteString(templ_7745c5c3_Buffer, 14, "<input type=\"password\" id=\"password\" name=\"password\" class=\"")
+			if templ_7745c5c3_Err != nil {
+				return templ_7745c5c3_Err
+			}
+			var templ_7745c5c3_Var8 string
+			templ_7745c5c3_Var8, templ_7745c5c3_Err = templ.JoinStringErrs(templ.CSSClasses(templ_7745c5c3_Var7).String())
+			if templ_7745c5c3_Err != nil {
+				return templ.Error{Err: templ_7745c5c3_Err, FileName: `features/auth/pages/login.templ`, Line: 1, Col: 0}
+			}
+			_, templ_7745c5c3_Err = templ_7745c5c3_Buffer.WriteString(templ.EscapeString(templ_7745c5c3_Var8))
+			if templ_7745c5c3_Err != nil {
+				return templ_7745c5c3_Err
+			}
+			templ_7745c5c3_Err = templruntime.WriteString(templ_7745c5c3_Buffer, 15, "\" placeholder=\"Enter your password\" required>")
+			if templ_7745c5c3_Err != nil {
+				return templ_7745c5c3_Err
+			}
+			templ_7745c5c3_Err = components.FieldError(fields, "password").Render(ctx, templ_7745c5c3_Buffer)
+			if templ_7745c5c3_Err != nil {
+				return templ_7745c5c3_Err
+			}
+			templ_7745c5c3_Err = templruntime.WriteString(templ_7745c5c3_Buffer, 16, "</div><div class=\"form-control mt-6\"><button type=\"submit\" class=\"btn btn-primary w-full\">Login with Password</button></div></form><p class=\"text-center text-sm\">Don't have an account? <a href=\"/register\" class=\"link link-primary\">Register</a></p></div></div></div><!-- SimpleWebAuthn Browser Library --><script nonce=\"")
+			if templ_7745c5c3_Err != nil {
+				return templ_7745c5c3_Err
+			}
+			var templ_7745c5c3_Var9 string
+			templ_7745c5c3_Var9, templ_7745c5c3_Err = templ.JoinStringErrs(templ.GetNonce(ctx))
+			if templ_7745c5c3_Err != nil {
+				return templ.Error{Err: templ_7745c5c3_Err, FileName: `features/auth/pages/login.templ`, Line: 91, Col: 37}
+			}
+			_, templ_7745c5c3_Err = templ_7745c5c3_Buffer.WriteString(templ.EscapeString(templ_7745c5c3_Var9))
+			if templ_7745c5c3_Err != nil {
+				return templ_7745c5c3_Err
+			}
+			templ_7745c5c3_Err = templruntime.WriteString(templ_7745c5c3_Buffer, 17, "\" src=\"https://unpkg.com/@simplewebauthn/browser/dist/bundle/index.umd.min.js\"></script><script nonce=\"")
 			if templ_7745c5c3_Err != nil {
 				return templ_7745c5c3_Err
 			}
-			var templ_7745c5c3_Var4 string
-			templ_7745c5c3_Var4, templ_7745c5c3_Err = templ.JoinStringErrs(email)
+			var templ_7745c5c3_Var10 string
+			templ_7745c5c3_Var10, templ_7745c5c3_Err = templ.JoinStringErrs(templ.GetNonce(ctx))
 			if templ_7745c5c3_Err != nil {
-				return templ.Error{Err: templ_7745c5c3_Err, FileName: `features/auth/pages/login.templ`, Line: 50, Col: 21}
+				return templ.Error{Err: templ_7745c5c3_Err, FileName: `features/auth/pages/login.templ`, Line: 92, Col: 37}
 			}
-			_, templ_7745c5c3_Err = templ_7745c5c3_Buffer.WriteString(templ.EscapeString(templ_7745c5c3_Var4))
+			_, templ_7745c5c3_Err = templ_7745c5c3_Buffer.WriteString(templ.EscapeString(templ_7745c5c3_Var10))
 			if templ_7745c5c3_Err != nil {
 				return templ_7745c5c3_Err
 			}
-			templ_7745c5c3_Err = templruntime.WriteString(templ_7745c5c3_Buffer, 7, "\" class=\"input input-bordered w-full\" placeholder=\"you@example.com\" required></div><div class=\"form-control\"><label class=\"label\" for=\"password\"><span class=\"label-text\">Password</span></label> <input type=\"password\" id=\"password\" name=\"password\" class=\"input input-bordered w-full\" placeholder=\"Enter your password\" required></div><div class=\"form-control mt-6\"><button type=\"submit\" class=\"btn btn-primary w-full\">Login with Password</button></div></form><p class=\"text-center text-sm\">Don't have an account? <a href=\"/register\" class=\"link link-primary\">Register</a></p></div></div></div><!-- SimpleWebAuthn Browser Library --> <script src=\"https://unpkg.com/@simplewebauthn/browser/dist/bundle/index.umd.min.js\"></script> <script>\n\t\t\tasync function loginWithPasskey() {\n\t\t\t\tconst btn = document.getElementById('passkey-login-btn');\n\t\t\t\tconst errorDiv = document.getElementById('passkey-error');\n\t\t\t\tconst errorMsg = document.getElementById('passkey-error-message');\n\t\t\t\tconst spinner = document.getElementById('passkey-login-spinner');\n\t\t\t\tconst icon = document.getElementById('passkey-login-icon');\n\t\t\t\tconst text = document.getElementById('passkey-login-text');\n\t\t\t\t\n\t\t\t\t// Reset error state\n\t\t\t\terrorDiv.classList.add('hidden');\n\t\t\t\tbtn.disabled = true;\n\t\t\t\tspinner.classList.remove('hidden');\n\t\t\t\ticon.classList.add('hidden');\n\t\t\t\ttext.textContent = 'Authenticating...';\n\t\t\t\t\n\t\t\t\ttry {\n\t\t\t\t\t// Check if WebAuthn is supported\n\t\t\t\t\tif (!window.SimpleWebAuthnBrowser) {\n\t\t\t\t\t\tthrow new Error('WebAuthn is not supported in this browser');\n\t\t\t\t\t}\n\t\t\t\t\t\n\t\t\t\t\t// Step 1: Get authentication options from server\n\t\t\t\t\tconst beginResp = await fetch('/passkey/login/begin', {\n\t\t\t\t\t\tmethod: 'POST',\n\t\t\t\t\t\theaders: { 'Content-Type': 'application/json' },\n\t\t\t\t\t});\n\t\t\t\t\t\n\t\t\t\t\tif (!beginResp.ok) {\n\t\t\t\t\t\tconst data = await beginResp.json();\n\t\t\t\t\t\tthrow new Error(data.error || 'Failed to start authentication');\n\t\t\t\t\t}\n\t\t\t\t\t\n\t\t\t\t\tconst options = await beginResp.json();\n\t\t\t\t\t\n\t\t\t\t\t// Step 2: Trigger browser's passkey UI\n\t\t\t\t\tconst credential = await SimpleWebAuthnBrowser.startAuthentication({ optionsJSON: options });\n\t\t\t\t\t\n\t\t\t\t\t// Step 3: Send credential to server for verification\n\t\t\t\t\tconst finishResp = await fetch('/passkey/login/finish', {\n\t\t\t\t\t\tmethod: 'POST',\n\t\t\t\t\t\theaders: { 'Content-Type': 'application/json' },\n\t\t\t\t\t\tbody: JSON.stringify(credential),\n\t\t\t\t\t});\n\t\t\t\t\t\n\t\t\t\t\tconst result = await finishResp.json();\n\t\t\t\t\t\n\t\t\t\t\tif (!finishResp.ok) {\n\t\t\t\t\t\tthrow new Error(result.error || 'Failed to complete authentication');\n\t\t\t\t\t}\n\t\t\t\t\t\n\t\t\t\t\t// Success - redirect to home\n\t\t\t\t\twindow.location.href = result.redirect || '/';\n\t\t\t\t\t\n\t\t\t\t} catch (err) {\n\t\t\t\t\tconsole.error('Passkey login error:', err);\n\t\t\t\t\terrorMsg.textContent = err.message || 'Passkey authentication failed';\n\t\t\t\t\terrorDiv.classList.remove('hidden');\n\t\t\t\t\t\n\t\t\t\t// Reset button\n\t\t\t\tbtn.disabled = false;\n\t\t\t\tspinner.classList.add('hidden');\n\t\t\t\ticon.classList.remove('hidden');\n\t\t\t\ttext.textContent = 'Sign in with Passkey';\n\t\t\t\t}\n\t\t\t}\n\t\t\t\n\t\t\t// Check if WebAuthn is available and show/hide passkey button accordingly\n\t\t\tdocument.addEventListener('DOMContentLoaded', function() {\n\t\t\t\tconst btn = document.getElementById('passkey-login-btn');\n\t\t\t\tif (!window.PublicKeyCredential) {\n\t\t\t\t\tbtn.style.display = 'none';\n\t\t\t\t\t// Also hide the divider since there's no passkey option\n\t\t\t\t\tconst dividers = document.querySelectorAll('.divider');\n\t\t\t\t\tif (dividers.length > 0) {\n\t\t\t\t\t\tdividers[0].style.display = 'none';\n\t\t\t\t\t}\n\t\t\t\t}\n\t\t\t});\n\t\t</script>")
+			templ_7745c5c3_Err = templruntime.WriteString(templ_7745c5c3_Buffer, 18, "\">\n\t\t\tasync function loginWithPasskey() {\n\t\t\t\tconst btn = document.getElementById('passkey-login-btn');\n\t\t\t\tconst errorDiv = document.getElementById('passkey-error');\n\t\t\t\tconst errorMsg = document.getElementById('passkey-error-message');\n\t\t\t\tconst spinner = document.getElementById('passkey-login-spinner');\n\t\t\t\tconst icon = document.getElementById('passkey-login-icon');\n\t\t\t\tconst text = document.getElementById('passkey-login-text');\n\t\t\t\t\n\t\t\t\t// Reset error state\n\t\t\t\terrorDiv.classList.add('hidden');\n\t\t\t\tbtn.disabled = true;\n\t\t\t\tspinner.classList.remove('hidden');\n\t\t\t\ticon.classList.add('hidden');\n\t\t\t\ttext.textContent = 'Authenticating...';\n\t\t\t\t\n\t\t\t\ttry {\n\t\t\t\t\t// Check if WebAuthn is supported\n\t\t\t\t\tif (!window.SimpleWebAuthnBrowser) {\n\t\t\t\t\t\tthrow new Error('WebAuthn is not supported in this browser');\n\t\t\t\t\t}\n\t\t\t\t\t\n\t\t\t\t\t// Step 1: Get authentication options from server\n\t\t\t\t\tconst antibotHeaders = await window.antibot.headers(btn);\n\t\t\t\t\tconst beginResp = await fetch('/passkey/login/begin', {\n\t\t\t\t\t\tmethod: 'POST',\n\t\t\t\t\t\theaders: { 'Content-Type': 'application/json', ...antibotHeaders },\n\t\t\t\t\t});\n\t\t\t\t\t// Each begin consumes the challenge; keep the fresh one for a retry.\n\t\t\t\t\twindow.antibot.update(btn, beginResp);\n\t\t\t\t\t\n\t\t\t\t\tif (!beginResp.ok) {\n\t\t\t\t\t\tconst data = await beginResp.json();\n\t\t\t\t\t\tthrow new Error(data.error || 'Failed to start authentication');\n\t\t\t\t\t}\n\t\t\t\t\t\n\t\t\t\t\tconst options = await beginResp.json();\n\t\t\t\t\t\n\t\t\t\t\t// Step 2: Trigger browser's passkey UI\n\t\t\t\t\tconst credential = await SimpleWebAuthnBrowser.startAuthentication({ optionsJSON: options });\n\t\t\t\t\t\n\t\t\t\t\t// Step 3: Send credential to server for verification\n\t\t\t\t\tconst finishResp = await fetch('/passkey/login/finish', {\n\t\t\t\t\t\tmethod: 'POST',\n\t\t\t\t\t\theaders: { 'Content-Type': 'application/json' },\n\t\t\t\t\t\tbody: JSON.stringify(credential),\n\t\t\t\t\t});\n\t\t\t\t\t\n\t\t\t\t\tconst result = await finishResp.json();\n\t\t\t\t\t\n\t\t\t\t\tif (!finishResp.ok) {\n\t\t\t\t\t\tthrow new Error(result.error || 'Failed to complete authentication');\n\t\t\t\t\t}\n\t\t\t\t\t\n\t\t\t\t\t// Success - redirect to home\n\t\t\t\t\twindow.location.href = result.redirect || '/';\n\t\t\t\t\t\n\t\t\t\t} catch (err) {\n\t\t\t\t\tconsole.error('Passkey login error:', err);\n\t\t\t\t\terrorMsg.textContent = err.message || 'Passkey authentication failed';\n\t\t\t\t\terrorDiv.classList.remove('hidden');\n\t\t\t\t\t\n\t\t\t\t// Reset button\n\t\t\t\tbtn.disabled = false;\n\t\t\t\tspinner.classList.add('hidden');\n\t\t\t\ticon.classList.remove('hidden');\n\t\t\t\ttext.textContent = 'Sign in with Passkey';\n\t\t\t\t}\n\t\t\t}\n\t\t\t\n\t\t\t// Check if WebAuthn is available and show/hide passkey button accordingly\n\t\t\tdocument.addEventListener('DOMContentLoaded', function() {\n\t\t\t\tconst btn = document.getElementById('passkey-login-btn');\n\t\t\t\tif (!window.PublicKeyCredential) {\n\t\t\t\t\tbtn.style.display = 'none';\n\t\t\t\t\t// Also hide the divider since there's no passkey option\n\t\t\t\t\tconst dividers = document.querySelectorAll('.divider');\n\t\t\t\t\tif (dividers.length > 0) {\n\t\t\t\t\t\tdividers[0].style.display = 'none';\n\t\t\t\t\t}\n\t\t\t\t}\n\t\t\t});\n\t\t</script>")
 			if templ_7745c5c3_Err != nil {
 				return templ_7745c5c3_Err
 			}
@@ -8,6 +8,7 @@ import (
 	"net/http"
 
 	"github.com/cavenine/queryops/features/auth/services"
+	"github.com/cavenine/queryops/internal/antibot"
 
 	"github.com/alexedwards/scs/v2"
 	"github.com/go-webauthn/webauthn/protocol"
@@ -18,6 +19,8 @@ type PasskeyHandlers struct {
 	webauthnService *services.WebAuthnService
 	userService     *services.UserService
 	sessionManager  *scs.SessionManager
+	antibot         *antibot.Protector
+	events          securityEventRecorder
 }
 
 // NewPasskeyHandlers creates a new PasskeyHandlers instance.
@@ -25,11 +28,13 @@ func NewPasskeyHandlers(
 	webauthnService *services.WebAuthnService,
 	userService *services.UserService,
 	sessionManager *scs.SessionManager,
+	protector *antibot.Protector,
 ) *PasskeyHandlers {
 	return &PasskeyHandlers{
 		webauthnService: webauthnService,
 		userService:     userService,
 		sessionManager:  sessionManager,
+		antibot:         protector,
 	}
 }
 
@@ -113,6 +118,8 @@ func (h *PasskeyHandlers) RegisterFinish(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
+	RecordSecurityEvent(r, h.events, user.ID, services.EventPasskeyAdded)
+
 	jsonSuccess(w, map[string]any{
 		"success":      true,
 		"credentialId": credential.ID,
@@ -131,11 +138,25 @@ func (h *PasskeyHandlers) LoginBegin(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// The token was consumed; send a fresh one so the button can be used again.
+	if err := h.antibot.SetChallengeHeaders(w, r, passkeyLoginForm); err != nil {
+		jsonError(w, "Failed to start login", http.StatusInternalServerError)
+		return
+	}
+
 	// Return the PublicKeyCredentialRequestOptions directly (not wrapped in {publicKey: ...})
 	// SimpleWebAuthn expects the options object directly
 	jsonSuccess(w, options.Response)
 }
 
+func (h *PasskeyHandlers) loginBeginBlocked(w http.ResponseWriter, r *http.Request, _ antibot.Result) {
+	if err := h.antibot.SetChallengeHeaders(w, r, passkeyLoginForm); err != nil {
+		jsonError(w, "Failed to start login", http.StatusInternalServerError)
+		return
+	}
+	jsonError(w, blockedMessage, http.StatusUnprocessableEntity)
+}
+
 // LoginFinish completes the passkey login process.
 // Public endpoint - creates a session on success.
 func (h *PasskeyHandlers) LoginFinish(w http.ResponseWriter, r *http.Request) {
@@ -167,6 +188,8 @@ func (h *PasskeyHandlers) LoginFinish(w http.ResponseWriter, r *http.Request) {
 		jsonError(w, "Failed to create session", http.StatusInternalServerError)
 		return
 	}
+	SetSessionPasskeyAuth(ctx, h.sessionManager)
+	RecordSecurityEvent(r, h.events, user.ID, services.EventPasskeyLogin)
 
 	jsonSuccess(w, map[string]any{
 		"success":  true,
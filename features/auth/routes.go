@@ -2,9 +2,11 @@ package auth
 
 import (
 	"fmt"
+	"time"
 
 	"github.com/cavenine/queryops/config"
 	"github.com/cavenine/queryops/features/auth/services"
+	"github.com/cavenine/queryops/internal/antibot"
 
 	"github.com/alexedwards/scs/v2"
 	"github.com/go-chi/chi/v5"
@@ -16,6 +18,8 @@ type Feature struct {
 	userService     *services.UserService
 	webauthnService *services.WebAuthnService
 	credentialRepo  *services.CredentialRepository
+	events          *services.SecurityEventRepository
+	deletions       *services.AccountDeletionService
 	handlers        *Handlers
 	passkeyHandlers *PasskeyHandlers
 }
@@ -25,19 +29,34 @@ func NewAuthFeature(sessionManager *scs.SessionManager, pool *pgxpool.Pool) (*Fe
 	userRepo := services.NewUserRepository(pool)
 	userService := services.NewUserService(userRepo)
 	credentialRepo := services.NewCredentialRepository(pool)
+	events := services.NewSecurityEventRepository(pool)
+	deletions := services.NewAccountDeletionService(
+		services.NewAccountDeletionRepository(pool),
+		time.Duration(config.Global.AccountDeletionGraceMs)*time.Millisecond,
+	)
 
 	webauthnService, err := services.NewWebAuthnService(config.Global, credentialRepo, userRepo, sessionManager)
 	if err != nil {
 		return nil, fmt.Errorf("creating webauthn service: %w", err)
 	}
 
+	antibotCfg := antibot.DefaultConfig()
+	antibotCfg.PoWDifficulty = config.Global.AntibotPoWDifficulty
+
+	protector := antibot.New(sessionManager, antibotCfg)
+
 	handlers := NewHandlers(userService, sessionManager)
-	passkeyHandlers := NewPasskeyHandlers(webauthnService, userService, sessionManager)
+	handlers.SetAntibot(protector)
+	handlers.events = events
+	passkeyHandlers := NewPasskeyHandlers(webauthnService, userService, sessionManager, protector)
+	passkeyHandlers.events = events
 
 	return &Feature{
 		userService:     userService,
 		webauthnService: webauthnService,
 		credentialRepo:  credentialRepo,
+		events:          events,
+		deletions:       deletions,
 		handlers:        handlers,
 		passkeyHandlers: passkeyHandlers,
 	}, nil
@@ -53,17 +72,26 @@ func (f *Feature) CredentialRepo() *services.CredentialRepository {
 	return f.credentialRepo
 }
 
+// SecurityEvents returns the security event repository for use by other
+// packages (e.g., account feature).
+func (f *Feature) SecurityEvents() *services.SecurityEventRepository {
+	return f.events
+}
+
+// AccountDeletions returns the account deletion service for use by other
+// packages (e.g., account feature).
+func (f *Feature) AccountDeletions() *services.AccountDeletionService {
+	return f.deletions
+}
+
 // SetupPublicRoutes registers authentication routes that don't require authentication.
 func (f *Feature) SetupPublicRoutes(router chi.Router) {
 	// Standard auth routes
-	router.Get("/login", f.handlers.LoginPage)
-	router.Post("/login", f.handlers.LoginSubmit)
-	router.Get("/register", f.handlers.RegisterPage)
-	router.Post("/register", f.handlers.RegisterSubmit)
-	router.Post("/logout", f.handlers.Logout)
+	f.handlers.Routes(router)
 
 	// Public passkey login routes
-	router.Post("/passkey/login/begin", f.passkeyHandlers.LoginBegin)
+	p := f.passkeyHandlers
+	router.With(p.antibot.Protect(passkeyLoginForm, p.loginBeginBlocked)).Post("/passkey/login/begin", p.LoginBegin)
 	router.Post("/passkey/login/finish", f.passkeyHandlers.LoginFinish)
 }
 
@@ -0,0 +1,351 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/alexedwards/scs/v2"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// auditActionUserDeleted is the admin audit log action recorded when an
+// account is deleted.
+const auditActionUserDeleted = "user.deleted"
+
+// SessionUserIDKey and SessionImpersonatorIDKey are the session values
+// holding who a session is signed in as and, while impersonating, the
+// superuser behind it.
+const (
+	SessionUserIDKey         = "user_id"
+	SessionImpersonatorIDKey = "impersonator_id"
+)
+
+var (
+	// ErrAccountDeletionNotFound is returned when an account deletion link
+	// doesn't match a pending request, or the request has expired or was
+	// cancelled.
+	ErrAccountDeletionNotFound = errors.New("account deletion link is invalid or has expired")
+	// ErrSoleOwner is returned when deleting an account would leave one of
+	// its organizations without an owner.
+	ErrSoleOwner = errors.New("user is the only owner of an organization")
+)
+
+// AccountDeletion is a user's request to delete their account.
+type AccountDeletion struct {
+	UserID int `json:"user_id"`
+	// ConfirmedAt and DeleteAfter are set once the user followed the link
+	// emailed to them.
+	ConfirmedAt *time.Time `json:"confirmed_at,omitempty"`
+	DeleteAfter *time.Time `json:"delete_after,omitempty"`
+	CreatedAt   time.Time  `json:"created_at"`
+}
+
+// Confirmed reports whether the account is scheduled for deletion.
+func (d *AccountDeletion) Confirmed() bool {
+	return d.DeleteAfter != nil
+}
+
+// DeletedAccount is what's left to say about an account once it's deleted.
+type DeletedAccount struct {
+	UserID int
+	Email  string
+	// CampaignsReassigned counts campaigns the user created that now belong
+	// to another owner of their organization, and CampaignsOrphaned those
+	// left without a creator.
+	CampaignsReassigned int64
+	CampaignsOrphaned   int64
+}
+
+// AccountDeletionRepository handles data access for account deletions.
+type AccountDeletionRepository struct {
+	pool *pgxpool.Pool
+}
+
+// NewAccountDeletionRepository creates a new AccountDeletionRepository.
+func NewAccountDeletionRepository(pool *pgxpool.Pool) *AccountDeletionRepository {
+	return &AccountDeletionRepository{pool: pool}
+}
+
+// soleOwnedOrganizationsQuery returns the names of the organizations $1 is
+// the only owner of.
+const soleOwnedOrganizationsQuery = `
+	SELECT o.name
+	FROM organization_members m
+	JOIN organizations o ON o.id = m.organization_id
+	WHERE m.user_id = $1 AND m.role = 'owner'
+		AND NOT EXISTS (
+			SELECT 1 FROM organization_members other
+			WHERE other.organization_id = m.organization_id
+				AND other.role = 'owner' AND other.user_id <> m.user_id
+		)
+	ORDER BY o.name`
+
+// SoleOwnedOrganizations returns the names of the organizations the user is
+// the only owner of.
+func (r *AccountDeletionRepository) SoleOwnedOrganizations(ctx context.Context, userID int) ([]string, error) {
+	rows, err := r.pool.Query(ctx, soleOwnedOrganizationsQuery, userID)
+	if err != nil {
+		return nil, fmt.Errorf("querying owned organizations: %w", err)
+	}
+	names, err := pgx.CollectRows(rows, pgx.RowTo[string])
+	if err != nil {
+		return nil, fmt.Errorf("querying owned organizations: %w", err)
+	}
+	return names, nil
+}
+
+// Request records that the user asked to delete their account, replacing an
+// earlier request that wasn't confirmed. tokenHash is the SHA-256 of the
+// token emailed to them. A confirmed request is left alone.
+func (r *AccountDeletionRepository) Request(ctx context.Context, userID int, tokenHash []byte, expiresAt time.Time) error {
+	_, err := r.pool.Exec(ctx, `
+		INSERT INTO account_deletions (user_id, token_hash, token_expires_at)
+		VALUES ($1, $2, $3)
+		ON CONFLICT (user_id)
+		DO UPDATE SET token_hash = EXCLUDED.token_hash,
+			token_expires_at = EXCLUDED.token_expires_at,
+			created_at = NOW()
+		WHERE account_deletions.confirmed_at IS NULL
+	`, userID, tokenHash, expiresAt)
+	if err != nil {
+		return fmt.Errorf("saving account deletion: %w", err)
+	}
+	return nil
+}
+
+// Get returns the user's confirmed deletion, or their unconfirmed one whose
+// link still works, or nil if there is neither.
+func (r *AccountDeletionRepository) Get(ctx context.Context, userID int) (*AccountDeletion, error) {
+	d := &AccountDeletion{}
+	err := r.pool.QueryRow(ctx, `
+		SELECT user_id, confirmed_at, delete_after, created_at
+		FROM account_deletions
+		WHERE user_id = $1 AND (confirmed_at IS NOT NULL OR token_expires_at > NOW())
+	`, userID).Scan(&d.UserID, &d.ConfirmedAt, &d.DeleteAfter, &d.CreatedAt)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("querying account deletion: %w", err)
+	}
+	return d, nil
+}
+
+// Confirm schedules the user's account for deletion at deleteAfter if
+// tokenHash matches their unexpired, unconfirmed request. It returns
+// ErrAccountDeletionNotFound otherwise.
+func (r *AccountDeletionRepository) Confirm(ctx context.Context, userID int, tokenHash []byte, deleteAfter time.Time) (*AccountDeletion, error) {
+	d := &AccountDeletion{}
+	err := r.pool.QueryRow(ctx, `
+		UPDATE account_deletions
+		SET confirmed_at = NOW(), delete_after = $3
+		WHERE user_id = $1 AND token_hash = $2 AND token_expires_at > NOW() AND confirmed_at IS NULL
+		RETURNING user_id, confirmed_at, delete_after, created_at
+	`, userID, tokenHash, deleteAfter).Scan(&d.UserID, &d.ConfirmedAt, &d.DeleteAfter, &d.CreatedAt)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, ErrAccountDeletionNotFound
+		}
+		return nil, fmt.Errorf("confirming account deletion: %w", err)
+	}
+	return d, nil
+}
+
+// Cancel removes the user's deletion request, confirmed or not. It returns
+// ErrAccountDeletionNotFound if there was none.
+func (r *AccountDeletionRepository) Cancel(ctx context.Context, userID int) error {
+	tag, err := r.pool.Exec(ctx, `DELETE FROM account_deletions WHERE user_id = $1`, userID)
+	if err != nil {
+		return fmt.Errorf("cancelling account deletion: %w", err)
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrAccountDeletionNotFound
+	}
+	return nil
+}
+
+// ListDue returns the users whose confirmed deletion is due at now, oldest
+// first.
+func (r *AccountDeletionRepository) ListDue(ctx context.Context, now time.Time, limit int) ([]int, error) {
+	rows, err := r.pool.Query(ctx, `
+		SELECT user_id
+		FROM account_deletions
+		WHERE delete_after <= $1
+		ORDER BY delete_after, user_id
+		LIMIT $2
+	`, now, limit)
+	if err != nil {
+		return nil, fmt.Errorf("querying due account deletions: %w", err)
+	}
+	ids, err := pgx.CollectRows(rows, pgx.RowTo[int])
+	if err != nil {
+		return nil, fmt.Errorf("querying due account deletions: %w", err)
+	}
+	return ids, nil
+}
+
+// Delete deletes the user if their confirmed deletion is due at now, and
+// records it in the admin audit log. Campaigns they created move to the
+// longest-standing other owner of the campaign's organization, or are left
+// without a creator if it has none. Their credentials, memberships,
+// notifications, security events, and sessions go with the user row; other
+// records they created keep no reference to them. It returns
+// ErrAccountDeletionNotFound if the deletion was cancelled or isn't due, and
+// ErrSoleOwner if it would leave an organization without an owner.
+func (r *AccountDeletionRepository) Delete(ctx context.Context, userID int, now time.Time) (*DeletedAccount, error) {
+	tx, err := r.pool.Begin(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("starting transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	var requestedAt, confirmedAt time.Time
+	err = tx.QueryRow(ctx, `
+		SELECT created_at, confirmed_at
+		FROM account_deletions
+		WHERE user_id = $1 AND delete_after <= $2
+		FOR UPDATE
+	`, userID, now).Scan(&requestedAt, &confirmedAt)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, ErrAccountDeletionNotFound
+		}
+		return nil, fmt.Errorf("querying account deletion: %w", err)
+	}
+
+	deleted := &DeletedAccount{UserID: userID}
+	if err := tx.QueryRow(ctx, `SELECT email FROM users WHERE id = $1`, userID).Scan(&deleted.Email); err != nil {
+		return nil, fmt.Errorf("querying user: %w", err)
+	}
+	// Locking the memberships of the user's organizations keeps another
+	// owner from leaving between the check below and the commit.
+	rows, err := tx.Query(ctx, `
+		SELECT organization_id, user_id = $1
+		FROM organization_members
+		WHERE organization_id IN (SELECT organization_id FROM organization_members WHERE user_id = $1)
+		ORDER BY organization_id, user_id
+		FOR UPDATE
+	`, userID)
+	if err != nil {
+		return nil, fmt.Errorf("locking memberships: %w", err)
+	}
+	var organizationIDs []uuid.UUID
+	for rows.Next() {
+		var (
+			id     uuid.UUID
+			member bool
+		)
+		if err := rows.Scan(&id, &member); err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("locking memberships: %w", err)
+		}
+		if member {
+			organizationIDs = append(organizationIDs, id)
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("locking memberships: %w", err)
+	}
+	var soleOwned bool
+	if err := tx.QueryRow(ctx, `SELECT EXISTS (`+soleOwnedOrganizationsQuery+`)`, userID).Scan(&soleOwned); err != nil {
+		return nil, fmt.Errorf("querying owned organizations: %w", err)
+	}
+	if soleOwned {
+		return nil, ErrSoleOwner
+	}
+
+	err = tx.QueryRow(ctx, `
+		WITH reassigned AS (
+			UPDATE campaigns c
+			SET created_by = (
+				SELECT m.user_id FROM organization_members m
+				WHERE m.organization_id = c.organization_id AND m.role = 'owner' AND m.user_id <> $1
+				ORDER BY m.created_at, m.user_id
+				LIMIT 1
+			)
+			WHERE c.created_by = $1
+			RETURNING c.created_by
+		)
+		SELECT COUNT(*) FILTER (WHERE created_by IS NOT NULL), COUNT(*) FILTER (WHERE created_by IS NULL)
+		FROM reassigned
+	`, userID).Scan(&deleted.CampaignsReassigned, &deleted.CampaignsOrphaned)
+	if err != nil {
+		return nil, fmt.Errorf("reassigning campaigns: %w", err)
+	}
+
+	details, err := json.Marshal(map[string]any{
+		"user_id":              userID,
+		"organization_ids":     organizationIDs,
+		"campaigns_reassigned": deleted.CampaignsReassigned,
+		"campaigns_orphaned":   deleted.CampaignsOrphaned,
+		"requested_at":         requestedAt,
+		"confirmed_at":         confirmedAt,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("encoding audit details: %w", err)
+	}
+	// The entry keeps no email address or other personal data, and no
+	// reference to the deleted row.
+	if _, err := tx.Exec(ctx, `
+		INSERT INTO admin_audit_log (action, details)
+		VALUES ($1, $2)
+	`, auditActionUserDeleted, details); err != nil {
+		return nil, fmt.Errorf("recording audit log entry: %w", err)
+	}
+
+	if _, err := tx.Exec(ctx, `DELETE FROM users WHERE id = $1`, userID); err != nil {
+		return nil, fmt.Errorf("deleting user: %w", err)
+	}
+	if err := deleteSessions(ctx, tx, userID, now); err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return nil, fmt.Errorf("committing account deletion: %w", err)
+	}
+	return deleted, nil
+}
+
+// deleteSessions deletes userID's unexpired sessions: those signed in as
+// them, and those in which they're impersonating someone. Sessions are
+// stored encoded, so each is decoded to find whose it is.
+func deleteSessions(ctx context.Context, tx pgx.Tx, userID int, now time.Time) error {
+	rows, err := tx.Query(ctx, `SELECT token, data FROM sessions WHERE expiry > $1`, now)
+	if err != nil {
+		return fmt.Errorf("querying sessions: %w", err)
+	}
+	var tokens []string
+	for rows.Next() {
+		var (
+			token string
+			data  []byte
+		)
+		if err := rows.Scan(&token, &data); err != nil {
+			rows.Close()
+			return fmt.Errorf("querying sessions: %w", err)
+		}
+		// A session that doesn't decode can't be loaded to sign anyone in
+		// either.
+		_, values, err := scs.GobCodec{}.Decode(data)
+		if err != nil {
+			continue
+		}
+		if values[SessionUserIDKey] == userID || values[SessionImpersonatorIDKey] == userID {
+			tokens = append(tokens, token)
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("querying sessions: %w", err)
+	}
+
+	if _, err := tx.Exec(ctx, `DELETE FROM sessions WHERE token = ANY($1)`, tokens); err != nil {
+		return fmt.Errorf("deleting sessions: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,196 @@
+package services_test
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/alexedwards/scs/v2"
+	"github.com/jackc/pgx/v5"
+
+	"github.com/cavenine/queryops/features/auth/services"
+	"github.com/cavenine/queryops/internal/testdb"
+	"github.com/cavenine/queryops/internal/testdb/fixtures"
+)
+
+func TestAccountDeletionRepository(t *testing.T) {
+	tdb := testdb.SetupTestDB(t)
+	ctx := context.Background()
+	repo := services.NewAccountDeletionRepository(tdb.Pool)
+
+	user := fixtures.CreateUser(t, tdb.Pool, "leaving@example.com")
+	other := fixtures.CreateUser(t, tdb.Pool, "staying@example.com")
+	shared := fixtures.CreateOrg(t, tdb.Pool, "shared-org")
+	fixtures.AddMember(t, tdb.Pool, shared.ID, user.ID, "owner")
+	solo := fixtures.CreateOrg(t, tdb.Pool, "solo-org")
+	fixtures.AddMember(t, tdb.Pool, solo.ID, user.ID, "owner")
+	unowned := fixtures.CreateOrg(t, tdb.Pool, "unowned-org")
+	fixtures.AddMember(t, tdb.Pool, unowned.ID, user.ID, "member")
+
+	owned, err := repo.SoleOwnedOrganizations(ctx, user.ID)
+	if err != nil {
+		t.Fatalf("SoleOwnedOrganizations: %v", err)
+	}
+	if !slices.Equal(owned, []string{"shared-org", "solo-org"}) {
+		t.Fatalf("SoleOwnedOrganizations = %v", owned)
+	}
+	fixtures.AddMember(t, tdb.Pool, shared.ID, other.ID, "owner")
+
+	reassigned := fixtures.CreateCampaign(t, tdb.Pool, shared.ID, "SELECT 1")
+	orphaned := fixtures.CreateCampaign(t, tdb.Pool, unowned.ID, "SELECT 2")
+	if _, err := tdb.Pool.Exec(ctx, `UPDATE campaigns SET created_by = $1`, user.ID); err != nil {
+		t.Fatalf("setting campaign creator: %v", err)
+	}
+
+	if d, err := repo.Get(ctx, user.ID); err != nil || d != nil {
+		t.Fatalf("Get before Request = %+v, %v", d, err)
+	}
+	if err := repo.Request(ctx, user.ID, []byte("old-token"), time.Now().Add(time.Hour)); err != nil {
+		t.Fatalf("Request: %v", err)
+	}
+	if err := repo.Request(ctx, user.ID, []byte("token"), time.Now().Add(time.Hour)); err != nil {
+		t.Fatalf("second Request: %v", err)
+	}
+	if _, err := repo.Confirm(ctx, user.ID, []byte("old-token"), time.Now()); !errors.Is(err, services.ErrAccountDeletionNotFound) {
+		t.Fatalf("Confirm with replaced token err = %v", err)
+	}
+	if _, err := repo.Confirm(ctx, other.ID, []byte("token"), time.Now()); !errors.Is(err, services.ErrAccountDeletionNotFound) {
+		t.Fatalf("Confirm for another user err = %v", err)
+	}
+	d, err := repo.Get(ctx, user.ID)
+	if err != nil || d == nil || d.Confirmed() {
+		t.Fatalf("Get pending = %+v, %v", d, err)
+	}
+
+	deleteAfter := time.Now().Add(time.Hour)
+	d, err = repo.Confirm(ctx, user.ID, []byte("token"), deleteAfter)
+	if err != nil {
+		t.Fatalf("Confirm: %v", err)
+	}
+	if !d.Confirmed() || d.ConfirmedAt == nil {
+		t.Fatalf("Confirm = %+v", d)
+	}
+	// A new request doesn't undo a confirmed one.
+	if err := repo.Request(ctx, user.ID, []byte("new-token"), time.Now().Add(time.Hour)); err != nil {
+		t.Fatalf("Request after Confirm: %v", err)
+	}
+	if d, err := repo.Get(ctx, user.ID); err != nil || d == nil || !d.Confirmed() {
+		t.Fatalf("Get after new Request = %+v, %v", d, err)
+	}
+
+	if due, err := repo.ListDue(ctx, time.Now(), 10); err != nil || len(due) != 0 {
+		t.Fatalf("ListDue before the grace period = %v, %v", due, err)
+	}
+	if _, err := repo.Delete(ctx, user.ID, time.Now()); !errors.Is(err, services.ErrAccountDeletionNotFound) {
+		t.Fatalf("Delete before the grace period err = %v", err)
+	}
+
+	later := deleteAfter.Add(time.Minute)
+	due, err := repo.ListDue(ctx, later, 10)
+	if err != nil || !slices.Equal(due, []int{user.ID}) {
+		t.Fatalf("ListDue = %v, %v", due, err)
+	}
+	if _, err := repo.Delete(ctx, user.ID, later); !errors.Is(err, services.ErrSoleOwner) {
+		t.Fatalf("Delete while sole owner err = %v", err)
+	}
+
+	if _, err := tdb.Pool.Exec(ctx, `DELETE FROM organizations WHERE id = $1`, solo.ID); err != nil {
+		t.Fatalf("deleting organization: %v", err)
+	}
+	expiry := later.Add(time.Hour)
+	insertSession(t, tdb, "signed-in", expiry, map[string]any{services.SessionUserIDKey: user.ID})
+	insertSession(t, tdb, "impersonating", expiry, map[string]any{
+		services.SessionUserIDKey:         other.ID,
+		services.SessionImpersonatorIDKey: user.ID,
+	})
+	insertSession(t, tdb, "other", expiry, map[string]any{services.SessionUserIDKey: other.ID})
+	deleted, err := repo.Delete(ctx, user.ID, later)
+	if err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+	if deleted.Email != user.Email || deleted.CampaignsReassigned != 1 || deleted.CampaignsOrphaned != 1 {
+		t.Fatalf("Delete = %+v", deleted)
+	}
+
+	var exists bool
+	if err := tdb.Pool.QueryRow(ctx, `SELECT EXISTS (SELECT 1 FROM users WHERE id = $1)`, user.ID).Scan(&exists); err != nil || exists {
+		t.Fatalf("user still exists: %v, %v", exists, err)
+	}
+	var members int
+	if err := tdb.Pool.QueryRow(ctx, `SELECT COUNT(*) FROM organization_members WHERE user_id = $1`, user.ID).Scan(&members); err != nil || members != 0 {
+		t.Fatalf("memberships left = %d, %v", members, err)
+	}
+	var sessions []string
+	rows, err := tdb.Pool.Query(ctx, `SELECT token FROM sessions ORDER BY token`)
+	if err != nil {
+		t.Fatalf("querying sessions: %v", err)
+	}
+	if sessions, err = pgx.CollectRows(rows, pgx.RowTo[string]); err != nil || !slices.Equal(sessions, []string{"other"}) {
+		t.Fatalf("sessions left = %v, %v", sessions, err)
+	}
+	var reassignedTo, orphanedBy *int
+	if err := tdb.Pool.QueryRow(ctx, `SELECT created_by FROM campaigns WHERE id = $1`, reassigned.ID).Scan(&reassignedTo); err != nil {
+		t.Fatalf("querying campaign: %v", err)
+	}
+	if err := tdb.Pool.QueryRow(ctx, `SELECT created_by FROM campaigns WHERE id = $1`, orphaned.ID).Scan(&orphanedBy); err != nil {
+		t.Fatalf("querying campaign: %v", err)
+	}
+	if reassignedTo == nil || *reassignedTo != other.ID || orphanedBy != nil {
+		t.Fatalf("campaign creators = %v, %v", reassignedTo, orphanedBy)
+	}
+
+	var (
+		action string
+		userID int
+	)
+	err = tdb.Pool.QueryRow(ctx, `
+		SELECT action, (details->>'user_id')::int
+		FROM admin_audit_log
+		WHERE actor_user_id IS NULL
+		ORDER BY id DESC
+		LIMIT 1
+	`).Scan(&action, &userID)
+	if err != nil || action != "user.deleted" || userID != user.ID {
+		t.Fatalf("audit entry = %q for user %d, %v", action, userID, err)
+	}
+
+	if err := repo.Cancel(ctx, user.ID); !errors.Is(err, services.ErrAccountDeletionNotFound) {
+		t.Fatalf("Cancel after Delete err = %v", err)
+	}
+}
+
+func insertSession(t *testing.T, tdb *testdb.TestDB, token string, expiry time.Time, values map[string]any) {
+	t.Helper()
+	data, err := scs.GobCodec{}.Encode(expiry, values)
+	if err != nil {
+		t.Fatalf("encoding session: %v", err)
+	}
+	if _, err := tdb.Pool.Exec(context.Background(), `INSERT INTO sessions (token, data, expiry) VALUES ($1, $2, $3)`, token, data, expiry); err != nil {
+		t.Fatalf("inserting session: %v", err)
+	}
+}
+
+func TestAccountDeletionRepository_Cancel(t *testing.T) {
+	tdb := testdb.SetupTestDB(t)
+	ctx := context.Background()
+	repo := services.NewAccountDeletionRepository(tdb.Pool)
+	user := fixtures.CreateUser(t, tdb.Pool, "changed-mind@example.com")
+
+	if err := repo.Request(ctx, user.ID, []byte("token"), time.Now().Add(time.Hour)); err != nil {
+		t.Fatalf("Request: %v", err)
+	}
+	if _, err := repo.Confirm(ctx, user.ID, []byte("token"), time.Now()); err != nil {
+		t.Fatalf("Confirm: %v", err)
+	}
+	if err := repo.Cancel(ctx, user.ID); err != nil {
+		t.Fatalf("Cancel: %v", err)
+	}
+	if _, err := repo.Delete(ctx, user.ID, time.Now().Add(time.Hour)); !errors.Is(err, services.ErrAccountDeletionNotFound) {
+		t.Fatalf("Delete after Cancel err = %v", err)
+	}
+	if d, err := repo.Get(ctx, user.ID); err != nil || d != nil {
+		t.Fatalf("Get after Cancel = %+v, %v", d, err)
+	}
+}
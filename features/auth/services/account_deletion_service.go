@@ -0,0 +1,109 @@
+package services
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/cavenine/queryops/internal/validate"
+)
+
+// AccountDeletionLinkTTL is how long the link that confirms an account
+// deletion works.
+const AccountDeletionLinkTTL = 24 * time.Hour
+
+// ErrAccountDeletionScheduled is returned when a user asks to delete an
+// account that's already scheduled for deletion.
+var ErrAccountDeletionScheduled = errors.New("account is already scheduled for deletion")
+
+type accountDeletionRepository interface {
+	SoleOwnedOrganizations(ctx context.Context, userID int) ([]string, error)
+	Request(ctx context.Context, userID int, tokenHash []byte, expiresAt time.Time) error
+	Get(ctx context.Context, userID int) (*AccountDeletion, error)
+	Confirm(ctx context.Context, userID int, tokenHash []byte, deleteAfter time.Time) (*AccountDeletion, error)
+	Cancel(ctx context.Context, userID int) error
+}
+
+// AccountDeletionService handles users deleting their own accounts. A
+// request is confirmed from an emailed link, and the account is deleted a
+// grace period later unless the user cancels; see
+// AccountDeletionRepository.Delete.
+type AccountDeletionService struct {
+	repo  accountDeletionRepository
+	grace time.Duration
+}
+
+// NewAccountDeletionService creates an AccountDeletionService whose
+// confirmed deletions happen grace after confirmation.
+func NewAccountDeletionService(repo accountDeletionRepository, grace time.Duration) *AccountDeletionService {
+	return &AccountDeletionService{repo: repo, grace: grace}
+}
+
+// GracePeriod is how long after confirmation an account is deleted.
+func (s *AccountDeletionService) GracePeriod() time.Duration {
+	return s.grace
+}
+
+// Request starts deleting user's account. It returns the token to email to
+// them; the deletion is scheduled when Confirm gets it back within
+// AccountDeletionLinkTTL. Returns validate.Errors, keyed "current_password"
+// and "organizations", if the reauth fails or the user is the only owner of
+// an organization, and ErrAccountDeletionScheduled if the deletion is
+// already confirmed. Any other error is a database error.
+func (s *AccountDeletionService) Request(ctx context.Context, user *User, reauth Reauth) (string, error) {
+	existing, err := s.repo.Get(ctx, user.ID)
+	if err != nil {
+		return "", err
+	}
+	if existing != nil && existing.Confirmed() {
+		return "", ErrAccountDeletionScheduled
+	}
+
+	fields := validate.Errors{}
+	reauth.check(fields, user)
+	owned, err := s.repo.SoleOwnedOrganizations(ctx, user.ID)
+	if err != nil {
+		return "", err
+	}
+	if len(owned) > 0 {
+		fields.Add("organizations", fmt.Sprintf(
+			"You're the only owner of %s. Make another member an owner, or delete the organization, first.",
+			strings.Join(owned, ", ")))
+	}
+	if err := fields.Err(); err != nil {
+		return "", err
+	}
+
+	raw := make([]byte, 32)
+	if _, err := rand.Read(raw); err != nil {
+		return "", fmt.Errorf("generating account deletion token: %w", err)
+	}
+	token := base64.RawURLEncoding.EncodeToString(raw)
+	if err := s.repo.Request(ctx, user.ID, linkTokenHash(token), time.Now().Add(AccountDeletionLinkTTL)); err != nil {
+		return "", err
+	}
+	return token, nil
+}
+
+// Get returns the user's pending or scheduled deletion, or nil if there is
+// none.
+func (s *AccountDeletionService) Get(ctx context.Context, userID int) (*AccountDeletion, error) {
+	return s.repo.Get(ctx, userID)
+}
+
+// Confirm schedules the user's account for deletion after the grace period.
+// It returns ErrAccountDeletionNotFound if token doesn't match an unexpired
+// request of theirs.
+func (s *AccountDeletionService) Confirm(ctx context.Context, userID int, token string) (*AccountDeletion, error) {
+	return s.repo.Confirm(ctx, userID, linkTokenHash(token), time.Now().Add(s.grace))
+}
+
+// Cancel withdraws the user's deletion request. It returns
+// ErrAccountDeletionNotFound if there was none.
+func (s *AccountDeletionService) Cancel(ctx context.Context, userID int) error {
+	return s.repo.Cancel(ctx, userID)
+}
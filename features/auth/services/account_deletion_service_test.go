@@ -0,0 +1,118 @@
+package services_test
+
+import (
+	"context"
+	"crypto/sha256"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/cavenine/queryops/features/auth/services"
+	"github.com/cavenine/queryops/internal/validate"
+)
+
+type stubAccountDeletionRepo struct {
+	soleOwned []string
+	existing  *services.AccountDeletion
+
+	savedTokenHash   []byte
+	confirmTokenHash []byte
+	deleteAfter      time.Time
+}
+
+func (s *stubAccountDeletionRepo) SoleOwnedOrganizations(context.Context, int) ([]string, error) {
+	return s.soleOwned, nil
+}
+
+func (s *stubAccountDeletionRepo) Request(_ context.Context, _ int, tokenHash []byte, _ time.Time) error {
+	s.savedTokenHash = tokenHash
+	return nil
+}
+
+func (s *stubAccountDeletionRepo) Get(context.Context, int) (*services.AccountDeletion, error) {
+	return s.existing, nil
+}
+
+func (s *stubAccountDeletionRepo) Confirm(_ context.Context, userID int, tokenHash []byte, deleteAfter time.Time) (*services.AccountDeletion, error) {
+	s.confirmTokenHash = tokenHash
+	s.deleteAfter = deleteAfter
+	return &services.AccountDeletion{UserID: userID, DeleteAfter: &deleteAfter}, nil
+}
+
+func (s *stubAccountDeletionRepo) Cancel(context.Context, int) error {
+	return nil
+}
+
+func TestAccountDeletionService_Request(t *testing.T) {
+	user := &services.User{ID: 1, Email: "a@example.com"}
+	deleteAfter := time.Now().Add(time.Hour)
+	ctx := context.Background()
+
+	tests := []struct {
+		name       string
+		repo       *stubAccountDeletionRepo
+		reauth     services.Reauth
+		wantFields []string
+		wantErr    error
+	}{
+		{name: "no reauth", repo: &stubAccountDeletionRepo{}, wantFields: []string{"current_password"}},
+		{name: "sole owner", repo: &stubAccountDeletionRepo{soleOwned: []string{"acme"}}, reauth: services.Reauth{RecentPasskey: true}, wantFields: []string{"organizations"}},
+		{name: "both", repo: &stubAccountDeletionRepo{soleOwned: []string{"acme"}}, wantFields: []string{"current_password", "organizations"}},
+		{
+			name:    "already scheduled",
+			repo:    &stubAccountDeletionRepo{existing: &services.AccountDeletion{DeleteAfter: &deleteAfter}},
+			reauth:  services.Reauth{RecentPasskey: true},
+			wantErr: services.ErrAccountDeletionScheduled,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			service := services.NewAccountDeletionService(tt.repo, time.Hour)
+			_, err := service.Request(ctx, user, tt.reauth)
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("error = %v, want %v", err, tt.wantErr)
+				}
+			} else {
+				fields, ok := validate.As(err)
+				if !ok {
+					t.Fatalf("error = %v, want field errors", err)
+				}
+				for _, field := range tt.wantFields {
+					if !fields.Has(field) {
+						t.Errorf("error = %v, want a %q field error", err, field)
+					}
+				}
+			}
+			if tt.repo.savedTokenHash != nil {
+				t.Error("request saved despite the error")
+			}
+		})
+	}
+}
+
+func TestAccountDeletionService_RequestAndConfirm(t *testing.T) {
+	user := &services.User{ID: 1, Email: "a@example.com"}
+	repo := &stubAccountDeletionRepo{}
+	service := services.NewAccountDeletionService(repo, 48*time.Hour)
+	ctx := context.Background()
+
+	token, err := service.Request(ctx, user, services.Reauth{RecentPasskey: true})
+	if err != nil {
+		t.Fatalf("Request: %v", err)
+	}
+	sum := sha256.Sum256([]byte(token))
+	if token == "" || string(repo.savedTokenHash) != string(sum[:]) {
+		t.Fatalf("token = %q, saved hash = %x", token, repo.savedTokenHash)
+	}
+
+	if _, err := service.Confirm(ctx, user.ID, token); err != nil {
+		t.Fatalf("Confirm: %v", err)
+	}
+	if string(repo.confirmTokenHash) != string(sum[:]) {
+		t.Errorf("Confirm looked up %x, want %x", repo.confirmTokenHash, sum)
+	}
+	if until := time.Until(repo.deleteAfter); until < 47*time.Hour || until > 48*time.Hour {
+		t.Errorf("deletion scheduled in %v, want the 48h grace period", until)
+	}
+}
@@ -8,6 +8,7 @@ import (
 
 	"github.com/cavenine/queryops/features/auth/services"
 	"github.com/cavenine/queryops/internal/testdb"
+	"github.com/cavenine/queryops/internal/testdb/fixtures"
 	"github.com/go-webauthn/webauthn/protocol"
 	"github.com/go-webauthn/webauthn/webauthn"
 )
@@ -16,10 +17,7 @@ func TestCredentialRepository_Create(t *testing.T) {
 	tdb := testdb.SetupTestDB(t)
 	ctx := context.Background()
 
-	var userID int
-	if err := tdb.Pool.QueryRow(ctx, `INSERT INTO users (email, password_hash) VALUES ($1, $2) RETURNING id`, "test@example.com", "hash").Scan(&userID); err != nil {
-		t.Fatalf("insert user: %v", err)
-	}
+	userID := fixtures.CreateUser(t, tdb.Pool, "test@example.com").ID
 
 	repo := services.NewCredentialRepository(tdb.Pool)
 
@@ -58,10 +56,7 @@ func TestCredentialRepository_GetByUserID(t *testing.T) {
 	tdb := testdb.SetupTestDB(t)
 	ctx := context.Background()
 
-	var userID int
-	if err := tdb.Pool.QueryRow(ctx, `INSERT INTO users (email, password_hash) VALUES ($1, $2) RETURNING id`, "test@example.com", "hash").Scan(&userID); err != nil {
-		t.Fatalf("insert user: %v", err)
-	}
+	userID := fixtures.CreateUser(t, tdb.Pool, "test@example.com").ID
 
 	repo := services.NewCredentialRepository(tdb.Pool)
 
@@ -109,10 +104,7 @@ func TestCredentialRepository_GetByCredentialID(t *testing.T) {
 	tdb := testdb.SetupTestDB(t)
 	ctx := context.Background()
 
-	var userID int
-	if err := tdb.Pool.QueryRow(ctx, `INSERT INTO users (email, password_hash) VALUES ($1, $2) RETURNING id`, "test@example.com", "hash").Scan(&userID); err != nil {
-		t.Fatalf("insert user: %v", err)
-	}
+	userID := fixtures.CreateUser(t, tdb.Pool, "test@example.com").ID
 
 	repo := services.NewCredentialRepository(tdb.Pool)
 
@@ -186,10 +178,7 @@ func TestCredentialRepository_UpdateSignCount(t *testing.T) {
 	tdb := testdb.SetupTestDB(t)
 	ctx := context.Background()
 
-	var userID int
-	if err := tdb.Pool.QueryRow(ctx, `INSERT INTO users (email, password_hash) VALUES ($1, $2) RETURNING id`, "test@example.com", "hash").Scan(&userID); err != nil {
-		t.Fatalf("insert user: %v", err)
-	}
+	userID := fixtures.CreateUser(t, tdb.Pool, "test@example.com").ID
 
 	repo := services.NewCredentialRepository(tdb.Pool)
 
@@ -252,10 +241,7 @@ func TestCredentialRepository_CountByUserID(t *testing.T) {
 		{
 			name: "three credentials",
 			setup: func() int {
-				var uid int
-				if err := tdb.Pool.QueryRow(ctx, `INSERT INTO users (email, password_hash) VALUES ($1, $2) RETURNING id`, "count@example.com", "hash").Scan(&uid); err != nil {
-					t.Fatalf("insert user: %v", err)
-				}
+				uid := fixtures.CreateUser(t, tdb.Pool, "count@example.com").ID
 				for i := 0; i < 3; i++ {
 					cred := webauthn.Credential{
 						ID:              []byte{byte('a' + i)},
@@ -295,10 +281,7 @@ func TestCredentialRepository_GetPasskeysByUserID(t *testing.T) {
 	tdb := testdb.SetupTestDB(t)
 	ctx := context.Background()
 
-	var userID int
-	if err := tdb.Pool.QueryRow(ctx, `INSERT INTO users (email, password_hash) VALUES ($1, $2) RETURNING id`, "test@example.com", "hash").Scan(&userID); err != nil {
-		t.Fatalf("insert user: %v", err)
-	}
+	userID := fixtures.CreateUser(t, tdb.Pool, "test@example.com").ID
 
 	repo := services.NewCredentialRepository(tdb.Pool)
 
@@ -334,10 +317,7 @@ func TestCredentialRepository_UpdateNickname(t *testing.T) {
 	tdb := testdb.SetupTestDB(t)
 	ctx := context.Background()
 
-	var userID int
-	if err := tdb.Pool.QueryRow(ctx, `INSERT INTO users (email, password_hash) VALUES ($1, $2) RETURNING id`, "test@example.com", "hash").Scan(&userID); err != nil {
-		t.Fatalf("insert user: %v", err)
-	}
+	userID := fixtures.CreateUser(t, tdb.Pool, "test@example.com").ID
 
 	repo := services.NewCredentialRepository(tdb.Pool)
 
@@ -371,10 +351,7 @@ func TestCredentialRepository_DeleteByUserAndID(t *testing.T) {
 	tdb := testdb.SetupTestDB(t)
 	ctx := context.Background()
 
-	var userID int
-	if err := tdb.Pool.QueryRow(ctx, `INSERT INTO users (email, password_hash) VALUES ($1, $2) RETURNING id`, "test@example.com", "hash").Scan(&userID); err != nil {
-		t.Fatalf("insert user: %v", err)
-	}
+	userID := fixtures.CreateUser(t, tdb.Pool, "test@example.com").ID
 
 	repo := services.NewCredentialRepository(tdb.Pool)
 
@@ -0,0 +1,91 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// Security event kinds.
+const (
+	EventPasswordLogin        = "password_login"
+	EventPasskeyLogin         = "passkey_login"
+	EventPasswordChanged      = "password_changed"
+	EventEmailChangeRequested = "email_change_requested"
+	EventEmailChanged         = "email_changed"
+	EventPasskeyAdded         = "passkey_added"
+	EventPasskeyRemoved       = "passkey_removed"
+
+	EventAccountDeletionRequested = "account_deletion_requested"
+	EventAccountDeletionConfirmed = "account_deletion_confirmed"
+	EventAccountDeletionCancelled = "account_deletion_cancelled"
+)
+
+// maxUserAgentLength caps the user agent stored with an event.
+const maxUserAgentLength = 512
+
+// SecurityEvent is a sign-in or a change to a user's credentials, shown on
+// their account page.
+type SecurityEvent struct {
+	ID        int64     `json:"id"`
+	UserID    int       `json:"user_id"`
+	Kind      string    `json:"kind"`
+	IP        string    `json:"ip"`
+	UserAgent string    `json:"user_agent"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// SecurityEventRepository handles data access for security events.
+type SecurityEventRepository struct {
+	pool *pgxpool.Pool
+}
+
+// NewSecurityEventRepository creates a new SecurityEventRepository.
+func NewSecurityEventRepository(pool *pgxpool.Pool) *SecurityEventRepository {
+	return &SecurityEventRepository{pool: pool}
+}
+
+// Record stores an event of kind for the user, made from ip with userAgent.
+func (r *SecurityEventRepository) Record(ctx context.Context, userID int, kind, ip, userAgent string) error {
+	if len(userAgent) > maxUserAgentLength {
+		userAgent = userAgent[:maxUserAgentLength]
+	}
+	_, err := r.pool.Exec(ctx, `
+		INSERT INTO security_events (user_id, kind, ip, user_agent)
+		VALUES ($1, $2, $3, $4)
+	`, userID, kind, ip, userAgent)
+	if err != nil {
+		return fmt.Errorf("recording security event: %w", err)
+	}
+	return nil
+}
+
+// ListRecent returns the user's latest limit events, newest first.
+func (r *SecurityEventRepository) ListRecent(ctx context.Context, userID, limit int) ([]SecurityEvent, error) {
+	rows, err := r.pool.Query(ctx, `
+		SELECT id, user_id, kind, ip, user_agent, created_at
+		FROM security_events
+		WHERE user_id = $1
+		ORDER BY created_at DESC, id DESC
+		LIMIT $2
+	`, userID, limit)
+	if err != nil {
+		return nil, fmt.Errorf("listing security events: %w", err)
+	}
+	defer rows.Close()
+
+	var events []SecurityEvent
+	for rows.Next() {
+		var e SecurityEvent
+		if err := rows.Scan(&e.ID, &e.UserID, &e.Kind, &e.IP, &e.UserAgent, &e.CreatedAt); err != nil {
+			return nil, fmt.Errorf("scanning security event: %w", err)
+		}
+		events = append(events, e)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("listing security events: %w", err)
+	}
+	return events, nil
+}
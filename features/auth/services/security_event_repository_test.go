@@ -0,0 +1,40 @@
+package services_test
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/cavenine/queryops/features/auth/services"
+	"github.com/cavenine/queryops/internal/testdb"
+	"github.com/cavenine/queryops/internal/testdb/fixtures"
+)
+
+func TestSecurityEventRepository(t *testing.T) {
+	tdb := testdb.SetupTestDB(t)
+	ctx := context.Background()
+
+	u := fixtures.CreateUser(t, tdb.Pool, "events@example.com")
+	other := fixtures.CreateUser(t, tdb.Pool, "other@example.com")
+	repo := services.NewSecurityEventRepository(tdb.Pool)
+
+	for _, kind := range []string{services.EventPasswordLogin, services.EventPasskeyAdded, services.EventPasswordChanged} {
+		if err := repo.Record(ctx, u.ID, kind, "192.0.2.1", strings.Repeat("a", 600)); err != nil {
+			t.Fatalf("Record(%s): %v", kind, err)
+		}
+	}
+	if err := repo.Record(ctx, other.ID, services.EventPasswordLogin, "", ""); err != nil {
+		t.Fatalf("Record: %v", err)
+	}
+
+	events, err := repo.ListRecent(ctx, u.ID, 2)
+	if err != nil {
+		t.Fatalf("ListRecent: %v", err)
+	}
+	if len(events) != 2 || events[0].Kind != services.EventPasswordChanged || events[1].Kind != services.EventPasskeyAdded {
+		t.Fatalf("events = %+v, want the two newest, newest first", events)
+	}
+	if events[0].IP != "192.0.2.1" || len(events[0].UserAgent) != 512 {
+		t.Errorf("IP = %q, user agent length = %d, want 192.0.2.1 and 512", events[0].IP, len(events[0].UserAgent))
+	}
+}
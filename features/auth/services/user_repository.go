@@ -5,26 +5,49 @@ import (
 	"encoding/binary"
 	"errors"
 	"fmt"
+	"time"
 
 	"github.com/go-webauthn/webauthn/webauthn"
 	"github.com/jackc/pgx/v5"
 	"github.com/jackc/pgx/v5/pgconn"
 	"github.com/jackc/pgx/v5/pgxpool"
+
+	"github.com/cavenine/queryops/db"
 )
 
 // User represents a user account in the system.
 type User struct {
-	ID           int    `json:"id"`
-	Email        string `json:"email"`
-	PasswordHash string `json:"-"` // Never expose hash
+	ID           int    `json:"id" db:"id"`
+	Email        string `json:"email" db:"email"`
+	PasswordHash string `json:"-" db:"password_hash"` // Never expose hash
+	IsSuperuser  bool   `json:"is_superuser" db:"is_superuser"`
+
+	// SessionsValidAfter is when the password last changed. Sessions that
+	// signed in before it are rejected by auth.RequireAuth.
+	SessionsValidAfter *time.Time `json:"-" db:"sessions_valid_after"`
+
+	// ImpersonatedBy is the superuser acting as this user, when the request
+	// comes from an impersonation session. Set by auth.RequireAuth.
+	ImpersonatedBy *User `json:"-" db:"-"`
 
 	// Credentials holds the user's WebAuthn credentials (passkeys).
 	// Populated by loading from user_credentials table when needed.
-	Credentials []webauthn.Credential `json:"-"`
+	Credentials []webauthn.Credential `json:"-" db:"-"`
 }
 
-// ErrUserNotFound is returned when a user cannot be found.
-var ErrUserNotFound = errors.New("user not found")
+// userColumns selects a User, for pgx.RowToAddrOfStructByName.
+var userColumns = db.Columns[User]()
+
+var (
+	// ErrUserNotFound is returned when a user cannot be found.
+	ErrUserNotFound = errors.New("user not found")
+	// ErrEmailTaken is returned when another account already uses an email
+	// address.
+	ErrEmailTaken = errors.New("email already registered")
+	// ErrEmailChangeNotFound is returned when an email change link doesn't
+	// match a pending change, or the change has expired.
+	ErrEmailChangeNotFound = errors.New("email change link is invalid or has expired")
+)
 
 // WebAuthnID returns a unique identifier for the user (required by webauthn.User interface).
 // We use the user's database ID encoded as bytes.
@@ -70,14 +93,17 @@ func NewUserRepository(pool *pgxpool.Pool) *UserRepository {
 // GetByEmail retrieves a user by their email address.
 // Returns ErrUserNotFound if no user found.
 func (r *UserRepository) GetByEmail(ctx context.Context, email string) (*User, error) {
-	row := r.pool.QueryRow(ctx, `
-		SELECT id, email, password_hash
+	rows, err := r.pool.Query(ctx, `
+		SELECT `+userColumns+`
 		FROM users
 		WHERE email = $1
 	`, email)
+	if err != nil {
+		return nil, fmt.Errorf("querying user by email: %w", err)
+	}
 
-	user := &User{}
-	if err := row.Scan(&user.ID, &user.Email, &user.PasswordHash); err != nil {
+	user, err := pgx.CollectOneRow(rows, pgx.RowToAddrOfStructByName[User])
+	if err != nil {
 		if errors.Is(err, pgx.ErrNoRows) {
 			return nil, ErrUserNotFound
 		}
@@ -90,14 +116,17 @@ func (r *UserRepository) GetByEmail(ctx context.Context, email string) (*User, e
 // GetByID retrieves a user by their ID.
 // Returns ErrUserNotFound if no user found.
 func (r *UserRepository) GetByID(ctx context.Context, id int) (*User, error) {
-	row := r.pool.QueryRow(ctx, `
-		SELECT id, email, password_hash
+	rows, err := r.pool.Query(ctx, `
+		SELECT `+userColumns+`
 		FROM users
 		WHERE id = $1
 	`, id)
+	if err != nil {
+		return nil, fmt.Errorf("querying user by id: %w", err)
+	}
 
-	user := &User{}
-	if err := row.Scan(&user.ID, &user.Email, &user.PasswordHash); err != nil {
+	user, err := pgx.CollectOneRow(rows, pgx.RowToAddrOfStructByName[User])
+	if err != nil {
 		if errors.Is(err, pgx.ErrNoRows) {
 			return nil, ErrUserNotFound
 		}
@@ -109,18 +138,21 @@ func (r *UserRepository) GetByID(ctx context.Context, id int) (*User, error) {
 
 // Create inserts a new user into the database.
 func (r *UserRepository) Create(ctx context.Context, email, passwordHash string) (*User, error) {
-	user := &User{}
-	err := r.pool.QueryRow(ctx, `
+	rows, err := r.pool.Query(ctx, `
 		INSERT INTO users (email, password_hash)
 		VALUES ($1, $2)
-		RETURNING id, email, password_hash
-	`, email, passwordHash).Scan(&user.ID, &user.Email, &user.PasswordHash)
+		RETURNING `+userColumns, email, passwordHash)
+	if err != nil {
+		return nil, fmt.Errorf("creating user: %w", err)
+	}
 
+	// A unique violation is reported when the row is read.
+	user, err := pgx.CollectOneRow(rows, pgx.RowToAddrOfStructByName[User])
 	if err != nil {
 		// Check for unique violation (PostgreSQL error code 23505)
 		var pgErr *pgconn.PgError
 		if errors.As(err, &pgErr) && pgErr.Code == "23505" {
-			return nil, errors.New("email already registered")
+			return nil, ErrEmailTaken
 		}
 		return nil, fmt.Errorf("creating user: %w", err)
 	}
@@ -141,3 +173,100 @@ func (r *UserRepository) EmailExists(ctx context.Context, email string) (bool, e
 
 	return exists, nil
 }
+
+// UpdatePassword replaces the user's password hash and signs out their
+// sessions. It returns the new SessionsValidAfter.
+func (r *UserRepository) UpdatePassword(ctx context.Context, id int, passwordHash string) (time.Time, error) {
+	var validAfter time.Time
+	err := r.pool.QueryRow(ctx, `
+		UPDATE users
+		SET password_hash = $2, sessions_valid_after = NOW(), updated_at = NOW()
+		WHERE id = $1
+		RETURNING sessions_valid_after
+	`, id, passwordHash).Scan(&validAfter)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return time.Time{}, ErrUserNotFound
+		}
+		return time.Time{}, fmt.Errorf("updating password: %w", err)
+	}
+	return validAfter, nil
+}
+
+// SaveEmailChange records newEmail as waiting for verification, replacing any
+// earlier change the user started. tokenHash is the SHA-256 of the token sent
+// to newEmail.
+func (r *UserRepository) SaveEmailChange(ctx context.Context, userID int, newEmail string, tokenHash []byte, expiresAt time.Time) error {
+	_, err := r.pool.Exec(ctx, `
+		INSERT INTO email_changes (user_id, new_email, token_hash, expires_at)
+		VALUES ($1, $2, $3, $4)
+		ON CONFLICT (user_id)
+		DO UPDATE SET new_email = EXCLUDED.new_email,
+			token_hash = EXCLUDED.token_hash,
+			expires_at = EXCLUDED.expires_at,
+			created_at = NOW()
+	`, userID, newEmail, tokenHash, expiresAt)
+	if err != nil {
+		return fmt.Errorf("saving email change: %w", err)
+	}
+	return nil
+}
+
+// GetPendingEmail returns the address the user asked to change to, or "" if
+// there is no unexpired change.
+func (r *UserRepository) GetPendingEmail(ctx context.Context, userID int) (string, error) {
+	var email string
+	err := r.pool.QueryRow(ctx, `
+		SELECT new_email
+		FROM email_changes
+		WHERE user_id = $1 AND expires_at > NOW()
+	`, userID).Scan(&email)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return "", nil
+		}
+		return "", fmt.Errorf("querying pending email: %w", err)
+	}
+	return email, nil
+}
+
+// ConfirmEmailChange moves the user to the pending address whose token hashes
+// to tokenHash and returns it. It returns ErrEmailChangeNotFound if there is
+// no such unexpired change, and ErrEmailTaken if another account took the
+// address in the meantime.
+func (r *UserRepository) ConfirmEmailChange(ctx context.Context, userID int, tokenHash []byte) (string, error) {
+	tx, err := r.pool.Begin(ctx)
+	if err != nil {
+		return "", fmt.Errorf("confirming email change: begin transaction: %w", err)
+	}
+	defer func() { _ = tx.Rollback(ctx) }()
+
+	var email string
+	err = tx.QueryRow(ctx, `
+		DELETE FROM email_changes
+		WHERE user_id = $1 AND token_hash = $2 AND expires_at > NOW()
+		RETURNING new_email
+	`, userID, tokenHash).Scan(&email)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return "", ErrEmailChangeNotFound
+		}
+		return "", fmt.Errorf("confirming email change: %w", err)
+	}
+
+	_, err = tx.Exec(ctx, `
+		UPDATE users SET email = $2, updated_at = NOW() WHERE id = $1
+	`, userID, email)
+	if err != nil {
+		var pgErr *pgconn.PgError
+		if errors.As(err, &pgErr) && pgErr.Code == "23505" {
+			return "", ErrEmailTaken
+		}
+		return "", fmt.Errorf("confirming email change: updating email: %w", err)
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return "", fmt.Errorf("confirming email change: commit transaction: %w", err)
+	}
+	return email, nil
+}
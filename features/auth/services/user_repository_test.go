@@ -5,18 +5,18 @@ import (
 	"errors"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/cavenine/queryops/features/auth/services"
 	"github.com/cavenine/queryops/internal/testdb"
+	"github.com/cavenine/queryops/internal/testdb/fixtures"
 )
 
 func TestUserRepository_GetByEmail(t *testing.T) {
 	tdb := testdb.SetupTestDB(t)
 	ctx := context.Background()
 
-	if _, err := tdb.Pool.Exec(ctx, `INSERT INTO users (email, password_hash) VALUES ($1, $2)`, "test@example.com", "hash"); err != nil {
-		t.Fatalf("insert user: %v", err)
-	}
+	fixtures.CreateUser(t, tdb.Pool, "test@example.com")
 
 	repo := services.NewUserRepository(tdb.Pool)
 
@@ -62,10 +62,7 @@ func TestUserRepository_GetByID(t *testing.T) {
 	tdb := testdb.SetupTestDB(t)
 	ctx := context.Background()
 
-	var userID int
-	if err := tdb.Pool.QueryRow(ctx, `INSERT INTO users (email, password_hash) VALUES ($1, $2) RETURNING id`, "test@example.com", "hash").Scan(&userID); err != nil {
-		t.Fatalf("insert user: %v", err)
-	}
+	userID := fixtures.CreateUser(t, tdb.Pool, "test@example.com").ID
 
 	repo := services.NewUserRepository(tdb.Pool)
 
@@ -138,9 +135,7 @@ func TestUserRepository_Create(t *testing.T) {
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			if tt.name == "duplicate email" {
-				if _, err := tdb.Pool.Exec(ctx, `INSERT INTO users (email, password_hash) VALUES ($1, $2)`, tt.email, tt.passwordHash); err != nil {
-					t.Fatalf("seed user: %v", err)
-				}
+				fixtures.CreateUser(t, tdb.Pool, tt.email)
 			}
 
 			user, err := repo.Create(ctx, tt.email, tt.passwordHash)
@@ -164,9 +159,7 @@ func TestUserRepository_EmailExists(t *testing.T) {
 	tdb := testdb.SetupTestDB(t)
 	ctx := context.Background()
 
-	if _, err := tdb.Pool.Exec(ctx, `INSERT INTO users (email, password_hash) VALUES ($1, $2)`, "exists@example.com", "hash"); err != nil {
-		t.Fatalf("seed user: %v", err)
-	}
+	fixtures.CreateUser(t, tdb.Pool, "exists@example.com")
 
 	repo := services.NewUserRepository(tdb.Pool)
 
@@ -199,3 +192,80 @@ func TestUserRepository_EmailExists(t *testing.T) {
 		})
 	}
 }
+
+func TestUserRepository_UpdatePassword(t *testing.T) {
+	tdb := testdb.SetupTestDB(t)
+	ctx := context.Background()
+
+	u := fixtures.CreateUser(t, tdb.Pool, "pw@example.com")
+	repo := services.NewUserRepository(tdb.Pool)
+
+	validAfter, err := repo.UpdatePassword(ctx, u.ID, "newhash")
+	if err != nil {
+		t.Fatalf("UpdatePassword: %v", err)
+	}
+	user, err := repo.GetByID(ctx, u.ID)
+	if err != nil {
+		t.Fatalf("GetByID: %v", err)
+	}
+	if user.PasswordHash != "newhash" {
+		t.Errorf("PasswordHash = %q, want newhash", user.PasswordHash)
+	}
+	if user.SessionsValidAfter == nil || !user.SessionsValidAfter.Equal(validAfter) {
+		t.Errorf("SessionsValidAfter = %v, want %v", user.SessionsValidAfter, validAfter)
+	}
+
+	if _, err := repo.UpdatePassword(ctx, -1, "hash"); !errors.Is(err, services.ErrUserNotFound) {
+		t.Errorf("UpdatePassword(unknown) error = %v, want ErrUserNotFound", err)
+	}
+}
+
+func TestUserRepository_EmailChange(t *testing.T) {
+	tdb := testdb.SetupTestDB(t)
+	ctx := context.Background()
+
+	u := fixtures.CreateUser(t, tdb.Pool, "old@example.com")
+	fixtures.CreateUser(t, tdb.Pool, "taken@example.com")
+	repo := services.NewUserRepository(tdb.Pool)
+	expires := time.Now().Add(time.Hour)
+
+	if err := repo.SaveEmailChange(ctx, u.ID, "first@example.com", []byte("first"), expires); err != nil {
+		t.Fatalf("SaveEmailChange: %v", err)
+	}
+	// A second request replaces the first.
+	if err := repo.SaveEmailChange(ctx, u.ID, "new@example.com", []byte("second"), expires); err != nil {
+		t.Fatalf("SaveEmailChange: %v", err)
+	}
+	if got, err := repo.GetPendingEmail(ctx, u.ID); err != nil || got != "new@example.com" {
+		t.Fatalf("GetPendingEmail = %q, %v, want new@example.com", got, err)
+	}
+	if _, err := repo.ConfirmEmailChange(ctx, u.ID, []byte("first")); !errors.Is(err, services.ErrEmailChangeNotFound) {
+		t.Fatalf("ConfirmEmailChange(replaced token) error = %v, want ErrEmailChangeNotFound", err)
+	}
+
+	email, err := repo.ConfirmEmailChange(ctx, u.ID, []byte("second"))
+	if err != nil || email != "new@example.com" {
+		t.Fatalf("ConfirmEmailChange = %q, %v, want new@example.com", email, err)
+	}
+	user, err := repo.GetByID(ctx, u.ID)
+	if err != nil || user.Email != "new@example.com" {
+		t.Fatalf("user after confirming = %+v, %v", user, err)
+	}
+	if got, _ := repo.GetPendingEmail(ctx, u.ID); got != "" {
+		t.Errorf("GetPendingEmail after confirming = %q, want empty", got)
+	}
+
+	// Expired changes and addresses taken in the meantime are refused.
+	if err := repo.SaveEmailChange(ctx, u.ID, "late@example.com", []byte("late"), time.Now().Add(-time.Minute)); err != nil {
+		t.Fatalf("SaveEmailChange: %v", err)
+	}
+	if _, err := repo.ConfirmEmailChange(ctx, u.ID, []byte("late")); !errors.Is(err, services.ErrEmailChangeNotFound) {
+		t.Errorf("ConfirmEmailChange(expired) error = %v, want ErrEmailChangeNotFound", err)
+	}
+	if err := repo.SaveEmailChange(ctx, u.ID, "taken@example.com", []byte("taken"), expires); err != nil {
+		t.Fatalf("SaveEmailChange: %v", err)
+	}
+	if _, err := repo.ConfirmEmailChange(ctx, u.ID, []byte("taken")); !errors.Is(err, services.ErrEmailTaken) {
+		t.Errorf("ConfirmEmailChange(taken) error = %v, want ErrEmailTaken", err)
+	}
+}
@@ -2,9 +2,14 @@ package services
 
 import (
 	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
 	"errors"
 	"fmt"
+	"time"
 
+	"github.com/cavenine/queryops/internal/validate"
 	"golang.org/x/crypto/bcrypt"
 )
 
@@ -13,6 +18,10 @@ type userRepository interface {
 	Create(ctx context.Context, email, passwordHash string) (*User, error)
 	GetByEmail(ctx context.Context, email string) (*User, error)
 	GetByID(ctx context.Context, id int) (*User, error)
+	UpdatePassword(ctx context.Context, id int, passwordHash string) (time.Time, error)
+	SaveEmailChange(ctx context.Context, userID int, newEmail string, tokenHash []byte, expiresAt time.Time) error
+	GetPendingEmail(ctx context.Context, userID int) (string, error)
+	ConfirmEmailChange(ctx context.Context, userID int, tokenHash []byte) (string, error)
 }
 
 // UserService handles user authentication and account operations.
@@ -25,17 +34,24 @@ func NewUserService(repo userRepository) *UserService {
 	return &UserService{repo: repo}
 }
 
+// MinPasswordLength is the shortest password Register and ChangePassword
+// accept.
+const MinPasswordLength = 8
+
+// EmailChangeTTL is how long the link sent to a new email address works.
+const EmailChangeTTL = 24 * time.Hour
+
 // Register creates a new user account with the given email and password.
-// Returns an error if:
-// - email is invalid or already registered
-// - password is empty
-// - database error occurs.
+// Returns validate.Errors, keyed "email" and "password", if:
+// - email is missing, invalid or already registered
+// - password is missing or shorter than MinPasswordLength.
+// Any other error is a database error.
 func (s *UserService) Register(ctx context.Context, email, password string) (*User, error) {
-	if email == "" {
-		return nil, errors.New("email is required")
-	}
-	if password == "" {
-		return nil, errors.New("password is required")
+	fields := validate.Errors{}
+	fields.Field("email", email, validate.Required(), validate.Email(), validate.MaxLength(254))
+	fields.Field("password", password, validate.Required(), validate.MinLength(MinPasswordLength))
+	if err := fields.Err(); err != nil {
+		return nil, err
 	}
 
 	// Check if email already exists
@@ -44,7 +60,7 @@ func (s *UserService) Register(ctx context.Context, email, password string) (*Us
 		return nil, fmt.Errorf("checking email: %w", err)
 	}
 	if exists {
-		return nil, errors.New("email already registered")
+		return nil, validate.Errors{"email": "Already registered"}
 	}
 
 	// Hash password
@@ -94,3 +110,106 @@ func (s *UserService) Authenticate(ctx context.Context, email, password string)
 func (s *UserService) GetByID(ctx context.Context, id int) (*User, error) {
 	return s.repo.GetByID(ctx, id)
 }
+
+// Reauth is how a user proved it's them before changing their password or
+// email: their current password, or a recent passkey sign-in.
+type Reauth struct {
+	CurrentPassword string
+	// RecentPasskey is set when the session signed in with a passkey
+	// recently enough to skip the password.
+	RecentPasskey bool
+}
+
+// check records a problem under "current_password" unless the reauth is
+// valid for user.
+func (a Reauth) check(fields validate.Errors, user *User) {
+	switch {
+	case a.RecentPasskey:
+	case !user.HasPassword():
+		fields.Add("current_password", "Sign in with your passkey again to continue")
+	case a.CurrentPassword == "":
+		fields.Add("current_password", validate.MsgRequired)
+	case bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(a.CurrentPassword)) != nil:
+		fields.Add("current_password", "Incorrect password")
+	}
+}
+
+// ChangePassword sets user's password to newPassword and signs out their
+// other sessions. It returns when sessions became invalid, for renewing the
+// current one. Returns validate.Errors, keyed "current_password" and
+// "new_password", if the reauth fails or newPassword is shorter than
+// MinPasswordLength. Any other error is a database error.
+func (s *UserService) ChangePassword(ctx context.Context, user *User, reauth Reauth, newPassword string) (time.Time, error) {
+	fields := validate.Errors{}
+	reauth.check(fields, user)
+	fields.Field("new_password", newPassword, validate.Required(), validate.MinLength(MinPasswordLength))
+	if err := fields.Err(); err != nil {
+		return time.Time{}, err
+	}
+
+	hash, err := bcrypt.GenerateFromPassword([]byte(newPassword), bcrypt.DefaultCost)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("hashing password: %w", err)
+	}
+	validAfter, err := s.repo.UpdatePassword(ctx, user.ID, string(hash))
+	if err != nil {
+		return time.Time{}, fmt.Errorf("updating password: %w", err)
+	}
+	return validAfter, nil
+}
+
+// RequestEmailChange starts moving user to newEmail. It returns the token to
+// send to newEmail; the change happens when ConfirmEmailChange gets it back
+// within EmailChangeTTL. Returns validate.Errors, keyed "current_password"
+// and "email", if the reauth fails or newEmail is invalid, unchanged, or
+// already registered. Any other error is a database error.
+func (s *UserService) RequestEmailChange(ctx context.Context, user *User, reauth Reauth, newEmail string) (string, error) {
+	fields := validate.Errors{}
+	reauth.check(fields, user)
+	fields.Field("email", newEmail, validate.Required(), validate.Email(), validate.MaxLength(254))
+	if !fields.Has("email") && newEmail == user.Email {
+		fields.Add("email", "Already your email address")
+	}
+	if err := fields.Err(); err != nil {
+		return "", err
+	}
+
+	exists, err := s.repo.EmailExists(ctx, newEmail)
+	if err != nil {
+		return "", fmt.Errorf("checking email: %w", err)
+	}
+	if exists {
+		return "", validate.Errors{"email": "Already registered"}
+	}
+
+	raw := make([]byte, 32)
+	if _, err := rand.Read(raw); err != nil {
+		return "", fmt.Errorf("generating email change token: %w", err)
+	}
+	token := base64.RawURLEncoding.EncodeToString(raw)
+	if err := s.repo.SaveEmailChange(ctx, user.ID, newEmail, linkTokenHash(token), time.Now().Add(EmailChangeTTL)); err != nil {
+		return "", fmt.Errorf("saving email change: %w", err)
+	}
+	return token, nil
+}
+
+// ConfirmEmailChange moves the user to the address token was sent to and
+// returns it. It returns ErrEmailChangeNotFound if token doesn't match an
+// unexpired change for the user, and ErrEmailTaken if the address was
+// registered since.
+func (s *UserService) ConfirmEmailChange(ctx context.Context, userID int, token string) (string, error) {
+	return s.repo.ConfirmEmailChange(ctx, userID, linkTokenHash(token))
+}
+
+// GetPendingEmail returns the address the user is changing to, or "" if
+// there is none.
+func (s *UserService) GetPendingEmail(ctx context.Context, userID int) (string, error) {
+	return s.repo.GetPendingEmail(ctx, userID)
+}
+
+// linkTokenHash is the lookup key for a token emailed in a link. Tokens
+// are random, so an unsalted hash is enough to keep them out of the table.
+func linkTokenHash(token string) []byte {
+	sum := sha256.Sum256([]byte(token))
+	return sum[:]
+}
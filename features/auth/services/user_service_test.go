@@ -4,8 +4,10 @@ import (
 	"context"
 	"errors"
 	"testing"
+	"time"
 
 	"github.com/cavenine/queryops/features/auth/services"
+	"github.com/cavenine/queryops/internal/validate"
 	"golang.org/x/crypto/bcrypt"
 )
 
@@ -14,6 +16,10 @@ type stubUserRepo struct {
 	createFunc      func(ctx context.Context, email, passwordHash string) (*services.User, error)
 	getByEmailFunc  func(ctx context.Context, email string) (*services.User, error)
 	getByIDFunc     func(ctx context.Context, id int) (*services.User, error)
+
+	updatedPasswordHash string
+	savedEmail          string
+	savedTokenHash      []byte
 }
 
 func (s *stubUserRepo) EmailExists(ctx context.Context, email string) (bool, error) {
@@ -44,6 +50,25 @@ func (s *stubUserRepo) GetByID(ctx context.Context, id int) (*services.User, err
 	return nil, nil
 }
 
+func (s *stubUserRepo) UpdatePassword(_ context.Context, _ int, passwordHash string) (time.Time, error) {
+	s.updatedPasswordHash = passwordHash
+	return time.Now(), nil
+}
+
+func (s *stubUserRepo) SaveEmailChange(_ context.Context, _ int, newEmail string, tokenHash []byte, _ time.Time) error {
+	s.savedEmail = newEmail
+	s.savedTokenHash = tokenHash
+	return nil
+}
+
+func (s *stubUserRepo) GetPendingEmail(_ context.Context, _ int) (string, error) {
+	return s.savedEmail, nil
+}
+
+func (s *stubUserRepo) ConfirmEmailChange(_ context.Context, _ int, _ []byte) (string, error) {
+	return "", services.ErrEmailChangeNotFound
+}
+
 func TestRegister_Success(t *testing.T) {
 	repo := &stubUserRepo{
 		emailExistsFunc: func(ctx context.Context, email string) (bool, error) {
@@ -83,8 +108,9 @@ func TestRegister_EmptyEmail(t *testing.T) {
 	if err == nil {
 		t.Fatal("expected error, got nil")
 	}
-	if err.Error() != "email is required" {
-		t.Errorf("expected 'email is required' error, got: %v", err)
+	fields, ok := validate.As(err)
+	if !ok || fields.Get("email") != validate.MsgRequired {
+		t.Errorf("expected email field error %q, got: %v", validate.MsgRequired, err)
 	}
 }
 
@@ -97,8 +123,21 @@ func TestRegister_EmptyPassword(t *testing.T) {
 	if err == nil {
 		t.Fatal("expected error, got nil")
 	}
-	if err.Error() != "password is required" {
-		t.Errorf("expected 'password is required' error, got: %v", err)
+	fields, ok := validate.As(err)
+	if !ok || fields.Get("password") != validate.MsgRequired {
+		t.Errorf("expected password field error %q, got: %v", validate.MsgRequired, err)
+	}
+}
+
+func TestRegister_ShortPassword(t *testing.T) {
+	repo := &stubUserRepo{}
+	service := services.NewUserService(repo)
+
+	_, err := service.Register(context.Background(), "test@example.com", "short")
+
+	fields, ok := validate.As(err)
+	if !ok || !fields.Has("password") {
+		t.Errorf("expected password field error, got: %v", err)
 	}
 }
 
@@ -115,8 +154,9 @@ func TestRegister_DuplicateEmail(t *testing.T) {
 	if err == nil {
 		t.Fatal("expected error, got nil")
 	}
-	if err.Error() != "email already registered" {
-		t.Errorf("expected 'email already registered' error, got: %v", err)
+	fields, ok := validate.As(err)
+	if !ok || fields.Get("email") != "Already registered" {
+		t.Errorf("expected email field error %q, got: %v", "Already registered", err)
 	}
 }
 
@@ -239,3 +279,88 @@ func TestGetByID_NotFound(t *testing.T) {
 		t.Errorf("expected ErrUserNotFound, got: %v", err)
 	}
 }
+
+func TestChangePassword(t *testing.T) {
+	hash, err := bcrypt.GenerateFromPassword([]byte("oldpassword"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	withPassword := &services.User{ID: 1, Email: "a@example.com", PasswordHash: string(hash)}
+	passkeyOnly := &services.User{ID: 2, Email: "b@example.com"}
+
+	tests := []struct {
+		name      string
+		user      *services.User
+		reauth    services.Reauth
+		password  string
+		wantField string
+	}{
+		{name: "current password", user: withPassword, reauth: services.Reauth{CurrentPassword: "oldpassword"}, password: "newpassword"},
+		{name: "recent passkey", user: withPassword, reauth: services.Reauth{RecentPasskey: true}, password: "newpassword"},
+		{name: "passkey-only user sets a password", user: passkeyOnly, reauth: services.Reauth{RecentPasskey: true}, password: "newpassword"},
+		{name: "wrong current password", user: withPassword, reauth: services.Reauth{CurrentPassword: "nope"}, password: "newpassword", wantField: "current_password"},
+		{name: "missing current password", user: withPassword, password: "newpassword", wantField: "current_password"},
+		{name: "passkey-only user without passkey", user: passkeyOnly, reauth: services.Reauth{CurrentPassword: "anything"}, password: "newpassword", wantField: "current_password"},
+		{name: "short new password", user: withPassword, reauth: services.Reauth{RecentPasskey: true}, password: "short", wantField: "new_password"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			repo := &stubUserRepo{}
+			service := services.NewUserService(repo)
+
+			_, err := service.ChangePassword(context.Background(), tt.user, tt.reauth, tt.password)
+			if tt.wantField != "" {
+				fields, ok := validate.As(err)
+				if !ok || !fields.Has(tt.wantField) {
+					t.Fatalf("error = %v, want a %q field error", err, tt.wantField)
+				}
+				if repo.updatedPasswordHash != "" {
+					t.Error("password updated despite the error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ChangePassword: %v", err)
+			}
+			if bcrypt.CompareHashAndPassword([]byte(repo.updatedPasswordHash), []byte(tt.password)) != nil {
+				t.Error("stored hash doesn't match the new password")
+			}
+		})
+	}
+}
+
+func TestRequestEmailChange(t *testing.T) {
+	user := &services.User{ID: 1, Email: "old@example.com"}
+	reauth := services.Reauth{RecentPasskey: true}
+
+	repo := &stubUserRepo{
+		emailExistsFunc: func(_ context.Context, email string) (bool, error) {
+			return email == "taken@example.com", nil
+		},
+	}
+	service := services.NewUserService(repo)
+	ctx := context.Background()
+
+	for email, want := range map[string]string{
+		"not-an-email":      validate.MsgEmail,
+		"old@example.com":   "Already your email address",
+		"taken@example.com": "Already registered",
+	} {
+		_, err := service.RequestEmailChange(ctx, user, reauth, email)
+		if fields, ok := validate.As(err); !ok || fields.Get("email") != want {
+			t.Errorf("RequestEmailChange(%q) error = %v, want email %q", email, err, want)
+		}
+	}
+
+	token, err := service.RequestEmailChange(ctx, user, reauth, "new@example.com")
+	if err != nil {
+		t.Fatalf("RequestEmailChange: %v", err)
+	}
+	if token == "" || repo.savedEmail != "new@example.com" {
+		t.Fatalf("token = %q, saved email = %q", token, repo.savedEmail)
+	}
+	if string(repo.savedTokenHash) == token {
+		t.Error("token stored in plain text")
+	}
+}
@@ -0,0 +1,51 @@
+package dashboard
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/cavenine/queryops/features/dashboard/pages"
+	"github.com/cavenine/queryops/features/dashboard/services"
+	org "github.com/cavenine/queryops/features/organization"
+	osqueryPages "github.com/cavenine/queryops/features/osquery/pages"
+)
+
+type summaryReader interface {
+	Summary(ctx context.Context, organizationID uuid.UUID, onlineSince time.Time) (*services.Summary, error)
+}
+
+type Handlers struct {
+	repo summaryReader
+	// minOsqueryVersion, when set, marks older osquery versions as outdated.
+	minOsqueryVersion string
+}
+
+func NewHandlers(repo summaryReader, minOsqueryVersion string) *Handlers {
+	return &Handlers{repo: repo, minOsqueryVersion: minOsqueryVersion}
+}
+
+// DashboardPage summarizes the active organization's hosts and campaigns.
+func (h *Handlers) DashboardPage(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	activeOrg := org.GetOrganizationFromContext(ctx)
+	if activeOrg == nil {
+		slog.ErrorContext(ctx, "missing active organization in context")
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	summary, err := h.repo.Summary(ctx, activeOrg.ID, time.Now().Add(-osqueryPages.HostOnlineWindow))
+	if err != nil {
+		slog.ErrorContext(ctx, "failed to load dashboard", "error", err)
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	if err := pages.DashboardPage(summary, h.minOsqueryVersion).Render(ctx, w); err != nil {
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+	}
+}
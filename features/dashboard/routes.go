@@ -0,0 +1,25 @@
+package dashboard
+
+import (
+	"github.com/go-chi/chi/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+
+	"github.com/cavenine/queryops/config"
+	"github.com/cavenine/queryops/features/dashboard/services"
+)
+
+type Feature struct {
+	handlers *Handlers
+}
+
+func NewFeature(pool *pgxpool.Pool) *Feature {
+	return &Feature{handlers: NewHandlers(
+		services.NewDashboardRepository(pool, config.Global.DashboardLargeOrgHosts),
+		config.Global.OsqueryMinVersion,
+	)}
+}
+
+// SetupRoutes registers the dashboard. It requires an active organization.
+func (f *Feature) SetupRoutes(router chi.Router) {
+	router.Get("/dashboard", f.handlers.DashboardPage)
+}
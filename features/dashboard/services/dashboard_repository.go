@@ -0,0 +1,348 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+const (
+	// CampaignDays is how many days, including today, the campaign chart
+	// covers.
+	CampaignDays = 30
+
+	// FailingQueriesLimit is how many of the most failing queries a summary
+	// lists.
+	FailingQueriesLimit = 5
+)
+
+// HostStatus counts an organization's hosts by whether they checked in
+// recently.
+type HostStatus struct {
+	Online  int `json:"online"`
+	Offline int `json:"offline"`
+}
+
+func (s HostStatus) Total() int {
+	return s.Online + s.Offline
+}
+
+// PlatformCount is the number of hosts running one OS version.
+type PlatformCount struct {
+	Platform  string `json:"platform"`
+	OSVersion string `json:"os_version"`
+	Hosts     int    `json:"hosts"`
+}
+
+// OsqueryVersionCount is the number of hosts running one osquery version.
+// Hosts that haven't reported theirs count under "unknown".
+type OsqueryVersionCount struct {
+	Version string `json:"version"`
+	Hosts   int    `json:"hosts"`
+}
+
+// CampaignDay is one day of campaign activity, in UTC.
+type CampaignDay struct {
+	Day           time.Time `json:"day"`
+	Campaigns     int       `json:"campaigns"`
+	FailedTargets int       `json:"failed_targets"`
+}
+
+// FailingQuery is a query whose campaign targets failed over the last
+// CampaignDays days.
+type FailingQuery struct {
+	Query        string    `json:"query"`
+	Failures     int       `json:"failures"`
+	Hosts        int       `json:"hosts"`
+	LastFailedAt time.Time `json:"last_failed_at"`
+}
+
+// Summary is the dashboard for one organization.
+type Summary struct {
+	Hosts           HostStatus            `json:"hosts"`
+	Platforms       []PlatformCount       `json:"platforms"`
+	OsqueryVersions []OsqueryVersionCount `json:"osquery_versions"`
+	Campaigns       []CampaignDay         `json:"campaigns"`
+	FailingQueries  []FailingQuery        `json:"failing_queries"`
+
+	// RefreshedAt is when the materialized views the aggregates came from
+	// were last refreshed, or zero if they were computed live.
+	RefreshedAt time.Time `json:"refreshed_at"`
+}
+
+type DashboardRepository struct {
+	pool *pgxpool.Pool
+
+	// largeOrgHosts is the host count from which summaries read the
+	// materialized views instead of aggregating live. Zero always reads live.
+	largeOrgHosts int
+}
+
+// NewDashboardRepository returns a repository that reads precomputed
+// aggregates for organizations with at least largeOrgHosts hosts.
+func NewDashboardRepository(pool *pgxpool.Pool, largeOrgHosts int) *DashboardRepository {
+	return &DashboardRepository{pool: pool, largeOrgHosts: largeOrgHosts}
+}
+
+// Summary returns the organization's dashboard. Hosts that checked in after
+// onlineSince count as online; that is always read live.
+func (r *DashboardRepository) Summary(ctx context.Context, organizationID uuid.UUID, onlineSince time.Time) (*Summary, error) {
+	s := &Summary{}
+	err := r.pool.QueryRow(ctx, `
+		SELECT
+			COUNT(*) FILTER (WHERE checked_in >= $2),
+			COUNT(*) FILTER (WHERE checked_in IS NULL OR checked_in < $2)
+		FROM (
+			SELECT GREATEST(last_logger_at, last_seen_at) AS checked_in
+			FROM hosts
+			WHERE organization_id = $1
+		) h
+	`, organizationID, onlineSince).Scan(&s.Hosts.Online, &s.Hosts.Offline)
+	if err != nil {
+		return nil, fmt.Errorf("counting hosts: %w", err)
+	}
+
+	precomputed := r.largeOrgHosts > 0 && s.Hosts.Total() >= r.largeOrgHosts
+	if precomputed {
+		err = r.fromViews(ctx, organizationID, s)
+	} else {
+		err = r.live(ctx, organizationID, s)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	s.Campaigns = fillCampaignDays(s.Campaigns, time.Now().UTC())
+	return s, nil
+}
+
+func (r *DashboardRepository) live(ctx context.Context, organizationID uuid.UUID, s *Summary) error {
+	var err error
+	s.Platforms, err = queryPlatforms(ctx, r.pool, nil, `
+		SELECT
+			COALESCE(NULLIF(os_version->>'platform', ''), 'unknown'),
+			COALESCE(NULLIF(TRIM(CONCAT_WS(' ', os_version->>'name', os_version->>'version')), ''), 'unknown'),
+			COUNT(*)::int,
+			NULL::timestamptz
+		FROM hosts
+		WHERE organization_id = $1
+		GROUP BY 1, 2
+		ORDER BY 3 DESC, 1, 2
+	`, organizationID)
+	if err != nil {
+		return err
+	}
+
+	s.OsqueryVersions, err = queryOsqueryVersions(ctx, r.pool, nil, `
+		SELECT COALESCE(NULLIF(osquery_version, ''), 'unknown'), COUNT(*)::int, NULL::timestamptz
+		FROM hosts
+		WHERE organization_id = $1
+		GROUP BY 1
+		ORDER BY 2 DESC, 1 DESC
+	`, organizationID)
+	if err != nil {
+		return err
+	}
+
+	s.Campaigns, err = queryCampaignDays(ctx, r.pool, nil, `
+		SELECT
+			(c.created_at AT TIME ZONE 'UTC')::date,
+			COUNT(DISTINCT c.id)::int,
+			(COUNT(t.host_id) FILTER (WHERE t.status = 'failed'))::int,
+			NULL::timestamptz
+		FROM campaigns c
+		LEFT JOIN campaign_targets t ON t.campaign_id = c.id
+		WHERE c.organization_id = $1
+		  AND c.created_at >= date_trunc('day', NOW() AT TIME ZONE 'UTC') AT TIME ZONE 'UTC' - INTERVAL '29 days'
+		GROUP BY 1
+		ORDER BY 1
+	`, organizationID)
+	if err != nil {
+		return err
+	}
+
+	s.FailingQueries, err = queryFailingQueries(ctx, r.pool, nil, `
+		SELECT c.query, COUNT(*)::int, COUNT(DISTINCT t.host_id)::int, MAX(t.updated_at), NULL::timestamptz
+		FROM campaign_targets t
+		JOIN campaigns c ON c.id = t.campaign_id
+		WHERE c.organization_id = $1
+		  AND t.status = 'failed'
+		  AND t.updated_at >= NOW() - INTERVAL '30 days'
+		GROUP BY c.query
+		ORDER BY 2 DESC, 4 DESC
+		LIMIT $2
+	`, organizationID, FailingQueriesLimit)
+	return err
+}
+
+func (r *DashboardRepository) fromViews(ctx context.Context, organizationID uuid.UUID, s *Summary) error {
+	var (
+		err       error
+		refreshed []time.Time
+	)
+	s.Platforms, err = queryPlatforms(ctx, r.pool, &refreshed, `
+		SELECT platform, os_version, hosts, refreshed_at
+		FROM dashboard_host_platforms
+		WHERE organization_id = $1
+		ORDER BY hosts DESC, platform, os_version
+	`, organizationID)
+	if err != nil {
+		return err
+	}
+
+	s.OsqueryVersions, err = queryOsqueryVersions(ctx, r.pool, &refreshed, `
+		SELECT osquery_version, hosts, refreshed_at
+		FROM dashboard_osquery_versions
+		WHERE organization_id = $1
+		ORDER BY hosts DESC, osquery_version DESC
+	`, organizationID)
+	if err != nil {
+		return err
+	}
+
+	s.Campaigns, err = queryCampaignDays(ctx, r.pool, &refreshed, `
+		SELECT day, campaigns, failed_targets, refreshed_at
+		FROM dashboard_campaigns_daily
+		WHERE organization_id = $1
+		ORDER BY day
+	`, organizationID)
+	if err != nil {
+		return err
+	}
+
+	s.FailingQueries, err = queryFailingQueries(ctx, r.pool, &refreshed, `
+		SELECT query, failures, hosts, last_failed_at, refreshed_at
+		FROM dashboard_failing_queries
+		WHERE organization_id = $1
+		ORDER BY failures DESC, last_failed_at DESC
+		LIMIT $2
+	`, organizationID, FailingQueriesLimit)
+	if err != nil {
+		return err
+	}
+
+	// Report the oldest refresh, so the page never claims to be fresher
+	// than its stalest section.
+	for _, t := range refreshed {
+		if s.RefreshedAt.IsZero() || t.Before(s.RefreshedAt) {
+			s.RefreshedAt = t
+		}
+	}
+	return nil
+}
+
+// RefreshViews recomputes the dashboard materialized views. Readers keep
+// seeing the previous contents until each refresh completes.
+func (r *DashboardRepository) RefreshViews(ctx context.Context) error {
+	for _, view := range []string{
+		"dashboard_host_platforms",
+		"dashboard_osquery_versions",
+		"dashboard_campaigns_daily",
+		"dashboard_failing_queries",
+	} {
+		if _, err := r.pool.Exec(ctx, "REFRESH MATERIALIZED VIEW CONCURRENTLY "+view); err != nil {
+			return fmt.Errorf("refreshing %s: %w", view, err)
+		}
+	}
+	return nil
+}
+
+// query runs sql with args, calling scan for each row. Every dashboard query
+// selects refreshed_at last (NULL when live); non-null values are appended to
+// refreshed if it is set.
+func query(ctx context.Context, pool *pgxpool.Pool, refreshed *[]time.Time, sql string, args []any, scan func(rows pgx.Rows, refreshedAt **time.Time) error) error {
+	rows, err := pool.Query(ctx, sql, args...)
+	if err != nil {
+		return fmt.Errorf("querying dashboard: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var refreshedAt *time.Time
+		if err := scan(rows, &refreshedAt); err != nil {
+			return fmt.Errorf("scanning dashboard row: %w", err)
+		}
+		if refreshed != nil && refreshedAt != nil {
+			*refreshed = append(*refreshed, *refreshedAt)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("iterating dashboard rows: %w", err)
+	}
+	return nil
+}
+
+func queryPlatforms(ctx context.Context, pool *pgxpool.Pool, refreshed *[]time.Time, sql string, args ...any) ([]PlatformCount, error) {
+	var out []PlatformCount
+	err := query(ctx, pool, refreshed, sql, args, func(rows pgx.Rows, refreshedAt **time.Time) error {
+		var p PlatformCount
+		if err := rows.Scan(&p.Platform, &p.OSVersion, &p.Hosts, refreshedAt); err != nil {
+			return err
+		}
+		out = append(out, p)
+		return nil
+	})
+	return out, err
+}
+
+func queryOsqueryVersions(ctx context.Context, pool *pgxpool.Pool, refreshed *[]time.Time, sql string, args ...any) ([]OsqueryVersionCount, error) {
+	var out []OsqueryVersionCount
+	err := query(ctx, pool, refreshed, sql, args, func(rows pgx.Rows, refreshedAt **time.Time) error {
+		var v OsqueryVersionCount
+		if err := rows.Scan(&v.Version, &v.Hosts, refreshedAt); err != nil {
+			return err
+		}
+		out = append(out, v)
+		return nil
+	})
+	return out, err
+}
+
+func queryCampaignDays(ctx context.Context, pool *pgxpool.Pool, refreshed *[]time.Time, sql string, args ...any) ([]CampaignDay, error) {
+	var out []CampaignDay
+	err := query(ctx, pool, refreshed, sql, args, func(rows pgx.Rows, refreshedAt **time.Time) error {
+		var d CampaignDay
+		if err := rows.Scan(&d.Day, &d.Campaigns, &d.FailedTargets, refreshedAt); err != nil {
+			return err
+		}
+		out = append(out, d)
+		return nil
+	})
+	return out, err
+}
+
+func queryFailingQueries(ctx context.Context, pool *pgxpool.Pool, refreshed *[]time.Time, sql string, args ...any) ([]FailingQuery, error) {
+	var out []FailingQuery
+	err := query(ctx, pool, refreshed, sql, args, func(rows pgx.Rows, refreshedAt **time.Time) error {
+		var q FailingQuery
+		if err := rows.Scan(&q.Query, &q.Failures, &q.Hosts, &q.LastFailedAt, refreshedAt); err != nil {
+			return err
+		}
+		out = append(out, q)
+		return nil
+	})
+	return out, err
+}
+
+// fillCampaignDays returns one entry per day for the CampaignDays days up to
+// and including now's, with zeros for days without campaigns.
+func fillCampaignDays(days []CampaignDay, now time.Time) []CampaignDay {
+	byDay := make(map[string]CampaignDay, len(days))
+	for _, d := range days {
+		byDay[d.Day.Format(time.DateOnly)] = d
+	}
+
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+	out := make([]CampaignDay, CampaignDays)
+	for i := range out {
+		day := today.AddDate(0, 0, i-(CampaignDays-1))
+		d := byDay[day.Format(time.DateOnly)]
+		d.Day = day
+		out[i] = d
+	}
+	return out
+}
@@ -0,0 +1,98 @@
+package services_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/cavenine/queryops/features/dashboard/services"
+	"github.com/cavenine/queryops/internal/testdb"
+	"github.com/cavenine/queryops/internal/testdb/fixtures"
+)
+
+func TestDashboardRepository_Summary(t *testing.T) {
+	tdb := testdb.SetupTestDB(t)
+	ctx := context.Background()
+
+	orgID := fixtures.CreateOrg(t, tdb.Pool, "dashboard-org").ID
+	otherOrgID := fixtures.CreateOrg(t, tdb.Pool, "other-org").ID
+
+	ubuntu := fixtures.CreateHost(t, tdb.Pool, orgID, "ubuntu-1")
+	ubuntu2 := fixtures.CreateHost(t, tdb.Pool, orgID, "ubuntu-2")
+	mac := fixtures.CreateHost(t, tdb.Pool, orgID, "mac-1")
+	fixtures.CreateHost(t, tdb.Pool, otherOrgID, "other-1")
+
+	_, err := tdb.Pool.Exec(ctx, `
+		UPDATE hosts SET os_version = '{"platform":"ubuntu","name":"Ubuntu","version":"24.04 LTS"}', osquery_version = '5.12.1', last_logger_at = NOW()
+		WHERE id = ANY($1)
+	`, []uuid.UUID{ubuntu.ID, ubuntu2.ID})
+	if err != nil {
+		t.Fatalf("updating hosts: %v", err)
+	}
+	_, err = tdb.Pool.Exec(ctx, `
+		UPDATE hosts SET os_version = '{"platform":"darwin","name":"macOS","version":"15.1"}', last_logger_at = NOW() - INTERVAL '1 hour'
+		WHERE id = $1
+	`, mac.ID)
+	if err != nil {
+		t.Fatalf("updating hosts: %v", err)
+	}
+
+	failing := fixtures.CreateCampaign(t, tdb.Pool, orgID, "SELECT * FROM missing_table;", ubuntu.ID, mac.ID)
+	fixtures.CreateCampaign(t, tdb.Pool, orgID, "SELECT 1;", ubuntu.ID)
+	if _, err := tdb.Pool.Exec(ctx, `UPDATE campaign_targets SET status = 'failed' WHERE campaign_id = $1`, failing.ID); err != nil {
+		t.Fatalf("failing targets: %v", err)
+	}
+
+	check := func(t *testing.T, s *services.Summary) {
+		t.Helper()
+		if s.Hosts.Online != 2 || s.Hosts.Offline != 1 {
+			t.Fatalf("Hosts = %+v, want 2 online, 1 offline", s.Hosts)
+		}
+		if len(s.Platforms) != 2 || s.Platforms[0].Platform != "ubuntu" || s.Platforms[0].OSVersion != "Ubuntu 24.04 LTS" || s.Platforms[0].Hosts != 2 {
+			t.Fatalf("Platforms = %+v", s.Platforms)
+		}
+		if len(s.OsqueryVersions) != 2 || s.OsqueryVersions[0] != (services.OsqueryVersionCount{Version: "5.12.1", Hosts: 2}) ||
+			s.OsqueryVersions[1] != (services.OsqueryVersionCount{Version: "unknown", Hosts: 1}) {
+			t.Fatalf("OsqueryVersions = %+v", s.OsqueryVersions)
+		}
+		if len(s.Campaigns) != services.CampaignDays {
+			t.Fatalf("len(Campaigns) = %d, want %d", len(s.Campaigns), services.CampaignDays)
+		}
+		if today := s.Campaigns[len(s.Campaigns)-1]; today.Campaigns != 2 || today.FailedTargets != 2 {
+			t.Fatalf("today = %+v, want 2 campaigns, 2 failed targets", today)
+		}
+		if len(s.FailingQueries) != 1 || s.FailingQueries[0].Query != failing.Query || s.FailingQueries[0].Hosts != 2 {
+			t.Fatalf("FailingQueries = %+v", s.FailingQueries)
+		}
+	}
+
+	onlineSince := time.Now().Add(-5 * time.Minute)
+
+	t.Run("live", func(t *testing.T) {
+		s, err := services.NewDashboardRepository(tdb.Pool, 0).Summary(ctx, orgID, onlineSince)
+		if err != nil {
+			t.Fatalf("Summary: %v", err)
+		}
+		check(t, s)
+		if !s.RefreshedAt.IsZero() {
+			t.Fatalf("RefreshedAt = %v for a live summary", s.RefreshedAt)
+		}
+	})
+
+	t.Run("materialized views", func(t *testing.T) {
+		repo := services.NewDashboardRepository(tdb.Pool, 3)
+		if err := repo.RefreshViews(ctx); err != nil {
+			t.Fatalf("RefreshViews: %v", err)
+		}
+		s, err := repo.Summary(ctx, orgID, onlineSince)
+		if err != nil {
+			t.Fatalf("Summary: %v", err)
+		}
+		check(t, s)
+		if s.RefreshedAt.IsZero() {
+			t.Fatalf("RefreshedAt is zero for a summary read from the views")
+		}
+	})
+}
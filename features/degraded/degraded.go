@@ -0,0 +1,171 @@
+// Package degraded keeps the web UI usable, read-only, while the database is
+// down. Pages render from a copy saved when each session last loaded them,
+// under a banner saying so; anything else gets a page explaining the outage
+// instead of a 500 from every handler on the way.
+package degraded
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/cavenine/queryops/db"
+	"github.com/cavenine/queryops/features/degraded/pages"
+)
+
+// retryAfter is the Retry-After sent while the database is down.
+const retryAfter = 30 * time.Second
+
+// databaseHealth reports whether the database is down. *db.Breaker is one.
+type databaseHealth interface {
+	Open() bool
+}
+
+// Feature is the degraded mode of the web UI.
+type Feature struct {
+	database      databaseHealth
+	sessionCookie string
+	pages         *pageCache
+}
+
+// NewFeature returns the degraded mode for database, recognizing sessions
+// by the sessionCookie cookie. A nil database is never down, and no pages
+// are saved.
+func NewFeature(database *db.Breaker, sessionCookie string) *Feature {
+	f := &Feature{sessionCookie: sessionCookie, pages: newPageCache()}
+	if database != nil {
+		f.database = database
+	}
+	return f
+}
+
+// Middleware serves requests in degraded mode while the database is down,
+// and otherwise saves the pages a signed-in user loads. Put it before
+// anything that loads the session, which is stored in the database.
+func (f *Feature) Middleware(next http.Handler) http.Handler {
+	if f.database == nil {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key, saveable := f.pageKey(r)
+		if f.database.Open() {
+			f.serveDegraded(w, r, key, saveable)
+			return
+		}
+		if !saveable {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		rec := &recorder{ResponseWriter: w}
+		next.ServeHTTP(rec, r)
+		contentType := w.Header().Get("Content-Type")
+		if rec.status == http.StatusOK && !rec.tooBig && strings.HasPrefix(contentType, "text/html") &&
+			!strings.Contains(w.Header().Get("Cache-Control"), "no-store") {
+			f.pages.put(key, rec.body.Bytes(), contentType)
+		}
+	})
+}
+
+// pageKey returns the key r's page is saved under, and whether it's a page
+// load that can be saved: a signed-in browser navigation, not a Datastar
+// request, event stream, or WebSocket.
+func (f *Feature) pageKey(r *http.Request) (pageKey, bool) {
+	if r.Method != http.MethodGet || r.Header.Get("Datastar-Request") != "" || r.Header.Get("Upgrade") != "" ||
+		!strings.Contains(r.Header.Get("Accept"), "text/html") {
+		return pageKey{}, false
+	}
+	cookie, err := r.Cookie(f.sessionCookie)
+	if err != nil || cookie.Value == "" {
+		return pageKey{}, false
+	}
+	return pageKey{session: sha256.Sum256([]byte(cookie.Value)), uri: r.URL.RequestURI()}, true
+}
+
+// serveDegraded answers r without the database: with the saved copy of its
+// page if there is one, and otherwise with 503.
+func (f *Feature) serveDegraded(w http.ResponseWriter, r *http.Request, key pageKey, saveable bool) {
+	w.Header().Set("Cache-Control", "no-store")
+	if saveable {
+		if page, ok := f.pages.get(key); ok {
+			var banner bytes.Buffer
+			if err := pages.Banner(page.savedAt).Render(r.Context(), &banner); err != nil {
+				slog.ErrorContext(r.Context(), "failed to render degraded banner", "error", err)
+			}
+			w.Header().Set("Content-Type", page.contentType)
+			_, _ = w.Write(withBanner(page.body, banner.Bytes()))
+			return
+		}
+	}
+
+	w.Header().Set("Retry-After", strconv.Itoa(int(retryAfter.Seconds())))
+	if r.Method != http.MethodGet || r.Header.Get("Datastar-Request") != "" || !strings.Contains(r.Header.Get("Accept"), "text/html") {
+		http.Error(w, "database unavailable", http.StatusServiceUnavailable)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(http.StatusServiceUnavailable)
+	if err := pages.Unavailable().Render(r.Context(), w); err != nil {
+		slog.ErrorContext(r.Context(), "failed to render degraded page", "error", err)
+	}
+}
+
+// withBanner returns page with banner inserted at the top of its body.
+// With the Content-Security-Policy on, scripts in page carry a nonce it no
+// longer allows, so the copy stays static; without it, whatever they request
+// gets 503 like any other request.
+func withBanner(page, banner []byte) []byte {
+	at := 0
+	if i := bytes.Index(page, []byte("<body")); i >= 0 {
+		if end := bytes.IndexByte(page[i:], '>'); end >= 0 {
+			at = i + end + 1
+		}
+	}
+	out := make([]byte, 0, len(page)+len(banner))
+	out = append(out, page[:at]...)
+	out = append(out, banner...)
+	return append(out, page[at:]...)
+}
+
+// recorder keeps a copy of the response it passes through, up to
+// maxPageBytes.
+type recorder struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+	tooBig bool
+}
+
+func (r *recorder) WriteHeader(status int) {
+	if r.status == 0 {
+		r.status = status
+	}
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *recorder) Write(p []byte) (int, error) {
+	if r.status == 0 {
+		r.status = http.StatusOK
+	}
+	if !r.tooBig {
+		if r.body.Len()+len(p) > maxPageBytes {
+			r.tooBig = true
+			r.body = bytes.Buffer{}
+		} else {
+			r.body.Write(p)
+		}
+	}
+	return r.ResponseWriter.Write(p)
+}
+
+func (r *recorder) Flush() {
+	_ = http.NewResponseController(r.ResponseWriter).Flush()
+}
+
+func (r *recorder) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
+}
@@ -0,0 +1,78 @@
+package degraded
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+type databaseState bool
+
+func (d *databaseState) Open() bool { return bool(*d) }
+
+func TestMiddleware(t *testing.T) {
+	var down databaseState
+	f := &Feature{database: &down, sessionCookie: "session", pages: newPageCache()}
+	calls := 0
+	handler := f.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		_, _ = w.Write([]byte(`<html><body class="flex">hosts of ` + r.URL.Query().Get("org") + `</body></html>`))
+	}))
+	get := func(uri, session string, header ...string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, uri, nil)
+		req.Header.Set("Accept", "text/html")
+		for i := 0; i < len(header); i += 2 {
+			req.Header.Set(header[i], header[i+1])
+		}
+		if session != "" {
+			req.AddCookie(&http.Cookie{Name: "session", Value: session})
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+
+	get("/hosts?org=a", "alice")
+	get("/hosts?org=b", "alice", "Datastar-Request", "true")
+	if calls != 2 {
+		t.Fatalf("handler called %d times", calls)
+	}
+
+	down = true
+	rec := get("/hosts?org=a", "alice")
+	body := rec.Body.String()
+	if rec.Code != http.StatusOK || !strings.HasPrefix(body, `<html><body class="flex"><div role="alert"`) || !strings.HasSuffix(body, "hosts of a</body></html>") {
+		t.Fatalf("saved page: status = %d, body = %q", rec.Code, body)
+	}
+	if calls != 2 {
+		t.Fatal("handler called while the database is down")
+	}
+
+	// Pages are saved per session and URL, and only for page loads.
+	for _, tt := range []struct {
+		uri, session string
+	}{
+		{"/hosts?org=a", "bob"},
+		{"/hosts?org=a", ""},
+		{"/hosts?org=b", "alice"},
+	} {
+		rec := get(tt.uri, tt.session)
+		if rec.Code != http.StatusServiceUnavailable || rec.Header().Get("Retry-After") != "30" || !strings.Contains(rec.Body.String(), "can't reach its database") {
+			t.Errorf("GET %s as %q: status = %d, body = %q", tt.uri, tt.session, rec.Code, rec.Body.String())
+		}
+	}
+	if rec := get("/hosts?org=a", "alice", "Datastar-Request", "true"); rec.Code != http.StatusServiceUnavailable || strings.Contains(rec.Body.String(), "<html") {
+		t.Errorf("Datastar request: status = %d, body = %q", rec.Code, rec.Body.String())
+	}
+}
+
+func TestWithBanner(t *testing.T) {
+	if got := string(withBanner([]byte("<body>x</body>"), []byte("B"))); got != "<body>Bx</body>" {
+		t.Errorf("withBanner = %q", got)
+	}
+	if got := string(withBanner([]byte("x"), []byte("B"))); got != "Bx" {
+		t.Errorf("withBanner without a body = %q", got)
+	}
+}
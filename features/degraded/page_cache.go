@@ -0,0 +1,92 @@
+package degraded
+
+import (
+	"container/list"
+	"sync"
+	"time"
+)
+
+const (
+	// maxPageBytes is the largest page kept. Bigger ones, such as long
+	// result tables, aren't worth the memory.
+	maxPageBytes = 512 << 10
+	// maxCacheBytes bounds the pages kept across all sessions; the least
+	// recently saved go first.
+	maxCacheBytes = 64 << 20
+	// maxPageAge is how old a kept page may be and still be shown.
+	maxPageAge = time.Hour
+)
+
+// pageCache keeps the last copy of each page a session loaded, to show while
+// the database is down.
+type pageCache struct {
+	now func() time.Time
+
+	mu    sync.Mutex
+	pages map[pageKey]*list.Element
+	order *list.List // of *savedPage, most recently saved first
+	bytes int
+}
+
+type pageKey struct {
+	// session is a hash of the session token, so the cache holds no tokens.
+	session [32]byte
+	uri     string
+}
+
+type savedPage struct {
+	key         pageKey
+	body        []byte
+	contentType string
+	savedAt     time.Time
+}
+
+func newPageCache() *pageCache {
+	return &pageCache{
+		now:   time.Now,
+		pages: make(map[pageKey]*list.Element),
+		order: list.New(),
+	}
+}
+
+// put saves body as key's page, replacing any earlier copy.
+func (c *pageCache) put(key pageKey, body []byte, contentType string) {
+	if len(body) > maxPageBytes {
+		return
+	}
+	page := &savedPage{key: key, body: body, contentType: contentType, savedAt: c.now()}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if el, ok := c.pages[key]; ok {
+		c.removeLocked(el)
+	}
+	c.pages[key] = c.order.PushFront(page)
+	c.bytes += len(body)
+	for c.bytes > maxCacheBytes {
+		c.removeLocked(c.order.Back())
+	}
+}
+
+// get returns key's page if one was saved within maxPageAge.
+func (c *pageCache) get(key pageKey) (*savedPage, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	el, ok := c.pages[key]
+	if !ok {
+		return nil, false
+	}
+	page := el.Value.(*savedPage)
+	if c.now().Sub(page.savedAt) > maxPageAge {
+		c.removeLocked(el)
+		return nil, false
+	}
+	return page, true
+}
+
+// removeLocked drops el's page. c.mu must be held.
+func (c *pageCache) removeLocked(el *list.Element) {
+	page := c.order.Remove(el).(*savedPage)
+	delete(c.pages, page.key)
+	c.bytes -= len(page.body)
+}
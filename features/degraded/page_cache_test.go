@@ -0,0 +1,42 @@
+package degraded
+
+import (
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestPageCache(t *testing.T) {
+	now := time.Now()
+	c := newPageCache()
+	c.now = func() time.Time { return now }
+	key := func(i int) pageKey { return pageKey{uri: "/page/" + strconv.Itoa(i)} }
+
+	c.put(key(0), []byte("old"), "text/html")
+	c.put(key(0), []byte("new"), "text/html")
+	if page, ok := c.get(key(0)); !ok || string(page.body) != "new" || c.bytes != 3 {
+		t.Fatalf("get = %+v, %v; bytes = %d", page, ok, c.bytes)
+	}
+
+	c.put(key(1), make([]byte, maxPageBytes+1), "text/html")
+	if _, ok := c.get(key(1)); ok {
+		t.Fatal("kept a page over maxPageBytes")
+	}
+
+	// The least recently saved pages go once the cache is full.
+	page := make([]byte, maxPageBytes)
+	for i := 1; i <= maxCacheBytes/maxPageBytes; i++ {
+		c.put(key(i), page, "text/html")
+	}
+	if _, ok := c.get(key(0)); ok {
+		t.Fatal("oldest page kept past maxCacheBytes")
+	}
+	if _, ok := c.get(key(1)); !ok || c.bytes > maxCacheBytes {
+		t.Fatalf("newer page dropped; bytes = %d", c.bytes)
+	}
+
+	now = now.Add(maxPageAge + time.Second)
+	if _, ok := c.get(key(1)); ok {
+		t.Fatal("showed a page older than maxPageAge")
+	}
+}
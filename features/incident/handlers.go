@@ -0,0 +1,458 @@
+package incident
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/ThreeDotsLabs/watermill/message"
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+	"github.com/starfederation/datastar-go/datastar"
+
+	"github.com/cavenine/queryops/features/auth"
+	"github.com/cavenine/queryops/features/incident/pages"
+	"github.com/cavenine/queryops/features/incident/services"
+	org "github.com/cavenine/queryops/features/organization"
+	"github.com/cavenine/queryops/internal/pubsub"
+	"github.com/cavenine/queryops/internal/validate"
+)
+
+const (
+	// patchWindow is how long the live stream waits after a change for more
+	// before re-rendering the workspace, so a campaign's hosts answering
+	// together cost one render.
+	patchWindow = time.Second
+
+	// pollInterval is how often the live stream re-reads the workspace when
+	// pubsub is unavailable.
+	pollInterval = 5 * time.Second
+)
+
+type incidentRepository interface {
+	List(ctx context.Context, organizationID uuid.UUID) ([]*services.Incident, error)
+	Create(ctx context.Context, organizationID uuid.UUID, userID int, title string) (uuid.UUID, error)
+	Workspace(ctx context.Context, organizationID, incidentID uuid.UUID) (*services.Workspace, error)
+	CampaignChoices(ctx context.Context, organizationID uuid.UUID) ([]services.CampaignChoice, error)
+	AddNote(ctx context.Context, organizationID, incidentID uuid.UUID, userID int, body string) error
+	PinCampaign(ctx context.Context, organizationID, incidentID uuid.UUID, userID int, campaignID uuid.UUID) error
+	UnpinCampaign(ctx context.Context, organizationID, incidentID uuid.UUID, userID int, campaignID uuid.UUID) error
+	PinHost(ctx context.Context, organizationID, incidentID uuid.UUID, userID int, hostIdentifier string) error
+	UnpinHost(ctx context.Context, organizationID, incidentID uuid.UUID, userID int, hostID uuid.UUID) error
+	SetStatus(ctx context.Context, organizationID, incidentID uuid.UUID, userID int, status string) error
+}
+
+type Handlers struct {
+	repo   incidentRepository
+	pubsub *pubsub.PubSub
+}
+
+// NewHandlers creates incident handlers. ps may be nil, in which case the
+// live stream polls.
+func NewHandlers(repo incidentRepository, ps *pubsub.PubSub) *Handlers {
+	return &Handlers{repo: repo, pubsub: ps}
+}
+
+// IncidentsPage lists the active organization's incidents.
+func (h *Handlers) IncidentsPage(w http.ResponseWriter, r *http.Request) {
+	h.renderIncidents(w, r, http.StatusOK, "", nil)
+}
+
+func (h *Handlers) renderIncidents(w http.ResponseWriter, r *http.Request, status int, title string, fields validate.Errors) {
+	ctx := r.Context()
+	activeOrg := org.GetOrganizationFromContext(ctx)
+	if activeOrg == nil {
+		slog.ErrorContext(ctx, "missing active organization in context")
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+
+	incidents, err := h.repo.List(ctx, activeOrg.ID)
+	if err != nil {
+		slog.ErrorContext(ctx, "failed to list incidents", "error", err)
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(status)
+	pages.IncidentsPage(incidents, title, fields).Render(ctx, w)
+}
+
+// CreateIncident opens an incident and redirects to its workspace.
+func (h *Handlers) CreateIncident(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	activeOrg, user := org.GetOrganizationFromContext(ctx), auth.GetUserFromContext(ctx)
+	if activeOrg == nil || user == nil {
+		slog.ErrorContext(ctx, "missing active organization or user in context")
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "invalid form data", http.StatusBadRequest)
+		return
+	}
+
+	title := strings.TrimSpace(r.PostForm.Get("title"))
+	fields := validate.Errors{}
+	fields.Field("title", title, validate.Required(), validate.MaxLength(services.MaxTitleLength))
+	if len(fields) > 0 {
+		h.renderIncidents(w, r, http.StatusUnprocessableEntity, title, fields)
+		return
+	}
+
+	id, err := h.repo.Create(ctx, activeOrg.ID, user.ID, title)
+	if err != nil {
+		slog.ErrorContext(ctx, "failed to create incident", "error", err)
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+	http.Redirect(w, r, "/incidents/"+id.String(), http.StatusSeeOther)
+}
+
+// IncidentPage shows an incident's workspace.
+func (h *Handlers) IncidentPage(w http.ResponseWriter, r *http.Request) {
+	h.renderIncident(w, r, http.StatusOK, nil)
+}
+
+// renderIncident renders the workspace, with fields beside the inputs of the
+// form that was rejected.
+func (h *Handlers) renderIncident(w http.ResponseWriter, r *http.Request, status int, fields validate.Errors) {
+	ctx := r.Context()
+	activeOrg := org.GetOrganizationFromContext(ctx)
+	if activeOrg == nil {
+		slog.ErrorContext(ctx, "missing active organization in context")
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+	incidentID, err := uuid.Parse(chi.URLParam(r, "id"))
+	if err != nil {
+		http.Error(w, "invalid incident id", http.StatusBadRequest)
+		return
+	}
+
+	ws, err := h.repo.Workspace(ctx, activeOrg.ID, incidentID)
+	if errors.Is(err, services.ErrIncidentNotFound) {
+		http.Error(w, "incident not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		slog.ErrorContext(ctx, "failed to load incident", "error", err)
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+	choices, err := h.repo.CampaignChoices(ctx, activeOrg.ID)
+	if err != nil {
+		slog.ErrorContext(ctx, "failed to list campaigns", "error", err)
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(status)
+	pages.IncidentPage(pages.IncidentProps{
+		Workspace:       ws,
+		CampaignChoices: choices,
+		Note:            r.PostForm.Get("note"),
+		HostIdentifier:  r.PostForm.Get("host_identifier"),
+		Fields:          fields,
+	}).Render(ctx, w)
+}
+
+// action is a change to an incident made from its workspace. It runs once
+// the request's form has been parsed.
+type action func(ctx context.Context, organizationID, incidentID uuid.UUID, userID int) (validate.Errors, error)
+
+// serveAction runs do and redirects back to the workspace, or re-renders it
+// with the problems do found.
+func (h *Handlers) serveAction(w http.ResponseWriter, r *http.Request, op string, do action) {
+	ctx := r.Context()
+	activeOrg, user := org.GetOrganizationFromContext(ctx), auth.GetUserFromContext(ctx)
+	if activeOrg == nil || user == nil {
+		slog.ErrorContext(ctx, "missing active organization or user in context")
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+	incidentID, err := uuid.Parse(chi.URLParam(r, "id"))
+	if err != nil {
+		http.Error(w, "invalid incident id", http.StatusBadRequest)
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "invalid form data", http.StatusBadRequest)
+		return
+	}
+
+	fields, err := do(ctx, activeOrg.ID, incidentID, user.ID)
+	if errors.Is(err, services.ErrIncidentNotFound) {
+		http.Error(w, "incident not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		slog.ErrorContext(ctx, "failed to "+op, "error", err)
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+	if len(fields) > 0 {
+		h.renderIncident(w, r, http.StatusUnprocessableEntity, fields)
+		return
+	}
+	http.Redirect(w, r, "/incidents/"+incidentID.String(), http.StatusSeeOther)
+}
+
+// AddNote adds the posted note to the incident's feed.
+func (h *Handlers) AddNote(w http.ResponseWriter, r *http.Request) {
+	h.serveAction(w, r, "add incident note", func(ctx context.Context, orgID, incidentID uuid.UUID, userID int) (validate.Errors, error) {
+		note := strings.TrimSpace(r.PostForm.Get("note"))
+		fields := validate.Errors{}
+		fields.Field("note", note, validate.Required(), validate.MaxLength(services.MaxNoteLength))
+		if len(fields) > 0 {
+			return fields, nil
+		}
+		return nil, h.repo.AddNote(ctx, orgID, incidentID, userID, note)
+	})
+}
+
+// PinCampaign pins the posted campaign to the incident.
+func (h *Handlers) PinCampaign(w http.ResponseWriter, r *http.Request) {
+	h.serveAction(w, r, "pin campaign", func(ctx context.Context, orgID, incidentID uuid.UUID, userID int) (validate.Errors, error) {
+		campaignID, err := uuid.Parse(r.PostForm.Get("campaign_id"))
+		if err != nil {
+			return validate.Errors{"campaign_id": "Choose a campaign"}, nil
+		}
+		err = h.repo.PinCampaign(ctx, orgID, incidentID, userID, campaignID)
+		if errors.Is(err, services.ErrCampaignNotFound) {
+			return validate.Errors{"campaign_id": "No such campaign"}, nil
+		}
+		return nil, err
+	})
+}
+
+// UnpinCampaign removes a campaign from the incident.
+func (h *Handlers) UnpinCampaign(w http.ResponseWriter, r *http.Request) {
+	campaignID, err := uuid.Parse(chi.URLParam(r, "campaignID"))
+	if err != nil {
+		http.Error(w, "invalid campaign id", http.StatusBadRequest)
+		return
+	}
+	h.serveAction(w, r, "unpin campaign", func(ctx context.Context, orgID, incidentID uuid.UUID, userID int) (validate.Errors, error) {
+		return nil, h.repo.UnpinCampaign(ctx, orgID, incidentID, userID, campaignID)
+	})
+}
+
+// PinHost pins the host with the posted identifier to the incident.
+func (h *Handlers) PinHost(w http.ResponseWriter, r *http.Request) {
+	h.serveAction(w, r, "pin host", func(ctx context.Context, orgID, incidentID uuid.UUID, userID int) (validate.Errors, error) {
+		identifier := strings.TrimSpace(r.PostForm.Get("host_identifier"))
+		fields := validate.Errors{}
+		fields.Field("host_identifier", identifier, validate.Required())
+		if len(fields) > 0 {
+			return fields, nil
+		}
+		err := h.repo.PinHost(ctx, orgID, incidentID, userID, identifier)
+		if errors.Is(err, services.ErrHostNotFound) {
+			return validate.Errors{"host_identifier": "No such host"}, nil
+		}
+		return nil, err
+	})
+}
+
+// UnpinHost removes a host from the incident.
+func (h *Handlers) UnpinHost(w http.ResponseWriter, r *http.Request) {
+	hostID, err := uuid.Parse(chi.URLParam(r, "hostID"))
+	if err != nil {
+		http.Error(w, "invalid host id", http.StatusBadRequest)
+		return
+	}
+	h.serveAction(w, r, "unpin host", func(ctx context.Context, orgID, incidentID uuid.UUID, userID int) (validate.Errors, error) {
+		return nil, h.repo.UnpinHost(ctx, orgID, incidentID, userID, hostID)
+	})
+}
+
+// CloseIncident closes the incident.
+func (h *Handlers) CloseIncident(w http.ResponseWriter, r *http.Request) {
+	h.setStatus(w, r, services.StatusClosed)
+}
+
+// ReopenIncident reopens a closed incident.
+func (h *Handlers) ReopenIncident(w http.ResponseWriter, r *http.Request) {
+	h.setStatus(w, r, services.StatusOpen)
+}
+
+func (h *Handlers) setStatus(w http.ResponseWriter, r *http.Request, status string) {
+	h.serveAction(w, r, "set incident status", func(ctx context.Context, orgID, incidentID uuid.UUID, userID int) (validate.Errors, error) {
+		return nil, h.repo.SetStatus(ctx, orgID, incidentID, userID, status)
+	})
+}
+
+// Live keeps an incident's pinned campaigns, hosts, and activity feed current
+// for as long as its page is open. It follows the incident's topic and those
+// of its pinned campaigns, picking up campaigns as they're pinned.
+func (h *Handlers) Live(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	activeOrg := org.GetOrganizationFromContext(ctx)
+	if activeOrg == nil {
+		slog.ErrorContext(ctx, "missing active organization in context")
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+	incidentID, err := uuid.Parse(chi.URLParam(r, "id"))
+	if err != nil {
+		http.Error(w, "invalid incident id", http.StatusBadRequest)
+		return
+	}
+
+	ws, err := h.repo.Workspace(ctx, activeOrg.ID, incidentID)
+	if errors.Is(err, services.ErrIncidentNotFound) {
+		http.Error(w, "incident not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		slog.ErrorContext(ctx, "failed to load incident", "error", err)
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+
+	sse := datastar.NewSSE(w, r)
+	if err := sse.PatchElementTempl(pages.Workspace(ws)); err != nil {
+		return
+	}
+
+	if h.pubsub == nil {
+		h.pollLegacy(ctx, sse, activeOrg.ID, ws)
+		return
+	}
+
+	subscriber, err := h.pubsub.NewSubscriber(ctx)
+	if err != nil {
+		slog.ErrorContext(ctx, "failed to create subscriber; falling back to polling", "error", err)
+		h.pollLegacy(ctx, sse, activeOrg.ID, ws)
+		return
+	}
+	defer func() {
+		_ = subscriber.Close()
+	}()
+
+	topic := pubsub.TopicIncident(incidentID)
+	messages, err := subscriber.Subscribe(ctx, topic)
+	if err != nil {
+		slog.ErrorContext(ctx, "failed to subscribe; falling back to polling", "error", err, "topic", topic)
+		h.pollLegacy(ctx, sse, activeOrg.ID, ws)
+		return
+	}
+
+	// Campaign results only mark the workspace stale; they're acknowledged as
+	// they arrive, and the changes they make are rendered together.
+	campaignResults := make(chan struct{}, 1)
+	followed := make(map[uuid.UUID]bool)
+	follow := func(campaignID uuid.UUID) {
+		if followed[campaignID] {
+			return
+		}
+		topic := pubsub.TopicCampaign(campaignID)
+		results, err := subscriber.Subscribe(ctx, topic)
+		if err != nil {
+			slog.ErrorContext(ctx, "failed to subscribe to pinned campaign", "error", err, "topic", topic)
+			return
+		}
+		followed[campaignID] = true
+		go forwardChanges(results, campaignResults)
+	}
+	for _, c := range ws.Campaigns {
+		follow(c.ID)
+	}
+
+	var render <-chan time.Time
+	stale := func() {
+		if render == nil {
+			render = time.After(patchWindow)
+		}
+	}
+	handle := h.pubsub.Handler("sse:incident", topic, func(msg *message.Message) error {
+		event, err := pubsub.ParseIncidentEvent(msg)
+		if err != nil {
+			return pubsub.Poison(err)
+		}
+		if event.Kind == services.EventCampaignPinned && event.CampaignID != nil {
+			follow(*event.CampaignID)
+		}
+		stale()
+		return nil
+	})
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case msg := <-messages:
+			if msg == nil {
+				return
+			}
+			_ = pubsub.Handle(msg, handle)
+		case <-campaignResults:
+			stale()
+		case <-render:
+			render = nil
+			ws, err := h.repo.Workspace(ctx, activeOrg.ID, incidentID)
+			if err != nil {
+				slog.ErrorContext(ctx, "failed to load incident after event", "error", err)
+				stale()
+				continue
+			}
+			if err := sse.PatchElementTempl(pages.Workspace(ws)); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// forwardChanges acknowledges messages and signals changed for each, without
+// blocking on it, until messages is closed.
+func forwardChanges(messages <-chan *message.Message, changed chan<- struct{}) {
+	for msg := range messages {
+		msg.Ack()
+		select {
+		case changed <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// pollLegacy implements the fallback polling mechanism for Live.
+// Used when pub/sub is unavailable or subscription fails.
+func (h *Handlers) pollLegacy(ctx context.Context, sse *datastar.ServerSentEventGenerator, organizationID uuid.UUID, initial *services.Workspace) {
+	snapshot := workspaceSnapshot(initial)
+
+	ticker := time.NewTicker(pollInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			ws, err := h.repo.Workspace(ctx, organizationID, initial.Incident.ID)
+			if err != nil {
+				_ = sse.ConsoleError(err)
+				return
+			}
+
+			if s := workspaceSnapshot(ws); !bytes.Equal(s, snapshot) {
+				snapshot = s
+				if err := sse.PatchElementTempl(pages.Workspace(ws)); err != nil {
+					return
+				}
+			}
+		}
+	}
+}
+
+func workspaceSnapshot(ws *services.Workspace) []byte {
+	s, err := json.Marshal(map[string]any{"campaigns": ws.Campaigns, "hosts": ws.Hosts, "events": ws.Events})
+	if err != nil {
+		return nil
+	}
+	return s
+}
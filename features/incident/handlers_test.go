@@ -0,0 +1,109 @@
+package incident
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+
+	"github.com/cavenine/queryops/features/auth"
+	authServices "github.com/cavenine/queryops/features/auth/services"
+	"github.com/cavenine/queryops/features/incident/services"
+	org "github.com/cavenine/queryops/features/organization"
+	orgServices "github.com/cavenine/queryops/features/organization/services"
+)
+
+type fakeIncidentRepo struct {
+	incidentRepository
+
+	workspace *services.Workspace
+	pinned    []string
+	notes     []string
+}
+
+func (r *fakeIncidentRepo) Workspace(_ context.Context, _, incidentID uuid.UUID) (*services.Workspace, error) {
+	if r.workspace == nil || r.workspace.Incident.ID != incidentID {
+		return nil, services.ErrIncidentNotFound
+	}
+	return r.workspace, nil
+}
+
+func (r *fakeIncidentRepo) CampaignChoices(context.Context, uuid.UUID) ([]services.CampaignChoice, error) {
+	return nil, nil
+}
+
+func (r *fakeIncidentRepo) AddNote(_ context.Context, _, incidentID uuid.UUID, _ int, body string) error {
+	if r.workspace == nil || r.workspace.Incident.ID != incidentID {
+		return services.ErrIncidentNotFound
+	}
+	r.notes = append(r.notes, body)
+	return nil
+}
+
+func (r *fakeIncidentRepo) PinHost(_ context.Context, _, _ uuid.UUID, _ int, hostIdentifier string) error {
+	if hostIdentifier != "web-1" {
+		return services.ErrHostNotFound
+	}
+	r.pinned = append(r.pinned, hostIdentifier)
+	return nil
+}
+
+func TestIncidentActions(t *testing.T) {
+	incidentID := uuid.New()
+	repo := &fakeIncidentRepo{workspace: &services.Workspace{
+		Incident: &services.Incident{ID: incidentID, Title: "Suspicious logins", Status: services.StatusOpen},
+	}}
+	h := NewHandlers(repo, nil)
+
+	post := func(handler http.HandlerFunc, id string, form url.Values) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/incidents/"+id, strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rctx := chi.NewRouteContext()
+		rctx.URLParams.Add("id", id)
+		ctx := context.WithValue(req.Context(), chi.RouteCtxKey, rctx)
+		ctx = org.SetOrganizationInContext(ctx, &orgServices.Organization{ID: uuid.New()})
+		ctx = auth.SetUserInContext(ctx, &authServices.User{ID: 1, Email: "responder@example.com"})
+		rec := httptest.NewRecorder()
+		handler(rec, req.WithContext(ctx))
+		return rec
+	}
+
+	rec := post(h.AddNote, incidentID.String(), url.Values{"note": {"  Found a cron job on web-1  "}})
+	if rec.Code != http.StatusSeeOther || rec.Header().Get("Location") != "/incidents/"+incidentID.String() {
+		t.Fatalf("AddNote: status = %d, location = %q", rec.Code, rec.Header().Get("Location"))
+	}
+	if len(repo.notes) != 1 || repo.notes[0] != "Found a cron job on web-1" {
+		t.Fatalf("notes = %q", repo.notes)
+	}
+
+	rec = post(h.AddNote, incidentID.String(), url.Values{"note": {"   "}})
+	if rec.Code != http.StatusUnprocessableEntity {
+		t.Fatalf("AddNote blank: status = %d, want 422", rec.Code)
+	}
+
+	rec = post(h.PinHost, incidentID.String(), url.Values{"host_identifier": {"db-9"}})
+	if rec.Code != http.StatusUnprocessableEntity || !strings.Contains(rec.Body.String(), "No such host") ||
+		!strings.Contains(rec.Body.String(), `value="db-9"`) {
+		t.Fatalf("PinHost unknown: status = %d, body = %q", rec.Code, rec.Body.String())
+	}
+
+	rec = post(h.PinHost, incidentID.String(), url.Values{"host_identifier": {"web-1"}})
+	if rec.Code != http.StatusSeeOther || len(repo.pinned) != 1 {
+		t.Fatalf("PinHost: status = %d, pinned = %q", rec.Code, repo.pinned)
+	}
+
+	rec = post(h.AddNote, uuid.NewString(), url.Values{"note": {"elsewhere"}})
+	if rec.Code != http.StatusNotFound {
+		t.Fatalf("AddNote other incident: status = %d, want 404", rec.Code)
+	}
+
+	rec = post(h.PinCampaign, incidentID.String(), url.Values{"campaign_id": {"nope"}})
+	if rec.Code != http.StatusUnprocessableEntity || !strings.Contains(rec.Body.String(), "Choose a campaign") {
+		t.Fatalf("PinCampaign invalid: status = %d", rec.Code)
+	}
+}
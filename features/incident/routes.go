@@ -0,0 +1,40 @@
+package incident
+
+import (
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+
+	"github.com/cavenine/queryops/features/auth"
+	"github.com/cavenine/queryops/features/incident/services"
+	"github.com/cavenine/queryops/internal/pubsub"
+)
+
+type Feature struct {
+	handlers *Handlers
+}
+
+// NewFeature wires the incident feature. ps may be nil, in which case
+// workspaces poll for changes.
+func NewFeature(pool *pgxpool.Pool, ps *pubsub.PubSub) *Feature {
+	return &Feature{handlers: NewHandlers(services.NewIncidentRepository(pool), ps)}
+}
+
+// SetupRoutes registers incidents and their workspaces. They require an
+// active organization, and any of its members may use them.
+func (f *Feature) SetupRoutes(router chi.Router) {
+	router.Get("/incidents", f.handlers.IncidentsPage)
+	router.Post("/incidents", f.handlers.CreateIncident)
+	router.Route("/incidents/{id}", func(r chi.Router) {
+		r.Get("/", f.handlers.IncidentPage)
+		r.Method(http.MethodGet, "/live", auth.LiveStream(f.handlers.Live))
+		r.Post("/notes", f.handlers.AddNote)
+		r.Post("/campaigns", f.handlers.PinCampaign)
+		r.Post("/campaigns/{campaignID}/unpin", f.handlers.UnpinCampaign)
+		r.Post("/hosts", f.handlers.PinHost)
+		r.Post("/hosts/{hostID}/unpin", f.handlers.UnpinHost)
+		r.Post("/close", f.handlers.CloseIncident)
+		r.Post("/reopen", f.handlers.ReopenIncident)
+	})
+}
@@ -0,0 +1,498 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+
+	"github.com/cavenine/queryops/internal/outbox"
+	"github.com/cavenine/queryops/internal/pubsub"
+)
+
+// Incident statuses.
+const (
+	StatusOpen   = "open"
+	StatusClosed = "closed"
+)
+
+// Activity feed event kinds.
+const (
+	EventOpened           = "opened"
+	EventNote             = "note"
+	EventCampaignPinned   = "campaign_pinned"
+	EventCampaignUnpinned = "campaign_unpinned"
+	EventHostPinned       = "host_pinned"
+	EventHostUnpinned     = "host_unpinned"
+	EventClosed           = "closed"
+	EventReopened         = "reopened"
+)
+
+const (
+	// MaxTitleLength is the longest incident title.
+	MaxTitleLength = 200
+	// MaxNoteLength is the longest note.
+	MaxNoteLength = 10000
+	// FeedSize is how many of the latest events a workspace shows.
+	FeedSize = 200
+	// campaignChoices is how many of the organization's latest campaigns are
+	// offered for pinning.
+	campaignChoices = 50
+)
+
+var (
+	// ErrIncidentNotFound is returned when an incident does not exist or
+	// belongs to another organization.
+	ErrIncidentNotFound = errors.New("incident not found")
+	// ErrCampaignNotFound is returned when pinning a campaign the
+	// organization doesn't have.
+	ErrCampaignNotFound = errors.New("campaign not found")
+	// ErrHostNotFound is returned when pinning a host the organization
+	// doesn't have.
+	ErrHostNotFound = errors.New("host not found")
+)
+
+// Incident groups the campaigns, hosts, and notes of one investigation.
+type Incident struct {
+	ID             uuid.UUID
+	OrganizationID uuid.UUID
+	Title          string
+	Status         string
+	CreatedByEmail *string
+	CreatedAt      time.Time
+	UpdatedAt      time.Time
+	ClosedAt       *time.Time
+	CampaignCount  int
+	HostCount      int
+}
+
+// PinnedCampaign is a campaign pinned to an incident, with its progress.
+type PinnedCampaign struct {
+	ID          uuid.UUID
+	Name        string
+	Status      string
+	TargetCount int
+	ResultCount int
+	CreatedAt   time.Time
+	PinnedAt    time.Time
+}
+
+// PinnedHost is a host pinned to an incident.
+type PinnedHost struct {
+	ID             uuid.UUID
+	HostIdentifier string
+	LastSeenAt     *time.Time
+	PinnedAt       time.Time
+}
+
+// Event is an entry in an incident's activity feed: a note, or something a
+// responder did. Body is the note, or the name of the campaign or host acted
+// on.
+type Event struct {
+	ID         int64
+	Kind       string
+	Body       string
+	UserEmail  *string
+	CampaignID *uuid.UUID
+	HostID     *uuid.UUID
+	CreatedAt  time.Time
+}
+
+// Workspace is everything an incident's page shows.
+type Workspace struct {
+	Incident  *Incident
+	Campaigns []PinnedCampaign
+	Hosts     []PinnedHost
+	// Events are the latest FeedSize events, newest first.
+	Events []Event
+}
+
+// CampaignChoice is a campaign offered for pinning.
+type CampaignChoice struct {
+	ID        uuid.UUID
+	Name      string
+	Status    string
+	CreatedAt time.Time
+}
+
+// IncidentRepository stores incidents. Every change is added to the
+// incident's activity feed and announced on pubsub.TopicIncident through the
+// outbox, in the same transaction.
+type IncidentRepository struct {
+	pool *pgxpool.Pool
+}
+
+func NewIncidentRepository(pool *pgxpool.Pool) *IncidentRepository {
+	return &IncidentRepository{pool: pool}
+}
+
+const incidentColumns = `
+	i.id, i.organization_id, i.title, i.status, u.email, i.created_at, i.updated_at, i.closed_at,
+	(SELECT COUNT(*) FROM incident_campaigns c WHERE c.incident_id = i.id)::int,
+	(SELECT COUNT(*) FROM incident_hosts h WHERE h.incident_id = i.id)::int
+`
+
+func scanIncident(row pgx.Row) (*Incident, error) {
+	var i Incident
+	err := row.Scan(&i.ID, &i.OrganizationID, &i.Title, &i.Status, &i.CreatedByEmail, &i.CreatedAt, &i.UpdatedAt,
+		&i.ClosedAt, &i.CampaignCount, &i.HostCount)
+	if err != nil {
+		return nil, err
+	}
+	return &i, nil
+}
+
+// List returns the organization's incidents, open ones first, most recently
+// active first.
+func (r *IncidentRepository) List(ctx context.Context, organizationID uuid.UUID) ([]*Incident, error) {
+	rows, err := r.pool.Query(ctx, `
+		SELECT `+incidentColumns+`
+		FROM incidents i
+		LEFT JOIN users u ON u.id = i.created_by
+		WHERE i.organization_id = $1
+		ORDER BY i.status = 'closed', i.updated_at DESC
+	`, organizationID)
+	if err != nil {
+		return nil, fmt.Errorf("listing incidents: %w", err)
+	}
+	defer rows.Close()
+
+	var incidents []*Incident
+	for rows.Next() {
+		i, err := scanIncident(rows)
+		if err != nil {
+			return nil, fmt.Errorf("listing incidents: scanning incident: %w", err)
+		}
+		incidents = append(incidents, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("listing incidents: %w", err)
+	}
+	return incidents, nil
+}
+
+// Create opens an incident.
+func (r *IncidentRepository) Create(ctx context.Context, organizationID uuid.UUID, userID int, title string) (uuid.UUID, error) {
+	tx, err := r.pool.Begin(ctx)
+	if err != nil {
+		return uuid.Nil, fmt.Errorf("creating incident: begin transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	var id uuid.UUID
+	err = tx.QueryRow(ctx, `
+		INSERT INTO incidents (organization_id, title, created_by)
+		VALUES ($1, $2, $3)
+		RETURNING id
+	`, organizationID, title, userID).Scan(&id)
+	if err != nil {
+		return uuid.Nil, fmt.Errorf("creating incident: %w", err)
+	}
+	if err := recordEvent(ctx, tx, id, userID, Event{Kind: EventOpened, Body: title}); err != nil {
+		return uuid.Nil, fmt.Errorf("creating incident: %w", err)
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return uuid.Nil, fmt.Errorf("creating incident: commit transaction: %w", err)
+	}
+	return id, nil
+}
+
+// Workspace returns the incident with its pinned campaigns and hosts and its
+// latest events.
+func (r *IncidentRepository) Workspace(ctx context.Context, organizationID, incidentID uuid.UUID) (*Workspace, error) {
+	incident, err := scanIncident(r.pool.QueryRow(ctx, `
+		SELECT `+incidentColumns+`
+		FROM incidents i
+		LEFT JOIN users u ON u.id = i.created_by
+		WHERE i.id = $1 AND i.organization_id = $2
+	`, incidentID, organizationID))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrIncidentNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("getting incident: %w", err)
+	}
+	ws := &Workspace{Incident: incident}
+
+	rows, err := r.pool.Query(ctx, `
+		SELECT c.id, COALESCE(NULLIF(c.name, ''), c.query), c.status, c.target_count, c.result_count, c.created_at, ic.pinned_at
+		FROM incident_campaigns ic
+		JOIN campaigns c ON c.id = ic.campaign_id
+		WHERE ic.incident_id = $1
+		ORDER BY ic.pinned_at DESC
+	`, incidentID)
+	if err != nil {
+		return nil, fmt.Errorf("getting incident campaigns: %w", err)
+	}
+	ws.Campaigns, err = pgx.CollectRows(rows, func(row pgx.CollectableRow) (PinnedCampaign, error) {
+		var c PinnedCampaign
+		err := row.Scan(&c.ID, &c.Name, &c.Status, &c.TargetCount, &c.ResultCount, &c.CreatedAt, &c.PinnedAt)
+		return c, err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("getting incident campaigns: %w", err)
+	}
+
+	rows, err = r.pool.Query(ctx, `
+		SELECT h.id, h.host_identifier, GREATEST(h.last_logger_at, h.last_seen_at), ih.pinned_at
+		FROM incident_hosts ih
+		JOIN hosts h ON h.id = ih.host_id
+		WHERE ih.incident_id = $1
+		ORDER BY h.host_identifier
+	`, incidentID)
+	if err != nil {
+		return nil, fmt.Errorf("getting incident hosts: %w", err)
+	}
+	ws.Hosts, err = pgx.CollectRows(rows, func(row pgx.CollectableRow) (PinnedHost, error) {
+		var h PinnedHost
+		err := row.Scan(&h.ID, &h.HostIdentifier, &h.LastSeenAt, &h.PinnedAt)
+		return h, err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("getting incident hosts: %w", err)
+	}
+
+	rows, err = r.pool.Query(ctx, `
+		SELECT e.id, e.kind, e.body, u.email, e.campaign_id, e.host_id, e.created_at
+		FROM incident_events e
+		LEFT JOIN users u ON u.id = e.user_id
+		WHERE e.incident_id = $1
+		ORDER BY e.id DESC
+		LIMIT $2
+	`, incidentID, FeedSize)
+	if err != nil {
+		return nil, fmt.Errorf("getting incident events: %w", err)
+	}
+	ws.Events, err = pgx.CollectRows(rows, func(row pgx.CollectableRow) (Event, error) {
+		var e Event
+		err := row.Scan(&e.ID, &e.Kind, &e.Body, &e.UserEmail, &e.CampaignID, &e.HostID, &e.CreatedAt)
+		return e, err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("getting incident events: %w", err)
+	}
+	return ws, nil
+}
+
+// CampaignChoices returns the organization's latest unarchived campaigns, to
+// offer for pinning.
+func (r *IncidentRepository) CampaignChoices(ctx context.Context, organizationID uuid.UUID) ([]CampaignChoice, error) {
+	rows, err := r.pool.Query(ctx, `
+		SELECT id, COALESCE(NULLIF(name, ''), query), status, created_at
+		FROM campaigns
+		WHERE organization_id = $1 AND archived_at IS NULL
+		ORDER BY created_at DESC
+		LIMIT $2
+	`, organizationID, campaignChoices)
+	if err != nil {
+		return nil, fmt.Errorf("listing campaigns to pin: %w", err)
+	}
+	choices, err := pgx.CollectRows(rows, func(row pgx.CollectableRow) (CampaignChoice, error) {
+		var c CampaignChoice
+		err := row.Scan(&c.ID, &c.Name, &c.Status, &c.CreatedAt)
+		return c, err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("listing campaigns to pin: %w", err)
+	}
+	return choices, nil
+}
+
+// AddNote adds a note to the incident's feed.
+func (r *IncidentRepository) AddNote(ctx context.Context, organizationID, incidentID uuid.UUID, userID int, body string) error {
+	return r.change(ctx, "adding incident note", organizationID, incidentID, userID, func(tx pgx.Tx) (*Event, error) {
+		return &Event{Kind: EventNote, Body: body}, nil
+	})
+}
+
+// PinCampaign pins one of the organization's campaigns to the incident.
+// Pinning a campaign again does nothing.
+func (r *IncidentRepository) PinCampaign(ctx context.Context, organizationID, incidentID uuid.UUID, userID int, campaignID uuid.UUID) error {
+	return r.change(ctx, "pinning campaign", organizationID, incidentID, userID, func(tx pgx.Tx) (*Event, error) {
+		var name string
+		err := tx.QueryRow(ctx, `
+			SELECT COALESCE(NULLIF(name, ''), query) FROM campaigns WHERE id = $1 AND organization_id = $2
+		`, campaignID, organizationID).Scan(&name)
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, ErrCampaignNotFound
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		tag, err := tx.Exec(ctx, `
+			INSERT INTO incident_campaigns (incident_id, campaign_id, pinned_by)
+			VALUES ($1, $2, $3)
+			ON CONFLICT DO NOTHING
+		`, incidentID, campaignID, userID)
+		if err != nil || tag.RowsAffected() == 0 {
+			return nil, err
+		}
+		return &Event{Kind: EventCampaignPinned, Body: name, CampaignID: &campaignID}, nil
+	})
+}
+
+// UnpinCampaign removes a campaign from the incident.
+func (r *IncidentRepository) UnpinCampaign(ctx context.Context, organizationID, incidentID uuid.UUID, userID int, campaignID uuid.UUID) error {
+	return r.change(ctx, "unpinning campaign", organizationID, incidentID, userID, func(tx pgx.Tx) (*Event, error) {
+		var name string
+		err := tx.QueryRow(ctx, `
+			DELETE FROM incident_campaigns ic
+			USING campaigns c
+			WHERE ic.incident_id = $1 AND ic.campaign_id = $2 AND c.id = ic.campaign_id
+			RETURNING COALESCE(NULLIF(c.name, ''), c.query)
+		`, incidentID, campaignID).Scan(&name)
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		return &Event{Kind: EventCampaignUnpinned, Body: name, CampaignID: &campaignID}, nil
+	})
+}
+
+// PinHost pins the organization's host with hostIdentifier to the incident.
+// Pinning a host again does nothing.
+func (r *IncidentRepository) PinHost(ctx context.Context, organizationID, incidentID uuid.UUID, userID int, hostIdentifier string) error {
+	return r.change(ctx, "pinning host", organizationID, incidentID, userID, func(tx pgx.Tx) (*Event, error) {
+		var hostID uuid.UUID
+		var identifier string
+		err := tx.QueryRow(ctx, `
+			SELECT id, host_identifier FROM hosts
+			WHERE organization_id = $1 AND (host_identifier = $2 OR id::text = $2)
+			ORDER BY host_identifier = $2 DESC
+			LIMIT 1
+		`, organizationID, hostIdentifier).Scan(&hostID, &identifier)
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, ErrHostNotFound
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		tag, err := tx.Exec(ctx, `
+			INSERT INTO incident_hosts (incident_id, host_id, pinned_by)
+			VALUES ($1, $2, $3)
+			ON CONFLICT DO NOTHING
+		`, incidentID, hostID, userID)
+		if err != nil || tag.RowsAffected() == 0 {
+			return nil, err
+		}
+		return &Event{Kind: EventHostPinned, Body: identifier, HostID: &hostID}, nil
+	})
+}
+
+// UnpinHost removes a host from the incident.
+func (r *IncidentRepository) UnpinHost(ctx context.Context, organizationID, incidentID uuid.UUID, userID int, hostID uuid.UUID) error {
+	return r.change(ctx, "unpinning host", organizationID, incidentID, userID, func(tx pgx.Tx) (*Event, error) {
+		var identifier string
+		err := tx.QueryRow(ctx, `
+			DELETE FROM incident_hosts ih
+			USING hosts h
+			WHERE ih.incident_id = $1 AND ih.host_id = $2 AND h.id = ih.host_id
+			RETURNING h.host_identifier
+		`, incidentID, hostID).Scan(&identifier)
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		return &Event{Kind: EventHostUnpinned, Body: identifier, HostID: &hostID}, nil
+	})
+}
+
+// SetStatus closes or reopens the incident. Setting the status it already
+// has does nothing.
+func (r *IncidentRepository) SetStatus(ctx context.Context, organizationID, incidentID uuid.UUID, userID int, status string) error {
+	kind := EventReopened
+	if status == StatusClosed {
+		kind = EventClosed
+	}
+	return r.change(ctx, "setting incident status", organizationID, incidentID, userID, func(tx pgx.Tx) (*Event, error) {
+		tag, err := tx.Exec(ctx, `
+			UPDATE incidents
+			SET status = $2, closed_at = CASE WHEN $2 = 'closed' THEN NOW() END
+			WHERE id = $1 AND status <> $2
+		`, incidentID, status)
+		if err != nil || tag.RowsAffected() == 0 {
+			return nil, err
+		}
+		return &Event{Kind: kind}, nil
+	})
+}
+
+// change runs apply in a transaction holding the incident's row, then
+// records the event it returns, if any. A nil event means nothing changed.
+func (r *IncidentRepository) change(
+	ctx context.Context,
+	op string,
+	organizationID, incidentID uuid.UUID,
+	userID int,
+	apply func(tx pgx.Tx) (*Event, error),
+) error {
+	tx, err := r.pool.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("%s: begin transaction: %w", op, err)
+	}
+	defer tx.Rollback(ctx)
+
+	var exists bool
+	err = tx.QueryRow(ctx, `
+		SELECT true FROM incidents WHERE id = $1 AND organization_id = $2 FOR UPDATE
+	`, incidentID, organizationID).Scan(&exists)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return ErrIncidentNotFound
+	}
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	event, err := apply(tx)
+	if errors.Is(err, ErrCampaignNotFound) || errors.Is(err, ErrHostNotFound) {
+		return err
+	}
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	if event == nil {
+		return nil
+	}
+
+	if err := recordEvent(ctx, tx, incidentID, userID, *event); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return fmt.Errorf("%s: commit transaction: %w", op, err)
+	}
+	return nil
+}
+
+// recordEvent adds e to the incident's feed, marks the incident active, and
+// announces the event.
+func recordEvent(ctx context.Context, tx pgx.Tx, incidentID uuid.UUID, userID int, e Event) error {
+	var createdAt time.Time
+	err := tx.QueryRow(ctx, `
+		INSERT INTO incident_events (incident_id, user_id, kind, body, campaign_id, host_id)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		RETURNING created_at
+	`, incidentID, userID, e.Kind, e.Body, e.CampaignID, e.HostID).Scan(&createdAt)
+	if err != nil {
+		return fmt.Errorf("inserting incident event: %w", err)
+	}
+	if _, err := tx.Exec(ctx, `UPDATE incidents SET updated_at = $2 WHERE id = $1`, incidentID, createdAt); err != nil {
+		return fmt.Errorf("updating incident: %w", err)
+	}
+
+	event := pubsub.IncidentEvent{IncidentID: incidentID, Kind: e.Kind, CampaignID: e.CampaignID, OccurredAt: createdAt}
+	return outbox.Insert(ctx, tx, outbox.Event{
+		Topic:   pubsub.TopicIncident(incidentID),
+		Message: event.ToMessage(),
+	})
+}
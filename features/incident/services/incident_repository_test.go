@@ -0,0 +1,166 @@
+package services_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/google/uuid"
+
+	"github.com/cavenine/queryops/features/incident/services"
+	"github.com/cavenine/queryops/internal/pubsub"
+	"github.com/cavenine/queryops/internal/testdb"
+	"github.com/cavenine/queryops/internal/testdb/fixtures"
+)
+
+func TestIncidentRepository_Workspace(t *testing.T) {
+	tdb := testdb.SetupTestDB(t)
+	ctx := context.Background()
+	repo := services.NewIncidentRepository(tdb.Pool)
+
+	orgID := fixtures.CreateOrg(t, tdb.Pool, "incident-org").ID
+	otherOrgID := fixtures.CreateOrg(t, tdb.Pool, "other-org").ID
+	user := fixtures.CreateUser(t, tdb.Pool, "responder@example.com")
+	host := fixtures.CreateHost(t, tdb.Pool, orgID, "web-1")
+	campaign := fixtures.CreateCampaign(t, tdb.Pool, orgID, "SELECT * FROM crontab;", host.ID)
+	otherCampaign := fixtures.CreateCampaign(t, tdb.Pool, otherOrgID, "SELECT 1;")
+
+	id, err := repo.Create(ctx, orgID, user.ID, "Suspicious cron jobs")
+	if err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+
+	if err := repo.AddNote(ctx, orgID, id, user.ID, "Started on web-1"); err != nil {
+		t.Fatalf("AddNote: %v", err)
+	}
+	for range 2 {
+		if err := repo.PinCampaign(ctx, orgID, id, user.ID, campaign.ID); err != nil {
+			t.Fatalf("PinCampaign: %v", err)
+		}
+	}
+	if err := repo.PinCampaign(ctx, orgID, id, user.ID, otherCampaign.ID); !errors.Is(err, services.ErrCampaignNotFound) {
+		t.Fatalf("PinCampaign other organization's campaign: err = %v, want ErrCampaignNotFound", err)
+	}
+	if err := repo.PinHost(ctx, orgID, id, user.ID, "web-1"); err != nil {
+		t.Fatalf("PinHost: %v", err)
+	}
+	if err := repo.PinHost(ctx, orgID, id, user.ID, "db-9"); !errors.Is(err, services.ErrHostNotFound) {
+		t.Fatalf("PinHost unknown host: err = %v, want ErrHostNotFound", err)
+	}
+	if err := repo.AddNote(ctx, otherOrgID, id, user.ID, "wrong org"); !errors.Is(err, services.ErrIncidentNotFound) {
+		t.Fatalf("AddNote from other organization: err = %v, want ErrIncidentNotFound", err)
+	}
+
+	ws, err := repo.Workspace(ctx, orgID, id)
+	if err != nil {
+		t.Fatalf("Workspace: %v", err)
+	}
+	if ws.Incident.Title != "Suspicious cron jobs" || ws.Incident.Status != services.StatusOpen ||
+		ws.Incident.CampaignCount != 1 || ws.Incident.HostCount != 1 {
+		t.Fatalf("Incident = %+v", ws.Incident)
+	}
+	if len(ws.Campaigns) != 1 || ws.Campaigns[0].ID != campaign.ID || ws.Campaigns[0].TargetCount != 1 {
+		t.Fatalf("Campaigns = %+v", ws.Campaigns)
+	}
+	if len(ws.Hosts) != 1 || ws.Hosts[0].ID != host.ID {
+		t.Fatalf("Hosts = %+v", ws.Hosts)
+	}
+	wantKinds := []string{services.EventHostPinned, services.EventCampaignPinned, services.EventNote, services.EventOpened}
+	if len(ws.Events) != len(wantKinds) {
+		t.Fatalf("Events = %+v, want kinds %v", ws.Events, wantKinds)
+	}
+	for i, kind := range wantKinds {
+		if ws.Events[i].Kind != kind {
+			t.Fatalf("Events[%d].Kind = %q, want %q", i, ws.Events[i].Kind, kind)
+		}
+	}
+	if ws.Events[0].UserEmail == nil || *ws.Events[0].UserEmail != "responder@example.com" {
+		t.Fatalf("Events[0].UserEmail = %v", ws.Events[0].UserEmail)
+	}
+
+	if _, err := repo.Workspace(ctx, otherOrgID, id); !errors.Is(err, services.ErrIncidentNotFound) {
+		t.Fatalf("Workspace from other organization: err = %v, want ErrIncidentNotFound", err)
+	}
+
+	var announced int
+	err = tdb.Pool.QueryRow(ctx, `SELECT COUNT(*) FROM pubsub_outbox WHERE topic = $1`, pubsub.TopicIncident(id)).Scan(&announced)
+	if err != nil {
+		t.Fatalf("counting outbox events: %v", err)
+	}
+	if announced != len(wantKinds) {
+		t.Fatalf("outbox events = %d, want %d", announced, len(wantKinds))
+	}
+}
+
+func TestIncidentRepository_StatusAndUnpin(t *testing.T) {
+	tdb := testdb.SetupTestDB(t)
+	ctx := context.Background()
+	repo := services.NewIncidentRepository(tdb.Pool)
+
+	orgID := fixtures.CreateOrg(t, tdb.Pool, "incident-org").ID
+	user := fixtures.CreateUser(t, tdb.Pool, "responder@example.com")
+	host := fixtures.CreateHost(t, tdb.Pool, orgID, "web-1")
+	campaign := fixtures.CreateCampaign(t, tdb.Pool, orgID, "SELECT 1;", host.ID)
+
+	id, err := repo.Create(ctx, orgID, user.ID, "Outage")
+	if err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	if err := repo.PinCampaign(ctx, orgID, id, user.ID, campaign.ID); err != nil {
+		t.Fatalf("PinCampaign: %v", err)
+	}
+	if err := repo.PinHost(ctx, orgID, id, user.ID, host.ID.String()); err != nil {
+		t.Fatalf("PinHost by id: %v", err)
+	}
+	if err := repo.UnpinCampaign(ctx, orgID, id, user.ID, campaign.ID); err != nil {
+		t.Fatalf("UnpinCampaign: %v", err)
+	}
+	if err := repo.UnpinHost(ctx, orgID, id, user.ID, host.ID); err != nil {
+		t.Fatalf("UnpinHost: %v", err)
+	}
+	if err := repo.UnpinHost(ctx, orgID, id, user.ID, uuid.New()); err != nil {
+		t.Fatalf("UnpinHost not pinned: %v", err)
+	}
+	for range 2 {
+		if err := repo.SetStatus(ctx, orgID, id, user.ID, services.StatusClosed); err != nil {
+			t.Fatalf("SetStatus: %v", err)
+		}
+	}
+
+	incidents, err := repo.List(ctx, orgID)
+	if err != nil {
+		t.Fatalf("List: %v", err)
+	}
+	if len(incidents) != 1 || incidents[0].Status != services.StatusClosed || incidents[0].ClosedAt == nil ||
+		incidents[0].CampaignCount != 0 || incidents[0].HostCount != 0 {
+		t.Fatalf("List = %+v", incidents)
+	}
+
+	ws, err := repo.Workspace(ctx, orgID, id)
+	if err != nil {
+		t.Fatalf("Workspace: %v", err)
+	}
+	wantKinds := []string{
+		services.EventClosed, services.EventHostUnpinned, services.EventCampaignUnpinned,
+		services.EventHostPinned, services.EventCampaignPinned, services.EventOpened,
+	}
+	if len(ws.Events) != len(wantKinds) {
+		t.Fatalf("Events = %+v, want kinds %v", ws.Events, wantKinds)
+	}
+	for i, kind := range wantKinds {
+		if ws.Events[i].Kind != kind {
+			t.Fatalf("Events[%d].Kind = %q, want %q", i, ws.Events[i].Kind, kind)
+		}
+	}
+
+	if err := repo.SetStatus(ctx, orgID, id, user.ID, services.StatusOpen); err != nil {
+		t.Fatalf("SetStatus reopen: %v", err)
+	}
+	ws, err = repo.Workspace(ctx, orgID, id)
+	if err != nil {
+		t.Fatalf("Workspace: %v", err)
+	}
+	if ws.Incident.Status != services.StatusOpen || ws.Incident.ClosedAt != nil || ws.Events[0].Kind != services.EventReopened {
+		t.Fatalf("after reopen: incident = %+v, latest event = %q", ws.Incident, ws.Events[0].Kind)
+	}
+}
@@ -9,11 +9,19 @@ import (
 	"github.com/jackc/pgx/v5/pgxpool"
 )
 
-func SetupRoutes(router chi.Router, sessionManager *scs.SessionManager, pool *pgxpool.Pool, orgService *orgServices.OrganizationService) error {
+type Feature struct {
+	handlers *Handlers
+}
+
+func NewFeature(sessionManager *scs.SessionManager, pool *pgxpool.Pool, orgService *orgServices.OrganizationService) *Feature {
 	repo := services.NewTodoRepository(pool)
 	todoService := services.NewTodoService(repo, sessionManager)
 
-	handlers := NewHandlers(todoService, orgService)
+	return &Feature{handlers: NewHandlers(todoService, orgService)}
+}
+
+func (f *Feature) SetupRoutes(router chi.Router) error {
+	handlers := f.handlers
 
 	router.Get("/", handlers.IndexPage)
 
@@ -0,0 +1,282 @@
+package notification
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/ThreeDotsLabs/watermill/message"
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+	"github.com/starfederation/datastar-go/datastar"
+
+	"github.com/cavenine/queryops/features/auth"
+	"github.com/cavenine/queryops/features/notification/components"
+	"github.com/cavenine/queryops/features/notification/services"
+	"github.com/cavenine/queryops/internal/pubsub"
+)
+
+const (
+	// bellSize is how many notifications the navbar menu lists.
+	bellSize = 10
+
+	// pollInterval is how often the stream re-reads notifications when pubsub
+	// is unavailable.
+	pollInterval = 15 * time.Second
+)
+
+type notificationRepository interface {
+	ListForUser(ctx context.Context, userID int, limit int) ([]services.Notification, error)
+	CountUnread(ctx context.Context, userID int) (int, error)
+	MarkRead(ctx context.Context, userID int, id uuid.UUID) (*services.Notification, error)
+	MarkAllRead(ctx context.Context, userID int) error
+}
+
+type Handlers struct {
+	repo   notificationRepository
+	pubsub *pubsub.PubSub
+}
+
+// NewHandlers creates notification handlers. ps may be nil, in which case the
+// stream polls.
+func NewHandlers(repo notificationRepository, ps *pubsub.PubSub) *Handlers {
+	return &Handlers{repo: repo, pubsub: ps}
+}
+
+// bell is the user's notification menu and the state it was rendered from.
+type bell struct {
+	unread        int
+	notifications []services.Notification
+}
+
+func (h *Handlers) loadBell(ctx context.Context, userID int) (*bell, error) {
+	unread, err := h.repo.CountUnread(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	notifications, err := h.repo.ListForUser(ctx, userID, bellSize)
+	if err != nil {
+		return nil, err
+	}
+	return &bell{unread: unread, notifications: notifications}, nil
+}
+
+func (b *bell) patch(sse *datastar.ServerSentEventGenerator) error {
+	return sse.PatchElementTempl(components.BellMenu(b.unread, b.notifications))
+}
+
+// Stream keeps the navbar bell current for the signed-in user. It stays open
+// for as long as the page is.
+func (h *Handlers) Stream(w http.ResponseWriter, r *http.Request) {
+	user := auth.GetUserFromContext(r.Context())
+	if user == nil {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	ctx := r.Context()
+
+	b, err := h.loadBell(ctx, user.ID)
+	if err != nil {
+		slog.ErrorContext(ctx, "failed to load notifications", "error", err)
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+
+	sse := datastar.NewSSE(w, r)
+	if err := b.patch(sse); err != nil {
+		return
+	}
+
+	if h.pubsub == nil {
+		h.pollLegacy(ctx, sse, user.ID, b)
+		return
+	}
+
+	subscriber, err := h.pubsub.NewSubscriber(ctx)
+	if err != nil {
+		slog.ErrorContext(ctx, "failed to create subscriber; falling back to polling", "error", err)
+		h.pollLegacy(ctx, sse, user.ID, b)
+		return
+	}
+	defer func() {
+		_ = subscriber.Close()
+	}()
+
+	topic := pubsub.TopicNotifications(user.ID)
+	messages, err := subscriber.Subscribe(ctx, topic)
+	if err != nil {
+		slog.ErrorContext(ctx, "failed to subscribe; falling back to polling", "error", err, "topic", topic)
+		h.pollLegacy(ctx, sse, user.ID, b)
+		return
+	}
+
+	var loaded *bell
+	handle := h.pubsub.Handler("sse:notifications", topic, func(msg *message.Message) error {
+		event, err := pubsub.ParseNotificationEvent(msg)
+		if err != nil {
+			return pubsub.Poison(err)
+		}
+
+		// Topic-scoped, but keep it defensive.
+		if event.UserID != user.ID {
+			return nil
+		}
+
+		loaded, err = h.loadBell(ctx, user.ID)
+		if err != nil {
+			return fmt.Errorf("loading notifications after event: %w", err)
+		}
+		return nil
+	})
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case msg := <-messages:
+			if msg == nil {
+				return
+			}
+
+			loaded = nil
+			if err := pubsub.Handle(msg, handle); err != nil || loaded == nil {
+				continue
+			}
+			if err := loaded.patch(sse); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// pollLegacy implements the fallback polling mechanism for Stream.
+// Used when pub/sub is unavailable or subscription fails.
+func (h *Handlers) pollLegacy(ctx context.Context, sse *datastar.ServerSentEventGenerator, userID int, initial *bell) {
+	snapshot := initial.snapshot()
+
+	ticker := time.NewTicker(pollInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			b, err := h.loadBell(ctx, userID)
+			if err != nil {
+				_ = sse.ConsoleError(err)
+				return
+			}
+
+			if s := b.snapshot(); !bytes.Equal(s, snapshot) {
+				snapshot = s
+				if err := b.patch(sse); err != nil {
+					return
+				}
+			}
+		}
+	}
+}
+
+func (b *bell) snapshot() []byte {
+	s, err := json.Marshal(map[string]any{"unread": b.unread, "notifications": b.notifications})
+	if err != nil {
+		return nil
+	}
+	return s
+}
+
+// Open marks a notification read and redirects to the page it is about.
+func (h *Handlers) Open(w http.ResponseWriter, r *http.Request) {
+	user := auth.GetUserFromContext(r.Context())
+	if user == nil {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	id, err := uuid.Parse(chi.URLParam(r, "id"))
+	if err != nil {
+		http.Error(w, "invalid notification id", http.StatusBadRequest)
+		return
+	}
+
+	n, err := h.repo.MarkRead(r.Context(), user.ID, id)
+	if errors.Is(err, services.ErrNotificationNotFound) {
+		http.Error(w, "notification not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		slog.ErrorContext(r.Context(), "failed to mark notification read", "error", err)
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+
+	target := "/"
+	if n.Link != nil && *n.Link != "" {
+		target = *n.Link
+	}
+	http.Redirect(w, r, target, http.StatusSeeOther)
+}
+
+// MarkRead marks one notification read and re-renders the bell.
+func (h *Handlers) MarkRead(w http.ResponseWriter, r *http.Request) {
+	user := auth.GetUserFromContext(r.Context())
+	if user == nil {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	id, err := uuid.Parse(chi.URLParam(r, "id"))
+	if err != nil {
+		http.Error(w, "invalid notification id", http.StatusBadRequest)
+		return
+	}
+
+	if _, err := h.repo.MarkRead(r.Context(), user.ID, id); err != nil {
+		if errors.Is(err, services.ErrNotificationNotFound) {
+			http.Error(w, "notification not found", http.StatusNotFound)
+			return
+		}
+		slog.ErrorContext(r.Context(), "failed to mark notification read", "error", err)
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+
+	h.patchBell(w, r, user.ID)
+}
+
+// MarkAllRead marks all of the user's notifications read and re-renders the
+// bell.
+func (h *Handlers) MarkAllRead(w http.ResponseWriter, r *http.Request) {
+	user := auth.GetUserFromContext(r.Context())
+	if user == nil {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	if err := h.repo.MarkAllRead(r.Context(), user.ID); err != nil {
+		slog.ErrorContext(r.Context(), "failed to mark notifications read", "error", err)
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+
+	h.patchBell(w, r, user.ID)
+}
+
+func (h *Handlers) patchBell(w http.ResponseWriter, r *http.Request, userID int) {
+	b, err := h.loadBell(r.Context(), userID)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "failed to load notifications", "error", err)
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+
+	sse := datastar.NewSSE(w, r)
+	_ = b.patch(sse)
+}
@@ -0,0 +1,31 @@
+package notification
+
+import (
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+
+	"github.com/cavenine/queryops/features/auth"
+	"github.com/cavenine/queryops/features/notification/services"
+	"github.com/cavenine/queryops/internal/pubsub"
+)
+
+type Feature struct {
+	handlers *Handlers
+}
+
+// NewFeature wires the notification feature. ps may be nil, in which case
+// the stream polls.
+func NewFeature(pool *pgxpool.Pool, ps *pubsub.PubSub) *Feature {
+	return &Feature{handlers: NewHandlers(services.NewNotificationRepository(pool), ps)}
+}
+
+// SetupRoutes registers the notification stream and mark-as-read endpoints.
+// They require an authenticated user but no active organization.
+func (f *Feature) SetupRoutes(router chi.Router) {
+	router.Method(http.MethodGet, "/notifications/stream", auth.LiveStream(f.handlers.Stream))
+	router.Get("/notifications/{id}", f.handlers.Open)
+	router.Post("/notifications/{id}/read", f.handlers.MarkRead)
+	router.Post("/notifications/read-all", f.handlers.MarkAllRead)
+}
@@ -0,0 +1,11 @@
+package services_test
+
+import (
+	"testing"
+
+	"github.com/cavenine/queryops/internal/testdb"
+)
+
+func TestMain(m *testing.M) {
+	testdb.RunWithPostgres(m)
+}
@@ -0,0 +1,390 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+
+	"github.com/cavenine/queryops/internal/outbox"
+	"github.com/cavenine/queryops/internal/pubsub"
+)
+
+// Notification kinds.
+const (
+	KindCampaignFinished = "campaign_finished"
+	KindHostOffline      = "host_offline"
+	KindWebhookFailed    = "webhook_failed"
+	KindStatusAlert      = "status_alert"
+	KindResultAnomaly    = "result_anomaly"
+)
+
+// Kinds lists every notification kind, in the order the settings page offers
+// them for muting.
+var Kinds = []string{
+	KindCampaignFinished,
+	KindHostOffline,
+	KindStatusAlert,
+	KindResultAnomaly,
+	KindWebhookFailed,
+}
+
+// ErrNotificationNotFound is returned when a notification does not exist or
+// belongs to another user.
+var ErrNotificationNotFound = errors.New("notification not found")
+
+// Notification is a message shown to one user in the navbar.
+type Notification struct {
+	ID             uuid.UUID  `json:"id"`
+	UserID         int        `json:"user_id"`
+	OrganizationID *uuid.UUID `json:"organization_id,omitempty"`
+	Kind           string     `json:"kind"`
+	Title          string     `json:"title"`
+	Body           string     `json:"body"`
+	// Link is the page the notification is about, e.g. "/campaigns/<id>".
+	Link      *string    `json:"link,omitempty"`
+	ReadAt    *time.Time `json:"read_at,omitempty"`
+	CreatedAt time.Time  `json:"created_at"`
+}
+
+// NotificationRepository stores notifications. Every notification created is
+// announced on pubsub.TopicNotifications through the outbox, in the same
+// transaction.
+type NotificationRepository struct {
+	pool *pgxpool.Pool
+}
+
+func NewNotificationRepository(pool *pgxpool.Pool) *NotificationRepository {
+	return &NotificationRepository{pool: pool}
+}
+
+// NotifyOrganization sends n to every member of the organization and returns
+// how many notifications were created.
+func (r *NotificationRepository) NotifyOrganization(ctx context.Context, organizationID uuid.UUID, n Notification) (int, error) {
+	tx, err := r.pool.Begin(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("notifying organization: begin transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	n.OrganizationID = &organizationID
+	created, err := insertForOrganization(ctx, tx, n)
+	if err != nil {
+		return 0, fmt.Errorf("notifying organization: %w", err)
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return 0, fmt.Errorf("notifying organization: commit transaction: %w", err)
+	}
+	return created, nil
+}
+
+// NotifyFinishedCampaigns notifies about campaigns that completed or failed
+// since the last call. The campaign's creator is notified, or every member of
+// its organization if it was created without a user.
+func (r *NotificationRepository) NotifyFinishedCampaigns(ctx context.Context) (int, error) {
+	tx, err := r.pool.Begin(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("notifying finished campaigns: begin transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	rows, err := tx.Query(ctx, `
+		UPDATE campaigns
+		SET notified_at = NOW()
+		WHERE notified_at IS NULL AND status IN ('completed', 'failed')
+		RETURNING id, organization_id, created_by, COALESCE(name, query), status, result_count, target_count
+	`)
+	if err != nil {
+		return 0, fmt.Errorf("notifying finished campaigns: %w", err)
+	}
+
+	type finished struct {
+		id, organizationID       uuid.UUID
+		createdBy                *int
+		name, status             string
+		resultCount, targetCount int
+	}
+	var campaigns []finished
+	for rows.Next() {
+		var c finished
+		if err := rows.Scan(&c.id, &c.organizationID, &c.createdBy, &c.name, &c.status, &c.resultCount, &c.targetCount); err != nil {
+			rows.Close()
+			return 0, fmt.Errorf("notifying finished campaigns: scanning campaign: %w", err)
+		}
+		campaigns = append(campaigns, c)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, fmt.Errorf("notifying finished campaigns: %w", err)
+	}
+
+	var created int
+	for _, c := range campaigns {
+		link := "/campaigns/" + c.id.String()
+		n := Notification{
+			OrganizationID: &c.organizationID,
+			Kind:           KindCampaignFinished,
+			Title:          "Campaign " + c.status,
+			Body:           fmt.Sprintf("%s: %d/%d hosts responded", truncate(c.name, 80), c.resultCount, c.targetCount),
+			Link:           &link,
+		}
+
+		if c.createdBy != nil {
+			if err := queueSlackMessages(ctx, tx, n); err != nil {
+				return 0, fmt.Errorf("notifying finished campaigns: %w", err)
+			}
+			isMuted, err := muted(ctx, tx, c.organizationID, n.Kind)
+			if err != nil {
+				return 0, fmt.Errorf("notifying finished campaigns: %w", err)
+			}
+			if isMuted {
+				continue
+			}
+			n.UserID = *c.createdBy
+			if err := insert(ctx, tx, n); err != nil {
+				return 0, fmt.Errorf("notifying finished campaigns: %w", err)
+			}
+			created++
+			continue
+		}
+
+		count, err := insertForOrganization(ctx, tx, n)
+		if err != nil {
+			return 0, fmt.Errorf("notifying finished campaigns: %w", err)
+		}
+		created += count
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return 0, fmt.Errorf("notifying finished campaigns: commit transaction: %w", err)
+	}
+	return created, nil
+}
+
+// NotifyOfflineHosts notifies organization members about hosts whose last
+// check-in was before offlineBefore. A host is notified once per outage, and
+// the outage is added to its timeline.
+func (r *NotificationRepository) NotifyOfflineHosts(ctx context.Context, offlineBefore time.Time) (int, error) {
+	tx, err := r.pool.Begin(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("notifying offline hosts: begin transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	rows, err := tx.Query(ctx, `
+		UPDATE hosts
+		SET offline_notified_at = NOW()
+		WHERE GREATEST(last_logger_at, last_seen_at) < $1
+			AND (offline_notified_at IS NULL OR offline_notified_at < GREATEST(last_logger_at, last_seen_at))
+		RETURNING id, organization_id, host_identifier, GREATEST(last_logger_at, last_seen_at)
+	`, offlineBefore)
+	if err != nil {
+		return 0, fmt.Errorf("notifying offline hosts: %w", err)
+	}
+
+	type offline struct {
+		id, organizationID uuid.UUID
+		identifier         string
+		lastSeen           time.Time
+	}
+	var hosts []offline
+	for rows.Next() {
+		var h offline
+		if err := rows.Scan(&h.id, &h.organizationID, &h.identifier, &h.lastSeen); err != nil {
+			rows.Close()
+			return 0, fmt.Errorf("notifying offline hosts: scanning host: %w", err)
+		}
+		hosts = append(hosts, h)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, fmt.Errorf("notifying offline hosts: %w", err)
+	}
+
+	var created int
+	for _, h := range hosts {
+		link := "/hosts/" + h.id.String()
+		count, err := insertForOrganization(ctx, tx, Notification{
+			OrganizationID: &h.organizationID,
+			Kind:           KindHostOffline,
+			Title:          "Host offline",
+			Body:           fmt.Sprintf("%s last checked in at %s", h.identifier, h.lastSeen.UTC().Format("2006-01-02 15:04 MST")),
+			Link:           &link,
+		})
+		if err != nil {
+			return 0, fmt.Errorf("notifying offline hosts: %w", err)
+		}
+		created += count
+
+		event := pubsub.HostActivityEvent{
+			HostID:     h.id,
+			Type:       pubsub.HostActivityWentOffline,
+			Detail:     "last checked in at " + h.lastSeen.UTC().Format("2006-01-02 15:04 MST"),
+			OccurredAt: time.Now().UTC(),
+		}
+		if err := outbox.Insert(ctx, tx, outbox.Event{
+			Topic:   pubsub.TopicHostActivity(h.id),
+			Message: event.ToMessage(),
+		}); err != nil {
+			return 0, fmt.Errorf("notifying offline hosts: %w", err)
+		}
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return 0, fmt.Errorf("notifying offline hosts: commit transaction: %w", err)
+	}
+	return created, nil
+}
+
+// ListForUser returns the user's most recent notifications, newest first.
+func (r *NotificationRepository) ListForUser(ctx context.Context, userID int, limit int) ([]Notification, error) {
+	rows, err := r.pool.Query(ctx, `
+		SELECT id, user_id, organization_id, kind, title, body, link, read_at, created_at
+		FROM notifications
+		WHERE user_id = $1
+		ORDER BY created_at DESC, id
+		LIMIT $2
+	`, userID, limit)
+	if err != nil {
+		return nil, fmt.Errorf("listing notifications: %w", err)
+	}
+	defer rows.Close()
+
+	var notifications []Notification
+	for rows.Next() {
+		var n Notification
+		if err := rows.Scan(&n.ID, &n.UserID, &n.OrganizationID, &n.Kind, &n.Title, &n.Body, &n.Link, &n.ReadAt, &n.CreatedAt); err != nil {
+			return nil, fmt.Errorf("scanning notification: %w", err)
+		}
+		notifications = append(notifications, n)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("listing notifications: %w", err)
+	}
+	return notifications, nil
+}
+
+// CountUnread returns how many of the user's notifications are unread.
+func (r *NotificationRepository) CountUnread(ctx context.Context, userID int) (int, error) {
+	var n int
+	err := r.pool.QueryRow(ctx, `
+		SELECT COUNT(*) FROM notifications WHERE user_id = $1 AND read_at IS NULL
+	`, userID).Scan(&n)
+	if err != nil {
+		return 0, fmt.Errorf("counting unread notifications: %w", err)
+	}
+	return n, nil
+}
+
+// MarkRead marks one of the user's notifications as read and returns it. It
+// returns ErrNotificationNotFound if the notification is not the user's.
+func (r *NotificationRepository) MarkRead(ctx context.Context, userID int, id uuid.UUID) (*Notification, error) {
+	var n Notification
+	err := r.pool.QueryRow(ctx, `
+		UPDATE notifications
+		SET read_at = COALESCE(read_at, NOW())
+		WHERE id = $1 AND user_id = $2
+		RETURNING id, user_id, organization_id, kind, title, body, link, read_at, created_at
+	`, id, userID).Scan(&n.ID, &n.UserID, &n.OrganizationID, &n.Kind, &n.Title, &n.Body, &n.Link, &n.ReadAt, &n.CreatedAt)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrNotificationNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("marking notification read: %w", err)
+	}
+	return &n, nil
+}
+
+// MarkAllRead marks all of the user's notifications as read.
+func (r *NotificationRepository) MarkAllRead(ctx context.Context, userID int) error {
+	_, err := r.pool.Exec(ctx, `
+		UPDATE notifications SET read_at = NOW() WHERE user_id = $1 AND read_at IS NULL
+	`, userID)
+	if err != nil {
+		return fmt.Errorf("marking notifications read: %w", err)
+	}
+	return nil
+}
+
+// insertForOrganization inserts a copy of n for every member of
+// *n.OrganizationID, unless the organization has muted n.Kind, and queues it
+// for the organization's Slack routes.
+func insertForOrganization(ctx context.Context, tx pgx.Tx, n Notification) (int, error) {
+	if err := queueSlackMessages(ctx, tx, n); err != nil {
+		return 0, err
+	}
+	isMuted, err := muted(ctx, tx, *n.OrganizationID, n.Kind)
+	if err != nil {
+		return 0, err
+	}
+	if isMuted {
+		return 0, nil
+	}
+
+	rows, err := tx.Query(ctx, `
+		SELECT user_id FROM organization_members WHERE organization_id = $1 ORDER BY user_id
+	`, n.OrganizationID)
+	if err != nil {
+		return 0, fmt.Errorf("listing organization members: %w", err)
+	}
+	userIDs, err := pgx.CollectRows(rows, pgx.RowTo[int])
+	if err != nil {
+		return 0, fmt.Errorf("listing organization members: %w", err)
+	}
+
+	for _, userID := range userIDs {
+		n.UserID = userID
+		if err := insert(ctx, tx, n); err != nil {
+			return 0, err
+		}
+	}
+	return len(userIDs), nil
+}
+
+// muted reports whether the organization has turned off notifications of
+// kind in its settings.
+func muted(ctx context.Context, tx pgx.Tx, organizationID uuid.UUID, kind string) (bool, error) {
+	var isMuted bool
+	err := tx.QueryRow(ctx, `
+		SELECT EXISTS (
+			SELECT 1 FROM organization_settings
+			WHERE organization_id = $1 AND $2 = ANY(muted_notification_kinds)
+		)
+	`, organizationID, kind).Scan(&isMuted)
+	if err != nil {
+		return false, fmt.Errorf("checking muted notifications: %w", err)
+	}
+	return isMuted, nil
+}
+
+func insert(ctx context.Context, tx pgx.Tx, n Notification) error {
+	var id uuid.UUID
+	var createdAt time.Time
+	err := tx.QueryRow(ctx, `
+		INSERT INTO notifications (user_id, organization_id, kind, title, body, link)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		RETURNING id, created_at
+	`, n.UserID, n.OrganizationID, n.Kind, n.Title, n.Body, n.Link).Scan(&id, &createdAt)
+	if err != nil {
+		return fmt.Errorf("inserting notification: %w", err)
+	}
+
+	event := pubsub.NotificationEvent{UserID: n.UserID, NotificationID: id, OccurredAt: createdAt}
+	return outbox.Insert(ctx, tx, outbox.Event{
+		Topic:   pubsub.TopicNotifications(n.UserID),
+		Message: event.ToMessage(),
+	})
+}
+
+func truncate(s string, n int) string {
+	r := []rune(s)
+	if len(r) <= n {
+		return s
+	}
+	return string(r[:n]) + "…"
+}
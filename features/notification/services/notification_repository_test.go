@@ -0,0 +1,233 @@
+package services_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/cavenine/queryops/features/notification/services"
+	"github.com/cavenine/queryops/internal/pubsub"
+	"github.com/cavenine/queryops/internal/testdb"
+	"github.com/cavenine/queryops/internal/testdb/fixtures"
+)
+
+func TestNotificationRepository_FinishedCampaigns(t *testing.T) {
+	tdb := testdb.SetupTestDB(t)
+	ctx := context.Background()
+
+	org := fixtures.CreateOrg(t, tdb.Pool, "notify-org")
+	owner := fixtures.CreateUser(t, tdb.Pool, "owner@example.com")
+	member := fixtures.CreateUser(t, tdb.Pool, "member@example.com")
+	fixtures.AddMember(t, tdb.Pool, org.ID, owner.ID, "owner")
+	fixtures.AddMember(t, tdb.Pool, org.ID, member.ID, "member")
+
+	mine := fixtures.CreateCampaign(t, tdb.Pool, org.ID, "SELECT 1;")
+	anonymous := fixtures.CreateCampaign(t, tdb.Pool, org.ID, "SELECT 2;")
+	fixtures.CreateCampaign(t, tdb.Pool, org.ID, "SELECT 3;")
+
+	if _, err := tdb.Pool.Exec(ctx, `UPDATE campaigns SET created_by = $1 WHERE id = $2`, owner.ID, mine.ID); err != nil {
+		t.Fatalf("setting creator: %v", err)
+	}
+	if _, err := tdb.Pool.Exec(ctx, `UPDATE campaigns SET status = 'completed' WHERE id = ANY($1)`, []uuid.UUID{mine.ID, anonymous.ID}); err != nil {
+		t.Fatalf("completing campaigns: %v", err)
+	}
+
+	repo := services.NewNotificationRepository(tdb.Pool)
+
+	created, err := repo.NotifyFinishedCampaigns(ctx)
+	if err != nil {
+		t.Fatalf("NotifyFinishedCampaigns: %v", err)
+	}
+	// The owner's campaign notifies the owner; the anonymous one notifies both members.
+	if created != 3 {
+		t.Fatalf("created = %d, want 3", created)
+	}
+
+	created, err = repo.NotifyFinishedCampaigns(ctx)
+	if err != nil {
+		t.Fatalf("NotifyFinishedCampaigns again: %v", err)
+	}
+	if created != 0 {
+		t.Fatalf("second run created = %d, want 0", created)
+	}
+
+	unread, err := repo.CountUnread(ctx, owner.ID)
+	if err != nil {
+		t.Fatalf("CountUnread: %v", err)
+	}
+	if unread != 2 {
+		t.Fatalf("owner unread = %d, want 2", unread)
+	}
+
+	var events int
+	if err := tdb.Pool.QueryRow(ctx, `SELECT COUNT(*) FROM pubsub_outbox`).Scan(&events); err != nil {
+		t.Fatalf("counting outbox events: %v", err)
+	}
+	if events != 3 {
+		t.Fatalf("outbox events = %d, want 3", events)
+	}
+}
+
+func TestNotificationRepository_OfflineHosts(t *testing.T) {
+	tdb := testdb.SetupTestDB(t)
+	ctx := context.Background()
+
+	org := fixtures.CreateOrg(t, tdb.Pool, "offline-org")
+	user := fixtures.CreateUser(t, tdb.Pool, "user@example.com")
+	fixtures.AddMember(t, tdb.Pool, org.ID, user.ID, "owner")
+
+	stale := fixtures.CreateHost(t, tdb.Pool, org.ID, "stale")
+	fresh := fixtures.CreateHost(t, tdb.Pool, org.ID, "fresh")
+	fixtures.CreateHost(t, tdb.Pool, org.ID, "never")
+
+	now := time.Now()
+	setLastSeen := func(hostID uuid.UUID, at time.Time) {
+		t.Helper()
+		if _, err := tdb.Pool.Exec(ctx, `UPDATE hosts SET last_logger_at = $1 WHERE id = $2`, at, hostID); err != nil {
+			t.Fatalf("setting last_logger_at: %v", err)
+		}
+	}
+	setLastSeen(stale.ID, now.Add(-time.Hour))
+	setLastSeen(fresh.ID, now)
+
+	repo := services.NewNotificationRepository(tdb.Pool)
+	offlineBefore := now.Add(-15 * time.Minute)
+
+	created, err := repo.NotifyOfflineHosts(ctx, offlineBefore)
+	if err != nil {
+		t.Fatalf("NotifyOfflineHosts: %v", err)
+	}
+	if created != 1 {
+		t.Fatalf("created = %d, want 1", created)
+	}
+	var wentOffline int
+	if err := tdb.Pool.QueryRow(ctx, `
+		SELECT COUNT(*) FROM pubsub_outbox WHERE topic = $1 AND convert_from(payload, 'UTF8')::jsonb->>'type' = $2
+	`, pubsub.TopicHostActivity(stale.ID), pubsub.HostActivityWentOffline).Scan(&wentOffline); err != nil {
+		t.Fatalf("counting host activity: %v", err)
+	}
+	if wentOffline != 1 {
+		t.Fatalf("went offline events = %d, want 1", wentOffline)
+	}
+
+	if created, err = repo.NotifyOfflineHosts(ctx, offlineBefore); err != nil || created != 0 {
+		t.Fatalf("same outage: created = %d, err = %v; want 0, nil", created, err)
+	}
+
+	// The host comes back and drops off again: a new outage.
+	setLastSeen(stale.ID, now.Add(-30*time.Minute))
+	if created, err = repo.NotifyOfflineHosts(ctx, offlineBefore); err != nil || created != 1 {
+		t.Fatalf("new outage: created = %d, err = %v; want 1, nil", created, err)
+	}
+
+	list, err := repo.ListForUser(ctx, user.ID, 10)
+	if err != nil {
+		t.Fatalf("ListForUser: %v", err)
+	}
+	if len(list) != 2 {
+		t.Fatalf("listed %d notifications, want 2", len(list))
+	}
+	if list[0].Kind != services.KindHostOffline || list[0].Link == nil || *list[0].Link != "/hosts/"+stale.ID.String() {
+		t.Fatalf("notification = %+v", list[0])
+	}
+}
+
+func TestNotificationRepository_MutedKinds(t *testing.T) {
+	tdb := testdb.SetupTestDB(t)
+	ctx := context.Background()
+
+	org := fixtures.CreateOrg(t, tdb.Pool, "muted-org")
+	owner := fixtures.CreateUser(t, tdb.Pool, "owner@example.com")
+	fixtures.AddMember(t, tdb.Pool, org.ID, owner.ID, "owner")
+	if _, err := tdb.Pool.Exec(ctx, `
+		INSERT INTO organization_settings (organization_id, muted_notification_kinds) VALUES ($1, $2)
+	`, org.ID, []string{services.KindCampaignFinished}); err != nil {
+		t.Fatalf("muting notifications: %v", err)
+	}
+
+	mine := fixtures.CreateCampaign(t, tdb.Pool, org.ID, "SELECT 1;")
+	fixtures.CreateCampaign(t, tdb.Pool, org.ID, "SELECT 2;")
+	if _, err := tdb.Pool.Exec(ctx, `UPDATE campaigns SET created_by = $1 WHERE id = $2`, owner.ID, mine.ID); err != nil {
+		t.Fatalf("setting creator: %v", err)
+	}
+	if _, err := tdb.Pool.Exec(ctx, `UPDATE campaigns SET status = 'completed' WHERE organization_id = $1`, org.ID); err != nil {
+		t.Fatalf("completing campaigns: %v", err)
+	}
+
+	repo := services.NewNotificationRepository(tdb.Pool)
+	created, err := repo.NotifyFinishedCampaigns(ctx)
+	if err != nil {
+		t.Fatalf("NotifyFinishedCampaigns: %v", err)
+	}
+	if created != 0 {
+		t.Fatalf("muted campaign notifications created = %d, want 0", created)
+	}
+
+	created, err = repo.NotifyOrganization(ctx, org.ID, services.Notification{
+		Kind:  services.KindWebhookFailed,
+		Title: "Digest webhook failed",
+	})
+	if err != nil {
+		t.Fatalf("NotifyOrganization: %v", err)
+	}
+	if created != 1 {
+		t.Fatalf("unmuted notifications created = %d, want 1", created)
+	}
+}
+
+func TestNotificationRepository_MarkRead(t *testing.T) {
+	tdb := testdb.SetupTestDB(t)
+	ctx := context.Background()
+
+	org := fixtures.CreateOrg(t, tdb.Pool, "read-org")
+	alice := fixtures.CreateUser(t, tdb.Pool, "alice@example.com")
+	bob := fixtures.CreateUser(t, tdb.Pool, "bob@example.com")
+	fixtures.AddMember(t, tdb.Pool, org.ID, alice.ID, "owner")
+	fixtures.AddMember(t, tdb.Pool, org.ID, bob.ID, "member")
+
+	repo := services.NewNotificationRepository(tdb.Pool)
+	for range 2 {
+		if _, err := repo.NotifyOrganization(ctx, org.ID, services.Notification{
+			Kind:  services.KindWebhookFailed,
+			Title: "Digest webhook failed",
+		}); err != nil {
+			t.Fatalf("NotifyOrganization: %v", err)
+		}
+	}
+
+	list, err := repo.ListForUser(ctx, alice.ID, 10)
+	if err != nil {
+		t.Fatalf("ListForUser: %v", err)
+	}
+	if len(list) != 2 {
+		t.Fatalf("listed %d notifications, want 2", len(list))
+	}
+
+	if _, err := repo.MarkRead(ctx, bob.ID, list[0].ID); !errors.Is(err, services.ErrNotificationNotFound) {
+		t.Fatalf("MarkRead by another user: err = %v, want ErrNotificationNotFound", err)
+	}
+
+	n, err := repo.MarkRead(ctx, alice.ID, list[0].ID)
+	if err != nil {
+		t.Fatalf("MarkRead: %v", err)
+	}
+	if n.ReadAt == nil {
+		t.Fatalf("ReadAt not set")
+	}
+	if unread, _ := repo.CountUnread(ctx, alice.ID); unread != 1 {
+		t.Fatalf("alice unread = %d, want 1", unread)
+	}
+
+	if err := repo.MarkAllRead(ctx, alice.ID); err != nil {
+		t.Fatalf("MarkAllRead: %v", err)
+	}
+	if unread, _ := repo.CountUnread(ctx, alice.ID); unread != 0 {
+		t.Fatalf("alice unread = %d after MarkAllRead, want 0", unread)
+	}
+	if unread, _ := repo.CountUnread(ctx, bob.ID); unread != 2 {
+		t.Fatalf("bob unread = %d, want 2", unread)
+	}
+}
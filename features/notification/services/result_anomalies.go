@@ -0,0 +1,318 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+
+	orgServices "github.com/cavenine/queryops/features/organization/services"
+)
+
+// Result anomaly kinds.
+const (
+	AnomalyRowCount = "row_count"
+	AnomalyNewValue = "new_value"
+)
+
+// maxNewValuesPerRule caps the new values one rule flags in an hour, so a
+// column that turns out to change constantly doesn't flood the table.
+const maxNewValuesPerRule = 100
+
+// ResultAnomaly is a host's results for a scheduled query straying from its
+// baseline during one hour.
+type ResultAnomaly struct {
+	RuleID         int64     `json:"rule_id"`
+	OrganizationID uuid.UUID `json:"organization_id"`
+	HostID         uuid.UUID `json:"host_id"`
+	HostIdentifier string    `json:"host_identifier"`
+	QueryName      string    `json:"query_name"`
+	Kind           string    `json:"kind"`
+	// Column and Value are set for AnomalyNewValue; Observed, Expected, and
+	// StdDev for AnomalyRowCount.
+	Column   string    `json:"column,omitempty"`
+	Value    string    `json:"value,omitempty"`
+	Observed float64   `json:"observed,omitempty"`
+	Expected float64   `json:"expected,omitempty"`
+	StdDev   float64   `json:"stddev,omitempty"`
+	Hour     time.Time `json:"hour"`
+}
+
+type resultAnomalyKey struct {
+	ruleID int64
+	hostID uuid.UUID
+}
+
+// NotifyResultAnomalies compares the results logged in the hour starting at
+// hour with each host's results over the preceding window, for every
+// organization's baseline rules. A host is only compared once it has reported
+// the query in at least minSamples hours of the window. Anomalies are
+// recorded, members of the organization are notified once per rule and host,
+// and the new anomalies are returned. Evaluating the same hour again finds
+// the same anomalies and returns none.
+func (r *NotificationRepository) NotifyResultAnomalies(ctx context.Context, hour time.Time, window time.Duration, minSamples int) ([]ResultAnomaly, error) {
+	tx, err := r.pool.Begin(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("notifying result anomalies: begin transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	rules, err := loadResultBaselineRules(ctx, tx)
+	if err != nil {
+		return nil, fmt.Errorf("notifying result anomalies: %w", err)
+	}
+
+	var found []ResultAnomaly
+	for _, rule := range rules {
+		anomalies, err := findResultAnomalies(ctx, tx, rule, hour, window, minSamples)
+		if err != nil {
+			return nil, fmt.Errorf("notifying result anomalies: rule %d: %w", rule.ID, err)
+		}
+		found = append(found, anomalies...)
+	}
+
+	var (
+		recorded []ResultAnomaly
+		byHost   = make(map[resultAnomalyKey][]ResultAnomaly)
+		order    []resultAnomalyKey
+	)
+	for _, a := range found {
+		var id int64
+		err := tx.QueryRow(ctx, `
+			INSERT INTO result_anomalies (rule_id, organization_id, host_id, query_name, kind, column_name, value, observed, expected, stddev, hour)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)
+			ON CONFLICT (rule_id, host_id, hour, kind, column_name, value) DO NOTHING
+			RETURNING id
+		`, a.RuleID, a.OrganizationID, a.HostID, a.QueryName, a.Kind, a.Column, a.Value, a.Observed, a.Expected, a.StdDev, a.Hour).Scan(&id)
+		if errors.Is(err, pgx.ErrNoRows) {
+			continue // already flagged
+		}
+		if err != nil {
+			return nil, fmt.Errorf("notifying result anomalies: recording anomaly: %w", err)
+		}
+		recorded = append(recorded, a)
+
+		key := resultAnomalyKey{ruleID: a.RuleID, hostID: a.HostID}
+		if _, ok := byHost[key]; !ok {
+			order = append(order, key)
+		}
+		byHost[key] = append(byHost[key], a)
+	}
+
+	for _, key := range order {
+		anomalies := byHost[key]
+		a := anomalies[0]
+		link := fmt.Sprintf("/hosts/%s?tab=scheduled&query=%s", a.HostID, url.QueryEscape(a.QueryName))
+		if _, err := insertForOrganization(ctx, tx, Notification{
+			OrganizationID: &a.OrganizationID,
+			Kind:           KindResultAnomaly,
+			Title:          fmt.Sprintf("Unusual %s results on %s", truncate(a.QueryName, 60), a.HostIdentifier),
+			Body:           resultAnomalyBody(anomalies),
+			Link:           &link,
+		}); err != nil {
+			return nil, fmt.Errorf("notifying result anomalies: %w", err)
+		}
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return nil, fmt.Errorf("notifying result anomalies: commit transaction: %w", err)
+	}
+	return recorded, nil
+}
+
+func loadResultBaselineRules(ctx context.Context, tx pgx.Tx) ([]orgServices.ResultBaselineRule, error) {
+	rows, err := tx.Query(ctx, `
+		SELECT id, organization_id, query_name, columns, threshold, description
+		FROM result_baseline_rules
+		ORDER BY id
+	`)
+	if err != nil {
+		return nil, fmt.Errorf("listing result baseline rules: %w", err)
+	}
+	defer rows.Close()
+
+	var rules []orgServices.ResultBaselineRule
+	for rows.Next() {
+		var rule orgServices.ResultBaselineRule
+		if err := rows.Scan(&rule.ID, &rule.OrganizationID, &rule.QueryName, &rule.Columns, &rule.Threshold, &rule.Description); err != nil {
+			return nil, fmt.Errorf("scanning result baseline rule: %w", err)
+		}
+		rules = append(rules, rule)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("listing result baseline rules: %w", err)
+	}
+	return rules, nil
+}
+
+// findResultAnomalies compares each host's row count for rule's query in the
+// hour starting at hour with its hourly counts over the preceding window, and
+// looks for values of the rule's columns the host didn't report in the
+// window. Hosts with fewer than minSamples hours of history are skipped.
+func findResultAnomalies(ctx context.Context, tx pgx.Tx, rule orgServices.ResultBaselineRule, hour time.Time, window time.Duration, minSamples int) ([]ResultAnomaly, error) {
+	since, until := hour.Add(-window), hour.Add(time.Hour)
+	rows, err := tx.Query(ctx, `
+		SELECT r.host_id, h.host_identifier, r.timestamp >= $4 AS current, COUNT(*)
+		FROM osquery_results r
+		JOIN hosts h ON h.id = r.host_id
+		WHERE h.organization_id = $1 AND r.name = $2
+			AND r.timestamp >= $3 AND r.timestamp < $5
+		GROUP BY r.host_id, h.host_identifier, date_trunc('hour', r.timestamp, 'UTC'), 3
+		ORDER BY r.host_id
+	`, rule.OrganizationID, rule.QueryName, since, hour, until)
+	if err != nil {
+		return nil, fmt.Errorf("counting results: %w", err)
+	}
+	defer rows.Close()
+
+	type hostCounts struct {
+		identifier string
+		baseline   []float64
+		observed   float64
+	}
+	var (
+		hosts   = make(map[uuid.UUID]*hostCounts)
+		hostIDs []uuid.UUID
+	)
+	for rows.Next() {
+		var (
+			hostID     uuid.UUID
+			identifier string
+			current    bool
+			count      int
+		)
+		if err := rows.Scan(&hostID, &identifier, &current, &count); err != nil {
+			return nil, fmt.Errorf("scanning result count: %w", err)
+		}
+		hc, ok := hosts[hostID]
+		if !ok {
+			hc = &hostCounts{identifier: identifier}
+			hosts[hostID] = hc
+			hostIDs = append(hostIDs, hostID)
+		}
+		if current {
+			hc.observed = float64(count)
+		} else {
+			hc.baseline = append(hc.baseline, float64(count))
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("counting results: %w", err)
+	}
+
+	var (
+		anomalies []ResultAnomaly
+		eligible  []uuid.UUID
+	)
+	for _, hostID := range hostIDs {
+		hc := hosts[hostID]
+		// A host that logged nothing this hour is offline or between runs,
+		// which host offline alerts already cover.
+		if len(hc.baseline) < minSamples || hc.observed == 0 {
+			continue
+		}
+		eligible = append(eligible, hostID)
+		b := orgServices.NewBaseline(hc.baseline)
+		if b.Deviation(hc.observed) < rule.Threshold {
+			continue
+		}
+		anomalies = append(anomalies, ResultAnomaly{
+			RuleID:         rule.ID,
+			OrganizationID: rule.OrganizationID,
+			HostID:         hostID,
+			HostIdentifier: hc.identifier,
+			QueryName:      rule.QueryName,
+			Kind:           AnomalyRowCount,
+			Observed:       hc.observed,
+			Expected:       b.Mean,
+			StdDev:         b.StdDev,
+			Hour:           hour,
+		})
+	}
+	if len(rule.Columns) == 0 || len(eligible) == 0 {
+		return anomalies, nil
+	}
+
+	rows, err = tx.Query(ctx, `
+		SELECT r.host_id, c.name, r.columns->>c.name
+		FROM osquery_results r
+		CROSS JOIN unnest($3::text[]) AS c(name)
+		WHERE r.host_id = ANY($1) AND r.name = $2
+			AND r.timestamp >= $5 AND r.timestamp < $6
+			AND r.action IN ('added', 'snapshot')
+			AND r.columns ? c.name
+			AND NOT EXISTS (
+				SELECT 1
+				FROM osquery_results b
+				WHERE b.host_id = r.host_id AND b.name = $2
+					AND b.timestamp >= $4 AND b.timestamp < $5
+					AND b.columns->>c.name = r.columns->>c.name
+			)
+		GROUP BY r.host_id, c.name, r.columns->>c.name
+		ORDER BY r.host_id, c.name, r.columns->>c.name
+		LIMIT $7
+	`, eligible, rule.QueryName, rule.Columns, since, hour, until, maxNewValuesPerRule)
+	if err != nil {
+		return nil, fmt.Errorf("finding new values: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var (
+			hostID uuid.UUID
+			column string
+			value  *string
+		)
+		if err := rows.Scan(&hostID, &column, &value); err != nil {
+			return nil, fmt.Errorf("scanning new value: %w", err)
+		}
+		if value == nil {
+			continue
+		}
+		anomalies = append(anomalies, ResultAnomaly{
+			RuleID:         rule.ID,
+			OrganizationID: rule.OrganizationID,
+			HostID:         hostID,
+			HostIdentifier: hosts[hostID].identifier,
+			QueryName:      rule.QueryName,
+			Kind:           AnomalyNewValue,
+			Column:         column,
+			Value:          *value,
+			Hour:           hour,
+		})
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("finding new values: %w", err)
+	}
+	return anomalies, nil
+}
+
+// resultAnomalyBody describes one host's anomalies for a rule, e.g.
+// "412 rows, usually about 120; new port: 4444, 31337".
+func resultAnomalyBody(anomalies []ResultAnomaly) string {
+	var (
+		parts   []string
+		columns []string
+		values  = make(map[string][]string)
+	)
+	for _, a := range anomalies {
+		switch a.Kind {
+		case AnomalyRowCount:
+			parts = append(parts, fmt.Sprintf("%.0f rows, usually about %.0f", a.Observed, a.Expected))
+		case AnomalyNewValue:
+			if _, ok := values[a.Column]; !ok {
+				columns = append(columns, a.Column)
+			}
+			values[a.Column] = append(values[a.Column], a.Value)
+		}
+	}
+	for _, column := range columns {
+		parts = append(parts, fmt.Sprintf("new %s: %s", column, strings.Join(values[column], ", ")))
+	}
+	return truncate(strings.Join(parts, "; "), 300)
+}
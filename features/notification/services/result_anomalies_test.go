@@ -0,0 +1,94 @@
+package services_test
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/cavenine/queryops/features/notification/services"
+	orgservices "github.com/cavenine/queryops/features/organization/services"
+	"github.com/cavenine/queryops/internal/testdb"
+	"github.com/cavenine/queryops/internal/testdb/fixtures"
+)
+
+func TestNotificationRepository_ResultAnomalies(t *testing.T) {
+	tdb := testdb.SetupTestDB(t)
+	ctx := context.Background()
+
+	org := fixtures.CreateOrg(t, tdb.Pool, "anomaly-org")
+	owner := fixtures.CreateUser(t, tdb.Pool, "owner@example.com")
+	fixtures.AddMember(t, tdb.Pool, org.ID, owner.ID, "owner")
+	host := fixtures.CreateHost(t, tdb.Pool, org.ID, "web-1")
+	newHost := fixtures.CreateHost(t, tdb.Pool, org.ID, "web-2")
+
+	if _, err := orgservices.NewResultBaselineRuleRepository(tdb.Pool).Add(ctx, org.ID, "listening_ports", "port", 0, ""); err != nil {
+		t.Fatalf("adding rule: %v", err)
+	}
+
+	logResults := func(hostID uuid.UUID, at time.Time, ports ...string) {
+		t.Helper()
+		for _, port := range ports {
+			if _, err := tdb.Pool.Exec(ctx, `
+				INSERT INTO osquery_results (host_id, name, action, columns, timestamp)
+				VALUES ($1, 'listening_ports', 'snapshot', $2, $3)
+			`, hostID, map[string]string{"port": port}, at); err != nil {
+				t.Fatalf("logging result: %v", err)
+			}
+		}
+	}
+
+	hour := time.Now().UTC().Truncate(time.Hour).Add(-time.Hour)
+	for h := 1; h <= 30; h++ {
+		logResults(host.ID, hour.Add(-time.Duration(h)*time.Hour+10*time.Minute), "22", "443")
+	}
+	// Far more rows than usual, one on a port never seen before.
+	current := []string{"4444"}
+	for range 19 {
+		current = append(current, "22")
+	}
+	logResults(host.ID, hour.Add(10*time.Minute), current...)
+	// No history yet: not compared.
+	logResults(newHost.ID, hour.Add(10*time.Minute), "9999")
+
+	repo := services.NewNotificationRepository(tdb.Pool)
+	anomalies, err := repo.NotifyResultAnomalies(ctx, hour, 7*24*time.Hour, 24)
+	if err != nil {
+		t.Fatalf("NotifyResultAnomalies: %v", err)
+	}
+	if len(anomalies) != 2 {
+		t.Fatalf("anomalies = %+v, want a row count and a new value", anomalies)
+	}
+	for _, a := range anomalies {
+		if a.HostID != host.ID {
+			t.Fatalf("anomaly for host without history: %+v", a)
+		}
+		switch a.Kind {
+		case services.AnomalyRowCount:
+			if a.Observed != 20 || a.Expected != 2 {
+				t.Fatalf("row count anomaly = %+v, want 20 observed, 2 expected", a)
+			}
+		case services.AnomalyNewValue:
+			if a.Column != "port" || a.Value != "4444" {
+				t.Fatalf("new value anomaly = %+v, want port 4444", a)
+			}
+		}
+	}
+
+	if again, err := repo.NotifyResultAnomalies(ctx, hour, 7*24*time.Hour, 24); err != nil || len(again) != 0 {
+		t.Fatalf("same hour again: %d anomalies, err = %v; want 0, nil", len(again), err)
+	}
+
+	list, err := repo.ListForUser(ctx, owner.ID, 10)
+	if err != nil {
+		t.Fatalf("ListForUser: %v", err)
+	}
+	if len(list) != 1 || list[0].Kind != services.KindResultAnomaly {
+		t.Fatalf("notifications = %+v, want one result anomaly", list)
+	}
+	if !strings.Contains(list[0].Body, "20 rows, usually about 2") || !strings.Contains(list[0].Body, "new port: 4444") {
+		t.Fatalf("body = %q", list[0].Body)
+	}
+}
@@ -0,0 +1,89 @@
+package services
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+
+	orgServices "github.com/cavenine/queryops/features/organization/services"
+)
+
+// queueSlackMessages queues n for each of its organization's Slack routes for
+// n.Kind. Routes are independent of muting, which only covers in-app
+// notifications.
+func queueSlackMessages(ctx context.Context, tx pgx.Tx, n Notification) error {
+	_, err := tx.Exec(ctx, `
+		INSERT INTO slack_messages (route_id, organization_id, title, body, link)
+		SELECT id, organization_id, $3, $4, $5
+		FROM slack_routes
+		WHERE organization_id = $1 AND event = $2
+	`, n.OrganizationID, n.Kind, n.Title, n.Body, n.Link)
+	if err != nil {
+		return fmt.Errorf("queueing slack messages: %w", err)
+	}
+	return nil
+}
+
+// NotifyEnrolledHosts queues Slack messages about hosts that enrolled since
+// the last call and returns how many hosts there were. Enrollments aren't
+// in-app notifications; they're only posted to organizations that route
+// them to Slack.
+func (r *NotificationRepository) NotifyEnrolledHosts(ctx context.Context) (int, error) {
+	tx, err := r.pool.Begin(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("notifying enrolled hosts: begin transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	rows, err := tx.Query(ctx, `
+		UPDATE hosts
+		SET enroll_announced_at = NOW()
+		WHERE enroll_announced_at IS NULL AND organization_id IS NOT NULL
+		RETURNING id, organization_id, host_identifier, COALESCE(os_version->>'name', '')
+	`)
+	if err != nil {
+		return 0, fmt.Errorf("notifying enrolled hosts: %w", err)
+	}
+
+	type enrolled struct {
+		id, organizationID uuid.UUID
+		identifier, osName string
+	}
+	var hosts []enrolled
+	for rows.Next() {
+		var h enrolled
+		if err := rows.Scan(&h.id, &h.organizationID, &h.identifier, &h.osName); err != nil {
+			rows.Close()
+			return 0, fmt.Errorf("notifying enrolled hosts: scanning host: %w", err)
+		}
+		hosts = append(hosts, h)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, fmt.Errorf("notifying enrolled hosts: %w", err)
+	}
+
+	for _, h := range hosts {
+		link := "/hosts/" + h.id.String()
+		body := h.identifier + " enrolled"
+		if h.osName != "" {
+			body += " (" + h.osName + ")"
+		}
+		if err := queueSlackMessages(ctx, tx, Notification{
+			OrganizationID: &h.organizationID,
+			Kind:           orgServices.SlackEventHostEnrolled,
+			Title:          "Host enrolled",
+			Body:           body,
+			Link:           &link,
+		}); err != nil {
+			return 0, fmt.Errorf("notifying enrolled hosts: %w", err)
+		}
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return 0, fmt.Errorf("notifying enrolled hosts: commit transaction: %w", err)
+	}
+	return len(hosts), nil
+}
@@ -0,0 +1,91 @@
+package services_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/cavenine/queryops/features/notification/services"
+	"github.com/cavenine/queryops/internal/testdb"
+	"github.com/cavenine/queryops/internal/testdb/fixtures"
+)
+
+func TestNotificationRepository_SlackRoutes(t *testing.T) {
+	tdb := testdb.SetupTestDB(t)
+	ctx := context.Background()
+
+	org := fixtures.CreateOrg(t, tdb.Pool, "slack-org")
+	owner := fixtures.CreateUser(t, tdb.Pool, "owner@example.com")
+	fixtures.AddMember(t, tdb.Pool, org.ID, owner.ID, "owner")
+	// Muting in-app notifications doesn't stop routed Slack messages.
+	if _, err := tdb.Pool.Exec(ctx, `
+		INSERT INTO organization_settings (organization_id, muted_notification_kinds) VALUES ($1, $2)
+	`, org.ID, []string{services.KindHostOffline}); err != nil {
+		t.Fatalf("muting notifications: %v", err)
+	}
+	for _, event := range []string{"host_offline", "host_enrolled"} {
+		if _, err := tdb.Pool.Exec(ctx, `
+			INSERT INTO slack_routes (organization_id, event) VALUES ($1, $2)
+		`, org.ID, event); err != nil {
+			t.Fatalf("adding route: %v", err)
+		}
+	}
+
+	repo := services.NewNotificationRepository(tdb.Pool)
+	host := fixtures.CreateHost(t, tdb.Pool, org.ID, "web-1")
+	if enrolled, err := repo.NotifyEnrolledHosts(ctx); err != nil || enrolled != 1 {
+		t.Fatalf("NotifyEnrolledHosts = %d, %v; want 1", enrolled, err)
+	}
+	if enrolled, err := repo.NotifyEnrolledHosts(ctx); err != nil || enrolled != 0 {
+		t.Fatalf("NotifyEnrolledHosts again = %d, %v; want 0", enrolled, err)
+	}
+
+	if _, err := tdb.Pool.Exec(ctx, `
+		UPDATE hosts SET last_logger_at = $2 WHERE id = $1
+	`, host.ID, time.Now().Add(-time.Hour)); err != nil {
+		t.Fatalf("setting last seen: %v", err)
+	}
+	if created, err := repo.NotifyOfflineHosts(ctx, time.Now().Add(-15*time.Minute)); err != nil || created != 0 {
+		t.Fatalf("NotifyOfflineHosts = %d, %v; want 0 in-app notifications", created, err)
+	}
+
+	rows, err := tdb.Pool.Query(ctx, `
+		SELECT m.title, m.link, r.event
+		FROM slack_messages m
+		JOIN slack_routes r ON r.id = m.route_id
+		WHERE m.organization_id = $1
+		ORDER BY m.id
+	`, org.ID)
+	if err != nil {
+		t.Fatalf("listing queued messages: %v", err)
+	}
+	defer rows.Close()
+	var events []string
+	for rows.Next() {
+		var (
+			title, event string
+			link         *string
+		)
+		if err := rows.Scan(&title, &link, &event); err != nil {
+			t.Fatalf("scanning queued message: %v", err)
+		}
+		if link == nil || *link != "/hosts/"+host.ID.String() {
+			t.Fatalf("%s message link = %v", event, link)
+		}
+		events = append(events, event)
+	}
+	if len(events) != 2 || events[0] != "host_enrolled" || events[1] != "host_offline" {
+		t.Fatalf("queued events = %v, want host_enrolled then host_offline", events)
+	}
+
+	// Organizations without routes queue nothing.
+	other := fixtures.CreateOrg(t, tdb.Pool, "other-org")
+	fixtures.CreateHost(t, tdb.Pool, other.ID, "db-1")
+	if _, err := repo.NotifyEnrolledHosts(ctx); err != nil {
+		t.Fatalf("NotifyEnrolledHosts: %v", err)
+	}
+	var queued int
+	if err := tdb.Pool.QueryRow(ctx, `SELECT COUNT(*) FROM slack_messages WHERE organization_id = $1`, other.ID).Scan(&queued); err != nil || queued != 0 {
+		t.Fatalf("other organization queued %d messages, err = %v", queued, err)
+	}
+}
@@ -0,0 +1,265 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+
+	orgServices "github.com/cavenine/queryops/features/organization/services"
+)
+
+// StatusAlert is a host's status log messages matching one alert rule during
+// a sweep. It is also the payload posted to the rule's webhook.
+type StatusAlert struct {
+	Event          string    `json:"event"`
+	RuleID         int64     `json:"rule_id"`
+	Rule           string    `json:"rule"`
+	OrganizationID uuid.UUID `json:"organization_id"`
+	HostID         uuid.UUID `json:"host_id"`
+	HostIdentifier string    `json:"host_identifier"`
+	// Severity and Message are from the first matching line; Count is how
+	// many lines matched.
+	Severity string    `json:"severity"`
+	Message  string    `json:"message"`
+	Count    int       `json:"count"`
+	LoggedAt time.Time `json:"logged_at"`
+
+	WebhookURL *string `json:"-"`
+}
+
+type statusAlertRule struct {
+	orgServices.StatusAlertRule
+	matcher *orgServices.StatusAlertMatcher
+}
+
+type statusAlertKey struct {
+	ruleID int64
+	hostID uuid.UUID
+}
+
+// NotifyStatusAlerts evaluates the status logs saved since the last call
+// against every organization's alert rules, reading at most limit matching
+// lines. Each rule alerts about a host at most once per cooldown: members of
+// the organization are notified, and the alerts are returned so the caller
+// can post those with a WebhookURL.
+//
+// Logs are read in id order up to the newest id at the start of the call, so
+// a line committed out of order while a sweep runs can be missed.
+func (r *NotificationRepository) NotifyStatusAlerts(ctx context.Context, cooldown time.Duration, limit int) ([]StatusAlert, error) {
+	tx, err := r.pool.Begin(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("notifying status alerts: begin transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	var lastLogID int64
+	err = tx.QueryRow(ctx, `SELECT last_log_id FROM status_alert_cursor FOR UPDATE`).Scan(&lastLogID)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("notifying status alerts: reading cursor: %w", err)
+	}
+	var maxLogID int64
+	if err := tx.QueryRow(ctx, `SELECT COALESCE(MAX(id), 0) FROM osquery_status_logs`).Scan(&maxLogID); err != nil {
+		return nil, fmt.Errorf("notifying status alerts: %w", err)
+	}
+	if maxLogID <= lastLogID {
+		return nil, nil
+	}
+
+	rules, minSeverity, err := loadStatusAlertRules(ctx, tx)
+	if err != nil {
+		return nil, fmt.Errorf("notifying status alerts: %w", err)
+	}
+
+	var alerts []StatusAlert
+	nextLogID := maxLogID
+	if len(rules) > 0 {
+		alerts, nextLogID, err = matchStatusLogs(ctx, tx, rules, minSeverity, lastLogID, maxLogID, limit)
+		if err != nil {
+			return nil, fmt.Errorf("notifying status alerts: %w", err)
+		}
+	}
+
+	var sent []StatusAlert
+	for _, a := range alerts {
+		var alertedAt time.Time
+		err := tx.QueryRow(ctx, `
+			INSERT INTO status_alert_hosts (rule_id, host_id, alerted_at)
+			VALUES ($1, $2, NOW())
+			ON CONFLICT (rule_id, host_id) DO UPDATE SET alerted_at = NOW()
+			WHERE status_alert_hosts.alerted_at <= NOW() - make_interval(secs => $3)
+			RETURNING alerted_at
+		`, a.RuleID, a.HostID, cooldown.Seconds()).Scan(&alertedAt)
+		if errors.Is(err, pgx.ErrNoRows) {
+			continue // still cooling down
+		}
+		if err != nil {
+			return nil, fmt.Errorf("notifying status alerts: recording alert: %w", err)
+		}
+
+		link := "/hosts/" + a.HostID.String()
+		if _, err := insertForOrganization(ctx, tx, Notification{
+			OrganizationID: &a.OrganizationID,
+			Kind:           KindStatusAlert,
+			Title:          fmt.Sprintf("osquery %s on %s", a.Severity, a.HostIdentifier),
+			Body:           statusAlertBody(a),
+			Link:           &link,
+		}); err != nil {
+			return nil, fmt.Errorf("notifying status alerts: %w", err)
+		}
+		sent = append(sent, a)
+	}
+
+	if _, err := tx.Exec(ctx, `UPDATE status_alert_cursor SET last_log_id = $1`, nextLogID); err != nil {
+		return nil, fmt.Errorf("notifying status alerts: advancing cursor: %w", err)
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return nil, fmt.Errorf("notifying status alerts: commit transaction: %w", err)
+	}
+	return sent, nil
+}
+
+// loadStatusAlertRules returns every organization's rules by organization,
+// and the lowest severity any of them alerts on. Rules that no longer compile
+// are skipped.
+func loadStatusAlertRules(ctx context.Context, tx pgx.Tx) (map[uuid.UUID][]statusAlertRule, int, error) {
+	rows, err := tx.Query(ctx, `
+		SELECT id, organization_id, min_severity, pattern, webhook_url, description
+		FROM status_alert_rules
+		ORDER BY id
+	`)
+	if err != nil {
+		return nil, 0, fmt.Errorf("listing status alert rules: %w", err)
+	}
+	defer rows.Close()
+
+	rules := make(map[uuid.UUID][]statusAlertRule)
+	minSeverity := orgServices.SeverityFatal
+	for rows.Next() {
+		var rule statusAlertRule
+		if err := rows.Scan(&rule.ID, &rule.OrganizationID, &rule.MinSeverity, &rule.Pattern, &rule.WebhookURL, &rule.Description); err != nil {
+			return nil, 0, fmt.Errorf("scanning status alert rule: %w", err)
+		}
+		rule.matcher, err = orgServices.NewStatusAlertMatcher(rule.StatusAlertRule)
+		if err != nil {
+			slog.WarnContext(ctx, "skipping invalid status alert rule", "error", err, "rule_id", rule.ID)
+			continue
+		}
+		rules[rule.OrganizationID] = append(rules[rule.OrganizationID], rule)
+		minSeverity = min(minSeverity, rule.MinSeverity)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, 0, fmt.Errorf("listing status alert rules: %w", err)
+	}
+	return rules, minSeverity, nil
+}
+
+// matchStatusLogs reads up to limit lines after afterID, through maxID, that
+// are severe enough for some rule, and groups those matching a rule by rule
+// and host. It returns the id the next sweep should start after.
+func matchStatusLogs(ctx context.Context, tx pgx.Tx, rules map[uuid.UUID][]statusAlertRule, minSeverity int, afterID, maxID int64, limit int) ([]StatusAlert, int64, error) {
+	orgIDs := make([]uuid.UUID, 0, len(rules))
+	for id := range rules {
+		orgIDs = append(orgIDs, id)
+	}
+
+	rows, err := tx.Query(ctx, `
+		SELECT l.id, l.host_id, h.organization_id, h.host_identifier, l.severity, l.message, l.created_at
+		FROM osquery_status_logs l
+		JOIN hosts h ON h.id = l.host_id
+		WHERE l.id > $1 AND l.id <= $2
+			AND l.severity >= $3
+			AND h.organization_id = ANY($4)
+		ORDER BY l.id
+		LIMIT $5
+	`, afterID, maxID, minSeverity, orgIDs, limit)
+	if err != nil {
+		return nil, 0, fmt.Errorf("reading status logs: %w", err)
+	}
+	defer rows.Close()
+
+	var (
+		alerts []StatusAlert
+		index  = make(map[statusAlertKey]int)
+		read   int
+		lastID int64
+	)
+	for rows.Next() {
+		var (
+			hostID, orgID uuid.UUID
+			identifier    string
+			severity      *int
+			message       *string
+			loggedAt      *time.Time
+		)
+		if err := rows.Scan(&lastID, &hostID, &orgID, &identifier, &severity, &message, &loggedAt); err != nil {
+			return nil, 0, fmt.Errorf("scanning status log: %w", err)
+		}
+		read++
+		if severity == nil || message == nil {
+			continue
+		}
+
+		for _, rule := range rules[orgID] {
+			if !rule.matcher.Match(*severity, *message) {
+				continue
+			}
+			key := statusAlertKey{ruleID: rule.ID, hostID: hostID}
+			if i, ok := index[key]; ok {
+				alerts[i].Count++
+				continue
+			}
+			a := StatusAlert{
+				Event:          "status_alert",
+				RuleID:         rule.ID,
+				Rule:           statusAlertRuleName(rule.StatusAlertRule),
+				OrganizationID: orgID,
+				HostID:         hostID,
+				HostIdentifier: identifier,
+				Severity:       orgServices.SeverityName(*severity),
+				Message:        *message,
+				Count:          1,
+				WebhookURL:     rule.WebhookURL,
+			}
+			if loggedAt != nil {
+				a.LoggedAt = *loggedAt
+			}
+			index[key] = len(alerts)
+			alerts = append(alerts, a)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, 0, fmt.Errorf("reading status logs: %w", err)
+	}
+
+	// A full page may have stopped short of maxID; resume after it.
+	if read == limit {
+		return alerts, lastID, nil
+	}
+	return alerts, maxID, nil
+}
+
+func statusAlertRuleName(rule orgServices.StatusAlertRule) string {
+	if rule.Description != "" {
+		return rule.Description
+	}
+	if rule.Pattern != "" {
+		return rule.Pattern
+	}
+	return orgServices.SeverityName(rule.MinSeverity) + " or worse"
+}
+
+func statusAlertBody(a StatusAlert) string {
+	body := truncate(a.Message, 200)
+	if a.Count > 1 {
+		body = fmt.Sprintf("%s (%d matching messages)", body, a.Count)
+	}
+	return fmt.Sprintf("%s: %s", a.Rule, body)
+}
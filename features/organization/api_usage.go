@@ -0,0 +1,179 @@
+package organization
+
+import (
+	"context"
+	"expvar"
+	"io"
+	"log/slog"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/google/uuid"
+
+	"github.com/cavenine/queryops/features/auth"
+	"github.com/cavenine/queryops/features/organization/services"
+)
+
+const (
+	// apiUsageFlushInterval is how often metered API usage is written out.
+	apiUsageFlushInterval = time.Minute
+	apiUsageWriteTimeout  = 30 * time.Second
+	// apiUsageWindow is the period the settings page reports API usage for.
+	apiUsageWindow = 30 * 24 * time.Hour
+)
+
+// apiUsageMetrics exposes metering counters under /debug/vars:
+//
+//	flushed  samples written
+//	failed   failed sample writes, retried at the next flush
+var apiUsageMetrics = expvar.NewMap("api_usage")
+
+type apiUsageRecorder interface {
+	RecordAPIUsage(ctx context.Context, samples []services.APIUsageSample) error
+}
+
+type apiUsageKey struct {
+	organizationID uuid.UUID
+	userID         int
+}
+
+// APIUsageMeter counts requests to /api/ routes and the bytes they carry per
+// organization and signed-in user. Counts are kept in memory and flushed as
+// samples every apiUsageFlushInterval; a periodic job rolls samples up into
+// hourly totals. A crash loses at most one interval of usage.
+type APIUsageMeter struct {
+	recorder apiUsageRecorder
+	flush    time.Duration
+
+	mu      sync.Mutex
+	pending map[apiUsageKey]services.APIUsage
+
+	closeOnce sync.Once
+	done      chan struct{}
+}
+
+func newAPIUsageMeter(recorder apiUsageRecorder, flush time.Duration) *APIUsageMeter {
+	if flush <= 0 {
+		flush = apiUsageFlushInterval
+	}
+	return &APIUsageMeter{
+		recorder: recorder,
+		flush:    flush,
+		pending:  make(map[apiUsageKey]services.APIUsage),
+		done:     make(chan struct{}),
+	}
+}
+
+// Middleware meters API requests. It must run after RequireOrganization;
+// requests without a user or organization aren't metered. Streamed responses
+// are counted when the stream ends.
+func (m *APIUsageMeter) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.HasPrefix(r.URL.Path, "/api/") {
+			next.ServeHTTP(w, r)
+			return
+		}
+		user := auth.GetUserFromContext(r.Context())
+		org := GetOrganizationFromContext(r.Context())
+		if user == nil || org == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		body := &countingReader{ReadCloser: r.Body}
+		if r.Body != nil && r.Body != http.NoBody {
+			r.Body = body
+		}
+		ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+		defer func() {
+			m.add(apiUsageKey{organizationID: org.ID, userID: user.ID}, services.APIUsage{
+				Requests:      1,
+				RequestBytes:  body.n,
+				ResponseBytes: int64(ww.BytesWritten()),
+			})
+		}()
+		next.ServeHTTP(ww, r)
+	})
+}
+
+func (m *APIUsageMeter) add(key apiUsageKey, u services.APIUsage) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.pending[key] = m.pending[key].Add(u)
+}
+
+// Close stops Run after a final flush. Call it after the HTTP server has
+// stopped so in-flight requests are counted.
+func (m *APIUsageMeter) Close() {
+	m.closeOnce.Do(func() { close(m.done) })
+}
+
+// Run flushes metered usage until Close is called.
+func (m *APIUsageMeter) Run() {
+	ticker := time.NewTicker(m.flush)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-m.done:
+			m.write()
+			return
+		case <-ticker.C:
+			m.write()
+		}
+	}
+}
+
+// write saves the usage metered since the last write. Usage that fails to
+// save is kept for the next write.
+func (m *APIUsageMeter) write() {
+	m.mu.Lock()
+	pending := m.pending
+	m.pending = make(map[apiUsageKey]services.APIUsage)
+	m.mu.Unlock()
+	if len(pending) == 0 {
+		return
+	}
+
+	now := time.Now()
+	samples := make([]services.APIUsageSample, 0, len(pending))
+	for key, u := range pending {
+		samples = append(samples, services.APIUsageSample{
+			OrganizationID: key.organizationID,
+			UserID:         key.userID,
+			RecordedAt:     now,
+			APIUsage:       u,
+		})
+	}
+
+	// Use a fresh context so the final flush still runs during shutdown.
+	ctx, cancel := context.WithTimeout(context.Background(), apiUsageWriteTimeout)
+	defer cancel()
+	if err := m.recorder.RecordAPIUsage(ctx, samples); err != nil {
+		apiUsageMetrics.Add("failed", 1)
+		slog.Error("failed to record api usage", "error", err, "samples", len(samples))
+		m.mu.Lock()
+		for key, u := range m.pending {
+			pending[key] = pending[key].Add(u)
+		}
+		m.pending = pending
+		m.mu.Unlock()
+		return
+	}
+	apiUsageMetrics.Add("flushed", int64(len(samples)))
+}
+
+// countingReader counts the bytes read through it.
+type countingReader struct {
+	io.ReadCloser
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.ReadCloser.Read(p)
+	c.n += int64(n)
+	return n, err
+}
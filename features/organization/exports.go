@@ -0,0 +1,142 @@
+package organization
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/cavenine/queryops/features/auth"
+	"github.com/cavenine/queryops/features/organization/pages"
+	"github.com/cavenine/queryops/features/organization/services"
+	"github.com/cavenine/queryops/internal/sharelink"
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+)
+
+// settingsExportLimit is how many organization exports the settings page
+// lists.
+const settingsExportLimit = 5
+
+type organizationExportStore interface {
+	Request(ctx context.Context, organizationID uuid.UUID, requestedBy int) (*services.OrganizationExport, error)
+	List(ctx context.Context, organizationID uuid.UUID, limit int) ([]services.OrganizationExport, error)
+	Content(ctx context.Context, organizationID, id uuid.UUID) (*services.OrganizationExport, []byte, error)
+}
+
+// RequestOrganizationExport queues an export of the active organization's
+// data.
+func (h *Handlers) RequestOrganizationExport(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	activeOrg := GetOrganizationFromContext(ctx)
+	user := auth.GetUserFromContext(ctx)
+	if activeOrg == nil || user == nil {
+		slog.ErrorContext(ctx, "missing active organization or user in context")
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	export, err := h.exports.Request(ctx, activeOrg.ID, user.ID)
+	if err != nil {
+		if errors.Is(err, services.ErrOrganizationExportInProgress) {
+			h.renderSettings(w, r, http.StatusConflict, settingsErrors{export: "An export is already being built. Download it once it's ready."})
+			return
+		}
+		slog.ErrorContext(ctx, "failed to request organization export", "error", err)
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+	slog.InfoContext(ctx, "organization export requested",
+		"organization_id", activeOrg.ID,
+		"export_id", export.ID,
+		"user_id", user.ID,
+	)
+
+	http.Redirect(w, r, "/organization/settings", http.StatusSeeOther)
+}
+
+// DownloadOrganizationExport serves the export a signed link refers to. The
+// link works until the export expires, for owners and admins of its
+// organization with it active.
+func (h *Handlers) DownloadOrganizationExport(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	activeOrg := GetOrganizationFromContext(ctx)
+	if activeOrg == nil {
+		slog.ErrorContext(ctx, "missing active organization in context")
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	link, err := h.exportLinks.Verify(chi.URLParam(r, "token"), time.Now())
+	if err != nil {
+		if errors.Is(err, sharelink.ErrExpired) {
+			http.Error(w, "this export has expired; request a new one from organization settings", http.StatusGone)
+			return
+		}
+		http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+		return
+	}
+	if link.OrganizationID != activeOrg.ID {
+		for _, o := range GetUserOrganizationsFromContext(ctx) {
+			if o.ID == link.OrganizationID {
+				http.Error(w, "this export is of the "+o.Name+" organization; switch to it and open the link again", http.StatusConflict)
+				return
+			}
+		}
+		http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+		return
+	}
+
+	export, content, err := h.exports.Content(ctx, activeOrg.ID, link.ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, services.ErrOrganizationExportNotFound):
+			http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+		case errors.Is(err, services.ErrOrganizationExportNotReady):
+			http.Error(w, err.Error(), http.StatusConflict)
+		default:
+			slog.ErrorContext(ctx, "failed to load organization export", "error", err)
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		}
+		return
+	}
+	slog.InfoContext(ctx, "organization export downloaded",
+		"organization_id", activeOrg.ID,
+		"export_id", export.ID,
+	)
+
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", "attachment; filename="+strconv.Quote(export.Filename))
+	w.Header().Set("Content-Length", strconv.Itoa(len(content)))
+	if _, err := w.Write(content); err != nil {
+		slog.ErrorContext(ctx, "failed to write organization export", "error", err)
+	}
+}
+
+// organizationExports returns the active organization's recent exports for
+// the settings page, with signed download links for the ready ones. Only
+// owners and admins see them.
+func (h *Handlers) organizationExports(ctx context.Context, activeOrg *services.Organization) ([]pages.OrganizationExport, error) {
+	if !activeOrg.CanManage() {
+		return nil, nil
+	}
+	exports, err := h.exports.List(ctx, activeOrg.ID, settingsExportLimit)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	listed := make([]pages.OrganizationExport, 0, len(exports))
+	for _, export := range exports {
+		e := pages.OrganizationExport{OrganizationExport: export}
+		if export.Status == services.ExportReady && export.ExpiresAt != nil && export.ExpiresAt.After(now) {
+			token := h.exportLinks.Sign(sharelink.Link{ID: export.ID, OrganizationID: activeOrg.ID, ExpiresAt: *export.ExpiresAt})
+			e.DownloadURL = "/organization/exports/" + token
+		}
+		listed = append(listed, e)
+	}
+	return listed, nil
+}
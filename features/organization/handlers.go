@@ -1,17 +1,151 @@
 package organization
 
 import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
 	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/alexedwards/scs/v2"
 	"github.com/cavenine/queryops/features/auth"
+	notificationServices "github.com/cavenine/queryops/features/notification/services"
 	"github.com/cavenine/queryops/features/organization/pages"
 	"github.com/cavenine/queryops/features/organization/services"
+	"github.com/cavenine/queryops/internal/notify"
+	"github.com/cavenine/queryops/internal/osqueryinstall"
+	"github.com/cavenine/queryops/internal/osquerypkg"
+	"github.com/cavenine/queryops/internal/sharelink"
+	"github.com/cavenine/queryops/internal/validate"
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
 )
 
+type quotaReader interface {
+	GetQuotas(ctx context.Context, organizationID uuid.UUID) (services.Quotas, error)
+	GetUsage(ctx context.Context, organizationID uuid.UUID) (services.Usage, error)
+}
+
+type apiUsageReader interface {
+	GetAPIUsage(ctx context.Context, organizationID uuid.UUID, since time.Time) (services.APIUsage, error)
+	ListMemberAPIUsage(ctx context.Context, organizationID uuid.UUID, since time.Time) ([]services.MemberAPIUsage, error)
+}
+
+type enrollNetworkStore interface {
+	List(ctx context.Context, organizationID uuid.UUID) ([]services.EnrollNetwork, error)
+	Add(ctx context.Context, organizationID uuid.UUID, network, action, description string) (*services.EnrollNetwork, error)
+	Delete(ctx context.Context, organizationID uuid.UUID, id int64) error
+}
+
+type redactionRuleStore interface {
+	List(ctx context.Context, organizationID uuid.UUID) ([]services.RedactionRule, error)
+	Add(ctx context.Context, organizationID uuid.UUID, kind, pattern, description string) (*services.RedactionRule, error)
+	Delete(ctx context.Context, organizationID uuid.UUID, id int64) error
+}
+
+type statusAlertRuleStore interface {
+	List(ctx context.Context, organizationID uuid.UUID) ([]services.StatusAlertRule, error)
+	Add(ctx context.Context, organizationID uuid.UUID, minSeverity int, pattern, webhookURL, description string) (*services.StatusAlertRule, error)
+	Delete(ctx context.Context, organizationID uuid.UUID, id int64) error
+}
+
+type resultBaselineRuleStore interface {
+	List(ctx context.Context, organizationID uuid.UUID) ([]services.ResultBaselineRule, error)
+	Add(ctx context.Context, organizationID uuid.UUID, queryName, columns string, threshold float64, description string) (*services.ResultBaselineRule, error)
+	Delete(ctx context.Context, organizationID uuid.UUID, id int64) error
+}
+
+type digestSettingsStore interface {
+	GetSettings(ctx context.Context, organizationID uuid.UUID) (*services.DigestSettings, error)
+	SaveSettings(ctx context.Context, s services.DigestSettings) error
+}
+
+type slackStore interface {
+	GetIntegration(ctx context.Context, organizationID uuid.UUID) (*services.SlackIntegration, error)
+	SaveIntegration(ctx context.Context, organizationID uuid.UUID, webhookURL, botToken string, removeToken bool) error
+	ListRoutes(ctx context.Context, organizationID uuid.UUID) ([]services.SlackRoute, error)
+	AddRoute(ctx context.Context, organizationID uuid.UUID, event, channel, template string) (*services.SlackRoute, error)
+	DeleteRoute(ctx context.Context, organizationID uuid.UUID, id int64) error
+}
+
+type alertDestinationStore interface {
+	List(ctx context.Context, organizationID uuid.UUID) ([]services.AlertDestination, error)
+	ListHostGroups(ctx context.Context, organizationID uuid.UUID) ([]services.AlertHostGroup, error)
+	Add(ctx context.Context, d services.AlertDestination, key string) (*services.AlertDestination, error)
+	Delete(ctx context.Context, organizationID uuid.UUID, id int64) error
+}
+
+type settingsStore interface {
+	GetSettings(ctx context.Context, organizationID uuid.UUID) (*services.OrganizationSettings, error)
+	SaveDefaults(ctx context.Context, s services.OrganizationSettings) error
+	DefaultConfigIntervals(ctx context.Context) (services.OsqueryIntervals, error)
+	SetMutedNotifications(ctx context.Context, organizationID uuid.UUID, kinds []string) error
+	SetDecorators(ctx context.Context, organizationID uuid.UUID, decorators []string, tag string) error
+}
+
+type enrollmentPackageStore interface {
+	Request(ctx context.Context, organizationID uuid.UUID, format, hostname string, requestedBy int) (*services.EnrollmentPackage, error)
+	List(ctx context.Context, organizationID uuid.UUID, limit int) ([]services.EnrollmentPackage, error)
+	Content(ctx context.Context, organizationID, id uuid.UUID) (*services.EnrollmentPackage, []byte, error)
+}
+
+// settingsErrors are the form errors shown on the settings page: a message
+// above a form, or problems with its fields below it.
+type settingsErrors struct {
+	general         string
+	generalFields   validate.Errors
+	defaults        string
+	defaultsFields  validate.Errors
+	decorators      string
+	decoratorFields validate.Errors
+	network         string
+	networkFields   validate.Errors
+	redaction       string
+	redactionFields validate.Errors
+	alert           string
+	alertFields     validate.Errors
+	baseline        string
+	baselineFields  validate.Errors
+	digest          string
+	digestFields    validate.Errors
+	slack           string
+	slackFields     validate.Errors
+	paging          string
+	pagingFields    validate.Errors
+	pkg             string
+	pkgFields       validate.Errors
+	export          string
+	notifications   string
+	danger          string
+	dangerFields    validate.Errors
+}
+
+// settingsPackageLimit is how many enrollment packages the settings page
+// lists.
+const settingsPackageLimit = 10
+
 type Handlers struct {
 	orgService     *services.OrganizationService
 	sessionManager *scs.SessionManager
+	quotas         quotaReader
+	networks       enrollNetworkStore
+	redactions     redactionRuleStore
+	alerts         statusAlertRuleStore
+	baselines      resultBaselineRuleStore
+	digests        digestSettingsStore
+	slack          slackStore
+	paging         alertDestinationStore
+	settings       settingsStore
+	packages       enrollmentPackageStore
+	apiUsage       apiUsageReader
+	exports        organizationExportStore
+	exportLinks    *sharelink.Signer
+	// tlsHostname overrides the request Host in generated install files.
+	tlsHostname string
 }
 
 func NewHandlers(orgService *services.OrganizationService, sessionManager *scs.SessionManager) *Handlers {
@@ -22,20 +156,22 @@ func NewHandlers(orgService *services.OrganizationService, sessionManager *scs.S
 }
 
 func (h *Handlers) CreateOrgPage(w http.ResponseWriter, r *http.Request) {
-	if err := pages.CreatePage("").Render(r.Context(), w); err != nil {
+	if err := pages.CreatePage("", nil).Render(r.Context(), w); err != nil {
 		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
 	}
 }
 
 func (h *Handlers) CreateOrgSubmit(w http.ResponseWriter, r *http.Request) {
 	if err := r.ParseForm(); err != nil {
-		h.renderCreateError(w, r, "Invalid form data")
+		h.renderCreateError(w, r, "Invalid form data", nil)
 		return
 	}
 
-	name := r.FormValue("name")
-	if name == "" {
-		h.renderCreateError(w, r, "Organization name is required")
+	name := strings.TrimSpace(r.FormValue("name"))
+	fields := validate.Errors{}
+	fields.Field("name", name, validate.Required(), validate.MaxLength(100))
+	if len(fields) > 0 {
+		h.renderCreateError(w, r, "", fields)
 		return
 	}
 
@@ -47,7 +183,8 @@ func (h *Handlers) CreateOrgSubmit(w http.ResponseWriter, r *http.Request) {
 
 	org, err := h.orgService.Create(r.Context(), name, user.ID)
 	if err != nil {
-		h.renderCreateError(w, r, err.Error())
+		slog.Error("failed to create organization", "error", err)
+		h.renderCreateError(w, r, "Failed to create organization", nil)
 		return
 	}
 
@@ -57,9 +194,779 @@ func (h *Handlers) CreateOrgSubmit(w http.ResponseWriter, r *http.Request) {
 	http.Redirect(w, r, "/", http.StatusSeeOther)
 }
 
-func (h *Handlers) renderCreateError(w http.ResponseWriter, r *http.Request, errorMsg string) {
+func (h *Handlers) renderCreateError(w http.ResponseWriter, r *http.Request, errorMsg string, fields validate.Errors) {
 	w.WriteHeader(http.StatusUnprocessableEntity)
-	if err := pages.CreatePage(errorMsg).Render(r.Context(), w); err != nil {
+	if err := pages.CreatePage(errorMsg, fields).Render(r.Context(), w); err != nil {
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+	}
+}
+
+// SettingsPage shows the active organization's name and defaults, its usage
+// against its quotas, its enrollment networks, its result redaction rules, its status log alert
+// and result baseline rules, and its osquery install files and packages.
+func (h *Handlers) SettingsPage(w http.ResponseWriter, r *http.Request) {
+	h.renderSettings(w, r, http.StatusOK, settingsErrors{})
+}
+
+// AddEnrollNetwork adds a network to the active organization's enrollment
+// allow or deny list.
+func (h *Handlers) AddEnrollNetwork(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	activeOrg := GetOrganizationFromContext(ctx)
+	if activeOrg == nil {
+		slog.ErrorContext(ctx, "missing active organization in context")
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		h.renderSettings(w, r, http.StatusUnprocessableEntity, settingsErrors{network: "Invalid form data"})
+		return
+	}
+
+	fields := validate.Errors{}
+	fields.Field("network", strings.TrimSpace(r.FormValue("network")), validate.Required())
+	if len(fields) > 0 {
+		h.renderSettings(w, r, http.StatusUnprocessableEntity, settingsErrors{networkFields: fields})
+		return
+	}
+
+	network, err := h.networks.Add(ctx, activeOrg.ID, r.FormValue("network"), r.FormValue("action"), r.FormValue("description"))
+	if err != nil {
+		switch {
+		case errors.Is(err, services.ErrInvalidNetwork), errors.Is(err, services.ErrDuplicateNetwork):
+			fields.Add("network", err.Error())
+		case errors.Is(err, services.ErrInvalidNetworkAction):
+			fields.Add("action", err.Error())
+		}
+		if len(fields) > 0 {
+			h.renderSettings(w, r, http.StatusUnprocessableEntity, settingsErrors{networkFields: fields})
+			return
+		}
+		slog.ErrorContext(ctx, "failed to add enroll network", "error", err)
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+	slog.InfoContext(ctx, "enroll network added",
+		"organization_id", activeOrg.ID,
+		"network", network.Network,
+		"action", network.Action,
+	)
+
+	http.Redirect(w, r, "/organization/settings", http.StatusSeeOther)
+}
+
+// DeleteEnrollNetwork removes a network from the active organization's
+// enrollment lists.
+func (h *Handlers) DeleteEnrollNetwork(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	activeOrg := GetOrganizationFromContext(ctx)
+	if activeOrg == nil {
+		slog.ErrorContext(ctx, "missing active organization in context")
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	id, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+		return
+	}
+	if err := h.networks.Delete(ctx, activeOrg.ID, id); err != nil {
+		if errors.Is(err, services.ErrNetworkNotFound) {
+			http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+			return
+		}
+		slog.ErrorContext(ctx, "failed to delete enroll network", "error", err)
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+	slog.InfoContext(ctx, "enroll network deleted", "organization_id", activeOrg.ID, "id", id)
+
+	http.Redirect(w, r, "/organization/settings", http.StatusSeeOther)
+}
+
+// AddRedactionRule adds a rule that hides matching columns or values in the
+// active organization's query results.
+func (h *Handlers) AddRedactionRule(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	activeOrg := GetOrganizationFromContext(ctx)
+	if activeOrg == nil {
+		slog.ErrorContext(ctx, "missing active organization in context")
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		h.renderSettings(w, r, http.StatusUnprocessableEntity, settingsErrors{redaction: "Invalid form data"})
+		return
+	}
+
+	fields := validate.Errors{}
+	fields.Field("pattern", r.FormValue("pattern"), validate.Required())
+	if len(fields) > 0 {
+		h.renderSettings(w, r, http.StatusUnprocessableEntity, settingsErrors{redactionFields: fields})
+		return
+	}
+
+	rule, err := h.redactions.Add(ctx, activeOrg.ID, r.FormValue("kind"), r.FormValue("pattern"), r.FormValue("description"))
+	if err != nil {
+		switch {
+		case errors.Is(err, services.ErrInvalidRedactionKind):
+			fields.Add("kind", err.Error())
+		case errors.Is(err, services.ErrInvalidRedactionPattern), errors.Is(err, services.ErrDuplicateRedactionRule):
+			fields.Add("pattern", err.Error())
+		}
+		if len(fields) > 0 {
+			h.renderSettings(w, r, http.StatusUnprocessableEntity, settingsErrors{redactionFields: fields})
+			return
+		}
+		slog.ErrorContext(ctx, "failed to add redaction rule", "error", err)
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+	slog.InfoContext(ctx, "redaction rule added",
+		"organization_id", activeOrg.ID,
+		"kind", rule.Kind,
+		"pattern", rule.Pattern,
+	)
+
+	http.Redirect(w, r, "/organization/settings", http.StatusSeeOther)
+}
+
+// DeleteRedactionRule removes one of the active organization's redaction
+// rules. Results stored while it applied stay redacted.
+func (h *Handlers) DeleteRedactionRule(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	activeOrg := GetOrganizationFromContext(ctx)
+	if activeOrg == nil {
+		slog.ErrorContext(ctx, "missing active organization in context")
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	id, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+		return
+	}
+	if err := h.redactions.Delete(ctx, activeOrg.ID, id); err != nil {
+		if errors.Is(err, services.ErrRedactionRuleNotFound) {
+			http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+			return
+		}
+		slog.ErrorContext(ctx, "failed to delete redaction rule", "error", err)
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+	slog.InfoContext(ctx, "redaction rule deleted", "organization_id", activeOrg.ID, "id", id)
+
+	http.Redirect(w, r, "/organization/settings", http.StatusSeeOther)
+}
+
+// AddStatusAlertRule adds a rule that alerts the active organization when a
+// host logs matching status messages.
+func (h *Handlers) AddStatusAlertRule(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	activeOrg := GetOrganizationFromContext(ctx)
+	if activeOrg == nil {
+		slog.ErrorContext(ctx, "missing active organization in context")
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		h.renderSettings(w, r, http.StatusUnprocessableEntity, settingsErrors{alert: "Invalid form data"})
+		return
+	}
+	fields := validate.Errors{}
+	severity, err := strconv.Atoi(r.FormValue("min_severity"))
+	fields.Check(err == nil, "min_severity", services.ErrInvalidAlertSeverity.Error())
+	if strings.TrimSpace(r.FormValue("webhook_url")) != "" && !activeOrg.HasFeature(services.FeatureWebhooks) {
+		fields.Add("webhook_url", FeatureUnavailable(activeOrg, services.FeatureWebhooks))
+	}
+	if len(fields) > 0 {
+		h.renderSettings(w, r, http.StatusUnprocessableEntity, settingsErrors{alertFields: fields})
+		return
+	}
+
+	rule, err := h.alerts.Add(ctx, activeOrg.ID, severity, r.FormValue("pattern"), r.FormValue("webhook_url"), r.FormValue("description"))
+	if err != nil {
+		switch {
+		case errors.Is(err, services.ErrInvalidAlertSeverity):
+			fields.Add("min_severity", err.Error())
+		case errors.Is(err, services.ErrInvalidAlertPattern), errors.Is(err, services.ErrDuplicateStatusAlertRule):
+			fields.Add("pattern", err.Error())
+		case errors.Is(err, notify.ErrInvalidWebhookURL), errors.Is(err, notify.ErrDisallowedWebhookAddress):
+			fields.Add("webhook_url", err.Error())
+		}
+		if len(fields) > 0 {
+			h.renderSettings(w, r, http.StatusUnprocessableEntity, settingsErrors{alertFields: fields})
+			return
+		}
+		slog.ErrorContext(ctx, "failed to add status alert rule", "error", err)
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+	slog.InfoContext(ctx, "status alert rule added",
+		"organization_id", activeOrg.ID,
+		"min_severity", rule.MinSeverity,
+		"pattern", rule.Pattern,
+	)
+
+	http.Redirect(w, r, "/organization/settings", http.StatusSeeOther)
+}
+
+// SaveDigestSettings sets how often the active organization gets an activity
+// digest and where it goes.
+func (h *Handlers) SaveDigestSettings(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	activeOrg := GetOrganizationFromContext(ctx)
+	if activeOrg == nil {
+		slog.ErrorContext(ctx, "missing active organization in context")
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		h.renderSettings(w, r, http.StatusUnprocessableEntity, settingsErrors{digest: "Invalid form data"})
+		return
+	}
+	email := strings.TrimSpace(r.FormValue("email"))
+	webhookURL := r.FormValue("webhook_url")
+	fields := validate.Errors{}
+	fields.Field("email", email, validate.Email(), validate.MaxLength(254))
+	if strings.TrimSpace(webhookURL) != "" && !activeOrg.HasFeature(services.FeatureWebhooks) {
+		fields.Add("webhook_url", FeatureUnavailable(activeOrg, services.FeatureWebhooks))
+	}
+	if len(fields) > 0 {
+		h.renderSettings(w, r, http.StatusUnprocessableEntity, settingsErrors{digestFields: fields})
+		return
+	}
+
+	settings := services.DigestSettings{
+		OrganizationID: activeOrg.ID,
+		Frequency:      r.FormValue("frequency"),
+		Email:          &email,
+		WebhookURL:     &webhookURL,
+	}
+	if err := h.digests.SaveSettings(ctx, settings); err != nil {
+		switch {
+		case errors.Is(err, services.ErrInvalidDigestFrequency):
+			fields.Add("frequency", err.Error())
+		case errors.Is(err, services.ErrDigestNoDestination):
+			fields.Add("email", err.Error())
+		case errors.Is(err, notify.ErrInvalidWebhookURL), errors.Is(err, notify.ErrDisallowedWebhookAddress):
+			fields.Add("webhook_url", err.Error())
+		}
+		if len(fields) > 0 {
+			h.renderSettings(w, r, http.StatusUnprocessableEntity, settingsErrors{digestFields: fields})
+			return
+		}
+		slog.ErrorContext(ctx, "failed to save digest settings", "error", err)
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+	slog.InfoContext(ctx, "digest settings saved",
+		"organization_id", activeOrg.ID,
+		"frequency", settings.Frequency,
+	)
+
+	http.Redirect(w, r, "/organization/settings", http.StatusSeeOther)
+}
+
+// DeleteStatusAlertRule removes one of the active organization's status log
+// alert rules.
+func (h *Handlers) DeleteStatusAlertRule(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	activeOrg := GetOrganizationFromContext(ctx)
+	if activeOrg == nil {
+		slog.ErrorContext(ctx, "missing active organization in context")
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	id, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+		return
+	}
+	if err := h.alerts.Delete(ctx, activeOrg.ID, id); err != nil {
+		if errors.Is(err, services.ErrStatusAlertRuleNotFound) {
+			http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+			return
+		}
+		slog.ErrorContext(ctx, "failed to delete status alert rule", "error", err)
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+	slog.InfoContext(ctx, "status alert rule deleted", "organization_id", activeOrg.ID, "id", id)
+
+	http.Redirect(w, r, "/organization/settings", http.StatusSeeOther)
+}
+
+// AddResultBaselineRule adds a rule that flags a scheduled query's results
+// when they stray from each host's baseline.
+func (h *Handlers) AddResultBaselineRule(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	activeOrg := GetOrganizationFromContext(ctx)
+	if activeOrg == nil {
+		slog.ErrorContext(ctx, "missing active organization in context")
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		h.renderSettings(w, r, http.StatusUnprocessableEntity, settingsErrors{baseline: "Invalid form data"})
+		return
+	}
+	fields := validate.Errors{}
+	fields.Field("query_name", strings.TrimSpace(r.FormValue("query_name")), validate.Required())
+	var threshold float64
+	if s := strings.TrimSpace(r.FormValue("threshold")); s != "" {
+		var err error
+		threshold, err = strconv.ParseFloat(s, 64)
+		fields.Check(err == nil, "threshold", services.ErrInvalidBaselineThreshold.Error())
+	}
+	if len(fields) > 0 {
+		h.renderSettings(w, r, http.StatusUnprocessableEntity, settingsErrors{baselineFields: fields})
+		return
+	}
+
+	rule, err := h.baselines.Add(ctx, activeOrg.ID, r.FormValue("query_name"), r.FormValue("columns"), threshold, r.FormValue("description"))
+	if err != nil {
+		switch {
+		case errors.Is(err, services.ErrInvalidBaselineQuery), errors.Is(err, services.ErrDuplicateBaselineRule):
+			fields.Add("query_name", err.Error())
+		case errors.Is(err, services.ErrInvalidBaselineColumns):
+			fields.Add("columns", err.Error())
+		case errors.Is(err, services.ErrInvalidBaselineThreshold):
+			fields.Add("threshold", err.Error())
+		}
+		if len(fields) > 0 {
+			h.renderSettings(w, r, http.StatusUnprocessableEntity, settingsErrors{baselineFields: fields})
+			return
+		}
+		slog.ErrorContext(ctx, "failed to add result baseline rule", "error", err)
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+	slog.InfoContext(ctx, "result baseline rule added",
+		"organization_id", activeOrg.ID,
+		"query_name", rule.QueryName,
+		"columns", rule.Columns,
+		"threshold", rule.Threshold,
+	)
+
+	http.Redirect(w, r, "/organization/settings", http.StatusSeeOther)
+}
+
+// DeleteResultBaselineRule removes one of the active organization's result
+// baseline rules, along with the anomalies it flagged.
+func (h *Handlers) DeleteResultBaselineRule(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	activeOrg := GetOrganizationFromContext(ctx)
+	if activeOrg == nil {
+		slog.ErrorContext(ctx, "missing active organization in context")
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	id, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+		return
+	}
+	if err := h.baselines.Delete(ctx, activeOrg.ID, id); err != nil {
+		if errors.Is(err, services.ErrBaselineRuleNotFound) {
+			http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+			return
+		}
+		slog.ErrorContext(ctx, "failed to delete result baseline rule", "error", err)
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+	slog.InfoContext(ctx, "result baseline rule deleted", "organization_id", activeOrg.ID, "id", id)
+
+	http.Redirect(w, r, "/organization/settings", http.StatusSeeOther)
+}
+
+// installPlatformLabels names osqueryinstall's platforms for the settings page.
+var installPlatformLabels = map[string]string{
+	"darwin":  "macOS",
+	"linux":   "Linux",
+	"windows": "Windows",
+}
+
+// DownloadInstallFile serves one of the active organization's osquery
+// install files.
+func (h *Handlers) DownloadInstallFile(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	activeOrg := GetOrganizationFromContext(ctx)
+	if activeOrg == nil {
+		slog.ErrorContext(ctx, "missing active organization in context")
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	params, err := h.installParams(r, activeOrg.ID)
+	if err != nil {
+		slog.ErrorContext(ctx, "failed to load install parameters", "error", err)
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+	file, err := osqueryinstall.FindFile(chi.URLParam(r, "platform"), chi.URLParam(r, "file"), params)
+	if err != nil {
+		switch {
+		case errors.Is(err, osqueryinstall.ErrUnknownPlatform), errors.Is(err, osqueryinstall.ErrUnknownFile):
+			http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+		case errors.Is(err, osqueryinstall.ErrInvalidParams):
+			http.Error(w, err.Error(), http.StatusConflict)
+		default:
+			slog.ErrorContext(ctx, "failed to render install file", "error", err)
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		}
+		return
+	}
+
+	// Install scripts and flags embed the enroll secret.
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", file.Name))
+	if _, err := w.Write(file.Content); err != nil {
+		slog.ErrorContext(ctx, "failed to write install file", "error", err)
+	}
+}
+
+// packageFormatLabels names osquerypkg's formats for the settings page.
+var packageFormatLabels = map[string]string{
+	osquerypkg.FormatDeb: "Debian / Ubuntu (.deb)",
+	osquerypkg.FormatMSI: "Windows (.msi)",
+	osquerypkg.FormatPkg: "macOS (.pkg)",
+}
+
+// RequestEnrollmentPackage queues a build of the active organization's
+// enrollment package in the requested format.
+func (h *Handlers) RequestEnrollmentPackage(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	activeOrg := GetOrganizationFromContext(ctx)
+	user := auth.GetUserFromContext(ctx)
+	if activeOrg == nil || user == nil {
+		slog.ErrorContext(ctx, "missing active organization or user in context")
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		h.renderSettings(w, r, http.StatusUnprocessableEntity, settingsErrors{pkg: "Invalid form data"})
+		return
+	}
+
+	format := r.FormValue("format")
+	fields := validate.Errors{}
+	if err := osquerypkg.Available(format); err != nil {
+		fields.Add("format", err.Error())
+		h.renderSettings(w, r, http.StatusUnprocessableEntity, settingsErrors{pkgFields: fields})
+		return
+	}
+	pkg, err := h.packages.Request(ctx, activeOrg.ID, format, h.installHostname(r), user.ID)
+	if err != nil {
+		if errors.Is(err, services.ErrInvalidPackageFormat) {
+			fields.Add("format", err.Error())
+			h.renderSettings(w, r, http.StatusUnprocessableEntity, settingsErrors{pkgFields: fields})
+			return
+		}
+		slog.ErrorContext(ctx, "failed to request enrollment package", "error", err)
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+	slog.InfoContext(ctx, "enrollment package requested",
+		"organization_id", activeOrg.ID,
+		"package_id", pkg.ID,
+		"format", pkg.Format,
+		"build", pkg.Build,
+	)
+
+	http.Redirect(w, r, "/organization/settings", http.StatusSeeOther)
+}
+
+// DownloadEnrollmentPackage serves one of the active organization's built
+// enrollment packages.
+func (h *Handlers) DownloadEnrollmentPackage(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	activeOrg := GetOrganizationFromContext(ctx)
+	if activeOrg == nil {
+		slog.ErrorContext(ctx, "missing active organization in context")
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	id, err := uuid.Parse(chi.URLParam(r, "id"))
+	if err != nil {
+		http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+		return
+	}
+	pkg, content, err := h.packages.Content(ctx, activeOrg.ID, id)
+	if err != nil {
+		switch {
+		case errors.Is(err, services.ErrEnrollmentPackageNotFound):
+			http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+		case errors.Is(err, services.ErrEnrollmentPackageNotReady):
+			http.Error(w, err.Error(), http.StatusConflict)
+		default:
+			slog.ErrorContext(ctx, "failed to load enrollment package", "error", err)
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		}
+		return
+	}
+
+	// Packages embed the enroll secret.
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", pkg.Filename))
+	w.Header().Set("Content-Length", strconv.Itoa(len(content)))
+	if _, err := w.Write(content); err != nil {
+		slog.ErrorContext(ctx, "failed to write enrollment package", "error", err)
+	}
+}
+
+// installHostname is the host osquery is pointed at in install files and
+// packages.
+func (h *Handlers) installHostname(r *http.Request) string {
+	if h.tlsHostname != "" {
+		return h.tlsHostname
+	}
+	return r.Host
+}
+
+// installParams returns the values substituted into the organization's
+// install files. An organization without an active enroll secret gets an
+// empty one, which osqueryinstall rejects.
+func (h *Handlers) installParams(r *http.Request, organizationID uuid.UUID) (osqueryinstall.Params, error) {
+	secret, err := h.orgService.GetActiveEnrollSecret(r.Context(), organizationID)
+	if err != nil {
+		return osqueryinstall.Params{}, fmt.Errorf("loading enroll secret: %w", err)
+	}
+	return osqueryinstall.NewParams(h.installHostname(r), secret), nil
+}
+
+// installPlatforms renders the install files shown on the settings page. If
+// they can't be generated, it returns a message saying why instead.
+func (h *Handlers) installPlatforms(r *http.Request, organizationID uuid.UUID) ([]pages.InstallPlatform, string, error) {
+	params, err := h.installParams(r, organizationID)
+	if err != nil {
+		return nil, "", err
+	}
+	if params.EnrollSecret == "" {
+		return nil, "This organization has no active enroll secret.", nil
+	}
+
+	platforms := make([]pages.InstallPlatform, 0, len(osqueryinstall.Platforms))
+	for _, name := range osqueryinstall.Platforms {
+		files, err := osqueryinstall.Files(name, params)
+		if err != nil {
+			if errors.Is(err, osqueryinstall.ErrInvalidParams) {
+				return nil, fmt.Sprintf("Install files can't be generated: %v.", err), nil
+			}
+			return nil, "", err
+		}
+		platforms = append(platforms, pages.InstallPlatform{Name: name, Label: installPlatformLabels[name], Files: files})
+	}
+	return platforms, "", nil
+}
+
+func (h *Handlers) renderSettings(w http.ResponseWriter, r *http.Request, status int, formErrors settingsErrors) {
+	ctx := r.Context()
+	activeOrg := GetOrganizationFromContext(ctx)
+	if activeOrg == nil {
+		slog.ErrorContext(ctx, "missing active organization in context")
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	quotas, err := h.quotas.GetQuotas(ctx, activeOrg.ID)
+	if err != nil {
+		slog.ErrorContext(ctx, "failed to load organization quotas", "error", err)
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+	usage, err := h.quotas.GetUsage(ctx, activeOrg.ID)
+	if err != nil {
+		slog.ErrorContext(ctx, "failed to load organization usage", "error", err)
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+	apiUsageSince := time.Now().Add(-apiUsageWindow)
+	apiUsage, err := h.apiUsage.GetAPIUsage(ctx, activeOrg.ID, apiUsageSince)
+	if err != nil {
+		slog.ErrorContext(ctx, "failed to load api usage", "error", err)
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+	memberAPIUsage, err := h.apiUsage.ListMemberAPIUsage(ctx, activeOrg.ID, apiUsageSince)
+	if err != nil {
+		slog.ErrorContext(ctx, "failed to load member api usage", "error", err)
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+	networks, err := h.networks.List(ctx, activeOrg.ID)
+	if err != nil {
+		slog.ErrorContext(ctx, "failed to load enroll networks", "error", err)
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+	redactions, err := h.redactions.List(ctx, activeOrg.ID)
+	if err != nil {
+		slog.ErrorContext(ctx, "failed to load redaction rules", "error", err)
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+	alerts, err := h.alerts.List(ctx, activeOrg.ID)
+	if err != nil {
+		slog.ErrorContext(ctx, "failed to load status alert rules", "error", err)
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+	baselines, err := h.baselines.List(ctx, activeOrg.ID)
+	if err != nil {
+		slog.ErrorContext(ctx, "failed to load result baseline rules", "error", err)
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+	digest, err := h.digests.GetSettings(ctx, activeOrg.ID)
+	if err != nil {
+		slog.ErrorContext(ctx, "failed to load digest settings", "error", err)
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+	slack, err := h.slack.GetIntegration(ctx, activeOrg.ID)
+	if err != nil {
+		slog.ErrorContext(ctx, "failed to load slack integration", "error", err)
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+	slackRoutes, err := h.slack.ListRoutes(ctx, activeOrg.ID)
+	if err != nil {
+		slog.ErrorContext(ctx, "failed to load slack routes", "error", err)
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+	slackEvents := make([]pages.SlackEvent, 0, len(services.SlackEvents))
+	for _, name := range services.SlackEvents {
+		slackEvents = append(slackEvents, pages.SlackEvent{Name: name, Label: slackEventLabels[name]})
+	}
+	alertDestinations, err := h.paging.List(ctx, activeOrg.ID)
+	if err != nil {
+		slog.ErrorContext(ctx, "failed to load alert destinations", "error", err)
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+	alertHostGroups, err := h.paging.ListHostGroups(ctx, activeOrg.ID)
+	if err != nil {
+		slog.ErrorContext(ctx, "failed to load host groups", "error", err)
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+	alertProviders := make([]pages.AlertProvider, 0, len(services.AlertProviders))
+	for _, name := range services.AlertProviders {
+		alertProviders = append(alertProviders, pages.AlertProvider{Name: name, Label: alertProviderLabels[name]})
+	}
+	settings, err := h.settings.GetSettings(ctx, activeOrg.ID)
+	if err != nil {
+		slog.ErrorContext(ctx, "failed to load organization settings", "error", err)
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+	configIntervals, err := h.settings.DefaultConfigIntervals(ctx)
+	if err != nil {
+		slog.ErrorContext(ctx, "failed to load default config intervals", "error", err)
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+	kinds := make([]pages.NotificationKind, 0, len(notificationServices.Kinds))
+	for _, name := range notificationServices.Kinds {
+		kinds = append(kinds, pages.NotificationKind{
+			Name:  name,
+			Label: notificationKindLabels[name],
+			Muted: slices.Contains(settings.MutedNotificationKinds, name),
+		})
+	}
+	install, installError, err := h.installPlatforms(r, activeOrg.ID)
+	if err != nil {
+		slog.ErrorContext(ctx, "failed to render install files", "error", err)
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+	packages, err := h.packages.List(ctx, activeOrg.ID, settingsPackageLimit)
+	if err != nil {
+		slog.ErrorContext(ctx, "failed to load enrollment packages", "error", err)
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+	exports, err := h.organizationExports(ctx, activeOrg)
+	if err != nil {
+		slog.ErrorContext(ctx, "failed to load organization exports", "error", err)
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+	formats := make([]pages.PackageFormat, 0, len(osquerypkg.Formats))
+	for _, name := range osquerypkg.Formats {
+		format := pages.PackageFormat{Name: name, Label: packageFormatLabels[name]}
+		if err := osquerypkg.Available(name); err != nil {
+			format.Unavailable = err.Error()
+		}
+		formats = append(formats, format)
+	}
+
+	w.WriteHeader(status)
+	if err := pages.SettingsPage(pages.SettingsProps{
+		User:               auth.GetUserFromContext(ctx),
+		ActiveOrg:          activeOrg,
+		UserOrgs:           GetUserOrganizationsFromContext(ctx),
+		GeneralError:       formErrors.general,
+		GeneralFields:      formErrors.generalFields,
+		Settings:           settings,
+		ConfigIntervals:    configIntervals,
+		DefaultsError:      formErrors.defaults,
+		DefaultsFields:     formErrors.defaultsFields,
+		DecoratorsError:    formErrors.decorators,
+		DecoratorFields:    formErrors.decoratorFields,
+		Quotas:             quotas,
+		Usage:              usage,
+		APIUsage:           apiUsage,
+		MemberAPIUsage:     memberAPIUsage,
+		EnrollNetworks:     networks,
+		NetworkError:       formErrors.network,
+		NetworkFields:      formErrors.networkFields,
+		RedactionRules:     redactions,
+		RedactionError:     formErrors.redaction,
+		RedactionFields:    formErrors.redactionFields,
+		AlertRules:         alerts,
+		AlertError:         formErrors.alert,
+		AlertFields:        formErrors.alertFields,
+		BaselineRules:      baselines,
+		BaselineError:      formErrors.baseline,
+		BaselineFields:     formErrors.baselineFields,
+		Digest:             digest,
+		DigestError:        formErrors.digest,
+		DigestFields:       formErrors.digestFields,
+		Slack:              slack,
+		SlackRoutes:        slackRoutes,
+		SlackEvents:        slackEvents,
+		SlackError:         formErrors.slack,
+		SlackFields:        formErrors.slackFields,
+		AlertDestinations:  alertDestinations,
+		AlertProviders:     alertProviders,
+		AlertHostGroups:    alertHostGroups,
+		PagingError:        formErrors.paging,
+		PagingFields:       formErrors.pagingFields,
+		NotificationKinds:  kinds,
+		NotificationsError: formErrors.notifications,
+		Install:            install,
+		InstallError:       installError,
+		Packages:           packages,
+		PackageFormats:     formats,
+		PackageError:       formErrors.pkg,
+		PackageFields:      formErrors.pkgFields,
+		Exports:            exports,
+		ExportError:        formErrors.export,
+		DeleteError:        formErrors.danger,
+		DeleteFields:       formErrors.dangerFields,
+	}).Render(ctx, w); err != nil {
 		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
 	}
 }
@@ -0,0 +1,125 @@
+package organization
+
+import (
+	"errors"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/cavenine/queryops/features/organization/services"
+	"github.com/cavenine/queryops/internal/validate"
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+)
+
+// alertProviderLabels names the paging services for the settings page.
+var alertProviderLabels = map[string]string{
+	services.AlertProviderPagerDuty:  "PagerDuty",
+	services.AlertProviderOpsgenie:   "Opsgenie",
+	services.AlertProviderOpsgenieEU: "Opsgenie (EU)",
+}
+
+// AddAlertDestination pages a PagerDuty service or Opsgenie for the active
+// organization's offline hosts, status alerts, or both.
+func (h *Handlers) AddAlertDestination(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	activeOrg := GetOrganizationFromContext(ctx)
+	if activeOrg == nil {
+		slog.ErrorContext(ctx, "missing active organization in context")
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		h.renderSettings(w, r, http.StatusUnprocessableEntity, settingsErrors{paging: "Invalid form data"})
+		return
+	}
+
+	fields := validate.Errors{}
+	d := services.AlertDestination{
+		OrganizationID: activeOrg.ID,
+		Name:           r.FormValue("name"),
+		Provider:       r.FormValue("provider"),
+		StatusAlerts:   r.FormValue("status_alerts") != "",
+	}
+	if v := strings.TrimSpace(r.FormValue("offline_hours")); v != "" {
+		hours, err := strconv.Atoi(v)
+		if err != nil {
+			fields.Add("offline_hours", services.ErrInvalidAlertOfflineHours.Error())
+		}
+		d.OfflineHours = hours
+	}
+	if v := r.FormValue("host_group"); v != "" {
+		groupID, err := uuid.Parse(v)
+		if err != nil {
+			fields.Add("host_group", services.ErrAlertHostGroupNotFound.Error())
+		}
+		d.HostGroupID = &groupID
+	}
+	if len(fields) > 0 {
+		h.renderSettings(w, r, http.StatusUnprocessableEntity, settingsErrors{pagingFields: fields})
+		return
+	}
+
+	dest, err := h.paging.Add(ctx, d, r.FormValue("key"))
+	if err != nil {
+		switch {
+		case errors.Is(err, services.ErrInvalidAlertDestinationName), errors.Is(err, services.ErrDuplicateAlertDestination):
+			fields.Add("name", err.Error())
+		case errors.Is(err, services.ErrInvalidAlertProvider):
+			fields.Add("provider", err.Error())
+		case errors.Is(err, services.ErrInvalidPagerDutyKey), errors.Is(err, services.ErrInvalidOpsgenieKey):
+			fields.Add("key", err.Error())
+		case errors.Is(err, services.ErrInvalidAlertOfflineHours), errors.Is(err, services.ErrAlertDestinationNoTrigger):
+			fields.Add("offline_hours", err.Error())
+		case errors.Is(err, services.ErrAlertHostGroupNotFound):
+			fields.Add("host_group", err.Error())
+		}
+		if len(fields) > 0 {
+			h.renderSettings(w, r, http.StatusUnprocessableEntity, settingsErrors{pagingFields: fields})
+			return
+		}
+		slog.ErrorContext(ctx, "failed to add alert destination", "error", err)
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+	slog.InfoContext(ctx, "alert destination added",
+		"organization_id", activeOrg.ID,
+		"id", dest.ID,
+		"provider", dest.Provider,
+		"offline_hours", dest.OfflineHours,
+		"status_alerts", dest.StatusAlerts,
+	)
+
+	http.Redirect(w, r, "/organization/settings", http.StatusSeeOther)
+}
+
+// DeleteAlertDestination removes one of the active organization's paging
+// destinations. Its incidents still open at the provider stay open there.
+func (h *Handlers) DeleteAlertDestination(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	activeOrg := GetOrganizationFromContext(ctx)
+	if activeOrg == nil {
+		slog.ErrorContext(ctx, "missing active organization in context")
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	id, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+		return
+	}
+	if err := h.paging.Delete(ctx, activeOrg.ID, id); err != nil {
+		if errors.Is(err, services.ErrAlertDestinationNotFound) {
+			http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+			return
+		}
+		slog.ErrorContext(ctx, "failed to delete alert destination", "error", err)
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+	slog.InfoContext(ctx, "alert destination deleted", "organization_id", activeOrg.ID, "id", id)
+
+	http.Redirect(w, r, "/organization/settings", http.StatusSeeOther)
+}
@@ -0,0 +1,216 @@
+package organization
+
+import (
+	"errors"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strings"
+
+	notificationServices "github.com/cavenine/queryops/features/notification/services"
+	"github.com/cavenine/queryops/features/organization/services"
+	"github.com/cavenine/queryops/internal/validate"
+)
+
+// notificationKindLabels names the notifications an organization can mute for
+// the settings page.
+var notificationKindLabels = map[string]string{
+	notificationServices.KindCampaignFinished: "Campaign finished",
+	notificationServices.KindHostOffline:      "Host went offline",
+	notificationServices.KindStatusAlert:      "Status log alert",
+	notificationServices.KindResultAnomaly:    "Unusual query results",
+	notificationServices.KindWebhookFailed:    "Webhook delivery failed",
+}
+
+// RenameOrganization changes the active organization's name.
+func (h *Handlers) RenameOrganization(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	activeOrg := GetOrganizationFromContext(ctx)
+	if activeOrg == nil {
+		slog.ErrorContext(ctx, "missing active organization in context")
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		h.renderSettings(w, r, http.StatusUnprocessableEntity, settingsErrors{general: "Invalid form data"})
+		return
+	}
+	name := strings.TrimSpace(r.FormValue("name"))
+	fields := validate.Errors{}
+	fields.Field("name", name, validate.Required(), validate.MaxLength(100))
+	if len(fields) > 0 {
+		h.renderSettings(w, r, http.StatusUnprocessableEntity, settingsErrors{generalFields: fields})
+		return
+	}
+
+	if err := h.orgService.Rename(ctx, activeOrg.ID, name); err != nil {
+		if errors.Is(err, services.ErrDuplicateOrganization) {
+			fields.Add("name", err.Error())
+			h.renderSettings(w, r, http.StatusUnprocessableEntity, settingsErrors{generalFields: fields})
+			return
+		}
+		slog.ErrorContext(ctx, "failed to rename organization", "error", err)
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+	slog.InfoContext(ctx, "organization renamed", "organization_id", activeOrg.ID, "name", name)
+
+	http.Redirect(w, r, "/organization/settings", http.StatusSeeOther)
+}
+
+// SaveOrganizationDefaults sets how long the active organization keeps
+// scheduled query results and status logs, when its finished campaigns are
+// archived, the osquery intervals of the default config its hosts use, and
+// how its enrolling hosts are matched to host records. Blank fields keep the
+// built-in values.
+func (h *Handlers) SaveOrganizationDefaults(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	activeOrg := GetOrganizationFromContext(ctx)
+	if activeOrg == nil {
+		slog.ErrorContext(ctx, "missing active organization in context")
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		h.renderSettings(w, r, http.StatusUnprocessableEntity, settingsErrors{defaults: "Invalid form data"})
+		return
+	}
+	fields := validate.Errors{}
+	optional := func(field string) *int {
+		n := fields.NonNegativeInt(field, r.FormValue(field))
+		if n == 0 {
+			return nil
+		}
+		return &n
+	}
+	settings := services.OrganizationSettings{
+		OrganizationID:      activeOrg.ID,
+		ResultRetentionDays: optional("retention_days"),
+		CampaignArchiveDays: optional("campaign_archive_days"),
+		DistributedInterval: optional("distributed_interval"),
+		ConfigRefresh:       optional("config_refresh"),
+		LoggerTLSPeriod:     optional("logger_tls_period"),
+		HostIdentity:        r.FormValue("host_identity"),
+	}
+	if len(fields) > 0 {
+		h.renderSettings(w, r, http.StatusUnprocessableEntity, settingsErrors{defaultsFields: fields})
+		return
+	}
+
+	if err := h.settings.SaveDefaults(ctx, settings); err != nil {
+		if fields, ok := validate.As(err); ok {
+			h.renderSettings(w, r, http.StatusUnprocessableEntity, settingsErrors{defaultsFields: fields})
+			return
+		}
+		slog.ErrorContext(ctx, "failed to save organization defaults", "error", err)
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+	slog.InfoContext(ctx, "organization defaults saved", "organization_id", activeOrg.ID)
+
+	http.Redirect(w, r, "/organization/settings", http.StatusSeeOther)
+}
+
+// SaveNotificationPreferences mutes the notifications left unchecked for the
+// active organization's members. Unknown kinds in the form are ignored.
+func (h *Handlers) SaveNotificationPreferences(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	activeOrg := GetOrganizationFromContext(ctx)
+	if activeOrg == nil {
+		slog.ErrorContext(ctx, "missing active organization in context")
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		h.renderSettings(w, r, http.StatusUnprocessableEntity, settingsErrors{notifications: "Invalid form data"})
+		return
+	}
+	enabled := r.Form["notify"]
+	muted := []string{}
+	for _, kind := range notificationServices.Kinds {
+		if !slices.Contains(enabled, kind) {
+			muted = append(muted, kind)
+		}
+	}
+
+	if err := h.settings.SetMutedNotifications(ctx, activeOrg.ID, muted); err != nil {
+		slog.ErrorContext(ctx, "failed to save notification preferences", "error", err)
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+	slog.InfoContext(ctx, "notification preferences saved",
+		"organization_id", activeOrg.ID,
+		"muted", muted,
+	)
+
+	http.Redirect(w, r, "/organization/settings", http.StatusSeeOther)
+}
+
+// SaveDecorators sets the decorators added to every config the active
+// organization's hosts are served, and the tag the org_tag decorator adds.
+func (h *Handlers) SaveDecorators(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	activeOrg := GetOrganizationFromContext(ctx)
+	if activeOrg == nil {
+		slog.ErrorContext(ctx, "missing active organization in context")
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		h.renderSettings(w, r, http.StatusUnprocessableEntity, settingsErrors{decorators: "Invalid form data"})
+		return
+	}
+	decorators := r.Form["decorator"]
+	tag := strings.TrimSpace(r.FormValue("decorator_tag"))
+
+	if err := h.settings.SetDecorators(ctx, activeOrg.ID, decorators, tag); err != nil {
+		if fields, ok := validate.As(err); ok {
+			h.renderSettings(w, r, http.StatusUnprocessableEntity, settingsErrors{decoratorFields: fields})
+			return
+		}
+		slog.ErrorContext(ctx, "failed to save decorators", "error", err)
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+	slog.InfoContext(ctx, "decorators saved",
+		"organization_id", activeOrg.ID,
+		"decorators", decorators,
+	)
+
+	http.Redirect(w, r, "/organization/settings", http.StatusSeeOther)
+}
+
+// DeleteOrganization deletes the active organization and everything in it once
+// the owner has typed its name, then clears it from the session.
+func (h *Handlers) DeleteOrganization(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	activeOrg := GetOrganizationFromContext(ctx)
+	if activeOrg == nil {
+		slog.ErrorContext(ctx, "missing active organization in context")
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		h.renderSettings(w, r, http.StatusUnprocessableEntity, settingsErrors{danger: "Invalid form data"})
+		return
+	}
+	fields := validate.Errors{}
+	fields.Check(strings.TrimSpace(r.FormValue("confirm")) == activeOrg.Name, "confirm", "Type the organization's name to confirm")
+	if len(fields) > 0 {
+		h.renderSettings(w, r, http.StatusUnprocessableEntity, settingsErrors{dangerFields: fields})
+		return
+	}
+
+	if err := h.orgService.Delete(ctx, activeOrg.ID); err != nil {
+		slog.ErrorContext(ctx, "failed to delete organization", "error", err)
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+	slog.InfoContext(ctx, "organization deleted", "organization_id", activeOrg.ID, "name", activeOrg.Name)
+
+	// The next request defaults to another of the user's organizations, or
+	// onboarding if they have none left.
+	h.sessionManager.Remove(ctx, activeOrgIDKey)
+
+	http.Redirect(w, r, "/", http.StatusSeeOther)
+}
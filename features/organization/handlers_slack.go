@@ -0,0 +1,140 @@
+package organization
+
+import (
+	"errors"
+	"log/slog"
+	"net/http"
+	"strconv"
+
+	"github.com/cavenine/queryops/features/organization/services"
+	"github.com/cavenine/queryops/internal/notify"
+	"github.com/cavenine/queryops/internal/validate"
+	"github.com/go-chi/chi/v5"
+)
+
+// slackEventLabels names the events a Slack route can post for the settings
+// page.
+var slackEventLabels = map[string]string{
+	services.SlackEventCampaignFinished: "Campaign finished",
+	services.SlackEventHostEnrolled:     "Host enrolled",
+	services.SlackEventHostOffline:      "Host went offline",
+	services.SlackEventStatusAlert:      "Status log alert",
+	services.SlackEventResultAnomaly:    "Unusual query results",
+}
+
+// SaveSlackIntegration sets the active organization's Slack webhook and bot
+// token. A blank token keeps the saved one.
+func (h *Handlers) SaveSlackIntegration(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	activeOrg := GetOrganizationFromContext(ctx)
+	if activeOrg == nil {
+		slog.ErrorContext(ctx, "missing active organization in context")
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		h.renderSettings(w, r, http.StatusUnprocessableEntity, settingsErrors{slack: "Invalid form data"})
+		return
+	}
+
+	removeToken := r.FormValue("remove_bot_token") != ""
+	fields := validate.Errors{}
+	err := h.slack.SaveIntegration(ctx, activeOrg.ID, r.FormValue("webhook_url"), r.FormValue("bot_token"), removeToken)
+	if err != nil {
+		switch {
+		case errors.Is(err, notify.ErrInvalidWebhookURL), errors.Is(err, notify.ErrDisallowedWebhookAddress):
+			fields.Add("webhook_url", err.Error())
+		case errors.Is(err, notify.ErrInvalidSlackToken):
+			fields.Add("bot_token", err.Error())
+		}
+		if len(fields) > 0 {
+			h.renderSettings(w, r, http.StatusUnprocessableEntity, settingsErrors{slackFields: fields})
+			return
+		}
+		slog.ErrorContext(ctx, "failed to save slack integration", "error", err)
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+	slog.InfoContext(ctx, "slack integration saved",
+		"organization_id", activeOrg.ID,
+		"webhook", r.FormValue("webhook_url") != "",
+		"token_replaced", r.FormValue("bot_token") != "",
+		"token_removed", removeToken,
+	)
+
+	http.Redirect(w, r, "/organization/settings", http.StatusSeeOther)
+}
+
+// AddSlackRoute posts one of the active organization's events to a Slack
+// channel, or to the webhook's channel.
+func (h *Handlers) AddSlackRoute(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	activeOrg := GetOrganizationFromContext(ctx)
+	if activeOrg == nil {
+		slog.ErrorContext(ctx, "missing active organization in context")
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		h.renderSettings(w, r, http.StatusUnprocessableEntity, settingsErrors{slack: "Invalid form data"})
+		return
+	}
+
+	fields := validate.Errors{}
+	route, err := h.slack.AddRoute(ctx, activeOrg.ID, r.FormValue("event"), r.FormValue("channel"), r.FormValue("template"))
+	if err != nil {
+		switch {
+		case errors.Is(err, services.ErrInvalidSlackEvent):
+			fields.Add("event", err.Error())
+		case errors.Is(err, services.ErrInvalidSlackChannel), errors.Is(err, services.ErrDuplicateSlackRoute),
+			errors.Is(err, services.ErrSlackTokenRequired), errors.Is(err, services.ErrSlackWebhookRequired):
+			fields.Add("channel", err.Error())
+		case errors.Is(err, services.ErrInvalidSlackTemplate), errors.Is(err, services.ErrSlackTemplateTooLong):
+			fields.Add("template", err.Error())
+		}
+		if len(fields) > 0 {
+			h.renderSettings(w, r, http.StatusUnprocessableEntity, settingsErrors{slackFields: fields})
+			return
+		}
+		slog.ErrorContext(ctx, "failed to add slack route", "error", err)
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+	slog.InfoContext(ctx, "slack route added",
+		"organization_id", activeOrg.ID,
+		"event", route.Event,
+		"channel", route.Channel,
+	)
+
+	http.Redirect(w, r, "/organization/settings", http.StatusSeeOther)
+}
+
+// DeleteSlackRoute removes one of the active organization's Slack routes,
+// along with messages still queued for it.
+func (h *Handlers) DeleteSlackRoute(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	activeOrg := GetOrganizationFromContext(ctx)
+	if activeOrg == nil {
+		slog.ErrorContext(ctx, "missing active organization in context")
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	id, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+		return
+	}
+	if err := h.slack.DeleteRoute(ctx, activeOrg.ID, id); err != nil {
+		if errors.Is(err, services.ErrSlackRouteNotFound) {
+			http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+			return
+		}
+		slog.ErrorContext(ctx, "failed to delete slack route", "error", err)
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+	slog.InfoContext(ctx, "slack route deleted", "organization_id", activeOrg.ID, "id", id)
+
+	http.Redirect(w, r, "/organization/settings", http.StatusSeeOther)
+}
@@ -2,7 +2,9 @@ package organization
 
 import (
 	"context"
+	"fmt"
 	"net/http"
+	"slices"
 
 	"github.com/alexedwards/scs/v2"
 	"github.com/cavenine/queryops/features/auth"
@@ -26,6 +28,15 @@ func GetOrganizationFromContext(ctx context.Context) *services.Organization {
 	return org
 }
 
+// GetOrganizationIDFromContext returns the active organization's ID, or
+// uuid.Nil when there is none.
+func GetOrganizationIDFromContext(ctx context.Context) uuid.UUID {
+	if org := GetOrganizationFromContext(ctx); org != nil {
+		return org.ID
+	}
+	return uuid.Nil
+}
+
 func SetOrganizationInContext(ctx context.Context, org *services.Organization) context.Context {
 	return context.WithValue(ctx, organizationContextKey, org)
 }
@@ -96,3 +107,53 @@ func RequireOrganization(orgService *services.OrganizationService, sessionManage
 		})
 	}
 }
+
+// RequireRole rejects requests unless the user's role in the active
+// organization is one of roles. It must run after RequireOrganization.
+func RequireRole(roles ...string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			org := GetOrganizationFromContext(r.Context())
+			if org == nil || !slices.Contains(roles, org.Role) {
+				http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// featureLabels name plan features in messages.
+var featureLabels = map[string]string{
+	services.FeatureWebhooks:      "Webhooks",
+	services.FeatureResultExports: "Result exports",
+}
+
+// FeatureUnavailable explains that the organization's plan doesn't include
+// feature.
+func FeatureUnavailable(org *services.Organization, feature string) string {
+	label := featureLabels[feature]
+	if label == "" {
+		label = feature
+	}
+	return fmt.Sprintf("%s aren't included in the %s plan.", label, org.PlanName)
+}
+
+// RequireFeature rejects requests unless the active organization's plan
+// includes feature. It must run after RequireOrganization.
+func RequireFeature(feature string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			org := GetOrganizationFromContext(r.Context())
+			if org == nil || !org.HasFeature(feature) {
+				msg := http.StatusText(http.StatusForbidden)
+				if org != nil {
+					msg = FeatureUnavailable(org, feature)
+				}
+				http.Error(w, msg, http.StatusForbidden)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
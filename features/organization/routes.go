@@ -2,7 +2,10 @@ package organization
 
 import (
 	"github.com/alexedwards/scs/v2"
+	"github.com/cavenine/queryops/config"
 	"github.com/cavenine/queryops/features/organization/services"
+	"github.com/cavenine/queryops/internal/crypto"
+	"github.com/cavenine/queryops/internal/sharelink"
 	"github.com/go-chi/chi/v5"
 	"github.com/jackc/pgx/v5/pgxpool"
 )
@@ -10,16 +13,36 @@ import (
 type Feature struct {
 	service  *services.OrganizationService
 	handlers *Handlers
+	apiUsage *APIUsageMeter
 }
 
-func NewFeature(pool *pgxpool.Pool, sessionManager *scs.SessionManager) *Feature {
-	repo := services.NewOrganizationRepository(pool)
+// NewFeature wires the organization feature. jobs enqueues enrollment package
+// and organization export builds.
+func NewFeature(pool *pgxpool.Pool, sessionManager *scs.SessionManager, keys *crypto.Keyring, jobs services.JobInserter) *Feature {
+	repo := services.NewOrganizationRepository(pool, keys)
+	repo.SetDefaultPlan(config.Global.PlanDefault)
 	service := services.NewOrganizationService(repo)
 	handlers := NewHandlers(service, sessionManager)
+	handlers.quotas = NewQuotaRepository(pool)
+	handlers.networks = services.NewEnrollNetworkRepository(pool)
+	handlers.redactions = services.NewRedactionRuleRepository(pool)
+	handlers.alerts = services.NewStatusAlertRuleRepository(pool)
+	handlers.baselines = services.NewResultBaselineRuleRepository(pool)
+	handlers.digests = services.NewDigestRepository(pool)
+	handlers.slack = services.NewSlackRepository(pool, keys)
+	handlers.paging = services.NewAlertDestinationRepository(pool, keys)
+	handlers.settings = services.NewSettingsRepository(pool)
+	handlers.packages = services.NewEnrollmentPackageRepository(pool, keys, jobs)
+	handlers.exports = services.NewOrganizationExportRepository(pool, jobs)
+	handlers.exportLinks = sharelink.NewSigner(config.Global.SessionSecret, "organization-export")
+	apiUsage := services.NewAPIUsageRepository(pool)
+	handlers.apiUsage = apiUsage
+	handlers.tlsHostname = config.Global.OsqueryTLSHostname
 
 	return &Feature{
 		service:  service,
 		handlers: handlers,
+		apiUsage: newAPIUsageMeter(apiUsage, apiUsageFlushInterval),
 	}
 }
 
@@ -27,6 +50,28 @@ func (f *Feature) Service() *services.OrganizationService {
 	return f.service
 }
 
+// APIUsage returns the meter for API requests. The caller mounts its
+// Middleware, runs it, and closes it after the HTTP server stops.
+func (f *Feature) APIUsage() *APIUsageMeter {
+	return f.apiUsage
+}
+
+// NewQuotaRepository returns a quota repository whose defaults come from the
+// QUOTA_* configuration, following config reloads.
+func NewQuotaRepository(pool *pgxpool.Pool) *services.QuotaRepository {
+	repo := services.NewQuotaRepository(pool, defaultQuotas(config.Global))
+	config.OnReload(func(c *config.Config) { repo.SetDefaults(defaultQuotas(c)) })
+	return repo
+}
+
+func defaultQuotas(c *config.Config) services.Quotas {
+	return services.Quotas{
+		MaxHosts:                c.QuotaMaxHosts,
+		MaxCampaignsPerDay:      c.QuotaMaxCampaignsPerDay,
+		MaxResultLogBytesPerDay: c.QuotaMaxResultLogBytesPerDay,
+	}
+}
+
 func (f *Feature) SetupOnboardingRoutes(r chi.Router) {
 	r.Route("/onboarding", func(r chi.Router) {
 		r.Get("/create-org", f.handlers.CreateOrgPage)
@@ -38,3 +83,45 @@ func (f *Feature) SetupOnboardingRoutes(r chi.Router) {
 		r.Post("/switch", f.handlers.SwitchOrganization)
 	})
 }
+
+// SetupSettingsRoutes registers pages that require an active organization.
+// Any member can view the settings page and download install files; changes
+// and organization exports need an owner or admin, and deleting the
+// organization needs an owner.
+// Integrations can only be added on a plan with webhooks, but can always be
+// removed.
+func (f *Feature) SetupSettingsRoutes(r chi.Router) {
+	r.Get("/organization/settings", f.handlers.SettingsPage)
+	r.Get("/organization/settings/install/{platform}/{file}", f.handlers.DownloadInstallFile)
+	r.Get("/organization/settings/packages/{id}/download", f.handlers.DownloadEnrollmentPackage)
+
+	r.Group(func(r chi.Router) {
+		r.Use(RequireRole(services.RoleOwner, services.RoleAdmin))
+		r.Post("/organization/settings/general", f.handlers.RenameOrganization)
+		r.Post("/organization/settings/defaults", f.handlers.SaveOrganizationDefaults)
+		r.Post("/organization/settings/notifications", f.handlers.SaveNotificationPreferences)
+		r.Post("/organization/settings/decorators", f.handlers.SaveDecorators)
+		r.Post("/organization/settings/enroll-networks", f.handlers.AddEnrollNetwork)
+		r.Post("/organization/settings/enroll-networks/{id}/delete", f.handlers.DeleteEnrollNetwork)
+		r.Post("/organization/settings/redaction-rules", f.handlers.AddRedactionRule)
+		r.Post("/organization/settings/redaction-rules/{id}/delete", f.handlers.DeleteRedactionRule)
+		r.Post("/organization/settings/status-alerts", f.handlers.AddStatusAlertRule)
+		r.Post("/organization/settings/status-alerts/{id}/delete", f.handlers.DeleteStatusAlertRule)
+		r.Post("/organization/settings/result-baselines", f.handlers.AddResultBaselineRule)
+		r.Post("/organization/settings/result-baselines/{id}/delete", f.handlers.DeleteResultBaselineRule)
+		r.Post("/organization/settings/digest", f.handlers.SaveDigestSettings)
+		r.Post("/organization/settings/slack/routes/{id}/delete", f.handlers.DeleteSlackRoute)
+		r.Post("/organization/settings/alert-destinations/{id}/delete", f.handlers.DeleteAlertDestination)
+		r.Group(func(r chi.Router) {
+			r.Use(RequireFeature(services.FeatureWebhooks))
+			r.Post("/organization/settings/slack", f.handlers.SaveSlackIntegration)
+			r.Post("/organization/settings/slack/routes", f.handlers.AddSlackRoute)
+			r.Post("/organization/settings/alert-destinations", f.handlers.AddAlertDestination)
+		})
+		r.Post("/organization/settings/packages", f.handlers.RequestEnrollmentPackage)
+		r.Post("/organization/settings/exports", f.handlers.RequestOrganizationExport)
+		r.Get("/organization/exports/{token}", f.handlers.DownloadOrganizationExport)
+	})
+
+	r.With(RequireRole(services.RoleOwner)).Post("/organization/settings/delete", f.handlers.DeleteOrganization)
+}
@@ -0,0 +1,468 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgxpool"
+
+	"github.com/cavenine/queryops/internal/crypto"
+)
+
+// Alert destination providers.
+const (
+	AlertProviderPagerDuty  = "pagerduty"
+	AlertProviderOpsgenie   = "opsgenie"
+	AlertProviderOpsgenieEU = "opsgenie_eu"
+)
+
+// AlertProviders lists the providers in the order the settings page offers
+// them.
+var AlertProviders = []string{AlertProviderPagerDuty, AlertProviderOpsgenie, AlertProviderOpsgenieEU}
+
+// Alert incident kinds.
+const (
+	IncidentHostOffline = "host_offline"
+	IncidentStatusAlert = "status_alert"
+)
+
+// MaxAlertOfflineHours caps a destination's offline threshold.
+const MaxAlertOfflineHours = 24 * 30
+
+var (
+	ErrInvalidAlertDestinationName = errors.New("name must be 1 to 100 characters")
+	ErrInvalidAlertProvider        = errors.New("choose PagerDuty or Opsgenie")
+	ErrInvalidPagerDutyKey         = errors.New("integration key must be the 32 characters from a PagerDuty Events API v2 integration")
+	ErrInvalidOpsgenieKey          = errors.New("API key must be the key of an Opsgenie API integration")
+	ErrInvalidAlertOfflineHours    = fmt.Errorf("offline hours must be between 0 and %d", MaxAlertOfflineHours)
+	ErrAlertDestinationNoTrigger   = errors.New("page for offline hosts, status alerts, or both")
+	ErrAlertHostGroupNotFound      = errors.New("host group not found")
+	ErrDuplicateAlertDestination   = errors.New("a destination with that name already exists")
+	ErrAlertDestinationNotFound    = errors.New("alert destination not found")
+)
+
+var (
+	pagerDutyKeyPattern = regexp.MustCompile(`^[A-Za-z0-9]{32}$`)
+	opsgenieKeyPattern  = regexp.MustCompile(`^[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}$`)
+)
+
+// AlertDestination opens incidents in a PagerDuty service or Opsgenie for an
+// organization's hosts that are offline longer than OfflineHours, if it's
+// set, and for status log alert rule matches, if StatusAlerts is. Only hosts
+// in HostGroupID are paged for when it's set.
+type AlertDestination struct {
+	ID             int64      `json:"id"`
+	OrganizationID uuid.UUID  `json:"organization_id"`
+	Name           string     `json:"name"`
+	Provider       string     `json:"provider"`
+	OfflineHours   int        `json:"offline_hours"`
+	StatusAlerts   bool       `json:"status_alerts"`
+	HostGroupID    *uuid.UUID `json:"host_group_id,omitempty"`
+	HostGroupName  *string    `json:"host_group_name,omitempty"`
+	// OpenIncidents counts incidents triggered and not yet resolved.
+	OpenIncidents int       `json:"open_incidents"`
+	CreatedAt     time.Time `json:"created_at"`
+}
+
+// AlertHostGroup is a host group a destination can be limited to.
+type AlertHostGroup struct {
+	ID   uuid.UUID
+	Name string
+}
+
+// AlertDelivery is an incident claimed for triggering or, if Resolve is set,
+// resolving, with the destination's decrypted key.
+type AlertDelivery struct {
+	ID              int64
+	DestinationID   int64
+	DestinationName string
+	OrganizationID  uuid.UUID
+	Provider        string
+	Key             string
+	Kind            string
+	DedupKey        string
+	Severity        string
+	Summary         string
+	Source          string
+	Link            *string
+	// Attempts counts this one.
+	Attempts int
+	Resolve  bool
+}
+
+// IncidentKey is the key the incident is known by to the provider.
+func (d AlertDelivery) IncidentKey() string {
+	return fmt.Sprintf("queryops:%d:%s", d.ID, d.DedupKey)
+}
+
+// AlertDestinationRepository stores organizations' paging destinations and
+// the incidents opened in them. Keys are encrypted with keys.
+type AlertDestinationRepository struct {
+	pool *pgxpool.Pool
+	keys *crypto.Keyring
+}
+
+func NewAlertDestinationRepository(pool *pgxpool.Pool, keys *crypto.Keyring) *AlertDestinationRepository {
+	return &AlertDestinationRepository{pool: pool, keys: keys}
+}
+
+// List returns the organization's destinations by name.
+func (r *AlertDestinationRepository) List(ctx context.Context, organizationID uuid.UUID) ([]AlertDestination, error) {
+	rows, err := r.pool.Query(ctx, `
+		SELECT d.id, d.organization_id, d.name, d.provider, d.offline_hours, d.status_alerts, d.host_group_id, g.name,
+			(SELECT COUNT(*) FROM alert_incidents i WHERE i.destination_id = d.id AND i.trigger_sent_at IS NOT NULL AND i.resolved_at IS NULL),
+			d.created_at
+		FROM alert_destinations d
+		LEFT JOIN host_groups g ON g.id = d.host_group_id
+		WHERE d.organization_id = $1
+		ORDER BY d.name
+	`, organizationID)
+	if err != nil {
+		return nil, fmt.Errorf("querying alert destinations: %w", err)
+	}
+	defer rows.Close()
+
+	var destinations []AlertDestination
+	for rows.Next() {
+		var d AlertDestination
+		if err := rows.Scan(&d.ID, &d.OrganizationID, &d.Name, &d.Provider, &d.OfflineHours, &d.StatusAlerts, &d.HostGroupID, &d.HostGroupName, &d.OpenIncidents, &d.CreatedAt); err != nil {
+			return nil, fmt.Errorf("scanning alert destination: %w", err)
+		}
+		destinations = append(destinations, d)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("iterating alert destinations: %w", err)
+	}
+	return destinations, nil
+}
+
+// ListHostGroups returns the organization's host groups by name, for
+// limiting a destination to one.
+func (r *AlertDestinationRepository) ListHostGroups(ctx context.Context, organizationID uuid.UUID) ([]AlertHostGroup, error) {
+	rows, err := r.pool.Query(ctx, `
+		SELECT id, name FROM host_groups WHERE organization_id = $1 ORDER BY name
+	`, organizationID)
+	if err != nil {
+		return nil, fmt.Errorf("querying host groups: %w", err)
+	}
+	groups, err := pgx.CollectRows(rows, pgx.RowToStructByPos[AlertHostGroup])
+	if err != nil {
+		return nil, fmt.Errorf("querying host groups: %w", err)
+	}
+	return groups, nil
+}
+
+// Add validates and stores a destination for d.OrganizationID. key is the
+// PagerDuty integration key or Opsgenie API key.
+func (r *AlertDestinationRepository) Add(ctx context.Context, d AlertDestination, key string) (*AlertDestination, error) {
+	d.Name = strings.TrimSpace(d.Name)
+	if d.Name == "" || len(d.Name) > 100 {
+		return nil, ErrInvalidAlertDestinationName
+	}
+	key = strings.TrimSpace(key)
+	switch d.Provider {
+	case AlertProviderPagerDuty:
+		if !pagerDutyKeyPattern.MatchString(key) {
+			return nil, ErrInvalidPagerDutyKey
+		}
+	case AlertProviderOpsgenie, AlertProviderOpsgenieEU:
+		if !opsgenieKeyPattern.MatchString(key) {
+			return nil, ErrInvalidOpsgenieKey
+		}
+	default:
+		return nil, ErrInvalidAlertProvider
+	}
+	if d.OfflineHours < 0 || d.OfflineHours > MaxAlertOfflineHours {
+		return nil, ErrInvalidAlertOfflineHours
+	}
+	if d.OfflineHours == 0 && !d.StatusAlerts {
+		return nil, ErrAlertDestinationNoTrigger
+	}
+	if d.HostGroupID != nil {
+		var name string
+		err := r.pool.QueryRow(ctx, `
+			SELECT name FROM host_groups WHERE id = $1 AND organization_id = $2
+		`, *d.HostGroupID, d.OrganizationID).Scan(&name)
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, ErrAlertHostGroupNotFound
+		}
+		if err != nil {
+			return nil, fmt.Errorf("querying host group: %w", err)
+		}
+		d.HostGroupName = &name
+	}
+
+	ciphertext, err := r.keys.Encrypt([]byte(key), d.OrganizationID[:])
+	if err != nil {
+		return nil, fmt.Errorf("encrypting alert destination key: %w", err)
+	}
+	err = r.pool.QueryRow(ctx, `
+		INSERT INTO alert_destinations (organization_id, name, provider, key_ciphertext, offline_hours, status_alerts, host_group_id)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+		RETURNING id, created_at
+	`, d.OrganizationID, d.Name, d.Provider, ciphertext, d.OfflineHours, d.StatusAlerts, d.HostGroupID).Scan(&d.ID, &d.CreatedAt)
+	if err != nil {
+		var pgErr *pgconn.PgError
+		if errors.As(err, &pgErr) && pgErr.Code == "23505" {
+			return nil, ErrDuplicateAlertDestination
+		}
+		return nil, fmt.Errorf("inserting alert destination: %w", err)
+	}
+	return &d, nil
+}
+
+// Delete removes one of the organization's destinations and its incidents.
+// Incidents still open at the provider are left for it to resolve.
+func (r *AlertDestinationRepository) Delete(ctx context.Context, organizationID uuid.UUID, id int64) error {
+	tag, err := r.pool.Exec(ctx, `
+		DELETE FROM alert_destinations
+		WHERE organization_id = $1 AND id = $2
+	`, organizationID, id)
+	if err != nil {
+		return fmt.Errorf("deleting alert destination: %w", err)
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrAlertDestinationNotFound
+	}
+	return nil
+}
+
+// EvaluateIncidents opens an incident for every destination's condition that
+// holds without one, and resolves open incidents whose condition no longer
+// does. A host's status alert condition holds until a rule has gone
+// statusQuietFor without alerting about it. It returns how many incidents
+// were opened and resolved; delivering them is ClaimIncidents' job.
+func (r *AlertDestinationRepository) EvaluateIncidents(ctx context.Context, statusQuietFor time.Duration) (opened, resolved int, err error) {
+	tx, err := r.pool.Begin(ctx)
+	if err != nil {
+		return 0, 0, fmt.Errorf("evaluating alert incidents: begin transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	// The conditions that hold, as (destination, dedup key) pairs with what
+	// an incident for each needs.
+	const conditions = `
+		SELECT d.id AS destination_id, d.organization_id, 'host_offline' AS kind,
+			'host-offline:' || h.id AS dedup_key, h.id AS host_id, NULL::bigint AS rule_id,
+			'critical' AS severity,
+			format('%s has not checked in for over %s hours', h.host_identifier, d.offline_hours) AS summary,
+			h.host_identifier AS source
+		FROM alert_destinations d
+		JOIN hosts h ON h.organization_id = d.organization_id
+		WHERE d.offline_hours > 0
+			AND GREATEST(h.last_logger_at, h.last_seen_at) < NOW() - make_interval(hours => d.offline_hours)
+			AND (d.host_group_id IS NULL OR EXISTS (
+				SELECT 1 FROM host_group_members m WHERE m.group_id = d.host_group_id AND m.host_id = h.id
+			))
+		UNION ALL
+		SELECT d.id, d.organization_id, 'status_alert',
+			'status-alert:' || r.id || ':' || h.id, h.id, r.id,
+			CASE r.min_severity WHEN 3 THEN 'critical' WHEN 2 THEN 'error' WHEN 1 THEN 'warning' ELSE 'info' END,
+			format('Status alert on %s: %s', h.host_identifier, COALESCE(NULLIF(r.description, ''), NULLIF(r.pattern, ''), 'any message')),
+			h.host_identifier
+		FROM alert_destinations d
+		JOIN status_alert_rules r ON r.organization_id = d.organization_id
+		JOIN status_alert_hosts s ON s.rule_id = r.id
+		JOIN hosts h ON h.id = s.host_id
+		WHERE d.status_alerts
+			AND s.alerted_at > NOW() - make_interval(secs => $1)
+			AND (d.host_group_id IS NULL OR EXISTS (
+				SELECT 1 FROM host_group_members m WHERE m.group_id = d.host_group_id AND m.host_id = h.id
+			))
+	`
+
+	// An incident whose trigger was never sent has nothing to resolve at the
+	// provider.
+	tag, err := tx.Exec(ctx, `
+		WITH holding AS (`+conditions+`)
+		UPDATE alert_incidents i
+		SET resolved_at = NOW(),
+			resolve_sent_at = CASE WHEN i.trigger_sent_at IS NULL THEN NOW() END,
+			attempts = 0,
+			next_attempt_at = NOW(),
+			last_error = NULL
+		WHERE i.resolved_at IS NULL
+			AND NOT EXISTS (
+				SELECT 1 FROM holding c
+				WHERE c.destination_id = i.destination_id AND c.dedup_key = i.dedup_key
+			)
+	`, statusQuietFor.Seconds())
+	if err != nil {
+		return 0, 0, fmt.Errorf("evaluating alert incidents: resolving: %w", err)
+	}
+	resolved = int(tag.RowsAffected())
+
+	tag, err = tx.Exec(ctx, `
+		INSERT INTO alert_incidents (destination_id, organization_id, kind, dedup_key, host_id, rule_id, severity, summary, source, link)
+		SELECT destination_id, organization_id, kind, dedup_key, host_id, rule_id, severity, summary, source, '/hosts/' || host_id
+		FROM (`+conditions+`) c
+		ON CONFLICT (destination_id, dedup_key) WHERE resolved_at IS NULL DO NOTHING
+	`, statusQuietFor.Seconds())
+	if err != nil {
+		return 0, 0, fmt.Errorf("evaluating alert incidents: opening: %w", err)
+	}
+	opened = int(tag.RowsAffected())
+
+	if err := tx.Commit(ctx); err != nil {
+		return 0, 0, fmt.Errorf("evaluating alert incidents: commit transaction: %w", err)
+	}
+	return opened, resolved, nil
+}
+
+// ClaimIncidents claims up to limit incidents whose trigger or resolve is
+// due and has been attempted fewer than maxAttempts times, oldest first.
+// Claiming counts an attempt and puts the next one retryAfter away, doubling
+// with each attempt.
+func (r *AlertDestinationRepository) ClaimIncidents(ctx context.Context, limit int, retryAfter time.Duration, maxAttempts int) ([]AlertDelivery, error) {
+	rows, err := r.pool.Query(ctx, `
+		WITH claimed AS (
+			UPDATE alert_incidents i
+			SET attempts = i.attempts + 1,
+				next_attempt_at = NOW() + make_interval(secs => $2 * power(2, i.attempts))
+			WHERE i.id IN (
+				SELECT id
+				FROM alert_incidents
+				WHERE resolve_sent_at IS NULL
+					AND ((resolved_at IS NULL AND trigger_sent_at IS NULL) OR (resolved_at IS NOT NULL AND trigger_sent_at IS NOT NULL))
+					AND attempts < $3 AND next_attempt_at <= NOW()
+				ORDER BY id
+				LIMIT $1
+				FOR UPDATE SKIP LOCKED
+			)
+			RETURNING i.id, i.destination_id, i.organization_id, i.kind, i.dedup_key, i.severity, i.summary, i.source, i.link,
+				i.attempts, i.resolved_at IS NOT NULL AS resolve
+		)
+		SELECT c.id, c.destination_id, d.name, c.organization_id, d.provider, d.key_ciphertext, c.kind, c.dedup_key,
+			c.severity, c.summary, c.source, c.link, c.attempts, c.resolve
+		FROM claimed c
+		JOIN alert_destinations d ON d.id = c.destination_id
+		ORDER BY c.id
+	`, limit, retryAfter.Seconds(), maxAttempts)
+	if err != nil {
+		return nil, fmt.Errorf("claiming alert incidents: %w", err)
+	}
+	defer rows.Close()
+
+	var deliveries []AlertDelivery
+	for rows.Next() {
+		var (
+			d          AlertDelivery
+			ciphertext string
+		)
+		if err := rows.Scan(&d.ID, &d.DestinationID, &d.DestinationName, &d.OrganizationID, &d.Provider, &ciphertext, &d.Kind, &d.DedupKey,
+			&d.Severity, &d.Summary, &d.Source, &d.Link, &d.Attempts, &d.Resolve); err != nil {
+			return nil, fmt.Errorf("scanning alert incident: %w", err)
+		}
+		key, err := r.keys.Decrypt(ciphertext, d.OrganizationID[:])
+		if err != nil {
+			return nil, fmt.Errorf("decrypting alert destination key: %w", err)
+		}
+		d.Key = string(key)
+		deliveries = append(deliveries, d)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("claiming alert incidents: %w", err)
+	}
+	return deliveries, nil
+}
+
+// MarkIncidentSent records that a claimed incident's trigger, or resolve if
+// resolve is set, reached the provider.
+func (r *AlertDestinationRepository) MarkIncidentSent(ctx context.Context, id int64, resolve bool) error {
+	column := "trigger_sent_at"
+	if resolve {
+		column = "resolve_sent_at"
+	}
+	if _, err := r.pool.Exec(ctx, `
+		UPDATE alert_incidents
+		SET `+column+` = NOW(), attempts = 0, next_attempt_at = NOW(), last_error = NULL
+		WHERE id = $1
+	`, id); err != nil {
+		return fmt.Errorf("marking alert incident sent: %w", err)
+	}
+	return nil
+}
+
+// MarkIncidentFailed records why a claimed incident couldn't be sent. It's
+// retried when next due, if it has attempts left.
+func (r *AlertDestinationRepository) MarkIncidentFailed(ctx context.Context, id int64, reason string) error {
+	if _, err := r.pool.Exec(ctx, `
+		UPDATE alert_incidents SET last_error = $2 WHERE id = $1
+	`, id, reason); err != nil {
+		return fmt.Errorf("marking alert incident failed: %w", err)
+	}
+	return nil
+}
+
+// RotateKeys re-encrypts up to batchSize destination keys stored under a key
+// other than the keyring's primary, and returns how many it rewrote. Call it
+// until it returns 0.
+func (r *AlertDestinationRepository) RotateKeys(ctx context.Context, batchSize int) (int, error) {
+	tx, err := r.pool.Begin(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("starting transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	rows, err := tx.Query(ctx, `
+		SELECT id, organization_id, key_ciphertext
+		FROM alert_destinations
+		WHERE split_part(key_ciphertext, ':', 2) <> $1
+		ORDER BY id
+		LIMIT $2
+		FOR UPDATE SKIP LOCKED
+	`, r.keys.PrimaryID(), batchSize)
+	if err != nil {
+		return 0, fmt.Errorf("querying alert destination keys to rotate: %w", err)
+	}
+	type rotation struct {
+		id         int64
+		ciphertext string
+	}
+	var rotations []rotation
+	for rows.Next() {
+		var (
+			id         int64
+			orgID      uuid.UUID
+			ciphertext string
+		)
+		if err := rows.Scan(&id, &orgID, &ciphertext); err != nil {
+			rows.Close()
+			return 0, fmt.Errorf("scanning alert destination key: %w", err)
+		}
+		key, err := r.keys.Decrypt(ciphertext, orgID[:])
+		if err != nil {
+			rows.Close()
+			return 0, fmt.Errorf("decrypting alert destination key: %w", err)
+		}
+		rotated, err := r.keys.Encrypt(key, orgID[:])
+		if err != nil {
+			rows.Close()
+			return 0, fmt.Errorf("encrypting alert destination key: %w", err)
+		}
+		rotations = append(rotations, rotation{id: id, ciphertext: rotated})
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, fmt.Errorf("iterating alert destination keys: %w", err)
+	}
+
+	for _, rot := range rotations {
+		if _, err := tx.Exec(ctx, `
+			UPDATE alert_destinations SET key_ciphertext = $2 WHERE id = $1
+		`, rot.id, rot.ciphertext); err != nil {
+			return 0, fmt.Errorf("updating alert destination key: %w", err)
+		}
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return 0, fmt.Errorf("committing alert destination keys: %w", err)
+	}
+	return len(rotations), nil
+}
@@ -0,0 +1,164 @@
+package services_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+
+	orgservices "github.com/cavenine/queryops/features/organization/services"
+	"github.com/cavenine/queryops/internal/testdb"
+	"github.com/cavenine/queryops/internal/testdb/fixtures"
+)
+
+func TestAlertDestinationRepository(t *testing.T) {
+	tdb := testdb.SetupTestDB(t)
+	ctx := context.Background()
+
+	orgID := fixtures.CreateOrg(t, tdb.Pool, "paging-org").ID
+	otherOrgID := fixtures.CreateOrg(t, tdb.Pool, "other-org").ID
+	repo := orgservices.NewAlertDestinationRepository(tdb.Pool, testKeyring(t))
+
+	critical := fixtures.CreateHost(t, tdb.Pool, orgID, "db-1")
+	fixtures.CreateHost(t, tdb.Pool, orgID, "web-1")
+	var groupID uuid.UUID
+	if err := tdb.Pool.QueryRow(ctx, `
+		INSERT INTO host_groups (organization_id, name) VALUES ($1, 'critical') RETURNING id
+	`, orgID).Scan(&groupID); err != nil {
+		t.Fatalf("creating host group: %v", err)
+	}
+	if _, err := tdb.Pool.Exec(ctx, `
+		INSERT INTO host_group_members (group_id, host_id) VALUES ($1, $2)
+	`, groupID, critical.ID); err != nil {
+		t.Fatalf("adding host group member: %v", err)
+	}
+	var otherGroupID uuid.UUID
+	if err := tdb.Pool.QueryRow(ctx, `
+		INSERT INTO host_groups (organization_id, name) VALUES ($1, 'theirs') RETURNING id
+	`, otherOrgID).Scan(&otherGroupID); err != nil {
+		t.Fatalf("creating other host group: %v", err)
+	}
+
+	pdKey := "0123456789abcdef0123456789ABCDEF"
+	valid := orgservices.AlertDestination{OrganizationID: orgID, Name: "On-call", Provider: orgservices.AlertProviderPagerDuty, OfflineHours: 2, HostGroupID: &groupID}
+	for _, tc := range []struct {
+		name string
+		edit func(d *orgservices.AlertDestination)
+		key  string
+		want error
+	}{
+		{"blank name", func(d *orgservices.AlertDestination) { d.Name = " " }, pdKey, orgservices.ErrInvalidAlertDestinationName},
+		{"unknown provider", func(d *orgservices.AlertDestination) { d.Provider = "victorops" }, pdKey, orgservices.ErrInvalidAlertProvider},
+		{"short pagerduty key", func(d *orgservices.AlertDestination) {}, "abc", orgservices.ErrInvalidPagerDutyKey},
+		{"opsgenie key not a uuid", func(d *orgservices.AlertDestination) { d.Provider = orgservices.AlertProviderOpsgenie }, pdKey, orgservices.ErrInvalidOpsgenieKey},
+		{"offline hours too high", func(d *orgservices.AlertDestination) { d.OfflineHours = orgservices.MaxAlertOfflineHours + 1 }, pdKey, orgservices.ErrInvalidAlertOfflineHours},
+		{"nothing to page for", func(d *orgservices.AlertDestination) { d.OfflineHours = 0 }, pdKey, orgservices.ErrAlertDestinationNoTrigger},
+		{"other org's group", func(d *orgservices.AlertDestination) { d.HostGroupID = &otherGroupID }, pdKey, orgservices.ErrAlertHostGroupNotFound},
+	} {
+		d := valid
+		tc.edit(&d)
+		if _, err := repo.Add(ctx, d, tc.key); !errors.Is(err, tc.want) {
+			t.Errorf("Add(%s) err = %v, want %v", tc.name, err, tc.want)
+		}
+	}
+
+	dest, err := repo.Add(ctx, valid, " "+pdKey+" ")
+	if err != nil {
+		t.Fatalf("Add: %v", err)
+	}
+	if dest.HostGroupName == nil || *dest.HostGroupName != "critical" {
+		t.Fatalf("Add host group name = %v", dest.HostGroupName)
+	}
+	if _, err := repo.Add(ctx, valid, pdKey); !errors.Is(err, orgservices.ErrDuplicateAlertDestination) {
+		t.Fatalf("Add(duplicate) err = %v", err)
+	}
+
+	// Both hosts are offline, but only db-1 is in the destination's group.
+	if _, err := tdb.Pool.Exec(ctx, `
+		UPDATE hosts SET last_logger_at = NOW() - INTERVAL '3 hours' WHERE organization_id = $1
+	`, orgID); err != nil {
+		t.Fatalf("backdating check-ins: %v", err)
+	}
+	opened, resolved, err := repo.EvaluateIncidents(ctx, time.Hour)
+	if err != nil || opened != 1 || resolved != 0 {
+		t.Fatalf("EvaluateIncidents = %d opened, %d resolved, %v; want 1, 0", opened, resolved, err)
+	}
+	// An open incident isn't opened again.
+	if opened, _, err := repo.EvaluateIncidents(ctx, time.Hour); err != nil || opened != 0 {
+		t.Fatalf("EvaluateIncidents again = %d opened, %v; want 0", opened, err)
+	}
+
+	deliveries, err := repo.ClaimIncidents(ctx, 10, time.Minute, 5)
+	if err != nil || len(deliveries) != 1 {
+		t.Fatalf("ClaimIncidents = %+v, %v; want 1", deliveries, err)
+	}
+	trigger := deliveries[0]
+	if trigger.Resolve || trigger.Key != pdKey || trigger.Source != "db-1" || trigger.Severity != "critical" ||
+		trigger.Kind != orgservices.IncidentHostOffline || trigger.Attempts != 1 {
+		t.Fatalf("trigger = %+v", trigger)
+	}
+	if trigger.Link == nil || *trigger.Link != "/hosts/"+critical.ID.String() {
+		t.Fatalf("trigger link = %v", trigger.Link)
+	}
+	// A claimed incident isn't due again until it's retried.
+	if deliveries, err := repo.ClaimIncidents(ctx, 10, time.Minute, 5); err != nil || len(deliveries) != 0 {
+		t.Fatalf("ClaimIncidents while claimed = %+v, %v; want none", deliveries, err)
+	}
+	if err := repo.MarkIncidentSent(ctx, trigger.ID, false); err != nil {
+		t.Fatalf("MarkIncidentSent: %v", err)
+	}
+	destinations, err := repo.List(ctx, orgID)
+	if err != nil || len(destinations) != 1 || destinations[0].OpenIncidents != 1 {
+		t.Fatalf("List = %+v, %v; want one destination with an open incident", destinations, err)
+	}
+	if deliveries, err := repo.ClaimIncidents(ctx, 10, time.Minute, 5); err != nil || len(deliveries) != 0 {
+		t.Fatalf("ClaimIncidents after trigger sent = %+v, %v; want none", deliveries, err)
+	}
+
+	// Checking in resolves the incident, and the resolve is then due.
+	if _, err := tdb.Pool.Exec(ctx, `UPDATE hosts SET last_logger_at = NOW() WHERE id = $1`, critical.ID); err != nil {
+		t.Fatalf("checking in: %v", err)
+	}
+	if opened, resolved, err := repo.EvaluateIncidents(ctx, time.Hour); err != nil || opened != 0 || resolved != 1 {
+		t.Fatalf("EvaluateIncidents after check-in = %d opened, %d resolved, %v; want 0, 1", opened, resolved, err)
+	}
+	deliveries, err = repo.ClaimIncidents(ctx, 10, time.Minute, 5)
+	if err != nil || len(deliveries) != 1 || !deliveries[0].Resolve || deliveries[0].IncidentKey() != trigger.IncidentKey() {
+		t.Fatalf("ClaimIncidents after resolve = %+v, %v; want the resolve", deliveries, err)
+	}
+	if err := repo.MarkIncidentSent(ctx, deliveries[0].ID, true); err != nil {
+		t.Fatalf("MarkIncidentSent(resolve): %v", err)
+	}
+	if destinations, _ := repo.List(ctx, orgID); destinations[0].OpenIncidents != 0 {
+		t.Fatalf("OpenIncidents after resolve = %d", destinations[0].OpenIncidents)
+	}
+
+	// An incident that clears before its trigger is sent is never sent.
+	if _, err := tdb.Pool.Exec(ctx, `UPDATE hosts SET last_logger_at = NOW() - INTERVAL '3 hours' WHERE id = $1`, critical.ID); err != nil {
+		t.Fatalf("backdating check-in: %v", err)
+	}
+	if opened, _, err := repo.EvaluateIncidents(ctx, time.Hour); err != nil || opened != 1 {
+		t.Fatalf("EvaluateIncidents on a new outage = %d opened, %v; want 1", opened, err)
+	}
+	if _, err := tdb.Pool.Exec(ctx, `UPDATE hosts SET last_logger_at = NOW() WHERE id = $1`, critical.ID); err != nil {
+		t.Fatalf("checking in: %v", err)
+	}
+	if _, resolved, err := repo.EvaluateIncidents(ctx, time.Hour); err != nil || resolved != 1 {
+		t.Fatalf("EvaluateIncidents before trigger sent = %d resolved, %v; want 1", resolved, err)
+	}
+	if deliveries, err := repo.ClaimIncidents(ctx, 10, time.Minute, 5); err != nil || len(deliveries) != 0 {
+		t.Fatalf("ClaimIncidents for an unsent incident = %+v, %v; want none", deliveries, err)
+	}
+
+	if err := repo.Delete(ctx, otherOrgID, dest.ID); !errors.Is(err, orgservices.ErrAlertDestinationNotFound) {
+		t.Fatalf("Delete from another org err = %v", err)
+	}
+	if err := repo.Delete(ctx, orgID, dest.ID); err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+	if destinations, err := repo.List(ctx, orgID); err != nil || len(destinations) != 0 {
+		t.Fatalf("List after delete = %+v, %v", destinations, err)
+	}
+}
@@ -0,0 +1,159 @@
+package services
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// apiTokenPrefix marks API tokens so secret scanners can recognize them.
+const apiTokenPrefix = "qo_"
+
+var (
+	ErrAPITokenNotFound = errors.New("API token not found")
+	ErrAPITokenName     = errors.New("API token name is required")
+)
+
+// APIToken is a bearer token for the gRPC API, scoped to one organization.
+// Only its hash is stored.
+type APIToken struct {
+	ID             int64      `json:"id"`
+	OrganizationID uuid.UUID  `json:"organization_id"`
+	Name           string     `json:"name"`
+	CreatedBy      *int       `json:"created_by,omitempty"`
+	CreatedAt      time.Time  `json:"created_at"`
+	LastUsedAt     *time.Time `json:"last_used_at,omitempty"`
+	RevokedAt      *time.Time `json:"revoked_at,omitempty"`
+}
+
+type APITokenRepository struct {
+	pool *pgxpool.Pool
+}
+
+func NewAPITokenRepository(pool *pgxpool.Pool) *APITokenRepository {
+	return &APITokenRepository{pool: pool}
+}
+
+// apiTokenHash is how API tokens are stored and looked up. Tokens are
+// random, so an unsalted hash is enough.
+func apiTokenHash(token string) []byte {
+	sum := sha256.Sum256([]byte(token))
+	return sum[:]
+}
+
+// Create issues the organization a token and returns it with its plaintext,
+// which is not stored and can't be shown again.
+func (r *APITokenRepository) Create(ctx context.Context, organizationID uuid.UUID, name string, createdBy *int) (*APIToken, string, error) {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return nil, "", ErrAPITokenName
+	}
+
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return nil, "", fmt.Errorf("generating API token: %w", err)
+	}
+	token := apiTokenPrefix + base64.RawURLEncoding.EncodeToString(b)
+
+	t := &APIToken{}
+	err := r.pool.QueryRow(ctx, `
+		INSERT INTO organization_api_tokens (organization_id, token_hash, name, created_by)
+		VALUES ($1, $2, $3, $4)
+		RETURNING id, organization_id, name, created_by, created_at, last_used_at, revoked_at
+	`, organizationID, apiTokenHash(token), name, createdBy).
+		Scan(&t.ID, &t.OrganizationID, &t.Name, &t.CreatedBy, &t.CreatedAt, &t.LastUsedAt, &t.RevokedAt)
+	if err != nil {
+		return nil, "", fmt.Errorf("inserting API token: %w", err)
+	}
+	return t, token, nil
+}
+
+// List returns the organization's tokens, revoked ones included, newest
+// first.
+func (r *APITokenRepository) List(ctx context.Context, organizationID uuid.UUID) ([]APIToken, error) {
+	rows, err := r.pool.Query(ctx, `
+		SELECT id, organization_id, name, created_by, created_at, last_used_at, revoked_at
+		FROM organization_api_tokens
+		WHERE organization_id = $1
+		ORDER BY created_at DESC, id DESC
+	`, organizationID)
+	if err != nil {
+		return nil, fmt.Errorf("querying API tokens: %w", err)
+	}
+	defer rows.Close()
+
+	var tokens []APIToken
+	for rows.Next() {
+		var t APIToken
+		if err := rows.Scan(&t.ID, &t.OrganizationID, &t.Name, &t.CreatedBy, &t.CreatedAt, &t.LastUsedAt, &t.RevokedAt); err != nil {
+			return nil, fmt.Errorf("scanning API token: %w", err)
+		}
+		tokens = append(tokens, t)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("iterating API tokens: %w", err)
+	}
+	return tokens, nil
+}
+
+// Revoke stops one of the organization's tokens working. It returns
+// ErrAPITokenNotFound if the organization has no such unrevoked token.
+func (r *APITokenRepository) Revoke(ctx context.Context, organizationID uuid.UUID, id int64) error {
+	tag, err := r.pool.Exec(ctx, `
+		UPDATE organization_api_tokens
+		SET revoked_at = NOW()
+		WHERE organization_id = $1 AND id = $2 AND revoked_at IS NULL
+	`, organizationID, id)
+	if err != nil {
+		return fmt.Errorf("revoking API token: %w", err)
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrAPITokenNotFound
+	}
+	return nil
+}
+
+// Authenticate returns the organization token belongs to and records the
+// token's use, at most once a minute. It returns ErrAPITokenNotFound if the
+// token is unknown or revoked, or its organization is disabled.
+func (r *APITokenRepository) Authenticate(ctx context.Context, token string) (*Organization, error) {
+	rows, err := r.pool.Query(ctx, `
+		WITH token AS (
+			SELECT id, organization_id
+			FROM organization_api_tokens
+			WHERE token_hash = $1 AND revoked_at IS NULL
+		), touched AS (
+			UPDATE organization_api_tokens t
+			SET last_used_at = NOW()
+			FROM token
+			WHERE t.id = token.id
+				AND (t.last_used_at IS NULL OR t.last_used_at < NOW() - INTERVAL '1 minute')
+		)
+		SELECT o.id, o.name, o.created_at, o.updated_at, o.disabled_at, `+organizationPlanColumns+`
+		FROM token
+		JOIN organizations o ON o.id = token.organization_id
+		JOIN plans p ON p.id = o.plan_id
+		WHERE o.disabled_at IS NULL
+	`, apiTokenHash(token))
+	if err != nil {
+		return nil, fmt.Errorf("querying organization by API token: %w", err)
+	}
+
+	org, err := pgx.CollectOneRow(rows, pgx.RowToAddrOfStructByNameLax[Organization])
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, ErrAPITokenNotFound
+		}
+		return nil, fmt.Errorf("querying organization by API token: %w", err)
+	}
+	return org, nil
+}
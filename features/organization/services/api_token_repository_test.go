@@ -0,0 +1,73 @@
+package services_test
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	orgservices "github.com/cavenine/queryops/features/organization/services"
+	"github.com/cavenine/queryops/internal/testdb"
+	"github.com/cavenine/queryops/internal/testdb/fixtures"
+)
+
+func TestAPITokenRepository(t *testing.T) {
+	tdb := testdb.SetupTestDB(t)
+	ctx := context.Background()
+
+	orgID := fixtures.CreateOrg(t, tdb.Pool, "token-org").ID
+	otherOrgID := fixtures.CreateOrg(t, tdb.Pool, "other-org").ID
+	user := fixtures.CreateUser(t, tdb.Pool, "tokens@example.com")
+	repo := orgservices.NewAPITokenRepository(tdb.Pool)
+
+	if _, _, err := repo.Create(ctx, orgID, " ", nil); !errors.Is(err, orgservices.ErrAPITokenName) {
+		t.Fatalf("Create without a name err = %v", err)
+	}
+	created, token, err := repo.Create(ctx, orgID, " siem ", &user.ID)
+	if err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	if created.Name != "siem" || !strings.HasPrefix(token, "qo_") {
+		t.Fatalf("created = %+v, token %q", created, token)
+	}
+
+	org, err := repo.Authenticate(ctx, token)
+	if err != nil {
+		t.Fatalf("Authenticate: %v", err)
+	}
+	if org.ID != orgID || org.PlanID == "" {
+		t.Fatalf("Authenticate = %+v, want organization %s with its plan", org, orgID)
+	}
+	if _, err := repo.Authenticate(ctx, token+"x"); !errors.Is(err, orgservices.ErrAPITokenNotFound) {
+		t.Fatalf("Authenticate unknown token err = %v", err)
+	}
+
+	tokens, err := repo.List(ctx, orgID)
+	if err != nil {
+		t.Fatalf("List: %v", err)
+	}
+	if len(tokens) != 1 || tokens[0].LastUsedAt == nil || *tokens[0].CreatedBy != user.ID {
+		t.Fatalf("List = %+v, want the token marked used", tokens)
+	}
+
+	if err := repo.Revoke(ctx, otherOrgID, created.ID); !errors.Is(err, orgservices.ErrAPITokenNotFound) {
+		t.Fatalf("Revoke from other org err = %v", err)
+	}
+	if err := repo.Revoke(ctx, orgID, created.ID); err != nil {
+		t.Fatalf("Revoke: %v", err)
+	}
+	if _, err := repo.Authenticate(ctx, token); !errors.Is(err, orgservices.ErrAPITokenNotFound) {
+		t.Fatalf("Authenticate revoked token err = %v", err)
+	}
+
+	_, token, err = repo.Create(ctx, orgID, "second", nil)
+	if err != nil {
+		t.Fatalf("Create second: %v", err)
+	}
+	if _, err := tdb.Pool.Exec(ctx, `UPDATE organizations SET disabled_at = NOW() WHERE id = $1`, orgID); err != nil {
+		t.Fatalf("disabling organization: %v", err)
+	}
+	if _, err := repo.Authenticate(ctx, token); !errors.Is(err, orgservices.ErrAPITokenNotFound) {
+		t.Fatalf("Authenticate for disabled organization err = %v", err)
+	}
+}
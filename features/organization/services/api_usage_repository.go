@@ -0,0 +1,175 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// APIUsageRetention is how long hourly API usage is kept.
+const APIUsageRetention = 400 * 24 * time.Hour
+
+// APIUsage is a count of API requests and the bytes they carried.
+type APIUsage struct {
+	Requests      int64 `json:"requests"`
+	RequestBytes  int64 `json:"request_bytes"`
+	ResponseBytes int64 `json:"response_bytes"`
+}
+
+// Add returns the sum of u and o.
+func (u APIUsage) Add(o APIUsage) APIUsage {
+	return APIUsage{
+		Requests:      u.Requests + o.Requests,
+		RequestBytes:  u.RequestBytes + o.RequestBytes,
+		ResponseBytes: u.ResponseBytes + o.ResponseBytes,
+	}
+}
+
+// APIUsageSample is usage metered for one user in one organization since the
+// previous sample.
+type APIUsageSample struct {
+	OrganizationID uuid.UUID
+	UserID         int
+	RecordedAt     time.Time
+	APIUsage
+}
+
+// MemberAPIUsage is one user's API usage in an organization. Email is empty
+// once the user no longer exists.
+type MemberAPIUsage struct {
+	UserID int    `json:"user_id"`
+	Email  string `json:"email"`
+	APIUsage
+}
+
+// APIUsageRepository stores metered API usage. Samples are written as the web
+// process flushes them and rolled up into hourly totals by a periodic job;
+// reads cover both, so usage is current before the rollup runs.
+type APIUsageRepository struct {
+	pool *pgxpool.Pool
+}
+
+func NewAPIUsageRepository(pool *pgxpool.Pool) *APIUsageRepository {
+	return &APIUsageRepository{pool: pool}
+}
+
+// RecordAPIUsage saves samples.
+func (r *APIUsageRepository) RecordAPIUsage(ctx context.Context, samples []APIUsageSample) error {
+	if len(samples) == 0 {
+		return nil
+	}
+	_, err := r.pool.CopyFrom(
+		ctx,
+		pgx.Identifier{"api_usage_samples"},
+		[]string{"organization_id", "user_id", "recorded_at", "requests", "request_bytes", "response_bytes"},
+		pgx.CopyFromSlice(len(samples), func(i int) ([]any, error) {
+			s := samples[i]
+			return []any{s.OrganizationID, s.UserID, s.RecordedAt, s.Requests, s.RequestBytes, s.ResponseBytes}, nil
+		}),
+	)
+	if err != nil {
+		return fmt.Errorf("recording api usage: %w", err)
+	}
+	return nil
+}
+
+// RollupAPIUsage folds every sample into its organization, user and UTC hour,
+// then deletes hourly usage older than APIUsageRetention. It returns the
+// number of samples rolled up.
+func (r *APIUsageRepository) RollupAPIUsage(ctx context.Context, now time.Time) (int, error) {
+	tx, err := r.pool.Begin(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("starting api usage rollup: %w", err)
+	}
+	defer func() { _ = tx.Rollback(ctx) }()
+
+	var n int
+	err = tx.QueryRow(ctx, `
+		WITH moved AS (
+			DELETE FROM api_usage_samples
+			RETURNING organization_id, user_id, recorded_at, requests, request_bytes, response_bytes
+		), rolled AS (
+			INSERT INTO api_usage_hourly (organization_id, user_id, hour, requests, request_bytes, response_bytes)
+			SELECT organization_id, user_id, date_trunc('hour', recorded_at, 'UTC'),
+				SUM(requests), SUM(request_bytes), SUM(response_bytes)
+			FROM moved
+			GROUP BY 1, 2, 3
+			ON CONFLICT (organization_id, user_id, hour)
+			DO UPDATE SET requests = api_usage_hourly.requests + EXCLUDED.requests,
+				request_bytes = api_usage_hourly.request_bytes + EXCLUDED.request_bytes,
+				response_bytes = api_usage_hourly.response_bytes + EXCLUDED.response_bytes
+		)
+		SELECT COUNT(*) FROM moved
+	`).Scan(&n)
+	if err != nil {
+		return 0, fmt.Errorf("rolling up api usage: %w", err)
+	}
+
+	if _, err := tx.Exec(ctx, `DELETE FROM api_usage_hourly WHERE hour < $1`, now.Add(-APIUsageRetention)); err != nil {
+		return 0, fmt.Errorf("pruning api usage: %w", err)
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return 0, fmt.Errorf("committing api usage rollup: %w", err)
+	}
+	return n, nil
+}
+
+// GetAPIUsage returns the organization's API usage since since, rounded down
+// to the hour. Plan limits on API use should be checked against this.
+func (r *APIUsageRepository) GetAPIUsage(ctx context.Context, organizationID uuid.UUID, since time.Time) (APIUsage, error) {
+	var u APIUsage
+	err := r.pool.QueryRow(ctx, `
+		SELECT COALESCE(SUM(requests), 0)::bigint, COALESCE(SUM(request_bytes), 0)::bigint, COALESCE(SUM(response_bytes), 0)::bigint
+		FROM (
+			SELECT requests, request_bytes, response_bytes FROM api_usage_hourly
+			WHERE organization_id = $1 AND hour >= date_trunc('hour', $2::timestamptz, 'UTC')
+			UNION ALL
+			SELECT requests, request_bytes, response_bytes FROM api_usage_samples
+			WHERE organization_id = $1 AND recorded_at >= date_trunc('hour', $2::timestamptz, 'UTC')
+		) metered
+	`, organizationID, since).Scan(&u.Requests, &u.RequestBytes, &u.ResponseBytes)
+	if err != nil {
+		return APIUsage{}, fmt.Errorf("querying api usage: %w", err)
+	}
+	return u, nil
+}
+
+// ListMemberAPIUsage returns each user's API usage in the organization since
+// since, rounded down to the hour, heaviest first.
+func (r *APIUsageRepository) ListMemberAPIUsage(ctx context.Context, organizationID uuid.UUID, since time.Time) ([]MemberAPIUsage, error) {
+	rows, err := r.pool.Query(ctx, `
+		SELECT metered.user_id, COALESCE(u.email, ''),
+			SUM(metered.requests)::bigint, SUM(metered.request_bytes)::bigint, SUM(metered.response_bytes)::bigint
+		FROM (
+			SELECT user_id, requests, request_bytes, response_bytes FROM api_usage_hourly
+			WHERE organization_id = $1 AND hour >= date_trunc('hour', $2::timestamptz, 'UTC')
+			UNION ALL
+			SELECT user_id, requests, request_bytes, response_bytes FROM api_usage_samples
+			WHERE organization_id = $1 AND recorded_at >= date_trunc('hour', $2::timestamptz, 'UTC')
+		) metered
+		LEFT JOIN users u ON u.id = metered.user_id
+		GROUP BY metered.user_id, u.email
+		ORDER BY SUM(metered.requests) DESC, metered.user_id
+	`, organizationID, since)
+	if err != nil {
+		return nil, fmt.Errorf("querying member api usage: %w", err)
+	}
+	defer rows.Close()
+
+	var usage []MemberAPIUsage
+	for rows.Next() {
+		var m MemberAPIUsage
+		if err := rows.Scan(&m.UserID, &m.Email, &m.Requests, &m.RequestBytes, &m.ResponseBytes); err != nil {
+			return nil, fmt.Errorf("scanning member api usage: %w", err)
+		}
+		usage = append(usage, m)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("iterating member api usage: %w", err)
+	}
+	return usage, nil
+}
@@ -0,0 +1,99 @@
+package services_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	orgservices "github.com/cavenine/queryops/features/organization/services"
+	"github.com/cavenine/queryops/internal/testdb"
+	"github.com/cavenine/queryops/internal/testdb/fixtures"
+	"github.com/google/uuid"
+)
+
+func TestAPIUsageRepository_RecordAndRollup(t *testing.T) {
+	tdb := testdb.SetupTestDB(t)
+	ctx := context.Background()
+
+	orgID := fixtures.CreateOrg(t, tdb.Pool, "usage-org").ID
+	otherOrgID := fixtures.CreateOrg(t, tdb.Pool, "other-org").ID
+	alice := fixtures.CreateUser(t, tdb.Pool, "alice@example.com")
+	bob := fixtures.CreateUser(t, tdb.Pool, "bob@example.com")
+	repo := orgservices.NewAPIUsageRepository(tdb.Pool)
+
+	now := time.Now().UTC()
+	sample := func(org uuid.UUID, userID int, at time.Time, requests int64) orgservices.APIUsageSample {
+		return orgservices.APIUsageSample{
+			OrganizationID: org,
+			UserID:         userID,
+			RecordedAt:     at,
+			APIUsage:       orgservices.APIUsage{Requests: requests, RequestBytes: 10 * requests, ResponseBytes: 100 * requests},
+		}
+	}
+
+	if err := repo.RecordAPIUsage(ctx, []orgservices.APIUsageSample{
+		sample(orgID, alice.ID, now, 3),
+		sample(orgID, alice.ID, now, 2),
+		sample(orgID, bob.ID, now, 1),
+		sample(orgID, bob.ID, now.Add(-48*time.Hour), 7),
+		sample(otherOrgID, alice.ID, now, 100),
+	}); err != nil {
+		t.Fatalf("RecordAPIUsage: %v", err)
+	}
+
+	assertUsage := func(since time.Time, want orgservices.APIUsage) {
+		t.Helper()
+		got, err := repo.GetAPIUsage(ctx, orgID, since)
+		if err != nil {
+			t.Fatalf("GetAPIUsage: %v", err)
+		}
+		if got != want {
+			t.Fatalf("GetAPIUsage(%v) = %+v, want %+v", since, got, want)
+		}
+	}
+	day := orgservices.APIUsage{Requests: 6, RequestBytes: 60, ResponseBytes: 600}
+	week := orgservices.APIUsage{Requests: 13, RequestBytes: 130, ResponseBytes: 1300}
+
+	// Unrolled samples already count.
+	assertUsage(now.Add(-24*time.Hour), day)
+	assertUsage(now.Add(-7*24*time.Hour), week)
+
+	n, err := repo.RollupAPIUsage(ctx, now)
+	if err != nil {
+		t.Fatalf("RollupAPIUsage: %v", err)
+	}
+	if n != 5 {
+		t.Fatalf("rolled up %d samples, want 5", n)
+	}
+	assertUsage(now.Add(-24*time.Hour), day)
+	assertUsage(now.Add(-7*24*time.Hour), week)
+
+	// A second rollup adds to the hour rather than replacing it.
+	if err := repo.RecordAPIUsage(ctx, []orgservices.APIUsageSample{sample(orgID, alice.ID, now, 4)}); err != nil {
+		t.Fatalf("RecordAPIUsage: %v", err)
+	}
+	if _, err := repo.RollupAPIUsage(ctx, now); err != nil {
+		t.Fatalf("RollupAPIUsage: %v", err)
+	}
+	assertUsage(now.Add(-24*time.Hour), orgservices.APIUsage{Requests: 10, RequestBytes: 100, ResponseBytes: 1000})
+
+	members, err := repo.ListMemberAPIUsage(ctx, orgID, now.Add(-7*24*time.Hour))
+	if err != nil {
+		t.Fatalf("ListMemberAPIUsage: %v", err)
+	}
+	if len(members) != 2 {
+		t.Fatalf("members = %+v, want 2", members)
+	}
+	if members[0].UserID != alice.ID || members[0].Email != alice.Email || members[0].Requests != 9 {
+		t.Fatalf("members[0] = %+v, want alice with 9 requests", members[0])
+	}
+	if members[1].UserID != bob.ID || members[1].Requests != 8 {
+		t.Fatalf("members[1] = %+v, want bob with 8 requests", members[1])
+	}
+
+	// Usage older than the retention period is pruned.
+	if _, err := repo.RollupAPIUsage(ctx, now.Add(orgservices.APIUsageRetention+72*time.Hour)); err != nil {
+		t.Fatalf("RollupAPIUsage: %v", err)
+	}
+	assertUsage(now.Add(-7*24*time.Hour), orgservices.APIUsage{})
+}
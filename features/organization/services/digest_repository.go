@@ -0,0 +1,291 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+
+	"github.com/cavenine/queryops/internal/notify"
+)
+
+const (
+	DigestOff    = "off"
+	DigestDaily  = "daily"
+	DigestWeekly = "weekly"
+)
+
+var (
+	ErrInvalidDigestFrequency = errors.New("frequency must be off, daily, or weekly")
+	ErrDigestNoDestination    = errors.New("enter an email address or a webhook URL to send digests to")
+)
+
+// maxDigestItems caps each list in a digest; totals are still reported.
+const maxDigestItems = 50
+
+// DigestSettings is an organization's opt-in for periodic activity digests.
+type DigestSettings struct {
+	OrganizationID uuid.UUID  `json:"organization_id"`
+	Frequency      string     `json:"frequency"`
+	Email          *string    `json:"email,omitempty"`
+	WebhookURL     *string    `json:"webhook_url,omitempty"`
+	LastSentAt     *time.Time `json:"last_sent_at,omitempty"`
+}
+
+// Period returns how much time a digest covers for the configured frequency.
+func (s DigestSettings) Period() time.Duration {
+	switch s.Frequency {
+	case DigestDaily:
+		return 24 * time.Hour
+	case DigestWeekly:
+		return 7 * 24 * time.Hour
+	default:
+		return 0
+	}
+}
+
+type DigestHost struct {
+	HostIdentifier string    `json:"host_identifier"`
+	At             time.Time `json:"at"`
+}
+
+type DigestCampaign struct {
+	ID          uuid.UUID `json:"id"`
+	Name        *string   `json:"name,omitempty"`
+	Status      string    `json:"status"`
+	TargetCount int       `json:"target_count"`
+	ResultCount int       `json:"result_count"`
+	CompletedAt time.Time `json:"completed_at"`
+}
+
+// Digest summarizes an organization's activity over a period.
+type Digest struct {
+	OrganizationID   uuid.UUID `json:"organization_id"`
+	OrganizationName string    `json:"organization_name"`
+	Since            time.Time `json:"since"`
+	Until            time.Time `json:"until"`
+
+	NewHosts         []DigestHost     `json:"new_hosts"`
+	NewHostCount     int              `json:"new_host_count"`
+	OfflineHosts     []DigestHost     `json:"offline_hosts"`
+	OfflineHostCount int              `json:"offline_host_count"`
+	Campaigns        []DigestCampaign `json:"completed_campaigns"`
+	CampaignCount    int              `json:"completed_campaign_count"`
+}
+
+// Empty reports whether the digest has nothing worth sending.
+func (d *Digest) Empty() bool {
+	return d.NewHostCount == 0 && d.OfflineHostCount == 0 && d.CampaignCount == 0
+}
+
+type DigestRepository struct {
+	pool *pgxpool.Pool
+}
+
+func NewDigestRepository(pool *pgxpool.Pool) *DigestRepository {
+	return &DigestRepository{pool: pool}
+}
+
+// GetSettings returns the organization's digest settings, defaulting to off.
+func (r *DigestRepository) GetSettings(ctx context.Context, organizationID uuid.UUID) (*DigestSettings, error) {
+	s := &DigestSettings{OrganizationID: organizationID}
+	err := r.pool.QueryRow(ctx, `
+		SELECT frequency, email, webhook_url, last_sent_at
+		FROM organization_digest_settings
+		WHERE organization_id = $1
+	`, organizationID).Scan(&s.Frequency, &s.Email, &s.WebhookURL, &s.LastSentAt)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			s.Frequency = DigestOff
+			return s, nil
+		}
+		return nil, fmt.Errorf("querying digest settings: %w", err)
+	}
+	return s, nil
+}
+
+// SaveSettings creates or updates the organization's digest settings. Blank
+// destinations are stored as unset, and a webhook URL must pass
+// notify.ValidateWebhookURL.
+func (r *DigestRepository) SaveSettings(ctx context.Context, s DigestSettings) error {
+	switch s.Frequency {
+	case DigestOff, DigestDaily, DigestWeekly:
+	default:
+		return ErrInvalidDigestFrequency
+	}
+	s.Email = trimmedOrNil(s.Email)
+	s.WebhookURL = trimmedOrNil(s.WebhookURL)
+	if s.WebhookURL != nil {
+		if err := notify.ValidateWebhookURL(*s.WebhookURL); err != nil {
+			return err
+		}
+	}
+	if s.Frequency != DigestOff && s.Email == nil && s.WebhookURL == nil {
+		return ErrDigestNoDestination
+	}
+
+	_, err := r.pool.Exec(ctx, `
+		INSERT INTO organization_digest_settings (organization_id, frequency, email, webhook_url)
+		VALUES ($1, $2, $3, $4)
+		ON CONFLICT (organization_id)
+		DO UPDATE SET frequency = EXCLUDED.frequency,
+			email = EXCLUDED.email,
+			webhook_url = EXCLUDED.webhook_url,
+			updated_at = NOW()
+	`, s.OrganizationID, s.Frequency, s.Email, s.WebhookURL)
+	if err != nil {
+		return fmt.Errorf("saving digest settings: %w", err)
+	}
+	return nil
+}
+
+func trimmedOrNil(v *string) *string {
+	if v == nil {
+		return nil
+	}
+	t := strings.TrimSpace(*v)
+	if t == "" {
+		return nil
+	}
+	return &t
+}
+
+// ListDue returns settings for organizations whose next digest is due at now.
+func (r *DigestRepository) ListDue(ctx context.Context, now time.Time) ([]*DigestSettings, error) {
+	rows, err := r.pool.Query(ctx, `
+		SELECT organization_id, frequency, email, webhook_url, last_sent_at
+		FROM organization_digest_settings
+		WHERE frequency <> 'off'
+			AND (email IS NOT NULL OR webhook_url IS NOT NULL)
+			AND (
+				last_sent_at IS NULL
+				OR (frequency = 'daily' AND last_sent_at <= $1 - INTERVAL '1 day')
+				OR (frequency = 'weekly' AND last_sent_at <= $1 - INTERVAL '7 days')
+			)
+		ORDER BY organization_id
+	`, now)
+	if err != nil {
+		return nil, fmt.Errorf("listing due digests: %w", err)
+	}
+	defer rows.Close()
+
+	var due []*DigestSettings
+	for rows.Next() {
+		s := &DigestSettings{}
+		if err := rows.Scan(&s.OrganizationID, &s.Frequency, &s.Email, &s.WebhookURL, &s.LastSentAt); err != nil {
+			return nil, fmt.Errorf("scanning digest settings: %w", err)
+		}
+		due = append(due, s)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("listing due digests: %w", err)
+	}
+	return due, nil
+}
+
+// MarkSent records that a digest was delivered at sentAt.
+func (r *DigestRepository) MarkSent(ctx context.Context, organizationID uuid.UUID, sentAt time.Time) error {
+	_, err := r.pool.Exec(ctx, `
+		UPDATE organization_digest_settings
+		SET last_sent_at = $2, updated_at = NOW()
+		WHERE organization_id = $1
+	`, organizationID, sentAt)
+	if err != nil {
+		return fmt.Errorf("marking digest sent: %w", err)
+	}
+	return nil
+}
+
+// Build summarizes hosts enrolled and campaigns finished in [since, until),
+// plus hosts that have not checked in for offlineAfter.
+func (r *DigestRepository) Build(ctx context.Context, organizationID uuid.UUID, since, until time.Time, offlineAfter time.Duration) (*Digest, error) {
+	d := &Digest{OrganizationID: organizationID, Since: since, Until: until}
+
+	if err := r.pool.QueryRow(ctx, `SELECT name FROM organizations WHERE id = $1`, organizationID).Scan(&d.OrganizationName); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, ErrOrganizationNotFound
+		}
+		return nil, fmt.Errorf("building digest: querying organization: %w", err)
+	}
+
+	var err error
+	d.NewHosts, d.NewHostCount, err = r.digestHosts(ctx, `
+		SELECT host_identifier, created_at, COUNT(*) OVER ()
+		FROM hosts
+		WHERE organization_id = $1 AND created_at >= $2 AND created_at < $3
+		ORDER BY created_at DESC
+		LIMIT $4
+	`, organizationID, since, until, maxDigestItems)
+	if err != nil {
+		return nil, fmt.Errorf("building digest: new hosts: %w", err)
+	}
+
+	d.OfflineHosts, d.OfflineHostCount, err = r.digestHosts(ctx, `
+		SELECT host_identifier, last_seen, COUNT(*) OVER ()
+		FROM (
+			SELECT host_identifier,
+				GREATEST(last_enrollment_at, last_config_at, last_logger_at, last_distributed_at, last_seen_at) AS last_seen
+			FROM hosts
+			WHERE organization_id = $1
+		) h
+		WHERE last_seen < $2
+		ORDER BY last_seen DESC
+		LIMIT $3
+	`, organizationID, until.Add(-offlineAfter), maxDigestItems)
+	if err != nil {
+		return nil, fmt.Errorf("building digest: offline hosts: %w", err)
+	}
+
+	rows, err := r.pool.Query(ctx, `
+		SELECT id, name, status, target_count, result_count, updated_at, COUNT(*) OVER ()
+		FROM campaigns
+		WHERE organization_id = $1
+			AND status IN ('completed', 'failed')
+			AND updated_at >= $2 AND updated_at < $3
+		ORDER BY updated_at DESC
+		LIMIT $4
+	`, organizationID, since, until, maxDigestItems)
+	if err != nil {
+		return nil, fmt.Errorf("building digest: campaigns: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var c DigestCampaign
+		if err := rows.Scan(&c.ID, &c.Name, &c.Status, &c.TargetCount, &c.ResultCount, &c.CompletedAt, &d.CampaignCount); err != nil {
+			return nil, fmt.Errorf("building digest: scanning campaign: %w", err)
+		}
+		d.Campaigns = append(d.Campaigns, c)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("building digest: campaigns: %w", err)
+	}
+
+	return d, nil
+}
+
+func (r *DigestRepository) digestHosts(ctx context.Context, query string, args ...any) ([]DigestHost, int, error) {
+	rows, err := r.pool.Query(ctx, query, args...)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	var (
+		hosts []DigestHost
+		total int
+	)
+	for rows.Next() {
+		var h DigestHost
+		if err := rows.Scan(&h.HostIdentifier, &h.At, &total); err != nil {
+			return nil, 0, err
+		}
+		hosts = append(hosts, h)
+	}
+	return hosts, total, rows.Err()
+}
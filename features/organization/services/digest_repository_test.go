@@ -0,0 +1,108 @@
+package services_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+
+	orgservices "github.com/cavenine/queryops/features/organization/services"
+	"github.com/cavenine/queryops/internal/notify"
+	"github.com/cavenine/queryops/internal/testdb"
+	"github.com/cavenine/queryops/internal/testdb/fixtures"
+)
+
+func TestDigestRepository_Flow(t *testing.T) {
+	tdb := testdb.SetupTestDB(t)
+	ctx := context.Background()
+
+	orgID := fixtures.CreateOrg(t, tdb.Pool, "digest-org").ID
+	fixtures.CreateHost(t, tdb.Pool, orgID, "fresh")
+	fixtures.CreateHost(t, tdb.Pool, orgID, "stale", fixtures.EnrolledAt(time.Now().Add(-72*time.Hour)))
+
+	repo := orgservices.NewDigestRepository(tdb.Pool)
+
+	settings, err := repo.GetSettings(ctx, orgID)
+	if err != nil {
+		t.Fatalf("GetSettings: %v", err)
+	}
+	if settings.Frequency != orgservices.DigestOff {
+		t.Fatalf("default Frequency = %q, want off", settings.Frequency)
+	}
+
+	now := time.Now()
+	due, err := repo.ListDue(ctx, now)
+	if err != nil {
+		t.Fatalf("ListDue: %v", err)
+	}
+	if len(due) != 0 {
+		t.Fatalf("due = %d before opt-in, want 0", len(due))
+	}
+
+	webhook := "https://example.com/hook"
+	if err := repo.SaveSettings(ctx, orgservices.DigestSettings{
+		OrganizationID: orgID,
+		Frequency:      orgservices.DigestDaily,
+		WebhookURL:     &webhook,
+	}); err != nil {
+		t.Fatalf("SaveSettings: %v", err)
+	}
+
+	due, err = repo.ListDue(ctx, now)
+	if err != nil {
+		t.Fatalf("ListDue: %v", err)
+	}
+	if len(due) != 1 || due[0].OrganizationID != orgID {
+		t.Fatalf("due = %+v, want org %s", due, orgID)
+	}
+
+	digest, err := repo.Build(ctx, orgID, now.Add(-24*time.Hour), now.Add(time.Minute), 24*time.Hour)
+	if err != nil {
+		t.Fatalf("Build: %v", err)
+	}
+	if digest.NewHostCount != 2 {
+		t.Fatalf("NewHostCount = %d, want 2", digest.NewHostCount)
+	}
+	if digest.OfflineHostCount != 1 || digest.OfflineHosts[0].HostIdentifier != "stale" {
+		t.Fatalf("OfflineHosts = %+v, want [stale]", digest.OfflineHosts)
+	}
+
+	if err := repo.MarkSent(ctx, orgID, now); err != nil {
+		t.Fatalf("MarkSent: %v", err)
+	}
+	due, err = repo.ListDue(ctx, now.Add(time.Hour))
+	if err != nil {
+		t.Fatalf("ListDue: %v", err)
+	}
+	if len(due) != 0 {
+		t.Fatalf("due = %d right after sending, want 0", len(due))
+	}
+}
+
+func TestDigestRepository_SaveSettingsValidates(t *testing.T) {
+	// Invalid settings are refused before the database is touched.
+	repo := orgservices.NewDigestRepository(nil)
+	orgID := uuid.New()
+	str := func(s string) *string { return &s }
+
+	tests := []struct {
+		name     string
+		settings orgservices.DigestSettings
+		want     error
+	}{
+		{"unknown frequency", orgservices.DigestSettings{Frequency: "hourly", Email: str("ops@example.com")}, orgservices.ErrInvalidDigestFrequency},
+		{"no destination", orgservices.DigestSettings{Frequency: orgservices.DigestDaily, Email: str("  ")}, orgservices.ErrDigestNoDestination},
+		{"bad webhook", orgservices.DigestSettings{Frequency: orgservices.DigestWeekly, WebhookURL: str("ftp://example.com")}, notify.ErrInvalidWebhookURL},
+		{"internal webhook", orgservices.DigestSettings{Frequency: orgservices.DigestWeekly, WebhookURL: str("http://169.254.169.254/")}, notify.ErrDisallowedWebhookAddress},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.settings.OrganizationID = orgID
+			if err := repo.SaveSettings(context.Background(), tt.settings); !errors.Is(err, tt.want) {
+				t.Fatalf("SaveSettings = %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
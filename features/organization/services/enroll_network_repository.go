@@ -0,0 +1,191 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/netip"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// Enrollment network actions.
+const (
+	NetworkAllow = "allow"
+	NetworkDeny  = "deny"
+)
+
+var (
+	ErrInvalidNetwork       = errors.New("invalid network; use CIDR notation such as 10.0.0.0/8 or a single address")
+	ErrInvalidNetworkAction = errors.New("network action must be allow or deny")
+	ErrDuplicateNetwork     = errors.New("network is already listed")
+	ErrNetworkNotFound      = errors.New("network not found")
+)
+
+// EnrollNetwork is one entry of an organization's enrollment allow or deny
+// list.
+type EnrollNetwork struct {
+	ID             int64        `json:"id"`
+	OrganizationID uuid.UUID    `json:"organization_id"`
+	Network        netip.Prefix `json:"network"`
+	Action         string       `json:"action"`
+	Description    string       `json:"description"`
+	CreatedAt      time.Time    `json:"created_at"`
+}
+
+// EnrollNetworkDeniedError reports an enrollment from a network the
+// organization does not allow. Rule is the matching deny rule, or nil when
+// the address matched none of the allow rules.
+type EnrollNetworkDeniedError struct {
+	Addr netip.Addr
+	Rule *EnrollNetwork
+}
+
+func (e *EnrollNetworkDeniedError) Error() string {
+	if e.Rule != nil {
+		return fmt.Sprintf("enrollment from %s is denied by %s", e.Addr, e.Rule.Network)
+	}
+	return fmt.Sprintf("enrollment from %s is not in the organization's allowed networks", e.Addr)
+}
+
+// IsEnrollNetworkDenied reports whether err is or wraps an
+// *EnrollNetworkDeniedError.
+func IsEnrollNetworkDenied(err error) bool {
+	var de *EnrollNetworkDeniedError
+	return errors.As(err, &de)
+}
+
+// ParseNetwork parses a CIDR, or a single address as a host prefix, and
+// clears any host bits.
+func ParseNetwork(s string) (netip.Prefix, error) {
+	s = strings.TrimSpace(s)
+	if !strings.Contains(s, "/") {
+		addr, err := netip.ParseAddr(s)
+		if err != nil {
+			return netip.Prefix{}, ErrInvalidNetwork
+		}
+		addr = addr.Unmap()
+		return netip.PrefixFrom(addr, addr.BitLen()), nil
+	}
+	p, err := netip.ParsePrefix(s)
+	if err != nil {
+		return netip.Prefix{}, ErrInvalidNetwork
+	}
+	return p.Masked(), nil
+}
+
+// CheckEnrollNetworks applies rules to addr: any matching deny rule rejects
+// it, and if there are allow rules it must match one of them. An invalid addr
+// matches no rule.
+func CheckEnrollNetworks(rules []EnrollNetwork, addr netip.Addr) error {
+	addr = addr.Unmap()
+	hasAllow, allowed := false, false
+	for i := range rules {
+		rule := &rules[i]
+		matches := addr.IsValid() && rule.Network.Contains(addr)
+		switch rule.Action {
+		case NetworkDeny:
+			if matches {
+				return &EnrollNetworkDeniedError{Addr: addr, Rule: rule}
+			}
+		case NetworkAllow:
+			hasAllow = true
+			allowed = allowed || matches
+		}
+	}
+	if hasAllow && !allowed {
+		return &EnrollNetworkDeniedError{Addr: addr}
+	}
+	return nil
+}
+
+type EnrollNetworkRepository struct {
+	pool *pgxpool.Pool
+}
+
+func NewEnrollNetworkRepository(pool *pgxpool.Pool) *EnrollNetworkRepository {
+	return &EnrollNetworkRepository{pool: pool}
+}
+
+// List returns the organization's rules, deny rules first.
+func (r *EnrollNetworkRepository) List(ctx context.Context, organizationID uuid.UUID) ([]EnrollNetwork, error) {
+	rows, err := r.pool.Query(ctx, `
+		SELECT id, organization_id, network, action, description, created_at
+		FROM organization_enroll_networks
+		WHERE organization_id = $1
+		ORDER BY action DESC, network
+	`, organizationID)
+	if err != nil {
+		return nil, fmt.Errorf("querying enroll networks: %w", err)
+	}
+	defer rows.Close()
+
+	var networks []EnrollNetwork
+	for rows.Next() {
+		var n EnrollNetwork
+		if err := rows.Scan(&n.ID, &n.OrganizationID, &n.Network, &n.Action, &n.Description, &n.CreatedAt); err != nil {
+			return nil, fmt.Errorf("scanning enroll network: %w", err)
+		}
+		networks = append(networks, n)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("iterating enroll networks: %w", err)
+	}
+	return networks, nil
+}
+
+// Add lists network (see ParseNetwork) under action for the organization.
+func (r *EnrollNetworkRepository) Add(ctx context.Context, organizationID uuid.UUID, network, action, description string) (*EnrollNetwork, error) {
+	prefix, err := ParseNetwork(network)
+	if err != nil {
+		return nil, err
+	}
+	if action != NetworkAllow && action != NetworkDeny {
+		return nil, ErrInvalidNetworkAction
+	}
+
+	n := &EnrollNetwork{}
+	err = r.pool.QueryRow(ctx, `
+		INSERT INTO organization_enroll_networks (organization_id, network, action, description)
+		VALUES ($1, $2, $3, $4)
+		RETURNING id, organization_id, network, action, description, created_at
+	`, organizationID, prefix, action, strings.TrimSpace(description)).
+		Scan(&n.ID, &n.OrganizationID, &n.Network, &n.Action, &n.Description, &n.CreatedAt)
+	if err != nil {
+		var pgErr *pgconn.PgError
+		if errors.As(err, &pgErr) && pgErr.Code == "23505" {
+			return nil, ErrDuplicateNetwork
+		}
+		return nil, fmt.Errorf("inserting enroll network: %w", err)
+	}
+	return n, nil
+}
+
+// Delete removes one of the organization's rules.
+func (r *EnrollNetworkRepository) Delete(ctx context.Context, organizationID uuid.UUID, id int64) error {
+	tag, err := r.pool.Exec(ctx, `
+		DELETE FROM organization_enroll_networks
+		WHERE organization_id = $1 AND id = $2
+	`, organizationID, id)
+	if err != nil {
+		return fmt.Errorf("deleting enroll network: %w", err)
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrNetworkNotFound
+	}
+	return nil
+}
+
+// CheckEnrollment returns an *EnrollNetworkDeniedError if the organization's
+// rules do not allow enrolling from addr.
+func (r *EnrollNetworkRepository) CheckEnrollment(ctx context.Context, organizationID uuid.UUID, addr netip.Addr) error {
+	rules, err := r.List(ctx, organizationID)
+	if err != nil {
+		return err
+	}
+	return CheckEnrollNetworks(rules, addr)
+}
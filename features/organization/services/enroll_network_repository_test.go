@@ -0,0 +1,134 @@
+package services_test
+
+import (
+	"context"
+	"errors"
+	"net/netip"
+	"testing"
+
+	orgservices "github.com/cavenine/queryops/features/organization/services"
+	"github.com/cavenine/queryops/internal/testdb"
+	"github.com/cavenine/queryops/internal/testdb/fixtures"
+)
+
+func TestParseNetwork(t *testing.T) {
+	tests := []struct {
+		in   string
+		want string
+	}{
+		{"10.0.0.0/8", "10.0.0.0/8"},
+		{" 10.1.2.3/8 ", "10.0.0.0/8"},
+		{"192.0.2.7", "192.0.2.7/32"},
+		{"2001:db8::1", "2001:db8::1/128"},
+		{"::ffff:192.0.2.7", "192.0.2.7/32"},
+	}
+	for _, tt := range tests {
+		got, err := orgservices.ParseNetwork(tt.in)
+		if err != nil {
+			t.Fatalf("ParseNetwork(%q): %v", tt.in, err)
+		}
+		if got.String() != tt.want {
+			t.Fatalf("ParseNetwork(%q) = %s, want %s", tt.in, got, tt.want)
+		}
+	}
+
+	for _, in := range []string{"", "corp", "10.0.0.0/33"} {
+		if _, err := orgservices.ParseNetwork(in); !errors.Is(err, orgservices.ErrInvalidNetwork) {
+			t.Fatalf("ParseNetwork(%q) err = %v, want ErrInvalidNetwork", in, err)
+		}
+	}
+}
+
+func TestCheckEnrollNetworks(t *testing.T) {
+	rule := func(network, action string) orgservices.EnrollNetwork {
+		return orgservices.EnrollNetwork{Network: netip.MustParsePrefix(network), Action: action}
+	}
+	corp := []orgservices.EnrollNetwork{
+		rule("10.0.0.0/8", orgservices.NetworkAllow),
+		rule("10.66.0.0/16", orgservices.NetworkDeny),
+	}
+
+	tests := []struct {
+		name    string
+		rules   []orgservices.EnrollNetwork
+		addr    string
+		allowed bool
+	}{
+		{"no rules", nil, "203.0.113.9", true},
+		{"allowed network", corp, "10.1.2.3", true},
+		{"mapped address", corp, "::ffff:10.1.2.3", true},
+		{"outside allow list", corp, "203.0.113.9", false},
+		{"deny wins", corp, "10.66.1.1", false},
+		{"deny only", []orgservices.EnrollNetwork{rule("203.0.113.0/24", orgservices.NetworkDeny)}, "10.1.2.3", true},
+		{"unknown address", corp, "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var addr netip.Addr
+			if tt.addr != "" {
+				addr = netip.MustParseAddr(tt.addr)
+			}
+			err := orgservices.CheckEnrollNetworks(tt.rules, addr)
+			if tt.allowed && err != nil {
+				t.Fatalf("err = %v, want allowed", err)
+			}
+			if !tt.allowed && !orgservices.IsEnrollNetworkDenied(err) {
+				t.Fatalf("err = %v, want EnrollNetworkDeniedError", err)
+			}
+		})
+	}
+}
+
+func TestEnrollNetworkRepository(t *testing.T) {
+	tdb := testdb.SetupTestDB(t)
+	ctx := context.Background()
+
+	orgID := fixtures.CreateOrg(t, tdb.Pool, "network-org").ID
+	otherOrgID := fixtures.CreateOrg(t, tdb.Pool, "other-org").ID
+	repo := orgservices.NewEnrollNetworkRepository(tdb.Pool)
+
+	allow, err := repo.Add(ctx, orgID, "10.1.2.3/8", orgservices.NetworkAllow, " office ")
+	if err != nil {
+		t.Fatalf("Add allow: %v", err)
+	}
+	if allow.Network.String() != "10.0.0.0/8" || allow.Description != "office" {
+		t.Fatalf("added = %+v", allow)
+	}
+	if _, err := repo.Add(ctx, orgID, "10.66.0.0/16", orgservices.NetworkDeny, ""); err != nil {
+		t.Fatalf("Add deny: %v", err)
+	}
+	if _, err := repo.Add(ctx, orgID, "10.0.0.0/8", orgservices.NetworkAllow, ""); !errors.Is(err, orgservices.ErrDuplicateNetwork) {
+		t.Fatalf("duplicate Add err = %v", err)
+	}
+	if _, err := repo.Add(ctx, orgID, "10.0.0.0/8", "maybe", ""); !errors.Is(err, orgservices.ErrInvalidNetworkAction) {
+		t.Fatalf("invalid action Add err = %v", err)
+	}
+
+	networks, err := repo.List(ctx, orgID)
+	if err != nil {
+		t.Fatalf("List: %v", err)
+	}
+	if len(networks) != 2 || networks[0].Action != orgservices.NetworkDeny {
+		t.Fatalf("List = %+v, want deny rule first", networks)
+	}
+
+	if err := repo.CheckEnrollment(ctx, orgID, netip.MustParseAddr("10.1.2.3")); err != nil {
+		t.Fatalf("CheckEnrollment allowed: %v", err)
+	}
+	if err := repo.CheckEnrollment(ctx, orgID, netip.MustParseAddr("10.66.0.1")); !orgservices.IsEnrollNetworkDenied(err) {
+		t.Fatalf("CheckEnrollment denied err = %v", err)
+	}
+	if err := repo.CheckEnrollment(ctx, otherOrgID, netip.MustParseAddr("203.0.113.9")); err != nil {
+		t.Fatalf("CheckEnrollment other org: %v", err)
+	}
+
+	if err := repo.Delete(ctx, otherOrgID, allow.ID); !errors.Is(err, orgservices.ErrNetworkNotFound) {
+		t.Fatalf("Delete from other org err = %v", err)
+	}
+	if err := repo.Delete(ctx, orgID, allow.ID); err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+	if err := repo.CheckEnrollment(ctx, orgID, netip.MustParseAddr("203.0.113.9")); err != nil {
+		t.Fatalf("CheckEnrollment after removing allow rule: %v", err)
+	}
+}
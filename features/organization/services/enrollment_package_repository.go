@@ -0,0 +1,299 @@
+package services
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/riverqueue/river"
+	"github.com/riverqueue/river/rivertype"
+
+	"github.com/cavenine/queryops/internal/crypto"
+	"github.com/cavenine/queryops/internal/osquerypkg"
+)
+
+// Enrollment package statuses.
+const (
+	PackagePending = "pending"
+	PackageReady   = "ready"
+	PackageFailed  = "failed"
+)
+
+var (
+	ErrInvalidPackageFormat      = errors.New("unsupported package format")
+	ErrEnrollmentPackageNotFound = errors.New("enrollment package not found")
+	ErrEnrollmentPackageNotReady = errors.New("enrollment package is not ready")
+)
+
+// EnrollmentPackage is a requested build of an organization's enrollment
+// package; see internal/osquerypkg.
+type EnrollmentPackage struct {
+	ID             uuid.UUID  `json:"id"`
+	OrganizationID uuid.UUID  `json:"organization_id"`
+	Format         string     `json:"format"`
+	Build          int        `json:"build"`
+	Hostname       string     `json:"hostname"`
+	Status         string     `json:"status"`
+	Filename       string     `json:"filename"`
+	Size           int        `json:"size"`
+	SHA256         string     `json:"sha256"`
+	Error          string     `json:"error"`
+	RequestedBy    *int       `json:"requested_by,omitempty"`
+	CreatedAt      time.Time  `json:"created_at"`
+	CompletedAt    *time.Time `json:"completed_at,omitempty"`
+}
+
+// BuildEnrollmentPackageArgs builds a pending enrollment package. It's
+// declared here rather than in background so Request can enqueue it in the
+// transaction that creates the package.
+type BuildEnrollmentPackageArgs struct {
+	PackageID uuid.UUID `json:"package_id"`
+}
+
+func (BuildEnrollmentPackageArgs) Kind() string {
+	return "build_enrollment_package"
+}
+
+func (BuildEnrollmentPackageArgs) InsertOpts() river.InsertOpts {
+	return river.InsertOpts{Queue: river.QueueDefault, MaxAttempts: 3}
+}
+
+// JobInserter enqueues River jobs in a transaction. *river.Client[pgx.Tx]
+// implements it, including an insert-only client.
+type JobInserter interface {
+	InsertTx(ctx context.Context, tx pgx.Tx, args river.JobArgs, opts *river.InsertOpts) (*rivertype.JobInsertResult, error)
+}
+
+type EnrollmentPackageRepository struct {
+	pool *pgxpool.Pool
+	// keys encrypts package content, which embeds the enroll secret.
+	keys *crypto.Keyring
+	// jobs may be nil in processes that only build packages.
+	jobs JobInserter
+}
+
+func NewEnrollmentPackageRepository(pool *pgxpool.Pool, keys *crypto.Keyring, jobs JobInserter) *EnrollmentPackageRepository {
+	return &EnrollmentPackageRepository{pool: pool, keys: keys, jobs: jobs}
+}
+
+const enrollmentPackageColumns = `
+	id, organization_id, format, build, hostname, status, filename, size,
+	sha256, error, requested_by, created_at, completed_at`
+
+func scanEnrollmentPackage(row pgx.Row) (*EnrollmentPackage, error) {
+	p := &EnrollmentPackage{}
+	err := row.Scan(&p.ID, &p.OrganizationID, &p.Format, &p.Build, &p.Hostname, &p.Status, &p.Filename, &p.Size,
+		&p.SHA256, &p.Error, &p.RequestedBy, &p.CreatedAt, &p.CompletedAt)
+	if err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// Request records a pending package with the organization's next build
+// number and enqueues its build.
+func (r *EnrollmentPackageRepository) Request(ctx context.Context, organizationID uuid.UUID, format, hostname string, requestedBy int) (*EnrollmentPackage, error) {
+	if !slices.Contains(osquerypkg.Formats, format) {
+		return nil, ErrInvalidPackageFormat
+	}
+	if r.jobs == nil {
+		return nil, errors.New("enrollment package repository has no job inserter")
+	}
+
+	tx, err := r.pool.Begin(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("starting transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	// Serialize build numbering per organization without blocking inserts
+	// that reference it.
+	if _, err := tx.Exec(ctx, `SELECT 1 FROM organizations WHERE id = $1 FOR NO KEY UPDATE`, organizationID); err != nil {
+		return nil, fmt.Errorf("locking organization: %w", err)
+	}
+	pkg, err := scanEnrollmentPackage(tx.QueryRow(ctx, `
+		INSERT INTO enrollment_packages (organization_id, format, build, hostname, requested_by)
+		SELECT $1::uuid, $2::text, COALESCE(MAX(build), 0) + 1, $3::text, $4::integer
+		FROM enrollment_packages
+		WHERE organization_id = $1
+		RETURNING`+enrollmentPackageColumns,
+		organizationID, format, hostname, requestedBy))
+	if err != nil {
+		return nil, fmt.Errorf("inserting enrollment package: %w", err)
+	}
+	if _, err := r.jobs.InsertTx(ctx, tx, BuildEnrollmentPackageArgs{PackageID: pkg.ID}, nil); err != nil {
+		return nil, fmt.Errorf("enqueueing enrollment package build: %w", err)
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return nil, fmt.Errorf("committing enrollment package: %w", err)
+	}
+	return pkg, nil
+}
+
+// List returns the organization's most recent packages, newest first.
+func (r *EnrollmentPackageRepository) List(ctx context.Context, organizationID uuid.UUID, limit int) ([]EnrollmentPackage, error) {
+	rows, err := r.pool.Query(ctx, `
+		SELECT`+enrollmentPackageColumns+`
+		FROM enrollment_packages
+		WHERE organization_id = $1
+		ORDER BY build DESC
+		LIMIT $2
+	`, organizationID, limit)
+	if err != nil {
+		return nil, fmt.Errorf("querying enrollment packages: %w", err)
+	}
+	defer rows.Close()
+
+	var packages []EnrollmentPackage
+	for rows.Next() {
+		pkg, err := scanEnrollmentPackage(rows)
+		if err != nil {
+			return nil, fmt.Errorf("scanning enrollment package: %w", err)
+		}
+		packages = append(packages, *pkg)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("iterating enrollment packages: %w", err)
+	}
+	return packages, nil
+}
+
+// Get returns a package regardless of organization, for the build job.
+func (r *EnrollmentPackageRepository) Get(ctx context.Context, id uuid.UUID) (*EnrollmentPackage, error) {
+	pkg, err := scanEnrollmentPackage(r.pool.QueryRow(ctx, `
+		SELECT`+enrollmentPackageColumns+`
+		FROM enrollment_packages
+		WHERE id = $1
+	`, id))
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, ErrEnrollmentPackageNotFound
+		}
+		return nil, fmt.Errorf("querying enrollment package: %w", err)
+	}
+	return pkg, nil
+}
+
+// Content returns one of the organization's ready packages and its
+// decrypted content.
+func (r *EnrollmentPackageRepository) Content(ctx context.Context, organizationID, id uuid.UUID) (*EnrollmentPackage, []byte, error) {
+	var ciphertext *string
+	row := r.pool.QueryRow(ctx, `
+		SELECT`+enrollmentPackageColumns+`, content_ciphertext
+		FROM enrollment_packages
+		WHERE organization_id = $1 AND id = $2
+	`, organizationID, id)
+	pkg := &EnrollmentPackage{}
+	err := row.Scan(&pkg.ID, &pkg.OrganizationID, &pkg.Format, &pkg.Build, &pkg.Hostname, &pkg.Status, &pkg.Filename, &pkg.Size,
+		&pkg.SHA256, &pkg.Error, &pkg.RequestedBy, &pkg.CreatedAt, &pkg.CompletedAt, &ciphertext)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, nil, ErrEnrollmentPackageNotFound
+		}
+		return nil, nil, fmt.Errorf("querying enrollment package: %w", err)
+	}
+	if pkg.Status != PackageReady || ciphertext == nil {
+		return nil, nil, ErrEnrollmentPackageNotReady
+	}
+	content, err := r.keys.Decrypt(*ciphertext, pkg.ID[:])
+	if err != nil {
+		return nil, nil, fmt.Errorf("decrypting enrollment package: %w", err)
+	}
+	return pkg, content, nil
+}
+
+// Complete stores a pending package's built content.
+func (r *EnrollmentPackageRepository) Complete(ctx context.Context, id uuid.UUID, filename string, content []byte) error {
+	ciphertext, err := r.keys.Encrypt(content, id[:])
+	if err != nil {
+		return fmt.Errorf("encrypting enrollment package: %w", err)
+	}
+	sum := sha256.Sum256(content)
+	if _, err := r.pool.Exec(ctx, `
+		UPDATE enrollment_packages
+		SET status = 'ready', filename = $2, content_ciphertext = $3, size = $4, sha256 = $5, completed_at = NOW()
+		WHERE id = $1 AND status = 'pending'
+	`, id, filename, ciphertext, len(content), hex.EncodeToString(sum[:])); err != nil {
+		return fmt.Errorf("completing enrollment package: %w", err)
+	}
+	return nil
+}
+
+// Fail marks a pending package as failed with a message for the admin.
+func (r *EnrollmentPackageRepository) Fail(ctx context.Context, id uuid.UUID, message string) error {
+	if _, err := r.pool.Exec(ctx, `
+		UPDATE enrollment_packages
+		SET status = 'failed', error = $2, completed_at = NOW()
+		WHERE id = $1 AND status = 'pending'
+	`, id, message); err != nil {
+		return fmt.Errorf("failing enrollment package: %w", err)
+	}
+	return nil
+}
+
+// RotatePackages re-encrypts up to batchSize packages stored under a key
+// other than the keyring's primary, and returns how many it rewrote. Call it
+// until it returns 0.
+func (r *EnrollmentPackageRepository) RotatePackages(ctx context.Context, batchSize int) (int, error) {
+	tx, err := r.pool.Begin(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("starting transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	rows, err := tx.Query(ctx, `
+		SELECT id, content_ciphertext
+		FROM enrollment_packages
+		WHERE split_part(content_ciphertext, ':', 2) <> $1
+		ORDER BY id
+		LIMIT $2
+		FOR UPDATE SKIP LOCKED
+	`, r.keys.PrimaryID(), batchSize)
+	if err != nil {
+		return 0, fmt.Errorf("querying enrollment packages to rotate: %w", err)
+	}
+	rotated := map[uuid.UUID]string{}
+	for rows.Next() {
+		var (
+			id         uuid.UUID
+			ciphertext string
+		)
+		if err := rows.Scan(&id, &ciphertext); err != nil {
+			rows.Close()
+			return 0, fmt.Errorf("scanning enrollment package: %w", err)
+		}
+		content, err := r.keys.Decrypt(ciphertext, id[:])
+		if err != nil {
+			rows.Close()
+			return 0, fmt.Errorf("decrypting enrollment package: %w", err)
+		}
+		rotated[id], err = r.keys.Encrypt(content, id[:])
+		if err != nil {
+			rows.Close()
+			return 0, fmt.Errorf("encrypting enrollment package: %w", err)
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, fmt.Errorf("iterating enrollment packages: %w", err)
+	}
+
+	for id, ciphertext := range rotated {
+		if _, err := tx.Exec(ctx, `
+			UPDATE enrollment_packages SET content_ciphertext = $2 WHERE id = $1
+		`, id, ciphertext); err != nil {
+			return 0, fmt.Errorf("updating enrollment package: %w", err)
+		}
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return 0, fmt.Errorf("committing enrollment packages: %w", err)
+	}
+	return len(rotated), nil
+}
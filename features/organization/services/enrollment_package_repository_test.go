@@ -0,0 +1,113 @@
+package services_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/riverqueue/river"
+	"github.com/riverqueue/river/rivertype"
+
+	orgservices "github.com/cavenine/queryops/features/organization/services"
+	"github.com/cavenine/queryops/internal/testdb"
+	"github.com/cavenine/queryops/internal/testdb/fixtures"
+)
+
+// recordingInserter records enqueued jobs instead of inserting them.
+type recordingInserter struct {
+	args []river.JobArgs
+}
+
+func (r *recordingInserter) InsertTx(_ context.Context, _ pgx.Tx, args river.JobArgs, _ *river.InsertOpts) (*rivertype.JobInsertResult, error) {
+	r.args = append(r.args, args)
+	return &rivertype.JobInsertResult{}, nil
+}
+
+func TestEnrollmentPackageRepository(t *testing.T) {
+	tdb := testdb.SetupTestDB(t)
+	ctx := context.Background()
+
+	orgID := fixtures.CreateOrg(t, tdb.Pool, "package-org").ID
+	otherOrgID := fixtures.CreateOrg(t, tdb.Pool, "other-org").ID
+	user := fixtures.CreateUser(t, tdb.Pool, "admin@example.com")
+	jobs := &recordingInserter{}
+	repo := orgservices.NewEnrollmentPackageRepository(tdb.Pool, testKeyring(t), jobs)
+
+	if _, err := repo.Request(ctx, orgID, "rpm", "queryops.example.com", user.ID); !errors.Is(err, orgservices.ErrInvalidPackageFormat) {
+		t.Fatalf("Request(rpm) err = %v, want ErrInvalidPackageFormat", err)
+	}
+
+	first, err := repo.Request(ctx, orgID, "deb", "queryops.example.com", user.ID)
+	if err != nil {
+		t.Fatalf("Request: %v", err)
+	}
+	second, err := repo.Request(ctx, orgID, "msi", "queryops.example.com", user.ID)
+	if err != nil {
+		t.Fatalf("Request: %v", err)
+	}
+	other, err := repo.Request(ctx, otherOrgID, "deb", "queryops.example.com", user.ID)
+	if err != nil {
+		t.Fatalf("Request: %v", err)
+	}
+	if first.Build != 1 || second.Build != 2 || other.Build != 1 {
+		t.Fatalf("builds = %d, %d, %d; want 1, 2, 1", first.Build, second.Build, other.Build)
+	}
+	if first.Status != orgservices.PackagePending {
+		t.Fatalf("status = %q, want pending", first.Status)
+	}
+	if len(jobs.args) != 3 {
+		t.Fatalf("enqueued %d jobs, want 3", len(jobs.args))
+	}
+	if args, ok := jobs.args[0].(orgservices.BuildEnrollmentPackageArgs); !ok || args.PackageID != first.ID {
+		t.Fatalf("first job = %#v", jobs.args[0])
+	}
+
+	if _, _, err := repo.Content(ctx, orgID, first.ID); !errors.Is(err, orgservices.ErrEnrollmentPackageNotReady) {
+		t.Fatalf("Content of pending package err = %v", err)
+	}
+
+	content := []byte("!<arch>\npackage bytes")
+	if err := repo.Complete(ctx, first.ID, "queryops-enroll-1.0.1.deb", content); err != nil {
+		t.Fatalf("Complete: %v", err)
+	}
+	if err := repo.Fail(ctx, second.ID, "wixl not installed"); err != nil {
+		t.Fatalf("Fail: %v", err)
+	}
+	// A finished package stays finished.
+	if err := repo.Fail(ctx, first.ID, "late failure"); err != nil {
+		t.Fatalf("Fail: %v", err)
+	}
+
+	var stored string
+	if err := tdb.Pool.QueryRow(ctx, `SELECT content_ciphertext FROM enrollment_packages WHERE id = $1`, first.ID).Scan(&stored); err != nil {
+		t.Fatalf("reading ciphertext: %v", err)
+	}
+	if !strings.HasPrefix(stored, "v1:test:") {
+		t.Fatalf("package content not encrypted: %q", stored)
+	}
+
+	pkg, got, err := repo.Content(ctx, orgID, first.ID)
+	if err != nil {
+		t.Fatalf("Content: %v", err)
+	}
+	if !bytes.Equal(got, content) || pkg.Status != orgservices.PackageReady || pkg.Size != len(content) || pkg.SHA256 == "" {
+		t.Fatalf("Content = %+v, %q", pkg, got)
+	}
+	if _, _, err := repo.Content(ctx, otherOrgID, first.ID); !errors.Is(err, orgservices.ErrEnrollmentPackageNotFound) {
+		t.Fatalf("Content from another org err = %v", err)
+	}
+
+	list, err := repo.List(ctx, orgID, 10)
+	if err != nil {
+		t.Fatalf("List: %v", err)
+	}
+	if len(list) != 2 || list[0].ID != second.ID || list[0].Status != orgservices.PackageFailed || list[0].Error != "wixl not installed" {
+		t.Fatalf("List = %+v", list)
+	}
+	if list[1].Status != orgservices.PackageReady {
+		t.Fatalf("completed package status = %q after late Fail", list[1].Status)
+	}
+}
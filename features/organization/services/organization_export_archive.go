@@ -0,0 +1,280 @@
+package services
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+)
+
+// exportFile is a file of an organization export and the query for its
+// content, which takes the organization ID as $1.
+type exportFile struct {
+	name  string
+	query string
+}
+
+// exportCSVFiles are the tables of an organization export, one row per
+// record.
+var exportCSVFiles = []exportFile{
+	{"members.csv", `
+		SELECT u.id AS user_id, u.email, m.role, m.created_at AS joined_at
+		FROM organization_members m
+		JOIN users u ON u.id = m.user_id
+		WHERE m.organization_id = $1
+		ORDER BY m.created_at, u.id`},
+	{"hosts.csv", `
+		SELECT id, host_identifier,
+			COALESCE(system_info->>'hostname', '') AS hostname,
+			COALESCE(os_version->>'platform', '') AS platform,
+			COALESCE(os_version->>'name', '') AS os_name,
+			COALESCE(os_version->>'version', '') AS os_version,
+			osquery_version, hardware_uuid,
+			created_at, last_enrollment_at, last_seen_at
+		FROM hosts
+		WHERE organization_id = $1
+		ORDER BY created_at, id`},
+	{"campaigns.csv", `
+		SELECT c.id, c.name, c.description, c.query, c.status, u.email AS created_by,
+			c.target_count, c.result_count, c.previous_campaign_id,
+			c.created_at, c.updated_at, c.archived_at
+		FROM campaigns c
+		LEFT JOIN users u ON u.id = c.created_by
+		WHERE c.organization_id = $1
+		ORDER BY c.created_at, c.id`},
+	{"campaign_targets.csv", `
+		SELECT t.campaign_id, t.host_id, h.host_identifier, t.status,
+			CASE WHEN jsonb_typeof(t.results) = 'array' THEN jsonb_array_length(t.results) ELSE 0 END AS row_count,
+			t.truncated, t.error, t.sent_at, t.completed_at
+		FROM campaign_targets t
+		JOIN campaigns c ON c.id = t.campaign_id
+		JOIN hosts h ON h.id = t.host_id
+		WHERE c.organization_id = $1
+		ORDER BY c.created_at, t.campaign_id, h.host_identifier`},
+	{"scheduled_results.csv", `
+		SELECT r.name, r.host_id, h.host_identifier, COUNT(*) AS row_count,
+			MIN(r.created_at) AS first_stored_at, MAX(r.created_at) AS last_stored_at
+		FROM osquery_results r
+		JOIN hosts h ON h.id = r.host_id
+		WHERE h.organization_id = $1
+		GROUP BY r.name, r.host_id, h.host_identifier
+		ORDER BY r.name, h.host_identifier`},
+}
+
+// exportSettingsQuery returns the organization's settings as one JSON
+// object. Webhook URLs and other integration secrets are left out.
+const exportSettingsQuery = `
+	SELECT json_build_object(
+		'plan_id', o.plan_id,
+		'extra_features', o.extra_features,
+		'defaults', (SELECT row_to_json(s) FROM (
+			SELECT result_retention_days, distributed_interval, config_refresh, logger_tls_period,
+				campaign_archive_days, muted_notification_kinds
+			FROM organization_settings WHERE organization_id = o.id) s),
+		'quotas', (SELECT row_to_json(q) FROM (
+			SELECT max_hosts, max_campaigns_per_day, max_result_log_bytes_per_day
+			FROM organization_quotas WHERE organization_id = o.id) q),
+		'enroll_networks', (SELECT COALESCE(json_agg(n ORDER BY n.id), '[]') FROM (
+			SELECT id, network, action, description, created_at
+			FROM organization_enroll_networks WHERE organization_id = o.id) n),
+		'redaction_rules', (SELECT COALESCE(json_agg(r ORDER BY r.id), '[]') FROM (
+			SELECT id, kind, pattern, description, created_at
+			FROM organization_redaction_rules WHERE organization_id = o.id) r),
+		'status_alert_rules', (SELECT COALESCE(json_agg(a ORDER BY a.id), '[]') FROM (
+			SELECT id, min_severity, pattern, description, created_at
+			FROM status_alert_rules WHERE organization_id = o.id) a),
+		'result_baseline_rules', (SELECT COALESCE(json_agg(b ORDER BY b.id), '[]') FROM (
+			SELECT id, query_name, columns, threshold, description, created_at
+			FROM result_baseline_rules WHERE organization_id = o.id) b),
+		'digest', (SELECT row_to_json(d) FROM (
+			SELECT frequency, email
+			FROM organization_digest_settings WHERE organization_id = o.id) d),
+		'slack_routes', (SELECT COALESCE(json_agg(sr ORDER BY sr.id), '[]') FROM (
+			SELECT id, event, channel, template, created_at
+			FROM slack_routes WHERE organization_id = o.id) sr),
+		'alert_destinations', (SELECT COALESCE(json_agg(ad ORDER BY ad.id), '[]') FROM (
+			SELECT id, name, provider, offline_hours, status_alerts, host_group_id, created_at
+			FROM alert_destinations WHERE organization_id = o.id) ad),
+		'host_groups', (SELECT COALESCE(json_agg(g ORDER BY g.name), '[]') FROM (
+			SELECT hg.id, hg.name, hg.description, hg.created_at,
+				COALESCE((SELECT json_agg(gm.host_id ORDER BY gm.host_id) FROM host_group_members gm WHERE gm.group_id = hg.id), '[]') AS host_ids
+			FROM host_groups hg WHERE hg.organization_id = o.id) g),
+		'packs', (SELECT COALESCE(json_agg(p ORDER BY p.name), '[]') FROM (
+			SELECT name, pack, enabled_at
+			FROM organization_packs WHERE organization_id = o.id) p)
+	)
+	FROM organizations o
+	WHERE o.id = $1`
+
+// exportAuditLogQuery returns the admin console's audit log entries about the
+// organization as a JSON array, oldest first.
+const exportAuditLogQuery = `
+	SELECT COALESCE(json_agg(e ORDER BY e.created_at, e.id), '[]')
+	FROM (
+		SELECT l.id, l.action, actor.email AS actor, impersonated.email AS impersonated_user,
+			l.details, l.created_at
+		FROM admin_audit_log l
+		LEFT JOIN users actor ON actor.id = l.actor_user_id
+		LEFT JOIN users impersonated ON impersonated.id = l.impersonated_user_id
+		WHERE l.organization_id = $1
+	) e`
+
+// exportManifest describes an organization export. It's written last, as
+// manifest.json.
+type exportManifest struct {
+	OrganizationID   uuid.UUID `json:"organization_id"`
+	OrganizationName string    `json:"organization_name"`
+	ExportedAt       time.Time `json:"exported_at"`
+	// Rows counts the records in each CSV file.
+	Rows map[string]int64 `json:"rows"`
+}
+
+// WriteArchive writes a zip of the organization's data to w: its members,
+// hosts, campaigns and their per-host outcomes, and a summary of stored
+// scheduled query results as CSV; its settings and the admin audit log
+// entries about it as JSON; and a manifest. Result rows themselves aren't
+// included; result exports cover those.
+func (r *OrganizationExportRepository) WriteArchive(ctx context.Context, organizationID uuid.UUID, w io.Writer, now time.Time) error {
+	manifest := exportManifest{
+		OrganizationID: organizationID,
+		ExportedAt:     now.UTC(),
+		Rows:           make(map[string]int64, len(exportCSVFiles)),
+	}
+	if err := r.pool.QueryRow(ctx, `SELECT name FROM organizations WHERE id = $1`, organizationID).Scan(&manifest.OrganizationName); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return ErrOrganizationNotFound
+		}
+		return fmt.Errorf("querying organization: %w", err)
+	}
+
+	// A repeatable read snapshot keeps the files consistent with each other.
+	tx, err := r.pool.BeginTx(ctx, pgx.TxOptions{IsoLevel: pgx.RepeatableRead, AccessMode: pgx.ReadOnly})
+	if err != nil {
+		return fmt.Errorf("starting transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	zw := zip.NewWriter(w)
+	for _, file := range exportCSVFiles {
+		n, err := writeExportCSV(ctx, tx, zw, file.name, now, file.query, organizationID)
+		if err != nil {
+			return fmt.Errorf("writing %s: %w", file.name, err)
+		}
+		manifest.Rows[file.name] = n
+	}
+	for _, file := range []exportFile{
+		{"settings.json", exportSettingsQuery},
+		{"audit_log.json", exportAuditLogQuery},
+	} {
+		var doc []byte
+		if err := tx.QueryRow(ctx, file.query, organizationID).Scan(&doc); err != nil {
+			return fmt.Errorf("writing %s: %w", file.name, err)
+		}
+		var pretty bytes.Buffer
+		if err := json.Indent(&pretty, doc, "", "  "); err != nil {
+			return fmt.Errorf("writing %s: %w", file.name, err)
+		}
+		pretty.WriteByte('\n')
+		if err := writeExportFile(zw, file.name, pretty.Bytes(), now); err != nil {
+			return err
+		}
+	}
+
+	doc, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return fmt.Errorf("encoding manifest: %w", err)
+	}
+	if err := writeExportFile(zw, "manifest.json", append(doc, '\n'), now); err != nil {
+		return err
+	}
+	return zw.Close()
+}
+
+func writeExportFile(zw *zip.Writer, name string, body []byte, now time.Time) error {
+	f, err := zw.CreateHeader(&zip.FileHeader{Name: name, Method: zip.Deflate, Modified: now})
+	if err != nil {
+		return fmt.Errorf("adding %s: %w", name, err)
+	}
+	if _, err := f.Write(body); err != nil {
+		return fmt.Errorf("writing %s: %w", name, err)
+	}
+	return nil
+}
+
+// writeExportCSV writes the rows query returns as a CSV file named name, with
+// a header of the column names, and returns how many rows it wrote.
+func writeExportCSV(ctx context.Context, tx pgx.Tx, zw *zip.Writer, name string, now time.Time, query string, args ...any) (int64, error) {
+	rows, err := tx.Query(ctx, query, args...)
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+
+	f, err := zw.CreateHeader(&zip.FileHeader{Name: name, Method: zip.Deflate, Modified: now})
+	if err != nil {
+		return 0, err
+	}
+	cw := csv.NewWriter(f)
+	fields := rows.FieldDescriptions()
+	record := make([]string, len(fields))
+	for i, fd := range fields {
+		record[i] = fd.Name
+	}
+	if err := cw.Write(record); err != nil {
+		return 0, err
+	}
+
+	var n int64
+	for rows.Next() {
+		values, err := rows.Values()
+		if err != nil {
+			return 0, err
+		}
+		for i, v := range values {
+			record[i] = exportCSVValue(v)
+		}
+		if err := cw.Write(record); err != nil {
+			return 0, err
+		}
+		n++
+	}
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+	cw.Flush()
+	return n, cw.Error()
+}
+
+// exportCSVValue formats a column value for a CSV cell: times as RFC 3339 in
+// UTC, NULL as empty, and anything structured as JSON.
+func exportCSVValue(v any) string {
+	switch v := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case time.Time:
+		return v.UTC().Format(time.RFC3339)
+	case [16]byte:
+		return uuid.UUID(v).String()
+	case bool:
+		return strconv.FormatBool(v)
+	case int16, int32, int64, int, float32, float64:
+		return fmt.Sprint(v)
+	default:
+		b, err := json.Marshal(v)
+		if err != nil {
+			return fmt.Sprint(v)
+		}
+		return string(b)
+	}
+}
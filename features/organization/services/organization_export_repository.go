@@ -0,0 +1,233 @@
+package services
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/riverqueue/river"
+)
+
+// Organization export statuses.
+const (
+	ExportPending = "pending"
+	ExportReady   = "ready"
+	ExportFailed  = "failed"
+)
+
+// OrganizationExportTTL is how long a ready export, and the link to it, can
+// be downloaded.
+const OrganizationExportTTL = 7 * 24 * time.Hour
+
+var (
+	ErrOrganizationExportNotFound   = errors.New("organization export not found")
+	ErrOrganizationExportNotReady   = errors.New("organization export is not ready")
+	ErrOrganizationExportInProgress = errors.New("an export of this organization is already in progress")
+)
+
+// OrganizationExport is a requested archive of an organization's data; see
+// WriteArchive.
+type OrganizationExport struct {
+	ID             uuid.UUID  `json:"id"`
+	OrganizationID uuid.UUID  `json:"organization_id"`
+	Status         string     `json:"status"`
+	Filename       string     `json:"filename"`
+	Size           int64      `json:"size"`
+	SHA256         string     `json:"sha256"`
+	Error          string     `json:"error"`
+	RequestedBy    *int       `json:"requested_by,omitempty"`
+	CreatedAt      time.Time  `json:"created_at"`
+	CompletedAt    *time.Time `json:"completed_at,omitempty"`
+	ExpiresAt      *time.Time `json:"expires_at,omitempty"`
+}
+
+// BuildOrganizationExportArgs builds a pending organization export. It's
+// declared here rather than in background so Request can enqueue it in the
+// transaction that creates the export.
+type BuildOrganizationExportArgs struct {
+	ExportID uuid.UUID `json:"export_id"`
+}
+
+func (BuildOrganizationExportArgs) Kind() string {
+	return "build_organization_export"
+}
+
+func (BuildOrganizationExportArgs) InsertOpts() river.InsertOpts {
+	// background.QueueIngest, with the other bulk data jobs.
+	return river.InsertOpts{Queue: "ingest", MaxAttempts: 3}
+}
+
+type OrganizationExportRepository struct {
+	pool *pgxpool.Pool
+	// jobs may be nil in processes that only build exports.
+	jobs JobInserter
+}
+
+func NewOrganizationExportRepository(pool *pgxpool.Pool, jobs JobInserter) *OrganizationExportRepository {
+	return &OrganizationExportRepository{pool: pool, jobs: jobs}
+}
+
+const organizationExportColumns = `
+	id, organization_id, status, filename, size, sha256, error, requested_by,
+	created_at, completed_at, expires_at`
+
+func scanOrganizationExport(row pgx.Row) (*OrganizationExport, error) {
+	e := &OrganizationExport{}
+	err := row.Scan(&e.ID, &e.OrganizationID, &e.Status, &e.Filename, &e.Size, &e.SHA256, &e.Error, &e.RequestedBy,
+		&e.CreatedAt, &e.CompletedAt, &e.ExpiresAt)
+	if err != nil {
+		return nil, err
+	}
+	return e, nil
+}
+
+// Request records a pending export of the organization and enqueues its
+// build, or returns ErrOrganizationExportInProgress if one is pending.
+func (r *OrganizationExportRepository) Request(ctx context.Context, organizationID uuid.UUID, requestedBy int) (*OrganizationExport, error) {
+	if r.jobs == nil {
+		return nil, errors.New("organization export repository has no job inserter")
+	}
+
+	tx, err := r.pool.Begin(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("starting transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	export, err := scanOrganizationExport(tx.QueryRow(ctx, `
+		INSERT INTO organization_exports (organization_id, requested_by)
+		VALUES ($1, $2)
+		RETURNING`+organizationExportColumns,
+		organizationID, requestedBy))
+	if err != nil {
+		var pgErr *pgconn.PgError
+		if errors.As(err, &pgErr) && pgErr.Code == "23505" {
+			return nil, ErrOrganizationExportInProgress
+		}
+		return nil, fmt.Errorf("inserting organization export: %w", err)
+	}
+	if _, err := r.jobs.InsertTx(ctx, tx, BuildOrganizationExportArgs{ExportID: export.ID}, nil); err != nil {
+		return nil, fmt.Errorf("enqueueing organization export: %w", err)
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return nil, fmt.Errorf("committing organization export: %w", err)
+	}
+	return export, nil
+}
+
+// List returns the organization's most recent exports, newest first.
+func (r *OrganizationExportRepository) List(ctx context.Context, organizationID uuid.UUID, limit int) ([]OrganizationExport, error) {
+	rows, err := r.pool.Query(ctx, `
+		SELECT`+organizationExportColumns+`
+		FROM organization_exports
+		WHERE organization_id = $1
+		ORDER BY created_at DESC, id
+		LIMIT $2
+	`, organizationID, limit)
+	if err != nil {
+		return nil, fmt.Errorf("querying organization exports: %w", err)
+	}
+	defer rows.Close()
+
+	var exports []OrganizationExport
+	for rows.Next() {
+		export, err := scanOrganizationExport(rows)
+		if err != nil {
+			return nil, fmt.Errorf("scanning organization export: %w", err)
+		}
+		exports = append(exports, *export)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("iterating organization exports: %w", err)
+	}
+	return exports, nil
+}
+
+// Get returns an export regardless of organization, for the build job.
+func (r *OrganizationExportRepository) Get(ctx context.Context, id uuid.UUID) (*OrganizationExport, error) {
+	export, err := scanOrganizationExport(r.pool.QueryRow(ctx, `
+		SELECT`+organizationExportColumns+`
+		FROM organization_exports
+		WHERE id = $1
+	`, id))
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, ErrOrganizationExportNotFound
+		}
+		return nil, fmt.Errorf("querying organization export: %w", err)
+	}
+	return export, nil
+}
+
+// Content returns one of the organization's ready exports and its archive.
+// Expired exports are not found, even before they're deleted.
+func (r *OrganizationExportRepository) Content(ctx context.Context, organizationID, id uuid.UUID) (*OrganizationExport, []byte, error) {
+	var content []byte
+	row := r.pool.QueryRow(ctx, `
+		SELECT`+organizationExportColumns+`, content
+		FROM organization_exports
+		WHERE organization_id = $1 AND id = $2 AND (expires_at IS NULL OR expires_at > NOW())
+	`, organizationID, id)
+	e := &OrganizationExport{}
+	err := row.Scan(&e.ID, &e.OrganizationID, &e.Status, &e.Filename, &e.Size, &e.SHA256, &e.Error, &e.RequestedBy,
+		&e.CreatedAt, &e.CompletedAt, &e.ExpiresAt, &content)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, nil, ErrOrganizationExportNotFound
+		}
+		return nil, nil, fmt.Errorf("querying organization export: %w", err)
+	}
+	if e.Status != ExportReady || content == nil {
+		return nil, nil, ErrOrganizationExportNotReady
+	}
+	return e, content, nil
+}
+
+// Complete stores a pending export's archive, which expires
+// OrganizationExportTTL from now.
+func (r *OrganizationExportRepository) Complete(ctx context.Context, id uuid.UUID, filename string, content []byte) error {
+	sum := sha256.Sum256(content)
+	if _, err := r.pool.Exec(ctx, `
+		UPDATE organization_exports
+		SET status = 'ready', filename = $2, content = $3, size = $4, sha256 = $5,
+			completed_at = NOW(), expires_at = NOW() + $6 * INTERVAL '1 second'
+		WHERE id = $1 AND status = 'pending'
+	`, id, filename, content, len(content), hex.EncodeToString(sum[:]), int(OrganizationExportTTL.Seconds())); err != nil {
+		return fmt.Errorf("completing organization export: %w", err)
+	}
+	return nil
+}
+
+// Fail marks a pending export as failed with a message for the requester.
+func (r *OrganizationExportRepository) Fail(ctx context.Context, id uuid.UUID, message string) error {
+	if _, err := r.pool.Exec(ctx, `
+		UPDATE organization_exports
+		SET status = 'failed', error = $2, completed_at = NOW()
+		WHERE id = $1 AND status = 'pending'
+	`, id, message); err != nil {
+		return fmt.Errorf("failing organization export: %w", err)
+	}
+	return nil
+}
+
+// PurgeExpired deletes ready exports that expired before now, and failed
+// ones that ended more than OrganizationExportTTL before it. It returns how
+// many it deleted.
+func (r *OrganizationExportRepository) PurgeExpired(ctx context.Context, now time.Time) (int64, error) {
+	tag, err := r.pool.Exec(ctx, `
+		DELETE FROM organization_exports
+		WHERE (status = 'ready' AND expires_at <= $1)
+			OR (status = 'failed' AND completed_at <= $2)
+	`, now, now.Add(-OrganizationExportTTL))
+	if err != nil {
+		return 0, fmt.Errorf("purging organization exports: %w", err)
+	}
+	return tag.RowsAffected(), nil
+}
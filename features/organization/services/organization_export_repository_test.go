@@ -0,0 +1,134 @@
+package services_test
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"io"
+	"testing"
+	"time"
+
+	orgservices "github.com/cavenine/queryops/features/organization/services"
+	"github.com/cavenine/queryops/internal/testdb"
+	"github.com/cavenine/queryops/internal/testdb/fixtures"
+)
+
+func TestOrganizationExportRepository(t *testing.T) {
+	tdb := testdb.SetupTestDB(t)
+	ctx := context.Background()
+
+	orgID := fixtures.CreateOrg(t, tdb.Pool, "export-org").ID
+	otherOrgID := fixtures.CreateOrg(t, tdb.Pool, "other-org").ID
+	user := fixtures.CreateUser(t, tdb.Pool, "admin@example.com")
+	fixtures.AddMember(t, tdb.Pool, orgID, user.ID, "admin")
+	host := fixtures.CreateHost(t, tdb.Pool, orgID, "host-a")
+	fixtures.CreateHost(t, tdb.Pool, otherOrgID, "host-b")
+	fixtures.CreateCampaign(t, tdb.Pool, orgID, "SELECT 1", host.ID)
+	jobs := &recordingInserter{}
+	repo := orgservices.NewOrganizationExportRepository(tdb.Pool, jobs)
+
+	export, err := repo.Request(ctx, orgID, user.ID)
+	if err != nil {
+		t.Fatalf("Request: %v", err)
+	}
+	if export.Status != orgservices.ExportPending {
+		t.Fatalf("status = %q, want pending", export.Status)
+	}
+	if args, ok := jobs.args[0].(orgservices.BuildOrganizationExportArgs); !ok || args.ExportID != export.ID {
+		t.Fatalf("job = %#v", jobs.args[0])
+	}
+	if _, err := repo.Request(ctx, orgID, user.ID); !errors.Is(err, orgservices.ErrOrganizationExportInProgress) {
+		t.Fatalf("second Request err = %v, want ErrOrganizationExportInProgress", err)
+	}
+	if _, _, err := repo.Content(ctx, orgID, export.ID); !errors.Is(err, orgservices.ErrOrganizationExportNotReady) {
+		t.Fatalf("Content of pending export err = %v", err)
+	}
+
+	var archive bytes.Buffer
+	if err := repo.WriteArchive(ctx, orgID, &archive, time.Now()); err != nil {
+		t.Fatalf("WriteArchive: %v", err)
+	}
+	zr, err := zip.NewReader(bytes.NewReader(archive.Bytes()), int64(archive.Len()))
+	if err != nil {
+		t.Fatalf("reading archive: %v", err)
+	}
+	files := map[string][]byte{}
+	for _, f := range zr.File {
+		rc, err := f.Open()
+		if err != nil {
+			t.Fatalf("opening %s: %v", f.Name, err)
+		}
+		body, err := io.ReadAll(rc)
+		rc.Close()
+		if err != nil {
+			t.Fatalf("reading %s: %v", f.Name, err)
+		}
+		files[f.Name] = body
+	}
+	for _, name := range []string{"members.csv", "hosts.csv", "campaigns.csv", "campaign_targets.csv", "scheduled_results.csv", "settings.json", "audit_log.json", "manifest.json"} {
+		if _, ok := files[name]; !ok {
+			t.Fatalf("archive is missing %s", name)
+		}
+	}
+	hosts, err := csv.NewReader(bytes.NewReader(files["hosts.csv"])).ReadAll()
+	if err != nil {
+		t.Fatalf("parsing hosts.csv: %v", err)
+	}
+	if len(hosts) != 2 || hosts[0][1] != "host_identifier" || hosts[1][1] != "host-a" {
+		t.Fatalf("hosts.csv = %v", hosts)
+	}
+	var manifest struct {
+		OrganizationName string           `json:"organization_name"`
+		Rows             map[string]int64 `json:"rows"`
+	}
+	if err := json.Unmarshal(files["manifest.json"], &manifest); err != nil {
+		t.Fatalf("parsing manifest.json: %v", err)
+	}
+	if manifest.OrganizationName != "export-org" || manifest.Rows["members.csv"] != 1 || manifest.Rows["hosts.csv"] != 1 || manifest.Rows["campaign_targets.csv"] != 1 {
+		t.Fatalf("manifest = %+v", manifest)
+	}
+
+	if err := repo.Complete(ctx, export.ID, "queryops-export.zip", archive.Bytes()); err != nil {
+		t.Fatalf("Complete: %v", err)
+	}
+	got, content, err := repo.Content(ctx, orgID, export.ID)
+	if err != nil {
+		t.Fatalf("Content: %v", err)
+	}
+	if !bytes.Equal(content, archive.Bytes()) || got.Status != orgservices.ExportReady || got.Size != int64(archive.Len()) || got.ExpiresAt == nil {
+		t.Fatalf("Content = %+v", got)
+	}
+	if _, _, err := repo.Content(ctx, otherOrgID, export.ID); !errors.Is(err, orgservices.ErrOrganizationExportNotFound) {
+		t.Fatalf("Content from another org err = %v", err)
+	}
+
+	// Once the first is ready, another can be requested.
+	second, err := repo.Request(ctx, orgID, user.ID)
+	if err != nil {
+		t.Fatalf("Request after ready: %v", err)
+	}
+	if err := repo.Fail(ctx, second.ID, "timed out"); err != nil {
+		t.Fatalf("Fail: %v", err)
+	}
+	list, err := repo.List(ctx, orgID, 5)
+	if err != nil {
+		t.Fatalf("List: %v", err)
+	}
+	if len(list) != 2 || list[0].ID != second.ID || list[0].Status != orgservices.ExportFailed || list[0].Error != "timed out" {
+		t.Fatalf("List = %+v", list)
+	}
+
+	if n, err := repo.PurgeExpired(ctx, time.Now()); err != nil || n != 0 {
+		t.Fatalf("PurgeExpired(now) = %d, %v; want 0", n, err)
+	}
+	if n, err := repo.PurgeExpired(ctx, time.Now().Add(orgservices.OrganizationExportTTL+time.Hour)); err != nil || n != 2 {
+		t.Fatalf("PurgeExpired(after TTL) = %d, %v; want 2", n, err)
+	}
+
+	if err := repo.WriteArchive(ctx, fixtures.CreateOrg(t, tdb.Pool, "empty-org").ID, io.Discard, time.Now()); err != nil {
+		t.Fatalf("WriteArchive of an empty organization: %v", err)
+	}
+}
@@ -2,21 +2,58 @@ package services
 
 import (
 	"context"
+	"crypto/sha256"
 	"errors"
 	"fmt"
+	"slices"
 	"time"
 
 	"github.com/google/uuid"
 	"github.com/jackc/pgx/v5"
 	"github.com/jackc/pgx/v5/pgconn"
 	"github.com/jackc/pgx/v5/pgxpool"
+
+	"github.com/cavenine/queryops/db"
+	"github.com/cavenine/queryops/internal/crypto"
+)
+
+// Member roles. Owners and admins manage the organization's settings; only
+// owners may delete it.
+const (
+	RoleOwner  = "owner"
+	RoleAdmin  = "admin"
+	RoleMember = "member"
 )
 
 type Organization struct {
-	ID        uuid.UUID `json:"id"`
-	Name      string    `json:"name"`
-	CreatedAt time.Time `json:"created_at"`
-	UpdatedAt time.Time `json:"updated_at"`
+	ID        uuid.UUID `json:"id" db:"id"`
+	Name      string    `json:"name" db:"name"`
+	CreatedAt time.Time `json:"created_at" db:"created_at"`
+	UpdatedAt time.Time `json:"updated_at" db:"updated_at"`
+
+	// DisabledAt is when a superuser disabled the organization, or nil.
+	DisabledAt *time.Time `json:"disabled_at,omitempty" db:"disabled_at"`
+
+	// Role is the user's role in the organization, set by
+	// GetUserOrganizations.
+	Role string `json:"role,omitempty" db:"role"`
+
+	// PlanID and PlanName are the organization's plan. Features are the
+	// plan's plus any a superuser granted on top.
+	PlanID   string   `json:"plan_id" db:"plan_id"`
+	PlanName string   `json:"plan_name" db:"plan_name"`
+	Features []string `json:"features" db:"features"`
+}
+
+// CanManage reports whether the user may change the organization's settings.
+func (o *Organization) CanManage() bool {
+	return o.Role == RoleOwner || o.Role == RoleAdmin
+}
+
+// HasFeature reports whether the organization's plan, or a superuser's
+// override, includes feature.
+func (o *Organization) HasFeature(feature string) bool {
+	return slices.Contains(o.Features, feature)
 }
 
 type OrganizationMember struct {
@@ -33,34 +70,74 @@ type OrganizationEnrollSecret struct {
 	CreatedAt      time.Time `json:"created_at"`
 }
 
-var ErrOrganizationNotFound = errors.New("organization not found")
+var (
+	ErrOrganizationNotFound  = errors.New("organization not found")
+	ErrDuplicateOrganization = errors.New("organization name already exists")
+)
+
+// organizationPlanColumns selects an organization's plan and features, for
+// queries joining organizations o to plans p.
+const organizationPlanColumns = `o.plan_id, p.name AS plan_name,
+	ARRAY(SELECT DISTINCT unnest(p.features || o.extra_features) ORDER BY 1) AS features`
 
 type OrganizationRepository struct {
 	pool *pgxpool.Pool
+	// keys encrypts enroll secrets at rest.
+	keys *crypto.Keyring
+	// defaultPlan is the plan new organizations start on.
+	defaultPlan string
+}
+
+func NewOrganizationRepository(pool *pgxpool.Pool, keys *crypto.Keyring) *OrganizationRepository {
+	return &OrganizationRepository{pool: pool, keys: keys, defaultPlan: PlanEnterprise}
 }
 
-func NewOrganizationRepository(pool *pgxpool.Pool) *OrganizationRepository {
-	return &OrganizationRepository{pool: pool}
+// SetDefaultPlan sets the plan new organizations start on. An empty plan
+// leaves it unchanged.
+func (r *OrganizationRepository) SetDefaultPlan(plan string) {
+	if plan != "" {
+		r.defaultPlan = plan
+	}
 }
 
 func (r *OrganizationRepository) Create(ctx context.Context, name string, ownerID int) (*Organization, error) {
-	tx, err := r.pool.Begin(ctx)
+	var org *Organization
+	err := db.WithTx(ctx, r.pool, func(tx pgx.Tx) error {
+		var err error
+		org, err = r.CreateTx(ctx, tx, name, ownerID)
+		return err
+	})
 	if err != nil {
-		return nil, fmt.Errorf("starting transaction: %w", err)
+		return nil, err
 	}
-	defer tx.Rollback(ctx)
+	return org, nil
+}
 
-	org := &Organization{}
-	err = tx.QueryRow(ctx, `
-		INSERT INTO organizations (name)
-		VALUES ($1)
-		RETURNING id, name, created_at, updated_at
-	`, name).Scan(&org.ID, &org.Name, &org.CreatedAt, &org.UpdatedAt)
+// CreateTx is Create in tx.
+func (r *OrganizationRepository) CreateTx(ctx context.Context, tx pgx.Tx, name string, ownerID int) (*Organization, error) {
+	rows, err := tx.Query(ctx, `
+		WITH o AS (
+			INSERT INTO organizations (name, plan_id)
+			VALUES ($1, $2)
+			RETURNING *
+		)
+		SELECT o.id, o.name, o.created_at, o.updated_at, `+organizationPlanColumns+`
+		FROM o
+		JOIN plans p ON p.id = o.plan_id
+	`, name, r.defaultPlan)
+	if err != nil {
+		return nil, fmt.Errorf("inserting organization: %w", err)
+	}
 
+	// A unique violation is reported when the row is read.
+	org, err := pgx.CollectOneRow(rows, pgx.RowToAddrOfStructByNameLax[Organization])
 	if err != nil {
 		var pgErr *pgconn.PgError
 		if errors.As(err, &pgErr) && pgErr.Code == "23505" {
-			return nil, errors.New("organization name already exists")
+			return nil, ErrDuplicateOrganization
+		}
+		if errors.As(err, &pgErr) && pgErr.Code == "23503" {
+			return nil, fmt.Errorf("default plan %q: %w", r.defaultPlan, ErrPlanNotFound)
 		}
 		return nil, fmt.Errorf("inserting organization: %w", err)
 	}
@@ -74,21 +151,21 @@ func (r *OrganizationRepository) Create(ctx context.Context, name string, ownerI
 		return nil, fmt.Errorf("adding owner: %w", err)
 	}
 
-	if err := tx.Commit(ctx); err != nil {
-		return nil, fmt.Errorf("committing transaction: %w", err)
-	}
-
 	return org, nil
 }
 
 func (r *OrganizationRepository) GetByID(ctx context.Context, id uuid.UUID) (*Organization, error) {
-	org := &Organization{}
-	err := r.pool.QueryRow(ctx, `
-		SELECT id, name, created_at, updated_at
-		FROM organizations
-		WHERE id = $1
-	`, id).Scan(&org.ID, &org.Name, &org.CreatedAt, &org.UpdatedAt)
+	rows, err := r.pool.Query(ctx, `
+		SELECT o.id, o.name, o.created_at, o.updated_at, o.disabled_at, `+organizationPlanColumns+`
+		FROM organizations o
+		JOIN plans p ON p.id = o.plan_id
+		WHERE o.id = $1
+	`, id)
+	if err != nil {
+		return nil, fmt.Errorf("querying organization by id: %w", err)
+	}
 
+	org, err := pgx.CollectOneRow(rows, pgx.RowToAddrOfStructByNameLax[Organization])
 	if err != nil {
 		if errors.Is(err, pgx.ErrNoRows) {
 			return nil, ErrOrganizationNotFound
@@ -98,41 +175,69 @@ func (r *OrganizationRepository) GetByID(ctx context.Context, id uuid.UUID) (*Or
 	return org, nil
 }
 
+// GetUserOrganizations lists the organizations userID belongs to. Disabled
+// organizations are left out, which keeps their members out of them.
 func (r *OrganizationRepository) GetUserOrganizations(ctx context.Context, userID int) ([]*Organization, error) {
 	rows, err := r.pool.Query(ctx, `
-		SELECT o.id, o.name, o.created_at, o.updated_at
+		SELECT o.id, o.name, o.created_at, o.updated_at, om.role, `+organizationPlanColumns+`
 		FROM organizations o
 		JOIN organization_members om ON o.id = om.organization_id
-		WHERE om.user_id = $1
+		JOIN plans p ON p.id = o.plan_id
+		WHERE om.user_id = $1 AND o.disabled_at IS NULL
 		ORDER BY o.created_at ASC
 	`, userID)
 	if err != nil {
 		return nil, fmt.Errorf("querying user organizations: %w", err)
 	}
-	defer rows.Close()
+	orgs, err := pgx.CollectRows(rows, pgx.RowToAddrOfStructByNameLax[Organization])
+	if err != nil {
+		return nil, fmt.Errorf("querying user organizations: %w", err)
+	}
+	return orgs, nil
+}
 
-	var orgs []*Organization
-	for rows.Next() {
-		org := &Organization{}
-		if err := rows.Scan(&org.ID, &org.Name, &org.CreatedAt, &org.UpdatedAt); err != nil {
-			return nil, fmt.Errorf("scanning organization: %w", err)
+// Rename changes the organization's name, which must stay unique.
+func (r *OrganizationRepository) Rename(ctx context.Context, id uuid.UUID, name string) error {
+	tag, err := r.pool.Exec(ctx, `
+		UPDATE organizations
+		SET name = $2, updated_at = NOW()
+		WHERE id = $1
+	`, id, name)
+	if err != nil {
+		var pgErr *pgconn.PgError
+		if errors.As(err, &pgErr) && pgErr.Code == "23505" {
+			return ErrDuplicateOrganization
 		}
-		orgs = append(orgs, org)
+		return fmt.Errorf("renaming organization: %w", err)
 	}
-	if err := rows.Err(); err != nil {
-		return nil, fmt.Errorf("querying user organizations: %w", err)
+	if tag.RowsAffected() == 0 {
+		return ErrOrganizationNotFound
 	}
-	return orgs, nil
+	return nil
 }
 
-func (r *OrganizationRepository) AddEnrollSecret(ctx context.Context, organizationID uuid.UUID, secret string) error {
-	tx, err := r.pool.Begin(ctx)
+// Delete removes the organization. Its hosts, results, members, and settings
+// go with it.
+func (r *OrganizationRepository) Delete(ctx context.Context, id uuid.UUID) error {
+	tag, err := r.pool.Exec(ctx, `DELETE FROM organizations WHERE id = $1`, id)
 	if err != nil {
-		return fmt.Errorf("starting transaction: %w", err)
+		return fmt.Errorf("deleting organization: %w", err)
 	}
-	defer tx.Rollback(ctx)
+	if tag.RowsAffected() == 0 {
+		return ErrOrganizationNotFound
+	}
+	return nil
+}
 
-	_, err = tx.Exec(ctx, `
+func (r *OrganizationRepository) AddEnrollSecret(ctx context.Context, organizationID uuid.UUID, secret string) error {
+	return db.WithTx(ctx, r.pool, func(tx pgx.Tx) error {
+		return r.AddEnrollSecretTx(ctx, tx, organizationID, secret)
+	})
+}
+
+// AddEnrollSecretTx is AddEnrollSecret in tx.
+func (r *OrganizationRepository) AddEnrollSecretTx(ctx context.Context, tx pgx.Tx, organizationID uuid.UUID, secret string) error {
+	_, err := tx.Exec(ctx, `
 		UPDATE organization_enroll_secrets
 		SET active = false
 		WHERE organization_id = $1 AND active = true
@@ -141,30 +246,40 @@ func (r *OrganizationRepository) AddEnrollSecret(ctx context.Context, organizati
 		return fmt.Errorf("deactivating enroll secrets: %w", err)
 	}
 
+	ciphertext, err := r.keys.Encrypt([]byte(secret), organizationID[:])
+	if err != nil {
+		return fmt.Errorf("encrypting enroll secret: %w", err)
+	}
 	_, err = tx.Exec(ctx, `
-		INSERT INTO organization_enroll_secrets (secret, organization_id, active)
-		VALUES ($1, $2, true)
-	`, secret, organizationID)
+		INSERT INTO organization_enroll_secrets (secret_hash, secret_ciphertext, organization_id, active)
+		VALUES ($1, $2, $3, true)
+	`, enrollSecretHash(secret), ciphertext, organizationID)
 	if err != nil {
 		return fmt.Errorf("inserting enroll secret: %w", err)
 	}
 
-	if err := tx.Commit(ctx); err != nil {
-		return fmt.Errorf("committing enroll secret: %w", err)
-	}
-
 	return nil
 }
 
+// WithTx runs fn in a transaction on the repository's pool; see db.WithTx.
+func (r *OrganizationRepository) WithTx(ctx context.Context, fn func(tx pgx.Tx) error) error {
+	return db.WithTx(ctx, r.pool, fn)
+}
+
+// GetOrganizationByEnrollSecret finds the enabled organization whose active
+// enroll secret is secret.
 func (r *OrganizationRepository) GetOrganizationByEnrollSecret(ctx context.Context, secret string) (*Organization, error) {
-	org := &Organization{}
-	err := r.pool.QueryRow(ctx, `
+	rows, err := r.pool.Query(ctx, `
 		SELECT o.id, o.name, o.created_at, o.updated_at
 		FROM organizations o
 		JOIN organization_enroll_secrets oes ON o.id = oes.organization_id
-		WHERE oes.secret = $1 AND oes.active = true
-	`, secret).Scan(&org.ID, &org.Name, &org.CreatedAt, &org.UpdatedAt)
+		WHERE oes.secret_hash = $1 AND oes.active = true AND o.disabled_at IS NULL
+	`, enrollSecretHash(secret))
+	if err != nil {
+		return nil, fmt.Errorf("querying organization by secret: %w", err)
+	}
 
+	org, err := pgx.CollectOneRow(rows, pgx.RowToAddrOfStructByNameLax[Organization])
 	if err != nil {
 		if errors.Is(err, pgx.ErrNoRows) {
 			return nil, ErrOrganizationNotFound
@@ -176,13 +291,14 @@ func (r *OrganizationRepository) GetOrganizationByEnrollSecret(ctx context.Conte
 
 func (r *OrganizationRepository) GetActiveEnrollSecret(ctx context.Context, organizationID uuid.UUID) (*OrganizationEnrollSecret, error) {
 	secret := &OrganizationEnrollSecret{}
+	var plaintext, ciphertext *string
 	err := r.pool.QueryRow(ctx, `
-		SELECT secret, organization_id, active, created_at
+		SELECT secret, secret_ciphertext, organization_id, active, created_at
 		FROM organization_enroll_secrets
 		WHERE organization_id = $1 AND active = true
 		ORDER BY created_at DESC
 		LIMIT 1
-	`, organizationID).Scan(&secret.Secret, &secret.OrganizationID, &secret.Active, &secret.CreatedAt)
+	`, organizationID).Scan(&plaintext, &ciphertext, &secret.OrganizationID, &secret.Active, &secret.CreatedAt)
 
 	if err != nil {
 		if errors.Is(err, pgx.ErrNoRows) {
@@ -190,5 +306,100 @@ func (r *OrganizationRepository) GetActiveEnrollSecret(ctx context.Context, orga
 		}
 		return nil, fmt.Errorf("querying active secret: %w", err)
 	}
+	secret.Secret, err = r.decryptEnrollSecret(organizationID, plaintext, ciphertext)
+	if err != nil {
+		return nil, err
+	}
 	return secret, nil
 }
+
+// RotateEnrollSecrets re-encrypts up to batchSize enroll secrets that are
+// stored in plaintext or under a key other than the keyring's primary, and
+// returns how many it rewrote. Call it until it returns 0.
+func (r *OrganizationRepository) RotateEnrollSecrets(ctx context.Context, batchSize int) (int, error) {
+	tx, err := r.pool.Begin(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("starting transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	rows, err := tx.Query(ctx, `
+		SELECT secret_hash, organization_id, secret, secret_ciphertext
+		FROM organization_enroll_secrets
+		WHERE secret IS NOT NULL OR split_part(secret_ciphertext, ':', 2) <> $1
+		ORDER BY secret_hash
+		LIMIT $2
+		FOR UPDATE SKIP LOCKED
+	`, r.keys.PrimaryID(), batchSize)
+	if err != nil {
+		return 0, fmt.Errorf("querying enroll secrets to rotate: %w", err)
+	}
+	type rotation struct {
+		hash       []byte
+		ciphertext string
+	}
+	var rotations []rotation
+	for rows.Next() {
+		var (
+			hash                  []byte
+			orgID                 uuid.UUID
+			plaintext, ciphertext *string
+		)
+		if err := rows.Scan(&hash, &orgID, &plaintext, &ciphertext); err != nil {
+			rows.Close()
+			return 0, fmt.Errorf("scanning enroll secret: %w", err)
+		}
+		secret, err := r.decryptEnrollSecret(orgID, plaintext, ciphertext)
+		if err != nil {
+			rows.Close()
+			return 0, err
+		}
+		rotated, err := r.keys.Encrypt([]byte(secret), orgID[:])
+		if err != nil {
+			rows.Close()
+			return 0, fmt.Errorf("encrypting enroll secret: %w", err)
+		}
+		rotations = append(rotations, rotation{hash: hash, ciphertext: rotated})
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, fmt.Errorf("iterating enroll secrets: %w", err)
+	}
+
+	for _, rot := range rotations {
+		if _, err := tx.Exec(ctx, `
+			UPDATE organization_enroll_secrets
+			SET secret = NULL, secret_ciphertext = $2
+			WHERE secret_hash = $1
+		`, rot.hash, rot.ciphertext); err != nil {
+			return 0, fmt.Errorf("updating enroll secret: %w", err)
+		}
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return 0, fmt.Errorf("committing enroll secrets: %w", err)
+	}
+	return len(rotations), nil
+}
+
+// decryptEnrollSecret returns a secret stored either encrypted or, before
+// RotateEnrollSecrets has run, in plaintext.
+func (r *OrganizationRepository) decryptEnrollSecret(organizationID uuid.UUID, plaintext, ciphertext *string) (string, error) {
+	if ciphertext == nil {
+		if plaintext == nil {
+			return "", errors.New("enroll secret has no value")
+		}
+		return *plaintext, nil
+	}
+	secret, err := r.keys.Decrypt(*ciphertext, organizationID[:])
+	if err != nil {
+		return "", fmt.Errorf("decrypting enroll secret: %w", err)
+	}
+	return string(secret), nil
+}
+
+// enrollSecretHash is the lookup key for an enroll secret. Secrets are random,
+// so an unsalted hash is enough to keep them out of the index.
+func enrollSecretHash(secret string) []byte {
+	sum := sha256.Sum256([]byte(secret))
+	return sum[:]
+}
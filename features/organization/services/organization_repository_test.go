@@ -7,20 +7,33 @@ import (
 	"testing"
 
 	orgservices "github.com/cavenine/queryops/features/organization/services"
+	"github.com/cavenine/queryops/internal/crypto"
 	"github.com/cavenine/queryops/internal/testdb"
+	"github.com/cavenine/queryops/internal/testdb/fixtures"
 	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
 )
 
+func testKeyring(t *testing.T) *crypto.Keyring {
+	t.Helper()
+	spec, err := crypto.GenerateKey("test")
+	if err != nil {
+		t.Fatalf("GenerateKey: %v", err)
+	}
+	keys, err := crypto.ParseKeyring(spec)
+	if err != nil {
+		t.Fatalf("ParseKeyring: %v", err)
+	}
+	return keys
+}
+
 func TestOrganizationRepository_Create(t *testing.T) {
 	tdb := testdb.SetupTestDB(t)
 	ctx := context.Background()
 
-	var ownerID int
-	if err := tdb.Pool.QueryRow(ctx, `INSERT INTO users (email, password_hash) VALUES ($1, $2) RETURNING id`, "owner@example.com", "hash").Scan(&ownerID); err != nil {
-		t.Fatalf("insert owner: %v", err)
-	}
+	ownerID := fixtures.CreateUser(t, tdb.Pool, "owner@example.com").ID
 
-	repo := orgservices.NewOrganizationRepository(tdb.Pool)
+	repo := orgservices.NewOrganizationRepository(tdb.Pool, testKeyring(t))
 
 	tests := []struct {
 		name        string
@@ -81,12 +94,9 @@ func TestOrganizationRepository_GetByID(t *testing.T) {
 	tdb := testdb.SetupTestDB(t)
 	ctx := context.Background()
 
-	var userID int
-	if err := tdb.Pool.QueryRow(ctx, `INSERT INTO users (email, password_hash) VALUES ($1, $2) RETURNING id`, "user@example.com", "hash").Scan(&userID); err != nil {
-		t.Fatalf("insert user: %v", err)
-	}
+	userID := fixtures.CreateUser(t, tdb.Pool, "user@example.com").ID
 
-	repo := orgservices.NewOrganizationRepository(tdb.Pool)
+	repo := orgservices.NewOrganizationRepository(tdb.Pool, testKeyring(t))
 	org, err := repo.Create(ctx, "Test Org", userID)
 	if err != nil {
 		t.Fatalf("Create() error = %v", err)
@@ -134,15 +144,10 @@ func TestOrganizationRepository_GetUserOrganizations(t *testing.T) {
 	tdb := testdb.SetupTestDB(t)
 	ctx := context.Background()
 
-	var userID1, userID2 int
-	if err := tdb.Pool.QueryRow(ctx, `INSERT INTO users (email, password_hash) VALUES ($1, $2) RETURNING id`, "user1@example.com", "hash").Scan(&userID1); err != nil {
-		t.Fatalf("insert user1: %v", err)
-	}
-	if err := tdb.Pool.QueryRow(ctx, `INSERT INTO users (email, password_hash) VALUES ($1, $2) RETURNING id`, "user2@example.com", "hash").Scan(&userID2); err != nil {
-		t.Fatalf("insert user2: %v", err)
-	}
+	userID1 := fixtures.CreateUser(t, tdb.Pool, "user1@example.com").ID
+	userID2 := fixtures.CreateUser(t, tdb.Pool, "user2@example.com").ID
 
-	repo := orgservices.NewOrganizationRepository(tdb.Pool)
+	repo := orgservices.NewOrganizationRepository(tdb.Pool, testKeyring(t))
 
 	if _, err := repo.Create(ctx, "Org 1", userID1); err != nil {
 		t.Fatalf("Create(Org 1) error = %v", err)
@@ -155,9 +160,7 @@ func TestOrganizationRepository_GetUserOrganizations(t *testing.T) {
 		t.Fatalf("Create(Org 3) error = %v", err)
 	}
 
-	if _, err := tdb.Pool.Exec(ctx, `INSERT INTO organization_members (user_id, organization_id, role) VALUES ($1, $2, 'member')`, userID1, org3.ID); err != nil {
-		t.Fatalf("seed member: %v", err)
-	}
+	fixtures.AddMember(t, tdb.Pool, org3.ID, userID1, "member")
 
 	tests := []struct {
 		name    string
@@ -198,12 +201,9 @@ func TestOrganizationRepository_AddEnrollSecret(t *testing.T) {
 	tdb := testdb.SetupTestDB(t)
 	ctx := context.Background()
 
-	var userID int
-	if err := tdb.Pool.QueryRow(ctx, `INSERT INTO users (email, password_hash) VALUES ($1, $2) RETURNING id`, "user@example.com", "hash").Scan(&userID); err != nil {
-		t.Fatalf("insert user: %v", err)
-	}
+	userID := fixtures.CreateUser(t, tdb.Pool, "user@example.com").ID
 
-	repo := orgservices.NewOrganizationRepository(tdb.Pool)
+	repo := orgservices.NewOrganizationRepository(tdb.Pool, testKeyring(t))
 	org, err := repo.Create(ctx, "Test Org", userID)
 	if err != nil {
 		t.Fatalf("Create() error = %v", err)
@@ -214,12 +214,18 @@ func TestOrganizationRepository_AddEnrollSecret(t *testing.T) {
 		t.Fatalf("AddEnrollSecret(first) error = %v", err)
 	}
 
-	var activeSecret string
-	if err := tdb.Pool.QueryRow(ctx, `SELECT secret FROM organization_enroll_secrets WHERE organization_id = $1 AND active = true`, org.ID).Scan(&activeSecret); err != nil {
+	var (
+		plaintext  *string
+		ciphertext string
+	)
+	if err := tdb.Pool.QueryRow(ctx, `SELECT secret, secret_ciphertext FROM organization_enroll_secrets WHERE organization_id = $1 AND active = true`, org.ID).Scan(&plaintext, &ciphertext); err != nil {
 		t.Fatalf("select active secret: %v", err)
 	}
-	if activeSecret != secret1 {
-		t.Fatalf("active secret = %q, want %q", activeSecret, secret1)
+	if plaintext != nil || strings.Contains(ciphertext, secret1) {
+		t.Fatalf("secret stored in plaintext: %v, %q", plaintext, ciphertext)
+	}
+	if got, err := repo.GetActiveEnrollSecret(ctx, org.ID); err != nil || got.Secret != secret1 {
+		t.Fatalf("GetActiveEnrollSecret = %+v, %v; want %q", got, err, secret1)
 	}
 
 	secret2 := "secret-002"
@@ -227,11 +233,8 @@ func TestOrganizationRepository_AddEnrollSecret(t *testing.T) {
 		t.Fatalf("AddEnrollSecret(second) error = %v", err)
 	}
 
-	if err := tdb.Pool.QueryRow(ctx, `SELECT secret FROM organization_enroll_secrets WHERE organization_id = $1 AND active = true`, org.ID).Scan(&activeSecret); err != nil {
-		t.Fatalf("select active secret after rotation: %v", err)
-	}
-	if activeSecret != secret2 {
-		t.Fatalf("active secret after rotation = %q, want %q", activeSecret, secret2)
+	if got, err := repo.GetActiveEnrollSecret(ctx, org.ID); err != nil || got.Secret != secret2 {
+		t.Fatalf("GetActiveEnrollSecret after rotation = %+v, %v; want %q", got, err, secret2)
 	}
 
 	var inactiveCount int
@@ -243,16 +246,45 @@ func TestOrganizationRepository_AddEnrollSecret(t *testing.T) {
 	}
 }
 
-func TestOrganizationRepository_GetOrganizationByEnrollSecret(t *testing.T) {
+func TestOrganizationService_CreateInOneTransaction(t *testing.T) {
 	tdb := testdb.SetupTestDB(t)
 	ctx := context.Background()
 
-	var userID int
-	if err := tdb.Pool.QueryRow(ctx, `INSERT INTO users (email, password_hash) VALUES ($1, $2) RETURNING id`, "user@example.com", "hash").Scan(&userID); err != nil {
-		t.Fatalf("insert user: %v", err)
+	userID := fixtures.CreateUser(t, tdb.Pool, "owner@example.com").ID
+	repo := orgservices.NewOrganizationRepository(tdb.Pool, testKeyring(t))
+
+	org, err := orgservices.NewOrganizationService(repo).Create(ctx, "Acme", userID)
+	if err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	if got, err := repo.GetActiveEnrollSecret(ctx, org.ID); err != nil || got == nil || !strings.HasPrefix(got.Secret, "acme-") {
+		t.Fatalf("GetActiveEnrollSecret = %+v, %v", got, err)
+	}
+
+	// A failure after the organization is inserted leaves nothing behind.
+	failed := errors.New("later write failed")
+	err = repo.WithTx(ctx, func(tx pgx.Tx) error {
+		if _, err := repo.CreateTx(ctx, tx, "Abandoned", userID); err != nil {
+			return err
+		}
+		return failed
+	})
+	if !errors.Is(err, failed) {
+		t.Fatalf("WithTx err = %v, want %v", err, failed)
+	}
+	orgs, err := repo.GetUserOrganizations(ctx, userID)
+	if err != nil || len(orgs) != 1 || orgs[0].ID != org.ID {
+		t.Fatalf("GetUserOrganizations = %+v, %v; want only %s", orgs, err, org.ID)
 	}
+}
+
+func TestOrganizationRepository_GetOrganizationByEnrollSecret(t *testing.T) {
+	tdb := testdb.SetupTestDB(t)
+	ctx := context.Background()
+
+	userID := fixtures.CreateUser(t, tdb.Pool, "user@example.com").ID
 
-	repo := orgservices.NewOrganizationRepository(tdb.Pool)
+	repo := orgservices.NewOrganizationRepository(tdb.Pool, testKeyring(t))
 	org, err := repo.Create(ctx, "Test Org", userID)
 	if err != nil {
 		t.Fatalf("Create() error = %v", err)
@@ -311,16 +343,119 @@ func TestOrganizationRepository_GetOrganizationByEnrollSecret(t *testing.T) {
 	}
 }
 
-func TestOrganizationRepository_GetActiveEnrollSecret(t *testing.T) {
+func TestOrganizationRepository_Roles(t *testing.T) {
+	tdb := testdb.SetupTestDB(t)
+	ctx := context.Background()
+
+	ownerID := fixtures.CreateUser(t, tdb.Pool, "owner@example.com").ID
+	adminID := fixtures.CreateUser(t, tdb.Pool, "admin@example.com").ID
+
+	repo := orgservices.NewOrganizationRepository(tdb.Pool, testKeyring(t))
+	org, err := repo.Create(ctx, "Role Org", ownerID)
+	if err != nil {
+		t.Fatalf("Create() error = %v", err)
+	}
+	fixtures.AddMember(t, tdb.Pool, org.ID, adminID, orgservices.RoleAdmin)
+
+	for userID, want := range map[int]string{ownerID: orgservices.RoleOwner, adminID: orgservices.RoleAdmin} {
+		orgs, err := repo.GetUserOrganizations(ctx, userID)
+		if err != nil || len(orgs) != 1 {
+			t.Fatalf("GetUserOrganizations(%d) = %v, %v", userID, orgs, err)
+		}
+		if orgs[0].Role != want {
+			t.Errorf("role of user %d = %q, want %q", userID, orgs[0].Role, want)
+		}
+	}
+}
+
+func TestOrganizationRepository_RenameAndDelete(t *testing.T) {
+	tdb := testdb.SetupTestDB(t)
+	ctx := context.Background()
+
+	userID := fixtures.CreateUser(t, tdb.Pool, "user@example.com").ID
+
+	repo := orgservices.NewOrganizationRepository(tdb.Pool, testKeyring(t))
+	org, err := repo.Create(ctx, "Old Name", userID)
+	if err != nil {
+		t.Fatalf("Create() error = %v", err)
+	}
+	if _, err := repo.Create(ctx, "Taken", userID); err != nil {
+		t.Fatalf("Create(Taken) error = %v", err)
+	}
+	fixtures.CreateHost(t, tdb.Pool, org.ID, "host-a")
+
+	if err := repo.Rename(ctx, org.ID, "Taken"); !errors.Is(err, orgservices.ErrDuplicateOrganization) {
+		t.Fatalf("Rename(Taken) error = %v, want ErrDuplicateOrganization", err)
+	}
+	if err := repo.Rename(ctx, org.ID, "New Name"); err != nil {
+		t.Fatalf("Rename() error = %v", err)
+	}
+	got, err := repo.GetByID(ctx, org.ID)
+	if err != nil {
+		t.Fatalf("GetByID() error = %v", err)
+	}
+	if got.Name != "New Name" {
+		t.Errorf("Name = %q, want New Name", got.Name)
+	}
+
+	if err := repo.Delete(ctx, org.ID); err != nil {
+		t.Fatalf("Delete() error = %v", err)
+	}
+	if _, err := repo.GetByID(ctx, org.ID); !errors.Is(err, orgservices.ErrOrganizationNotFound) {
+		t.Fatalf("GetByID() after Delete error = %v, want ErrOrganizationNotFound", err)
+	}
+	var hosts int
+	if err := tdb.Pool.QueryRow(ctx, `SELECT COUNT(*) FROM hosts WHERE organization_id = $1`, org.ID).Scan(&hosts); err != nil {
+		t.Fatalf("counting hosts: %v", err)
+	}
+	if hosts != 0 {
+		t.Errorf("hosts left after Delete = %d, want 0", hosts)
+	}
+	if err := repo.Delete(ctx, org.ID); !errors.Is(err, orgservices.ErrOrganizationNotFound) {
+		t.Fatalf("second Delete() error = %v, want ErrOrganizationNotFound", err)
+	}
+}
+
+func TestOrganizationRepository_DisabledOrganization(t *testing.T) {
 	tdb := testdb.SetupTestDB(t)
 	ctx := context.Background()
 
-	var userID int
-	if err := tdb.Pool.QueryRow(ctx, `INSERT INTO users (email, password_hash) VALUES ($1, $2) RETURNING id`, "user@example.com", "hash").Scan(&userID); err != nil {
-		t.Fatalf("insert user: %v", err)
+	userID := fixtures.CreateUser(t, tdb.Pool, "user@example.com").ID
+
+	repo := orgservices.NewOrganizationRepository(tdb.Pool, testKeyring(t))
+	org, err := repo.Create(ctx, "Disabled Org", userID)
+	if err != nil {
+		t.Fatalf("Create() error = %v", err)
+	}
+	if err := repo.AddEnrollSecret(ctx, org.ID, "secret"); err != nil {
+		t.Fatalf("AddEnrollSecret() error = %v", err)
+	}
+	if _, err := tdb.Pool.Exec(ctx, `UPDATE organizations SET disabled_at = NOW() WHERE id = $1`, org.ID); err != nil {
+		t.Fatalf("disabling organization: %v", err)
 	}
 
-	repo := orgservices.NewOrganizationRepository(tdb.Pool)
+	if orgs, err := repo.GetUserOrganizations(ctx, userID); err != nil || len(orgs) != 0 {
+		t.Fatalf("GetUserOrganizations() = %v, %v; want none", orgs, err)
+	}
+	if _, err := repo.GetOrganizationByEnrollSecret(ctx, "secret"); !errors.Is(err, orgservices.ErrOrganizationNotFound) {
+		t.Fatalf("GetOrganizationByEnrollSecret() error = %v, want ErrOrganizationNotFound", err)
+	}
+	got, err := repo.GetByID(ctx, org.ID)
+	if err != nil {
+		t.Fatalf("GetByID() error = %v", err)
+	}
+	if got.DisabledAt == nil {
+		t.Fatal("GetByID().DisabledAt = nil, want the time it was disabled")
+	}
+}
+
+func TestOrganizationRepository_GetActiveEnrollSecret(t *testing.T) {
+	tdb := testdb.SetupTestDB(t)
+	ctx := context.Background()
+
+	userID := fixtures.CreateUser(t, tdb.Pool, "user@example.com").ID
+
+	repo := orgservices.NewOrganizationRepository(tdb.Pool, testKeyring(t))
 	org, err := repo.Create(ctx, "Test Org", userID)
 	if err != nil {
 		t.Fatalf("Create() error = %v", err)
@@ -356,3 +491,82 @@ func TestOrganizationRepository_GetActiveEnrollSecret(t *testing.T) {
 		t.Fatalf("OrganizationID = %s, want %s", secret.OrganizationID, org.ID)
 	}
 }
+
+func TestOrganizationRepository_RotateEnrollSecrets(t *testing.T) {
+	tdb := testdb.SetupTestDB(t)
+	ctx := context.Background()
+
+	userID := fixtures.CreateUser(t, tdb.Pool, "user@example.com").ID
+
+	oldKey, err := crypto.GenerateKey("old")
+	if err != nil {
+		t.Fatalf("GenerateKey: %v", err)
+	}
+	newKey, err := crypto.GenerateKey("new")
+	if err != nil {
+		t.Fatalf("GenerateKey: %v", err)
+	}
+	oldKeys, err := crypto.ParseKeyring(oldKey)
+	if err != nil {
+		t.Fatalf("ParseKeyring: %v", err)
+	}
+	rotatedKeys, err := crypto.ParseKeyring(newKey + "," + oldKey)
+	if err != nil {
+		t.Fatalf("ParseKeyring: %v", err)
+	}
+
+	repo := orgservices.NewOrganizationRepository(tdb.Pool, oldKeys)
+	encrypted, err := repo.Create(ctx, "Encrypted Org", userID)
+	if err != nil {
+		t.Fatalf("Create() error = %v", err)
+	}
+	if err := repo.AddEnrollSecret(ctx, encrypted.ID, "encrypted-secret"); err != nil {
+		t.Fatalf("AddEnrollSecret() error = %v", err)
+	}
+	legacy := fixtures.CreateOrg(t, tdb.Pool, "legacy-org").ID
+	fixtures.CreateEnrollSecret(t, tdb.Pool, legacy, "legacy-secret")
+
+	repo = orgservices.NewOrganizationRepository(tdb.Pool, rotatedKeys)
+	total := 0
+	for {
+		n, err := repo.RotateEnrollSecrets(ctx, 1)
+		if err != nil {
+			t.Fatalf("RotateEnrollSecrets() error = %v", err)
+		}
+		if n == 0 {
+			break
+		}
+		total += n
+	}
+	if total != 2 {
+		t.Fatalf("rotated %d secrets, want 2", total)
+	}
+
+	var remaining int
+	if err := tdb.Pool.QueryRow(ctx, `
+		SELECT COUNT(*) FROM organization_enroll_secrets
+		WHERE secret IS NOT NULL OR split_part(secret_ciphertext, ':', 2) <> 'new'
+	`).Scan(&remaining); err != nil {
+		t.Fatalf("counting unrotated secrets: %v", err)
+	}
+	if remaining != 0 {
+		t.Fatalf("%d secrets not rotated", remaining)
+	}
+
+	// Only the new key is needed from here on.
+	newOnly, err := crypto.ParseKeyring(newKey)
+	if err != nil {
+		t.Fatalf("ParseKeyring: %v", err)
+	}
+	repo = orgservices.NewOrganizationRepository(tdb.Pool, newOnly)
+	for orgID, want := range map[uuid.UUID]string{encrypted.ID: "encrypted-secret", legacy: "legacy-secret"} {
+		got, err := repo.GetActiveEnrollSecret(ctx, orgID)
+		if err != nil || got == nil || got.Secret != want {
+			t.Fatalf("GetActiveEnrollSecret(%s) = %+v, %v; want %q", orgID, got, err, want)
+		}
+		org, err := repo.GetOrganizationByEnrollSecret(ctx, want)
+		if err != nil || org.ID != orgID {
+			t.Fatalf("GetOrganizationByEnrollSecret(%q) = %+v, %v", want, org, err)
+		}
+	}
+}
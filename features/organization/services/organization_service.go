@@ -8,15 +8,19 @@ import (
 	"strings"
 
 	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
 )
 
 type organizationRepository interface {
-	Create(ctx context.Context, name string, ownerID int) (*Organization, error)
-	AddEnrollSecret(ctx context.Context, orgID uuid.UUID, secret string) error
+	WithTx(ctx context.Context, fn func(tx pgx.Tx) error) error
+	CreateTx(ctx context.Context, tx pgx.Tx, name string, ownerID int) (*Organization, error)
+	AddEnrollSecretTx(ctx context.Context, tx pgx.Tx, orgID uuid.UUID, secret string) error
 	GetByID(ctx context.Context, id uuid.UUID) (*Organization, error)
 	GetUserOrganizations(ctx context.Context, userID int) ([]*Organization, error)
 	GetActiveEnrollSecret(ctx context.Context, orgID uuid.UUID) (*OrganizationEnrollSecret, error)
 	GetOrganizationByEnrollSecret(ctx context.Context, secret string) (*Organization, error)
+	Rename(ctx context.Context, id uuid.UUID, name string) error
+	Delete(ctx context.Context, id uuid.UUID) error
 }
 
 type OrganizationService struct {
@@ -27,23 +31,29 @@ func NewOrganizationService(repo organizationRepository) *OrganizationService {
 	return &OrganizationService{repo: repo}
 }
 
+// Create creates the organization with ownerID as its owner and an
+// enrollment secret, all in one transaction, so a failure leaves no
+// organization without a secret behind.
 func (s *OrganizationService) Create(ctx context.Context, name string, ownerID int) (*Organization, error) {
-	// Create organization and assign owner
-	org, err := s.repo.Create(ctx, name, ownerID)
-	if err != nil {
-		return nil, err
-	}
-
-	// Generate and assign enrollment secret
 	secret, err := s.GenerateEnrollSecret(name)
 	if err != nil {
 		return nil, fmt.Errorf("generating secret: %w", err)
 	}
 
-	if err := s.repo.AddEnrollSecret(ctx, org.ID, secret); err != nil {
-		return nil, fmt.Errorf("adding secret: %w", err)
+	var org *Organization
+	err = s.repo.WithTx(ctx, func(tx pgx.Tx) error {
+		org, err = s.repo.CreateTx(ctx, tx, name, ownerID)
+		if err != nil {
+			return err
+		}
+		if err := s.repo.AddEnrollSecretTx(ctx, tx, org.ID, secret); err != nil {
+			return fmt.Errorf("adding secret: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
 	}
-
 	return org, nil
 }
 
@@ -88,3 +98,13 @@ func (s *OrganizationService) GetActiveEnrollSecret(ctx context.Context, orgID u
 func (s *OrganizationService) GetOrganizationByEnrollSecret(ctx context.Context, secret string) (*Organization, error) {
 	return s.repo.GetOrganizationByEnrollSecret(ctx, secret)
 }
+
+// Rename changes the organization's name. Surrounding whitespace is dropped.
+func (s *OrganizationService) Rename(ctx context.Context, id uuid.UUID, name string) error {
+	return s.repo.Rename(ctx, id, strings.TrimSpace(name))
+}
+
+// Delete removes the organization and everything in it.
+func (s *OrganizationService) Delete(ctx context.Context, id uuid.UUID) error {
+	return s.repo.Delete(ctx, id)
+}
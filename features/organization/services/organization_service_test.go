@@ -8,6 +8,7 @@ import (
 
 	"github.com/cavenine/queryops/features/organization/services"
 	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
 )
 
 type stubOrgRepo struct {
@@ -17,16 +18,22 @@ type stubOrgRepo struct {
 	getUserOrganizationsFunc  func(ctx context.Context, userID int) ([]*services.Organization, error)
 	getActiveEnrollSecretFunc func(ctx context.Context, orgID uuid.UUID) (*services.OrganizationEnrollSecret, error)
 	getOrgByEnrollSecretFunc  func(ctx context.Context, secret string) (*services.Organization, error)
+	renameFunc                func(ctx context.Context, id uuid.UUID, name string) error
 }
 
-func (s *stubOrgRepo) Create(ctx context.Context, name string, ownerID int) (*services.Organization, error) {
+// WithTx runs fn without a transaction; the stub's Tx methods ignore it.
+func (s *stubOrgRepo) WithTx(ctx context.Context, fn func(tx pgx.Tx) error) error {
+	return fn(nil)
+}
+
+func (s *stubOrgRepo) CreateTx(ctx context.Context, _ pgx.Tx, name string, ownerID int) (*services.Organization, error) {
 	if s.createFunc != nil {
 		return s.createFunc(ctx, name, ownerID)
 	}
 	return nil, nil
 }
 
-func (s *stubOrgRepo) AddEnrollSecret(ctx context.Context, orgID uuid.UUID, secret string) error {
+func (s *stubOrgRepo) AddEnrollSecretTx(ctx context.Context, _ pgx.Tx, orgID uuid.UUID, secret string) error {
 	if s.addEnrollSecretFunc != nil {
 		return s.addEnrollSecretFunc(ctx, orgID, secret)
 	}
@@ -61,6 +68,17 @@ func (s *stubOrgRepo) GetOrganizationByEnrollSecret(ctx context.Context, secret
 	return nil, nil
 }
 
+func (s *stubOrgRepo) Rename(ctx context.Context, id uuid.UUID, name string) error {
+	if s.renameFunc != nil {
+		return s.renameFunc(ctx, id, name)
+	}
+	return nil
+}
+
+func (s *stubOrgRepo) Delete(ctx context.Context, id uuid.UUID) error {
+	return nil
+}
+
 func TestCreate_Success(t *testing.T) {
 	orgID := uuid.New()
 
@@ -360,3 +378,21 @@ func TestGetOrganizationByEnrollSecret_NotFound(t *testing.T) {
 		t.Errorf("expected ErrOrganizationNotFound, got: %v", err)
 	}
 }
+
+func TestRename_TrimsName(t *testing.T) {
+	var got string
+	repo := &stubOrgRepo{
+		renameFunc: func(ctx context.Context, id uuid.UUID, name string) error {
+			got = name
+			return nil
+		},
+	}
+	svc := services.NewOrganizationService(repo)
+
+	if err := svc.Rename(context.Background(), uuid.New(), "  Acme Corp \n"); err != nil {
+		t.Fatalf("Rename() error = %v", err)
+	}
+	if got != "Acme Corp" {
+		t.Errorf("name = %q, want %q", got, "Acme Corp")
+	}
+}
@@ -0,0 +1,94 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// Plans seeded by the plans migration. Organizations are on PlanEnterprise
+// unless PLAN_DEFAULT or a superuser says otherwise.
+const (
+	PlanFree       = "free"
+	PlanTeam       = "team"
+	PlanEnterprise = "enterprise"
+)
+
+// Features a plan can include.
+const (
+	// FeatureWebhooks covers every outbound integration: digest and status
+	// alert webhooks, Slack, and paging destinations.
+	FeatureWebhooks      = "webhooks"
+	FeatureResultExports = "result_exports"
+)
+
+// Features lists every feature a plan can include.
+var Features = []string{FeatureWebhooks, FeatureResultExports}
+
+var ErrPlanNotFound = errors.New("plan not found")
+
+// Plan is a bundle of features and limits an organization is on. Nil limits
+// fall back to the configured default quotas.
+type Plan struct {
+	ID                      string   `json:"id" db:"id"`
+	Name                    string   `json:"name" db:"name"`
+	Rank                    int      `json:"rank" db:"rank"`
+	Features                []string `json:"features" db:"features"`
+	MaxHosts                *int     `json:"max_hosts,omitempty" db:"max_hosts"`
+	MaxCampaignsPerDay      *int     `json:"max_campaigns_per_day,omitempty" db:"max_campaigns_per_day"`
+	MaxResultLogBytesPerDay *int64   `json:"max_result_log_bytes_per_day,omitempty" db:"max_result_log_bytes_per_day"`
+}
+
+// Has reports whether the plan includes feature.
+func (p *Plan) Has(feature string) bool {
+	return slices.Contains(p.Features, feature)
+}
+
+type PlanRepository struct {
+	pool *pgxpool.Pool
+}
+
+func NewPlanRepository(pool *pgxpool.Pool) *PlanRepository {
+	return &PlanRepository{pool: pool}
+}
+
+// ListPlans returns every plan, cheapest first.
+func (r *PlanRepository) ListPlans(ctx context.Context) ([]Plan, error) {
+	rows, err := r.pool.Query(ctx, `
+		SELECT id, name, rank, features, max_hosts, max_campaigns_per_day, max_result_log_bytes_per_day
+		FROM plans
+		ORDER BY rank
+	`)
+	if err != nil {
+		return nil, fmt.Errorf("querying plans: %w", err)
+	}
+	plans, err := pgx.CollectRows(rows, pgx.RowToStructByName[Plan])
+	if err != nil {
+		return nil, fmt.Errorf("scanning plans: %w", err)
+	}
+	return plans, nil
+}
+
+// GetPlan returns the plan with id, or ErrPlanNotFound.
+func (r *PlanRepository) GetPlan(ctx context.Context, id string) (*Plan, error) {
+	rows, err := r.pool.Query(ctx, `
+		SELECT id, name, rank, features, max_hosts, max_campaigns_per_day, max_result_log_bytes_per_day
+		FROM plans
+		WHERE id = $1
+	`, id)
+	if err != nil {
+		return nil, fmt.Errorf("querying plan: %w", err)
+	}
+	plan, err := pgx.CollectOneRow(rows, pgx.RowToAddrOfStructByName[Plan])
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, ErrPlanNotFound
+		}
+		return nil, fmt.Errorf("querying plan: %w", err)
+	}
+	return plan, nil
+}
@@ -0,0 +1,84 @@
+package services_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	orgservices "github.com/cavenine/queryops/features/organization/services"
+	"github.com/cavenine/queryops/internal/testdb"
+	"github.com/cavenine/queryops/internal/testdb/fixtures"
+)
+
+func TestPlanRepository(t *testing.T) {
+	tdb := testdb.SetupTestDB(t)
+	ctx := context.Background()
+	repo := orgservices.NewPlanRepository(tdb.Pool)
+
+	plans, err := repo.ListPlans(ctx)
+	if err != nil {
+		t.Fatalf("ListPlans: %v", err)
+	}
+	ids := make([]string, len(plans))
+	for i, p := range plans {
+		ids[i] = p.ID
+	}
+	if len(ids) != 3 || ids[0] != orgservices.PlanFree || ids[1] != orgservices.PlanTeam || ids[2] != orgservices.PlanEnterprise {
+		t.Fatalf("plans = %v, want free, team, enterprise", ids)
+	}
+	if plans[0].Has(orgservices.FeatureWebhooks) || !plans[1].Has(orgservices.FeatureWebhooks) {
+		t.Fatalf("webhooks should start on the team plan: %+v", plans)
+	}
+	for _, f := range orgservices.Features {
+		if !plans[2].Has(f) {
+			t.Fatalf("enterprise lacks %s", f)
+		}
+	}
+
+	if _, err := repo.GetPlan(ctx, "platinum"); !errors.Is(err, orgservices.ErrPlanNotFound) {
+		t.Fatalf("GetPlan(unknown) error = %v, want ErrPlanNotFound", err)
+	}
+}
+
+func TestOrganizationRepository_Plans(t *testing.T) {
+	tdb := testdb.SetupTestDB(t)
+	ctx := context.Background()
+
+	ownerID := fixtures.CreateUser(t, tdb.Pool, "owner@example.com").ID
+	repo := orgservices.NewOrganizationRepository(tdb.Pool, testKeyring(t))
+
+	// Existing installations keep every feature.
+	org, err := repo.Create(ctx, "Enterprise Org", ownerID)
+	if err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	if org.PlanID != orgservices.PlanEnterprise || !org.HasFeature(orgservices.FeatureWebhooks) || !org.HasFeature(orgservices.FeatureResultExports) {
+		t.Fatalf("default org = %+v, want enterprise with every feature", org)
+	}
+
+	repo.SetDefaultPlan(orgservices.PlanFree)
+	org, err = repo.Create(ctx, "Free Org", ownerID)
+	if err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	if org.PlanID != orgservices.PlanFree || org.PlanName != "Free" || len(org.Features) != 0 {
+		t.Fatalf("free org = %+v, want the free plan without features", org)
+	}
+
+	// Extra features are added to the plan's, once each.
+	if _, err := tdb.Pool.Exec(ctx, `UPDATE organizations SET extra_features = '{result_exports,result_exports}' WHERE id = $1`, org.ID); err != nil {
+		t.Fatalf("granting features: %v", err)
+	}
+	got, err := repo.GetByID(ctx, org.ID)
+	if err != nil {
+		t.Fatalf("GetByID: %v", err)
+	}
+	if len(got.Features) != 1 || !got.HasFeature(orgservices.FeatureResultExports) || got.HasFeature(orgservices.FeatureWebhooks) {
+		t.Fatalf("features = %v, want result_exports", got.Features)
+	}
+
+	repo.SetDefaultPlan("platinum")
+	if _, err := repo.Create(ctx, "Platinum Org", ownerID); !errors.Is(err, orgservices.ErrPlanNotFound) {
+		t.Fatalf("Create on an unknown plan error = %v, want ErrPlanNotFound", err)
+	}
+}
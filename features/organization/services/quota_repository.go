@@ -0,0 +1,245 @@
+package services
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"sync/atomic"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+const (
+	QuotaHosts                = "hosts"
+	QuotaCampaignsPerDay      = "campaigns_per_day"
+	QuotaResultLogBytesPerDay = "result_log_bytes_per_day"
+)
+
+// Quotas are an organization's limits. Zero means unlimited.
+type Quotas struct {
+	MaxHosts                int   `json:"max_hosts"`
+	MaxCampaignsPerDay      int   `json:"max_campaigns_per_day"`
+	MaxResultLogBytesPerDay int64 `json:"max_result_log_bytes_per_day"`
+}
+
+// Usage is an organization's consumption of each quota. Daily counters reset
+// at midnight UTC.
+type Usage struct {
+	Hosts               int   `json:"hosts"`
+	CampaignsToday      int   `json:"campaigns_today"`
+	ResultLogBytesToday int64 `json:"result_log_bytes_today"`
+}
+
+// QuotaExceededError reports which quota an operation would exceed.
+type QuotaExceededError struct {
+	Quota string
+	Limit int64
+	Used  int64
+}
+
+func (e *QuotaExceededError) Error() string {
+	switch e.Quota {
+	case QuotaHosts:
+		return fmt.Sprintf("organization has reached its limit of %d enrolled hosts", e.Limit)
+	case QuotaCampaignsPerDay:
+		return fmt.Sprintf("organization has reached its limit of %d queries per day; the limit resets at midnight UTC", e.Limit)
+	case QuotaResultLogBytesPerDay:
+		return fmt.Sprintf("organization has used %d of its %d bytes of result logs for today; the limit resets at midnight UTC", e.Used, e.Limit)
+	default:
+		return fmt.Sprintf("organization quota %q exceeded (%d/%d)", e.Quota, e.Used, e.Limit)
+	}
+}
+
+// IsQuotaExceeded reports whether err is or wraps a *QuotaExceededError.
+func IsQuotaExceeded(err error) bool {
+	var qe *QuotaExceededError
+	return errors.As(err, &qe)
+}
+
+type QuotaRepository struct {
+	pool     *pgxpool.Pool
+	defaults atomic.Pointer[Quotas]
+}
+
+// NewQuotaRepository returns a repository that applies defaults where an
+// organization has neither an override nor a plan limit.
+func NewQuotaRepository(pool *pgxpool.Pool, defaults Quotas) *QuotaRepository {
+	r := &QuotaRepository{pool: pool}
+	r.SetDefaults(defaults)
+	return r
+}
+
+// SetDefaults replaces the quotas applied where an organization has neither
+// an override nor a plan limit.
+func (r *QuotaRepository) SetDefaults(defaults Quotas) {
+	r.defaults.Store(&defaults)
+}
+
+// GetQuotas returns the organization's effective quotas: its overrides, then
+// its plan's limits, then the defaults.
+func (r *QuotaRepository) GetQuotas(ctx context.Context, organizationID uuid.UUID) (Quotas, error) {
+	var (
+		q                   = *r.defaults.Load()
+		maxHosts, planHosts *int
+		maxCamps, planCamps *int
+		maxBytes, planBytes *int64
+	)
+	err := r.pool.QueryRow(ctx, `
+		SELECT oq.max_hosts, oq.max_campaigns_per_day, oq.max_result_log_bytes_per_day,
+			p.max_hosts, p.max_campaigns_per_day, p.max_result_log_bytes_per_day
+		FROM organizations o
+		JOIN plans p ON p.id = o.plan_id
+		LEFT JOIN organization_quotas oq ON oq.organization_id = o.id
+		WHERE o.id = $1
+	`, organizationID).Scan(&maxHosts, &maxCamps, &maxBytes, &planHosts, &planCamps, &planBytes)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return q, nil
+		}
+		return Quotas{}, fmt.Errorf("querying organization quotas: %w", err)
+	}
+
+	if v := cmp.Or(maxHosts, planHosts); v != nil {
+		q.MaxHosts = *v
+	}
+	if v := cmp.Or(maxCamps, planCamps); v != nil {
+		q.MaxCampaignsPerDay = *v
+	}
+	if v := cmp.Or(maxBytes, planBytes); v != nil {
+		q.MaxResultLogBytesPerDay = *v
+	}
+	return q, nil
+}
+
+// SetQuotas overrides the defaults for one organization.
+func (r *QuotaRepository) SetQuotas(ctx context.Context, organizationID uuid.UUID, q Quotas) error {
+	_, err := r.pool.Exec(ctx, `
+		INSERT INTO organization_quotas (organization_id, max_hosts, max_campaigns_per_day, max_result_log_bytes_per_day)
+		VALUES ($1, $2, $3, $4)
+		ON CONFLICT (organization_id)
+		DO UPDATE SET max_hosts = EXCLUDED.max_hosts,
+			max_campaigns_per_day = EXCLUDED.max_campaigns_per_day,
+			max_result_log_bytes_per_day = EXCLUDED.max_result_log_bytes_per_day,
+			updated_at = NOW()
+	`, organizationID, q.MaxHosts, q.MaxCampaignsPerDay, q.MaxResultLogBytesPerDay)
+	if err != nil {
+		return fmt.Errorf("saving organization quotas: %w", err)
+	}
+	return nil
+}
+
+// GetUsage returns the organization's current usage.
+func (r *QuotaRepository) GetUsage(ctx context.Context, organizationID uuid.UUID) (Usage, error) {
+	var u Usage
+	err := r.pool.QueryRow(ctx, `
+		SELECT
+			(SELECT COUNT(*) FROM hosts WHERE organization_id = $1),
+			(SELECT COUNT(*) FROM campaigns
+				WHERE organization_id = $1 AND created_at >= date_trunc('day', NOW() AT TIME ZONE 'UTC') AT TIME ZONE 'UTC'),
+			COALESCE((SELECT result_log_bytes FROM organization_usage_daily
+				WHERE organization_id = $1 AND day = (NOW() AT TIME ZONE 'UTC')::date), 0)
+	`, organizationID).Scan(&u.Hosts, &u.CampaignsToday, &u.ResultLogBytesToday)
+	if err != nil {
+		return Usage{}, fmt.Errorf("querying organization usage: %w", err)
+	}
+	return u, nil
+}
+
+// CheckHostEnrollment returns a *QuotaExceededError if enrolling
+// hostIdentifier would add a host beyond the organization's limit.
+// Re-enrolling a known host is always allowed.
+func (r *QuotaRepository) CheckHostEnrollment(ctx context.Context, organizationID uuid.UUID, hostIdentifier string) error {
+	q, err := r.GetQuotas(ctx, organizationID)
+	if err != nil {
+		return err
+	}
+	if q.MaxHosts == 0 {
+		return nil
+	}
+
+	var (
+		hosts int
+		known bool
+	)
+	err = r.pool.QueryRow(ctx, `
+		SELECT COUNT(*), COALESCE(BOOL_OR(host_identifier = $2), false)
+		FROM hosts
+		WHERE organization_id = $1
+	`, organizationID, hostIdentifier).Scan(&hosts, &known)
+	if err != nil {
+		return fmt.Errorf("counting hosts: %w", err)
+	}
+	if !known && hosts >= q.MaxHosts {
+		return &QuotaExceededError{Quota: QuotaHosts, Limit: int64(q.MaxHosts), Used: int64(hosts)}
+	}
+	return nil
+}
+
+// CheckCampaign returns a *QuotaExceededError if the organization has
+// already created its daily limit of campaigns.
+func (r *QuotaRepository) CheckCampaign(ctx context.Context, organizationID uuid.UUID) error {
+	q, err := r.GetQuotas(ctx, organizationID)
+	if err != nil {
+		return err
+	}
+	if q.MaxCampaignsPerDay == 0 {
+		return nil
+	}
+
+	var today int
+	err = r.pool.QueryRow(ctx, `
+		SELECT COUNT(*) FROM campaigns
+		WHERE organization_id = $1 AND created_at >= date_trunc('day', NOW() AT TIME ZONE 'UTC') AT TIME ZONE 'UTC'
+	`, organizationID).Scan(&today)
+	if err != nil {
+		return fmt.Errorf("counting campaigns: %w", err)
+	}
+	if today >= q.MaxCampaignsPerDay {
+		return &QuotaExceededError{Quota: QuotaCampaignsPerDay, Limit: int64(q.MaxCampaignsPerDay), Used: int64(today)}
+	}
+	return nil
+}
+
+// ReserveResultLogBytes adds n to today's result log volume, or returns a
+// *QuotaExceededError without recording anything if that would exceed the
+// organization's daily limit.
+func (r *QuotaRepository) ReserveResultLogBytes(ctx context.Context, organizationID uuid.UUID, n int64) error {
+	if n <= 0 {
+		return nil
+	}
+	q, err := r.GetQuotas(ctx, organizationID)
+	if err != nil {
+		return err
+	}
+
+	limit := q.MaxResultLogBytesPerDay
+	if limit > 0 && n > limit {
+		return &QuotaExceededError{Quota: QuotaResultLogBytesPerDay, Limit: limit, Used: 0}
+	}
+
+	// The conditional upsert makes the check and increment atomic across
+	// concurrent logger requests.
+	var used int64
+	err = r.pool.QueryRow(ctx, `
+		INSERT INTO organization_usage_daily (organization_id, day, result_log_bytes)
+		VALUES ($1, (NOW() AT TIME ZONE 'UTC')::date, $2)
+		ON CONFLICT (organization_id, day)
+		DO UPDATE SET result_log_bytes = organization_usage_daily.result_log_bytes + EXCLUDED.result_log_bytes
+		WHERE $3::bigint = 0 OR organization_usage_daily.result_log_bytes + EXCLUDED.result_log_bytes <= $3::bigint
+		RETURNING result_log_bytes
+	`, organizationID, n, limit).Scan(&used)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			current, uerr := r.GetUsage(ctx, organizationID)
+			if uerr != nil {
+				return uerr
+			}
+			return &QuotaExceededError{Quota: QuotaResultLogBytesPerDay, Limit: limit, Used: current.ResultLogBytesToday}
+		}
+		return fmt.Errorf("recording result log usage: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,137 @@
+package services_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	orgservices "github.com/cavenine/queryops/features/organization/services"
+	"github.com/cavenine/queryops/internal/testdb"
+	"github.com/cavenine/queryops/internal/testdb/fixtures"
+)
+
+func TestQuotaRepository_DefaultsAndOverrides(t *testing.T) {
+	tdb := testdb.SetupTestDB(t)
+	ctx := context.Background()
+
+	orgID := fixtures.CreateOrg(t, tdb.Pool, "quota-org").ID
+	repo := orgservices.NewQuotaRepository(tdb.Pool, orgservices.Quotas{MaxHosts: 10, MaxCampaignsPerDay: 5})
+
+	q, err := repo.GetQuotas(ctx, orgID)
+	if err != nil {
+		t.Fatalf("GetQuotas: %v", err)
+	}
+	if q.MaxHosts != 10 || q.MaxCampaignsPerDay != 5 || q.MaxResultLogBytesPerDay != 0 {
+		t.Fatalf("default quotas = %+v", q)
+	}
+
+	if err := repo.SetQuotas(ctx, orgID, orgservices.Quotas{MaxHosts: 2, MaxResultLogBytesPerDay: 100}); err != nil {
+		t.Fatalf("SetQuotas: %v", err)
+	}
+	q, err = repo.GetQuotas(ctx, orgID)
+	if err != nil {
+		t.Fatalf("GetQuotas: %v", err)
+	}
+	if q.MaxHosts != 2 || q.MaxCampaignsPerDay != 0 || q.MaxResultLogBytesPerDay != 100 {
+		t.Fatalf("overridden quotas = %+v", q)
+	}
+}
+
+func TestQuotaRepository_Enforcement(t *testing.T) {
+	tdb := testdb.SetupTestDB(t)
+	ctx := context.Background()
+
+	orgID := fixtures.CreateOrg(t, tdb.Pool, "quota-org").ID
+	repo := orgservices.NewQuotaRepository(tdb.Pool, orgservices.Quotas{
+		MaxHosts:                1,
+		MaxCampaignsPerDay:      1,
+		MaxResultLogBytesPerDay: 100,
+	})
+
+	assertQuota := func(err error, quota string) {
+		t.Helper()
+		var qe *orgservices.QuotaExceededError
+		if !errors.As(err, &qe) {
+			t.Fatalf("err = %v, want QuotaExceededError", err)
+		}
+		if qe.Quota != quota {
+			t.Fatalf("Quota = %q, want %q", qe.Quota, quota)
+		}
+	}
+
+	// Hosts.
+	if err := repo.CheckHostEnrollment(ctx, orgID, "first"); err != nil {
+		t.Fatalf("CheckHostEnrollment(first): %v", err)
+	}
+	host := fixtures.CreateHost(t, tdb.Pool, orgID, "first")
+	if err := repo.CheckHostEnrollment(ctx, orgID, "first"); err != nil {
+		t.Fatalf("re-enrolling a known host: %v", err)
+	}
+	assertQuota(repo.CheckHostEnrollment(ctx, orgID, "second"), orgservices.QuotaHosts)
+
+	// Campaigns.
+	if err := repo.CheckCampaign(ctx, orgID); err != nil {
+		t.Fatalf("CheckCampaign: %v", err)
+	}
+	fixtures.CreateCampaign(t, tdb.Pool, orgID, "SELECT 1;", host.ID)
+	assertQuota(repo.CheckCampaign(ctx, orgID), orgservices.QuotaCampaignsPerDay)
+
+	// Result log bytes.
+	if err := repo.ReserveResultLogBytes(ctx, orgID, 60); err != nil {
+		t.Fatalf("ReserveResultLogBytes(60): %v", err)
+	}
+	assertQuota(repo.ReserveResultLogBytes(ctx, orgID, 50), orgservices.QuotaResultLogBytesPerDay)
+	if err := repo.ReserveResultLogBytes(ctx, orgID, 40); err != nil {
+		t.Fatalf("ReserveResultLogBytes(40): %v", err)
+	}
+
+	usage, err := repo.GetUsage(ctx, orgID)
+	if err != nil {
+		t.Fatalf("GetUsage: %v", err)
+	}
+	want := orgservices.Usage{Hosts: 1, CampaignsToday: 1, ResultLogBytesToday: 100}
+	if usage != want {
+		t.Fatalf("usage = %+v, want %+v", usage, want)
+	}
+}
+
+func TestQuotaRepository_PlanLimits(t *testing.T) {
+	tdb := testdb.SetupTestDB(t)
+	ctx := context.Background()
+
+	orgID := fixtures.CreateOrg(t, tdb.Pool, "plan-org").ID
+	repo := orgservices.NewQuotaRepository(tdb.Pool, orgservices.Quotas{MaxHosts: 1000, MaxCampaignsPerDay: 7})
+
+	if _, err := tdb.Pool.Exec(ctx, `UPDATE organizations SET plan_id = $2 WHERE id = $1`, orgID, orgservices.PlanFree); err != nil {
+		t.Fatalf("moving to the free plan: %v", err)
+	}
+	free, err := orgservices.NewPlanRepository(tdb.Pool).GetPlan(ctx, orgservices.PlanFree)
+	if err != nil {
+		t.Fatalf("GetPlan: %v", err)
+	}
+
+	q, err := repo.GetQuotas(ctx, orgID)
+	if err != nil {
+		t.Fatalf("GetQuotas: %v", err)
+	}
+	want := orgservices.Quotas{
+		MaxHosts:                *free.MaxHosts,
+		MaxCampaignsPerDay:      *free.MaxCampaignsPerDay,
+		MaxResultLogBytesPerDay: *free.MaxResultLogBytesPerDay,
+	}
+	if q != want {
+		t.Fatalf("plan quotas = %+v, want %+v", q, want)
+	}
+
+	// An override beats the plan.
+	if err := repo.SetQuotas(ctx, orgID, orgservices.Quotas{MaxHosts: 3}); err != nil {
+		t.Fatalf("SetQuotas: %v", err)
+	}
+	q, err = repo.GetQuotas(ctx, orgID)
+	if err != nil {
+		t.Fatalf("GetQuotas: %v", err)
+	}
+	if q.MaxHosts != 3 || q.MaxCampaignsPerDay != 0 {
+		t.Fatalf("overridden quotas = %+v, want 3 hosts and unlimited campaigns", q)
+	}
+}
@@ -0,0 +1,240 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// Redaction rule kinds.
+const (
+	// RedactColumn replaces the whole value of columns whose name matches
+	// the pattern, case-insensitively.
+	RedactColumn = "column"
+	// RedactValue replaces the parts of any value that match the pattern.
+	RedactValue = "value"
+)
+
+// RedactedValue replaces redacted data.
+const RedactedValue = "[REDACTED]"
+
+// redactorCacheTTL bounds how long another instance keeps applying rules
+// after they change.
+const redactorCacheTTL = 30 * time.Second
+
+var (
+	ErrInvalidRedactionKind    = errors.New("redaction rule must match a column or a value")
+	ErrInvalidRedactionPattern = errors.New("invalid pattern; use a regular expression such as password|secret")
+	ErrDuplicateRedactionRule  = errors.New("redaction rule already exists")
+	ErrRedactionRuleNotFound   = errors.New("redaction rule not found")
+)
+
+// RedactionRule hides sensitive data in an organization's query results.
+type RedactionRule struct {
+	ID             int64     `json:"id"`
+	OrganizationID uuid.UUID `json:"organization_id"`
+	Kind           string    `json:"kind"`
+	Pattern        string    `json:"pattern"`
+	Description    string    `json:"description"`
+	CreatedAt      time.Time `json:"created_at"`
+}
+
+func compileRedactionRule(kind, pattern string) (*regexp.Regexp, error) {
+	if pattern == "" {
+		return nil, ErrInvalidRedactionPattern
+	}
+	switch kind {
+	case RedactColumn:
+		pattern = "(?i)" + pattern
+	case RedactValue:
+	default:
+		return nil, ErrInvalidRedactionKind
+	}
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, ErrInvalidRedactionPattern
+	}
+	return re, nil
+}
+
+// Redactor applies a set of redaction rules to result rows. A nil *Redactor
+// redacts nothing.
+type Redactor struct {
+	columns []*regexp.Regexp
+	values  []*regexp.Regexp
+}
+
+// NewRedactor compiles rules, skipping any that don't compile. It returns nil
+// if no rules remain.
+func NewRedactor(rules []RedactionRule) *Redactor {
+	r := &Redactor{}
+	for _, rule := range rules {
+		re, err := compileRedactionRule(rule.Kind, rule.Pattern)
+		if err != nil {
+			continue
+		}
+		if rule.Kind == RedactColumn {
+			r.columns = append(r.columns, re)
+		} else {
+			r.values = append(r.values, re)
+		}
+	}
+	if len(r.columns) == 0 && len(r.values) == 0 {
+		return nil
+	}
+	return r
+}
+
+// RedactRow redacts row in place and reports whether it changed anything.
+func (r *Redactor) RedactRow(row map[string]string) bool {
+	if r == nil {
+		return false
+	}
+	changed := false
+	for col, v := range row {
+		redacted := r.redact(col, v)
+		if redacted != v {
+			row[col] = redacted
+			changed = true
+		}
+	}
+	return changed
+}
+
+func (r *Redactor) redact(col, v string) string {
+	for _, re := range r.columns {
+		if re.MatchString(col) {
+			return RedactedValue
+		}
+	}
+	for _, re := range r.values {
+		v = re.ReplaceAllLiteralString(v, RedactedValue)
+	}
+	return v
+}
+
+type RedactionRuleRepository struct {
+	pool *pgxpool.Pool
+
+	mu        sync.Mutex
+	redactors map[uuid.UUID]cachedRedactor
+}
+
+type cachedRedactor struct {
+	redactor  *Redactor
+	expiresAt time.Time
+}
+
+func NewRedactionRuleRepository(pool *pgxpool.Pool) *RedactionRuleRepository {
+	return &RedactionRuleRepository{pool: pool, redactors: make(map[uuid.UUID]cachedRedactor)}
+}
+
+// List returns the organization's rules, column rules first.
+func (r *RedactionRuleRepository) List(ctx context.Context, organizationID uuid.UUID) ([]RedactionRule, error) {
+	rows, err := r.pool.Query(ctx, `
+		SELECT id, organization_id, kind, pattern, description, created_at
+		FROM organization_redaction_rules
+		WHERE organization_id = $1
+		ORDER BY kind, pattern
+	`, organizationID)
+	if err != nil {
+		return nil, fmt.Errorf("querying redaction rules: %w", err)
+	}
+	defer rows.Close()
+
+	var rules []RedactionRule
+	for rows.Next() {
+		var rule RedactionRule
+		if err := rows.Scan(&rule.ID, &rule.OrganizationID, &rule.Kind, &rule.Pattern, &rule.Description, &rule.CreatedAt); err != nil {
+			return nil, fmt.Errorf("scanning redaction rule: %w", err)
+		}
+		rules = append(rules, rule)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("iterating redaction rules: %w", err)
+	}
+	return rules, nil
+}
+
+// Add validates and stores a rule for the organization.
+func (r *RedactionRuleRepository) Add(ctx context.Context, organizationID uuid.UUID, kind, pattern, description string) (*RedactionRule, error) {
+	pattern = strings.TrimSpace(pattern)
+	if _, err := compileRedactionRule(kind, pattern); err != nil {
+		return nil, err
+	}
+
+	rule := &RedactionRule{}
+	err := r.pool.QueryRow(ctx, `
+		INSERT INTO organization_redaction_rules (organization_id, kind, pattern, description)
+		VALUES ($1, $2, $3, $4)
+		RETURNING id, organization_id, kind, pattern, description, created_at
+	`, organizationID, kind, pattern, strings.TrimSpace(description)).
+		Scan(&rule.ID, &rule.OrganizationID, &rule.Kind, &rule.Pattern, &rule.Description, &rule.CreatedAt)
+	if err != nil {
+		var pgErr *pgconn.PgError
+		if errors.As(err, &pgErr) && pgErr.Code == "23505" {
+			return nil, ErrDuplicateRedactionRule
+		}
+		return nil, fmt.Errorf("inserting redaction rule: %w", err)
+	}
+	r.invalidate(organizationID)
+	return rule, nil
+}
+
+// Delete removes one of the organization's rules.
+func (r *RedactionRuleRepository) Delete(ctx context.Context, organizationID uuid.UUID, id int64) error {
+	tag, err := r.pool.Exec(ctx, `
+		DELETE FROM organization_redaction_rules
+		WHERE organization_id = $1 AND id = $2
+	`, organizationID, id)
+	if err != nil {
+		return fmt.Errorf("deleting redaction rule: %w", err)
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrRedactionRuleNotFound
+	}
+	r.invalidate(organizationID)
+	return nil
+}
+
+// Redactor returns the organization's compiled rules, or nil if it has none.
+// Results are cached briefly since every result write asks for them.
+func (r *RedactionRuleRepository) Redactor(ctx context.Context, organizationID uuid.UUID) (*Redactor, error) {
+	now := time.Now()
+	r.mu.Lock()
+	cached, ok := r.redactors[organizationID]
+	r.mu.Unlock()
+	if ok && now.Before(cached.expiresAt) {
+		return cached.redactor, nil
+	}
+
+	rules, err := r.List(ctx, organizationID)
+	if err != nil {
+		return nil, err
+	}
+	redactor := NewRedactor(rules)
+
+	r.mu.Lock()
+	for id, c := range r.redactors {
+		if !now.Before(c.expiresAt) {
+			delete(r.redactors, id)
+		}
+	}
+	r.redactors[organizationID] = cachedRedactor{redactor: redactor, expiresAt: now.Add(redactorCacheTTL)}
+	r.mu.Unlock()
+	return redactor, nil
+}
+
+func (r *RedactionRuleRepository) invalidate(organizationID uuid.UUID) {
+	r.mu.Lock()
+	delete(r.redactors, organizationID)
+	r.mu.Unlock()
+}
@@ -0,0 +1,180 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+const (
+	// DefaultBaselineThreshold is the threshold of a rule added without one.
+	DefaultBaselineThreshold = 3.0
+	// MaxBaselineColumns caps the columns a rule watches for new values.
+	MaxBaselineColumns = 10
+)
+
+var (
+	ErrInvalidBaselineQuery     = errors.New("enter the name of a scheduled query, e.g. pack/security/listening_ports")
+	ErrInvalidBaselineColumns   = fmt.Errorf("list at most %d column names, separated by commas", MaxBaselineColumns)
+	ErrInvalidBaselineThreshold = errors.New("threshold must be between 0 and 100 standard deviations")
+	ErrDuplicateBaselineRule    = errors.New("that query already has a baseline rule")
+	ErrBaselineRuleNotFound     = errors.New("baseline rule not found")
+)
+
+// ResultBaselineRule flags a scheduled query's results on any of an
+// organization's hosts that stray from the host's own history: an hour whose
+// row count is Threshold or more standard deviations from the host's hourly
+// mean, or a value in one of Columns that the host hasn't reported before.
+type ResultBaselineRule struct {
+	ID             int64     `json:"id"`
+	OrganizationID uuid.UUID `json:"organization_id"`
+	// QueryName is the name osquery logs the results under, such as a
+	// schedule entry or pack/<pack>/<query>.
+	QueryName   string    `json:"query_name"`
+	Columns     []string  `json:"columns"`
+	Threshold   float64   `json:"threshold"`
+	Description string    `json:"description"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+// Baseline summarizes a host's hourly row counts for a query.
+type Baseline struct {
+	Mean    float64
+	StdDev  float64
+	Samples int
+}
+
+// NewBaseline computes the mean and population standard deviation of counts.
+func NewBaseline(counts []float64) Baseline {
+	b := Baseline{Samples: len(counts)}
+	if b.Samples == 0 {
+		return b
+	}
+	for _, c := range counts {
+		b.Mean += c
+	}
+	b.Mean /= float64(b.Samples)
+	var variance float64
+	for _, c := range counts {
+		variance += (c - b.Mean) * (c - b.Mean)
+	}
+	b.StdDev = math.Sqrt(variance / float64(b.Samples))
+	return b
+}
+
+// Deviation returns how many standard deviations observed is from the mean.
+// The spread is taken to be at least one row, so a query that always returns
+// the same rows isn't flagged for a single extra one.
+func (b Baseline) Deviation(observed float64) float64 {
+	return math.Abs(observed-b.Mean) / max(b.StdDev, 1)
+}
+
+// parseBaselineColumns splits a comma-separated list of column names,
+// dropping blanks and repeats.
+func parseBaselineColumns(columns string) ([]string, error) {
+	parsed := []string{}
+	for _, c := range strings.Split(columns, ",") {
+		if c = strings.TrimSpace(c); c != "" && !slices.Contains(parsed, c) {
+			parsed = append(parsed, c)
+		}
+	}
+	if len(parsed) > MaxBaselineColumns {
+		return nil, ErrInvalidBaselineColumns
+	}
+	return parsed, nil
+}
+
+type ResultBaselineRuleRepository struct {
+	pool *pgxpool.Pool
+}
+
+func NewResultBaselineRuleRepository(pool *pgxpool.Pool) *ResultBaselineRuleRepository {
+	return &ResultBaselineRuleRepository{pool: pool}
+}
+
+// List returns the organization's rules by query name.
+func (r *ResultBaselineRuleRepository) List(ctx context.Context, organizationID uuid.UUID) ([]ResultBaselineRule, error) {
+	rows, err := r.pool.Query(ctx, `
+		SELECT id, organization_id, query_name, columns, threshold, description, created_at
+		FROM result_baseline_rules
+		WHERE organization_id = $1
+		ORDER BY query_name
+	`, organizationID)
+	if err != nil {
+		return nil, fmt.Errorf("querying result baseline rules: %w", err)
+	}
+	defer rows.Close()
+
+	var rules []ResultBaselineRule
+	for rows.Next() {
+		var rule ResultBaselineRule
+		if err := rows.Scan(&rule.ID, &rule.OrganizationID, &rule.QueryName, &rule.Columns, &rule.Threshold, &rule.Description, &rule.CreatedAt); err != nil {
+			return nil, fmt.Errorf("scanning result baseline rule: %w", err)
+		}
+		rules = append(rules, rule)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("iterating result baseline rules: %w", err)
+	}
+	return rules, nil
+}
+
+// Add validates and stores a rule for the organization. columns is a
+// comma-separated list of column names, and a zero threshold means
+// DefaultBaselineThreshold.
+func (r *ResultBaselineRuleRepository) Add(ctx context.Context, organizationID uuid.UUID, queryName, columns string, threshold float64, description string) (*ResultBaselineRule, error) {
+	rule := ResultBaselineRule{QueryName: strings.TrimSpace(queryName), Threshold: threshold}
+	if rule.QueryName == "" || len(rule.QueryName) > 255 {
+		return nil, ErrInvalidBaselineQuery
+	}
+	parsed, err := parseBaselineColumns(columns)
+	if err != nil {
+		return nil, err
+	}
+	rule.Columns = parsed
+	if rule.Threshold == 0 {
+		rule.Threshold = DefaultBaselineThreshold
+	}
+	if math.IsNaN(rule.Threshold) || rule.Threshold <= 0 || rule.Threshold > 100 {
+		return nil, ErrInvalidBaselineThreshold
+	}
+
+	err = r.pool.QueryRow(ctx, `
+		INSERT INTO result_baseline_rules (organization_id, query_name, columns, threshold, description)
+		VALUES ($1, $2, $3, $4, $5)
+		RETURNING id, organization_id, query_name, columns, threshold, description, created_at
+	`, organizationID, rule.QueryName, rule.Columns, rule.Threshold, strings.TrimSpace(description)).
+		Scan(&rule.ID, &rule.OrganizationID, &rule.QueryName, &rule.Columns, &rule.Threshold, &rule.Description, &rule.CreatedAt)
+	if err != nil {
+		var pgErr *pgconn.PgError
+		if errors.As(err, &pgErr) && pgErr.Code == "23505" {
+			return nil, ErrDuplicateBaselineRule
+		}
+		return nil, fmt.Errorf("inserting result baseline rule: %w", err)
+	}
+	return &rule, nil
+}
+
+// Delete removes one of the organization's rules, and the anomalies it
+// found.
+func (r *ResultBaselineRuleRepository) Delete(ctx context.Context, organizationID uuid.UUID, id int64) error {
+	tag, err := r.pool.Exec(ctx, `
+		DELETE FROM result_baseline_rules
+		WHERE organization_id = $1 AND id = $2
+	`, organizationID, id)
+	if err != nil {
+		return fmt.Errorf("deleting result baseline rule: %w", err)
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrBaselineRuleNotFound
+	}
+	return nil
+}
@@ -0,0 +1,83 @@
+package services_test
+
+import (
+	"context"
+	"errors"
+	"math"
+	"slices"
+	"testing"
+
+	orgservices "github.com/cavenine/queryops/features/organization/services"
+	"github.com/cavenine/queryops/internal/testdb"
+	"github.com/cavenine/queryops/internal/testdb/fixtures"
+)
+
+func TestBaseline_Deviation(t *testing.T) {
+	b := orgservices.NewBaseline([]float64{2, 4, 4, 4, 5, 5, 7, 9})
+	if b.Mean != 5 || b.StdDev != 2 || b.Samples != 8 {
+		t.Fatalf("NewBaseline = %+v, want mean 5, stddev 2, 8 samples", b)
+	}
+	if got := b.Deviation(11); got != 3 {
+		t.Fatalf("Deviation(11) = %v, want 3", got)
+	}
+	if got := b.Deviation(1); got != 2 {
+		t.Fatalf("Deviation(1) = %v, want 2", got)
+	}
+
+	flat := orgservices.NewBaseline([]float64{10, 10, 10})
+	if got := flat.Deviation(11); got != 1 {
+		t.Fatalf("flat Deviation(11) = %v, want 1", got)
+	}
+	if got := orgservices.NewBaseline(nil); got.Samples != 0 || math.IsNaN(got.Mean) {
+		t.Fatalf("empty NewBaseline = %+v", got)
+	}
+}
+
+func TestResultBaselineRuleRepository(t *testing.T) {
+	tdb := testdb.SetupTestDB(t)
+	ctx := context.Background()
+
+	orgID := fixtures.CreateOrg(t, tdb.Pool, "baseline-org").ID
+	otherOrgID := fixtures.CreateOrg(t, tdb.Pool, "other-org").ID
+	repo := orgservices.NewResultBaselineRuleRepository(tdb.Pool)
+
+	rule, err := repo.Add(ctx, orgID, " pack/security/listening_ports ", " port, address,,port ", 0, " listeners ")
+	if err != nil {
+		t.Fatalf("Add: %v", err)
+	}
+	if rule.QueryName != "pack/security/listening_ports" || rule.Description != "listeners" ||
+		rule.Threshold != orgservices.DefaultBaselineThreshold || !slices.Equal(rule.Columns, []string{"port", "address"}) {
+		t.Fatalf("added = %+v", rule)
+	}
+	if _, err := repo.Add(ctx, orgID, "pack/security/listening_ports", "", 2, ""); !errors.Is(err, orgservices.ErrDuplicateBaselineRule) {
+		t.Fatalf("duplicate Add err = %v", err)
+	}
+	if _, err := repo.Add(ctx, orgID, " ", "", 0, ""); !errors.Is(err, orgservices.ErrInvalidBaselineQuery) {
+		t.Fatalf("blank query Add err = %v", err)
+	}
+	if _, err := repo.Add(ctx, orgID, "users", "", -1, ""); !errors.Is(err, orgservices.ErrInvalidBaselineThreshold) {
+		t.Fatalf("negative threshold Add err = %v", err)
+	}
+	if _, err := repo.Add(ctx, orgID, "users", "a,b,c,d,e,f,g,h,i,j,k", 0, ""); !errors.Is(err, orgservices.ErrInvalidBaselineColumns) {
+		t.Fatalf("too many columns Add err = %v", err)
+	}
+	counts, err := repo.Add(ctx, orgID, "processes", "", 4.5, "")
+	if err != nil || len(counts.Columns) != 0 || counts.Threshold != 4.5 {
+		t.Fatalf("Add(no columns) = %+v, %v", counts, err)
+	}
+
+	rules, err := repo.List(ctx, orgID)
+	if err != nil || len(rules) != 2 || rules[0].ID != rule.ID {
+		t.Fatalf("List = %+v, %v; want 2 rules by query name", rules, err)
+	}
+	if others, _ := repo.List(ctx, otherOrgID); len(others) != 0 {
+		t.Fatalf("other organization got rules")
+	}
+
+	if err := repo.Delete(ctx, otherOrgID, rule.ID); !errors.Is(err, orgservices.ErrBaselineRuleNotFound) {
+		t.Fatalf("Delete from other org err = %v", err)
+	}
+	if err := repo.Delete(ctx, orgID, rule.ID); err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+}
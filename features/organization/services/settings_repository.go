@@ -0,0 +1,338 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"regexp"
+	"slices"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+
+	"github.com/cavenine/queryops/internal/validate"
+)
+
+// Bounds on the organization defaults, matching the organization_settings
+// checks. The osquery intervals are in seconds: distributed reads more often
+// than every few seconds, or logs flushed every second, load the server far
+// more than they speed anything up, and an hour is the most anyone waits for
+// a live query or a log line; configs change rarely enough that refreshing
+// once a day is still useful.
+const (
+	MaxRetentionDays = 3650
+
+	MinDistributedInterval = 5
+	MaxDistributedInterval = 3600
+	MinConfigRefresh       = 60
+	MaxConfigRefresh       = 86400
+	MinLoggerTLSPeriod     = 2
+	MaxLoggerTLSPeriod     = 3600
+)
+
+// osquery's own intervals, in seconds, used when neither the config nor the
+// organization sets one. osquery doesn't refresh its config unless told to.
+const (
+	osqueryDistributedInterval = 60
+	osqueryLoggerTLSPeriod     = 4
+)
+
+// Host identity strategies: what decides which host record an enrolling
+// machine belongs to.
+const (
+	// HostIdentityHostIdentifier matches hosts by the host identifier
+	// osquery enrolls with, its hostname unless configured otherwise.
+	HostIdentityHostIdentifier = "host_identifier"
+	// HostIdentityHardwareUUID matches hosts by system_info's hardware UUID,
+	// so re-imaged and renamed machines keep their records.
+	HostIdentityHardwareUUID = "hardware_uuid"
+	// HostIdentityInstanceID matches hosts by osquery_info's instance ID,
+	// so each osquery installation gets its own record even when hostnames
+	// are reused, as in auto-scaling groups.
+	HostIdentityInstanceID = "instance_id"
+)
+
+// HostIdentities lists the host identity strategies.
+var HostIdentities = []string{HostIdentityHostIdentifier, HostIdentityHardwareUUID, HostIdentityInstanceID}
+
+// Decorators an organization can add to every config its hosts are served.
+// Each is also the name of the decoration osquery sends with its logs.
+const (
+	// DecoratorHostname is system_info's hostname.
+	DecoratorHostname = "hostname"
+	// DecoratorHardwareUUID is system_info's hardware UUID.
+	DecoratorHardwareUUID = "hardware_uuid"
+	// DecoratorOrgTag is the organization's decorator tag, so logs that
+	// leave QueryOps can be told apart by organization.
+	DecoratorOrgTag = "org_tag"
+)
+
+// Decorators lists the decorators an organization can add.
+var Decorators = []string{DecoratorHostname, DecoratorHardwareUUID, DecoratorOrgTag}
+
+// MaxDecoratorTagLength is the longest decorator tag, matching the
+// organization_settings check.
+const MaxDecoratorTagLength = 64
+
+var decoratorTagPattern = regexp.MustCompile(`^[A-Za-z0-9._:-]+$`)
+
+// OrganizationSettings are an organization's defaults. A nil value keeps the
+// built-in behavior.
+type OrganizationSettings struct {
+	OrganizationID uuid.UUID `json:"organization_id"`
+	// ResultRetentionDays is how long scheduled query results and status
+	// logs are kept. Nil keeps them forever.
+	ResultRetentionDays *int `json:"result_retention_days,omitempty"`
+	// CampaignArchiveDays is how old finished campaigns get before they're
+	// archived. Nil archives them by hand only.
+	CampaignArchiveDays *int `json:"campaign_archive_days,omitempty"`
+	// DistributedInterval, ConfigRefresh, and LoggerTLSPeriod override the
+	// osquery options of the shared default config, in seconds. Hosts
+	// assigned their own config get it as written.
+	DistributedInterval *int `json:"distributed_interval,omitempty"`
+	ConfigRefresh       *int `json:"config_refresh,omitempty"`
+	LoggerTLSPeriod     *int `json:"logger_tls_period,omitempty"`
+	// MutedNotificationKinds are the notification kinds the organization's
+	// members aren't sent.
+	MutedNotificationKinds []string `json:"muted_notification_kinds"`
+	// HostIdentity is the host identity strategy enrolling hosts are
+	// matched by. Machines missing the hardware UUID or instance ID it
+	// needs are matched by host identifier.
+	HostIdentity string `json:"host_identity"`
+	// Decorators are added to every config the organization's hosts are
+	// served, and DecoratorTag is the value of DecoratorOrgTag.
+	Decorators   []string `json:"decorators"`
+	DecoratorTag string   `json:"decorator_tag,omitempty"`
+}
+
+// OsqueryIntervals are the polling intervals hosts are given, in seconds. A
+// nil interval is left to osquery.
+type OsqueryIntervals struct {
+	DistributedInterval *int `json:"distributed_interval,omitempty"`
+	ConfigRefresh       *int `json:"config_refresh,omitempty"`
+	LoggerTLSPeriod     *int `json:"logger_tls_period,omitempty"`
+}
+
+// Merge returns i with the intervals set in s in its place, as hosts on the
+// default config get them.
+func (i OsqueryIntervals) Merge(s *OrganizationSettings) OsqueryIntervals {
+	if s == nil {
+		return i
+	}
+	if s.DistributedInterval != nil {
+		i.DistributedInterval = s.DistributedInterval
+	}
+	if s.ConfigRefresh != nil {
+		i.ConfigRefresh = s.ConfigRefresh
+	}
+	if s.LoggerTLSPeriod != nil {
+		i.LoggerTLSPeriod = s.LoggerTLSPeriod
+	}
+	return i
+}
+
+// LiveQueryDelay returns the longest a host polling at i waits before it
+// picks up a live query, in seconds.
+func (i OsqueryIntervals) LiveQueryDelay() int {
+	return intervalOr(i.DistributedInterval, osqueryDistributedInterval)
+}
+
+// RequestsPerMinute estimates how many requests hosts polling at i make in
+// a minute: distributed reads, config refreshes, and log flushes. A host
+// only flushes logs it has, so that part is an upper bound.
+func (i OsqueryIntervals) RequestsPerMinute(hosts int) int {
+	perHost := 60.0 / float64(i.LiveQueryDelay())
+	if refresh := intervalOr(i.ConfigRefresh, 0); refresh > 0 {
+		perHost += 60.0 / float64(refresh)
+	}
+	perHost += 60.0 / float64(intervalOr(i.LoggerTLSPeriod, osqueryLoggerTLSPeriod))
+	return int(math.Round(perHost * float64(hosts)))
+}
+
+func intervalOr(v *int, fallback int) int {
+	if v == nil || *v <= 0 {
+		return fallback
+	}
+	return *v
+}
+
+type SettingsRepository struct {
+	pool *pgxpool.Pool
+}
+
+func NewSettingsRepository(pool *pgxpool.Pool) *SettingsRepository {
+	return &SettingsRepository{pool: pool}
+}
+
+// GetSettings returns the organization's settings, all unset if it has never
+// saved any.
+func (r *SettingsRepository) GetSettings(ctx context.Context, organizationID uuid.UUID) (*OrganizationSettings, error) {
+	s := &OrganizationSettings{OrganizationID: organizationID, HostIdentity: HostIdentityHostIdentifier}
+	err := r.pool.QueryRow(ctx, `
+		SELECT result_retention_days, campaign_archive_days, distributed_interval, config_refresh, logger_tls_period, muted_notification_kinds,
+		       host_identity, decorators, decorator_tag
+		FROM organization_settings
+		WHERE organization_id = $1
+	`, organizationID).Scan(&s.ResultRetentionDays, &s.CampaignArchiveDays, &s.DistributedInterval, &s.ConfigRefresh, &s.LoggerTLSPeriod, &s.MutedNotificationKinds,
+		&s.HostIdentity, &s.Decorators, &s.DecoratorTag)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return s, nil
+		}
+		return nil, fmt.Errorf("querying organization settings: %w", err)
+	}
+	return s, nil
+}
+
+// SaveDefaults stores the retention and archival periods, osquery
+// intervals, and host identity strategy in s; an empty strategy is
+// HostIdentityHostIdentifier. Invalid values are reported as validate.Errors
+// keyed by form field: retention_days, campaign_archive_days,
+// distributed_interval, config_refresh, logger_tls_period, and
+// host_identity.
+func (r *SettingsRepository) SaveDefaults(ctx context.Context, s OrganizationSettings) error {
+	fields := validate.Errors{}
+	checkRange(fields, "retention_days", s.ResultRetentionDays, 1, MaxRetentionDays, "days")
+	checkRange(fields, "campaign_archive_days", s.CampaignArchiveDays, 1, MaxRetentionDays, "days")
+	checkRange(fields, "distributed_interval", s.DistributedInterval, MinDistributedInterval, MaxDistributedInterval, "seconds")
+	checkRange(fields, "config_refresh", s.ConfigRefresh, MinConfigRefresh, MaxConfigRefresh, "seconds")
+	checkRange(fields, "logger_tls_period", s.LoggerTLSPeriod, MinLoggerTLSPeriod, MaxLoggerTLSPeriod, "seconds")
+	if s.HostIdentity == "" {
+		s.HostIdentity = HostIdentityHostIdentifier
+	}
+	fields.Check(slices.Contains(HostIdentities, s.HostIdentity), "host_identity", "Unknown host identity strategy")
+	if err := fields.Err(); err != nil {
+		return err
+	}
+
+	_, err := r.pool.Exec(ctx, `
+		INSERT INTO organization_settings (organization_id, result_retention_days, campaign_archive_days, distributed_interval, config_refresh, logger_tls_period,
+		                                   host_identity)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+		ON CONFLICT (organization_id)
+		DO UPDATE SET result_retention_days = EXCLUDED.result_retention_days,
+			campaign_archive_days = EXCLUDED.campaign_archive_days,
+			distributed_interval = EXCLUDED.distributed_interval,
+			config_refresh = EXCLUDED.config_refresh,
+			logger_tls_period = EXCLUDED.logger_tls_period,
+			host_identity = EXCLUDED.host_identity,
+			updated_at = NOW()
+	`, s.OrganizationID, s.ResultRetentionDays, s.CampaignArchiveDays, s.DistributedInterval, s.ConfigRefresh, s.LoggerTLSPeriod,
+		s.HostIdentity)
+	if err != nil {
+		return fmt.Errorf("saving organization defaults: %w", err)
+	}
+	return nil
+}
+
+// SetMutedNotifications replaces the notification kinds the organization's
+// members aren't sent.
+func (r *SettingsRepository) SetMutedNotifications(ctx context.Context, organizationID uuid.UUID, kinds []string) error {
+	if kinds == nil {
+		kinds = []string{}
+	}
+
+	_, err := r.pool.Exec(ctx, `
+		INSERT INTO organization_settings (organization_id, muted_notification_kinds)
+		VALUES ($1, $2)
+		ON CONFLICT (organization_id)
+		DO UPDATE SET muted_notification_kinds = EXCLUDED.muted_notification_kinds,
+			updated_at = NOW()
+	`, organizationID, kinds)
+	if err != nil {
+		return fmt.Errorf("saving muted notifications: %w", err)
+	}
+	return nil
+}
+
+// SetDecorators replaces the decorators added to the organization's configs
+// and the tag DecoratorOrgTag decorates logs with. The tag is required with
+// DecoratorOrgTag, and kept without it. Problems are reported as
+// validate.Errors keyed by form field: decorators and decorator_tag.
+func (r *SettingsRepository) SetDecorators(ctx context.Context, organizationID uuid.UUID, decorators []string, tag string) error {
+	fields := validate.Errors{}
+	for _, d := range decorators {
+		if !slices.Contains(Decorators, d) {
+			fields.Add("decorators", fmt.Sprintf("Unknown decorator %q", d))
+			break
+		}
+	}
+	switch {
+	case tag == "":
+		fields.Check(!slices.Contains(decorators, DecoratorOrgTag), "decorator_tag", "Enter the tag to decorate logs with")
+	case len(tag) > MaxDecoratorTagLength:
+		fields.Add("decorator_tag", fmt.Sprintf("Must be at most %d characters", MaxDecoratorTagLength))
+	default:
+		fields.Check(decoratorTagPattern.MatchString(tag), "decorator_tag", "Use letters, digits, and . _ : - only")
+	}
+	if err := fields.Err(); err != nil {
+		return err
+	}
+	// Stored in Decorators order, however they were given.
+	ordered := []string{}
+	for _, d := range Decorators {
+		if slices.Contains(decorators, d) {
+			ordered = append(ordered, d)
+		}
+	}
+
+	_, err := r.pool.Exec(ctx, `
+		INSERT INTO organization_settings (organization_id, decorators, decorator_tag)
+		VALUES ($1, $2, $3)
+		ON CONFLICT (organization_id)
+		DO UPDATE SET decorators = EXCLUDED.decorators,
+			decorator_tag = EXCLUDED.decorator_tag,
+			updated_at = NOW()
+	`, organizationID, ordered, tag)
+	if err != nil {
+		return fmt.Errorf("saving decorators: %w", err)
+	}
+	return nil
+}
+
+// DefaultConfigIntervals returns the osquery intervals set in the shared
+// default config, which the organization's settings override. Options that
+// aren't whole numbers of seconds count as unset.
+func (r *SettingsRepository) DefaultConfigIntervals(ctx context.Context) (OsqueryIntervals, error) {
+	var raw []byte
+	err := r.pool.QueryRow(ctx, `
+		SELECT COALESCE(config->'options', '{}')
+		FROM osquery_configs
+		WHERE name = 'default'
+	`).Scan(&raw)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return OsqueryIntervals{}, nil
+		}
+		return OsqueryIntervals{}, fmt.Errorf("querying default config options: %w", err)
+	}
+	var options map[string]any
+	if err := json.Unmarshal(raw, &options); err != nil {
+		return OsqueryIntervals{}, fmt.Errorf("decoding default config options: %w", err)
+	}
+	return OsqueryIntervals{
+		DistributedInterval: secondsOption(options, "distributed_interval"),
+		ConfigRefresh:       secondsOption(options, "config_refresh"),
+		LoggerTLSPeriod:     secondsOption(options, "logger_tls_period"),
+	}, nil
+}
+
+func secondsOption(options map[string]any, name string) *int {
+	f, ok := options[name].(float64)
+	if !ok || f != math.Trunc(f) {
+		return nil
+	}
+	n := int(f)
+	return &n
+}
+
+// checkRange records a problem with field if v is set and outside min..max,
+// measured in unit.
+func checkRange(fields validate.Errors, field string, v *int, min, max int, unit string) {
+	if v != nil && (*v < min || *v > max) {
+		fields.Add(field, fmt.Sprintf("Must be between %d and %d %s", min, max, unit))
+	}
+}
@@ -0,0 +1,184 @@
+package services_test
+
+import (
+	"context"
+	"slices"
+	"testing"
+
+	notificationServices "github.com/cavenine/queryops/features/notification/services"
+	orgservices "github.com/cavenine/queryops/features/organization/services"
+	"github.com/cavenine/queryops/internal/testdb"
+	"github.com/cavenine/queryops/internal/testdb/fixtures"
+	"github.com/cavenine/queryops/internal/validate"
+)
+
+func TestSettingsRepository_Defaults(t *testing.T) {
+	tdb := testdb.SetupTestDB(t)
+	ctx := context.Background()
+
+	orgID := fixtures.CreateOrg(t, tdb.Pool, "settings-org").ID
+	repo := orgservices.NewSettingsRepository(tdb.Pool)
+
+	settings, err := repo.GetSettings(ctx, orgID)
+	if err != nil {
+		t.Fatalf("GetSettings: %v", err)
+	}
+	if settings.ResultRetentionDays != nil || settings.DistributedInterval != nil || len(settings.MutedNotificationKinds) != 0 ||
+		settings.HostIdentity != orgservices.HostIdentityHostIdentifier {
+		t.Fatalf("default settings = %+v, want unset", settings)
+	}
+
+	days, archiveDays, interval, tooLong, tooShort := 30, 90, 60, orgservices.MaxConfigRefresh+1, orgservices.MinDistributedInterval-1
+	err = repo.SaveDefaults(ctx, orgservices.OrganizationSettings{OrganizationID: orgID, ConfigRefresh: &tooLong, DistributedInterval: &tooShort})
+	if fields, ok := validate.As(err); !ok || !fields.Has("config_refresh") || !fields.Has("distributed_interval") {
+		t.Fatalf("SaveDefaults(out of range) error = %v, want config_refresh and distributed_interval problems", err)
+	}
+	err = repo.SaveDefaults(ctx, orgservices.OrganizationSettings{OrganizationID: orgID, HostIdentity: "mac_address"})
+	if fields, ok := validate.As(err); !ok || !fields.Has("host_identity") {
+		t.Fatalf("SaveDefaults(unknown strategy) error = %v, want host_identity problem", err)
+	}
+
+	if err := repo.SaveDefaults(ctx, orgservices.OrganizationSettings{
+		OrganizationID:      orgID,
+		ResultRetentionDays: &days,
+		CampaignArchiveDays: &archiveDays,
+		DistributedInterval: &interval,
+		HostIdentity:        orgservices.HostIdentityHardwareUUID,
+	}); err != nil {
+		t.Fatalf("SaveDefaults: %v", err)
+	}
+	muted := []string{notificationServices.KindHostOffline}
+	if err := repo.SetMutedNotifications(ctx, orgID, muted); err != nil {
+		t.Fatalf("SetMutedNotifications: %v", err)
+	}
+
+	settings, err = repo.GetSettings(ctx, orgID)
+	if err != nil {
+		t.Fatalf("GetSettings: %v", err)
+	}
+	if settings.ResultRetentionDays == nil || *settings.ResultRetentionDays != days {
+		t.Errorf("ResultRetentionDays = %v, want %d", settings.ResultRetentionDays, days)
+	}
+	if settings.CampaignArchiveDays == nil || *settings.CampaignArchiveDays != archiveDays {
+		t.Errorf("CampaignArchiveDays = %v, want %d", settings.CampaignArchiveDays, archiveDays)
+	}
+	if settings.DistributedInterval == nil || *settings.DistributedInterval != interval {
+		t.Errorf("DistributedInterval = %v, want %d", settings.DistributedInterval, interval)
+	}
+	if settings.ConfigRefresh != nil || settings.LoggerTLSPeriod != nil {
+		t.Errorf("unset intervals = %v, %v, want nil", settings.ConfigRefresh, settings.LoggerTLSPeriod)
+	}
+	if !slices.Equal(settings.MutedNotificationKinds, muted) {
+		t.Errorf("MutedNotificationKinds = %v, want %v", settings.MutedNotificationKinds, muted)
+	}
+	if settings.HostIdentity != orgservices.HostIdentityHardwareUUID {
+		t.Errorf("HostIdentity = %q, want %q", settings.HostIdentity, orgservices.HostIdentityHardwareUUID)
+	}
+
+	// Saving defaults leaves the notification preferences alone.
+	if err := repo.SaveDefaults(ctx, orgservices.OrganizationSettings{OrganizationID: orgID}); err != nil {
+		t.Fatalf("SaveDefaults(cleared): %v", err)
+	}
+	settings, err = repo.GetSettings(ctx, orgID)
+	if err != nil {
+		t.Fatalf("GetSettings: %v", err)
+	}
+	if settings.ResultRetentionDays != nil || settings.CampaignArchiveDays != nil || !slices.Equal(settings.MutedNotificationKinds, muted) ||
+		settings.HostIdentity != orgservices.HostIdentityHostIdentifier {
+		t.Errorf("settings after clearing defaults = %+v", settings)
+	}
+}
+
+func TestSettingsRepository_Decorators(t *testing.T) {
+	tdb := testdb.SetupTestDB(t)
+	ctx := context.Background()
+
+	orgID := fixtures.CreateOrg(t, tdb.Pool, "decorators-org").ID
+	repo := orgservices.NewSettingsRepository(tdb.Pool)
+
+	err := repo.SetDecorators(ctx, orgID, []string{"mac_address"}, "")
+	if fields, ok := validate.As(err); !ok || !fields.Has("decorators") {
+		t.Fatalf("SetDecorators(unknown) error = %v, want decorators problem", err)
+	}
+	err = repo.SetDecorators(ctx, orgID, []string{orgservices.DecoratorOrgTag}, "")
+	if fields, ok := validate.As(err); !ok || !fields.Has("decorator_tag") {
+		t.Fatalf("SetDecorators(no tag) error = %v, want decorator_tag problem", err)
+	}
+	err = repo.SetDecorators(ctx, orgID, nil, "acme prod")
+	if fields, ok := validate.As(err); !ok || !fields.Has("decorator_tag") {
+		t.Fatalf("SetDecorators(bad tag) error = %v, want decorator_tag problem", err)
+	}
+
+	if err := repo.SetDecorators(ctx, orgID, []string{orgservices.DecoratorOrgTag, orgservices.DecoratorHostname}, "acme-prod"); err != nil {
+		t.Fatalf("SetDecorators: %v", err)
+	}
+	settings, err := repo.GetSettings(ctx, orgID)
+	if err != nil {
+		t.Fatalf("GetSettings: %v", err)
+	}
+	want := []string{orgservices.DecoratorHostname, orgservices.DecoratorOrgTag}
+	if !slices.Equal(settings.Decorators, want) || settings.DecoratorTag != "acme-prod" {
+		t.Fatalf("decorators = %v, tag %q; want %v, acme-prod", settings.Decorators, settings.DecoratorTag, want)
+	}
+
+	// Saving defaults leaves the decorators alone.
+	if err := repo.SaveDefaults(ctx, orgservices.OrganizationSettings{OrganizationID: orgID}); err != nil {
+		t.Fatalf("SaveDefaults: %v", err)
+	}
+	if settings, err = repo.GetSettings(ctx, orgID); err != nil || !slices.Equal(settings.Decorators, want) {
+		t.Fatalf("decorators after saving defaults = %v, %v", settings.Decorators, err)
+	}
+}
+
+func TestSettingsRepository_DefaultConfigIntervals(t *testing.T) {
+	tdb := testdb.SetupTestDB(t)
+	ctx := context.Background()
+	repo := orgservices.NewSettingsRepository(tdb.Pool)
+
+	if _, err := tdb.Pool.Exec(ctx, `
+		UPDATE osquery_configs
+		SET config = '{"options":{"distributed_interval":10,"config_refresh":"300","logger_tls_period":2.5}}'
+		WHERE name = 'default'
+	`); err != nil {
+		t.Fatalf("updating default config: %v", err)
+	}
+
+	got, err := repo.DefaultConfigIntervals(ctx)
+	if err != nil {
+		t.Fatalf("DefaultConfigIntervals: %v", err)
+	}
+	if got.DistributedInterval == nil || *got.DistributedInterval != 10 {
+		t.Errorf("DistributedInterval = %v, want 10", got.DistributedInterval)
+	}
+	if got.ConfigRefresh != nil || got.LoggerTLSPeriod != nil {
+		t.Errorf("non-integer intervals = %v, %v, want nil", got.ConfigRefresh, got.LoggerTLSPeriod)
+	}
+}
+
+func TestOsqueryIntervals(t *testing.T) {
+	ten, thirty, sixty := 10, 30, 60
+	config := orgservices.OsqueryIntervals{DistributedInterval: &thirty, ConfigRefresh: &sixty}
+
+	merged := config.Merge(&orgservices.OrganizationSettings{DistributedInterval: &ten})
+	if *merged.DistributedInterval != 10 || *merged.ConfigRefresh != 60 || merged.LoggerTLSPeriod != nil {
+		t.Fatalf("Merge = %+v, want distributed 10, config 60, logger unset", merged)
+	}
+	if got := merged.LiveQueryDelay(); got != 10 {
+		t.Errorf("LiveQueryDelay = %d, want 10", got)
+	}
+	// Per host: 6 distributed reads, 1 config refresh, and 15 log flushes at
+	// osquery's 4-second default.
+	if got := merged.RequestsPerMinute(100); got != 2200 {
+		t.Errorf("RequestsPerMinute(100) = %d, want 2200", got)
+	}
+
+	// osquery polls for live queries every minute and never refreshes its
+	// config unless told to.
+	var unset orgservices.OsqueryIntervals
+	if got := unset.LiveQueryDelay(); got != 60 {
+		t.Errorf("unset LiveQueryDelay = %d, want 60", got)
+	}
+	if got := unset.RequestsPerMinute(10); got != 160 {
+		t.Errorf("unset RequestsPerMinute(10) = %d, want 160", got)
+	}
+}
@@ -0,0 +1,444 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgxpool"
+
+	"github.com/cavenine/queryops/internal/crypto"
+	"github.com/cavenine/queryops/internal/notify"
+)
+
+// Slack events. All but SlackEventHostEnrolled are also notification kinds,
+// and share their names.
+const (
+	SlackEventCampaignFinished = "campaign_finished"
+	SlackEventHostEnrolled     = "host_enrolled"
+	SlackEventHostOffline      = "host_offline"
+	SlackEventStatusAlert      = "status_alert"
+	SlackEventResultAnomaly    = "result_anomaly"
+)
+
+// SlackEvents lists the events a route can post, in the order the settings
+// page offers them.
+var SlackEvents = []string{
+	SlackEventCampaignFinished,
+	SlackEventHostEnrolled,
+	SlackEventHostOffline,
+	SlackEventStatusAlert,
+	SlackEventResultAnomaly,
+}
+
+// DefaultSlackTemplate formats messages for routes without a template.
+const DefaultSlackTemplate = "*{{.Title}}*\n{{.Body}}{{if .URL}}\n<{{.URL}}|Open in QueryOps>{{end}}"
+
+// maxSlackTemplateLength caps a route's template.
+const maxSlackTemplateLength = 2000
+
+var (
+	ErrInvalidSlackEvent    = errors.New("choose an event to post")
+	ErrInvalidSlackChannel  = errors.New("channel must be a name like #alerts or a channel ID")
+	ErrInvalidSlackTemplate = errors.New("invalid template; use fields like {{.Title}}, {{.Body}}, and {{.URL}}")
+	ErrSlackTokenRequired   = errors.New("posting to a channel needs a bot token; leave the channel empty to use the webhook")
+	ErrSlackWebhookRequired = errors.New("save an incoming webhook first, or enter a channel to post to with the bot token")
+	ErrDuplicateSlackRoute  = errors.New("that event already posts to that channel")
+	ErrSlackRouteNotFound   = errors.New("slack route not found")
+	ErrSlackTemplateTooLong = fmt.Errorf("template must be at most %d characters", maxSlackTemplateLength)
+)
+
+var slackChannelPattern = regexp.MustCompile(`^#?[a-z0-9][a-z0-9_-]{0,79}$|^[CG][A-Z0-9]{8,}$`)
+
+// SlackIntegration is an organization's Slack connection. The bot token
+// itself is never read back out for display.
+type SlackIntegration struct {
+	OrganizationID uuid.UUID  `json:"organization_id"`
+	WebhookURL     *string    `json:"webhook_url,omitempty"`
+	HasBotToken    bool       `json:"has_bot_token"`
+	UpdatedAt      *time.Time `json:"updated_at,omitempty"`
+}
+
+// SlackRoute posts one event to a channel, or to the webhook's channel if
+// Channel is empty.
+type SlackRoute struct {
+	ID             int64     `json:"id"`
+	OrganizationID uuid.UUID `json:"organization_id"`
+	Event          string    `json:"event"`
+	Channel        string    `json:"channel"`
+	// Template is a Go text/template rendered with a SlackMessage; empty
+	// means DefaultSlackTemplate.
+	Template  string    `json:"template"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// SlackMessage is the data a route's template is rendered with.
+type SlackMessage struct {
+	Event string
+	Title string
+	Body  string
+	// URL is the page the event is about, or empty if the server's public
+	// origin isn't configured.
+	URL string
+}
+
+// RenderSlackTemplate renders m with tmpl, or DefaultSlackTemplate if tmpl is
+// empty. Title and Body are escaped for Slack's markup.
+func RenderSlackTemplate(tmpl string, m SlackMessage) (string, error) {
+	if tmpl == "" {
+		tmpl = DefaultSlackTemplate
+	}
+	t, err := template.New("slack").Option("missingkey=error").Parse(tmpl)
+	if err != nil {
+		return "", fmt.Errorf("%w: %w", ErrInvalidSlackTemplate, err)
+	}
+	m.Title = escapeSlack(m.Title)
+	m.Body = escapeSlack(m.Body)
+	var b strings.Builder
+	if err := t.Execute(&b, m); err != nil {
+		return "", fmt.Errorf("%w: %w", ErrInvalidSlackTemplate, err)
+	}
+	return b.String(), nil
+}
+
+var slackEscaper = strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;")
+
+func escapeSlack(s string) string {
+	return slackEscaper.Replace(s)
+}
+
+// SlackDelivery is a queued message claimed for posting, with what's needed
+// to render and post it.
+type SlackDelivery struct {
+	ID             int64
+	OrganizationID uuid.UUID
+	Event          string
+	Channel        string
+	Template       string
+	Title          string
+	Body           string
+	Link           *string
+	// Attempts counts this one.
+	Attempts   int
+	WebhookURL *string
+	// BotToken is decrypted; empty if the organization has none.
+	BotToken string
+}
+
+// SlackRepository stores organizations' Slack connections and routes, and
+// the messages queued for them. Bot tokens are encrypted with keys.
+type SlackRepository struct {
+	pool *pgxpool.Pool
+	keys *crypto.Keyring
+}
+
+func NewSlackRepository(pool *pgxpool.Pool, keys *crypto.Keyring) *SlackRepository {
+	return &SlackRepository{pool: pool, keys: keys}
+}
+
+// GetIntegration returns the organization's Slack connection, empty if it
+// has never saved one.
+func (r *SlackRepository) GetIntegration(ctx context.Context, organizationID uuid.UUID) (*SlackIntegration, error) {
+	s := &SlackIntegration{OrganizationID: organizationID}
+	err := r.pool.QueryRow(ctx, `
+		SELECT webhook_url, bot_token_ciphertext IS NOT NULL, updated_at
+		FROM slack_integrations
+		WHERE organization_id = $1
+	`, organizationID).Scan(&s.WebhookURL, &s.HasBotToken, &s.UpdatedAt)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return s, nil
+		}
+		return nil, fmt.Errorf("querying slack integration: %w", err)
+	}
+	return s, nil
+}
+
+// SaveIntegration sets the organization's incoming webhook, clearing it if
+// webhookURL is empty. A non-empty botToken replaces the stored token, and
+// removeToken deletes it; otherwise the token is kept.
+func (r *SlackRepository) SaveIntegration(ctx context.Context, organizationID uuid.UUID, webhookURL, botToken string, removeToken bool) error {
+	var webhook *string
+	if webhookURL = strings.TrimSpace(webhookURL); webhookURL != "" {
+		if err := notify.ValidateWebhookURL(webhookURL); err != nil {
+			return err
+		}
+		webhook = &webhookURL
+	}
+	var ciphertext *string
+	if botToken = strings.TrimSpace(botToken); botToken != "" {
+		if err := notify.ValidateSlackToken(botToken); err != nil {
+			return err
+		}
+		encrypted, err := r.keys.Encrypt([]byte(botToken), organizationID[:])
+		if err != nil {
+			return fmt.Errorf("encrypting slack bot token: %w", err)
+		}
+		ciphertext = &encrypted
+	}
+
+	_, err := r.pool.Exec(ctx, `
+		INSERT INTO slack_integrations (organization_id, webhook_url, bot_token_ciphertext)
+		VALUES ($1, $2, $3)
+		ON CONFLICT (organization_id) DO UPDATE SET
+			webhook_url = EXCLUDED.webhook_url,
+			bot_token_ciphertext = CASE
+				WHEN $4 THEN NULL
+				ELSE COALESCE(EXCLUDED.bot_token_ciphertext, slack_integrations.bot_token_ciphertext)
+			END,
+			updated_at = NOW()
+	`, organizationID, webhook, ciphertext, removeToken && ciphertext == nil)
+	if err != nil {
+		return fmt.Errorf("saving slack integration: %w", err)
+	}
+	return nil
+}
+
+// ListRoutes returns the organization's routes by event and channel.
+func (r *SlackRepository) ListRoutes(ctx context.Context, organizationID uuid.UUID) ([]SlackRoute, error) {
+	rows, err := r.pool.Query(ctx, `
+		SELECT id, organization_id, event, channel, template, created_at
+		FROM slack_routes
+		WHERE organization_id = $1
+		ORDER BY event, channel
+	`, organizationID)
+	if err != nil {
+		return nil, fmt.Errorf("querying slack routes: %w", err)
+	}
+	defer rows.Close()
+
+	var routes []SlackRoute
+	for rows.Next() {
+		var route SlackRoute
+		if err := rows.Scan(&route.ID, &route.OrganizationID, &route.Event, &route.Channel, &route.Template, &route.CreatedAt); err != nil {
+			return nil, fmt.Errorf("scanning slack route: %w", err)
+		}
+		routes = append(routes, route)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("iterating slack routes: %w", err)
+	}
+	return routes, nil
+}
+
+// AddRoute validates and stores a route for the organization. A route with a
+// channel needs the organization to have a bot token, and one without needs
+// a webhook.
+func (r *SlackRepository) AddRoute(ctx context.Context, organizationID uuid.UUID, event, channel, tmpl string) (*SlackRoute, error) {
+	route := SlackRoute{Event: event, Channel: strings.TrimSpace(channel), Template: strings.TrimSpace(tmpl)}
+	if !validSlackEvent(route.Event) {
+		return nil, ErrInvalidSlackEvent
+	}
+	if route.Channel != "" && !slackChannelPattern.MatchString(route.Channel) {
+		return nil, ErrInvalidSlackChannel
+	}
+	if len(route.Template) > maxSlackTemplateLength {
+		return nil, ErrSlackTemplateTooLong
+	}
+	if _, err := RenderSlackTemplate(route.Template, SlackMessage{Event: route.Event, Title: "Title", Body: "Body", URL: "https://example.com"}); err != nil {
+		return nil, err
+	}
+
+	integration, err := r.GetIntegration(ctx, organizationID)
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case route.Channel != "" && !integration.HasBotToken:
+		return nil, ErrSlackTokenRequired
+	case route.Channel == "" && integration.WebhookURL == nil:
+		return nil, ErrSlackWebhookRequired
+	}
+
+	err = r.pool.QueryRow(ctx, `
+		INSERT INTO slack_routes (organization_id, event, channel, template)
+		VALUES ($1, $2, $3, $4)
+		RETURNING id, organization_id, event, channel, template, created_at
+	`, organizationID, route.Event, route.Channel, route.Template).
+		Scan(&route.ID, &route.OrganizationID, &route.Event, &route.Channel, &route.Template, &route.CreatedAt)
+	if err != nil {
+		var pgErr *pgconn.PgError
+		if errors.As(err, &pgErr) && pgErr.Code == "23505" {
+			return nil, ErrDuplicateSlackRoute
+		}
+		return nil, fmt.Errorf("inserting slack route: %w", err)
+	}
+	return &route, nil
+}
+
+func validSlackEvent(event string) bool {
+	for _, e := range SlackEvents {
+		if e == event {
+			return true
+		}
+	}
+	return false
+}
+
+// DeleteRoute removes one of the organization's routes, and any messages
+// still queued for it.
+func (r *SlackRepository) DeleteRoute(ctx context.Context, organizationID uuid.UUID, id int64) error {
+	tag, err := r.pool.Exec(ctx, `
+		DELETE FROM slack_routes
+		WHERE organization_id = $1 AND id = $2
+	`, organizationID, id)
+	if err != nil {
+		return fmt.Errorf("deleting slack route: %w", err)
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrSlackRouteNotFound
+	}
+	return nil
+}
+
+// ClaimMessages claims up to limit queued messages that are due and have
+// been attempted fewer than maxAttempts times, oldest first. Claiming counts
+// an attempt and puts the next one retryAfter away, doubling with each
+// attempt, so a message isn't posted twice by concurrent runs and a failed
+// one is retried later.
+func (r *SlackRepository) ClaimMessages(ctx context.Context, limit int, retryAfter time.Duration, maxAttempts int) ([]SlackDelivery, error) {
+	rows, err := r.pool.Query(ctx, `
+		WITH claimed AS (
+			UPDATE slack_messages m
+			SET attempts = m.attempts + 1,
+				next_attempt_at = NOW() + make_interval(secs => $2 * power(2, m.attempts))
+			WHERE m.id IN (
+				SELECT id
+				FROM slack_messages
+				WHERE sent_at IS NULL AND attempts < $3 AND next_attempt_at <= NOW()
+				ORDER BY next_attempt_at, id
+				LIMIT $1
+				FOR UPDATE SKIP LOCKED
+			)
+			RETURNING m.id, m.route_id, m.organization_id, m.title, m.body, m.link, m.attempts
+		)
+		SELECT c.id, c.organization_id, r.event, r.channel, r.template, c.title, c.body, c.link, c.attempts,
+			i.webhook_url, i.bot_token_ciphertext
+		FROM claimed c
+		JOIN slack_routes r ON r.id = c.route_id
+		LEFT JOIN slack_integrations i ON i.organization_id = c.organization_id
+		ORDER BY c.id
+	`, limit, retryAfter.Seconds(), maxAttempts)
+	if err != nil {
+		return nil, fmt.Errorf("claiming slack messages: %w", err)
+	}
+	defer rows.Close()
+
+	var deliveries []SlackDelivery
+	for rows.Next() {
+		var (
+			d          SlackDelivery
+			ciphertext *string
+		)
+		if err := rows.Scan(&d.ID, &d.OrganizationID, &d.Event, &d.Channel, &d.Template, &d.Title, &d.Body, &d.Link, &d.Attempts, &d.WebhookURL, &ciphertext); err != nil {
+			return nil, fmt.Errorf("scanning slack message: %w", err)
+		}
+		if ciphertext != nil {
+			token, err := r.keys.Decrypt(*ciphertext, d.OrganizationID[:])
+			if err != nil {
+				return nil, fmt.Errorf("decrypting slack bot token: %w", err)
+			}
+			d.BotToken = string(token)
+		}
+		deliveries = append(deliveries, d)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("claiming slack messages: %w", err)
+	}
+	return deliveries, nil
+}
+
+// MarkMessageSent records that a claimed message was posted.
+func (r *SlackRepository) MarkMessageSent(ctx context.Context, id int64) error {
+	if _, err := r.pool.Exec(ctx, `
+		UPDATE slack_messages SET sent_at = NOW(), last_error = NULL WHERE id = $1
+	`, id); err != nil {
+		return fmt.Errorf("marking slack message sent: %w", err)
+	}
+	return nil
+}
+
+// MarkMessageFailed records why a claimed message couldn't be posted. It's
+// retried when next due, if it has attempts left.
+func (r *SlackRepository) MarkMessageFailed(ctx context.Context, id int64, reason string) error {
+	if _, err := r.pool.Exec(ctx, `
+		UPDATE slack_messages SET last_error = $2 WHERE id = $1
+	`, id, reason); err != nil {
+		return fmt.Errorf("marking slack message failed: %w", err)
+	}
+	return nil
+}
+
+// RotateBotTokens re-encrypts up to batchSize bot tokens stored under a key
+// other than the keyring's primary, and returns how many it rewrote. Call it
+// until it returns 0.
+func (r *SlackRepository) RotateBotTokens(ctx context.Context, batchSize int) (int, error) {
+	tx, err := r.pool.Begin(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("starting transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	rows, err := tx.Query(ctx, `
+		SELECT organization_id, bot_token_ciphertext
+		FROM slack_integrations
+		WHERE split_part(bot_token_ciphertext, ':', 2) <> $1
+		ORDER BY organization_id
+		LIMIT $2
+		FOR UPDATE SKIP LOCKED
+	`, r.keys.PrimaryID(), batchSize)
+	if err != nil {
+		return 0, fmt.Errorf("querying slack bot tokens to rotate: %w", err)
+	}
+	type rotation struct {
+		orgID      uuid.UUID
+		ciphertext string
+	}
+	var rotations []rotation
+	for rows.Next() {
+		var (
+			orgID      uuid.UUID
+			ciphertext string
+		)
+		if err := rows.Scan(&orgID, &ciphertext); err != nil {
+			rows.Close()
+			return 0, fmt.Errorf("scanning slack bot token: %w", err)
+		}
+		token, err := r.keys.Decrypt(ciphertext, orgID[:])
+		if err != nil {
+			rows.Close()
+			return 0, fmt.Errorf("decrypting slack bot token: %w", err)
+		}
+		rotated, err := r.keys.Encrypt(token, orgID[:])
+		if err != nil {
+			rows.Close()
+			return 0, fmt.Errorf("encrypting slack bot token: %w", err)
+		}
+		rotations = append(rotations, rotation{orgID: orgID, ciphertext: rotated})
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, fmt.Errorf("iterating slack bot tokens: %w", err)
+	}
+
+	for _, rot := range rotations {
+		if _, err := tx.Exec(ctx, `
+			UPDATE slack_integrations
+			SET bot_token_ciphertext = $2
+			WHERE organization_id = $1
+		`, rot.orgID, rot.ciphertext); err != nil {
+			return 0, fmt.Errorf("updating slack bot token: %w", err)
+		}
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return 0, fmt.Errorf("committing slack bot tokens: %w", err)
+	}
+	return len(rotations), nil
+}
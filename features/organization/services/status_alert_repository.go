@@ -0,0 +1,166 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgxpool"
+
+	"github.com/cavenine/queryops/internal/notify"
+)
+
+// Status log severities, as osquery reports them.
+const (
+	SeverityInfo    = 0
+	SeverityWarning = 1
+	SeverityError   = 2
+	SeverityFatal   = 3
+)
+
+var severityNames = []string{"info", "warning", "error", "fatal"}
+
+// SeverityName names an osquery status log severity, e.g. "error".
+func SeverityName(severity int) string {
+	if severity < 0 || severity >= len(severityNames) {
+		return fmt.Sprintf("severity %d", severity)
+	}
+	return severityNames[severity]
+}
+
+var (
+	ErrInvalidAlertSeverity     = errors.New("severity must be info, warning, error, or fatal")
+	ErrInvalidAlertPattern      = errors.New("invalid pattern; use a regular expression such as worker respawn|denylisted")
+	ErrDuplicateStatusAlertRule = errors.New("status alert rule already exists")
+	ErrStatusAlertRuleNotFound  = errors.New("status alert rule not found")
+)
+
+// StatusAlertRule notifies an organization when one of its hosts logs a
+// status message at or above MinSeverity that matches Pattern.
+type StatusAlertRule struct {
+	ID             int64     `json:"id"`
+	OrganizationID uuid.UUID `json:"organization_id"`
+	MinSeverity    int       `json:"min_severity"`
+	// Pattern is a case-insensitive regular expression; empty matches every
+	// message.
+	Pattern string `json:"pattern"`
+	// WebhookURL, if set, is posted each alert as well.
+	WebhookURL  *string   `json:"webhook_url,omitempty"`
+	Description string    `json:"description"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+// StatusAlertMatcher tests status log lines against a compiled rule.
+type StatusAlertMatcher struct {
+	minSeverity int
+	pattern     *regexp.Regexp
+}
+
+// NewStatusAlertMatcher compiles rule.
+func NewStatusAlertMatcher(rule StatusAlertRule) (*StatusAlertMatcher, error) {
+	if rule.MinSeverity < SeverityInfo || rule.MinSeverity > SeverityFatal {
+		return nil, ErrInvalidAlertSeverity
+	}
+	m := &StatusAlertMatcher{minSeverity: rule.MinSeverity}
+	if rule.Pattern != "" {
+		re, err := regexp.Compile("(?i)" + rule.Pattern)
+		if err != nil {
+			return nil, ErrInvalidAlertPattern
+		}
+		m.pattern = re
+	}
+	return m, nil
+}
+
+// Match reports whether a status log line triggers the rule.
+func (m *StatusAlertMatcher) Match(severity int, message string) bool {
+	if severity < m.minSeverity {
+		return false
+	}
+	return m.pattern == nil || m.pattern.MatchString(message)
+}
+
+type StatusAlertRuleRepository struct {
+	pool *pgxpool.Pool
+}
+
+func NewStatusAlertRuleRepository(pool *pgxpool.Pool) *StatusAlertRuleRepository {
+	return &StatusAlertRuleRepository{pool: pool}
+}
+
+// List returns the organization's rules, most severe first.
+func (r *StatusAlertRuleRepository) List(ctx context.Context, organizationID uuid.UUID) ([]StatusAlertRule, error) {
+	rows, err := r.pool.Query(ctx, `
+		SELECT id, organization_id, min_severity, pattern, webhook_url, description, created_at
+		FROM status_alert_rules
+		WHERE organization_id = $1
+		ORDER BY min_severity DESC, pattern
+	`, organizationID)
+	if err != nil {
+		return nil, fmt.Errorf("querying status alert rules: %w", err)
+	}
+	defer rows.Close()
+
+	var rules []StatusAlertRule
+	for rows.Next() {
+		var rule StatusAlertRule
+		if err := rows.Scan(&rule.ID, &rule.OrganizationID, &rule.MinSeverity, &rule.Pattern, &rule.WebhookURL, &rule.Description, &rule.CreatedAt); err != nil {
+			return nil, fmt.Errorf("scanning status alert rule: %w", err)
+		}
+		rules = append(rules, rule)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("iterating status alert rules: %w", err)
+	}
+	return rules, nil
+}
+
+// Add validates and stores a rule for the organization. An empty webhookURL
+// alerts in the app only; others must pass notify.ValidateWebhookURL.
+func (r *StatusAlertRuleRepository) Add(ctx context.Context, organizationID uuid.UUID, minSeverity int, pattern, webhookURL, description string) (*StatusAlertRule, error) {
+	rule := StatusAlertRule{MinSeverity: minSeverity, Pattern: strings.TrimSpace(pattern)}
+	if _, err := NewStatusAlertMatcher(rule); err != nil {
+		return nil, err
+	}
+	if webhookURL = strings.TrimSpace(webhookURL); webhookURL != "" {
+		if err := notify.ValidateWebhookURL(webhookURL); err != nil {
+			return nil, err
+		}
+		rule.WebhookURL = &webhookURL
+	}
+
+	err := r.pool.QueryRow(ctx, `
+		INSERT INTO status_alert_rules (organization_id, min_severity, pattern, webhook_url, description)
+		VALUES ($1, $2, $3, $4, $5)
+		RETURNING id, organization_id, min_severity, pattern, webhook_url, description, created_at
+	`, organizationID, rule.MinSeverity, rule.Pattern, rule.WebhookURL, strings.TrimSpace(description)).
+		Scan(&rule.ID, &rule.OrganizationID, &rule.MinSeverity, &rule.Pattern, &rule.WebhookURL, &rule.Description, &rule.CreatedAt)
+	if err != nil {
+		var pgErr *pgconn.PgError
+		if errors.As(err, &pgErr) && pgErr.Code == "23505" {
+			return nil, ErrDuplicateStatusAlertRule
+		}
+		return nil, fmt.Errorf("inserting status alert rule: %w", err)
+	}
+	return &rule, nil
+}
+
+// Delete removes one of the organization's rules.
+func (r *StatusAlertRuleRepository) Delete(ctx context.Context, organizationID uuid.UUID, id int64) error {
+	tag, err := r.pool.Exec(ctx, `
+		DELETE FROM status_alert_rules
+		WHERE organization_id = $1 AND id = $2
+	`, organizationID, id)
+	if err != nil {
+		return fmt.Errorf("deleting status alert rule: %w", err)
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrStatusAlertRuleNotFound
+	}
+	return nil
+}
@@ -0,0 +1,101 @@
+package services_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	orgservices "github.com/cavenine/queryops/features/organization/services"
+	"github.com/cavenine/queryops/internal/notify"
+	"github.com/cavenine/queryops/internal/testdb"
+	"github.com/cavenine/queryops/internal/testdb/fixtures"
+)
+
+func TestStatusAlertMatcher_Match(t *testing.T) {
+	m, err := orgservices.NewStatusAlertMatcher(orgservices.StatusAlertRule{
+		MinSeverity: orgservices.SeverityWarning,
+		Pattern:     "worker respawn|denylisted",
+	})
+	if err != nil {
+		t.Fatalf("NewStatusAlertMatcher: %v", err)
+	}
+	tests := []struct {
+		severity int
+		message  string
+		want     bool
+	}{
+		{orgservices.SeverityWarning, "osqueryd worker (1234) stopping: Worker Respawned", true},
+		{orgservices.SeverityError, "Worker respawning too quickly", true},
+		{orgservices.SeverityFatal, "Query denylisted: processes", true},
+		{orgservices.SeverityInfo, "Query denylisted: processes", false},
+		{orgservices.SeverityError, "Could not load extension", false},
+	}
+	for _, tt := range tests {
+		if got := m.Match(tt.severity, tt.message); got != tt.want {
+			t.Errorf("Match(%d, %q) = %v, want %v", tt.severity, tt.message, got, tt.want)
+		}
+	}
+
+	anyMessage, err := orgservices.NewStatusAlertMatcher(orgservices.StatusAlertRule{MinSeverity: orgservices.SeverityError})
+	if err != nil {
+		t.Fatalf("NewStatusAlertMatcher(no pattern): %v", err)
+	}
+	if !anyMessage.Match(orgservices.SeverityError, "anything") || anyMessage.Match(orgservices.SeverityWarning, "anything") {
+		t.Fatalf("a rule without a pattern should match on severity alone")
+	}
+
+	if _, err := orgservices.NewStatusAlertMatcher(orgservices.StatusAlertRule{Pattern: "("}); !errors.Is(err, orgservices.ErrInvalidAlertPattern) {
+		t.Fatalf("invalid pattern err = %v", err)
+	}
+	if _, err := orgservices.NewStatusAlertMatcher(orgservices.StatusAlertRule{MinSeverity: 4}); !errors.Is(err, orgservices.ErrInvalidAlertSeverity) {
+		t.Fatalf("invalid severity err = %v", err)
+	}
+}
+
+func TestStatusAlertRuleRepository(t *testing.T) {
+	tdb := testdb.SetupTestDB(t)
+	ctx := context.Background()
+
+	orgID := fixtures.CreateOrg(t, tdb.Pool, "alert-org").ID
+	otherOrgID := fixtures.CreateOrg(t, tdb.Pool, "other-org").ID
+	repo := orgservices.NewStatusAlertRuleRepository(tdb.Pool)
+
+	rule, err := repo.Add(ctx, orgID, orgservices.SeverityError, " worker respawn ", " https://hooks.example.com/osquery ", " agents ")
+	if err != nil {
+		t.Fatalf("Add: %v", err)
+	}
+	if rule.Pattern != "worker respawn" || rule.Description != "agents" || rule.WebhookURL == nil || *rule.WebhookURL != "https://hooks.example.com/osquery" {
+		t.Fatalf("added = %+v", rule)
+	}
+	if _, err := repo.Add(ctx, orgID, orgservices.SeverityError, "worker respawn", "", ""); !errors.Is(err, orgservices.ErrDuplicateStatusAlertRule) {
+		t.Fatalf("duplicate Add err = %v", err)
+	}
+	if _, err := repo.Add(ctx, orgID, orgservices.SeverityError, "x", "ftp://example.com", ""); !errors.Is(err, notify.ErrInvalidWebhookURL) {
+		t.Fatalf("invalid webhook Add err = %v", err)
+	}
+	if _, err := repo.Add(ctx, orgID, orgservices.SeverityError, "x", "http://169.254.169.254/latest", ""); !errors.Is(err, notify.ErrDisallowedWebhookAddress) {
+		t.Fatalf("metadata webhook Add err = %v", err)
+	}
+	if _, err := repo.Add(ctx, orgID, -1, "x", "", ""); !errors.Is(err, orgservices.ErrInvalidAlertSeverity) {
+		t.Fatalf("invalid severity Add err = %v", err)
+	}
+	anyFatal, err := repo.Add(ctx, orgID, orgservices.SeverityFatal, "", "", "")
+	if err != nil || anyFatal.WebhookURL != nil {
+		t.Fatalf("Add(no pattern, no webhook) = %+v, %v", anyFatal, err)
+	}
+
+	rules, err := repo.List(ctx, orgID)
+	if err != nil || len(rules) != 2 || rules[0].ID != anyFatal.ID {
+		t.Fatalf("List = %+v, %v; want 2 rules, fatal first", rules, err)
+	}
+	if others, _ := repo.List(ctx, otherOrgID); len(others) != 0 {
+		t.Fatalf("other organization got rules")
+	}
+
+	if err := repo.Delete(ctx, otherOrgID, rule.ID); !errors.Is(err, orgservices.ErrStatusAlertRuleNotFound) {
+		t.Fatalf("Delete from other org err = %v", err)
+	}
+	if err := repo.Delete(ctx, orgID, rule.ID); err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+}
@@ -0,0 +1,228 @@
+package osquery
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+
+	"github.com/cavenine/queryops/features/auth"
+	org "github.com/cavenine/queryops/features/organization"
+	"github.com/cavenine/queryops/features/osquery/services"
+	"github.com/cavenine/queryops/internal/httpbody"
+	"github.com/cavenine/queryops/internal/validate"
+)
+
+const (
+	// agentUpgradeListLimit is how many upgrades ListAgentUpgrades returns.
+	agentUpgradeListLimit = 50
+	// defaultUpgradeWaveSize is the wave size of an upgrade created without
+	// one.
+	defaultUpgradeWaveSize = 50
+)
+
+// agentUpgradeTargets tells hosts which osquery version to upgrade to.
+type agentUpgradeTargets interface {
+	TargetForHost(ctx context.Context, hostID uuid.UUID) (*services.AgentUpgradeTarget, error)
+}
+
+// agentUpgradeRepository creates agent upgrades, releases their waves, and
+// reports on their progress.
+type agentUpgradeRepository interface {
+	Create(ctx context.Context, organizationID uuid.UUID, targetVersion string, waveSize int, groupID *uuid.UUID, createdBy *int) (*services.AgentUpgrade, error)
+	List(ctx context.Context, organizationID uuid.UUID, limit int) ([]services.AgentUpgrade, error)
+	GetForOrganization(ctx context.Context, organizationID, id uuid.UUID) (*services.AgentUpgrade, error)
+	Hosts(ctx context.Context, organizationID, id uuid.UUID) ([]services.AgentUpgradeHost, error)
+	Advance(ctx context.Context, organizationID, id uuid.UUID) (*services.AgentUpgrade, error)
+	Cancel(ctx context.Context, organizationID, id uuid.UUID) (*services.AgentUpgrade, error)
+}
+
+// agentUpgradeTarget returns the upgrade to serve in host's config, or nil.
+// Failing to look it up only delays the upgrade, so it isn't an error.
+func (h *Handlers) agentUpgradeTarget(ctx context.Context, host *services.Host) *services.AgentUpgradeTarget {
+	if h.upgradeTargets == nil {
+		return nil
+	}
+	target, err := h.upgradeTargets.TargetForHost(ctx, host.ID)
+	if err != nil {
+		slog.ErrorContext(ctx, "failed to get agent upgrade target", "host_id", host.ID, "error", err)
+		return nil
+	}
+	return target
+}
+
+type createAgentUpgradeRequest struct {
+	Version  string     `json:"version"`
+	WaveSize int        `json:"wave_size"`
+	GroupID  *uuid.UUID `json:"group_id"`
+}
+
+type listAgentUpgradesResponse struct {
+	Upgrades []services.AgentUpgrade `json:"upgrades"`
+}
+
+type agentUpgradeResponse struct {
+	*services.AgentUpgrade
+	HostProgress []services.AgentUpgradeHost `json:"host_progress"`
+}
+
+// CreateAgentUpgrade starts rolling the active organization's hosts that run
+// an osquery older than version to it, wave_size hosts at a time, optionally
+// only those in group_id. The first wave is released at once.
+func (h *Handlers) CreateAgentUpgrade(w http.ResponseWriter, r *http.Request) {
+	activeOrg := org.GetOrganizationFromContext(r.Context())
+	if activeOrg == nil {
+		slog.Error("missing active organization in context")
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+	if h.upgrades == nil {
+		http.Error(w, "agent upgrades are not available", http.StatusServiceUnavailable)
+		return
+	}
+
+	var req createAgentUpgradeRequest
+	if err := httpbody.DecodeStrictJSON(r, &req); err != nil {
+		httpbody.Error(w, err)
+		return
+	}
+	if req.WaveSize == 0 {
+		req.WaveSize = defaultUpgradeWaveSize
+	}
+
+	fields := validate.Errors{}
+	if req.Version == "" {
+		fields.Add("version", validate.MsgRequired)
+	}
+	if req.WaveSize < 0 {
+		fields.Add("wave_size", "Must be positive")
+	}
+	if len(fields) > 0 {
+		validate.WriteJSON(w, fields)
+		return
+	}
+
+	ctx := r.Context()
+	var createdBy *int
+	if user := auth.GetUserFromContext(ctx); user != nil {
+		createdBy = &user.ID
+	}
+
+	upgrade, err := h.upgrades.Create(ctx, activeOrg.ID, req.Version, req.WaveSize, req.GroupID, createdBy)
+	if err != nil {
+		switch {
+		case errors.Is(err, services.ErrInvalidUpgradeVersion):
+			validate.WriteJSON(w, validate.Errors{"version": "Must be an osquery version such as 5.12.1"})
+		case errors.Is(err, services.ErrNoHostsToUpgrade):
+			validate.WriteJSON(w, validate.Errors{"version": "No hosts run an older osquery"})
+		case errors.Is(err, services.ErrAgentUpgradeInProgress):
+			http.Error(w, err.Error(), http.StatusConflict)
+		default:
+			slog.ErrorContext(ctx, "failed to create agent upgrade", "error", err)
+			http.Error(w, "internal error", http.StatusInternalServerError)
+		}
+		return
+	}
+
+	w.WriteHeader(http.StatusCreated)
+	h.jsonResponse(w, upgrade)
+}
+
+// ListAgentUpgrades lists the active organization's recent agent upgrades,
+// newest first.
+func (h *Handlers) ListAgentUpgrades(w http.ResponseWriter, r *http.Request) {
+	activeOrg := org.GetOrganizationFromContext(r.Context())
+	if activeOrg == nil {
+		slog.Error("missing active organization in context")
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+	if h.upgrades == nil {
+		h.jsonResponse(w, listAgentUpgradesResponse{Upgrades: []services.AgentUpgrade{}})
+		return
+	}
+
+	upgrades, err := h.upgrades.List(r.Context(), activeOrg.ID, agentUpgradeListLimit)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "failed to list agent upgrades", "error", err)
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+	if upgrades == nil {
+		upgrades = []services.AgentUpgrade{}
+	}
+	h.jsonResponse(w, listAgentUpgradesResponse{Upgrades: upgrades})
+}
+
+// GetAgentUpgrade returns one of the active organization's agent upgrades
+// with the progress of each of its hosts.
+func (h *Handlers) GetAgentUpgrade(w http.ResponseWriter, r *http.Request) {
+	h.agentUpgradeAction(w, r, "get", func(ctx context.Context, organizationID, id uuid.UUID) (*services.AgentUpgrade, error) {
+		return h.upgrades.GetForOrganization(ctx, organizationID, id)
+	})
+}
+
+// AdvanceAgentUpgrade releases the next wave of one of the active
+// organization's agent upgrades.
+func (h *Handlers) AdvanceAgentUpgrade(w http.ResponseWriter, r *http.Request) {
+	h.agentUpgradeAction(w, r, "advance", func(ctx context.Context, organizationID, id uuid.UUID) (*services.AgentUpgrade, error) {
+		return h.upgrades.Advance(ctx, organizationID, id)
+	})
+}
+
+// CancelAgentUpgrade stops one of the active organization's agent upgrades.
+func (h *Handlers) CancelAgentUpgrade(w http.ResponseWriter, r *http.Request) {
+	h.agentUpgradeAction(w, r, "cancel", func(ctx context.Context, organizationID, id uuid.UUID) (*services.AgentUpgrade, error) {
+		return h.upgrades.Cancel(ctx, organizationID, id)
+	})
+}
+
+// agentUpgradeAction runs action on the agent upgrade named by the id URL
+// parameter and responds with it and its hosts' progress.
+func (h *Handlers) agentUpgradeAction(w http.ResponseWriter, r *http.Request, name string, action func(ctx context.Context, organizationID, id uuid.UUID) (*services.AgentUpgrade, error)) {
+	activeOrg := org.GetOrganizationFromContext(r.Context())
+	if activeOrg == nil {
+		slog.Error("missing active organization in context")
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+
+	id, err := uuid.Parse(chi.URLParam(r, "id"))
+	if err != nil {
+		http.Error(w, "invalid upgrade id", http.StatusBadRequest)
+		return
+	}
+	if h.upgrades == nil {
+		http.Error(w, "agent upgrade not found", http.StatusNotFound)
+		return
+	}
+
+	ctx := r.Context()
+	upgrade, err := action(ctx, activeOrg.ID, id)
+	if err != nil {
+		switch {
+		case errors.Is(err, services.ErrAgentUpgradeNotFound):
+			http.Error(w, "agent upgrade not found", http.StatusNotFound)
+		case errors.Is(err, services.ErrAgentUpgradeNotActive), errors.Is(err, services.ErrNoMoreUpgradeWaves):
+			http.Error(w, err.Error(), http.StatusConflict)
+		default:
+			slog.ErrorContext(ctx, "agent upgrade action failed", "action", name, "upgrade_id", id, "error", err)
+			http.Error(w, "internal error", http.StatusInternalServerError)
+		}
+		return
+	}
+
+	hosts, err := h.upgrades.Hosts(ctx, activeOrg.ID, id)
+	if err != nil {
+		slog.ErrorContext(ctx, "failed to list agent upgrade hosts", "upgrade_id", id, "error", err)
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+	if hosts == nil {
+		hosts = []services.AgentUpgradeHost{}
+	}
+	h.jsonResponse(w, agentUpgradeResponse{AgentUpgrade: upgrade, HostProgress: hosts})
+}
@@ -0,0 +1,214 @@
+package osquery
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+
+	org "github.com/cavenine/queryops/features/organization"
+	orgServices "github.com/cavenine/queryops/features/organization/services"
+	"github.com/cavenine/queryops/features/osquery/services"
+	"github.com/cavenine/queryops/internal/validate"
+)
+
+type stubUpgradeTargets struct {
+	target *services.AgentUpgradeTarget
+}
+
+func (s stubUpgradeTargets) TargetForHost(context.Context, uuid.UUID) (*services.AgentUpgradeTarget, error) {
+	return s.target, nil
+}
+
+func TestConfig_ServesAgentUpgradeTarget(t *testing.T) {
+	h := NewHandlers(&issuedAtHostRepo{issuedAt: time.Now()}, nil, nil, nil)
+	config := func() map[string]json.RawMessage {
+		req := httptest.NewRequest(http.MethodPost, "/osquery/config", strings.NewReader(`{"node_key":"k"}`))
+		rec := httptest.NewRecorder()
+		h.Config(rec, req)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("status = %d", rec.Code)
+		}
+		var resp map[string]json.RawMessage
+		if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+			t.Fatalf("unmarshal: %v", err)
+		}
+		return resp
+	}
+
+	h.upgradeTargets = stubUpgradeTargets{}
+	if raw, ok := config()["queryops_agent_upgrade"]; ok {
+		t.Fatalf("config without a target has queryops_agent_upgrade = %s", raw)
+	}
+
+	upgradeID := uuid.New()
+	h.upgradeTargets = stubUpgradeTargets{target: &services.AgentUpgradeTarget{UpgradeID: upgradeID, Version: "5.13.1", Wave: 2}}
+	var target struct {
+		UpgradeID uuid.UUID `json:"upgrade_id"`
+		Version   string    `json:"version"`
+		Wave      int       `json:"wave"`
+	}
+	if err := json.Unmarshal(config()["queryops_agent_upgrade"], &target); err != nil {
+		t.Fatalf("unmarshal queryops_agent_upgrade: %v", err)
+	}
+	if target.UpgradeID != upgradeID || target.Version != "5.13.1" || target.Wave != 2 {
+		t.Fatalf("queryops_agent_upgrade = %+v", target)
+	}
+}
+
+type stubAgentUpgrades struct {
+	upgrades []services.AgentUpgrade
+}
+
+func (s *stubAgentUpgrades) Create(_ context.Context, organizationID uuid.UUID, targetVersion string, waveSize int, _ *uuid.UUID, _ *int) (*services.AgentUpgrade, error) {
+	if _, ok := services.CompareOsqueryVersions(targetVersion, targetVersion); !ok {
+		return nil, services.ErrInvalidUpgradeVersion
+	}
+	for _, u := range s.upgrades {
+		if u.OrganizationID == organizationID && u.Status == services.UpgradeActive {
+			return nil, services.ErrAgentUpgradeInProgress
+		}
+	}
+	upgrade := services.AgentUpgrade{
+		ID: uuid.New(), OrganizationID: organizationID, TargetVersion: targetVersion,
+		WaveSize: waveSize, Waves: 2, ReleasedWaves: 1, Status: services.UpgradeActive,
+	}
+	s.upgrades = append(s.upgrades, upgrade)
+	return &upgrade, nil
+}
+
+func (s *stubAgentUpgrades) List(_ context.Context, organizationID uuid.UUID, _ int) ([]services.AgentUpgrade, error) {
+	var upgrades []services.AgentUpgrade
+	for _, u := range s.upgrades {
+		if u.OrganizationID == organizationID {
+			upgrades = append(upgrades, u)
+		}
+	}
+	return upgrades, nil
+}
+
+func (s *stubAgentUpgrades) GetForOrganization(_ context.Context, organizationID, id uuid.UUID) (*services.AgentUpgrade, error) {
+	for i, u := range s.upgrades {
+		if u.ID == id && u.OrganizationID == organizationID {
+			return &s.upgrades[i], nil
+		}
+	}
+	return nil, services.ErrAgentUpgradeNotFound
+}
+
+func (s *stubAgentUpgrades) Hosts(context.Context, uuid.UUID, uuid.UUID) ([]services.AgentUpgradeHost, error) {
+	return nil, nil
+}
+
+func (s *stubAgentUpgrades) Advance(ctx context.Context, organizationID, id uuid.UUID) (*services.AgentUpgrade, error) {
+	u, err := s.GetForOrganization(ctx, organizationID, id)
+	if err != nil {
+		return nil, err
+	}
+	if u.ReleasedWaves == u.Waves {
+		return nil, services.ErrNoMoreUpgradeWaves
+	}
+	u.ReleasedWaves++
+	return u, nil
+}
+
+func (s *stubAgentUpgrades) Cancel(ctx context.Context, organizationID, id uuid.UUID) (*services.AgentUpgrade, error) {
+	u, err := s.GetForOrganization(ctx, organizationID, id)
+	if err != nil {
+		return nil, err
+	}
+	u.Status = services.UpgradeCancelled
+	return u, nil
+}
+
+func TestAgentUpgrades(t *testing.T) {
+	activeOrg := &orgServices.Organization{ID: uuid.New()}
+	h := NewHandlers(nil, nil, nil, nil)
+
+	withOrg := func(req *http.Request, o *orgServices.Organization) *http.Request {
+		return req.WithContext(org.SetOrganizationInContext(req.Context(), o))
+	}
+	create := func(body string) *httptest.ResponseRecorder {
+		req := withOrg(httptest.NewRequest(http.MethodPost, "/api/v1/agent-upgrades", strings.NewReader(body)), activeOrg)
+		rec := httptest.NewRecorder()
+		h.CreateAgentUpgrade(rec, req)
+		return rec
+	}
+	act := func(handler http.HandlerFunc, id string, o *orgServices.Organization) *httptest.ResponseRecorder {
+		req := withOrg(httptest.NewRequest(http.MethodPost, "/api/v1/agent-upgrades/"+id, nil), o)
+		rctx := chi.NewRouteContext()
+		rctx.URLParams.Add("id", id)
+		req = req.WithContext(context.WithValue(req.Context(), chi.RouteCtxKey, rctx))
+		rec := httptest.NewRecorder()
+		handler(rec, req)
+		return rec
+	}
+
+	if rec := create(`{"version":"5.13.1"}`); rec.Code != http.StatusServiceUnavailable {
+		t.Fatalf("unavailable: status = %d, want 503", rec.Code)
+	}
+	h.upgrades = &stubAgentUpgrades{}
+
+	for body, field := range map[string]string{
+		`{}`:                                  "version",
+		`{"version":"latest"}`:                "version",
+		`{"version":"5.13.1","wave_size":-1}`: "wave_size",
+	} {
+		rec := create(body)
+		if rec.Code != http.StatusBadRequest {
+			t.Errorf("%s: status = %d, want 400", body, rec.Code)
+			continue
+		}
+		var resp validate.Response
+		if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil || !resp.Fields.Has(field) {
+			t.Errorf("%s: body = %q, want an error for %s", body, rec.Body.String(), field)
+		}
+	}
+
+	rec := create(`{"version":"5.13.1"}`)
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("create: status = %d, body=%q", rec.Code, rec.Body.String())
+	}
+	var created services.AgentUpgrade
+	if err := json.Unmarshal(rec.Body.Bytes(), &created); err != nil {
+		t.Fatalf("unmarshal: %v", err)
+	}
+	if created.WaveSize != defaultUpgradeWaveSize || created.ReleasedWaves != 1 {
+		t.Fatalf("created = %+v", created)
+	}
+	if rec := create(`{"version":"5.14.0"}`); rec.Code != http.StatusConflict {
+		t.Fatalf("second active upgrade: status = %d, want 409", rec.Code)
+	}
+
+	id := created.ID.String()
+	if rec := act(h.GetAgentUpgrade, id, &orgServices.Organization{ID: uuid.New()}); rec.Code != http.StatusNotFound {
+		t.Fatalf("get from another organization: status = %d, want 404", rec.Code)
+	}
+	if rec := act(h.GetAgentUpgrade, "nope", activeOrg); rec.Code != http.StatusBadRequest {
+		t.Fatalf("get with a bad id: status = %d, want 400", rec.Code)
+	}
+
+	rec = act(h.AdvanceAgentUpgrade, id, activeOrg)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("advance: status = %d", rec.Code)
+	}
+	var advanced agentUpgradeResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &advanced); err != nil {
+		t.Fatalf("unmarshal: %v", err)
+	}
+	if advanced.ReleasedWaves != 2 || advanced.HostProgress == nil {
+		t.Fatalf("advanced = %+v", advanced)
+	}
+	if rec := act(h.AdvanceAgentUpgrade, id, activeOrg); rec.Code != http.StatusConflict {
+		t.Fatalf("advance past the last wave: status = %d, want 409", rec.Code)
+	}
+	if rec := act(h.CancelAgentUpgrade, id, activeOrg); rec.Code != http.StatusOK {
+		t.Fatalf("cancel: status = %d", rec.Code)
+	}
+}
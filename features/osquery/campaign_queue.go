@@ -0,0 +1,62 @@
+package osquery
+
+import (
+	"context"
+
+	"github.com/google/uuid"
+
+	"github.com/cavenine/queryops/features/osquery/services"
+)
+
+// campaignQuota is the quota check every new campaign passes.
+type campaignQuota interface {
+	CheckCampaign(ctx context.Context, organizationID uuid.UUID) error
+}
+
+// queryQueuer creates campaigns for hosts, and groupQueryQueuer for the
+// members of host groups.
+type (
+	queryQueuer interface {
+		QueueQuery(ctx context.Context, organizationID uuid.UUID, createdBy *int, name *string, description *string, query string, hostIDs []uuid.UUID, opts services.CampaignOptions) (uuid.UUID, error)
+	}
+	groupQueryQueuer interface {
+		QueueGroupQuery(ctx context.Context, organizationID uuid.UUID, createdBy *int, name *string, description *string, query string, groupID uuid.UUID, opts services.CampaignOptions) (uuid.UUID, error)
+	}
+)
+
+// CampaignQueue creates campaigns after checking the organization's daily
+// campaign quota. The handlers and the gRPC API both queue through it, so
+// neither transport can skip the quota. Quota errors are
+// *orgServices.QuotaExceededError. Feature.Campaigns returns the web
+// server's.
+type CampaignQueue struct {
+	queries queryQueuer
+	groups  groupQueryQueuer
+	// quotas may be nil, for no quota.
+	quotas campaignQuota
+}
+
+// QueueQuery creates a campaign sending query to hostIDs.
+func (q *CampaignQueue) QueueQuery(ctx context.Context, organizationID uuid.UUID, createdBy *int, name, description *string, query string, hostIDs []uuid.UUID, opts services.CampaignOptions) (uuid.UUID, error) {
+	if err := q.checkQuota(ctx, organizationID); err != nil {
+		return uuid.Nil, err
+	}
+	return q.queries.QueueQuery(ctx, organizationID, createdBy, name, description, query, hostIDs, opts)
+}
+
+// QueueGroupQuery creates a campaign sending query to the members of a host
+// group. It returns uuid.Nil if the organization has no such group, and
+// services.ErrEmptyHostGroup if the group has no members.
+func (q *CampaignQueue) QueueGroupQuery(ctx context.Context, organizationID uuid.UUID, createdBy *int, name, description *string, query string, groupID uuid.UUID, opts services.CampaignOptions) (uuid.UUID, error) {
+	if err := q.checkQuota(ctx, organizationID); err != nil {
+		return uuid.Nil, err
+	}
+	return q.groups.QueueGroupQuery(ctx, organizationID, createdBy, name, description, query, groupID, opts)
+}
+
+func (q *CampaignQueue) checkQuota(ctx context.Context, organizationID uuid.UUID) error {
+	if q.quotas == nil {
+		return nil
+	}
+	return q.quotas.CheckCampaign(ctx, organizationID)
+}
@@ -17,6 +17,7 @@ import (
 	osqueryServices "github.com/cavenine/queryops/features/osquery/services"
 	"github.com/cavenine/queryops/internal/pubsub"
 	"github.com/cavenine/queryops/internal/testdb"
+	"github.com/cavenine/queryops/internal/testdb/fixtures"
 	"github.com/go-chi/chi/v5"
 	"github.com/google/uuid"
 )
@@ -32,27 +33,12 @@ func TestCampaignResultsSSE_EmitsUpdatesOnPublish(t *testing.T) {
 	ctx := context.Background()
 
 	// Minimal user+org+host setup.
-	var userID int
-	if err := tdb.Pool.QueryRow(ctx, `INSERT INTO users (email, password_hash) VALUES ($1, $2) RETURNING id`, "sse@example.com", "x").Scan(&userID); err != nil {
-		t.Fatalf("creating user: %v", err)
-	}
-
-	var orgID uuid.UUID
-	if err := tdb.Pool.QueryRow(ctx, `INSERT INTO organizations (name) VALUES ($1) RETURNING id`, "sse-org").Scan(&orgID); err != nil {
-		t.Fatalf("creating org: %v", err)
-	}
-
-	var hostID uuid.UUID
-	if err := tdb.Pool.QueryRow(ctx, `
-		INSERT INTO hosts (organization_id, host_identifier, node_key)
-		VALUES ($1, $2, $3)
-		RETURNING id
-	`, orgID, "host-1", uuid.NewString()).Scan(&hostID); err != nil {
-		t.Fatalf("creating host: %v", err)
-	}
+	userID := fixtures.CreateUser(t, tdb.Pool, "sse@example.com").ID
+	orgID := fixtures.CreateOrg(t, tdb.Pool, "sse-org").ID
+	hostID := fixtures.CreateHost(t, tdb.Pool, orgID, "host-1").ID
 
 	repo := osqueryServices.NewHostRepository(tdb.Pool)
-	campaignID, err := repo.QueueQuery(ctx, orgID, &userID, nil, nil, "select 1", []uuid.UUID{hostID})
+	campaignID, err := repo.QueueQuery(ctx, orgID, &userID, nil, nil, "select 1", []uuid.UUID{hostID}, osqueryServices.CampaignOptions{})
 	if err != nil {
 		t.Fatalf("QueueQuery: %v", err)
 	}
@@ -0,0 +1,213 @@
+package osquery
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/starfederation/datastar-go/datastar"
+
+	org "github.com/cavenine/queryops/features/organization"
+	"github.com/cavenine/queryops/features/osquery/pages"
+	"github.com/cavenine/queryops/features/osquery/services"
+)
+
+// errUnknownCampaignTarget is returned for a live query target that's
+// neither all hosts, the selected hosts, nor a host group's ID.
+var errUnknownCampaignTarget = errors.New("unknown live query target")
+
+// campaignTargetSignals are the New Live Query page's targeting signals.
+type campaignTargetSignals struct {
+	// Target is pages.CampaignTargetAll, pages.CampaignTargetHosts for
+	// Hosts, or a host group's ID.
+	Target string   `json:"target"`
+	Hosts  []string `json:"hosts"`
+	// FanoutLimit is a string because an emptied number input sends "".
+	FanoutLimit string `json:"fanoutLimit"`
+}
+
+// campaignTarget is what a live query target resolved to: the hosts it
+// targets now, and the group if it targets one.
+type campaignTarget struct {
+	hosts   []*services.Host
+	groupID *uuid.UUID
+}
+
+// resolveCampaignTarget resolves a New Live Query target to the
+// organization's hosts; see campaignTargetSignals. An empty target is all
+// hosts. It returns services.ErrHostNotFound if a selected host isn't the
+// organization's. A group that isn't resolves to no hosts.
+func (h *Handlers) resolveCampaignTarget(ctx context.Context, organizationID uuid.UUID, target string, hostIDs []string) (campaignTarget, error) {
+	var (
+		want    map[uuid.UUID]bool
+		groupID *uuid.UUID
+	)
+	switch target {
+	case "", pages.CampaignTargetAll:
+	case pages.CampaignTargetHosts:
+		want = make(map[uuid.UUID]bool, len(hostIDs))
+		for _, s := range hostIDs {
+			id, err := uuid.Parse(s)
+			if err != nil {
+				return campaignTarget{}, services.ErrHostNotFound
+			}
+			want[id] = true
+		}
+	default:
+		id, err := uuid.Parse(target)
+		if err != nil || h.groups == nil {
+			return campaignTarget{}, errUnknownCampaignTarget
+		}
+		members, err := h.groups.ListHostGroupMembers(ctx, id, organizationID)
+		if err != nil {
+			return campaignTarget{}, err
+		}
+		want = make(map[uuid.UUID]bool, len(members))
+		for _, member := range members {
+			want[member] = true
+		}
+		groupID = &id
+	}
+
+	hosts, err := h.repo.ListByOrganization(ctx, organizationID)
+	if err != nil {
+		return campaignTarget{}, err
+	}
+	if want == nil {
+		return campaignTarget{hosts: hosts}, nil
+	}
+	targeted := make([]*services.Host, 0, len(want))
+	for _, host := range hosts {
+		if want[host.ID] {
+			targeted = append(targeted, host)
+		}
+	}
+	if groupID == nil && len(targeted) != len(want) {
+		return campaignTarget{}, services.ErrHostNotFound
+	}
+	return campaignTarget{hosts: targeted, groupID: groupID}, nil
+}
+
+// estimateCampaignTarget estimates how a live query on hosts fans out when
+// it's released to at most fanoutLimit hosts per fan-out interval; zero is
+// unthrottled.
+func estimateCampaignTarget(hosts []*services.Host, fanoutLimit int, now time.Time) pages.CampaignTargetEstimate {
+	e := pages.CampaignTargetEstimate{Hosts: len(hosts)}
+	for _, host := range hosts {
+		if last := host.LastCheckIn(); last != nil && now.Sub(*last) < pages.HostOnlineWindow {
+			e.Online++
+		}
+	}
+	if fanoutLimit > 0 && len(hosts) > fanoutLimit {
+		e.FanoutLimit = fanoutLimit
+		e.Waves = (len(hosts) + fanoutLimit - 1) / fanoutLimit
+		// The first wave goes out at once; each other waits an interval.
+		e.Release = time.Duration(e.Waves-1) * services.DefaultFanoutInterval
+	} else if len(hosts) > 0 {
+		e.Waves = 1
+	}
+	return e
+}
+
+// campaignTargetFailed writes the error response for a failed
+// resolveCampaignTarget and reports whether it did.
+func campaignTargetFailed(w http.ResponseWriter, r *http.Request, err error) bool {
+	switch {
+	case err == nil:
+		return false
+	case errors.Is(err, errUnknownCampaignTarget):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	case errors.Is(err, services.ErrHostNotFound):
+		http.Error(w, "host not found", http.StatusNotFound)
+	default:
+		slog.ErrorContext(r.Context(), "failed to resolve live query target", "error", err)
+		http.Error(w, "internal error", http.StatusInternalServerError)
+	}
+	return true
+}
+
+// CampaignNewPage shows the New Live Query page. ?host=<id>, repeated for
+// more hosts, or ?group=<id> preselects its target, as the host details and
+// host groups pages link to it; it targets all hosts otherwise.
+func (h *Handlers) CampaignNewPage(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	activeOrg := org.GetOrganizationFromContext(ctx)
+	if activeOrg == nil {
+		slog.Error("missing active organization in context")
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+
+	params := r.URL.Query()
+	targeting := pages.CampaignTargeting{Target: pages.CampaignTargetAll}
+	hostIDs := params["host"]
+	group := params.Get("group")
+	switch {
+	case group != "" && len(hostIDs) > 0:
+		http.Error(w, "target either hosts or a group", http.StatusBadRequest)
+		return
+	case group != "":
+		targeting.Target = group
+	case len(hostIDs) > 0:
+		targeting.Target = pages.CampaignTargetHosts
+	}
+
+	target, err := h.resolveCampaignTarget(ctx, activeOrg.ID, targeting.Target, hostIDs)
+	if campaignTargetFailed(w, r, err) {
+		return
+	}
+	if h.groups != nil {
+		if targeting.Groups, err = h.groups.ListHostGroups(ctx, activeOrg.ID); err != nil {
+			slog.ErrorContext(ctx, "failed to list host groups", "error", err)
+			http.Error(w, "internal error", http.StatusInternalServerError)
+			return
+		}
+	}
+	if target.groupID != nil && !slices.ContainsFunc(targeting.Groups, func(g *services.HostGroup) bool { return g.ID == *target.groupID }) {
+		http.Error(w, "host group not found", http.StatusNotFound)
+		return
+	}
+	if targeting.Target == pages.CampaignTargetHosts {
+		targeting.Hosts = target.hosts
+	}
+	targeting.Estimate = estimateCampaignTarget(target.hosts, 0, time.Now())
+
+	pages.CampaignNewPage("New Live Query", targeting).Render(ctx, w)
+}
+
+// CampaignTargetsSSE patches the New Live Query page's target summary when
+// its target or fan-out limit changes, so the hosts a query will reach are
+// shown before it's run.
+func (h *Handlers) CampaignTargetsSSE(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	activeOrg := org.GetOrganizationFromContext(ctx)
+	if activeOrg == nil {
+		slog.Error("missing active organization in context")
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+
+	var signals campaignTargetSignals
+	if err := datastar.ReadSignals(r, &signals); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	target, err := h.resolveCampaignTarget(ctx, activeOrg.ID, signals.Target, signals.Hosts)
+	if campaignTargetFailed(w, r, err) {
+		return
+	}
+	// An invalid limit is reported when the query is run; until then the
+	// estimate is unthrottled.
+	fanoutLimit, _ := strconv.Atoi(strings.TrimSpace(signals.FanoutLimit))
+
+	sse := datastar.NewSSE(w, r)
+	if err := sse.PatchElementTempl(pages.CampaignTargetSummary(estimateCampaignTarget(target.hosts, max(fanoutLimit, 0), time.Now()))); err != nil {
+		slog.ErrorContext(ctx, "failed to patch live query target summary", "error", err)
+	}
+}
@@ -0,0 +1,182 @@
+package osquery
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+
+	org "github.com/cavenine/queryops/features/organization"
+	orgServices "github.com/cavenine/queryops/features/organization/services"
+	"github.com/cavenine/queryops/features/osquery/pages"
+	"github.com/cavenine/queryops/features/osquery/services"
+)
+
+type targetTestHostRepo struct {
+	hostRepository
+
+	hosts []*services.Host
+}
+
+func (r *targetTestHostRepo) ListByOrganization(context.Context, uuid.UUID) ([]*services.Host, error) {
+	return r.hosts, nil
+}
+
+type targetTestGroupRepo struct {
+	hostGroupRepository
+
+	groups  []*services.HostGroup
+	members map[uuid.UUID][]uuid.UUID
+}
+
+func (r *targetTestGroupRepo) ListHostGroups(context.Context, uuid.UUID) ([]*services.HostGroup, error) {
+	return r.groups, nil
+}
+
+func (r *targetTestGroupRepo) ListHostGroupMembers(_ context.Context, groupID, _ uuid.UUID) ([]uuid.UUID, error) {
+	return r.members[groupID], nil
+}
+
+func TestResolveCampaignTarget(t *testing.T) {
+	a, b, c := &services.Host{ID: uuid.New()}, &services.Host{ID: uuid.New()}, &services.Host{ID: uuid.New()}
+	groupID := uuid.New()
+	h := NewHandlers(&targetTestHostRepo{hosts: []*services.Host{a, b, c}}, nil, nil, nil)
+	h.groups = &targetTestGroupRepo{members: map[uuid.UUID][]uuid.UUID{groupID: {b.ID, c.ID}}}
+
+	tests := []struct {
+		name      string
+		target    string
+		hosts     []string
+		wantHosts int
+		wantGroup bool
+		wantErr   error
+	}{
+		{"empty is all hosts", "", nil, 3, false, nil},
+		{"all hosts", pages.CampaignTargetAll, nil, 3, false, nil},
+		{"selected hosts", pages.CampaignTargetHosts, []string{a.ID.String(), c.ID.String()}, 2, false, nil},
+		{"another organization's host", pages.CampaignTargetHosts, []string{a.ID.String(), uuid.NewString()}, 0, false, services.ErrHostNotFound},
+		{"group", groupID.String(), nil, 2, true, nil},
+		{"unknown group", uuid.NewString(), nil, 0, true, nil},
+		{"unknown target", "label:linux", nil, 0, false, errUnknownCampaignTarget},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			target, err := h.resolveCampaignTarget(context.Background(), uuid.New(), tt.target, tt.hosts)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("err = %v, want %v", err, tt.wantErr)
+			}
+			if len(target.hosts) != tt.wantHosts || (target.groupID != nil) != tt.wantGroup {
+				t.Fatalf("hosts = %d, group = %v; want %d, %v", len(target.hosts), target.groupID, tt.wantHosts, tt.wantGroup)
+			}
+		})
+	}
+}
+
+func TestEstimateCampaignTarget(t *testing.T) {
+	now := time.Now()
+	recent, stale := now.Add(-time.Minute), now.Add(-time.Hour)
+	hosts := make([]*services.Host, 0, 7)
+	for i := range 7 {
+		host := &services.Host{ID: uuid.New(), LastSeenAt: &stale}
+		if i < 3 {
+			host.LastSeenAt = &recent
+		}
+		hosts = append(hosts, host)
+	}
+
+	tests := []struct {
+		name        string
+		hosts       []*services.Host
+		fanoutLimit int
+		want        pages.CampaignTargetEstimate
+	}{
+		{"no hosts", nil, 2, pages.CampaignTargetEstimate{}},
+		{"unthrottled", hosts, 0, pages.CampaignTargetEstimate{Hosts: 7, Online: 3, Waves: 1}},
+		{"limit above the hosts", hosts, 10, pages.CampaignTargetEstimate{Hosts: 7, Online: 3, Waves: 1}},
+		{"throttled", hosts, 2, pages.CampaignTargetEstimate{Hosts: 7, Online: 3, FanoutLimit: 2, Waves: 4, Release: 3 * services.DefaultFanoutInterval}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := estimateCampaignTarget(tt.hosts, tt.fanoutLimit, now); got != tt.want {
+				t.Fatalf("estimate = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCampaignTargetsSSE(t *testing.T) {
+	hosts := []*services.Host{{ID: uuid.New()}, {ID: uuid.New()}, {ID: uuid.New()}}
+	h := NewHandlers(&targetTestHostRepo{hosts: hosts}, nil, nil, nil)
+
+	get := func(signals string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/campaigns/new/targets?datastar="+url.QueryEscape(signals), nil)
+		req.Header.Set("Datastar-Request", "true")
+		req = req.WithContext(org.SetOrganizationInContext(req.Context(), &orgServices.Organization{ID: uuid.New()}))
+		rec := httptest.NewRecorder()
+		h.CampaignTargetsSSE(rec, req)
+		return rec
+	}
+
+	rec := get(`{"target":"hosts","hosts":["` + hosts[0].ID.String() + `","` + hosts[1].ID.String() + `"],"fanoutLimit":"1"}`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, body = %s", rec.Code, rec.Body)
+	}
+	if body := rec.Body.String(); !strings.Contains(body, "campaign-target-summary") || !strings.Contains(body, "2 hosts, 0 online") || !strings.Contains(body, "in 2 waves") {
+		t.Fatalf("summary = %s", body)
+	}
+
+	if rec := get(`{"target":"hosts","hosts":["` + uuid.NewString() + `"]}`); rec.Code != http.StatusNotFound {
+		t.Fatalf("unknown host: status = %d, want 404", rec.Code)
+	}
+	if rec := get(`{"target":"` + uuid.NewString() + `"}`); rec.Code != http.StatusBadRequest {
+		t.Fatalf("group without groups: status = %d, want 400", rec.Code)
+	}
+}
+
+func TestCampaignNewPage_Prefill(t *testing.T) {
+	host := &services.Host{ID: uuid.New(), HostIdentifier: "web-1"}
+	group := &services.HostGroup{ID: uuid.New(), Name: "web"}
+	h := NewHandlers(&targetTestHostRepo{hosts: []*services.Host{host, {ID: uuid.New()}}}, nil, nil, nil)
+	h.groups = &targetTestGroupRepo{groups: []*services.HostGroup{group}, members: map[uuid.UUID][]uuid.UUID{group.ID: {host.ID}}}
+
+	get := func(query string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/campaigns/new?"+query, nil)
+		req = req.WithContext(org.SetOrganizationInContext(req.Context(), &orgServices.Organization{ID: uuid.New()}))
+		rec := httptest.NewRecorder()
+		h.CampaignNewPage(rec, req)
+		return rec
+	}
+
+	tests := []struct {
+		name       string
+		query      string
+		wantStatus int
+		wantBody   []string
+	}{
+		{"all hosts", "", http.StatusOK, []string{"2 hosts, 0 online", "&#34;target&#34;:&#34;all&#34;"}},
+		{"host", "host=" + host.ID.String(), http.StatusOK, []string{"1 host, 0 online", "web-1", host.ID.String()}},
+		{"group", "group=" + group.ID.String(), http.StatusOK, []string{"1 host, 0 online", "Group: web", "&#34;target&#34;:&#34;" + group.ID.String()}},
+		{"unknown host", "host=" + uuid.NewString(), http.StatusNotFound, nil},
+		{"unknown group", "group=" + uuid.NewString(), http.StatusNotFound, nil},
+		{"host and group", "host=" + host.ID.String() + "&group=" + group.ID.String(), http.StatusBadRequest, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := get(tt.query)
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d", rec.Code, tt.wantStatus)
+			}
+			for _, want := range tt.wantBody {
+				if !strings.Contains(rec.Body.String(), want) {
+					t.Errorf("page is missing %q", want)
+				}
+			}
+		})
+	}
+}
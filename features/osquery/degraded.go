@@ -0,0 +1,30 @@
+package osquery
+
+import (
+	"net/http"
+	"time"
+)
+
+// databaseRetryAfter is the Retry-After sent to agents while the database is
+// down.
+const databaseRetryAfter = 30 * time.Second
+
+// databaseHealth reports whether the database is down. *db.Breaker is one.
+type databaseHealth interface {
+	Open() bool
+}
+
+// databaseDown reports whether the database is known to be down. The
+// endpoints then answer without it, rather than each request failing on it
+// and logging the failure.
+func (h *Handlers) databaseDown() bool {
+	return h.database != nil && h.database.Open()
+}
+
+// databaseUnavailable answers with 503, a Retry-After, and resp in the shape
+// osquery expects from the endpoint. Like a rate-limited request, osquery
+// treats it as a failed attempt: it keeps its config and buffered logs and
+// tries again on its next interval.
+func (h *Handlers) databaseUnavailable(w http.ResponseWriter, resp any) {
+	retryLater(w, http.StatusServiceUnavailable, databaseRetryAfter, resp)
+}
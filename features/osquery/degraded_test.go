@@ -0,0 +1,59 @@
+package osquery
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+type databaseState bool
+
+func (d databaseState) Open() bool { return bool(d) }
+
+func TestEndpointsWhileDatabaseDown(t *testing.T) {
+	h := NewHandlers(&issuedAtHostRepo{issuedAt: time.Now()}, nil, nil, nil)
+	h.database = databaseState(true)
+	call := func(handler http.HandlerFunc, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/osquery/x", strings.NewReader(body))
+		req.Header.Set(PingNodeKeyHeader, "k")
+		rec := httptest.NewRecorder()
+		handler(rec, req)
+		return rec
+	}
+
+	for name, handler := range map[string]http.HandlerFunc{
+		"enroll":            h.Enroll,
+		"config":            h.Config,
+		"logger":            h.Logger,
+		"distributed_write": h.DistributedWrite,
+		"ping":              h.Ping,
+	} {
+		rec := call(handler, `{"node_key":"k"}`)
+		if rec.Code != http.StatusServiceUnavailable || rec.Header().Get("Retry-After") != "30" {
+			t.Errorf("%s: status = %d, Retry-After = %q", name, rec.Code, rec.Header().Get("Retry-After"))
+		}
+		// Hosts must not be told to enroll again because their node key
+		// couldn't be checked.
+		if name != "enroll" && strings.Contains(rec.Body.String(), "node_invalid") {
+			t.Errorf("%s: body = %q", name, rec.Body.String())
+		}
+	}
+
+	// Pending queries wait for a read once the database is back.
+	rec := call(h.DistributedRead, `{"node_key":"k"}`)
+	var read DistributedReadResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &read); err != nil {
+		t.Fatalf("unmarshal: %v", err)
+	}
+	if rec.Code != http.StatusOK || read.NodeInvalid || read.Queries == nil || len(read.Queries) != 0 {
+		t.Fatalf("distributed_read: status = %d, body = %q", rec.Code, rec.Body.String())
+	}
+
+	h.database = databaseState(false)
+	if rec := call(h.Config, `{"node_key":"k"}`); rec.Code != http.StatusOK {
+		t.Fatalf("config once the database is back: status = %d", rec.Code)
+	}
+}
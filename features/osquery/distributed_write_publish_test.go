@@ -24,7 +24,7 @@ func TestDistributedWrite_PublishesEventOnSuccess(t *testing.T) {
 	repo.GetByNodeKeyFunc = func(context.Context, string) (*osqueryServices.Host, error) {
 		return &osqueryServices.Host{ID: hostID}, nil
 	}
-	repo.SaveQueryResultsFunc = func(ctx context.Context, gotHostID uuid.UUID, gotQueryID uuid.UUID, status string, results json.RawMessage, errorText *string) error {
+	repo.SaveQueryResultsFunc = func(ctx context.Context, gotHostID uuid.UUID, gotQueryID uuid.UUID, status string, results json.RawMessage, errorText *string, _ bool) error {
 		if gotHostID != hostID {
 			t.Fatalf("hostID = %s, want %s", gotHostID, hostID)
 		}
@@ -136,7 +136,7 @@ func TestDistributedWrite_SkipsPublishOnSaveFailure(t *testing.T) {
 	repo.GetByNodeKeyFunc = func(context.Context, string) (*osqueryServices.Host, error) {
 		return &osqueryServices.Host{ID: hostID}, nil
 	}
-	repo.SaveQueryResultsFunc = func(context.Context, uuid.UUID, uuid.UUID, string, json.RawMessage, *string) error {
+	repo.SaveQueryResultsFunc = func(context.Context, uuid.UUID, uuid.UUID, string, json.RawMessage, *string, bool) error {
 		return errors.New("db")
 	}
 
@@ -173,3 +173,55 @@ func TestDistributedWrite_SkipsPublishOnSaveFailure(t *testing.T) {
 		t.Fatalf("publish calls = %d, want 0", calls)
 	}
 }
+
+func TestDistributedWrite_RetriedPostPublishesOnce(t *testing.T) {
+	hostID := uuid.New()
+	queryID := uuid.New()
+
+	saved := false
+	repo := &stubHostRepo{}
+	repo.GetByNodeKeyFunc = func(context.Context, string) (*osqueryServices.Host, error) {
+		return &osqueryServices.Host{ID: hostID}, nil
+	}
+	repo.SaveQueryResultsFunc = func(context.Context, uuid.UUID, uuid.UUID, string, json.RawMessage, *string, bool) error {
+		if saved {
+			return osqueryServices.ErrQueryResultsAlreadySaved
+		}
+		saved = true
+		return nil
+	}
+
+	publisher := &mockPublisher{}
+	h := osquery.NewHandlers(repo, &stubEnrollOrgLookup{}, publisher, nil)
+
+	body, err := json.Marshal(osquery.DistributedWriteRequest{
+		NodeKey:  "k1",
+		Statuses: map[string]int{queryID.String(): 0},
+		Queries:  map[string][]map[string]string{queryID.String(): {{"a": "1"}}},
+	})
+	if err != nil {
+		t.Fatalf("marshal body: %v", err)
+	}
+
+	// osquery retries when the first response is lost on the way back.
+	for attempt := 1; attempt <= 2; attempt++ {
+		rec := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodPost, "/osquery/distributed_write", strings.NewReader(string(body)))
+		h.DistributedWrite(rec, req)
+
+		if rec.Code != http.StatusOK {
+			t.Fatalf("attempt %d: status = %d, body=%q", attempt, rec.Code, rec.Body.String())
+		}
+		if strings.Contains(rec.Body.String(), "node_invalid\":true") {
+			t.Fatalf("attempt %d: body = %q", attempt, rec.Body.String())
+		}
+	}
+
+	publisher.mu.Lock()
+	calls := len(publisher.publishCalls)
+	publisher.mu.Unlock()
+
+	if calls != 2 {
+		t.Fatalf("publish calls = %d, want 2 (one host and one campaign event)", calls)
+	}
+}
@@ -0,0 +1,60 @@
+package osquery
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"strings"
+	"testing"
+
+	"github.com/google/uuid"
+
+	orgServices "github.com/cavenine/queryops/features/organization/services"
+)
+
+type fixedEnrollNetworks []orgServices.EnrollNetwork
+
+func (n fixedEnrollNetworks) CheckEnrollment(_ context.Context, _ uuid.UUID, addr netip.Addr) error {
+	return orgServices.CheckEnrollNetworks(n, addr)
+}
+
+func TestEnroll_EnrollNetworks(t *testing.T) {
+	networks := fixedEnrollNetworks{{
+		Network: netip.MustParsePrefix("10.0.0.0/8"),
+		Action:  orgServices.NetworkAllow,
+	}}
+
+	enroll := func(remoteAddr string) (*httptest.ResponseRecorder, *quotaHostRepo) {
+		repo := &quotaHostRepo{}
+		h := NewHandlers(repo, quotaOrgLookup{org: &orgServices.Organization{ID: uuid.New()}}, nil, nil)
+		h.enrollNetworks = networks
+
+		req := httptest.NewRequest(http.MethodPost, "/osquery/enroll", strings.NewReader(`{"enroll_secret":"s","host_identifier":"h"}`))
+		req.RemoteAddr = remoteAddr
+		rec := httptest.NewRecorder()
+		h.Enroll(rec, req)
+		return rec, repo
+	}
+
+	rec, repo := enroll("10.1.2.3:51234")
+	if rec.Code != http.StatusOK || !repo.enrolled {
+		t.Fatalf("allowed network: status = %d, enrolled = %v", rec.Code, repo.enrolled)
+	}
+
+	rec, repo = enroll("203.0.113.9:51234")
+	if rec.Code != http.StatusForbidden {
+		t.Fatalf("status = %d, want 403", rec.Code)
+	}
+	var resp EnrollmentResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("unmarshal response: %v", err)
+	}
+	if !resp.NodeInvalid || resp.Error == "" {
+		t.Fatalf("response = %+v", resp)
+	}
+	if repo.enrolled {
+		t.Fatalf("host enrolled from a network outside the allow list")
+	}
+}
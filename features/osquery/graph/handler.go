@@ -0,0 +1,49 @@
+package graph
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/99designs/gqlgen/graphql/handler"
+	"github.com/99designs/gqlgen/graphql/handler/extension"
+	"github.com/99designs/gqlgen/graphql/handler/transport"
+)
+
+// NewHandler serves the GraphQL API over repo. It must run behind
+// organization.RequireOrganization. Hosts count as online within
+// onlineWindow of checking in.
+//
+// Queries costing more than maxComplexity are rejected before they run; zero
+// disables the limit. Each field costs one, and a list field costs its
+// children once per item it may return, so hosts(first: 100) { results(first:
+// 10) { query } } costs 1 + 100 × (1 + 10).
+func NewHandler(repo Repository, onlineWindow time.Duration, maxComplexity int) http.Handler {
+	cfg := Config{Resolvers: &Resolver{repo: repo, onlineWindow: onlineWindow, now: time.Now}}
+	cfg.Complexity.Query.Hosts = func(childComplexity, first int) int {
+		return listComplexity(childComplexity, first)
+	}
+	cfg.Complexity.Query.Campaigns = func(childComplexity, first int, _ bool) int {
+		return listComplexity(childComplexity, first)
+	}
+	cfg.Complexity.Host.Results = func(childComplexity, first int, _ *string) int {
+		return listComplexity(childComplexity, first)
+	}
+	cfg.Complexity.Campaign.Targets = func(childComplexity, first int) int {
+		return listComplexity(childComplexity, first)
+	}
+
+	srv := handler.New(NewExecutableSchema(cfg))
+	srv.AddTransport(transport.POST{})
+	srv.Use(extension.Introspection{})
+	if maxComplexity > 0 {
+		srv.Use(extension.FixedComplexityLimit(maxComplexity))
+	}
+	return srv
+}
+
+// listComplexity is the cost of a list field returning up to first items.
+// first is clamped to what the resolvers accept so a huge value can't
+// overflow the total; they reject it anyway.
+func listComplexity(childComplexity, first int) int {
+	return 1 + min(max(first, 1), maxFirst)*childComplexity
+}
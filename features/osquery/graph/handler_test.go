@@ -0,0 +1,207 @@
+package graph_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+
+	org "github.com/cavenine/queryops/features/organization"
+	orgServices "github.com/cavenine/queryops/features/organization/services"
+	"github.com/cavenine/queryops/features/osquery/graph"
+	"github.com/cavenine/queryops/features/osquery/services"
+)
+
+// stubRepo holds one organization's records; lookups scoped to any other
+// organization find nothing.
+type stubRepo struct {
+	orgID     uuid.UUID
+	hosts     []*services.Host
+	results   map[uuid.UUID][]services.QueryResult
+	campaigns []*services.Campaign
+	targets   map[uuid.UUID][]*services.CampaignTarget
+
+	resultsBefore *services.ResultsCursor
+	calls         int
+}
+
+func (r *stubRepo) ListByOrganization(_ context.Context, organizationID uuid.UUID) ([]*services.Host, error) {
+	r.calls++
+	if organizationID != r.orgID {
+		return nil, nil
+	}
+	return r.hosts, nil
+}
+
+func (r *stubRepo) GetByIDAndOrganization(_ context.Context, id uuid.UUID, organizationID uuid.UUID) (*services.Host, error) {
+	r.calls++
+	for _, h := range r.hosts {
+		if h.ID == id && organizationID == r.orgID {
+			return h, nil
+		}
+	}
+	return nil, nil
+}
+
+func (r *stubRepo) ListResults(_ context.Context, hostID uuid.UUID, before *services.ResultsCursor, limit int) ([]services.QueryResult, error) {
+	r.calls++
+	r.resultsBefore = before
+	results := r.results[hostID]
+	return results[:min(limit, len(results))], nil
+}
+
+func (r *stubRepo) ListScheduledQueries(context.Context, uuid.UUID) ([]services.ScheduledQuery, error) {
+	r.calls++
+	return nil, nil
+}
+
+func (r *stubRepo) ListCampaignsByOrganization(_ context.Context, organizationID uuid.UUID, _ bool, limit int) ([]*services.Campaign, error) {
+	r.calls++
+	if organizationID != r.orgID {
+		return nil, nil
+	}
+	return r.campaigns[:min(limit, len(r.campaigns))], nil
+}
+
+func (r *stubRepo) GetCampaignByIDAndOrganization(_ context.Context, campaignID uuid.UUID, organizationID uuid.UUID) (*services.Campaign, error) {
+	r.calls++
+	for _, c := range r.campaigns {
+		if c.ID == campaignID && organizationID == r.orgID {
+			return c, nil
+		}
+	}
+	return nil, nil
+}
+
+func (r *stubRepo) GetCampaignTargets(_ context.Context, campaignID uuid.UUID) ([]*services.CampaignTarget, error) {
+	r.calls++
+	return r.targets[campaignID], nil
+}
+
+func (r *stubRepo) ListHostGroups(context.Context, uuid.UUID) ([]*services.HostGroup, error) {
+	r.calls++
+	return nil, nil
+}
+
+type response struct {
+	Data   json.RawMessage `json:"data"`
+	Errors []struct {
+		Message    string         `json:"message"`
+		Extensions map[string]any `json:"extensions"`
+	} `json:"errors"`
+}
+
+func query(t *testing.T, h http.Handler, orgID uuid.UUID, q string) response {
+	t.Helper()
+	body, err := json.Marshal(map[string]string{"query": q})
+	if err != nil {
+		t.Fatalf("encoding query: %v", err)
+	}
+	req := httptest.NewRequest(http.MethodPost, "/api/graphql", bytes.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	req = req.WithContext(org.SetOrganizationInContext(req.Context(), &orgServices.Organization{ID: orgID}))
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+
+	var resp response
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("decoding response %q: %v", rec.Body.String(), err)
+	}
+	return resp
+}
+
+func TestHostsWithResults(t *testing.T) {
+	orgID := uuid.New()
+	recent := time.Now().Add(-time.Minute)
+	online := &services.Host{ID: uuid.New(), HostIdentifier: "web-1", LastSeenAt: &recent, OsqueryVersion: "5.12.1"}
+	offline := &services.Host{ID: uuid.New(), HostIdentifier: "web-2"}
+	result := services.QueryResult{
+		QueryID:   uuid.New(),
+		Query:     "SELECT pid FROM processes",
+		Status:    "completed",
+		Results:   json.RawMessage(`[{"pid":"1"}]`),
+		UpdatedAt: time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC),
+	}
+	repo := &stubRepo{
+		orgID:   orgID,
+		hosts:   []*services.Host{online, offline},
+		results: map[uuid.UUID][]services.QueryResult{online.ID: {result}},
+	}
+	h := graph.NewHandler(repo, 10*time.Minute, 1000)
+
+	resp := query(t, h, orgID, `{ hosts { hostIdentifier osqueryVersion online results(first: 5) { campaignId rows cursor } } }`)
+	if len(resp.Errors) > 0 {
+		t.Fatalf("errors = %+v", resp.Errors)
+	}
+	want := `{"hosts":[` +
+		`{"hostIdentifier":"web-1","osqueryVersion":"5.12.1","online":true,"results":[{"campaignId":"` + result.QueryID.String() + `","rows":[{"pid":"1"}],"cursor":"` + result.Cursor().String() + `"}]},` +
+		`{"hostIdentifier":"web-2","osqueryVersion":"","online":false,"results":[]}]}`
+	if string(resp.Data) != want {
+		t.Fatalf("data = %s\nwant   %s", resp.Data, want)
+	}
+
+	resp = query(t, h, orgID, `{ host(id: "`+online.ID.String()+`") { results(before: "`+result.Cursor().String()+`") { query } } }`)
+	if len(resp.Errors) > 0 || repo.resultsBefore == nil || *repo.resultsBefore != result.Cursor() {
+		t.Fatalf("results before cursor: errors = %+v, cursor = %v", resp.Errors, repo.resultsBefore)
+	}
+	resp = query(t, h, orgID, `{ host(id: "`+online.ID.String()+`") { results(before: "nope") { query } } }`)
+	if len(resp.Errors) != 1 || resp.Errors[0].Message != "invalid cursor" {
+		t.Fatalf("malformed cursor errors = %+v", resp.Errors)
+	}
+}
+
+func TestOrganizationScoping(t *testing.T) {
+	orgID := uuid.New()
+	host := &services.Host{ID: uuid.New(), HostIdentifier: "web-1"}
+	campaign := &services.Campaign{ID: uuid.New(), Query: "SELECT 1", Status: "completed"}
+	repo := &stubRepo{
+		orgID:     orgID,
+		hosts:     []*services.Host{host},
+		campaigns: []*services.Campaign{campaign},
+		targets: map[uuid.UUID][]*services.CampaignTarget{campaign.ID: {
+			{HostID: host.ID, HostIdentifier: "web-1", Status: "completed", Results: json.RawMessage(`[]`)},
+		}},
+	}
+	h := graph.NewHandler(repo, 10*time.Minute, 1000)
+
+	q := `{ host(id: "` + host.ID.String() + `") { hostIdentifier } campaign(id: "` + campaign.ID.String() + `") { targets { hostIdentifier rows } } hosts { id } }`
+	resp := query(t, h, orgID, q)
+	want := `{"host":{"hostIdentifier":"web-1"},"campaign":{"targets":[{"hostIdentifier":"web-1","rows":[]}]},"hosts":[{"id":"` + host.ID.String() + `"}]}`
+	if len(resp.Errors) > 0 || string(resp.Data) != want {
+		t.Fatalf("own organization = %s, %+v", resp.Data, resp.Errors)
+	}
+
+	resp = query(t, h, uuid.New(), q)
+	if want := `{"host":null,"campaign":null,"hosts":[]}`; len(resp.Errors) > 0 || string(resp.Data) != want {
+		t.Fatalf("another organization = %s, %+v", resp.Data, resp.Errors)
+	}
+}
+
+func TestComplexityLimit(t *testing.T) {
+	repo := &stubRepo{orgID: uuid.New()}
+	h := graph.NewHandler(repo, 10*time.Minute, 1000)
+
+	resp := query(t, h, repo.orgID, `{ hosts(first: 500) { results(first: 50) { query rows } } }`)
+	if len(resp.Errors) != 1 || resp.Errors[0].Extensions["code"] != "COMPLEXITY_LIMIT_EXCEEDED" {
+		t.Fatalf("errors = %+v, want a complexity error", resp.Errors)
+	}
+	if repo.calls != 0 {
+		t.Fatalf("repository called %d times for a rejected query", repo.calls)
+	}
+
+	resp = query(t, h, repo.orgID, `{ hosts(first: 50) { results(first: 5) { query rows } } }`)
+	if len(resp.Errors) > 0 {
+		t.Fatalf("query within the limit: errors = %+v", resp.Errors)
+	}
+
+	resp = query(t, h, repo.orgID, `{ campaigns(first: 100000) { id } }`)
+	if len(resp.Errors) != 1 || !strings.Contains(resp.Errors[0].Message, "first must be between 1 and 500") {
+		t.Fatalf("oversized first: errors = %+v", resp.Errors)
+	}
+}
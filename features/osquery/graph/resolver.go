@@ -0,0 +1,73 @@
+package graph
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/google/uuid"
+
+	org "github.com/cavenine/queryops/features/organization"
+	orgServices "github.com/cavenine/queryops/features/organization/services"
+	"github.com/cavenine/queryops/features/osquery/services"
+)
+
+// maxFirst is the most items a list field returns.
+const maxFirst = 500
+
+// errInternal is what a client sees of a failure it can't fix; the cause is
+// logged.
+var errInternal = errors.New("internal error")
+
+// Repository is what the resolvers read. Every root field is scoped to the
+// active organization; nested fields only follow records already scoped.
+type Repository interface {
+	ListByOrganization(ctx context.Context, organizationID uuid.UUID) ([]*services.Host, error)
+	GetByIDAndOrganization(ctx context.Context, id uuid.UUID, organizationID uuid.UUID) (*services.Host, error)
+	ListResults(ctx context.Context, hostID uuid.UUID, before *services.ResultsCursor, limit int) ([]services.QueryResult, error)
+	ListScheduledQueries(ctx context.Context, hostID uuid.UUID) ([]services.ScheduledQuery, error)
+	ListCampaignsByOrganization(ctx context.Context, organizationID uuid.UUID, archived bool, limit int) ([]*services.Campaign, error)
+	GetCampaignByIDAndOrganization(ctx context.Context, campaignID uuid.UUID, organizationID uuid.UUID) (*services.Campaign, error)
+	GetCampaignTargets(ctx context.Context, campaignID uuid.UUID) ([]*services.CampaignTarget, error)
+	ListHostGroups(ctx context.Context, organizationID uuid.UUID) ([]*services.HostGroup, error)
+}
+
+// Resolver resolves the queries in schema.graphqls.
+type Resolver struct {
+	repo Repository
+	// onlineWindow is how recently a host must have checked in to be
+	// online.
+	onlineWindow time.Duration
+	now          func() time.Time
+}
+
+func activeOrganization(ctx context.Context) (*orgServices.Organization, error) {
+	activeOrg := org.GetOrganizationFromContext(ctx)
+	if activeOrg == nil {
+		slog.ErrorContext(ctx, "missing active organization in context")
+		return nil, errInternal
+	}
+	return activeOrg, nil
+}
+
+func internalError(ctx context.Context, msg string, err error) error {
+	slog.ErrorContext(ctx, msg, "error", err)
+	return errInternal
+}
+
+func checkFirst(first int) error {
+	if first < 1 || first > maxFirst {
+		return fmt.Errorf("first must be between 1 and %d", maxFirst)
+	}
+	return nil
+}
+
+func pointers[T any](items []T) []*T {
+	ptrs := make([]*T, len(items))
+	for i := range items {
+		ptrs[i] = &items[i]
+	}
+	return ptrs
+}
@@ -0,0 +1,28 @@
+package graph
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+
+	"github.com/99designs/gqlgen/graphql"
+)
+
+// MarshalJSON writes stored JSON, such as result rows, as it is.
+func MarshalJSON(v json.RawMessage) graphql.Marshaler {
+	if v == nil {
+		return graphql.Null
+	}
+	return graphql.WriterFunc(func(w io.Writer) {
+		_, _ = w.Write(v)
+	})
+}
+
+// UnmarshalJSON is required of a scalar, though no argument takes JSON.
+func UnmarshalJSON(v any) (json.RawMessage, error) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return nil, fmt.Errorf("encoding JSON scalar: %w", err)
+	}
+	return b, nil
+}
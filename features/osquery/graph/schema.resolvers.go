@@ -0,0 +1,163 @@
+package graph
+
+// This file will be automatically regenerated based on the schema, any resolver implementations
+// will be copied through when generating and any unknown code will be moved to the end.
+// Code generated by github.com/99designs/gqlgen version v0.17.81
+
+import (
+	"context"
+	"errors"
+
+	"github.com/cavenine/queryops/features/osquery/services"
+	"github.com/google/uuid"
+)
+
+// Archived is the resolver for the archived field.
+func (r *campaignResolver) Archived(ctx context.Context, obj *services.Campaign) (bool, error) {
+	return obj.ArchivedAt != nil, nil
+}
+
+// Targets is the resolver for the targets field.
+func (r *campaignResolver) Targets(ctx context.Context, obj *services.Campaign, first int) ([]*services.CampaignTarget, error) {
+	if err := checkFirst(first); err != nil {
+		return nil, err
+	}
+	targets, err := r.repo.GetCampaignTargets(ctx, obj.ID)
+	if err != nil {
+		return nil, internalError(ctx, "failed to get campaign targets", err)
+	}
+	return targets[:min(first, len(targets))], nil
+}
+
+// Online is the resolver for the online field.
+func (r *hostResolver) Online(ctx context.Context, obj *services.Host) (bool, error) {
+	last := obj.LastCheckIn()
+	return last != nil && r.now().Sub(*last) < r.onlineWindow, nil
+}
+
+// Quarantined is the resolver for the quarantined field.
+func (r *hostResolver) Quarantined(ctx context.Context, obj *services.Host) (bool, error) {
+	return obj.QuarantineID != nil, nil
+}
+
+// Results is the resolver for the results field.
+func (r *hostResolver) Results(ctx context.Context, obj *services.Host, first int, before *string) ([]*services.QueryResult, error) {
+	if err := checkFirst(first); err != nil {
+		return nil, err
+	}
+	var cursor *services.ResultsCursor
+	if before != nil {
+		c, err := services.ParseResultsCursor(*before)
+		if err != nil {
+			return nil, errors.New("invalid cursor")
+		}
+		cursor = &c
+	}
+	results, err := r.repo.ListResults(ctx, obj.ID, cursor, first)
+	if err != nil {
+		return nil, internalError(ctx, "failed to list host results", err)
+	}
+	return pointers(results), nil
+}
+
+// ScheduledQueries is the resolver for the scheduledQueries field.
+func (r *hostResolver) ScheduledQueries(ctx context.Context, obj *services.Host) ([]*services.ScheduledQuery, error) {
+	queries, err := r.repo.ListScheduledQueries(ctx, obj.ID)
+	if err != nil {
+		return nil, internalError(ctx, "failed to list scheduled queries", err)
+	}
+	return pointers(queries), nil
+}
+
+// Hosts is the resolver for the hosts field.
+func (r *queryResolver) Hosts(ctx context.Context, first int) ([]*services.Host, error) {
+	if err := checkFirst(first); err != nil {
+		return nil, err
+	}
+	activeOrg, err := activeOrganization(ctx)
+	if err != nil {
+		return nil, err
+	}
+	hosts, err := r.repo.ListByOrganization(ctx, activeOrg.ID)
+	if err != nil {
+		return nil, internalError(ctx, "failed to list hosts", err)
+	}
+	return hosts[:min(first, len(hosts))], nil
+}
+
+// Host is the resolver for the host field.
+func (r *queryResolver) Host(ctx context.Context, id uuid.UUID) (*services.Host, error) {
+	activeOrg, err := activeOrganization(ctx)
+	if err != nil {
+		return nil, err
+	}
+	host, err := r.repo.GetByIDAndOrganization(ctx, id, activeOrg.ID)
+	if err != nil {
+		return nil, internalError(ctx, "failed to get host", err)
+	}
+	return host, nil
+}
+
+// Campaigns is the resolver for the campaigns field.
+func (r *queryResolver) Campaigns(ctx context.Context, first int, archived bool) ([]*services.Campaign, error) {
+	if err := checkFirst(first); err != nil {
+		return nil, err
+	}
+	activeOrg, err := activeOrganization(ctx)
+	if err != nil {
+		return nil, err
+	}
+	campaigns, err := r.repo.ListCampaignsByOrganization(ctx, activeOrg.ID, archived, first)
+	if err != nil {
+		return nil, internalError(ctx, "failed to list campaigns", err)
+	}
+	return campaigns, nil
+}
+
+// Campaign is the resolver for the campaign field.
+func (r *queryResolver) Campaign(ctx context.Context, id uuid.UUID) (*services.Campaign, error) {
+	activeOrg, err := activeOrganization(ctx)
+	if err != nil {
+		return nil, err
+	}
+	campaign, err := r.repo.GetCampaignByIDAndOrganization(ctx, id, activeOrg.ID)
+	if err != nil {
+		return nil, internalError(ctx, "failed to get campaign", err)
+	}
+	return campaign, nil
+}
+
+// HostGroups is the resolver for the hostGroups field.
+func (r *queryResolver) HostGroups(ctx context.Context) ([]*services.HostGroup, error) {
+	activeOrg, err := activeOrganization(ctx)
+	if err != nil {
+		return nil, err
+	}
+	groups, err := r.repo.ListHostGroups(ctx, activeOrg.ID)
+	if err != nil {
+		return nil, internalError(ctx, "failed to list host groups", err)
+	}
+	return groups, nil
+}
+
+// Cursor is the resolver for the cursor field.
+func (r *queryResultResolver) Cursor(ctx context.Context, obj *services.QueryResult) (string, error) {
+	return obj.Cursor().String(), nil
+}
+
+// Campaign returns CampaignResolver implementation.
+func (r *Resolver) Campaign() CampaignResolver { return &campaignResolver{r} }
+
+// Host returns HostResolver implementation.
+func (r *Resolver) Host() HostResolver { return &hostResolver{r} }
+
+// Query returns QueryResolver implementation.
+func (r *Resolver) Query() QueryResolver { return &queryResolver{r} }
+
+// QueryResult returns QueryResultResolver implementation.
+func (r *Resolver) QueryResult() QueryResultResolver { return &queryResultResolver{r} }
+
+type campaignResolver struct{ *Resolver }
+type hostResolver struct{ *Resolver }
+type queryResolver struct{ *Resolver }
+type queryResultResolver struct{ *Resolver }
@@ -3,11 +3,14 @@ package osquery
 import (
 	"bytes"
 	"context"
+	"encoding/binary"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"log/slog"
 	"net/http"
+	"net/netip"
+	"strconv"
 	"strings"
 	"time"
 
@@ -21,7 +24,13 @@ import (
 	orgServices "github.com/cavenine/queryops/features/organization/services"
 	"github.com/cavenine/queryops/features/osquery/pages"
 	"github.com/cavenine/queryops/features/osquery/services"
+	"github.com/cavenine/queryops/internal/httpbody"
+	"github.com/cavenine/queryops/internal/logarchive"
+	"github.com/cavenine/queryops/internal/outbox"
 	"github.com/cavenine/queryops/internal/pubsub"
+	"github.com/cavenine/queryops/internal/realip"
+	"github.com/cavenine/queryops/internal/sharelink"
+	"github.com/cavenine/queryops/internal/validate"
 )
 
 type hostRepository interface {
@@ -30,31 +39,158 @@ type hostRepository interface {
 	UpdateLastConfig(ctx context.Context, nodeKey string) error
 	UpdateLastLogger(ctx context.Context, nodeKey string) error
 	UpdateLastDistributed(ctx context.Context, nodeKey string) error
+	Ping(ctx context.Context, nodeKey string) (*services.Host, error)
 	GetConfigForHost(ctx context.Context, nodeKey string) (json.RawMessage, error)
 	SaveResultLogs(ctx context.Context, hostID uuid.UUID, name, action string, columns json.RawMessage, timestamp time.Time) error
 	SaveStatusLogs(ctx context.Context, hostID uuid.UUID, line int, message string, severity int, filename string, createdAt time.Time) error
 	GetPendingQueries(ctx context.Context, hostID uuid.UUID) (map[string]string, error)
-	SaveQueryResults(ctx context.Context, hostID uuid.UUID, queryID uuid.UUID, status string, results json.RawMessage, errorText *string) error
+	SaveQueryResults(ctx context.Context, hostID uuid.UUID, queryID uuid.UUID, status string, results json.RawMessage, errorText *string, truncated bool, events ...outbox.Event) error
 
 	ListByOrganization(ctx context.Context, organizationID uuid.UUID) ([]*services.Host, error)
 	GetByIDAndOrganization(ctx context.Context, id uuid.UUID, organizationID uuid.UUID) (*services.Host, error)
-	GetRecentResults(ctx context.Context, hostID uuid.UUID) ([]services.QueryResult, error)
-	QueueQuery(ctx context.Context, organizationID uuid.UUID, createdBy *int, name *string, description *string, query string, hostIDs []uuid.UUID) (uuid.UUID, error)
+	ListResults(ctx context.Context, hostID uuid.UUID, before *services.ResultsCursor, limit int) ([]services.QueryResult, error)
+	ListResultsUpdatedSince(ctx context.Context, hostID uuid.UUID, since time.Time) ([]services.QueryResult, error)
+	SearchResults(ctx context.Context, organizationID uuid.UUID, s services.ResultSearch) ([]services.SearchHit, error)
+	ListScheduledQueries(ctx context.Context, hostID uuid.UUID) ([]services.ScheduledQuery, error)
+	ListScheduledResultEvents(ctx context.Context, hostID uuid.UUID, name string, since, until time.Time, limit int) ([]services.ScheduledResultEvent, error)
+	GetScheduledSnapshot(ctx context.Context, hostID uuid.UUID, name string, at time.Time) (*services.ScheduledSnapshot, error)
+	ListHostEvents(ctx context.Context, hostID uuid.UUID, limit int) ([]services.HostTimelineEvent, error)
+	QueueQuery(ctx context.Context, organizationID uuid.UUID, createdBy *int, name *string, description *string, query string, hostIDs []uuid.UUID, opts services.CampaignOptions) (uuid.UUID, error)
+	RerunCampaign(ctx context.Context, campaignID uuid.UUID, organizationID uuid.UUID, createdBy *int) (uuid.UUID, error)
 
 	GetCampaignByIDAndOrganization(ctx context.Context, campaignID uuid.UUID, organizationID uuid.UUID) (*services.Campaign, error)
-	ListCampaignsByOrganization(ctx context.Context, organizationID uuid.UUID, limit int) ([]*services.Campaign, error)
+	ListCampaignsByOrganization(ctx context.Context, organizationID uuid.UUID, archived bool, limit int) ([]*services.Campaign, error)
+	SetCampaignArchived(ctx context.Context, campaignID uuid.UUID, organizationID uuid.UUID, archived bool) (bool, error)
 	GetCampaignTargets(ctx context.Context, campaignID uuid.UUID) ([]*services.CampaignTarget, error)
+	QueryCampaignResults(ctx context.Context, campaignID uuid.UUID, view services.CampaignResultView) (*services.CampaignResults, error)
 }
 
 type enrollmentOrgLookup interface {
 	GetOrganizationByEnrollSecret(ctx context.Context, secret string) (*orgServices.Organization, error)
 }
 
+// quotaEnforcer checks per-organization limits. Errors that exceed a quota
+// are *orgServices.QuotaExceededError.
+type quotaEnforcer interface {
+	CheckHostEnrollment(ctx context.Context, organizationID uuid.UUID, hostIdentifier string) error
+	CheckCampaign(ctx context.Context, organizationID uuid.UUID) error
+	ReserveResultLogBytes(ctx context.Context, organizationID uuid.UUID, n int64) error
+}
+
+// enrollNetworkChecker applies per-organization network allow/deny lists to
+// enrollments. Rejections are *orgServices.EnrollNetworkDeniedError.
+type enrollNetworkChecker interface {
+	CheckEnrollment(ctx context.Context, organizationID uuid.UUID, addr netip.Addr) error
+}
+
+// resultRedactor supplies each organization's result redaction rules.
+type resultRedactor interface {
+	Redactor(ctx context.Context, organizationID uuid.UUID) (*orgServices.Redactor, error)
+}
+
+// resultLimitReader supplies campaigns' per-host result caps.
+type resultLimitReader interface {
+	GetResultLimits(ctx context.Context, campaignIDs []uuid.UUID) (map[uuid.UUID]services.ResultLimits, error)
+}
+
 type Handlers struct {
 	repo       hostRepository
 	orgService enrollmentOrgLookup
 	publisher  message.Publisher
 	pubsub     *pubsub.PubSub
+
+	// logs, when set, persists logger writes asynchronously.
+	logs *LogIngester
+
+	// archive, when set, archives raw logger writes to object storage.
+	archive *logarchive.Archiver
+
+	// outbox, when set, makes result events transactional with the results
+	// they describe; the relay publishes them after commit.
+	outbox *outbox.Relay
+
+	// quotas, when set, enforces per-organization limits on enrollment,
+	// campaigns, and result log volume.
+	quotas quotaEnforcer
+
+	// enrollNetworks, when set, restricts enrollment to the networks each
+	// organization allows.
+	enrollNetworks enrollNetworkChecker
+
+	// groups, when set, enables host groups and bulk host actions.
+	groups hostGroupRepository
+
+	// redaction, when set, scrubs sensitive data from query results and
+	// result logs before they are stored.
+	redaction resultRedactor
+
+	// resultLimits, when set, truncates distributed query results to their
+	// campaign's per-host caps.
+	resultLimits resultLimitReader
+
+	// scheduleHealth, when set, backs the scheduled query health page.
+	scheduleHealth scheduleHealthReader
+
+	// hostSearch, when set, backs the host search page and API.
+	hostSearch hostSearcher
+
+	// identities, when set, lets hosts flagged with an identity conflict be
+	// split or dismissed.
+	identities hostIdentityRepository
+
+	// quarantines, when set, lets suspicious hosts be quarantined.
+	quarantines hostQuarantineRepository
+
+	// resultViews, when set with shareLinks, lets views of campaign results
+	// be saved and shared as signed links.
+	resultViews resultViewRepository
+	shareLinks  *sharelink.Signer
+	// secureLinks makes share links https even when the request reached us
+	// over plain HTTP, as behind a TLS-terminating proxy.
+	secureLinks bool
+
+	// configs, when set, lets superusers export and import the schedule and
+	// packs of osquery configs.
+	configs configRepository
+
+	// packs, when set, lets organizations enable the query library's packs.
+	packs organizationPackRepository
+
+	// annotations, when set, lets campaign results be triaged.
+	annotations resultAnnotationRepository
+
+	// exports, when set, lets results be exported to Parquet files.
+	exports resultExportRepository
+
+	// upgradeTargets, when set, tells hosts in released waves of agent
+	// upgrades which osquery version to install.
+	upgradeTargets agentUpgradeTargets
+
+	// upgrades, when set, lets hosts be upgraded to a new osquery version in
+	// waves.
+	upgrades agentUpgradeRepository
+
+	// minOsqueryVersion, when set, flags hosts running an older osquery as
+	// outdated.
+	minOsqueryVersion string
+
+	// rateLimits, when set, limits how often each agent may call the osquery
+	// endpoints.
+	rateLimits *rateLimiter
+
+	// database, when set, tells the endpoints Postgres is down, so they ask
+	// agents to retry instead of failing on it.
+	database databaseHealth
+
+	// nodeKeyMaxAge, when positive, makes hosts re-enroll for a new node key
+	// once theirs is older than this plus the host's nodeKeyJitter.
+	nodeKeyMaxAge time.Duration
+
+	// signing, when set, issues hosts request signing keys at enrollment and
+	// checks their requests' signatures as their organization requires.
+	signing *requestSigning
+
+	checkIns *checkInThrottle
 }
 
 // NewHandlers creates a new Handlers instance.
@@ -70,13 +206,24 @@ func NewHandlers(
 		orgService: orgService,
 		publisher:  publisher,
 		pubsub:     ps,
+		checkIns:   newCheckInThrottle(hostCheckInInterval),
 	}
 }
 
 func (h *Handlers) Enroll(w http.ResponseWriter, r *http.Request) {
 	var req EnrollmentRequest
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, "invalid request", http.StatusBadRequest)
+	if err := httpbody.DecodeJSON(r, &req); err != nil {
+		httpbody.Error(w, err)
+		return
+	}
+	// Hosts behind one NAT enroll from the same address, so each host
+	// identifier gets its own allowance.
+	if wait, limited := h.overRateLimit(r, endpointEnroll, realip.FromRequest(r).String()+" "+req.HostIdentifier); limited {
+		h.rateLimited(w, wait, EnrollmentResponse{NodeInvalid: true, Error: "too many enrollment requests"})
+		return
+	}
+	if h.databaseDown() {
+		h.databaseUnavailable(w, EnrollmentResponse{NodeInvalid: true, Error: "database unavailable"})
 		return
 	}
 
@@ -97,6 +244,39 @@ func (h *Handlers) Enroll(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if h.enrollNetworks != nil {
+		if err := h.enrollNetworks.CheckEnrollment(r.Context(), org.ID, realip.FromRequest(r)); err != nil {
+			if orgServices.IsEnrollNetworkDenied(err) {
+				slog.Warn("host enrollment rejected by network rules", "organization_id", org.ID, "host_identifier", req.HostIdentifier, "error", err)
+				w.Header().Set("Content-Type", "application/json")
+				w.WriteHeader(http.StatusForbidden)
+				if err := json.NewEncoder(w).Encode(EnrollmentResponse{NodeInvalid: true, Error: "enrollment is not allowed from this network"}); err != nil {
+					slog.Error("failed to encode json response", "error", err)
+				}
+				return
+			}
+			slog.Error("failed to check enrollment networks", "error", err)
+			http.Error(w, "internal error", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	if h.quotas != nil {
+		if err := h.quotas.CheckHostEnrollment(r.Context(), org.ID, req.HostIdentifier); err != nil {
+			if h.quotaExceeded(w, err, EnrollmentResponse{NodeInvalid: true, Error: err.Error()}) {
+				slog.Warn("host enrollment rejected by quota", "organization_id", org.ID, "host_identifier", req.HostIdentifier, "error", err)
+				return
+			}
+			slog.Error("failed to check host quota", "error", err)
+			http.Error(w, "internal error", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	if !h.checkEnrollSignature(w, r, org.ID, req) {
+		return
+	}
+
 	nodeKey, err := h.repo.Enroll(r.Context(), req.HostIdentifier, req.HostDetails, org.ID)
 	if err != nil {
 		slog.Error("failed to enroll host", "error", err)
@@ -104,13 +284,27 @@ func (h *Handlers) Enroll(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	h.jsonResponse(w, EnrollmentResponse{NodeKey: nodeKey})
+	signingKey := h.issueSigningKey(r.Context(), nodeKey)
+
+	h.publishHostEnrolledEvent(r.Context(), org.ID, req.HostIdentifier)
+
+	h.jsonResponse(w, EnrollmentResponse{NodeKey: nodeKey, SigningKey: signingKey})
 }
 
 func (h *Handlers) Config(w http.ResponseWriter, r *http.Request) {
 	var req ConfigRequest
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, "invalid request", http.StatusBadRequest)
+	if err := httpbody.DecodeJSON(r, &req); err != nil {
+		httpbody.Error(w, err)
+		return
+	}
+	if wait, limited := h.overRateLimit(r, endpointConfig, req.NodeKey); limited {
+		h.rateLimited(w, wait, ConfigResponse{})
+		return
+	}
+	// Without the database the node key can't be checked, and answering
+	// node_invalid would make every host enroll again.
+	if h.databaseDown() {
+		h.databaseUnavailable(w, ConfigResponse{})
 		return
 	}
 
@@ -119,6 +313,18 @@ func (h *Handlers) Config(w http.ResponseWriter, r *http.Request) {
 		h.jsonResponse(w, ConfigResponse{NodeInvalid: true})
 		return
 	}
+	if !h.checkSignature(w, r, host) {
+		return
+	}
+
+	// osquery answers node_invalid by enrolling again, which issues a new
+	// key. Requests it already sent with the old key are covered by
+	// services.NodeKeyGracePeriod.
+	if h.nodeKeyMaxAge > 0 && time.Since(host.NodeKeyIssuedAt) > h.nodeKeyMaxAge+nodeKeyJitter(host.ID, h.nodeKeyMaxAge) {
+		slog.Info("rotating node key", "host_identifier", host.HostIdentifier, "issued_at", host.NodeKeyIssuedAt)
+		h.jsonResponse(w, ConfigResponse{NodeInvalid: true})
+		return
+	}
 
 	if err := h.repo.UpdateLastConfig(r.Context(), req.NodeKey); err != nil {
 		slog.Error("failed to update last config", "error", err)
@@ -137,14 +343,74 @@ func (h *Handlers) Config(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "internal error", http.StatusInternalServerError)
 		return
 	}
+	addIngestHookQueries(&resp)
+	filterForPlatform(&resp, host.Platform())
+	addScheduleVitals(&resp)
+	resp.AgentUpgrade = h.agentUpgradeTarget(r.Context(), host)
 
 	h.jsonResponse(w, resp)
 }
 
+// PingNodeKeyHeader carries the node key to Ping.
+const PingNodeKeyHeader = "X-Node-Key"
+
+// Ping records that a host is up. Agents can call it more often than they
+// poll for config, so hosts that stop are shown offline sooner, without the
+// cost of more config requests. It takes no body: the node key is sent in
+// PingNodeKeyHeader, and the response is 204, or 401 for an unknown key.
+func (h *Handlers) Ping(w http.ResponseWriter, r *http.Request) {
+	nodeKey := r.Header.Get(PingNodeKeyHeader)
+	if nodeKey == "" {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	// The host was seen moments ago; there's nothing new to record.
+	if _, limited := h.overRateLimit(r, endpointPing, nodeKey); limited {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	if h.databaseDown() {
+		h.databaseUnavailable(w, nil)
+		return
+	}
+	if h.signing != nil {
+		host, err := h.repo.GetByNodeKey(r.Context(), nodeKey)
+		if err != nil || host == nil {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		if !h.checkSignature(w, r, host) {
+			return
+		}
+	}
+
+	host, err := h.repo.Ping(r.Context(), nodeKey)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "failed to record ping", "error", err)
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+	if host == nil {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	h.publishHostCheckIn(r.Context(), host)
+	w.WriteHeader(http.StatusNoContent)
+}
+
 func (h *Handlers) Logger(w http.ResponseWriter, r *http.Request) {
 	var req LoggerRequest
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, "invalid request", http.StatusBadRequest)
+	if err := httpbody.DecodeJSON(r, &req); err != nil {
+		httpbody.Error(w, err)
+		return
+	}
+	if wait, limited := h.overRateLimit(r, endpointLogger, req.NodeKey); limited {
+		h.rateLimited(w, wait, LoggerResponse{Error: "too many logger requests"})
+		return
+	}
+	if h.databaseDown() {
+		h.databaseUnavailable(w, LoggerResponse{Error: "database unavailable"})
 		return
 	}
 
@@ -153,49 +419,131 @@ func (h *Handlers) Logger(w http.ResponseWriter, r *http.Request) {
 		h.jsonResponse(w, LoggerResponse{NodeInvalid: true})
 		return
 	}
-
-	if err := h.repo.UpdateLastLogger(r.Context(), req.NodeKey); err != nil {
-		slog.Error("failed to update last logger", "error", err)
+	if !h.checkSignature(w, r, host) {
+		return
 	}
 
 	slog.Info("received logs from host", "host_identifier", host.HostIdentifier, "log_type", req.LogType, "count", len(req.Data))
 
-	for _, raw := range req.Data {
-		if req.LogType == "result" {
-			var log ResultLog
-			if err := json.Unmarshal(raw, &log); err != nil {
-				slog.Error("failed to unmarshal result log", "error", err)
-				continue
-			}
-			ts := time.Unix(int64(log.UnixTime), 0)
-			cols, err := json.Marshal(log.Columns)
-			if err != nil {
-				slog.Error("failed to marshal result log columns", "error", err)
-				continue
-			}
-			if err := h.repo.SaveResultLogs(r.Context(), host.ID, log.Name, log.Action, json.RawMessage(cols), ts); err != nil {
-				slog.Error("failed to save result log", "error", err)
-			}
-		} else if req.LogType == "status" {
-			var log StatusLog
-			if err := json.Unmarshal(raw, &log); err != nil {
-				slog.Error("failed to unmarshal status log", "error", err)
-				continue
-			}
-			ts := time.Unix(int64(log.UnixTime), 0)
-			if err := h.repo.SaveStatusLogs(r.Context(), host.ID, log.Line, log.Message, log.Severity, log.Filename, ts); err != nil {
-				slog.Error("failed to save status log", "error", err)
+	redactor, ok := h.loadRedactor(w, r, host)
+	if !ok {
+		return
+	}
+	batch := parseLogBatch(host.ID, req.LogType, req.Data, redactor)
+	batch.tagQuarantine(host.QuarantineID)
+
+	if h.quotas != nil && len(batch.results) > 0 {
+		if err := h.quotas.ReserveResultLogBytes(r.Context(), host.OrganizationID, batch.resultBytes()); err != nil {
+			// osquery keeps the batch buffered and retries it, so nothing is
+			// lost until its own buffer limits kick in.
+			if h.quotaExceeded(w, err, LoggerResponse{Error: err.Error()}) {
+				slog.Warn("result logs rejected by quota", "host_identifier", host.HostIdentifier, "error", err)
+				return
 			}
+			slog.Error("failed to record result log usage", "error", err)
+			http.Error(w, "internal error", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	if h.logs != nil {
+		// last_logger_at is bumped by the ingester alongside the batch write.
+		if !h.logs.enqueue(batch) {
+			slog.Warn("log ingest queue full; asking host to retry", "host_identifier", host.HostIdentifier)
+			http.Error(w, "log queue full", http.StatusServiceUnavailable)
+			return
+		}
+		h.archiveLogs(host, req)
+		h.publishHostCheckIn(r.Context(), host)
+		h.jsonResponse(w, LoggerResponse{})
+		return
+	}
+
+	h.archiveLogs(host, req)
+	if err := h.repo.UpdateLastLogger(r.Context(), req.NodeKey); err != nil {
+		slog.Error("failed to update last logger", "error", err)
+	}
+	h.publishHostCheckIn(r.Context(), host)
+	for _, e := range batch.results {
+		if err := h.repo.SaveResultLogs(r.Context(), e.HostID, e.Name, e.Action, e.Columns, e.Timestamp); err != nil {
+			slog.Error("failed to save result log", "error", err)
+		}
+	}
+	for _, e := range batch.statuses {
+		if err := h.repo.SaveStatusLogs(r.Context(), e.HostID, e.Line, e.Message, e.Severity, e.Filename, e.CreatedAt); err != nil {
+			slog.Error("failed to save status log", "error", err)
 		}
 	}
 
 	h.jsonResponse(w, LoggerResponse{})
 }
 
+// archiveLogs queues an accepted logger write for the archive, if there is
+// one. Archiving is best effort; a full archive queue never fails the write.
+func (h *Handlers) archiveLogs(host *services.Host, req LoggerRequest) {
+	if h.archive == nil || len(req.Data) == 0 {
+		return
+	}
+	ok := h.archive.Enqueue(host.OrganizationID, logarchive.Record{
+		ReceivedAt:     time.Now(),
+		HostID:         host.ID,
+		HostIdentifier: host.HostIdentifier,
+		LogType:        req.LogType,
+		Data:           req.Data,
+	})
+	if !ok {
+		slog.Warn("log archive queue full; write not archived", "host_identifier", host.HostIdentifier)
+	}
+}
+
+// loadRedactor returns the host organization's redaction rules. If they
+// can't be loaded it fails the request so osquery retries the write later
+// rather than storing data that should have been redacted.
+func (h *Handlers) loadRedactor(w http.ResponseWriter, r *http.Request, host *services.Host) (*orgServices.Redactor, bool) {
+	if h.redaction == nil {
+		return nil, true
+	}
+	redactor, err := h.redaction.Redactor(r.Context(), host.OrganizationID)
+	if err != nil {
+		slog.Error("failed to load redaction rules", "error", err, "organization_id", host.OrganizationID)
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return nil, false
+	}
+	return redactor, true
+}
+
+// loadResultLimits returns the result caps of the campaigns in queries. It
+// writes a 500 and returns false if they can't be loaded, so osquery retries
+// the write rather than results being stored uncapped.
+func (h *Handlers) loadResultLimits(w http.ResponseWriter, r *http.Request, queries map[string][]map[string]string) (map[uuid.UUID]services.ResultLimits, bool) {
+	if h.resultLimits == nil || len(queries) == 0 {
+		return nil, true
+	}
+	ids := make([]uuid.UUID, 0, len(queries))
+	for idStr := range queries {
+		if id, err := uuid.Parse(idStr); err == nil {
+			ids = append(ids, id)
+		}
+	}
+	limits, err := h.resultLimits.GetResultLimits(r.Context(), ids)
+	if err != nil {
+		slog.Error("failed to load campaign result limits", "error", err)
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return nil, false
+	}
+	return limits, true
+}
+
 func (h *Handlers) DistributedRead(w http.ResponseWriter, r *http.Request) {
 	var req DistributedReadRequest
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, "invalid request", http.StatusBadRequest)
+	if err := httpbody.DecodeJSON(r, &req); err != nil {
+		httpbody.Error(w, err)
+		return
+	}
+	// Pending queries wait for the host's next read, so there's no need to
+	// fail this one.
+	if _, limited := h.overRateLimit(r, endpointDistributedRead, req.NodeKey); limited || h.databaseDown() {
+		h.jsonResponse(w, DistributedReadResponse{Queries: map[string]string{}})
 		return
 	}
 
@@ -204,6 +552,9 @@ func (h *Handlers) DistributedRead(w http.ResponseWriter, r *http.Request) {
 		h.jsonResponse(w, DistributedReadResponse{NodeInvalid: true, Queries: map[string]string{}})
 		return
 	}
+	if !h.checkSignature(w, r, host) {
+		return
+	}
 
 	if err := h.repo.UpdateLastDistributed(r.Context(), req.NodeKey); err != nil {
 		slog.Error("failed to update last distributed", "error", err)
@@ -215,6 +566,11 @@ func (h *Handlers) DistributedRead(w http.ResponseWriter, r *http.Request) {
 		h.jsonResponse(w, DistributedReadResponse{Queries: map[string]string{}})
 		return
 	}
+	// osquery expects queries to be an object; nothing pending is {}, never
+	// null.
+	if queries == nil {
+		queries = map[string]string{}
+	}
 
 	h.jsonResponse(w, DistributedReadResponse{
 		Queries: queries,
@@ -223,8 +579,12 @@ func (h *Handlers) DistributedRead(w http.ResponseWriter, r *http.Request) {
 
 func (h *Handlers) DistributedWrite(w http.ResponseWriter, r *http.Request) {
 	var req DistributedWriteRequest
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, "invalid request", http.StatusBadRequest)
+	if err := httpbody.DecodeJSON(r, &req); err != nil {
+		httpbody.Error(w, err)
+		return
+	}
+	if h.databaseDown() {
+		h.databaseUnavailable(w, DistributedWriteResponse{})
 		return
 	}
 
@@ -233,6 +593,23 @@ func (h *Handlers) DistributedWrite(w http.ResponseWriter, r *http.Request) {
 		h.jsonResponse(w, DistributedWriteResponse{NodeInvalid: true})
 		return
 	}
+	if !h.checkSignature(w, r, host) {
+		return
+	}
+
+	redactor, ok := h.loadRedactor(w, r, host)
+	if !ok {
+		return
+	}
+	for _, results := range req.Queries {
+		for _, row := range results {
+			redactor.RedactRow(row)
+		}
+	}
+	limits, ok := h.loadResultLimits(w, r, req.Queries)
+	if !ok {
+		return
+	}
 
 	// osquery reports completion via the `statuses` map. Results may be empty even on success.
 	if len(req.Statuses) == 0 {
@@ -243,18 +620,16 @@ func (h *Handlers) DistributedWrite(w http.ResponseWriter, r *http.Request) {
 				continue
 			}
 
+			results, truncated := limits[queryID].Truncate(results)
 			resJSON, err := json.Marshal(results)
 			if err != nil {
 				slog.Error("failed to marshal query results", "error", err)
 				continue
 			}
-			if err := h.repo.SaveQueryResults(r.Context(), host.ID, queryID, "completed", json.RawMessage(resJSON), nil); err != nil {
-				slog.Error("failed to save query results", "error", err)
+			if err := h.saveQueryResults(r.Context(), host, queryID, pubsub.QueryResultStatusCompleted, json.RawMessage(resJSON), len(results), nil, truncated); err != nil {
+				logSaveQueryResultsError(r.Context(), err, host, queryID)
 				continue
 			}
-
-			h.publishQueryResultEvent(r.Context(), host.ID, queryID, pubsub.QueryResultStatusCompleted, nil)
-			h.publishCampaignResultEvent(r.Context(), queryID, host, pubsub.QueryResultStatusCompleted, len(results), nil)
 		}
 
 		h.jsonResponse(w, DistributedWriteResponse{})
@@ -277,10 +652,12 @@ func (h *Handlers) DistributedWrite(w http.ResponseWriter, r *http.Request) {
 		}
 
 		var (
-			resJSON  json.RawMessage
-			rowCount int
+			resJSON   json.RawMessage
+			rowCount  int
+			truncated bool
 		)
 		if results, ok := req.Queries[queryIDStr]; ok {
+			results, truncated = limits[queryID].Truncate(results)
 			rowCount = len(results)
 			b, err := json.Marshal(results)
 			if err != nil {
@@ -294,13 +671,10 @@ func (h *Handlers) DistributedWrite(w http.ResponseWriter, r *http.Request) {
 			}
 		}
 
-		if err := h.repo.SaveQueryResults(r.Context(), host.ID, queryID, status, resJSON, errorText); err != nil {
-			slog.Error("failed to save query results", "error", err)
+		if err := h.saveQueryResults(r.Context(), host, queryID, status, resJSON, rowCount, errorText, truncated); err != nil {
+			logSaveQueryResultsError(r.Context(), err, host, queryID)
 			continue
 		}
-
-		h.publishQueryResultEvent(r.Context(), host.ID, queryID, status, errorText)
-		h.publishCampaignResultEvent(r.Context(), queryID, host, status, rowCount, errorText)
 	}
 
 	h.jsonResponse(w, DistributedWriteResponse{})
@@ -321,7 +695,24 @@ func (h *Handlers) HostsPage(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	pages.HostsPage("Hosts", hosts).Render(r.Context(), w)
+	var (
+		groups  []*services.HostGroup
+		configs []services.OsqueryConfig
+	)
+	if h.groups != nil {
+		if groups, err = h.groups.ListHostGroups(r.Context(), activeOrg.ID); err != nil {
+			slog.Error("failed to list host groups", "error", err)
+			http.Error(w, "internal error", http.StatusInternalServerError)
+			return
+		}
+		if configs, err = h.groups.ListConfigs(r.Context()); err != nil {
+			slog.Error("failed to list osquery configs", "error", err)
+			http.Error(w, "internal error", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	pages.HostsPage("Hosts", hosts, groups, configs, h.minOsqueryVersion).Render(r.Context(), w)
 }
 
 func (h *Handlers) CampaignsPage(w http.ResponseWriter, r *http.Request) {
@@ -332,18 +723,21 @@ func (h *Handlers) CampaignsPage(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	campaigns, err := h.repo.ListCampaignsByOrganization(r.Context(), activeOrg.ID, 50)
+	archived := archivedParam(r)
+	campaigns, err := h.repo.ListCampaignsByOrganization(r.Context(), activeOrg.ID, archived, 50)
 	if err != nil {
 		slog.Error("failed to list campaigns", "error", err)
 		http.Error(w, "internal error", http.StatusInternalServerError)
 		return
 	}
 
-	pages.CampaignsPage("Live Queries", campaigns).Render(r.Context(), w)
+	pages.CampaignsPage("Live Queries", campaigns, archived).Render(r.Context(), w)
 }
 
-func (h *Handlers) CampaignNewPage(w http.ResponseWriter, r *http.Request) {
-	pages.CampaignNewPage("New Live Query").Render(r.Context(), w)
+// archivedParam reports whether a campaigns list asks for archived campaigns
+// with ?archived=true.
+func archivedParam(r *http.Request) bool {
+	return r.URL.Query().Get("archived") == "true"
 }
 
 func (h *Handlers) RunCampaign(w http.ResponseWriter, r *http.Request) {
@@ -358,6 +752,11 @@ func (h *Handlers) RunCampaign(w http.ResponseWriter, r *http.Request) {
 		Name        string `json:"name"`
 		Description string `json:"description"`
 		Query       string `json:"query"`
+		campaignTargetSignals
+		// The options are strings because an emptied number input sends "".
+		MaxRows        string `json:"maxRows"`
+		MaxKilobytes   string `json:"maxKilobytes"`
+		TimeoutMinutes string `json:"timeoutMinutes"`
 	}
 	var store Store
 	if err := datastar.ReadSignals(r, &store); err != nil {
@@ -366,8 +765,21 @@ func (h *Handlers) RunCampaign(w http.ResponseWriter, r *http.Request) {
 	}
 
 	store.Query = strings.TrimSpace(store.Query)
-	if store.Query == "" {
-		http.Error(w, "query cannot be empty", http.StatusBadRequest)
+
+	fields := validate.Errors{}
+	fields.Field("query", store.Query, validate.Required())
+	opts := services.CampaignOptions{
+		FanoutLimit:     fields.NonNegativeInt("fanoutLimit", store.FanoutLimit),
+		MaxRowsPerHost:  fields.NonNegativeInt("maxRows", store.MaxRows),
+		MaxBytesPerHost: int64(fields.NonNegativeInt("maxKilobytes", store.MaxKilobytes)) << 10,
+		Timeout:         time.Duration(fields.NonNegativeInt("timeoutMinutes", store.TimeoutMinutes)) * time.Minute,
+	}
+	if len(fields) > 0 {
+		patchFormErrors(w, r, campaignFormFields, fields)
+		return
+	}
+	if err := opts.Validate(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
 
@@ -394,23 +806,37 @@ func (h *Handlers) RunCampaign(w http.ResponseWriter, r *http.Request) {
 		createdBy = &user.ID
 	}
 
-	hosts, err := h.repo.ListByOrganization(ctx, activeOrg.ID)
-	if err != nil {
-		slog.ErrorContext(ctx, "failed to list hosts", "error", err)
-		http.Error(w, "internal error", http.StatusInternalServerError)
+	target, err := h.resolveCampaignTarget(ctx, activeOrg.ID, store.Target, store.Hosts)
+	if campaignTargetFailed(w, r, err) {
 		return
 	}
-
-	hostIDs := make([]uuid.UUID, 0, len(hosts))
-	for _, host := range hosts {
-		hostIDs = append(hostIDs, host.ID)
+	if len(target.hosts) == 0 {
+		fields.Add("target", "There are no hosts to target")
+		patchFormErrors(w, r, campaignFormFields, fields)
+		return
 	}
 
-	campaignID, err := h.repo.QueueQuery(ctx, activeOrg.ID, createdBy, name, description, store.Query, hostIDs)
-	if err != nil {
-		slog.ErrorContext(ctx, "failed to create campaign", "error", err)
-		http.Error(w, "internal error", http.StatusInternalServerError)
-		return
+	var campaignID uuid.UUID
+	if target.groupID != nil {
+		campaignID, err = h.queueGroupQuery(ctx, activeOrg.ID, createdBy, name, description, store.Query, *target.groupID, opts)
+		if !h.groupQueryQueued(w, r, campaignID, err) {
+			return
+		}
+	} else {
+		hostIDs := make([]uuid.UUID, 0, len(target.hosts))
+		for _, host := range target.hosts {
+			hostIDs = append(hostIDs, host.ID)
+		}
+		campaignID, err = h.queueQuery(ctx, activeOrg.ID, createdBy, name, description, store.Query, hostIDs, opts)
+		if err != nil {
+			if orgServices.IsQuotaExceeded(err) {
+				http.Error(w, err.Error(), http.StatusTooManyRequests)
+				return
+			}
+			slog.ErrorContext(ctx, "failed to create campaign", "error", err)
+			http.Error(w, "internal error", http.StatusInternalServerError)
+			return
+		}
 	}
 
 	sse := datastar.NewSSE(w, r)
@@ -419,6 +845,22 @@ func (h *Handlers) RunCampaign(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// campaignFormFields are the signals CampaignNewPage shows errors for.
+var campaignFormFields = []string{"query", "target", "fanoutLimit", "maxRows", "maxKilobytes", "timeoutMinutes"}
+
+// patchFormErrors answers a datastar request whose signals failed validation
+// by patching the page's errors signal. Every one of the page's fields is
+// sent, so a fixed field's message is cleared.
+func patchFormErrors(w http.ResponseWriter, r *http.Request, pageFields []string, fields validate.Errors) {
+	errs := make(map[string]string, len(pageFields))
+	for _, field := range pageFields {
+		errs[field] = fields.Get(field)
+	}
+	if err := datastar.NewSSE(w, r).MarshalAndPatchSignals(map[string]any{"errors": errs}); err != nil {
+		slog.ErrorContext(r.Context(), "failed to patch form errors", "error", err)
+	}
+}
+
 func (h *Handlers) CampaignPage(w http.ResponseWriter, r *http.Request) {
 	activeOrg := org.GetOrganizationFromContext(r.Context())
 	if activeOrg == nil {
@@ -453,8 +895,181 @@ func (h *Handlers) CampaignPage(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	view := services.ParseCampaignResultView(r.URL.Query())
+	results, err := h.repo.QueryCampaignResults(ctx, campaignID, view)
+	if err != nil {
+		slog.ErrorContext(ctx, "failed to query campaign results", "error", err)
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+
 	title := "Live Query"
-	pages.CampaignDetailsPage(title, campaign, targets).Render(ctx, w)
+	pages.CampaignDetailsPage(title, campaign, targets, results, view, h.savedResultViews(ctx, campaign)).Render(ctx, w)
+}
+
+// RerunCampaignUI re-runs a campaign from its details page and navigates to
+// the new campaign, whose results are diffed against this one.
+func (h *Handlers) RerunCampaignUI(w http.ResponseWriter, r *http.Request) {
+	newID, ok := h.rerunCampaign(w, r)
+	if !ok {
+		return
+	}
+
+	sse := datastar.NewSSE(w, r)
+	if err := sse.ExecuteScript(fmt.Sprintf("window.location = '/campaigns/%s'", newID.String())); err != nil {
+		return
+	}
+}
+
+// RerunCampaign is the API form of RerunCampaignUI.
+func (h *Handlers) RerunCampaign(w http.ResponseWriter, r *http.Request) {
+	newID, ok := h.rerunCampaign(w, r)
+	if !ok {
+		return
+	}
+
+	campaign, err := h.repo.GetCampaignByIDAndOrganization(r.Context(), newID, org.GetOrganizationFromContext(r.Context()).ID)
+	if err != nil || campaign == nil {
+		slog.ErrorContext(r.Context(), "failed to load re-run campaign", "error", err, "campaign_id", newID)
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusCreated)
+	h.jsonResponse(w, createCampaignResponse{CampaignID: newID, TargetCount: campaign.TargetCount})
+}
+
+// rerunCampaign queues a re-run of the campaign named in the URL. It writes
+// an error response and returns false on failure.
+func (h *Handlers) rerunCampaign(w http.ResponseWriter, r *http.Request) (uuid.UUID, bool) {
+	ctx := r.Context()
+	activeOrg := org.GetOrganizationFromContext(ctx)
+	if activeOrg == nil {
+		slog.Error("missing active organization in context")
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return uuid.Nil, false
+	}
+
+	campaignID, err := uuid.Parse(chi.URLParam(r, "id"))
+	if err != nil {
+		http.Error(w, "invalid campaign id", http.StatusBadRequest)
+		return uuid.Nil, false
+	}
+
+	var createdBy *int
+	if user := auth.GetUserFromContext(ctx); user != nil {
+		createdBy = &user.ID
+	}
+
+	if h.quotas != nil {
+		if err := h.quotas.CheckCampaign(ctx, activeOrg.ID); err != nil {
+			if orgServices.IsQuotaExceeded(err) {
+				http.Error(w, err.Error(), http.StatusTooManyRequests)
+				return uuid.Nil, false
+			}
+			slog.ErrorContext(ctx, "failed to check campaign quota", "error", err)
+			http.Error(w, "internal error", http.StatusInternalServerError)
+			return uuid.Nil, false
+		}
+	}
+
+	newID, err := h.repo.RerunCampaign(ctx, campaignID, activeOrg.ID, createdBy)
+	if err != nil {
+		slog.ErrorContext(ctx, "failed to re-run campaign", "error", err, "campaign_id", campaignID)
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return uuid.Nil, false
+	}
+	if newID == uuid.Nil {
+		http.Error(w, "campaign not found", http.StatusNotFound)
+		return uuid.Nil, false
+	}
+
+	slog.InfoContext(ctx, "re-ran campaign", "campaign_id", newID, "previous_campaign_id", campaignID)
+	return newID, true
+}
+
+// ArchiveCampaignUI hides a campaign from the campaigns list and reloads its
+// details page.
+func (h *Handlers) ArchiveCampaignUI(w http.ResponseWriter, r *http.Request) {
+	h.setCampaignArchivedUI(w, r, true)
+}
+
+// UnarchiveCampaignUI returns an archived campaign to the campaigns list and
+// reloads its details page.
+func (h *Handlers) UnarchiveCampaignUI(w http.ResponseWriter, r *http.Request) {
+	h.setCampaignArchivedUI(w, r, false)
+}
+
+func (h *Handlers) setCampaignArchivedUI(w http.ResponseWriter, r *http.Request, archived bool) {
+	campaignID, ok := h.setCampaignArchived(w, r, archived)
+	if !ok {
+		return
+	}
+
+	sse := datastar.NewSSE(w, r)
+	if err := sse.ExecuteScript(fmt.Sprintf("window.location = '/campaigns/%s'", campaignID.String())); err != nil {
+		return
+	}
+}
+
+// ArchiveCampaign is the API form of ArchiveCampaignUI. It responds with the
+// archived campaign.
+func (h *Handlers) ArchiveCampaign(w http.ResponseWriter, r *http.Request) {
+	h.setCampaignArchivedAPI(w, r, true)
+}
+
+// UnarchiveCampaign is the API form of UnarchiveCampaignUI. It responds with
+// the unarchived campaign.
+func (h *Handlers) UnarchiveCampaign(w http.ResponseWriter, r *http.Request) {
+	h.setCampaignArchivedAPI(w, r, false)
+}
+
+func (h *Handlers) setCampaignArchivedAPI(w http.ResponseWriter, r *http.Request, archived bool) {
+	campaignID, ok := h.setCampaignArchived(w, r, archived)
+	if !ok {
+		return
+	}
+
+	campaign, err := h.repo.GetCampaignByIDAndOrganization(r.Context(), campaignID, org.GetOrganizationFromContext(r.Context()).ID)
+	if err != nil || campaign == nil {
+		slog.ErrorContext(r.Context(), "failed to load campaign", "error", err, "campaign_id", campaignID)
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+
+	h.jsonResponse(w, campaign)
+}
+
+// setCampaignArchived archives or unarchives the campaign named in the URL.
+// It writes an error response and returns false on failure.
+func (h *Handlers) setCampaignArchived(w http.ResponseWriter, r *http.Request, archived bool) (uuid.UUID, bool) {
+	ctx := r.Context()
+	activeOrg := org.GetOrganizationFromContext(ctx)
+	if activeOrg == nil {
+		slog.Error("missing active organization in context")
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return uuid.Nil, false
+	}
+
+	campaignID, err := uuid.Parse(chi.URLParam(r, "id"))
+	if err != nil {
+		http.Error(w, "invalid campaign id", http.StatusBadRequest)
+		return uuid.Nil, false
+	}
+
+	found, err := h.repo.SetCampaignArchived(ctx, campaignID, activeOrg.ID, archived)
+	if err != nil {
+		slog.ErrorContext(ctx, "failed to archive campaign", "error", err, "campaign_id", campaignID, "archived", archived)
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return uuid.Nil, false
+	}
+	if !found {
+		http.Error(w, "campaign not found", http.StatusNotFound)
+		return uuid.Nil, false
+	}
+
+	slog.InfoContext(ctx, "set campaign archived", "campaign_id", campaignID, "archived", archived)
+	return campaignID, true
 }
 
 func (h *Handlers) HostDetailsPage(w http.ResponseWriter, r *http.Request) {
@@ -484,15 +1099,237 @@ func (h *Handlers) HostDetailsPage(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	results, err := h.repo.GetRecentResults(r.Context(), hostID)
-	if err != nil {
-		slog.Error("failed to get recent results", "error", err)
+	if r.URL.Query().Get("tab") == pages.HostTabScheduled {
+		view, err := h.scheduledResultsView(r, hostID)
+		if err != nil {
+			if errors.Is(err, errInvalidTimeRange) {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			slog.Error("failed to get scheduled results", "error", err)
+			http.Error(w, "internal error", http.StatusInternalServerError)
+			return
+		}
+		pages.HostDetailsPage(host.HostIdentifier, host, h.hostIdentities(r.Context(), host), h.hostQuarantine(r.Context(), host), nil, "", view, nil, h.minOsqueryVersion).Render(r.Context(), w)
+		return
+	}
+
+	if r.URL.Query().Get("tab") == pages.HostTabTimeline {
+		events, err := h.repo.ListHostEvents(r.Context(), hostID, hostTimelineLimit)
+		if err != nil {
+			slog.Error("failed to get host timeline", "error", err)
+			http.Error(w, "internal error", http.StatusInternalServerError)
+			return
+		}
+		timeline := &pages.HostTimelineView{Events: events}
+		pages.HostDetailsPage(host.HostIdentifier, host, h.hostIdentities(r.Context(), host), h.hostQuarantine(r.Context(), host), nil, "", nil, timeline, h.minOsqueryVersion).Render(r.Context(), w)
+		return
+	}
+
+	results, next, err := h.hostResultsPage(r.Context(), hostID, nil, hostResultsPageSize)
+	if err != nil {
+		slog.Error("failed to get recent results", "error", err)
+	}
+
+	pages.HostDetailsPage(host.HostIdentifier, host, h.hostIdentities(r.Context(), host), h.hostQuarantine(r.Context(), host), results, next, nil, nil, h.minOsqueryVersion).Render(r.Context(), w)
+}
+
+const (
+	scheduledResultsWindow = 24 * time.Hour
+	scheduledEventsLimit   = 200
+)
+
+var errInvalidTimeRange = errors.New("invalid time range")
+
+// scheduledResultsView loads the scheduled query tab. The query parameter
+// selects a query name (default: the most recently active), and since/until
+// bound the events shown, in UTC; the snapshot is taken as of until.
+func (h *Handlers) scheduledResultsView(r *http.Request, hostID uuid.UUID) (*pages.ScheduledResultsView, error) {
+	ctx := r.Context()
+	q := r.URL.Query()
+
+	view := &pages.ScheduledResultsView{
+		Until: time.Now().UTC().Truncate(time.Minute).Add(time.Minute),
+	}
+	if s := q.Get("until"); s != "" {
+		t, err := time.Parse(pages.ScheduledTimeLayout, s)
+		if err != nil {
+			return nil, fmt.Errorf("%w: until: %w", errInvalidTimeRange, err)
+		}
+		view.Until = t
+	}
+	view.Since = view.Until.Add(-scheduledResultsWindow)
+	if s := q.Get("since"); s != "" {
+		t, err := time.Parse(pages.ScheduledTimeLayout, s)
+		if err != nil {
+			return nil, fmt.Errorf("%w: since: %w", errInvalidTimeRange, err)
+		}
+		view.Since = t
+	}
+	if !view.Since.Before(view.Until) {
+		return nil, fmt.Errorf("%w: since must be before until", errInvalidTimeRange)
+	}
+
+	queries, err := h.repo.ListScheduledQueries(ctx, hostID)
+	if err != nil {
+		return nil, err
+	}
+	view.Queries = queries
+	if len(queries) == 0 {
+		return view, nil
+	}
+
+	view.Selected = queries[0].Name
+	if name := q.Get("query"); name != "" {
+		view.Selected = name
+	}
+
+	view.Snapshot, err = h.repo.GetScheduledSnapshot(ctx, hostID, view.Selected, view.Until)
+	if err != nil {
+		return nil, err
+	}
+	view.Events, err = h.repo.ListScheduledResultEvents(ctx, hostID, view.Selected, view.Since, view.Until, scheduledEventsLimit)
+	if err != nil {
+		return nil, err
+	}
+	return view, nil
+}
+
+func (h *Handlers) HostResultsSSE(w http.ResponseWriter, r *http.Request) {
+	hostIDStr := chi.URLParam(r, "id")
+	hostID, err := uuid.Parse(hostIDStr)
+	if err != nil {
+		http.Error(w, "invalid host id", http.StatusBadRequest)
+		return
+	}
+
+	activeOrg := org.GetOrganizationFromContext(r.Context())
+	if activeOrg == nil {
+		slog.Error("missing active organization in context")
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+
+	host, err := h.repo.GetByIDAndOrganization(r.Context(), hostID, activeOrg.ID)
+	if err != nil {
+		slog.Error("failed to get host", "error", err)
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+	if host == nil {
+		// Treat org mismatch as not found.
+		http.Error(w, "host not found", http.StatusNotFound)
+		return
+	}
+
+	sse, done, err := openLiveStream(w, r)
+	if err != nil {
+		slog.WarnContext(r.Context(), "failed to open live stream", "error", err)
+		return
+	}
+	defer done()
+	ctx := sse.Context()
+
+	results, next, err := h.hostResultsPage(ctx, hostID, nil, hostResultsPageSize)
+	if err != nil {
+		_ = sse.ConsoleError(err)
+		return
+	}
+	if err := sse.PatchElementTempl(pages.HostResultsTable(hostIDStr, results, next)); err != nil {
+		return
+	}
+
+	stream := newHostResultsStream(hostID, results)
+
+	if h.pubsub == nil {
+		h.pollResultsLegacy(ctx, sse, stream)
+		return
+	}
+
+	subscriber, err := h.pubsub.NewSubscriber(ctx)
+	if err != nil {
+		slog.ErrorContext(ctx, "failed to create subscriber; falling back to polling", "error", err)
+		h.pollResultsLegacy(ctx, sse, stream)
+		return
+	}
+	defer func() {
+		_ = subscriber.Close()
+	}()
+
+	topic := pubsub.TopicQueryResults(hostID)
+	messages, err := subscriber.Subscribe(ctx, topic)
+	if err != nil {
+		slog.ErrorContext(ctx, "failed to subscribe; falling back to polling", "error", err, "topic", topic)
+		h.pollResultsLegacy(ctx, sse, stream)
+		return
+	}
+
+	var changed []services.QueryResult
+	handle := h.pubsub.Handler("sse:host_results", topic, func(msg *message.Message) error {
+		event, err := pubsub.ParseQueryResultEvent(msg)
+		if err != nil {
+			return pubsub.Poison(err)
+		}
+
+		// Topic-scoped, but keep it defensive.
+		if event.HostID != hostID {
+			return nil
+		}
+
+		changed, err = stream.changedResults(ctx, h.repo)
+		if err != nil {
+			return fmt.Errorf("getting changed results after event: %w", err)
+		}
+		return nil
+	})
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case msg := <-messages:
+			if msg == nil {
+				return
+			}
+
+			changed = nil
+			if err := pubsub.Handle(msg, handle); err != nil {
+				continue
+			}
+			if err := patchHostResultRows(sse, changed); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// pollResultsLegacy implements the fallback polling mechanism for HostResultsSSE.
+// Used when pub/sub is unavailable or subscription fails.
+func (h *Handlers) pollResultsLegacy(ctx context.Context, sse liveStream, stream *hostResultsStream) {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			changed, err := stream.changedResults(ctx, h.repo)
+			if err != nil {
+				_ = sse.ConsoleError(err)
+				return
+			}
+
+			if err := patchHostResultRows(sse, changed); err != nil {
+				return
+			}
+		}
 	}
-
-	pages.HostDetailsPage(host.HostIdentifier, host, results).Render(r.Context(), w)
 }
 
-func (h *Handlers) HostResultsSSE(w http.ResponseWriter, r *http.Request) {
+// HostResultsMore appends the next page of results to the host details table
+// and replaces the "load more" control.
+func (h *Handlers) HostResultsMore(w http.ResponseWriter, r *http.Request) {
 	hostIDStr := chi.URLParam(r, "id")
 	hostID, err := uuid.Parse(hostIDStr)
 	if err != nil {
@@ -500,6 +1337,12 @@ func (h *Handlers) HostResultsSSE(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	cursor, err := services.ParseResultsCursor(r.URL.Query().Get("cursor"))
+	if err != nil {
+		http.Error(w, "invalid cursor", http.StatusBadRequest)
+		return
+	}
+
 	activeOrg := org.GetOrganizationFromContext(r.Context())
 	if activeOrg == nil {
 		slog.Error("missing active organization in context")
@@ -507,9 +1350,10 @@ func (h *Handlers) HostResultsSSE(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	host, err := h.repo.GetByIDAndOrganization(r.Context(), hostID, activeOrg.ID)
+	ctx := r.Context()
+	host, err := h.repo.GetByIDAndOrganization(ctx, hostID, activeOrg.ID)
 	if err != nil {
-		slog.Error("failed to get host", "error", err)
+		slog.ErrorContext(ctx, "failed to get host", "error", err)
 		http.Error(w, "internal error", http.StatusInternalServerError)
 		return
 	}
@@ -519,124 +1363,92 @@ func (h *Handlers) HostResultsSSE(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	ctx := r.Context()
-	sse := datastar.NewSSE(w, r)
-
-	results, err := h.repo.GetRecentResults(ctx, hostID)
+	results, next, err := h.hostResultsPage(ctx, hostID, &cursor, hostResultsPageSize)
 	if err != nil {
-		_ = sse.ConsoleError(err)
-		return
-	}
-	if err := sse.PatchElementTempl(pages.HostResultsTable(hostIDStr, results)); err != nil {
+		slog.ErrorContext(ctx, "failed to get results page", "error", err)
+		http.Error(w, "internal error", http.StatusInternalServerError)
 		return
 	}
 
-	if h.pubsub == nil {
-		h.pollResultsLegacy(ctx, sse, hostID, hostIDStr, results)
+	sse := datastar.NewSSE(w, r)
+	if len(results) > 0 {
+		if err := sse.PatchElementTempl(
+			pages.HostResultRows(results),
+			datastar.WithSelectorID(pages.HostResultsBodyID),
+			datastar.WithModeAppend(),
+		); err != nil {
+			return
+		}
+	}
+	if err := sse.PatchElementTempl(pages.HostResultsMore(hostIDStr, next)); err != nil {
 		return
 	}
+}
 
-	subscriber, err := h.pubsub.NewSubscriber(ctx)
-	if err != nil {
-		slog.ErrorContext(ctx, "failed to create subscriber; falling back to polling", "error", err)
-		h.pollResultsLegacy(ctx, sse, hostID, hostIDStr, results)
+type listHostResultsResponse struct {
+	Results    []services.QueryResult `json:"results"`
+	NextCursor string                 `json:"next_cursor,omitempty"`
+}
+
+// ListHostResults returns a page of a host's distributed query results, newest
+// first. Pass the returned next_cursor as ?cursor= to fetch older results.
+func (h *Handlers) ListHostResults(w http.ResponseWriter, r *http.Request) {
+	activeOrg := org.GetOrganizationFromContext(r.Context())
+	if activeOrg == nil {
+		slog.Error("missing active organization in context")
+		http.Error(w, "internal error", http.StatusInternalServerError)
 		return
 	}
-	defer func() {
-		_ = subscriber.Close()
-	}()
 
-	topic := pubsub.TopicQueryResults(hostID)
-	messages, err := subscriber.Subscribe(ctx, topic)
+	hostID, err := uuid.Parse(chi.URLParam(r, "id"))
 	if err != nil {
-		slog.ErrorContext(ctx, "failed to subscribe; falling back to polling", "error", err, "topic", topic)
-		h.pollResultsLegacy(ctx, sse, hostID, hostIDStr, results)
+		http.Error(w, "invalid host id", http.StatusBadRequest)
 		return
 	}
 
-	for {
-		select {
-		case <-ctx.Done():
+	var before *services.ResultsCursor
+	if s := r.URL.Query().Get("cursor"); s != "" {
+		cursor, err := services.ParseResultsCursor(s)
+		if err != nil {
+			http.Error(w, "invalid cursor", http.StatusBadRequest)
 			return
-		case msg := <-messages:
-			if msg == nil {
-				return
-			}
-
-			event, err := pubsub.ParseQueryResultEvent(msg)
-			if err != nil {
-				slog.ErrorContext(ctx, "failed to parse query result event", "error", err)
-				msg.Nack()
-				continue
-			}
-
-			// Topic-scoped, but keep it defensive.
-			if event.HostID != hostID {
-				msg.Ack()
-				continue
-			}
-
-			results, err := h.repo.GetRecentResults(ctx, hostID)
-			if err != nil {
-				slog.ErrorContext(ctx, "failed to get recent results after event", "error", err)
-				msg.Nack()
-				continue
-			}
-
-			if err := sse.PatchElementTempl(pages.HostResultsTable(hostIDStr, results)); err != nil {
-				msg.Nack()
-				return
-			}
-
-			msg.Ack()
 		}
+		before = &cursor
 	}
-}
 
-// pollResultsLegacy implements the fallback polling mechanism for HostResultsSSE.
-// Used when pub/sub is unavailable or subscription fails.
-func (h *Handlers) pollResultsLegacy(
-	ctx context.Context,
-	sse *datastar.ServerSentEventGenerator,
-	hostID uuid.UUID,
-	hostIDStr string,
-	initialResults []services.QueryResult,
-) {
-	ticker := time.NewTicker(time.Second)
-	defer ticker.Stop()
+	limit := hostResultsPageSize
+	if s := r.URL.Query().Get("limit"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n <= 0 {
+			http.Error(w, "invalid limit", http.StatusBadRequest)
+			return
+		}
+		limit = min(n, maxHostResultsPageSize)
+	}
 
-	last, err := json.Marshal(initialResults)
+	ctx := r.Context()
+	host, err := h.repo.GetByIDAndOrganization(ctx, hostID, activeOrg.ID)
 	if err != nil {
-		last = nil
+		slog.ErrorContext(ctx, "failed to get host", "error", err)
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+	if host == nil {
+		http.Error(w, "host not found", http.StatusNotFound)
+		return
 	}
 
-	for {
-		select {
-		case <-ctx.Done():
-			return
-		case <-ticker.C:
-			results, err := h.repo.GetRecentResults(ctx, hostID)
-			if err != nil {
-				_ = sse.ConsoleError(err)
-				return
-			}
-
-			b, err := json.Marshal(results)
-			if err != nil {
-				_ = sse.ConsoleError(err)
-				return
-			}
-
-			if bytes.Equal(b, last) {
-				continue
-			}
-			last = b
-
-			if err := sse.PatchElementTempl(pages.HostResultsTable(hostIDStr, results)); err != nil {
-				return
-			}
-		}
+	results, next, err := h.hostResultsPage(ctx, hostID, before, limit)
+	if err != nil {
+		slog.ErrorContext(ctx, "failed to get results page", "error", err)
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+	if results == nil {
+		results = []services.QueryResult{}
 	}
+
+	h.jsonResponse(w, listHostResultsResponse{Results: results, NextCursor: next})
 }
 
 func (h *Handlers) RunQuery(w http.ResponseWriter, r *http.Request) {
@@ -656,8 +1468,10 @@ func (h *Handlers) RunQuery(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if store.Query == "" {
-		http.Error(w, "query cannot be empty", http.StatusBadRequest)
+	fields := validate.Errors{}
+	fields.Field("query", strings.TrimSpace(store.Query), validate.Required())
+	if len(fields) > 0 {
+		patchFormErrors(w, r, hostsPageFields, fields)
 		return
 	}
 
@@ -686,8 +1500,12 @@ func (h *Handlers) RunQuery(w http.ResponseWriter, r *http.Request) {
 		createdBy = &user.ID
 	}
 
-	queryID, err := h.repo.QueueQuery(r.Context(), activeOrg.ID, createdBy, nil, nil, store.Query, []uuid.UUID{host.ID})
+	queryID, err := h.queueQuery(r.Context(), activeOrg.ID, createdBy, nil, nil, store.Query, []uuid.UUID{host.ID}, services.CampaignOptions{})
 	if err != nil {
+		if orgServices.IsQuotaExceeded(err) {
+			http.Error(w, err.Error(), http.StatusTooManyRequests)
+			return
+		}
 		slog.Error("failed to queue query", "error", err)
 		http.Error(w, "internal error", http.StatusInternalServerError)
 		return
@@ -695,60 +1513,174 @@ func (h *Handlers) RunQuery(w http.ResponseWriter, r *http.Request) {
 
 	slog.Info("queued campaign query", "campaign_id", queryID, "host_id", hostID)
 
-	// Send a script to close the dialog and maybe show a toast
+	// Clear any earlier error, then close the dialog.
 	sse := datastar.NewSSE(w, r)
+	if err := sse.MarshalAndPatchSignals(map[string]any{"errors": map[string]string{"query": ""}}); err != nil {
+		return
+	}
 	if err := sse.ExecuteScript(fmt.Sprintf("document.querySelector('[data-tui-dialog-close=\"query-dialog-%s\"]').click()", hostIDStr)); err != nil {
 		return
 	}
 }
 
-func (h *Handlers) publishQueryResultEvent(ctx context.Context, hostID uuid.UUID, queryID uuid.UUID, status string, errorText *string) {
+// queueQuery creates a campaign after checking the organization's daily
+// campaign quota.
+func (h *Handlers) queueQuery(ctx context.Context, organizationID uuid.UUID, createdBy *int, name, description *string, query string, hostIDs []uuid.UUID, opts services.CampaignOptions) (uuid.UUID, error) {
+	return h.campaigns().QueueQuery(ctx, organizationID, createdBy, name, description, query, hostIDs, opts)
+}
+
+// campaigns queues campaigns with the handlers' repositories and quotas.
+func (h *Handlers) campaigns() *CampaignQueue {
+	return &CampaignQueue{queries: h.repo, groups: h.groups, quotas: h.quotas}
+}
+
+// quotaExceeded writes resp with a status osquery treats as a failed request
+// if err is a quota error, and reports whether it did.
+func (h *Handlers) quotaExceeded(w http.ResponseWriter, err error, resp any) bool {
+	var qe *orgServices.QuotaExceededError
+	if !errors.As(err, &qe) {
+		return false
+	}
+	status := http.StatusTooManyRequests
+	if qe.Quota == orgServices.QuotaHosts {
+		status = http.StatusForbidden
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(resp); err != nil {
+		slog.Error("failed to encode json response", "error", err)
+	}
+	return true
+}
+
+// nodeKeyJitter spreads rotations out: it returns a stable per-host delay of
+// up to a tenth of maxAge, so keys issued at the same moment don't all expire
+// on the same config poll.
+func nodeKeyJitter(hostID uuid.UUID, maxAge time.Duration) time.Duration {
+	spread := int64(maxAge / 10)
+	if spread <= 0 {
+		return 0
+	}
+	return time.Duration(binary.BigEndian.Uint64(hostID[8:]) % uint64(spread))
+}
+
+// publishHostDeletedEvent tells every instance's host cache to forget the
+// deleted hosts, so their node keys stop authenticating.
+func (h *Handlers) publishHostDeletedEvent(ctx context.Context, organizationID uuid.UUID, hostIDs []uuid.UUID) {
+	if h.publisher == nil {
+		return
+	}
+
+	topic := pubsub.TopicHostDeletions
+	event := pubsub.HostDeletedEvent{
+		OrganizationID: organizationID,
+		HostIDs:        hostIDs,
+		OccurredAt:     time.Now().UTC(),
+	}
+	if err := h.publisher.Publish(topic, event.ToMessage()); err != nil {
+		slog.ErrorContext(ctx, "failed to publish host deleted event", "error", err, "topic", topic, "organization_id", organizationID)
+	}
+}
+
+func (h *Handlers) publishHostEnrolledEvent(ctx context.Context, organizationID uuid.UUID, hostIdentifier string) {
 	if h.publisher == nil {
 		return
 	}
 
-	topic := pubsub.TopicQueryResults(hostID)
-	event := pubsub.QueryResultEvent{
-		HostID:     hostID,
-		QueryID:    queryID,
-		Status:     status,
-		OccurredAt: time.Now().UTC(),
-		Error:      errorText,
+	topic := pubsub.TopicHostEnrollments
+	event := pubsub.HostEnrolledEvent{
+		OrganizationID: organizationID,
+		HostIdentifier: hostIdentifier,
+		OccurredAt:     time.Now().UTC(),
 	}
 
 	if err := h.publisher.Publish(topic, event.ToMessage()); err != nil {
-		slog.ErrorContext(ctx, "failed to publish query result event", "error", err, "topic", topic, "host_id", hostID, "query_id", queryID)
+		slog.ErrorContext(ctx, "failed to publish host enrolled event", "error", err, "topic", topic, "organization_id", organizationID)
 		return
 	}
 
-	slog.DebugContext(ctx, "published query result event", "topic", topic, "host_id", hostID, "query_id", queryID, "status", status)
+	hostsTopic := pubsub.TopicHosts(organizationID)
+	hostEvent := pubsub.HostEvent{
+		OrganizationID: organizationID,
+		HostIdentifier: hostIdentifier,
+		Type:           pubsub.HostEventEnrolled,
+		OccurredAt:     event.OccurredAt,
+	}
+	if err := h.publisher.Publish(hostsTopic, hostEvent.ToMessage()); err != nil {
+		slog.ErrorContext(ctx, "failed to publish host event", "error", err, "topic", hostsTopic, "organization_id", organizationID)
+	}
+
+	slog.DebugContext(ctx, "published host enrolled event", "topic", topic, "organization_id", organizationID, "host_identifier", hostIdentifier)
 }
 
-func (h *Handlers) publishCampaignResultEvent(ctx context.Context, campaignID uuid.UUID, host *services.Host, status string, rowCount int, errorText *string) {
+// saveQueryResults persists a host's campaign response and publishes the
+// matching result events. With an outbox relay the events commit atomically
+// with the results; otherwise they are published directly after the save,
+// and are lost if the process dies in between.
+func (h *Handlers) saveQueryResults(ctx context.Context, host *services.Host, queryID uuid.UUID, status string, results json.RawMessage, rowCount int, errorText *string, truncated bool) error {
+	events := queryResultEvents(host, queryID, status, rowCount, errorText)
+
+	if h.outbox != nil {
+		if err := h.repo.SaveQueryResults(ctx, host.ID, queryID, status, results, errorText, truncated, events...); err != nil {
+			return err
+		}
+		h.outbox.Notify()
+		return nil
+	}
+
+	if err := h.repo.SaveQueryResults(ctx, host.ID, queryID, status, results, errorText, truncated); err != nil {
+		return err
+	}
 	if h.publisher == nil {
-		return
+		return nil
 	}
-	if host == nil {
+	for _, e := range events {
+		if err := h.publisher.Publish(e.Topic, e.Message); err != nil {
+			slog.ErrorContext(ctx, "failed to publish result event", "error", err, "topic", e.Topic, "host_id", host.ID, "query_id", queryID)
+			continue
+		}
+		slog.DebugContext(ctx, "published result event", "topic", e.Topic, "host_id", host.ID, "query_id", queryID, "status", status)
+	}
+	return nil
+}
+
+// logSaveQueryResultsError logs a failed save. A result the target already
+// has is a retried distributed_write, which is expected and only logged at
+// debug level; osquery still gets a success response so it stops retrying.
+func logSaveQueryResultsError(ctx context.Context, err error, host *services.Host, queryID uuid.UUID) {
+	if errors.Is(err, services.ErrQueryResultsAlreadySaved) {
+		slog.DebugContext(ctx, "ignoring duplicate query results", "host_id", host.ID, "query_id", queryID)
 		return
 	}
+	slog.ErrorContext(ctx, "failed to save query results", "error", err, "host_id", host.ID, "query_id", queryID)
+}
 
-	topic := pubsub.TopicCampaign(campaignID)
-	event := pubsub.CampaignResultEvent{
-		CampaignID:     campaignID,
+// queryResultEvents builds the events announcing a host's result: one for the
+// host's detail page and one for the campaign (queryID is the campaign ID).
+func queryResultEvents(host *services.Host, queryID uuid.UUID, status string, rowCount int, errorText *string) []outbox.Event {
+	now := time.Now().UTC()
+
+	resultEvent := pubsub.QueryResultEvent{
+		HostID:     host.ID,
+		QueryID:    queryID,
+		Status:     status,
+		OccurredAt: now,
+		Error:      errorText,
+	}
+	campaignEvent := pubsub.CampaignResultEvent{
+		CampaignID:     queryID,
 		HostID:         host.ID,
 		HostIdentifier: host.HostIdentifier,
 		Status:         status,
-		OccurredAt:     time.Now().UTC(),
+		OccurredAt:     now,
 		RowCount:       rowCount,
 		Error:          errorText,
 	}
 
-	if err := h.publisher.Publish(topic, event.ToMessage()); err != nil {
-		slog.ErrorContext(ctx, "failed to publish campaign result event", "error", err, "topic", topic, "campaign_id", campaignID, "host_id", host.ID)
-		return
+	return []outbox.Event{
+		{Topic: pubsub.TopicQueryResults(host.ID), Message: resultEvent.ToMessage()},
+		{Topic: pubsub.TopicCampaign(queryID), Message: campaignEvent.ToMessage()},
 	}
-
-	slog.DebugContext(ctx, "published campaign result event", "topic", topic, "campaign_id", campaignID, "host_id", host.ID, "status", status)
 }
 
 type createCampaignRequest struct {
@@ -756,6 +1688,42 @@ type createCampaignRequest struct {
 	Name        *string     `json:"name,omitempty"`
 	Description *string     `json:"description,omitempty"`
 	HostIDs     []uuid.UUID `json:"host_ids,omitempty"`
+
+	// GroupID targets a host group's members instead of HostIDs.
+	GroupID *uuid.UUID `json:"group_id,omitempty"`
+
+	// FanoutLimit throttles the campaign to this many hosts per
+	// FanoutIntervalSeconds (default 30).
+	FanoutLimit           int `json:"fanout_limit,omitempty"`
+	FanoutIntervalSeconds int `json:"fanout_interval_seconds,omitempty"`
+
+	// MaxRowsPerHost and MaxBytesPerHost truncate each host's results.
+	MaxRowsPerHost  int   `json:"max_rows_per_host,omitempty"`
+	MaxBytesPerHost int64 `json:"max_bytes_per_host,omitempty"`
+	// TimeoutSeconds fails targets still outstanding this long after the
+	// campaign is created.
+	TimeoutSeconds int `json:"timeout_seconds,omitempty"`
+}
+
+// options checks the request and converts it to CampaignOptions, naming each
+// invalid field by its JSON key.
+func (req createCampaignRequest) options() (services.CampaignOptions, validate.Errors) {
+	fields := validate.Errors{}
+	fields.Field("query", req.Query, validate.Required())
+	fields.Check(req.GroupID == nil || len(req.HostIDs) == 0, "group_id", "Can't be combined with host_ids")
+	fields.Check(req.FanoutLimit >= 0, "fanout_limit", validate.MsgNonNegative)
+	fields.Check(req.FanoutIntervalSeconds >= 0, "fanout_interval_seconds", validate.MsgNonNegative)
+	fields.Check(req.MaxRowsPerHost >= 0, "max_rows_per_host", validate.MsgNonNegative)
+	fields.Check(req.MaxBytesPerHost >= 0, "max_bytes_per_host", validate.MsgNonNegative)
+	fields.Check(req.TimeoutSeconds >= 0, "timeout_seconds", validate.MsgNonNegative)
+
+	return services.CampaignOptions{
+		FanoutLimit:     req.FanoutLimit,
+		FanoutInterval:  time.Duration(req.FanoutIntervalSeconds) * time.Second,
+		MaxRowsPerHost:  req.MaxRowsPerHost,
+		MaxBytesPerHost: req.MaxBytesPerHost,
+		Timeout:         time.Duration(req.TimeoutSeconds) * time.Second,
+	}, fields
 }
 
 type createCampaignResponse struct {
@@ -772,8 +1740,8 @@ func (h *Handlers) CreateCampaign(w http.ResponseWriter, r *http.Request) {
 	}
 
 	var req createCampaignRequest
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, "invalid request", http.StatusBadRequest)
+	if err := httpbody.DecodeStrictJSON(r, &req); err != nil {
+		httpbody.Error(w, err)
 		return
 	}
 	if req.Name != nil && *req.Name == "" {
@@ -782,8 +1750,9 @@ func (h *Handlers) CreateCampaign(w http.ResponseWriter, r *http.Request) {
 	if req.Description != nil && *req.Description == "" {
 		req.Description = nil
 	}
-	if req.Query == "" {
-		http.Error(w, "query cannot be empty", http.StatusBadRequest)
+	opts, fields := req.options()
+	if len(fields) > 0 {
+		validate.WriteJSON(w, fields)
 		return
 	}
 
@@ -794,6 +1763,15 @@ func (h *Handlers) CreateCampaign(w http.ResponseWriter, r *http.Request) {
 		createdBy = &user.ID
 	}
 
+	if req.GroupID != nil {
+		if h.groups == nil {
+			http.Error(w, "host group not found", http.StatusNotFound)
+			return
+		}
+		h.createGroupCampaign(w, r, activeOrg.ID, createdBy, req, opts)
+		return
+	}
+
 	targetHostIDs := req.HostIDs
 	if len(targetHostIDs) == 0 {
 		hosts, err := h.repo.ListByOrganization(ctx, activeOrg.ID)
@@ -825,8 +1803,12 @@ func (h *Handlers) CreateCampaign(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	campaignID, err := h.repo.QueueQuery(ctx, activeOrg.ID, createdBy, req.Name, req.Description, req.Query, targetHostIDs)
+	campaignID, err := h.queueQuery(ctx, activeOrg.ID, createdBy, req.Name, req.Description, req.Query, targetHostIDs, opts)
 	if err != nil {
+		if orgServices.IsQuotaExceeded(err) {
+			http.Error(w, err.Error(), http.StatusTooManyRequests)
+			return
+		}
 		slog.ErrorContext(ctx, "failed to create campaign", "error", err)
 		http.Error(w, "internal error", http.StatusInternalServerError)
 		return
@@ -836,6 +1818,24 @@ func (h *Handlers) CreateCampaign(w http.ResponseWriter, r *http.Request) {
 	h.jsonResponse(w, createCampaignResponse{CampaignID: campaignID, TargetCount: len(targetHostIDs)})
 }
 
+func (h *Handlers) createGroupCampaign(w http.ResponseWriter, r *http.Request, organizationID uuid.UUID, createdBy *int, req createCampaignRequest, opts services.CampaignOptions) {
+	ctx := r.Context()
+	campaignID, err := h.queueGroupQuery(ctx, organizationID, createdBy, req.Name, req.Description, req.Query, *req.GroupID, opts)
+	if !h.groupQueryQueued(w, r, campaignID, err) {
+		return
+	}
+
+	campaign, err := h.repo.GetCampaignByIDAndOrganization(ctx, campaignID, organizationID)
+	if err != nil || campaign == nil {
+		slog.ErrorContext(ctx, "failed to load group campaign", "error", err, "campaign_id", campaignID)
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusCreated)
+	h.jsonResponse(w, createCampaignResponse{CampaignID: campaignID, TargetCount: campaign.TargetCount})
+}
+
 func (h *Handlers) GetCampaign(w http.ResponseWriter, r *http.Request) {
 	activeOrg := org.GetOrganizationFromContext(r.Context())
 	if activeOrg == nil {
@@ -877,7 +1877,7 @@ func (h *Handlers) ListCampaigns(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	campaigns, err := h.repo.ListCampaignsByOrganization(r.Context(), activeOrg.ID, 50)
+	campaigns, err := h.repo.ListCampaignsByOrganization(r.Context(), activeOrg.ID, archivedParam(r), 50)
 	if err != nil {
 		slog.ErrorContext(r.Context(), "failed to list campaigns", "error", err)
 		http.Error(w, "internal error", http.StatusInternalServerError)
@@ -921,25 +1921,41 @@ func (h *Handlers) CampaignResultsSSE(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "internal error", http.StatusInternalServerError)
 		return
 	}
+	// The page passes its sort, filters, and columns on to the stream.
+	view := services.ParseCampaignResultView(r.URL.Query())
+	results, err := h.repo.QueryCampaignResults(ctx, campaignID, view)
+	if err != nil {
+		slog.ErrorContext(ctx, "failed to query campaign results", "error", err)
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
 
-	sse := datastar.NewSSE(w, r)
-	if err := sse.PatchElementTempl(pages.CampaignResultsTable(campaignID.String(), campaign, targets)); err != nil {
+	sse, done, err := openLiveStream(w, r)
+	if err != nil {
+		slog.WarnContext(ctx, "failed to open live stream", "error", err)
 		return
 	}
+	defer done()
+	ctx = sse.Context()
 
-	if campaign.Status == "completed" || campaign.Status == "failed" {
+	if err := sse.PatchElementTempl(pages.CampaignResultsTable(campaignID.String(), campaign, targets, results, view)); err != nil {
 		return
 	}
 
+	// A finished campaign's results no longer change, but their
+	// annotations do, and those only arrive through pubsub.
+	finished := campaign.Status == "completed" || campaign.Status == "failed"
 	if h.pubsub == nil {
-		h.pollCampaignLegacy(ctx, sse, activeOrg.ID, campaignID, campaign, targets)
+		if !finished {
+			h.pollCampaignLegacy(ctx, sse, activeOrg.ID, campaignID, campaign, targets, view)
+		}
 		return
 	}
 
 	subscriber, err := h.pubsub.NewSubscriber(ctx)
 	if err != nil {
 		slog.ErrorContext(ctx, "failed to create subscriber; falling back to polling", "error", err)
-		h.pollCampaignLegacy(ctx, sse, activeOrg.ID, campaignID, campaign, targets)
+		h.pollCampaignLegacy(ctx, sse, activeOrg.ID, campaignID, campaign, targets, view)
 		return
 	}
 	defer func() {
@@ -950,9 +1966,43 @@ func (h *Handlers) CampaignResultsSSE(w http.ResponseWriter, r *http.Request) {
 	messages, err := subscriber.Subscribe(ctx, topic)
 	if err != nil {
 		slog.ErrorContext(ctx, "failed to subscribe; falling back to polling", "error", err, "topic", topic)
-		h.pollCampaignLegacy(ctx, sse, activeOrg.ID, campaignID, campaign, targets)
-		return
-	}
+		h.pollCampaignLegacy(ctx, sse, activeOrg.ID, campaignID, campaign, targets, view)
+		return
+	}
+
+	// Events are acknowledged as they arrive and only mark the table stale;
+	// the coalescer re-renders it, so a burst of results or a slow client
+	// doesn't back events up in the subscriber.
+	ctx, cancel := context.WithCancel(ctx)
+	updates := newPatchCoalescer(campaignPatchWindow)
+	rendered := make(chan struct{})
+	go func() {
+		defer close(rendered)
+		defer cancel()
+		updates.run(ctx, func() bool {
+			return h.renderCampaignResults(ctx, sse, updates, activeOrg.ID, campaignID, view)
+		})
+	}()
+	defer func() {
+		cancel()
+		<-rendered
+	}()
+
+	handle := h.pubsub.Handler("sse:campaign_results", topic, func(msg *message.Message) error {
+		if msg.Metadata.Get("event_type") == pubsub.EventTypeResultAnnotation {
+			updates.mark()
+			return nil
+		}
+
+		event, err := pubsub.ParseCampaignResultEvent(msg)
+		if err != nil {
+			return pubsub.Poison(err)
+		}
+		if event.CampaignID == campaignID {
+			updates.mark()
+		}
+		return nil
+	})
 
 	for {
 		select {
@@ -962,58 +2012,57 @@ func (h *Handlers) CampaignResultsSSE(w http.ResponseWriter, r *http.Request) {
 			if msg == nil {
 				return
 			}
+			_ = pubsub.Handle(msg, handle)
+		}
+	}
+}
 
-			event, err := pubsub.ParseCampaignResultEvent(msg)
-			if err != nil {
-				slog.ErrorContext(ctx, "failed to parse campaign result event", "error", err)
-				msg.Nack()
-				continue
-			}
-
-			if event.CampaignID != campaignID {
-				msg.Ack()
-				continue
-			}
-
-			campaign, err = h.repo.GetCampaignByIDAndOrganization(ctx, campaignID, activeOrg.ID)
-			if err != nil {
-				slog.ErrorContext(ctx, "failed to get campaign", "error", err)
-				msg.Nack()
-				continue
-			}
-			if campaign == nil {
-				msg.Ack()
-				return
-			}
-
-			targets, err = h.repo.GetCampaignTargets(ctx, campaignID)
-			if err != nil {
-				slog.ErrorContext(ctx, "failed to get campaign targets", "error", err)
-				msg.Nack()
-				continue
-			}
-
-			if err := sse.PatchElementTempl(pages.CampaignResultsTable(campaignID.String(), campaign, targets)); err != nil {
-				msg.Nack()
-				return
-			}
-
-			msg.Ack()
+// renderCampaignResults sends a campaign's current results table to a live
+// stream and reports whether the stream should go on. A failed read is
+// retried on the next batch of updates, with one marked in case no more
+// events come.
+func (h *Handlers) renderCampaignResults(
+	ctx context.Context,
+	sse liveStream,
+	updates *patchCoalescer,
+	organizationID uuid.UUID,
+	campaignID uuid.UUID,
+	view services.CampaignResultView,
+) bool {
+	campaign, err := h.repo.GetCampaignByIDAndOrganization(ctx, campaignID, organizationID)
+	if err != nil {
+		slog.ErrorContext(ctx, "failed to get campaign", "error", err)
+		updates.mark()
+		return true
+	}
+	if campaign == nil {
+		return false
+	}
 
-			if campaign.Status == "completed" || campaign.Status == "failed" {
-				return
-			}
-		}
+	targets, err := h.repo.GetCampaignTargets(ctx, campaignID)
+	if err != nil {
+		slog.ErrorContext(ctx, "failed to get campaign targets", "error", err)
+		updates.mark()
+		return true
+	}
+	results, err := h.repo.QueryCampaignResults(ctx, campaignID, view)
+	if err != nil {
+		slog.ErrorContext(ctx, "failed to query campaign results", "error", err)
+		updates.mark()
+		return true
 	}
+
+	return sse.PatchElementTempl(pages.CampaignResultsTable(campaignID.String(), campaign, targets, results, view)) == nil
 }
 
 func (h *Handlers) pollCampaignLegacy(
 	ctx context.Context,
-	sse *datastar.ServerSentEventGenerator,
+	sse liveStream,
 	organizationID uuid.UUID,
 	campaignID uuid.UUID,
 	initialCampaign *services.Campaign,
 	initialTargets []*services.CampaignTarget,
+	view services.CampaignResultView,
 ) {
 	snapshot, err := json.Marshal(map[string]any{"campaign": initialCampaign, "targets": initialTargets})
 	if err != nil {
@@ -1051,7 +2100,12 @@ func (h *Handlers) pollCampaignLegacy(
 
 			if !bytes.Equal(b, snapshot) {
 				snapshot = b
-				if err := sse.PatchElementTempl(pages.CampaignResultsTable(campaignID.String(), campaign, targets)); err != nil {
+				results, err := h.repo.QueryCampaignResults(ctx, campaignID, view)
+				if err != nil {
+					_ = sse.ConsoleError(err)
+					return
+				}
+				if err := sse.PatchElementTempl(pages.CampaignResultsTable(campaignID.String(), campaign, targets, results, view)); err != nil {
 					return
 				}
 			}
@@ -12,11 +12,15 @@ import (
 	"time"
 
 	"github.com/ThreeDotsLabs/watermill/message"
+	"github.com/go-chi/chi/v5"
 	"github.com/google/uuid"
 
+	org "github.com/cavenine/queryops/features/organization"
 	orgServices "github.com/cavenine/queryops/features/organization/services"
 	"github.com/cavenine/queryops/features/osquery"
 	osqueryServices "github.com/cavenine/queryops/features/osquery/services"
+	"github.com/cavenine/queryops/internal/httpbody"
+	"github.com/cavenine/queryops/internal/outbox"
 )
 
 type stubHostRepo struct {
@@ -25,20 +29,30 @@ type stubHostRepo struct {
 	UpdateLastConfigFunc      func(ctx context.Context, nodeKey string) error
 	UpdateLastLoggerFunc      func(ctx context.Context, nodeKey string) error
 	UpdateLastDistributedFunc func(ctx context.Context, nodeKey string) error
+	PingFunc                  func(ctx context.Context, nodeKey string) (*osqueryServices.Host, error)
 	GetConfigForHostFunc      func(ctx context.Context, nodeKey string) (json.RawMessage, error)
 	SaveResultLogsFunc        func(ctx context.Context, hostID uuid.UUID, name, action string, columns json.RawMessage, timestamp time.Time) error
 	SaveStatusLogsFunc        func(ctx context.Context, hostID uuid.UUID, line int, message string, severity int, filename string, createdAt time.Time) error
 	GetPendingQueriesFunc     func(ctx context.Context, hostID uuid.UUID) (map[string]string, error)
-	SaveQueryResultsFunc      func(ctx context.Context, hostID uuid.UUID, queryID uuid.UUID, status string, results json.RawMessage, errorText *string) error
-
-	ListByOrganizationFunc     func(ctx context.Context, organizationID uuid.UUID) ([]*osqueryServices.Host, error)
-	GetByIDAndOrganizationFunc func(ctx context.Context, id uuid.UUID, organizationID uuid.UUID) (*osqueryServices.Host, error)
-	GetRecentResultsFunc       func(ctx context.Context, hostID uuid.UUID) ([]osqueryServices.QueryResult, error)
-	QueueQueryFunc             func(ctx context.Context, organizationID uuid.UUID, createdBy *int, name *string, description *string, query string, hostIDs []uuid.UUID) (uuid.UUID, error)
+	SaveQueryResultsFunc      func(ctx context.Context, hostID uuid.UUID, queryID uuid.UUID, status string, results json.RawMessage, errorText *string, truncated bool) error
+
+	ListByOrganizationFunc        func(ctx context.Context, organizationID uuid.UUID) ([]*osqueryServices.Host, error)
+	GetByIDAndOrganizationFunc    func(ctx context.Context, id uuid.UUID, organizationID uuid.UUID) (*osqueryServices.Host, error)
+	ListResultsFunc               func(ctx context.Context, hostID uuid.UUID, before *osqueryServices.ResultsCursor, limit int) ([]osqueryServices.QueryResult, error)
+	ListResultsUpdatedSinceFunc   func(ctx context.Context, hostID uuid.UUID, since time.Time) ([]osqueryServices.QueryResult, error)
+	SearchResultsFunc             func(ctx context.Context, organizationID uuid.UUID, s osqueryServices.ResultSearch) ([]osqueryServices.SearchHit, error)
+	ListScheduledQueriesFunc      func(ctx context.Context, hostID uuid.UUID) ([]osqueryServices.ScheduledQuery, error)
+	ListScheduledResultEventsFunc func(ctx context.Context, hostID uuid.UUID, name string, since, until time.Time, limit int) ([]osqueryServices.ScheduledResultEvent, error)
+	GetScheduledSnapshotFunc      func(ctx context.Context, hostID uuid.UUID, name string, at time.Time) (*osqueryServices.ScheduledSnapshot, error)
+	ListHostEventsFunc            func(ctx context.Context, hostID uuid.UUID, limit int) ([]osqueryServices.HostTimelineEvent, error)
+	QueueQueryFunc                func(ctx context.Context, organizationID uuid.UUID, createdBy *int, name *string, description *string, query string, hostIDs []uuid.UUID, opts osqueryServices.CampaignOptions) (uuid.UUID, error)
+	RerunCampaignFunc             func(ctx context.Context, campaignID uuid.UUID, organizationID uuid.UUID, createdBy *int) (uuid.UUID, error)
 
 	GetCampaignByIDAndOrganizationFunc func(ctx context.Context, campaignID uuid.UUID, organizationID uuid.UUID) (*osqueryServices.Campaign, error)
-	ListCampaignsByOrganizationFunc    func(ctx context.Context, organizationID uuid.UUID, limit int) ([]*osqueryServices.Campaign, error)
+	ListCampaignsByOrganizationFunc    func(ctx context.Context, organizationID uuid.UUID, archived bool, limit int) ([]*osqueryServices.Campaign, error)
+	SetCampaignArchivedFunc            func(ctx context.Context, campaignID uuid.UUID, organizationID uuid.UUID, archived bool) (bool, error)
 	GetCampaignTargetsFunc             func(ctx context.Context, campaignID uuid.UUID) ([]*osqueryServices.CampaignTarget, error)
+	QueryCampaignResultsFunc           func(ctx context.Context, campaignID uuid.UUID, view osqueryServices.CampaignResultView) (*osqueryServices.CampaignResults, error)
 }
 
 func (s *stubHostRepo) Enroll(ctx context.Context, hostIdentifier string, hostDetails json.RawMessage, organizationID uuid.UUID) (string, error) {
@@ -76,6 +90,13 @@ func (s *stubHostRepo) UpdateLastDistributed(ctx context.Context, nodeKey string
 	return s.UpdateLastDistributedFunc(ctx, nodeKey)
 }
 
+func (s *stubHostRepo) Ping(ctx context.Context, nodeKey string) (*osqueryServices.Host, error) {
+	if s.PingFunc == nil {
+		return nil, nil
+	}
+	return s.PingFunc(ctx, nodeKey)
+}
+
 func (s *stubHostRepo) GetConfigForHost(ctx context.Context, nodeKey string) (json.RawMessage, error) {
 	if s.GetConfigForHostFunc == nil {
 		return nil, nil
@@ -104,11 +125,11 @@ func (s *stubHostRepo) GetPendingQueries(ctx context.Context, hostID uuid.UUID)
 	return s.GetPendingQueriesFunc(ctx, hostID)
 }
 
-func (s *stubHostRepo) SaveQueryResults(ctx context.Context, hostID uuid.UUID, queryID uuid.UUID, status string, results json.RawMessage, errorText *string) error {
+func (s *stubHostRepo) SaveQueryResults(ctx context.Context, hostID uuid.UUID, queryID uuid.UUID, status string, results json.RawMessage, errorText *string, truncated bool, _ ...outbox.Event) error {
 	if s.SaveQueryResultsFunc == nil {
 		return nil
 	}
-	return s.SaveQueryResultsFunc(ctx, hostID, queryID, status, results, errorText)
+	return s.SaveQueryResultsFunc(ctx, hostID, queryID, status, results, errorText, truncated)
 }
 
 func (s *stubHostRepo) ListByOrganization(ctx context.Context, organizationID uuid.UUID) ([]*osqueryServices.Host, error) {
@@ -125,18 +146,67 @@ func (s *stubHostRepo) GetByIDAndOrganization(ctx context.Context, id uuid.UUID,
 	return s.GetByIDAndOrganizationFunc(ctx, id, organizationID)
 }
 
-func (s *stubHostRepo) GetRecentResults(ctx context.Context, hostID uuid.UUID) ([]osqueryServices.QueryResult, error) {
-	if s.GetRecentResultsFunc == nil {
+func (s *stubHostRepo) ListResults(ctx context.Context, hostID uuid.UUID, before *osqueryServices.ResultsCursor, limit int) ([]osqueryServices.QueryResult, error) {
+	if s.ListResultsFunc == nil {
+		return nil, nil
+	}
+	return s.ListResultsFunc(ctx, hostID, before, limit)
+}
+
+func (s *stubHostRepo) ListResultsUpdatedSince(ctx context.Context, hostID uuid.UUID, since time.Time) ([]osqueryServices.QueryResult, error) {
+	if s.ListResultsUpdatedSinceFunc == nil {
+		return nil, nil
+	}
+	return s.ListResultsUpdatedSinceFunc(ctx, hostID, since)
+}
+
+func (s *stubHostRepo) SearchResults(ctx context.Context, organizationID uuid.UUID, search osqueryServices.ResultSearch) ([]osqueryServices.SearchHit, error) {
+	if s.SearchResultsFunc == nil {
+		return nil, nil
+	}
+	return s.SearchResultsFunc(ctx, organizationID, search)
+}
+
+func (s *stubHostRepo) ListScheduledQueries(ctx context.Context, hostID uuid.UUID) ([]osqueryServices.ScheduledQuery, error) {
+	if s.ListScheduledQueriesFunc == nil {
+		return nil, nil
+	}
+	return s.ListScheduledQueriesFunc(ctx, hostID)
+}
+
+func (s *stubHostRepo) ListScheduledResultEvents(ctx context.Context, hostID uuid.UUID, name string, since, until time.Time, limit int) ([]osqueryServices.ScheduledResultEvent, error) {
+	if s.ListScheduledResultEventsFunc == nil {
+		return nil, nil
+	}
+	return s.ListScheduledResultEventsFunc(ctx, hostID, name, since, until, limit)
+}
+
+func (s *stubHostRepo) GetScheduledSnapshot(ctx context.Context, hostID uuid.UUID, name string, at time.Time) (*osqueryServices.ScheduledSnapshot, error) {
+	if s.GetScheduledSnapshotFunc == nil {
+		return &osqueryServices.ScheduledSnapshot{}, nil
+	}
+	return s.GetScheduledSnapshotFunc(ctx, hostID, name, at)
+}
+
+func (s *stubHostRepo) ListHostEvents(ctx context.Context, hostID uuid.UUID, limit int) ([]osqueryServices.HostTimelineEvent, error) {
+	if s.ListHostEventsFunc == nil {
 		return nil, nil
 	}
-	return s.GetRecentResultsFunc(ctx, hostID)
+	return s.ListHostEventsFunc(ctx, hostID, limit)
 }
 
-func (s *stubHostRepo) QueueQuery(ctx context.Context, organizationID uuid.UUID, createdBy *int, name *string, description *string, query string, hostIDs []uuid.UUID) (uuid.UUID, error) {
+func (s *stubHostRepo) QueueQuery(ctx context.Context, organizationID uuid.UUID, createdBy *int, name *string, description *string, query string, hostIDs []uuid.UUID, opts osqueryServices.CampaignOptions) (uuid.UUID, error) {
 	if s.QueueQueryFunc == nil {
 		return uuid.Nil, nil
 	}
-	return s.QueueQueryFunc(ctx, organizationID, createdBy, name, description, query, hostIDs)
+	return s.QueueQueryFunc(ctx, organizationID, createdBy, name, description, query, hostIDs, opts)
+}
+
+func (s *stubHostRepo) RerunCampaign(ctx context.Context, campaignID uuid.UUID, organizationID uuid.UUID, createdBy *int) (uuid.UUID, error) {
+	if s.RerunCampaignFunc == nil {
+		return uuid.Nil, nil
+	}
+	return s.RerunCampaignFunc(ctx, campaignID, organizationID, createdBy)
 }
 
 func (s *stubHostRepo) GetCampaignByIDAndOrganization(ctx context.Context, campaignID uuid.UUID, organizationID uuid.UUID) (*osqueryServices.Campaign, error) {
@@ -146,11 +216,18 @@ func (s *stubHostRepo) GetCampaignByIDAndOrganization(ctx context.Context, campa
 	return s.GetCampaignByIDAndOrganizationFunc(ctx, campaignID, organizationID)
 }
 
-func (s *stubHostRepo) ListCampaignsByOrganization(ctx context.Context, organizationID uuid.UUID, limit int) ([]*osqueryServices.Campaign, error) {
+func (s *stubHostRepo) ListCampaignsByOrganization(ctx context.Context, organizationID uuid.UUID, archived bool, limit int) ([]*osqueryServices.Campaign, error) {
 	if s.ListCampaignsByOrganizationFunc == nil {
 		return nil, nil
 	}
-	return s.ListCampaignsByOrganizationFunc(ctx, organizationID, limit)
+	return s.ListCampaignsByOrganizationFunc(ctx, organizationID, archived, limit)
+}
+
+func (s *stubHostRepo) SetCampaignArchived(ctx context.Context, campaignID uuid.UUID, organizationID uuid.UUID, archived bool) (bool, error) {
+	if s.SetCampaignArchivedFunc == nil {
+		return false, nil
+	}
+	return s.SetCampaignArchivedFunc(ctx, campaignID, organizationID, archived)
 }
 
 func (s *stubHostRepo) GetCampaignTargets(ctx context.Context, campaignID uuid.UUID) ([]*osqueryServices.CampaignTarget, error) {
@@ -160,6 +237,13 @@ func (s *stubHostRepo) GetCampaignTargets(ctx context.Context, campaignID uuid.U
 	return s.GetCampaignTargetsFunc(ctx, campaignID)
 }
 
+func (s *stubHostRepo) QueryCampaignResults(ctx context.Context, campaignID uuid.UUID, view osqueryServices.CampaignResultView) (*osqueryServices.CampaignResults, error) {
+	if s.QueryCampaignResultsFunc == nil {
+		return &osqueryServices.CampaignResults{}, nil
+	}
+	return s.QueryCampaignResultsFunc(ctx, campaignID, view)
+}
+
 type mockPublisher struct {
 	mu           sync.Mutex
 	publishErr   error
@@ -406,6 +490,52 @@ func TestConfig(t *testing.T) {
 	}
 }
 
+func TestPing(t *testing.T) {
+	hostID := uuid.New()
+
+	tests := []struct {
+		name       string
+		nodeKey    string
+		pingErr    error
+		wantStatus int
+	}{
+		{name: "known node key", nodeKey: "k1", wantStatus: http.StatusNoContent},
+		{name: "unknown node key", nodeKey: "missing", wantStatus: http.StatusUnauthorized},
+		{name: "no node key", wantStatus: http.StatusUnauthorized},
+		{name: "repository error", nodeKey: "k1", pingErr: errors.New("db"), wantStatus: http.StatusInternalServerError},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var pinged []string
+			repo := &stubHostRepo{}
+			repo.PingFunc = func(_ context.Context, nodeKey string) (*osqueryServices.Host, error) {
+				pinged = append(pinged, nodeKey)
+				if tt.pingErr != nil || nodeKey != "k1" {
+					return nil, tt.pingErr
+				}
+				return &osqueryServices.Host{ID: hostID}, nil
+			}
+
+			h := osquery.NewHandlers(repo, &stubEnrollOrgLookup{}, nil, nil)
+
+			rec := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodPost, "/osquery/ping", nil)
+			if tt.nodeKey != "" {
+				req.Header.Set(osquery.PingNodeKeyHeader, tt.nodeKey)
+			}
+			h.Ping(rec, req)
+
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d, body=%q", rec.Code, tt.wantStatus, rec.Body.String())
+			}
+			if tt.nodeKey == "" && len(pinged) != 0 {
+				t.Fatalf("Ping called without a node key")
+			}
+		})
+	}
+}
+
 func TestLogger_ResultLogs(t *testing.T) {
 	hostID := uuid.New()
 
@@ -646,7 +776,7 @@ func TestDistributedWrite(t *testing.T) {
 	repo.GetByNodeKeyFunc = func(context.Context, string) (*osqueryServices.Host, error) {
 		return &osqueryServices.Host{ID: hostID}, nil
 	}
-	repo.SaveQueryResultsFunc = func(_ context.Context, _ uuid.UUID, queryID uuid.UUID, status string, results json.RawMessage, errorText *string) error {
+	repo.SaveQueryResultsFunc = func(_ context.Context, _ uuid.UUID, queryID uuid.UUID, status string, results json.RawMessage, errorText *string, _ bool) error {
 		calls = append(calls, struct {
 			queryID   uuid.UUID
 			status    string
@@ -708,3 +838,98 @@ func TestDistributedWrite(t *testing.T) {
 		}
 	}
 }
+
+func TestDistributedWrite_BodyTooLarge(t *testing.T) {
+	repo := &stubHostRepo{}
+	repo.GetByNodeKeyFunc = func(context.Context, string) (*osqueryServices.Host, error) {
+		t.Fatal("GetByNodeKey called for an oversized body")
+		return nil, nil
+	}
+
+	h := osquery.NewHandlers(repo, &stubEnrollOrgLookup{}, nil, nil)
+	handler := httpbody.Limit(64)(http.HandlerFunc(h.DistributedWrite))
+
+	body := `{"node_key":"k1","queries":{"q":[{"a":"` + strings.Repeat("b", 128) + `"}]}}`
+	req := httptest.NewRequest(http.MethodPost, "/osquery/distributed_write", strings.NewReader(body))
+	// Unknown length, so the limit is enforced while decoding.
+	req.ContentLength = -1
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusRequestEntityTooLarge {
+		t.Fatalf("status = %d, want 413", rec.Code)
+	}
+}
+
+func TestArchiveCampaign(t *testing.T) {
+	orgID := uuid.New()
+	campaignID := uuid.New()
+	archivedAt := time.Now()
+
+	var gotArchived *bool
+	repo := &stubHostRepo{}
+	repo.SetCampaignArchivedFunc = func(_ context.Context, id uuid.UUID, organizationID uuid.UUID, archived bool) (bool, error) {
+		if id != campaignID || organizationID != orgID {
+			return false, nil
+		}
+		gotArchived = &archived
+		return true, nil
+	}
+	repo.GetCampaignByIDAndOrganizationFunc = func(_ context.Context, id uuid.UUID, _ uuid.UUID) (*osqueryServices.Campaign, error) {
+		return &osqueryServices.Campaign{ID: id, OrganizationID: orgID, ArchivedAt: &archivedAt}, nil
+	}
+
+	h := osquery.NewHandlers(repo, &stubEnrollOrgLookup{}, nil, nil)
+	router := chi.NewRouter()
+	router.Post("/api/v1/campaigns/{id}/archive", h.ArchiveCampaign)
+	post := func(id uuid.UUID) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/api/v1/campaigns/"+id.String()+"/archive", nil)
+		req = req.WithContext(org.SetOrganizationInContext(req.Context(), &orgServices.Organization{ID: orgID}))
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		return rec
+	}
+
+	rec := post(campaignID)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, body = %q", rec.Code, rec.Body.String())
+	}
+	if gotArchived == nil || !*gotArchived {
+		t.Fatalf("archived = %v, want true", gotArchived)
+	}
+	var campaign osqueryServices.Campaign
+	if err := json.Unmarshal(rec.Body.Bytes(), &campaign); err != nil {
+		t.Fatalf("decoding response: %v", err)
+	}
+	if campaign.ArchivedAt == nil {
+		t.Fatalf("response = %q, want archived_at", rec.Body.String())
+	}
+
+	if rec := post(uuid.New()); rec.Code != http.StatusNotFound {
+		t.Fatalf("unknown campaign: status = %d, want 404", rec.Code)
+	}
+}
+
+func TestListCampaigns_Archived(t *testing.T) {
+	var gotArchived []bool
+	repo := &stubHostRepo{}
+	repo.ListCampaignsByOrganizationFunc = func(_ context.Context, _ uuid.UUID, archived bool, _ int) ([]*osqueryServices.Campaign, error) {
+		gotArchived = append(gotArchived, archived)
+		return nil, nil
+	}
+
+	h := osquery.NewHandlers(repo, &stubEnrollOrgLookup{}, nil, nil)
+	for _, target := range []string{"/api/v1/campaigns", "/api/v1/campaigns?archived=true"} {
+		req := httptest.NewRequest(http.MethodGet, target, nil)
+		req = req.WithContext(org.SetOrganizationInContext(req.Context(), &orgServices.Organization{ID: uuid.New()}))
+		rec := httptest.NewRecorder()
+		h.ListCampaigns(rec, req)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("%s: status = %d", target, rec.Code)
+		}
+	}
+
+	if len(gotArchived) != 2 || gotArchived[0] || !gotArchived[1] {
+		t.Fatalf("archived = %v, want [false true]", gotArchived)
+	}
+}
@@ -0,0 +1,198 @@
+package osquery
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/cavenine/queryops/features/osquery/services"
+	"github.com/cavenine/queryops/internal/pubsub"
+)
+
+const (
+	defaultHostCacheTTL  = 30 * time.Second
+	maxHostCacheEntries  = 50_000
+	hostCacheSweepFactor = 2
+)
+
+// hostCache is a read-through TTL cache in front of GetByNodeKey. Every
+// osquery check-in resolves its node key, so caching the lookup removes a
+// database round trip from the hot path.
+//
+// Entries are dropped when a host (re-)enrolls, both locally and on other
+// instances via pubsub.HostEnrolledEvent, and when hosts are deleted via
+// pubsub.HostDeletedEvent. Without pubsub a deleted host stays cached until
+// its entry expires.
+type hostCache struct {
+	hostRepository
+
+	ttl time.Duration
+	now func() time.Time
+
+	mu      sync.RWMutex
+	entries map[string]hostCacheEntry
+}
+
+type hostCacheEntry struct {
+	host      services.Host
+	expiresAt time.Time
+}
+
+func newHostCache(repo hostRepository, ttl time.Duration) *hostCache {
+	if ttl <= 0 {
+		ttl = defaultHostCacheTTL
+	}
+	return &hostCache{
+		hostRepository: repo,
+		ttl:            ttl,
+		now:            time.Now,
+		entries:        make(map[string]hostCacheEntry),
+	}
+}
+
+func (c *hostCache) GetByNodeKey(ctx context.Context, nodeKey string) (*services.Host, error) {
+	now := c.now()
+
+	c.mu.RLock()
+	entry, ok := c.entries[nodeKey]
+	c.mu.RUnlock()
+	if ok && now.Before(entry.expiresAt) {
+		host := entry.host
+		return &host, nil
+	}
+
+	host, err := c.hostRepository.GetByNodeKey(ctx, nodeKey)
+	if err != nil || host == nil {
+		// Misses are not cached so a freshly issued node key is usable at once.
+		return host, err
+	}
+
+	c.mu.Lock()
+	if len(c.entries) >= maxHostCacheEntries {
+		c.sweepLocked(now)
+	}
+	c.entries[nodeKey] = hostCacheEntry{host: *host, expiresAt: now.Add(c.ttl)}
+	c.mu.Unlock()
+
+	return host, nil
+}
+
+func (c *hostCache) Enroll(ctx context.Context, hostIdentifier string, hostDetails json.RawMessage, organizationID uuid.UUID) (string, error) {
+	nodeKey, err := c.hostRepository.Enroll(ctx, hostIdentifier, hostDetails, organizationID)
+	if err != nil {
+		return "", err
+	}
+	c.invalidateHost(organizationID, hostIdentifier)
+	return nodeKey, nil
+}
+
+// invalidateHost drops any cached entries for the host, whichever node key
+// they were stored under.
+func (c *hostCache) invalidateHost(organizationID uuid.UUID, hostIdentifier string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for nodeKey, entry := range c.entries {
+		if entry.host.OrganizationID == organizationID && entry.host.HostIdentifier == hostIdentifier {
+			delete(c.entries, nodeKey)
+		}
+	}
+}
+
+// invalidateHostIDs drops cached entries for the organization's given hosts.
+func (c *hostCache) invalidateHostIDs(organizationID uuid.UUID, hostIDs []uuid.UUID) {
+	ids := make(map[uuid.UUID]bool, len(hostIDs))
+	for _, id := range hostIDs {
+		ids[id] = true
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for nodeKey, entry := range c.entries {
+		if entry.host.OrganizationID == organizationID && ids[entry.host.ID] {
+			delete(c.entries, nodeKey)
+		}
+	}
+}
+
+// sweepLocked removes expired entries. If the cache is still too large it is
+// reset rather than growing without bound. c.mu must be held.
+func (c *hostCache) sweepLocked(now time.Time) {
+	for nodeKey, entry := range c.entries {
+		if !now.Before(entry.expiresAt) {
+			delete(c.entries, nodeKey)
+		}
+	}
+	if len(c.entries)*hostCacheSweepFactor > maxHostCacheEntries {
+		c.entries = make(map[string]hostCacheEntry)
+	}
+}
+
+// listenForInvalidations invalidates cached hosts as enrollment and deletion
+// events arrive from any instance. It returns once the subscriptions are
+// established; the listener runs until ctx is cancelled.
+func (c *hostCache) listenForInvalidations(ctx context.Context, ps *pubsub.PubSub) error {
+	subscriber, err := ps.NewSubscriber(ctx)
+	if err != nil {
+		return err
+	}
+
+	enrollments, err := subscriber.Subscribe(ctx, pubsub.TopicHostEnrollments)
+	if err != nil {
+		_ = subscriber.Close()
+		return err
+	}
+	deletions, err := subscriber.Subscribe(ctx, pubsub.TopicHostDeletions)
+	if err != nil {
+		_ = subscriber.Close()
+		return err
+	}
+
+	go func() {
+		defer func() {
+			_ = subscriber.Close()
+		}()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case msg := <-enrollments:
+				if msg == nil {
+					return
+				}
+
+				event, err := pubsub.ParseHostEnrolledEvent(msg)
+				if err != nil {
+					slog.ErrorContext(ctx, "failed to parse host enrolled event", "error", err)
+					msg.Ack()
+					continue
+				}
+
+				c.invalidateHost(event.OrganizationID, event.HostIdentifier)
+				msg.Ack()
+			case msg := <-deletions:
+				if msg == nil {
+					return
+				}
+
+				event, err := pubsub.ParseHostDeletedEvent(msg)
+				if err != nil {
+					slog.ErrorContext(ctx, "failed to parse host deleted event", "error", err)
+					msg.Ack()
+					continue
+				}
+
+				c.invalidateHostIDs(event.OrganizationID, event.HostIDs)
+				msg.Ack()
+			}
+		}
+	}()
+
+	return nil
+}
@@ -0,0 +1,118 @@
+package osquery
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/cavenine/queryops/features/osquery/services"
+)
+
+type countingHostRepo struct {
+	hostRepository
+
+	hosts   map[string]*services.Host
+	lookups int
+}
+
+func (r *countingHostRepo) GetByNodeKey(_ context.Context, nodeKey string) (*services.Host, error) {
+	r.lookups++
+	return r.hosts[nodeKey], nil
+}
+
+func (r *countingHostRepo) Enroll(_ context.Context, _ string, _ json.RawMessage, _ uuid.UUID) (string, error) {
+	return uuid.NewString(), nil
+}
+
+func TestHostCache_GetByNodeKey(t *testing.T) {
+	orgID := uuid.New()
+	repo := &countingHostRepo{hosts: map[string]*services.Host{
+		"nk": {ID: uuid.New(), OrganizationID: orgID, HostIdentifier: "host-a"},
+	}}
+
+	now := time.Now()
+	cache := newHostCache(repo, time.Minute)
+	cache.now = func() time.Time { return now }
+
+	for range 3 {
+		host, err := cache.GetByNodeKey(context.Background(), "nk")
+		if err != nil {
+			t.Fatalf("GetByNodeKey: %v", err)
+		}
+		if host == nil || host.HostIdentifier != "host-a" {
+			t.Fatalf("host = %+v, want host-a", host)
+		}
+	}
+	if repo.lookups != 1 {
+		t.Fatalf("lookups = %d, want 1", repo.lookups)
+	}
+
+	now = now.Add(2 * time.Minute)
+	if _, err := cache.GetByNodeKey(context.Background(), "nk"); err != nil {
+		t.Fatalf("GetByNodeKey: %v", err)
+	}
+	if repo.lookups != 2 {
+		t.Fatalf("lookups after expiry = %d, want 2", repo.lookups)
+	}
+
+	if _, err := cache.Enroll(context.Background(), "host-a", nil, orgID); err != nil {
+		t.Fatalf("Enroll: %v", err)
+	}
+	if _, err := cache.GetByNodeKey(context.Background(), "nk"); err != nil {
+		t.Fatalf("GetByNodeKey: %v", err)
+	}
+	if repo.lookups != 3 {
+		t.Fatalf("lookups after enroll = %d, want 3", repo.lookups)
+	}
+}
+
+func TestHostCache_DoesNotCacheMisses(t *testing.T) {
+	repo := &countingHostRepo{hosts: map[string]*services.Host{}}
+	cache := newHostCache(repo, time.Minute)
+
+	for range 2 {
+		host, err := cache.GetByNodeKey(context.Background(), "unknown")
+		if err != nil {
+			t.Fatalf("GetByNodeKey: %v", err)
+		}
+		if host != nil {
+			t.Fatalf("host = %+v, want nil", host)
+		}
+	}
+	if repo.lookups != 2 {
+		t.Fatalf("lookups = %d, want 2", repo.lookups)
+	}
+}
+
+func TestHostCache_InvalidateHostIDs(t *testing.T) {
+	orgID := uuid.New()
+	deleted := &services.Host{ID: uuid.New(), OrganizationID: orgID, HostIdentifier: "host-a"}
+	kept := &services.Host{ID: uuid.New(), OrganizationID: orgID, HostIdentifier: "host-b"}
+	repo := &countingHostRepo{hosts: map[string]*services.Host{"nk-a": deleted, "nk-b": kept}}
+	cache := newHostCache(repo, time.Minute)
+
+	for _, nodeKey := range []string{"nk-a", "nk-b"} {
+		if _, err := cache.GetByNodeKey(context.Background(), nodeKey); err != nil {
+			t.Fatalf("GetByNodeKey: %v", err)
+		}
+	}
+
+	// Another organization's event for the same id is ignored.
+	cache.invalidateHostIDs(uuid.New(), []uuid.UUID{deleted.ID})
+	cache.invalidateHostIDs(orgID, []uuid.UUID{deleted.ID})
+	delete(repo.hosts, "nk-a")
+
+	host, err := cache.GetByNodeKey(context.Background(), "nk-a")
+	if err != nil || host != nil {
+		t.Fatalf("deleted host = %+v, %v; want it no longer cached", host, err)
+	}
+	if _, err := cache.GetByNodeKey(context.Background(), "nk-b"); err != nil {
+		t.Fatalf("GetByNodeKey: %v", err)
+	}
+	if repo.lookups != 3 {
+		t.Fatalf("lookups = %d, want 3 (only the deleted host looked up again)", repo.lookups)
+	}
+}
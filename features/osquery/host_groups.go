@@ -0,0 +1,434 @@
+package osquery
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+	"github.com/starfederation/datastar-go/datastar"
+
+	"github.com/cavenine/queryops/features/auth"
+	org "github.com/cavenine/queryops/features/organization"
+	orgServices "github.com/cavenine/queryops/features/organization/services"
+	"github.com/cavenine/queryops/features/osquery/pages"
+	"github.com/cavenine/queryops/features/osquery/services"
+	"github.com/cavenine/queryops/internal/validate"
+)
+
+// hostGroupRepository manages static host groups and the bulk actions the
+// hosts and groups pages offer.
+type hostGroupRepository interface {
+	ListHostGroups(ctx context.Context, organizationID uuid.UUID) ([]*services.HostGroup, error)
+	CreateHostGroup(ctx context.Context, organizationID uuid.UUID, name, description string) (*services.HostGroup, error)
+	DeleteHostGroup(ctx context.Context, groupID, organizationID uuid.UUID) (bool, error)
+	AddHostsToGroup(ctx context.Context, groupID, organizationID uuid.UUID, hostIDs []uuid.UUID) (int, error)
+	ListHostGroupMembers(ctx context.Context, groupID, organizationID uuid.UUID) ([]uuid.UUID, error)
+	QueueGroupQuery(ctx context.Context, organizationID uuid.UUID, createdBy *int, name *string, description *string, query string, groupID uuid.UUID, opts services.CampaignOptions) (uuid.UUID, error)
+	ListConfigs(ctx context.Context) ([]services.OsqueryConfig, error)
+	AssignConfig(ctx context.Context, organizationID uuid.UUID, hostIDs []uuid.UUID, configID *int) (int, error)
+	DeleteHosts(ctx context.Context, organizationID uuid.UUID, hostIDs []uuid.UUID) (int, error)
+}
+
+// bulkSignals are the hosts page signals bulk actions read.
+type bulkSignals struct {
+	Selected   []string `json:"selected"`
+	BulkGroup  string   `json:"bulkGroup"`
+	BulkConfig string   `json:"bulkConfig"`
+	Query      string   `json:"query"`
+}
+
+// hostsPageFields are the signals HostsPage shows errors for, and
+// hostGroupsPageFields those HostGroupsPage does.
+var (
+	hostsPageFields      = []string{"query", "bulkGroup", "bulkConfig"}
+	hostGroupsPageFields = []string{"query"}
+)
+
+func (s bulkSignals) hostIDs() ([]uuid.UUID, error) {
+	ids := make([]uuid.UUID, 0, len(s.Selected))
+	for _, v := range s.Selected {
+		id, err := uuid.Parse(v)
+		if err != nil {
+			return nil, fmt.Errorf("invalid host id %q", v)
+		}
+		ids = append(ids, id)
+	}
+	if len(ids) == 0 {
+		return nil, errors.New("no hosts selected")
+	}
+	return ids, nil
+}
+
+// parseConfigID parses a config select value; empty means the default
+// config.
+func parseConfigID(s string) (*int, error) {
+	if s == "" {
+		return nil, nil
+	}
+	id, err := strconv.Atoi(s)
+	if err != nil {
+		return nil, fmt.Errorf("invalid config id %q", s)
+	}
+	return &id, nil
+}
+
+func (h *Handlers) HostGroupsPage(w http.ResponseWriter, r *http.Request) {
+	h.renderHostGroups(w, r, http.StatusOK, "", nil)
+}
+
+// renderHostGroups renders the host groups page. groupError is shown above
+// the new group form and fields beside its inputs.
+func (h *Handlers) renderHostGroups(w http.ResponseWriter, r *http.Request, status int, groupError string, fields validate.Errors) {
+	ctx := r.Context()
+	activeOrg := org.GetOrganizationFromContext(ctx)
+	if activeOrg == nil {
+		slog.Error("missing active organization in context")
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+
+	groups, err := h.groups.ListHostGroups(ctx, activeOrg.ID)
+	if err != nil {
+		slog.ErrorContext(ctx, "failed to list host groups", "error", err)
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+	configs, err := h.groups.ListConfigs(ctx)
+	if err != nil {
+		slog.ErrorContext(ctx, "failed to list osquery configs", "error", err)
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(status)
+	pages.HostGroupsPage(groups, configs, groupError, fields).Render(ctx, w)
+}
+
+func (h *Handlers) CreateHostGroup(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	activeOrg := org.GetOrganizationFromContext(ctx)
+	if activeOrg == nil {
+		slog.Error("missing active organization in context")
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+
+	if err := r.ParseForm(); err != nil {
+		h.renderHostGroups(w, r, http.StatusUnprocessableEntity, "Invalid form data", nil)
+		return
+	}
+
+	fields := validate.Errors{}
+	fields.Field("name", strings.TrimSpace(r.PostForm.Get("name")), validate.Required())
+	if len(fields) > 0 {
+		h.renderHostGroups(w, r, http.StatusUnprocessableEntity, "", fields)
+		return
+	}
+
+	_, err := h.groups.CreateHostGroup(ctx, activeOrg.ID, r.PostForm.Get("name"), r.PostForm.Get("description"))
+	if errors.Is(err, services.ErrDuplicateHostGroup) {
+		h.renderHostGroups(w, r, http.StatusUnprocessableEntity, "", validate.Errors{"name": "Already in use"})
+		return
+	}
+	if err != nil {
+		slog.ErrorContext(ctx, "failed to create host group", "error", err)
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+
+	http.Redirect(w, r, "/hosts/groups", http.StatusSeeOther)
+}
+
+func (h *Handlers) DeleteHostGroup(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	activeOrg := org.GetOrganizationFromContext(ctx)
+	if activeOrg == nil {
+		slog.Error("missing active organization in context")
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+
+	groupID, err := uuid.Parse(chi.URLParam(r, "id"))
+	if err != nil {
+		http.Error(w, "invalid group id", http.StatusBadRequest)
+		return
+	}
+
+	deleted, err := h.groups.DeleteHostGroup(ctx, groupID, activeOrg.ID)
+	if err != nil {
+		slog.ErrorContext(ctx, "failed to delete host group", "error", err, "group_id", groupID)
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+	if !deleted {
+		http.Error(w, "host group not found", http.StatusNotFound)
+		return
+	}
+
+	http.Redirect(w, r, "/hosts/groups", http.StatusSeeOther)
+}
+
+// AssignHostGroupConfig assigns an osquery config to the group's current
+// members.
+func (h *Handlers) AssignHostGroupConfig(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	activeOrg := org.GetOrganizationFromContext(ctx)
+	if activeOrg == nil {
+		slog.Error("missing active organization in context")
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+
+	groupID, err := uuid.Parse(chi.URLParam(r, "id"))
+	if err != nil {
+		http.Error(w, "invalid group id", http.StatusBadRequest)
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		h.renderHostGroups(w, r, http.StatusUnprocessableEntity, "Invalid form data", nil)
+		return
+	}
+	configID, err := parseConfigID(r.PostForm.Get("config_id"))
+	if err != nil {
+		// The select only offers real configs; there is no field to mark.
+		h.renderHostGroups(w, r, http.StatusUnprocessableEntity, "Choose a config", nil)
+		return
+	}
+
+	hostIDs, err := h.groups.ListHostGroupMembers(ctx, groupID, activeOrg.ID)
+	if err != nil {
+		slog.ErrorContext(ctx, "failed to list host group members", "error", err, "group_id", groupID)
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+	if len(hostIDs) > 0 {
+		if _, err := h.groups.AssignConfig(ctx, activeOrg.ID, hostIDs, configID); err != nil {
+			slog.ErrorContext(ctx, "failed to assign config", "error", err, "group_id", groupID)
+			http.Error(w, "internal error", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	http.Redirect(w, r, "/hosts/groups", http.StatusSeeOther)
+}
+
+// RunHostGroupQuery starts a live query against the group's members.
+func (h *Handlers) RunHostGroupQuery(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	activeOrg := org.GetOrganizationFromContext(ctx)
+	if activeOrg == nil {
+		slog.Error("missing active organization in context")
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+
+	groupID, err := uuid.Parse(chi.URLParam(r, "id"))
+	if err != nil {
+		http.Error(w, "invalid group id", http.StatusBadRequest)
+		return
+	}
+
+	var signals bulkSignals
+	if err := datastar.ReadSignals(r, &signals); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	query := strings.TrimSpace(signals.Query)
+	fields := validate.Errors{}
+	fields.Field("query", query, validate.Required())
+	if len(fields) > 0 {
+		patchFormErrors(w, r, hostGroupsPageFields, fields)
+		return
+	}
+
+	campaignID, err := h.queueGroupQuery(ctx, activeOrg.ID, createdByFromContext(ctx), nil, nil, query, groupID, services.CampaignOptions{})
+	if !h.groupQueryQueued(w, r, campaignID, err) {
+		return
+	}
+
+	sse := datastar.NewSSE(w, r)
+	_ = sse.ExecuteScript(fmt.Sprintf("window.location = '/campaigns/%s'", campaignID.String()))
+}
+
+// BulkAddToGroup adds the selected hosts to the chosen group.
+func (h *Handlers) BulkAddToGroup(w http.ResponseWriter, r *http.Request) {
+	h.bulk(w, r, func(ctx context.Context, orgID uuid.UUID, hostIDs []uuid.UUID, signals bulkSignals, fields validate.Errors) (string, int) {
+		groupID, err := uuid.Parse(signals.BulkGroup)
+		if err != nil {
+			fields.Add("bulkGroup", "Choose a group")
+			return "", 0
+		}
+		if _, err := h.groups.AddHostsToGroup(ctx, groupID, orgID, hostIDs); err != nil {
+			slog.ErrorContext(ctx, "failed to add hosts to group", "error", err, "group_id", groupID)
+			return "internal error", http.StatusInternalServerError
+		}
+		return "", 0
+	})
+}
+
+// BulkAssignConfig assigns the chosen osquery config to the selected hosts.
+func (h *Handlers) BulkAssignConfig(w http.ResponseWriter, r *http.Request) {
+	h.bulk(w, r, func(ctx context.Context, orgID uuid.UUID, hostIDs []uuid.UUID, signals bulkSignals, fields validate.Errors) (string, int) {
+		configID, err := parseConfigID(signals.BulkConfig)
+		if err != nil {
+			fields.Add("bulkConfig", "Choose a config")
+			return "", 0
+		}
+		if _, err := h.groups.AssignConfig(ctx, orgID, hostIDs, configID); err != nil {
+			slog.ErrorContext(ctx, "failed to assign config", "error", err)
+			return "internal error", http.StatusInternalServerError
+		}
+		return "", 0
+	})
+}
+
+// BulkDeleteHosts deletes the selected hosts.
+func (h *Handlers) BulkDeleteHosts(w http.ResponseWriter, r *http.Request) {
+	h.bulk(w, r, func(ctx context.Context, orgID uuid.UUID, hostIDs []uuid.UUID, _ bulkSignals, _ validate.Errors) (string, int) {
+		n, err := h.groups.DeleteHosts(ctx, orgID, hostIDs)
+		if err != nil {
+			slog.ErrorContext(ctx, "failed to delete hosts", "error", err)
+			return "internal error", http.StatusInternalServerError
+		}
+		slog.InfoContext(ctx, "deleted hosts", "organization_id", orgID, "count", n)
+		h.publishHostDeletedEvent(ctx, orgID, hostIDs)
+		return "", 0
+	})
+}
+
+// BulkRunQuery starts a live query against the selected hosts.
+func (h *Handlers) BulkRunQuery(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	activeOrg := org.GetOrganizationFromContext(ctx)
+	if activeOrg == nil {
+		slog.Error("missing active organization in context")
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+
+	var signals bulkSignals
+	if err := datastar.ReadSignals(r, &signals); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	hostIDs, err := signals.hostIDs()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	query := strings.TrimSpace(signals.Query)
+	fields := validate.Errors{}
+	fields.Field("query", query, validate.Required())
+	if len(fields) > 0 {
+		patchFormErrors(w, r, hostsPageFields, fields)
+		return
+	}
+
+	// QueueQuery trusts its host IDs; keep them to this organization.
+	hosts, err := h.repo.ListByOrganization(ctx, activeOrg.ID)
+	if err != nil {
+		slog.ErrorContext(ctx, "failed to list hosts", "error", err)
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+	owned := make(map[uuid.UUID]bool, len(hosts))
+	for _, host := range hosts {
+		owned[host.ID] = true
+	}
+	for _, id := range hostIDs {
+		if !owned[id] {
+			http.Error(w, "host not found", http.StatusNotFound)
+			return
+		}
+	}
+
+	campaignID, err := h.queueQuery(ctx, activeOrg.ID, createdByFromContext(ctx), nil, nil, query, hostIDs, services.CampaignOptions{})
+	if err != nil {
+		if orgServices.IsQuotaExceeded(err) {
+			http.Error(w, err.Error(), http.StatusTooManyRequests)
+			return
+		}
+		slog.ErrorContext(ctx, "failed to create campaign", "error", err)
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+
+	sse := datastar.NewSSE(w, r)
+	_ = sse.ExecuteScript(fmt.Sprintf("window.location = '/campaigns/%s'", campaignID.String()))
+}
+
+// bulk reads the hosts page selection, applies action to it, and reloads the
+// page. action records problems with the signals it reads in fields, which
+// are shown on the page, and returns a message and status on other failures.
+func (h *Handlers) bulk(w http.ResponseWriter, r *http.Request, action func(ctx context.Context, orgID uuid.UUID, hostIDs []uuid.UUID, signals bulkSignals, fields validate.Errors) (string, int)) {
+	ctx := r.Context()
+	activeOrg := org.GetOrganizationFromContext(ctx)
+	if activeOrg == nil {
+		slog.Error("missing active organization in context")
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+
+	var signals bulkSignals
+	if err := datastar.ReadSignals(r, &signals); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	hostIDs, err := signals.hostIDs()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	fields := validate.Errors{}
+	msg, status := action(ctx, activeOrg.ID, hostIDs, signals, fields)
+	if len(fields) > 0 {
+		patchFormErrors(w, r, hostsPageFields, fields)
+		return
+	}
+	if status != 0 {
+		http.Error(w, msg, status)
+		return
+	}
+
+	sse := datastar.NewSSE(w, r)
+	_ = sse.ExecuteScript("window.location.reload()")
+}
+
+// queueGroupQuery creates a campaign for a host group after checking the
+// organization's daily campaign quota.
+func (h *Handlers) queueGroupQuery(ctx context.Context, organizationID uuid.UUID, createdBy *int, name, description *string, query string, groupID uuid.UUID, opts services.CampaignOptions) (uuid.UUID, error) {
+	return h.campaigns().QueueGroupQuery(ctx, organizationID, createdBy, name, description, query, groupID, opts)
+}
+
+// groupQueryQueued writes the error response for a failed queueGroupQuery and
+// reports whether the campaign was created.
+func (h *Handlers) groupQueryQueued(w http.ResponseWriter, r *http.Request, campaignID uuid.UUID, err error) bool {
+	switch {
+	case orgServices.IsQuotaExceeded(err):
+		http.Error(w, err.Error(), http.StatusTooManyRequests)
+	case errors.Is(err, services.ErrEmptyHostGroup):
+		http.Error(w, "no target hosts", http.StatusBadRequest)
+	case err != nil:
+		slog.ErrorContext(r.Context(), "failed to create group campaign", "error", err)
+		http.Error(w, "internal error", http.StatusInternalServerError)
+	case campaignID == uuid.Nil:
+		http.Error(w, "host group not found", http.StatusNotFound)
+	default:
+		return true
+	}
+	return false
+}
+
+func createdByFromContext(ctx context.Context) *int {
+	if user := auth.GetUserFromContext(ctx); user != nil {
+		return &user.ID
+	}
+	return nil
+}
@@ -0,0 +1,198 @@
+package osquery
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ThreeDotsLabs/watermill/message"
+	"github.com/google/uuid"
+
+	org "github.com/cavenine/queryops/features/organization"
+	orgServices "github.com/cavenine/queryops/features/organization/services"
+	"github.com/cavenine/queryops/features/osquery/services"
+	"github.com/cavenine/queryops/internal/pubsub"
+	"github.com/cavenine/queryops/internal/validate"
+)
+
+// groupTestHostRepo satisfies hostRepository for handlers that don't use it.
+type groupTestHostRepo struct {
+	hostRepository
+}
+
+type recordingPublisher struct {
+	topics   []string
+	messages []*message.Message
+}
+
+func (p *recordingPublisher) Publish(topic string, messages ...*message.Message) error {
+	for _, msg := range messages {
+		p.topics = append(p.topics, topic)
+		p.messages = append(p.messages, msg)
+	}
+	return nil
+}
+
+func (p *recordingPublisher) Close() error { return nil }
+
+type recordingGroupRepo struct {
+	hostGroupRepository
+
+	deletedOrg    uuid.UUID
+	deletedHosts  []uuid.UUID
+	assignedHosts []uuid.UUID
+}
+
+func (r *recordingGroupRepo) DeleteHosts(_ context.Context, organizationID uuid.UUID, hostIDs []uuid.UUID) (int, error) {
+	r.deletedOrg = organizationID
+	r.deletedHosts = hostIDs
+	return len(hostIDs), nil
+}
+
+func TestBulkDeleteHosts(t *testing.T) {
+	orgID := uuid.New()
+	hostID := uuid.New()
+
+	publisher := &recordingPublisher{}
+	post := func(body string) (*httptest.ResponseRecorder, *recordingGroupRepo) {
+		repo := &recordingGroupRepo{}
+		h := NewHandlers(&groupTestHostRepo{}, nil, publisher, nil)
+		h.groups = repo
+
+		req := httptest.NewRequest(http.MethodPost, "/hosts/bulk/delete", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		req = req.WithContext(org.SetOrganizationInContext(req.Context(), &orgServices.Organization{ID: orgID}))
+		rec := httptest.NewRecorder()
+		h.BulkDeleteHosts(rec, req)
+		return rec, repo
+	}
+
+	rec, repo := post(`{"selected":["` + hostID.String() + `"]}`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, body = %q", rec.Code, rec.Body.String())
+	}
+	if repo.deletedOrg != orgID || len(repo.deletedHosts) != 1 || repo.deletedHosts[0] != hostID {
+		t.Fatalf("DeleteHosts(%s, %v)", repo.deletedOrg, repo.deletedHosts)
+	}
+	if len(publisher.topics) != 1 || publisher.topics[0] != pubsub.TopicHostDeletions {
+		t.Fatalf("published to %v, want one host deletion event", publisher.topics)
+	}
+	event, err := pubsub.ParseHostDeletedEvent(publisher.messages[0])
+	if err != nil || event.OrganizationID != orgID || len(event.HostIDs) != 1 || event.HostIDs[0] != hostID {
+		t.Fatalf("host deleted event = %+v, %v", event, err)
+	}
+
+	for _, body := range []string{`{"selected":[]}`, `{"selected":["nope"]}`} {
+		rec, repo := post(body)
+		if rec.Code != http.StatusBadRequest {
+			t.Fatalf("%s: status = %d, want 400", body, rec.Code)
+		}
+		if repo.deletedHosts != nil {
+			t.Fatalf("%s: hosts deleted", body)
+		}
+	}
+}
+
+func TestCreateCampaign_GroupAndHostsConflict(t *testing.T) {
+	h := NewHandlers(&groupTestHostRepo{}, nil, nil, nil)
+	h.groups = &recordingGroupRepo{}
+
+	body := `{"query":"select 1","group_id":"` + uuid.NewString() + `","host_ids":["` + uuid.NewString() + `"]}`
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/queries/run", strings.NewReader(body))
+	req = req.WithContext(org.SetOrganizationInContext(req.Context(), &orgServices.Organization{ID: uuid.New()}))
+	rec := httptest.NewRecorder()
+	h.CreateCampaign(rec, req)
+
+	if rec.Code != http.StatusBadRequest {
+		t.Fatalf("status = %d, want 400", rec.Code)
+	}
+}
+
+// fanoutHostRepo records the options CreateCampaign queues a campaign with.
+type fanoutHostRepo struct {
+	hostRepository
+
+	opts *services.CampaignOptions
+}
+
+func (r *fanoutHostRepo) GetByIDAndOrganization(_ context.Context, id uuid.UUID, _ uuid.UUID) (*services.Host, error) {
+	return &services.Host{ID: id}, nil
+}
+
+func (r *fanoutHostRepo) QueueQuery(_ context.Context, _ uuid.UUID, _ *int, _, _ *string, _ string, _ []uuid.UUID, opts services.CampaignOptions) (uuid.UUID, error) {
+	r.opts = &opts
+	return uuid.New(), nil
+}
+
+func TestCreateCampaign_FanoutOptions(t *testing.T) {
+	hostID := uuid.New()
+
+	post := func(body string) (*httptest.ResponseRecorder, *services.CampaignOptions) {
+		repo := &fanoutHostRepo{}
+		h := NewHandlers(repo, nil, nil, nil)
+
+		req := httptest.NewRequest(http.MethodPost, "/api/v1/queries/run", strings.NewReader(body))
+		req = req.WithContext(org.SetOrganizationInContext(req.Context(), &orgServices.Organization{ID: uuid.New()}))
+		rec := httptest.NewRecorder()
+		h.CreateCampaign(rec, req)
+		return rec, repo.opts
+	}
+
+	rec, opts := post(`{"query":"select 1","host_ids":["` + hostID.String() + `"],"fanout_limit":5,"fanout_interval_seconds":60}`)
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("status = %d, body = %q", rec.Code, rec.Body.String())
+	}
+	if opts == nil || opts.FanoutLimit != 5 || opts.FanoutInterval != time.Minute {
+		t.Fatalf("options = %+v, want 5 per minute", opts)
+	}
+
+	for body, field := range map[string]string{
+		`{"query":"select 1","host_ids":["` + hostID.String() + `"],"fanout_limit":-1}`:                              "fanout_limit",
+		`{"query":"select 1","host_ids":["` + hostID.String() + `"],"fanout_limit":5,"fanout_interval_seconds":-30}`: "fanout_interval_seconds",
+		`{"query":"","host_ids":["` + hostID.String() + `"]}`:                                                        "query",
+	} {
+		rec, opts := post(body)
+		if rec.Code != http.StatusBadRequest {
+			t.Fatalf("%s: status = %d, want 400", body, rec.Code)
+		}
+		if opts != nil {
+			t.Fatalf("%s: campaign queued", body)
+		}
+		var resp validate.Response
+		if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil || !resp.Fields.Has(field) {
+			t.Fatalf("%s: body = %q, want an error for %s", body, rec.Body.String(), field)
+		}
+	}
+}
+
+func (r *recordingGroupRepo) AssignConfig(_ context.Context, _ uuid.UUID, hostIDs []uuid.UUID, _ *int) (int, error) {
+	r.assignedHosts = hostIDs
+	return len(hostIDs), nil
+}
+
+func TestBulkAssignConfig_InvalidConfigPatchesFieldError(t *testing.T) {
+	repo := &recordingGroupRepo{}
+	h := NewHandlers(&groupTestHostRepo{}, nil, nil, nil)
+	h.groups = repo
+
+	body := `{"selected":["` + uuid.NewString() + `"],"bulkConfig":"nope"}`
+	req := httptest.NewRequest(http.MethodPost, "/hosts/bulk/config", strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	req = req.WithContext(org.SetOrganizationInContext(req.Context(), &orgServices.Organization{ID: uuid.New()}))
+	rec := httptest.NewRecorder()
+	h.BulkAssignConfig(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, body = %q", rec.Code, rec.Body.String())
+	}
+	if !strings.Contains(rec.Body.String(), `"bulkConfig":"Choose a config"`) {
+		t.Fatalf("body = %q, want the bulkConfig error patched", rec.Body.String())
+	}
+	if repo.assignedHosts != nil {
+		t.Fatal("config assigned despite the invalid choice")
+	}
+}
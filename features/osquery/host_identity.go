@@ -0,0 +1,120 @@
+package osquery
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+
+	org "github.com/cavenine/queryops/features/organization"
+	"github.com/cavenine/queryops/features/osquery/services"
+)
+
+// hostIdentityRepository resolves hosts flagged as more than one machine
+// enrolling under the same host identifier.
+type hostIdentityRepository interface {
+	ListHostIdentities(ctx context.Context, hostID, organizationID uuid.UUID) ([]services.HostIdentity, error)
+	SplitHostIdentity(ctx context.Context, hostID, organizationID uuid.UUID) ([]uuid.UUID, error)
+	DismissIdentityConflict(ctx context.Context, hostID, organizationID uuid.UUID) (bool, error)
+}
+
+// hostIdentities returns the machines that enrolled as a flagged host, for
+// its details page. Hosts that aren't flagged have none to show.
+func (h *Handlers) hostIdentities(ctx context.Context, host *services.Host) []services.HostIdentity {
+	if h.identities == nil || host.IdentityConflictAt == nil {
+		return nil
+	}
+	identities, err := h.identities.ListHostIdentities(ctx, host.ID, host.OrganizationID)
+	if err != nil {
+		slog.ErrorContext(ctx, "failed to list host identities", "error", err, "host_id", host.ID)
+	}
+	return identities
+}
+
+// SplitHostIdentity gives each machine enrolling as the host its own host
+// record. The machines enroll again, each into its own record, when they
+// next check in.
+func (h *Handlers) SplitHostIdentity(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	activeOrg := org.GetOrganizationFromContext(ctx)
+	if activeOrg == nil {
+		slog.Error("missing active organization in context")
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+
+	hostID, err := uuid.Parse(chi.URLParam(r, "id"))
+	if err != nil {
+		http.Error(w, "invalid host id", http.StatusBadRequest)
+		return
+	}
+	host, err := h.repo.GetByIDAndOrganization(ctx, hostID, activeOrg.ID)
+	if err != nil {
+		slog.ErrorContext(ctx, "failed to get host", "error", err)
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+	if host == nil {
+		http.Error(w, "host not found", http.StatusNotFound)
+		return
+	}
+
+	created, err := h.identities.SplitHostIdentity(ctx, hostID, activeOrg.ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, services.ErrHostNotFound):
+			http.Error(w, "host not found", http.StatusNotFound)
+		case errors.Is(err, services.ErrNoIdentityConflict):
+			http.Error(w, err.Error(), http.StatusConflict)
+		default:
+			slog.ErrorContext(ctx, "failed to split host", "error", err, "host_id", hostID)
+			http.Error(w, "internal error", http.StatusInternalServerError)
+		}
+		return
+	}
+	slog.InfoContext(ctx, "host split by hardware uuid",
+		"organization_id", activeOrg.ID,
+		"host_id", hostID,
+		"host_identifier", host.HostIdentifier,
+		"created", created,
+	)
+	// The host's node keys were revoked; drop them from every instance's
+	// cache and show the new hosts.
+	h.publishHostEnrolledEvent(ctx, activeOrg.ID, host.HostIdentifier)
+
+	http.Redirect(w, r, "/hosts/"+hostID.String(), http.StatusSeeOther)
+}
+
+// DismissHostIdentityConflict clears a host's identity conflict flag, for a
+// host flagged by mistake.
+func (h *Handlers) DismissHostIdentityConflict(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	activeOrg := org.GetOrganizationFromContext(ctx)
+	if activeOrg == nil {
+		slog.Error("missing active organization in context")
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+
+	hostID, err := uuid.Parse(chi.URLParam(r, "id"))
+	if err != nil {
+		http.Error(w, "invalid host id", http.StatusBadRequest)
+		return
+	}
+
+	found, err := h.identities.DismissIdentityConflict(ctx, hostID, activeOrg.ID)
+	if err != nil {
+		slog.ErrorContext(ctx, "failed to dismiss identity conflict", "error", err, "host_id", hostID)
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+	if !found {
+		http.Error(w, "host not found", http.StatusNotFound)
+		return
+	}
+
+	http.Redirect(w, r, "/hosts/"+hostID.String(), http.StatusSeeOther)
+}
@@ -0,0 +1,164 @@
+package osquery
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+
+	org "github.com/cavenine/queryops/features/organization"
+	"github.com/cavenine/queryops/features/osquery/services"
+	"github.com/cavenine/queryops/internal/validate"
+)
+
+// maxQuarantineReasonLength bounds the reason given for a quarantine.
+const maxQuarantineReasonLength = 500
+
+// hostQuarantineRepository swaps suspicious hosts onto the quarantine config
+// and back.
+type hostQuarantineRepository interface {
+	QuarantineHost(ctx context.Context, organizationID, hostID uuid.UUID, reason string, quarantinedBy *int) (uuid.UUID, error)
+	ReleaseHost(ctx context.Context, organizationID, hostID uuid.UUID, releasedBy *int) error
+	ListHostQuarantines(ctx context.Context, hostID uuid.UUID) ([]services.HostQuarantine, error)
+}
+
+// hostQuarantine returns the host's open quarantine, for its details page,
+// or nil if it isn't quarantined.
+func (h *Handlers) hostQuarantine(ctx context.Context, host *services.Host) *services.HostQuarantine {
+	if h.quarantines == nil || host.QuarantineID == nil {
+		return nil
+	}
+	quarantines, err := h.quarantines.ListHostQuarantines(ctx, host.ID)
+	if err != nil {
+		slog.ErrorContext(ctx, "failed to list host quarantines", "error", err, "host_id", host.ID)
+		return nil
+	}
+	for i := range quarantines {
+		if quarantines[i].ID == *host.QuarantineID {
+			return &quarantines[i]
+		}
+	}
+	return nil
+}
+
+// QuarantineHost serves a suspicious host the quarantine config until it's
+// released, and tags the results it returns meanwhile.
+func (h *Handlers) QuarantineHost(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	activeOrg := org.GetOrganizationFromContext(ctx)
+	if activeOrg == nil {
+		slog.Error("missing active organization in context")
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+
+	hostID, err := uuid.Parse(chi.URLParam(r, "id"))
+	if err != nil {
+		http.Error(w, "invalid host id", http.StatusBadRequest)
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "invalid form data", http.StatusBadRequest)
+		return
+	}
+	reason := strings.TrimSpace(r.PostForm.Get("reason"))
+	fields := validate.Errors{}
+	fields.Field("reason", reason, validate.MaxLength(maxQuarantineReasonLength))
+	if len(fields) > 0 {
+		http.Error(w, fields.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+
+	host, ok := h.quarantineTarget(w, r, hostID, activeOrg.ID)
+	if !ok {
+		return
+	}
+	if _, err := h.quarantines.QuarantineHost(ctx, activeOrg.ID, hostID, reason, createdByFromContext(ctx)); err != nil {
+		switch {
+		case errors.Is(err, services.ErrHostNotFound):
+			http.Error(w, "host not found", http.StatusNotFound)
+		case errors.Is(err, services.ErrHostQuarantined):
+			http.Error(w, err.Error(), http.StatusConflict)
+		case errors.Is(err, services.ErrQuarantineConfigMissing):
+			slog.ErrorContext(ctx, "cannot quarantine host", "error", err, "host_id", hostID)
+			http.Error(w, err.Error(), http.StatusConflict)
+		default:
+			slog.ErrorContext(ctx, "failed to quarantine host", "error", err, "host_id", hostID)
+			http.Error(w, "internal error", http.StatusInternalServerError)
+		}
+		return
+	}
+	slog.InfoContext(ctx, "host quarantined",
+		"organization_id", activeOrg.ID,
+		"host_id", hostID,
+		"host_identifier", host.HostIdentifier,
+		"reason", reason,
+	)
+	// Cached hosts tag their results from the cache; drop them on every
+	// instance so tagging starts at once.
+	h.publishHostEnrolledEvent(ctx, activeOrg.ID, host.HostIdentifier)
+
+	http.Redirect(w, r, "/hosts/"+hostID.String(), http.StatusSeeOther)
+}
+
+// ReleaseHost ends a host's quarantine, restoring its config.
+func (h *Handlers) ReleaseHost(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	activeOrg := org.GetOrganizationFromContext(ctx)
+	if activeOrg == nil {
+		slog.Error("missing active organization in context")
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+
+	hostID, err := uuid.Parse(chi.URLParam(r, "id"))
+	if err != nil {
+		http.Error(w, "invalid host id", http.StatusBadRequest)
+		return
+	}
+
+	host, ok := h.quarantineTarget(w, r, hostID, activeOrg.ID)
+	if !ok {
+		return
+	}
+	if err := h.quarantines.ReleaseHost(ctx, activeOrg.ID, hostID, createdByFromContext(ctx)); err != nil {
+		switch {
+		case errors.Is(err, services.ErrHostNotFound):
+			http.Error(w, "host not found", http.StatusNotFound)
+		case errors.Is(err, services.ErrHostNotQuarantined):
+			http.Error(w, err.Error(), http.StatusConflict)
+		default:
+			slog.ErrorContext(ctx, "failed to release host", "error", err, "host_id", hostID)
+			http.Error(w, "internal error", http.StatusInternalServerError)
+		}
+		return
+	}
+	slog.InfoContext(ctx, "host released from quarantine",
+		"organization_id", activeOrg.ID,
+		"host_id", hostID,
+		"host_identifier", host.HostIdentifier,
+	)
+	h.publishHostEnrolledEvent(ctx, activeOrg.ID, host.HostIdentifier)
+
+	http.Redirect(w, r, "/hosts/"+hostID.String(), http.StatusSeeOther)
+}
+
+// quarantineTarget loads the organization's host for a quarantine action,
+// writing a 404 if there's no such host.
+func (h *Handlers) quarantineTarget(w http.ResponseWriter, r *http.Request, hostID, organizationID uuid.UUID) (*services.Host, bool) {
+	host, err := h.repo.GetByIDAndOrganization(r.Context(), hostID, organizationID)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "failed to get host", "error", err)
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return nil, false
+	}
+	if host == nil {
+		http.Error(w, "host not found", http.StatusNotFound)
+		return nil, false
+	}
+	return host, true
+}
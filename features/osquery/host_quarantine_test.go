@@ -0,0 +1,118 @@
+package osquery
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+
+	org "github.com/cavenine/queryops/features/organization"
+	orgServices "github.com/cavenine/queryops/features/organization/services"
+	"github.com/cavenine/queryops/features/osquery/services"
+	"github.com/cavenine/queryops/internal/pubsub"
+)
+
+type quarantineTestHostRepo struct {
+	hostRepository
+
+	host *services.Host
+}
+
+func (r *quarantineTestHostRepo) GetByIDAndOrganization(_ context.Context, id, organizationID uuid.UUID) (*services.Host, error) {
+	if r.host == nil || r.host.ID != id || r.host.OrganizationID != organizationID {
+		return nil, nil
+	}
+	return r.host, nil
+}
+
+type fakeQuarantineRepo struct {
+	quarantined map[uuid.UUID]string
+}
+
+func (r *fakeQuarantineRepo) QuarantineHost(_ context.Context, _, hostID uuid.UUID, reason string, _ *int) (uuid.UUID, error) {
+	if _, ok := r.quarantined[hostID]; ok {
+		return uuid.Nil, services.ErrHostQuarantined
+	}
+	r.quarantined[hostID] = reason
+	return uuid.New(), nil
+}
+
+func (r *fakeQuarantineRepo) ReleaseHost(_ context.Context, _, hostID uuid.UUID, _ *int) error {
+	if _, ok := r.quarantined[hostID]; !ok {
+		return services.ErrHostNotQuarantined
+	}
+	delete(r.quarantined, hostID)
+	return nil
+}
+
+func (r *fakeQuarantineRepo) ListHostQuarantines(context.Context, uuid.UUID) ([]services.HostQuarantine, error) {
+	return nil, nil
+}
+
+func TestQuarantineHost(t *testing.T) {
+	orgID := uuid.New()
+	host := &services.Host{ID: uuid.New(), OrganizationID: orgID, HostIdentifier: "suspicious"}
+	publisher := &recordingPublisher{}
+	quarantines := &fakeQuarantineRepo{quarantined: make(map[uuid.UUID]string)}
+	h := NewHandlers(&quarantineTestHostRepo{host: host}, nil, publisher, nil)
+	h.quarantines = quarantines
+
+	post := func(handler http.HandlerFunc, id uuid.UUID, form url.Values) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/hosts/"+id.String()+"/quarantine", strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rctx := chi.NewRouteContext()
+		rctx.URLParams.Add("id", id.String())
+		ctx := context.WithValue(req.Context(), chi.RouteCtxKey, rctx)
+		req = req.WithContext(org.SetOrganizationInContext(ctx, &orgServices.Organization{ID: orgID}))
+		rec := httptest.NewRecorder()
+		handler(rec, req)
+		return rec
+	}
+
+	if rec := post(h.QuarantineHost, host.ID, url.Values{"reason": {strings.Repeat("x", maxQuarantineReasonLength+1)}}); rec.Code != http.StatusUnprocessableEntity {
+		t.Fatalf("long reason: status = %d, want 422", rec.Code)
+	}
+	if rec := post(h.QuarantineHost, uuid.New(), url.Values{"reason": {"beaconing"}}); rec.Code != http.StatusNotFound {
+		t.Fatalf("unknown host: status = %d, want 404", rec.Code)
+	}
+	if rec := post(h.ReleaseHost, host.ID, nil); rec.Code != http.StatusConflict {
+		t.Fatalf("release before quarantine: status = %d, want 409", rec.Code)
+	}
+
+	rec := post(h.QuarantineHost, host.ID, url.Values{"reason": {"  beaconing  "}})
+	if rec.Code != http.StatusSeeOther || rec.Header().Get("Location") != "/hosts/"+host.ID.String() {
+		t.Fatalf("quarantine: status = %d, location = %q", rec.Code, rec.Header().Get("Location"))
+	}
+	if reason := quarantines.quarantined[host.ID]; reason != "beaconing" {
+		t.Fatalf("reason = %q, want beaconing", reason)
+	}
+	if len(publisher.topics) == 0 || publisher.topics[0] != pubsub.TopicHostEnrollments {
+		t.Fatalf("published topics = %v, want the host enrolled event", publisher.topics)
+	}
+	if rec := post(h.QuarantineHost, host.ID, url.Values{"reason": {"again"}}); rec.Code != http.StatusConflict {
+		t.Fatalf("quarantine twice: status = %d, want 409", rec.Code)
+	}
+
+	if rec := post(h.ReleaseHost, host.ID, nil); rec.Code != http.StatusSeeOther {
+		t.Fatalf("release: status = %d, want 303", rec.Code)
+	}
+	if _, ok := quarantines.quarantined[host.ID]; ok {
+		t.Fatal("host still quarantined after release")
+	}
+}
+
+func TestLogBatchTagQuarantine(t *testing.T) {
+	id := uuid.New()
+	b := logBatch{results: []services.ResultLogEntry{{Name: "a"}, {Name: "b"}}}
+	b.tagQuarantine(&id)
+	for _, e := range b.results {
+		if e.QuarantineID == nil || *e.QuarantineID != id {
+			t.Fatalf("%s: quarantine_id = %v, want %v", e.Name, e.QuarantineID, id)
+		}
+	}
+}
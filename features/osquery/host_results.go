@@ -0,0 +1,101 @@
+package osquery
+
+import (
+	"context"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/starfederation/datastar-go/datastar"
+
+	"github.com/cavenine/queryops/features/osquery/pages"
+	"github.com/cavenine/queryops/features/osquery/services"
+)
+
+const (
+	hostResultsPageSize    = 10
+	maxHostResultsPageSize = 100
+)
+
+// hostResultsPage returns up to limit results older than before, along with the
+// encoded cursor for the following page. The cursor is empty on the last page.
+func (h *Handlers) hostResultsPage(ctx context.Context, hostID uuid.UUID, before *services.ResultsCursor, limit int) ([]services.QueryResult, string, error) {
+	// Fetch one extra row to learn whether another page exists.
+	results, err := h.repo.ListResults(ctx, hostID, before, limit+1)
+	if err != nil {
+		return nil, "", err
+	}
+	if len(results) <= limit {
+		return results, "", nil
+	}
+
+	results = results[:limit]
+	return results, results[limit-1].Cursor().String(), nil
+}
+
+// hostResultsStream tracks what a single HostResultsSSE client has been sent,
+// so that updates only carry new or changed rows instead of the whole table.
+type hostResultsStream struct {
+	hostID uuid.UUID
+
+	// since is the high-water mark of updated_at values already delivered.
+	since time.Time
+	sent  map[uuid.UUID]time.Time
+}
+
+func newHostResultsStream(hostID uuid.UUID, initial []services.QueryResult) *hostResultsStream {
+	s := &hostResultsStream{
+		hostID: hostID,
+		sent:   make(map[uuid.UUID]time.Time, len(initial)),
+	}
+	for _, res := range initial {
+		s.markSent(res)
+	}
+	return s
+}
+
+func (s *hostResultsStream) markSent(res services.QueryResult) {
+	s.sent[res.QueryID] = res.UpdatedAt
+	if res.UpdatedAt.After(s.since) {
+		s.since = res.UpdatedAt
+	}
+}
+
+// changedResults returns results that were created or updated since the last
+// call, oldest first.
+func (s *hostResultsStream) changedResults(ctx context.Context, repo hostRepository) ([]services.QueryResult, error) {
+	// The lower bound is inclusive so rows sharing the high-water timestamp are
+	// not missed; rows already sent at that timestamp are filtered out here.
+	results, err := repo.ListResultsUpdatedSince(ctx, s.hostID, s.since)
+	if err != nil {
+		return nil, err
+	}
+
+	changed := results[:0]
+	for _, res := range results {
+		if last, ok := s.sent[res.QueryID]; ok && !res.UpdatedAt.After(last) {
+			continue
+		}
+		s.markSent(res)
+		changed = append(changed, res)
+	}
+	return changed, nil
+}
+
+// patchHostResultRows moves each changed row to the top of the results table.
+// Rows are removed first so a row already on the page (including ones added by
+// "load more") is never duplicated.
+func patchHostResultRows(sse liveStream, results []services.QueryResult) error {
+	for _, res := range results {
+		if err := sse.RemoveElementByID(pages.HostResultRowID(res.QueryID)); err != nil {
+			return err
+		}
+		if err := sse.PatchElementTempl(
+			pages.HostResultRow(res),
+			datastar.WithSelectorID(pages.HostResultsBodyID),
+			datastar.WithModePrepend(),
+		); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,166 @@
+package osquery
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/google/uuid"
+
+	org "github.com/cavenine/queryops/features/organization"
+	"github.com/cavenine/queryops/features/osquery/pages"
+	"github.com/cavenine/queryops/features/osquery/services"
+	"github.com/cavenine/queryops/internal/validate"
+)
+
+const (
+	hostSearchPageSize    = 100
+	maxHostSearchPageSize = 1000
+)
+
+type hostSearcher interface {
+	SearchHosts(ctx context.Context, organizationID uuid.UUID, q *services.HostQuery, after *uuid.UUID, limit int) (*services.HostSearchResult, error)
+}
+
+type searchHostsResponse struct {
+	*services.HostSearchResult
+	NextCursor string `json:"next_cursor,omitempty"`
+}
+
+// hostSearchRequest is a host search read from the query string: the
+// expression q, and the limit and cursor of the page.
+type hostSearchRequest struct {
+	query *services.HostQuery
+	after *uuid.UUID
+	limit int
+}
+
+func parseHostSearch(r *http.Request, limit int) (hostSearchRequest, validate.Errors) {
+	q := r.URL.Query()
+	req := hostSearchRequest{limit: limit}
+	fields := validate.Errors{}
+
+	if expr := strings.TrimSpace(q.Get("q")); expr == "" {
+		fields.Add("q", "Required")
+	} else if query, err := services.ParseHostQuery(expr); err != nil {
+		var qe *services.HostQueryError
+		if errors.As(err, &qe) {
+			fields.Add("q", fmt.Sprintf("%s at character %d", capitalize(qe.Message), qe.Offset+1))
+		} else {
+			fields.Add("q", "Invalid expression")
+		}
+	} else {
+		req.query = query
+	}
+
+	if s := q.Get("cursor"); s != "" {
+		id, err := uuid.Parse(s)
+		if err != nil {
+			fields.Add("cursor", "Invalid cursor")
+		} else {
+			req.after = &id
+		}
+	}
+	if s := q.Get("limit"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n <= 0 {
+			fields.Add("limit", "Must be a whole number, 1 or more")
+		} else {
+			req.limit = min(n, maxHostSearchPageSize)
+		}
+	}
+	return req, fields
+}
+
+// searchHosts runs req in the organization, returning its page and the
+// cursor of the next one, if any.
+func (h *Handlers) searchHosts(ctx context.Context, organizationID uuid.UUID, req hostSearchRequest) (*services.HostSearchResult, string, error) {
+	// Fetch one extra host to learn whether another page exists.
+	result, err := h.hostSearch.SearchHosts(ctx, organizationID, req.query, req.after, req.limit+1)
+	if err != nil {
+		return nil, "", err
+	}
+	next := ""
+	if len(result.Hosts) > req.limit {
+		result.Hosts = result.Hosts[:req.limit]
+		next = result.Hosts[req.limit-1].HostID.String()
+	}
+	return result, next, nil
+}
+
+// SearchHosts finds the active organization's hosts matching a host search
+// expression over their scheduled query results and enrollment details; see
+// services.HostQuery for the syntax.
+//
+// Query parameters:
+//   - q: the expression, e.g. software.name = 'openssl' AND os.platform = 'ubuntu'
+//   - limit, cursor: pagination
+func (h *Handlers) SearchHosts(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	activeOrg := org.GetOrganizationFromContext(ctx)
+	if activeOrg == nil {
+		slog.Error("missing active organization in context")
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+
+	req, fields := parseHostSearch(r, hostSearchPageSize)
+	if len(fields) > 0 {
+		validate.WriteJSON(w, fields)
+		return
+	}
+
+	result, next, err := h.searchHosts(ctx, activeOrg.ID, req)
+	if err != nil {
+		slog.ErrorContext(ctx, "failed to search hosts", "error", err)
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+	h.jsonResponse(w, searchHostsResponse{HostSearchResult: result, NextCursor: next})
+}
+
+// HostSearchPage searches the active organization's hosts with the
+// expression in q, if there is one.
+func (h *Handlers) HostSearchPage(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	activeOrg := org.GetOrganizationFromContext(ctx)
+	if activeOrg == nil {
+		slog.Error("missing active organization in context")
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+
+	props := pages.HostSearchProps{Query: strings.TrimSpace(r.URL.Query().Get("q"))}
+	if props.Query == "" {
+		pages.HostSearchPage(props).Render(ctx, w)
+		return
+	}
+
+	req, fields := parseHostSearch(r, hostSearchPageSize)
+	if len(fields) > 0 {
+		props.Fields = fields
+		w.WriteHeader(http.StatusUnprocessableEntity)
+		pages.HostSearchPage(props).Render(ctx, w)
+		return
+	}
+	result, next, err := h.searchHosts(ctx, activeOrg.ID, req)
+	if err != nil {
+		slog.ErrorContext(ctx, "failed to search hosts", "error", err)
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+	props.Result = result
+	props.NextCursor = next
+	pages.HostSearchPage(props).Render(ctx, w)
+}
+
+func capitalize(s string) string {
+	if s == "" {
+		return s
+	}
+	return strings.ToUpper(s[:1]) + s[1:]
+}
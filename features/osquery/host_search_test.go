@@ -0,0 +1,81 @@
+package osquery
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/google/uuid"
+
+	org "github.com/cavenine/queryops/features/organization"
+	orgServices "github.com/cavenine/queryops/features/organization/services"
+	"github.com/cavenine/queryops/features/osquery/services"
+	"github.com/cavenine/queryops/internal/validate"
+)
+
+type stubHostSearcher struct {
+	hosts []services.HostSearchHit
+	after *uuid.UUID
+	limit int
+}
+
+func (s *stubHostSearcher) SearchHosts(_ context.Context, _ uuid.UUID, _ *services.HostQuery, after *uuid.UUID, limit int) (*services.HostSearchResult, error) {
+	s.after, s.limit = after, limit
+	hosts := s.hosts
+	if len(hosts) > limit {
+		hosts = hosts[:limit]
+	}
+	return &services.HostSearchResult{Total: len(s.hosts), Hosts: hosts}, nil
+}
+
+func TestSearchHosts(t *testing.T) {
+	searcher := &stubHostSearcher{hosts: []services.HostSearchHit{
+		{HostID: uuid.New(), HostIdentifier: "a"},
+		{HostID: uuid.New(), HostIdentifier: "b"},
+		{HostID: uuid.New(), HostIdentifier: "c"},
+	}}
+	h := NewHandlers(nil, nil, nil, nil)
+	h.hostSearch = searcher
+	activeOrg := &orgServices.Organization{ID: uuid.New()}
+
+	do := func(query string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/api/v1/hosts/search?"+query, nil)
+		req = req.WithContext(org.SetOrganizationInContext(req.Context(), activeOrg))
+		rec := httptest.NewRecorder()
+		h.SearchHosts(rec, req)
+		return rec
+	}
+
+	for bad, field := range map[string]string{
+		"":                                "q",
+		"q=" + url.QueryEscape("os.name"): "q",
+		"q=os.x%3D1&cursor=nope":          "cursor",
+		"q=os.x%3D1&limit=-1":             "limit",
+	} {
+		rec := do(bad)
+		var resp validate.Response
+		if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil || rec.Code != http.StatusBadRequest || !resp.Fields.Has(field) {
+			t.Errorf("%q: status = %d, body = %s, want 400 naming %s", bad, rec.Code, rec.Body, field)
+		}
+	}
+
+	rec := do("q=" + url.QueryEscape("software.name = 'openssl'") + "&limit=2")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, body = %s", rec.Code, rec.Body)
+	}
+	var resp searchHostsResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("unmarshal: %v", err)
+	}
+	if searcher.limit != 3 || resp.Total != 3 || len(resp.Hosts) != 2 || resp.NextCursor != searcher.hosts[1].HostID.String() {
+		t.Fatalf("response = %+v, limit = %d, want 2 of 3 hosts and a cursor", resp, searcher.limit)
+	}
+
+	do("q=" + url.QueryEscape("software.name = 'openssl'") + "&cursor=" + resp.NextCursor)
+	if searcher.after == nil || *searcher.after != searcher.hosts[1].HostID {
+		t.Fatalf("after = %v, want %s", searcher.after, searcher.hosts[1].HostID)
+	}
+}
@@ -0,0 +1,63 @@
+package osquery
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/ThreeDotsLabs/watermill/message"
+	"github.com/jackc/pgx/v5/pgxpool"
+
+	"github.com/cavenine/queryops/internal/outbox"
+	"github.com/cavenine/queryops/internal/pubsub"
+)
+
+// hostTimelineGroup is the outbox consumer group that records host activity
+// events in host timelines.
+const hostTimelineGroup = "host_timeline"
+
+// hostTimelineLimit is how many events the host details page shows.
+const hostTimelineLimit = 200
+
+// hostEventRecorder stores host activity events in host timelines.
+type hostEventRecorder interface {
+	RecordHostEvent(ctx context.Context, messageID string, event pubsub.HostActivityEvent) error
+}
+
+// recordHostTimeline records host activity events from the outbox in host
+// timelines. It returns once subscribed; recording runs until ctx is
+// cancelled. Each event is recorded by one instance, and events written while
+// no instance is running are recorded once one starts. Events that can't be
+// recorded are moved to the dead letters.
+func recordHostTimeline(ctx context.Context, pool *pgxpool.Pool, repo hostEventRecorder) error {
+	group := outbox.NewConsumerGroup(pool, hostTimelineGroup)
+	messages, err := group.Subscribe(ctx, pubsub.TopicAllHostActivity)
+	if err != nil {
+		_ = group.Close()
+		return err
+	}
+
+	handle := pubsub.Chain(func(msg *message.Message) error {
+		event, err := pubsub.ParseHostActivityEvent(msg)
+		if err != nil {
+			return pubsub.Poison(err)
+		}
+		if err := repo.RecordHostEvent(msg.Context(), msg.UUID, event); err != nil {
+			return fmt.Errorf("recording %s event for host %s: %w", event.Type, event.HostID, err)
+		}
+		return nil
+	}, pubsub.DeadLetterQueue(outbox.NewDeadLetters(pool), hostTimelineGroup, pubsub.TopicAllHostActivity), pubsub.DefaultRetry.Middleware)
+
+	go func() {
+		defer func() {
+			_ = group.Close()
+		}()
+
+		// Events that still fail after retrying are dead-lettered rather than
+		// nacked, which would redeliver them ahead of the rest forever.
+		for msg := range messages {
+			_ = pubsub.Handle(msg, handle)
+		}
+	}()
+
+	return nil
+}
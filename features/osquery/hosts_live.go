@@ -0,0 +1,290 @@
+package osquery
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/ThreeDotsLabs/watermill/message"
+	"github.com/google/uuid"
+	"github.com/starfederation/datastar-go/datastar"
+
+	org "github.com/cavenine/queryops/features/organization"
+	"github.com/cavenine/queryops/features/osquery/pages"
+	"github.com/cavenine/queryops/features/osquery/services"
+	"github.com/cavenine/queryops/internal/pubsub"
+)
+
+const (
+	// hostCheckInInterval limits check-in events to one per host per
+	// interval; the hosts page shows last seen to the minute.
+	hostCheckInInterval = time.Minute
+
+	// hostsPollInterval is how often HostsSSE re-reads hosts when pubsub is
+	// unavailable.
+	hostsPollInterval = 5 * time.Second
+)
+
+// checkInThrottle decides which check-ins are published as host events.
+type checkInThrottle struct {
+	interval time.Duration
+
+	mu   sync.Mutex
+	last map[uuid.UUID]time.Time
+}
+
+func newCheckInThrottle(interval time.Duration) *checkInThrottle {
+	return &checkInThrottle{
+		interval: interval,
+		last:     make(map[uuid.UUID]time.Time),
+	}
+}
+
+// allow reports whether a check-in from hostID at now should be published, and
+// if so records it.
+func (t *checkInThrottle) allow(hostID uuid.UUID, now time.Time) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if last, ok := t.last[hostID]; ok && now.Sub(last) < t.interval {
+		return false
+	}
+	if len(t.last) >= maxHostCacheEntries {
+		for id, at := range t.last {
+			if now.Sub(at) >= t.interval {
+				delete(t.last, id)
+			}
+		}
+	}
+	t.last[hostID] = now
+	return true
+}
+
+// publishHostCheckIn tells hosts pages in the host's organization that it
+// checked in. host is the record as it was before this check-in, so a host
+// that was offline is reported as a status change.
+func (h *Handlers) publishHostCheckIn(ctx context.Context, host *services.Host) {
+	if h.publisher == nil {
+		return
+	}
+
+	now := time.Now().UTC()
+	if !h.checkIns.allow(host.ID, now) {
+		return
+	}
+
+	eventType := pubsub.HostEventCheckedIn
+	if last := host.LastCheckIn(); last == nil || now.Sub(*last) >= pages.HostOnlineWindow {
+		eventType = pubsub.HostEventStatusChanged
+	}
+
+	topic := pubsub.TopicHosts(host.OrganizationID)
+	event := pubsub.HostEvent{
+		OrganizationID: host.OrganizationID,
+		HostID:         host.ID,
+		HostIdentifier: host.HostIdentifier,
+		Type:           eventType,
+		OccurredAt:     now,
+	}
+
+	if err := h.publisher.Publish(topic, event.ToMessage()); err != nil {
+		slog.ErrorContext(ctx, "failed to publish host event", "error", err, "topic", topic, "host_id", host.ID)
+	}
+}
+
+// HostsSSE keeps the hosts table current. It replaces the table body once on
+// connect, then patches only the rows of hosts that enroll or check in.
+func (h *Handlers) HostsSSE(w http.ResponseWriter, r *http.Request) {
+	activeOrg := org.GetOrganizationFromContext(r.Context())
+	if activeOrg == nil {
+		slog.Error("missing active organization in context")
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+
+	ctx := r.Context()
+
+	hosts, err := h.repo.ListByOrganization(ctx, activeOrg.ID)
+	if err != nil {
+		slog.ErrorContext(ctx, "failed to list hosts", "error", err)
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+
+	sse, done, err := openLiveStream(w, r)
+	if err != nil {
+		slog.WarnContext(ctx, "failed to open live stream", "error", err)
+		return
+	}
+	defer done()
+	ctx = sse.Context()
+
+	if err := sse.PatchElementTempl(pages.HostsTableBody(hosts, h.minOsqueryVersion)); err != nil {
+		return
+	}
+
+	stream := newHostsStream(hosts, h.minOsqueryVersion)
+
+	if h.pubsub == nil {
+		h.pollHostsLegacy(ctx, sse, activeOrg.ID, stream)
+		return
+	}
+
+	subscriber, err := h.pubsub.NewSubscriber(ctx)
+	if err != nil {
+		slog.ErrorContext(ctx, "failed to create subscriber; falling back to polling", "error", err)
+		h.pollHostsLegacy(ctx, sse, activeOrg.ID, stream)
+		return
+	}
+	defer func() {
+		_ = subscriber.Close()
+	}()
+
+	topic := pubsub.TopicHosts(activeOrg.ID)
+	messages, err := subscriber.Subscribe(ctx, topic)
+	if err != nil {
+		slog.ErrorContext(ctx, "failed to subscribe; falling back to polling", "error", err, "topic", topic)
+		h.pollHostsLegacy(ctx, sse, activeOrg.ID, stream)
+		return
+	}
+
+	var changed []*services.Host
+	handle := h.pubsub.Handler("sse:hosts", topic, func(msg *message.Message) error {
+		event, err := pubsub.ParseHostEvent(msg)
+		if err != nil {
+			return pubsub.Poison(err)
+		}
+
+		// Topic-scoped, but keep it defensive.
+		if event.OrganizationID != activeOrg.ID {
+			return nil
+		}
+
+		changed, err = h.hostsForEvent(ctx, event)
+		if err != nil {
+			return fmt.Errorf("getting hosts after event: %w", err)
+		}
+		return nil
+	})
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case msg := <-messages:
+			if msg == nil {
+				return
+			}
+
+			changed = nil
+			if err := pubsub.Handle(msg, handle); err != nil {
+				continue
+			}
+			if err := stream.patch(sse, changed); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// hostsForEvent loads the rows an event affects. Enrollments don't carry a
+// host ID, so they reload the organization's hosts and rely on the stream to
+// skip unchanged rows.
+func (h *Handlers) hostsForEvent(ctx context.Context, event pubsub.HostEvent) ([]*services.Host, error) {
+	if event.HostID == uuid.Nil {
+		return h.repo.ListByOrganization(ctx, event.OrganizationID)
+	}
+
+	host, err := h.repo.GetByIDAndOrganization(ctx, event.HostID, event.OrganizationID)
+	if err != nil || host == nil {
+		return nil, err
+	}
+
+	// Logger writes may still be queued for ingest, so the stored check-in
+	// time can lag the event.
+	if last := host.LastCheckIn(); last == nil || last.Before(event.OccurredAt) {
+		at := event.OccurredAt
+		host.LastLoggerAt = &at
+	}
+	return []*services.Host{host}, nil
+}
+
+// pollHostsLegacy implements the fallback polling mechanism for HostsSSE.
+// Used when pub/sub is unavailable or subscription fails.
+func (h *Handlers) pollHostsLegacy(ctx context.Context, sse liveStream, organizationID uuid.UUID, stream *hostsStream) {
+	ticker := time.NewTicker(hostsPollInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			hosts, err := h.repo.ListByOrganization(ctx, organizationID)
+			if err != nil {
+				_ = sse.ConsoleError(err)
+				return
+			}
+
+			if err := stream.patch(sse, hosts); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// hostsStream tracks the rows a single HostsSSE client has been sent.
+type hostsStream struct {
+	// sent is each host's last check-in as rendered; zero for never.
+	sent map[uuid.UUID]time.Time
+	// minOsqueryVersion is passed to the rows rendered.
+	minOsqueryVersion string
+}
+
+func newHostsStream(initial []*services.Host, minOsqueryVersion string) *hostsStream {
+	s := &hostsStream{sent: make(map[uuid.UUID]time.Time, len(initial)), minOsqueryVersion: minOsqueryVersion}
+	for _, host := range initial {
+		s.track(host)
+	}
+	return s
+}
+
+// track records host and reports whether its row changed and whether it is
+// new to the client. Older check-in times never replace newer ones, since
+// events can report check-ins before they are stored.
+func (s *hostsStream) track(host *services.Host) (changed, added bool) {
+	var seen time.Time
+	if last := host.LastCheckIn(); last != nil {
+		seen = *last
+	}
+
+	last, ok := s.sent[host.ID]
+	if ok && !seen.After(last) {
+		return false, false
+	}
+	s.sent[host.ID] = seen
+	return true, !ok
+}
+
+// patch sends the rows of hosts that changed; new hosts are appended to the
+// table.
+func (s *hostsStream) patch(sse liveStream, hosts []*services.Host) error {
+	for _, host := range hosts {
+		changed, added := s.track(host)
+		if !changed {
+			continue
+		}
+
+		var opts []datastar.PatchElementOption
+		if added {
+			opts = append(opts, datastar.WithSelectorID(pages.HostsBodyID), datastar.WithModeAppend())
+		}
+		if err := sse.PatchElementTempl(pages.HostRow(host, s.minOsqueryVersion), opts...); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,101 @@
+package osquery
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/ThreeDotsLabs/watermill/message"
+	"github.com/google/uuid"
+
+	"github.com/cavenine/queryops/features/osquery/services"
+	"github.com/cavenine/queryops/internal/pubsub"
+)
+
+type topicRecorder struct {
+	topics []string
+	events []pubsub.HostEvent
+}
+
+func (p *topicRecorder) Publish(topic string, msgs ...*message.Message) error {
+	for _, msg := range msgs {
+		p.topics = append(p.topics, topic)
+		if event, err := pubsub.ParseHostEvent(msg); err == nil {
+			p.events = append(p.events, event)
+		}
+	}
+	return nil
+}
+
+func (p *topicRecorder) Close() error { return nil }
+
+func TestCheckInThrottle(t *testing.T) {
+	throttle := newCheckInThrottle(time.Minute)
+	hostID := uuid.New()
+	now := time.Now()
+
+	if !throttle.allow(hostID, now) {
+		t.Fatalf("first check-in throttled")
+	}
+	if throttle.allow(hostID, now.Add(30*time.Second)) {
+		t.Fatalf("check-in within interval allowed")
+	}
+	if !throttle.allow(uuid.New(), now.Add(30*time.Second)) {
+		t.Fatalf("other host throttled")
+	}
+	if !throttle.allow(hostID, now.Add(time.Minute)) {
+		t.Fatalf("check-in after interval throttled")
+	}
+}
+
+func TestPublishHostCheckIn(t *testing.T) {
+	publisher := &topicRecorder{}
+	h := NewHandlers(nil, nil, publisher, nil)
+
+	recent := time.Now().Add(-time.Minute)
+	online := &services.Host{ID: uuid.New(), OrganizationID: uuid.New(), LastLoggerAt: &recent}
+	h.publishHostCheckIn(context.Background(), online)
+	h.publishHostCheckIn(context.Background(), online)
+
+	offline := &services.Host{ID: uuid.New(), OrganizationID: online.OrganizationID}
+	h.publishHostCheckIn(context.Background(), offline)
+
+	if len(publisher.events) != 2 {
+		t.Fatalf("published %d events, want 2 (second check-in throttled)", len(publisher.events))
+	}
+	if publisher.topics[0] != pubsub.TopicHosts(online.OrganizationID) {
+		t.Fatalf("topic = %q", publisher.topics[0])
+	}
+	if got := publisher.events[0]; got.HostID != online.ID || got.Type != pubsub.HostEventCheckedIn {
+		t.Fatalf("online host event = %+v", got)
+	}
+	if got := publisher.events[1]; got.HostID != offline.ID || got.Type != pubsub.HostEventStatusChanged {
+		t.Fatalf("offline host event = %+v", got)
+	}
+}
+
+func TestHostsStream_Track(t *testing.T) {
+	seen := time.Now().Add(-time.Hour)
+	known := &services.Host{ID: uuid.New(), LastLoggerAt: &seen}
+	never := &services.Host{ID: uuid.New()}
+	s := newHostsStream([]*services.Host{known, never}, "")
+
+	if changed, _ := s.track(known); changed {
+		t.Fatalf("unchanged host reported changed")
+	}
+	if changed, _ := s.track(never); changed {
+		t.Fatalf("never seen host reported changed")
+	}
+
+	later := seen.Add(time.Minute)
+	if changed, added := s.track(&services.Host{ID: known.ID, LastLoggerAt: &later}); !changed || added {
+		t.Fatalf("check-in: changed = %v, added = %v; want true, false", changed, added)
+	}
+	if changed, _ := s.track(known); changed {
+		t.Fatalf("older check-in replaced a newer one")
+	}
+
+	if changed, added := s.track(&services.Host{ID: uuid.New()}); !changed || !added {
+		t.Fatalf("enrolled host: changed = %v, added = %v; want true, true", changed, added)
+	}
+}
@@ -0,0 +1,20 @@
+package osquery
+
+import "github.com/cavenine/queryops/features/osquery/services"
+
+// addIngestHookQueries adds the queries of the registered ingest hooks to a
+// config served to a host. Their results keep the host's normalized records,
+// such as its osquery and OS versions, current between enrollments.
+func addIngestHookQueries(cfg *ConfigResponse) {
+	if cfg.Schedule == nil {
+		cfg.Schedule = make(map[string]ScheduledQuery)
+	}
+	for _, hook := range services.Ingest.Hooks() {
+		cfg.Schedule[hook.QueryName] = ScheduledQuery{
+			Query:    hook.Query,
+			Interval: hook.Interval,
+			Snapshot: true,
+			Platform: hook.Platform,
+		}
+	}
+}
@@ -0,0 +1,38 @@
+package osquery
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/cavenine/queryops/features/osquery/services"
+)
+
+func TestConfig_AddsIngestHookQueries(t *testing.T) {
+	h := NewHandlers(&platformHostRepo{osVersion: `{"platform":"windows"}`, config: `{}`}, nil, nil, nil)
+
+	req := httptest.NewRequest(http.MethodPost, "/osquery/config", strings.NewReader(`{"node_key":"k"}`))
+	rec := httptest.NewRecorder()
+	h.Config(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d", rec.Code)
+	}
+	var resp ConfigResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("unmarshal: %v", err)
+	}
+
+	q, ok := resp.Schedule[services.OSVersionQueryName]
+	if !ok || q.Query != services.OSVersionQuery || q.Interval != services.ScheduleVitalsInterval || !q.Snapshot {
+		t.Fatalf("os version query = %+v, %v", q, ok)
+	}
+	if q := resp.Schedule["queryops_software_programs"]; q.Interval != services.SoftwareInterval || q.Platform != "windows" {
+		t.Fatalf("windows software query = %+v", q)
+	}
+	// Other platforms' software queries are left out.
+	if q, ok := resp.Schedule["queryops_software_deb"]; ok {
+		t.Fatalf("windows host was served %+v", q)
+	}
+}
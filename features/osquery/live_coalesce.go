@@ -0,0 +1,67 @@
+package osquery
+
+import (
+	"context"
+	"time"
+)
+
+// campaignPatchWindow is how long a campaign stream waits after a result
+// event for more before re-rendering the table. Hosts answering a campaign
+// together then cost one render instead of one each.
+const campaignPatchWindow = 250 * time.Millisecond
+
+// patchCoalescer turns change notifications for a live stream into as few
+// renders as keep it current. Notifications within window of the first are
+// rendered together, and those that arrive while a render is being written
+// to a slow client collapse into a single pending one. Intermediate states
+// are dropped rather than queued, since each render sends the full state;
+// the client always ends up with the latest, and nothing buffers without
+// bound while it catches up.
+type patchCoalescer struct {
+	window  time.Duration
+	pending chan struct{}
+}
+
+func newPatchCoalescer(window time.Duration) *patchCoalescer {
+	return &patchCoalescer{window: window, pending: make(chan struct{}, 1)}
+}
+
+// mark notes that the stream's state changed. It never blocks.
+func (c *patchCoalescer) mark() {
+	select {
+	case c.pending <- struct{}{}:
+	default:
+	}
+}
+
+// run calls render once per batch of marks until ctx is done or render
+// returns false.
+func (c *patchCoalescer) run(ctx context.Context, render func() bool) {
+	timer := time.NewTimer(c.window)
+	timer.Stop()
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-c.pending:
+		}
+
+		timer.Reset(c.window)
+		select {
+		case <-ctx.Done():
+			return
+		case <-timer.C:
+		}
+		// Marks made during the window are covered by this render.
+		select {
+		case <-c.pending:
+		default:
+		}
+
+		if !render() {
+			return
+		}
+	}
+}
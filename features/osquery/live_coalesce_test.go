@@ -0,0 +1,64 @@
+package osquery
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestPatchCoalescer(t *testing.T) {
+	c := newPatchCoalescer(20 * time.Millisecond)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	renders := make(chan int, 10)
+	release := make(chan struct{})
+	n := 0
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		c.run(ctx, func() bool {
+			n++
+			renders <- n
+			if n == 1 {
+				// A client slow to take the first render.
+				<-release
+			}
+			return n < 2
+		})
+	}()
+
+	// A burst renders once.
+	for range 5 {
+		c.mark()
+	}
+	select {
+	case <-renders:
+	case <-time.After(time.Second):
+		t.Fatal("burst was never rendered")
+	}
+
+	// Marks made while the render is being written collapse into one more.
+	for range 100 {
+		c.mark()
+	}
+	close(release)
+	select {
+	case got := <-renders:
+		if got != 2 {
+			t.Fatalf("render %d, want 2", got)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("marks during a render were never rendered")
+	}
+
+	// render returning false ends run.
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("run didn't stop when render returned false")
+	}
+	if len(renders) != 0 {
+		t.Fatalf("%d extra renders", len(renders))
+	}
+}
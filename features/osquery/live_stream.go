@@ -0,0 +1,40 @@
+package osquery
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/starfederation/datastar-go/datastar"
+
+	"github.com/cavenine/queryops/internal/wsstream"
+)
+
+// liveStream is what the hosts, host results, and campaign results streams
+// send their updates to. It is an SSE response, or the same Datastar events
+// carried over a WebSocket when the page asked for one because a proxy
+// buffers SSE (see live-stream.js), so the subscription and polling code is
+// shared by both transports.
+type liveStream interface {
+	// Context is done when the client goes away.
+	Context() context.Context
+	PatchElementTempl(c datastar.TemplComponent, opts ...datastar.PatchElementOption) error
+	RemoveElementByID(id string) error
+	ConsoleError(err error, opts ...datastar.ExecuteScriptOption) error
+}
+
+// openLiveStream starts the stream r negotiated. The returned func ends it
+// and must be called once the handler is done sending.
+func openLiveStream(w http.ResponseWriter, r *http.Request) (liveStream, func(), error) {
+	if !wsstream.IsUpgrade(r) {
+		return datastar.NewSSE(w, r), func() {}, nil
+	}
+
+	conn, err := wsstream.Accept(w, r)
+	if err != nil {
+		return nil, nil, err
+	}
+	// Each event is a single write, so each becomes one message holding the
+	// event exactly as it would appear in the SSE response.
+	sse := datastar.NewSSE(conn.ResponseWriter(), r, datastar.WithContext(conn.Context()))
+	return sse, func() { _ = conn.Close() }, nil
+}
@@ -0,0 +1,123 @@
+package osquery
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/a-h/templ"
+)
+
+func TestOpenLiveStream_WebSocket(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		sse, done, err := openLiveStream(w, r)
+		if err != nil {
+			t.Errorf("openLiveStream: %v", err)
+			return
+		}
+		defer done()
+
+		if err := sse.PatchElementTempl(templ.Raw(`<tbody id="hosts-body"></tbody>`)); err != nil {
+			t.Errorf("PatchElementTempl: %v", err)
+		}
+		if err := sse.RemoveElementByID("host-1"); err != nil {
+			t.Errorf("RemoveElementByID: %v", err)
+		}
+	}))
+	defer srv.Close()
+
+	conn, err := net.Dial("tcp", srv.Listener.Addr().String())
+	if err != nil {
+		t.Fatalf("dialing: %v", err)
+	}
+	defer func() { _ = conn.Close() }()
+	_ = conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	req, err := http.NewRequest(http.MethodGet, srv.URL+"/hosts/live", nil)
+	if err != nil {
+		t.Fatalf("NewRequest: %v", err)
+	}
+	req.Header.Set("Connection", "Upgrade")
+	req.Header.Set("Upgrade", "websocket")
+	req.Header.Set("Sec-WebSocket-Version", "13")
+	req.Header.Set("Sec-WebSocket-Key", "dGhlIHNhbXBsZSBub25jZQ==")
+	if err := req.Write(conn); err != nil {
+		t.Fatalf("writing handshake: %v", err)
+	}
+
+	br := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(br, req)
+	if err != nil {
+		t.Fatalf("reading handshake: %v", err)
+	}
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		t.Fatalf("status = %d, want 101", resp.StatusCode)
+	}
+
+	// Each event arrives as one text message, formatted byte for byte as in
+	// the SSE response (datastar ends every event with a blank line after
+	// its data lines), followed by a close frame when the handler returns.
+	want := []string{
+		"event: datastar-patch-elements\ndata: elements <tbody id=\"hosts-body\"></tbody>\n\n\n",
+		"event: datastar-patch-elements\ndata: selector #host-1\ndata: mode remove\n\n\n",
+	}
+	for i, w := range want {
+		opcode, payload := readTestFrame(t, br)
+		if opcode != 0x1 || string(payload) != w {
+			t.Fatalf("message %d = %#x %q, want text %q", i, opcode, payload, w)
+		}
+	}
+	if opcode, _ := readTestFrame(t, br); opcode != 0x8 {
+		t.Fatalf("final frame opcode = %#x, want close", opcode)
+	}
+}
+
+func TestOpenLiveStream_SSE(t *testing.T) {
+	r := httptest.NewRequest(http.MethodGet, "/hosts/live", nil)
+	w := httptest.NewRecorder()
+
+	sse, done, err := openLiveStream(w, r)
+	if err != nil {
+		t.Fatalf("openLiveStream: %v", err)
+	}
+	if err := sse.RemoveElementByID("host-1"); err != nil {
+		t.Fatalf("RemoveElementByID: %v", err)
+	}
+	done()
+
+	if ct := w.Header().Get("Content-Type"); ct != "text/event-stream" {
+		t.Fatalf("Content-Type = %q", ct)
+	}
+	if !strings.Contains(w.Body.String(), "data: selector #host-1\n") {
+		t.Fatalf("body = %q", w.Body.String())
+	}
+}
+
+// readTestFrame reads one unmasked server frame with a payload shorter than
+// 64 KiB.
+func readTestFrame(t *testing.T, br *bufio.Reader) (byte, []byte) {
+	t.Helper()
+
+	var head [2]byte
+	if _, err := io.ReadFull(br, head[:]); err != nil {
+		t.Fatalf("reading frame: %v", err)
+	}
+	length := int(head[1] & 0x7F)
+	if length == 126 {
+		var ext [2]byte
+		if _, err := io.ReadFull(br, ext[:]); err != nil {
+			t.Fatalf("reading frame length: %v", err)
+		}
+		length = int(ext[0])<<8 | int(ext[1])
+	}
+	payload := make([]byte, length)
+	if _, err := io.ReadFull(br, payload); err != nil {
+		t.Fatalf("reading frame payload: %v", err)
+	}
+	return head[0] & 0x0F, payload
+}
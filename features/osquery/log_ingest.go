@@ -0,0 +1,280 @@
+package osquery
+
+import (
+	"context"
+	"encoding/json"
+	"expvar"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+
+	orgServices "github.com/cavenine/queryops/features/organization/services"
+	"github.com/cavenine/queryops/features/osquery/services"
+	"github.com/cavenine/queryops/internal/logarchive"
+)
+
+const (
+	defaultLogIngestQueueSize = 1024
+	defaultLogIngestBatchSize = 1000
+	defaultLogIngestFlush     = 500 * time.Millisecond
+	logIngestWriteTimeout     = 30 * time.Second
+	// logIngestWriteAttempts and logIngestRetryBackoff bound how long a
+	// failing batch holds up the writer before its lines are dropped.
+	logIngestWriteAttempts = 4
+	logIngestRetryBackoff  = time.Second
+)
+
+// logIngestMetrics exposes ingestion counters under /debug/vars:
+//
+//	enqueued  logger requests accepted into the queue
+//	overflow  logger requests rejected because the queue was full
+//	written   log lines persisted
+//	retried   failed batch writes that were retried
+//	failed    log lines dropped after every write attempt failed
+var logIngestMetrics = expvar.NewMap("osquery_log_ingest")
+
+type logBatchWriter interface {
+	SaveLogBatch(ctx context.Context, results []services.ResultLogEntry, statuses []services.StatusLogEntry) error
+}
+
+// logBatch holds the parsed log lines from a single /logger request.
+type logBatch struct {
+	results  []services.ResultLogEntry
+	statuses []services.StatusLogEntry
+}
+
+func (b logBatch) len() int {
+	return len(b.results) + len(b.statuses)
+}
+
+// resultBytes is the size of the batch's result columns, which is what the
+// daily result log quota meters.
+func (b logBatch) resultBytes() int64 {
+	var n int64
+	for _, e := range b.results {
+		n += int64(len(e.Columns))
+	}
+	return n
+}
+
+// tagQuarantine tags the batch's results with the host's open quarantine;
+// id may be nil.
+func (b logBatch) tagQuarantine(id *uuid.UUID) {
+	for i := range b.results {
+		b.results[i].QuarantineID = id
+	}
+}
+
+// parseLogBatch decodes the raw lines of a /logger request. Lines that fail to
+// decode are logged and skipped. Snapshot results become one entry per row,
+// all with action "snapshot" and the snapshot's timestamp and decorations.
+// Result rows are redacted before they are encoded; redactor may be nil.
+func parseLogBatch(hostID uuid.UUID, logType string, data []json.RawMessage, redactor *orgServices.Redactor) logBatch {
+	var b logBatch
+	for _, raw := range data {
+		switch logType {
+		case "result":
+			var log ResultLog
+			if err := json.Unmarshal(raw, &log); err != nil {
+				slog.Error("failed to unmarshal result log", "error", err)
+				continue
+			}
+			rows := []map[string]string{log.Columns}
+			if log.Action == "snapshot" {
+				rows = log.Snapshot
+			}
+			var decorations json.RawMessage
+			if len(log.Decorations) > 0 {
+				// A map of strings always encodes.
+				decorations, _ = json.Marshal(log.Decorations)
+			}
+			for _, row := range rows {
+				redactor.RedactRow(row)
+				cols, err := json.Marshal(row)
+				if err != nil {
+					slog.Error("failed to marshal result log columns", "error", err)
+					continue
+				}
+				b.results = append(b.results, services.ResultLogEntry{
+					HostID:      hostID,
+					Name:        log.Name,
+					Action:      log.Action,
+					Columns:     json.RawMessage(cols),
+					Timestamp:   time.Unix(int64(log.UnixTime), 0),
+					Decorations: decorations,
+				})
+			}
+		case "status":
+			var log StatusLog
+			if err := json.Unmarshal(raw, &log); err != nil {
+				slog.Error("failed to unmarshal status log", "error", err)
+				continue
+			}
+			b.statuses = append(b.statuses, services.StatusLogEntry{
+				HostID:    hostID,
+				Line:      log.Line,
+				Message:   log.Message,
+				Severity:  log.Severity,
+				Filename:  log.Filename,
+				CreatedAt: time.Unix(int64(log.UnixTime), 0),
+			})
+		}
+	}
+	return b
+}
+
+// LogIngester moves log persistence out of the /logger request path. Requests
+// are parsed and queued; a single background writer coalesces them and flushes
+// to Postgres when a batch fills up or the flush interval elapses.
+//
+// osquery is told a batch was saved once it is queued, so queued lines are
+// only as durable as this process: Close drains the queue on shutdown, and a
+// write that keeps failing is retried with backoff and then dropped (counted
+// as failed). A crash loses whatever was queued.
+type LogIngester struct {
+	writer    logBatchWriter
+	queue     chan logBatch
+	batchSize int
+	flush     time.Duration
+	backoff   time.Duration
+
+	// archive, when set, runs alongside the ingester and is drained after
+	// it, since /logger requests feed both.
+	archive *logarchive.Archiver
+
+	// mu guards closed against enqueues racing Close.
+	mu     sync.RWMutex
+	closed bool
+}
+
+func newLogIngester(writer logBatchWriter, queueSize, batchSize int, flush time.Duration) *LogIngester {
+	if queueSize <= 0 {
+		queueSize = defaultLogIngestQueueSize
+	}
+	if batchSize <= 0 {
+		batchSize = defaultLogIngestBatchSize
+	}
+	if flush <= 0 {
+		flush = defaultLogIngestFlush
+	}
+	return &LogIngester{
+		writer:    writer,
+		queue:     make(chan logBatch, queueSize),
+		batchSize: batchSize,
+		flush:     flush,
+		backoff:   logIngestRetryBackoff,
+	}
+}
+
+// enqueue queues b for writing without blocking. It reports false when the
+// queue is full or the ingester is closed; callers should ask osquery to
+// retry later.
+func (i *LogIngester) enqueue(b logBatch) bool {
+	if b.len() == 0 {
+		return true
+	}
+	i.mu.RLock()
+	defer i.mu.RUnlock()
+	if i.closed {
+		logIngestMetrics.Add("overflow", 1)
+		return false
+	}
+	select {
+	case i.queue <- b:
+		logIngestMetrics.Add("enqueued", 1)
+		return true
+	default:
+		logIngestMetrics.Add("overflow", 1)
+		return false
+	}
+}
+
+// Close stops accepting batches. Run returns once the batches already queued
+// are written. Call it after the HTTP server has stopped so in-flight /logger
+// requests are queued first; later requests are refused and retried by
+// osquery.
+func (i *LogIngester) Close() {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	if !i.closed {
+		i.closed = true
+		close(i.queue)
+	}
+}
+
+// Run writes queued logs until Close is called and the queue is drained,
+// then drains the archive, if there is one.
+func (i *LogIngester) Run() {
+	if i.archive != nil {
+		done := make(chan struct{})
+		go func() {
+			i.archive.Run()
+			close(done)
+		}()
+		defer func() {
+			i.archive.Close()
+			<-done
+		}()
+	}
+
+	ticker := time.NewTicker(i.flush)
+	defer ticker.Stop()
+
+	var pending logBatch
+	for {
+		select {
+		case b, ok := <-i.queue:
+			if !ok {
+				i.write(pending)
+				return
+			}
+			pending = i.add(pending, b)
+		case <-ticker.C:
+			i.write(pending)
+			pending = logBatch{}
+		}
+	}
+}
+
+// add appends b to pending, writing out pending first once it is full.
+func (i *LogIngester) add(pending, b logBatch) logBatch {
+	pending.results = append(pending.results, b.results...)
+	pending.statuses = append(pending.statuses, b.statuses...)
+	if pending.len() < i.batchSize {
+		return pending
+	}
+	i.write(pending)
+	return logBatch{}
+}
+
+// write saves b, retrying with exponential backoff. Lines are dropped only
+// once every attempt has failed.
+func (i *LogIngester) write(b logBatch) {
+	n := b.len()
+	if n == 0 {
+		return
+	}
+
+	backoff := i.backoff
+	var err error
+	for attempt := 1; attempt <= logIngestWriteAttempts; attempt++ {
+		// Use a fresh context so the final drain still runs during shutdown.
+		ctx, cancel := context.WithTimeout(context.Background(), logIngestWriteTimeout)
+		err = i.writer.SaveLogBatch(ctx, b.results, b.statuses)
+		cancel()
+		if err == nil {
+			logIngestMetrics.Add("written", int64(n))
+			return
+		}
+		if attempt < logIngestWriteAttempts {
+			logIngestMetrics.Add("retried", 1)
+			slog.Warn("failed to write osquery log batch; retrying", "error", err, "attempt", attempt, "backoff", backoff)
+			time.Sleep(backoff)
+			backoff *= 2
+		}
+	}
+	logIngestMetrics.Add("failed", int64(n))
+	slog.Error("dropping osquery log batch after repeated write failures", "error", err, "results", len(b.results), "statuses", len(b.statuses))
+}
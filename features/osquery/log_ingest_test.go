@@ -0,0 +1,175 @@
+package osquery
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+
+	orgServices "github.com/cavenine/queryops/features/organization/services"
+	"github.com/cavenine/queryops/features/osquery/services"
+)
+
+type recordingBatchWriter struct {
+	mu       sync.Mutex
+	failures int // number of calls to fail before succeeding
+	calls    int
+	batches  int
+	results  int
+	statuses int
+}
+
+func (w *recordingBatchWriter) SaveLogBatch(_ context.Context, results []services.ResultLogEntry, statuses []services.StatusLogEntry) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.calls++
+	if w.calls <= w.failures {
+		return errors.New("database unavailable")
+	}
+	w.batches++
+	w.results += len(results)
+	w.statuses += len(statuses)
+	return nil
+}
+
+func TestParseLogBatch(t *testing.T) {
+	hostID := uuid.New()
+
+	results := parseLogBatch(hostID, "result", []json.RawMessage{
+		json.RawMessage(`{"name":"pack_test","unixTime":10,"action":"added","columns":{"a":"b"}}`),
+		json.RawMessage(`not json`),
+	}, nil)
+	if len(results.results) != 1 || len(results.statuses) != 0 {
+		t.Fatalf("results = %d, statuses = %d; want 1, 0", len(results.results), len(results.statuses))
+	}
+	if got := results.results[0]; got.HostID != hostID || got.Name != "pack_test" || got.Timestamp.Unix() != 10 {
+		t.Fatalf("result entry = %+v", got)
+	}
+
+	statuses := parseLogBatch(hostID, "status", []json.RawMessage{
+		json.RawMessage(`{"line":1,"message":"m","severity":0,"filename":"f.cpp","unixTime":"20"}`),
+	}, nil)
+	if len(statuses.statuses) != 1 || statuses.statuses[0].CreatedAt.Unix() != 20 {
+		t.Fatalf("statuses = %+v", statuses.statuses)
+	}
+}
+
+func TestParseLogBatch_Snapshot(t *testing.T) {
+	b := parseLogBatch(uuid.New(), "result", []json.RawMessage{
+		json.RawMessage(`{"name":"pack_test","unixTime":30,"action":"snapshot","snapshot":[{"pid":"1"},{"pid":"2"}]}`),
+	}, nil)
+	if len(b.results) != 2 {
+		t.Fatalf("results = %d, want one per snapshot row", len(b.results))
+	}
+	for i, want := range []string{`{"pid":"1"}`, `{"pid":"2"}`} {
+		got := b.results[i]
+		if got.Action != "snapshot" || string(got.Columns) != want || got.Timestamp.Unix() != 30 {
+			t.Fatalf("result %d = %+v", i, got)
+		}
+	}
+}
+
+func TestParseLogBatch_Decorations(t *testing.T) {
+	b := parseLogBatch(uuid.New(), "result", []json.RawMessage{
+		json.RawMessage(`{"name":"procs","unixTime":30,"action":"snapshot","snapshot":[{"pid":"1"},{"pid":"2"}],"decorations":{"hostname":"web-1","org_tag":"acme"}}`),
+		json.RawMessage(`{"name":"users","unixTime":30,"action":"added","columns":{"user":"root"}}`),
+	}, nil)
+	if len(b.results) != 3 {
+		t.Fatalf("results = %d, want 3", len(b.results))
+	}
+	for _, got := range b.results[:2] {
+		if want := `{"hostname":"web-1","org_tag":"acme"}`; string(got.Decorations) != want {
+			t.Fatalf("decorations = %s, want %s", got.Decorations, want)
+		}
+	}
+	if got := b.results[2].Decorations; got != nil {
+		t.Fatalf("undecorated line: decorations = %s, want nil", got)
+	}
+}
+
+func TestParseLogBatch_Redacted(t *testing.T) {
+	redactor := orgServices.NewRedactor([]orgServices.RedactionRule{
+		{Kind: orgServices.RedactColumn, Pattern: "password"},
+	})
+	b := parseLogBatch(uuid.New(), "result", []json.RawMessage{
+		json.RawMessage(`{"name":"users","unixTime":10,"action":"added","columns":{"user":"root","Password":"hunter2"}}`),
+	}, redactor)
+	if len(b.results) != 1 {
+		t.Fatalf("results = %d, want 1", len(b.results))
+	}
+	if got, want := string(b.results[0].Columns), `{"Password":"[REDACTED]","user":"root"}`; got != want {
+		t.Fatalf("columns = %s, want %s", got, want)
+	}
+}
+
+func TestLogIngester_EnqueueOverflow(t *testing.T) {
+	ing := newLogIngester(&recordingBatchWriter{}, 1, 10, time.Minute)
+	b := logBatch{statuses: []services.StatusLogEntry{{HostID: uuid.New()}}}
+
+	if !ing.enqueue(b) {
+		t.Fatalf("first enqueue rejected")
+	}
+	if ing.enqueue(b) {
+		t.Fatalf("second enqueue accepted; want overflow")
+	}
+	if !ing.enqueue(logBatch{}) {
+		t.Fatalf("empty batch rejected")
+	}
+}
+
+func TestLogIngester_RunFlushesOnClose(t *testing.T) {
+	w := &recordingBatchWriter{}
+	ing := newLogIngester(w, 10, 2, time.Hour)
+
+	for range 3 {
+		ing.enqueue(logBatch{results: []services.ResultLogEntry{{HostID: uuid.New()}}})
+	}
+
+	done := make(chan struct{})
+	go func() {
+		ing.Run()
+		close(done)
+	}()
+	ing.Close()
+	<-done
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.results != 3 {
+		t.Fatalf("results written = %d, want 3", w.results)
+	}
+}
+
+func TestLogIngester_EnqueueAfterClose(t *testing.T) {
+	ing := newLogIngester(&recordingBatchWriter{}, 10, 10, time.Minute)
+	ing.Close()
+	ing.Close() // idempotent
+
+	if ing.enqueue(logBatch{statuses: []services.StatusLogEntry{{HostID: uuid.New()}}}) {
+		t.Fatalf("enqueue after Close accepted; want it refused")
+	}
+}
+
+func TestLogIngester_WriteRetries(t *testing.T) {
+	b := logBatch{results: []services.ResultLogEntry{{HostID: uuid.New()}}}
+
+	w := &recordingBatchWriter{failures: logIngestWriteAttempts - 1}
+	ing := newLogIngester(w, 1, 10, time.Minute)
+	ing.backoff = time.Millisecond
+	ing.write(b)
+	if w.calls != logIngestWriteAttempts || w.results != 1 {
+		t.Fatalf("calls = %d, results = %d; want the last attempt to succeed", w.calls, w.results)
+	}
+
+	w = &recordingBatchWriter{failures: logIngestWriteAttempts}
+	ing = newLogIngester(w, 1, 10, time.Minute)
+	ing.backoff = time.Millisecond
+	ing.write(b)
+	if w.calls != logIngestWriteAttempts || w.results != 0 {
+		t.Fatalf("calls = %d, results = %d; want %d attempts and the batch dropped", w.calls, w.results, logIngestWriteAttempts)
+	}
+}
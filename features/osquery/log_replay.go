@@ -0,0 +1,105 @@
+package osquery
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5/pgxpool"
+
+	"github.com/cavenine/queryops/config"
+	orgServices "github.com/cavenine/queryops/features/organization/services"
+	"github.com/cavenine/queryops/features/osquery/services"
+	"github.com/cavenine/queryops/internal/logarchive"
+	"github.com/cavenine/queryops/internal/objectstore"
+)
+
+// replayBatchSize is how many log lines a replay collects per transaction.
+const replayBatchSize = 1000
+
+// LogArchiveStore returns the bucket configured by the LOG_ARCHIVE_*
+// settings.
+func LogArchiveStore() (*objectstore.Client, error) {
+	store, err := objectstore.New(objectstore.Config{
+		Endpoint:        config.Global.LogArchiveEndpoint,
+		Region:          config.Global.LogArchiveRegion,
+		Bucket:          config.Global.LogArchiveBucket,
+		AccessKeyID:     config.Global.LogArchiveAccessKeyID,
+		SecretAccessKey: config.Global.LogArchiveSecretAccessKey,
+		PathStyle:       config.Global.LogArchivePathStyle,
+	}, nil)
+	if err != nil {
+		return nil, fmt.Errorf("configuring log archive: %w", err)
+	}
+	return store, nil
+}
+
+// logBatchImporter persists replayed log lines.
+type logBatchImporter interface {
+	ImportLogBatch(ctx context.Context, results []services.ResultLogEntry, statuses []services.StatusLogEntry) (int, error)
+}
+
+// ReplayStats summarizes a replay.
+type ReplayStats struct {
+	Objects  int
+	Requests int
+	Lines    int
+	// Skipped counts lines of hosts that have since been deleted.
+	Skipped int
+}
+
+// ReplayArchivedLogs re-ingests the /logger requests archived from the hour
+// containing from up to to, for org or, if nil, every organization. Lines
+// are redacted under the organization's current rules, as if just received,
+// but hosts' check-in times are left alone.
+//
+// Replaying a range that is still in Postgres stores its lines twice.
+func ReplayArchivedLogs(ctx context.Context, pool *pgxpool.Pool, src logarchive.Source, prefix string, org *uuid.UUID, from, to time.Time) (ReplayStats, error) {
+	return replayLogs(ctx, services.NewHostRepository(pool), orgServices.NewRedactionRuleRepository(pool), src, prefix, org, from, to)
+}
+
+func replayLogs(ctx context.Context, importer logBatchImporter, redaction resultRedactor, src logarchive.Source, prefix string, org *uuid.UUID, from, to time.Time) (ReplayStats, error) {
+	var (
+		stats     ReplayStats
+		pending   logBatch
+		redactors = make(map[uuid.UUID]*orgServices.Redactor)
+	)
+	flush := func() error {
+		if pending.len() == 0 {
+			return nil
+		}
+		skipped, err := importer.ImportLogBatch(ctx, pending.results, pending.statuses)
+		if err != nil {
+			return fmt.Errorf("importing replayed logs: %w", err)
+		}
+		stats.Lines += pending.len() - skipped
+		stats.Skipped += skipped
+		pending = logBatch{}
+		return nil
+	}
+
+	objects, err := logarchive.Replay(ctx, src, prefix, org, from, to, func(orgID uuid.UUID, r logarchive.Record) error {
+		redactor, ok := redactors[orgID]
+		if !ok {
+			var err error
+			if redactor, err = redaction.Redactor(ctx, orgID); err != nil {
+				return fmt.Errorf("loading redaction rules: %w", err)
+			}
+			redactors[orgID] = redactor
+		}
+		b := parseLogBatch(r.HostID, r.LogType, r.Data, redactor)
+		pending.results = append(pending.results, b.results...)
+		pending.statuses = append(pending.statuses, b.statuses...)
+		stats.Requests++
+		if pending.len() >= replayBatchSize {
+			return flush()
+		}
+		return nil
+	})
+	stats.Objects = objects
+	if err != nil {
+		return stats, err
+	}
+	return stats, flush()
+}
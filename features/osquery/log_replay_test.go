@@ -0,0 +1,124 @@
+package osquery
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+
+	orgServices "github.com/cavenine/queryops/features/organization/services"
+	"github.com/cavenine/queryops/features/osquery/services"
+	"github.com/cavenine/queryops/internal/logarchive"
+	"github.com/cavenine/queryops/internal/objectstore"
+)
+
+// memArchive is an in-memory bucket that only lists without a delimiter.
+type memArchive struct {
+	mu      sync.Mutex
+	objects map[string][]byte
+}
+
+func (s *memArchive) Put(_ context.Context, key string, body []byte, _ string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.objects[key] = append([]byte(nil), body...)
+	return nil
+}
+
+func (s *memArchive) Get(_ context.Context, key string) ([]byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.objects[key], nil
+}
+
+func (s *memArchive) List(_ context.Context, prefix, _ string) (*objectstore.Listing, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var listing objectstore.Listing
+	for key := range s.objects {
+		if strings.HasPrefix(key, prefix) {
+			listing.Keys = append(listing.Keys, key)
+		}
+	}
+	return &listing, nil
+}
+
+type recordingImporter struct {
+	gone     uuid.UUID // host whose lines are skipped
+	batches  int
+	results  []services.ResultLogEntry
+	statuses int
+	skipped  int
+}
+
+func (i *recordingImporter) ImportLogBatch(_ context.Context, results []services.ResultLogEntry, statuses []services.StatusLogEntry) (int, error) {
+	i.batches++
+	skipped := 0
+	for _, r := range results {
+		if r.HostID == i.gone {
+			skipped++
+			continue
+		}
+		i.results = append(i.results, r)
+	}
+	i.statuses += len(statuses)
+	return skipped, nil
+}
+
+type staticRedactor struct {
+	calls int
+}
+
+func (r *staticRedactor) Redactor(context.Context, uuid.UUID) (*orgServices.Redactor, error) {
+	r.calls++
+	return orgServices.NewRedactor([]orgServices.RedactionRule{
+		{Kind: orgServices.RedactColumn, Pattern: "password"},
+	}), nil
+}
+
+func TestReplayLogs(t *testing.T) {
+	store := &memArchive{objects: map[string][]byte{}}
+	archiver := logarchive.NewArchiver(store, "logs/", 10, 0, time.Hour)
+	done := make(chan struct{})
+	go func() {
+		archiver.Run()
+		close(done)
+	}()
+
+	org, host, gone := uuid.New(), uuid.New(), uuid.New()
+	at := time.Date(2026, 3, 4, 5, 6, 0, 0, time.UTC)
+	archiver.Enqueue(org, logarchive.Record{ReceivedAt: at, HostID: host, LogType: "result", Data: []json.RawMessage{
+		json.RawMessage(`{"name":"users","unixTime":10,"action":"added","columns":{"user":"root","password":"hunter2"}}`),
+	}})
+	archiver.Enqueue(org, logarchive.Record{ReceivedAt: at, HostID: host, LogType: "status", Data: []json.RawMessage{
+		json.RawMessage(`{"line":1,"message":"m","severity":0,"filename":"f.cpp","unixTime":"20"}`),
+	}})
+	archiver.Enqueue(org, logarchive.Record{ReceivedAt: at, HostID: gone, LogType: "result", Data: []json.RawMessage{
+		json.RawMessage(`{"name":"users","unixTime":10,"action":"added","columns":{"user":"root"}}`),
+	}})
+	archiver.Close()
+	<-done
+
+	importer := &recordingImporter{gone: gone}
+	redaction := &staticRedactor{}
+	stats, err := replayLogs(context.Background(), importer, redaction, store, "logs/", &org, at, at.Add(time.Hour))
+	if err != nil {
+		t.Fatalf("replayLogs: %v", err)
+	}
+	if stats != (ReplayStats{Objects: 1, Requests: 3, Lines: 2, Skipped: 1}) {
+		t.Fatalf("stats = %+v", stats)
+	}
+	if importer.batches != 1 || importer.statuses != 1 || len(importer.results) != 1 {
+		t.Fatalf("importer = %+v", importer)
+	}
+	if got, want := string(importer.results[0].Columns), `{"password":"[REDACTED]","user":"root"}`; got != want {
+		t.Fatalf("columns = %s, want %s", got, want)
+	}
+	if redaction.calls != 1 {
+		t.Fatalf("loaded redaction rules %d times, want once per organization", redaction.calls)
+	}
+}
@@ -0,0 +1,91 @@
+package osquery
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/cavenine/queryops/features/osquery/services"
+)
+
+type issuedAtHostRepo struct {
+	hostRepository
+
+	issuedAt time.Time
+}
+
+func (r *issuedAtHostRepo) GetByNodeKey(context.Context, string) (*services.Host, error) {
+	return &services.Host{ID: uuid.New(), HostIdentifier: "host-a", NodeKeyIssuedAt: r.issuedAt}, nil
+}
+
+func (r *issuedAtHostRepo) UpdateLastConfig(context.Context, string) error {
+	return nil
+}
+
+func (r *issuedAtHostRepo) GetConfigForHost(context.Context, string) (json.RawMessage, error) {
+	return json.RawMessage(`{"options":{}}`), nil
+}
+
+func TestConfig_RotatesExpiredNodeKeys(t *testing.T) {
+	tests := []struct {
+		name        string
+		maxAge      time.Duration
+		issuedAt    time.Time
+		wantInvalid bool
+	}{
+		{"fresh key", time.Hour, time.Now().Add(-time.Minute), false},
+		{"expired key", time.Hour, time.Now().Add(-2 * time.Hour), true},
+		{"rotation disabled", 0, time.Now().Add(-365 * 24 * time.Hour), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := NewHandlers(&issuedAtHostRepo{issuedAt: tt.issuedAt}, nil, nil, nil)
+			h.nodeKeyMaxAge = tt.maxAge
+
+			req := httptest.NewRequest(http.MethodPost, "/osquery/config", strings.NewReader(`{"node_key":"k"}`))
+			rec := httptest.NewRecorder()
+			h.Config(rec, req)
+
+			if rec.Code != http.StatusOK {
+				t.Fatalf("status = %d", rec.Code)
+			}
+			var resp ConfigResponse
+			if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+				t.Fatalf("unmarshal: %v", err)
+			}
+			if resp.NodeInvalid != tt.wantInvalid {
+				t.Fatalf("node_invalid = %v, want %v", resp.NodeInvalid, tt.wantInvalid)
+			}
+		})
+	}
+}
+
+func TestNodeKeyJitter(t *testing.T) {
+	const maxAge = 30 * 24 * time.Hour
+
+	hostID := uuid.New()
+	if a, b := nodeKeyJitter(hostID, maxAge), nodeKeyJitter(hostID, maxAge); a != b {
+		t.Fatalf("jitter is not stable per host: %v then %v", a, b)
+	}
+
+	seen := make(map[time.Duration]bool)
+	for range 100 {
+		j := nodeKeyJitter(uuid.New(), maxAge)
+		if j < 0 || j >= maxAge/10 {
+			t.Fatalf("jitter = %v, want within [0, %v)", j, maxAge/10)
+		}
+		seen[j] = true
+	}
+	if len(seen) < 90 {
+		t.Fatalf("only %d distinct delays for 100 hosts; want rotations spread out", len(seen))
+	}
+	if j := nodeKeyJitter(uuid.New(), 0); j != 0 {
+		t.Fatalf("jitter with rotation off = %v, want 0", j)
+	}
+}
@@ -0,0 +1,262 @@
+//go:build e2e
+
+package osquery_test
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"net"
+	"net/http/httptest"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+	"github.com/testcontainers/testcontainers-go"
+
+	orgServices "github.com/cavenine/queryops/features/organization/services"
+	"github.com/cavenine/queryops/features/osquery"
+	"github.com/cavenine/queryops/features/osquery/services"
+	"github.com/cavenine/queryops/internal/crypto"
+	"github.com/cavenine/queryops/internal/testdb"
+	"github.com/cavenine/queryops/internal/testdb/fixtures"
+)
+
+// defaultOsquerydImage is the osqueryd the end-to-end test runs unless
+// OSQUERYD_IMAGE names another.
+const defaultOsquerydImage = "osquery/osquery:5.13.1-ubuntu22.04"
+
+const (
+	e2eHostIdentifier = "e2e-host"
+	e2eEnrollSecret   = "e2e-enroll-secret"
+	e2eTimeout        = 2 * time.Minute
+)
+
+// TestOsquerydEndToEnd runs a real osqueryd against the TLS endpoints served
+// in-process: it enrolls, pulls its config, answers a campaign over the
+// distributed plugin, and ships its status logs.
+//
+// It needs Docker and is only built with -tags e2e.
+func TestOsquerydEndToEnd(t *testing.T) {
+	tdb := testdb.SetupTestDB(t)
+	ctx := t.Context()
+
+	orgID := fixtures.CreateOrg(t, tdb.Pool, "E2E Org").ID
+	fixtures.CreateEnrollSecret(t, tdb.Pool, orgID, e2eEnrollSecret)
+
+	certPEM, cert := e2eCertificate(t, testcontainers.HostInternal)
+	port := startOsqueryServer(t, tdb, cert)
+	startOsqueryd(t, port, certPEM)
+
+	hosts := services.NewHostRepository(tdb.Pool)
+
+	var host *services.Host
+	eventually(t, "osqueryd to enroll", func() bool {
+		list, err := hosts.ListByOrganization(ctx, orgID)
+		if err != nil {
+			t.Fatalf("ListByOrganization: %v", err)
+		}
+		for _, h := range list {
+			if h.HostIdentifier == e2eHostIdentifier && h.OsqueryVersion != "" {
+				host = h
+				return true
+			}
+		}
+		return false
+	})
+
+	campaignID, err := hosts.QueueQuery(ctx, orgID, nil, nil, nil,
+		"SELECT version FROM osquery_info;", []uuid.UUID{host.ID}, services.CampaignOptions{})
+	if err != nil {
+		t.Fatalf("QueueQuery: %v", err)
+	}
+	eventually(t, "the campaign to complete", func() bool {
+		c, err := hosts.GetCampaignByIDAndOrganization(ctx, campaignID, orgID)
+		if err != nil {
+			t.Fatalf("GetCampaignByIDAndOrganization: %v", err)
+		}
+		if c.Status == "failed" {
+			t.Fatalf("campaign failed")
+		}
+		return c.Status == "completed"
+	})
+
+	results, err := hosts.QueryCampaignResults(ctx, campaignID, services.CampaignResultView{})
+	if err != nil {
+		t.Fatalf("QueryCampaignResults: %v", err)
+	}
+	if len(results.Rows) != 1 {
+		t.Fatalf("campaign rows = %d, want 1", len(results.Rows))
+	}
+	row := results.Rows[0]
+	if row.HostID != host.ID || row.Values["version"] != host.OsqueryVersion {
+		t.Fatalf("campaign row = %+v, want host %s with version %q", row, host.ID, host.OsqueryVersion)
+	}
+
+	eventually(t, "osqueryd status logs", func() bool {
+		var n int
+		err := tdb.Pool.QueryRow(ctx, `SELECT count(*) FROM osquery_status_logs WHERE host_id = $1`, host.ID).Scan(&n)
+		if err != nil {
+			t.Fatalf("counting status logs: %v", err)
+		}
+		return n > 0
+	})
+}
+
+// startOsqueryServer serves the osquery feature's TLS endpoints with cert on
+// a loopback port, which the container reaches through HostAccessPorts, and
+// returns the port.
+func startOsqueryServer(t *testing.T, tdb *testdb.TestDB, cert tls.Certificate) int {
+	t.Helper()
+
+	spec, err := crypto.GenerateKey("test")
+	if err != nil {
+		t.Fatalf("GenerateKey: %v", err)
+	}
+	keys, err := crypto.ParseKeyring(spec)
+	if err != nil {
+		t.Fatalf("ParseKeyring: %v", err)
+	}
+	orgService := orgServices.NewOrganizationService(orgServices.NewOrganizationRepository(tdb.Pool, keys))
+
+	feature, err := osquery.NewFeature(t.Context(), tdb.Pool, nil, orgService, nil, nil)
+	if err != nil {
+		t.Fatalf("NewFeature: %v", err)
+	}
+	logs := feature.Logs()
+	go logs.Run()
+
+	router := chi.NewRouter()
+	feature.SetupRoutes(router)
+
+	srv := httptest.NewUnstartedServer(router)
+	srv.TLS = &tls.Config{Certificates: []tls.Certificate{cert}}
+	srv.StartTLS()
+	t.Cleanup(func() {
+		srv.Close()
+		logs.Close()
+	})
+
+	_, port, err := net.SplitHostPort(srv.Listener.Addr().String())
+	if err != nil {
+		t.Fatalf("server address: %v", err)
+	}
+	n, err := strconv.Atoi(port)
+	if err != nil {
+		t.Fatalf("server port %q: %v", srv.Listener.Addr(), err)
+	}
+	return n
+}
+
+// startOsqueryd runs osqueryd with every plugin pointed at the server on
+// port, polling fast enough to finish within e2eTimeout.
+func startOsqueryd(t *testing.T, port int, certPEM []byte) {
+	t.Helper()
+
+	image := os.Getenv("OSQUERYD_IMAGE")
+	if image == "" {
+		image = defaultOsquerydImage
+	}
+	ctr, err := testcontainers.GenericContainer(t.Context(), testcontainers.GenericContainerRequest{
+		ContainerRequest: testcontainers.ContainerRequest{
+			Image:           image,
+			HostAccessPorts: []int{port},
+			Files: []testcontainers.ContainerFile{
+				{Reader: bytes.NewReader(certPEM), ContainerFilePath: "/etc/osquery/server.pem", FileMode: 0o644},
+				{Reader: strings.NewReader(e2eEnrollSecret), ContainerFilePath: "/etc/osquery/enroll_secret", FileMode: 0o600},
+			},
+			Entrypoint: []string{"osqueryd"},
+			Cmd: []string{
+				fmt.Sprintf("--tls_hostname=%s:%d", testcontainers.HostInternal, port),
+				"--tls_server_certs=/etc/osquery/server.pem",
+				"--enroll_secret_path=/etc/osquery/enroll_secret",
+				"--enroll_tls_endpoint=/osquery/enroll",
+				"--config_plugin=tls",
+				"--config_tls_endpoint=/osquery/config",
+				"--config_refresh=5",
+				"--logger_plugin=tls",
+				"--logger_tls_endpoint=/osquery/logger",
+				"--logger_tls_period=3",
+				"--disable_distributed=false",
+				"--distributed_plugin=tls",
+				"--distributed_interval=3",
+				"--distributed_tls_read_endpoint=/osquery/distributed_read",
+				"--distributed_tls_write_endpoint=/osquery/distributed_write",
+				"--host_identifier=specified",
+				"--specified_identifier=" + e2eHostIdentifier,
+				"--ephemeral",
+				"--disable_watchdog",
+				"--database_path=/tmp/osquery.db",
+				"--pidfile=/tmp/osquery.pid",
+				"--verbose",
+			},
+		},
+		Started: true,
+	})
+	testcontainers.CleanupContainer(t, ctr)
+	if err != nil {
+		t.Fatalf("starting osqueryd: %v", err)
+	}
+}
+
+// e2eCertificate returns a self-signed certificate for host, PEM-encoded
+// for osqueryd to trust and parsed for the server to present.
+func e2eCertificate(t *testing.T, host string) ([]byte, tls.Certificate) {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("generating key: %v", err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: host},
+		DNSNames:              []string{host, "localhost"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("creating certificate: %v", err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatalf("marshaling key: %v", err)
+	}
+
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	cert, err := tls.X509KeyPair(certPEM, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}))
+	if err != nil {
+		t.Fatalf("loading key pair: %v", err)
+	}
+	return certPEM, cert
+}
+
+// eventually polls cond every second until it holds, failing the test after
+// e2eTimeout.
+func eventually(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+
+	deadline := time.Now().Add(e2eTimeout)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(time.Second)
+	}
+}
@@ -0,0 +1,239 @@
+package osquery
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+	"strconv"
+	"strings"
+
+	"go.yaml.in/yaml/v3"
+)
+
+// PackFile is the query content of an osquery config as a YAML document
+// teams can keep in git: its schedule and packs, in osquery's config format.
+// The config's options and decorators aren't part of it.
+//
+// ParsePackFile also reads a single osquery pack, the JSON files osquery
+// loads from its packs directory, as a file holding only that pack.
+type PackFile struct {
+	Schedule map[string]PackQuery `json:"schedule,omitempty" yaml:"schedule,omitempty"`
+	Packs    map[string]PackSpec  `json:"packs,omitempty" yaml:"packs,omitempty"`
+
+	// single is set when the file was one osquery pack, which adds to a
+	// config's packs rather than replacing them.
+	single bool
+}
+
+// PackSpec is a pack in a PackFile.
+type PackSpec struct {
+	Platform  string               `json:"platform,omitempty" yaml:"platform,omitempty"`
+	Version   string               `json:"version,omitempty" yaml:"version,omitempty"`
+	Shard     int                  `json:"shard,omitempty" yaml:"shard,omitempty"`
+	Discovery []string             `json:"discovery,omitempty" yaml:"discovery,omitempty"`
+	Queries   map[string]PackQuery `json:"queries" yaml:"queries"`
+}
+
+// PackQuery is a scheduled query in a PackFile. Description and Value
+// document the query for people; osquery ignores them.
+type PackQuery struct {
+	Query       string       `json:"query" yaml:"query"`
+	Interval    packInterval `json:"interval" yaml:"interval"`
+	Snapshot    bool         `json:"snapshot,omitempty" yaml:"snapshot,omitempty"`
+	Removed     *bool        `json:"removed,omitempty" yaml:"removed,omitempty"`
+	Platform    string       `json:"platform,omitempty" yaml:"platform,omitempty"`
+	Version     string       `json:"version,omitempty" yaml:"version,omitempty"`
+	Shard       int          `json:"shard,omitempty" yaml:"shard,omitempty"`
+	Denylist    *bool        `json:"denylist,omitempty" yaml:"denylist,omitempty"`
+	Description string       `json:"description,omitempty" yaml:"description,omitempty"`
+	Value       string       `json:"value,omitempty" yaml:"value,omitempty"`
+}
+
+// packInterval is a query's interval in seconds. osquery's own packs often
+// write it as a string, which is read the same.
+type packInterval int
+
+func (i *packInterval) UnmarshalYAML(node *yaml.Node) error {
+	n, err := strconv.Atoi(strings.TrimSpace(node.Value))
+	if node.Kind != yaml.ScalarNode || err != nil {
+		return fmt.Errorf("line %d: interval must be a whole number of seconds", node.Line)
+	}
+	*i = packInterval(n)
+	return nil
+}
+
+func (i *packInterval) UnmarshalJSON(data []byte) error {
+	s := string(data)
+	if unquoted, err := strconv.Unquote(s); err == nil {
+		s = unquoted
+	}
+	n, err := strconv.Atoi(strings.TrimSpace(s))
+	if err != nil {
+		return errors.New("interval must be a whole number of seconds")
+	}
+	*i = packInterval(n)
+	return nil
+}
+
+// ParsePackFile reads a pack file, or a single osquery pack, which becomes
+// the file's only pack under packName. YAML being a superset of JSON, both
+// can be either. Keys osquery doesn't define are rejected, to catch typos.
+func ParsePackFile(data []byte, packName string) (PackFile, error) {
+	var top map[string]yaml.Node
+	if err := yaml.Unmarshal(data, &top); err != nil {
+		return PackFile{}, fmt.Errorf("parsing pack file: %w", err)
+	}
+
+	var f PackFile
+	if _, ok := top["queries"]; ok {
+		packName = strings.TrimSpace(packName)
+		if packName == "" {
+			return PackFile{}, errors.New("a single pack needs a name")
+		}
+		var pack PackSpec
+		if err := decodeStrict(data, &pack); err != nil {
+			return PackFile{}, err
+		}
+		f = PackFile{Packs: map[string]PackSpec{packName: pack}, single: true}
+	} else if err := decodeStrict(data, &f); err != nil {
+		return PackFile{}, err
+	}
+
+	if err := f.validate(); err != nil {
+		return PackFile{}, err
+	}
+	return f, nil
+}
+
+func decodeStrict(data []byte, v any) error {
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	if err := dec.Decode(v); err != nil {
+		return fmt.Errorf("parsing pack file: %w", err)
+	}
+	return nil
+}
+
+func (f PackFile) validate() error {
+	var errs []error
+	check := func(where string, q PackQuery) {
+		if strings.TrimSpace(q.Query) == "" {
+			errs = append(errs, fmt.Errorf("%s: query is required", where))
+		}
+		if q.Interval <= 0 {
+			errs = append(errs, fmt.Errorf("%s: interval must be positive", where))
+		}
+	}
+	for _, name := range slices.Sorted(maps.Keys(f.Schedule)) {
+		check(fmt.Sprintf("schedule %q", name), f.Schedule[name])
+	}
+	for _, packName := range slices.Sorted(maps.Keys(f.Packs)) {
+		pack := f.Packs[packName]
+		if strings.TrimSpace(packName) == "" {
+			errs = append(errs, errors.New("packs need names"))
+		}
+		if len(pack.Queries) == 0 {
+			errs = append(errs, fmt.Errorf("pack %q: no queries", packName))
+		}
+		for _, name := range slices.Sorted(maps.Keys(pack.Queries)) {
+			check(fmt.Sprintf("pack %q query %q", packName, name), pack.Queries[name])
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// ExportPackFile returns the schedule and packs of a stored config as a pack
+// file. Importing it into the same config changes nothing.
+func ExportPackFile(config json.RawMessage) ([]byte, error) {
+	var f PackFile
+	if err := json.Unmarshal(config, &f); err != nil {
+		return nil, fmt.Errorf("reading config: %w", err)
+	}
+	var buf bytes.Buffer
+	enc := yaml.NewEncoder(&buf)
+	enc.SetIndent(2)
+	if err := enc.Encode(f); err != nil {
+		return nil, fmt.Errorf("encoding pack file: %w", err)
+	}
+	if err := enc.Close(); err != nil {
+		return nil, fmt.Errorf("encoding pack file: %w", err)
+	}
+	return buf.Bytes(), nil
+}
+
+// Apply returns config with the file's content imported: a file replaces the
+// config's schedule and packs, while a single pack replaces only the pack of
+// its name. Options, decorators, and anything else in config are kept.
+func (f PackFile) Apply(config json.RawMessage) (json.RawMessage, error) {
+	var keys map[string]json.RawMessage
+	if err := json.Unmarshal(config, &keys); err != nil {
+		return nil, fmt.Errorf("reading config: %w", err)
+	}
+	if keys == nil {
+		keys = map[string]json.RawMessage{}
+	}
+
+	set := func(key string, v any, empty bool) error {
+		if empty {
+			delete(keys, key)
+			return nil
+		}
+		b, err := json.Marshal(v)
+		if err != nil {
+			return fmt.Errorf("encoding %s: %w", key, err)
+		}
+		keys[key] = b
+		return nil
+	}
+
+	if f.single {
+		var packs map[string]json.RawMessage
+		if raw, ok := keys["packs"]; ok {
+			if err := json.Unmarshal(raw, &packs); err != nil {
+				return nil, fmt.Errorf("reading config packs: %w", err)
+			}
+		}
+		if packs == nil {
+			packs = map[string]json.RawMessage{}
+		}
+		for name, pack := range f.Packs {
+			b, err := json.Marshal(pack)
+			if err != nil {
+				return nil, fmt.Errorf("encoding pack %q: %w", name, err)
+			}
+			packs[name] = b
+		}
+		if err := set("packs", packs, false); err != nil {
+			return nil, err
+		}
+	} else {
+		if err := set("schedule", f.Schedule, len(f.Schedule) == 0); err != nil {
+			return nil, err
+		}
+		if err := set("packs", f.Packs, len(f.Packs) == 0); err != nil {
+			return nil, err
+		}
+	}
+
+	return json.Marshal(keys)
+}
+
+// Summary describes the file's content, as "3 scheduled queries and 2 packs".
+func (f PackFile) Summary() string {
+	count := func(n int, one, many string) string {
+		if n == 1 {
+			return "1 " + one
+		}
+		return strconv.Itoa(n) + " " + many
+	}
+	switch {
+	case len(f.Schedule) == 0:
+		return count(len(f.Packs), "pack", "packs")
+	case len(f.Packs) == 0:
+		return count(len(f.Schedule), "scheduled query", "scheduled queries")
+	}
+	return count(len(f.Schedule), "scheduled query", "scheduled queries") + " and " + count(len(f.Packs), "pack", "packs")
+}
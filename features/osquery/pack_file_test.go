@@ -0,0 +1,145 @@
+package osquery
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestPackFile_RoundTrip(t *testing.T) {
+	config := json.RawMessage(`{
+		"options": {"distributed_interval": 10},
+		"schedule": {"uptime": {"query": "SELECT * FROM uptime;", "interval": 60}},
+		"packs": {
+			"ir": {
+				"platform": "linux",
+				"discovery": ["SELECT 1 FROM system_info;"],
+				"queries": {
+					"procs": {
+						"query": "SELECT pid,\n  name\nFROM processes;",
+						"interval": 300,
+						"snapshot": true,
+						"description": "Running processes"
+					}
+				}
+			}
+		}
+	}`)
+
+	exported, err := ExportPackFile(config)
+	if err != nil {
+		t.Fatalf("ExportPackFile: %v", err)
+	}
+	if strings.Contains(string(exported), "options") {
+		t.Fatalf("export has options:\n%s", exported)
+	}
+	// Multi-line queries stay readable in git.
+	if !strings.Contains(string(exported), "query: |-") {
+		t.Fatalf("multi-line query not a block scalar:\n%s", exported)
+	}
+
+	f, err := ParsePackFile(exported, "")
+	if err != nil {
+		t.Fatalf("ParsePackFile: %v", err)
+	}
+	imported, err := f.Apply(json.RawMessage(`{"options": {"distributed_interval": 10}, "packs": {"old": {"queries": {}}}}`))
+	if err != nil {
+		t.Fatalf("Apply: %v", err)
+	}
+	again, err := ExportPackFile(imported)
+	if err != nil {
+		t.Fatalf("ExportPackFile(imported): %v", err)
+	}
+	if string(again) != string(exported) {
+		t.Fatalf("round trip changed the file:\n%s\nwant:\n%s", again, exported)
+	}
+
+	var keys map[string]json.RawMessage
+	if err := json.Unmarshal(imported, &keys); err != nil {
+		t.Fatalf("imported config: %v", err)
+	}
+	if string(keys["options"]) != `{"distributed_interval":10}` {
+		t.Fatalf("options = %s, want them kept", keys["options"])
+	}
+	// The config is still one the /config endpoint can serve.
+	var resp ConfigResponse
+	if err := json.Unmarshal(imported, &resp); err != nil {
+		t.Fatalf("imported config doesn't decode: %v", err)
+	}
+	if q := resp.Packs["ir"].Queries["procs"]; q.Interval != 300 || !q.Snapshot {
+		t.Fatalf("procs = %+v", q)
+	}
+	if _, ok := resp.Packs["old"]; ok {
+		t.Fatal("pack missing from the file was kept")
+	}
+}
+
+func TestParsePackFile_OsqueryPack(t *testing.T) {
+	// As in osquery's own packs: JSON, with intervals as strings.
+	pack := `{
+		"platform": "darwin",
+		"version": "1.4.5",
+		"queries": {
+			"launchd": {"query": "SELECT * FROM launchd;", "interval": "3600", "removed": false}
+		}
+	}`
+	f, err := ParsePackFile([]byte(pack), "osx-attacks")
+	if err != nil {
+		t.Fatalf("ParsePackFile: %v", err)
+	}
+	if q := f.Packs["osx-attacks"].Queries["launchd"]; q.Interval != 3600 || q.Removed == nil || *q.Removed {
+		t.Fatalf("launchd = %+v", q)
+	}
+
+	config, err := f.Apply(json.RawMessage(`{"schedule": {"uptime": {"query": "SELECT 1;", "interval": 60}}, "packs": {"other": {"queries": {"q": {"query": "SELECT 1;", "interval": 60}}}}}`))
+	if err != nil {
+		t.Fatalf("Apply: %v", err)
+	}
+	var resp ConfigResponse
+	if err := json.Unmarshal(config, &resp); err != nil {
+		t.Fatalf("config doesn't decode: %v", err)
+	}
+	if len(resp.Schedule) != 1 || len(resp.Packs) != 2 || resp.Packs["osx-attacks"].Platform != "darwin" {
+		t.Fatalf("config = %+v, want the pack added alongside the rest", resp)
+	}
+
+	if _, err := ParsePackFile([]byte(pack), " "); err == nil {
+		t.Fatal("single pack without a name accepted")
+	}
+}
+
+func TestParsePackFile_Invalid(t *testing.T) {
+	for name, tc := range map[string]struct {
+		file, want string
+	}{
+		"typo": {
+			"schedule:\n  uptime:\n    query: SELECT 1;\n    intervall: 60\n",
+			"intervall",
+		},
+		"no interval": {
+			"schedule:\n  uptime:\n    query: SELECT 1;\n",
+			`schedule "uptime": interval must be positive`,
+		},
+		"no query": {
+			"packs:\n  ir:\n    queries:\n      procs:\n        interval: 60\n",
+			`pack "ir" query "procs": query is required`,
+		},
+		"empty pack": {
+			"packs:\n  ir:\n    queries: {}\n",
+			`pack "ir": no queries`,
+		},
+		"bad interval": {
+			"schedule:\n  uptime:\n    query: SELECT 1;\n    interval: hourly\n",
+			"whole number of seconds",
+		},
+		"options": {
+			"options:\n  distributed_interval: 10\n",
+			"options",
+		},
+	} {
+		_, err := ParsePackFile([]byte(tc.file), "")
+		if err == nil || !strings.Contains(err.Error(), tc.want) {
+			t.Errorf("%s: err = %v, want it to mention %q", name, err, tc.want)
+		}
+	}
+}
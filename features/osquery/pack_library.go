@@ -0,0 +1,249 @@
+package osquery
+
+import (
+	"bytes"
+	"context"
+	"embed"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"maps"
+	"net/http"
+	"slices"
+	"sync"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+
+	"github.com/cavenine/queryops/features/auth"
+	org "github.com/cavenine/queryops/features/organization"
+	"github.com/cavenine/queryops/features/osquery/pages"
+	"github.com/cavenine/queryops/features/osquery/services"
+)
+
+// libraryFiles are the query library's packs, curated from osquery's
+// community packs and limited to tables in the bundled schema.
+//
+//go:embed library/*.conf
+var libraryFiles embed.FS
+
+// organizationPackRepository stores the library packs each organization has
+// enabled.
+type organizationPackRepository interface {
+	ListOrganizationPacks(ctx context.Context, organizationID uuid.UUID) ([]services.OrganizationPack, error)
+	EnableOrganizationPack(ctx context.Context, organizationID uuid.UUID, name string, pack json.RawMessage, enabledBy *int) error
+	DisableOrganizationPack(ctx context.Context, organizationID uuid.UUID, name string) (bool, error)
+}
+
+// LibraryPack is a pack from the query library, which organizations enable
+// to add it to the config their hosts are served.
+type LibraryPack struct {
+	Name        string
+	Title       string
+	Description string
+	Pack        PackSpec
+}
+
+// libraryPacks describes the library's packs, in the order they're listed.
+// Each is library/<name>.conf.
+var libraryPacks = []LibraryPack{
+	{
+		Name:        "incident-response",
+		Title:       "Incident response",
+		Description: "Persistence mechanisms, logins, listening ports, and network connections, to spot an intrusion and scope it.",
+	},
+	{
+		Name:        "it-compliance",
+		Title:       "IT compliance",
+		Description: "Disk encryption, local accounts, certificates, and security settings, to check hosts against policy.",
+	},
+	{
+		Name:        "vuln-management",
+		Title:       "Vulnerability management",
+		Description: "Kernels, operating systems, installed packages, and patches, to match against known vulnerabilities.",
+	},
+}
+
+var loadLibrary = sync.OnceValues(func() ([]LibraryPack, error) {
+	library := make([]LibraryPack, 0, len(libraryPacks))
+	for _, p := range libraryPacks {
+		data, err := libraryFiles.ReadFile("library/" + p.Name + ".conf")
+		if err != nil {
+			return nil, fmt.Errorf("reading library pack %s: %w", p.Name, err)
+		}
+		f, err := ParsePackFile(data, p.Name)
+		if err != nil {
+			return nil, fmt.Errorf("library pack %s: %w", p.Name, err)
+		}
+		p.Pack = f.Packs[p.Name]
+		library = append(library, p)
+	}
+	return library, nil
+})
+
+// Library returns the query library's packs.
+func Library() ([]LibraryPack, error) {
+	return loadLibrary()
+}
+
+// libraryPack returns the library pack named name, if there is one.
+func libraryPack(name string) (LibraryPack, bool, error) {
+	library, err := Library()
+	if err != nil {
+		return LibraryPack{}, false, err
+	}
+	for _, p := range library {
+		if p.Name == name {
+			return p, true, nil
+		}
+	}
+	return LibraryPack{}, false, nil
+}
+
+// outdated reports whether an enabled copy of the pack differs from the
+// library's.
+func (p LibraryPack) outdated(enabled json.RawMessage) bool {
+	var copied PackSpec
+	if err := json.Unmarshal(enabled, &copied); err != nil {
+		return true
+	}
+	// Both are encoded the same way, so only their content can differ.
+	a, errA := json.Marshal(copied)
+	b, errB := json.Marshal(p.Pack)
+	return errA != nil || errB != nil || !bytes.Equal(a, b)
+}
+
+// PackLibraryPage lists the query library's packs and which of them the
+// organization has enabled.
+func (h *Handlers) PackLibraryPage(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	activeOrg := org.GetOrganizationFromContext(ctx)
+	if activeOrg == nil {
+		slog.Error("missing active organization in context")
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+
+	library, err := Library()
+	if err != nil {
+		slog.ErrorContext(ctx, "failed to load query library", "error", err)
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+	enabled, err := h.packs.ListOrganizationPacks(ctx, activeOrg.ID)
+	if err != nil {
+		slog.ErrorContext(ctx, "failed to list organization packs", "error", err)
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+
+	list := make([]pages.LibraryPack, 0, len(library))
+	for _, p := range library {
+		entry := pages.LibraryPack{
+			Name:        p.Name,
+			Title:       p.Title,
+			Description: p.Description,
+		}
+		for _, name := range slices.Sorted(maps.Keys(p.Pack.Queries)) {
+			q := p.Pack.Queries[name]
+			entry.Queries = append(entry.Queries, pages.LibraryQuery{
+				Name:        name,
+				Platform:    q.Platform,
+				Interval:    int(q.Interval),
+				Description: q.Description,
+			})
+		}
+		if i := slices.IndexFunc(enabled, func(e services.OrganizationPack) bool { return e.Name == p.Name }); i >= 0 {
+			entry.Enabled = true
+			entry.Outdated = p.outdated(enabled[i].Pack)
+			entry.EnabledBy = enabled[i].EnabledByEmail
+			entry.EnabledAt = enabled[i].EnabledAt
+		}
+		list = append(list, entry)
+	}
+
+	if err := pages.PackLibraryPage(list).Render(ctx, w); err != nil {
+		slog.ErrorContext(ctx, "failed to render query library page", "error", err)
+	}
+}
+
+// EnableLibraryPack copies a library pack into the organization's packs, or
+// replaces the copy enabled earlier with the library's current version.
+func (h *Handlers) EnableLibraryPack(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	activeOrg := org.GetOrganizationFromContext(ctx)
+	if activeOrg == nil {
+		slog.Error("missing active organization in context")
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+
+	name := chi.URLParam(r, "name")
+	p, ok, err := libraryPack(name)
+	if err != nil {
+		slog.ErrorContext(ctx, "failed to load query library", "error", err)
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+	if !ok {
+		http.Error(w, "pack not found", http.StatusNotFound)
+		return
+	}
+	pack, err := json.Marshal(p.Pack)
+	if err != nil {
+		slog.ErrorContext(ctx, "failed to encode library pack", "error", err, "pack", name)
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+
+	var enabledBy *int
+	if user := auth.GetUserFromContext(ctx); user != nil {
+		enabledBy = &user.ID
+	}
+	if err := h.packs.EnableOrganizationPack(ctx, activeOrg.ID, name, pack, enabledBy); err != nil {
+		slog.ErrorContext(ctx, "failed to enable library pack", "error", err, "pack", name)
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+	slog.InfoContext(ctx, "library pack enabled",
+		"organization_id", activeOrg.ID,
+		"pack", name,
+		"actor_user_id", enabledBy,
+	)
+
+	http.Redirect(w, r, "/packs", http.StatusSeeOther)
+}
+
+// DisableLibraryPack removes a library pack from the organization's packs.
+func (h *Handlers) DisableLibraryPack(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	activeOrg := org.GetOrganizationFromContext(ctx)
+	if activeOrg == nil {
+		slog.Error("missing active organization in context")
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+
+	name := chi.URLParam(r, "name")
+	disabled, err := h.packs.DisableOrganizationPack(ctx, activeOrg.ID, name)
+	if err != nil {
+		slog.ErrorContext(ctx, "failed to disable library pack", "error", err, "pack", name)
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+	if !disabled {
+		http.Error(w, "pack not enabled", http.StatusNotFound)
+		return
+	}
+	var actor *int
+	if user := auth.GetUserFromContext(ctx); user != nil {
+		actor = &user.ID
+	}
+	slog.InfoContext(ctx, "library pack disabled",
+		"organization_id", activeOrg.ID,
+		"pack", name,
+		"actor_user_id", actor,
+	)
+
+	http.Redirect(w, r, "/packs", http.StatusSeeOther)
+}
@@ -0,0 +1,159 @@
+package osquery
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+
+	"github.com/cavenine/queryops/features/auth"
+	authServices "github.com/cavenine/queryops/features/auth/services"
+	org "github.com/cavenine/queryops/features/organization"
+	orgServices "github.com/cavenine/queryops/features/organization/services"
+	"github.com/cavenine/queryops/features/osquery/schema"
+	"github.com/cavenine/queryops/features/osquery/services"
+)
+
+var queryTables = regexp.MustCompile(`(?i)\b(?:from|join)\s+([a-z_]+)`)
+
+func TestLibrary(t *testing.T) {
+	library, err := Library()
+	if err != nil {
+		t.Fatalf("Library: %v", err)
+	}
+	if len(library) != len(libraryPacks) {
+		t.Fatalf("library has %d packs, want %d", len(library), len(libraryPacks))
+	}
+
+	tables := map[string]map[string]bool{}
+	for _, platform := range schema.Platforms {
+		list, err := schema.Tables(platform)
+		if err != nil {
+			t.Fatalf("schema.Tables(%s): %v", platform, err)
+		}
+		tables[platform] = map[string]bool{}
+		for _, table := range list {
+			tables[platform][table.Name] = true
+		}
+	}
+
+	// Every query only reads tables osquery has on the platforms it runs on,
+	// so no host is sent a query that fails.
+	for _, p := range library {
+		if len(p.Pack.Queries) == 0 {
+			t.Errorf("%s: no queries", p.Name)
+		}
+		for name, q := range p.Pack.Queries {
+			if q.Description == "" {
+				t.Errorf("%s %s: no description", p.Name, name)
+			}
+			for _, platform := range schema.Platforms {
+				if !platformAllows(q.Platform, platform) {
+					continue
+				}
+				for _, m := range queryTables.FindAllStringSubmatch(q.Query, -1) {
+					if !tables[platform][strings.ToLower(m[1])] {
+						t.Errorf("%s %s: no %s table on %s", p.Name, name, m[1], platform)
+					}
+				}
+			}
+		}
+	}
+}
+
+// memoryOrgPacks stores enabled packs in memory for one organization.
+type memoryOrgPacks struct {
+	orgID uuid.UUID
+	packs map[string]json.RawMessage
+}
+
+func (m *memoryOrgPacks) ListOrganizationPacks(_ context.Context, organizationID uuid.UUID) ([]services.OrganizationPack, error) {
+	var packs []services.OrganizationPack
+	if organizationID != m.orgID {
+		return nil, nil
+	}
+	for name, pack := range m.packs {
+		packs = append(packs, services.OrganizationPack{Name: name, Pack: pack, EnabledAt: time.Now()})
+	}
+	return packs, nil
+}
+
+func (m *memoryOrgPacks) EnableOrganizationPack(_ context.Context, organizationID uuid.UUID, name string, pack json.RawMessage, _ *int) error {
+	if organizationID == m.orgID {
+		m.packs[name] = pack
+	}
+	return nil
+}
+
+func (m *memoryOrgPacks) DisableOrganizationPack(_ context.Context, organizationID uuid.UUID, name string) (bool, error) {
+	_, ok := m.packs[name]
+	if organizationID != m.orgID || !ok {
+		return false, nil
+	}
+	delete(m.packs, name)
+	return true, nil
+}
+
+func TestLibraryPacks(t *testing.T) {
+	repo := &memoryOrgPacks{orgID: uuid.New(), packs: map[string]json.RawMessage{}}
+	h := NewHandlers(&groupTestHostRepo{}, nil, nil, nil)
+	h.packs = repo
+	router := chi.NewRouter()
+	router.Get("/packs", h.PackLibraryPage)
+	router.Post("/packs/{name}/enable", h.EnableLibraryPack)
+	router.Post("/packs/{name}/disable", h.DisableLibraryPack)
+
+	serve := func(method, target string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, target, nil)
+		ctx := org.SetOrganizationInContext(req.Context(), &orgServices.Organization{ID: repo.orgID, Role: orgServices.RoleAdmin})
+		ctx = auth.SetUserInContext(ctx, &authServices.User{ID: 1, Email: "admin@example.com"})
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req.WithContext(ctx))
+		return rec
+	}
+
+	rec := serve(http.MethodGet, "/packs")
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), "incident-response") || strings.Contains(rec.Body.String(), "Disable") {
+		t.Fatalf("library page = %d, want every pack listed and none enabled", rec.Code)
+	}
+
+	if rec := serve(http.MethodPost, "/packs/incident-response/enable"); rec.Code != http.StatusSeeOther {
+		t.Fatalf("enable = %d %q", rec.Code, rec.Body.String())
+	}
+	var enabled PackSpec
+	if err := json.Unmarshal(repo.packs["incident-response"], &enabled); err != nil || enabled.Queries["launchd"].Platform != "darwin" {
+		t.Fatalf("enabled pack = %s, %v", repo.packs["incident-response"], err)
+	}
+	// The copy is a pack the /config endpoint can serve.
+	var served ConfigResponse
+	if err := json.Unmarshal([]byte(`{"packs":{"incident-response":`+string(repo.packs["incident-response"])+`}}`), &served); err != nil || served.Packs["incident-response"].Queries["launchd"].Interval != 3600 {
+		t.Fatalf("enabled pack doesn't decode as a config pack: %+v, %v", served, err)
+	}
+	rec = serve(http.MethodGet, "/packs")
+	if !strings.Contains(rec.Body.String(), "Disable") || strings.Contains(rec.Body.String(), "Update available") {
+		t.Fatalf("library page after enabling doesn't show the pack as enabled")
+	}
+
+	// A copy that no longer matches the library can be updated.
+	repo.packs["incident-response"] = json.RawMessage(`{"queries":{"old":{"query":"SELECT 1;","interval":60}}}`)
+	if rec := serve(http.MethodGet, "/packs"); !strings.Contains(rec.Body.String(), "Update available") {
+		t.Fatal("library page doesn't offer to update an outdated pack")
+	}
+
+	if rec := serve(http.MethodPost, "/packs/incident-response/disable"); rec.Code != http.StatusSeeOther || len(repo.packs) != 0 {
+		t.Fatalf("disable = %d, packs = %v", rec.Code, repo.packs)
+	}
+	if rec := serve(http.MethodPost, "/packs/incident-response/disable"); rec.Code != http.StatusNotFound {
+		t.Fatalf("disable again = %d, want 404", rec.Code)
+	}
+	if rec := serve(http.MethodPost, "/packs/nope/enable"); rec.Code != http.StatusNotFound {
+		t.Fatalf("enable unknown pack = %d, want 404", rec.Code)
+	}
+}
@@ -0,0 +1,154 @@
+package osquery
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+
+	"github.com/cavenine/queryops/features/auth"
+	"github.com/cavenine/queryops/features/osquery/pages"
+	"github.com/cavenine/queryops/features/osquery/services"
+	"github.com/cavenine/queryops/internal/validate"
+)
+
+// packUploadMemory is how much of an uploaded pack file is held in memory
+// rather than a temporary file. MAX_BODY_BYTES bounds the upload.
+const packUploadMemory = 1 << 20
+
+// configRepository reads and writes the osquery configs every organization
+// shares.
+type configRepository interface {
+	ListConfigs(ctx context.Context) ([]services.OsqueryConfig, error)
+	GetConfig(ctx context.Context, name string) (json.RawMessage, error)
+	UpdateConfig(ctx context.Context, name string, update func(json.RawMessage) (json.RawMessage, error)) error
+}
+
+// PacksPage lists the osquery configs with links to export their packs, and
+// a form to import a pack file.
+func (h *Handlers) PacksPage(w http.ResponseWriter, r *http.Request) {
+	h.renderPacks(w, r, http.StatusOK, pages.PacksProps{
+		Config:   r.URL.Query().Get("config"),
+		Imported: r.URL.Query().Get("imported"),
+	})
+}
+
+func (h *Handlers) renderPacks(w http.ResponseWriter, r *http.Request, status int, props pages.PacksProps) {
+	configs, err := h.configs.ListConfigs(r.Context())
+	if err != nil {
+		slog.ErrorContext(r.Context(), "failed to list osquery configs", "error", err)
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+	props.Configs = configs
+	if props.Config == "" {
+		props.Config = "default"
+	}
+	w.WriteHeader(status)
+	if err := pages.PacksPage(props).Render(r.Context(), w); err != nil {
+		slog.ErrorContext(r.Context(), "failed to render packs page", "error", err)
+	}
+}
+
+// ExportPacks downloads a config's schedule and packs as a pack file.
+func (h *Handlers) ExportPacks(w http.ResponseWriter, r *http.Request) {
+	// chi matches the escaped path, so a name with a slash arrives escaped.
+	name, err := url.PathUnescape(chi.URLParam(r, "name"))
+	if err != nil {
+		http.Error(w, "config not found", http.StatusNotFound)
+		return
+	}
+	config, err := h.configs.GetConfig(r.Context(), name)
+	if errors.Is(err, services.ErrConfigNotFound) {
+		http.Error(w, "config not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		slog.ErrorContext(r.Context(), "failed to get osquery config", "error", err, "config", name)
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+	data, err := ExportPackFile(config)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "failed to export packs", "error", err, "config", name)
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/yaml")
+	w.Header().Set("Content-Disposition", `attachment; filename="`+strings.ReplaceAll(name, `"`, "")+`.yaml"`)
+	_, _ = w.Write(data)
+}
+
+// ImportPacks loads an uploaded pack file into a config, creating the config
+// if there's none by that name. A single osquery pack is named after its
+// file unless the form names it.
+func (h *Handlers) ImportPacks(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	user := auth.GetUserFromContext(ctx)
+	if user == nil {
+		slog.ErrorContext(ctx, "missing user in context")
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+	if err := r.ParseMultipartForm(packUploadMemory); err != nil {
+		http.Error(w, "invalid form data", http.StatusBadRequest)
+		return
+	}
+
+	props := pages.PacksProps{
+		Config: strings.TrimSpace(r.PostFormValue("config")),
+		Pack:   strings.TrimSpace(r.PostFormValue("pack")),
+		Fields: validate.Errors{},
+	}
+	props.Fields.Check(props.Config != "", "config", validate.MsgRequired)
+
+	var data []byte
+	file, header, err := r.FormFile("file")
+	if err != nil {
+		props.Fields.Add("file", "Choose a pack file")
+	} else {
+		defer file.Close()
+		data, err = io.ReadAll(file)
+		if err != nil {
+			http.Error(w, "invalid form data", http.StatusBadRequest)
+			return
+		}
+	}
+	if len(props.Fields) > 0 {
+		h.renderPacks(w, r, http.StatusUnprocessableEntity, props)
+		return
+	}
+
+	packName := props.Pack
+	if packName == "" {
+		packName = strings.TrimSuffix(path.Base(header.Filename), path.Ext(header.Filename))
+	}
+	f, err := ParsePackFile(data, packName)
+	if err != nil {
+		props.Error = err.Error()
+		h.renderPacks(w, r, http.StatusUnprocessableEntity, props)
+		return
+	}
+
+	if err := h.configs.UpdateConfig(ctx, props.Config, f.Apply); err != nil {
+		slog.ErrorContext(ctx, "failed to import packs", "error", err, "config", props.Config)
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+	slog.InfoContext(ctx, "packs imported",
+		"config", props.Config,
+		"file", header.Filename,
+		"content", f.Summary(),
+		"actor_user_id", user.ID,
+	)
+
+	http.Redirect(w, r, "/admin/packs?"+url.Values{"config": {props.Config}, "imported": {f.Summary()}}.Encode(), http.StatusSeeOther)
+}
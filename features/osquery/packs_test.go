@@ -0,0 +1,123 @@
+package osquery
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+
+	"github.com/cavenine/queryops/features/auth"
+	authServices "github.com/cavenine/queryops/features/auth/services"
+	"github.com/cavenine/queryops/features/osquery/services"
+)
+
+// memoryConfigs stores osquery configs by name.
+type memoryConfigs map[string]json.RawMessage
+
+func (c memoryConfigs) ListConfigs(context.Context) ([]services.OsqueryConfig, error) {
+	var configs []services.OsqueryConfig
+	for name := range c {
+		configs = append(configs, services.OsqueryConfig{Name: name})
+	}
+	return configs, nil
+}
+
+func (c memoryConfigs) GetConfig(_ context.Context, name string) (json.RawMessage, error) {
+	config, ok := c[name]
+	if !ok {
+		return nil, services.ErrConfigNotFound
+	}
+	return config, nil
+}
+
+func (c memoryConfigs) UpdateConfig(_ context.Context, name string, update func(json.RawMessage) (json.RawMessage, error)) error {
+	config, ok := c[name]
+	if !ok {
+		config = json.RawMessage(`{}`)
+	}
+	config, err := update(config)
+	if err != nil {
+		return err
+	}
+	c[name] = config
+	return nil
+}
+
+func TestImportExportPacks(t *testing.T) {
+	configs := memoryConfigs{
+		"default": json.RawMessage(`{"options":{"config_refresh":60},"schedule":{"uptime":{"query":"SELECT * FROM uptime;","interval":60}}}`),
+	}
+	h := NewHandlers(&groupTestHostRepo{}, nil, nil, nil)
+	h.configs = configs
+	router := chi.NewRouter()
+	router.Get("/admin/packs", h.PacksPage)
+	router.Post("/admin/packs/import", h.ImportPacks)
+	router.Get("/admin/packs/{name}/export", h.ExportPacks)
+
+	serve := func(req *http.Request) *httptest.ResponseRecorder {
+		req = req.WithContext(auth.SetUserInContext(req.Context(), &authServices.User{ID: 1, Email: "root@example.com"}))
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		return rec
+	}
+	upload := func(config, pack, filename, content string) *httptest.ResponseRecorder {
+		var body bytes.Buffer
+		mw := multipart.NewWriter(&body)
+		_ = mw.WriteField("config", config)
+		_ = mw.WriteField("pack", pack)
+		if filename != "" {
+			fw, _ := mw.CreateFormFile("file", filename)
+			_, _ = fw.Write([]byte(content))
+		}
+		_ = mw.Close()
+		req := httptest.NewRequest(http.MethodPost, "/admin/packs/import", &body)
+		req.Header.Set("Content-Type", mw.FormDataContentType())
+		return serve(req)
+	}
+
+	rec := serve(httptest.NewRequest(http.MethodGet, "/admin/packs/default/export", nil))
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), "uptime:") {
+		t.Fatalf("export = %d %q", rec.Code, rec.Body.String())
+	}
+	if got := rec.Header().Get("Content-Disposition"); got != `attachment; filename="default.yaml"` {
+		t.Fatalf("Content-Disposition = %q", got)
+	}
+	exported := rec.Body.String()
+
+	// A single osquery pack is named after its file.
+	rec = upload("default", "", "incident-response.conf", `{"queries":{"procs":{"query":"SELECT * FROM processes;","interval":"3600"}}}`)
+	if rec.Code != http.StatusSeeOther || !strings.HasPrefix(rec.Header().Get("Location"), "/admin/packs?") {
+		t.Fatalf("import pack = %d %q", rec.Code, rec.Body.String())
+	}
+	var resp ConfigResponse
+	if err := json.Unmarshal(configs["default"], &resp); err != nil {
+		t.Fatalf("config: %v", err)
+	}
+	if resp.Packs["incident-response"].Queries["procs"].Interval != 3600 || resp.Schedule["uptime"].Query == "" || resp.Options["config_refresh"] != float64(60) {
+		t.Fatalf("config after pack import = %s", configs["default"])
+	}
+
+	// Importing the earlier export puts the config back as it was.
+	if rec := upload("default", "", "default.yaml", exported); rec.Code != http.StatusSeeOther {
+		t.Fatalf("import export = %d %q", rec.Code, rec.Body.String())
+	}
+	if rec := serve(httptest.NewRequest(http.MethodGet, "/admin/packs/default/export", nil)); rec.Body.String() != exported {
+		t.Fatalf("export after import = %q, want %q", rec.Body.String(), exported)
+	}
+
+	if rec := upload("default", "", "bad.yaml", "schedule:\n  uptime:\n    query: SELECT 1;\n"); rec.Code != http.StatusUnprocessableEntity || !strings.Contains(rec.Body.String(), "interval must be positive") {
+		t.Fatalf("invalid file = %d, want 422 explaining why", rec.Code)
+	}
+	if rec := upload("", "", "", ""); rec.Code != http.StatusUnprocessableEntity {
+		t.Fatalf("empty form = %d, want 422", rec.Code)
+	}
+	if rec := serve(httptest.NewRequest(http.MethodGet, "/admin/packs/missing/export", nil)); rec.Code != http.StatusNotFound {
+		t.Fatalf("missing config export = %d, want 404", rec.Code)
+	}
+}
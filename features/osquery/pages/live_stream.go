@@ -0,0 +1,15 @@
+package pages
+
+import "fmt"
+
+// LiveStream is the data-init expression that starts a live table's stream
+// from the URL formatted like datastar.GetSSE's. It streams over SSE with
+// @get unless live-stream.js has found SSE buffered by a proxy, in which case
+// the same events arrive over a WebSocket.
+func LiveStream(urlFormat string, args ...any) string {
+	url := fmt.Sprintf(urlFormat, args...)
+	return fmt.Sprintf(
+		`window.queryopsLive.connect(el, '%[1]s') || @get('%[1]s', {requestCancellation: window.queryopsLive.watch(el, '%[1]s')})`,
+		url,
+	)
+}
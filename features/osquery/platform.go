@@ -0,0 +1,56 @@
+package osquery
+
+import "strings"
+
+// filterForPlatform drops the scheduled queries and packs in cfg whose
+// platform constraint excludes the host's platform, one of schema.Platforms.
+// A pack left without queries is dropped too. If the host's platform is
+// unknown nothing is dropped; osquery still skips queries for other platforms
+// when it loads the config.
+func filterForPlatform(cfg *ConfigResponse, platform string) {
+	if platform == "" {
+		return
+	}
+	for name, q := range cfg.Schedule {
+		if !platformAllows(q.Platform, platform) {
+			delete(cfg.Schedule, name)
+		}
+	}
+	for name, pack := range cfg.Packs {
+		if !platformAllows(pack.Platform, platform) {
+			delete(cfg.Packs, name)
+			continue
+		}
+		for queryName, q := range pack.Queries {
+			if !platformAllows(q.Platform, platform) {
+				delete(pack.Queries, queryName)
+			}
+		}
+		if len(pack.Queries) == 0 {
+			delete(cfg.Packs, name)
+		}
+	}
+}
+
+// platformAllows reports whether an osquery platform constraint, a
+// comma-separated list such as "darwin,linux", includes platform. An empty
+// constraint, "all", and "any" include every platform, and "posix" includes
+// darwin and linux.
+func platformAllows(constraint, platform string) bool {
+	if strings.TrimSpace(constraint) == "" {
+		return true
+	}
+	for _, p := range strings.Split(constraint, ",") {
+		switch p = strings.ToLower(strings.TrimSpace(p)); p {
+		case "all", "any":
+			return true
+		case "posix":
+			if platform == "darwin" || platform == "linux" {
+				return true
+			}
+		case platform:
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,135 @@
+package osquery
+
+import (
+	"context"
+	"encoding/json"
+	"maps"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/google/uuid"
+
+	"github.com/cavenine/queryops/features/osquery/services"
+)
+
+func TestPlatformAllows(t *testing.T) {
+	tests := []struct {
+		constraint string
+		platform   string
+		want       bool
+	}{
+		{"", "windows", true},
+		{"all", "windows", true},
+		{"any", "linux", true},
+		{"darwin", "darwin", true},
+		{"darwin", "windows", false},
+		{"darwin, linux", "linux", true},
+		{"darwin,linux", "windows", false},
+		{"posix", "darwin", true},
+		{"posix", "windows", false},
+		{"Windows", "windows", true},
+	}
+	for _, tt := range tests {
+		if got := platformAllows(tt.constraint, tt.platform); got != tt.want {
+			t.Errorf("platformAllows(%q, %q) = %v, want %v", tt.constraint, tt.platform, got, tt.want)
+		}
+	}
+}
+
+type platformHostRepo struct {
+	issuedAtHostRepo
+
+	osVersion string
+	config    string
+}
+
+func (r *platformHostRepo) GetByNodeKey(context.Context, string) (*services.Host, error) {
+	return &services.Host{ID: uuid.New(), HostIdentifier: "host-a", OSVersion: json.RawMessage(r.osVersion)}, nil
+}
+
+func (r *platformHostRepo) GetConfigForHost(context.Context, string) (json.RawMessage, error) {
+	return json.RawMessage(r.config), nil
+}
+
+func TestConfig_FiltersByPlatform(t *testing.T) {
+	config := `{
+		"schedule": {
+			"uptime": {"query": "SELECT * FROM uptime;", "interval": 60},
+			"launchd": {"query": "SELECT * FROM launchd;", "interval": 60, "platform": "darwin"},
+			"services": {"query": "SELECT * FROM services;", "interval": 60, "platform": "windows"}
+		},
+		"packs": {
+			"mac": {"platform": "darwin", "queries": {"apps": {"query": "SELECT * FROM apps;", "interval": 60}}},
+			"mixed": {"queries": {
+				"users": {"query": "SELECT * FROM users;", "interval": 60},
+				"crontab": {"query": "SELECT * FROM crontab;", "interval": 60, "platform": "posix"}
+			}}
+		}
+	}`
+	tests := []struct {
+		name         string
+		osVersion    string
+		wantSchedule []string
+		wantPacks    map[string][]string
+	}{
+		{
+			name:         "windows",
+			osVersion:    `{"platform":"windows"}`,
+			wantSchedule: []string{"services", "uptime"},
+			wantPacks:    map[string][]string{"mixed": {"users"}},
+		},
+		{
+			name:         "darwin",
+			osVersion:    `{"platform":"darwin"}`,
+			wantSchedule: []string{"launchd", "uptime"},
+			wantPacks:    map[string][]string{"mac": {"apps"}, "mixed": {"crontab", "users"}},
+		},
+		{
+			name:         "linux distribution",
+			osVersion:    `{"platform":"ubuntu","platform_like":"debian"}`,
+			wantSchedule: []string{"uptime"},
+			wantPacks:    map[string][]string{"mixed": {"crontab", "users"}},
+		},
+		{
+			name:         "unknown platform",
+			osVersion:    `{}`,
+			wantSchedule: []string{"launchd", "services", "uptime"},
+			wantPacks:    map[string][]string{"mac": {"apps"}, "mixed": {"crontab", "users"}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := NewHandlers(&platformHostRepo{osVersion: tt.osVersion, config: config}, nil, nil, nil)
+
+			req := httptest.NewRequest(http.MethodPost, "/osquery/config", strings.NewReader(`{"node_key":"k"}`))
+			rec := httptest.NewRecorder()
+			h.Config(rec, req)
+
+			if rec.Code != http.StatusOK {
+				t.Fatalf("status = %d", rec.Code)
+			}
+			var resp ConfigResponse
+			if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+				t.Fatalf("unmarshal: %v", err)
+			}
+			delete(resp.Schedule, services.ScheduleVitalsQueryName)
+			for _, hook := range services.Ingest.Hooks() {
+				delete(resp.Schedule, hook.QueryName)
+			}
+			if got := slices.Sorted(maps.Keys(resp.Schedule)); !slices.Equal(got, tt.wantSchedule) {
+				t.Errorf("schedule = %v, want %v", got, tt.wantSchedule)
+			}
+			if len(resp.Packs) != len(tt.wantPacks) {
+				t.Fatalf("packs = %v, want %v", resp.Packs, tt.wantPacks)
+			}
+			for name, want := range tt.wantPacks {
+				if got := slices.Sorted(maps.Keys(resp.Packs[name].Queries)); !slices.Equal(got, want) {
+					t.Errorf("pack %s queries = %v, want %v", name, got, want)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,229 @@
+package osquery
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+
+	orgServices "github.com/cavenine/queryops/features/organization/services"
+	"github.com/cavenine/queryops/features/osquery/services"
+	"github.com/cavenine/queryops/internal/outbox"
+)
+
+// protocolFixture is a session recorded from one osquery release, in
+// testdata/protocol. Each exchange is a request the agent sent and the
+// response it accepted.
+type protocolFixture struct {
+	OsqueryVersion string             `json:"osquery_version"`
+	LoggerPlugin   string             `json:"logger_plugin"`
+	Exchanges      []protocolExchange `json:"exchanges"`
+}
+
+type protocolExchange struct {
+	Name     string          `json:"name"`
+	Endpoint string          `json:"endpoint"`
+	Request  json.RawMessage `json:"request"`
+	// PendingQueries are the queries waiting for the host when it reads.
+	PendingQueries map[string]string `json:"pending_queries"`
+	Status         int               `json:"status"`
+	Response       json.RawMessage   `json:"response"`
+}
+
+const (
+	compatEnrollSecret = "compat-secret"
+	compatNodeKey      = "compat-node-key"
+)
+
+// compatHostRepo knows one host, enrolled as compatNodeKey.
+type compatHostRepo struct {
+	hostRepository
+
+	pending map[string]string
+}
+
+func (r *compatHostRepo) Enroll(context.Context, string, json.RawMessage, uuid.UUID) (string, error) {
+	return compatNodeKey, nil
+}
+
+func (r *compatHostRepo) GetByNodeKey(_ context.Context, nodeKey string) (*services.Host, error) {
+	if nodeKey != compatNodeKey {
+		return nil, nil
+	}
+	return &services.Host{
+		ID:             uuid.MustParse("3f1d7c9a-2b4e-4c8f-9a6d-5e0b1c2d3e4f"),
+		HostIdentifier: "compat-host",
+		OSVersion:      json.RawMessage(`{"platform":"ubuntu","platform_like":"debian"}`),
+	}, nil
+}
+
+func (r *compatHostRepo) GetConfigForHost(context.Context, string) (json.RawMessage, error) {
+	return json.RawMessage(`{
+		"options": {"distributed_interval": 10},
+		"schedule": {"uptime": {"query": "SELECT * FROM uptime;", "interval": 3600}}
+	}`), nil
+}
+
+func (r *compatHostRepo) GetPendingQueries(context.Context, uuid.UUID) (map[string]string, error) {
+	return r.pending, nil
+}
+
+func (r *compatHostRepo) UpdateLastConfig(context.Context, string) error      { return nil }
+func (r *compatHostRepo) UpdateLastLogger(context.Context, string) error      { return nil }
+func (r *compatHostRepo) UpdateLastDistributed(context.Context, string) error { return nil }
+
+func (r *compatHostRepo) SaveResultLogs(context.Context, uuid.UUID, string, string, json.RawMessage, time.Time) error {
+	return nil
+}
+
+func (r *compatHostRepo) SaveStatusLogs(context.Context, uuid.UUID, int, string, int, string, time.Time) error {
+	return nil
+}
+
+func (r *compatHostRepo) SaveQueryResults(context.Context, uuid.UUID, uuid.UUID, string, json.RawMessage, *string, bool, ...outbox.Event) error {
+	return nil
+}
+
+type compatOrgLookup struct{}
+
+func (compatOrgLookup) GetOrganizationByEnrollSecret(_ context.Context, secret string) (*orgServices.Organization, error) {
+	if secret != compatEnrollSecret {
+		return nil, orgServices.ErrOrganizationNotFound
+	}
+	return &orgServices.Organization{ID: uuid.New(), Name: "compat"}, nil
+}
+
+// TestProtocolCompatibility replays the recorded agent sessions against the
+// TLS handlers, so a change that would break an agent already in the field
+// fails here rather than on an upgrade. Responses must have the recorded
+// shape: every recorded key with the same value, extra keys allowed, and an
+// empty object never answered with null. node_invalid, which sends osquery
+// back to enroll, must be set exactly when it was recorded.
+func TestProtocolCompatibility(t *testing.T) {
+	paths, err := filepath.Glob(filepath.Join("testdata", "protocol", "*.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(paths) == 0 {
+		t.Fatal("no protocol fixtures")
+	}
+
+	for _, path := range paths {
+		raw, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var fixture protocolFixture
+		if err := json.Unmarshal(raw, &fixture); err != nil {
+			t.Fatalf("%s: %v", path, err)
+		}
+
+		t.Run(fixture.OsqueryVersion+"/"+fixture.LoggerPlugin, func(t *testing.T) {
+			repo := &compatHostRepo{}
+			h := NewHandlers(repo, compatOrgLookup{}, nil, nil)
+			endpoints := map[string]http.HandlerFunc{
+				endpointEnroll:          h.Enroll,
+				endpointConfig:          h.Config,
+				endpointDistributedRead: h.DistributedRead,
+				"distributed_write":     h.DistributedWrite,
+				endpointLogger:          h.Logger,
+			}
+
+			for _, ex := range fixture.Exchanges {
+				handler, ok := endpoints[ex.Endpoint]
+				if !ok {
+					t.Fatalf("%s: unknown endpoint %q", ex.Name, ex.Endpoint)
+				}
+				repo.pending = ex.PendingQueries
+
+				req := httptest.NewRequest(http.MethodPost, "/osquery/"+ex.Endpoint, bytes.NewReader(ex.Request))
+				req.Header.Set("Content-Type", "application/json")
+				rec := httptest.NewRecorder()
+				handler(rec, req)
+
+				if rec.Code != ex.Status {
+					t.Fatalf("%s: status = %d, want %d, body=%q", ex.Name, rec.Code, ex.Status, rec.Body.String())
+				}
+				if ct := rec.Header().Get("Content-Type"); ct != "application/json" {
+					t.Errorf("%s: Content-Type = %q", ex.Name, ct)
+				}
+
+				var got, want any
+				if err := json.Unmarshal(rec.Body.Bytes(), &got); err != nil {
+					t.Fatalf("%s: response isn't JSON: %v, body=%q", ex.Name, err, rec.Body.String())
+				}
+				if err := json.Unmarshal(ex.Response, &want); err != nil {
+					t.Fatalf("%s: recorded response: %v", ex.Name, err)
+				}
+				if err := matchShape("response", got, want); err != nil {
+					t.Errorf("%s: %v\n got: %s\nwant: %s", ex.Name, err, strings.TrimSpace(rec.Body.String()), ex.Response)
+				}
+				gotInvalid, _ := got.(map[string]any)["node_invalid"].(bool)
+				wantInvalid, _ := want.(map[string]any)["node_invalid"].(bool)
+				if gotInvalid != wantInvalid {
+					t.Errorf("%s: node_invalid = %v, want %v", ex.Name, gotInvalid, wantInvalid)
+				}
+			}
+		})
+	}
+}
+
+// matchShape reports how got differs from the recorded want. Objects in got
+// may have keys want lacks; everything else must be equal, and an object or
+// array is never matched by null.
+func matchShape(path string, got, want any) error {
+	switch want := want.(type) {
+	case map[string]any:
+		obj, ok := got.(map[string]any)
+		if !ok {
+			return fmt.Errorf("%s = %s, want an object", path, describeJSON(got))
+		}
+		for k, v := range want {
+			g, ok := obj[k]
+			if !ok {
+				return fmt.Errorf("%s.%s is missing", path, k)
+			}
+			if err := matchShape(path+"."+k, g, v); err != nil {
+				return err
+			}
+		}
+		return nil
+	case []any:
+		arr, ok := got.([]any)
+		if !ok {
+			return fmt.Errorf("%s = %s, want an array", path, describeJSON(got))
+		}
+		if len(arr) != len(want) {
+			return fmt.Errorf("%s has %d elements, want %d", path, len(arr), len(want))
+		}
+		for i := range want {
+			if err := matchShape(fmt.Sprintf("%s[%d]", path, i), arr[i], want[i]); err != nil {
+				return err
+			}
+		}
+		return nil
+	default:
+		if !reflect.DeepEqual(got, want) {
+			return fmt.Errorf("%s = %s, want %s", path, describeJSON(got), describeJSON(want))
+		}
+		return nil
+	}
+}
+
+func describeJSON(v any) string {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Sprint(v)
+	}
+	return string(b)
+}
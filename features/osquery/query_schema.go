@@ -0,0 +1,31 @@
+package osquery
+
+import (
+	"errors"
+	"log/slog"
+	"net/http"
+
+	"github.com/cavenine/queryops/features/osquery/schema"
+)
+
+// QuerySchema returns the osquery tables available on a platform, for editor
+// autocomplete.
+//
+// Query parameters:
+//   - platform: darwin, linux or windows (default all platforms)
+func (h *Handlers) QuerySchema(w http.ResponseWriter, r *http.Request) {
+	tables, err := schema.Tables(r.URL.Query().Get("platform"))
+	if errors.Is(err, schema.ErrUnknownPlatform) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		slog.ErrorContext(r.Context(), "failed to load osquery schema", "error", err)
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+
+	// The schema only changes with a release.
+	w.Header().Set("Cache-Control", "private, max-age=3600")
+	h.jsonResponse(w, tables)
+}
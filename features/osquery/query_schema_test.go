@@ -0,0 +1,36 @@
+package osquery_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/cavenine/queryops/features/osquery"
+	"github.com/cavenine/queryops/features/osquery/schema"
+)
+
+func TestQuerySchema(t *testing.T) {
+	h := osquery.NewHandlers(&stubHostRepo{}, &stubEnrollOrgLookup{}, nil, nil)
+
+	rec := httptest.NewRecorder()
+	h.QuerySchema(rec, httptest.NewRequest(http.MethodGet, "/api/v1/schema?platform=windows", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, body = %s", rec.Code, rec.Body.String())
+	}
+
+	var tables []schema.Table
+	if err := json.Unmarshal(rec.Body.Bytes(), &tables); err != nil {
+		t.Fatalf("decoding response: %v", err)
+	}
+	want, _ := schema.Tables("windows")
+	if len(tables) != len(want) {
+		t.Fatalf("got %d tables, want %d", len(tables), len(want))
+	}
+
+	rec = httptest.NewRecorder()
+	h.QuerySchema(rec, httptest.NewRequest(http.MethodGet, "/api/v1/schema?platform=plan9", nil))
+	if rec.Code != http.StatusBadRequest {
+		t.Fatalf("unknown platform: status = %d, want 400", rec.Code)
+	}
+}
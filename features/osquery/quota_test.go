@@ -0,0 +1,134 @@
+package osquery
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+
+	orgServices "github.com/cavenine/queryops/features/organization/services"
+	"github.com/cavenine/queryops/features/osquery/services"
+)
+
+type fixedQuotas struct {
+	enroll   error
+	campaign error
+	logBytes error
+
+	reserved int64
+}
+
+func (q *fixedQuotas) CheckHostEnrollment(context.Context, uuid.UUID, string) error { return q.enroll }
+func (q *fixedQuotas) CheckCampaign(context.Context, uuid.UUID) error               { return q.campaign }
+func (q *fixedQuotas) ReserveResultLogBytes(_ context.Context, _ uuid.UUID, n int64) error {
+	if q.logBytes == nil {
+		q.reserved += n
+	}
+	return q.logBytes
+}
+
+type quotaHostRepo struct {
+	hostRepository
+
+	host     *services.Host
+	enrolled bool
+	queued   bool
+	saved    int
+}
+
+func (r *quotaHostRepo) Enroll(context.Context, string, json.RawMessage, uuid.UUID) (string, error) {
+	r.enrolled = true
+	return "node-key", nil
+}
+
+func (r *quotaHostRepo) GetByNodeKey(context.Context, string) (*services.Host, error) {
+	return r.host, nil
+}
+
+func (r *quotaHostRepo) UpdateLastLogger(context.Context, string) error { return nil }
+
+func (r *quotaHostRepo) SaveResultLogs(context.Context, uuid.UUID, string, string, json.RawMessage, time.Time) error {
+	r.saved++
+	return nil
+}
+
+func (r *quotaHostRepo) QueueQuery(context.Context, uuid.UUID, *int, *string, *string, string, []uuid.UUID, services.CampaignOptions) (uuid.UUID, error) {
+	r.queued = true
+	return uuid.New(), nil
+}
+
+type quotaOrgLookup struct{ org *orgServices.Organization }
+
+func (l quotaOrgLookup) GetOrganizationByEnrollSecret(context.Context, string) (*orgServices.Organization, error) {
+	return l.org, nil
+}
+
+func TestEnroll_HostQuotaExceeded(t *testing.T) {
+	repo := &quotaHostRepo{}
+	h := NewHandlers(repo, quotaOrgLookup{org: &orgServices.Organization{ID: uuid.New()}}, nil, nil)
+	h.quotas = &fixedQuotas{enroll: &orgServices.QuotaExceededError{Quota: orgServices.QuotaHosts, Limit: 5, Used: 5}}
+
+	rec := httptest.NewRecorder()
+	h.Enroll(rec, httptest.NewRequest(http.MethodPost, "/osquery/enroll", strings.NewReader(`{"enroll_secret":"s","host_identifier":"h"}`)))
+
+	if rec.Code != http.StatusForbidden {
+		t.Fatalf("status = %d, want 403", rec.Code)
+	}
+	var resp EnrollmentResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("unmarshal response: %v", err)
+	}
+	if !resp.NodeInvalid || !strings.Contains(resp.Error, "limit of 5 enrolled hosts") {
+		t.Fatalf("response = %+v", resp)
+	}
+	if repo.enrolled {
+		t.Fatalf("host enrolled despite quota")
+	}
+}
+
+func TestLogger_ResultLogQuota(t *testing.T) {
+	repo := &quotaHostRepo{host: &services.Host{ID: uuid.New(), OrganizationID: uuid.New()}}
+	quotas := &fixedQuotas{}
+	h := NewHandlers(repo, quotaOrgLookup{}, nil, nil)
+	h.quotas = quotas
+
+	body := `{"node_key":"k","log_type":"result","data":[{"name":"q","unixTime":1,"action":"added","columns":{"a":"b"}}]}`
+
+	rec := httptest.NewRecorder()
+	h.Logger(rec, httptest.NewRequest(http.MethodPost, "/osquery/logger", strings.NewReader(body)))
+	if rec.Code != http.StatusOK || repo.saved != 1 {
+		t.Fatalf("status = %d, saved = %d; want 200, 1", rec.Code, repo.saved)
+	}
+	if quotas.reserved != int64(len(`{"a":"b"}`)) {
+		t.Fatalf("reserved = %d bytes", quotas.reserved)
+	}
+
+	quotas.logBytes = &orgServices.QuotaExceededError{Quota: orgServices.QuotaResultLogBytesPerDay, Limit: 10, Used: 10}
+	rec = httptest.NewRecorder()
+	h.Logger(rec, httptest.NewRequest(http.MethodPost, "/osquery/logger", strings.NewReader(body)))
+	if rec.Code != http.StatusTooManyRequests {
+		t.Fatalf("status = %d, want 429", rec.Code)
+	}
+	if repo.saved != 1 {
+		t.Fatalf("saved = %d after quota rejection, want 1", repo.saved)
+	}
+}
+
+func TestQueueQuery_CampaignQuota(t *testing.T) {
+	repo := &quotaHostRepo{}
+	h := NewHandlers(repo, quotaOrgLookup{}, nil, nil)
+	h.quotas = &fixedQuotas{campaign: &orgServices.QuotaExceededError{Quota: orgServices.QuotaCampaignsPerDay, Limit: 1, Used: 1}}
+
+	_, err := h.queueQuery(context.Background(), uuid.New(), nil, nil, nil, "SELECT 1;", []uuid.UUID{uuid.New()}, services.CampaignOptions{})
+	if !orgServices.IsQuotaExceeded(err) {
+		t.Fatalf("err = %v, want quota error", err)
+	}
+	if repo.queued {
+		t.Fatalf("campaign queued despite quota")
+	}
+}
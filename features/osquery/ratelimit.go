@@ -0,0 +1,182 @@
+package osquery
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"math"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cavenine/queryops/internal/realip"
+)
+
+// The osquery endpoints that can be rate limited, as named in
+// OSQUERY_RATE_LIMITS. distributed_write isn't one: a host only writes
+// results for queries distributed_read handed it.
+const (
+	endpointEnroll          = "enroll"
+	endpointConfig          = "config"
+	endpointDistributedRead = "distributed_read"
+	endpointLogger          = "logger"
+	endpointPing            = "ping"
+)
+
+var rateLimitedEndpoints = []string{endpointEnroll, endpointConfig, endpointDistributedRead, endpointLogger, endpointPing}
+
+// rateLimit lets each agent make perMinute requests to an endpoint, plus
+// bursts of up to burst requests.
+type rateLimit struct {
+	perMinute int
+	burst     int
+}
+
+// parseRateLimits parses comma-separated endpoint:per_minute:burst triples,
+// e.g. "config:12:10,logger:30:20". A per_minute of 0 leaves the endpoint
+// unlimited, as do endpoints not listed.
+func parseRateLimits(spec string) (map[string]rateLimit, error) {
+	limits := make(map[string]rateLimit)
+	for part := range strings.SplitSeq(spec, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		fields := strings.Split(part, ":")
+		if len(fields) != 3 {
+			return nil, fmt.Errorf("parsing rate limit %q: want endpoint:per_minute:burst", part)
+		}
+		endpoint := strings.TrimSpace(fields[0])
+		if !slices.Contains(rateLimitedEndpoints, endpoint) {
+			return nil, fmt.Errorf("parsing rate limit %q: unknown endpoint %q", part, endpoint)
+		}
+		if _, dup := limits[endpoint]; dup {
+			return nil, fmt.Errorf("parsing rate limits: duplicate endpoint %q", endpoint)
+		}
+		perMinute, err := strconv.Atoi(strings.TrimSpace(fields[1]))
+		if err != nil || perMinute < 0 {
+			return nil, fmt.Errorf("parsing rate limit %q: per_minute must be a non-negative integer", part)
+		}
+		burst, err := strconv.Atoi(strings.TrimSpace(fields[2]))
+		if err != nil || burst < 1 {
+			return nil, fmt.Errorf("parsing rate limit %q: burst must be a positive integer", part)
+		}
+		if perMinute > 0 {
+			limits[endpoint] = rateLimit{perMinute: perMinute, burst: burst}
+		}
+	}
+	return limits, nil
+}
+
+// rateLimiter keeps a token bucket per endpoint and agent. Buckets start
+// full, so an agent can make burst requests at once, and refill at the
+// endpoint's rate.
+type rateLimiter struct {
+	limits map[string]rateLimit
+
+	mu      sync.Mutex
+	buckets map[rateLimitKey]*tokenBucket
+}
+
+type rateLimitKey struct {
+	endpoint string
+	agent    string
+}
+
+type tokenBucket struct {
+	tokens  float64
+	updated time.Time
+}
+
+func newRateLimiter(limits map[string]rateLimit) *rateLimiter {
+	return &rateLimiter{
+		limits:  limits,
+		buckets: make(map[rateLimitKey]*tokenBucket),
+	}
+}
+
+// allow takes a token from agent's bucket for endpoint at now. When the
+// bucket is empty it returns false and how long until it holds a token.
+func (l *rateLimiter) allow(endpoint, agent string, now time.Time) (bool, time.Duration) {
+	limit, ok := l.limits[endpoint]
+	if !ok {
+		return true, 0
+	}
+	perSecond := float64(limit.perMinute) / 60
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	key := rateLimitKey{endpoint: endpoint, agent: agent}
+	b, ok := l.buckets[key]
+	if !ok {
+		if len(l.buckets) >= maxHostCacheEntries {
+			l.evictFull(now)
+		}
+		b = &tokenBucket{tokens: float64(limit.burst), updated: now}
+		l.buckets[key] = b
+	}
+	b.tokens = min(float64(limit.burst), b.tokens+now.Sub(b.updated).Seconds()*perSecond)
+	b.updated = now
+
+	if b.tokens < 1 {
+		wait := time.Duration(math.Ceil((1 - b.tokens) / perSecond * float64(time.Second)))
+		return false, wait
+	}
+	b.tokens--
+	return true, 0
+}
+
+// evictFull drops buckets that have refilled by now; a new bucket starts
+// out the same. l.mu must be held.
+func (l *rateLimiter) evictFull(now time.Time) {
+	for key, b := range l.buckets {
+		limit := l.limits[key.endpoint]
+		if b.tokens+now.Sub(b.updated).Seconds()*float64(limit.perMinute)/60 >= float64(limit.burst) {
+			delete(l.buckets, key)
+		}
+	}
+}
+
+// overRateLimit reports whether a request to endpoint from agent, usually
+// its node key, is over the endpoint's rate limit, and if so for how long.
+// Requests without an agent are counted per client address.
+func (h *Handlers) overRateLimit(r *http.Request, endpoint, agent string) (time.Duration, bool) {
+	if h.rateLimits == nil {
+		return 0, false
+	}
+	if agent == "" {
+		agent = realip.FromRequest(r).String()
+	}
+	ok, wait := h.rateLimits.allow(endpoint, agent, time.Now())
+	if !ok {
+		slog.DebugContext(r.Context(), "osquery request rate limited", "endpoint", endpoint, "retry_after", wait)
+	}
+	return wait, !ok
+}
+
+// rateLimited answers a rate-limited request with 429, a Retry-After in
+// whole seconds, and resp in the shape osquery expects from the endpoint.
+// osquery treats it as a failed attempt: it keeps its config and buffered
+// logs and tries again on its next interval.
+func (h *Handlers) rateLimited(w http.ResponseWriter, wait time.Duration, resp any) {
+	retryLater(w, http.StatusTooManyRequests, wait, resp)
+}
+
+// retryLater answers with status, a Retry-After of wait in whole seconds,
+// and resp as JSON unless it's nil.
+func retryLater(w http.ResponseWriter, status int, wait time.Duration, resp any) {
+	w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+	if resp == nil {
+		w.WriteHeader(status)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(resp); err != nil {
+		slog.Error("failed to encode json response", "error", err)
+	}
+}
@@ -0,0 +1,102 @@
+package osquery
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseRateLimits(t *testing.T) {
+	limits, err := parseRateLimits(" config:12:10, logger:0:5,ping:60:1 ")
+	if err != nil {
+		t.Fatalf("parseRateLimits: %v", err)
+	}
+	if got := limits[endpointConfig]; got != (rateLimit{perMinute: 12, burst: 10}) {
+		t.Errorf("config limit = %+v", got)
+	}
+	if _, ok := limits[endpointLogger]; ok {
+		t.Errorf("logger with per_minute 0 is limited")
+	}
+	if len(limits) != 2 {
+		t.Errorf("limits = %+v", limits)
+	}
+
+	for _, spec := range []string{"config:12", "distributed_write:1:1", "config:-1:1", "config:1:0", "config:1:1,config:2:2"} {
+		if _, err := parseRateLimits(spec); err == nil {
+			t.Errorf("parseRateLimits(%q) succeeded", spec)
+		}
+	}
+}
+
+func TestRateLimiter(t *testing.T) {
+	l := newRateLimiter(map[string]rateLimit{endpointConfig: {perMinute: 6, burst: 2}})
+	now := time.Now()
+
+	for i := range 2 {
+		if ok, _ := l.allow(endpointConfig, "a", now); !ok {
+			t.Fatalf("request %d within the burst was limited", i+1)
+		}
+	}
+	ok, wait := l.allow(endpointConfig, "a", now)
+	if ok || wait != 10*time.Second {
+		t.Fatalf("request past the burst = %v, %v; want limited for 10s", ok, wait)
+	}
+	if ok, _ := l.allow(endpointConfig, "b", now); !ok {
+		t.Fatalf("another agent was limited")
+	}
+	if ok, _ := l.allow(endpointLogger, "a", now); !ok {
+		t.Fatalf("an unlimited endpoint was limited")
+	}
+	if ok, _ := l.allow(endpointConfig, "a", now.Add(10*time.Second)); !ok {
+		t.Fatalf("request after a refill was limited")
+	}
+	if ok, _ := l.allow(endpointConfig, "a", now.Add(11*time.Second)); ok {
+		t.Fatalf("second request after one refill was allowed")
+	}
+}
+
+func TestRateLimitedEndpoints(t *testing.T) {
+	h := NewHandlers(&issuedAtHostRepo{issuedAt: time.Now()}, nil, nil, nil)
+	h.rateLimits = newRateLimiter(map[string]rateLimit{
+		endpointConfig:          {perMinute: 1, burst: 1},
+		endpointDistributedRead: {perMinute: 1, burst: 1},
+		endpointPing:            {perMinute: 1, burst: 1},
+	})
+	call := func(handler http.HandlerFunc, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/osquery/x", strings.NewReader(body))
+		req.Header.Set(PingNodeKeyHeader, "k")
+		rec := httptest.NewRecorder()
+		handler(rec, req)
+		return rec
+	}
+
+	if rec := call(h.Config, `{"node_key":"k"}`); rec.Code != http.StatusOK {
+		t.Fatalf("first config: status = %d", rec.Code)
+	}
+	rec := call(h.Config, `{"node_key":"k"}`)
+	if rec.Code != http.StatusTooManyRequests || rec.Header().Get("Retry-After") != "60" {
+		t.Fatalf("second config: status = %d, Retry-After = %q", rec.Code, rec.Header().Get("Retry-After"))
+	}
+	if rec := call(h.Config, `{"node_key":"other"}`); rec.Code != http.StatusOK {
+		t.Fatalf("config for another node key: status = %d", rec.Code)
+	}
+
+	// A limited read is answered as if there were no queries.
+	h.rateLimits.allow(endpointDistributedRead, "k", time.Now())
+	rec = call(h.DistributedRead, `{"node_key":"k"}`)
+	var read DistributedReadResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &read); err != nil {
+		t.Fatalf("unmarshal: %v", err)
+	}
+	if rec.Code != http.StatusOK || read.NodeInvalid || read.Queries == nil || len(read.Queries) != 0 {
+		t.Fatalf("limited distributed_read: status = %d, body = %q", rec.Code, rec.Body.String())
+	}
+
+	h.rateLimits.allow(endpointPing, "k", time.Now())
+	if rec := call(h.Ping, ``); rec.Code != http.StatusNoContent {
+		t.Fatalf("limited ping: status = %d", rec.Code)
+	}
+}
@@ -0,0 +1,206 @@
+package osquery
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/cavenine/queryops/features/osquery/services"
+	"github.com/cavenine/queryops/internal/requestsign"
+)
+
+// signingModeCacheTTL is how long an organization's request signing mode is
+// cached; every agent request needs it.
+const signingModeCacheTTL = 30 * time.Second
+
+// signedRequestRecordInterval is how often a host signing its config
+// requests is recorded, for the rollout status.
+const signedRequestRecordInterval = time.Hour
+
+// requestSigningRepository issues hosts their signing keys and stores each
+// organization's request signing mode.
+type requestSigningRepository interface {
+	RequestSigningMode(ctx context.Context, organizationID uuid.UUID) (string, error)
+	EnrollmentSigningSalts(ctx context.Context, organizationID uuid.UUID, hostIdentifier string, hostDetails json.RawMessage) ([]services.SigningSalt, error)
+	IssueSigningSalt(ctx context.Context, nodeKey string) (uuid.UUID, []byte, error)
+	RecordSignedRequest(ctx context.Context, hostID uuid.UUID) error
+}
+
+// requestSigning checks that agent requests are signed with the key their
+// host was issued at enrollment, as each organization's mode requires. The
+// routes must keep request bodies with requestsign.KeepBody.
+type requestSigning struct {
+	repo requestSigningRepository
+	keys *requestsign.Keys
+	now  func() time.Time
+
+	mu    sync.Mutex
+	modes map[uuid.UUID]cachedSigningMode
+}
+
+type cachedSigningMode struct {
+	mode      string
+	expiresAt time.Time
+}
+
+func newRequestSigning(repo requestSigningRepository, keys *requestsign.Keys) *requestSigning {
+	return &requestSigning{repo: repo, keys: keys, now: time.Now, modes: make(map[uuid.UUID]cachedSigningMode)}
+}
+
+func (s *requestSigning) mode(ctx context.Context, organizationID uuid.UUID) (string, error) {
+	now := s.now()
+	s.mu.Lock()
+	cached, ok := s.modes[organizationID]
+	s.mu.Unlock()
+	if ok && now.Before(cached.expiresAt) {
+		return cached.mode, nil
+	}
+
+	mode, err := s.repo.RequestSigningMode(ctx, organizationID)
+	if err != nil {
+		return "", err
+	}
+	s.mu.Lock()
+	for id, c := range s.modes {
+		if !now.Before(c.expiresAt) {
+			delete(s.modes, id)
+		}
+	}
+	s.modes[organizationID] = cachedSigningMode{mode: mode, expiresAt: now.Add(signingModeCacheTTL)}
+	s.mu.Unlock()
+	return mode, nil
+}
+
+// verify checks the request's signature against the keys derived from
+// salts, any of which may have signed it.
+func (s *requestSigning) verify(r *http.Request, salts []services.SigningSalt) error {
+	err := requestsign.ErrInvalid
+	for _, salt := range salts {
+		err = requestsign.Verify(s.keys.HostKey(salt.HostID, salt.Salt), r.Header.Get(requestsign.Header), r.URL.Path, requestsign.Body(r), s.now())
+		if err == nil || errors.Is(err, requestsign.ErrMissing) {
+			return err
+		}
+	}
+	return err
+}
+
+// checkSignature checks that a request from host is signed with its key. In
+// monitor mode a request that isn't is logged; in enforce mode it's also
+// rejected with a 403, and checkSignature returns false. Hosts that haven't
+// been issued a key can't sign, so enforcing rejects them until they're
+// reset to enroll again.
+func (h *Handlers) checkSignature(w http.ResponseWriter, r *http.Request, host *services.Host) bool {
+	if h.signing == nil {
+		return true
+	}
+	ctx := r.Context()
+	mode, err := h.signing.mode(ctx, host.OrganizationID)
+	if err != nil {
+		slog.ErrorContext(ctx, "failed to get request signing mode", "error", err, "organization_id", host.OrganizationID)
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return false
+	}
+	if mode == services.RequestSigningOff {
+		return true
+	}
+
+	err = errors.New("host has no signing key")
+	if host.SigningSalt != nil {
+		err = h.signing.verify(r, []services.SigningSalt{{HostID: host.ID, Salt: host.SigningSalt}})
+	}
+	if err == nil {
+		// Config requests come every config_refresh at most, so they're a
+		// cheap place to note that the host signs.
+		if r.URL.Path == "/osquery/config" && (host.SignedRequestAt == nil || time.Since(*host.SignedRequestAt) > signedRequestRecordInterval) {
+			if err := h.signing.repo.RecordSignedRequest(ctx, host.ID); err != nil {
+				slog.ErrorContext(ctx, "failed to record signed request", "error", err, "host_id", host.ID)
+			}
+		}
+		return true
+	}
+
+	slog.WarnContext(ctx, "osquery request not signed with the host's key",
+		"host_identifier", host.HostIdentifier,
+		"organization_id", host.OrganizationID,
+		"path", r.URL.Path,
+		"mode", mode,
+		"error", err,
+	)
+	if mode != services.RequestSigningEnforce {
+		return true
+	}
+	http.Error(w, "request must be signed with the host's signing key", http.StatusForbidden)
+	return false
+}
+
+// checkEnrollSignature checks that an enrollment into an existing host with
+// a signing key is signed with that key, as checkSignature does for other
+// requests, so the enroll secret alone can't take over the host.
+func (h *Handlers) checkEnrollSignature(w http.ResponseWriter, r *http.Request, organizationID uuid.UUID, req EnrollmentRequest) bool {
+	if h.signing == nil {
+		return true
+	}
+	ctx := r.Context()
+	mode, err := h.signing.mode(ctx, organizationID)
+	if err != nil {
+		slog.ErrorContext(ctx, "failed to get request signing mode", "error", err, "organization_id", organizationID)
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return false
+	}
+	if mode == services.RequestSigningOff {
+		return true
+	}
+
+	salts, err := h.signing.repo.EnrollmentSigningSalts(ctx, organizationID, req.HostIdentifier, req.HostDetails)
+	if err != nil {
+		slog.ErrorContext(ctx, "failed to get enrollment signing salts", "error", err, "organization_id", organizationID)
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return false
+	}
+	if len(salts) == 0 {
+		return true
+	}
+	if err := h.signing.verify(r, salts); err != nil {
+		slog.WarnContext(ctx, "re-enrollment not signed with the host's key",
+			"host_identifier", req.HostIdentifier,
+			"organization_id", organizationID,
+			"mode", mode,
+			"error", err,
+		)
+		if mode == services.RequestSigningEnforce {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusForbidden)
+			if err := json.NewEncoder(w).Encode(EnrollmentResponse{NodeInvalid: true, Error: "re-enrollment must be signed with the host's signing key"}); err != nil {
+				slog.Error("failed to encode json response", "error", err)
+			}
+			return false
+		}
+	}
+	return true
+}
+
+// issueSigningKey gives a newly enrolled host its signing key, returned hex
+// encoded, or "" if the host already has one or it couldn't be issued.
+// Enrollment doesn't fail for it: the host runs unsigned, which only
+// enforcing organizations reject.
+func (h *Handlers) issueSigningKey(ctx context.Context, nodeKey string) string {
+	if h.signing == nil {
+		return ""
+	}
+	hostID, salt, err := h.signing.repo.IssueSigningSalt(ctx, nodeKey)
+	if err != nil {
+		slog.ErrorContext(ctx, "failed to issue signing key", "error", err)
+		return ""
+	}
+	if salt == nil {
+		return ""
+	}
+	return hex.EncodeToString(h.signing.keys.HostKey(hostID, salt))
+}
@@ -0,0 +1,181 @@
+package osquery
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+
+	orgServices "github.com/cavenine/queryops/features/organization/services"
+	"github.com/cavenine/queryops/features/osquery/services"
+	"github.com/cavenine/queryops/internal/requestsign"
+)
+
+type fakeSigningRepo struct {
+	mode     string
+	salts    []services.SigningSalt
+	issue    services.SigningSalt
+	recorded []uuid.UUID
+}
+
+func (r *fakeSigningRepo) RequestSigningMode(context.Context, uuid.UUID) (string, error) {
+	return r.mode, nil
+}
+
+func (r *fakeSigningRepo) EnrollmentSigningSalts(context.Context, uuid.UUID, string, json.RawMessage) ([]services.SigningSalt, error) {
+	return r.salts, nil
+}
+
+func (r *fakeSigningRepo) IssueSigningSalt(context.Context, string) (uuid.UUID, []byte, error) {
+	return r.issue.HostID, r.issue.Salt, nil
+}
+
+func (r *fakeSigningRepo) RecordSignedRequest(_ context.Context, hostID uuid.UUID) error {
+	r.recorded = append(r.recorded, hostID)
+	return nil
+}
+
+func signedRequest(key []byte, path, body string) *http.Request {
+	req := httptest.NewRequest(http.MethodPost, path, strings.NewReader(body))
+	if key != nil {
+		req.Header.Set(requestsign.Header, requestsign.Sign(key, time.Now(), path, []byte(body)))
+	}
+	return req
+}
+
+func TestLogger_RequestSigning(t *testing.T) {
+	keys := requestsign.NewKeys("secret")
+	host := &services.Host{ID: uuid.New(), OrganizationID: uuid.New(), SigningSalt: []byte("0123456789abcdef")}
+	key := keys.HostKey(host.ID, host.SigningSalt)
+	otherKey := keys.HostKey(uuid.New(), host.SigningSalt)
+	body := `{"node_key":"k","log_type":"result","data":[{"name":"q","unixTime":1,"action":"added","columns":{"a":"b"}}]}`
+
+	tests := []struct {
+		name       string
+		mode       string
+		host       *services.Host
+		key        []byte
+		wantStatus int
+		wantSaved  int
+	}{
+		{"off ignores signatures", services.RequestSigningOff, host, nil, http.StatusOK, 1},
+		{"monitor accepts unsigned", services.RequestSigningMonitor, host, nil, http.StatusOK, 1},
+		{"monitor accepts wrong key", services.RequestSigningMonitor, host, otherKey, http.StatusOK, 1},
+		{"enforce accepts signed", services.RequestSigningEnforce, host, key, http.StatusOK, 1},
+		{"enforce rejects unsigned", services.RequestSigningEnforce, host, nil, http.StatusForbidden, 0},
+		{"enforce rejects wrong key", services.RequestSigningEnforce, host, otherKey, http.StatusForbidden, 0},
+		{"enforce rejects hosts without a key", services.RequestSigningEnforce, &services.Host{ID: host.ID, OrganizationID: host.OrganizationID}, key, http.StatusForbidden, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			repo := &quotaHostRepo{host: tt.host}
+			h := NewHandlers(repo, nil, nil, nil)
+			h.signing = newRequestSigning(&fakeSigningRepo{mode: tt.mode}, keys)
+
+			rec := httptest.NewRecorder()
+			requestsign.KeepBody(http.HandlerFunc(h.Logger)).ServeHTTP(rec, signedRequest(tt.key, "/osquery/logger", body))
+
+			if rec.Code != tt.wantStatus || repo.saved != tt.wantSaved {
+				t.Fatalf("status = %d, saved = %d; want %d, %d", rec.Code, repo.saved, tt.wantStatus, tt.wantSaved)
+			}
+		})
+	}
+}
+
+func TestConfig_RecordsSignedRequests(t *testing.T) {
+	keys := requestsign.NewKeys("secret")
+	recent := time.Now().Add(-time.Minute)
+	for name, tc := range map[string]struct {
+		signedAt   *time.Time
+		wantRecord bool
+	}{
+		"first signed request": {nil, true},
+		"recorded recently":    {&recent, false},
+	} {
+		t.Run(name, func(t *testing.T) {
+			host := &services.Host{ID: uuid.New(), OrganizationID: uuid.New(), SigningSalt: []byte("salt"), SignedRequestAt: tc.signedAt}
+			signing := &fakeSigningRepo{mode: services.RequestSigningMonitor}
+			h := NewHandlers(&signingConfigHostRepo{host: host}, nil, nil, nil)
+			h.signing = newRequestSigning(signing, keys)
+
+			body := `{"node_key":"k"}`
+			rec := httptest.NewRecorder()
+			requestsign.KeepBody(http.HandlerFunc(h.Config)).ServeHTTP(rec, signedRequest(keys.HostKey(host.ID, host.SigningSalt), "/osquery/config", body))
+
+			if rec.Code != http.StatusOK {
+				t.Fatalf("status = %d", rec.Code)
+			}
+			if got := len(signing.recorded) > 0; got != tc.wantRecord {
+				t.Fatalf("recorded = %v, want %v", got, tc.wantRecord)
+			}
+		})
+	}
+}
+
+type signingConfigHostRepo struct {
+	hostRepository
+
+	host *services.Host
+}
+
+func (r *signingConfigHostRepo) GetByNodeKey(context.Context, string) (*services.Host, error) {
+	return r.host, nil
+}
+
+func (r *signingConfigHostRepo) UpdateLastConfig(context.Context, string) error { return nil }
+
+func (r *signingConfigHostRepo) GetConfigForHost(context.Context, string) (json.RawMessage, error) {
+	return json.RawMessage(`{"options":{}}`), nil
+}
+
+func TestEnroll_RequestSigning(t *testing.T) {
+	keys := requestsign.NewKeys("secret")
+	existing := services.SigningSalt{HostID: uuid.New(), Salt: []byte("existing")}
+	issued := services.SigningSalt{HostID: uuid.New(), Salt: []byte("issued")}
+	body := `{"enroll_secret":"s","host_identifier":"h"}`
+
+	tests := []struct {
+		name         string
+		mode         string
+		salts        []services.SigningSalt
+		key          []byte
+		wantStatus   int
+		wantEnrolled bool
+	}{
+		{"new host", services.RequestSigningEnforce, nil, nil, http.StatusOK, true},
+		{"monitor accepts unsigned re-enrollment", services.RequestSigningMonitor, []services.SigningSalt{existing}, nil, http.StatusOK, true},
+		{"enforce accepts signed re-enrollment", services.RequestSigningEnforce, []services.SigningSalt{existing}, keys.HostKey(existing.HostID, existing.Salt), http.StatusOK, true},
+		{"enforce rejects unsigned re-enrollment", services.RequestSigningEnforce, []services.SigningSalt{existing}, nil, http.StatusForbidden, false},
+		{"off ignores keys", services.RequestSigningOff, []services.SigningSalt{existing}, nil, http.StatusOK, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			repo := &quotaHostRepo{}
+			h := NewHandlers(repo, quotaOrgLookup{org: &orgServices.Organization{ID: uuid.New()}}, nil, nil)
+			h.signing = newRequestSigning(&fakeSigningRepo{mode: tt.mode, salts: tt.salts, issue: issued}, keys)
+
+			rec := httptest.NewRecorder()
+			requestsign.KeepBody(http.HandlerFunc(h.Enroll)).ServeHTTP(rec, signedRequest(tt.key, "/osquery/enroll", body))
+
+			if rec.Code != tt.wantStatus || repo.enrolled != tt.wantEnrolled {
+				t.Fatalf("status = %d, enrolled = %v; want %d, %v", rec.Code, repo.enrolled, tt.wantStatus, tt.wantEnrolled)
+			}
+			if !tt.wantEnrolled {
+				return
+			}
+			var resp EnrollmentResponse
+			if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+				t.Fatalf("unmarshal: %v", err)
+			}
+			if want := hex.EncodeToString(keys.HostKey(issued.HostID, issued.Salt)); resp.SigningKey != want {
+				t.Fatalf("signing_key = %q, want %q", resp.SigningKey, want)
+			}
+		})
+	}
+}
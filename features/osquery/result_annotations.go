@@ -0,0 +1,196 @@
+package osquery
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+
+	"github.com/cavenine/queryops/features/auth"
+	org "github.com/cavenine/queryops/features/organization"
+	"github.com/cavenine/queryops/features/osquery/services"
+	"github.com/cavenine/queryops/internal/httpbody"
+	"github.com/cavenine/queryops/internal/validate"
+)
+
+// resultAnnotationRepository triages campaign results.
+type resultAnnotationRepository interface {
+	AnnotateResult(ctx context.Context, organizationID, campaignID, hostID uuid.UUID, rowKey, state, comment string, updatedBy *int) error
+	ListResultAnnotations(ctx context.Context, campaignID uuid.UUID) (services.ResultAnnotations, error)
+}
+
+type annotateResultRequest struct {
+	HostID uuid.UUID `json:"host_id"`
+	// RowKey is the row_key of a result row, or empty to annotate the host.
+	RowKey string `json:"row_key"`
+	// State is a triage state, or empty to remove the annotation.
+	State   string `json:"state"`
+	Comment string `json:"comment"`
+}
+
+func (req *annotateResultRequest) validate() validate.Errors {
+	req.Comment = strings.TrimSpace(req.Comment)
+	fields := validate.Errors{}
+	fields.Check(req.HostID != uuid.Nil, "host_id", validate.MsgRequired)
+	fields.Check(req.State == "" || slices.Contains(services.AnnotationStates, req.State), "state",
+		"Must be one of "+strings.Join(services.AnnotationStates, ", "))
+	fields.Check(utf8.RuneCountInString(req.Comment) <= services.AnnotationCommentMaxLength, "comment",
+		"Must be at most 2000 characters")
+	return fields
+}
+
+type listResultAnnotationsResponse struct {
+	Annotations []*services.ResultAnnotation `json:"annotations"`
+}
+
+// annotate saves req on the campaign named in the URL. It writes an error
+// response and returns false on failure, with problems in the request written
+// by invalid.
+func (h *Handlers) annotate(w http.ResponseWriter, r *http.Request, req annotateResultRequest, invalid func(validate.Errors)) (uuid.UUID, bool) {
+	ctx := r.Context()
+	activeOrg := org.GetOrganizationFromContext(ctx)
+	if activeOrg == nil {
+		slog.Error("missing active organization in context")
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return uuid.Nil, false
+	}
+	if h.annotations == nil {
+		http.Error(w, "result annotations are not available", http.StatusServiceUnavailable)
+		return uuid.Nil, false
+	}
+
+	campaignID, err := uuid.Parse(chi.URLParam(r, "id"))
+	if err != nil {
+		http.Error(w, "invalid campaign id", http.StatusBadRequest)
+		return uuid.Nil, false
+	}
+	if fields := req.validate(); len(fields) > 0 {
+		invalid(fields)
+		return uuid.Nil, false
+	}
+
+	var updatedBy *int
+	if user := auth.GetUserFromContext(ctx); user != nil {
+		updatedBy = &user.ID
+	}
+	err = h.annotations.AnnotateResult(ctx, activeOrg.ID, campaignID, req.HostID, req.RowKey, req.State, req.Comment, updatedBy)
+	switch {
+	case err == nil:
+		return campaignID, true
+	case errors.Is(err, services.ErrCampaignNotFound):
+		http.Error(w, "campaign not found", http.StatusNotFound)
+	case errors.Is(err, services.ErrResultNotFound):
+		invalid(validate.Errors{"host_id": "The campaign has no such result"})
+	default:
+		slog.ErrorContext(ctx, "failed to annotate result", "error", err, "campaign_id", campaignID)
+		http.Error(w, "internal error", http.StatusInternalServerError)
+	}
+	return uuid.Nil, false
+}
+
+// AnnotateResultUI triages a result row or host from the campaign's page and
+// returns to the view of its results the form was posted from.
+func (h *Handlers) AnnotateResultUI(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "invalid form data", http.StatusBadRequest)
+		return
+	}
+	hostID, err := uuid.Parse(r.PostForm.Get("host_id"))
+	if err != nil {
+		http.Error(w, "invalid host id", http.StatusBadRequest)
+		return
+	}
+	req := annotateResultRequest{
+		HostID:  hostID,
+		RowKey:  r.PostForm.Get("row_key"),
+		State:   r.PostForm.Get("state"),
+		Comment: r.PostForm.Get("comment"),
+	}
+
+	campaignID, ok := h.annotate(w, r, req, func(fields validate.Errors) {
+		http.Error(w, fields.Error(), http.StatusUnprocessableEntity)
+	})
+	if !ok {
+		return
+	}
+
+	// The view is posted as the query string of the page it was posted from.
+	q, err := url.ParseQuery(r.PostForm.Get("view"))
+	if err != nil {
+		q = url.Values{}
+	}
+	path := "/campaigns/" + campaignID.String()
+	if v := services.ParseCampaignResultView(q).Values().Encode(); v != "" {
+		path += "?" + v
+	}
+	http.Redirect(w, r, path, http.StatusSeeOther)
+}
+
+// AnnotateResult is the API form of AnnotateResultUI. It responds with the
+// campaign's annotations.
+func (h *Handlers) AnnotateResult(w http.ResponseWriter, r *http.Request) {
+	var req annotateResultRequest
+	if err := httpbody.DecodeStrictJSON(r, &req); err != nil {
+		httpbody.Error(w, err)
+		return
+	}
+
+	campaignID, ok := h.annotate(w, r, req, func(fields validate.Errors) {
+		validate.WriteJSON(w, fields)
+	})
+	if !ok {
+		return
+	}
+	h.writeResultAnnotations(w, r, campaignID)
+}
+
+// ListResultAnnotations lists a campaign's annotations, least recently
+// updated first.
+func (h *Handlers) ListResultAnnotations(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	activeOrg := org.GetOrganizationFromContext(ctx)
+	if activeOrg == nil {
+		slog.Error("missing active organization in context")
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+	if h.annotations == nil {
+		http.Error(w, "result annotations are not available", http.StatusServiceUnavailable)
+		return
+	}
+
+	campaignID, err := uuid.Parse(chi.URLParam(r, "id"))
+	if err != nil {
+		http.Error(w, "invalid campaign id", http.StatusBadRequest)
+		return
+	}
+	campaign, err := h.repo.GetCampaignByIDAndOrganization(ctx, campaignID, activeOrg.ID)
+	if err != nil {
+		slog.ErrorContext(ctx, "failed to get campaign", "error", err)
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+	if campaign == nil {
+		http.Error(w, "campaign not found", http.StatusNotFound)
+		return
+	}
+
+	h.writeResultAnnotations(w, r, campaignID)
+}
+
+func (h *Handlers) writeResultAnnotations(w http.ResponseWriter, r *http.Request, campaignID uuid.UUID) {
+	annotations, err := h.annotations.ListResultAnnotations(r.Context(), campaignID)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "failed to list result annotations", "error", err, "campaign_id", campaignID)
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+	h.jsonResponse(w, listResultAnnotationsResponse{Annotations: annotations.List()})
+}
@@ -0,0 +1,114 @@
+package osquery
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+
+	org "github.com/cavenine/queryops/features/organization"
+	orgServices "github.com/cavenine/queryops/features/organization/services"
+	"github.com/cavenine/queryops/features/osquery/services"
+	"github.com/cavenine/queryops/internal/validate"
+)
+
+// recordingAnnotations keeps annotations in memory for one organization's
+// campaign, whose only target is host.
+type recordingAnnotations struct {
+	orgID, campaignID, hostID uuid.UUID
+
+	annotations services.ResultAnnotations
+}
+
+func (r *recordingAnnotations) AnnotateResult(_ context.Context, organizationID, campaignID, hostID uuid.UUID, rowKey, state, comment string, _ *int) error {
+	if organizationID != r.orgID || campaignID != r.campaignID {
+		return services.ErrCampaignNotFound
+	}
+	if hostID != r.hostID {
+		return services.ErrResultNotFound
+	}
+	key := services.ResultAnnotationKey{HostID: hostID, RowKey: rowKey}
+	if state == "" {
+		delete(r.annotations, key)
+		return nil
+	}
+	r.annotations[key] = &services.ResultAnnotation{CampaignID: campaignID, HostID: hostID, RowKey: rowKey, State: state, Comment: comment}
+	return nil
+}
+
+func (r *recordingAnnotations) ListResultAnnotations(_ context.Context, _ uuid.UUID) (services.ResultAnnotations, error) {
+	return r.annotations, nil
+}
+
+func TestAnnotateResult(t *testing.T) {
+	repo := &recordingAnnotations{orgID: uuid.New(), campaignID: uuid.New(), hostID: uuid.New(), annotations: services.ResultAnnotations{}}
+	h := NewHandlers(&groupTestHostRepo{}, nil, nil, nil)
+	h.annotations = repo
+	router := chi.NewRouter()
+	router.Post("/campaigns/{id}/annotations", h.AnnotateResultUI)
+	router.Post("/api/v1/campaigns/{id}/annotations", h.AnnotateResult)
+
+	post := func(req *http.Request) *httptest.ResponseRecorder {
+		req = req.WithContext(org.SetOrganizationInContext(req.Context(), &orgServices.Organization{ID: repo.orgID}))
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		return rec
+	}
+	postForm := func(campaignID uuid.UUID, form url.Values) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/campaigns/"+campaignID.String()+"/annotations", strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		return post(req)
+	}
+
+	rec := postForm(repo.campaignID, url.Values{
+		"host_id": {repo.hostID.String()},
+		"row_key": {"abc"},
+		"state":   {services.AnnotationSuspicious},
+		"comment": {"  unexpected listener  "},
+		"view":    {"sort=pid&desc=1"},
+	})
+	if want := "/campaigns/" + repo.campaignID.String() + "?desc=1&sort=pid"; rec.Code != http.StatusSeeOther || rec.Header().Get("Location") != want {
+		t.Fatalf("annotate = %d %q, want redirect to %q", rec.Code, rec.Header().Get("Location"), want)
+	}
+	got := repo.annotations[services.ResultAnnotationKey{HostID: repo.hostID, RowKey: "abc"}]
+	if got == nil || got.State != services.AnnotationSuspicious || got.Comment != "unexpected listener" {
+		t.Fatalf("annotation = %+v, want a trimmed suspicious one", got)
+	}
+
+	if rec := postForm(repo.campaignID, url.Values{"host_id": {repo.hostID.String()}, "state": {"benign"}}); rec.Code != http.StatusUnprocessableEntity {
+		t.Fatalf("unknown state: status = %d, want 422", rec.Code)
+	}
+	if rec := postForm(uuid.New(), url.Values{"host_id": {repo.hostID.String()}, "state": {services.AnnotationTriaged}}); rec.Code != http.StatusNotFound {
+		t.Fatalf("another campaign: status = %d, want 404", rec.Code)
+	}
+
+	api := func(body string) *httptest.ResponseRecorder {
+		return post(httptest.NewRequest(http.MethodPost, "/api/v1/campaigns/"+repo.campaignID.String()+"/annotations", strings.NewReader(body)))
+	}
+
+	rec = api(`{"host_id":"` + repo.hostID.String() + `","state":"false_positive"}`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("api annotate: status = %d, body = %q", rec.Code, rec.Body.String())
+	}
+	var resp listResultAnnotationsResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil || len(resp.Annotations) != 2 {
+		t.Fatalf("api annotate: body = %q, want both annotations", rec.Body.String())
+	}
+
+	rec = api(`{"host_id":"` + uuid.NewString() + `","state":"triaged"}`)
+	var fields validate.Response
+	if err := json.Unmarshal(rec.Body.Bytes(), &fields); err != nil || !fields.Fields.Has("host_id") {
+		t.Fatalf("api unknown host: %d %q, want an error for host_id", rec.Code, rec.Body.String())
+	}
+
+	rec = api(`{"host_id":"` + repo.hostID.String() + `","row_key":"abc","state":""}`)
+	if rec.Code != http.StatusOK || len(repo.annotations) != 1 {
+		t.Fatalf("api remove: status = %d, annotations = %d, want 1 left", rec.Code, len(repo.annotations))
+	}
+}
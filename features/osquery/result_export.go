@@ -0,0 +1,161 @@
+package osquery
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+
+	"github.com/cavenine/queryops/features/auth"
+	org "github.com/cavenine/queryops/features/organization"
+	"github.com/cavenine/queryops/features/osquery/services"
+	"github.com/cavenine/queryops/internal/httpbody"
+	"github.com/cavenine/queryops/internal/validate"
+)
+
+// resultExportListLimit is how many exports ListResultExports returns.
+const resultExportListLimit = 50
+
+// resultExportRepository requests and reports on exports of scheduled query
+// results to Parquet files.
+type resultExportRepository interface {
+	Request(ctx context.Context, organizationID uuid.UUID, start, end time.Time, requestedBy *int) (*services.ResultExport, error)
+	List(ctx context.Context, organizationID uuid.UUID, limit int) ([]services.ResultExport, error)
+	GetForOrganization(ctx context.Context, organizationID, id uuid.UUID) (*services.ResultExport, error)
+}
+
+type createResultExportRequest struct {
+	Start string `json:"start"`
+	End   string `json:"end"`
+}
+
+type listResultExportsResponse struct {
+	Exports []services.ResultExport `json:"exports"`
+}
+
+// CreateResultExport requests an export of the active organization's
+// scheduled query results stored from start up to end, both RFC 3339 times.
+// The export runs in the background; poll GetResultExport for its status.
+func (h *Handlers) CreateResultExport(w http.ResponseWriter, r *http.Request) {
+	activeOrg := org.GetOrganizationFromContext(r.Context())
+	if activeOrg == nil {
+		slog.Error("missing active organization in context")
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+	if h.exports == nil {
+		http.Error(w, "result exports are not configured", http.StatusServiceUnavailable)
+		return
+	}
+
+	var req createResultExportRequest
+	if err := httpbody.DecodeStrictJSON(r, &req); err != nil {
+		httpbody.Error(w, err)
+		return
+	}
+
+	fields := validate.Errors{}
+	var start, end time.Time
+	for name, field := range map[string]struct {
+		value string
+		dst   *time.Time
+	}{"start": {req.Start, &start}, "end": {req.End, &end}} {
+		if field.value == "" {
+			fields.Add(name, validate.MsgRequired)
+			continue
+		}
+		t, err := time.Parse(time.RFC3339, field.value)
+		if err != nil {
+			fields.Add(name, "Must be an RFC 3339 time")
+			continue
+		}
+		*field.dst = t
+	}
+	if len(fields) > 0 {
+		validate.WriteJSON(w, fields)
+		return
+	}
+
+	ctx := r.Context()
+	var requestedBy *int
+	if user := auth.GetUserFromContext(ctx); user != nil {
+		requestedBy = &user.ID
+	}
+
+	export, err := h.exports.Request(ctx, activeOrg.ID, start, end, requestedBy)
+	if err != nil {
+		if errors.Is(err, services.ErrInvalidExportRange) {
+			validate.WriteJSON(w, validate.Errors{"end": "Must be after start and at most 366 days later"})
+			return
+		}
+		slog.ErrorContext(ctx, "failed to request result export", "error", err)
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusAccepted)
+	h.jsonResponse(w, export)
+}
+
+// ListResultExports lists the active organization's recent result exports,
+// newest first.
+func (h *Handlers) ListResultExports(w http.ResponseWriter, r *http.Request) {
+	activeOrg := org.GetOrganizationFromContext(r.Context())
+	if activeOrg == nil {
+		slog.Error("missing active organization in context")
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+	if h.exports == nil {
+		h.jsonResponse(w, listResultExportsResponse{Exports: []services.ResultExport{}})
+		return
+	}
+
+	exports, err := h.exports.List(r.Context(), activeOrg.ID, resultExportListLimit)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "failed to list result exports", "error", err)
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+	if exports == nil {
+		exports = []services.ResultExport{}
+	}
+	h.jsonResponse(w, listResultExportsResponse{Exports: exports})
+}
+
+// GetResultExport returns one of the active organization's result exports.
+func (h *Handlers) GetResultExport(w http.ResponseWriter, r *http.Request) {
+	activeOrg := org.GetOrganizationFromContext(r.Context())
+	if activeOrg == nil {
+		slog.Error("missing active organization in context")
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+
+	id, err := uuid.Parse(chi.URLParam(r, "id"))
+	if err != nil {
+		http.Error(w, "invalid export id", http.StatusBadRequest)
+		return
+	}
+	if h.exports == nil {
+		http.Error(w, "result export not found", http.StatusNotFound)
+		return
+	}
+
+	export, err := h.exports.GetForOrganization(r.Context(), activeOrg.ID, id)
+	if err != nil {
+		if errors.Is(err, services.ErrResultExportNotFound) {
+			http.Error(w, "result export not found", http.StatusNotFound)
+			return
+		}
+		slog.ErrorContext(r.Context(), "failed to get result export", "error", err)
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+
+	h.jsonResponse(w, export)
+}
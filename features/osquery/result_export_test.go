@@ -0,0 +1,130 @@
+package osquery
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+
+	org "github.com/cavenine/queryops/features/organization"
+	orgServices "github.com/cavenine/queryops/features/organization/services"
+	"github.com/cavenine/queryops/features/osquery/services"
+	"github.com/cavenine/queryops/internal/validate"
+)
+
+type stubResultExports struct {
+	requested []services.ResultExport
+}
+
+func (s *stubResultExports) Request(_ context.Context, organizationID uuid.UUID, start, end time.Time, _ *int) (*services.ResultExport, error) {
+	if !start.Before(end) {
+		return nil, services.ErrInvalidExportRange
+	}
+	export := services.ResultExport{ID: uuid.New(), OrganizationID: organizationID, Start: start, End: end, Status: services.ExportPending}
+	s.requested = append(s.requested, export)
+	return &export, nil
+}
+
+func (s *stubResultExports) List(_ context.Context, organizationID uuid.UUID, _ int) ([]services.ResultExport, error) {
+	var exports []services.ResultExport
+	for _, e := range s.requested {
+		if e.OrganizationID == organizationID {
+			exports = append(exports, e)
+		}
+	}
+	return exports, nil
+}
+
+func (s *stubResultExports) GetForOrganization(_ context.Context, organizationID, id uuid.UUID) (*services.ResultExport, error) {
+	for _, e := range s.requested {
+		if e.ID == id && e.OrganizationID == organizationID {
+			return &e, nil
+		}
+	}
+	return nil, services.ErrResultExportNotFound
+}
+
+func TestResultExports(t *testing.T) {
+	activeOrg := &orgServices.Organization{ID: uuid.New()}
+	exports := &stubResultExports{}
+	h := NewHandlers(nil, nil, nil, nil)
+
+	withOrg := func(req *http.Request, o *orgServices.Organization) *http.Request {
+		return req.WithContext(org.SetOrganizationInContext(req.Context(), o))
+	}
+	create := func(body string) *httptest.ResponseRecorder {
+		req := withOrg(httptest.NewRequest(http.MethodPost, "/api/v1/results/exports", strings.NewReader(body)), activeOrg)
+		rec := httptest.NewRecorder()
+		h.CreateResultExport(rec, req)
+		return rec
+	}
+	get := func(id string, o *orgServices.Organization) *httptest.ResponseRecorder {
+		req := withOrg(httptest.NewRequest(http.MethodGet, "/api/v1/results/exports/"+id, nil), o)
+		rctx := chi.NewRouteContext()
+		rctx.URLParams.Add("id", id)
+		req = req.WithContext(context.WithValue(req.Context(), chi.RouteCtxKey, rctx))
+		rec := httptest.NewRecorder()
+		h.GetResultExport(rec, req)
+		return rec
+	}
+
+	if rec := create(`{"start":"2026-02-01T00:00:00Z","end":"2026-02-02T00:00:00Z"}`); rec.Code != http.StatusServiceUnavailable {
+		t.Fatalf("unconfigured: status = %d, want 503", rec.Code)
+	}
+	h.exports = exports
+
+	for body, field := range map[string]string{
+		`{"end":"2026-02-02T00:00:00Z"}`:                                "start",
+		`{"start":"2026-02-01","end":"2026-02-02T00:00:00Z"}`:           "start",
+		`{"start":"2026-02-02T00:00:00Z","end":"2026-02-01T00:00:00Z"}`: "end",
+	} {
+		rec := create(body)
+		if rec.Code != http.StatusBadRequest {
+			t.Errorf("%s: status = %d, want 400", body, rec.Code)
+			continue
+		}
+		var resp validate.Response
+		if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil || !resp.Fields.Has(field) {
+			t.Errorf("%s: body = %q, want an error for %s", body, rec.Body.String(), field)
+		}
+	}
+
+	rec := create(`{"start":"2026-02-01T00:00:00Z","end":"2026-02-02T00:00:00+01:00"}`)
+	if rec.Code != http.StatusAccepted {
+		t.Fatalf("create: status = %d, body=%q", rec.Code, rec.Body.String())
+	}
+	var created services.ResultExport
+	if err := json.Unmarshal(rec.Body.Bytes(), &created); err != nil {
+		t.Fatalf("unmarshal: %v", err)
+	}
+	if created.Status != services.ExportPending || !created.End.Equal(time.Date(2026, 2, 1, 23, 0, 0, 0, time.UTC)) {
+		t.Fatalf("created = %+v", created)
+	}
+
+	if rec := get(created.ID.String(), activeOrg); rec.Code != http.StatusOK {
+		t.Fatalf("get: status = %d", rec.Code)
+	}
+	if rec := get(created.ID.String(), &orgServices.Organization{ID: uuid.New()}); rec.Code != http.StatusNotFound {
+		t.Fatalf("get from another organization: status = %d, want 404", rec.Code)
+	}
+	if rec := get("nope", activeOrg); rec.Code != http.StatusBadRequest {
+		t.Fatalf("get with a bad id: status = %d, want 400", rec.Code)
+	}
+
+	req := withOrg(httptest.NewRequest(http.MethodGet, "/api/v1/results/exports", nil), activeOrg)
+	rec = httptest.NewRecorder()
+	h.ListResultExports(rec, req)
+	var list listResultExportsResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &list); err != nil {
+		t.Fatalf("unmarshal list: %v", err)
+	}
+	if len(list.Exports) != 1 || list.Exports[0].ID != created.ID {
+		t.Fatalf("list = %+v", list.Exports)
+	}
+}
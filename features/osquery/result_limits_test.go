@@ -0,0 +1,68 @@
+package osquery
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/google/uuid"
+
+	"github.com/cavenine/queryops/features/osquery/services"
+	"github.com/cavenine/queryops/internal/outbox"
+)
+
+type savedResult struct {
+	results   json.RawMessage
+	truncated bool
+}
+
+// resultsHostRepo records the results DistributedWrite saves.
+type resultsHostRepo struct {
+	hostRepository
+
+	saved map[uuid.UUID]savedResult
+}
+
+func (r *resultsHostRepo) GetByNodeKey(context.Context, string) (*services.Host, error) {
+	return &services.Host{ID: uuid.New()}, nil
+}
+
+func (r *resultsHostRepo) SaveQueryResults(_ context.Context, _ uuid.UUID, queryID uuid.UUID, _ string, results json.RawMessage, _ *string, truncated bool, _ ...outbox.Event) error {
+	r.saved[queryID] = savedResult{results: results, truncated: truncated}
+	return nil
+}
+
+type fixedResultLimits map[uuid.UUID]services.ResultLimits
+
+func (l fixedResultLimits) GetResultLimits(context.Context, []uuid.UUID) (map[uuid.UUID]services.ResultLimits, error) {
+	return l, nil
+}
+
+func TestDistributedWrite_TruncatesToCampaignLimits(t *testing.T) {
+	capped := uuid.New()
+	uncapped := uuid.New()
+
+	repo := &resultsHostRepo{saved: map[uuid.UUID]savedResult{}}
+	h := NewHandlers(repo, nil, nil, nil)
+	h.resultLimits = fixedResultLimits{capped: {MaxRows: 1}}
+
+	rows := `[{"a":"1"},{"a":"2"}]`
+	body := `{"node_key":"k","queries":{"` + capped.String() + `":` + rows + `,"` + uncapped.String() + `":` + rows + `},` +
+		`"statuses":{"` + capped.String() + `":0,"` + uncapped.String() + `":0}}`
+	req := httptest.NewRequest(http.MethodPost, "/osquery/distributed_write", strings.NewReader(body))
+	rec := httptest.NewRecorder()
+	h.DistributedWrite(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, body = %q", rec.Code, rec.Body.String())
+	}
+	if got := repo.saved[capped]; string(got.results) != `[{"a":"1"}]` || !got.truncated {
+		t.Fatalf("capped = %s truncated=%v, want first row truncated", got.results, got.truncated)
+	}
+	if got := repo.saved[uncapped]; string(got.results) != rows || got.truncated {
+		t.Fatalf("uncapped = %s truncated=%v, want all rows", got.results, got.truncated)
+	}
+}
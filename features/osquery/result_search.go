@@ -0,0 +1,118 @@
+package osquery
+
+import (
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	org "github.com/cavenine/queryops/features/organization"
+	"github.com/cavenine/queryops/features/osquery/services"
+	"github.com/cavenine/queryops/internal/validate"
+)
+
+const (
+	searchPageSize    = 50
+	maxSearchPageSize = 500
+)
+
+type searchResultsResponse struct {
+	Results    []services.SearchHit `json:"results"`
+	NextCursor string               `json:"next_cursor,omitempty"`
+}
+
+// SearchResults searches stored result logs and campaign results in the
+// active organization.
+//
+// Query parameters:
+//   - q: full-text search over row values
+//   - match: column:value, repeatable; every pair must match in one row
+//   - source: "logs" or "campaigns" (default both)
+//   - since, until: RFC 3339 time range
+//   - limit, cursor: pagination
+func (h *Handlers) SearchResults(w http.ResponseWriter, r *http.Request) {
+	activeOrg := org.GetOrganizationFromContext(r.Context())
+	if activeOrg == nil {
+		slog.Error("missing active organization in context")
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+
+	q := r.URL.Query()
+	search := services.ResultSearch{
+		Text:   strings.TrimSpace(q.Get("q")),
+		Source: q.Get("source"),
+		Limit:  searchPageSize,
+	}
+
+	fields := validate.Errors{}
+	for _, m := range q["match"] {
+		column, value, ok := strings.Cut(m, ":")
+		if !ok || column == "" {
+			fields.Add("match", "Must be column:value")
+			continue
+		}
+		if search.Match == nil {
+			search.Match = make(map[string]string)
+		}
+		search.Match[column] = value
+	}
+	fields.Check(search.Text != "" || len(search.Match) > 0 || fields.Has("match"), "q", "Required unless match is set")
+	fields.Check(search.Source == "" || search.Source == services.SearchSourceLogs || search.Source == services.SearchSourceCampaigns,
+		"source", `Must be "logs" or "campaigns"`)
+
+	for name, dst := range map[string]**time.Time{"since": &search.Since, "until": &search.Until} {
+		if s := q.Get(name); s != "" {
+			t, err := time.Parse(time.RFC3339, s)
+			if err != nil {
+				fields.Add(name, "Must be an RFC 3339 time")
+				continue
+			}
+			*dst = &t
+		}
+	}
+
+	if s := q.Get("cursor"); s != "" {
+		cursor, err := services.ParseSearchCursor(s)
+		if err != nil {
+			fields.Add("cursor", "Invalid cursor")
+		} else {
+			search.Before = &cursor
+		}
+	}
+	if s := q.Get("limit"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n <= 0 {
+			fields.Add("limit", "Must be a whole number, 1 or more")
+		} else {
+			search.Limit = min(n, maxSearchPageSize)
+		}
+	}
+	if len(fields) > 0 {
+		validate.WriteJSON(w, fields)
+		return
+	}
+
+	// Fetch one extra hit to learn whether another page exists.
+	limit := search.Limit
+	search.Limit++
+
+	ctx := r.Context()
+	hits, err := h.repo.SearchResults(ctx, activeOrg.ID, search)
+	if err != nil {
+		slog.ErrorContext(ctx, "failed to search results", "error", err)
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+
+	resp := searchResultsResponse{Results: hits}
+	if len(hits) > limit {
+		resp.Results = hits[:limit]
+		resp.NextCursor = hits[limit-1].Cursor().String()
+	}
+	if resp.Results == nil {
+		resp.Results = []services.SearchHit{}
+	}
+	h.jsonResponse(w, resp)
+}
@@ -0,0 +1,95 @@
+package osquery_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/cavenine/queryops/features/organization"
+	orgServices "github.com/cavenine/queryops/features/organization/services"
+	"github.com/cavenine/queryops/features/osquery"
+	osqueryServices "github.com/cavenine/queryops/features/osquery/services"
+	"github.com/cavenine/queryops/internal/validate"
+)
+
+func TestSearchResults(t *testing.T) {
+	activeOrg := &orgServices.Organization{ID: uuid.New()}
+	now := time.Now().UTC().Truncate(time.Second)
+
+	var got osqueryServices.ResultSearch
+	repo := &stubHostRepo{
+		SearchResultsFunc: func(_ context.Context, organizationID uuid.UUID, s osqueryServices.ResultSearch) ([]osqueryServices.SearchHit, error) {
+			if organizationID != activeOrg.ID {
+				t.Fatalf("organizationID = %s, want %s", organizationID, activeOrg.ID)
+			}
+			got = s
+			return []osqueryServices.SearchHit{
+				{Source: "logs", HostIdentifier: "a", At: now},
+				{Source: "campaigns", HostIdentifier: "b", At: now.Add(-time.Minute)},
+			}, nil
+		},
+	}
+	h := osquery.NewHandlers(repo, &stubEnrollOrgLookup{}, nil, nil)
+
+	do := func(query string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/api/v1/results/search?"+query, nil)
+		req = req.WithContext(organization.SetOrganizationInContext(req.Context(), activeOrg))
+		rec := httptest.NewRecorder()
+		h.SearchResults(rec, req)
+		return rec
+	}
+
+	for bad, field := range map[string]string{
+		"":                    "q",
+		"match=novalue":       "match",
+		"q=x&source=nope":     "source",
+		"q=x&since=yesterday": "since",
+		"q=x&limit=0":         "limit",
+		"q=x&cursor=bad":      "cursor",
+	} {
+		rec := do(bad)
+		if rec.Code != http.StatusBadRequest {
+			t.Errorf("%q: status = %d, want 400", bad, rec.Code)
+			continue
+		}
+		var resp validate.Response
+		if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil || !resp.Fields.Has(field) {
+			t.Errorf("%q: body = %q, want an error for %s", bad, rec.Body.String(), field)
+		}
+	}
+
+	rec := do("q=sshd&match=name:sshd&match=path:/usr/sbin/sshd&source=campaigns&since=2026-01-01T00:00:00Z&limit=1")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, body=%q", rec.Code, rec.Body.String())
+	}
+	if got.Text != "sshd" || got.Match["name"] != "sshd" || got.Match["path"] != "/usr/sbin/sshd" || got.Source != "campaigns" {
+		t.Fatalf("search = %+v", got)
+	}
+	if got.Since == nil || !got.Since.Equal(time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)) || got.Until != nil {
+		t.Fatalf("time range = %v..%v", got.Since, got.Until)
+	}
+	if got.Limit != 2 {
+		t.Fatalf("repo limit = %d, want limit+1", got.Limit)
+	}
+
+	var resp struct {
+		Results    []osqueryServices.SearchHit `json:"results"`
+		NextCursor string                      `json:"next_cursor"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("unmarshal response: %v", err)
+	}
+	if len(resp.Results) != 1 || resp.Results[0].HostIdentifier != "a" || resp.NextCursor == "" {
+		t.Fatalf("response = %+v", resp)
+	}
+	// Hits from the stub have no per-source key, so the cursor can't be
+	// parsed back; it must still be the last returned hit's.
+	if want := (osqueryServices.SearchHit{At: now}).Cursor().String(); resp.NextCursor != want {
+		t.Fatalf("next cursor = %q, want %q", resp.NextCursor, want)
+	}
+}
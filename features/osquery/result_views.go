@@ -0,0 +1,236 @@
+package osquery
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+	"github.com/starfederation/datastar-go/datastar"
+
+	"github.com/cavenine/queryops/features/auth"
+	org "github.com/cavenine/queryops/features/organization"
+	"github.com/cavenine/queryops/features/osquery/services"
+	"github.com/cavenine/queryops/internal/sharelink"
+)
+
+// resultViewLinkTTL is how long a shared result view link works.
+const resultViewLinkTTL = 7 * 24 * time.Hour
+
+// resultViewRepository saves named views of campaign results.
+type resultViewRepository interface {
+	SaveResultView(ctx context.Context, organizationID, campaignID uuid.UUID, createdBy *int, name string, view services.CampaignResultView) (*services.SavedResultView, error)
+	ListResultViews(ctx context.Context, campaignID, organizationID uuid.UUID) ([]services.SavedResultView, error)
+	GetResultView(ctx context.Context, viewID, organizationID uuid.UUID) (*services.SavedResultView, error)
+	DeleteResultView(ctx context.Context, viewID, campaignID, organizationID uuid.UUID) (bool, error)
+}
+
+// savedResultViews returns the campaign's saved views for its details page.
+func (h *Handlers) savedResultViews(ctx context.Context, campaign *services.Campaign) []services.SavedResultView {
+	if h.resultViews == nil {
+		return nil
+	}
+	views, err := h.resultViews.ListResultViews(ctx, campaign.ID, campaign.OrganizationID)
+	if err != nil {
+		slog.ErrorContext(ctx, "failed to list result views", "error", err, "campaign_id", campaign.ID)
+	}
+	return views
+}
+
+// SaveResultView saves the view of a campaign's results the form was posted
+// from, and shows it.
+func (h *Handlers) SaveResultView(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	activeOrg := org.GetOrganizationFromContext(ctx)
+	if activeOrg == nil {
+		slog.Error("missing active organization in context")
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+
+	campaignID, err := uuid.Parse(chi.URLParam(r, "id"))
+	if err != nil {
+		http.Error(w, "invalid campaign id", http.StatusBadRequest)
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "invalid form data", http.StatusBadRequest)
+		return
+	}
+	// The view is posted as the query string of the page it was saved from.
+	q, err := url.ParseQuery(r.PostForm.Get("view"))
+	if err != nil {
+		http.Error(w, "invalid view", http.StatusBadRequest)
+		return
+	}
+	view := services.ParseCampaignResultView(q)
+
+	var createdBy *int
+	if user := auth.GetUserFromContext(ctx); user != nil {
+		createdBy = &user.ID
+	}
+	saved, err := h.resultViews.SaveResultView(ctx, activeOrg.ID, campaignID, createdBy, r.PostForm.Get("name"), view)
+	if err != nil {
+		switch {
+		case errors.Is(err, services.ErrInvalidResultViewName):
+			http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+		case errors.Is(err, services.ErrCampaignNotFound):
+			http.Error(w, "campaign not found", http.StatusNotFound)
+		default:
+			slog.ErrorContext(ctx, "failed to save result view", "error", err, "campaign_id", campaignID)
+			http.Error(w, "internal error", http.StatusInternalServerError)
+		}
+		return
+	}
+
+	http.Redirect(w, r, resultViewPath(saved), http.StatusSeeOther)
+}
+
+// DeleteResultView deletes a saved view of a campaign's results. Links
+// shared to it stop working.
+func (h *Handlers) DeleteResultView(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	activeOrg := org.GetOrganizationFromContext(ctx)
+	if activeOrg == nil {
+		slog.Error("missing active organization in context")
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+
+	campaignID, err := uuid.Parse(chi.URLParam(r, "id"))
+	if err != nil {
+		http.Error(w, "invalid campaign id", http.StatusBadRequest)
+		return
+	}
+	viewID, err := uuid.Parse(chi.URLParam(r, "viewID"))
+	if err != nil {
+		http.Error(w, "invalid view id", http.StatusBadRequest)
+		return
+	}
+
+	found, err := h.resultViews.DeleteResultView(ctx, viewID, campaignID, activeOrg.ID)
+	if err != nil {
+		slog.ErrorContext(ctx, "failed to delete result view", "error", err, "view_id", viewID)
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+	if !found {
+		http.Error(w, "view not found", http.StatusNotFound)
+		return
+	}
+
+	http.Redirect(w, r, "/campaigns/"+campaignID.String(), http.StatusSeeOther)
+}
+
+// ShareResultView patches the page's shareLink and shareExpires signals
+// with a link to a saved view, for another member of the organization to
+// open.
+func (h *Handlers) ShareResultView(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	activeOrg := org.GetOrganizationFromContext(ctx)
+	if activeOrg == nil {
+		slog.Error("missing active organization in context")
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+
+	campaignID, err := uuid.Parse(chi.URLParam(r, "id"))
+	if err != nil {
+		http.Error(w, "invalid campaign id", http.StatusBadRequest)
+		return
+	}
+	viewID, err := uuid.Parse(chi.URLParam(r, "viewID"))
+	if err != nil {
+		http.Error(w, "invalid view id", http.StatusBadRequest)
+		return
+	}
+
+	saved, err := h.resultViews.GetResultView(ctx, viewID, activeOrg.ID)
+	if errors.Is(err, services.ErrResultViewNotFound) || (err == nil && saved.CampaignID != campaignID) {
+		http.Error(w, "view not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		slog.ErrorContext(ctx, "failed to get result view", "error", err, "view_id", viewID)
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+
+	expiresAt := time.Now().Add(resultViewLinkTTL).UTC()
+	token := h.shareLinks.Sign(sharelink.Link{ID: saved.ID, OrganizationID: activeOrg.ID, ExpiresAt: expiresAt})
+	if err := datastar.NewSSE(w, r).MarshalAndPatchSignals(map[string]any{
+		"shareLink":    h.sharedResultViewURL(r, token),
+		"shareExpires": expiresAt.Format(time.DateTime) + " UTC",
+	}); err != nil {
+		return
+	}
+}
+
+// OpenSharedResultView shows the saved view a share link refers to. The link
+// only works for members of the view's organization, with it active.
+func (h *Handlers) OpenSharedResultView(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	activeOrg := org.GetOrganizationFromContext(ctx)
+	if activeOrg == nil {
+		slog.Error("missing active organization in context")
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+
+	link, err := h.shareLinks.Verify(chi.URLParam(r, "token"), time.Now())
+	if err != nil {
+		if errors.Is(err, sharelink.ErrExpired) {
+			http.Error(w, "this link has expired; ask for a new one", http.StatusGone)
+			return
+		}
+		http.Error(w, "view not found", http.StatusNotFound)
+		return
+	}
+	if link.OrganizationID != activeOrg.ID {
+		for _, o := range org.GetUserOrganizationsFromContext(ctx) {
+			if o.ID == link.OrganizationID {
+				http.Error(w, "this link is for the "+o.Name+" organization; switch to it and open the link again", http.StatusConflict)
+				return
+			}
+		}
+		http.Error(w, "view not found", http.StatusNotFound)
+		return
+	}
+
+	saved, err := h.resultViews.GetResultView(ctx, link.ID, activeOrg.ID)
+	if err != nil {
+		if errors.Is(err, services.ErrResultViewNotFound) {
+			http.Error(w, "view not found", http.StatusNotFound)
+			return
+		}
+		slog.ErrorContext(ctx, "failed to get result view", "error", err, "view_id", link.ID)
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+
+	http.Redirect(w, r, resultViewPath(saved), http.StatusSeeOther)
+}
+
+// resultViewPath is the campaign details page showing a saved view.
+func resultViewPath(saved *services.SavedResultView) string {
+	path := "/campaigns/" + saved.CampaignID.String()
+	if q := saved.View.Values().Encode(); q != "" {
+		path += "?" + q
+	}
+	return path
+}
+
+// sharedResultViewURL is the link that opens a shared result view with
+// token.
+func (h *Handlers) sharedResultViewURL(r *http.Request, token string) string {
+	scheme := "http"
+	if r.TLS != nil || h.secureLinks {
+		scheme = "https"
+	}
+	u := url.URL{Scheme: scheme, Host: r.Host, Path: "/campaigns/shared/" + token}
+	return u.String()
+}
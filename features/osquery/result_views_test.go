@@ -0,0 +1,78 @@
+package osquery
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+
+	org "github.com/cavenine/queryops/features/organization"
+	orgServices "github.com/cavenine/queryops/features/organization/services"
+	"github.com/cavenine/queryops/features/osquery/services"
+	"github.com/cavenine/queryops/internal/sharelink"
+)
+
+type fixedResultViews struct {
+	resultViewRepository
+
+	views []services.SavedResultView
+}
+
+func (r fixedResultViews) GetResultView(_ context.Context, viewID, organizationID uuid.UUID) (*services.SavedResultView, error) {
+	for _, v := range r.views {
+		if v.ID == viewID && v.OrganizationID == organizationID {
+			return &v, nil
+		}
+	}
+	return nil, services.ErrResultViewNotFound
+}
+
+func TestOpenSharedResultView(t *testing.T) {
+	orgID := uuid.New()
+	saved := services.SavedResultView{
+		ID:             uuid.New(),
+		OrganizationID: orgID,
+		CampaignID:     uuid.New(),
+		View:           services.CampaignResultView{Sort: "pid", Desc: true},
+	}
+
+	h := NewHandlers(&groupTestHostRepo{}, nil, nil, nil)
+	h.resultViews = fixedResultViews{views: []services.SavedResultView{saved}}
+	h.shareLinks = sharelink.NewSigner("secret", "campaign-result-view")
+	router := chi.NewRouter()
+	router.Get("/campaigns/shared/{token}", h.OpenSharedResultView)
+
+	open := func(token string, activeOrgID uuid.UUID) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/campaigns/shared/"+token, nil)
+		req = req.WithContext(org.SetOrganizationInContext(req.Context(), &orgServices.Organization{ID: activeOrgID}))
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		return rec
+	}
+	link := func(id uuid.UUID, expiresIn time.Duration) string {
+		return h.shareLinks.Sign(sharelink.Link{ID: id, OrganizationID: orgID, ExpiresAt: time.Now().Add(expiresIn)})
+	}
+
+	rec := open(link(saved.ID, time.Hour), orgID)
+	if want := "/campaigns/" + saved.CampaignID.String() + "?desc=1&sort=pid"; rec.Code != http.StatusSeeOther || rec.Header().Get("Location") != want {
+		t.Fatalf("open = %d %q, want redirect to %q", rec.Code, rec.Header().Get("Location"), want)
+	}
+
+	if rec := open(link(saved.ID, -time.Minute), orgID); rec.Code != http.StatusGone {
+		t.Fatalf("expired link: status = %d, want 410", rec.Code)
+	}
+	if rec := open(link(saved.ID, time.Hour), uuid.New()); rec.Code != http.StatusNotFound {
+		t.Fatalf("another organization: status = %d, want 404", rec.Code)
+	}
+	if rec := open(link(uuid.New(), time.Hour), orgID); rec.Code != http.StatusNotFound {
+		t.Fatalf("deleted view: status = %d, want 404", rec.Code)
+	}
+	forged := sharelink.NewSigner("guess", "campaign-result-view").Sign(sharelink.Link{ID: saved.ID, OrganizationID: orgID, ExpiresAt: time.Now().Add(time.Hour)})
+	if rec := open(forged, orgID); rec.Code != http.StatusNotFound {
+		t.Fatalf("forged link: status = %d, want 404", rec.Code)
+	}
+}
@@ -1,60 +1,260 @@
 package osquery
 
 import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+
 	"github.com/ThreeDotsLabs/watermill/message"
+	"github.com/cavenine/queryops/config"
+	"github.com/cavenine/queryops/db"
+	"github.com/cavenine/queryops/features/auth"
+	org "github.com/cavenine/queryops/features/organization"
 	orgServices "github.com/cavenine/queryops/features/organization/services"
+	"github.com/cavenine/queryops/features/osquery/graph"
+	"github.com/cavenine/queryops/features/osquery/pages"
 	"github.com/cavenine/queryops/features/osquery/services"
+	"github.com/cavenine/queryops/internal/httpbody"
+	"github.com/cavenine/queryops/internal/logarchive"
+	"github.com/cavenine/queryops/internal/outbox"
 	"github.com/cavenine/queryops/internal/pubsub"
+	"github.com/cavenine/queryops/internal/requestsign"
+	"github.com/cavenine/queryops/internal/sharelink"
 	"github.com/go-chi/chi/v5"
 	"github.com/jackc/pgx/v5/pgxpool"
 )
 
-func SetupRoutes(router chi.Router, pool *pgxpool.Pool, orgService *orgServices.OrganizationService, ps *pubsub.PubSub) {
-	repo := services.NewHostRepository(pool)
+// Feature is the osquery TLS endpoints agents call and the pages and API for
+// hosts and campaigns.
+type Feature struct {
+	// agent serves the TLS endpoints, with hosts cached by node key.
+	agent *Handlers
+	ui    *Handlers
+	// graphql serves the read-only GraphQL API.
+	graphql http.Handler
+}
+
+// NewFeature wires the osquery feature. It starts relaying outbox events to
+// ps, listening on it for enrollments and deletions that invalidate cached
+// hosts, and recording host activity in host timelines, until ctx is done. ps
+// may be nil. jobs enqueues result exports. While breaker is open, the TLS
+// endpoints ask agents to retry instead of querying pool; it may be nil.
+func NewFeature(ctx context.Context, pool *pgxpool.Pool, breaker *db.Breaker, orgService *orgServices.OrganizationService, ps *pubsub.PubSub, jobs orgServices.JobInserter) (*Feature, error) {
+	// Check-ins resolve the host by node key on every request; cache it.
+	hostRepo := services.NewHostRepository(pool)
+	repo := newHostCache(hostRepo, defaultHostCacheTTL)
 
 	var publisher message.Publisher
 	if ps != nil {
 		publisher = ps.Publisher()
+		if err := repo.listenForInvalidations(ctx, ps); err != nil {
+			slog.ErrorContext(ctx, "failed to subscribe to host enrollments and deletions; cached hosts expire by TTL only", "error", err)
+		}
 	}
+	quotas := org.NewQuotaRepository(pool)
 
-	handlers := NewHandlers(repo, orgService, publisher, ps)
-
-	router.Route("/osquery", func(r chi.Router) {
-		r.Post("/enroll", handlers.Enroll)
-		r.Post("/config", handlers.Config)
-		r.Post("/logger", handlers.Logger)
-		r.Post("/distributed_read", handlers.DistributedRead)
-		r.Post("/distributed_write", handlers.DistributedWrite)
-	})
-}
+	agent := NewHandlers(repo, orgService, publisher, ps)
+	agent.quotas = quotas
+	agent.enrollNetworks = orgServices.NewEnrollNetworkRepository(pool)
+	agent.redaction = orgServices.NewRedactionRuleRepository(pool)
+	agent.resultLimits = hostRepo
+	upgrades := services.NewAgentUpgradeRepository(pool)
+	agent.upgradeTargets = upgrades
+	if breaker != nil {
+		agent.database = breaker
+	}
+	agent.nodeKeyMaxAge = time.Duration(config.Global.NodeKeyRotationMs) * time.Millisecond
+	agent.signing = newRequestSigning(hostRepo, requestsign.NewKeys(config.Global.SessionSecret))
+	if config.Global.OsqueryRateLimits != "" {
+		limits, err := parseRateLimits(config.Global.OsqueryRateLimits)
+		if err != nil {
+			return nil, fmt.Errorf("OSQUERY_RATE_LIMITS: %w", err)
+		}
+		agent.rateLimits = newRateLimiter(limits)
+	}
 
-func SetupProtectedRoutes(router chi.Router, pool *pgxpool.Pool, orgService *orgServices.OrganizationService, ps *pubsub.PubSub) {
-	repo := services.NewHostRepository(pool)
+	agent.logs = newLogIngester(
+		hostRepo,
+		config.Global.LogIngestQueueSize,
+		config.Global.LogIngestBatchSize,
+		time.Duration(config.Global.LogIngestFlushMs)*time.Millisecond,
+	)
+	if config.Global.LogArchiveBucket != "" {
+		store, err := LogArchiveStore()
+		if err != nil {
+			return nil, err
+		}
+		agent.archive = logarchive.NewArchiver(
+			store,
+			config.Global.LogArchivePrefix,
+			config.Global.LogArchiveQueueSize,
+			config.Global.LogArchiveObjectBytes,
+			time.Duration(config.Global.LogArchiveFlushMs)*time.Millisecond,
+		)
+		agent.logs.archive = agent.archive
+	}
 
-	var publisher message.Publisher
 	if ps != nil {
-		publisher = ps.Publisher()
+		agent.outbox = outbox.NewRelay(pool, ps.DirectPublisher())
+		go agent.outbox.Run(ctx)
+	}
+	if err := recordHostTimeline(ctx, pool, hostRepo); err != nil {
+		return nil, fmt.Errorf("recording host timelines: %w", err)
 	}
 
-	handlers := NewHandlers(repo, orgService, publisher, ps)
+	ui := NewHandlers(hostRepo, orgService, publisher, ps)
+	ui.quotas = quotas
+	ui.groups = hostRepo
+	ui.scheduleHealth = hostRepo
+	ui.hostSearch = hostRepo
+	ui.identities = hostRepo
+	ui.quarantines = hostRepo
+	ui.resultViews = hostRepo
+	ui.annotations = hostRepo
+	ui.configs = hostRepo
+	ui.packs = hostRepo
+	ui.shareLinks = sharelink.NewSigner(config.Global.SessionSecret, "campaign-result-view")
+	ui.secureLinks = config.Global.Environment == config.Prod
+	ui.upgrades = upgrades
+	ui.minOsqueryVersion = config.Global.OsqueryMinVersion
+	if config.Global.ResultExportDir != "" || config.Global.ResultExportBucket != "" {
+		ui.exports = services.NewResultExportRepository(pool, jobs)
+	}
+
+	return &Feature{
+		agent:   agent,
+		ui:      ui,
+		graphql: graph.NewHandler(hostRepo, pages.HostOnlineWindow, config.Global.GraphQLMaxComplexity),
+	}, nil
+}
+
+// Logs returns the ingester that persists /logger batches and archives them
+// if configured. The caller runs it, and closes it once the HTTP server has
+// stopped.
+func (f *Feature) Logs() *LogIngester {
+	return f.agent.logs
+}
+
+// Campaigns returns the queue the campaign pages and API create campaigns
+// through, for other transports to share.
+func (f *Feature) Campaigns() *CampaignQueue {
+	return f.ui.campaigns()
+}
+
+// SetupRoutes mounts the osquery TLS endpoints.
+func (f *Feature) SetupRoutes(router chi.Router) {
+	handlers := f.agent
+
+	router.Route("/osquery", func(r chi.Router) {
+		// Check-ins are small; logger and distributed_write carry result
+		// batches and get the larger limit. Bodies are kept to check request
+		// signatures against.
+		small := r.With(httpbody.Limit(config.Global.MaxOsqueryBodyBytes), requestsign.KeepBody)
+		small.Post("/enroll", handlers.Enroll)
+		small.Post("/config", handlers.Config)
+		small.Post("/distributed_read", handlers.DistributedRead)
+		small.Post("/ping", handlers.Ping)
+
+		large := r.With(httpbody.Limit(config.Global.MaxOsqueryWriteBodyBytes), requestsign.KeepBody)
+		large.Post("/logger", handlers.Logger)
+		large.Post("/distributed_write", handlers.DistributedWrite)
+	})
+}
+
+// SetupProtectedRoutes mounts the host and campaign pages and API. They
+// require an active organization.
+func (f *Feature) SetupProtectedRoutes(router chi.Router) {
+	handlers := f.ui
 
 	router.Get("/hosts", handlers.HostsPage)
+	router.Method(http.MethodGet, "/hosts/live", auth.LiveStream(handlers.HostsSSE))
+	router.Post("/hosts/bulk/group", handlers.BulkAddToGroup)
+	router.Post("/hosts/bulk/config", handlers.BulkAssignConfig)
+	router.Post("/hosts/bulk/query", handlers.BulkRunQuery)
+	router.Post("/hosts/bulk/delete", handlers.BulkDeleteHosts)
+
+	// Host groups
+	router.Get("/hosts/groups", handlers.HostGroupsPage)
+	router.Post("/hosts/groups", handlers.CreateHostGroup)
+	router.Post("/hosts/groups/{id}/query", handlers.RunHostGroupQuery)
+	router.Post("/hosts/groups/{id}/config", handlers.AssignHostGroupConfig)
+	router.Post("/hosts/groups/{id}/delete", handlers.DeleteHostGroup)
+	router.Get("/hosts/schedule", handlers.ScheduleHealthPage)
+	router.Get("/hosts/search", handlers.HostSearchPage)
 	router.Get("/hosts/{id}", handlers.HostDetailsPage)
-	router.Get("/hosts/{id}/results", handlers.HostResultsSSE)
+	router.Method(http.MethodGet, "/hosts/{id}/results", auth.LiveStream(handlers.HostResultsSSE))
+	router.Get("/hosts/{id}/results/more", handlers.HostResultsMore)
 	router.Post("/hosts/{id}/query", handlers.RunQuery)
+	router.Post("/hosts/{id}/identity/split", handlers.SplitHostIdentity)
+	router.Post("/hosts/{id}/identity/dismiss", handlers.DismissHostIdentityConflict)
+	router.Post("/hosts/{id}/quarantine", handlers.QuarantineHost)
+	router.Post("/hosts/{id}/release", handlers.ReleaseHost)
 
 	// Campaign UI
 	router.Get("/campaigns", handlers.CampaignsPage)
 	router.Get("/campaigns/new", handlers.CampaignNewPage)
+	router.Get("/campaigns/new/targets", handlers.CampaignTargetsSSE)
 	router.Post("/campaigns/run", handlers.RunCampaign)
 	router.Get("/campaigns/{id}", handlers.CampaignPage)
-	router.Get("/campaigns/{id}/results", handlers.CampaignResultsSSE)
+	router.Method(http.MethodGet, "/campaigns/{id}/results", auth.LiveStream(handlers.CampaignResultsSSE))
+	router.Post("/campaigns/{id}/rerun", handlers.RerunCampaignUI)
+	router.Post("/campaigns/{id}/archive", handlers.ArchiveCampaignUI)
+	router.Post("/campaigns/{id}/unarchive", handlers.UnarchiveCampaignUI)
+	router.Post("/campaigns/{id}/views", handlers.SaveResultView)
+	router.Post("/campaigns/{id}/annotations", handlers.AnnotateResultUI)
+	router.Post("/campaigns/{id}/views/{viewID}/share", handlers.ShareResultView)
+	router.Post("/campaigns/{id}/views/{viewID}/delete", handlers.DeleteResultView)
+	router.Get("/campaigns/shared/{token}", handlers.OpenSharedResultView)
+
+	// Query library
+	router.Get("/packs", handlers.PackLibraryPage)
+	router.Group(func(r chi.Router) {
+		// Enabled packs run on every host in the organization.
+		r.Use(org.RequireRole(orgServices.RoleOwner, orgServices.RoleAdmin))
+		r.Post("/packs/{name}/enable", handlers.EnableLibraryPack)
+		r.Post("/packs/{name}/disable", handlers.DisableLibraryPack)
+	})
 
 	// Campaign API
 	router.Route("/api/v1", func(r chi.Router) {
+		r.Get("/hosts/search", handlers.SearchHosts)
+		r.Get("/hosts/{id}/results", handlers.ListHostResults)
+		r.Get("/results/search", handlers.SearchResults)
+		r.With(org.RequireFeature(orgServices.FeatureResultExports)).Post("/results/exports", handlers.CreateResultExport)
+		r.Get("/results/exports", handlers.ListResultExports)
+		r.Get("/results/exports/{id}", handlers.GetResultExport)
+		r.Get("/agent-upgrades", handlers.ListAgentUpgrades)
+		r.Get("/agent-upgrades/{id}", handlers.GetAgentUpgrade)
+		r.Group(func(r chi.Router) {
+			// Upgrading osquery across the fleet is for administrators.
+			r.Use(org.RequireRole(orgServices.RoleOwner, orgServices.RoleAdmin))
+			r.Post("/agent-upgrades", handlers.CreateAgentUpgrade)
+			r.Post("/agent-upgrades/{id}/advance", handlers.AdvanceAgentUpgrade)
+			r.Post("/agent-upgrades/{id}/cancel", handlers.CancelAgentUpgrade)
+		})
+		r.Get("/schema", handlers.QuerySchema)
 		r.Post("/queries/run", handlers.CreateCampaign)
 		r.Get("/campaigns", handlers.ListCampaigns)
 		r.Get("/campaigns/{id}", handlers.GetCampaign)
 		r.Get("/campaigns/{id}/results", handlers.CampaignResultsSSE)
+		r.Post("/campaigns/{id}/rerun", handlers.RerunCampaign)
+		r.Post("/campaigns/{id}/archive", handlers.ArchiveCampaign)
+		r.Post("/campaigns/{id}/unarchive", handlers.UnarchiveCampaign)
+		r.Get("/campaigns/{id}/annotations", handlers.ListResultAnnotations)
+		r.Post("/campaigns/{id}/annotations", handlers.AnnotateResult)
 	})
+	router.Method(http.MethodPost, "/api/graphql", f.graphql)
+}
+
+// SetupAdminRoutes mounts the export and import of the osquery configs'
+// packs. Configs are shared by every organization, so the router must only
+// let superusers through; see auth.RequireSuperuser.
+func (f *Feature) SetupAdminRoutes(router chi.Router) {
+	handlers := f.ui
+
+	router.Get("/admin/packs", handlers.PacksPage)
+	router.Post("/admin/packs/import", handlers.ImportPacks)
+	router.Get("/admin/packs/{name}/export", handlers.ExportPacks)
 }
@@ -0,0 +1,56 @@
+package osquery
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+
+	org "github.com/cavenine/queryops/features/organization"
+	"github.com/cavenine/queryops/features/osquery/pages"
+	"github.com/cavenine/queryops/features/osquery/services"
+)
+
+// scheduleFailureWindow is how far back the schedule health page looks for
+// status logs of scheduled queries failing.
+const scheduleFailureWindow = 24 * time.Hour
+
+type scheduleHealthReader interface {
+	ListScheduleHealth(ctx context.Context, organizationID uuid.UUID, since time.Time) ([]services.ScheduleHealth, error)
+}
+
+// addScheduleVitals adds the query that reports which scheduled queries
+// osquery has denylisted to a config served to a host.
+func addScheduleVitals(cfg *ConfigResponse) {
+	if cfg.Schedule == nil {
+		cfg.Schedule = make(map[string]ScheduledQuery)
+	}
+	cfg.Schedule[services.ScheduleVitalsQueryName] = ScheduledQuery{
+		Query:    services.ScheduleVitalsQuery,
+		Interval: services.ScheduleVitalsInterval,
+		Snapshot: true,
+	}
+}
+
+// ScheduleHealthPage lists the scheduled queries that osquery denylists or
+// that fail on the active organization's hosts.
+func (h *Handlers) ScheduleHealthPage(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	activeOrg := org.GetOrganizationFromContext(ctx)
+	if activeOrg == nil {
+		slog.Error("missing active organization in context")
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+
+	health, err := h.scheduleHealth.ListScheduleHealth(ctx, activeOrg.ID, time.Now().Add(-scheduleFailureWindow))
+	if err != nil {
+		slog.ErrorContext(ctx, "failed to list schedule health", "error", err)
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+
+	pages.ScheduleHealthPage(health).Render(ctx, w)
+}
@@ -0,0 +1,44 @@
+package osquery
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cavenine/queryops/features/osquery/services"
+)
+
+func TestConfig_AddsScheduleVitals(t *testing.T) {
+	h := NewHandlers(&issuedAtHostRepo{issuedAt: time.Now()}, nil, nil, nil)
+
+	req := httptest.NewRequest(http.MethodPost, "/osquery/config", strings.NewReader(`{"node_key":"k"}`))
+	rec := httptest.NewRecorder()
+	h.Config(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d", rec.Code)
+	}
+	var resp struct {
+		Schedule map[string]struct {
+			Query    string `json:"query"`
+			Interval int    `json:"interval"`
+			Snapshot bool   `json:"snapshot"`
+		} `json:"schedule"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("unmarshal: %v", err)
+	}
+	q, ok := resp.Schedule[services.ScheduleVitalsQueryName]
+	if !ok {
+		t.Fatalf("schedule = %v, want %s", resp.Schedule, services.ScheduleVitalsQueryName)
+	}
+	if q.Query != services.ScheduleVitalsQuery || q.Interval != services.ScheduleVitalsInterval || !q.Snapshot {
+		t.Fatalf("vitals query = %+v", q)
+	}
+	if q := resp.Schedule[services.OsqueryInfoQueryName]; q.Query != services.OsqueryInfoQuery || !q.Snapshot {
+		t.Fatalf("osquery info query = %+v", q)
+	}
+}
@@ -0,0 +1,103 @@
+// Package schema provides the osquery table schema for the query editor's
+// autocomplete. The schema is bundled as one JSON file per platform.
+package schema
+
+import (
+	"embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+)
+
+//go:embed *.json
+var files embed.FS
+
+// Platforms lists the platforms a schema is bundled for.
+var Platforms = []string{"darwin", "linux", "windows"}
+
+// ErrUnknownPlatform is returned for a platform not in Platforms.
+var ErrUnknownPlatform = errors.New("unknown platform")
+
+type Column struct {
+	Name        string `json:"name"`
+	Type        string `json:"type"`
+	Description string `json:"description"`
+}
+
+type Table struct {
+	Name        string   `json:"name"`
+	Description string   `json:"description"`
+	Columns     []Column `json:"columns"`
+}
+
+var load = sync.OnceValues(func() (map[string][]Table, error) {
+	schemas := make(map[string][]Table, len(Platforms))
+	for _, platform := range Platforms {
+		data, err := files.ReadFile(platform + ".json")
+		if err != nil {
+			return nil, fmt.Errorf("reading %s schema: %w", platform, err)
+		}
+		var tables []Table
+		if err := json.Unmarshal(data, &tables); err != nil {
+			return nil, fmt.Errorf("parsing %s schema: %w", platform, err)
+		}
+		schemas[platform] = tables
+	}
+	return schemas, nil
+})
+
+// Tables returns the tables available on platform, sorted by name. An empty
+// platform returns the tables of every platform, for queries that target a
+// mix of hosts.
+func Tables(platform string) ([]Table, error) {
+	schemas, err := load()
+	if err != nil {
+		return nil, err
+	}
+
+	if platform != "" {
+		tables, ok := schemas[platform]
+		if !ok {
+			return nil, fmt.Errorf("%w: %q", ErrUnknownPlatform, platform)
+		}
+		return tables, nil
+	}
+
+	var all []Table
+	seen := make(map[string]bool)
+	for _, p := range Platforms {
+		for _, t := range schemas[p] {
+			if !seen[t.Name] {
+				seen[t.Name] = true
+				all = append(all, t)
+			}
+		}
+	}
+	slices.SortFunc(all, func(a, b Table) int { return strings.Compare(a.Name, b.Name) })
+	return all, nil
+}
+
+// Platform maps a host's os_version platform and platform_like values to one
+// of Platforms, or "" if the host is not on a known platform.
+func Platform(platform, platformLike string) string {
+	switch platform {
+	case "darwin", "windows":
+		return platform
+	case "linux":
+		return "linux"
+	}
+	// Linux distributions report their own ID, e.g. "ubuntu" like "debian".
+	for _, id := range append([]string{platform}, strings.Fields(platformLike)...) {
+		if slices.Contains(linuxDistributions, id) {
+			return "linux"
+		}
+	}
+	return ""
+}
+
+var linuxDistributions = []string{
+	"amzn", "arch", "centos", "debian", "fedora", "gentoo", "rhel", "rocky", "sles", "ubuntu",
+}
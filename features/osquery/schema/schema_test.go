@@ -0,0 +1,72 @@
+package schema
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestTables(t *testing.T) {
+	for _, platform := range Platforms {
+		tables, err := Tables(platform)
+		if err != nil {
+			t.Fatalf("Tables(%q): %v", platform, err)
+		}
+		if !hasTable(tables, "processes") {
+			t.Fatalf("%s schema has no processes table", platform)
+		}
+		for _, table := range tables {
+			if len(table.Columns) == 0 {
+				t.Fatalf("%s.%s has no columns", platform, table.Name)
+			}
+		}
+	}
+
+	linux, _ := Tables("linux")
+	if hasTable(linux, "registry") {
+		t.Fatalf("linux schema includes the windows registry table")
+	}
+
+	all, err := Tables("")
+	if err != nil {
+		t.Fatalf("Tables(\"\"): %v", err)
+	}
+	if !hasTable(all, "registry") || !hasTable(all, "deb_packages") || !hasTable(all, "launchd") {
+		t.Fatalf("combined schema is missing platform tables")
+	}
+	for i := 1; i < len(all); i++ {
+		if all[i-1].Name >= all[i].Name {
+			t.Fatalf("combined schema not sorted or has duplicates at %q", all[i].Name)
+		}
+	}
+
+	if _, err := Tables("plan9"); !errors.Is(err, ErrUnknownPlatform) {
+		t.Fatalf("Tables(plan9) error = %v, want ErrUnknownPlatform", err)
+	}
+}
+
+func TestPlatform(t *testing.T) {
+	tests := []struct {
+		platform, like, want string
+	}{
+		{"darwin", "darwin", "darwin"},
+		{"windows", "windows", "windows"},
+		{"ubuntu", "debian", "linux"},
+		{"rhel", "", "linux"},
+		{"freebsd", "", ""},
+		{"", "", ""},
+	}
+	for _, tt := range tests {
+		if got := Platform(tt.platform, tt.like); got != tt.want {
+			t.Errorf("Platform(%q, %q) = %q, want %q", tt.platform, tt.like, got, tt.want)
+		}
+	}
+}
+
+func hasTable(tables []Table, name string) bool {
+	for _, table := range tables {
+		if table.Name == name {
+			return true
+		}
+	}
+	return false
+}
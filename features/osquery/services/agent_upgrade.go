@@ -0,0 +1,400 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgxpool"
+
+	"github.com/cavenine/queryops/db"
+)
+
+// Agent upgrade statuses.
+const (
+	UpgradeActive    = "active"
+	UpgradeCompleted = "completed"
+	UpgradeCancelled = "cancelled"
+)
+
+// Statuses of a host in an agent upgrade.
+const (
+	UpgradeHostPending   = "pending"
+	UpgradeHostRequested = "requested"
+	UpgradeHostCompleted = "completed"
+)
+
+var (
+	ErrAgentUpgradeNotFound   = errors.New("agent upgrade not found")
+	ErrAgentUpgradeInProgress = errors.New("organization already has an active agent upgrade")
+	ErrAgentUpgradeNotActive  = errors.New("agent upgrade is not active")
+	ErrNoMoreUpgradeWaves     = errors.New("every wave of the agent upgrade is already released")
+	ErrInvalidUpgradeVersion  = errors.New("target version must be a dotted osquery version such as 5.12.1")
+	ErrNoHostsToUpgrade       = errors.New("no hosts run an osquery older than the target version")
+)
+
+// AgentUpgrade rolls an organization's hosts running an osquery older than
+// TargetVersion to it, WaveSize hosts at a time. Hosts in the first
+// ReleasedWaves waves are told the target in their config.
+type AgentUpgrade struct {
+	ID             uuid.UUID `db:"id" json:"id"`
+	OrganizationID uuid.UUID `db:"organization_id" json:"organization_id"`
+	TargetVersion  string    `db:"target_version" json:"target_version"`
+	WaveSize       int       `db:"wave_size" json:"wave_size"`
+	Waves          int       `db:"waves" json:"waves"`
+	ReleasedWaves  int       `db:"released_waves" json:"released_waves"`
+	Status         string    `db:"status" json:"status"`
+	CreatedBy      *int      `db:"created_by" json:"created_by,omitempty"`
+	CreatedAt      time.Time `db:"created_at" json:"created_at"`
+	UpdatedAt      time.Time `db:"updated_at" json:"updated_at"`
+
+	// Progress, counted over the upgrade's hosts.
+	Hosts         int `db:"hosts" json:"hosts"`
+	ReleasedHosts int `db:"released_hosts" json:"released_hosts"`
+	Requested     int `db:"requested" json:"requested"`
+	Completed     int `db:"completed" json:"completed"`
+}
+
+// AgentUpgradeHost is a host's progress in an agent upgrade.
+type AgentUpgradeHost struct {
+	HostID         uuid.UUID `db:"host_id" json:"host_id"`
+	HostIdentifier string    `db:"host_identifier" json:"host_identifier"`
+	Wave           int       `db:"wave" json:"wave"`
+	// FromVersion is the osquery version the host ran when the upgrade was
+	// created; OsqueryVersion is the one it last reported.
+	FromVersion    string     `db:"from_version" json:"from_version"`
+	OsqueryVersion string     `db:"osquery_version" json:"osquery_version"`
+	Status         string     `db:"status" json:"status"`
+	RequestedAt    *time.Time `db:"requested_at" json:"requested_at,omitempty"`
+	CompletedAt    *time.Time `db:"completed_at" json:"completed_at,omitempty"`
+}
+
+// AgentUpgradeTarget is what a host in a released wave is told in its config
+// under the "queryops_agent_upgrade" key, for an updater extension or script
+// to act on.
+type AgentUpgradeTarget struct {
+	UpgradeID uuid.UUID `db:"upgrade_id" json:"upgrade_id"`
+	Version   string    `db:"target_version" json:"version"`
+	Wave      int       `db:"wave" json:"wave"`
+}
+
+var (
+	agentUpgradeColumns     = db.Columns[AgentUpgrade]()
+	agentUpgradeHostColumns = db.Columns[AgentUpgradeHost]()
+)
+
+// agentUpgradesWithProgress selects agent_upgrades with their progress
+// counts, under the same name.
+const agentUpgradesWithProgress = `(
+	SELECT u.*, p.hosts, p.released_hosts, p.requested, p.completed
+	FROM agent_upgrades u
+	CROSS JOIN LATERAL (
+		SELECT
+			COUNT(*)::int AS hosts,
+			COUNT(*) FILTER (WHERE t.wave < u.released_waves)::int AS released_hosts,
+			COUNT(*) FILTER (WHERE t.status = 'requested')::int AS requested,
+			COUNT(*) FILTER (WHERE t.status = 'completed')::int AS completed
+		FROM agent_upgrade_hosts t
+		WHERE t.upgrade_id = u.id
+	) p
+) AS agent_upgrades`
+
+type AgentUpgradeRepository struct {
+	pool *pgxpool.Pool
+}
+
+func NewAgentUpgradeRepository(pool *pgxpool.Pool) *AgentUpgradeRepository {
+	return &AgentUpgradeRepository{pool: pool}
+}
+
+// Create starts an upgrade of the organization's hosts running an osquery
+// older than targetVersion, or only those in groupID when it's set. Hosts are
+// split into waves of waveSize in a stable but arbitrary order, and the first
+// wave is released at once. Hosts that haven't reported a comparable version
+// are left out.
+func (r *AgentUpgradeRepository) Create(ctx context.Context, organizationID uuid.UUID, targetVersion string, waveSize int, groupID *uuid.UUID, createdBy *int) (*AgentUpgrade, error) {
+	if _, ok := parseOsqueryVersion(targetVersion); !ok {
+		return nil, ErrInvalidUpgradeVersion
+	}
+	if waveSize < 1 {
+		return nil, fmt.Errorf("wave size must be positive, got %d", waveSize)
+	}
+
+	tx, err := r.pool.Begin(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("creating agent upgrade: begin transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	rows, err := tx.Query(ctx, `
+		SELECT h.id, h.osquery_version
+		FROM hosts h
+		WHERE h.organization_id = $1
+		  AND ($2::uuid IS NULL OR EXISTS (
+			SELECT 1 FROM host_group_members m
+			JOIN host_groups g ON g.id = m.group_id
+			WHERE m.host_id = h.id AND g.id = $2 AND g.organization_id = $1
+		  ))
+		ORDER BY h.id
+	`, organizationID, groupID)
+	if err != nil {
+		return nil, fmt.Errorf("listing hosts to upgrade: %w", err)
+	}
+	var (
+		hostIDs  []uuid.UUID
+		versions []string
+		hostID   uuid.UUID
+		version  string
+	)
+	_, err = pgx.ForEachRow(rows, []any{&hostID, &version}, func() error {
+		if cmp, ok := CompareOsqueryVersions(version, targetVersion); ok && cmp < 0 {
+			hostIDs = append(hostIDs, hostID)
+			versions = append(versions, version)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("listing hosts to upgrade: %w", err)
+	}
+	if len(hostIDs) == 0 {
+		return nil, ErrNoHostsToUpgrade
+	}
+
+	waves := (len(hostIDs) + waveSize - 1) / waveSize
+	var id uuid.UUID
+	err = tx.QueryRow(ctx, `
+		INSERT INTO agent_upgrades (organization_id, target_version, wave_size, waves, released_waves, created_by)
+		VALUES ($1, $2, $3, $4, 1, $5)
+		RETURNING id
+	`, organizationID, targetVersion, waveSize, waves, createdBy).Scan(&id)
+	if err != nil {
+		var pgErr *pgconn.PgError
+		if errors.As(err, &pgErr) && pgErr.Code == "23505" {
+			return nil, ErrAgentUpgradeInProgress
+		}
+		return nil, fmt.Errorf("inserting agent upgrade: %w", err)
+	}
+
+	if _, err := tx.Exec(ctx, `
+		INSERT INTO agent_upgrade_hosts (upgrade_id, host_id, wave, from_version)
+		SELECT $1, v.host_id, (v.n - 1) / $2, v.version
+		FROM unnest($3::uuid[], $4::text[]) WITH ORDINALITY AS v(host_id, version, n)
+	`, id, waveSize, hostIDs, versions); err != nil {
+		return nil, fmt.Errorf("inserting agent upgrade hosts: %w", err)
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return nil, fmt.Errorf("creating agent upgrade: commit transaction: %w", err)
+	}
+	return r.GetForOrganization(ctx, organizationID, id)
+}
+
+// List returns the organization's most recent upgrades, newest first.
+func (r *AgentUpgradeRepository) List(ctx context.Context, organizationID uuid.UUID, limit int) ([]AgentUpgrade, error) {
+	rows, err := r.pool.Query(ctx, `
+		SELECT `+agentUpgradeColumns+`
+		FROM `+agentUpgradesWithProgress+`
+		WHERE organization_id = $1
+		ORDER BY created_at DESC, id
+		LIMIT $2
+	`, organizationID, limit)
+	if err != nil {
+		return nil, fmt.Errorf("listing agent upgrades: %w", err)
+	}
+	upgrades, err := pgx.CollectRows(rows, pgx.RowToStructByName[AgentUpgrade])
+	if err != nil {
+		return nil, fmt.Errorf("scanning agent upgrades: %w", err)
+	}
+	return upgrades, nil
+}
+
+// GetForOrganization returns one of the organization's upgrades, or
+// ErrAgentUpgradeNotFound.
+func (r *AgentUpgradeRepository) GetForOrganization(ctx context.Context, organizationID, id uuid.UUID) (*AgentUpgrade, error) {
+	rows, err := r.pool.Query(ctx, `
+		SELECT `+agentUpgradeColumns+`
+		FROM `+agentUpgradesWithProgress+`
+		WHERE id = $1 AND organization_id = $2
+	`, id, organizationID)
+	if err != nil {
+		return nil, fmt.Errorf("querying agent upgrade: %w", err)
+	}
+	upgrade, err := pgx.CollectOneRow(rows, pgx.RowToAddrOfStructByName[AgentUpgrade])
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, ErrAgentUpgradeNotFound
+		}
+		return nil, fmt.Errorf("querying agent upgrade: %w", err)
+	}
+	return upgrade, nil
+}
+
+// Hosts returns the progress of each host in one of the organization's
+// upgrades, by wave.
+func (r *AgentUpgradeRepository) Hosts(ctx context.Context, organizationID, id uuid.UUID) ([]AgentUpgradeHost, error) {
+	rows, err := r.pool.Query(ctx, `
+		SELECT `+agentUpgradeHostColumns+`
+		FROM (
+			SELECT t.*, h.host_identifier, h.osquery_version
+			FROM agent_upgrade_hosts t
+			JOIN agent_upgrades u ON u.id = t.upgrade_id
+			JOIN hosts h ON h.id = t.host_id
+			WHERE t.upgrade_id = $1 AND u.organization_id = $2
+		) AS agent_upgrade_hosts
+		ORDER BY wave, host_identifier, host_id
+	`, id, organizationID)
+	if err != nil {
+		return nil, fmt.Errorf("listing agent upgrade hosts: %w", err)
+	}
+	hosts, err := pgx.CollectRows(rows, pgx.RowToStructByName[AgentUpgradeHost])
+	if err != nil {
+		return nil, fmt.Errorf("scanning agent upgrade hosts: %w", err)
+	}
+	return hosts, nil
+}
+
+// Advance releases the next wave of one of the organization's active
+// upgrades. It returns ErrNoMoreUpgradeWaves once every wave is released.
+func (r *AgentUpgradeRepository) Advance(ctx context.Context, organizationID, id uuid.UUID) (*AgentUpgrade, error) {
+	upgrade, err := r.GetForOrganization(ctx, organizationID, id)
+	if err != nil {
+		return nil, err
+	}
+	if upgrade.Status != UpgradeActive {
+		return nil, ErrAgentUpgradeNotActive
+	}
+
+	tag, err := r.pool.Exec(ctx, `
+		UPDATE agent_upgrades
+		SET released_waves = released_waves + 1, updated_at = NOW()
+		WHERE id = $1 AND status = 'active' AND released_waves < waves
+	`, id)
+	if err != nil {
+		return nil, fmt.Errorf("advancing agent upgrade: %w", err)
+	}
+	if tag.RowsAffected() == 0 {
+		return nil, ErrNoMoreUpgradeWaves
+	}
+	return r.GetForOrganization(ctx, organizationID, id)
+}
+
+// Cancel stops one of the organization's active upgrades. Its hosts are no
+// longer told the target, though those that already started upgrading may
+// finish.
+func (r *AgentUpgradeRepository) Cancel(ctx context.Context, organizationID, id uuid.UUID) (*AgentUpgrade, error) {
+	tag, err := r.pool.Exec(ctx, `
+		UPDATE agent_upgrades
+		SET status = 'cancelled', updated_at = NOW()
+		WHERE id = $1 AND organization_id = $2 AND status = 'active'
+	`, id, organizationID)
+	if err != nil {
+		return nil, fmt.Errorf("cancelling agent upgrade: %w", err)
+	}
+	upgrade, err := r.GetForOrganization(ctx, organizationID, id)
+	if err != nil {
+		return nil, err
+	}
+	if tag.RowsAffected() == 0 {
+		return nil, ErrAgentUpgradeNotActive
+	}
+	return upgrade, nil
+}
+
+// TargetForHost returns the upgrade a host should make, or nil if it isn't
+// in a released wave of an active upgrade or has already upgraded. The first
+// time a host is given a target it's marked requested.
+func (r *AgentUpgradeRepository) TargetForHost(ctx context.Context, hostID uuid.UUID) (*AgentUpgradeTarget, error) {
+	rows, err := r.pool.Query(ctx, `
+		WITH target AS (
+			SELECT t.upgrade_id, t.host_id, t.wave, t.status, u.target_version
+			FROM agent_upgrade_hosts t
+			JOIN agent_upgrades u ON u.id = t.upgrade_id
+			WHERE t.host_id = $1
+			  AND u.status = 'active'
+			  AND t.wave < u.released_waves
+			  AND t.status <> 'completed'
+		), requested AS (
+			UPDATE agent_upgrade_hosts t
+			SET status = 'requested', requested_at = NOW()
+			FROM target
+			WHERE t.upgrade_id = target.upgrade_id
+			  AND t.host_id = target.host_id
+			  AND target.status = 'pending'
+		)
+		SELECT upgrade_id, target_version, wave FROM target
+	`, hostID)
+	if err != nil {
+		return nil, fmt.Errorf("querying agent upgrade target: %w", err)
+	}
+	target, err := pgx.CollectOneRow(rows, pgx.RowToAddrOfStructByName[AgentUpgradeTarget])
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("querying agent upgrade target: %w", err)
+	}
+	return target, nil
+}
+
+// completeAgentUpgrades marks hosts in active upgrades completed once the
+// version they report is at least the target, and completes upgrades that
+// have no hosts left. hostIDs and versions are parallel.
+func completeAgentUpgrades(ctx context.Context, tx pgx.Tx, hostIDs []uuid.UUID, versions []string) error {
+	reported := make(map[uuid.UUID]string, len(hostIDs))
+	for i, id := range hostIDs {
+		reported[id] = versions[i]
+	}
+
+	rows, err := tx.Query(ctx, `
+		SELECT t.upgrade_id, t.host_id, u.target_version
+		FROM agent_upgrade_hosts t
+		JOIN agent_upgrades u ON u.id = t.upgrade_id
+		WHERE t.host_id = ANY($1) AND u.status = 'active' AND t.status <> 'completed'
+	`, hostIDs)
+	if err != nil {
+		return fmt.Errorf("querying agent upgrade hosts: %w", err)
+	}
+	var (
+		upgradeIDs, doneHosts []uuid.UUID
+		upgradeID, hostID     uuid.UUID
+		target                string
+	)
+	_, err = pgx.ForEachRow(rows, []any{&upgradeID, &hostID, &target}, func() error {
+		if cmp, ok := CompareOsqueryVersions(reported[hostID], target); ok && cmp >= 0 {
+			upgradeIDs = append(upgradeIDs, upgradeID)
+			doneHosts = append(doneHosts, hostID)
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("querying agent upgrade hosts: %w", err)
+	}
+	if len(doneHosts) == 0 {
+		return nil
+	}
+
+	if _, err := tx.Exec(ctx, `
+		UPDATE agent_upgrade_hosts t
+		SET status = 'completed', completed_at = NOW()
+		FROM unnest($1::uuid[], $2::uuid[]) AS v(upgrade_id, host_id)
+		WHERE t.upgrade_id = v.upgrade_id AND t.host_id = v.host_id
+	`, upgradeIDs, doneHosts); err != nil {
+		return fmt.Errorf("completing agent upgrade hosts: %w", err)
+	}
+	if _, err := tx.Exec(ctx, `
+		UPDATE agent_upgrades u
+		SET status = 'completed', updated_at = NOW()
+		WHERE u.id = ANY($1) AND u.status = 'active'
+		  AND NOT EXISTS (
+			SELECT 1 FROM agent_upgrade_hosts t
+			WHERE t.upgrade_id = u.id AND t.status <> 'completed'
+		  )
+	`, upgradeIDs); err != nil {
+		return fmt.Errorf("completing agent upgrades: %w", err)
+	}
+	return nil
+}
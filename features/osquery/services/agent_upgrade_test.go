@@ -0,0 +1,118 @@
+package services_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/cavenine/queryops/features/osquery/services"
+	"github.com/cavenine/queryops/internal/testdb"
+	"github.com/cavenine/queryops/internal/testdb/fixtures"
+)
+
+func TestAgentUpgradeRepository(t *testing.T) {
+	tdb := testdb.SetupTestDB(t)
+	ctx := context.Background()
+
+	orgID := fixtures.CreateOrg(t, tdb.Pool, "agent-upgrade-org").ID
+	hostRepo := services.NewHostRepository(tdb.Pool)
+	repo := services.NewAgentUpgradeRepository(tdb.Pool)
+
+	hostOn := func(identifier, version string) uuid.UUID {
+		id := fixtures.CreateHost(t, tdb.Pool, orgID, identifier).ID
+		if _, err := tdb.Pool.Exec(ctx, `UPDATE hosts SET osquery_version = $2 WHERE id = $1`, id, version); err != nil {
+			t.Fatalf("setting osquery version: %v", err)
+		}
+		return id
+	}
+	old1 := hostOn("old-1", "5.11.0")
+	old2 := hostOn("old-2", "5.12.0")
+	hostOn("current", "5.13.1")
+	hostOn("unknown", "")
+
+	if _, err := repo.Create(ctx, orgID, "latest", 1, nil, nil); !errors.Is(err, services.ErrInvalidUpgradeVersion) {
+		t.Fatalf("Create with a bad version: err = %v", err)
+	}
+	if _, err := repo.Create(ctx, orgID, "5.0.0", 1, nil, nil); !errors.Is(err, services.ErrNoHostsToUpgrade) {
+		t.Fatalf("Create with no older hosts: err = %v", err)
+	}
+
+	upgrade, err := repo.Create(ctx, orgID, "5.13.1", 1, nil, nil)
+	if err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	if upgrade.Hosts != 2 || upgrade.Waves != 2 || upgrade.ReleasedWaves != 1 || upgrade.ReleasedHosts != 1 {
+		t.Fatalf("created upgrade = %+v", upgrade)
+	}
+	if _, err := repo.Create(ctx, orgID, "5.14.0", 1, nil, nil); !errors.Is(err, services.ErrAgentUpgradeInProgress) {
+		t.Fatalf("second Create: err = %v", err)
+	}
+
+	hosts, err := repo.Hosts(ctx, orgID, upgrade.ID)
+	if err != nil || len(hosts) != 2 {
+		t.Fatalf("Hosts = %+v, %v", hosts, err)
+	}
+	waveOf := map[uuid.UUID]int{hosts[0].HostID: hosts[0].Wave, hosts[1].HostID: hosts[1].Wave}
+	first, second := old1, old2
+	if waveOf[old1] == 1 {
+		first, second = old2, old1
+	}
+
+	target, err := repo.TargetForHost(ctx, first)
+	if err != nil || target == nil || target.Version != "5.13.1" || target.Wave != 0 {
+		t.Fatalf("TargetForHost(first wave) = %+v, %v", target, err)
+	}
+	if target, err := repo.TargetForHost(ctx, second); err != nil || target != nil {
+		t.Fatalf("TargetForHost(unreleased wave) = %+v, %v", target, err)
+	}
+
+	upgrade, err = repo.Advance(ctx, orgID, upgrade.ID)
+	if err != nil || upgrade.ReleasedWaves != 2 || upgrade.Requested != 1 {
+		t.Fatalf("Advance = %+v, %v", upgrade, err)
+	}
+	if _, err := repo.Advance(ctx, orgID, upgrade.ID); !errors.Is(err, services.ErrNoMoreUpgradeWaves) {
+		t.Fatalf("Advance past the last wave: err = %v", err)
+	}
+	if target, err := repo.TargetForHost(ctx, second); err != nil || target == nil {
+		t.Fatalf("TargetForHost(second wave) = %+v, %v", target, err)
+	}
+
+	// Hosts complete once their vitals report the target or newer.
+	vitals := func(hostID uuid.UUID, version string) services.ResultLogEntry {
+		return services.ResultLogEntry{
+			HostID:    hostID,
+			Name:      services.OsqueryInfoQueryName,
+			Action:    "snapshot",
+			Columns:   json.RawMessage(`{"version":"` + version + `","config_hash":"h","extensions":"active"}`),
+			Timestamp: time.Now(),
+		}
+	}
+	if err := hostRepo.SaveLogBatch(ctx, []services.ResultLogEntry{vitals(first, "5.13.1"), vitals(second, "5.12.1")}, nil); err != nil {
+		t.Fatalf("SaveLogBatch: %v", err)
+	}
+	upgrade, err = repo.GetForOrganization(ctx, orgID, upgrade.ID)
+	if err != nil || upgrade.Completed != 1 || upgrade.Status != services.UpgradeActive {
+		t.Fatalf("after one host upgraded = %+v, %v", upgrade, err)
+	}
+	if target, err := repo.TargetForHost(ctx, first); err != nil || target != nil {
+		t.Fatalf("TargetForHost(upgraded host) = %+v, %v", target, err)
+	}
+
+	if err := hostRepo.SaveLogBatch(ctx, []services.ResultLogEntry{vitals(second, "5.14.0")}, nil); err != nil {
+		t.Fatalf("SaveLogBatch: %v", err)
+	}
+	upgrade, err = repo.GetForOrganization(ctx, orgID, upgrade.ID)
+	if err != nil || upgrade.Completed != 2 || upgrade.Status != services.UpgradeCompleted {
+		t.Fatalf("after every host upgraded = %+v, %v", upgrade, err)
+	}
+	if _, err := repo.Cancel(ctx, orgID, upgrade.ID); !errors.Is(err, services.ErrAgentUpgradeNotActive) {
+		t.Fatalf("Cancel a completed upgrade: err = %v", err)
+	}
+	if _, err := repo.GetForOrganization(ctx, fixtures.CreateOrg(t, tdb.Pool, "other-org").ID, upgrade.ID); !errors.Is(err, services.ErrAgentUpgradeNotFound) {
+		t.Fatalf("GetForOrganization from another organization: err = %v", err)
+	}
+}
@@ -23,6 +23,105 @@ type Campaign struct {
 	Status         string    `json:"status"`
 	TargetCount    int       `json:"target_count"`
 	ResultCount    int       `json:"result_count"`
+
+	// PreviousCampaignID is set on re-runs; targets are diffed against it.
+	PreviousCampaignID *uuid.UUID `json:"previous_campaign_id,omitempty"`
+
+	// FanoutLimit, if set, is the most hosts the query is released to per
+	// FanoutIntervalSeconds.
+	FanoutLimit           *int `json:"fanout_limit,omitempty"`
+	FanoutIntervalSeconds int  `json:"fanout_interval_seconds"`
+
+	// MaxRowsPerHost and MaxBytesPerHost, if set, cap each host's stored
+	// results; see ResultLimits.
+	MaxRowsPerHost  *int   `json:"max_rows_per_host,omitempty"`
+	MaxBytesPerHost *int64 `json:"max_bytes_per_host,omitempty"`
+	// TimeoutSeconds, if set, fails targets still outstanding this long
+	// after the campaign was created.
+	TimeoutSeconds *int `json:"timeout_seconds,omitempty"`
+
+	// ArchivedAt is set while the campaign is hidden from the campaigns
+	// list.
+	ArchivedAt *time.Time `json:"archived_at,omitempty"`
+}
+
+// Deadline returns when the campaign's outstanding targets are failed, or
+// nil if it has no timeout.
+func (c *Campaign) Deadline() *time.Time {
+	if c.TimeoutSeconds == nil {
+		return nil
+	}
+	d := c.CreatedAt.Add(time.Duration(*c.TimeoutSeconds) * time.Second)
+	return &d
+}
+
+// DefaultFanoutInterval matches osquery's default distributed interval, so
+// a fan-out limit of N releases the query to about N hosts per poll.
+const DefaultFanoutInterval = 30 * time.Second
+
+// ErrInvalidCampaignOptions is returned for negative limits or an interval
+// or timeout under a second.
+var ErrInvalidCampaignOptions = errors.New("invalid campaign options")
+
+// CampaignOptions tunes how a new campaign is delivered. The zero value
+// releases the query to every target on its next poll, stores whatever each
+// host returns, and waits for hosts only as long as the stale-target job
+// allows.
+type CampaignOptions struct {
+	// FanoutLimit throttles the campaign to this many hosts per
+	// FanoutInterval. Zero means unthrottled.
+	FanoutLimit int
+	// FanoutInterval defaults to DefaultFanoutInterval.
+	FanoutInterval time.Duration
+
+	// MaxRowsPerHost and MaxBytesPerHost cap each host's stored results.
+	// Zero means no cap.
+	MaxRowsPerHost  int
+	MaxBytesPerHost int64
+	// Timeout fails targets still outstanding this long after the campaign
+	// is created. Zero means no deadline.
+	Timeout time.Duration
+}
+
+// Validate checks the options and fills in defaults.
+func (o *CampaignOptions) Validate() error {
+	if o.FanoutLimit < 0 {
+		return fmt.Errorf("%w: fan-out limit must not be negative", ErrInvalidCampaignOptions)
+	}
+	if o.FanoutInterval == 0 {
+		o.FanoutInterval = DefaultFanoutInterval
+	}
+	if o.FanoutInterval < time.Second {
+		return fmt.Errorf("%w: fan-out interval must be at least 1s", ErrInvalidCampaignOptions)
+	}
+	if o.MaxRowsPerHost < 0 || o.MaxBytesPerHost < 0 {
+		return fmt.Errorf("%w: result caps must not be negative", ErrInvalidCampaignOptions)
+	}
+	if o.Timeout != 0 && o.Timeout < time.Second {
+		return fmt.Errorf("%w: timeout must be at least 1s", ErrInvalidCampaignOptions)
+	}
+	return nil
+}
+
+// nonZero maps an option's zero value to NULL.
+func nonZero[T int | int64](v T) *T {
+	if v == 0 {
+		return nil
+	}
+	return &v
+}
+
+// fanoutLimit is the value stored in campaigns.fanout_limit.
+func (o CampaignOptions) fanoutLimit() *int {
+	return nonZero(o.FanoutLimit)
+}
+
+func (o CampaignOptions) timeoutSeconds() *int {
+	return nonZero(int(o.Timeout / time.Second))
+}
+
+func (o CampaignOptions) fanoutIntervalSeconds() int {
+	return int(o.FanoutInterval / time.Second)
 }
 
 type CampaignTarget struct {
@@ -35,13 +134,22 @@ type CampaignTarget struct {
 	Results        json.RawMessage `json:"results,omitempty"`
 	Error          *string         `json:"error,omitempty"`
 	UpdatedAt      time.Time       `json:"updated_at"`
+	// Truncated is set when Results were cut to the campaign's caps.
+	Truncated bool `json:"truncated,omitempty"`
+	// QuarantineID is set when the host was quarantined as it returned the
+	// results.
+	QuarantineID *uuid.UUID `json:"quarantine_id,omitempty"`
+
+	// Diff is set on re-runs when the host completed the previous campaign.
+	Diff *ResultDiff `json:"diff,omitempty"`
 }
 
 func (r *HostRepository) GetCampaignByIDAndOrganization(ctx context.Context, campaignID uuid.UUID, organizationID uuid.UUID) (*Campaign, error) {
 	var c Campaign
 
 	err := r.pool.QueryRow(ctx, `
-		SELECT id, organization_id, name, description, query, created_by, created_at, updated_at, status, target_count, result_count
+		SELECT id, organization_id, name, description, query, created_by, created_at, updated_at, status, target_count, result_count, previous_campaign_id,
+			fanout_limit, fanout_interval_seconds, max_rows_per_host, max_bytes_per_host, timeout_seconds, archived_at
 		FROM campaigns
 		WHERE id = $1 AND organization_id = $2
 	`, campaignID, organizationID).Scan(
@@ -56,6 +164,13 @@ func (r *HostRepository) GetCampaignByIDAndOrganization(ctx context.Context, cam
 		&c.Status,
 		&c.TargetCount,
 		&c.ResultCount,
+		&c.PreviousCampaignID,
+		&c.FanoutLimit,
+		&c.FanoutIntervalSeconds,
+		&c.MaxRowsPerHost,
+		&c.MaxBytesPerHost,
+		&c.TimeoutSeconds,
+		&c.ArchivedAt,
 	)
 	if err != nil {
 		if errors.Is(err, pgx.ErrNoRows) {
@@ -67,18 +182,21 @@ func (r *HostRepository) GetCampaignByIDAndOrganization(ctx context.Context, cam
 	return &c, nil
 }
 
-func (r *HostRepository) ListCampaignsByOrganization(ctx context.Context, organizationID uuid.UUID, limit int) ([]*Campaign, error) {
+// ListCampaignsByOrganization returns the organization's latest campaigns,
+// newest first: the archived ones if archived is set, otherwise the rest.
+func (r *HostRepository) ListCampaignsByOrganization(ctx context.Context, organizationID uuid.UUID, archived bool, limit int) ([]*Campaign, error) {
 	if limit <= 0 {
 		limit = 50
 	}
 
 	rows, err := r.pool.Query(ctx, `
-		SELECT id, organization_id, name, description, query, created_by, created_at, updated_at, status, target_count, result_count
+		SELECT id, organization_id, name, description, query, created_by, created_at, updated_at, status, target_count, result_count, previous_campaign_id,
+			fanout_limit, fanout_interval_seconds, max_rows_per_host, max_bytes_per_host, timeout_seconds, archived_at
 		FROM campaigns
-		WHERE organization_id = $1
+		WHERE organization_id = $1 AND (archived_at IS NOT NULL) = $2
 		ORDER BY created_at DESC
-		LIMIT $2
-	`, organizationID, limit)
+		LIMIT $3
+	`, organizationID, archived, limit)
 	if err != nil {
 		return nil, fmt.Errorf("listing campaigns: %w", err)
 	}
@@ -99,6 +217,13 @@ func (r *HostRepository) ListCampaignsByOrganization(ctx context.Context, organi
 			&c.Status,
 			&c.TargetCount,
 			&c.ResultCount,
+			&c.PreviousCampaignID,
+			&c.FanoutLimit,
+			&c.FanoutIntervalSeconds,
+			&c.MaxRowsPerHost,
+			&c.MaxBytesPerHost,
+			&c.TimeoutSeconds,
+			&c.ArchivedAt,
 		); err != nil {
 			return nil, fmt.Errorf("scanning campaign: %w", err)
 		}
@@ -111,9 +236,75 @@ func (r *HostRepository) ListCampaignsByOrganization(ctx context.Context, organi
 	return campaigns, nil
 }
 
+// SetCampaignArchived archives or unarchives the campaign. Archiving an
+// archived campaign keeps its original archived_at. It returns false if the
+// campaign doesn't exist in the organization.
+func (r *HostRepository) SetCampaignArchived(ctx context.Context, campaignID uuid.UUID, organizationID uuid.UUID, archived bool) (bool, error) {
+	cmd, err := r.pool.Exec(ctx, `
+		UPDATE campaigns
+		SET archived_at = CASE WHEN $3 THEN COALESCE(archived_at, NOW()) END
+		WHERE id = $1 AND organization_id = $2
+	`, campaignID, organizationID, archived)
+	if err != nil {
+		return false, fmt.Errorf("archiving campaign: %w", err)
+	}
+	return cmd.RowsAffected() == 1, nil
+}
+
+// ArchiveOldCampaigns archives finished campaigns created longer ago than
+// their organization's campaign_archive_days at now, and returns how many it
+// archived. Organizations without the setting archive by hand only.
+func (r *HostRepository) ArchiveOldCampaigns(ctx context.Context, now time.Time) (int, error) {
+	cmd, err := r.pool.Exec(ctx, `
+		UPDATE campaigns c
+		SET archived_at = $1
+		FROM organization_settings s
+		WHERE s.organization_id = c.organization_id
+			AND s.campaign_archive_days IS NOT NULL
+			AND c.archived_at IS NULL
+			AND c.status IN ('completed', 'failed')
+			AND c.created_at < $1 - make_interval(days => s.campaign_archive_days)
+	`, now)
+	if err != nil {
+		return 0, fmt.Errorf("archiving old campaigns: %w", err)
+	}
+	return int(cmd.RowsAffected()), nil
+}
+
+// GetResultLimits returns the result caps of those campaigns that have any,
+// keyed by campaign ID.
+func (r *HostRepository) GetResultLimits(ctx context.Context, campaignIDs []uuid.UUID) (map[uuid.UUID]ResultLimits, error) {
+	rows, err := r.pool.Query(ctx, `
+		SELECT id, COALESCE(max_rows_per_host, 0), COALESCE(max_bytes_per_host, 0)
+		FROM campaigns
+		WHERE id = ANY($1)
+			AND (max_rows_per_host IS NOT NULL OR max_bytes_per_host IS NOT NULL)
+	`, campaignIDs)
+	if err != nil {
+		return nil, fmt.Errorf("getting result limits: %w", err)
+	}
+	defer rows.Close()
+
+	limits := make(map[uuid.UUID]ResultLimits)
+	for rows.Next() {
+		var (
+			id uuid.UUID
+			l  ResultLimits
+		)
+		if err := rows.Scan(&id, &l.MaxRows, &l.MaxBytes); err != nil {
+			return nil, fmt.Errorf("scanning result limits: %w", err)
+		}
+		limits[id] = l
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("getting result limits: %w", err)
+	}
+	return limits, nil
+}
+
 func (r *HostRepository) GetCampaignTargets(ctx context.Context, campaignID uuid.UUID) ([]*CampaignTarget, error) {
 	rows, err := r.pool.Query(ctx, `
-		SELECT t.campaign_id, t.host_id, h.host_identifier, t.status, t.sent_at, t.completed_at, t.results, t.error, t.updated_at
+		SELECT t.campaign_id, t.host_id, h.host_identifier, t.status, t.sent_at, t.completed_at, t.results, t.error, t.updated_at, t.diff, t.truncated, t.quarantine_id
 		FROM campaign_targets t
 		JOIN hosts h ON h.id = t.host_id
 		WHERE t.campaign_id = $1
@@ -137,6 +328,9 @@ func (r *HostRepository) GetCampaignTargets(ctx context.Context, campaignID uuid
 			&t.Results,
 			&t.Error,
 			&t.UpdatedAt,
+			&t.Diff,
+			&t.Truncated,
+			&t.QuarantineID,
 		); err != nil {
 			return nil, fmt.Errorf("scanning campaign target: %w", err)
 		}
@@ -148,3 +342,221 @@ func (r *HostRepository) GetCampaignTargets(ctx context.Context, campaignID uuid
 
 	return targets, nil
 }
+
+// RerunCampaign queues the campaign's query again against the same hosts, as
+// a new campaign linked to the original so results can be diffed, with the
+// same fan-out throttling, result caps, and timeout. Hosts that have since been removed are dropped. It returns uuid.Nil if the campaign
+// doesn't exist in the organization.
+func (r *HostRepository) RerunCampaign(ctx context.Context, campaignID uuid.UUID, organizationID uuid.UUID, createdBy *int) (uuid.UUID, error) {
+	tx, err := r.pool.Begin(ctx)
+	if err != nil {
+		return uuid.Nil, fmt.Errorf("rerunning campaign: begin transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	var newID uuid.UUID
+	err = tx.QueryRow(ctx, `
+		INSERT INTO campaigns (organization_id, name, description, query, created_by, status, target_count, previous_campaign_id,
+			fanout_limit, fanout_interval_seconds, max_rows_per_host, max_bytes_per_host, timeout_seconds)
+		SELECT c.organization_id, c.name, c.description, c.query, $3, 'pending',
+			(SELECT COUNT(*) FROM campaign_targets WHERE campaign_id = c.id), c.id,
+			c.fanout_limit, c.fanout_interval_seconds, c.max_rows_per_host, c.max_bytes_per_host, c.timeout_seconds
+		FROM campaigns c
+		WHERE c.id = $1 AND c.organization_id = $2
+		RETURNING id
+	`, campaignID, organizationID, createdBy).Scan(&newID)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return uuid.Nil, nil
+		}
+		return uuid.Nil, fmt.Errorf("rerunning campaign: %w", err)
+	}
+
+	cmd, err := tx.Exec(ctx, `
+		INSERT INTO campaign_targets (campaign_id, host_id)
+		SELECT $1, host_id FROM campaign_targets WHERE campaign_id = $2
+	`, newID, campaignID)
+	if err != nil {
+		return uuid.Nil, fmt.Errorf("rerunning campaign: inserting targets: %w", err)
+	}
+	if cmd.RowsAffected() == 0 {
+		return uuid.Nil, fmt.Errorf("rerunning campaign: no target hosts")
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return uuid.Nil, fmt.Errorf("rerunning campaign: commit transaction: %w", err)
+	}
+	return newID, nil
+}
+
+// storeResultDiff diffs a re-run target's results against the same host's
+// completed results in the previous campaign. Without such a baseline the
+// diff stays NULL.
+func storeResultDiff(ctx context.Context, tx pgx.Tx, campaignID, hostID uuid.UUID, results json.RawMessage) error {
+	var previous json.RawMessage
+	err := tx.QueryRow(ctx, `
+		SELECT p.results
+		FROM campaigns c
+		JOIN campaign_targets p ON p.campaign_id = c.previous_campaign_id AND p.host_id = $2
+		WHERE c.id = $1 AND p.status = 'completed'
+	`, campaignID, hostID).Scan(&previous)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil
+		}
+		return fmt.Errorf("loading previous results: %w", err)
+	}
+
+	diff, err := DiffResults(previous, results)
+	if err != nil {
+		return err
+	}
+	if _, err := tx.Exec(ctx, `
+		UPDATE campaign_targets SET diff = $3 WHERE campaign_id = $1 AND host_id = $2
+	`, campaignID, hostID, diff); err != nil {
+		return fmt.Errorf("storing result diff: %w", err)
+	}
+	return nil
+}
+
+// ExpiredTarget is a campaign target that was failed because its host never
+// returned results.
+type ExpiredTarget struct {
+	CampaignID     uuid.UUID
+	HostID         uuid.UUID
+	HostIdentifier string
+	// Error is the text recorded on the target.
+	Error string
+}
+
+// ErrTargetTimedOut is the error text recorded on expired campaign targets.
+const ErrTargetTimedOut = "timed out waiting for host results"
+
+// ErrCampaignDeadlineExceeded is the error text recorded on targets still
+// outstanding when their campaign's timeout passes.
+const ErrCampaignDeadlineExceeded = "campaign timeout exceeded"
+
+// FailStaleSentTargets marks targets that were sent before sentBefore but
+// never completed as failed, and updates the status of affected campaigns.
+func (r *HostRepository) FailStaleSentTargets(ctx context.Context, sentBefore time.Time) ([]ExpiredTarget, error) {
+	expired, err := r.failTargets(ctx, `
+		UPDATE campaign_targets
+		SET status = 'failed',
+			error = $1,
+			completed_at = NOW(),
+			updated_at = NOW()
+		WHERE status = 'sent' AND sent_at < $2
+		RETURNING campaign_id, host_id, error
+	`, ErrTargetTimedOut, sentBefore)
+	if err != nil {
+		return nil, fmt.Errorf("failing stale targets: %w", err)
+	}
+	return expired, nil
+}
+
+// FailTimedOutCampaignTargets marks the pending and sent targets of open
+// campaigns past their timeout as failed, and updates those campaigns'
+// status.
+func (r *HostRepository) FailTimedOutCampaignTargets(ctx context.Context) ([]ExpiredTarget, error) {
+	expired, err := r.failTargets(ctx, `
+		UPDATE campaign_targets t
+		SET status = 'failed',
+			error = $1,
+			completed_at = NOW(),
+			updated_at = NOW()
+		FROM campaigns c
+		WHERE t.campaign_id = c.id
+			AND c.timeout_seconds IS NOT NULL
+			AND c.status IN ('pending', 'running')
+			AND c.created_at + make_interval(secs => c.timeout_seconds) < NOW()
+			AND t.status IN ('pending', 'sent')
+		RETURNING t.campaign_id, t.host_id, t.error
+	`, ErrCampaignDeadlineExceeded)
+	if err != nil {
+		return nil, fmt.Errorf("failing timed out campaign targets: %w", err)
+	}
+	return expired, nil
+}
+
+// failTargets runs update, which must fail targets and return their
+// campaign_id, host_id, and error, then refreshes the affected campaigns.
+func (r *HostRepository) failTargets(ctx context.Context, update string, args ...any) ([]ExpiredTarget, error) {
+	tx, err := r.pool.Begin(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("begin transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	rows, err := tx.Query(ctx, `
+		WITH expired AS (`+update+`)
+		SELECT e.campaign_id, e.host_id, h.host_identifier, e.error
+		FROM expired e
+		JOIN hosts h ON h.id = e.host_id
+	`, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	var expired []ExpiredTarget
+	seen := make(map[uuid.UUID]struct{})
+	var campaignIDs []uuid.UUID
+	for rows.Next() {
+		var t ExpiredTarget
+		if err := rows.Scan(&t.CampaignID, &t.HostID, &t.HostIdentifier, &t.Error); err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("scanning target: %w", err)
+		}
+		expired = append(expired, t)
+		if _, ok := seen[t.CampaignID]; !ok {
+			seen[t.CampaignID] = struct{}{}
+			campaignIDs = append(campaignIDs, t.CampaignID)
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	if len(campaignIDs) > 0 {
+		if err := refreshCampaignStatus(ctx, tx, campaignIDs); err != nil {
+			return nil, fmt.Errorf("updating campaign status: %w", err)
+		}
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return nil, fmt.Errorf("commit transaction: %w", err)
+	}
+	return expired, nil
+}
+
+// refreshCampaignStatus recomputes result_count and status for the given
+// campaigns from their targets.
+func refreshCampaignStatus(ctx context.Context, tx pgx.Tx, campaignIDs []uuid.UUID) error {
+	_, err := tx.Exec(ctx, `
+		UPDATE campaigns c
+		SET result_count = (
+				SELECT COUNT(*)
+				FROM campaign_targets
+				WHERE campaign_id = c.id
+					AND status IN ('completed', 'failed')
+			),
+			status = CASE
+				WHEN EXISTS(
+					SELECT 1
+					FROM campaign_targets
+					WHERE campaign_id = c.id
+						AND status IN ('pending', 'sent')
+				) THEN 'running'
+				WHEN EXISTS(
+					SELECT 1
+					FROM campaign_targets
+					WHERE campaign_id = c.id
+						AND status = 'failed'
+				) THEN 'failed'
+				ELSE 'completed'
+			END,
+			updated_at = NOW()
+		WHERE c.id = ANY($1)
+	`, campaignIDs)
+	return err
+}
@@ -3,10 +3,17 @@ package services_test
 import (
 	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/cavenine/queryops/features/osquery/services"
+	"github.com/cavenine/queryops/internal/outbox"
+	"github.com/cavenine/queryops/internal/pubsub"
 	"github.com/cavenine/queryops/internal/testdb"
+	"github.com/cavenine/queryops/internal/testdb/fixtures"
 	"github.com/google/uuid"
 )
 
@@ -14,32 +21,10 @@ func TestCampaignRepository_Flow(t *testing.T) {
 	tdb := testdb.SetupTestDB(t)
 	ctx := context.Background()
 
-	var userID int
-	if err := tdb.Pool.QueryRow(ctx, `INSERT INTO users (email, password_hash) VALUES ($1, $2) RETURNING id`, "campaign@example.com", "x").Scan(&userID); err != nil {
-		t.Fatalf("creating user: %v", err)
-	}
-
-	var orgID uuid.UUID
-	if err := tdb.Pool.QueryRow(ctx, `INSERT INTO organizations (name) VALUES ($1) RETURNING id`, "campaign-org").Scan(&orgID); err != nil {
-		t.Fatalf("creating org: %v", err)
-	}
-
-	insertHost := func(hostIdentifier string) uuid.UUID {
-		t.Helper()
-		var hostID uuid.UUID
-		err := tdb.Pool.QueryRow(ctx, `
-			INSERT INTO hosts (organization_id, host_identifier, node_key)
-			VALUES ($1, $2, $3)
-			RETURNING id
-		`, orgID, hostIdentifier, uuid.NewString()).Scan(&hostID)
-		if err != nil {
-			t.Fatalf("creating host %q: %v", hostIdentifier, err)
-		}
-		return hostID
-	}
-
-	hostA := insertHost("host-a")
-	hostB := insertHost("host-b")
+	userID := fixtures.CreateUser(t, tdb.Pool, "campaign@example.com").ID
+	orgID := fixtures.CreateOrg(t, tdb.Pool, "campaign-org").ID
+	hostA := fixtures.CreateHost(t, tdb.Pool, orgID, "host-a").ID
+	hostB := fixtures.CreateHost(t, tdb.Pool, orgID, "host-b").ID
 
 	repo := services.NewHostRepository(tdb.Pool)
 
@@ -47,7 +32,7 @@ func TestCampaignRepository_Flow(t *testing.T) {
 	description := "Runs a query on hosts"
 	createdBy := userID
 
-	campaignID, err := repo.QueueQuery(ctx, orgID, &createdBy, &name, &description, "select 1", []uuid.UUID{hostA, hostB})
+	campaignID, err := repo.QueueQuery(ctx, orgID, &createdBy, &name, &description, "select 1", []uuid.UUID{hostA, hostB}, services.CampaignOptions{})
 	if err != nil {
 		t.Fatalf("QueueQuery: %v", err)
 	}
@@ -86,7 +71,7 @@ func TestCampaignRepository_Flow(t *testing.T) {
 	}
 
 	res := json.RawMessage(`[{"a":"b"}]`)
-	if err := repo.SaveQueryResults(ctx, hostA, campaignID, "completed", res, nil); err != nil {
+	if err := repo.SaveQueryResults(ctx, hostA, campaignID, "completed", res, nil, false); err != nil {
 		t.Fatalf("SaveQueryResults(hostA): %v", err)
 	}
 
@@ -101,7 +86,7 @@ func TestCampaignRepository_Flow(t *testing.T) {
 		t.Fatalf("Status = %q, want running", campaign.Status)
 	}
 
-	if err := repo.SaveQueryResults(ctx, hostB, campaignID, "completed", json.RawMessage(`[]`), nil); err != nil {
+	if err := repo.SaveQueryResults(ctx, hostB, campaignID, "completed", json.RawMessage(`[]`), nil, false); err != nil {
 		t.Fatalf("SaveQueryResults(hostB): %v", err)
 	}
 
@@ -116,7 +101,7 @@ func TestCampaignRepository_Flow(t *testing.T) {
 		t.Fatalf("Status = %q, want completed", campaign.Status)
 	}
 
-	campaigns, err := repo.ListCampaignsByOrganization(ctx, orgID, 10)
+	campaigns, err := repo.ListCampaignsByOrganization(ctx, orgID, false, 10)
 	if err != nil {
 		t.Fatalf("ListCampaignsByOrganization: %v", err)
 	}
@@ -135,3 +120,549 @@ func TestCampaignRepository_Flow(t *testing.T) {
 		t.Fatalf("targets = %d, want 2", len(targets))
 	}
 }
+
+func BenchmarkQueueQuery(b *testing.B) {
+	tdb := testdb.SetupTestDB(b)
+	ctx := context.Background()
+
+	orgID := fixtures.CreateOrg(b, tdb.Pool, "bench-org").ID
+
+	for _, n := range []int{10, 500, 5000} {
+		hostIDs := createBenchHosts(b, tdb, orgID, n)
+		repo := services.NewHostRepository(tdb.Pool)
+
+		b.Run(fmt.Sprintf("hosts=%d", n), func(b *testing.B) {
+			for b.Loop() {
+				if _, err := repo.QueueQuery(ctx, orgID, nil, nil, nil, "select 1", hostIDs, services.CampaignOptions{}); err != nil {
+					b.Fatalf("QueueQuery: %v", err)
+				}
+			}
+		})
+	}
+}
+
+// BenchmarkGetPendingQueries measures a distributed read sweep: every host
+// polls at once, pollers concurrent, for a campaign queued to all of them.
+// Throttled campaigns take the row lock that serializes polling hosts.
+func BenchmarkGetPendingQueries(b *testing.B) {
+	tdb := testdb.SetupTestDB(b)
+	ctx := context.Background()
+
+	orgID := fixtures.CreateOrg(b, tdb.Pool, "bench-org").ID
+	repo := services.NewHostRepository(tdb.Pool)
+
+	for _, n := range []int{1000, 5000} {
+		hostIDs := createBenchHosts(b, tdb, orgID, n)
+
+		for _, opts := range []struct {
+			name string
+			opts services.CampaignOptions
+		}{
+			{"unthrottled", services.CampaignOptions{}},
+			{"throttled", services.CampaignOptions{FanoutLimit: n}},
+		} {
+			b.Run(fmt.Sprintf("hosts=%d/%s", n, opts.name), func(b *testing.B) {
+				for b.Loop() {
+					b.StopTimer()
+					if _, err := repo.QueueQuery(ctx, orgID, nil, nil, nil, "select 1", hostIDs, opts.opts); err != nil {
+						b.Fatalf("QueueQuery: %v", err)
+					}
+					b.StartTimer()
+
+					pollAll(b, repo, hostIDs, benchPollers)
+				}
+				b.ReportMetric(float64(n)*float64(b.N)/b.Elapsed().Seconds(), "polls/s")
+			})
+		}
+	}
+}
+
+// benchPollers is how many hosts poll at the same moment, about what a fleet
+// of thousands checking in every few seconds keeps in flight.
+const benchPollers = 64
+
+// pollAll runs GetPendingQueries for every host with the given number of
+// concurrent pollers, checking each receives exactly one query.
+func pollAll(b *testing.B, repo *services.HostRepository, hostIDs []uuid.UUID, pollers int) {
+	b.Helper()
+
+	ctx := context.Background()
+	next := make(chan uuid.UUID)
+	errs := make(chan error, pollers)
+	var wg sync.WaitGroup
+	for range pollers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for id := range next {
+				queries, err := repo.GetPendingQueries(ctx, id)
+				if err == nil && len(queries) != 1 {
+					err = fmt.Errorf("host %s got %d queries, want 1", id, len(queries))
+				}
+				if err != nil {
+					errs <- err
+					for range next {
+					}
+					return
+				}
+			}
+		}()
+	}
+	for _, id := range hostIDs {
+		next <- id
+	}
+	close(next)
+	wg.Wait()
+	close(errs)
+	if err := <-errs; err != nil {
+		b.Fatalf("GetPendingQueries: %v", err)
+	}
+}
+
+// createBenchHosts inserts n hosts in one statement; fixtures.CreateHost is
+// too slow for thousands.
+func createBenchHosts(b *testing.B, tdb *testdb.TestDB, orgID uuid.UUID, n int) []uuid.UUID {
+	b.Helper()
+
+	rows, err := tdb.Pool.Query(context.Background(), `
+		INSERT INTO hosts (organization_id, host_identifier, node_key_hash)
+		SELECT $1, 'bench-' || $2::int || '-' || g, sha256(convert_to(gen_random_uuid()::text, 'UTF8'))
+		FROM generate_series(1, $2::int) AS g
+		RETURNING id
+	`, orgID, n)
+	if err != nil {
+		b.Fatalf("creating %d hosts: %v", n, err)
+	}
+	hostIDs := make([]uuid.UUID, 0, n)
+	for rows.Next() {
+		var id uuid.UUID
+		if err := rows.Scan(&id); err != nil {
+			b.Fatalf("scanning host id: %v", err)
+		}
+		hostIDs = append(hostIDs, id)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		b.Fatalf("reading host ids: %v", err)
+	}
+	return hostIDs
+}
+
+func TestFailStaleSentTargets(t *testing.T) {
+	tdb := testdb.SetupTestDB(t)
+	ctx := context.Background()
+
+	orgID := fixtures.CreateOrg(t, tdb.Pool, "stale-org").ID
+	hostID := fixtures.CreateHost(t, tdb.Pool, orgID, "stale-host").ID
+
+	repo := services.NewHostRepository(tdb.Pool)
+
+	campaignID, err := repo.QueueQuery(ctx, orgID, nil, nil, nil, "select 1", []uuid.UUID{hostID}, services.CampaignOptions{})
+	if err != nil {
+		t.Fatalf("QueueQuery: %v", err)
+	}
+	if _, err := repo.GetPendingQueries(ctx, hostID); err != nil {
+		t.Fatalf("GetPendingQueries: %v", err)
+	}
+
+	expired, err := repo.FailStaleSentTargets(ctx, time.Now().Add(-time.Hour))
+	if err != nil {
+		t.Fatalf("FailStaleSentTargets: %v", err)
+	}
+	if len(expired) != 0 {
+		t.Fatalf("expired = %d before timeout, want 0", len(expired))
+	}
+
+	if _, err := tdb.Pool.Exec(ctx, `UPDATE campaign_targets SET sent_at = NOW() - INTERVAL '2 hours' WHERE campaign_id = $1`, campaignID); err != nil {
+		t.Fatalf("backdating sent_at: %v", err)
+	}
+
+	expired, err = repo.FailStaleSentTargets(ctx, time.Now().Add(-time.Hour))
+	if err != nil {
+		t.Fatalf("FailStaleSentTargets: %v", err)
+	}
+	if len(expired) != 1 || expired[0].CampaignID != campaignID || expired[0].HostIdentifier != "stale-host" {
+		t.Fatalf("expired = %+v", expired)
+	}
+
+	campaign, err := repo.GetCampaignByIDAndOrganization(ctx, campaignID, orgID)
+	if err != nil {
+		t.Fatalf("GetCampaignByIDAndOrganization: %v", err)
+	}
+	if campaign.Status != "failed" {
+		t.Fatalf("Status = %q, want failed", campaign.Status)
+	}
+	if campaign.ResultCount != 1 {
+		t.Fatalf("ResultCount = %d, want 1", campaign.ResultCount)
+	}
+}
+
+func TestGetPendingQueries_FanoutLimit(t *testing.T) {
+	tdb := testdb.SetupTestDB(t)
+	ctx := context.Background()
+
+	orgID := fixtures.CreateOrg(t, tdb.Pool, "fanout-org").ID
+	hosts := make([]uuid.UUID, 3)
+	for i := range hosts {
+		hosts[i] = fixtures.CreateHost(t, tdb.Pool, orgID, fmt.Sprintf("fanout-host-%d", i)).ID
+	}
+
+	repo := services.NewHostRepository(tdb.Pool)
+
+	campaignID, err := repo.QueueQuery(ctx, orgID, nil, nil, nil, "select 1", hosts, services.CampaignOptions{FanoutLimit: 2})
+	if err != nil {
+		t.Fatalf("QueueQuery: %v", err)
+	}
+
+	campaign, err := repo.GetCampaignByIDAndOrganization(ctx, campaignID, orgID)
+	if err != nil {
+		t.Fatalf("GetCampaignByIDAndOrganization: %v", err)
+	}
+	if campaign.FanoutLimit == nil || *campaign.FanoutLimit != 2 || campaign.FanoutIntervalSeconds != 30 {
+		t.Fatalf("fanout = %v/%ds, want 2/30s", campaign.FanoutLimit, campaign.FanoutIntervalSeconds)
+	}
+
+	released := 0
+	for _, host := range hosts {
+		pending, err := repo.GetPendingQueries(ctx, host)
+		if err != nil {
+			t.Fatalf("GetPendingQueries: %v", err)
+		}
+		released += len(pending)
+	}
+	if released != 2 {
+		t.Fatalf("released to %d hosts, want 2", released)
+	}
+
+	// Once the interval has passed, the withheld host gets the query.
+	if _, err := tdb.Pool.Exec(ctx, `UPDATE campaign_targets SET sent_at = NOW() - INTERVAL '1 minute' WHERE campaign_id = $1 AND sent_at IS NOT NULL`, campaignID); err != nil {
+		t.Fatalf("backdating sent_at: %v", err)
+	}
+	pending, err := repo.GetPendingQueries(ctx, hosts[2])
+	if err != nil {
+		t.Fatalf("GetPendingQueries: %v", err)
+	}
+	if pending[campaignID.String()] != "select 1" {
+		t.Fatalf("pending = %v, want the throttled query", pending)
+	}
+
+	if _, err := repo.QueueQuery(ctx, orgID, nil, nil, nil, "select 1", hosts, services.CampaignOptions{FanoutLimit: -1}); !errors.Is(err, services.ErrInvalidCampaignOptions) {
+		t.Fatalf("QueueQuery(negative limit) = %v, want ErrInvalidCampaignOptions", err)
+	}
+}
+
+func TestCampaignRepository_ResultLimitsAndTimeout(t *testing.T) {
+	tdb := testdb.SetupTestDB(t)
+	ctx := context.Background()
+
+	orgID := fixtures.CreateOrg(t, tdb.Pool, "limits-org").ID
+	hostA := fixtures.CreateHost(t, tdb.Pool, orgID, "limits-a").ID
+	hostB := fixtures.CreateHost(t, tdb.Pool, orgID, "limits-b").ID
+
+	repo := services.NewHostRepository(tdb.Pool)
+
+	capped, err := repo.QueueQuery(ctx, orgID, nil, nil, nil, "select 1", []uuid.UUID{hostA, hostB}, services.CampaignOptions{
+		MaxRowsPerHost:  10,
+		MaxBytesPerHost: 4096,
+		Timeout:         time.Hour,
+	})
+	if err != nil {
+		t.Fatalf("QueueQuery: %v", err)
+	}
+	uncapped, err := repo.QueueQuery(ctx, orgID, nil, nil, nil, "select 1", []uuid.UUID{hostA}, services.CampaignOptions{})
+	if err != nil {
+		t.Fatalf("QueueQuery: %v", err)
+	}
+
+	limits, err := repo.GetResultLimits(ctx, []uuid.UUID{capped, uncapped})
+	if err != nil {
+		t.Fatalf("GetResultLimits: %v", err)
+	}
+	if len(limits) != 1 || limits[capped] != (services.ResultLimits{MaxRows: 10, MaxBytes: 4096}) {
+		t.Fatalf("limits = %+v", limits)
+	}
+
+	if _, err := repo.GetPendingQueries(ctx, hostA); err != nil {
+		t.Fatalf("GetPendingQueries: %v", err)
+	}
+	if err := repo.SaveQueryResults(ctx, hostA, capped, "completed", json.RawMessage(`[{"a":"1"}]`), nil, true); err != nil {
+		t.Fatalf("SaveQueryResults: %v", err)
+	}
+	targets, err := repo.GetCampaignTargets(ctx, capped)
+	if err != nil {
+		t.Fatalf("GetCampaignTargets: %v", err)
+	}
+	for _, target := range targets {
+		if target.Truncated != (target.HostID == hostA) {
+			t.Fatalf("target %s truncated = %v", target.HostIdentifier, target.Truncated)
+		}
+	}
+
+	// Before the timeout nothing is failed.
+	expired, err := repo.FailTimedOutCampaignTargets(ctx)
+	if err != nil {
+		t.Fatalf("FailTimedOutCampaignTargets: %v", err)
+	}
+	if len(expired) != 0 {
+		t.Fatalf("expired = %+v before timeout", expired)
+	}
+
+	if _, err := tdb.Pool.Exec(ctx, `UPDATE campaigns SET created_at = NOW() - INTERVAL '2 hours' WHERE id = $1`, capped); err != nil {
+		t.Fatalf("backdating campaign: %v", err)
+	}
+
+	// A host polling after the timeout isn't sent the query.
+	pending, err := repo.GetPendingQueries(ctx, hostB)
+	if err != nil {
+		t.Fatalf("GetPendingQueries: %v", err)
+	}
+	if _, ok := pending[capped.String()]; ok {
+		t.Fatalf("query sent after campaign timeout")
+	}
+
+	expired, err = repo.FailTimedOutCampaignTargets(ctx)
+	if err != nil {
+		t.Fatalf("FailTimedOutCampaignTargets: %v", err)
+	}
+	if len(expired) != 1 || expired[0].HostID != hostB || expired[0].Error != services.ErrCampaignDeadlineExceeded {
+		t.Fatalf("expired = %+v, want host B only", expired)
+	}
+
+	campaign, err := repo.GetCampaignByIDAndOrganization(ctx, capped, orgID)
+	if err != nil {
+		t.Fatalf("GetCampaignByIDAndOrganization: %v", err)
+	}
+	if campaign.Status != "failed" || campaign.ResultCount != 2 {
+		t.Fatalf("campaign = %s with %d results, want failed with 2", campaign.Status, campaign.ResultCount)
+	}
+	if campaign.TimeoutSeconds == nil || *campaign.TimeoutSeconds != 3600 {
+		t.Fatalf("TimeoutSeconds = %v, want 3600", campaign.TimeoutSeconds)
+	}
+}
+
+func TestCampaignRepository_RerunDiffsResults(t *testing.T) {
+	tdb := testdb.SetupTestDB(t)
+	ctx := context.Background()
+
+	orgID := fixtures.CreateOrg(t, tdb.Pool, "rerun-org").ID
+	otherOrgID := fixtures.CreateOrg(t, tdb.Pool, "other-org").ID
+	hostA := fixtures.CreateHost(t, tdb.Pool, orgID, "host-a").ID
+	hostB := fixtures.CreateHost(t, tdb.Pool, orgID, "host-b").ID
+
+	repo := services.NewHostRepository(tdb.Pool)
+
+	firstID, err := repo.QueueQuery(ctx, orgID, nil, nil, nil, "select * from users", []uuid.UUID{hostA, hostB}, services.CampaignOptions{})
+	if err != nil {
+		t.Fatalf("QueueQuery: %v", err)
+	}
+	// Only host A answers the first run, so host B has no baseline.
+	if err := repo.SaveQueryResults(ctx, hostA, firstID, "completed", json.RawMessage(`[{"u":"root"},{"u":"alice"}]`), nil, false); err != nil {
+		t.Fatalf("SaveQueryResults(first): %v", err)
+	}
+
+	if id, err := repo.RerunCampaign(ctx, firstID, otherOrgID, nil); err != nil || id != uuid.Nil {
+		t.Fatalf("RerunCampaign(other org) = %s, %v; want nil id", id, err)
+	}
+
+	secondID, err := repo.RerunCampaign(ctx, firstID, orgID, nil)
+	if err != nil {
+		t.Fatalf("RerunCampaign: %v", err)
+	}
+	second, err := repo.GetCampaignByIDAndOrganization(ctx, secondID, orgID)
+	if err != nil {
+		t.Fatalf("GetCampaignByIDAndOrganization: %v", err)
+	}
+	if second.PreviousCampaignID == nil || *second.PreviousCampaignID != firstID || second.TargetCount != 2 || second.Query != "select * from users" {
+		t.Fatalf("re-run campaign = %+v", second)
+	}
+
+	if err := repo.SaveQueryResults(ctx, hostA, secondID, "completed", json.RawMessage(`[{"u":"root"},{"u":"bob"}]`), nil, false); err != nil {
+		t.Fatalf("SaveQueryResults(second, A): %v", err)
+	}
+	if err := repo.SaveQueryResults(ctx, hostB, secondID, "completed", json.RawMessage(`[{"u":"root"}]`), nil, false); err != nil {
+		t.Fatalf("SaveQueryResults(second, B): %v", err)
+	}
+
+	targets, err := repo.GetCampaignTargets(ctx, secondID)
+	if err != nil {
+		t.Fatalf("GetCampaignTargets: %v", err)
+	}
+	if len(targets) != 2 {
+		t.Fatalf("targets = %d, want 2", len(targets))
+	}
+	diffA := targets[0].Diff
+	if diffA == nil || len(diffA.Added) != 1 || diffA.Added[0]["u"] != "bob" || len(diffA.Removed) != 1 || diffA.Removed[0]["u"] != "alice" {
+		t.Fatalf("host-a diff = %+v", diffA)
+	}
+	if targets[1].Diff != nil {
+		t.Fatalf("host-b diff = %+v, want nil without a baseline", targets[1].Diff)
+	}
+}
+
+func TestSaveQueryResults_RetriedWrite(t *testing.T) {
+	tdb := testdb.SetupTestDB(t)
+	ctx := context.Background()
+
+	orgID := fixtures.CreateOrg(t, tdb.Pool, "retry-org").ID
+	hostA := fixtures.CreateHost(t, tdb.Pool, orgID, "host-a").ID
+	hostB := fixtures.CreateHost(t, tdb.Pool, orgID, "host-b").ID
+
+	repo := services.NewHostRepository(tdb.Pool)
+
+	campaignID, err := repo.QueueQuery(ctx, orgID, nil, nil, nil, "select 1", []uuid.UUID{hostA, hostB}, services.CampaignOptions{})
+	if err != nil {
+		t.Fatalf("QueueQuery: %v", err)
+	}
+
+	event := func() outbox.Event {
+		e := pubsub.CampaignResultEvent{CampaignID: campaignID, HostID: hostA, Status: "completed"}
+		return outbox.Event{Topic: pubsub.TopicCampaign(campaignID), Message: e.ToMessage()}
+	}
+
+	if err := repo.SaveQueryResults(ctx, hostA, campaignID, "completed", json.RawMessage(`[{"a":"1"}]`), nil, false, event()); err != nil {
+		t.Fatalf("SaveQueryResults: %v", err)
+	}
+	// osquery retries a post whose response was lost, possibly with a
+	// different outcome; the first result stands.
+	failed := "osquery status 1"
+	for _, status := range []string{"completed", "failed"} {
+		err := repo.SaveQueryResults(ctx, hostA, campaignID, status, json.RawMessage(`[{"a":"2"}]`), &failed, false, event())
+		if !errors.Is(err, services.ErrQueryResultsAlreadySaved) {
+			t.Fatalf("SaveQueryResults(retry %s) = %v, want ErrQueryResultsAlreadySaved", status, err)
+		}
+	}
+
+	targets, err := repo.GetCampaignTargets(ctx, campaignID)
+	if err != nil {
+		t.Fatalf("GetCampaignTargets: %v", err)
+	}
+	for _, target := range targets {
+		if target.HostID == hostA && (target.Status != "completed" || string(target.Results) != `[{"a": "1"}]` || target.Error != nil) {
+			t.Fatalf("target = status %q, results %s, error %v; want the first result", target.Status, target.Results, target.Error)
+		}
+	}
+
+	var events int
+	if err := tdb.Pool.QueryRow(ctx, `SELECT count(*) FROM pubsub_outbox WHERE topic = $1`, pubsub.TopicCampaign(campaignID)).Scan(&events); err != nil {
+		t.Fatalf("counting outbox events: %v", err)
+	}
+	if events != 1 {
+		t.Fatalf("outbox events = %d, want 1", events)
+	}
+
+	campaign, err := repo.GetCampaignByIDAndOrganization(ctx, campaignID, orgID)
+	if err != nil {
+		t.Fatalf("GetCampaignByIDAndOrganization: %v", err)
+	}
+	if campaign.ResultCount != 1 || campaign.Status != "running" {
+		t.Fatalf("campaign = %d results, %q; want 1, running", campaign.ResultCount, campaign.Status)
+	}
+
+	if err := repo.SaveQueryResults(ctx, hostA, uuid.New(), "completed", json.RawMessage(`[]`), nil, false); err == nil || errors.Is(err, services.ErrQueryResultsAlreadySaved) {
+		t.Fatalf("SaveQueryResults(unknown campaign) = %v, want a missing target error", err)
+	}
+}
+
+func TestCampaignRepository_Archive(t *testing.T) {
+	tdb := testdb.SetupTestDB(t)
+	ctx := context.Background()
+
+	orgID := fixtures.CreateOrg(t, tdb.Pool, "archive-org").ID
+	otherOrgID := fixtures.CreateOrg(t, tdb.Pool, "other-org").ID
+	host := fixtures.CreateHost(t, tdb.Pool, orgID, "host-a").ID
+	otherHost := fixtures.CreateHost(t, tdb.Pool, otherOrgID, "host-b").ID
+	if _, err := tdb.Pool.Exec(ctx, `
+		INSERT INTO organization_settings (organization_id, campaign_archive_days) VALUES ($1, 7)
+	`, orgID); err != nil {
+		t.Fatalf("saving archive setting: %v", err)
+	}
+
+	repo := services.NewHostRepository(tdb.Pool)
+	now := time.Now()
+	queue := func(orgID, hostID uuid.UUID, status string, age time.Duration) uuid.UUID {
+		t.Helper()
+		id, err := repo.QueueQuery(ctx, orgID, nil, nil, nil, "select 1", []uuid.UUID{hostID}, services.CampaignOptions{})
+		if err != nil {
+			t.Fatalf("QueueQuery: %v", err)
+		}
+		if _, err := tdb.Pool.Exec(ctx, `
+			UPDATE campaigns SET status = $2, created_at = $3 WHERE id = $1
+		`, id, status, now.Add(-age)); err != nil {
+			t.Fatalf("aging campaign: %v", err)
+		}
+		return id
+	}
+	oldCompleted := queue(orgID, host, "completed", 10*24*time.Hour)
+	oldRunning := queue(orgID, host, "running", 10*24*time.Hour)
+	recent := queue(orgID, host, "completed", time.Hour)
+	otherOld := queue(otherOrgID, otherHost, "completed", 10*24*time.Hour)
+
+	// Another organization can't archive the campaign.
+	found, err := repo.SetCampaignArchived(ctx, recent, otherOrgID, true)
+	if err != nil {
+		t.Fatalf("SetCampaignArchived: %v", err)
+	}
+	if found {
+		t.Fatal("SetCampaignArchived found another organization's campaign")
+	}
+
+	if found, err := repo.SetCampaignArchived(ctx, recent, orgID, true); err != nil || !found {
+		t.Fatalf("SetCampaignArchived = %v, %v; want true", found, err)
+	}
+	listIDs := func(archived bool) []uuid.UUID {
+		t.Helper()
+		campaigns, err := repo.ListCampaignsByOrganization(ctx, orgID, archived, 10)
+		if err != nil {
+			t.Fatalf("ListCampaignsByOrganization: %v", err)
+		}
+		ids := make([]uuid.UUID, 0, len(campaigns))
+		for _, c := range campaigns {
+			ids = append(ids, c.ID)
+		}
+		return ids
+	}
+	if got := listIDs(true); len(got) != 1 || got[0] != recent {
+		t.Fatalf("archived campaigns = %v, want [%s]", got, recent)
+	}
+	if got := listIDs(false); len(got) != 2 {
+		t.Fatalf("active campaigns = %v, want 2", got)
+	}
+
+	if found, err := repo.SetCampaignArchived(ctx, recent, orgID, false); err != nil || !found {
+		t.Fatalf("SetCampaignArchived(unarchive) = %v, %v; want true", found, err)
+	}
+	campaign, err := repo.GetCampaignByIDAndOrganization(ctx, recent, orgID)
+	if err != nil {
+		t.Fatalf("GetCampaignByIDAndOrganization: %v", err)
+	}
+	if campaign.ArchivedAt != nil {
+		t.Fatalf("ArchivedAt = %v after unarchiving, want nil", campaign.ArchivedAt)
+	}
+
+	n, err := repo.ArchiveOldCampaigns(ctx, now)
+	if err != nil {
+		t.Fatalf("ArchiveOldCampaigns: %v", err)
+	}
+	if n != 1 {
+		t.Fatalf("archived %d campaigns, want 1", n)
+	}
+	if got := listIDs(true); len(got) != 1 || got[0] != oldCompleted {
+		t.Fatalf("archived campaigns = %v, want [%s]", got, oldCompleted)
+	}
+	for _, id := range []uuid.UUID{oldRunning, recent} {
+		c, err := repo.GetCampaignByIDAndOrganization(ctx, id, orgID)
+		if err != nil {
+			t.Fatalf("GetCampaignByIDAndOrganization: %v", err)
+		}
+		if c.ArchivedAt != nil {
+			t.Errorf("campaign %s archived, want it kept", id)
+		}
+	}
+	other, err := repo.GetCampaignByIDAndOrganization(ctx, otherOld, otherOrgID)
+	if err != nil {
+		t.Fatalf("GetCampaignByIDAndOrganization: %v", err)
+	}
+	if other.ArchivedAt != nil {
+		t.Error("campaign of an organization without the setting was archived")
+	}
+}
@@ -0,0 +1,216 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+)
+
+// CampaignResultsLimit is how many result rows a campaign's results view
+// shows at once. Larger result sets are narrowed with filters.
+const CampaignResultsLimit = 500
+
+// HostColumn names the host identifier in a CampaignResultView, alongside
+// the columns the query returned.
+const HostColumn = "_host"
+
+// resultArray is a target's results if they're a JSON array, which is all
+// jsonb_array_elements takes.
+const resultArray = "CASE WHEN jsonb_typeof(t.results) = 'array' THEN t.results END"
+
+// CampaignResultView sorts, filters, and picks the columns of a campaign's
+// result rows, flattened across hosts.
+type CampaignResultView struct {
+	// Sort is the column rows are ordered by, numerically when both values
+	// are numbers. Empty orders them by host.
+	Sort string `json:"sort,omitempty"`
+	Desc bool   `json:"desc,omitempty"`
+	// Filters keeps the rows whose column contains the text, ignoring case.
+	Filters map[string]string `json:"filters,omitempty"`
+	// Columns are the columns shown, in order. Empty shows them all.
+	Columns []string `json:"columns,omitempty"`
+}
+
+// ParseCampaignResultView reads a view from query parameters written by
+// CampaignResultView.Values: sort, desc, col for each shown column, and f.
+// followed by the column for each filter.
+func ParseCampaignResultView(q url.Values) CampaignResultView {
+	v := CampaignResultView{
+		Sort:    q.Get("sort"),
+		Desc:    q.Get("desc") == "1",
+		Columns: q["col"],
+	}
+	for key, values := range q {
+		column, ok := strings.CutPrefix(key, "f.")
+		if !ok || column == "" || strings.TrimSpace(values[0]) == "" {
+			continue
+		}
+		if v.Filters == nil {
+			v.Filters = make(map[string]string)
+		}
+		v.Filters[column] = strings.TrimSpace(values[0])
+	}
+	return v
+}
+
+// Values encodes the view as query parameters.
+func (v CampaignResultView) Values() url.Values {
+	q := url.Values{}
+	if v.Sort != "" {
+		q.Set("sort", v.Sort)
+		if v.Desc {
+			q.Set("desc", "1")
+		}
+	}
+	for _, c := range v.Columns {
+		q.Add("col", c)
+	}
+	for column, text := range v.Filters {
+		if text != "" {
+			q.Set("f."+column, text)
+		}
+	}
+	return q
+}
+
+// SortedBy returns the view sorted by column: ascending, or descending if
+// it's already sorted ascending by it.
+func (v CampaignResultView) SortedBy(column string) CampaignResultView {
+	v.Desc = v.Sort == column && !v.Desc
+	v.Sort = column
+	return v
+}
+
+// CampaignResultRow is one row a host returned.
+type CampaignResultRow struct {
+	HostID         uuid.UUID
+	HostIdentifier string
+	// RowKey identifies the row among the host's results, for annotating it.
+	RowKey string
+	Values map[string]string
+}
+
+// CampaignResults are a page of a campaign's result rows under a view.
+type CampaignResults struct {
+	// Columns are every column the campaign's rows have, sorted.
+	Columns []string
+	// Rows are the first CampaignResultsLimit of Total matching rows.
+	Rows  []CampaignResultRow
+	Total int
+	// Annotations are every annotation on the campaign's rows and hosts,
+	// shown or not.
+	Annotations ResultAnnotations
+}
+
+// Shown returns the columns the view shows that the results have, all of
+// them if it picks none.
+func (res *CampaignResults) Shown(view CampaignResultView) []string {
+	if len(view.Columns) == 0 {
+		return res.Columns
+	}
+	var shown []string
+	for _, c := range view.Columns {
+		if slices.Contains(res.Columns, c) {
+			shown = append(shown, c)
+		}
+	}
+	if len(shown) == 0 {
+		return res.Columns
+	}
+	return shown
+}
+
+// QueryCampaignResults returns the campaign's result rows sorted and
+// filtered by view. The work is done in Postgres, so large result sets
+// aren't loaded to show a page of them.
+func (r *HostRepository) QueryCampaignResults(ctx context.Context, campaignID uuid.UUID, view CampaignResultView) (*CampaignResults, error) {
+	res := &CampaignResults{}
+	rows, err := r.pool.Query(ctx, `
+		SELECT DISTINCT k
+		FROM campaign_targets t
+		CROSS JOIN LATERAL jsonb_array_elements(`+resultArray+`) e
+		CROSS JOIN LATERAL jsonb_object_keys(CASE WHEN jsonb_typeof(e) = 'object' THEN e END) k
+		WHERE t.campaign_id = $1
+		ORDER BY k
+	`, campaignID)
+	if err != nil {
+		return nil, fmt.Errorf("listing campaign result columns: %w", err)
+	}
+	res.Columns, err = pgx.CollectRows(rows, pgx.RowTo[string])
+	if err != nil {
+		return nil, fmt.Errorf("listing campaign result columns: %w", err)
+	}
+
+	args := []any{campaignID}
+	arg := func(v any) string {
+		args = append(args, v)
+		return "$" + strconv.Itoa(len(args))
+	}
+	// value is the SQL text of a column in the current row.
+	value := func(column string) string {
+		if column == HostColumn {
+			return "h.host_identifier"
+		}
+		return "(e ->> " + arg(column) + ")"
+	}
+
+	conds := []string{"t.campaign_id = $1", "jsonb_typeof(e) = 'object'"}
+	filtered := make([]string, 0, len(view.Filters))
+	for column := range view.Filters {
+		filtered = append(filtered, column)
+	}
+	slices.Sort(filtered)
+	for _, column := range filtered {
+		text := strings.TrimSpace(view.Filters[column])
+		if text == "" {
+			continue
+		}
+		conds = append(conds, "strpos(lower(COALESCE("+value(column)+", '')), lower("+arg(text)+")) > 0")
+	}
+
+	order := "h.host_identifier, n"
+	if view.Sort != "" {
+		dir := "ASC NULLS FIRST"
+		if view.Desc {
+			dir = "DESC NULLS LAST"
+		}
+		v := value(view.Sort)
+		order = fmt.Sprintf(`CASE WHEN %[1]s ~ '^-?[0-9]+(\.[0-9]+)?$' THEN %[1]s::numeric END %[2]s, %[1]s %[2]s, %[3]s`, v, dir, order)
+	}
+
+	rows, err = r.pool.Query(ctx, `
+		SELECT h.id, h.host_identifier, md5(e::text), (SELECT jsonb_object_agg(key, value) FROM jsonb_each_text(e)), COUNT(*) OVER ()
+		FROM campaign_targets t
+		JOIN hosts h ON h.id = t.host_id
+		CROSS JOIN LATERAL jsonb_array_elements(`+resultArray+`) WITH ORDINALITY AS r(e, n)
+		WHERE `+strings.Join(conds, " AND ")+`
+		ORDER BY `+order+`
+		LIMIT `+arg(CampaignResultsLimit), args...)
+	if err != nil {
+		return nil, fmt.Errorf("querying campaign results: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var row CampaignResultRow
+		if err := rows.Scan(&row.HostID, &row.HostIdentifier, &row.RowKey, &row.Values, &res.Total); err != nil {
+			return nil, fmt.Errorf("scanning campaign result: %w", err)
+		}
+		res.Rows = append(res.Rows, row)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("querying campaign results: %w", err)
+	}
+
+	res.Annotations, err = r.ListResultAnnotations(ctx, campaignID)
+	if err != nil {
+		return nil, err
+	}
+	return res, nil
+}
@@ -0,0 +1,109 @@
+package services_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/url"
+	"slices"
+	"testing"
+
+	"github.com/cavenine/queryops/features/osquery/services"
+	"github.com/cavenine/queryops/internal/testdb"
+	"github.com/cavenine/queryops/internal/testdb/fixtures"
+	"github.com/google/uuid"
+)
+
+func TestHostRepository_QueryCampaignResults(t *testing.T) {
+	tdb := testdb.SetupTestDB(t)
+	ctx := context.Background()
+
+	orgID := fixtures.CreateOrg(t, tdb.Pool, "campaign-results-org").ID
+	hostA := fixtures.CreateHost(t, tdb.Pool, orgID, "host-a").ID
+	hostB := fixtures.CreateHost(t, tdb.Pool, orgID, "host-b").ID
+	repo := services.NewHostRepository(tdb.Pool)
+
+	campaignID, err := repo.QueueQuery(ctx, orgID, nil, nil, nil, "select name, pid from processes", []uuid.UUID{hostA, hostB}, services.CampaignOptions{})
+	if err != nil {
+		t.Fatalf("QueueQuery: %v", err)
+	}
+	save := func(hostID uuid.UUID, rows string) {
+		t.Helper()
+		if err := repo.SaveQueryResults(ctx, hostID, campaignID, "completed", json.RawMessage(rows), nil, false); err != nil {
+			t.Fatalf("SaveQueryResults: %v", err)
+		}
+	}
+	save(hostA, `[{"name":"sshd","pid":"900"},{"name":"nginx","pid":"80"}]`)
+	save(hostB, `[{"name":"SSHD","pid":"1000","user":"root"}]`)
+
+	query := func(view services.CampaignResultView) *services.CampaignResults {
+		t.Helper()
+		res, err := repo.QueryCampaignResults(ctx, campaignID, view)
+		if err != nil {
+			t.Fatalf("QueryCampaignResults(%+v): %v", view, err)
+		}
+		return res
+	}
+	pids := func(res *services.CampaignResults) []string {
+		var got []string
+		for _, row := range res.Rows {
+			got = append(got, row.Values["pid"])
+		}
+		return got
+	}
+
+	res := query(services.CampaignResultView{})
+	if !slices.Equal(res.Columns, []string{"name", "pid", "user"}) || res.Total != 3 {
+		t.Fatalf("results = %+v", res)
+	}
+	// By host, then in the order each host returned its rows.
+	if got := pids(res); !slices.Equal(got, []string{"900", "80", "1000"}) || res.Rows[2].HostIdentifier != "host-b" {
+		t.Fatalf("default order = %v", got)
+	}
+
+	// Numbers sort as numbers, not text.
+	if got := pids(query(services.CampaignResultView{Sort: "pid", Desc: true})); !slices.Equal(got, []string{"1000", "900", "80"}) {
+		t.Fatalf("sorted by pid desc = %v", got)
+	}
+	// Filters ignore case and combine.
+	res = query(services.CampaignResultView{Sort: "pid", Filters: map[string]string{"name": "sshd"}})
+	if got := pids(res); !slices.Equal(got, []string{"900", "1000"}) || res.Total != 2 {
+		t.Fatalf("filtered by name = %v", got)
+	}
+	res = query(services.CampaignResultView{Filters: map[string]string{"name": "sshd", services.HostColumn: "host-b"}})
+	if got := pids(res); !slices.Equal(got, []string{"1000"}) {
+		t.Fatalf("filtered by name and host = %v", got)
+	}
+
+	if shown := res.Shown(services.CampaignResultView{Columns: []string{"pid", "missing"}}); !slices.Equal(shown, []string{"pid"}) {
+		t.Fatalf("Shown = %v", shown)
+	}
+}
+
+func TestCampaignResultView_Values(t *testing.T) {
+	view := services.CampaignResultView{
+		Sort:    "pid",
+		Desc:    true,
+		Filters: map[string]string{"name": "sshd", services.HostColumn: "web"},
+		Columns: []string{"pid", "name"},
+	}
+	got := services.ParseCampaignResultView(view.Values())
+	if got.Sort != "pid" || !got.Desc || !slices.Equal(got.Columns, view.Columns) ||
+		got.Filters["name"] != "sshd" || got.Filters[services.HostColumn] != "web" || len(got.Filters) != 2 {
+		t.Fatalf("round trip = %+v", got)
+	}
+
+	q, _ := url.ParseQuery("f.name=+&f.pid=80&sort=")
+	if got := services.ParseCampaignResultView(q); got.Sort != "" || len(got.Filters) != 1 || got.Filters["pid"] != "80" {
+		t.Fatalf("ParseCampaignResultView = %+v", got)
+	}
+
+	if v := view.SortedBy("pid"); v.Sort != "pid" || v.Desc {
+		t.Fatalf("SortedBy(same column, desc) = %+v", v)
+	}
+	if v := (services.CampaignResultView{Sort: "pid"}).SortedBy("pid"); !v.Desc {
+		t.Fatalf("SortedBy(same column, asc) = %+v", v)
+	}
+	if v := view.SortedBy("name"); v.Sort != "name" || v.Desc {
+		t.Fatalf("SortedBy(other column) = %+v", v)
+	}
+}
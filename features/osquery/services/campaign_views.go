@@ -0,0 +1,115 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+)
+
+// ResultViewNameMaxLength bounds saved result view names.
+const ResultViewNameMaxLength = 100
+
+var (
+	ErrCampaignNotFound      = errors.New("campaign not found")
+	ErrInvalidResultViewName = errors.New("a view name of at most 100 characters is required")
+	ErrResultViewNotFound    = errors.New("result view not found")
+)
+
+// SavedResultView is a named view of a campaign's results.
+type SavedResultView struct {
+	ID             uuid.UUID
+	OrganizationID uuid.UUID
+	CampaignID     uuid.UUID
+	Name           string
+	View           CampaignResultView
+	CreatedBy      *int
+	CreatedAt      time.Time
+}
+
+// SaveResultView saves view of the campaign's results under name. It returns
+// ErrCampaignNotFound if the campaign isn't the organization's.
+func (r *HostRepository) SaveResultView(ctx context.Context, organizationID, campaignID uuid.UUID, createdBy *int, name string, view CampaignResultView) (*SavedResultView, error) {
+	name = strings.TrimSpace(name)
+	if name == "" || utf8.RuneCountInString(name) > ResultViewNameMaxLength {
+		return nil, ErrInvalidResultViewName
+	}
+
+	v := SavedResultView{OrganizationID: organizationID, CampaignID: campaignID, Name: name, View: view, CreatedBy: createdBy}
+	err := r.pool.QueryRow(ctx, `
+		INSERT INTO campaign_result_views (organization_id, campaign_id, name, view, created_by)
+		SELECT c.organization_id, c.id, $3, $4, $5
+		FROM campaigns c
+		WHERE c.id = $2 AND c.organization_id = $1
+		RETURNING id, created_at
+	`, organizationID, campaignID, name, view, createdBy).Scan(&v.ID, &v.CreatedAt)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, ErrCampaignNotFound
+		}
+		return nil, fmt.Errorf("saving result view: %w", err)
+	}
+	return &v, nil
+}
+
+// ListResultViews returns the campaign's saved views, oldest first.
+func (r *HostRepository) ListResultViews(ctx context.Context, campaignID, organizationID uuid.UUID) ([]SavedResultView, error) {
+	rows, err := r.pool.Query(ctx, `
+		SELECT id, organization_id, campaign_id, name, view, created_by, created_at
+		FROM campaign_result_views
+		WHERE campaign_id = $1 AND organization_id = $2
+		ORDER BY created_at, id
+	`, campaignID, organizationID)
+	if err != nil {
+		return nil, fmt.Errorf("listing result views: %w", err)
+	}
+	defer rows.Close()
+
+	var views []SavedResultView
+	for rows.Next() {
+		var v SavedResultView
+		if err := rows.Scan(&v.ID, &v.OrganizationID, &v.CampaignID, &v.Name, &v.View, &v.CreatedBy, &v.CreatedAt); err != nil {
+			return nil, fmt.Errorf("scanning result view: %w", err)
+		}
+		views = append(views, v)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("listing result views: %w", err)
+	}
+	return views, nil
+}
+
+// GetResultView returns the organization's saved view, or
+// ErrResultViewNotFound.
+func (r *HostRepository) GetResultView(ctx context.Context, viewID, organizationID uuid.UUID) (*SavedResultView, error) {
+	var v SavedResultView
+	err := r.pool.QueryRow(ctx, `
+		SELECT id, organization_id, campaign_id, name, view, created_by, created_at
+		FROM campaign_result_views
+		WHERE id = $1 AND organization_id = $2
+	`, viewID, organizationID).Scan(&v.ID, &v.OrganizationID, &v.CampaignID, &v.Name, &v.View, &v.CreatedBy, &v.CreatedAt)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, ErrResultViewNotFound
+		}
+		return nil, fmt.Errorf("getting result view: %w", err)
+	}
+	return &v, nil
+}
+
+// DeleteResultView deletes a saved view of the campaign. Links shared to it
+// stop working. It reports whether the view existed.
+func (r *HostRepository) DeleteResultView(ctx context.Context, viewID, campaignID, organizationID uuid.UUID) (bool, error) {
+	cmd, err := r.pool.Exec(ctx, `
+		DELETE FROM campaign_result_views WHERE id = $1 AND campaign_id = $2 AND organization_id = $3
+	`, viewID, campaignID, organizationID)
+	if err != nil {
+		return false, fmt.Errorf("deleting result view: %w", err)
+	}
+	return cmd.RowsAffected() > 0, nil
+}
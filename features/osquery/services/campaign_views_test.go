@@ -0,0 +1,70 @@
+package services_test
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/cavenine/queryops/features/osquery/services"
+	"github.com/cavenine/queryops/internal/testdb"
+	"github.com/cavenine/queryops/internal/testdb/fixtures"
+	"github.com/google/uuid"
+)
+
+func TestHostRepository_ResultViews(t *testing.T) {
+	tdb := testdb.SetupTestDB(t)
+	ctx := context.Background()
+
+	orgID := fixtures.CreateOrg(t, tdb.Pool, "result-views-org").ID
+	otherOrgID := fixtures.CreateOrg(t, tdb.Pool, "result-views-other-org").ID
+	hostID := fixtures.CreateHost(t, tdb.Pool, orgID, "host-a").ID
+	repo := services.NewHostRepository(tdb.Pool)
+
+	campaignID, err := repo.QueueQuery(ctx, orgID, nil, nil, nil, "select name, pid from processes", []uuid.UUID{hostID}, services.CampaignOptions{})
+	if err != nil {
+		t.Fatalf("QueueQuery: %v", err)
+	}
+
+	view := services.CampaignResultView{Sort: "pid", Desc: true, Filters: map[string]string{"name": "ssh"}, Columns: []string{"name", "pid"}}
+	saved, err := repo.SaveResultView(ctx, orgID, campaignID, nil, "  sshd by pid ", view)
+	if err != nil {
+		t.Fatalf("SaveResultView: %v", err)
+	}
+	if saved.Name != "sshd by pid" {
+		t.Fatalf("Name = %q", saved.Name)
+	}
+
+	for _, name := range []string{" ", strings.Repeat("x", services.ResultViewNameMaxLength+1)} {
+		if _, err := repo.SaveResultView(ctx, orgID, campaignID, nil, name, view); !errors.Is(err, services.ErrInvalidResultViewName) {
+			t.Errorf("SaveResultView(%q) err = %v", name, err)
+		}
+	}
+	if _, err := repo.SaveResultView(ctx, otherOrgID, campaignID, nil, "theirs", view); !errors.Is(err, services.ErrCampaignNotFound) {
+		t.Fatalf("SaveResultView(other org) err = %v", err)
+	}
+
+	got, err := repo.GetResultView(ctx, saved.ID, orgID)
+	if err != nil || got.CampaignID != campaignID || !reflect.DeepEqual(got.View, view) {
+		t.Fatalf("GetResultView = %+v, %v", got, err)
+	}
+	if _, err := repo.GetResultView(ctx, saved.ID, otherOrgID); !errors.Is(err, services.ErrResultViewNotFound) {
+		t.Fatalf("GetResultView(other org) err = %v", err)
+	}
+
+	views, err := repo.ListResultViews(ctx, campaignID, orgID)
+	if err != nil || len(views) != 1 || views[0].ID != saved.ID {
+		t.Fatalf("ListResultViews = %+v, %v", views, err)
+	}
+
+	if ok, err := repo.DeleteResultView(ctx, saved.ID, campaignID, otherOrgID); err != nil || ok {
+		t.Fatalf("DeleteResultView(other org) = %v, %v", ok, err)
+	}
+	if ok, err := repo.DeleteResultView(ctx, saved.ID, campaignID, orgID); err != nil || !ok {
+		t.Fatalf("DeleteResultView = %v, %v", ok, err)
+	}
+	if _, err := repo.GetResultView(ctx, saved.ID, orgID); !errors.Is(err, services.ErrResultViewNotFound) {
+		t.Fatalf("GetResultView(deleted) err = %v", err)
+	}
+}
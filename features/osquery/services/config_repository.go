@@ -0,0 +1,64 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// ErrConfigNotFound is returned for an osquery config name that isn't stored.
+var ErrConfigNotFound = errors.New("osquery config not found")
+
+// GetConfig returns the named osquery config as stored.
+func (r *HostRepository) GetConfig(ctx context.Context, name string) (json.RawMessage, error) {
+	var config json.RawMessage
+	err := r.pool.QueryRow(ctx, `SELECT config FROM osquery_configs WHERE name = $1`, name).Scan(&config)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, ErrConfigNotFound
+		}
+		return nil, fmt.Errorf("getting osquery config: %w", err)
+	}
+	return config, nil
+}
+
+// UpdateConfig replaces the named osquery config with what update makes of
+// it, creating the config from {} if there's none by that name. Concurrent
+// updates to a config are applied one at a time. Hosts pick up the change on
+// their next config refresh.
+func (r *HostRepository) UpdateConfig(ctx context.Context, name string, update func(json.RawMessage) (json.RawMessage, error)) error {
+	tx, err := r.pool.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("updating osquery config: begin transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	if _, err := tx.Exec(ctx, `
+		INSERT INTO osquery_configs (name, config) VALUES ($1, '{}')
+		ON CONFLICT (name) DO NOTHING
+	`, name); err != nil {
+		return fmt.Errorf("updating osquery config: %w", err)
+	}
+
+	var config json.RawMessage
+	if err := tx.QueryRow(ctx, `SELECT config FROM osquery_configs WHERE name = $1 FOR UPDATE`, name).Scan(&config); err != nil {
+		return fmt.Errorf("updating osquery config: %w", err)
+	}
+	config, err = update(config)
+	if err != nil {
+		return err
+	}
+	if _, err := tx.Exec(ctx, `
+		UPDATE osquery_configs SET config = $2, updated_at = NOW() WHERE name = $1
+	`, name, config); err != nil {
+		return fmt.Errorf("updating osquery config: %w", err)
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return fmt.Errorf("updating osquery config: commit transaction: %w", err)
+	}
+	return nil
+}
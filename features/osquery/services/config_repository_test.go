@@ -0,0 +1,51 @@
+package services_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/cavenine/queryops/features/osquery/services"
+	"github.com/cavenine/queryops/internal/testdb"
+)
+
+func TestHostRepository_UpdateConfig(t *testing.T) {
+	tdb := testdb.SetupTestDB(t)
+	ctx := context.Background()
+	repo := services.NewHostRepository(tdb.Pool)
+
+	if _, err := repo.GetConfig(ctx, "packs"); !errors.Is(err, services.ErrConfigNotFound) {
+		t.Fatalf("GetConfig(missing) err = %v, want ErrConfigNotFound", err)
+	}
+
+	// A config that doesn't exist is created from {}.
+	err := repo.UpdateConfig(ctx, "packs", func(config json.RawMessage) (json.RawMessage, error) {
+		if string(config) != "{}" {
+			t.Errorf("new config = %s, want {}", config)
+		}
+		return json.RawMessage(`{"schedule":{"uptime":{"query":"SELECT 1;","interval":60}}}`), nil
+	})
+	if err != nil {
+		t.Fatalf("UpdateConfig: %v", err)
+	}
+	config, err := repo.GetConfig(ctx, "packs")
+	if err != nil {
+		t.Fatalf("GetConfig: %v", err)
+	}
+	var got map[string]any
+	if err := json.Unmarshal(config, &got); err != nil || got["schedule"] == nil {
+		t.Fatalf("config = %s, %v", config, err)
+	}
+
+	// An update that fails leaves the config alone.
+	failed := errors.New("bad file")
+	if err := repo.UpdateConfig(ctx, "packs", func(json.RawMessage) (json.RawMessage, error) {
+		return nil, failed
+	}); !errors.Is(err, failed) {
+		t.Fatalf("UpdateConfig err = %v, want %v", err, failed)
+	}
+	if after, err := repo.GetConfig(ctx, "packs"); err != nil || string(after) != string(config) {
+		t.Fatalf("config after failed update = %s, %v", after, err)
+	}
+}
@@ -0,0 +1,299 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+var (
+	ErrInvalidHostGroupName = errors.New("host group name is required")
+	ErrDuplicateHostGroup   = errors.New("a host group with that name already exists")
+	ErrEmptyHostGroup       = errors.New("host group has no hosts")
+)
+
+// HostGroup is a static, hand-maintained set of hosts in one organization.
+type HostGroup struct {
+	ID             uuid.UUID `json:"id"`
+	OrganizationID uuid.UUID `json:"organization_id"`
+	Name           string    `json:"name"`
+	Description    string    `json:"description"`
+	HostCount      int       `json:"host_count"`
+	CreatedAt      time.Time `json:"created_at"`
+}
+
+// OsqueryConfig names a stored osquery configuration hosts can be assigned.
+type OsqueryConfig struct {
+	ID   int    `json:"id"`
+	Name string `json:"name"`
+}
+
+func (r *HostRepository) ListHostGroups(ctx context.Context, organizationID uuid.UUID) ([]*HostGroup, error) {
+	rows, err := r.pool.Query(ctx, `
+		SELECT g.id, g.organization_id, g.name, g.description, COUNT(m.host_id)::int, g.created_at
+		FROM host_groups g
+		LEFT JOIN host_group_members m ON m.group_id = g.id
+		WHERE g.organization_id = $1
+		GROUP BY g.id
+		ORDER BY g.name
+	`, organizationID)
+	if err != nil {
+		return nil, fmt.Errorf("listing host groups: %w", err)
+	}
+	defer rows.Close()
+
+	var groups []*HostGroup
+	for rows.Next() {
+		var g HostGroup
+		if err := rows.Scan(&g.ID, &g.OrganizationID, &g.Name, &g.Description, &g.HostCount, &g.CreatedAt); err != nil {
+			return nil, fmt.Errorf("scanning host group: %w", err)
+		}
+		groups = append(groups, &g)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("listing host groups: %w", err)
+	}
+	return groups, nil
+}
+
+func (r *HostRepository) CreateHostGroup(ctx context.Context, organizationID uuid.UUID, name, description string) (*HostGroup, error) {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return nil, ErrInvalidHostGroupName
+	}
+
+	g := HostGroup{OrganizationID: organizationID, Name: name, Description: strings.TrimSpace(description)}
+	err := r.pool.QueryRow(ctx, `
+		INSERT INTO host_groups (organization_id, name, description)
+		VALUES ($1, $2, $3)
+		RETURNING id, created_at
+	`, organizationID, g.Name, g.Description).Scan(&g.ID, &g.CreatedAt)
+	if err != nil {
+		var pgErr *pgconn.PgError
+		if errors.As(err, &pgErr) && pgErr.Code == "23505" {
+			return nil, ErrDuplicateHostGroup
+		}
+		return nil, fmt.Errorf("creating host group: %w", err)
+	}
+	return &g, nil
+}
+
+// DeleteHostGroup deletes the group, not its hosts. It reports whether the
+// group existed in the organization.
+func (r *HostRepository) DeleteHostGroup(ctx context.Context, groupID, organizationID uuid.UUID) (bool, error) {
+	cmd, err := r.pool.Exec(ctx, `DELETE FROM host_groups WHERE id = $1 AND organization_id = $2`, groupID, organizationID)
+	if err != nil {
+		return false, fmt.Errorf("deleting host group: %w", err)
+	}
+	return cmd.RowsAffected() > 0, nil
+}
+
+// AddHostsToGroup adds the hosts to the group, ignoring hosts that are
+// already members or belong to another organization. It returns how many
+// were added.
+func (r *HostRepository) AddHostsToGroup(ctx context.Context, groupID, organizationID uuid.UUID, hostIDs []uuid.UUID) (int, error) {
+	cmd, err := r.pool.Exec(ctx, `
+		INSERT INTO host_group_members (group_id, host_id)
+		SELECT g.id, h.id
+		FROM host_groups g
+		JOIN hosts h ON h.organization_id = g.organization_id
+		WHERE g.id = $1 AND g.organization_id = $2 AND h.id = ANY($3)
+		ON CONFLICT DO NOTHING
+	`, groupID, organizationID, hostIDs)
+	if err != nil {
+		return 0, fmt.Errorf("adding hosts to group: %w", err)
+	}
+	return int(cmd.RowsAffected()), nil
+}
+
+func (r *HostRepository) RemoveHostsFromGroup(ctx context.Context, groupID, organizationID uuid.UUID, hostIDs []uuid.UUID) (int, error) {
+	cmd, err := r.pool.Exec(ctx, `
+		DELETE FROM host_group_members m
+		USING host_groups g
+		WHERE m.group_id = g.id AND g.id = $1 AND g.organization_id = $2 AND m.host_id = ANY($3)
+	`, groupID, organizationID, hostIDs)
+	if err != nil {
+		return 0, fmt.Errorf("removing hosts from group: %w", err)
+	}
+	return int(cmd.RowsAffected()), nil
+}
+
+// ListHostGroupMembers returns the IDs of the group's hosts.
+func (r *HostRepository) ListHostGroupMembers(ctx context.Context, groupID, organizationID uuid.UUID) ([]uuid.UUID, error) {
+	rows, err := r.pool.Query(ctx, `
+		SELECT m.host_id
+		FROM host_group_members m
+		JOIN host_groups g ON g.id = m.group_id
+		WHERE g.id = $1 AND g.organization_id = $2
+		ORDER BY m.host_id
+	`, groupID, organizationID)
+	if err != nil {
+		return nil, fmt.Errorf("listing host group members: %w", err)
+	}
+	ids, err := pgx.CollectRows(rows, pgx.RowTo[uuid.UUID])
+	if err != nil {
+		return nil, fmt.Errorf("listing host group members: %w", err)
+	}
+	return ids, nil
+}
+
+// QueueGroupQuery creates a campaign targeting the group's current members;
+// hosts added to the group later are not targeted. It returns uuid.Nil if the group doesn't exist in the
+// organization and ErrEmptyHostGroup if it has no hosts.
+func (r *HostRepository) QueueGroupQuery(
+	ctx context.Context,
+	organizationID uuid.UUID,
+	createdBy *int,
+	name *string,
+	description *string,
+	query string,
+	groupID uuid.UUID,
+	opts CampaignOptions,
+) (uuid.UUID, error) {
+	if err := opts.Validate(); err != nil {
+		return uuid.Nil, err
+	}
+
+	tx, err := r.pool.Begin(ctx)
+	if err != nil {
+		return uuid.Nil, fmt.Errorf("queueing group query: begin transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	var exists bool
+	err = tx.QueryRow(ctx, `
+		SELECT EXISTS(SELECT 1 FROM host_groups WHERE id = $1 AND organization_id = $2)
+	`, groupID, organizationID).Scan(&exists)
+	if err != nil {
+		return uuid.Nil, fmt.Errorf("queueing group query: %w", err)
+	}
+	if !exists {
+		return uuid.Nil, nil
+	}
+
+	var campaignID uuid.UUID
+	err = tx.QueryRow(ctx, `
+		INSERT INTO campaigns (organization_id, name, description, query, created_by, status, target_count, result_count, created_at, updated_at,
+			fanout_limit, fanout_interval_seconds, max_rows_per_host, max_bytes_per_host, timeout_seconds)
+		VALUES ($1, $2, $3, $4, $5, 'pending', 0, 0, NOW(), NOW(), $6, $7, $8, $9, $10)
+		RETURNING id
+	`, organizationID, name, description, query, createdBy,
+		opts.fanoutLimit(), opts.fanoutIntervalSeconds(), nonZero(opts.MaxRowsPerHost), nonZero(opts.MaxBytesPerHost), opts.timeoutSeconds()).Scan(&campaignID)
+	if err != nil {
+		return uuid.Nil, fmt.Errorf("queueing group query: %w", err)
+	}
+
+	cmd, err := tx.Exec(ctx, `
+		INSERT INTO campaign_targets (campaign_id, host_id)
+		SELECT $1, host_id FROM host_group_members WHERE group_id = $2
+	`, campaignID, groupID)
+	if err != nil {
+		return uuid.Nil, fmt.Errorf("queueing group query: inserting targets: %w", err)
+	}
+	if cmd.RowsAffected() == 0 {
+		return uuid.Nil, ErrEmptyHostGroup
+	}
+
+	_, err = tx.Exec(ctx, `UPDATE campaigns SET target_count = $2 WHERE id = $1`, campaignID, cmd.RowsAffected())
+	if err != nil {
+		return uuid.Nil, fmt.Errorf("queueing group query: counting targets: %w", err)
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return uuid.Nil, fmt.Errorf("queueing group query: commit transaction: %w", err)
+	}
+	return campaignID, nil
+}
+
+func (r *HostRepository) ListConfigs(ctx context.Context) ([]OsqueryConfig, error) {
+	rows, err := r.pool.Query(ctx, `SELECT id, name FROM osquery_configs ORDER BY name`)
+	if err != nil {
+		return nil, fmt.Errorf("listing osquery configs: %w", err)
+	}
+	configs, err := pgx.CollectRows(rows, pgx.RowToStructByPos[OsqueryConfig])
+	if err != nil {
+		return nil, fmt.Errorf("listing osquery configs: %w", err)
+	}
+	return configs, nil
+}
+
+// AssignConfig points the organization's given hosts at an osquery config.
+// A nil configID reverts them to the default config. Hosts pick it up on
+// their next config refresh; quarantined hosts keep the quarantine config
+// and pick it up when they're released.
+func (r *HostRepository) AssignConfig(ctx context.Context, organizationID uuid.UUID, hostIDs []uuid.UUID, configID *int) (int, error) {
+	var n int
+	err := r.pool.QueryRow(ctx, `
+		WITH quarantined AS (
+			UPDATE host_quarantines q SET previous_config_id = $3
+			FROM hosts h
+			WHERE h.organization_id = $1 AND h.id = ANY($2) AND q.id = h.quarantine_id
+			RETURNING q.host_id
+		), assigned AS (
+			UPDATE hosts SET config_id = $3, updated_at = NOW()
+			WHERE organization_id = $1 AND id = ANY($2) AND quarantine_id IS NULL
+			RETURNING id
+		)
+		SELECT (SELECT COUNT(*) FROM quarantined) + (SELECT COUNT(*) FROM assigned)
+	`, organizationID, hostIDs, configID).Scan(&n)
+	if err != nil {
+		return 0, fmt.Errorf("assigning config: %w", err)
+	}
+	return n, nil
+}
+
+// DeleteHosts removes the organization's given hosts along with their
+// results and logs. Campaigns that targeted them are recounted so they can
+// still finish. A deleted host that is still running osquery is told its
+// node key is invalid and can enroll again.
+func (r *HostRepository) DeleteHosts(ctx context.Context, organizationID uuid.UUID, hostIDs []uuid.UUID) (int, error) {
+	tx, err := r.pool.Begin(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("deleting hosts: begin transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	rows, err := tx.Query(ctx, `
+		SELECT DISTINCT t.campaign_id
+		FROM campaign_targets t
+		JOIN hosts h ON h.id = t.host_id
+		WHERE h.organization_id = $1 AND h.id = ANY($2)
+	`, organizationID, hostIDs)
+	if err != nil {
+		return 0, fmt.Errorf("deleting hosts: listing campaigns: %w", err)
+	}
+	campaignIDs, err := pgx.CollectRows(rows, pgx.RowTo[uuid.UUID])
+	if err != nil {
+		return 0, fmt.Errorf("deleting hosts: listing campaigns: %w", err)
+	}
+
+	cmd, err := tx.Exec(ctx, `DELETE FROM hosts WHERE organization_id = $1 AND id = ANY($2)`, organizationID, hostIDs)
+	if err != nil {
+		return 0, fmt.Errorf("deleting hosts: %w", err)
+	}
+
+	if len(campaignIDs) > 0 {
+		_, err = tx.Exec(ctx, `
+			UPDATE campaigns c
+			SET target_count = (SELECT COUNT(*) FROM campaign_targets WHERE campaign_id = c.id)
+			WHERE c.id = ANY($1)
+		`, campaignIDs)
+		if err != nil {
+			return 0, fmt.Errorf("deleting hosts: recounting campaign targets: %w", err)
+		}
+		if err := refreshCampaignStatus(ctx, tx, campaignIDs); err != nil {
+			return 0, fmt.Errorf("deleting hosts: refreshing campaign status: %w", err)
+		}
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return 0, fmt.Errorf("deleting hosts: commit transaction: %w", err)
+	}
+	return int(cmd.RowsAffected()), nil
+}
@@ -0,0 +1,159 @@
+package services_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/cavenine/queryops/features/osquery/services"
+	"github.com/cavenine/queryops/internal/testdb"
+	"github.com/cavenine/queryops/internal/testdb/fixtures"
+	"github.com/google/uuid"
+)
+
+func TestHostGroups_Flow(t *testing.T) {
+	tdb := testdb.SetupTestDB(t)
+	ctx := context.Background()
+
+	orgID := fixtures.CreateOrg(t, tdb.Pool, "groups-org").ID
+	otherOrgID := fixtures.CreateOrg(t, tdb.Pool, "other-org").ID
+	hostA := fixtures.CreateHost(t, tdb.Pool, orgID, "host-a").ID
+	hostB := fixtures.CreateHost(t, tdb.Pool, orgID, "host-b").ID
+	foreign := fixtures.CreateHost(t, tdb.Pool, otherOrgID, "foreign").ID
+
+	repo := services.NewHostRepository(tdb.Pool)
+
+	group, err := repo.CreateHostGroup(ctx, orgID, " web ", "front ends")
+	if err != nil {
+		t.Fatalf("CreateHostGroup: %v", err)
+	}
+	if group.Name != "web" {
+		t.Fatalf("Name = %q, want web", group.Name)
+	}
+	if _, err := repo.CreateHostGroup(ctx, orgID, "web", ""); !errors.Is(err, services.ErrDuplicateHostGroup) {
+		t.Fatalf("duplicate CreateHostGroup error = %v", err)
+	}
+
+	if _, err := repo.QueueGroupQuery(ctx, orgID, nil, nil, nil, "select 1", group.ID, services.CampaignOptions{}); !errors.Is(err, services.ErrEmptyHostGroup) {
+		t.Fatalf("QueueGroupQuery on empty group error = %v", err)
+	}
+
+	added, err := repo.AddHostsToGroup(ctx, group.ID, orgID, []uuid.UUID{hostA, hostB, foreign})
+	if err != nil {
+		t.Fatalf("AddHostsToGroup: %v", err)
+	}
+	if added != 2 {
+		t.Fatalf("added = %d, want 2 (foreign host skipped)", added)
+	}
+	if added, _ := repo.AddHostsToGroup(ctx, group.ID, orgID, []uuid.UUID{hostA}); added != 0 {
+		t.Fatalf("re-adding a member added %d", added)
+	}
+
+	groups, err := repo.ListHostGroups(ctx, orgID)
+	if err != nil {
+		t.Fatalf("ListHostGroups: %v", err)
+	}
+	if len(groups) != 1 || groups[0].HostCount != 2 {
+		t.Fatalf("groups = %+v", groups)
+	}
+
+	campaignID, err := repo.QueueGroupQuery(ctx, orgID, nil, nil, nil, "select 1", group.ID, services.CampaignOptions{})
+	if err != nil {
+		t.Fatalf("QueueGroupQuery: %v", err)
+	}
+	campaign, err := repo.GetCampaignByIDAndOrganization(ctx, campaignID, orgID)
+	if err != nil || campaign == nil {
+		t.Fatalf("GetCampaignByIDAndOrganization: %v, %v", campaign, err)
+	}
+	if campaign.TargetCount != 2 {
+		t.Fatalf("TargetCount = %d, want 2", campaign.TargetCount)
+	}
+
+	if id, err := repo.QueueGroupQuery(ctx, otherOrgID, nil, nil, nil, "select 1", group.ID, services.CampaignOptions{}); err != nil || id != uuid.Nil {
+		t.Fatalf("QueueGroupQuery from another org = %v, %v", id, err)
+	}
+
+	if removed, err := repo.RemoveHostsFromGroup(ctx, group.ID, orgID, []uuid.UUID{hostB}); err != nil || removed != 1 {
+		t.Fatalf("RemoveHostsFromGroup = %d, %v", removed, err)
+	}
+	members, err := repo.ListHostGroupMembers(ctx, group.ID, orgID)
+	if err != nil {
+		t.Fatalf("ListHostGroupMembers: %v", err)
+	}
+	if len(members) != 1 || members[0] != hostA {
+		t.Fatalf("members = %v, want [%s]", members, hostA)
+	}
+
+	if deleted, err := repo.DeleteHostGroup(ctx, group.ID, otherOrgID); err != nil || deleted {
+		t.Fatalf("DeleteHostGroup from another org = %v, %v", deleted, err)
+	}
+	if deleted, err := repo.DeleteHostGroup(ctx, group.ID, orgID); err != nil || !deleted {
+		t.Fatalf("DeleteHostGroup = %v, %v", deleted, err)
+	}
+	if host, _ := repo.GetByIDAndOrganization(ctx, hostA, orgID); host == nil {
+		t.Fatalf("deleting a group deleted its hosts")
+	}
+}
+
+func TestHostBulkActions(t *testing.T) {
+	tdb := testdb.SetupTestDB(t)
+	ctx := context.Background()
+
+	orgID := fixtures.CreateOrg(t, tdb.Pool, "bulk-org").ID
+	otherOrgID := fixtures.CreateOrg(t, tdb.Pool, "other-org").ID
+	hostA := fixtures.CreateHost(t, tdb.Pool, orgID, "host-a")
+	hostB := fixtures.CreateHost(t, tdb.Pool, orgID, "host-b")
+	foreign := fixtures.CreateHost(t, tdb.Pool, otherOrgID, "foreign")
+
+	repo := services.NewHostRepository(tdb.Pool)
+
+	var configID int
+	err := tdb.Pool.QueryRow(ctx, `INSERT INTO osquery_configs (name, config) VALUES ('strict', '{"options":{}}') RETURNING id`).Scan(&configID)
+	if err != nil {
+		t.Fatalf("inserting config: %v", err)
+	}
+
+	configs, err := repo.ListConfigs(ctx)
+	if err != nil {
+		t.Fatalf("ListConfigs: %v", err)
+	}
+	if len(configs) < 2 {
+		t.Fatalf("configs = %+v, want default and strict", configs)
+	}
+
+	n, err := repo.AssignConfig(ctx, orgID, []uuid.UUID{hostA.ID, foreign.ID}, &configID)
+	if err != nil || n != 1 {
+		t.Fatalf("AssignConfig = %d, %v", n, err)
+	}
+	config, err := repo.GetConfigForHost(ctx, hostA.NodeKey)
+	if err != nil {
+		t.Fatalf("GetConfigForHost: %v", err)
+	}
+	if string(config) != `{"options": {}}` {
+		t.Fatalf("config = %s", config)
+	}
+
+	campaignID, err := repo.QueueQuery(ctx, orgID, nil, nil, nil, "select 1", []uuid.UUID{hostA.ID, hostB.ID}, services.CampaignOptions{})
+	if err != nil {
+		t.Fatalf("QueueQuery: %v", err)
+	}
+	if err := repo.SaveQueryResults(ctx, hostA.ID, campaignID, "completed", []byte(`[]`), nil, false); err != nil {
+		t.Fatalf("SaveQueryResults: %v", err)
+	}
+
+	n, err = repo.DeleteHosts(ctx, orgID, []uuid.UUID{hostB.ID, foreign.ID})
+	if err != nil || n != 1 {
+		t.Fatalf("DeleteHosts = %d, %v", n, err)
+	}
+	if host, _ := repo.GetByIDAndOrganization(ctx, foreign.ID, otherOrgID); host == nil {
+		t.Fatalf("DeleteHosts deleted another organization's host")
+	}
+
+	campaign, err := repo.GetCampaignByIDAndOrganization(ctx, campaignID, orgID)
+	if err != nil || campaign == nil {
+		t.Fatalf("GetCampaignByIDAndOrganization: %v, %v", campaign, err)
+	}
+	if campaign.TargetCount != 1 || campaign.Status != "completed" {
+		t.Fatalf("campaign = %d targets, %q; want 1, completed", campaign.TargetCount, campaign.Status)
+	}
+}
@@ -0,0 +1,472 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+)
+
+var (
+	ErrHostNotFound       = errors.New("host not found")
+	ErrNoIdentityConflict = errors.New("host has only enrolled as one machine")
+)
+
+const (
+	// identityHistory is how long enrollments are kept to detect conflicts.
+	identityHistory = 7 * 24 * time.Hour
+	// A host that enrolls identityBurstEnrollments times within
+	// identityBurstWindow is flagged. osquery enrolls again only when its
+	// node key stops working, which for clones is whenever the other one
+	// enrolls.
+	identityBurstEnrollments = 6
+	identityBurstWindow      = time.Hour
+)
+
+// HostIdentity is one machine seen enrolling as a host, told apart by its
+// hardware UUID.
+type HostIdentity struct {
+	HardwareUUID   string
+	Hostname       string
+	Enrollments    int
+	LastEnrolledAt time.Time
+}
+
+// hostDetails is the part of the host_details osquery sends when enrolling
+// that QueryOps keeps.
+type hostDetails struct {
+	OSVersion    json.RawMessage `json:"os_version"`
+	OsqueryInfo  json.RawMessage `json:"osquery_info"`
+	SystemInfo   json.RawMessage `json:"system_info"`
+	PlatformInfo json.RawMessage `json:"platform_info"`
+
+	hardwareUUID string
+	hostname     string
+	instanceID   string
+	osquery      OsqueryInfo
+}
+
+// parseHostDetails decodes what it can of raw; missing or malformed details
+// leave the host's stored ones alone.
+func parseHostDetails(raw json.RawMessage) hostDetails {
+	var d hostDetails
+	if len(raw) == 0 || json.Unmarshal(raw, &d) != nil {
+		return hostDetails{}
+	}
+	for _, field := range []*json.RawMessage{&d.OSVersion, &d.OsqueryInfo, &d.SystemInfo, &d.PlatformInfo} {
+		if string(*field) == "null" {
+			*field = nil
+		}
+	}
+
+	var system struct {
+		UUID     string `json:"uuid"`
+		Hostname string `json:"hostname"`
+	}
+	if len(d.SystemInfo) > 0 && json.Unmarshal(d.SystemInfo, &system) == nil {
+		d.hardwareUUID = normalizeHardwareUUID(system.UUID)
+		d.hostname = system.Hostname
+	}
+	var osquery struct {
+		OsqueryInfo
+		InstanceID string `json:"instance_id"`
+	}
+	if len(d.OsqueryInfo) > 0 && json.Unmarshal(d.OsqueryInfo, &osquery) == nil {
+		d.instanceID = strings.ToLower(strings.TrimSpace(osquery.InstanceID))
+		d.osquery = osquery.OsqueryInfo
+	}
+	return d
+}
+
+// normalizeHardwareUUID uppercases id, and drops the all-zero UUID some
+// hypervisors report for every VM.
+func normalizeHardwareUUID(id string) string {
+	id = strings.ToUpper(strings.TrimSpace(id))
+	if strings.Trim(id, "0-") == "" {
+		return ""
+	}
+	return id
+}
+
+// Host identity strategies, as stored in organization_settings.host_identity.
+const (
+	identityByHostIdentifier = "host_identifier"
+	identityByHardwareUUID   = "hardware_uuid"
+	identityByInstanceID     = "instance_id"
+)
+
+// instanceKeyPrefix sets identity keys that are osquery instance IDs apart
+// from hardware UUIDs.
+const instanceKeyPrefix = "instance:"
+
+// enrollTarget is the host record an enrollment goes to.
+type enrollTarget struct {
+	hostIdentifier string
+	identityKey    string
+
+	// hostID is the existing record the machine was matched to, which may
+	// have enrolled under another host identifier or identity key, or
+	// uuid.Nil if it's matched by hostIdentifier and identityKey alone.
+	hostID            uuid.UUID
+	storedIdentifier  string
+	storedIdentityKey string
+	// hardwareUUID is the record's hardware UUID before this enrollment.
+	hardwareUUID string
+}
+
+// moved reports whether the matched record must take the enrollment's host
+// identifier and identity key before enrolling.
+func (t enrollTarget) moved() bool {
+	return t.hostID != uuid.Nil && (t.storedIdentifier != t.hostIdentifier || t.storedIdentityKey != t.identityKey)
+}
+
+// resolveEnrollment decides which host record a machine enrolls into. A
+// record keyed by the machine's hardware UUID, by a split or the hardware
+// UUID strategy, always wins. Otherwise the organization's strategy picks
+// the identity key:
+//
+//   - host_identifier: none, so the host identifier alone names the host;
+//     once it has been split, each machine under it is keyed by hardware
+//     UUID.
+//   - hardware_uuid: the hardware UUID, so a re-imaged machine keeps its
+//     record.
+//   - instance_id: osquery's instance ID, so a new instance behind a reused
+//     hostname gets its own record.
+//
+// Machines without the hardware UUID or instance ID their strategy needs
+// fall back to host_identifier. Records enrolled under an earlier strategy
+// are adopted by host identifier rather than duplicated.
+func resolveEnrollment(ctx context.Context, tx pgx.Tx, organizationID uuid.UUID, hostIdentifier string, d hostDetails) (enrollTarget, error) {
+	t := enrollTarget{hostIdentifier: hostIdentifier}
+	find := func(query string, args ...any) (bool, error) {
+		err := tx.QueryRow(ctx, query, args...).Scan(&t.hostID, &t.storedIdentifier, &t.storedIdentityKey, &t.hardwareUUID)
+		if errors.Is(err, pgx.ErrNoRows) {
+			return false, nil
+		}
+		if err != nil {
+			return false, fmt.Errorf("enrolling host: finding host: %w", err)
+		}
+		return true, nil
+	}
+	const byIdentityKey = `
+		SELECT id, host_identifier, identity_key, hardware_uuid FROM hosts
+		WHERE organization_id = $1 AND identity_key = $2
+		ORDER BY last_enrollment_at DESC
+		LIMIT 1
+		FOR UPDATE
+	`
+
+	if d.hardwareUUID != "" {
+		t.identityKey = d.hardwareUUID
+		if found, err := find(byIdentityKey, organizationID, d.hardwareUUID); found || err != nil {
+			return t, err
+		}
+	}
+
+	var strategy string
+	err := tx.QueryRow(ctx, `
+		SELECT COALESCE((SELECT host_identity FROM organization_settings WHERE organization_id = $1), $2)
+	`, organizationID, identityByHostIdentifier).Scan(&strategy)
+	if err != nil {
+		return t, fmt.Errorf("enrolling host: getting identity strategy: %w", err)
+	}
+
+	switch {
+	case strategy == identityByHardwareUUID && d.hardwareUUID != "":
+		t.identityKey = d.hardwareUUID
+		_, err := find(`
+			SELECT id, host_identifier, identity_key, hardware_uuid FROM hosts
+			WHERE organization_id = $1 AND host_identifier = $2 AND identity_key = '' AND hardware_uuid IN ($3, '')
+			FOR UPDATE
+		`, organizationID, hostIdentifier, d.hardwareUUID)
+		return t, err
+
+	case strategy == identityByInstanceID && d.instanceID != "":
+		t.identityKey = instanceKeyPrefix + d.instanceID
+		if found, err := find(byIdentityKey, organizationID, t.identityKey); found || err != nil {
+			return t, err
+		}
+		_, err := find(`
+			SELECT id, host_identifier, identity_key, hardware_uuid FROM hosts
+			WHERE organization_id = $1 AND host_identifier = $2 AND identity_key = '' AND osquery_instance_id IN ($3, '')
+			FOR UPDATE
+		`, organizationID, hostIdentifier, d.instanceID)
+		return t, err
+	}
+
+	t.identityKey = ""
+	if d.hardwareUUID != "" {
+		var split bool
+		err := tx.QueryRow(ctx, `
+			SELECT EXISTS (
+				SELECT 1 FROM hosts
+				WHERE organization_id = $1 AND host_identifier = $2 AND identity_key <> '' AND identity_key NOT LIKE $3
+			)
+		`, organizationID, hostIdentifier, instanceKeyPrefix+"%").Scan(&split)
+		if err != nil {
+			return t, fmt.Errorf("enrolling host: checking for split hosts: %w", err)
+		}
+		if split {
+			t.identityKey = d.hardwareUUID
+			return t, nil
+		}
+	}
+	if found, err := find(`
+		SELECT id, host_identifier, identity_key, hardware_uuid FROM hosts
+		WHERE organization_id = $1 AND host_identifier = $2 AND identity_key = ''
+		FOR UPDATE
+	`, organizationID, hostIdentifier); found || err != nil {
+		return t, err
+	}
+	// Fold a host last keyed by instance ID back into its host identifier.
+	_, err = find(`
+		SELECT id, host_identifier, identity_key, hardware_uuid FROM hosts
+		WHERE organization_id = $1 AND host_identifier = $2 AND identity_key LIKE $3
+		ORDER BY last_enrollment_at DESC
+		LIMIT 1
+		FOR UPDATE
+	`, organizationID, hostIdentifier, instanceKeyPrefix+"%")
+	return t, err
+}
+
+// recordEnrollment adds an enrollment to the host's history and flags the
+// host if it now looks like more than one machine: it has enrolled
+// repeatedly in a short time, or switched back to hardware it had already
+// moved away from.
+func recordEnrollment(ctx context.Context, tx pgx.Tx, hostID uuid.UUID, previousUUID string, d hostDetails) error {
+	_, err := tx.Exec(ctx, `
+		DELETE FROM host_enrollments
+		WHERE host_id = $1 AND enrolled_at < NOW() - make_interval(secs => $2)
+	`, hostID, identityHistory.Seconds())
+	if err != nil {
+		return fmt.Errorf("enrolling host: pruning enrollments: %w", err)
+	}
+
+	var id int64
+	err = tx.QueryRow(ctx, `
+		INSERT INTO host_enrollments (host_id, hardware_uuid, hostname)
+		VALUES ($1, $2, $3)
+		RETURNING id
+	`, hostID, d.hardwareUUID, d.hostname).Scan(&id)
+	if err != nil {
+		return fmt.Errorf("enrolling host: recording enrollment: %w", err)
+	}
+
+	var recent, seenBefore int
+	err = tx.QueryRow(ctx, `
+		SELECT COUNT(*) FILTER (WHERE enrolled_at > NOW() - make_interval(secs => $2)),
+		       COUNT(*) FILTER (WHERE hardware_uuid = $3 AND id <> $4)
+		FROM host_enrollments
+		WHERE host_id = $1
+	`, hostID, identityBurstWindow.Seconds(), d.hardwareUUID, id).Scan(&recent, &seenBefore)
+	if err != nil {
+		return fmt.Errorf("enrolling host: counting enrollments: %w", err)
+	}
+
+	alternating := d.hardwareUUID != "" && previousUUID != "" && d.hardwareUUID != previousUUID && seenBefore > 0
+	if recent < identityBurstEnrollments && !alternating {
+		return nil
+	}
+	_, err = tx.Exec(ctx, `
+		UPDATE hosts SET identity_conflict_at = NOW()
+		WHERE id = $1 AND identity_conflict_at IS NULL
+	`, hostID)
+	if err != nil {
+		return fmt.Errorf("enrolling host: flagging identity conflict: %w", err)
+	}
+	return nil
+}
+
+// ListHostIdentities returns the machines that enrolled as the host in the
+// past week, most recent first. Enrollments without a hardware UUID are
+// left out.
+func (r *HostRepository) ListHostIdentities(ctx context.Context, hostID, organizationID uuid.UUID) ([]HostIdentity, error) {
+	rows, err := r.pool.Query(ctx, `
+		SELECT e.hardware_uuid, (array_agg(e.hostname ORDER BY e.enrolled_at DESC))[1], COUNT(*)::int, MAX(e.enrolled_at)
+		FROM host_enrollments e
+		JOIN hosts h ON h.id = e.host_id
+		WHERE e.host_id = $1 AND h.organization_id = $2 AND e.hardware_uuid <> ''
+		GROUP BY e.hardware_uuid
+		ORDER BY MAX(e.enrolled_at) DESC
+	`, hostID, organizationID)
+	if err != nil {
+		return nil, fmt.Errorf("listing host identities: %w", err)
+	}
+	defer rows.Close()
+
+	var identities []HostIdentity
+	for rows.Next() {
+		var i HostIdentity
+		if err := rows.Scan(&i.HardwareUUID, &i.Hostname, &i.Enrollments, &i.LastEnrolledAt); err != nil {
+			return nil, fmt.Errorf("scanning host identity: %w", err)
+		}
+		identities = append(identities, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("listing host identities: %w", err)
+	}
+	return identities, nil
+}
+
+// SplitHostIdentity gives each machine that enrolled as the host its own
+// host record, keyed by hardware UUID. The host keeps its history and
+// becomes the record of the machine it last enrolled as; the others start
+// empty, in the same groups and with the same config. Every node key the
+// host held is revoked, so each machine enrolls again into its own record.
+// It returns the new hosts' IDs.
+func (r *HostRepository) SplitHostIdentity(ctx context.Context, hostID, organizationID uuid.UUID) ([]uuid.UUID, error) {
+	tx, err := r.pool.Begin(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("splitting host: begin transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	var current string
+	err = tx.QueryRow(ctx, `
+		SELECT hardware_uuid FROM hosts
+		WHERE id = $1 AND organization_id = $2
+		FOR UPDATE
+	`, hostID, organizationID).Scan(&current)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, ErrHostNotFound
+		}
+		return nil, fmt.Errorf("splitting host: %w", err)
+	}
+
+	type machine struct {
+		hardwareUUID   string
+		lastEnrolledAt time.Time
+	}
+	rows, err := tx.Query(ctx, `
+		SELECT hardware_uuid, MAX(enrolled_at)
+		FROM host_enrollments
+		WHERE host_id = $1 AND hardware_uuid <> ''
+		GROUP BY hardware_uuid
+		ORDER BY MAX(enrolled_at) DESC
+	`, hostID)
+	if err != nil {
+		return nil, fmt.Errorf("splitting host: listing machines: %w", err)
+	}
+	machines, err := pgx.CollectRows(rows, func(row pgx.CollectableRow) (machine, error) {
+		var m machine
+		err := row.Scan(&m.hardwareUUID, &m.lastEnrolledAt)
+		return m, err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("splitting host: listing machines: %w", err)
+	}
+	if len(machines) < 2 {
+		return nil, ErrNoIdentityConflict
+	}
+
+	keep := machines[0].hardwareUUID
+	for _, m := range machines {
+		if m.hardwareUUID == current {
+			keep = current
+		}
+	}
+
+	revoked, err := generateNodeKey()
+	if err != nil {
+		return nil, err
+	}
+	_, err = tx.Exec(ctx, `
+		UPDATE hosts
+		SET identity_key = $2,
+			hardware_uuid = $2,
+			node_key_hash = $3,
+			previous_node_key_hash = NULL,
+			node_key_issued_at = NOW(),
+			identity_conflict_at = NULL,
+			updated_at = NOW()
+		WHERE id = $1
+	`, hostID, keep, HashNodeKey(revoked))
+	if err != nil {
+		return nil, fmt.Errorf("splitting host: %w", err)
+	}
+
+	var created []uuid.UUID
+	for _, m := range machines {
+		if m.hardwareUUID == keep {
+			continue
+		}
+		// Nobody holds the new record's node key; the machine gets one
+		// when it enrolls again.
+		unused, err := generateNodeKey()
+		if err != nil {
+			return nil, err
+		}
+		// A quarantine stays with the host being split; the new records get
+		// the config it had before.
+		var id uuid.UUID
+		err = tx.QueryRow(ctx, `
+			INSERT INTO hosts (organization_id, host_identifier, identity_key, hardware_uuid, node_key_hash, config_id,
+			                   last_enrollment_at, enroll_announced_at)
+			SELECT h.organization_id, h.host_identifier, $2, $2, $3,
+			       CASE WHEN h.quarantine_id IS NULL THEN h.config_id ELSE q.previous_config_id END, $4, NOW()
+			FROM hosts h
+			LEFT JOIN host_quarantines q ON q.id = h.quarantine_id
+			WHERE h.id = $1
+			ON CONFLICT (organization_id, host_identifier, identity_key) DO NOTHING
+			RETURNING id
+		`, hostID, m.hardwareUUID, HashNodeKey(unused), m.lastEnrolledAt).Scan(&id)
+		if errors.Is(err, pgx.ErrNoRows) {
+			// The machine already has a record from an earlier split.
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("splitting host: creating host: %w", err)
+		}
+		_, err = tx.Exec(ctx, `
+			INSERT INTO host_group_members (group_id, host_id)
+			SELECT group_id, $2 FROM host_group_members WHERE host_id = $1
+		`, hostID, id)
+		if err != nil {
+			return nil, fmt.Errorf("splitting host: copying group memberships: %w", err)
+		}
+		created = append(created, id)
+	}
+
+	if _, err := tx.Exec(ctx, `DELETE FROM host_enrollments WHERE host_id = $1`, hostID); err != nil {
+		return nil, fmt.Errorf("splitting host: clearing enrollments: %w", err)
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return nil, fmt.Errorf("splitting host: commit: %w", err)
+	}
+	return created, nil
+}
+
+// DismissIdentityConflict clears the host's identity conflict flag and its
+// enrollment history, for a host flagged by mistake. It reports whether the
+// host exists.
+func (r *HostRepository) DismissIdentityConflict(ctx context.Context, hostID, organizationID uuid.UUID) (bool, error) {
+	tx, err := r.pool.Begin(ctx)
+	if err != nil {
+		return false, fmt.Errorf("dismissing identity conflict: begin transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	cmd, err := tx.Exec(ctx, `
+		UPDATE hosts SET identity_conflict_at = NULL, updated_at = NOW()
+		WHERE id = $1 AND organization_id = $2
+	`, hostID, organizationID)
+	if err != nil {
+		return false, fmt.Errorf("dismissing identity conflict: %w", err)
+	}
+	if cmd.RowsAffected() == 0 {
+		return false, nil
+	}
+	if _, err := tx.Exec(ctx, `DELETE FROM host_enrollments WHERE host_id = $1`, hostID); err != nil {
+		return false, fmt.Errorf("dismissing identity conflict: clearing enrollments: %w", err)
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return false, fmt.Errorf("dismissing identity conflict: commit: %w", err)
+	}
+	return true, nil
+}
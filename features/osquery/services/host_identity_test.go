@@ -0,0 +1,201 @@
+package services_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/cavenine/queryops/features/osquery/services"
+	"github.com/cavenine/queryops/internal/testdb"
+	"github.com/cavenine/queryops/internal/testdb/fixtures"
+	"github.com/google/uuid"
+)
+
+func enrollmentDetails(hardwareUUID, hostname string) json.RawMessage {
+	b, _ := json.Marshal(map[string]any{
+		"os_version":  map[string]string{"name": "Ubuntu", "platform": "ubuntu"},
+		"system_info": map[string]string{"uuid": hardwareUUID, "hostname": hostname},
+	})
+	return b
+}
+
+func TestHostRepository_IdentityConflict(t *testing.T) {
+	tdb := testdb.SetupTestDB(t)
+	ctx := context.Background()
+
+	orgID := fixtures.CreateOrg(t, tdb.Pool, "identity-org").ID
+	repo := services.NewHostRepository(tdb.Pool)
+
+	const machineA, machineB = "AAAAAAAA-0000-0000-0000-000000000001", "BBBBBBBB-0000-0000-0000-000000000002"
+	enroll := func(hardwareUUID string) (string, *services.Host) {
+		t.Helper()
+		key, err := repo.Enroll(ctx, "clone", enrollmentDetails(hardwareUUID, "vm-"+hardwareUUID[:1]), orgID)
+		if err != nil {
+			t.Fatalf("Enroll: %v", err)
+		}
+		host, err := repo.GetByNodeKey(ctx, key)
+		if err != nil || host == nil {
+			t.Fatalf("GetByNodeKey = %+v, %v", host, err)
+		}
+		return key, host
+	}
+
+	_, host := enroll(machineA)
+	if host.HardwareUUID != machineA || host.IdentityConflictAt != nil || string(host.OSVersion) == "" {
+		t.Fatalf("first enrollment = %+v", host)
+	}
+	// Moving to new hardware once is not a conflict...
+	if _, host = enroll(machineB); host.IdentityConflictAt != nil {
+		t.Fatalf("host flagged after one hardware change")
+	}
+	// ...but switching back is.
+	keyA, host := enroll(machineA)
+	if host.IdentityConflictAt == nil {
+		t.Fatalf("host not flagged after alternating hardware")
+	}
+
+	identities, err := repo.ListHostIdentities(ctx, host.ID, orgID)
+	if err != nil || len(identities) != 2 || identities[0].HardwareUUID != machineA || identities[0].Enrollments != 2 || identities[0].Hostname != "vm-A" {
+		t.Fatalf("ListHostIdentities = %+v, %v", identities, err)
+	}
+	if other, err := repo.ListHostIdentities(ctx, host.ID, uuid.New()); err != nil || len(other) != 0 {
+		t.Fatalf("ListHostIdentities(other org) = %+v, %v", other, err)
+	}
+
+	created, err := repo.SplitHostIdentity(ctx, host.ID, orgID)
+	if err != nil || len(created) != 1 {
+		t.Fatalf("SplitHostIdentity = %v, %v", created, err)
+	}
+	if h, err := repo.GetByNodeKey(ctx, keyA); err != nil || h != nil {
+		t.Fatalf("node key survived the split: %+v, %v", h, err)
+	}
+
+	// Each machine now enrolls into its own record, without conflict.
+	for range 2 {
+		if _, h := enroll(machineA); h.ID != host.ID || h.IdentityConflictAt != nil {
+			t.Fatalf("machine A enrolled as %+v, want host %s", h, host.ID)
+		}
+		if _, h := enroll(machineB); h.ID != created[0] || h.IdentityConflictAt != nil {
+			t.Fatalf("machine B enrolled as %+v, want host %s", h, created[0])
+		}
+	}
+	if _, err := repo.SplitHostIdentity(ctx, created[0], orgID); !errors.Is(err, services.ErrNoIdentityConflict) {
+		t.Fatalf("SplitHostIdentity(one machine) err = %v", err)
+	}
+	if _, err := repo.SplitHostIdentity(ctx, uuid.New(), orgID); !errors.Is(err, services.ErrHostNotFound) {
+		t.Fatalf("SplitHostIdentity(missing) err = %v", err)
+	}
+}
+
+func TestHostRepository_IdentityConflictBurst(t *testing.T) {
+	tdb := testdb.SetupTestDB(t)
+	ctx := context.Background()
+
+	orgID := fixtures.CreateOrg(t, tdb.Pool, "identity-burst-org").ID
+	repo := services.NewHostRepository(tdb.Pool)
+
+	var key string
+	for range 6 {
+		var err error
+		// No hardware UUID: the all-zero one some hypervisors report is ignored.
+		if key, err = repo.Enroll(ctx, "flapping", enrollmentDetails("00000000-0000-0000-0000-000000000000", "vm"), orgID); err != nil {
+			t.Fatalf("Enroll: %v", err)
+		}
+	}
+	host, err := repo.GetByNodeKey(ctx, key)
+	if err != nil || host == nil || host.IdentityConflictAt == nil || host.HardwareUUID != "" {
+		t.Fatalf("host after rapid re-enrollment = %+v, %v", host, err)
+	}
+
+	if ok, err := repo.DismissIdentityConflict(ctx, host.ID, orgID); err != nil || !ok {
+		t.Fatalf("DismissIdentityConflict = %v, %v", ok, err)
+	}
+	if host, _ = repo.GetByIDAndOrganization(ctx, host.ID, orgID); host.IdentityConflictAt != nil {
+		t.Fatalf("conflict not dismissed")
+	}
+	// Dismissing clears the history, so the next enrollment isn't flagged.
+	if _, err := repo.Enroll(ctx, "flapping", nil, orgID); err != nil {
+		t.Fatalf("Enroll: %v", err)
+	}
+	if host, _ = repo.GetByIDAndOrganization(ctx, host.ID, orgID); host.IdentityConflictAt != nil {
+		t.Fatalf("host flagged again after dismissal")
+	}
+	if ok, err := repo.DismissIdentityConflict(ctx, host.ID, uuid.New()); err != nil || ok {
+		t.Fatalf("DismissIdentityConflict(other org) = %v, %v", ok, err)
+	}
+}
+
+func TestHostRepository_IdentityStrategy(t *testing.T) {
+	tdb := testdb.SetupTestDB(t)
+	ctx := context.Background()
+	repo := services.NewHostRepository(tdb.Pool)
+
+	setStrategy := func(orgID uuid.UUID, strategy string) {
+		t.Helper()
+		_, err := tdb.Pool.Exec(ctx, `
+			INSERT INTO organization_settings (organization_id, host_identity) VALUES ($1, $2)
+			ON CONFLICT (organization_id) DO UPDATE SET host_identity = EXCLUDED.host_identity
+		`, orgID, strategy)
+		if err != nil {
+			t.Fatalf("setting host identity strategy: %v", err)
+		}
+	}
+	enroll := func(orgID uuid.UUID, hostIdentifier, hardwareUUID, instanceID string) *services.Host {
+		t.Helper()
+		details, _ := json.Marshal(map[string]any{
+			"system_info":  map[string]string{"uuid": hardwareUUID, "hostname": hostIdentifier},
+			"osquery_info": map[string]string{"instance_id": instanceID},
+		})
+		key, err := repo.Enroll(ctx, hostIdentifier, details, orgID)
+		if err != nil {
+			t.Fatalf("Enroll: %v", err)
+		}
+		host, err := repo.GetByNodeKey(ctx, key)
+		if err != nil || host == nil {
+			t.Fatalf("GetByNodeKey = %+v, %v", host, err)
+		}
+		return host
+	}
+
+	const machineA, machineB = "AAAAAAAA-0000-0000-0000-000000000001", "BBBBBBBB-0000-0000-0000-000000000002"
+
+	t.Run("hardware uuid", func(t *testing.T) {
+		orgID := fixtures.CreateOrg(t, tdb.Pool, "identity-hardware-org").ID
+		// Enrolled before the organization chose the strategy.
+		legacy := enroll(orgID, "web-1", machineA, "")
+		setStrategy(orgID, "hardware_uuid")
+
+		if h := enroll(orgID, "web-1", machineA, ""); h.ID != legacy.ID {
+			t.Fatalf("legacy host not adopted: %+v", h)
+		}
+		// Re-imaged under a new hostname, the machine keeps its record.
+		if h := enroll(orgID, "ip-10-0-0-5", machineA, ""); h.ID != legacy.ID || h.HostIdentifier != "ip-10-0-0-5" {
+			t.Fatalf("re-imaged machine enrolled as %+v, want host %s", h, legacy.ID)
+		}
+		// Other hardware reusing the hostname gets its own.
+		if h := enroll(orgID, "ip-10-0-0-5", machineB, ""); h.ID == legacy.ID {
+			t.Fatalf("second machine enrolled into host %s", legacy.ID)
+		}
+	})
+
+	t.Run("instance id", func(t *testing.T) {
+		orgID := fixtures.CreateOrg(t, tdb.Pool, "identity-instance-org").ID
+		setStrategy(orgID, "instance_id")
+
+		first := enroll(orgID, "asg-node", machineA, "9f2c5f7e-0000-0000-0000-000000000001")
+		// A new instance behind the same hostname is a new host...
+		second := enroll(orgID, "asg-node", machineB, "9f2c5f7e-0000-0000-0000-000000000002")
+		if second.ID == first.ID {
+			t.Fatalf("new instance enrolled into host %s", first.ID)
+		}
+		// ...while the first keeps its own.
+		if h := enroll(orgID, "asg-node", machineA, "9F2C5F7E-0000-0000-0000-000000000001"); h.ID != first.ID {
+			t.Fatalf("first instance enrolled as %+v, want host %s", h, first.ID)
+		}
+		// Without an instance ID, hosts fall back to their host identifier.
+		if h := enroll(orgID, "old-osquery", "", ""); h.HostIdentifier != "old-osquery" {
+			t.Fatalf("host without instance id = %+v", h)
+		}
+	})
+}
@@ -0,0 +1,122 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+)
+
+const (
+	// OSVersionQueryName is the ingest hook query that keeps hosts.os_version
+	// current as hosts are upgraded, instead of as they enrolled.
+	OSVersionQueryName = "queryops_os_version"
+	OSVersionQuery     = "SELECT name, version, major, minor, patch, build, platform, platform_like, codename FROM os_version;"
+
+	// SoftwareInterval is how often, in seconds, hosts report their
+	// installed software. Package lists are long and change rarely.
+	SoftwareInterval = 86400
+)
+
+// softwareHooks are the ingest hook queries that list each platform's
+// installed software into host_software, by the source it's listed in.
+var softwareHooks = []struct {
+	QueryName, Query, Platform, Source string
+}{
+	{"queryops_software_deb", "SELECT name, version FROM deb_packages;", "linux", "deb_packages"},
+	{"queryops_software_rpm", "SELECT name, version || '-' || release AS version FROM rpm_packages;", "linux", "rpm_packages"},
+	{"queryops_software_apps", "SELECT name, bundle_short_version AS version FROM apps;", "darwin", "apps"},
+	{"queryops_software_programs", "SELECT name, version FROM programs;", "windows", "programs"},
+}
+
+func init() {
+	Ingest.Register(IngestHook{
+		QueryName: OSVersionQueryName,
+		Query:     OSVersionQuery,
+		Apply:     recordOSVersion,
+	})
+	for _, s := range softwareHooks {
+		Ingest.Register(IngestHook{
+			QueryName: s.QueryName,
+			Query:     s.Query,
+			Interval:  SoftwareInterval,
+			Platform:  s.Platform,
+			Apply:     recordSoftware(s.Source),
+		})
+	}
+}
+
+// recordOSVersion merges the latest of each host's os_version vitals into
+// hosts.os_version, keeping what it reported at enrollment that the vitals
+// don't cover.
+func recordOSVersion(ctx context.Context, tx pgx.Tx, results []ResultLogEntry) error {
+	var (
+		hostIDs  []uuid.UUID
+		versions []string
+	)
+	for hostID, rows := range latestSnapshots(results) {
+		var version map[string]string
+		if err := json.Unmarshal(rows[0].Columns, &version); err != nil || version["platform"] == "" {
+			continue
+		}
+		hostIDs = append(hostIDs, hostID)
+		versions = append(versions, string(rows[0].Columns))
+	}
+	if len(hostIDs) == 0 {
+		return nil
+	}
+
+	_, err := tx.Exec(ctx, `
+		UPDATE hosts h SET
+			os_version = COALESCE(h.os_version, '{}'::jsonb) || v.os_version::jsonb,
+			updated_at = NOW()
+		FROM unnest($1::uuid[], $2::text[]) AS v(id, os_version)
+		WHERE h.id = v.id
+	`, hostIDs, versions)
+	if err != nil {
+		return fmt.Errorf("recording os version: %w", err)
+	}
+	return nil
+}
+
+// recordSoftware returns the hook that replaces each host's software listed
+// in source with its latest snapshot of it. Rows without a name are skipped.
+func recordSoftware(source string) func(context.Context, pgx.Tx, []ResultLogEntry) error {
+	return func(ctx context.Context, tx pgx.Tx, results []ResultLogEntry) error {
+		var (
+			hostIDs, softwareHostIDs []uuid.UUID
+			names, versions          []string
+		)
+		for hostID, rows := range latestSnapshots(results) {
+			hostIDs = append(hostIDs, hostID)
+			for _, row := range rows {
+				var sw struct {
+					Name    string `json:"name"`
+					Version string `json:"version"`
+				}
+				if err := json.Unmarshal(row.Columns, &sw); err != nil || sw.Name == "" {
+					continue
+				}
+				softwareHostIDs = append(softwareHostIDs, hostID)
+				names = append(names, sw.Name)
+				versions = append(versions, sw.Version)
+			}
+		}
+
+		if _, err := tx.Exec(ctx, `DELETE FROM host_software WHERE host_id = ANY($1) AND source = $2`, hostIDs, source); err != nil {
+			return fmt.Errorf("clearing %s software: %w", source, err)
+		}
+		_, err := tx.Exec(ctx, `
+			INSERT INTO host_software (host_id, source, name, version)
+			SELECT v.host_id, $4, v.name, v.version
+			FROM unnest($1::uuid[], $2::text[], $3::text[]) AS v(host_id, name, version)
+			ON CONFLICT DO NOTHING
+		`, softwareHostIDs, names, versions, source)
+		if err != nil {
+			return fmt.Errorf("recording %s software: %w", source, err)
+		}
+		return nil
+	}
+}
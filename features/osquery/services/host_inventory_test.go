@@ -0,0 +1,81 @@
+package services_test
+
+import (
+	"context"
+	"encoding/json"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/cavenine/queryops/features/osquery/services"
+	"github.com/cavenine/queryops/internal/testdb"
+	"github.com/cavenine/queryops/internal/testdb/fixtures"
+)
+
+func TestHostRepository_InventoryHooks(t *testing.T) {
+	tdb := testdb.SetupTestDB(t)
+	ctx := context.Background()
+
+	orgID := fixtures.CreateOrg(t, tdb.Pool, "inventory-org").ID
+	host := fixtures.CreateHost(t, tdb.Pool, orgID, "host-a")
+	repo := services.NewHostRepository(tdb.Pool)
+	if _, err := tdb.Pool.Exec(ctx, `UPDATE hosts SET os_version = '{"name":"Ubuntu","version":"22.04","arch":"x86_64"}' WHERE id = $1`, host.ID); err != nil {
+		t.Fatalf("setting os version: %v", err)
+	}
+
+	now := time.Now().Truncate(time.Second)
+	row := func(name string, at time.Time, columns string) services.ResultLogEntry {
+		return services.ResultLogEntry{HostID: host.ID, Name: name, Action: "snapshot", Columns: json.RawMessage(columns), Timestamp: at}
+	}
+	software := func() []string {
+		t.Helper()
+		rows, err := tdb.Pool.Query(ctx, `SELECT source || ':' || name || '=' || version FROM host_software WHERE host_id = $1 ORDER BY 1`, host.ID)
+		if err != nil {
+			t.Fatalf("querying software: %v", err)
+		}
+		var got []string
+		for rows.Next() {
+			var s string
+			if err := rows.Scan(&s); err != nil {
+				t.Fatalf("scanning software: %v", err)
+			}
+			got = append(got, s)
+		}
+		return got
+	}
+
+	// The latest snapshot in a batch wins, whatever the order.
+	err := repo.SaveLogBatch(ctx, []services.ResultLogEntry{
+		row(services.OSVersionQueryName, now, `{"name":"Ubuntu","version":"24.04","platform":"ubuntu","platform_like":"debian"}`),
+		row(services.OSVersionQueryName, now.Add(-time.Hour), `{"name":"Ubuntu","version":"23.10","platform":"ubuntu"}`),
+		row("queryops_software_deb", now, `{"name":"curl","version":"8.5.0"}`),
+		row("queryops_software_deb", now, `{"name":"openssl","version":"3.0.13"}`),
+		row("queryops_software_deb", now.Add(-time.Hour), `{"name":"telnet","version":"0.17"}`),
+		row("queryops_software_rpm", now, `{"name":"","version":"1"}`),
+	}, nil)
+	if err != nil {
+		t.Fatalf("SaveLogBatch: %v", err)
+	}
+
+	var osVersion map[string]string
+	if err := tdb.Pool.QueryRow(ctx, `SELECT os_version FROM hosts WHERE id = $1`, host.ID).Scan(&osVersion); err != nil {
+		t.Fatalf("querying os version: %v", err)
+	}
+	// Keys the vitals don't report are kept.
+	if osVersion["version"] != "24.04" || osVersion["platform_like"] != "debian" || osVersion["arch"] != "x86_64" {
+		t.Fatalf("os_version = %v", osVersion)
+	}
+	if got, want := software(), []string{"deb_packages:curl=8.5.0", "deb_packages:openssl=3.0.13"}; !slices.Equal(got, want) {
+		t.Fatalf("software = %v, want %v", got, want)
+	}
+
+	// A later snapshot replaces the host's software from the same source.
+	if err := repo.SaveLogBatch(ctx, []services.ResultLogEntry{
+		row("queryops_software_deb", now.Add(time.Hour), `{"name":"curl","version":"8.5.1"}`),
+	}, nil); err != nil {
+		t.Fatalf("SaveLogBatch: %v", err)
+	}
+	if got, want := software(), []string{"deb_packages:curl=8.5.1"}; !slices.Equal(got, want) {
+		t.Fatalf("software after a new snapshot = %v, want %v", got, want)
+	}
+}
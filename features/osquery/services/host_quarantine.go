@@ -0,0 +1,165 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+
+	"github.com/cavenine/queryops/internal/outbox"
+	"github.com/cavenine/queryops/internal/pubsub"
+)
+
+// QuarantineConfigName is the osquery config quarantined hosts are served.
+const QuarantineConfigName = "quarantine"
+
+var (
+	ErrHostQuarantined         = errors.New("host is already quarantined")
+	ErrHostNotQuarantined      = errors.New("host is not quarantined")
+	ErrQuarantineConfigMissing = errors.New(`there is no "quarantine" osquery config to serve quarantined hosts`)
+)
+
+// HostQuarantine is a period a host was served the quarantine config.
+type HostQuarantine struct {
+	ID                 uuid.UUID  `db:"id"`
+	HostID             uuid.UUID  `db:"host_id"`
+	Reason             string     `db:"reason"`
+	QuarantinedByEmail *string    `db:"quarantined_by_email"`
+	QuarantinedAt      time.Time  `db:"quarantined_at"`
+	ReleasedByEmail    *string    `db:"released_by_email"`
+	ReleasedAt         *time.Time `db:"released_at"`
+}
+
+// QuarantineHost points the organization's host at the quarantine config,
+// remembering the config it had for ReleaseHost to restore, and tags the
+// results it returns until it's released. The host picks up the config on
+// its next config refresh.
+func (r *HostRepository) QuarantineHost(ctx context.Context, organizationID, hostID uuid.UUID, reason string, quarantinedBy *int) (uuid.UUID, error) {
+	tx, err := r.pool.Begin(ctx)
+	if err != nil {
+		return uuid.Nil, fmt.Errorf("quarantining host: begin transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	var configID int
+	err = tx.QueryRow(ctx, `SELECT id FROM osquery_configs WHERE name = $1`, QuarantineConfigName).Scan(&configID)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return uuid.Nil, ErrQuarantineConfigMissing
+	}
+	if err != nil {
+		return uuid.Nil, fmt.Errorf("quarantining host: getting quarantine config: %w", err)
+	}
+
+	var (
+		previousConfigID *int
+		quarantineID     *uuid.UUID
+	)
+	err = tx.QueryRow(ctx, `
+		SELECT config_id, quarantine_id FROM hosts
+		WHERE id = $1 AND organization_id = $2
+		FOR UPDATE
+	`, hostID, organizationID).Scan(&previousConfigID, &quarantineID)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return uuid.Nil, ErrHostNotFound
+	}
+	if err != nil {
+		return uuid.Nil, fmt.Errorf("quarantining host: %w", err)
+	}
+	if quarantineID != nil {
+		return uuid.Nil, ErrHostQuarantined
+	}
+
+	var id uuid.UUID
+	err = tx.QueryRow(ctx, `
+		INSERT INTO host_quarantines (organization_id, host_id, reason, previous_config_id, quarantined_by)
+		VALUES ($1, $2, $3, $4, $5)
+		RETURNING id
+	`, organizationID, hostID, reason, previousConfigID, quarantinedBy).Scan(&id)
+	if err != nil {
+		return uuid.Nil, fmt.Errorf("quarantining host: %w", err)
+	}
+	if _, err := tx.Exec(ctx, `
+		UPDATE hosts SET config_id = $2, quarantine_id = $3, updated_at = NOW() WHERE id = $1
+	`, hostID, configID, id); err != nil {
+		return uuid.Nil, fmt.Errorf("quarantining host: assigning quarantine config: %w", err)
+	}
+	if err := outbox.Insert(ctx, tx, hostActivity(hostID, pubsub.HostActivityQuarantined, uuid.Nil, reason)); err != nil {
+		return uuid.Nil, fmt.Errorf("quarantining host: %w", err)
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return uuid.Nil, fmt.Errorf("quarantining host: commit transaction: %w", err)
+	}
+	return id, nil
+}
+
+// ReleaseHost ends the organization's host's quarantine and points it back
+// at the config it had before, or the one assigned to it since.
+func (r *HostRepository) ReleaseHost(ctx context.Context, organizationID, hostID uuid.UUID, releasedBy *int) error {
+	tx, err := r.pool.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("releasing host: begin transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	var previousConfigID *int
+	err = tx.QueryRow(ctx, `
+		UPDATE host_quarantines q
+		SET released_at = NOW(), released_by = $3
+		FROM hosts h
+		WHERE h.id = $1 AND h.organization_id = $2 AND q.id = h.quarantine_id
+		RETURNING q.previous_config_id
+	`, hostID, organizationID, releasedBy).Scan(&previousConfigID)
+	if errors.Is(err, pgx.ErrNoRows) {
+		var exists bool
+		if err := tx.QueryRow(ctx, `
+			SELECT EXISTS (SELECT 1 FROM hosts WHERE id = $1 AND organization_id = $2)
+		`, hostID, organizationID).Scan(&exists); err != nil {
+			return fmt.Errorf("releasing host: %w", err)
+		}
+		if !exists {
+			return ErrHostNotFound
+		}
+		return ErrHostNotQuarantined
+	}
+	if err != nil {
+		return fmt.Errorf("releasing host: %w", err)
+	}
+	if _, err := tx.Exec(ctx, `
+		UPDATE hosts SET config_id = $2, quarantine_id = NULL, updated_at = NOW() WHERE id = $1
+	`, hostID, previousConfigID); err != nil {
+		return fmt.Errorf("releasing host: restoring config: %w", err)
+	}
+	if err := outbox.Insert(ctx, tx, hostActivity(hostID, pubsub.HostActivityReleased, uuid.Nil, "")); err != nil {
+		return fmt.Errorf("releasing host: %w", err)
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return fmt.Errorf("releasing host: commit transaction: %w", err)
+	}
+	return nil
+}
+
+// ListHostQuarantines returns the host's quarantines, newest first.
+func (r *HostRepository) ListHostQuarantines(ctx context.Context, hostID uuid.UUID) ([]HostQuarantine, error) {
+	rows, err := r.pool.Query(ctx, `
+		SELECT q.id, q.host_id, q.reason, qu.email AS quarantined_by_email, q.quarantined_at,
+			ru.email AS released_by_email, q.released_at
+		FROM host_quarantines q
+		LEFT JOIN users qu ON qu.id = q.quarantined_by
+		LEFT JOIN users ru ON ru.id = q.released_by
+		WHERE q.host_id = $1
+		ORDER BY q.quarantined_at DESC
+	`, hostID)
+	if err != nil {
+		return nil, fmt.Errorf("listing host quarantines: %w", err)
+	}
+	quarantines, err := pgx.CollectRows(rows, pgx.RowToStructByName[HostQuarantine])
+	if err != nil {
+		return nil, fmt.Errorf("listing host quarantines: %w", err)
+	}
+	return quarantines, nil
+}
@@ -0,0 +1,91 @@
+package services_test
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/cavenine/queryops/features/osquery/services"
+	"github.com/cavenine/queryops/internal/testdb"
+	"github.com/cavenine/queryops/internal/testdb/fixtures"
+)
+
+func TestHostRepository_Quarantine(t *testing.T) {
+	tdb := testdb.SetupTestDB(t)
+	ctx := context.Background()
+
+	orgID := fixtures.CreateOrg(t, tdb.Pool, "quarantine-org").ID
+	otherOrgID := fixtures.CreateOrg(t, tdb.Pool, "other-org").ID
+	host := fixtures.CreateHost(t, tdb.Pool, orgID, "host-a")
+	user := fixtures.CreateUser(t, tdb.Pool, "analyst@example.com")
+	repo := services.NewHostRepository(tdb.Pool)
+
+	if err := repo.ReleaseHost(ctx, orgID, host.ID, nil); !errors.Is(err, services.ErrHostNotQuarantined) {
+		t.Fatalf("ReleaseHost before quarantine err = %v", err)
+	}
+	if _, err := repo.QuarantineHost(ctx, otherOrgID, host.ID, "", nil); !errors.Is(err, services.ErrHostNotFound) {
+		t.Fatalf("QuarantineHost from another organization err = %v", err)
+	}
+
+	id, err := repo.QuarantineHost(ctx, orgID, host.ID, "beaconing", &user.ID)
+	if err != nil {
+		t.Fatalf("QuarantineHost: %v", err)
+	}
+	if _, err := repo.QuarantineHost(ctx, orgID, host.ID, "again", nil); !errors.Is(err, services.ErrHostQuarantined) {
+		t.Fatalf("second QuarantineHost err = %v", err)
+	}
+
+	config, err := repo.GetConfigForHost(ctx, host.NodeKey)
+	if err != nil {
+		t.Fatalf("GetConfigForHost: %v", err)
+	}
+	if !strings.Contains(string(config), "quarantine-detection") {
+		t.Fatalf("quarantined host config = %s", config)
+	}
+
+	now := time.Now()
+	if err := repo.SaveResultLogs(ctx, host.ID, "processes", "added", []byte(`{"pid":"1"}`), now); err != nil {
+		t.Fatalf("SaveResultLogs: %v", err)
+	}
+	events, err := repo.ListScheduledResultEvents(ctx, host.ID, "processes", now.Add(-time.Minute), now.Add(time.Minute), 0)
+	if err != nil || len(events) != 1 {
+		t.Fatalf("ListScheduledResultEvents = %+v, %v", events, err)
+	}
+	if events[0].QuarantineID == nil || *events[0].QuarantineID != id {
+		t.Fatalf("result quarantine_id = %v, want %v", events[0].QuarantineID, id)
+	}
+
+	// A config assigned while quarantined is what the host gets on release.
+	var configID int
+	err = tdb.Pool.QueryRow(ctx, `INSERT INTO osquery_configs (name, config) VALUES ('strict', '{"options":{}}') RETURNING id`).Scan(&configID)
+	if err != nil {
+		t.Fatalf("inserting config: %v", err)
+	}
+	if n, err := repo.AssignConfig(ctx, orgID, []uuid.UUID{host.ID}, &configID); err != nil || n != 1 {
+		t.Fatalf("AssignConfig = %d, %v", n, err)
+	}
+	if config, err := repo.GetConfigForHost(ctx, host.NodeKey); err != nil || !strings.Contains(string(config), "quarantine-detection") {
+		t.Fatalf("config after AssignConfig = %s, %v; want the quarantine config", config, err)
+	}
+
+	if err := repo.ReleaseHost(ctx, orgID, host.ID, &user.ID); err != nil {
+		t.Fatalf("ReleaseHost: %v", err)
+	}
+	if config, err := repo.GetConfigForHost(ctx, host.NodeKey); err != nil || string(config) != `{"options": {}}` {
+		t.Fatalf("config after release = %s, %v", config, err)
+	}
+
+	quarantines, err := repo.ListHostQuarantines(ctx, host.ID)
+	if err != nil || len(quarantines) != 1 {
+		t.Fatalf("ListHostQuarantines = %+v, %v", quarantines, err)
+	}
+	q := quarantines[0]
+	if q.ID != id || q.Reason != "beaconing" || q.ReleasedAt == nil ||
+		q.QuarantinedByEmail == nil || *q.QuarantinedByEmail != "analyst@example.com" {
+		t.Fatalf("quarantine = %+v", q)
+	}
+}
@@ -2,31 +2,96 @@ package services
 
 import (
 	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"slices"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/google/uuid"
 	"github.com/jackc/pgx/v5"
 	"github.com/jackc/pgx/v5/pgxpool"
+
+	"github.com/cavenine/queryops/db"
+	"github.com/cavenine/queryops/features/osquery/schema"
+	"github.com/cavenine/queryops/internal/outbox"
+	"github.com/cavenine/queryops/internal/pubsub"
 )
 
 type Host struct {
-	ID             uuid.UUID
-	OrganizationID uuid.UUID
-	HostIdentifier string
-	NodeKey        string
-	OSVersion      json.RawMessage
-	OsqueryInfo    json.RawMessage
-	SystemInfo     json.RawMessage
-	PlatformInfo   json.RawMessage
-
-	LastEnrollmentAt  time.Time
-	LastConfigAt      *time.Time
-	LastLoggerAt      *time.Time
-	LastDistributedAt *time.Time
-	CreatedAt         time.Time
-	UpdatedAt         time.Time
+	ID             uuid.UUID `db:"id"`
+	OrganizationID uuid.UUID `db:"organization_id"`
+	HostIdentifier string    `db:"host_identifier"`
+	// NodeKeyIssuedAt is when the host's current node key was issued. The
+	// key itself is only stored hashed.
+	NodeKeyIssuedAt time.Time       `db:"node_key_issued_at"`
+	OSVersion       json.RawMessage `db:"os_version"`
+	OsqueryInfo     json.RawMessage `db:"osquery_info"`
+	SystemInfo      json.RawMessage `db:"system_info"`
+	PlatformInfo    json.RawMessage `db:"platform_info"`
+
+	LastEnrollmentAt  time.Time  `db:"last_enrollment_at"`
+	LastConfigAt      *time.Time `db:"last_config_at"`
+	LastLoggerAt      *time.Time `db:"last_logger_at"`
+	LastDistributedAt *time.Time `db:"last_distributed_at"`
+	// LastSeenAt is when the host last called /osquery/ping.
+	LastSeenAt *time.Time `db:"last_seen_at"`
+	CreatedAt  time.Time  `db:"created_at"`
+	UpdatedAt  time.Time  `db:"updated_at"`
+
+	// HardwareUUID is the system_info uuid the host last enrolled with.
+	HardwareUUID string `db:"hardware_uuid"`
+	// IdentityConflictAt is when the host was flagged as more than one
+	// machine enrolling under its host identifier, until it's resolved.
+	IdentityConflictAt *time.Time `db:"identity_conflict_at"`
+
+	// OsqueryVersion, OsqueryConfigHash, and OsqueryExtensions are from the
+	// osquery_info the host last reported, at enrollment or in its vitals;
+	// "" until it has.
+	OsqueryVersion    string `db:"osquery_version"`
+	OsqueryConfigHash string `db:"osquery_config_hash"`
+	OsqueryExtensions string `db:"osquery_extensions"`
+
+	// SigningSalt is what the host's request signing key is derived from,
+	// or nil until it's issued one; see requestsign. SignedRequestAt is when
+	// it last sent a config request signed with that key.
+	SigningSalt     []byte     `db:"signing_salt"`
+	SignedRequestAt *time.Time `db:"signed_request_at"`
+
+	// QuarantineID is the host's open quarantine, if it's quarantined; the
+	// results it returns meanwhile are tagged with it.
+	QuarantineID *uuid.UUID `db:"quarantine_id"`
+}
+
+// hostColumns selects a Host, for pgx.RowToAddrOfStructByName.
+var hostColumns = db.Columns[Host]()
+
+// LastCheckIn is the later of the host's last logger write and ping, which
+// decides whether it's online, or nil if it has done neither.
+func (h *Host) LastCheckIn() *time.Time {
+	if h.LastSeenAt != nil && (h.LastLoggerAt == nil || h.LastSeenAt.After(*h.LastLoggerAt)) {
+		return h.LastSeenAt
+	}
+	return h.LastLoggerAt
+}
+
+// Platform returns the schema platform for the host's os_version, or "" if it
+// is unknown.
+func (h *Host) Platform() string {
+	var osVersion struct {
+		Platform     string `json:"platform"`
+		PlatformLike string `json:"platform_like"`
+	}
+	if err := json.Unmarshal(h.OSVersion, &osVersion); err != nil {
+		return ""
+	}
+	return schema.Platform(osVersion.Platform, osVersion.PlatformLike)
 }
 
 type HostRepository struct {
@@ -37,30 +102,144 @@ func NewHostRepository(pool *pgxpool.Pool) *HostRepository {
 	return &HostRepository{pool: pool}
 }
 
+// NodeKeyGracePeriod is how long a host's previous node key keeps working
+// after it enrolls again, so requests osquery sent with the old key before
+// re-enrolling still succeed.
+const NodeKeyGracePeriod = 5 * time.Minute
+
+// nodeKeyMatch matches the host holding node key hash $1, current or previous.
+// Use it only after GetByNodeKey has authenticated the key.
+const nodeKeyMatch = `(node_key_hash = $1 OR previous_node_key_hash = $1)`
+
+// HashNodeKey is how node keys are stored and looked up.
+func HashNodeKey(nodeKey string) []byte {
+	sum := sha256.Sum256([]byte(nodeKey))
+	return sum[:]
+}
+
+func generateNodeKey() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("generating node key: %w", err)
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// Enroll issues the host a new node key, creating the host if needed, and
+// returns the key. Only its hash is stored; a re-enrolling host's previous key
+// stays valid for NodeKeyGracePeriod. The host's details are updated from
+// hostDetails, and the enrollment is checked for an identity conflict. Which
+// host record the machine enrolls into follows the organization's host
+// identity strategy; see resolveEnrollment.
 func (r *HostRepository) Enroll(ctx context.Context, hostIdentifier string, hostDetails json.RawMessage, organizationID uuid.UUID) (string, error) {
-	nodeKey := uuid.New().String()
+	nodeKey, err := generateNodeKey()
+	if err != nil {
+		return "", err
+	}
+	details := parseHostDetails(hostDetails)
 
-	// Parse host details to extract info if possible, or just store as JSONB
-	// For now, we'll store the whole thing in relevant columns if they exist in the raw message
-	// or just leave them null for now and let the caller handle it.
-	// Actually, let's just store the raw message in one place or try to split it.
-	// The prompt says "For now this will include the detailed in the enrollment request."
+	tx, err := r.pool.Begin(ctx)
+	if err != nil {
+		return "", fmt.Errorf("enrolling host: begin transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
 
-	_, err := r.pool.Exec(ctx, `
-		INSERT INTO hosts (host_identifier, node_key, organization_id, last_enrollment_at, updated_at)
-		VALUES ($1, $2, $3, NOW(), NOW())
-		ON CONFLICT (organization_id, host_identifier)
-		DO UPDATE SET node_key = EXCLUDED.node_key, last_enrollment_at = NOW(), updated_at = NOW()
-	`, hostIdentifier, nodeKey, organizationID)
+	target, err := resolveEnrollment(ctx, tx, organizationID, hostIdentifier, details)
+	if err != nil {
+		return "", err
+	}
+	if target.moved() {
+		_, err = tx.Exec(ctx, `
+			UPDATE hosts SET host_identifier = $2, identity_key = $3, updated_at = NOW()
+			WHERE id = $1
+		`, target.hostID, target.hostIdentifier, target.identityKey)
+		if err != nil {
+			return "", fmt.Errorf("enrolling host: moving host: %w", err)
+		}
+	}
+
+	var hostID uuid.UUID
+	err = tx.QueryRow(ctx, `
+		INSERT INTO hosts (host_identifier, identity_key, hardware_uuid, osquery_instance_id, node_key_hash, node_key_issued_at, organization_id,
+		                   os_version, osquery_info, system_info, platform_info, osquery_version, osquery_config_hash, osquery_extensions,
+		                   last_enrollment_at, updated_at)
+		VALUES ($1, $2, $3, $4, $5, NOW(), $6, $7, $8, $9, $10, $11, $12, $13, NOW(), NOW())
+		ON CONFLICT (organization_id, host_identifier, identity_key)
+		DO UPDATE SET
+			previous_node_key_hash = hosts.node_key_hash,
+			node_key_hash = EXCLUDED.node_key_hash,
+			node_key_issued_at = NOW(),
+			hardware_uuid = COALESCE(NULLIF(EXCLUDED.hardware_uuid, ''), hosts.hardware_uuid),
+			osquery_instance_id = COALESCE(NULLIF(EXCLUDED.osquery_instance_id, ''), hosts.osquery_instance_id),
+			os_version = COALESCE(EXCLUDED.os_version, hosts.os_version),
+			osquery_info = COALESCE(EXCLUDED.osquery_info, hosts.osquery_info),
+			system_info = COALESCE(EXCLUDED.system_info, hosts.system_info),
+			platform_info = COALESCE(EXCLUDED.platform_info, hosts.platform_info),
+			osquery_version = COALESCE(NULLIF(EXCLUDED.osquery_version, ''), hosts.osquery_version),
+			osquery_config_hash = COALESCE(NULLIF(EXCLUDED.osquery_config_hash, ''), hosts.osquery_config_hash),
+			osquery_extensions = COALESCE(NULLIF(EXCLUDED.osquery_extensions, ''), hosts.osquery_extensions),
+			last_enrollment_at = NOW(),
+			updated_at = NOW()
+		RETURNING id
+	`, target.hostIdentifier, target.identityKey, details.hardwareUUID, details.instanceID, HashNodeKey(nodeKey), organizationID,
+		details.OSVersion, details.OsqueryInfo, details.SystemInfo, details.PlatformInfo,
+		details.osquery.Version, details.osquery.ConfigHash, details.osquery.Extensions,
+	).Scan(&hostID)
 	if err != nil {
 		return "", fmt.Errorf("enrolling host: %w", err)
 	}
 
+	if err := recordEnrollment(ctx, tx, hostID, target.hardwareUUID, details); err != nil {
+		return "", err
+	}
+	if err := outbox.Insert(ctx, tx, hostActivity(hostID, pubsub.HostActivityEnrolled, uuid.Nil, "")); err != nil {
+		return "", fmt.Errorf("enrolling host: %w", err)
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return "", fmt.Errorf("enrolling host: commit: %w", err)
+	}
 	return nodeKey, nil
 }
 
+// keyedHost is a Host with its node key hashes, which GetByNodeKey checks.
+type keyedHost struct {
+	Host
+	Current  []byte `db:"node_key_hash"`
+	Previous []byte `db:"previous_node_key_hash"`
+}
+
+var keyedHostColumns = db.Columns[keyedHost]()
+
+// GetByNodeKey returns the host holding nodeKey, or nil if no host does. A
+// host's previous key is accepted for NodeKeyGracePeriod after it was
+// replaced.
 func (r *HostRepository) GetByNodeKey(ctx context.Context, nodeKey string) (*Host, error) {
-	return r.getBy(ctx, "node_key", nodeKey)
+	hash := HashNodeKey(nodeKey)
+
+	rows, err := r.pool.Query(ctx, `
+		SELECT `+keyedHostColumns+`
+		FROM hosts
+		WHERE node_key_hash = $1
+		   OR (previous_node_key_hash = $1 AND node_key_issued_at > NOW() - make_interval(secs => $2))
+		LIMIT 1
+	`, hash, NodeKeyGracePeriod.Seconds())
+	if err != nil {
+		return nil, fmt.Errorf("getting host by node key: %w", err)
+	}
+	h, err := pgx.CollectOneRow(rows, pgx.RowToAddrOfStructByName[keyedHost])
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("getting host by node key: %w", err)
+	}
+	// The index lookup found the row; confirm the match without a
+	// data-dependent comparison before trusting it.
+	if subtle.ConstantTimeCompare(hash, h.Current)|subtle.ConstantTimeCompare(hash, h.Previous) != 1 {
+		return nil, nil
+	}
+	return &h.Host, nil
 }
 
 func (r *HostRepository) GetByID(ctx context.Context, id uuid.UUID) (*Host, error) {
@@ -68,65 +247,102 @@ func (r *HostRepository) GetByID(ctx context.Context, id uuid.UUID) (*Host, erro
 }
 
 func (r *HostRepository) getBy(ctx context.Context, column string, value any) (*Host, error) {
-	var h Host
-	query := fmt.Sprintf(`
-		SELECT id, organization_id, host_identifier, node_key, os_version, osquery_info, system_info, platform_info,
-		       last_enrollment_at, last_config_at, last_logger_at, last_distributed_at, created_at, updated_at
-		FROM hosts WHERE %s = $1
-	`, column)
-	err := r.pool.QueryRow(ctx, query, value).Scan(
-		&h.ID, &h.OrganizationID, &h.HostIdentifier, &h.NodeKey, &h.OSVersion, &h.OsqueryInfo, &h.SystemInfo, &h.PlatformInfo,
-		&h.LastEnrollmentAt, &h.LastConfigAt, &h.LastLoggerAt, &h.LastDistributedAt, &h.CreatedAt, &h.UpdatedAt,
-	)
+	rows, err := r.pool.Query(ctx, `SELECT `+hostColumns+` FROM hosts WHERE `+column+` = $1`, value)
+	if err != nil {
+		return nil, fmt.Errorf("getting host by %s: %w", column, err)
+	}
+	h, err := pgx.CollectOneRow(rows, pgx.RowToAddrOfStructByName[Host])
 	if err != nil {
 		if err == pgx.ErrNoRows {
 			return nil, nil
 		}
 		return nil, fmt.Errorf("getting host by %s: %w", column, err)
 	}
-	return &h, nil
+	return h, nil
 }
 
+// UpdateLastConfig records that the host holding nodeKey fetched its config.
+// The fetch is added to the host's timeline if none was in the last
+// configFetchedInterval.
 func (r *HostRepository) UpdateLastConfig(ctx context.Context, nodeKey string) error {
-	_, err := r.pool.Exec(ctx, `UPDATE hosts SET last_config_at = NOW(), updated_at = NOW() WHERE node_key = $1`, nodeKey)
-	return err
+	tx, err := r.pool.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("updating last config: begin transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	var hostID uuid.UUID
+	var previous *time.Time
+	err = tx.QueryRow(ctx, `
+		UPDATE hosts h SET last_config_at = NOW(), updated_at = NOW()
+		FROM (SELECT id, last_config_at FROM hosts WHERE `+nodeKeyMatch+` FOR UPDATE) old
+		WHERE h.id = old.id
+		RETURNING h.id, old.last_config_at
+	`, HashNodeKey(nodeKey)).Scan(&hostID, &previous)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("updating last config: %w", err)
+	}
+
+	if previous == nil || time.Since(*previous) >= configFetchedInterval {
+		if err := outbox.Insert(ctx, tx, hostActivity(hostID, pubsub.HostActivityConfigFetched, uuid.Nil, "")); err != nil {
+			return fmt.Errorf("updating last config: %w", err)
+		}
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return fmt.Errorf("updating last config: commit transaction: %w", err)
+	}
+	return nil
 }
 
 func (r *HostRepository) UpdateLastLogger(ctx context.Context, nodeKey string) error {
-	_, err := r.pool.Exec(ctx, `UPDATE hosts SET last_logger_at = NOW(), updated_at = NOW() WHERE node_key = $1`, nodeKey)
+	_, err := r.pool.Exec(ctx, `UPDATE hosts SET last_logger_at = NOW(), updated_at = NOW() WHERE `+nodeKeyMatch, HashNodeKey(nodeKey))
 	return err
 }
 
 func (r *HostRepository) UpdateLastDistributed(ctx context.Context, nodeKey string) error {
-	_, err := r.pool.Exec(ctx, `UPDATE hosts SET last_distributed_at = NOW(), updated_at = NOW() WHERE node_key = $1`, nodeKey)
+	_, err := r.pool.Exec(ctx, `UPDATE hosts SET last_distributed_at = NOW(), updated_at = NOW() WHERE `+nodeKeyMatch, HashNodeKey(nodeKey))
 	return err
 }
 
+// Ping records that the host holding nodeKey is up. Unlike the other
+// check-ins it doesn't read the host first. It returns the host with only
+// its ID, organization, host identifier, LastLoggerAt, and LastSeenAt set,
+// the last two from before the ping, or nil if the key isn't valid.
+func (r *HostRepository) Ping(ctx context.Context, nodeKey string) (*Host, error) {
+	var h Host
+	err := r.pool.QueryRow(ctx, `
+		UPDATE hosts h
+		SET last_seen_at = NOW()
+		FROM hosts old
+		WHERE old.id = h.id
+		  AND (h.node_key_hash = $1
+		   OR (h.previous_node_key_hash = $1 AND h.node_key_issued_at > NOW() - make_interval(secs => $2)))
+		RETURNING h.id, h.organization_id, h.host_identifier, old.last_logger_at, old.last_seen_at
+	`, HashNodeKey(nodeKey), NodeKeyGracePeriod.Seconds()).Scan(&h.ID, &h.OrganizationID, &h.HostIdentifier, &h.LastLoggerAt, &h.LastSeenAt)
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("pinging host: %w", err)
+	}
+	return &h, nil
+}
+
 func (r *HostRepository) List(ctx context.Context) ([]*Host, error) {
 	rows, err := r.pool.Query(ctx, `
-		SELECT id, organization_id, host_identifier, node_key, os_version, osquery_info, system_info, platform_info,
-		       last_enrollment_at, last_config_at, last_logger_at, last_distributed_at, created_at, updated_at
+		SELECT `+hostColumns+`
 		FROM hosts
-		ORDER BY last_logger_at DESC NULLS LAST
+		ORDER BY GREATEST(last_logger_at, last_seen_at) DESC NULLS LAST
 	`)
 	if err != nil {
 		return nil, fmt.Errorf("listing hosts: %w", err)
 	}
-	defer rows.Close()
-
-	var hosts []*Host
-	for rows.Next() {
-		var h Host
-		err := rows.Scan(
-			&h.ID, &h.OrganizationID, &h.HostIdentifier, &h.NodeKey, &h.OSVersion, &h.OsqueryInfo, &h.SystemInfo, &h.PlatformInfo,
-			&h.LastEnrollmentAt, &h.LastConfigAt, &h.LastLoggerAt, &h.LastDistributedAt, &h.CreatedAt, &h.UpdatedAt,
-		)
-		if err != nil {
-			return nil, fmt.Errorf("scanning host: %w", err)
-		}
-		hosts = append(hosts, &h)
-	}
-	if err := rows.Err(); err != nil {
+	hosts, err := pgx.CollectRows(rows, pgx.RowToAddrOfStructByName[Host])
+	if err != nil {
 		return nil, fmt.Errorf("listing hosts: %w", err)
 	}
 	return hosts, nil
@@ -134,59 +350,46 @@ func (r *HostRepository) List(ctx context.Context) ([]*Host, error) {
 
 func (r *HostRepository) ListByOrganization(ctx context.Context, organizationID uuid.UUID) ([]*Host, error) {
 	rows, err := r.pool.Query(ctx, `
-		SELECT id, organization_id, host_identifier, node_key, os_version, osquery_info, system_info, platform_info,
-		       last_enrollment_at, last_config_at, last_logger_at, last_distributed_at, created_at, updated_at
+		SELECT `+hostColumns+`
 		FROM hosts
 		WHERE organization_id = $1
-		ORDER BY last_logger_at DESC NULLS LAST
+		ORDER BY GREATEST(last_logger_at, last_seen_at) DESC NULLS LAST
 	`, organizationID)
 	if err != nil {
 		return nil, fmt.Errorf("listing hosts by organization: %w", err)
 	}
-	defer rows.Close()
-
-	var hosts []*Host
-	for rows.Next() {
-		var h Host
-		err := rows.Scan(
-			&h.ID, &h.OrganizationID, &h.HostIdentifier, &h.NodeKey, &h.OSVersion, &h.OsqueryInfo, &h.SystemInfo, &h.PlatformInfo,
-			&h.LastEnrollmentAt, &h.LastConfigAt, &h.LastLoggerAt, &h.LastDistributedAt, &h.CreatedAt, &h.UpdatedAt,
-		)
-		if err != nil {
-			return nil, fmt.Errorf("scanning host: %w", err)
-		}
-		hosts = append(hosts, &h)
-	}
-	if err := rows.Err(); err != nil {
+	hosts, err := pgx.CollectRows(rows, pgx.RowToAddrOfStructByName[Host])
+	if err != nil {
 		return nil, fmt.Errorf("listing hosts by organization: %w", err)
 	}
 	return hosts, nil
 }
 
 func (r *HostRepository) GetByIDAndOrganization(ctx context.Context, id uuid.UUID, organizationID uuid.UUID) (*Host, error) {
-	var h Host
-	err := r.pool.QueryRow(ctx, `
-		SELECT id, organization_id, host_identifier, node_key, os_version, osquery_info, system_info, platform_info,
-		       last_enrollment_at, last_config_at, last_logger_at, last_distributed_at, created_at, updated_at
+	rows, err := r.pool.Query(ctx, `
+		SELECT `+hostColumns+`
 		FROM hosts
 		WHERE id = $1 AND organization_id = $2
-	`, id, organizationID).Scan(
-		&h.ID, &h.OrganizationID, &h.HostIdentifier, &h.NodeKey, &h.OSVersion, &h.OsqueryInfo, &h.SystemInfo, &h.PlatformInfo,
-		&h.LastEnrollmentAt, &h.LastConfigAt, &h.LastLoggerAt, &h.LastDistributedAt, &h.CreatedAt, &h.UpdatedAt,
-	)
+	`, id, organizationID)
+	if err != nil {
+		return nil, fmt.Errorf("getting host by id and organization: %w", err)
+	}
+	h, err := pgx.CollectOneRow(rows, pgx.RowToAddrOfStructByName[Host])
 	if err != nil {
 		if err == pgx.ErrNoRows {
 			return nil, nil
 		}
 		return nil, fmt.Errorf("getting host by id and organization: %w", err)
 	}
-	return &h, nil
+	return h, nil
 }
 
+// SaveResultLogs saves a result log line, tagged with the host's open
+// quarantine.
 func (r *HostRepository) SaveResultLogs(ctx context.Context, hostID uuid.UUID, name, action string, columns json.RawMessage, timestamp time.Time) error {
 	_, err := r.pool.Exec(ctx, `
-		INSERT INTO osquery_results (host_id, name, action, columns, timestamp)
-		VALUES ($1, $2, $3, $4, $5)
+		INSERT INTO osquery_results (host_id, name, action, columns, timestamp, quarantine_id)
+		VALUES ($1, $2, $3, $4, $5, (SELECT quarantine_id FROM hosts WHERE id = $1))
 	`, hostID, name, action, columns, timestamp)
 	return err
 }
@@ -199,14 +402,221 @@ func (r *HostRepository) SaveStatusLogs(ctx context.Context, hostID uuid.UUID, l
 	return err
 }
 
+// ResultLogEntry is a single scheduled query result log line.
+type ResultLogEntry struct {
+	HostID    uuid.UUID
+	Name      string
+	Action    string
+	Columns   json.RawMessage
+	Timestamp time.Time
+	// QuarantineID tags the line with the quarantine the host was in.
+	QuarantineID *uuid.UUID
+	// Decorations are the decorator values osquery sent with the line, or
+	// nil if it sent none.
+	Decorations json.RawMessage
+}
+
+// StatusLogEntry is a single osquery status log line.
+type StatusLogEntry struct {
+	HostID    uuid.UUID
+	Line      int
+	Message   string
+	Severity  int
+	Filename  string
+	CreatedAt time.Time
+}
+
+// SaveLogBatch persists result and status logs in a single transaction and
+// bumps last_logger_at for every host that contributed to the batch. The
+// results of ingest hook queries are also handed to their hooks; see
+// IngestHook.
+func (r *HostRepository) SaveLogBatch(ctx context.Context, results []ResultLogEntry, statuses []StatusLogEntry) error {
+	if len(results) == 0 && len(statuses) == 0 {
+		return nil
+	}
+
+	tx, err := r.pool.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("beginning log batch: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	hostIDs, err := copyLogBatch(ctx, tx, results, statuses)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(ctx, `
+		UPDATE hosts SET last_logger_at = NOW(), updated_at = NOW()
+		WHERE id = ANY($1)
+	`, hostIDs)
+	if err != nil {
+		return fmt.Errorf("updating last logger: %w", err)
+	}
+	if err := Ingest.apply(ctx, tx, results); err != nil {
+		return err
+	}
+
+	return tx.Commit(ctx)
+}
+
+// ImportLogBatch persists logs replayed from an archive. Unlike
+// SaveLogBatch it leaves hosts' last_logger_at alone, and it skips the
+// entries of hosts that no longer exist, returning how many it skipped.
+func (r *HostRepository) ImportLogBatch(ctx context.Context, results []ResultLogEntry, statuses []StatusLogEntry) (int, error) {
+	if len(results) == 0 && len(statuses) == 0 {
+		return 0, nil
+	}
+
+	tx, err := r.pool.Begin(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("beginning log import: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	seen := make(map[uuid.UUID]struct{})
+	var hostIDs []uuid.UUID
+	for _, e := range results {
+		if _, ok := seen[e.HostID]; !ok {
+			seen[e.HostID] = struct{}{}
+			hostIDs = append(hostIDs, e.HostID)
+		}
+	}
+	for _, e := range statuses {
+		if _, ok := seen[e.HostID]; !ok {
+			seen[e.HostID] = struct{}{}
+			hostIDs = append(hostIDs, e.HostID)
+		}
+	}
+	// Lock the hosts so none is deleted before the copy commits.
+	rows, err := tx.Query(ctx, `SELECT id FROM hosts WHERE id = ANY($1) FOR KEY SHARE`, hostIDs)
+	if err != nil {
+		return 0, fmt.Errorf("querying hosts to import logs for: %w", err)
+	}
+	existing, err := pgx.CollectRows(rows, pgx.RowTo[uuid.UUID])
+	if err != nil {
+		return 0, fmt.Errorf("querying hosts to import logs for: %w", err)
+	}
+	exists := make(map[uuid.UUID]bool, len(existing))
+	for _, id := range existing {
+		exists[id] = true
+	}
+
+	total := len(results) + len(statuses)
+	results = slices.DeleteFunc(results, func(e ResultLogEntry) bool { return !exists[e.HostID] })
+	statuses = slices.DeleteFunc(statuses, func(e StatusLogEntry) bool { return !exists[e.HostID] })
+	skipped := total - len(results) - len(statuses)
+
+	if _, err := copyLogBatch(ctx, tx, results, statuses); err != nil {
+		return 0, err
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return 0, fmt.Errorf("committing log import: %w", err)
+	}
+	return skipped, nil
+}
+
+// copyLogBatch copies logs into their tables and returns the hosts they
+// came from.
+func copyLogBatch(ctx context.Context, tx pgx.Tx, results []ResultLogEntry, statuses []StatusLogEntry) ([]uuid.UUID, error) {
+	seen := make(map[uuid.UUID]struct{})
+	var hostIDs []uuid.UUID
+	touch := func(id uuid.UUID) {
+		if _, ok := seen[id]; !ok {
+			seen[id] = struct{}{}
+			hostIDs = append(hostIDs, id)
+		}
+	}
+
+	if len(results) > 0 {
+		_, err := tx.CopyFrom(
+			ctx,
+			pgx.Identifier{"osquery_results"},
+			[]string{"host_id", "name", "action", "columns", "timestamp", "quarantine_id", "decorations"},
+			pgx.CopyFromSlice(len(results), func(i int) ([]any, error) {
+				e := results[i]
+				touch(e.HostID)
+				return []any{e.HostID, e.Name, e.Action, e.Columns, e.Timestamp, e.QuarantineID, e.Decorations}, nil
+			}),
+		)
+		if err != nil {
+			return nil, fmt.Errorf("copying result logs: %w", err)
+		}
+	}
+
+	if len(statuses) > 0 {
+		_, err := tx.CopyFrom(
+			ctx,
+			pgx.Identifier{"osquery_status_logs"},
+			[]string{"host_id", "line", "message", "severity", "filename", "created_at"},
+			pgx.CopyFromSlice(len(statuses), func(i int) ([]any, error) {
+				e := statuses[i]
+				touch(e.HostID)
+				return []any{e.HostID, e.Line, e.Message, e.Severity, e.Filename, e.CreatedAt}, nil
+			}),
+		)
+		if err != nil {
+			return nil, fmt.Errorf("copying status logs: %w", err)
+		}
+	}
+
+	return hostIDs, nil
+}
+
+// GetConfigForHost returns the config assigned to the host, as written, or
+// else the shared default config with the osquery intervals set in its
+// organization's settings. Either way, the packs its organization enabled
+// from the query library are added to the config's packs, and the
+// decorators it chose to the config's load decorators, less any the config
+// already has.
 func (r *HostRepository) GetConfigForHost(ctx context.Context, nodeKey string) (json.RawMessage, error) {
 	var config json.RawMessage
 	err := r.pool.QueryRow(ctx, `
-		SELECT c.config 
-		FROM osquery_configs c
-		JOIN hosts h ON h.config_id = c.id
-		WHERE h.node_key = $1
-	`, nodeKey).Scan(&config)
+		SELECT CASE
+			WHEN p.packs IS NULL THEN dc.config
+			ELSE jsonb_set(dc.config, '{packs}', COALESCE(dc.config->'packs', '{}') || p.packs)
+		END
+		FROM hosts h
+		LEFT JOIN osquery_configs c ON c.id = h.config_id
+		LEFT JOIN osquery_configs d ON d.name = 'default'
+		LEFT JOIN organization_settings s ON s.organization_id = h.organization_id
+		CROSS JOIN LATERAL (
+			SELECT CASE
+				WHEN c.id IS NOT NULL THEN c.config
+				ELSE jsonb_set(d.config, '{options}', COALESCE(d.config->'options', '{}') || jsonb_strip_nulls(jsonb_build_object(
+					'distributed_interval', s.distributed_interval,
+					'config_refresh', s.config_refresh,
+					'logger_tls_period', s.logger_tls_period
+				)))
+			END AS config
+		) cfg
+		CROSS JOIN LATERAL (
+			SELECT jsonb_agg(to_jsonb(q.query) ORDER BY dn.ord) AS load
+			FROM unnest(s.decorators) WITH ORDINALITY AS dn(name, ord)
+			CROSS JOIN LATERAL (
+				SELECT CASE dn.name
+					WHEN 'hostname' THEN 'SELECT hostname AS hostname FROM system_info;'
+					WHEN 'hardware_uuid' THEN 'SELECT uuid AS hardware_uuid FROM system_info;'
+					WHEN 'org_tag' THEN format('SELECT %L AS org_tag;', s.decorator_tag)
+				END AS query
+			) q
+			WHERE NOT COALESCE(cfg.config->'decorators'->'load', '[]') ? q.query
+		) dec
+		CROSS JOIN LATERAL (
+			SELECT CASE
+				WHEN dec.load IS NULL THEN cfg.config
+				ELSE jsonb_set(cfg.config, '{decorators}', COALESCE(cfg.config->'decorators', '{}') || jsonb_build_object(
+					'load', COALESCE(cfg.config->'decorators'->'load', '[]') || dec.load
+				))
+			END AS config
+		) dc
+		CROSS JOIN LATERAL (
+			SELECT jsonb_object_agg(op.name, op.pack) AS packs
+			FROM organization_packs op
+			WHERE op.organization_id = h.organization_id
+		) p
+		WHERE h.node_key_hash = $1 OR h.previous_node_key_hash = $1
+	`, HashNodeKey(nodeKey)).Scan(&config)
 	if err != nil {
 		if err == pgx.ErrNoRows {
 			// Return default config
@@ -221,9 +631,36 @@ func (r *HostRepository) GetConfigForHost(ctx context.Context, nodeKey string) (
 	return config, nil
 }
 
+// GetPendingQueries returns the host's pending campaign queries and marks
+// them sent. A throttled campaign's query is withheld while it has already
+// been sent to its fan-out limit of hosts within its interval, and a
+// campaign past its timeout isn't sent at all. Each query sent is added to
+// the host's timeline.
 func (r *HostRepository) GetPendingQueries(ctx context.Context, hostID uuid.UUID) (map[string]string, error) {
-	// Atomically fetch pending campaign targets and mark them sent.
-	rows, err := r.pool.Query(ctx, `
+	tx, err := r.pool.Begin(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("getting pending queries: begin transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	// Hosts polling at once would each count the same recent sends; lock the
+	// throttled campaigns so they count them one at a time. Unthrottled
+	// campaigns aren't locked.
+	if _, err := tx.Exec(ctx, `
+		SELECT c.id
+		FROM campaigns c
+		JOIN campaign_targets t ON t.campaign_id = c.id
+		WHERE t.host_id = $1
+			AND t.status = 'pending'
+			AND c.status IN ('pending', 'running')
+			AND c.fanout_limit IS NOT NULL
+		ORDER BY c.id
+		FOR NO KEY UPDATE OF c
+	`, hostID); err != nil {
+		return nil, fmt.Errorf("getting pending queries: locking throttled campaigns: %w", err)
+	}
+
+	rows, err := tx.Query(ctx, `
 		WITH updated AS (
 			UPDATE campaign_targets t
 			SET status = 'sent', sent_at = NOW(), updated_at = NOW()
@@ -232,6 +669,13 @@ func (r *HostRepository) GetPendingQueries(ctx context.Context, hostID uuid.UUID
 				AND t.host_id = $1
 				AND t.status = 'pending'
 				AND c.status IN ('pending', 'running')
+				AND (c.timeout_seconds IS NULL OR c.created_at + make_interval(secs => c.timeout_seconds) > NOW())
+				AND (c.fanout_limit IS NULL OR (
+					SELECT COUNT(*)
+					FROM campaign_targets s
+					WHERE s.campaign_id = c.id
+						AND s.sent_at > NOW() - make_interval(secs => c.fanout_interval_seconds)
+				) < c.fanout_limit)
 			RETURNING t.campaign_id
 		), campaigns_running AS (
 			UPDATE campaigns c
@@ -251,6 +695,7 @@ func (r *HostRepository) GetPendingQueries(ctx context.Context, hostID uuid.UUID
 	defer rows.Close()
 
 	queries := make(map[string]string)
+	var sent []outbox.Event
 	for rows.Next() {
 		var campaignID uuid.UUID
 		var query string
@@ -258,15 +703,36 @@ func (r *HostRepository) GetPendingQueries(ctx context.Context, hostID uuid.UUID
 			return nil, fmt.Errorf("scanning pending query: %w", err)
 		}
 		queries[campaignID.String()] = query
+		sent = append(sent, hostActivity(hostID, pubsub.HostActivityQuerySent, campaignID, ""))
 	}
 	if err := rows.Err(); err != nil {
 		return nil, fmt.Errorf("iterating pending queries: %w", err)
 	}
+	rows.Close()
+
+	if err := outbox.Insert(ctx, tx, sent...); err != nil {
+		return nil, fmt.Errorf("getting pending queries: %w", err)
+	}
 
+	if err := tx.Commit(ctx); err != nil {
+		return nil, fmt.Errorf("getting pending queries: commit transaction: %w", err)
+	}
 	return queries, nil
 }
 
-func (r *HostRepository) SaveQueryResults(ctx context.Context, hostID uuid.UUID, queryID uuid.UUID, status string, results json.RawMessage, errorText *string) error {
+// ErrQueryResultsAlreadySaved is returned by SaveQueryResults when the
+// target already has a result, e.g. because osquery retried a
+// distributed_write whose response it never received.
+var ErrQueryResultsAlreadySaved = errors.New("query results already saved")
+
+// SaveQueryResults records a host's response to a campaign; truncated marks
+// results cut to the campaign's caps. Any events are written to the outbox in
+// the same transaction, so they are published if and only if the results are
+// saved. Only the first response for a target is kept: later ones, including
+// any arriving after the target timed out, return
+// ErrQueryResultsAlreadySaved and change nothing. A saved response is added
+// to the host's timeline.
+func (r *HostRepository) SaveQueryResults(ctx context.Context, hostID uuid.UUID, queryID uuid.UUID, status string, results json.RawMessage, errorText *string, truncated bool, events ...outbox.Event) error {
 	// In the campaign-based design, queryID is the campaign ID.
 	campaignID := queryID
 
@@ -281,47 +747,49 @@ func (r *HostRepository) SaveQueryResults(ctx context.Context, hostID uuid.UUID,
 		SET status = $1,
 			results = $2,
 			error = $3,
+			truncated = $6,
+			quarantine_id = (SELECT quarantine_id FROM hosts WHERE id = $5),
 			completed_at = NOW(),
 			updated_at = NOW()
-		WHERE campaign_id = $4 AND host_id = $5
-	`, status, results, errorText, campaignID, hostID)
+		WHERE campaign_id = $4 AND host_id = $5 AND completed_at IS NULL
+	`, status, results, errorText, campaignID, hostID, truncated)
 	if err != nil {
 		return fmt.Errorf("saving query results: %w", err)
 	}
 	if cmd.RowsAffected() == 0 {
+		var exists bool
+		if err := tx.QueryRow(ctx, `
+			SELECT EXISTS (SELECT 1 FROM campaign_targets WHERE campaign_id = $1 AND host_id = $2)
+		`, campaignID, hostID).Scan(&exists); err != nil {
+			return fmt.Errorf("saving query results: checking campaign target: %w", err)
+		}
+		if exists {
+			return ErrQueryResultsAlreadySaved
+		}
 		return fmt.Errorf("saving query results: no campaign target row")
 	}
 
-	_, err = tx.Exec(ctx, `
-		UPDATE campaigns
-		SET result_count = (
-				SELECT COUNT(*)
-				FROM campaign_targets
-				WHERE campaign_id = $1
-					AND status IN ('completed', 'failed')
-			),
-			status = CASE
-				WHEN EXISTS(
-					SELECT 1
-					FROM campaign_targets
-					WHERE campaign_id = $1
-						AND status IN ('pending', 'sent')
-				) THEN 'running'
-				WHEN EXISTS(
-					SELECT 1
-					FROM campaign_targets
-					WHERE campaign_id = $1
-						AND status = 'failed'
-				) THEN 'failed'
-				ELSE 'completed'
-			END,
-			updated_at = NOW()
-		WHERE id = $1
-	`, campaignID)
-	if err != nil {
+	if status == "completed" {
+		if err := storeResultDiff(ctx, tx, campaignID, hostID, results); err != nil {
+			return fmt.Errorf("saving query results: %w", err)
+		}
+	}
+
+	if err := refreshCampaignStatus(ctx, tx, []uuid.UUID{campaignID}); err != nil {
 		return fmt.Errorf("saving query results: updating campaign status: %w", err)
 	}
 
+	if err := outbox.Insert(ctx, tx, events...); err != nil {
+		return fmt.Errorf("saving query results: %w", err)
+	}
+	detail := status
+	if truncated {
+		detail += ", truncated"
+	}
+	if err := outbox.Insert(ctx, tx, hostActivity(hostID, pubsub.HostActivityResultsReturned, campaignID, detail)); err != nil {
+		return fmt.Errorf("saving query results: %w", err)
+	}
+
 	if err := tx.Commit(ctx); err != nil {
 		return fmt.Errorf("saving query results: commit transaction: %w", err)
 	}
@@ -329,37 +797,113 @@ func (r *HostRepository) SaveQueryResults(ctx context.Context, hostID uuid.UUID,
 }
 
 type QueryResult struct {
-	QueryID   uuid.UUID
-	Query     string
-	Status    string
-	Results   json.RawMessage
+	QueryID   uuid.UUID       `json:"query_id"`
+	Query     string          `json:"query"`
+	Status    string          `json:"status"`
+	Results   json.RawMessage `json:"results,omitempty"`
+	UpdatedAt time.Time       `json:"updated_at"`
+	// QuarantineID is the quarantine the host was in when it returned the
+	// results.
+	QuarantineID *uuid.UUID `json:"quarantine_id,omitempty"`
+}
+
+// Cursor returns the keyset position of the result, for fetching the next
+// (older) page with ListResults.
+func (q QueryResult) Cursor() ResultsCursor {
+	return ResultsCursor{UpdatedAt: q.UpdatedAt, QueryID: q.QueryID}
+}
+
+// ResultsCursor is a keyset pagination position over a host's results,
+// ordered by (updated_at, campaign id) descending.
+type ResultsCursor struct {
 	UpdatedAt time.Time
+	QueryID   uuid.UUID
+}
+
+// String encodes the cursor for use in URLs and API responses.
+func (c ResultsCursor) String() string {
+	return fmt.Sprintf("%d.%s", c.UpdatedAt.UnixMicro(), c.QueryID.String())
+}
+
+// ParseResultsCursor decodes a cursor produced by ResultsCursor.String.
+func ParseResultsCursor(s string) (ResultsCursor, error) {
+	micros, id, ok := strings.Cut(s, ".")
+	if !ok {
+		return ResultsCursor{}, fmt.Errorf("parsing results cursor: malformed cursor %q", s)
+	}
+	us, err := strconv.ParseInt(micros, 10, 64)
+	if err != nil {
+		return ResultsCursor{}, fmt.Errorf("parsing results cursor timestamp: %w", err)
+	}
+	queryID, err := uuid.Parse(id)
+	if err != nil {
+		return ResultsCursor{}, fmt.Errorf("parsing results cursor id: %w", err)
+	}
+	return ResultsCursor{UpdatedAt: time.UnixMicro(us).UTC(), QueryID: queryID}, nil
 }
 
-func (r *HostRepository) GetRecentResults(ctx context.Context, hostID uuid.UUID) ([]QueryResult, error) {
+// ListResults returns up to limit of the host's distributed query results,
+// newest first. If before is non-nil only results strictly older than the
+// cursor are returned.
+func (r *HostRepository) ListResults(ctx context.Context, hostID uuid.UUID, before *ResultsCursor, limit int) ([]QueryResult, error) {
+	if limit <= 0 {
+		limit = 10
+	}
+
+	var (
+		beforeAt *time.Time
+		beforeID *uuid.UUID
+	)
+	if before != nil {
+		beforeAt = &before.UpdatedAt
+		beforeID = &before.QueryID
+	}
+
 	rows, err := r.pool.Query(ctx, `
-		SELECT c.id, c.query, t.status, t.results, t.updated_at
+		SELECT c.id, c.query, t.status, t.results, t.updated_at, t.quarantine_id
 		FROM campaigns c
 		JOIN campaign_targets t ON t.campaign_id = c.id
 		WHERE t.host_id = $1
-		ORDER BY t.updated_at DESC
-		LIMIT 10
-	`, hostID)
+			AND ($2::timestamptz IS NULL OR (t.updated_at, c.id) < ($2::timestamptz, $3::uuid))
+		ORDER BY t.updated_at DESC, c.id DESC
+		LIMIT $4
+	`, hostID, beforeAt, beforeID, limit)
 	if err != nil {
-		return nil, err
+		return nil, fmt.Errorf("listing results: %w", err)
+	}
+	return scanQueryResults(rows)
+}
+
+// ListResultsUpdatedSince returns the host's results whose updated_at is at or
+// after since, oldest first. It is used to stream only new or changed rows.
+func (r *HostRepository) ListResultsUpdatedSince(ctx context.Context, hostID uuid.UUID, since time.Time) ([]QueryResult, error) {
+	rows, err := r.pool.Query(ctx, `
+		SELECT c.id, c.query, t.status, t.results, t.updated_at, t.quarantine_id
+		FROM campaigns c
+		JOIN campaign_targets t ON t.campaign_id = c.id
+		WHERE t.host_id = $1
+			AND t.updated_at >= $2
+		ORDER BY t.updated_at ASC, c.id ASC
+	`, hostID, since)
+	if err != nil {
+		return nil, fmt.Errorf("listing results updated since: %w", err)
 	}
+	return scanQueryResults(rows)
+}
+
+func scanQueryResults(rows pgx.Rows) ([]QueryResult, error) {
 	defer rows.Close()
 
 	var results []QueryResult
 	for rows.Next() {
 		var res QueryResult
-		if err := rows.Scan(&res.QueryID, &res.Query, &res.Status, &res.Results, &res.UpdatedAt); err != nil {
+		if err := rows.Scan(&res.QueryID, &res.Query, &res.Status, &res.Results, &res.UpdatedAt, &res.QuarantineID); err != nil {
 			return nil, fmt.Errorf("scanning query result: %w", err)
 		}
 		results = append(results, res)
 	}
 	if err := rows.Err(); err != nil {
-		return nil, fmt.Errorf("getting recent results: %w", err)
+		return nil, fmt.Errorf("iterating query results: %w", err)
 	}
 	return results, nil
 }
@@ -372,10 +916,14 @@ func (r *HostRepository) QueueQuery(
 	description *string,
 	query string,
 	hostIDs []uuid.UUID,
+	opts CampaignOptions,
 ) (uuid.UUID, error) {
 	if len(hostIDs) == 0 {
 		return uuid.Nil, fmt.Errorf("queue query: no target hosts")
 	}
+	if err := opts.Validate(); err != nil {
+		return uuid.Nil, err
+	}
 
 	tx, err := r.pool.Begin(ctx)
 	if err != nil {
@@ -395,23 +943,33 @@ func (r *HostRepository) QueueQuery(
 			target_count,
 			result_count,
 			created_at,
-			updated_at
+			updated_at,
+			fanout_limit,
+			fanout_interval_seconds,
+			max_rows_per_host,
+			max_bytes_per_host,
+			timeout_seconds
 		)
-		VALUES ($1, $2, $3, $4, $5, 'pending', $6, 0, NOW(), NOW())
+		VALUES ($1, $2, $3, $4, $5, 'pending', $6, 0, NOW(), NOW(), $7, $8, $9, $10, $11)
 		RETURNING id
-	`, organizationID, name, description, query, createdBy, len(hostIDs)).Scan(&campaignID)
+	`, organizationID, name, description, query, createdBy, len(hostIDs),
+		opts.fanoutLimit(), opts.fanoutIntervalSeconds(), nonZero(opts.MaxRowsPerHost), nonZero(opts.MaxBytesPerHost), opts.timeoutSeconds()).Scan(&campaignID)
 	if err != nil {
 		return uuid.Nil, err
 	}
 
-	for _, hostID := range hostIDs {
-		_, err = tx.Exec(ctx, `
-			INSERT INTO campaign_targets (campaign_id, host_id)
-			VALUES ($1, $2)
-		`, campaignID, hostID)
-		if err != nil {
-			return uuid.Nil, err
-		}
+	// COPY keeps large campaigns to a single round trip instead of one INSERT
+	// per target host.
+	_, err = tx.CopyFrom(
+		ctx,
+		pgx.Identifier{"campaign_targets"},
+		[]string{"campaign_id", "host_id"},
+		pgx.CopyFromSlice(len(hostIDs), func(i int) ([]any, error) {
+			return []any{campaignID, hostIDs[i]}, nil
+		}),
+	)
+	if err != nil {
+		return uuid.Nil, fmt.Errorf("inserting campaign targets: %w", err)
 	}
 
 	return campaignID, tx.Commit(ctx)
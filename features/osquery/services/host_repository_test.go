@@ -0,0 +1,316 @@
+package services_test
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/cavenine/queryops/features/osquery/services"
+	"github.com/cavenine/queryops/internal/testdb"
+	"github.com/cavenine/queryops/internal/testdb/fixtures"
+	"github.com/google/uuid"
+)
+
+func TestResultsCursor_RoundTrip(t *testing.T) {
+	want := services.ResultsCursor{
+		UpdatedAt: time.Date(2025, 1, 2, 3, 4, 5, 678901000, time.UTC),
+		QueryID:   uuid.New(),
+	}
+
+	got, err := services.ParseResultsCursor(want.String())
+	if err != nil {
+		t.Fatalf("ParseResultsCursor: %v", err)
+	}
+	if !got.UpdatedAt.Equal(want.UpdatedAt) || got.QueryID != want.QueryID {
+		t.Fatalf("got %+v, want %+v", got, want)
+	}
+}
+
+func TestParseResultsCursor_Invalid(t *testing.T) {
+	for _, s := range []string{"", "nodot", "abc." + uuid.NewString(), "123.not-a-uuid"} {
+		if _, err := services.ParseResultsCursor(s); err == nil {
+			t.Errorf("ParseResultsCursor(%q): expected error", s)
+		}
+	}
+}
+
+func TestHostRepository_NodeKeys(t *testing.T) {
+	tdb := testdb.SetupTestDB(t)
+	ctx := context.Background()
+
+	orgID := fixtures.CreateOrg(t, tdb.Pool, "node-key-org").ID
+	repo := services.NewHostRepository(tdb.Pool)
+
+	first, err := repo.Enroll(ctx, "host-a", nil, orgID)
+	if err != nil {
+		t.Fatalf("Enroll: %v", err)
+	}
+	var stored int
+	if err := tdb.Pool.QueryRow(ctx, `SELECT COUNT(*) FROM hosts WHERE node_key_hash = $1`, services.HashNodeKey(first)).Scan(&stored); err != nil || stored != 1 {
+		t.Fatalf("hosts with hashed key = %d, %v", stored, err)
+	}
+
+	host, err := repo.GetByNodeKey(ctx, first)
+	if err != nil || host == nil || host.HostIdentifier != "host-a" {
+		t.Fatalf("GetByNodeKey = %+v, %v", host, err)
+	}
+	if host.NodeKeyIssuedAt.IsZero() {
+		t.Fatalf("NodeKeyIssuedAt not set")
+	}
+	if host, err := repo.GetByNodeKey(ctx, first+"x"); err != nil || host != nil {
+		t.Fatalf("GetByNodeKey(wrong key) = %+v, %v", host, err)
+	}
+
+	second, err := repo.Enroll(ctx, "host-a", nil, orgID)
+	if err != nil {
+		t.Fatalf("re-Enroll: %v", err)
+	}
+	if second == first {
+		t.Fatalf("re-enrolling reused the node key")
+	}
+	for _, key := range []string{first, second} {
+		if h, err := repo.GetByNodeKey(ctx, key); err != nil || h == nil || h.ID != host.ID {
+			t.Fatalf("GetByNodeKey within grace period = %+v, %v", h, err)
+		}
+	}
+
+	// Once the grace period is over only the current key works.
+	if _, err := tdb.Pool.Exec(ctx, `UPDATE hosts SET node_key_issued_at = NOW() - make_interval(secs => $1) WHERE id = $2`,
+		(services.NodeKeyGracePeriod + time.Minute).Seconds(), host.ID); err != nil {
+		t.Fatalf("backdating node key: %v", err)
+	}
+	if h, err := repo.GetByNodeKey(ctx, first); err != nil || h != nil {
+		t.Fatalf("GetByNodeKey(previous key after grace) = %+v, %v", h, err)
+	}
+	if h, err := repo.GetByNodeKey(ctx, second); err != nil || h == nil {
+		t.Fatalf("GetByNodeKey(current key) = %+v, %v", h, err)
+	}
+}
+
+func TestHostRepository_Ping(t *testing.T) {
+	tdb := testdb.SetupTestDB(t)
+	ctx := context.Background()
+
+	orgID := fixtures.CreateOrg(t, tdb.Pool, "ping-org").ID
+	repo := services.NewHostRepository(tdb.Pool)
+
+	key, err := repo.Enroll(ctx, "host-a", nil, orgID)
+	if err != nil {
+		t.Fatalf("Enroll: %v", err)
+	}
+
+	pinged, err := repo.Ping(ctx, key)
+	if err != nil || pinged == nil || pinged.HostIdentifier != "host-a" || pinged.OrganizationID != orgID {
+		t.Fatalf("Ping = %+v, %v", pinged, err)
+	}
+	// The first ping reports the host as never seen...
+	if pinged.LastSeenAt != nil || pinged.LastCheckIn() != nil {
+		t.Fatalf("first Ping returned LastSeenAt = %v", pinged.LastSeenAt)
+	}
+	// ...and leaves it checked in.
+	host, err := repo.GetByIDAndOrganization(ctx, pinged.ID, orgID)
+	if err != nil || host.LastSeenAt == nil || host.LastCheckIn() != host.LastSeenAt {
+		t.Fatalf("host after Ping = %+v, %v", host, err)
+	}
+	if again, err := repo.Ping(ctx, key); err != nil || again.LastSeenAt == nil || !again.LastSeenAt.Equal(*host.LastSeenAt) {
+		t.Fatalf("second Ping = %+v, %v", again, err)
+	}
+
+	if h, err := repo.Ping(ctx, key+"x"); err != nil || h != nil {
+		t.Fatalf("Ping(wrong key) = %+v, %v", h, err)
+	}
+}
+
+func TestHostRepository_ConfigIntervals(t *testing.T) {
+	tdb := testdb.SetupTestDB(t)
+	ctx := context.Background()
+
+	orgID := fixtures.CreateOrg(t, tdb.Pool, "interval-org").ID
+	host := fixtures.CreateHost(t, tdb.Pool, orgID, "host-a")
+	repo := services.NewHostRepository(tdb.Pool)
+
+	options := func() map[string]any {
+		t.Helper()
+		config, err := repo.GetConfigForHost(ctx, host.NodeKey)
+		if err != nil {
+			t.Fatalf("GetConfigForHost: %v", err)
+		}
+		var parsed struct {
+			Options map[string]any `json:"options"`
+		}
+		if err := json.Unmarshal(config, &parsed); err != nil {
+			t.Fatalf("unmarshal config: %v", err)
+		}
+		return parsed.Options
+	}
+
+	if got := options(); got["distributed_interval"] != 10.0 {
+		t.Fatalf("default options = %v", got)
+	}
+
+	if _, err := tdb.Pool.Exec(ctx, `
+		INSERT INTO organization_settings (organization_id, distributed_interval, logger_tls_period) VALUES ($1, 120, 30)
+	`, orgID); err != nil {
+		t.Fatalf("saving organization settings: %v", err)
+	}
+	got := options()
+	if got["distributed_interval"] != 120.0 || got["logger_tls_period"] != 30.0 {
+		t.Fatalf("options = %v, want the organization's intervals", got)
+	}
+	if _, ok := got["config_refresh"]; ok {
+		t.Fatalf("unset config_refresh was added: %v", got)
+	}
+
+	// A config assigned to the host is served as written.
+	var configID int
+	if err := tdb.Pool.QueryRow(ctx, `
+		INSERT INTO osquery_configs (name, config) VALUES ('pinned', '{"options":{"distributed_interval":5}}') RETURNING id
+	`).Scan(&configID); err != nil {
+		t.Fatalf("inserting config: %v", err)
+	}
+	if _, err := tdb.Pool.Exec(ctx, `UPDATE hosts SET config_id = $1 WHERE id = $2`, configID, host.ID); err != nil {
+		t.Fatalf("assigning config: %v", err)
+	}
+	if got := options(); got["distributed_interval"] != 5.0 || got["logger_tls_period"] != nil {
+		t.Fatalf("assigned config options = %v", got)
+	}
+}
+
+func TestHostRepository_ConfigDecorators(t *testing.T) {
+	tdb := testdb.SetupTestDB(t)
+	ctx := context.Background()
+
+	orgID := fixtures.CreateOrg(t, tdb.Pool, "decorator-org").ID
+	host := fixtures.CreateHost(t, tdb.Pool, orgID, "host-a")
+	repo := services.NewHostRepository(tdb.Pool)
+
+	load := func() []string {
+		t.Helper()
+		config, err := repo.GetConfigForHost(ctx, host.NodeKey)
+		if err != nil {
+			t.Fatalf("GetConfigForHost: %v", err)
+		}
+		var parsed struct {
+			Decorators struct {
+				Load []string `json:"load"`
+			} `json:"decorators"`
+		}
+		if err := json.Unmarshal(config, &parsed); err != nil {
+			t.Fatalf("unmarshal config: %v", err)
+		}
+		return parsed.Decorators.Load
+	}
+
+	builtIn := load()
+	if _, err := tdb.Pool.Exec(ctx, `
+		INSERT INTO organization_settings (organization_id, decorators, decorator_tag)
+		VALUES ($1, ARRAY['hostname', 'hardware_uuid', 'org_tag'], 'acme-prod')
+	`, orgID); err != nil {
+		t.Fatalf("saving organization settings: %v", err)
+	}
+	// The default config already loads the hostname, so it isn't added twice.
+	want := append(slices.Clone(builtIn), "SELECT uuid AS hardware_uuid FROM system_info;", "SELECT 'acme-prod' AS org_tag;")
+	if got := load(); !slices.Equal(got, want) {
+		t.Fatalf("load decorators = %q, want %q", got, want)
+	}
+
+	// A config assigned to the host gets them too.
+	var configID int
+	if err := tdb.Pool.QueryRow(ctx, `
+		INSERT INTO osquery_configs (name, config) VALUES ('pinned', '{"options":{}}') RETURNING id
+	`).Scan(&configID); err != nil {
+		t.Fatalf("inserting config: %v", err)
+	}
+	if _, err := tdb.Pool.Exec(ctx, `UPDATE hosts SET config_id = $1 WHERE id = $2`, configID, host.ID); err != nil {
+		t.Fatalf("assigning config: %v", err)
+	}
+	want = []string{"SELECT hostname AS hostname FROM system_info;", "SELECT uuid AS hardware_uuid FROM system_info;", "SELECT 'acme-prod' AS org_tag;"}
+	if got := load(); !slices.Equal(got, want) {
+		t.Fatalf("assigned config load decorators = %q, want %q", got, want)
+	}
+}
+
+func TestHostRepository_ImportLogBatch(t *testing.T) {
+	tdb := testdb.SetupTestDB(t)
+	ctx := context.Background()
+
+	orgID := fixtures.CreateOrg(t, tdb.Pool, "import-org").ID
+	host := fixtures.CreateHost(t, tdb.Pool, orgID, "host-a")
+	repo := services.NewHostRepository(tdb.Pool)
+
+	at := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	gone := uuid.New()
+	skipped, err := repo.ImportLogBatch(ctx,
+		[]services.ResultLogEntry{
+			{HostID: host.ID, Name: "pack_processes", Action: "added", Columns: json.RawMessage(`{"pid":"1"}`), Timestamp: at},
+			{HostID: gone, Name: "pack_processes", Action: "added", Columns: json.RawMessage(`{"pid":"2"}`), Timestamp: at},
+		},
+		[]services.StatusLogEntry{{HostID: host.ID, Message: "started", CreatedAt: at}},
+	)
+	if err != nil || skipped != 1 {
+		t.Fatalf("ImportLogBatch = %d skipped, %v; want 1", skipped, err)
+	}
+
+	var results, statuses int
+	var lastLogger *time.Time
+	if err := tdb.Pool.QueryRow(ctx, `
+		SELECT (SELECT COUNT(*) FROM osquery_results WHERE host_id = $1),
+			(SELECT COUNT(*) FROM osquery_status_logs WHERE host_id = $1),
+			(SELECT last_logger_at FROM hosts WHERE id = $1)
+	`, host.ID).Scan(&results, &statuses, &lastLogger); err != nil {
+		t.Fatalf("counting imported logs: %v", err)
+	}
+	if results != 1 || statuses != 1 {
+		t.Fatalf("imported %d results and %d statuses, want 1 and 1", results, statuses)
+	}
+	if lastLogger != nil {
+		t.Fatalf("ImportLogBatch set last_logger_at to %v", lastLogger)
+	}
+}
+
+// BenchmarkSaveResultLogs compares writing a /logger batch a row at a time,
+// as the synchronous logger path does, with SaveLogBatch, which the log
+// ingester uses.
+func BenchmarkSaveResultLogs(b *testing.B) {
+	tdb := testdb.SetupTestDB(b)
+	ctx := context.Background()
+
+	orgID := fixtures.CreateOrg(b, tdb.Pool, "bench-org").ID
+	hostIDs := createBenchHosts(b, tdb, orgID, 100)
+	repo := services.NewHostRepository(tdb.Pool)
+
+	for _, n := range []int{1, 100, 1000, 5000} {
+		entries := make([]services.ResultLogEntry, n)
+		for i := range entries {
+			entries[i] = services.ResultLogEntry{
+				HostID:    hostIDs[i%len(hostIDs)],
+				Name:      "pack_processes",
+				Action:    "added",
+				Columns:   json.RawMessage(fmt.Sprintf(`{"pid":"%d","name":"worker","path":"/usr/bin/worker","cmdline":"/usr/bin/worker --id %d","uid":"1000"}`, i, i)),
+				Timestamp: time.Now(),
+			}
+		}
+
+		b.Run(fmt.Sprintf("SaveResultLogs/rows=%d", n), func(b *testing.B) {
+			for b.Loop() {
+				for _, e := range entries {
+					if err := repo.SaveResultLogs(ctx, e.HostID, e.Name, e.Action, e.Columns, e.Timestamp); err != nil {
+						b.Fatalf("SaveResultLogs: %v", err)
+					}
+				}
+			}
+			b.ReportMetric(float64(n)*float64(b.N)/b.Elapsed().Seconds(), "rows/s")
+		})
+		b.Run(fmt.Sprintf("SaveLogBatch/rows=%d", n), func(b *testing.B) {
+			for b.Loop() {
+				if err := repo.SaveLogBatch(ctx, entries, nil); err != nil {
+					b.Fatalf("SaveLogBatch: %v", err)
+				}
+			}
+			b.ReportMetric(float64(n)*float64(b.N)/b.Elapsed().Seconds(), "rows/s")
+		})
+	}
+}
@@ -0,0 +1,507 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	// maxHostQueryLength and maxHostQueryConditions bound a host search
+	// expression, so one search can't become an unbounded SQL statement.
+	maxHostQueryLength     = 2000
+	maxHostQueryConditions = 20
+)
+
+// hostDetailColumns are the host details a search can filter on by table
+// name, each by its osquery table and a shorter alias. The rest of the names
+// in an expression are scheduled queries.
+var hostDetailColumns = map[string]string{
+	"os":           "h.os_version",
+	"os_version":   "h.os_version",
+	"system":       "h.system_info",
+	"system_info":  "h.system_info",
+	"osquery":      "h.osquery_info",
+	"osquery_info": "h.osquery_info",
+}
+
+// HostQuery is a parsed host search expression, such as
+//
+//	software.name = 'openssl' AND os.platform = 'ubuntu'
+//
+// Each condition names a table and column and compares it with a quoted
+// string or a number using =, !=, <, <=, >, >=, or LIKE. The table is os
+// (os_version), system (system_info), or osquery (osquery_info) for what the
+// host reported when it enrolled, and otherwise a scheduled query, whose
+// current rows are its latest snapshot or, for differential queries, the
+// rows added and not since removed. A scheduled query condition holds when
+// any current row satisfies it, so NOT software.name = 'telnet' finds hosts
+// without telnet. Conditions combine with AND, OR, NOT, and parentheses.
+// Numbers compare numerically with values that look like numbers, and LIKE
+// ignores case, as in osquery.
+type HostQuery struct {
+	root   *hostExpr
+	tables []string
+}
+
+// HostQueryError reports where and why a host search expression doesn't
+// parse.
+type HostQueryError struct {
+	// Offset is the byte offset of the problem in the expression.
+	Offset  int
+	Message string
+}
+
+func (e *HostQueryError) Error() string {
+	return fmt.Sprintf("host query: %s at offset %d", e.Message, e.Offset)
+}
+
+// Tables returns the scheduled queries the expression names.
+func (q *HostQuery) Tables() []string {
+	return q.tables
+}
+
+// hostExpr is a node of a parsed HostQuery: an operator over its operands,
+// or a condition.
+type hostExpr struct {
+	op          string // "AND", "OR", "NOT", or "" for a condition
+	left, right *hostExpr
+	cond        hostCondition
+}
+
+type hostCondition struct {
+	table, column string
+	op            string
+	value         string
+	number        bool
+}
+
+// ParseHostQuery parses a host search expression. A problem with it is
+// reported as a *HostQueryError.
+func ParseHostQuery(s string) (*HostQuery, error) {
+	if len(s) > maxHostQueryLength {
+		return nil, &HostQueryError{Offset: maxHostQueryLength, Message: fmt.Sprintf("expression is longer than %d characters", maxHostQueryLength)}
+	}
+	tokens, err := lexHostQuery(s)
+	if err != nil {
+		return nil, err
+	}
+	p := &hostQueryParser{tokens: tokens, end: len(s)}
+	if len(tokens) == 0 {
+		return nil, &HostQueryError{Message: "expression is empty"}
+	}
+	root, err := p.or()
+	if err != nil {
+		return nil, err
+	}
+	if t := p.peek(); t.kind != tokenEOF {
+		return nil, &HostQueryError{Offset: t.pos, Message: fmt.Sprintf("unexpected %q", t.text)}
+	}
+
+	q := &HostQuery{root: root}
+	seen := map[string]bool{}
+	for _, c := range p.conditions {
+		if _, ok := hostDetailColumns[c.table]; !ok && !seen[c.table] {
+			seen[c.table] = true
+			q.tables = append(q.tables, c.table)
+		}
+	}
+	return q, nil
+}
+
+type tokenKind int
+
+const (
+	tokenEOF tokenKind = iota
+	tokenIdent
+	tokenString
+	tokenNumber
+	tokenOp
+	tokenKeyword
+	tokenDot
+	tokenLParen
+	tokenRParen
+)
+
+type hostQueryToken struct {
+	kind tokenKind
+	text string
+	pos  int
+}
+
+var hostQueryKeywords = map[string]bool{"AND": true, "OR": true, "NOT": true, "LIKE": true}
+
+func lexHostQuery(s string) ([]hostQueryToken, error) {
+	var tokens []hostQueryToken
+	for i := 0; i < len(s); {
+		c := s[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			i++
+		case c == '(':
+			tokens = append(tokens, hostQueryToken{kind: tokenLParen, text: "(", pos: i})
+			i++
+		case c == ')':
+			tokens = append(tokens, hostQueryToken{kind: tokenRParen, text: ")", pos: i})
+			i++
+		case c == '.':
+			tokens = append(tokens, hostQueryToken{kind: tokenDot, text: ".", pos: i})
+			i++
+		case c == '\'' || c == '"':
+			// 'string' or "quoted identifier"; a doubled quote escapes itself.
+			var b strings.Builder
+			j := i + 1
+			for {
+				if j >= len(s) {
+					return nil, &HostQueryError{Offset: i, Message: "unterminated quote"}
+				}
+				if s[j] == c {
+					if j+1 < len(s) && s[j+1] == c {
+						b.WriteByte(c)
+						j += 2
+						continue
+					}
+					break
+				}
+				b.WriteByte(s[j])
+				j++
+			}
+			kind := tokenString
+			if c == '"' {
+				kind = tokenIdent
+				if b.Len() == 0 {
+					return nil, &HostQueryError{Offset: i, Message: "empty quoted name"}
+				}
+			}
+			tokens = append(tokens, hostQueryToken{kind: kind, text: b.String(), pos: i})
+			i = j + 1
+		case strings.IndexByte("=!<>", c) >= 0:
+			n := 1
+			if i+1 < len(s) && (s[i+1] == '=' || (c == '<' && s[i+1] == '>')) {
+				n = 2
+			}
+			op := s[i : i+n]
+			switch op {
+			case "!":
+				return nil, &HostQueryError{Offset: i, Message: `unexpected "!"`}
+			case "<>":
+				op = "!="
+			case "==":
+				op = "="
+			}
+			tokens = append(tokens, hostQueryToken{kind: tokenOp, text: op, pos: i})
+			i += n
+		case c == '-' || (c >= '0' && c <= '9'):
+			j := i + 1
+			for j < len(s) && (s[j] >= '0' && s[j] <= '9' || s[j] == '.') {
+				j++
+			}
+			if _, err := strconv.ParseFloat(s[i:j], 64); err != nil {
+				return nil, &HostQueryError{Offset: i, Message: fmt.Sprintf("invalid number %q", s[i:j])}
+			}
+			tokens = append(tokens, hostQueryToken{kind: tokenNumber, text: s[i:j], pos: i})
+			i = j
+		case isNameStart(c):
+			j := i + 1
+			for j < len(s) && (isNameStart(s[j]) || s[j] == '-' || s[j] >= '0' && s[j] <= '9') {
+				j++
+			}
+			word := s[i:j]
+			if upper := strings.ToUpper(word); hostQueryKeywords[upper] {
+				tokens = append(tokens, hostQueryToken{kind: tokenKeyword, text: upper, pos: i})
+			} else {
+				tokens = append(tokens, hostQueryToken{kind: tokenIdent, text: word, pos: i})
+			}
+			i = j
+		default:
+			return nil, &HostQueryError{Offset: i, Message: fmt.Sprintf("unexpected %q", string(c))}
+		}
+	}
+	return tokens, nil
+}
+
+// isNameStart reports whether c can start an unquoted name. Names with other
+// characters are written in double quotes.
+func isNameStart(c byte) bool {
+	return c == '_' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z'
+}
+
+// hostQueryParser is a recursive descent parser over:
+//
+//	or        = and { "OR" and }
+//	and       = unary { "AND" unary }
+//	unary     = "NOT" unary | "(" or ")" | condition
+//	condition = name "." name op value
+type hostQueryParser struct {
+	tokens     []hostQueryToken
+	next       int
+	end        int
+	conditions []hostCondition
+}
+
+func (p *hostQueryParser) peek() hostQueryToken {
+	if p.next >= len(p.tokens) {
+		return hostQueryToken{kind: tokenEOF, text: "end of expression", pos: p.end}
+	}
+	return p.tokens[p.next]
+}
+
+func (p *hostQueryParser) take() hostQueryToken {
+	t := p.peek()
+	if t.kind != tokenEOF {
+		p.next++
+	}
+	return t
+}
+
+func (p *hostQueryParser) keyword(word string) bool {
+	if t := p.peek(); t.kind == tokenKeyword && t.text == word {
+		p.next++
+		return true
+	}
+	return false
+}
+
+func (p *hostQueryParser) or() (*hostExpr, error) {
+	left, err := p.and()
+	if err != nil {
+		return nil, err
+	}
+	for p.keyword("OR") {
+		right, err := p.and()
+		if err != nil {
+			return nil, err
+		}
+		left = &hostExpr{op: "OR", left: left, right: right}
+	}
+	return left, nil
+}
+
+func (p *hostQueryParser) and() (*hostExpr, error) {
+	left, err := p.unary()
+	if err != nil {
+		return nil, err
+	}
+	for p.keyword("AND") {
+		right, err := p.unary()
+		if err != nil {
+			return nil, err
+		}
+		left = &hostExpr{op: "AND", left: left, right: right}
+	}
+	return left, nil
+}
+
+func (p *hostQueryParser) unary() (*hostExpr, error) {
+	if p.keyword("NOT") {
+		x, err := p.unary()
+		if err != nil {
+			return nil, err
+		}
+		return &hostExpr{op: "NOT", left: x}, nil
+	}
+	if p.peek().kind == tokenLParen {
+		p.take()
+		x, err := p.or()
+		if err != nil {
+			return nil, err
+		}
+		if t := p.take(); t.kind != tokenRParen {
+			return nil, &HostQueryError{Offset: t.pos, Message: fmt.Sprintf("expected \")\", found %q", t.text)}
+		}
+		return x, nil
+	}
+	return p.condition()
+}
+
+func (p *hostQueryParser) condition() (*hostExpr, error) {
+	table := p.take()
+	if table.kind != tokenIdent {
+		return nil, &HostQueryError{Offset: table.pos, Message: fmt.Sprintf("expected table.column, found %q", table.text)}
+	}
+	if t := p.take(); t.kind != tokenDot {
+		return nil, &HostQueryError{Offset: t.pos, Message: fmt.Sprintf("expected \".\" after %q", table.text)}
+	}
+	column := p.take()
+	if column.kind != tokenIdent {
+		return nil, &HostQueryError{Offset: column.pos, Message: fmt.Sprintf("expected a column name, found %q", column.text)}
+	}
+
+	op := p.take()
+	if op.kind != tokenOp && !(op.kind == tokenKeyword && op.text == "LIKE") {
+		return nil, &HostQueryError{Offset: op.pos, Message: fmt.Sprintf("expected a comparison, found %q", op.text)}
+	}
+	value := p.take()
+	if value.kind != tokenString && value.kind != tokenNumber {
+		return nil, &HostQueryError{Offset: value.pos, Message: fmt.Sprintf("expected a quoted string or number, found %q", value.text)}
+	}
+	if op.text == "LIKE" && value.kind != tokenString {
+		return nil, &HostQueryError{Offset: value.pos, Message: "LIKE needs a quoted pattern"}
+	}
+
+	if len(p.conditions) == maxHostQueryConditions {
+		return nil, &HostQueryError{Offset: table.pos, Message: fmt.Sprintf("more than %d conditions", maxHostQueryConditions)}
+	}
+	c := hostCondition{
+		table:  table.text,
+		column: column.text,
+		op:     op.text,
+		value:  value.text,
+		number: value.kind == tokenNumber,
+	}
+	p.conditions = append(p.conditions, c)
+	return &hostExpr{cond: c}, nil
+}
+
+// HostSearchHit is a host matching a HostQuery.
+type HostSearchHit struct {
+	HostID         uuid.UUID  `json:"host_id"`
+	HostIdentifier string     `json:"host_identifier"`
+	Platform       string     `json:"platform"`
+	LastCheckIn    *time.Time `json:"last_check_in,omitempty"`
+	// MatchedRows is how many of the host's current scheduled query rows
+	// satisfy a condition of the expression; 0 if it names none.
+	MatchedRows int `json:"matched_rows"`
+}
+
+// HostSearchResult is a page of hosts matching a HostQuery.
+type HostSearchResult struct {
+	// Total is how many of the organization's hosts match; 0 on a page past
+	// the last.
+	Total int             `json:"total"`
+	Hosts []HostSearchHit `json:"hosts"`
+}
+
+// SearchHosts returns up to limit of the organization's hosts matching q,
+// ordered by host identifier, starting after the host with ID after if it
+// isn't nil.
+func (r *HostRepository) SearchHosts(ctx context.Context, organizationID uuid.UUID, q *HostQuery, after *uuid.UUID, limit int) (*HostSearchResult, error) {
+	if limit <= 0 {
+		limit = 100
+	}
+	b := &hostQueryBuilder{args: []any{organizationID}}
+	where := b.expr(q.root)
+
+	// Current rows of the scheduled queries the expression names: each
+	// host's latest snapshot, or the rows its differential results added
+	// more often than they removed.
+	with := ""
+	matched := "0"
+	if len(q.tables) > 0 {
+		tables := b.arg(q.tables)
+		with = `
+		WITH latest AS (
+			SELECT r.host_id, r.name, MAX(r.timestamp) AS ts
+			FROM osquery_results r
+			JOIN hosts h ON h.id = r.host_id
+			WHERE h.organization_id = $1 AND r.name = ANY(` + tables + `::text[]) AND r.action = 'snapshot'
+			GROUP BY r.host_id, r.name
+		), inventory AS (
+			SELECT r.host_id, r.name, r.columns
+			FROM latest l
+			JOIN osquery_results r ON r.host_id = l.host_id AND r.name = l.name AND r.action = 'snapshot' AND r.timestamp = l.ts
+			UNION ALL
+			SELECT r.host_id, r.name, r.columns
+			FROM osquery_results r
+			JOIN hosts h ON h.id = r.host_id
+			WHERE h.organization_id = $1 AND r.name = ANY(` + tables + `::text[]) AND r.action IN ('added', 'removed')
+				AND NOT EXISTS (SELECT 1 FROM latest l WHERE l.host_id = r.host_id AND l.name = r.name)
+			GROUP BY r.host_id, r.name, r.columns
+			HAVING SUM(CASE WHEN r.action = 'added' THEN 1 ELSE -1 END) > 0
+		)`
+		matched = `(SELECT COUNT(*) FROM inventory i WHERE i.host_id = h.id AND (` + strings.Join(b.rowConds, " OR ") + `))`
+	}
+
+	cursor := ""
+	if after != nil {
+		cursor = `WHERE (m.host_identifier, m.id) > (SELECT host_identifier, id FROM hosts WHERE id = ` + b.arg(*after) + `)`
+	}
+
+	rows, err := r.pool.Query(ctx, with+`
+		SELECT m.id, m.host_identifier, m.os_version, m.last_logger_at, m.last_seen_at, m.matched, m.total
+		FROM (
+			SELECT h.id, h.host_identifier, h.os_version, h.last_logger_at, h.last_seen_at,
+				`+matched+` AS matched, COUNT(*) OVER () AS total
+			FROM hosts h
+			WHERE h.organization_id = $1 AND (`+where+`)
+		) m
+		`+cursor+`
+		ORDER BY m.host_identifier, m.id
+		LIMIT `+b.arg(limit), b.args...)
+	if err != nil {
+		return nil, fmt.Errorf("searching hosts: %w", err)
+	}
+	defer rows.Close()
+
+	result := &HostSearchResult{Hosts: []HostSearchHit{}}
+	for rows.Next() {
+		var (
+			host Host
+			hit  HostSearchHit
+		)
+		if err := rows.Scan(&host.ID, &host.HostIdentifier, &host.OSVersion, &host.LastLoggerAt, &host.LastSeenAt, &hit.MatchedRows, &result.Total); err != nil {
+			return nil, fmt.Errorf("scanning host search hit: %w", err)
+		}
+		hit.HostID = host.ID
+		hit.HostIdentifier = host.HostIdentifier
+		hit.Platform = host.Platform()
+		hit.LastCheckIn = host.LastCheckIn()
+		result.Hosts = append(result.Hosts, hit)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("searching hosts: %w", err)
+	}
+	return result, nil
+}
+
+// hostQueryBuilder compiles a HostQuery to SQL over hosts h, passing every
+// name and value as a parameter.
+type hostQueryBuilder struct {
+	args []any
+	// rowConds are the scheduled query conditions as tests of one
+	// inventory row i, for counting matched rows.
+	rowConds []string
+}
+
+func (b *hostQueryBuilder) arg(v any) string {
+	b.args = append(b.args, v)
+	return "$" + strconv.Itoa(len(b.args))
+}
+
+func (b *hostQueryBuilder) expr(e *hostExpr) string {
+	switch e.op {
+	case "AND", "OR":
+		return "(" + b.expr(e.left) + " " + e.op + " " + b.expr(e.right) + ")"
+	case "NOT":
+		return "NOT " + b.expr(e.left)
+	}
+
+	c := e.cond
+	if details, ok := hostDetailColumns[c.table]; ok {
+		return b.compare(details+"->>"+b.arg(c.column)+"::text", c)
+	}
+	row := "i.name = " + b.arg(c.table) + " AND " + b.compare("i.columns->>"+b.arg(c.column)+"::text", c)
+	b.rowConds = append(b.rowConds, "("+row+")")
+	return "EXISTS (SELECT 1 FROM inventory i WHERE i.host_id = h.id AND " + row + ")"
+}
+
+// compare tests the text value v against c's value. A missing value
+// satisfies nothing, so NOT around it holds.
+func (b *hostQueryBuilder) compare(v string, c hostCondition) string {
+	op := c.op
+	if op == "!=" {
+		op = "<>"
+	}
+	switch {
+	case c.op == "LIKE":
+		return "COALESCE(" + v + " ILIKE " + b.arg(c.value) + ", false)"
+	case c.number:
+		return "COALESCE(CASE WHEN " + v + ` ~ '^\s*-?[0-9]+(\.[0-9]+)?\s*$' THEN (` + v + ")::numeric END " + op + " " + b.arg(c.value) + "::text::numeric, false)"
+	default:
+		return "COALESCE(" + v + " " + op + " " + b.arg(c.value) + "::text, false)"
+	}
+}
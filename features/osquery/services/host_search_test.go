@@ -0,0 +1,143 @@
+package services_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/cavenine/queryops/features/osquery/services"
+	"github.com/cavenine/queryops/internal/testdb"
+	"github.com/cavenine/queryops/internal/testdb/fixtures"
+)
+
+func TestParseHostQuery(t *testing.T) {
+	q, err := services.ParseHostQuery(`software.name = 'openssl' AND (os.platform = 'ubuntu' OR NOT "pack/ports".port <= 1024) AND software.version LIKE '3.%'`)
+	if err != nil {
+		t.Fatalf("ParseHostQuery: %v", err)
+	}
+	if got := q.Tables(); !slices.Equal(got, []string{"software", "pack/ports"}) {
+		t.Errorf("Tables = %v, want software and pack/ports", got)
+	}
+
+	for expr, offset := range map[string]int{
+		"":                             0,
+		"software.name":                13,
+		"software = 'x'":               9,
+		"software.name = openssl":      16,
+		"software.name = 'openssl":     16,
+		"software.name LIKE 3":         19,
+		"(os.platform = 'ubuntu'":      23,
+		"os.platform = 'a' os.x = 'b'": 18,
+		"os.platform ! 'a'":            12,
+		"os.platform = 'a' AND":        21,
+	} {
+		_, err := services.ParseHostQuery(expr)
+		var qe *services.HostQueryError
+		if !errors.As(err, &qe) {
+			t.Errorf("ParseHostQuery(%q) error = %v, want HostQueryError", expr, err)
+			continue
+		}
+		if qe.Offset != offset {
+			t.Errorf("ParseHostQuery(%q) offset = %d (%s), want %d", expr, qe.Offset, qe.Message, offset)
+		}
+	}
+}
+
+func TestSearchHosts(t *testing.T) {
+	tdb := testdb.SetupTestDB(t)
+	ctx := context.Background()
+
+	orgID := fixtures.CreateOrg(t, tdb.Pool, "host-search-org").ID
+	otherOrgID := fixtures.CreateOrg(t, tdb.Pool, "host-search-other").ID
+	ubuntu := fixtures.CreateHost(t, tdb.Pool, orgID, "ubuntu-a").ID
+	ubuntuOld := fixtures.CreateHost(t, tdb.Pool, orgID, "ubuntu-b").ID
+	darwin := fixtures.CreateHost(t, tdb.Pool, orgID, "mac-a").ID
+	other := fixtures.CreateHost(t, tdb.Pool, otherOrgID, "ubuntu-other").ID
+	for id, platform := range map[uuid.UUID]string{ubuntu: "ubuntu", ubuntuOld: "ubuntu", darwin: "darwin", other: "ubuntu"} {
+		if _, err := tdb.Pool.Exec(ctx, `UPDATE hosts SET os_version = jsonb_build_object('platform', $2::text) WHERE id = $1`, id, platform); err != nil {
+			t.Fatalf("setting os_version: %v", err)
+		}
+	}
+
+	repo := services.NewHostRepository(tdb.Pool)
+	base := time.Now().Add(-time.Hour).UTC().Truncate(time.Second)
+	logs := []struct {
+		host               uuid.UUID
+		name, action, cols string
+		at                 time.Time
+	}{
+		// ubuntu-a's latest software snapshot has openssl 3; an older one
+		// had telnet.
+		{ubuntu, "software", "snapshot", `{"name":"telnet","version":"1"}`, base},
+		{ubuntu, "software", "snapshot", `{"name":"openssl","version":"3.0.2"}`, base.Add(time.Minute)},
+		{ubuntu, "software", "snapshot", `{"name":"curl","version":"7.81"}`, base.Add(time.Minute)},
+		// ubuntu-b logs differentially: openssl 1.1 added, telnet added and
+		// removed.
+		{ubuntuOld, "software", "added", `{"name":"openssl","version":"1.1.1"}`, base},
+		{ubuntuOld, "software", "added", `{"name":"telnet","version":"1"}`, base},
+		{ubuntuOld, "software", "removed", `{"name":"telnet","version":"1"}`, base.Add(time.Minute)},
+		{darwin, "software", "snapshot", `{"name":"openssl","version":"3.1.0"}`, base},
+		{other, "software", "snapshot", `{"name":"openssl","version":"3.0.2"}`, base},
+	}
+	for _, l := range logs {
+		if err := repo.SaveResultLogs(ctx, l.host, l.name, l.action, json.RawMessage(l.cols), l.at); err != nil {
+			t.Fatalf("SaveResultLogs: %v", err)
+		}
+	}
+
+	search := func(expr string) []string {
+		t.Helper()
+		q, err := services.ParseHostQuery(expr)
+		if err != nil {
+			t.Fatalf("ParseHostQuery(%q): %v", expr, err)
+		}
+		result, err := repo.SearchHosts(ctx, orgID, q, nil, 10)
+		if err != nil {
+			t.Fatalf("SearchHosts(%q): %v", expr, err)
+		}
+		if result.Total != len(result.Hosts) {
+			t.Errorf("SearchHosts(%q) total = %d, want %d", expr, result.Total, len(result.Hosts))
+		}
+		var ids []string
+		for _, h := range result.Hosts {
+			ids = append(ids, h.HostIdentifier)
+		}
+		return ids
+	}
+
+	for expr, want := range map[string][]string{
+		`software.name = 'openssl' AND os.platform = 'ubuntu'`:      {"ubuntu-a", "ubuntu-b"},
+		`software.name = 'openssl' AND software.version LIKE '3.%'`: {"mac-a", "ubuntu-a"},
+		`software.name = 'telnet'`:                                  nil,
+		`NOT software.name = 'curl'`:                                {"mac-a", "ubuntu-b"},
+		`os.platform = 'darwin' OR software.name = 'curl'`:          {"mac-a", "ubuntu-a"},
+		// Only values that look like numbers compare with one: curl's 7.81,
+		// not openssl's 3.1.0.
+		`software.version > 3`: {"ubuntu-a"},
+	} {
+		if got := search(expr); !slices.Equal(got, want) {
+			t.Errorf("SearchHosts(%q) = %v, want %v", expr, got, want)
+		}
+	}
+
+	q, _ := services.ParseHostQuery(`os.platform = 'ubuntu' OR software.name = 'openssl'`)
+	page, err := repo.SearchHosts(ctx, orgID, q, nil, 1)
+	if err != nil {
+		t.Fatalf("SearchHosts(page 1): %v", err)
+	}
+	if page.Total != 3 || len(page.Hosts) != 1 || page.Hosts[0].HostIdentifier != "mac-a" || page.Hosts[0].MatchedRows != 1 {
+		t.Fatalf("page 1 = %+v, want mac-a of 3", page)
+	}
+	page, err = repo.SearchHosts(ctx, orgID, q, &page.Hosts[0].HostID, 5)
+	if err != nil {
+		t.Fatalf("SearchHosts(page 2): %v", err)
+	}
+	if len(page.Hosts) != 2 || page.Hosts[0].HostIdentifier != "ubuntu-a" || page.Hosts[0].Platform != "linux" {
+		t.Fatalf("page 2 = %+v, want ubuntu-a and ubuntu-b", page)
+	}
+}
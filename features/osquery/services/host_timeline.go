@@ -0,0 +1,91 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+
+	"github.com/cavenine/queryops/db"
+	"github.com/cavenine/queryops/internal/outbox"
+	"github.com/cavenine/queryops/internal/pubsub"
+)
+
+// HostEventRetention is how long a host's timeline is kept.
+const HostEventRetention = 90 * 24 * time.Hour
+
+// configFetchedInterval is how often a host's config fetches are recorded in
+// its timeline. osquery fetches its config every few minutes; recording each
+// one would bury everything else.
+const configFetchedInterval = time.Hour
+
+// HostTimelineEvent is a step in a host's lifecycle, as recorded in its
+// timeline.
+type HostTimelineEvent struct {
+	ID         int64      `db:"id"`
+	HostID     uuid.UUID  `db:"host_id"`
+	Type       string     `db:"type"`
+	CampaignID *uuid.UUID `db:"campaign_id"`
+	Detail     string     `db:"detail"`
+	OccurredAt time.Time  `db:"occurred_at"`
+}
+
+var hostTimelineEventColumns = db.Columns[HostTimelineEvent]()
+
+// hostActivity returns the outbox event recording a step in the host's
+// lifecycle. campaignID may be uuid.Nil.
+func hostActivity(hostID uuid.UUID, typ string, campaignID uuid.UUID, detail string) outbox.Event {
+	event := pubsub.HostActivityEvent{
+		HostID:     hostID,
+		Type:       typ,
+		Detail:     detail,
+		OccurredAt: time.Now().UTC(),
+	}
+	if campaignID != uuid.Nil {
+		event.CampaignID = &campaignID
+	}
+	return outbox.Event{Topic: pubsub.TopicHostActivity(hostID), Message: event.ToMessage()}
+}
+
+// RecordHostEvent adds a host activity event to the host's timeline.
+// messageID identifies the event; recording it again changes nothing. An
+// event for a host that has since been deleted is dropped.
+func (r *HostRepository) RecordHostEvent(ctx context.Context, messageID string, event pubsub.HostActivityEvent) error {
+	_, err := r.pool.Exec(ctx, `
+		INSERT INTO host_events (message_id, host_id, type, campaign_id, detail, occurred_at)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		ON CONFLICT (message_id) DO NOTHING
+	`, messageID, event.HostID, event.Type, event.CampaignID, event.Detail, event.OccurredAt)
+	if err != nil {
+		var pgErr *pgconn.PgError
+		if errors.As(err, &pgErr) && pgErr.Code == "23503" {
+			return nil
+		}
+		return fmt.Errorf("recording host event: %w", err)
+	}
+	return nil
+}
+
+// ListHostEvents returns the host's most recent timeline events, newest
+// first.
+func (r *HostRepository) ListHostEvents(ctx context.Context, hostID uuid.UUID, limit int) ([]HostTimelineEvent, error) {
+	rows, err := r.pool.Query(ctx, `
+		SELECT `+hostTimelineEventColumns+`
+		FROM host_events
+		WHERE host_id = $1
+		ORDER BY occurred_at DESC, id DESC
+		LIMIT $2
+	`, hostID, limit)
+	if err != nil {
+		return nil, fmt.Errorf("listing host events: %w", err)
+	}
+	events, err := pgx.CollectRows(rows, pgx.RowToStructByName[HostTimelineEvent])
+	if err != nil {
+		return nil, fmt.Errorf("scanning host events: %w", err)
+	}
+	return events, nil
+}
@@ -0,0 +1,113 @@
+package services_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/cavenine/queryops/features/osquery/services"
+	"github.com/cavenine/queryops/internal/pubsub"
+	"github.com/cavenine/queryops/internal/testdb"
+	"github.com/cavenine/queryops/internal/testdb/fixtures"
+)
+
+func TestHostRepository_HostActivityOutbox(t *testing.T) {
+	tdb := testdb.SetupTestDB(t)
+	ctx := context.Background()
+
+	orgID := fixtures.CreateOrg(t, tdb.Pool, "timeline-org").ID
+	repo := services.NewHostRepository(tdb.Pool)
+
+	nodeKey, err := repo.Enroll(ctx, "host-a", nil, orgID)
+	if err != nil {
+		t.Fatalf("Enroll: %v", err)
+	}
+	// Only the first of two config fetches within the hour is recorded.
+	for range 2 {
+		if err := repo.UpdateLastConfig(ctx, nodeKey); err != nil {
+			t.Fatalf("UpdateLastConfig: %v", err)
+		}
+	}
+
+	host, err := repo.GetByNodeKey(ctx, nodeKey)
+	if err != nil || host == nil {
+		t.Fatalf("GetByNodeKey = %+v, %v", host, err)
+	}
+	rows, err := tdb.Pool.Query(ctx, `
+		SELECT convert_from(payload, 'UTF8')::jsonb->>'type' FROM pubsub_outbox WHERE topic = $1 ORDER BY id
+	`, pubsub.TopicHostActivity(host.ID))
+	if err != nil {
+		t.Fatalf("reading outbox: %v", err)
+	}
+	var types []string
+	for rows.Next() {
+		var typ string
+		if err := rows.Scan(&typ); err != nil {
+			t.Fatalf("scanning outbox: %v", err)
+		}
+		types = append(types, typ)
+	}
+	rows.Close()
+	want := []string{pubsub.HostActivityEnrolled, pubsub.HostActivityConfigFetched}
+	if len(types) != len(want) || types[0] != want[0] || types[1] != want[1] {
+		t.Fatalf("host activity = %v, want %v", types, want)
+	}
+}
+
+func TestHostRepository_HostEvents(t *testing.T) {
+	tdb := testdb.SetupTestDB(t)
+	ctx := context.Background()
+
+	orgID := fixtures.CreateOrg(t, tdb.Pool, "timeline-org").ID
+	host := fixtures.CreateHost(t, tdb.Pool, orgID, "host-a")
+	repo := services.NewHostRepository(tdb.Pool)
+
+	campaignID := uuid.New()
+	now := time.Now().UTC().Truncate(time.Microsecond)
+	enrolled := pubsub.HostActivityEvent{HostID: host.ID, Type: pubsub.HostActivityEnrolled, OccurredAt: now.Add(-time.Hour)}
+	sent := pubsub.HostActivityEvent{HostID: host.ID, Type: pubsub.HostActivityQuerySent, CampaignID: &campaignID, OccurredAt: now}
+
+	for _, r := range []struct {
+		id    string
+		event pubsub.HostActivityEvent
+	}{
+		{"enrolled", enrolled},
+		{"sent", sent},
+		// Redelivered.
+		{"sent", sent},
+		// The host was deleted before the event was recorded.
+		{"deleted", pubsub.HostActivityEvent{HostID: uuid.New(), Type: pubsub.HostActivityWentOffline, OccurredAt: now}},
+	} {
+		if err := repo.RecordHostEvent(ctx, r.id, r.event); err != nil {
+			t.Fatalf("RecordHostEvent(%s): %v", r.id, err)
+		}
+	}
+
+	events, err := repo.ListHostEvents(ctx, host.ID, 10)
+	if err != nil {
+		t.Fatalf("ListHostEvents: %v", err)
+	}
+	if len(events) != 2 {
+		t.Fatalf("ListHostEvents returned %d events, want 2", len(events))
+	}
+	if events[0].Type != pubsub.HostActivityQuerySent || events[0].CampaignID == nil || *events[0].CampaignID != campaignID {
+		t.Fatalf("newest event = %+v, want query sent for %s", events[0], campaignID)
+	}
+	if events[1].Type != pubsub.HostActivityEnrolled || !events[1].OccurredAt.Equal(enrolled.OccurredAt) {
+		t.Fatalf("oldest event = %+v, want enrolled at %s", events[1], enrolled.OccurredAt)
+	}
+
+	// Events past retention are purged.
+	if _, err := repo.PurgeExpiredLogs(ctx, now.Add(services.HostEventRetention-time.Minute), 100); err != nil {
+		t.Fatalf("PurgeExpiredLogs: %v", err)
+	}
+	events, err = repo.ListHostEvents(ctx, host.ID, 10)
+	if err != nil {
+		t.Fatalf("ListHostEvents after purge: %v", err)
+	}
+	if len(events) != 1 || events[0].Type != pubsub.HostActivityQuerySent {
+		t.Fatalf("after purge = %+v, want only the query sent", events)
+	}
+}
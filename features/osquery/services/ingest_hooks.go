@@ -0,0 +1,103 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+)
+
+// IngestHook keeps normalized tables current from the results of a
+// scheduled query QueryOps adds to every config served to hosts, so pages
+// and reports read a column instead of digging through osquery_results.
+type IngestHook struct {
+	// QueryName names the scheduled query, and must be unique.
+	QueryName string
+	Query     string
+	// Interval is how often hosts run the query, in seconds; zero is
+	// ScheduleVitalsInterval. The query is always a snapshot.
+	Interval int
+	// Platform restricts the query to hosts on the listed platforms, in
+	// osquery's format; empty is every platform.
+	Platform string
+
+	// Apply stores the query's results, in the transaction that saves them.
+	// It's only given results of QueryName, possibly several snapshots of
+	// a host; see latestSnapshots.
+	Apply func(ctx context.Context, tx pgx.Tx, results []ResultLogEntry) error
+}
+
+// IngestHooks is a registry of ingest hooks.
+type IngestHooks struct {
+	mu    sync.Mutex
+	hooks []IngestHook
+}
+
+// Ingest is the registry SaveLogBatch runs. Hooks register here, typically
+// from an init function.
+var Ingest = &IngestHooks{}
+
+// Register adds hook to the registry. It panics on a hook missing its name,
+// query, or Apply, or registered twice, since both are programming errors.
+func (r *IngestHooks) Register(hook IngestHook) {
+	if hook.QueryName == "" || hook.Query == "" || hook.Apply == nil {
+		panic("services: ingest hook requires a query name, query, and apply")
+	}
+	if hook.Interval == 0 {
+		hook.Interval = ScheduleVitalsInterval
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, existing := range r.hooks {
+		if existing.QueryName == hook.QueryName {
+			panic(fmt.Sprintf("services: ingest hook %q registered twice", hook.QueryName))
+		}
+	}
+	r.hooks = append(r.hooks, hook)
+}
+
+// Hooks returns the registered hooks, in the order they were registered.
+func (r *IngestHooks) Hooks() []IngestHook {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]IngestHook(nil), r.hooks...)
+}
+
+// apply runs each hook on its query's results among results, in
+// registration order. Hooks without results aren't run.
+func (r *IngestHooks) apply(ctx context.Context, tx pgx.Tx, results []ResultLogEntry) error {
+	byName := make(map[string][]ResultLogEntry)
+	for _, e := range results {
+		byName[e.Name] = append(byName[e.Name], e)
+	}
+	if len(byName) == 0 {
+		return nil
+	}
+	for _, hook := range r.Hooks() {
+		if entries := byName[hook.QueryName]; len(entries) > 0 {
+			if err := hook.Apply(ctx, tx, entries); err != nil {
+				return fmt.Errorf("ingest hook %s: %w", hook.QueryName, err)
+			}
+		}
+	}
+	return nil
+}
+
+// latestSnapshots returns each host's rows of the latest snapshot among
+// results: those logged at its latest timestamp.
+func latestSnapshots(results []ResultLogEntry) map[uuid.UUID][]ResultLogEntry {
+	latest := make(map[uuid.UUID][]ResultLogEntry)
+	for _, e := range results {
+		prev := latest[e.HostID]
+		switch {
+		case len(prev) == 0 || e.Timestamp.After(prev[0].Timestamp):
+			latest[e.HostID] = []ResultLogEntry{e}
+		case e.Timestamp.Equal(prev[0].Timestamp):
+			latest[e.HostID] = append(prev, e)
+		}
+	}
+	return latest
+}
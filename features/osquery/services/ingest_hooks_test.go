@@ -0,0 +1,41 @@
+package services_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/jackc/pgx/v5"
+
+	"github.com/cavenine/queryops/features/osquery/services"
+)
+
+func TestIngestHooks_Register(t *testing.T) {
+	apply := func(context.Context, pgx.Tx, []services.ResultLogEntry) error { return nil }
+	r := &services.IngestHooks{}
+	r.Register(services.IngestHook{QueryName: "a", Query: "SELECT 1;", Apply: apply})
+	r.Register(services.IngestHook{QueryName: "b", Query: "SELECT 2;", Interval: 60, Apply: apply})
+
+	hooks := r.Hooks()
+	if len(hooks) != 2 || hooks[0].QueryName != "a" || hooks[1].QueryName != "b" {
+		t.Fatalf("hooks = %+v, want a then b", hooks)
+	}
+	if hooks[0].Interval != services.ScheduleVitalsInterval || hooks[1].Interval != 60 {
+		t.Fatalf("intervals = %d, %d; want %d, 60", hooks[0].Interval, hooks[1].Interval, services.ScheduleVitalsInterval)
+	}
+
+	for name, hook := range map[string]services.IngestHook{
+		"duplicate": {QueryName: "a", Query: "SELECT 3;", Apply: apply},
+		"no query":  {QueryName: "c", Apply: apply},
+		"no apply":  {QueryName: "c", Query: "SELECT 3;"},
+		"no name":   {Query: "SELECT 3;", Apply: apply},
+	} {
+		t.Run(name, func(t *testing.T) {
+			defer func() {
+				if recover() == nil {
+					t.Fatal("Register did not panic")
+				}
+			}()
+			r.Register(hook)
+		})
+	}
+}
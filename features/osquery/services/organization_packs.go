@@ -0,0 +1,76 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// OrganizationPack is a query library pack an organization has enabled, as
+// it was copied when enabled.
+type OrganizationPack struct {
+	Name           string          `json:"name"`
+	Pack           json.RawMessage `json:"pack"`
+	EnabledByEmail *string         `json:"enabled_by,omitempty"`
+	EnabledAt      time.Time       `json:"enabled_at"`
+}
+
+// ListOrganizationPacks returns the packs the organization has enabled,
+// sorted by name.
+func (r *HostRepository) ListOrganizationPacks(ctx context.Context, organizationID uuid.UUID) ([]OrganizationPack, error) {
+	rows, err := r.pool.Query(ctx, `
+		SELECT p.name, p.pack, u.email, p.enabled_at
+		FROM organization_packs p
+		LEFT JOIN users u ON u.id = p.enabled_by
+		WHERE p.organization_id = $1
+		ORDER BY p.name
+	`, organizationID)
+	if err != nil {
+		return nil, fmt.Errorf("listing organization packs: %w", err)
+	}
+	defer rows.Close()
+
+	var packs []OrganizationPack
+	for rows.Next() {
+		var p OrganizationPack
+		if err := rows.Scan(&p.Name, &p.Pack, &p.EnabledByEmail, &p.EnabledAt); err != nil {
+			return nil, fmt.Errorf("scanning organization pack: %w", err)
+		}
+		packs = append(packs, p)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("listing organization packs: %w", err)
+	}
+	return packs, nil
+}
+
+// EnableOrganizationPack adds pack to the config every host in the
+// organization is served, under name, replacing the copy enabled earlier if
+// there is one. A pack of the same name in a host's config is overridden.
+func (r *HostRepository) EnableOrganizationPack(ctx context.Context, organizationID uuid.UUID, name string, pack json.RawMessage, enabledBy *int) error {
+	_, err := r.pool.Exec(ctx, `
+		INSERT INTO organization_packs (organization_id, name, pack, enabled_by)
+		VALUES ($1, $2, $3, $4)
+		ON CONFLICT (organization_id, name) DO UPDATE
+		SET pack = EXCLUDED.pack, enabled_by = EXCLUDED.enabled_by, enabled_at = NOW()
+	`, organizationID, name, pack, enabledBy)
+	if err != nil {
+		return fmt.Errorf("enabling organization pack: %w", err)
+	}
+	return nil
+}
+
+// DisableOrganizationPack removes a pack the organization enabled from its
+// hosts' config. It reports whether the pack was enabled.
+func (r *HostRepository) DisableOrganizationPack(ctx context.Context, organizationID uuid.UUID, name string) (bool, error) {
+	tag, err := r.pool.Exec(ctx, `
+		DELETE FROM organization_packs WHERE organization_id = $1 AND name = $2
+	`, organizationID, name)
+	if err != nil {
+		return false, fmt.Errorf("disabling organization pack: %w", err)
+	}
+	return tag.RowsAffected() > 0, nil
+}
@@ -0,0 +1,92 @@
+package services_test
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/cavenine/queryops/features/osquery/services"
+	"github.com/cavenine/queryops/internal/testdb"
+	"github.com/cavenine/queryops/internal/testdb/fixtures"
+)
+
+func TestHostRepository_OrganizationPacks(t *testing.T) {
+	tdb := testdb.SetupTestDB(t)
+	ctx := context.Background()
+
+	orgID := fixtures.CreateOrg(t, tdb.Pool, "packs-org").ID
+	otherOrgID := fixtures.CreateOrg(t, tdb.Pool, "other-org").ID
+	host := fixtures.CreateHost(t, tdb.Pool, orgID, "host-a")
+	other := fixtures.CreateHost(t, tdb.Pool, otherOrgID, "host-b")
+	user := fixtures.CreateUser(t, tdb.Pool, "packs@example.com")
+	repo := services.NewHostRepository(tdb.Pool)
+
+	packs := func(nodeKey string) map[string]json.RawMessage {
+		t.Helper()
+		config, err := repo.GetConfigForHost(ctx, nodeKey)
+		if err != nil {
+			t.Fatalf("GetConfigForHost: %v", err)
+		}
+		var parsed struct {
+			Options map[string]any             `json:"options"`
+			Packs   map[string]json.RawMessage `json:"packs"`
+		}
+		if err := json.Unmarshal(config, &parsed); err != nil {
+			t.Fatalf("unmarshal config: %v", err)
+		}
+		if parsed.Options == nil {
+			t.Fatalf("config lost its options: %s", config)
+		}
+		return parsed.Packs
+	}
+
+	if got := packs(host.NodeKey); len(got) != 0 {
+		t.Fatalf("packs before enabling = %v", got)
+	}
+
+	pack := json.RawMessage(`{"queries":{"uptime":{"query":"SELECT * FROM uptime;","interval":3600}}}`)
+	if err := repo.EnableOrganizationPack(ctx, orgID, "ir", pack, &user.ID); err != nil {
+		t.Fatalf("EnableOrganizationPack: %v", err)
+	}
+	enabled, err := repo.ListOrganizationPacks(ctx, orgID)
+	if err != nil || len(enabled) != 1 || enabled[0].Name != "ir" || enabled[0].EnabledByEmail == nil || *enabled[0].EnabledByEmail != user.Email {
+		t.Fatalf("ListOrganizationPacks = %+v, %v", enabled, err)
+	}
+	if got := packs(host.NodeKey); got["ir"] == nil {
+		t.Fatalf("packs = %v, want ir", got)
+	}
+	if got := packs(other.NodeKey); len(got) != 0 {
+		t.Fatalf("other organization's packs = %v", got)
+	}
+
+	// Hosts with their own config get the pack too, replacing one of the
+	// same name.
+	var configID int
+	if err := tdb.Pool.QueryRow(ctx, `
+		INSERT INTO osquery_configs (name, config)
+		VALUES ('pinned', '{"options":{"distributed_interval":5},"packs":{"ir":{"queries":{}},"own":{"queries":{}}}}')
+		RETURNING id
+	`).Scan(&configID); err != nil {
+		t.Fatalf("inserting config: %v", err)
+	}
+	if _, err := tdb.Pool.Exec(ctx, `UPDATE hosts SET config_id = $1 WHERE id = $2`, configID, host.ID); err != nil {
+		t.Fatalf("assigning config: %v", err)
+	}
+	got := packs(host.NodeKey)
+	var ir struct {
+		Queries map[string]any `json:"queries"`
+	}
+	if err := json.Unmarshal(got["ir"], &ir); err != nil || ir.Queries["uptime"] == nil || got["own"] == nil {
+		t.Fatalf("assigned config packs = %v", got)
+	}
+
+	if disabled, err := repo.DisableOrganizationPack(ctx, orgID, "ir"); err != nil || !disabled {
+		t.Fatalf("DisableOrganizationPack = %v, %v", disabled, err)
+	}
+	if disabled, err := repo.DisableOrganizationPack(ctx, orgID, "ir"); err != nil || disabled {
+		t.Fatalf("DisableOrganizationPack(again) = %v, %v", disabled, err)
+	}
+	if enabled, err := repo.ListOrganizationPacks(ctx, orgID); err != nil || len(enabled) != 0 {
+		t.Fatalf("ListOrganizationPacks after disabling = %+v, %v", enabled, err)
+	}
+}
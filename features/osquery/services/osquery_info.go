@@ -0,0 +1,140 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+)
+
+const (
+	// OsqueryInfoQueryName is the ingest hook query that reports the
+	// version, config hash, and extensions status of the running osquery,
+	// which change without the host enrolling again.
+	OsqueryInfoQueryName = "queryops_osquery_info"
+	OsqueryInfoQuery     = "SELECT version, config_hash, extensions FROM osquery_info;"
+)
+
+func init() {
+	Ingest.Register(IngestHook{
+		QueryName: OsqueryInfoQueryName,
+		Query:     OsqueryInfoQuery,
+		Apply:     recordOsqueryInfo,
+	})
+}
+
+// OsqueryInfo is what QueryOps keeps of the osquery_info a host reports.
+type OsqueryInfo struct {
+	Version    string `json:"version"`
+	ConfigHash string `json:"config_hash"`
+	// Extensions is "active" when osquery's extension manager is running
+	// and "inactive" when it isn't.
+	Extensions string `json:"extensions"`
+}
+
+// OsqueryOutdated reports whether the host runs an osquery older than
+// minVersion. A host that hasn't reported a comparable version isn't
+// outdated, and no host is when minVersion is "".
+func (h *Host) OsqueryOutdated(minVersion string) bool {
+	if h.OsqueryVersion == "" || minVersion == "" {
+		return false
+	}
+	cmp, ok := CompareOsqueryVersions(h.OsqueryVersion, minVersion)
+	return ok && cmp < 0
+}
+
+// CompareOsqueryVersions compares the dotted release numbers of two osquery
+// versions, returning -1, 0, or 1 as a is older than, the same as, or newer
+// than b. Anything after the release numbers, such as the "-4-gabc123" of a
+// development build, is ignored, and missing numbers count as 0. ok is false
+// if either version doesn't start with a number.
+func CompareOsqueryVersions(a, b string) (cmp int, ok bool) {
+	av, aok := parseOsqueryVersion(a)
+	bv, bok := parseOsqueryVersion(b)
+	if !aok || !bok {
+		return 0, false
+	}
+	for i := range max(len(av), len(bv)) {
+		var x, y int
+		if i < len(av) {
+			x = av[i]
+		}
+		if i < len(bv) {
+			y = bv[i]
+		}
+		if x != y {
+			if x < y {
+				return -1, true
+			}
+			return 1, true
+		}
+	}
+	return 0, true
+}
+
+func parseOsqueryVersion(v string) ([]int, bool) {
+	v = strings.TrimPrefix(strings.TrimSpace(v), "v")
+	if end := strings.IndexFunc(v, func(r rune) bool { return r != '.' && (r < '0' || r > '9') }); end >= 0 {
+		v = v[:end]
+	}
+	var parts []int
+	for s := range strings.SplitSeq(v, ".") {
+		n, err := strconv.Atoi(s)
+		if err != nil {
+			break
+		}
+		parts = append(parts, n)
+	}
+	return parts, len(parts) > 0
+}
+
+// recordOsqueryInfo stores on each host the latest of its osquery info
+// vitals, completing agent upgrades the hosts have made. Vitals without a
+// version are ignored.
+func recordOsqueryInfo(ctx context.Context, tx pgx.Tx, results []ResultLogEntry) error {
+	latest := make(map[uuid.UUID]ResultLogEntry)
+	for _, e := range results {
+		if prev, ok := latest[e.HostID]; ok && prev.Timestamp.After(e.Timestamp) {
+			continue
+		}
+		latest[e.HostID] = e
+	}
+
+	var (
+		hostIDs                      []uuid.UUID
+		versions, hashes, extensions []string
+	)
+	for hostID, e := range latest {
+		var info OsqueryInfo
+		if err := json.Unmarshal(e.Columns, &info); err != nil || info.Version == "" {
+			continue
+		}
+		hostIDs = append(hostIDs, hostID)
+		versions = append(versions, info.Version)
+		hashes = append(hashes, info.ConfigHash)
+		extensions = append(extensions, info.Extensions)
+	}
+	if len(hostIDs) == 0 {
+		return nil
+	}
+
+	// osquery_info keeps the rest of what the host reported at enrollment.
+	_, err := tx.Exec(ctx, `
+		UPDATE hosts h SET
+			osquery_version = v.version,
+			osquery_config_hash = v.config_hash,
+			osquery_extensions = v.extensions,
+			osquery_info = COALESCE(h.osquery_info, '{}'::jsonb) || jsonb_build_object(
+				'version', v.version, 'config_hash', v.config_hash, 'extensions', v.extensions)
+		FROM unnest($1::uuid[], $2::text[], $3::text[], $4::text[]) AS v(id, version, config_hash, extensions)
+		WHERE h.id = v.id
+	`, hostIDs, versions, hashes, extensions)
+	if err != nil {
+		return fmt.Errorf("recording osquery info: %w", err)
+	}
+	return completeAgentUpgrades(ctx, tx, hostIDs, versions)
+}
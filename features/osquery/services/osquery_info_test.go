@@ -0,0 +1,116 @@
+package services_test
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/cavenine/queryops/features/osquery/services"
+	"github.com/cavenine/queryops/internal/testdb"
+	"github.com/cavenine/queryops/internal/testdb/fixtures"
+)
+
+func TestCompareOsqueryVersions(t *testing.T) {
+	for _, tc := range []struct {
+		a, b string
+		want int
+		ok   bool
+	}{
+		{"5.12.1", "5.12.1", 0, true},
+		{"5.12", "5.12.0", 0, true},
+		{"5.9.1", "5.12.0", -1, true},
+		{"5.12.2", "5.12.1", 1, true},
+		{"6.0.0", "5.99.99", 1, true},
+		{"5.13.1-4-gabc123", "5.13.1", 0, true},
+		{"v5.11.0", "5.12.0", -1, true},
+		{"unknown", "5.12.0", 0, false},
+		{"5.12.0", "", 0, false},
+	} {
+		got, ok := services.CompareOsqueryVersions(tc.a, tc.b)
+		if got != tc.want || ok != tc.ok {
+			t.Errorf("CompareOsqueryVersions(%q, %q) = %d, %v; want %d, %v", tc.a, tc.b, got, ok, tc.want, tc.ok)
+		}
+	}
+}
+
+func TestHost_OsqueryOutdated(t *testing.T) {
+	for _, tc := range []struct {
+		version, min string
+		want         bool
+	}{
+		{"5.11.0", "5.12.0", true},
+		{"5.12.0", "5.12.0", false},
+		{"", "5.12.0", false},
+		{"5.11.0", "", false},
+		{"dev", "5.12.0", false},
+	} {
+		h := &services.Host{OsqueryVersion: tc.version}
+		if got := h.OsqueryOutdated(tc.min); got != tc.want {
+			t.Errorf("host on %q OsqueryOutdated(%q) = %v, want %v", tc.version, tc.min, got, tc.want)
+		}
+	}
+}
+
+func TestHostRepository_OsqueryInfo(t *testing.T) {
+	tdb := testdb.SetupTestDB(t)
+	ctx := context.Background()
+
+	orgID := fixtures.CreateOrg(t, tdb.Pool, "osquery-info-org").ID
+	repo := services.NewHostRepository(tdb.Pool)
+
+	details, _ := json.Marshal(map[string]any{
+		"osquery_info": map[string]string{"version": "5.11.0", "config_hash": "abc", "extensions": "inactive", "instance_id": "i-1"},
+	})
+	nodeKey, err := repo.Enroll(ctx, "host-a", details, orgID)
+	if err != nil {
+		t.Fatalf("Enroll: %v", err)
+	}
+	host, err := repo.GetByNodeKey(ctx, nodeKey)
+	if err != nil || host == nil {
+		t.Fatalf("GetByNodeKey = %+v, %v", host, err)
+	}
+	if host.OsqueryVersion != "5.11.0" || host.OsqueryConfigHash != "abc" || host.OsqueryExtensions != "inactive" {
+		t.Fatalf("enrolled host osquery info = %q, %q, %q", host.OsqueryVersion, host.OsqueryConfigHash, host.OsqueryExtensions)
+	}
+
+	// Re-enrolling without osquery_info keeps what was reported.
+	if _, err := repo.Enroll(ctx, "host-a", nil, orgID); err != nil {
+		t.Fatalf("Enroll again: %v", err)
+	}
+
+	now := time.Now().Truncate(time.Second)
+	vitals := func(version string, at time.Time) services.ResultLogEntry {
+		return services.ResultLogEntry{
+			HostID:    host.ID,
+			Name:      services.OsqueryInfoQueryName,
+			Action:    "snapshot",
+			Columns:   json.RawMessage(`{"version":"` + version + `","config_hash":"def","extensions":"active"}`),
+			Timestamp: at,
+		}
+	}
+	// The latest vitals in a batch win, whatever their order.
+	err = repo.SaveLogBatch(ctx, []services.ResultLogEntry{
+		vitals("5.12.1", now),
+		vitals("5.12.0", now.Add(-time.Hour)),
+		{HostID: host.ID, Name: "users", Action: "added", Columns: json.RawMessage(`{"version":"1"}`), Timestamp: now},
+	}, nil)
+	if err != nil {
+		t.Fatalf("SaveLogBatch: %v", err)
+	}
+
+	host, err = repo.GetByID(ctx, host.ID)
+	if err != nil || host == nil {
+		t.Fatalf("GetByID = %+v, %v", host, err)
+	}
+	if host.OsqueryVersion != "5.12.1" || host.OsqueryConfigHash != "def" || host.OsqueryExtensions != "active" {
+		t.Fatalf("osquery info after vitals = %q, %q, %q", host.OsqueryVersion, host.OsqueryConfigHash, host.OsqueryExtensions)
+	}
+	var info map[string]string
+	if err := json.Unmarshal(host.OsqueryInfo, &info); err != nil {
+		t.Fatalf("unmarshal osquery_info: %v", err)
+	}
+	if info["version"] != "5.12.1" || info["instance_id"] != "i-1" {
+		t.Fatalf("osquery_info = %v, want the new version merged into the enrolled info", info)
+	}
+}
@@ -0,0 +1,191 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+
+	"github.com/cavenine/queryops/internal/requestsign"
+)
+
+// Request signing modes, as stored in organization_settings.request_signing.
+const (
+	// RequestSigningOff doesn't check signatures.
+	RequestSigningOff = "off"
+	// RequestSigningMonitor logs requests from hosts with a signing key that
+	// aren't signed with it, and records which hosts sign, for rolling
+	// signing out.
+	RequestSigningMonitor = "monitor"
+	// RequestSigningEnforce rejects requests that aren't signed with the
+	// host's key, including re-enrollments of hosts that have one.
+	RequestSigningEnforce = "enforce"
+)
+
+// RequestSigningModes lists the request signing modes in rollout order.
+var RequestSigningModes = []string{RequestSigningOff, RequestSigningMonitor, RequestSigningEnforce}
+
+var ErrInvalidRequestSigningMode = errors.New("request signing mode must be off, monitor, or enforce")
+
+// SigningSalt is what an enrolled host's signing key is derived from.
+type SigningSalt struct {
+	HostID uuid.UUID
+	Salt   []byte
+}
+
+// RequestSigningStatus is how far an organization has rolled out request
+// signing: how many of its hosts have a signing key, and how many sent a
+// signed config request in the last day.
+type RequestSigningStatus struct {
+	OrganizationID uuid.UUID
+	Name           string
+	Mode           string
+	Hosts          int
+	Keyed          int
+	Signing        int
+}
+
+// RequestSigningMode returns the organization's request signing mode.
+func (r *HostRepository) RequestSigningMode(ctx context.Context, organizationID uuid.UUID) (string, error) {
+	var mode string
+	err := r.pool.QueryRow(ctx, `
+		SELECT COALESCE((SELECT request_signing FROM organization_settings WHERE organization_id = $1), $2)
+	`, organizationID, RequestSigningOff).Scan(&mode)
+	if err != nil {
+		return "", fmt.Errorf("getting request signing mode: %w", err)
+	}
+	return mode, nil
+}
+
+// SetRequestSigningMode sets the organization's request signing mode, one of
+// RequestSigningModes.
+func (r *HostRepository) SetRequestSigningMode(ctx context.Context, organizationID uuid.UUID, mode string) error {
+	if !slices.Contains(RequestSigningModes, mode) {
+		return ErrInvalidRequestSigningMode
+	}
+	_, err := r.pool.Exec(ctx, `
+		INSERT INTO organization_settings (organization_id, request_signing)
+		VALUES ($1, $2)
+		ON CONFLICT (organization_id)
+		DO UPDATE SET request_signing = EXCLUDED.request_signing, updated_at = NOW()
+	`, organizationID, mode)
+	if err != nil {
+		return fmt.Errorf("setting request signing mode: %w", err)
+	}
+	return nil
+}
+
+// EnrollmentSigningSalts returns the salts of the organization's hosts with
+// a signing key that an enrollment as hostIdentifier with hostDetails could
+// enroll into: those of that host identifier, or of the hardware UUID or
+// osquery instance the details name.
+func (r *HostRepository) EnrollmentSigningSalts(ctx context.Context, organizationID uuid.UUID, hostIdentifier string, hostDetails json.RawMessage) ([]SigningSalt, error) {
+	d := parseHostDetails(hostDetails)
+	var instanceKey string
+	if d.instanceID != "" {
+		instanceKey = instanceKeyPrefix + d.instanceID
+	}
+	rows, err := r.pool.Query(ctx, `
+		SELECT id, signing_salt FROM hosts
+		WHERE organization_id = $1 AND signing_salt IS NOT NULL
+			AND (host_identifier = $2 OR (identity_key <> '' AND identity_key IN ($3, $4)))
+	`, organizationID, hostIdentifier, d.hardwareUUID, instanceKey)
+	if err != nil {
+		return nil, fmt.Errorf("getting enrollment signing salts: %w", err)
+	}
+	salts, err := pgx.CollectRows(rows, func(row pgx.CollectableRow) (SigningSalt, error) {
+		var s SigningSalt
+		err := row.Scan(&s.HostID, &s.Salt)
+		return s, err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("getting enrollment signing salts: %w", err)
+	}
+	return salts, nil
+}
+
+// IssueSigningSalt gives the host holding nodeKey a signing key salt if it
+// has none, and returns it. It returns a nil salt if the host already had
+// one, which is never issued again: a host that loses its key needs it
+// reset with ResetSigningKey.
+func (r *HostRepository) IssueSigningSalt(ctx context.Context, nodeKey string) (uuid.UUID, []byte, error) {
+	salt, err := requestsign.NewSalt()
+	if err != nil {
+		return uuid.Nil, nil, fmt.Errorf("issuing signing salt: %w", err)
+	}
+	var hostID uuid.UUID
+	err = r.pool.QueryRow(ctx, `
+		UPDATE hosts SET signing_salt = $2
+		WHERE node_key_hash = $1 AND signing_salt IS NULL
+		RETURNING id
+	`, HashNodeKey(nodeKey), salt).Scan(&hostID)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return uuid.Nil, nil, nil
+	}
+	if err != nil {
+		return uuid.Nil, nil, fmt.Errorf("issuing signing salt: %w", err)
+	}
+	return hostID, salt, nil
+}
+
+// RecordSignedRequest notes that the host sent a request signed with its
+// key.
+func (r *HostRepository) RecordSignedRequest(ctx context.Context, hostID uuid.UUID) error {
+	if _, err := r.pool.Exec(ctx, `UPDATE hosts SET signed_request_at = NOW() WHERE id = $1`, hostID); err != nil {
+		return fmt.Errorf("recording signed request: %w", err)
+	}
+	return nil
+}
+
+// ResetSigningKey drops the host's signing key and node keys, so that it
+// enrolls again and is issued a new key, as when a host that was
+// reinstalled lost its key. It reports whether the host was found.
+func (r *HostRepository) ResetSigningKey(ctx context.Context, organizationID, hostID uuid.UUID) (bool, error) {
+	// node_key_hash must be unique and not null; a hash of a random UUID
+	// matches no key a host holds.
+	tag, err := r.pool.Exec(ctx, `
+		UPDATE hosts
+		SET signing_salt = NULL,
+			signed_request_at = NULL,
+			node_key_hash = sha256(convert_to(gen_random_uuid()::text, 'UTF8')),
+			previous_node_key_hash = NULL,
+			updated_at = NOW()
+		WHERE id = $1 AND organization_id = $2
+	`, hostID, organizationID)
+	if err != nil {
+		return false, fmt.Errorf("resetting signing key: %w", err)
+	}
+	return tag.RowsAffected() > 0, nil
+}
+
+// ListRequestSigningStatus returns every organization's request signing
+// rollout, by organization name.
+func (r *HostRepository) ListRequestSigningStatus(ctx context.Context) ([]RequestSigningStatus, error) {
+	rows, err := r.pool.Query(ctx, `
+		SELECT o.id, o.name, COALESCE(s.request_signing, $1),
+			COUNT(h.id),
+			COUNT(h.id) FILTER (WHERE h.signing_salt IS NOT NULL),
+			COUNT(h.id) FILTER (WHERE h.signed_request_at > NOW() - INTERVAL '1 day')
+		FROM organizations o
+		LEFT JOIN organization_settings s ON s.organization_id = o.id
+		LEFT JOIN hosts h ON h.organization_id = o.id
+		GROUP BY o.id, o.name, s.request_signing
+		ORDER BY o.name, o.id
+	`, RequestSigningOff)
+	if err != nil {
+		return nil, fmt.Errorf("listing request signing status: %w", err)
+	}
+	statuses, err := pgx.CollectRows(rows, func(row pgx.CollectableRow) (RequestSigningStatus, error) {
+		var s RequestSigningStatus
+		err := row.Scan(&s.OrganizationID, &s.Name, &s.Mode, &s.Hosts, &s.Keyed, &s.Signing)
+		return s, err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("listing request signing status: %w", err)
+	}
+	return statuses, nil
+}
@@ -0,0 +1,80 @@
+package services_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/cavenine/queryops/features/osquery/services"
+	"github.com/cavenine/queryops/internal/testdb"
+	"github.com/cavenine/queryops/internal/testdb/fixtures"
+)
+
+func TestHostRepository_RequestSigning(t *testing.T) {
+	tdb := testdb.SetupTestDB(t)
+	ctx := context.Background()
+
+	orgID := fixtures.CreateOrg(t, tdb.Pool, "signing-org").ID
+	host := fixtures.CreateHost(t, tdb.Pool, orgID, "host-a")
+	repo := services.NewHostRepository(tdb.Pool)
+
+	if mode, err := repo.RequestSigningMode(ctx, orgID); err != nil || mode != services.RequestSigningOff {
+		t.Fatalf("RequestSigningMode = %q, %v; want off", mode, err)
+	}
+	if err := repo.SetRequestSigningMode(ctx, orgID, "strict"); !errors.Is(err, services.ErrInvalidRequestSigningMode) {
+		t.Fatalf("SetRequestSigningMode(strict) err = %v", err)
+	}
+	if err := repo.SetRequestSigningMode(ctx, orgID, services.RequestSigningEnforce); err != nil {
+		t.Fatalf("SetRequestSigningMode: %v", err)
+	}
+	if mode, err := repo.RequestSigningMode(ctx, orgID); err != nil || mode != services.RequestSigningEnforce {
+		t.Fatalf("RequestSigningMode = %q, %v; want enforce", mode, err)
+	}
+
+	hostID, salt, err := repo.IssueSigningSalt(ctx, host.NodeKey)
+	if err != nil || hostID != host.ID || len(salt) == 0 {
+		t.Fatalf("IssueSigningSalt = %v, %x, %v", hostID, salt, err)
+	}
+	if _, again, err := repo.IssueSigningSalt(ctx, host.NodeKey); err != nil || again != nil {
+		t.Fatalf("second IssueSigningSalt = %x, %v; want no salt", again, err)
+	}
+
+	salts, err := repo.EnrollmentSigningSalts(ctx, orgID, "host-a", nil)
+	if err != nil || len(salts) != 1 || salts[0].HostID != host.ID || string(salts[0].Salt) != string(salt) {
+		t.Fatalf("EnrollmentSigningSalts = %+v, %v", salts, err)
+	}
+	if salts, err := repo.EnrollmentSigningSalts(ctx, orgID, "host-b", nil); err != nil || len(salts) != 0 {
+		t.Fatalf("EnrollmentSigningSalts(host-b) = %+v, %v", salts, err)
+	}
+
+	if err := repo.RecordSignedRequest(ctx, host.ID); err != nil {
+		t.Fatalf("RecordSignedRequest: %v", err)
+	}
+	statuses, err := repo.ListRequestSigningStatus(ctx)
+	if err != nil {
+		t.Fatalf("ListRequestSigningStatus: %v", err)
+	}
+	var found bool
+	for _, s := range statuses {
+		if s.OrganizationID != orgID {
+			continue
+		}
+		found = true
+		if s.Mode != services.RequestSigningEnforce || s.Hosts != 1 || s.Keyed != 1 || s.Signing != 1 {
+			t.Fatalf("status = %+v", s)
+		}
+	}
+	if !found {
+		t.Fatalf("no status for the organization: %+v", statuses)
+	}
+
+	if ok, err := repo.ResetSigningKey(ctx, orgID, host.ID); err != nil || !ok {
+		t.Fatalf("ResetSigningKey = %v, %v", ok, err)
+	}
+	if h, err := repo.GetByNodeKey(ctx, host.NodeKey); err == nil && h != nil {
+		t.Fatalf("old node key still resolves to %v after reset", h.ID)
+	}
+	if salts, err := repo.EnrollmentSigningSalts(ctx, orgID, "host-a", nil); err != nil || len(salts) != 0 {
+		t.Fatalf("EnrollmentSigningSalts after reset = %+v, %v", salts, err)
+	}
+}
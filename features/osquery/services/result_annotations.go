@@ -0,0 +1,188 @@
+package services
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/google/uuid"
+
+	"github.com/cavenine/queryops/internal/outbox"
+	"github.com/cavenine/queryops/internal/pubsub"
+)
+
+// Triage states an annotation gives a result row or host.
+const (
+	AnnotationTriaged       = "triaged"
+	AnnotationSuspicious    = "suspicious"
+	AnnotationFalsePositive = "false_positive"
+)
+
+// AnnotationStates lists the triage states in the order the results page
+// offers them.
+var AnnotationStates = []string{AnnotationSuspicious, AnnotationTriaged, AnnotationFalsePositive}
+
+// AnnotationCommentMaxLength bounds annotation comments.
+const AnnotationCommentMaxLength = 2000
+
+var (
+	ErrInvalidAnnotation = errors.New("unknown triage state, or a comment longer than 2000 characters")
+	// ErrResultNotFound is returned when annotating a host that isn't one of
+	// the campaign's targets, or a row it didn't return.
+	ErrResultNotFound = errors.New("result not found")
+)
+
+// ResultAnnotation is an analyst's triage of one of a campaign's result
+// rows, or of everything a host returned when RowKey is empty.
+type ResultAnnotation struct {
+	CampaignID     uuid.UUID `json:"campaign_id"`
+	HostID         uuid.UUID `json:"host_id"`
+	RowKey         string    `json:"row_key,omitempty"`
+	State          string    `json:"state"`
+	Comment        string    `json:"comment,omitempty"`
+	UpdatedByEmail *string   `json:"updated_by,omitempty"`
+	UpdatedAt      time.Time `json:"updated_at"`
+}
+
+// ResultAnnotationKey identifies what an annotation is on.
+type ResultAnnotationKey struct {
+	HostID uuid.UUID
+	RowKey string
+}
+
+// ResultAnnotations are a campaign's annotations by what they're on.
+type ResultAnnotations map[ResultAnnotationKey]*ResultAnnotation
+
+// Row returns the annotation on a row, or nil.
+func (a ResultAnnotations) Row(row CampaignResultRow) *ResultAnnotation {
+	return a[ResultAnnotationKey{HostID: row.HostID, RowKey: row.RowKey}]
+}
+
+// Host returns the annotation on a host's results as a whole, or nil.
+func (a ResultAnnotations) Host(hostID uuid.UUID) *ResultAnnotation {
+	return a[ResultAnnotationKey{HostID: hostID}]
+}
+
+// List returns the annotations least recently updated first.
+func (a ResultAnnotations) List() []*ResultAnnotation {
+	list := make([]*ResultAnnotation, 0, len(a))
+	for _, annotation := range a {
+		list = append(list, annotation)
+	}
+	slices.SortFunc(list, func(x, y *ResultAnnotation) int {
+		return cmp.Or(
+			x.UpdatedAt.Compare(y.UpdatedAt),
+			bytes.Compare(x.HostID[:], y.HostID[:]),
+			strings.Compare(x.RowKey, y.RowKey),
+		)
+	})
+	return list
+}
+
+// AnnotateResult sets the triage state and comment of one of the campaign's
+// result rows, or of a host's results when rowKey is empty, replacing any
+// earlier annotation. An empty state removes it. Teammates watching the
+// campaign see the change through pubsub.TopicCampaign.
+func (r *HostRepository) AnnotateResult(
+	ctx context.Context,
+	organizationID, campaignID, hostID uuid.UUID,
+	rowKey, state, comment string,
+	updatedBy *int,
+) error {
+	if (state != "" && !slices.Contains(AnnotationStates, state)) || utf8.RuneCountInString(comment) > AnnotationCommentMaxLength {
+		return ErrInvalidAnnotation
+	}
+
+	tx, err := r.pool.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("annotating result: begin transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	var campaignFound, resultFound bool
+	err = tx.QueryRow(ctx, `
+		SELECT
+			EXISTS (SELECT 1 FROM campaigns WHERE id = $1 AND organization_id = $2),
+			EXISTS (
+				SELECT 1 FROM campaign_targets t
+				WHERE t.campaign_id = $1 AND t.host_id = $3
+					AND ($4 = '' OR EXISTS (
+						SELECT 1 FROM jsonb_array_elements(`+resultArray+`) e WHERE md5(e::text) = $4
+					))
+			)
+	`, campaignID, organizationID, hostID, rowKey).Scan(&campaignFound, &resultFound)
+	if err != nil {
+		return fmt.Errorf("annotating result: %w", err)
+	}
+	if !campaignFound {
+		return ErrCampaignNotFound
+	}
+	if !resultFound {
+		return ErrResultNotFound
+	}
+
+	if state == "" {
+		_, err = tx.Exec(ctx, `
+			DELETE FROM result_annotations WHERE campaign_id = $1 AND host_id = $2 AND row_key = $3
+		`, campaignID, hostID, rowKey)
+	} else {
+		_, err = tx.Exec(ctx, `
+			INSERT INTO result_annotations (organization_id, campaign_id, host_id, row_key, state, comment, updated_by)
+			VALUES ($1, $2, $3, $4, $5, $6, $7)
+			ON CONFLICT (campaign_id, host_id, row_key) DO UPDATE
+			SET state = EXCLUDED.state, comment = EXCLUDED.comment, updated_by = EXCLUDED.updated_by, updated_at = NOW()
+		`, organizationID, campaignID, hostID, rowKey, state, comment, updatedBy)
+	}
+	if err != nil {
+		return fmt.Errorf("annotating result: %w", err)
+	}
+
+	event := pubsub.ResultAnnotationEvent{
+		CampaignID: campaignID,
+		HostID:     hostID,
+		RowKey:     rowKey,
+		State:      state,
+		OccurredAt: time.Now().UTC(),
+	}
+	if err := outbox.Insert(ctx, tx, outbox.Event{Topic: pubsub.TopicCampaign(campaignID), Message: event.ToMessage()}); err != nil {
+		return fmt.Errorf("annotating result: %w", err)
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return fmt.Errorf("annotating result: commit transaction: %w", err)
+	}
+	return nil
+}
+
+// ListResultAnnotations returns the campaign's annotations.
+func (r *HostRepository) ListResultAnnotations(ctx context.Context, campaignID uuid.UUID) (ResultAnnotations, error) {
+	rows, err := r.pool.Query(ctx, `
+		SELECT a.campaign_id, a.host_id, a.row_key, a.state, a.comment, u.email, a.updated_at
+		FROM result_annotations a
+		LEFT JOIN users u ON u.id = a.updated_by
+		WHERE a.campaign_id = $1
+	`, campaignID)
+	if err != nil {
+		return nil, fmt.Errorf("listing result annotations: %w", err)
+	}
+	defer rows.Close()
+
+	annotations := ResultAnnotations{}
+	for rows.Next() {
+		var a ResultAnnotation
+		if err := rows.Scan(&a.CampaignID, &a.HostID, &a.RowKey, &a.State, &a.Comment, &a.UpdatedByEmail, &a.UpdatedAt); err != nil {
+			return nil, fmt.Errorf("scanning result annotation: %w", err)
+		}
+		annotations[ResultAnnotationKey{HostID: a.HostID, RowKey: a.RowKey}] = &a
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("listing result annotations: %w", err)
+	}
+	return annotations, nil
+}
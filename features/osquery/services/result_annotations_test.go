@@ -0,0 +1,105 @@
+package services_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/cavenine/queryops/features/osquery/services"
+	"github.com/cavenine/queryops/internal/pubsub"
+	"github.com/cavenine/queryops/internal/testdb"
+	"github.com/cavenine/queryops/internal/testdb/fixtures"
+	"github.com/google/uuid"
+)
+
+func TestHostRepository_AnnotateResult(t *testing.T) {
+	tdb := testdb.SetupTestDB(t)
+	ctx := context.Background()
+
+	orgID := fixtures.CreateOrg(t, tdb.Pool, "result-annotations-org").ID
+	otherOrgID := fixtures.CreateOrg(t, tdb.Pool, "result-annotations-other-org").ID
+	hostA := fixtures.CreateHost(t, tdb.Pool, orgID, "host-a").ID
+	hostB := fixtures.CreateHost(t, tdb.Pool, orgID, "host-b").ID
+	user := fixtures.CreateUser(t, tdb.Pool, "analyst@example.com")
+	repo := services.NewHostRepository(tdb.Pool)
+
+	campaignID, err := repo.QueueQuery(ctx, orgID, nil, nil, nil, "select name from processes", []uuid.UUID{hostA}, services.CampaignOptions{})
+	if err != nil {
+		t.Fatalf("QueueQuery: %v", err)
+	}
+	if err := repo.SaveQueryResults(ctx, hostA, campaignID, "completed", json.RawMessage(`[{"name":"sshd"},{"name":"nc"}]`), nil, false); err != nil {
+		t.Fatalf("SaveQueryResults: %v", err)
+	}
+
+	res, err := repo.QueryCampaignResults(ctx, campaignID, services.CampaignResultView{})
+	if err != nil || len(res.Rows) != 2 {
+		t.Fatalf("QueryCampaignResults = %+v, %v", res, err)
+	}
+	nc := res.Rows[1]
+	if nc.Values["name"] != "nc" || nc.RowKey == "" || nc.RowKey == res.Rows[0].RowKey {
+		t.Fatalf("rows = %+v, want distinct row keys", res.Rows)
+	}
+
+	if err := repo.AnnotateResult(ctx, orgID, campaignID, hostA, nc.RowKey, services.AnnotationSuspicious, "listener", &user.ID); err != nil {
+		t.Fatalf("AnnotateResult: %v", err)
+	}
+	if err := repo.AnnotateResult(ctx, orgID, campaignID, hostA, "", services.AnnotationTriaged, "", &user.ID); err != nil {
+		t.Fatalf("AnnotateResult(host): %v", err)
+	}
+
+	res, err = repo.QueryCampaignResults(ctx, campaignID, services.CampaignResultView{})
+	if err != nil {
+		t.Fatalf("QueryCampaignResults: %v", err)
+	}
+	row := res.Annotations.Row(res.Rows[1])
+	if row == nil || row.State != services.AnnotationSuspicious || row.Comment != "listener" || row.UpdatedByEmail == nil || *row.UpdatedByEmail != "analyst@example.com" {
+		t.Fatalf("row annotation = %+v", row)
+	}
+	if host := res.Annotations.Host(hostA); host == nil || host.State != services.AnnotationTriaged {
+		t.Fatalf("host annotation = %+v", host)
+	}
+	if res.Annotations.Row(res.Rows[0]) != nil {
+		t.Fatal("unannotated row has an annotation")
+	}
+
+	// Changing an annotation replaces it, and an empty state removes it.
+	if err := repo.AnnotateResult(ctx, orgID, campaignID, hostA, nc.RowKey, services.AnnotationFalsePositive, "", &user.ID); err != nil {
+		t.Fatalf("AnnotateResult(change): %v", err)
+	}
+	if err := repo.AnnotateResult(ctx, orgID, campaignID, hostA, "", "", "", &user.ID); err != nil {
+		t.Fatalf("AnnotateResult(remove): %v", err)
+	}
+	annotations, err := repo.ListResultAnnotations(ctx, campaignID)
+	if err != nil {
+		t.Fatalf("ListResultAnnotations: %v", err)
+	}
+	if list := annotations.List(); len(list) != 1 || list[0].State != services.AnnotationFalsePositive || list[0].Comment != "" {
+		t.Fatalf("annotations = %+v, want the row's false positive", list)
+	}
+
+	var published int
+	if err := tdb.Pool.QueryRow(ctx, `
+		SELECT count(*) FROM pubsub_outbox WHERE topic = $1 AND metadata->>'event_type' = $2
+	`, pubsub.TopicCampaign(campaignID), pubsub.EventTypeResultAnnotation).Scan(&published); err != nil {
+		t.Fatalf("counting outbox events: %v", err)
+	}
+	if published != 4 {
+		t.Fatalf("outbox events = %d, want one per change", published)
+	}
+
+	for name, tc := range map[string]struct {
+		orgID, hostID uuid.UUID
+		rowKey, state string
+		want          error
+	}{
+		"another organization": {otherOrgID, hostA, nc.RowKey, services.AnnotationTriaged, services.ErrCampaignNotFound},
+		"not a target":         {orgID, hostB, "", services.AnnotationTriaged, services.ErrResultNotFound},
+		"unknown row":          {orgID, hostA, "0123", services.AnnotationTriaged, services.ErrResultNotFound},
+		"unknown state":        {orgID, hostA, "", "benign", services.ErrInvalidAnnotation},
+	} {
+		if err := repo.AnnotateResult(ctx, tc.orgID, campaignID, tc.hostID, tc.rowKey, tc.state, "", nil); !errors.Is(err, tc.want) {
+			t.Errorf("%s: err = %v, want %v", name, err, tc.want)
+		}
+	}
+}
@@ -0,0 +1,74 @@
+package services
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// ResultDiff is the change in a host's rows between two runs of a query.
+// Rows are compared as whole rows; a changed column shows up as one row
+// removed and one added.
+type ResultDiff struct {
+	Added   []map[string]string `json:"added"`
+	Removed []map[string]string `json:"removed"`
+}
+
+// Empty reports whether the results were identical.
+func (d *ResultDiff) Empty() bool {
+	return len(d.Added) == 0 && len(d.Removed) == 0
+}
+
+// DiffResults compares two distributed query result sets. Duplicate rows are
+// counted, so a row returned twice before and once now is reported removed
+// once. Added and removed rows keep their order in current and previous.
+func DiffResults(previous, current json.RawMessage) (*ResultDiff, error) {
+	prevRows, err := decodeRows(previous)
+	if err != nil {
+		return nil, fmt.Errorf("diffing results: previous: %w", err)
+	}
+	currRows, err := decodeRows(current)
+	if err != nil {
+		return nil, fmt.Errorf("diffing results: current: %w", err)
+	}
+
+	remaining := make(map[string]int, len(prevRows))
+	prevKeys := make([]string, len(prevRows))
+	for i, row := range prevRows {
+		prevKeys[i] = rowKey(row)
+		remaining[prevKeys[i]]++
+	}
+
+	d := &ResultDiff{Added: []map[string]string{}, Removed: []map[string]string{}}
+	for _, row := range currRows {
+		k := rowKey(row)
+		if remaining[k] > 0 {
+			remaining[k]--
+			continue
+		}
+		d.Added = append(d.Added, row)
+	}
+	for i, row := range prevRows {
+		if remaining[prevKeys[i]] > 0 {
+			remaining[prevKeys[i]]--
+			d.Removed = append(d.Removed, row)
+		}
+	}
+	return d, nil
+}
+
+func decodeRows(raw json.RawMessage) ([]map[string]string, error) {
+	if len(raw) == 0 || string(raw) == "null" {
+		return nil, nil
+	}
+	var rows []map[string]string
+	if err := json.Unmarshal(raw, &rows); err != nil {
+		return nil, err
+	}
+	return rows, nil
+}
+
+// rowKey is a canonical encoding of row; json.Marshal sorts map keys.
+func rowKey(row map[string]string) string {
+	b, _ := json.Marshal(row)
+	return string(b)
+}
@@ -0,0 +1,67 @@
+package services_test
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	"github.com/cavenine/queryops/features/osquery/services"
+)
+
+func TestDiffResults(t *testing.T) {
+	tests := []struct {
+		name        string
+		previous    string
+		current     string
+		wantAdded   []map[string]string
+		wantRemoved []map[string]string
+	}{
+		{
+			name:        "identical ignoring order",
+			previous:    `[{"a":"1","b":"2"},{"a":"3"}]`,
+			current:     `[{"a":"3"},{"b":"2","a":"1"}]`,
+			wantAdded:   []map[string]string{},
+			wantRemoved: []map[string]string{},
+		},
+		{
+			name:        "changed column",
+			previous:    `[{"pid":"1","name":"init"}]`,
+			current:     `[{"pid":"1","name":"systemd"}]`,
+			wantAdded:   []map[string]string{{"pid": "1", "name": "systemd"}},
+			wantRemoved: []map[string]string{{"pid": "1", "name": "init"}},
+		},
+		{
+			name:        "duplicates are counted",
+			previous:    `[{"a":"1"},{"a":"1"}]`,
+			current:     `[{"a":"1"}]`,
+			wantAdded:   []map[string]string{},
+			wantRemoved: []map[string]string{{"a": "1"}},
+		},
+		{
+			name:        "empty previous",
+			previous:    `null`,
+			current:     `[{"a":"1"}]`,
+			wantAdded:   []map[string]string{{"a": "1"}},
+			wantRemoved: []map[string]string{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d, err := services.DiffResults(json.RawMessage(tt.previous), json.RawMessage(tt.current))
+			if err != nil {
+				t.Fatalf("DiffResults: %v", err)
+			}
+			if !reflect.DeepEqual(d.Added, tt.wantAdded) {
+				t.Errorf("Added = %v, want %v", d.Added, tt.wantAdded)
+			}
+			if !reflect.DeepEqual(d.Removed, tt.wantRemoved) {
+				t.Errorf("Removed = %v, want %v", d.Removed, tt.wantRemoved)
+			}
+		})
+	}
+
+	if _, err := services.DiffResults(json.RawMessage(`{`), nil); err == nil {
+		t.Fatalf("DiffResults(invalid) succeeded")
+	}
+}
@@ -0,0 +1,234 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/riverqueue/river"
+
+	"github.com/cavenine/queryops/db"
+	orgServices "github.com/cavenine/queryops/features/organization/services"
+)
+
+// Result export statuses.
+const (
+	ExportPending   = "pending"
+	ExportCompleted = "completed"
+	ExportFailed    = "failed"
+)
+
+// MaxExportRange is the longest time range one export covers.
+const MaxExportRange = 366 * 24 * time.Hour
+
+var (
+	ErrResultExportNotFound = errors.New("result export not found")
+	ErrInvalidExportRange   = errors.New("export range must end after it starts and span at most 366 days")
+)
+
+// ResultExport is a requested export of an organization's scheduled query
+// results, from Start up to End, to Parquet files.
+type ResultExport struct {
+	ID             uuid.UUID `db:"id" json:"id"`
+	OrganizationID uuid.UUID `db:"organization_id" json:"organization_id"`
+	Start          time.Time `db:"range_start" json:"start"`
+	End            time.Time `db:"range_end" json:"end"`
+	Status         string    `db:"status" json:"status"`
+	// Destination is the location the files were written under.
+	Destination string     `db:"destination" json:"destination,omitempty"`
+	Files       int        `db:"files" json:"files"`
+	Rows        int64      `db:"row_count" json:"rows"`
+	Error       string     `db:"error" json:"error,omitempty"`
+	RequestedBy *int       `db:"requested_by" json:"requested_by,omitempty"`
+	CreatedAt   time.Time  `db:"created_at" json:"created_at"`
+	CompletedAt *time.Time `db:"completed_at" json:"completed_at,omitempty"`
+}
+
+var resultExportColumns = db.Columns[ResultExport]()
+
+// ExportResultsArgs runs a pending result export. It's declared here rather
+// than in background so Request can enqueue it in the transaction that
+// creates the export.
+type ExportResultsArgs struct {
+	ExportID uuid.UUID `json:"export_id"`
+}
+
+func (ExportResultsArgs) Kind() string {
+	return "export_results"
+}
+
+func (ExportResultsArgs) InsertOpts() river.InsertOpts {
+	// background.QueueIngest, with the other bulk data jobs.
+	return river.InsertOpts{Queue: "ingest", MaxAttempts: 3}
+}
+
+type ResultExportRepository struct {
+	pool *pgxpool.Pool
+	// jobs may be nil in processes that only run exports.
+	jobs orgServices.JobInserter
+}
+
+func NewResultExportRepository(pool *pgxpool.Pool, jobs orgServices.JobInserter) *ResultExportRepository {
+	return &ResultExportRepository{pool: pool, jobs: jobs}
+}
+
+// Request records a pending export of the organization's results from start
+// up to end and enqueues it.
+func (r *ResultExportRepository) Request(ctx context.Context, organizationID uuid.UUID, start, end time.Time, requestedBy *int) (*ResultExport, error) {
+	if !start.Before(end) || end.Sub(start) > MaxExportRange {
+		return nil, ErrInvalidExportRange
+	}
+	if r.jobs == nil {
+		return nil, errors.New("result export repository has no job inserter")
+	}
+
+	tx, err := r.pool.Begin(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("requesting result export: begin transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	rows, err := tx.Query(ctx, `
+		INSERT INTO result_exports (organization_id, range_start, range_end, requested_by)
+		VALUES ($1, $2, $3, $4)
+		RETURNING `+resultExportColumns,
+		organizationID, start, end, requestedBy)
+	if err != nil {
+		return nil, fmt.Errorf("inserting result export: %w", err)
+	}
+	export, err := pgx.CollectOneRow(rows, pgx.RowToAddrOfStructByName[ResultExport])
+	if err != nil {
+		return nil, fmt.Errorf("inserting result export: %w", err)
+	}
+	if _, err := r.jobs.InsertTx(ctx, tx, ExportResultsArgs{ExportID: export.ID}, nil); err != nil {
+		return nil, fmt.Errorf("enqueueing result export: %w", err)
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return nil, fmt.Errorf("requesting result export: commit transaction: %w", err)
+	}
+	return export, nil
+}
+
+// List returns the organization's most recent exports, newest first.
+func (r *ResultExportRepository) List(ctx context.Context, organizationID uuid.UUID, limit int) ([]ResultExport, error) {
+	rows, err := r.pool.Query(ctx, `
+		SELECT `+resultExportColumns+`
+		FROM result_exports
+		WHERE organization_id = $1
+		ORDER BY created_at DESC, id
+		LIMIT $2
+	`, organizationID, limit)
+	if err != nil {
+		return nil, fmt.Errorf("listing result exports: %w", err)
+	}
+	exports, err := pgx.CollectRows(rows, pgx.RowToStructByName[ResultExport])
+	if err != nil {
+		return nil, fmt.Errorf("scanning result exports: %w", err)
+	}
+	return exports, nil
+}
+
+// GetForOrganization returns one of the organization's exports, or
+// ErrResultExportNotFound.
+func (r *ResultExportRepository) GetForOrganization(ctx context.Context, organizationID, id uuid.UUID) (*ResultExport, error) {
+	return r.get(ctx, `id = $1 AND organization_id = $2`, id, organizationID)
+}
+
+// Get returns an export regardless of organization, for the export job.
+func (r *ResultExportRepository) Get(ctx context.Context, id uuid.UUID) (*ResultExport, error) {
+	return r.get(ctx, `id = $1`, id)
+}
+
+func (r *ResultExportRepository) get(ctx context.Context, where string, args ...any) (*ResultExport, error) {
+	rows, err := r.pool.Query(ctx, `SELECT `+resultExportColumns+` FROM result_exports WHERE `+where, args...)
+	if err != nil {
+		return nil, fmt.Errorf("querying result export: %w", err)
+	}
+	export, err := pgx.CollectOneRow(rows, pgx.RowToAddrOfStructByName[ResultExport])
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, ErrResultExportNotFound
+		}
+		return nil, fmt.Errorf("querying result export: %w", err)
+	}
+	return export, nil
+}
+
+// Complete records where a pending export's files were written.
+func (r *ResultExportRepository) Complete(ctx context.Context, id uuid.UUID, destination string, files int, rows int64) error {
+	if _, err := r.pool.Exec(ctx, `
+		UPDATE result_exports
+		SET status = 'completed', destination = $2, files = $3, row_count = $4, completed_at = NOW()
+		WHERE id = $1 AND status = 'pending'
+	`, id, destination, files, rows); err != nil {
+		return fmt.Errorf("completing result export: %w", err)
+	}
+	return nil
+}
+
+// Fail marks a pending export as failed with a message for the requester.
+func (r *ResultExportRepository) Fail(ctx context.Context, id uuid.UUID, message string) error {
+	if _, err := r.pool.Exec(ctx, `
+		UPDATE result_exports
+		SET status = 'failed', error = $2, completed_at = NOW()
+		WHERE id = $1 AND status = 'pending'
+	`, id, message); err != nil {
+		return fmt.Errorf("failing result export: %w", err)
+	}
+	return nil
+}
+
+// ExportedResult is a scheduled query result row as exported.
+type ExportedResult struct {
+	HostID         uuid.UUID       `db:"host_id"`
+	HostIdentifier string          `db:"host_identifier"`
+	Name           string          `db:"name"`
+	Action         string          `db:"action"`
+	Columns        json.RawMessage `db:"columns"`
+	// Timestamp is when osquery logged the result, if it said.
+	Timestamp *time.Time `db:"timestamp"`
+	// CreatedAt is when QueryOps stored the result.
+	CreatedAt time.Time `db:"created_at"`
+	// Decorations are the decorator values osquery logged the result with,
+	// or nil if it sent none.
+	Decorations json.RawMessage `db:"decorations"`
+}
+
+// EachResult calls fn with each of the organization's scheduled query results
+// stored from start up to end, ordered by the UTC date they were stored,
+// then query name, then time, so results of the same date and query are
+// adjacent. It stops at the first error fn returns.
+func (r *ResultExportRepository) EachResult(ctx context.Context, organizationID uuid.UUID, start, end time.Time, fn func(ExportedResult) error) error {
+	rows, err := r.pool.Query(ctx, `
+		SELECT r.host_id, h.host_identifier, r.name, r.action, r.columns, r.timestamp, r.created_at, r.decorations
+		FROM osquery_results r
+		JOIN hosts h ON h.id = r.host_id
+		WHERE h.organization_id = $1
+			AND r.created_at >= $2
+			AND r.created_at < $3
+		ORDER BY (r.created_at AT TIME ZONE 'UTC')::date, r.name, r.created_at, r.id
+	`, organizationID, start, end)
+	if err != nil {
+		return fmt.Errorf("querying results to export: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		result, err := pgx.RowToStructByName[ExportedResult](rows)
+		if err != nil {
+			return fmt.Errorf("scanning result to export: %w", err)
+		}
+		if err := fn(result); err != nil {
+			return err
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("iterating results to export: %w", err)
+	}
+	return nil
+}
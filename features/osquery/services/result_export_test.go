@@ -0,0 +1,143 @@
+package services_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/riverqueue/river"
+	"github.com/riverqueue/river/rivertype"
+
+	"github.com/cavenine/queryops/features/osquery/services"
+	"github.com/cavenine/queryops/internal/testdb"
+	"github.com/cavenine/queryops/internal/testdb/fixtures"
+)
+
+// recordingInserter records enqueued jobs instead of inserting them.
+type recordingInserter struct {
+	args []river.JobArgs
+}
+
+func (r *recordingInserter) InsertTx(_ context.Context, _ pgx.Tx, args river.JobArgs, _ *river.InsertOpts) (*rivertype.JobInsertResult, error) {
+	r.args = append(r.args, args)
+	return &rivertype.JobInsertResult{}, nil
+}
+
+func TestResultExportRepository(t *testing.T) {
+	tdb := testdb.SetupTestDB(t)
+	ctx := context.Background()
+
+	orgID := fixtures.CreateOrg(t, tdb.Pool, "export-org").ID
+	otherOrgID := fixtures.CreateOrg(t, tdb.Pool, "other-org").ID
+	jobs := &recordingInserter{}
+	repo := services.NewResultExportRepository(tdb.Pool, jobs)
+
+	start := time.Date(2026, 2, 9, 0, 0, 0, 0, time.UTC)
+	end := start.Add(48 * time.Hour)
+	if _, err := repo.Request(ctx, orgID, end, start, nil); !errors.Is(err, services.ErrInvalidExportRange) {
+		t.Fatalf("Request(backwards) err = %v, want ErrInvalidExportRange", err)
+	}
+	if _, err := repo.Request(ctx, orgID, start, start.Add(services.MaxExportRange+time.Hour), nil); !errors.Is(err, services.ErrInvalidExportRange) {
+		t.Fatalf("Request(too long) err = %v, want ErrInvalidExportRange", err)
+	}
+
+	export, err := repo.Request(ctx, orgID, start, end, nil)
+	if err != nil {
+		t.Fatalf("Request: %v", err)
+	}
+	if export.Status != services.ExportPending || !export.Start.Equal(start) || !export.End.Equal(end) {
+		t.Fatalf("export = %+v", export)
+	}
+	if len(jobs.args) != 1 || jobs.args[0].(services.ExportResultsArgs).ExportID != export.ID {
+		t.Fatalf("enqueued %+v, want the export's job", jobs.args)
+	}
+
+	if _, err := repo.GetForOrganization(ctx, otherOrgID, export.ID); !errors.Is(err, services.ErrResultExportNotFound) {
+		t.Fatalf("GetForOrganization(other org) err = %v, want ErrResultExportNotFound", err)
+	}
+	if exports, err := repo.List(ctx, orgID, 10); err != nil || len(exports) != 1 || exports[0].ID != export.ID {
+		t.Fatalf("List = %+v, %v", exports, err)
+	}
+
+	if err := repo.Complete(ctx, export.ID, "s3://bucket/org/", 2, 10); err != nil {
+		t.Fatalf("Complete: %v", err)
+	}
+	// A finished export can't fail afterwards.
+	if err := repo.Fail(ctx, export.ID, "late"); err != nil {
+		t.Fatalf("Fail: %v", err)
+	}
+	got, err := repo.Get(ctx, export.ID)
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	if got.Status != services.ExportCompleted || got.Files != 2 || got.Rows != 10 || got.Destination != "s3://bucket/org/" || got.Error != "" || got.CompletedAt == nil {
+		t.Fatalf("completed export = %+v", got)
+	}
+	if _, err := repo.Get(ctx, uuid.New()); !errors.Is(err, services.ErrResultExportNotFound) {
+		t.Fatalf("Get(unknown) err = %v, want ErrResultExportNotFound", err)
+	}
+}
+
+func TestResultExportRepository_EachResult(t *testing.T) {
+	tdb := testdb.SetupTestDB(t)
+	ctx := context.Background()
+
+	orgID := fixtures.CreateOrg(t, tdb.Pool, "export-org").ID
+	otherOrgID := fixtures.CreateOrg(t, tdb.Pool, "other-org").ID
+	host := fixtures.CreateHost(t, tdb.Pool, orgID, "host-a")
+	otherHost := fixtures.CreateHost(t, tdb.Pool, otherOrgID, "host-b")
+	repo := services.NewResultExportRepository(tdb.Pool, nil)
+
+	day := time.Date(2026, 2, 9, 0, 0, 0, 0, time.UTC)
+	for _, r := range []struct {
+		hostID uuid.UUID
+		name   string
+		at     time.Time
+	}{
+		{host.ID, "users", day.Add(25 * time.Hour)},
+		{host.ID, "users", day.Add(2 * time.Hour)},
+		{host.ID, "procs", day.Add(3 * time.Hour)},
+		{host.ID, "users", day.Add(time.Hour)},
+		// Out of range.
+		{host.ID, "users", day.Add(-time.Hour)},
+		{host.ID, "users", day.Add(48 * time.Hour)},
+		// Another organization's.
+		{otherHost.ID, "users", day.Add(time.Hour)},
+	} {
+		if _, err := tdb.Pool.Exec(ctx, `
+			INSERT INTO osquery_results (host_id, name, action, columns, created_at)
+			VALUES ($1, $2, 'added', '{}', $3)
+		`, r.hostID, r.name, r.at); err != nil {
+			t.Fatalf("inserting result: %v", err)
+		}
+	}
+
+	var got []string
+	err := repo.EachResult(ctx, orgID, day, day.Add(48*time.Hour), func(r services.ExportedResult) error {
+		if r.HostIdentifier != "host-a" {
+			t.Fatalf("result of host %s exported", r.HostIdentifier)
+		}
+		got = append(got, r.CreatedAt.UTC().Format("02 15:04 ")+r.Name)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("EachResult: %v", err)
+	}
+	want := []string{"09 03:00 procs", "09 01:00 users", "09 02:00 users", "10 01:00 users"}
+	if len(got) != len(want) {
+		t.Fatalf("EachResult = %v, want %v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("EachResult = %v, want %v", got, want)
+		}
+	}
+
+	stop := errors.New("stop")
+	if err := repo.EachResult(ctx, orgID, day, day.Add(48*time.Hour), func(services.ExportedResult) error { return stop }); !errors.Is(err, stop) {
+		t.Fatalf("EachResult err = %v, want fn's error", err)
+	}
+}
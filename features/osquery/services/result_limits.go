@@ -0,0 +1,36 @@
+package services
+
+import "encoding/json"
+
+// ResultLimits caps the results stored for each host in a campaign. A zero
+// field means no cap.
+type ResultLimits struct {
+	MaxRows  int
+	MaxBytes int64
+}
+
+// Truncate returns the longest prefix of rows within the limits, measuring
+// bytes as the rows' JSON encoding, and whether any rows were dropped. A
+// single row larger than MaxBytes leaves no rows.
+func (l ResultLimits) Truncate(rows []map[string]string) ([]map[string]string, bool) {
+	kept := rows
+	if l.MaxRows > 0 && len(kept) > l.MaxRows {
+		kept = kept[:l.MaxRows]
+	}
+	if l.MaxBytes > 0 {
+		size := int64(len("[]"))
+		for i, row := range kept {
+			// Marshaling a map[string]string can't fail.
+			b, _ := json.Marshal(row)
+			size += int64(len(b))
+			if i > 0 {
+				size++ // comma
+			}
+			if size > l.MaxBytes {
+				kept = kept[:i]
+				break
+			}
+		}
+	}
+	return kept, len(kept) < len(rows)
+}
@@ -0,0 +1,41 @@
+package services_test
+
+import (
+	"testing"
+
+	"github.com/cavenine/queryops/features/osquery/services"
+)
+
+func TestResultLimitsTruncate(t *testing.T) {
+	rows := []map[string]string{{"a": "1"}, {"a": "2"}, {"a": "3"}}
+	// Each row encodes as {"a":"N"}: 9 bytes.
+
+	tests := []struct {
+		name          string
+		limits        services.ResultLimits
+		wantRows      int
+		wantTruncated bool
+	}{
+		{name: "no limits", limits: services.ResultLimits{}, wantRows: 3},
+		{name: "rows under cap", limits: services.ResultLimits{MaxRows: 3}, wantRows: 3},
+		{name: "rows over cap", limits: services.ResultLimits{MaxRows: 2}, wantRows: 2, wantTruncated: true},
+		// [ + 9 + , + 9 + , + 9 + ] = 31 bytes.
+		{name: "bytes exactly at cap", limits: services.ResultLimits{MaxBytes: 31}, wantRows: 3},
+		{name: "bytes over cap", limits: services.ResultLimits{MaxBytes: 30}, wantRows: 2, wantTruncated: true},
+		{name: "first row over cap", limits: services.ResultLimits{MaxBytes: 5}, wantRows: 0, wantTruncated: true},
+		{name: "rows and bytes", limits: services.ResultLimits{MaxRows: 2, MaxBytes: 15}, wantRows: 1, wantTruncated: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, truncated := tt.limits.Truncate(rows)
+			if len(got) != tt.wantRows || truncated != tt.wantTruncated {
+				t.Fatalf("Truncate = %d rows, truncated %v; want %d, %v", len(got), truncated, tt.wantRows, tt.wantTruncated)
+			}
+			for i := range got {
+				if got[i]["a"] != rows[i]["a"] {
+					t.Fatalf("row %d = %v, want %v", i, got[i], rows[i])
+				}
+			}
+		})
+	}
+}
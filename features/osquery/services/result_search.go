@@ -0,0 +1,205 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	SearchSourceLogs      = "logs"
+	SearchSourceCampaigns = "campaigns"
+)
+
+// ResultSearch filters stored results. At least one of Text or Match is
+// required.
+type ResultSearch struct {
+	// Text is matched against JSON string values with Postgres full-text
+	// search, e.g. "sshd".
+	Text string
+	// Match requires every key to equal its value in a row, e.g.
+	// {"name": "sshd"}.
+	Match map[string]string
+
+	// Source limits the search to SearchSourceLogs or SearchSourceCampaigns;
+	// empty searches both.
+	Source string
+	Since  *time.Time
+	Until  *time.Time
+
+	Before *SearchCursor
+	Limit  int
+}
+
+// SearchHit is one result log line, or one host's matching campaign rows.
+// Name is the scheduled query name for logs and the SQL for campaigns.
+type SearchHit struct {
+	Source         string          `json:"source"`
+	HostID         uuid.UUID       `json:"host_id"`
+	HostIdentifier string          `json:"host_identifier"`
+	Name           string          `json:"name"`
+	CampaignID     *uuid.UUID      `json:"campaign_id,omitempty"`
+	Action         *string         `json:"action,omitempty"`
+	Rows           json.RawMessage `json:"rows"`
+	At             time.Time       `json:"at"`
+
+	key string
+}
+
+// Cursor returns the keyset position of the hit for fetching the next page.
+func (h SearchHit) Cursor() SearchCursor {
+	return SearchCursor{At: h.At, Key: h.key}
+}
+
+// SearchCursor is a keyset position over search hits, ordered by time and
+// then by a per-source key, descending.
+type SearchCursor struct {
+	At  time.Time
+	Key string
+}
+
+func (c SearchCursor) String() string {
+	return fmt.Sprintf("%d.%s", c.At.UnixMicro(), c.Key)
+}
+
+// ParseSearchCursor decodes a cursor produced by SearchCursor.String.
+func ParseSearchCursor(s string) (SearchCursor, error) {
+	micros, key, ok := strings.Cut(s, ".")
+	if !ok || key == "" {
+		return SearchCursor{}, fmt.Errorf("parsing search cursor: malformed cursor %q", s)
+	}
+	us, err := strconv.ParseInt(micros, 10, 64)
+	if err != nil {
+		return SearchCursor{}, fmt.Errorf("parsing search cursor timestamp: %w", err)
+	}
+	return SearchCursor{At: time.UnixMicro(us).UTC(), Key: key}, nil
+}
+
+// SearchResults finds result log lines and campaign results in the
+// organization that match s, newest first. Campaign hits carry only the rows
+// that matched. The filters are only added when set, so the planner can use
+// the GIN indexes on columns and results.
+func (r *HostRepository) SearchResults(ctx context.Context, organizationID uuid.UUID, s ResultSearch) ([]SearchHit, error) {
+	if s.Text == "" && len(s.Match) == 0 {
+		return nil, errors.New("searching results: text or match is required")
+	}
+	if s.Source != "" && s.Source != SearchSourceLogs && s.Source != SearchSourceCampaigns {
+		return nil, fmt.Errorf("searching results: unknown source %q", s.Source)
+	}
+	if s.Limit <= 0 {
+		s.Limit = 50
+	}
+
+	args := []any{organizationID}
+	arg := func(v any) string {
+		args = append(args, v)
+		return "$" + strconv.Itoa(len(args))
+	}
+
+	var match, text string
+	if len(s.Match) > 0 {
+		b, err := json.Marshal(s.Match)
+		if err != nil {
+			return nil, fmt.Errorf("searching results: encoding match: %w", err)
+		}
+		match = arg(string(b)) + "::jsonb"
+	}
+	if s.Text != "" {
+		text = "plainto_tsquery('simple', " + arg(s.Text) + ")"
+	}
+
+	// rowFilter applies the search to one JSON object.
+	rowFilter := func(expr string) []string {
+		var conds []string
+		if match != "" {
+			conds = append(conds, expr+" @> "+match)
+		}
+		if text != "" {
+			conds = append(conds, "to_tsvector('simple', "+expr+") @@ "+text)
+		}
+		return conds
+	}
+
+	var branches []string
+	if s.Source != SearchSourceCampaigns {
+		conds := append([]string{"h.organization_id = $1"}, rowFilter("r.columns")...)
+		branches = append(branches, `
+			SELECT 'logs'::text AS source, 'l' || lpad(r.id::text, 19, '0') AS key,
+				h.id AS host_id, h.host_identifier, r.name, NULL::uuid AS campaign_id, r.action,
+				jsonb_build_array(r.columns) AS rows, COALESCE(r.timestamp, r.created_at) AS at
+			FROM osquery_results r
+			JOIN hosts h ON h.id = r.host_id
+			WHERE `+strings.Join(conds, " AND "))
+	}
+	if s.Source != SearchSourceLogs {
+		// The array-level conditions narrow targets with the indexes; the
+		// lateral subquery then keeps only the rows that match.
+		conds := []string{"c.organization_id = $1", "t.status = 'completed'", "m.rows IS NOT NULL"}
+		if match != "" {
+			conds = append(conds, "t.results @> jsonb_build_array("+match+")")
+		}
+		if text != "" {
+			conds = append(conds, "to_tsvector('simple', t.results) @@ "+text)
+		}
+		branches = append(branches, `
+			SELECT 'campaigns'::text, 'c' || t.campaign_id::text || t.host_id::text,
+				h.id, h.host_identifier, c.query, c.id, NULL::text, m.rows, t.completed_at
+			FROM campaign_targets t
+			JOIN campaigns c ON c.id = t.campaign_id
+			JOIN hosts h ON h.id = t.host_id
+			CROSS JOIN LATERAL (
+				SELECT jsonb_agg(e) AS rows
+				FROM jsonb_array_elements(t.results) e
+				WHERE `+strings.Join(rowFilter("e"), " AND ")+`
+			) m
+			WHERE `+strings.Join(conds, " AND "))
+	}
+
+	var outer []string
+	if s.Since != nil {
+		outer = append(outer, "at >= "+arg(*s.Since))
+	}
+	if s.Until != nil {
+		outer = append(outer, "at < "+arg(*s.Until))
+	}
+	if s.Before != nil {
+		outer = append(outer, "(at, key) < ("+arg(s.Before.At)+"::timestamptz, "+arg(s.Before.Key)+"::text)")
+	}
+	where := ""
+	if len(outer) > 0 {
+		where = "WHERE " + strings.Join(outer, " AND ")
+	}
+
+	query := `
+		SELECT source, key, host_id, host_identifier, name, campaign_id, action, rows, at
+		FROM (` + strings.Join(branches, "\n\t\t\tUNION ALL") + `
+		) hits
+		` + where + `
+		ORDER BY at DESC, key DESC
+		LIMIT ` + arg(s.Limit)
+
+	rows, err := r.pool.Query(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("searching results: %w", err)
+	}
+	defer rows.Close()
+
+	var hits []SearchHit
+	for rows.Next() {
+		var h SearchHit
+		if err := rows.Scan(&h.Source, &h.key, &h.HostID, &h.HostIdentifier, &h.Name, &h.CampaignID, &h.Action, &h.Rows, &h.At); err != nil {
+			return nil, fmt.Errorf("scanning search hit: %w", err)
+		}
+		hits = append(hits, h)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("searching results: %w", err)
+	}
+	return hits, nil
+}
@@ -0,0 +1,99 @@
+package services_test
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/cavenine/queryops/features/osquery/services"
+	"github.com/cavenine/queryops/internal/testdb"
+	"github.com/cavenine/queryops/internal/testdb/fixtures"
+)
+
+func TestSearchResults(t *testing.T) {
+	tdb := testdb.SetupTestDB(t)
+	ctx := context.Background()
+
+	orgID := fixtures.CreateOrg(t, tdb.Pool, "search-org").ID
+	otherOrgID := fixtures.CreateOrg(t, tdb.Pool, "other-org").ID
+	hostA := fixtures.CreateHost(t, tdb.Pool, orgID, "host-a").ID
+	hostB := fixtures.CreateHost(t, tdb.Pool, orgID, "host-b").ID
+	otherHost := fixtures.CreateHost(t, tdb.Pool, otherOrgID, "other").ID
+
+	repo := services.NewHostRepository(tdb.Pool)
+
+	base := time.Now().Add(-time.Hour).UTC().Truncate(time.Second)
+	logs := []struct {
+		host uuid.UUID
+		cols string
+		at   time.Time
+	}{
+		{hostA, `{"name":"sshd","pid":"412"}`, base},
+		{hostA, `{"name":"cron","pid":"88"}`, base.Add(time.Minute)},
+		{hostB, `{"name":"sshd","pid":"977"}`, base.Add(2 * time.Minute)},
+		{otherHost, `{"name":"sshd","pid":"1"}`, base.Add(3 * time.Minute)},
+	}
+	for _, l := range logs {
+		if err := repo.SaveResultLogs(ctx, l.host, "pack_processes", "added", json.RawMessage(l.cols), l.at); err != nil {
+			t.Fatalf("SaveResultLogs: %v", err)
+		}
+	}
+
+	campaignID, err := repo.QueueQuery(ctx, orgID, nil, nil, nil, "select name from processes", []uuid.UUID{hostA}, services.CampaignOptions{})
+	if err != nil {
+		t.Fatalf("QueueQuery: %v", err)
+	}
+	if err := repo.SaveQueryResults(ctx, hostA, campaignID, "completed", json.RawMessage(`[{"name":"sshd"},{"name":"bash"}]`), nil, false); err != nil {
+		t.Fatalf("SaveQueryResults: %v", err)
+	}
+
+	// Exact match across both sources, scoped to the organization.
+	hits, err := repo.SearchResults(ctx, orgID, services.ResultSearch{Match: map[string]string{"name": "sshd"}})
+	if err != nil {
+		t.Fatalf("SearchResults(match): %v", err)
+	}
+	if len(hits) != 3 {
+		t.Fatalf("hits = %+v, want 3", hits)
+	}
+	if hits[0].Source != services.SearchSourceCampaigns || hits[0].CampaignID == nil || *hits[0].CampaignID != campaignID {
+		t.Fatalf("newest hit = %+v, want the campaign", hits[0])
+	}
+	var rows []map[string]string
+	if err := json.Unmarshal(hits[0].Rows, &rows); err != nil || len(rows) != 1 || rows[0]["name"] != "sshd" {
+		t.Fatalf("campaign rows = %s, want only the matching row", hits[0].Rows)
+	}
+	if hits[1].HostIdentifier != "host-b" || hits[2].HostIdentifier != "host-a" {
+		t.Fatalf("log hits = %s, %s", hits[1].HostIdentifier, hits[2].HostIdentifier)
+	}
+
+	// Full text, logs only, with a time range.
+	since := base.Add(30 * time.Second)
+	hits, err = repo.SearchResults(ctx, orgID, services.ResultSearch{Text: "sshd", Source: services.SearchSourceLogs, Since: &since})
+	if err != nil {
+		t.Fatalf("SearchResults(text): %v", err)
+	}
+	if len(hits) != 1 || hits[0].HostIdentifier != "host-b" {
+		t.Fatalf("hits = %+v, want host-b only", hits)
+	}
+
+	// Pagination.
+	first, err := repo.SearchResults(ctx, orgID, services.ResultSearch{Text: "sshd", Limit: 2})
+	if err != nil {
+		t.Fatalf("SearchResults(page 1): %v", err)
+	}
+	cursor := first[len(first)-1].Cursor()
+	rest, err := repo.SearchResults(ctx, orgID, services.ResultSearch{Text: "sshd", Limit: 2, Before: &cursor})
+	if err != nil {
+		t.Fatalf("SearchResults(page 2): %v", err)
+	}
+	if len(first) != 2 || len(rest) != 1 || rest[0].HostIdentifier != "host-a" || rest[0].Source != services.SearchSourceLogs {
+		t.Fatalf("pages = %+v / %+v", first, rest)
+	}
+
+	if _, err := repo.SearchResults(ctx, orgID, services.ResultSearch{}); err == nil {
+		t.Fatalf("SearchResults with no filters succeeded")
+	}
+}
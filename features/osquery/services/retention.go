@@ -0,0 +1,58 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// PurgeExpiredLogs deletes up to batchSize scheduled query results and up to
+// batchSize status logs older than their organization's retention period at
+// now, and up to batchSize host timeline events older than
+// HostEventRetention, and returns how many rows it deleted. Organizations
+// without a retention period keep their results and status logs. Call it
+// until it returns 0.
+func (r *HostRepository) PurgeExpiredLogs(ctx context.Context, now time.Time, batchSize int) (int, error) {
+	results, err := r.pool.Exec(ctx, `
+		DELETE FROM osquery_results
+		WHERE id IN (
+			SELECT r.id
+			FROM organization_settings s
+			JOIN hosts h ON h.organization_id = s.organization_id
+			JOIN osquery_results r ON r.host_id = h.id
+			WHERE s.result_retention_days IS NOT NULL
+				AND r.created_at < $1 - make_interval(days => s.result_retention_days)
+			LIMIT $2
+		)
+	`, now, batchSize)
+	if err != nil {
+		return 0, fmt.Errorf("purging expired results: %w", err)
+	}
+	statusLogs, err := r.pool.Exec(ctx, `
+		DELETE FROM osquery_status_logs
+		WHERE id IN (
+			SELECT l.id
+			FROM organization_settings s
+			JOIN hosts h ON h.organization_id = s.organization_id
+			JOIN osquery_status_logs l ON l.host_id = h.id
+			WHERE s.result_retention_days IS NOT NULL
+				AND l.created_at < $1 - make_interval(days => s.result_retention_days)
+			LIMIT $2
+		)
+	`, now, batchSize)
+	if err != nil {
+		return 0, fmt.Errorf("purging expired status logs: %w", err)
+	}
+	hostEvents, err := r.pool.Exec(ctx, `
+		DELETE FROM host_events
+		WHERE id IN (
+			SELECT id FROM host_events
+			WHERE occurred_at < $1
+			LIMIT $2
+		)
+	`, now.Add(-HostEventRetention), batchSize)
+	if err != nil {
+		return 0, fmt.Errorf("purging expired host events: %w", err)
+	}
+	return int(results.RowsAffected() + statusLogs.RowsAffected() + hostEvents.RowsAffected()), nil
+}
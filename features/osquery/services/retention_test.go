@@ -0,0 +1,79 @@
+package services_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/cavenine/queryops/features/osquery/services"
+	"github.com/cavenine/queryops/internal/testdb"
+	"github.com/cavenine/queryops/internal/testdb/fixtures"
+)
+
+func TestHostRepository_PurgeExpiredLogs(t *testing.T) {
+	tdb := testdb.SetupTestDB(t)
+	ctx := context.Background()
+
+	retainedOrg := fixtures.CreateOrg(t, tdb.Pool, "retained-org").ID
+	keepAllOrg := fixtures.CreateOrg(t, tdb.Pool, "keep-all-org").ID
+	retained := fixtures.CreateHost(t, tdb.Pool, retainedOrg, "retained")
+	keepAll := fixtures.CreateHost(t, tdb.Pool, keepAllOrg, "keep-all")
+	if _, err := tdb.Pool.Exec(ctx, `
+		INSERT INTO organization_settings (organization_id, result_retention_days) VALUES ($1, 7)
+	`, retainedOrg); err != nil {
+		t.Fatalf("saving retention: %v", err)
+	}
+
+	now := time.Now()
+	for _, hostID := range []uuid.UUID{retained.ID, keepAll.ID} {
+		for _, age := range []time.Duration{time.Hour, 10 * 24 * time.Hour, 20 * 24 * time.Hour} {
+			if _, err := tdb.Pool.Exec(ctx, `
+				INSERT INTO osquery_results (host_id, name, action, columns, timestamp, created_at)
+				VALUES ($1, 'pack_users', 'added', '{}', $2, $2)
+			`, hostID, now.Add(-age)); err != nil {
+				t.Fatalf("inserting result: %v", err)
+			}
+			if _, err := tdb.Pool.Exec(ctx, `
+				INSERT INTO osquery_status_logs (host_id, line, message, severity, filename, created_at)
+				VALUES ($1, 1, 'status', 0, 'init.cpp', $2)
+			`, hostID, now.Add(-age)); err != nil {
+				t.Fatalf("inserting status log: %v", err)
+			}
+		}
+	}
+
+	repo := services.NewHostRepository(tdb.Pool)
+	var total int
+	for {
+		n, err := repo.PurgeExpiredLogs(ctx, now, 1)
+		if err != nil {
+			t.Fatalf("PurgeExpiredLogs: %v", err)
+		}
+		if n == 0 {
+			break
+		}
+		total += n
+	}
+	if total != 4 {
+		t.Fatalf("purged %d rows, want 4", total)
+	}
+
+	count := func(table string, hostID uuid.UUID) int {
+		t.Helper()
+		var n int
+		if err := tdb.Pool.QueryRow(ctx, `SELECT COUNT(*) FROM `+table+` WHERE host_id = $1`, hostID).Scan(&n); err != nil {
+			t.Fatalf("counting %s: %v", table, err)
+		}
+		return n
+	}
+	for _, table := range []string{"osquery_results", "osquery_status_logs"} {
+		if got := count(table, retained.ID); got != 1 {
+			t.Errorf("%s left for retained host = %d, want 1", table, got)
+		}
+		if got := count(table, keepAll.ID); got != 3 {
+			t.Errorf("%s left for keep-all host = %d, want 3", table, got)
+		}
+	}
+}
@@ -0,0 +1,111 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	// ScheduleVitalsQueryName is the scheduled query added to every config
+	// served to hosts. It snapshots osquery_schedule, which says whether
+	// osquery has denylisted each of the host's scheduled queries.
+	ScheduleVitalsQueryName = "queryops_schedule_vitals"
+	ScheduleVitalsQuery     = "SELECT name, denylisted, executions FROM osquery_schedule;"
+	// ScheduleVitalsInterval is how often, in seconds, hosts run the vitals
+	// query.
+	ScheduleVitalsInterval = 3600
+
+	// scheduleFailurePrefix starts the status log line osquery writes when a
+	// scheduled query was running as its worker was killed, e.g. by the
+	// watchdog. That query is denylisted for a while afterwards.
+	scheduleFailurePrefix = "Scheduled query may have failed: "
+)
+
+// ScheduleHealth summarizes how one scheduled query fares across an
+// organization's hosts.
+type ScheduleHealth struct {
+	Name string `json:"name"`
+	// Hosts is how many hosts run the query: those whose latest vitals list
+	// it, and any that logged it failing.
+	Hosts int `json:"hosts"`
+	// Denylisted is how many of those report it denylisted.
+	Denylisted int `json:"denylisted"`
+	// Failed is how many hosts logged the query failing since the window
+	// start.
+	Failed int `json:"failed"`
+	// Problem is how many hosts are in Denylisted, Failed, or both.
+	Problem int `json:"problem"`
+}
+
+// ProblemPercent is the share of the hosts running the query that have a
+// problem with it.
+func (s ScheduleHealth) ProblemPercent() int {
+	if s.Hosts == 0 {
+		return 0
+	}
+	return s.Problem * 100 / s.Hosts
+}
+
+// ListScheduleHealth returns, for each scheduled query, how many of the
+// organization's hosts run it and how many have it denylisted or logged it
+// failing since since. Queries with problems come first.
+func (r *HostRepository) ListScheduleHealth(ctx context.Context, organizationID uuid.UUID, since time.Time) ([]ScheduleHealth, error) {
+	rows, err := r.pool.Query(ctx, `
+		WITH latest AS (
+			SELECT h.id AS host_id, v.ts
+			FROM hosts h
+			CROSS JOIN LATERAL (
+				SELECT timestamp AS ts
+				FROM osquery_results
+				WHERE host_id = h.id AND name = $2
+				ORDER BY timestamp DESC
+				LIMIT 1
+			) v
+			WHERE h.organization_id = $1
+		), vitals AS (
+			SELECT r.host_id, r.columns->>'name' AS name, r.columns->>'denylisted' = '1' AS denylisted
+			FROM latest l
+			JOIN osquery_results r ON r.host_id = l.host_id AND r.name = $2 AND r.timestamp = l.ts
+		), failures AS (
+			SELECT DISTINCT s.host_id, btrim(substr(s.message, length($4) + 1)) AS name
+			FROM hosts h
+			JOIN osquery_status_logs s ON s.host_id = h.id
+			WHERE h.organization_id = $1
+				AND s.created_at >= $3
+				AND starts_with(s.message, $4)
+		), combined AS (
+			SELECT host_id, name, denylisted, false AS failed FROM vitals
+			UNION ALL
+			SELECT host_id, name, false, true FROM failures
+		)
+		SELECT name,
+			COUNT(DISTINCT host_id),
+			COUNT(DISTINCT host_id) FILTER (WHERE denylisted),
+			COUNT(DISTINCT host_id) FILTER (WHERE failed),
+			COUNT(DISTINCT host_id) FILTER (WHERE denylisted OR failed) AS problem
+		FROM combined
+		WHERE name IS NOT NULL AND name <> '' AND name <> $2
+		GROUP BY name
+		ORDER BY problem DESC, name
+	`, organizationID, ScheduleVitalsQueryName, since, scheduleFailurePrefix)
+	if err != nil {
+		return nil, fmt.Errorf("listing schedule health: %w", err)
+	}
+	defer rows.Close()
+
+	var health []ScheduleHealth
+	for rows.Next() {
+		var s ScheduleHealth
+		if err := rows.Scan(&s.Name, &s.Hosts, &s.Denylisted, &s.Failed, &s.Problem); err != nil {
+			return nil, fmt.Errorf("scanning schedule health: %w", err)
+		}
+		health = append(health, s)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("listing schedule health: %w", err)
+	}
+	return health, nil
+}
@@ -0,0 +1,77 @@
+package services_test
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/cavenine/queryops/features/osquery/services"
+	"github.com/cavenine/queryops/internal/testdb"
+	"github.com/cavenine/queryops/internal/testdb/fixtures"
+)
+
+func TestListScheduleHealth(t *testing.T) {
+	tdb := testdb.SetupTestDB(t)
+	ctx := context.Background()
+
+	orgID := fixtures.CreateOrg(t, tdb.Pool, "schedule-org").ID
+	otherOrgID := fixtures.CreateOrg(t, tdb.Pool, "other-org").ID
+	hostA := fixtures.CreateHost(t, tdb.Pool, orgID, "host-a").ID
+	hostB := fixtures.CreateHost(t, tdb.Pool, orgID, "host-b").ID
+	hostC := fixtures.CreateHost(t, tdb.Pool, orgID, "host-c").ID
+	otherHost := fixtures.CreateHost(t, tdb.Pool, otherOrgID, "other").ID
+	repo := services.NewHostRepository(tdb.Pool)
+
+	now := time.Now().UTC().Truncate(time.Second)
+	vitals := func(hostID uuid.UUID, at time.Time, name, denylisted string) {
+		t.Helper()
+		cols, _ := json.Marshal(map[string]string{"name": name, "denylisted": denylisted, "executions": "3"})
+		if err := repo.SaveResultLogs(ctx, hostID, services.ScheduleVitalsQueryName, "snapshot", cols, at); err != nil {
+			t.Fatalf("SaveResultLogs: %v", err)
+		}
+	}
+	failure := func(hostID uuid.UUID, at time.Time, name string) {
+		t.Helper()
+		if err := repo.SaveStatusLogs(ctx, hostID, 1, "Scheduled query may have failed: "+name, 1, "config.cpp", at); err != nil {
+			t.Fatalf("SaveStatusLogs: %v", err)
+		}
+	}
+
+	// An older snapshot that denylisted pack_users is superseded.
+	vitals(hostA, now.Add(-2*time.Hour), "pack_users", "1")
+	vitals(hostA, now.Add(-time.Hour), "pack_users", "0")
+	vitals(hostA, now.Add(-time.Hour), "pack_procs", "1")
+	vitals(hostB, now.Add(-time.Hour), "pack_users", "0")
+	vitals(hostB, now.Add(-time.Hour), "pack_procs", "0")
+	// host-c sends no vitals but logged pack_procs failing; its old failure
+	// of pack_users is outside the window.
+	failure(hostC, now.Add(-time.Minute), "pack_procs")
+	failure(hostC, now.Add(-48*time.Hour), "pack_users")
+	// host-a both reports and logs the denylisting; it counts once.
+	failure(hostA, now.Add(-time.Minute), "pack_procs")
+	// Other organizations don't count.
+	vitals(otherHost, now.Add(-time.Hour), "pack_procs", "1")
+
+	health, err := repo.ListScheduleHealth(ctx, orgID, now.Add(-24*time.Hour))
+	if err != nil {
+		t.Fatalf("ListScheduleHealth: %v", err)
+	}
+	want := []services.ScheduleHealth{
+		{Name: "pack_procs", Hosts: 3, Denylisted: 1, Failed: 2, Problem: 2},
+		{Name: "pack_users", Hosts: 2, Denylisted: 0, Failed: 0, Problem: 0},
+	}
+	if len(health) != len(want) {
+		t.Fatalf("health = %+v, want %+v", health, want)
+	}
+	for i := range want {
+		if health[i] != want[i] {
+			t.Errorf("health[%d] = %+v, want %+v", i, health[i], want[i])
+		}
+	}
+	if got := health[0].ProblemPercent(); got != 66 {
+		t.Errorf("ProblemPercent = %d, want 66", got)
+	}
+}
@@ -0,0 +1,175 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+)
+
+// maxSnapshotRows caps the rows returned for a scheduled query snapshot.
+const maxSnapshotRows = 1000
+
+// ScheduledQuery summarizes one scheduled query's result logs for a host.
+type ScheduledQuery struct {
+	Name         string    `json:"name"`
+	LastResultAt time.Time `json:"last_result_at"`
+	Events       int       `json:"events"`
+}
+
+// ScheduledResultEvent is one differential result log line.
+type ScheduledResultEvent struct {
+	Action    string            `json:"action"`
+	Columns   map[string]string `json:"columns"`
+	Timestamp time.Time         `json:"timestamp"`
+	// QuarantineID is the quarantine the host was in when it logged the
+	// row.
+	QuarantineID *uuid.UUID `json:"quarantine_id,omitempty"`
+}
+
+// ScheduledSnapshot is a scheduled query's rows as of a point in time.
+type ScheduledSnapshot struct {
+	Rows []map[string]string `json:"rows"`
+	// AsOf is when the newest contributing log line was recorded; nil if
+	// there were none.
+	AsOf *time.Time `json:"as_of,omitempty"`
+	// FromDiffs is true when the rows were rebuilt from added/removed events
+	// because the query doesn't log snapshots.
+	FromDiffs bool `json:"from_diffs"`
+}
+
+// ListScheduledQueries returns the scheduled queries that have logged results
+// for the host, most recently active first.
+func (r *HostRepository) ListScheduledQueries(ctx context.Context, hostID uuid.UUID) ([]ScheduledQuery, error) {
+	rows, err := r.pool.Query(ctx, `
+		SELECT name, MAX(COALESCE(timestamp, created_at)), COUNT(*)
+		FROM osquery_results
+		WHERE host_id = $1
+		GROUP BY name
+		ORDER BY MAX(COALESCE(timestamp, created_at)) DESC, name
+	`, hostID)
+	if err != nil {
+		return nil, fmt.Errorf("listing scheduled queries: %w", err)
+	}
+	defer rows.Close()
+
+	var queries []ScheduledQuery
+	for rows.Next() {
+		var q ScheduledQuery
+		if err := rows.Scan(&q.Name, &q.LastResultAt, &q.Events); err != nil {
+			return nil, fmt.Errorf("scanning scheduled query: %w", err)
+		}
+		queries = append(queries, q)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("listing scheduled queries: %w", err)
+	}
+	return queries, nil
+}
+
+// ListScheduledResultEvents returns up to limit added/removed events for the
+// host's scheduled query in [since, until), newest first.
+func (r *HostRepository) ListScheduledResultEvents(ctx context.Context, hostID uuid.UUID, name string, since, until time.Time, limit int) ([]ScheduledResultEvent, error) {
+	if limit <= 0 {
+		limit = 200
+	}
+
+	rows, err := r.pool.Query(ctx, `
+		SELECT action, columns, timestamp, quarantine_id
+		FROM osquery_results
+		WHERE host_id = $1 AND name = $2
+			AND action IN ('added', 'removed')
+			AND timestamp >= $3 AND timestamp < $4
+		ORDER BY timestamp DESC, id DESC
+		LIMIT $5
+	`, hostID, name, since, until, limit)
+	if err != nil {
+		return nil, fmt.Errorf("listing scheduled result events: %w", err)
+	}
+	defer rows.Close()
+
+	var events []ScheduledResultEvent
+	for rows.Next() {
+		var e ScheduledResultEvent
+		if err := rows.Scan(&e.Action, &e.Columns, &e.Timestamp, &e.QuarantineID); err != nil {
+			return nil, fmt.Errorf("scanning scheduled result event: %w", err)
+		}
+		events = append(events, e)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("listing scheduled result events: %w", err)
+	}
+	return events, nil
+}
+
+// GetScheduledSnapshot returns the host's rows for a scheduled query as of at.
+// Queries logged in snapshot mode return their latest snapshot; differential
+// queries are replayed, keeping rows added more often than removed.
+func (r *HostRepository) GetScheduledSnapshot(ctx context.Context, hostID uuid.UUID, name string, at time.Time) (*ScheduledSnapshot, error) {
+	snap := &ScheduledSnapshot{Rows: []map[string]string{}}
+
+	rows, err := r.pool.Query(ctx, `
+		SELECT columns, timestamp
+		FROM osquery_results
+		WHERE host_id = $1 AND name = $2 AND action = 'snapshot'
+			AND timestamp = (
+				SELECT MAX(timestamp)
+				FROM osquery_results
+				WHERE host_id = $1 AND name = $2 AND action = 'snapshot' AND timestamp <= $3
+			)
+		ORDER BY id
+		LIMIT $4
+	`, hostID, name, at, maxSnapshotRows)
+	if err != nil {
+		return nil, fmt.Errorf("getting scheduled snapshot: %w", err)
+	}
+	if err := scanSnapshotRows(rows, snap); err != nil {
+		return nil, err
+	}
+	if snap.AsOf != nil {
+		return snap, nil
+	}
+
+	snap.FromDiffs = true
+	rows, err = r.pool.Query(ctx, `
+		SELECT columns, MAX(timestamp)
+		FROM osquery_results
+		WHERE host_id = $1 AND name = $2
+			AND action IN ('added', 'removed')
+			AND timestamp <= $3
+		GROUP BY columns
+		HAVING SUM(CASE WHEN action = 'added' THEN 1 ELSE -1 END) > 0
+		ORDER BY MAX(timestamp) DESC
+		LIMIT $4
+	`, hostID, name, at, maxSnapshotRows)
+	if err != nil {
+		return nil, fmt.Errorf("replaying scheduled results: %w", err)
+	}
+	if err := scanSnapshotRows(rows, snap); err != nil {
+		return nil, err
+	}
+	return snap, nil
+}
+
+func scanSnapshotRows(rows pgx.Rows, snap *ScheduledSnapshot) error {
+	defer rows.Close()
+	for rows.Next() {
+		var (
+			cols map[string]string
+			ts   time.Time
+		)
+		if err := rows.Scan(&cols, &ts); err != nil {
+			return fmt.Errorf("scanning snapshot row: %w", err)
+		}
+		snap.Rows = append(snap.Rows, cols)
+		if snap.AsOf == nil || ts.After(*snap.AsOf) {
+			snap.AsOf = &ts
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("getting scheduled snapshot: %w", err)
+	}
+	return nil
+}
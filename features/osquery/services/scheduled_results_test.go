@@ -0,0 +1,81 @@
+package services_test
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/cavenine/queryops/features/osquery/services"
+	"github.com/cavenine/queryops/internal/testdb"
+	"github.com/cavenine/queryops/internal/testdb/fixtures"
+)
+
+func TestScheduledResults(t *testing.T) {
+	tdb := testdb.SetupTestDB(t)
+	ctx := context.Background()
+
+	orgID := fixtures.CreateOrg(t, tdb.Pool, "scheduled-org").ID
+	hostID := fixtures.CreateHost(t, tdb.Pool, orgID, "host-a").ID
+	repo := services.NewHostRepository(tdb.Pool)
+
+	base := time.Now().Add(-time.Hour).UTC().Truncate(time.Second)
+	logs := []struct {
+		name, action, cols string
+		at                 time.Time
+	}{
+		{"pack_users", "added", `{"user":"root"}`, base},
+		{"pack_users", "added", `{"user":"alice"}`, base},
+		{"pack_users", "removed", `{"user":"alice"}`, base.Add(10 * time.Minute)},
+		{"pack_users", "added", `{"user":"bob"}`, base.Add(20 * time.Minute)},
+		{"pack_ports", "snapshot", `{"port":"22"}`, base},
+		{"pack_ports", "snapshot", `{"port":"22"}`, base.Add(30 * time.Minute)},
+		{"pack_ports", "snapshot", `{"port":"443"}`, base.Add(30 * time.Minute)},
+	}
+	for _, l := range logs {
+		if err := repo.SaveResultLogs(ctx, hostID, l.name, l.action, json.RawMessage(l.cols), l.at); err != nil {
+			t.Fatalf("SaveResultLogs: %v", err)
+		}
+	}
+
+	queries, err := repo.ListScheduledQueries(ctx, hostID)
+	if err != nil {
+		t.Fatalf("ListScheduledQueries: %v", err)
+	}
+	if len(queries) != 2 || queries[0].Name != "pack_ports" || queries[0].Events != 3 || queries[1].Name != "pack_users" {
+		t.Fatalf("queries = %+v", queries)
+	}
+
+	// Differential queries are replayed as of the requested time.
+	snap, err := repo.GetScheduledSnapshot(ctx, hostID, "pack_users", base.Add(15*time.Minute))
+	if err != nil {
+		t.Fatalf("GetScheduledSnapshot(pack_users): %v", err)
+	}
+	if !snap.FromDiffs || len(snap.Rows) != 1 || snap.Rows[0]["user"] != "root" {
+		t.Fatalf("snapshot = %+v, want root only", snap)
+	}
+
+	// Snapshot queries return the latest snapshot at or before the time.
+	snap, err = repo.GetScheduledSnapshot(ctx, hostID, "pack_ports", base.Add(15*time.Minute))
+	if err != nil {
+		t.Fatalf("GetScheduledSnapshot(pack_ports): %v", err)
+	}
+	if snap.FromDiffs || len(snap.Rows) != 1 || snap.AsOf == nil || !snap.AsOf.Equal(base) {
+		t.Fatalf("snapshot = %+v, want the first snapshot", snap)
+	}
+	snap, err = repo.GetScheduledSnapshot(ctx, hostID, "pack_ports", time.Now())
+	if err != nil {
+		t.Fatalf("GetScheduledSnapshot(pack_ports, now): %v", err)
+	}
+	if len(snap.Rows) != 2 {
+		t.Fatalf("rows = %+v, want the latest snapshot", snap.Rows)
+	}
+
+	events, err := repo.ListScheduledResultEvents(ctx, hostID, "pack_users", base.Add(time.Minute), time.Now(), 0)
+	if err != nil {
+		t.Fatalf("ListScheduledResultEvents: %v", err)
+	}
+	if len(events) != 2 || events[0].Columns["user"] != "bob" || events[1].Action != "removed" {
+		t.Fatalf("events = %+v, want bob added then alice removed", events)
+	}
+}
@@ -5,6 +5,8 @@ import (
 	"encoding/json"
 	"fmt"
 	"strconv"
+
+	"github.com/cavenine/queryops/features/osquery/services"
 )
 
 // EnrollmentRequest is the request body for the /enroll endpoint.
@@ -18,6 +20,12 @@ type EnrollmentRequest struct {
 type EnrollmentResponse struct {
 	NodeKey     string `json:"node_key"`
 	NodeInvalid bool   `json:"node_invalid"`
+	// Error explains a rejected enrollment, such as an exceeded host quota.
+	Error string `json:"error,omitempty"`
+	// SigningKey is the hex key the host signs its requests with, given
+	// when it's first issued; see requestsign. osquery ignores it, so only
+	// agents that sign, or a proxy signing for osquery, keep it.
+	SigningKey string `json:"signing_key,omitempty"`
 }
 
 // ConfigRequest is the request body for the /config endpoint.
@@ -29,13 +37,38 @@ type ConfigRequest struct {
 type ConfigResponse struct {
 	Options     map[string]any            `json:"options,omitempty"`
 	Schedule    map[string]ScheduledQuery `json:"schedule,omitempty"`
+	Packs       map[string]Pack           `json:"packs,omitempty"`
 	Decorators  map[string][]string       `json:"decorators,omitempty"`
 	NodeInvalid bool                      `json:"node_invalid,omitempty"`
+	// AgentUpgrade tells a host in a released wave of an agent upgrade which
+	// osquery version to install. osquery itself ignores the key; an updater
+	// extension registers a config parser for it.
+	AgentUpgrade *services.AgentUpgradeTarget `json:"queryops_agent_upgrade,omitempty"`
 }
 
 type ScheduledQuery struct {
 	Query    string `json:"query"`
 	Interval int    `json:"interval"`
+	Snapshot bool   `json:"snapshot,omitempty"`
+	// Platform restricts the query to hosts on the listed platforms, as a
+	// comma-separated list in osquery's format, e.g. "darwin,linux".
+	Platform string `json:"platform,omitempty"`
+	// Removed, Version, Shard, and Denylist are passed to osquery as set in
+	// the config; see osquery's docs on configuration.
+	Removed  *bool  `json:"removed,omitempty"`
+	Version  string `json:"version,omitempty"`
+	Shard    int    `json:"shard,omitempty"`
+	Denylist *bool  `json:"denylist,omitempty"`
+}
+
+// Pack is a named group of scheduled queries in a config.
+type Pack struct {
+	// Platform restricts every query in the pack, like ScheduledQuery's.
+	Platform  string                    `json:"platform,omitempty"`
+	Version   string                    `json:"version,omitempty"`
+	Shard     int                       `json:"shard,omitempty"`
+	Discovery []string                  `json:"discovery,omitempty"`
+	Queries   map[string]ScheduledQuery `json:"queries"`
 }
 
 // LoggerRequest is the request body for the /logger endpoint.
@@ -47,7 +80,8 @@ type LoggerRequest struct {
 
 // LoggerResponse is the response body for the /logger endpoint.
 type LoggerResponse struct {
-	NodeInvalid bool `json:"node_invalid,omitempty"`
+	NodeInvalid bool   `json:"node_invalid,omitempty"`
+	Error       string `json:"error,omitempty"`
 }
 
 type UnixTime int64
@@ -93,6 +127,11 @@ type ResultLog struct {
 	UnixTime       UnixTime          `json:"unixTime"`
 	Action         string            `json:"action"`
 	Columns        map[string]string `json:"columns"`
+	// Snapshot holds every row when Action is "snapshot".
+	Snapshot []map[string]string `json:"snapshot,omitempty"`
+	// Decorations are the values of the config's decorators when the line
+	// was logged.
+	Decorations map[string]string `json:"decorations,omitempty"`
 }
 
 type StatusLog struct {
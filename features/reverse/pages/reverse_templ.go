@@ -49,20 +49,33 @@ func ReversePage() templ.Component {
 				}()
 			}
 			ctx = templ.InitializeContext(ctx)
-			templ_7745c5c3_Err = templruntime.WriteString(templ_7745c5c3_Buffer, 1, "<div class=\"flex flex-col gap-6\"><h1 class=\"text-3xl font-bold tracking-tight\">Reverse Text</h1><p class=\"text-base-content/60\">Example of Web Component integration.</p><div class=\"card bg-base-100 shadow-sm border border-base-300 max-w-lg\"><div class=\"card-body\"><div class=\"flex flex-col gap-4\"><div class=\"form-control\"><label class=\"label\"><span class=\"label-text\">Input Text</span></label> <input type=\"text\" class=\"input input-bordered\" placeholder=\"Type something...\" data-bind:_name=\"\"></div><div class=\"bg-base-200 p-4 rounded-lg\"><div class=\"label-text mb-2\">Reversed Output</div><p class=\"font-mono text-lg truncate\" data-signals:_reversed=\"\" data-text=\"$_reversed\">Waiting for input...</p></div><reverse-component data-on:reverse=\"$_reversed = evt.detail.value\" data-attr:name=\"$_name\"></reverse-component></div></div></div></div><script type=\"module\" src=\"")
+			templ_7745c5c3_Err = templruntime.WriteString(templ_7745c5c3_Buffer, 1, "<div class=\"flex flex-col gap-6\"><h1 class=\"text-3xl font-bold tracking-tight\">Reverse Text</h1><p class=\"text-base-content/60\">Example of Web Component integration.</p><div class=\"card bg-base-100 shadow-sm border border-base-300 max-w-lg\"><div class=\"card-body\"><div class=\"flex flex-col gap-4\"><div class=\"form-control\"><label class=\"label\"><span class=\"label-text\">Input Text</span></label><input type=\"text\" class=\"input input-bordered\" placeholder=\"Type something...\" data-bind:_name=\"\"></div><div class=\"bg-base-200 p-4 rounded-lg\"><div class=\"label-text mb-2\">Reversed Output</div><p class=\"font-mono text-lg truncate\" data-signals:_reversed=\"\" data-text=\"$_reversed\">Waiting for input...</p></div><reverse-component data-on:reverse=\"$_reversed = evt.detail.value\" data-attr:name=\"$_name\"></reverse-component></div></div></div></div><script nonce=\"")
 			if templ_7745c5c3_Err != nil {
 				return templ_7745c5c3_Err
 			}
 			var templ_7745c5c3_Var3 string
-			templ_7745c5c3_Var3, templ_7745c5c3_Err = templ.JoinStringErrs(resources.StaticPath("libs/reverse-component.js"))
+			templ_7745c5c3_Var3, templ_7745c5c3_Err = templ.JoinStringErrs(templ.GetNonce(ctx))
 			if templ_7745c5c3_Err != nil {
-				return templ.Error{Err: templ_7745c5c3_Err, FileName: `features/reverse/pages/reverse.templ`, Line: 43, Col: 79}
+				return templ.Error{Err: templ_7745c5c3_Err, FileName: `features/reverse/pages/reverse.templ`, Line: 43, Col: 37}
 			}
 			_, templ_7745c5c3_Err = templ_7745c5c3_Buffer.WriteString(templ.EscapeString(templ_7745c5c3_Var3))
 			if templ_7745c5c3_Err != nil {
 				return templ_7745c5c3_Err
 			}
-			templ_7745c5c3_Err = templruntime.WriteString(templ_7745c5c3_Buffer, 2, "\"></script>")
+			templ_7745c5c3_Err = templruntime.WriteString(templ_7745c5c3_Buffer, 2, "\" type=\"module\" src=\"")
+			if templ_7745c5c3_Err != nil {
+				return templ_7745c5c3_Err
+			}
+			var templ_7745c5c3_Var4 string
+			templ_7745c5c3_Var4, templ_7745c5c3_Err = templ.JoinStringErrs(resources.StaticPath("libs/reverse-component.js"))
+			if templ_7745c5c3_Err != nil {
+				return templ.Error{Err: templ_7745c5c3_Err, FileName: `features/reverse/pages/reverse.templ`, Line: 43, Col: 109}
+			}
+			_, templ_7745c5c3_Err = templ_7745c5c3_Buffer.WriteString(templ.EscapeString(templ_7745c5c3_Var4))
+			if templ_7745c5c3_Err != nil {
+				return templ_7745c5c3_Err
+			}
+			templ_7745c5c3_Err = templruntime.WriteString(templ_7745c5c3_Buffer, 3, "\"></script>")
 			if templ_7745c5c3_Err != nil {
 				return templ_7745c5c3_Err
 			}
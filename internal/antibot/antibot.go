@@ -3,12 +3,14 @@ package antibot
 import (
 	"context"
 	"crypto/rand"
+	"crypto/sha256"
 	"encoding/base64"
 	"encoding/gob"
 	"errors"
 	"maps"
 	"net/http"
 	"sort"
+	"strconv"
 	"strings"
 	"time"
 
@@ -29,6 +31,8 @@ const (
 	ReasonTokenMissing Reason = "token_missing"
 	ReasonTokenInvalid Reason = "token_mismatch"
 	ReasonTooFast      Reason = "too_fast"
+	ReasonPoWMissing   Reason = "pow_missing"
+	ReasonPoWInvalid   Reason = "pow_invalid"
 )
 
 type Result struct {
@@ -36,6 +40,39 @@ type Result struct {
 	Reason  Reason
 }
 
+// Form field names rendered by Fields, and the headers script-driven
+// endpoints send the same values in instead.
+const (
+	FieldHoneypot = "website"
+	FieldToken    = "js_token"
+	FieldPoWNonce = "pow_nonce"
+
+	HeaderToken    = "X-Antibot-Token"
+	HeaderPoWNonce = "X-Antibot-PoW"
+	// HeaderPoWDifficulty accompanies HeaderToken on responses that hand the
+	// page a fresh challenge.
+	HeaderPoWDifficulty = "X-Antibot-PoW-Difficulty"
+)
+
+// Form configures protection for one form. Every form shares the
+// Protector's session storage, defaults and metrics.
+type Form struct {
+	// ID namespaces the form's tokens in the session.
+	ID string
+	// MinDelay overrides Config.MinDelay when positive, e.g. for login forms
+	// that password managers fill and submit quickly.
+	MinDelay time.Duration
+	// SkipPoW exempts the form from the proof-of-work challenge.
+	SkipPoW bool
+}
+
+// Challenge is what a protected form is rendered with.
+type Challenge struct {
+	Token string
+	// PoWDifficulty is zero when the form needs no proof of work.
+	PoWDifficulty int
+}
+
 type Config struct {
 	// MinDelay is the minimum time between render and submit.
 	MinDelay time.Duration
@@ -43,6 +80,12 @@ type Config struct {
 	// MaxTokens bounds the number of outstanding tokens per form.
 	MaxTokens int
 
+	// PoWDifficulty is the number of leading zero bits the browser must find
+	// in SHA-256(token + ":" + nonce) before the form is accepted. Each extra
+	// bit doubles the expected work; 16 takes well under a second. Zero
+	// disables the proof-of-work challenge.
+	PoWDifficulty int
+
 	Now func() time.Time
 }
 
@@ -76,6 +119,23 @@ func New(sessionManager *scs.SessionManager, cfg Config) *Protector {
 	}
 }
 
+// Challenge issues a token for form and returns it with the form's
+// proof-of-work difficulty.
+func (p *Protector) Challenge(ctx context.Context, form Form) (Challenge, error) {
+	token, err := p.Issue(ctx, form.ID)
+	if err != nil {
+		return Challenge{}, err
+	}
+	return Challenge{Token: token, PoWDifficulty: p.powDifficulty(form)}, nil
+}
+
+func (p *Protector) powDifficulty(form Form) int {
+	if form.SkipPoW {
+		return 0
+	}
+	return p.cfg.PoWDifficulty
+}
+
 func (p *Protector) Issue(ctx context.Context, formID string) (string, error) {
 	if strings.TrimSpace(formID) == "" {
 		return "", errors.New("formID is required")
@@ -95,7 +155,9 @@ func (p *Protector) Issue(ctx context.Context, formID string) (string, error) {
 	return token, nil
 }
 
-func (p *Protector) Validate(r *http.Request, formID string, postedToken string, honeypotValue string) Result {
+// Validate checks a submitted form. powNonce is the proof-of-work solution
+// for postedToken; it is ignored when the form needs none.
+func (p *Protector) Validate(r *http.Request, form Form, postedToken string, honeypotValue string, powNonce string) Result {
 	ctx := r.Context()
 	if strings.TrimSpace(honeypotValue) != "" {
 		return Result{Allowed: false, Reason: ReasonHoneypot}
@@ -106,43 +168,71 @@ func (p *Protector) Validate(r *http.Request, formID string, postedToken string,
 		return Result{Allowed: false, Reason: ReasonTokenMissing}
 	}
 
-	tokens := p.getTokens(ctx, formID)
+	tokens := p.getTokens(ctx, form.ID)
 	renderedAtMs, ok := tokens[postedToken]
 	if !ok {
 		return Result{Allowed: false, Reason: ReasonTokenInvalid}
 	}
 
+	minDelay := p.cfg.MinDelay
+	if form.MinDelay > 0 {
+		minDelay = form.MinDelay
+	}
 	elapsed := p.cfg.Now().Sub(time.UnixMilli(renderedAtMs))
-	if elapsed < p.cfg.MinDelay {
+	if elapsed < minDelay {
 		return Result{Allowed: false, Reason: ReasonTooFast}
 	}
 
+	if difficulty := p.powDifficulty(form); difficulty > 0 {
+		powNonce = strings.TrimSpace(powNonce)
+		if powNonce == "" {
+			return Result{Allowed: false, Reason: ReasonPoWMissing}
+		}
+		if !solves(postedToken, powNonce, difficulty) {
+			return Result{Allowed: false, Reason: ReasonPoWInvalid}
+		}
+	}
+
 	// Single-use token: remove on success.
 	delete(tokens, postedToken)
-	p.sessionManager.Put(ctx, sessionKey(formID), tokens)
+	p.sessionManager.Put(ctx, sessionKey(form.ID), tokens)
 
 	return Result{Allowed: true, Reason: ReasonNone}
 }
 
-func ClientIP(r *http.Request) string {
-	xff := r.Header.Get("X-Forwarded-For")
-	if xff != "" {
-		// Take the first value.
-		parts := strings.Split(xff, ",")
-		if len(parts) > 0 {
-			ip := strings.TrimSpace(parts[0])
-			if ip != "" {
-				return ip
-			}
+// Solve finds a proof-of-work nonce for token, as antibot.js does in the
+// browser.
+func Solve(token string, difficulty int) string {
+	for n := 0; ; n++ {
+		nonce := strconv.Itoa(n)
+		if solves(token, nonce, difficulty) {
+			return nonce
 		}
 	}
+}
 
-	xri := strings.TrimSpace(r.Header.Get("X-Real-IP"))
-	if xri != "" {
-		return xri
+// maxNonceLen bounds the nonce a client may post; counters never get close.
+const maxNonceLen = 32
+
+func solves(token, nonce string, difficulty int) bool {
+	if len(nonce) > maxNonceLen {
+		return false
 	}
 
-	return r.RemoteAddr
+	sum := sha256.Sum256([]byte(token + ":" + nonce))
+	for _, b := range sum {
+		if difficulty <= 0 {
+			return true
+		}
+		if difficulty < 8 {
+			return b>>(8-difficulty) == 0
+		}
+		if b != 0 {
+			return false
+		}
+		difficulty -= 8
+	}
+	return difficulty <= 0
 }
 
 func (p *Protector) getTokens(ctx context.Context, formID string) map[string]int64 {
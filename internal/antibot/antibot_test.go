@@ -3,6 +3,8 @@ package antibot_test
 import (
 	"net/http"
 	"net/http/httptest"
+	"strconv"
+	"strings"
 	"testing"
 	"time"
 
@@ -46,7 +48,7 @@ func TestProtector_Validate(t *testing.T) {
 
 		var res antibot.Result
 		post := sm.LoadAndSave(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			res = p.Validate(r, "register", token, "")
+			res = p.Validate(r, antibot.Form{ID: "register"}, token, "", "")
 			w.WriteHeader(http.StatusOK)
 		}))
 
@@ -62,7 +64,7 @@ func TestProtector_Validate(t *testing.T) {
 		// Token is single-use.
 		var res2 antibot.Result
 		post2 := sm.LoadAndSave(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			res2 = p.Validate(r, "register", token, "")
+			res2 = p.Validate(r, antibot.Form{ID: "register"}, token, "", "")
 			w.WriteHeader(http.StatusOK)
 		}))
 
@@ -114,7 +116,7 @@ func runBlockedCases(t *testing.T, p *antibot.Protector, issue http.Handler, tok
 				if tc.useValidToken {
 					postedTok = tok
 				}
-				res = p.Validate(r, "register", postedTok, tc.honeypot)
+				res = p.Validate(r, antibot.Form{ID: "register"}, postedTok, tc.honeypot, "")
 				w.WriteHeader(http.StatusOK)
 			}))
 
@@ -173,3 +175,77 @@ func TestProtector_Issue_TrimsTokens(t *testing.T) {
 		t.Fatalf("expected 2 tokens after trim, got %d", len(tokensAfter))
 	}
 }
+
+func TestProtector_Validate_ProofOfWork(t *testing.T) {
+	now := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	sm := scs.New()
+	sm.Store = memstore.New()
+
+	const difficulty = 12
+	p := antibot.New(sm, antibot.Config{
+		MinDelay:      time.Second,
+		MaxTokens:     5,
+		PoWDifficulty: difficulty,
+		Now: func() time.Time {
+			return now
+		},
+	})
+
+	var token string
+	issue := sm.LoadAndSave(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		tok, err := p.Issue(r.Context(), "register")
+		if err != nil {
+			t.Fatalf("Issue: %v", err)
+		}
+		token = tok
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	validate := func(t *testing.T, nonce func(token string) string) antibot.Result {
+		t.Helper()
+
+		rec := httptest.NewRecorder()
+		issue.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/register", nil))
+		cookie := rec.Result().Cookies()[0]
+		now = now.Add(2 * time.Second)
+
+		var res antibot.Result
+		post := sm.LoadAndSave(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			res = p.Validate(r, antibot.Form{ID: "register"}, token, "", nonce(token))
+			w.WriteHeader(http.StatusOK)
+		}))
+		r := httptest.NewRequest(http.MethodPost, "/register", nil)
+		r.AddCookie(cookie)
+		post.ServeHTTP(httptest.NewRecorder(), r)
+		return res
+	}
+
+	t.Run("solved", func(t *testing.T) {
+		res := validate(t, func(token string) string { return antibot.Solve(token, difficulty) })
+		if !res.Allowed {
+			t.Fatalf("expected allowed, got %q", res.Reason)
+		}
+	})
+
+	t.Run("missing", func(t *testing.T) {
+		res := validate(t, func(string) string { return "" })
+		if res.Allowed || res.Reason != antibot.ReasonPoWMissing {
+			t.Fatalf("expected %q, got allowed=%v reason=%q", antibot.ReasonPoWMissing, res.Allowed, res.Reason)
+		}
+	})
+
+	t.Run("wrong", func(t *testing.T) {
+		res := validate(t, func(token string) string {
+			// Solve returns the smallest solution, so the nonce before it fails.
+			n, _ := strconv.Atoi(antibot.Solve(token, difficulty))
+			if n == 0 {
+				return strings.Repeat("9", 64)
+			}
+			return strconv.Itoa(n - 1)
+		})
+		if res.Allowed || res.Reason != antibot.ReasonPoWInvalid {
+			t.Fatalf("expected %q, got allowed=%v reason=%q", antibot.ReasonPoWInvalid, res.Allowed, res.Reason)
+		}
+	})
+}
@@ -0,0 +1,67 @@
+package antibot
+
+import (
+	"expvar"
+	"log/slog"
+	"net/http"
+	"strconv"
+
+	"github.com/cavenine/queryops/internal/realip"
+)
+
+// blockedMetrics counts blocked submissions under /debug/vars, keyed
+// "<form>.<reason>", e.g. "login.too_fast".
+var blockedMetrics = expvar.NewMap("antibot_blocked")
+
+// BlockedFunc responds to a submission the Protector rejected.
+type BlockedFunc func(w http.ResponseWriter, r *http.Request, res Result)
+
+// Protect validates submissions to form before next runs. Values are read
+// from the fields rendered by Fields, or from the Header* request headers
+// for script-driven endpoints. Blocked submissions are logged, counted and
+// passed to blocked instead of next.
+func (p *Protector) Protect(form Form, blocked BlockedFunc) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			res := p.Validate(r, form,
+				submitted(r, FieldToken, HeaderToken),
+				r.PostFormValue(FieldHoneypot),
+				submitted(r, FieldPoWNonce, HeaderPoWNonce),
+			)
+			if !res.Allowed {
+				blockedMetrics.Add(form.ID+"."+string(res.Reason), 1)
+				slog.WarnContext(r.Context(),
+					"antibot blocked form",
+					"form", form.ID,
+					"reason", res.Reason,
+					"ip", realip.FromRequest(r),
+					"ua", r.UserAgent(),
+				)
+				blocked(w, r, res)
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// SetChallengeHeaders issues a fresh challenge for form and sends it in the
+// response headers, so a script-driven endpoint can be called again without
+// reloading the page.
+func (p *Protector) SetChallengeHeaders(w http.ResponseWriter, r *http.Request, form Form) error {
+	challenge, err := p.Challenge(r.Context(), form)
+	if err != nil {
+		return err
+	}
+	w.Header().Set(HeaderToken, challenge.Token)
+	w.Header().Set(HeaderPoWDifficulty, strconv.Itoa(challenge.PoWDifficulty))
+	return nil
+}
+
+func submitted(r *http.Request, field, header string) string {
+	if v := r.PostFormValue(field); v != "" {
+		return v
+	}
+	return r.Header.Get(header)
+}
@@ -0,0 +1,130 @@
+package antibot_test
+
+import (
+	"expvar"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cavenine/queryops/internal/antibot"
+
+	"github.com/alexedwards/scs/v2"
+	"github.com/alexedwards/scs/v2/memstore"
+)
+
+func TestProtector_Protect(t *testing.T) {
+	now := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	sm := scs.New()
+	sm.Store = memstore.New()
+
+	p := antibot.New(sm, antibot.Config{
+		MinDelay:      2 * time.Second,
+		PoWDifficulty: 8,
+		Now: func() time.Time {
+			return now
+		},
+	})
+	form := antibot.Form{ID: "protect_test", MinDelay: 500 * time.Millisecond}
+
+	var challenge antibot.Challenge
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /form", func(w http.ResponseWriter, r *http.Request) {
+		c, err := p.Challenge(r.Context(), form)
+		if err != nil {
+			t.Fatalf("Challenge: %v", err)
+		}
+		challenge = c
+	})
+	var reached bool
+	mux.Handle("POST /form", p.Protect(form, func(w http.ResponseWriter, _ *http.Request, res antibot.Result) {
+		http.Error(w, string(res.Reason), http.StatusUnprocessableEntity)
+	})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		reached = true
+		if err := p.SetChallengeHeaders(w, r, form); err != nil {
+			t.Fatalf("SetChallengeHeaders: %v", err)
+		}
+	})))
+	handler := sm.LoadAndSave(mux)
+
+	get := func(t *testing.T) *http.Cookie {
+		t.Helper()
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/form", nil))
+		now = now.Add(time.Second)
+		return rec.Result().Cookies()[0]
+	}
+
+	t.Run("form fields", func(t *testing.T) {
+		cookie := get(t)
+		if challenge.PoWDifficulty != 8 {
+			t.Fatalf("PoWDifficulty = %d, want 8", challenge.PoWDifficulty)
+		}
+
+		body := url.Values{
+			antibot.FieldToken:    {challenge.Token},
+			antibot.FieldPoWNonce: {antibot.Solve(challenge.Token, challenge.PoWDifficulty)},
+		}
+		req := httptest.NewRequest(http.MethodPost, "/form", strings.NewReader(body.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req.AddCookie(cookie)
+		rec := httptest.NewRecorder()
+		reached = false
+		handler.ServeHTTP(rec, req)
+
+		if !reached {
+			t.Fatalf("handler not reached: %d %s", rec.Code, rec.Body.String())
+		}
+		if rec.Header().Get(antibot.HeaderToken) == "" {
+			t.Fatalf("expected a fresh challenge in the response headers")
+		}
+	})
+
+	t.Run("headers", func(t *testing.T) {
+		cookie := get(t)
+
+		req := httptest.NewRequest(http.MethodPost, "/form", nil)
+		req.Header.Set(antibot.HeaderToken, challenge.Token)
+		req.Header.Set(antibot.HeaderPoWNonce, antibot.Solve(challenge.Token, challenge.PoWDifficulty))
+		req.AddCookie(cookie)
+		rec := httptest.NewRecorder()
+		reached = false
+		handler.ServeHTTP(rec, req)
+
+		if !reached {
+			t.Fatalf("handler not reached: %d %s", rec.Code, rec.Body.String())
+		}
+	})
+
+	t.Run("blocked", func(t *testing.T) {
+		cookie := get(t)
+		metric := func() int64 {
+			v, ok := expvar.Get("antibot_blocked").(*expvar.Map).Get("protect_test.pow_missing").(*expvar.Int)
+			if !ok {
+				return 0
+			}
+			return v.Value()
+		}
+		before := metric()
+
+		req := httptest.NewRequest(http.MethodPost, "/form", nil)
+		req.Header.Set(antibot.HeaderToken, challenge.Token)
+		req.AddCookie(cookie)
+		rec := httptest.NewRecorder()
+		reached = false
+		handler.ServeHTTP(rec, req)
+
+		if reached {
+			t.Fatalf("handler reached")
+		}
+		if rec.Code != http.StatusUnprocessableEntity || strings.TrimSpace(rec.Body.String()) != string(antibot.ReasonPoWMissing) {
+			t.Fatalf("got %d %q", rec.Code, rec.Body.String())
+		}
+		if got := metric(); got != before+1 {
+			t.Fatalf("blocked metric = %d, want %d", got, before+1)
+		}
+	})
+}
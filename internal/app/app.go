@@ -0,0 +1,165 @@
+// Package app builds the web server's repositories, services, and handlers
+// once, from the dependencies a process opens: the database pool, sessions,
+// and pub/sub. router.SetupRoutes mounts what it builds, so tests and tools
+// that need the server in-process get the same wiring, with any dependency
+// replaced through Deps.
+package app
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/ThreeDotsLabs/watermill/message"
+	"github.com/alexedwards/scs/pgxstore"
+	"github.com/alexedwards/scs/v2"
+	"github.com/jackc/pgx/v5/pgxpool"
+
+	"github.com/cavenine/queryops/background"
+	"github.com/cavenine/queryops/config"
+	"github.com/cavenine/queryops/db"
+	accountFeature "github.com/cavenine/queryops/features/account"
+	adminFeature "github.com/cavenine/queryops/features/admin"
+	authFeature "github.com/cavenine/queryops/features/auth"
+	dashboardFeature "github.com/cavenine/queryops/features/dashboard"
+	degradedFeature "github.com/cavenine/queryops/features/degraded"
+	incidentFeature "github.com/cavenine/queryops/features/incident"
+	indexFeature "github.com/cavenine/queryops/features/index"
+	notificationFeature "github.com/cavenine/queryops/features/notification"
+	organizationFeature "github.com/cavenine/queryops/features/organization"
+	orgServices "github.com/cavenine/queryops/features/organization/services"
+	osqueryFeature "github.com/cavenine/queryops/features/osquery"
+	osqueryPages "github.com/cavenine/queryops/features/osquery/pages"
+	osqueryServices "github.com/cavenine/queryops/features/osquery/services"
+	"github.com/cavenine/queryops/internal/crypto"
+	"github.com/cavenine/queryops/internal/featureflags"
+	"github.com/cavenine/queryops/internal/grpcapi"
+	"github.com/cavenine/queryops/internal/pubsub"
+)
+
+// sessionLifetime is how long a sign-in lasts.
+const sessionLifetime = 30 * 24 * time.Hour
+
+// Deps are what an App is built from. Pool is required; the others are built
+// from the configuration when left nil, except PubSub, whose absence makes
+// live updates poll, and Breaker.
+type Deps struct {
+	Pool     *pgxpool.Pool
+	Sessions *scs.SessionManager
+	PubSub   *pubsub.PubSub
+	// Breaker tells handlers when Pool's database is down, so they answer
+	// without it. Without one they always try the database.
+	Breaker *db.Breaker
+	// Keys encrypts secrets at rest. Built from ENCRYPTION_KEYS.
+	Keys *crypto.Keyring
+	// Jobs enqueues the jobs requested from the web, which the background
+	// worker works.
+	Jobs orgServices.JobInserter
+}
+
+// App is the wired web server: each feature, built once.
+type App struct {
+	Deps
+
+	Organizations *organizationFeature.Feature
+	Osquery       *osqueryFeature.Feature
+	Auth          *authFeature.Feature
+	Account       *accountFeature.Feature
+	Admin         *adminFeature.Feature
+	Notifications *notificationFeature.Feature
+	Dashboard     *dashboardFeature.Feature
+	Incidents     *incidentFeature.Feature
+	Index         *indexFeature.Feature
+	// Degraded serves the UI while the database is down.
+	Degraded *degradedFeature.Feature
+
+	// Flags evaluates feature flags for each request's organization.
+	Flags *featureflags.Service
+
+	// GRPC serves the gRPC API, to callers APITokens authenticates.
+	GRPC      *grpcapi.Server
+	APITokens *orgServices.APITokenRepository
+}
+
+// New builds an App from deps. The osquery feature relays outbox events and
+// listens for host cache invalidations, and feature flags listen for
+// changes, until ctx is done.
+func New(ctx context.Context, deps Deps) (*App, error) {
+	if deps.Pool == nil {
+		return nil, errors.New("app: nil pool")
+	}
+	if deps.Sessions == nil {
+		deps.Sessions = NewSessionManager(deps.Pool)
+	}
+	if deps.Keys == nil {
+		keys, err := crypto.ParseKeyring(config.Global.EncryptionKeys)
+		if err != nil {
+			return nil, fmt.Errorf("loading ENCRYPTION_KEYS: %w", err)
+		}
+		deps.Keys = keys
+	}
+	if deps.Jobs == nil {
+		jobs, err := background.NewInsertClient(deps.Pool)
+		if err != nil {
+			return nil, err
+		}
+		deps.Jobs = jobs
+	}
+
+	a := &App{Deps: deps}
+	a.Organizations = organizationFeature.NewFeature(deps.Pool, deps.Sessions, deps.Keys, deps.Jobs)
+	orgService := a.Organizations.Service()
+
+	var err error
+	a.Osquery, err = osqueryFeature.NewFeature(ctx, deps.Pool, deps.Breaker, orgService, deps.PubSub, deps.Jobs)
+	if err != nil {
+		return nil, err
+	}
+	a.Auth, err = authFeature.NewAuthFeature(deps.Sessions, deps.Pool)
+	if err != nil {
+		return nil, fmt.Errorf("initializing auth feature: %w", err)
+	}
+	a.Account = accountFeature.NewFeature(a.Auth, deps.Sessions)
+	a.Admin = adminFeature.NewFeature(deps.Pool, deps.Sessions)
+	a.Notifications = notificationFeature.NewFeature(deps.Pool, deps.PubSub)
+	a.Dashboard = dashboardFeature.NewFeature(deps.Pool)
+	a.Incidents = incidentFeature.NewFeature(deps.Pool, deps.PubSub)
+	a.Index = indexFeature.NewFeature(deps.Sessions, deps.Pool, orgService)
+	a.Degraded = degradedFeature.NewFeature(deps.Breaker, deps.Sessions.Cookie.Name)
+
+	var publisher message.Publisher
+	if deps.PubSub != nil {
+		publisher = deps.PubSub.Publisher()
+	}
+	var subscriber grpcapi.Subscriber
+	if deps.PubSub != nil {
+		subscriber = deps.PubSub
+	}
+	a.GRPC = grpcapi.NewServer(osqueryServices.NewHostRepository(deps.Pool), a.Osquery.Campaigns(), subscriber, osqueryPages.HostOnlineWindow)
+	a.APITokens = orgServices.NewAPITokenRepository(deps.Pool)
+
+	a.Flags = featureflags.NewService(featureflags.NewStore(deps.Pool), publisher)
+	if deps.PubSub != nil {
+		if err := a.Flags.Listen(ctx, deps.PubSub); err != nil {
+			slog.ErrorContext(ctx, "failed to subscribe to feature flag changes; cached flags expire by TTL only", "error", err)
+		}
+	}
+	return a, nil
+}
+
+// NewSessionManager returns the session manager the web server uses, storing
+// sessions in Postgres.
+func NewSessionManager(pool *pgxpool.Pool) *scs.SessionManager {
+	sessionManager := scs.New()
+	sessionManager.Store = pgxstore.New(pool)
+	sessionManager.Lifetime = sessionLifetime
+	sessionManager.Cookie.Name = "session"
+	sessionManager.Cookie.Path = "/"
+	sessionManager.Cookie.HttpOnly = true
+	sessionManager.Cookie.Secure = config.Global.Environment == config.Prod
+	sessionManager.Cookie.SameSite = http.SameSiteLaxMode
+	return sessionManager
+}
@@ -0,0 +1,71 @@
+package app_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+
+	"github.com/cavenine/queryops/internal/app"
+	"github.com/cavenine/queryops/internal/crypto"
+	"github.com/cavenine/queryops/internal/testdb"
+	"github.com/cavenine/queryops/router"
+)
+
+func TestMain(m *testing.M) {
+	testdb.RunWithPostgres(m)
+}
+
+func TestNew(t *testing.T) {
+	if _, err := app.New(context.Background(), app.Deps{}); err == nil {
+		t.Fatalf("New accepted deps without a pool")
+	}
+
+	tdb := testdb.SetupTestDB(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+
+	spec, err := crypto.GenerateKey("test")
+	if err != nil {
+		t.Fatalf("generating key: %v", err)
+	}
+	keys, err := crypto.ParseKeyring(spec)
+	if err != nil {
+		t.Fatalf("parsing keyring: %v", err)
+	}
+	sessions := app.NewSessionManager(tdb.Pool)
+
+	a, err := app.New(ctx, app.Deps{Pool: tdb.Pool, Sessions: sessions, Keys: keys})
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	if a.Sessions != sessions || a.Keys != keys {
+		t.Fatalf("New replaced the dependencies it was given")
+	}
+	if a.Jobs == nil {
+		t.Fatalf("New left Jobs unset")
+	}
+
+	r := chi.NewMux()
+	if err := router.SetupRoutes(r, a); err != nil {
+		t.Fatalf("SetupRoutes: %v", err)
+	}
+
+	for _, tc := range []struct {
+		path string
+		want int
+	}{
+		{"/up", http.StatusOK},
+		// Protected pages send a signed-out visitor to sign in.
+		{"/dashboard", http.StatusSeeOther},
+		{"/hosts", http.StatusSeeOther},
+	} {
+		rec := httptest.NewRecorder()
+		r.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, tc.path, nil))
+		if rec.Code != tc.want {
+			t.Errorf("GET %s = %d, want %d", tc.path, rec.Code, tc.want)
+		}
+	}
+}
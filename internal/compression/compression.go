@@ -0,0 +1,73 @@
+// Package compression compresses HTTP responses with brotli or gzip.
+package compression
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/CAFxX/httpcompression"
+	"github.com/CAFxX/httpcompression/contrib/andybalholm/brotli"
+	"github.com/CAFxX/httpcompression/contrib/compress/gzip"
+
+	"github.com/cavenine/queryops/internal/wsstream"
+)
+
+// minSize is the smallest response worth compressing.
+const minSize = 1024
+
+// contentTypes are the responses that are compressed. text/event-stream is
+// deliberately absent.
+var contentTypes = []string{
+	"text/html",
+	"text/css",
+	"text/plain",
+	"text/javascript",
+	"application/javascript",
+	"application/json",
+	"image/svg+xml",
+}
+
+// Middleware compresses HTML, JSON and static asset responses, preferring
+// brotli when the client accepts it. Server-sent event streams are passed
+// through untouched: compressing them would buffer events until the
+// compressor flushes. So are WebSocket upgrades, which take over the
+// connection.
+func Middleware() (func(http.Handler) http.Handler, error) {
+	br, err := brotli.New(brotli.Options{Quality: 5})
+	if err != nil {
+		return nil, fmt.Errorf("creating brotli compressor: %w", err)
+	}
+	gz, err := gzip.New(gzip.Options{Level: gzip.DefaultCompression})
+	if err != nil {
+		return nil, fmt.Errorf("creating gzip compressor: %w", err)
+	}
+
+	compress, err := httpcompression.Adapter(
+		httpcompression.Compressor(brotli.Encoding, 1, br),
+		httpcompression.Compressor(gzip.Encoding, 0, gz),
+		httpcompression.ContentTypes(contentTypes, false),
+		httpcompression.MinSize(minSize),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("creating compression adapter: %w", err)
+	}
+
+	return func(next http.Handler) http.Handler {
+		compressed := compress(next)
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if isEventStream(r) || wsstream.IsUpgrade(r) {
+				next.ServeHTTP(w, r)
+				return
+			}
+			compressed.ServeHTTP(w, r)
+		})
+	}, nil
+}
+
+// isEventStream reports whether r expects a server-sent event stream.
+// Datastar marks every request it makes, and answers to them are SSE.
+func isEventStream(r *http.Request) bool {
+	return r.Header.Get("Datastar-Request") != "" ||
+		strings.Contains(r.Header.Get("Accept"), "text/event-stream")
+}
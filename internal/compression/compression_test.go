@@ -0,0 +1,86 @@
+package compression
+
+import (
+	"compress/gzip"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+var body = strings.Repeat("<tr><td>host</td><td>online</td></tr>", 200)
+
+func serve(t *testing.T, contentType string, header http.Header) *httptest.ResponseRecorder {
+	t.Helper()
+
+	mw, err := Middleware()
+	if err != nil {
+		t.Fatalf("Middleware: %v", err)
+	}
+	handler := mw(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", contentType)
+		_, _ = io.WriteString(w, body)
+	}))
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestMiddlewareGzip(t *testing.T) {
+	rec := serve(t, "text/html; charset=utf-8", http.Header{"Accept-Encoding": {"gzip"}})
+
+	if got := rec.Header().Get("Content-Encoding"); got != "gzip" {
+		t.Fatalf("Content-Encoding = %q, want gzip", got)
+	}
+	zr, err := gzip.NewReader(rec.Body)
+	if err != nil {
+		t.Fatalf("gzip.NewReader: %v", err)
+	}
+	got, err := io.ReadAll(zr)
+	if err != nil {
+		t.Fatalf("reading gzip body: %v", err)
+	}
+	if string(got) != body {
+		t.Fatalf("decompressed body does not match")
+	}
+}
+
+func TestMiddlewarePrefersBrotli(t *testing.T) {
+	rec := serve(t, "application/json", http.Header{"Accept-Encoding": {"gzip, br"}})
+
+	if got := rec.Header().Get("Content-Encoding"); got != "br" {
+		t.Fatalf("Content-Encoding = %q, want br", got)
+	}
+}
+
+func TestMiddlewareSkips(t *testing.T) {
+	tests := map[string]struct {
+		contentType string
+		header      http.Header
+	}{
+		"no accept-encoding": {"text/html", http.Header{}},
+		"event stream":       {"text/event-stream", http.Header{"Accept-Encoding": {"gzip"}}},
+		"datastar request":   {"text/html", http.Header{"Accept-Encoding": {"gzip"}, "Datastar-Request": {"true"}}},
+		"sse accept":         {"text/html", http.Header{"Accept-Encoding": {"gzip"}, "Accept": {"text/event-stream"}}},
+		"websocket":          {"text/html", http.Header{"Accept-Encoding": {"gzip"}, "Connection": {"Upgrade"}, "Upgrade": {"websocket"}}},
+		"image":              {"image/png", http.Header{"Accept-Encoding": {"gzip"}}},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			rec := serve(t, tt.contentType, tt.header)
+
+			if got := rec.Header().Get("Content-Encoding"); got != "" {
+				t.Fatalf("Content-Encoding = %q, want none", got)
+			}
+			if rec.Body.String() != body {
+				t.Fatalf("body was modified")
+			}
+		})
+	}
+}
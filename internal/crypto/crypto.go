@@ -0,0 +1,254 @@
+// Package crypto encrypts sensitive column values at rest with envelope
+// encryption: every value is sealed with its own random data key, and the data
+// key is wrapped by a key-encryption key (KEK) from the Keyring. Rotating the
+// KEK means adding a new primary key and re-encrypting rows in batches; the
+// old key stays in the keyring until no row uses it.
+//
+// Ciphertexts are strings of the form
+//
+//	v1:<kek id>:<wrapped data key>:<sealed value>
+//
+// with both binary parts base64url-encoded, so they fit TEXT columns and the
+// KEK that protects a row can be read off it.
+package crypto
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+const (
+	formatVersion = "v1"
+	dataKeySize   = 32
+)
+
+var (
+	ErrInvalidKeySpec    = errors.New("invalid encryption key")
+	ErrUnknownKey        = errors.New("ciphertext was encrypted with an unknown key")
+	ErrInvalidCiphertext = errors.New("invalid ciphertext")
+)
+
+// KeyEncryptionKey wraps and unwraps data keys. Local keys come from
+// NewAESKey; a KMS-held key implements it by calling the KMS, so the key
+// itself never reaches this process.
+type KeyEncryptionKey interface {
+	// ID names the key in ciphertexts. It must not contain ':'.
+	ID() string
+	Wrap(dataKey []byte) ([]byte, error)
+	Unwrap(wrapped []byte) ([]byte, error)
+}
+
+// Keyring encrypts with its primary key and decrypts with any of its keys.
+type Keyring struct {
+	primary KeyEncryptionKey
+	keys    map[string]KeyEncryptionKey
+}
+
+// NewKeyring returns a keyring that encrypts with primary and can still
+// decrypt values encrypted with any of the older keys.
+func NewKeyring(primary KeyEncryptionKey, older ...KeyEncryptionKey) (*Keyring, error) {
+	k := &Keyring{primary: primary, keys: make(map[string]KeyEncryptionKey)}
+	for _, key := range append([]KeyEncryptionKey{primary}, older...) {
+		id := key.ID()
+		if id == "" || strings.Contains(id, ":") {
+			return nil, fmt.Errorf("%w: key id %q", ErrInvalidKeySpec, id)
+		}
+		if _, ok := k.keys[id]; ok {
+			return nil, fmt.Errorf("%w: duplicate key id %q", ErrInvalidKeySpec, id)
+		}
+		k.keys[id] = key
+	}
+	return k, nil
+}
+
+// ParseKeyring parses a comma-separated list of id:base64key pairs, e.g.
+// "2026-10:<key>,2025-01:<key>". Keys are 32 bytes of standard base64; the
+// first is the primary.
+func ParseKeyring(spec string) (*Keyring, error) {
+	var keys []KeyEncryptionKey
+	for _, part := range strings.Split(spec, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		id, encoded, ok := strings.Cut(part, ":")
+		if !ok {
+			return nil, fmt.Errorf("%w: want id:base64key", ErrInvalidKeySpec)
+		}
+		raw, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			return nil, fmt.Errorf("%w: key %q is not base64", ErrInvalidKeySpec, id)
+		}
+		key, err := NewAESKey(id, raw)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("%w: no keys configured", ErrInvalidKeySpec)
+	}
+	return NewKeyring(keys[0], keys[1:]...)
+}
+
+// GenerateKey returns a new random key in the form ParseKeyring expects.
+func GenerateKey(id string) (string, error) {
+	raw := make([]byte, 32)
+	if _, err := rand.Read(raw); err != nil {
+		return "", fmt.Errorf("generating key: %w", err)
+	}
+	return id + ":" + base64.StdEncoding.EncodeToString(raw), nil
+}
+
+// PrimaryID is the ID of the key new values are encrypted with.
+func (k *Keyring) PrimaryID() string {
+	return k.primary.ID()
+}
+
+// Encrypt seals plaintext under a new data key wrapped by the primary key.
+// aad is authenticated but not stored; pass the same value to Decrypt. Use it
+// to bind a value to its row so it can't be copied to another.
+func (k *Keyring) Encrypt(plaintext, aad []byte) (string, error) {
+	dataKey := make([]byte, dataKeySize)
+	if _, err := rand.Read(dataKey); err != nil {
+		return "", fmt.Errorf("generating data key: %w", err)
+	}
+	wrapped, err := k.primary.Wrap(dataKey)
+	if err != nil {
+		return "", fmt.Errorf("wrapping data key: %w", err)
+	}
+	sealed, err := seal(dataKey, plaintext, aad)
+	if err != nil {
+		return "", err
+	}
+	return strings.Join([]string{
+		formatVersion,
+		k.primary.ID(),
+		base64.RawURLEncoding.EncodeToString(wrapped),
+		base64.RawURLEncoding.EncodeToString(sealed),
+	}, ":"), nil
+}
+
+// Decrypt opens a value produced by Encrypt with any key in the keyring.
+func (k *Keyring) Decrypt(ciphertext string, aad []byte) ([]byte, error) {
+	id, wrapped, sealed, err := parse(ciphertext)
+	if err != nil {
+		return nil, err
+	}
+	key, ok := k.keys[id]
+	if !ok {
+		return nil, fmt.Errorf("%w: %q", ErrUnknownKey, id)
+	}
+	dataKey, err := key.Unwrap(wrapped)
+	if err != nil {
+		return nil, fmt.Errorf("unwrapping data key: %w", err)
+	}
+	return open(dataKey, sealed, aad)
+}
+
+// NeedsRotation reports whether ciphertext was encrypted with a key other
+// than the primary.
+func (k *Keyring) NeedsRotation(ciphertext string) bool {
+	id, _, _, err := parse(ciphertext)
+	return err != nil || id != k.primary.ID()
+}
+
+func parse(ciphertext string) (id string, wrapped, sealed []byte, err error) {
+	parts := strings.Split(ciphertext, ":")
+	if len(parts) != 4 || parts[0] != formatVersion || parts[1] == "" {
+		return "", nil, nil, ErrInvalidCiphertext
+	}
+	wrapped, err = base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return "", nil, nil, ErrInvalidCiphertext
+	}
+	sealed, err = base64.RawURLEncoding.DecodeString(parts[3])
+	if err != nil {
+		return "", nil, nil, ErrInvalidCiphertext
+	}
+	return parts[1], wrapped, sealed, nil
+}
+
+// aesKey is a KEK held in memory.
+type aesKey struct {
+	id   string
+	aead cipher.AEAD
+}
+
+// NewAESKey returns a local AES-256-GCM key-encryption key.
+func NewAESKey(id string, key []byte) (KeyEncryptionKey, error) {
+	if len(key) != 32 {
+		return nil, fmt.Errorf("%w: key %q must be 32 bytes, got %d", ErrInvalidKeySpec, id, len(key))
+	}
+	aead, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	return &aesKey{id: id, aead: aead}, nil
+}
+
+func (k *aesKey) ID() string {
+	return k.id
+}
+
+func (k *aesKey) Wrap(dataKey []byte) ([]byte, error) {
+	return sealWith(k.aead, dataKey, []byte(k.id))
+}
+
+func (k *aesKey) Unwrap(wrapped []byte) ([]byte, error) {
+	return openWith(k.aead, wrapped, []byte(k.id))
+}
+
+func seal(key, plaintext, aad []byte) ([]byte, error) {
+	aead, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	return sealWith(aead, plaintext, aad)
+}
+
+func open(key, sealed, aad []byte) ([]byte, error) {
+	aead, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	return openWith(aead, sealed, aad)
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("creating cipher: %w", err)
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, fmt.Errorf("creating GCM: %w", err)
+	}
+	return aead, nil
+}
+
+// sealWith returns nonce || ciphertext.
+func sealWith(aead cipher.AEAD, plaintext, aad []byte) ([]byte, error) {
+	nonce := make([]byte, aead.NonceSize(), aead.NonceSize()+len(plaintext)+aead.Overhead())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, fmt.Errorf("generating nonce: %w", err)
+	}
+	return aead.Seal(nonce, nonce, plaintext, aad), nil
+}
+
+func openWith(aead cipher.AEAD, sealed, aad []byte) ([]byte, error) {
+	if len(sealed) < aead.NonceSize() {
+		return nil, ErrInvalidCiphertext
+	}
+	nonce, ct := sealed[:aead.NonceSize()], sealed[aead.NonceSize():]
+	plaintext, err := aead.Open(nil, nonce, ct, aad)
+	if err != nil {
+		return nil, ErrInvalidCiphertext
+	}
+	return plaintext, nil
+}
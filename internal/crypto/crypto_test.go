@@ -0,0 +1,104 @@
+package crypto
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func mustKeyring(t *testing.T, spec string) *Keyring {
+	t.Helper()
+	k, err := ParseKeyring(spec)
+	if err != nil {
+		t.Fatalf("ParseKeyring(%q): %v", spec, err)
+	}
+	return k
+}
+
+func mustGenerate(t *testing.T, id string) string {
+	t.Helper()
+	key, err := GenerateKey(id)
+	if err != nil {
+		t.Fatalf("GenerateKey: %v", err)
+	}
+	return key
+}
+
+func TestKeyring_RoundTrip(t *testing.T) {
+	k := mustKeyring(t, mustGenerate(t, "k1"))
+
+	ct, err := k.Encrypt([]byte("enroll-secret"), []byte("org-1"))
+	if err != nil {
+		t.Fatalf("Encrypt: %v", err)
+	}
+	if !strings.HasPrefix(ct, "v1:k1:") || strings.Contains(ct, "enroll-secret") {
+		t.Fatalf("ciphertext = %q", ct)
+	}
+	again, _ := k.Encrypt([]byte("enroll-secret"), []byte("org-1"))
+	if again == ct {
+		t.Fatalf("Encrypt is deterministic")
+	}
+
+	pt, err := k.Decrypt(ct, []byte("org-1"))
+	if err != nil || string(pt) != "enroll-secret" {
+		t.Fatalf("Decrypt = %q, %v", pt, err)
+	}
+	if _, err := k.Decrypt(ct, []byte("org-2")); !errors.Is(err, ErrInvalidCiphertext) {
+		t.Fatalf("Decrypt with other aad err = %v", err)
+	}
+	if _, err := k.Decrypt(ct[:len(ct)-2], []byte("org-1")); !errors.Is(err, ErrInvalidCiphertext) {
+		t.Fatalf("Decrypt truncated err = %v", err)
+	}
+	if _, err := k.Decrypt("plaintext", nil); !errors.Is(err, ErrInvalidCiphertext) {
+		t.Fatalf("Decrypt plaintext err = %v", err)
+	}
+}
+
+func TestKeyring_Rotation(t *testing.T) {
+	oldKey := mustGenerate(t, "old")
+	newKey := mustGenerate(t, "new")
+
+	before := mustKeyring(t, oldKey)
+	ct, err := before.Encrypt([]byte("secret"), nil)
+	if err != nil {
+		t.Fatalf("Encrypt: %v", err)
+	}
+
+	after := mustKeyring(t, newKey+","+oldKey)
+	if after.PrimaryID() != "new" {
+		t.Fatalf("PrimaryID = %q", after.PrimaryID())
+	}
+	if !after.NeedsRotation(ct) {
+		t.Fatalf("NeedsRotation(old ciphertext) = false")
+	}
+	pt, err := after.Decrypt(ct, nil)
+	if err != nil || string(pt) != "secret" {
+		t.Fatalf("Decrypt with old key = %q, %v", pt, err)
+	}
+	rotated, err := after.Encrypt(pt, nil)
+	if err != nil {
+		t.Fatalf("Encrypt: %v", err)
+	}
+	if after.NeedsRotation(rotated) {
+		t.Fatalf("NeedsRotation(rotated) = true")
+	}
+
+	retired := mustKeyring(t, newKey)
+	if _, err := retired.Decrypt(ct, nil); !errors.Is(err, ErrUnknownKey) {
+		t.Fatalf("Decrypt with retired key err = %v", err)
+	}
+}
+
+func TestParseKeyring_Invalid(t *testing.T) {
+	for _, spec := range []string{
+		"",
+		"k1",
+		"k1:not-base64!",
+		"k1:c2hvcnQ=",
+		mustGenerate(t, "k1") + "," + mustGenerate(t, "k1"),
+	} {
+		if _, err := ParseKeyring(spec); !errors.Is(err, ErrInvalidKeySpec) {
+			t.Fatalf("ParseKeyring(%q) err = %v, want ErrInvalidKeySpec", spec, err)
+		}
+	}
+}
@@ -0,0 +1,82 @@
+// Package featureflags gates risky features while they roll out. Each flag
+// has a default, stored in Postgres, that per-organization overrides turn on
+// or off. Flags are cached in process and reloaded when any instance changes
+// one, so a flag can be enabled for a few organizations, then everyone,
+// without a deploy.
+package featureflags
+
+import (
+	"context"
+	"errors"
+	"io"
+	"maps"
+	"slices"
+
+	"github.com/a-h/templ"
+)
+
+// Flags the code checks. Add a flag here before checking it; flags that
+// aren't listed can't be set.
+const (
+	// JetStream delivers live updates through NATS JetStream instead of core
+	// NATS.
+	JetStream = "jetstream"
+	// NewResultsUI shows the redesigned campaign results page.
+	NewResultsUI = "new_results_ui"
+)
+
+// Known describes every flag the code checks.
+var Known = map[string]string{
+	JetStream:    "Deliver live updates through NATS JetStream",
+	NewResultsUI: "Show the redesigned campaign results page",
+}
+
+var ErrUnknownFlag = errors.New("unknown feature flag")
+
+// Names returns the known flags, sorted.
+func Names() []string {
+	return slices.Sorted(maps.Keys(Known))
+}
+
+// Set is the flags evaluated for one organization. Flags missing from it are
+// off.
+type Set map[string]bool
+
+// Enabled reports whether flag is on.
+func (s Set) Enabled(flag string) bool {
+	return s[flag]
+}
+
+type contextKey struct{}
+
+// WithSet returns a copy of ctx carrying flags.
+func WithSet(ctx context.Context, flags Set) context.Context {
+	return context.WithValue(ctx, contextKey{}, flags)
+}
+
+// FromContext returns the flags Middleware evaluated for the request, or nil
+// outside it.
+func FromContext(ctx context.Context) Set {
+	flags, _ := ctx.Value(contextKey{}).(Set)
+	return flags
+}
+
+// Enabled reports whether flag is on for the request's organization.
+func Enabled(ctx context.Context, flag string) bool {
+	return FromContext(ctx).Enabled(flag)
+}
+
+// If renders its children only when flag is on for the request's
+// organization:
+//
+//	@featureflags.If(featureflags.NewResultsUI) {
+//		@newResults(campaign)
+//	}
+func If(flag string) templ.Component {
+	return templ.ComponentFunc(func(ctx context.Context, w io.Writer) error {
+		if !Enabled(ctx, flag) {
+			return nil
+		}
+		return templ.GetChildren(ctx).Render(ctx, w)
+	})
+}
@@ -0,0 +1,197 @@
+package featureflags
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/ThreeDotsLabs/watermill/message"
+	"github.com/google/uuid"
+
+	"github.com/cavenine/queryops/internal/pubsub"
+)
+
+// defaultCacheTTL bounds how stale flags get when a change event is missed,
+// or when the change was made without pubsub.
+const defaultCacheTTL = 30 * time.Second
+
+type store interface {
+	Load(ctx context.Context) (*Snapshot, error)
+	SetDefault(ctx context.Context, flag string, enabled bool) error
+	SetOverride(ctx context.Context, flag string, organizationID uuid.UUID, enabled bool) error
+	ClearOverride(ctx context.Context, flag string, organizationID uuid.UUID) error
+}
+
+// Service evaluates flags from an in-process copy of the store. The copy is
+// reloaded after defaultCacheTTL, and at once when a change is published on
+// pubsub.TopicFeatureFlags by any instance.
+type Service struct {
+	store     store
+	publisher message.Publisher
+	ttl       time.Duration
+	now       func() time.Time
+
+	snapshot atomic.Pointer[Snapshot]
+	// generation counts invalidations, so a load that raced one isn't cached.
+	generation atomic.Uint64
+	// loadMu keeps concurrent requests from reloading at once.
+	loadMu sync.Mutex
+}
+
+// NewService returns a Service reading from st. Changes are published on
+// publisher, which may be nil.
+func NewService(st store, publisher message.Publisher) *Service {
+	return &Service{
+		store:     st,
+		publisher: publisher,
+		ttl:       defaultCacheTTL,
+		now:       time.Now,
+	}
+}
+
+// Evaluate returns the flags for the organization, which may be uuid.Nil. If
+// flags can't be loaded, the last copy is used, or every flag is off.
+func (s *Service) Evaluate(ctx context.Context, organizationID uuid.UUID) Set {
+	snap := s.snapshot.Load()
+	if snap == nil || s.now().Sub(snap.LoadedAt) >= s.ttl {
+		snap = s.reload(ctx, snap)
+	}
+	return snap.Evaluate(organizationID)
+}
+
+func (s *Service) reload(ctx context.Context, stale *Snapshot) *Snapshot {
+	s.loadMu.Lock()
+	defer s.loadMu.Unlock()
+
+	// Another request may have reloaded while this one waited.
+	if snap := s.snapshot.Load(); snap != nil && snap != stale {
+		return snap
+	}
+
+	generation := s.generation.Load()
+	snap, err := s.store.Load(ctx)
+	if err != nil {
+		slog.ErrorContext(ctx, "failed to load feature flags", "error", err)
+		if stale == nil {
+			return &Snapshot{}
+		}
+		// Retry after another TTL rather than on every request.
+		retry := *stale
+		retry.LoadedAt = s.now()
+		s.snapshot.Store(&retry)
+		return &retry
+	}
+	snap.LoadedAt = s.now()
+	if s.generation.Load() == generation {
+		s.snapshot.Store(snap)
+	}
+	return snap
+}
+
+// Invalidate drops the cached flags so the next evaluation reloads them.
+func (s *Service) Invalidate() {
+	s.generation.Add(1)
+	s.snapshot.Store(nil)
+}
+
+// SetDefault turns flag on or off for organizations without an override.
+func (s *Service) SetDefault(ctx context.Context, flag string, enabled bool) error {
+	if err := s.store.SetDefault(ctx, flag, enabled); err != nil {
+		return err
+	}
+	s.changed(ctx, flag, uuid.Nil)
+	return nil
+}
+
+// SetOverride turns flag on or off for one organization.
+func (s *Service) SetOverride(ctx context.Context, flag string, organizationID uuid.UUID, enabled bool) error {
+	if err := s.store.SetOverride(ctx, flag, organizationID, enabled); err != nil {
+		return err
+	}
+	s.changed(ctx, flag, organizationID)
+	return nil
+}
+
+// ClearOverride returns the organization to flag's default.
+func (s *Service) ClearOverride(ctx context.Context, flag string, organizationID uuid.UUID) error {
+	if err := s.store.ClearOverride(ctx, flag, organizationID); err != nil {
+		return err
+	}
+	s.changed(ctx, flag, organizationID)
+	return nil
+}
+
+// changed drops the local copy and tells other instances to drop theirs.
+func (s *Service) changed(ctx context.Context, flag string, organizationID uuid.UUID) {
+	s.Invalidate()
+	if s.publisher == nil {
+		return
+	}
+	event := pubsub.FeatureFlagChangedEvent{
+		Flag:           flag,
+		OrganizationID: organizationID,
+		OccurredAt:     time.Now(),
+	}
+	if err := s.publisher.Publish(pubsub.TopicFeatureFlags, event.ToMessage()); err != nil {
+		slog.ErrorContext(ctx, "failed to publish feature flag change; other instances reload it by TTL", "flag", flag, "error", err)
+	}
+}
+
+// Listen invalidates the cached flags as changes arrive from any instance.
+// It returns once the subscription is established; the listener runs until
+// ctx is cancelled.
+func (s *Service) Listen(ctx context.Context, ps *pubsub.PubSub) error {
+	subscriber, err := ps.NewSubscriber(ctx)
+	if err != nil {
+		return err
+	}
+
+	changes, err := subscriber.Subscribe(ctx, pubsub.TopicFeatureFlags)
+	if err != nil {
+		_ = subscriber.Close()
+		return err
+	}
+
+	go func() {
+		defer func() {
+			_ = subscriber.Close()
+		}()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case msg := <-changes:
+				if msg == nil {
+					return
+				}
+
+				if _, err := pubsub.ParseFeatureFlagChangedEvent(msg); err != nil {
+					slog.ErrorContext(ctx, "failed to parse feature flag changed event", "error", err)
+				}
+				// Reload either way: the event only says something changed.
+				s.Invalidate()
+				msg.Ack()
+			}
+		}
+	}()
+
+	return nil
+}
+
+// Middleware evaluates the flags for the organization organizationID returns
+// from the request context, and stores them there for Enabled, FromContext,
+// and If. Mount it after the organization is loaded; without one, flags
+// take their defaults.
+func (s *Service) Middleware(organizationID func(context.Context) uuid.UUID) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ctx := r.Context()
+			flags := s.Evaluate(ctx, organizationID(ctx))
+			next.ServeHTTP(w, r.WithContext(WithSet(ctx, flags)))
+		})
+	}
+}
@@ -0,0 +1,199 @@
+package featureflags
+
+import (
+	"context"
+	"errors"
+	"maps"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ThreeDotsLabs/watermill/message"
+	"github.com/a-h/templ"
+	"github.com/google/uuid"
+
+	"github.com/cavenine/queryops/internal/pubsub"
+)
+
+type fakeStore struct {
+	mu        sync.Mutex
+	defaults  map[string]bool
+	overrides map[uuid.UUID]map[string]bool
+	loads     int
+	failLoad  bool
+}
+
+func newFakeStore() *fakeStore {
+	return &fakeStore{
+		defaults:  make(map[string]bool),
+		overrides: make(map[uuid.UUID]map[string]bool),
+	}
+}
+
+func (s *fakeStore) Load(context.Context) (*Snapshot, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.loads++
+	if s.failLoad {
+		return nil, errors.New("database unavailable")
+	}
+	snap := &Snapshot{
+		Defaults:  make(map[string]bool),
+		Overrides: make(map[uuid.UUID]map[string]bool),
+	}
+	maps.Copy(snap.Defaults, s.defaults)
+	for orgID, overrides := range s.overrides {
+		snap.Overrides[orgID] = maps.Clone(overrides)
+	}
+	return snap, nil
+}
+
+func (s *fakeStore) SetDefault(_ context.Context, flag string, enabled bool) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.defaults[flag] = enabled
+	return nil
+}
+
+func (s *fakeStore) SetOverride(_ context.Context, flag string, organizationID uuid.UUID, enabled bool) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.overrides[organizationID] == nil {
+		s.overrides[organizationID] = make(map[string]bool)
+	}
+	s.overrides[organizationID][flag] = enabled
+	return nil
+}
+
+func (s *fakeStore) ClearOverride(_ context.Context, flag string, organizationID uuid.UUID) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.overrides[organizationID], flag)
+	return nil
+}
+
+type recordingPublisher struct {
+	mu     sync.Mutex
+	events []pubsub.FeatureFlagChangedEvent
+}
+
+func (p *recordingPublisher) Publish(topic string, msgs ...*message.Message) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, msg := range msgs {
+		event, err := pubsub.ParseFeatureFlagChangedEvent(msg)
+		if err != nil {
+			return err
+		}
+		if topic != pubsub.TopicFeatureFlags {
+			return errors.New("unexpected topic " + topic)
+		}
+		p.events = append(p.events, event)
+	}
+	return nil
+}
+
+func (p *recordingPublisher) Close() error { return nil }
+
+func TestService_CachesUntilChanged(t *testing.T) {
+	ctx := context.Background()
+	store := newFakeStore()
+	publisher := &recordingPublisher{}
+	svc := NewService(store, publisher)
+	now := time.Now()
+	svc.now = func() time.Time { return now }
+
+	orgID := uuid.New()
+	if svc.Evaluate(ctx, orgID).Enabled(NewResultsUI) {
+		t.Fatal("NewResultsUI on before it was set")
+	}
+
+	// Changes made elsewhere are picked up once the TTL passes.
+	_ = store.SetDefault(ctx, NewResultsUI, true)
+	if svc.Evaluate(ctx, orgID).Enabled(NewResultsUI) {
+		t.Fatal("cached flags reloaded before the TTL")
+	}
+	if store.loads != 1 {
+		t.Fatalf("loads = %d, want 1", store.loads)
+	}
+	now = now.Add(defaultCacheTTL)
+	if !svc.Evaluate(ctx, orgID).Enabled(NewResultsUI) {
+		t.Fatal("NewResultsUI off after the TTL")
+	}
+
+	// Changes made through the service are picked up at once and published.
+	if err := svc.SetOverride(ctx, NewResultsUI, orgID, false); err != nil {
+		t.Fatalf("SetOverride: %v", err)
+	}
+	if svc.Evaluate(ctx, orgID).Enabled(NewResultsUI) {
+		t.Fatal("override not applied")
+	}
+	if !svc.Evaluate(ctx, uuid.New()).Enabled(NewResultsUI) {
+		t.Fatal("override applied to another organization")
+	}
+	if len(publisher.events) != 1 || publisher.events[0].Flag != NewResultsUI || publisher.events[0].OrganizationID != orgID {
+		t.Fatalf("published %+v, want one change to %s for %s", publisher.events, NewResultsUI, orgID)
+	}
+}
+
+func TestService_KeepsFlagsWhenLoadFails(t *testing.T) {
+	ctx := context.Background()
+	store := newFakeStore()
+	_ = store.SetDefault(ctx, JetStream, true)
+	svc := NewService(store, nil)
+	now := time.Now()
+	svc.now = func() time.Time { return now }
+
+	if !svc.Evaluate(ctx, uuid.Nil).Enabled(JetStream) {
+		t.Fatal("JetStream off")
+	}
+
+	store.failLoad = true
+	now = now.Add(defaultCacheTTL)
+	if !svc.Evaluate(ctx, uuid.Nil).Enabled(JetStream) {
+		t.Fatal("JetStream off after a failed reload")
+	}
+	// The failed reload isn't retried until another TTL passes.
+	svc.Evaluate(ctx, uuid.Nil)
+	if store.loads != 2 {
+		t.Fatalf("loads = %d, want 2", store.loads)
+	}
+
+	// Without a copy to fall back on, every flag is off.
+	svc.Invalidate()
+	if svc.Evaluate(ctx, uuid.Nil).Enabled(JetStream) {
+		t.Fatal("JetStream on without loaded flags")
+	}
+}
+
+func TestMiddleware(t *testing.T) {
+	ctx := context.Background()
+	store := newFakeStore()
+	orgID := uuid.New()
+	_ = store.SetOverride(ctx, NewResultsUI, orgID, true)
+	svc := NewService(store, nil)
+
+	handler := svc.Middleware(func(context.Context) uuid.UUID { return orgID })(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := templ.WithChildren(r.Context(), templ.Raw("new results"))
+		if err := If(NewResultsUI).Render(ctx, w); err != nil {
+			t.Errorf("If(NewResultsUI): %v", err)
+		}
+		ctx = templ.WithChildren(r.Context(), templ.Raw("jetstream"))
+		if err := If(JetStream).Render(ctx, w); err != nil {
+			t.Errorf("If(JetStream): %v", err)
+		}
+	}))
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+	if got := rec.Body.String(); !strings.Contains(got, "new results") || strings.Contains(got, "jetstream") {
+		t.Fatalf("body = %q, want only the enabled flag's children", got)
+	}
+
+	if Enabled(ctx, NewResultsUI) {
+		t.Fatal("Enabled outside Middleware = true, want false")
+	}
+}
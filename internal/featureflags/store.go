@@ -0,0 +1,158 @@
+package featureflags
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+var ErrOrganizationNotFound = errors.New("organization not found")
+
+// Snapshot is every flag's default and organization overrides, as loaded at
+// LoadedAt.
+type Snapshot struct {
+	Defaults  map[string]bool
+	Overrides map[uuid.UUID]map[string]bool
+	LoadedAt  time.Time
+}
+
+// Evaluate returns the known flags for the organization: its override if it
+// has one, otherwise the flag's default. organizationID may be uuid.Nil.
+func (s *Snapshot) Evaluate(organizationID uuid.UUID) Set {
+	flags := make(Set, len(Known))
+	for flag := range Known {
+		enabled, ok := s.Overrides[organizationID][flag]
+		if !ok {
+			enabled = s.Defaults[flag]
+		}
+		flags[flag] = enabled
+	}
+	return flags
+}
+
+// Store saves flags in Postgres.
+type Store struct {
+	pool *pgxpool.Pool
+}
+
+func NewStore(pool *pgxpool.Pool) *Store {
+	return &Store{pool: pool}
+}
+
+// Load returns every flag and override.
+func (s *Store) Load(ctx context.Context) (*Snapshot, error) {
+	snap := &Snapshot{
+		Defaults:  make(map[string]bool),
+		Overrides: make(map[uuid.UUID]map[string]bool),
+		LoadedAt:  time.Now(),
+	}
+
+	rows, err := s.pool.Query(ctx, `SELECT name, enabled FROM feature_flags`)
+	if err != nil {
+		return nil, fmt.Errorf("querying feature flags: %w", err)
+	}
+	for rows.Next() {
+		var (
+			name    string
+			enabled bool
+		)
+		if err := rows.Scan(&name, &enabled); err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("scanning feature flag: %w", err)
+		}
+		snap.Defaults[name] = enabled
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("iterating feature flags: %w", err)
+	}
+
+	rows, err = s.pool.Query(ctx, `SELECT flag, organization_id, enabled FROM feature_flag_overrides`)
+	if err != nil {
+		return nil, fmt.Errorf("querying feature flag overrides: %w", err)
+	}
+	for rows.Next() {
+		var (
+			flag           string
+			organizationID uuid.UUID
+			enabled        bool
+		)
+		if err := rows.Scan(&flag, &organizationID, &enabled); err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("scanning feature flag override: %w", err)
+		}
+		if snap.Overrides[organizationID] == nil {
+			snap.Overrides[organizationID] = make(map[string]bool)
+		}
+		snap.Overrides[organizationID][flag] = enabled
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("iterating feature flag overrides: %w", err)
+	}
+	return snap, nil
+}
+
+// SetDefault turns flag on or off for organizations without an override.
+func (s *Store) SetDefault(ctx context.Context, flag string, enabled bool) error {
+	if _, ok := Known[flag]; !ok {
+		return ErrUnknownFlag
+	}
+	_, err := s.pool.Exec(ctx, `
+		INSERT INTO feature_flags (name, enabled) VALUES ($1, $2)
+		ON CONFLICT (name) DO UPDATE SET enabled = EXCLUDED.enabled, updated_at = now()
+	`, flag, enabled)
+	if err != nil {
+		return fmt.Errorf("setting feature flag: %w", err)
+	}
+	return nil
+}
+
+// SetOverride turns flag on or off for one organization, whatever its
+// default.
+func (s *Store) SetOverride(ctx context.Context, flag string, organizationID uuid.UUID, enabled bool) error {
+	if _, ok := Known[flag]; !ok {
+		return ErrUnknownFlag
+	}
+	tx, err := s.pool.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("starting feature flag override: %w", err)
+	}
+	defer func() { _ = tx.Rollback(ctx) }()
+
+	// A flag overridden before its default is set stays off by default.
+	if _, err := tx.Exec(ctx, `
+		INSERT INTO feature_flags (name) VALUES ($1)
+		ON CONFLICT (name) DO NOTHING
+	`, flag); err != nil {
+		return fmt.Errorf("creating feature flag: %w", err)
+	}
+	_, err = tx.Exec(ctx, `
+		INSERT INTO feature_flag_overrides (flag, organization_id, enabled) VALUES ($1, $2, $3)
+		ON CONFLICT (flag, organization_id) DO UPDATE SET enabled = EXCLUDED.enabled, updated_at = now()
+	`, flag, organizationID, enabled)
+	if err != nil {
+		var pgErr *pgconn.PgError
+		if errors.As(err, &pgErr) && pgErr.Code == "23503" {
+			return ErrOrganizationNotFound
+		}
+		return fmt.Errorf("setting feature flag override: %w", err)
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return fmt.Errorf("committing feature flag override: %w", err)
+	}
+	return nil
+}
+
+// ClearOverride returns the organization to flag's default.
+func (s *Store) ClearOverride(ctx context.Context, flag string, organizationID uuid.UUID) error {
+	if _, err := s.pool.Exec(ctx, `
+		DELETE FROM feature_flag_overrides WHERE flag = $1 AND organization_id = $2
+	`, flag, organizationID); err != nil {
+		return fmt.Errorf("clearing feature flag override: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,78 @@
+package featureflags
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/google/uuid"
+
+	"github.com/cavenine/queryops/internal/testdb"
+	"github.com/cavenine/queryops/internal/testdb/fixtures"
+)
+
+func TestStore(t *testing.T) {
+	tdb := testdb.SetupTestDB(t)
+	ctx := context.Background()
+	store := NewStore(tdb.Pool)
+
+	rollout := fixtures.CreateOrg(t, tdb.Pool, "rollout-org").ID
+	optOut := fixtures.CreateOrg(t, tdb.Pool, "opt-out-org").ID
+	other := fixtures.CreateOrg(t, tdb.Pool, "other-org").ID
+
+	load := func() *Snapshot {
+		t.Helper()
+		snap, err := store.Load(ctx)
+		if err != nil {
+			t.Fatalf("Load: %v", err)
+		}
+		return snap
+	}
+
+	// Flags start off everywhere.
+	if got := load().Evaluate(rollout); got.Enabled(NewResultsUI) || got.Enabled(JetStream) {
+		t.Fatalf("Evaluate = %v, want every flag off", got)
+	}
+
+	// An override before the default is set turns the flag on for one
+	// organization only.
+	if err := store.SetOverride(ctx, NewResultsUI, rollout, true); err != nil {
+		t.Fatalf("SetOverride: %v", err)
+	}
+	snap := load()
+	if !snap.Evaluate(rollout).Enabled(NewResultsUI) {
+		t.Fatal("NewResultsUI off for the overridden organization")
+	}
+	if snap.Evaluate(other).Enabled(NewResultsUI) {
+		t.Fatal("NewResultsUI on for an organization without an override")
+	}
+
+	// Turning the default on leaves an override off in place.
+	if err := store.SetOverride(ctx, NewResultsUI, optOut, false); err != nil {
+		t.Fatalf("SetOverride: %v", err)
+	}
+	if err := store.SetDefault(ctx, NewResultsUI, true); err != nil {
+		t.Fatalf("SetDefault: %v", err)
+	}
+	snap = load()
+	if !snap.Evaluate(other).Enabled(NewResultsUI) || !snap.Evaluate(uuid.Nil).Enabled(NewResultsUI) {
+		t.Fatal("NewResultsUI off by default after SetDefault")
+	}
+	if snap.Evaluate(optOut).Enabled(NewResultsUI) {
+		t.Fatal("NewResultsUI on for the organization that opted out")
+	}
+
+	if err := store.ClearOverride(ctx, NewResultsUI, optOut); err != nil {
+		t.Fatalf("ClearOverride: %v", err)
+	}
+	if !load().Evaluate(optOut).Enabled(NewResultsUI) {
+		t.Fatal("NewResultsUI off after clearing the override")
+	}
+
+	if err := store.SetDefault(ctx, "typo", true); !errors.Is(err, ErrUnknownFlag) {
+		t.Fatalf("SetDefault(typo) error = %v, want ErrUnknownFlag", err)
+	}
+	if err := store.SetOverride(ctx, JetStream, uuid.New(), true); !errors.Is(err, ErrOrganizationNotFound) {
+		t.Fatalf("SetOverride(missing org) error = %v, want ErrOrganizationNotFound", err)
+	}
+}
@@ -0,0 +1,90 @@
+package grpcapi
+
+import (
+	"context"
+	"errors"
+	"strings"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+
+	org "github.com/cavenine/queryops/features/organization"
+	orgServices "github.com/cavenine/queryops/features/organization/services"
+	"github.com/cavenine/queryops/internal/grpcapi/gen/queryopsv1"
+)
+
+// TokenAuthenticator resolves API tokens to their organization;
+// *orgServices.APITokenRepository satisfies it. Unknown and revoked tokens,
+// and those of disabled organizations, are orgServices.ErrAPITokenNotFound.
+type TokenAuthenticator interface {
+	Authenticate(ctx context.Context, token string) (*orgServices.Organization, error)
+}
+
+// authenticator requires an API token on every QueryOpsService call and puts
+// its organization in the call's context, as the HTTP middleware does for
+// the session's. The health and reflection services are open.
+type authenticator struct {
+	tokens TokenAuthenticator
+}
+
+func (a *authenticator) unary(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+	if !protected(info.FullMethod) {
+		return handler(ctx, req)
+	}
+	ctx, err := a.authenticate(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return handler(ctx, req)
+}
+
+func (a *authenticator) stream(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	if !protected(info.FullMethod) {
+		return handler(srv, ss)
+	}
+	ctx, err := a.authenticate(ss.Context())
+	if err != nil {
+		return err
+	}
+	return handler(srv, &contextStream{ServerStream: ss, ctx: ctx})
+}
+
+// authenticate returns ctx with the organization of the call's bearer
+// token.
+func (a *authenticator) authenticate(ctx context.Context) (context.Context, error) {
+	md, _ := metadata.FromIncomingContext(ctx)
+	values := md.Get("authorization")
+	if len(values) != 1 {
+		return nil, status.Error(codes.Unauthenticated, "authorization: Bearer <token> required")
+	}
+	token, ok := strings.CutPrefix(values[0], "Bearer ")
+	if !ok || token == "" {
+		return nil, status.Error(codes.Unauthenticated, "authorization: Bearer <token> required")
+	}
+
+	activeOrg, err := a.tokens.Authenticate(ctx, token)
+	if errors.Is(err, orgServices.ErrAPITokenNotFound) {
+		return nil, status.Error(codes.Unauthenticated, "invalid API token")
+	}
+	if err != nil {
+		return nil, internalError(ctx, "failed to authenticate API token", err)
+	}
+	return org.SetOrganizationInContext(ctx, activeOrg), nil
+}
+
+// protected reports whether fullMethod belongs to QueryOpsService.
+func protected(fullMethod string) bool {
+	return strings.HasPrefix(fullMethod, "/"+queryopsv1.QueryOpsService_ServiceDesc.ServiceName+"/")
+}
+
+// contextStream is a ServerStream with its context replaced.
+type contextStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *contextStream) Context() context.Context {
+	return s.ctx
+}
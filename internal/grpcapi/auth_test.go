@@ -0,0 +1,40 @@
+package grpcapi
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+
+	"github.com/cavenine/queryops/internal/grpcapi/gen/queryopsv1"
+)
+
+func TestAuthentication(t *testing.T) {
+	f := newFixture()
+	client := dial(t, NewServer(f.repo, &stubQueue{}, nil, 10*time.Minute), f.tokens)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	_, err := client.ListHosts(ctx, &queryopsv1.ListHostsRequest{})
+	wantCode(t, err, codes.Unauthenticated)
+
+	basic := metadata.AppendToOutgoingContext(ctx, "authorization", "Basic "+f.token)
+	_, err = client.ListHosts(basic, &queryopsv1.ListHostsRequest{})
+	wantCode(t, err, codes.Unauthenticated)
+
+	// Revoked tokens, like unknown ones, aren't found.
+	_, err = client.ListHosts(withToken(t, "revoked-token"), &queryopsv1.ListHostsRequest{})
+	wantCode(t, err, codes.Unauthenticated)
+
+	stream, err := client.StreamCampaignResults(withToken(t, "revoked-token"), &queryopsv1.StreamCampaignResultsRequest{CampaignId: f.campaignID.String()})
+	if err == nil {
+		_, err = stream.Recv()
+	}
+	wantCode(t, err, codes.Unauthenticated)
+
+	if _, err := client.ListHosts(withToken(t, f.token), &queryopsv1.ListHostsRequest{}); err != nil {
+		t.Fatalf("ListHosts with a valid token: %v", err)
+	}
+}
@@ -0,0 +1,288 @@
+// Package grpcapi serves the gRPC API defined in proto/queryops/v1: host
+// listing, campaign creation, and streamed campaign results. Every call is
+// scoped to the organization of the API token it carries, and is answered
+// from the same repositories and campaign queue as the HTTP API.
+package grpcapi
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/ThreeDotsLabs/watermill/message"
+	"github.com/google/uuid"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/reflection"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	org "github.com/cavenine/queryops/features/organization"
+	orgServices "github.com/cavenine/queryops/features/organization/services"
+	"github.com/cavenine/queryops/features/osquery/services"
+	"github.com/cavenine/queryops/internal/grpcapi/gen/queryopsv1"
+)
+
+// Repository is what the API reads hosts and campaigns through;
+// *services.HostRepository satisfies it.
+type Repository interface {
+	ListByOrganization(ctx context.Context, organizationID uuid.UUID) ([]*services.Host, error)
+	GetByIDAndOrganization(ctx context.Context, id uuid.UUID, organizationID uuid.UUID) (*services.Host, error)
+	ListHostGroups(ctx context.Context, organizationID uuid.UUID) ([]*services.HostGroup, error)
+	ListHostGroupMembers(ctx context.Context, groupID, organizationID uuid.UUID) ([]uuid.UUID, error)
+	GetCampaignByIDAndOrganization(ctx context.Context, campaignID uuid.UUID, organizationID uuid.UUID) (*services.Campaign, error)
+	GetCampaignTargets(ctx context.Context, campaignID uuid.UUID) ([]*services.CampaignTarget, error)
+}
+
+// CampaignQueue creates campaigns within the organization's quota;
+// *osquery.CampaignQueue satisfies it.
+type CampaignQueue interface {
+	QueueQuery(ctx context.Context, organizationID uuid.UUID, createdBy *int, name, description *string, query string, hostIDs []uuid.UUID, opts services.CampaignOptions) (uuid.UUID, error)
+	QueueGroupQuery(ctx context.Context, organizationID uuid.UUID, createdBy *int, name, description *string, query string, groupID uuid.UUID, opts services.CampaignOptions) (uuid.UUID, error)
+}
+
+// Subscriber opens subscriptions to campaign topics; *pubsub.PubSub
+// satisfies it.
+type Subscriber interface {
+	NewSubscriber(ctx context.Context) (message.Subscriber, error)
+}
+
+// Server implements queryopsv1.QueryOpsServiceServer.
+type Server struct {
+	queryopsv1.UnimplementedQueryOpsServiceServer
+
+	repo      Repository
+	campaigns CampaignQueue
+	// pubsub, when set, pushes campaign changes to streams; without it
+	// they poll every pollInterval.
+	pubsub       Subscriber
+	pollInterval time.Duration
+	// onlineWindow is how recently a host must have checked in to be
+	// online.
+	onlineWindow time.Duration
+	now          func() time.Time
+
+	// closing is closed by Close, to end streams.
+	closing   chan struct{}
+	closeOnce sync.Once
+}
+
+// NewServer returns a Server over repo and campaigns. ps may be nil. Hosts
+// count as online within onlineWindow of checking in.
+func NewServer(repo Repository, campaigns CampaignQueue, ps Subscriber, onlineWindow time.Duration) *Server {
+	return &Server{
+		repo:         repo,
+		campaigns:    campaigns,
+		pubsub:       ps,
+		pollInterval: time.Second,
+		onlineWindow: onlineWindow,
+		now:          time.Now,
+		closing:      make(chan struct{}),
+	}
+}
+
+// Close ends open streams with Unavailable, so a graceful stop doesn't wait
+// for campaigns to finish. Clients reconnect to another instance and are
+// sent every target again.
+func (s *Server) Close() {
+	s.closeOnce.Do(func() { close(s.closing) })
+}
+
+// NewGRPCServer returns a grpc.Server serving api, with calls authenticated
+// by tokens, and the health service. reflect registers the reflection
+// service too, for development.
+func NewGRPCServer(api *Server, tokens TokenAuthenticator, reflect bool) *grpc.Server {
+	auth := &authenticator{tokens: tokens}
+	srv := grpc.NewServer(
+		grpc.ChainUnaryInterceptor(auth.unary),
+		grpc.ChainStreamInterceptor(auth.stream),
+	)
+	queryopsv1.RegisterQueryOpsServiceServer(srv, api)
+	healthpb.RegisterHealthServer(srv, health.NewServer())
+	if reflect {
+		reflection.Register(srv)
+	}
+	return srv
+}
+
+func (s *Server) ListHosts(ctx context.Context, req *queryopsv1.ListHostsRequest) (*queryopsv1.ListHostsResponse, error) {
+	activeOrg, err := activeOrganization(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	hosts, err := s.repo.ListByOrganization(ctx, activeOrg.ID)
+	if err != nil {
+		return nil, internalError(ctx, "failed to list hosts", err)
+	}
+
+	if req.GetHostGroupId() != "" {
+		groupID, err := parseID("host_group_id", req.GetHostGroupId())
+		if err != nil {
+			return nil, err
+		}
+		members, err := s.groupMembers(ctx, activeOrg.ID, groupID)
+		if err != nil {
+			return nil, err
+		}
+		hosts = slices.DeleteFunc(hosts, func(h *services.Host) bool {
+			_, ok := slices.BinarySearchFunc(members, h.ID, compareIDs)
+			return !ok
+		})
+	}
+
+	resp := &queryopsv1.ListHostsResponse{Hosts: make([]*queryopsv1.Host, 0, len(hosts))}
+	for _, h := range hosts {
+		resp.Hosts = append(resp.Hosts, s.host(h))
+	}
+	return resp, nil
+}
+
+// groupMembers returns the IDs of a host group's members, sorted, or
+// NotFound if the organization has no such group.
+func (s *Server) groupMembers(ctx context.Context, organizationID, groupID uuid.UUID) ([]uuid.UUID, error) {
+	groups, err := s.repo.ListHostGroups(ctx, organizationID)
+	if err != nil {
+		return nil, internalError(ctx, "failed to list host groups", err)
+	}
+	if !slices.ContainsFunc(groups, func(g *services.HostGroup) bool { return g.ID == groupID }) {
+		return nil, status.Error(codes.NotFound, "host group not found")
+	}
+
+	members, err := s.repo.ListHostGroupMembers(ctx, groupID, organizationID)
+	if err != nil {
+		return nil, internalError(ctx, "failed to list host group members", err)
+	}
+	slices.SortFunc(members, compareIDs)
+	return members, nil
+}
+
+func (s *Server) host(h *services.Host) *queryopsv1.Host {
+	out := &queryopsv1.Host{
+		Id:             h.ID.String(),
+		HostIdentifier: h.HostIdentifier,
+		Platform:       h.Platform(),
+		OsVersion:      osVersion(h),
+	}
+	if seen := h.LastCheckIn(); seen != nil {
+		out.LastSeenAt = timestamppb.New(*seen)
+		out.Online = s.now().Sub(*seen) < s.onlineWindow
+	}
+	return out
+}
+
+func (s *Server) CreateCampaign(ctx context.Context, req *queryopsv1.CreateCampaignRequest) (*queryopsv1.CreateCampaignResponse, error) {
+	activeOrg, err := activeOrganization(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if req.GetQuery() == "" {
+		return nil, status.Error(codes.InvalidArgument, "query is required")
+	}
+	if (len(req.GetHostIds()) == 0) == (req.GetHostGroupId() == "") {
+		return nil, status.Error(codes.InvalidArgument, "set exactly one of host_ids or host_group_id")
+	}
+	name, description := optional(req.GetName()), optional(req.GetDescription())
+	opts := services.CampaignOptions{
+		FanoutLimit:     int(req.GetFanoutLimit()),
+		FanoutInterval:  time.Duration(req.GetFanoutIntervalSeconds()) * time.Second,
+		MaxRowsPerHost:  int(req.GetMaxRowsPerHost()),
+		MaxBytesPerHost: req.GetMaxBytesPerHost(),
+		Timeout:         time.Duration(req.GetTimeoutSeconds()) * time.Second,
+	}
+
+	var campaignID uuid.UUID
+	if req.GetHostGroupId() != "" {
+		groupID, err := parseID("host_group_id", req.GetHostGroupId())
+		if err != nil {
+			return nil, err
+		}
+		campaignID, err = s.campaigns.QueueGroupQuery(ctx, activeOrg.ID, nil, name, description, req.GetQuery(), groupID, opts)
+		if err != nil {
+			return nil, campaignError(ctx, err)
+		}
+		if campaignID == uuid.Nil {
+			return nil, status.Error(codes.NotFound, "host group not found")
+		}
+	} else {
+		hostIDs := make([]uuid.UUID, 0, len(req.GetHostIds()))
+		for _, raw := range req.GetHostIds() {
+			hostID, err := parseID("host_ids", raw)
+			if err != nil {
+				return nil, err
+			}
+			host, err := s.repo.GetByIDAndOrganization(ctx, hostID, activeOrg.ID)
+			if err != nil {
+				return nil, internalError(ctx, "failed to load host", err)
+			}
+			if host == nil {
+				return nil, status.Errorf(codes.NotFound, "host %s not found", hostID)
+			}
+			hostIDs = append(hostIDs, hostID)
+		}
+		campaignID, err = s.campaigns.QueueQuery(ctx, activeOrg.ID, nil, name, description, req.GetQuery(), hostIDs, opts)
+		if err != nil {
+			return nil, campaignError(ctx, err)
+		}
+	}
+
+	slog.InfoContext(ctx, "created campaign over gRPC", "campaign_id", campaignID, "organization_id", activeOrg.ID)
+	return &queryopsv1.CreateCampaignResponse{CampaignId: campaignID.String()}, nil
+}
+
+// campaignError maps an error creating a campaign to its status.
+func campaignError(ctx context.Context, err error) error {
+	switch {
+	case errors.Is(err, services.ErrInvalidCampaignOptions):
+		return status.Error(codes.InvalidArgument, err.Error())
+	case errors.Is(err, services.ErrEmptyHostGroup):
+		return status.Error(codes.FailedPrecondition, "no target hosts")
+	case orgServices.IsQuotaExceeded(err):
+		return status.Error(codes.ResourceExhausted, err.Error())
+	default:
+		return internalError(ctx, "failed to create campaign", err)
+	}
+}
+
+// activeOrganization returns the organization the call's token belongs to.
+func activeOrganization(ctx context.Context) (*orgServices.Organization, error) {
+	activeOrg := org.GetOrganizationFromContext(ctx)
+	if activeOrg == nil {
+		slog.ErrorContext(ctx, "missing active organization in context")
+		return nil, status.Error(codes.Internal, "internal error")
+	}
+	return activeOrg, nil
+}
+
+// internalError logs err and returns the Internal status clients see in
+// its place.
+func internalError(ctx context.Context, msg string, err error) error {
+	slog.ErrorContext(ctx, msg, "error", err)
+	return status.Error(codes.Internal, "internal error")
+}
+
+func parseID(field, raw string) (uuid.UUID, error) {
+	id, err := uuid.Parse(raw)
+	if err != nil {
+		return uuid.Nil, status.Errorf(codes.InvalidArgument, "invalid %s %q", field, raw)
+	}
+	return id, nil
+}
+
+func compareIDs(a, b uuid.UUID) int {
+	return slices.Compare(a[:], b[:])
+}
+
+// optional maps an unset string field to nil.
+func optional(s string) *string {
+	if s == "" {
+		return nil
+	}
+	return &s
+}
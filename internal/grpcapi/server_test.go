@@ -0,0 +1,423 @@
+package grpcapi
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ThreeDotsLabs/watermill"
+	"github.com/ThreeDotsLabs/watermill/message"
+	"github.com/ThreeDotsLabs/watermill/pubsub/gochannel"
+	"github.com/google/uuid"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+
+	orgServices "github.com/cavenine/queryops/features/organization/services"
+	"github.com/cavenine/queryops/features/osquery/services"
+	"github.com/cavenine/queryops/internal/grpcapi/gen/queryopsv1"
+	"github.com/cavenine/queryops/internal/pubsub"
+)
+
+// stubRepo holds one organization's records; lookups scoped to any other
+// organization find nothing. It's safe for a stream to read while a test
+// updates it.
+type stubRepo struct {
+	orgID uuid.UUID
+
+	mu        sync.Mutex
+	hosts     []*services.Host
+	groups    map[uuid.UUID][]uuid.UUID
+	campaigns map[uuid.UUID]*services.Campaign
+	targets   map[uuid.UUID][]*services.CampaignTarget
+}
+
+func (r *stubRepo) ListByOrganization(_ context.Context, organizationID uuid.UUID) ([]*services.Host, error) {
+	if organizationID != r.orgID {
+		return nil, nil
+	}
+	return slices.Clone(r.hosts), nil
+}
+
+func (r *stubRepo) GetByIDAndOrganization(_ context.Context, id uuid.UUID, organizationID uuid.UUID) (*services.Host, error) {
+	for _, h := range r.hosts {
+		if h.ID == id && organizationID == r.orgID {
+			return h, nil
+		}
+	}
+	return nil, nil
+}
+
+func (r *stubRepo) ListHostGroups(_ context.Context, organizationID uuid.UUID) ([]*services.HostGroup, error) {
+	if organizationID != r.orgID {
+		return nil, nil
+	}
+	var groups []*services.HostGroup
+	for id := range r.groups {
+		groups = append(groups, &services.HostGroup{ID: id})
+	}
+	return groups, nil
+}
+
+func (r *stubRepo) ListHostGroupMembers(_ context.Context, groupID, organizationID uuid.UUID) ([]uuid.UUID, error) {
+	if organizationID != r.orgID {
+		return nil, nil
+	}
+	return slices.Clone(r.groups[groupID]), nil
+}
+
+func (r *stubRepo) GetCampaignByIDAndOrganization(_ context.Context, campaignID uuid.UUID, organizationID uuid.UUID) (*services.Campaign, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	c, ok := r.campaigns[campaignID]
+	if !ok || organizationID != r.orgID {
+		return nil, nil
+	}
+	copied := *c
+	return &copied, nil
+}
+
+func (r *stubRepo) GetCampaignTargets(_ context.Context, campaignID uuid.UUID) ([]*services.CampaignTarget, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var targets []*services.CampaignTarget
+	for _, t := range r.targets[campaignID] {
+		copied := *t
+		targets = append(targets, &copied)
+	}
+	return targets, nil
+}
+
+// complete marks the campaign's target for hostID completed with rows, and
+// the campaign with it.
+func (r *stubRepo) complete(campaignID, hostID uuid.UUID, rows string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	now := time.Now()
+	for _, t := range r.targets[campaignID] {
+		if t.HostID == hostID {
+			t.Status, t.Results, t.CompletedAt, t.UpdatedAt = "completed", json.RawMessage(rows), &now, now
+		}
+	}
+	r.campaigns[campaignID].Status = "completed"
+}
+
+// stubQueue records the host campaigns it's asked to create, and fails them
+// with err if it's set. The organization has no host groups to queue for.
+type stubQueue struct {
+	err     error
+	hostIDs []uuid.UUID
+	opts    services.CampaignOptions
+}
+
+func (q *stubQueue) QueueQuery(_ context.Context, _ uuid.UUID, _ *int, _, _ *string, _ string, hostIDs []uuid.UUID, opts services.CampaignOptions) (uuid.UUID, error) {
+	if q.err != nil {
+		return uuid.Nil, q.err
+	}
+	if err := opts.Validate(); err != nil {
+		return uuid.Nil, err
+	}
+	q.hostIDs, q.opts = hostIDs, opts
+	return uuid.New(), nil
+}
+
+func (q *stubQueue) QueueGroupQuery(context.Context, uuid.UUID, *int, *string, *string, string, uuid.UUID, services.CampaignOptions) (uuid.UUID, error) {
+	return uuid.Nil, q.err
+}
+
+// stubTokens authenticates tokens to their organization.
+type stubTokens map[string]*orgServices.Organization
+
+func (t stubTokens) Authenticate(_ context.Context, token string) (*orgServices.Organization, error) {
+	if org, ok := t[token]; ok {
+		return org, nil
+	}
+	return nil, orgServices.ErrAPITokenNotFound
+}
+
+// channelPubSub hands out subscribers to one in-memory pub/sub.
+type channelPubSub struct {
+	*gochannel.GoChannel
+}
+
+func (ps channelPubSub) NewSubscriber(context.Context) (message.Subscriber, error) {
+	return sharedSubscriber{ps.GoChannel}, nil
+}
+
+// sharedSubscriber leaves the pub/sub open when closed; its subscriptions
+// end with their contexts.
+type sharedSubscriber struct {
+	*gochannel.GoChannel
+}
+
+func (sharedSubscriber) Close() error {
+	return nil
+}
+
+// dial serves srv over an in-memory listener and returns a client calling
+// it with token.
+func dial(t *testing.T, srv *Server, tokens stubTokens) queryopsv1.QueryOpsServiceClient {
+	t.Helper()
+	lis := bufconn.Listen(1 << 20)
+	gs := NewGRPCServer(srv, tokens, false)
+	go func() {
+		_ = gs.Serve(lis)
+	}()
+	t.Cleanup(gs.Stop)
+
+	conn, err := grpc.NewClient("passthrough:///bufconn",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) { return lis.DialContext(ctx) }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	if err != nil {
+		t.Fatalf("dialing: %v", err)
+	}
+	t.Cleanup(func() { _ = conn.Close() })
+	return queryopsv1.NewQueryOpsServiceClient(conn)
+}
+
+// withToken returns a context sending token with each call.
+func withToken(t *testing.T, token string) context.Context {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	t.Cleanup(cancel)
+	return metadata.AppendToOutgoingContext(ctx, "authorization", "Bearer "+token)
+}
+
+func wantCode(t *testing.T, err error, code codes.Code) {
+	t.Helper()
+	if status.Code(err) != code {
+		t.Fatalf("err = %v, want %s", err, code)
+	}
+}
+
+// fixture is two organizations, each with a token, and a repository with
+// the first's records.
+type fixture struct {
+	repo             *stubRepo
+	tokens           stubTokens
+	online, offline  *services.Host
+	groupID          uuid.UUID
+	campaignID       uuid.UUID
+	token, otherOrgs string
+}
+
+func newFixture() *fixture {
+	orgID := uuid.New()
+	recent := time.Now().Add(-time.Minute)
+	online := &services.Host{ID: uuid.New(), HostIdentifier: "web-1", LastSeenAt: &recent, OSVersion: json.RawMessage(`{"name":"Ubuntu","version":"22.04.4 LTS","platform":"ubuntu"}`)}
+	offline := &services.Host{ID: uuid.New(), HostIdentifier: "web-2"}
+	groupID, campaignID := uuid.New(), uuid.New()
+	return &fixture{
+		repo: &stubRepo{
+			orgID:     orgID,
+			hosts:     []*services.Host{online, offline},
+			groups:    map[uuid.UUID][]uuid.UUID{groupID: {offline.ID}},
+			campaigns: map[uuid.UUID]*services.Campaign{campaignID: {ID: campaignID, Status: "running"}},
+			targets: map[uuid.UUID][]*services.CampaignTarget{campaignID: {
+				{CampaignID: campaignID, HostID: online.ID, Status: "sent"},
+				{CampaignID: campaignID, HostID: offline.ID, Status: "pending"},
+			}},
+		},
+		tokens: stubTokens{
+			"org-token":   {ID: orgID},
+			"other-token": {ID: uuid.New()},
+		},
+		online:     online,
+		offline:    offline,
+		groupID:    groupID,
+		campaignID: campaignID,
+		token:      "org-token",
+		otherOrgs:  "other-token",
+	}
+}
+
+func TestListHosts(t *testing.T) {
+	f := newFixture()
+	client := dial(t, NewServer(f.repo, &stubQueue{}, nil, 10*time.Minute), f.tokens)
+
+	resp, err := client.ListHosts(withToken(t, f.token), &queryopsv1.ListHostsRequest{})
+	if err != nil {
+		t.Fatalf("ListHosts: %v", err)
+	}
+	if len(resp.Hosts) != 2 {
+		t.Fatalf("hosts = %v, want 2", resp.Hosts)
+	}
+	got := resp.Hosts[0]
+	if got.Id != f.online.ID.String() || !got.Online || got.OsVersion != "Ubuntu 22.04.4 LTS" || got.Platform != "linux" || got.LastSeenAt == nil {
+		t.Fatalf("online host = %v", got)
+	}
+	if resp.Hosts[1].Online || resp.Hosts[1].LastSeenAt != nil {
+		t.Fatalf("offline host = %v", resp.Hosts[1])
+	}
+
+	resp, err = client.ListHosts(withToken(t, f.token), &queryopsv1.ListHostsRequest{HostGroupId: f.groupID.String()})
+	if err != nil || len(resp.Hosts) != 1 || resp.Hosts[0].HostIdentifier != "web-2" {
+		t.Fatalf("group's hosts = %v, %v", resp, err)
+	}
+
+	resp, err = client.ListHosts(withToken(t, f.otherOrgs), &queryopsv1.ListHostsRequest{})
+	if err != nil || len(resp.Hosts) != 0 {
+		t.Fatalf("another organization's hosts = %v, %v", resp, err)
+	}
+	_, err = client.ListHosts(withToken(t, f.otherOrgs), &queryopsv1.ListHostsRequest{HostGroupId: f.groupID.String()})
+	wantCode(t, err, codes.NotFound)
+	_, err = client.ListHosts(withToken(t, f.token), &queryopsv1.ListHostsRequest{HostGroupId: "nope"})
+	wantCode(t, err, codes.InvalidArgument)
+}
+
+func TestCreateCampaign(t *testing.T) {
+	f := newFixture()
+	queue := &stubQueue{}
+	client := dial(t, NewServer(f.repo, queue, nil, 10*time.Minute), f.tokens)
+
+	resp, err := client.CreateCampaign(withToken(t, f.token), &queryopsv1.CreateCampaignRequest{
+		Query:                 "SELECT 1;",
+		HostIds:               []string{f.online.ID.String()},
+		FanoutLimit:           10,
+		FanoutIntervalSeconds: 5,
+	})
+	if err != nil {
+		t.Fatalf("CreateCampaign: %v", err)
+	}
+	if _, err := uuid.Parse(resp.CampaignId); err != nil {
+		t.Fatalf("campaign ID %q: %v", resp.CampaignId, err)
+	}
+	if !slices.Equal(queue.hostIDs, []uuid.UUID{f.online.ID}) || queue.opts.FanoutLimit != 10 || queue.opts.FanoutInterval != 5*time.Second {
+		t.Fatalf("queued hosts %v with %+v", queue.hostIDs, queue.opts)
+	}
+
+	tests := []struct {
+		name  string
+		token string
+		req   *queryopsv1.CreateCampaignRequest
+		err   error
+		want  codes.Code
+	}{
+		{"no query", f.token, &queryopsv1.CreateCampaignRequest{HostIds: []string{f.online.ID.String()}}, nil, codes.InvalidArgument},
+		{"no targets", f.token, &queryopsv1.CreateCampaignRequest{Query: "SELECT 1;"}, nil, codes.InvalidArgument},
+		{"hosts and group", f.token, &queryopsv1.CreateCampaignRequest{Query: "SELECT 1;", HostIds: []string{f.online.ID.String()}, HostGroupId: f.groupID.String()}, nil, codes.InvalidArgument},
+		{"bad host ID", f.token, &queryopsv1.CreateCampaignRequest{Query: "SELECT 1;", HostIds: []string{"web-1"}}, nil, codes.InvalidArgument},
+		{"invalid options", f.token, &queryopsv1.CreateCampaignRequest{Query: "SELECT 1;", HostIds: []string{f.online.ID.String()}, MaxRowsPerHost: -1}, nil, codes.InvalidArgument},
+		{"another organization's host", f.otherOrgs, &queryopsv1.CreateCampaignRequest{Query: "SELECT 1;", HostIds: []string{f.online.ID.String()}}, nil, codes.NotFound},
+		{"unknown group", f.token, &queryopsv1.CreateCampaignRequest{Query: "SELECT 1;", HostGroupId: uuid.NewString()}, nil, codes.NotFound},
+		{"empty group", f.token, &queryopsv1.CreateCampaignRequest{Query: "SELECT 1;", HostGroupId: f.groupID.String()}, services.ErrEmptyHostGroup, codes.FailedPrecondition},
+		{"quota", f.token, &queryopsv1.CreateCampaignRequest{Query: "SELECT 1;", HostIds: []string{f.online.ID.String()}}, &orgServices.QuotaExceededError{Quota: orgServices.QuotaCampaignsPerDay, Limit: 10}, codes.ResourceExhausted},
+		{"repository failure", f.token, &queryopsv1.CreateCampaignRequest{Query: "SELECT 1;", HostIds: []string{f.online.ID.String()}}, errors.New("connection reset"), codes.Internal},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			queue.err = tt.err
+			_, err := client.CreateCampaign(withToken(t, tt.token), tt.req)
+			wantCode(t, err, tt.want)
+			if tt.want == codes.Internal && status.Convert(err).Message() != "internal error" {
+				t.Fatalf("internal error message = %q, want it hidden", status.Convert(err).Message())
+			}
+		})
+	}
+}
+
+// recvUpdates reads n updates from stream, keyed by host ID.
+func recvUpdates(t *testing.T, stream grpc.ServerStreamingClient[queryopsv1.CampaignTargetUpdate], n int) map[string]*queryopsv1.CampaignTargetUpdate {
+	t.Helper()
+	updates := map[string]*queryopsv1.CampaignTargetUpdate{}
+	for range n {
+		update, err := stream.Recv()
+		if err != nil {
+			t.Fatalf("Recv: %v", err)
+		}
+		updates[update.HostId] = update
+	}
+	return updates
+}
+
+func TestStreamCampaignResults(t *testing.T) {
+	for _, tt := range []struct {
+		name   string
+		pubsub bool
+	}{
+		{"pubsub", true},
+		{"polling", false},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			f := newFixture()
+			srv := NewServer(f.repo, &stubQueue{}, nil, 10*time.Minute)
+			// A poll interval this long would time the test out, so only
+			// the published event can wake the pub/sub stream.
+			srv.pollInterval = time.Hour
+			var ps *gochannel.GoChannel
+			if tt.pubsub {
+				ps = gochannel.NewGoChannel(gochannel.Config{Persistent: true}, watermill.NopLogger{})
+				t.Cleanup(func() { _ = ps.Close() })
+				srv.pubsub = channelPubSub{ps}
+			} else {
+				srv.pollInterval = 10 * time.Millisecond
+			}
+			client := dial(t, srv, f.tokens)
+
+			stream, err := client.StreamCampaignResults(withToken(t, f.token), &queryopsv1.StreamCampaignResultsRequest{CampaignId: f.campaignID.String()})
+			if err != nil {
+				t.Fatalf("StreamCampaignResults: %v", err)
+			}
+			initial := recvUpdates(t, stream, 2)
+			if initial[f.online.ID.String()].GetStatus() != queryopsv1.TargetStatus_TARGET_STATUS_SENT ||
+				initial[f.offline.ID.String()].GetStatus() != queryopsv1.TargetStatus_TARGET_STATUS_PENDING {
+				t.Fatalf("initial updates = %v", initial)
+			}
+
+			f.repo.complete(f.campaignID, f.online.ID, `[{"pid":"1","name":"init"}]`)
+			if ps != nil {
+				event := pubsub.CampaignResultEvent{CampaignID: f.campaignID, HostID: f.online.ID, Status: "completed", OccurredAt: time.Now()}
+				if err := ps.Publish(pubsub.TopicCampaign(f.campaignID), event.ToMessage()); err != nil {
+					t.Fatalf("publishing: %v", err)
+				}
+			}
+
+			// Only the changed target is sent again, then the stream ends
+			// with its campaign.
+			update := recvUpdates(t, stream, 1)[f.online.ID.String()]
+			if update.GetStatus() != queryopsv1.TargetStatus_TARGET_STATUS_COMPLETED || update.CompletedAt == nil || len(update.Rows) != 1 ||
+				update.Rows[0].GetFields()["name"].GetStringValue() != "init" {
+				t.Fatalf("completed update = %v", update)
+			}
+			if _, err := stream.Recv(); err != io.EOF {
+				t.Fatalf("Recv after completion err = %v, want EOF", err)
+			}
+		})
+	}
+}
+
+func TestStreamCampaignResults_Errors(t *testing.T) {
+	f := newFixture()
+	srv := NewServer(f.repo, &stubQueue{}, nil, 10*time.Minute)
+	client := dial(t, srv, f.tokens)
+
+	recvErr := func(token, campaignID string) error {
+		stream, err := client.StreamCampaignResults(withToken(t, token), &queryopsv1.StreamCampaignResultsRequest{CampaignId: campaignID})
+		if err != nil {
+			return err
+		}
+		_, err = stream.Recv()
+		return err
+	}
+	wantCode(t, recvErr(f.otherOrgs, f.campaignID.String()), codes.NotFound)
+	wantCode(t, recvErr(f.token, "nope"), codes.InvalidArgument)
+
+	// Closing the server ends open streams so it can stop.
+	stream, err := client.StreamCampaignResults(withToken(t, f.token), &queryopsv1.StreamCampaignResultsRequest{CampaignId: f.campaignID.String()})
+	if err != nil {
+		t.Fatalf("StreamCampaignResults: %v", err)
+	}
+	recvUpdates(t, stream, 2)
+	srv.Close()
+	_, err = stream.Recv()
+	wantCode(t, err, codes.Unavailable)
+}
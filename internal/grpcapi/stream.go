@@ -0,0 +1,221 @@
+package grpcapi
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/structpb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	"github.com/cavenine/queryops/features/osquery/services"
+	"github.com/cavenine/queryops/internal/grpcapi/gen/queryopsv1"
+	"github.com/cavenine/queryops/internal/pubsub"
+)
+
+// targetStatuses maps campaign_targets.status to the API's enum.
+var targetStatuses = map[string]queryopsv1.TargetStatus{
+	"pending":   queryopsv1.TargetStatus_TARGET_STATUS_PENDING,
+	"sent":      queryopsv1.TargetStatus_TARGET_STATUS_SENT,
+	"completed": queryopsv1.TargetStatus_TARGET_STATUS_COMPLETED,
+	"failed":    queryopsv1.TargetStatus_TARGET_STATUS_FAILED,
+}
+
+// targetVersion identifies what a stream last sent for a target; a target
+// whose version differs is sent again.
+type targetVersion struct {
+	status    string
+	updatedAt time.Time
+}
+
+// StreamCampaignResults sends every target's current state, then each
+// target that changes, until the campaign finishes or the client cancels.
+// Like the campaign results page, it learns of changes from the campaign's
+// pub/sub topic, and polls without pub/sub.
+func (s *Server) StreamCampaignResults(req *queryopsv1.StreamCampaignResultsRequest, stream grpc.ServerStreamingServer[queryopsv1.CampaignTargetUpdate]) error {
+	ctx := stream.Context()
+	activeOrg, err := activeOrganization(ctx)
+	if err != nil {
+		return err
+	}
+	campaignID, err := parseID("campaign_id", req.GetCampaignId())
+	if err != nil {
+		return err
+	}
+
+	campaign, err := s.repo.GetCampaignByIDAndOrganization(ctx, campaignID, activeOrg.ID)
+	if err != nil {
+		return internalError(ctx, "failed to get campaign", err)
+	}
+	if campaign == nil {
+		return status.Error(codes.NotFound, "campaign not found")
+	}
+
+	// Watch before the first read, so a change between the two isn't
+	// missed.
+	var changes <-chan struct{}
+	if !finished(campaign) {
+		ctx, cancel := context.WithCancel(ctx)
+		defer cancel()
+		changes = s.watchCampaign(ctx, campaignID)
+	}
+
+	sent := map[uuid.UUID]targetVersion{}
+	for {
+		targets, err := s.repo.GetCampaignTargets(ctx, campaignID)
+		if err != nil {
+			return internalError(ctx, "failed to get campaign targets", err)
+		}
+		for _, t := range targets {
+			version := targetVersion{status: t.Status, updatedAt: t.UpdatedAt}
+			if last, ok := sent[t.HostID]; ok && last == version {
+				continue
+			}
+			update, err := targetUpdate(t)
+			if err != nil {
+				return internalError(ctx, "failed to convert campaign target", err)
+			}
+			if err := stream.Send(update); err != nil {
+				return err
+			}
+			sent[t.HostID] = version
+		}
+
+		if finished(campaign) {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return status.FromContextError(ctx.Err()).Err()
+		case <-s.closing:
+			return status.Error(codes.Unavailable, "server shutting down")
+		case <-changes:
+		}
+
+		campaign, err = s.repo.GetCampaignByIDAndOrganization(ctx, campaignID, activeOrg.ID)
+		if err != nil {
+			return internalError(ctx, "failed to get campaign", err)
+		}
+		if campaign == nil {
+			return status.Error(codes.NotFound, "campaign not found")
+		}
+	}
+}
+
+// watchCampaign returns a channel that receives when the campaign's targets
+// may have changed, until ctx is done: on its pub/sub events, or every
+// pollInterval without pub/sub or once the subscription ends. Changes that
+// arrive before the last was received are coalesced.
+func (s *Server) watchCampaign(ctx context.Context, campaignID uuid.UUID) <-chan struct{} {
+	changes := make(chan struct{}, 1)
+	changed := func() {
+		select {
+		case changes <- struct{}{}:
+		default:
+		}
+	}
+
+	go func() {
+		if s.pubsub != nil {
+			if err := s.subscribeCampaign(ctx, campaignID, changed); err != nil {
+				slog.ErrorContext(ctx, "failed to subscribe to campaign; falling back to polling", "error", err, "campaign_id", campaignID)
+			}
+		}
+
+		ticker := time.NewTicker(s.pollInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				changed()
+			}
+		}
+	}()
+	return changes
+}
+
+// subscribeCampaign calls changed for each event on the campaign's topic
+// until ctx is done or the subscription ends.
+func (s *Server) subscribeCampaign(ctx context.Context, campaignID uuid.UUID, changed func()) error {
+	subscriber, err := s.pubsub.NewSubscriber(ctx)
+	if err != nil {
+		return fmt.Errorf("creating subscriber: %w", err)
+	}
+	defer func() {
+		_ = subscriber.Close()
+	}()
+
+	messages, err := subscriber.Subscribe(ctx, pubsub.TopicCampaign(campaignID))
+	if err != nil {
+		return fmt.Errorf("subscribing: %w", err)
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case msg, ok := <-messages:
+			if !ok {
+				return nil
+			}
+			msg.Ack()
+			changed()
+		}
+	}
+}
+
+// finished reports whether a campaign's targets will no longer change.
+func finished(c *services.Campaign) bool {
+	return c.Status == "completed" || c.Status == "failed"
+}
+
+func targetUpdate(t *services.CampaignTarget) (*queryopsv1.CampaignTargetUpdate, error) {
+	update := &queryopsv1.CampaignTargetUpdate{
+		CampaignId: t.CampaignID.String(),
+		HostId:     t.HostID.String(),
+		Status:     targetStatuses[t.Status],
+		Truncated:  t.Truncated,
+	}
+	if t.Error != nil {
+		update.Error = *t.Error
+	}
+	if t.CompletedAt != nil {
+		update.CompletedAt = timestamppb.New(*t.CompletedAt)
+	}
+
+	if len(t.Results) > 0 {
+		var rows []map[string]any
+		if err := json.Unmarshal(t.Results, &rows); err != nil {
+			return nil, fmt.Errorf("decoding results of host %s: %w", t.HostID, err)
+		}
+		for _, row := range rows {
+			s, err := structpb.NewStruct(row)
+			if err != nil {
+				return nil, fmt.Errorf("converting results of host %s: %w", t.HostID, err)
+			}
+			update.Rows = append(update.Rows, s)
+		}
+	}
+	return update, nil
+}
+
+// osVersion names the host's OS as its os_version reports it, such as
+// "Ubuntu 22.04.4 LTS", or "" if it hasn't.
+func osVersion(h *services.Host) string {
+	var v struct {
+		Name    string `json:"name"`
+		Version string `json:"version"`
+	}
+	if err := json.Unmarshal(h.OSVersion, &v); err != nil {
+		return ""
+	}
+	return strings.TrimSpace(v.Name + " " + v.Version)
+}
@@ -0,0 +1,77 @@
+// Package httpbody bounds request bodies and decodes JSON ones. Limit caps a
+// route class's bodies; DecodeJSON and DecodeStrictJSON read them; Error
+// answers a failed read with 413 if the body was too large and 400 otherwise.
+package httpbody
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+)
+
+// Limit rejects requests whose declared Content-Length exceeds maxBytes with
+// 413, and caps the rest so reading past maxBytes fails with an
+// *http.MaxBytesError. A non-positive maxBytes disables the limit.
+//
+// Nested limits don't raise an outer one, so install the largest limit a
+// route needs at that route rather than on a parent router.
+func Limit(maxBytes int64) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		if maxBytes <= 0 {
+			return next
+		}
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.ContentLength > maxBytes {
+				http.Error(w, http.StatusText(http.StatusRequestEntityTooLarge), http.StatusRequestEntityTooLarge)
+				return
+			}
+			r.Body = http.MaxBytesReader(w, r.Body, maxBytes)
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// DecodeJSON decodes a single JSON value from the request body into v,
+// ignoring unknown fields. Use it for payloads defined elsewhere, such as
+// osquery's, that may grow fields this server doesn't know.
+func DecodeJSON(r *http.Request, v any) error {
+	return decode(json.NewDecoder(r.Body), v)
+}
+
+// DecodeStrictJSON is DecodeJSON for this server's own APIs: unknown fields
+// are an error, so a misspelled field isn't silently ignored.
+func DecodeStrictJSON(r *http.Request, v any) error {
+	dec := json.NewDecoder(r.Body)
+	dec.DisallowUnknownFields()
+	return decode(dec, v)
+}
+
+func decode(dec *json.Decoder, v any) error {
+	if err := dec.Decode(v); err != nil {
+		return err
+	}
+	if _, err := dec.Token(); !errors.Is(err, io.EOF) {
+		if err != nil {
+			return err
+		}
+		return errors.New("unexpected data after JSON value")
+	}
+	return nil
+}
+
+// TooLarge reports whether err came from reading past a Limit.
+func TooLarge(err error) bool {
+	var maxErr *http.MaxBytesError
+	return errors.As(err, &maxErr)
+}
+
+// Error writes 413 if err came from reading past a Limit and 400 otherwise.
+func Error(w http.ResponseWriter, err error) {
+	if TooLarge(err) {
+		http.Error(w, http.StatusText(http.StatusRequestEntityTooLarge), http.StatusRequestEntityTooLarge)
+		return
+	}
+	http.Error(w, fmt.Sprintf("invalid request: %v", err), http.StatusBadRequest)
+}
@@ -0,0 +1,67 @@
+package httpbody
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+type payload struct {
+	Name string `json:"name"`
+}
+
+func serve(t *testing.T, limit int64, strict bool, body string, chunked bool) *httptest.ResponseRecorder {
+	t.Helper()
+
+	h := Limit(limit)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var p payload
+		decode := DecodeJSON
+		if strict {
+			decode = DecodeStrictJSON
+		}
+		if err := decode(r, &p); err != nil {
+			Error(w, err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+
+	req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(body))
+	if chunked {
+		req.ContentLength = -1
+	}
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestLimitAndDecode(t *testing.T) {
+	big := `{"name":"` + strings.Repeat("a", 100) + `"}`
+
+	tests := []struct {
+		name    string
+		limit   int64
+		strict  bool
+		body    string
+		chunked bool
+		want    int
+	}{
+		{"ok", 64, false, `{"name":"a"}`, false, http.StatusNoContent},
+		{"declared too large", 64, false, big, false, http.StatusRequestEntityTooLarge},
+		{"streamed too large", 64, false, big, true, http.StatusRequestEntityTooLarge},
+		{"no limit", 0, false, big, false, http.StatusNoContent},
+		{"malformed", 64, false, `{"name":`, false, http.StatusBadRequest},
+		{"trailing data", 64, false, `{"name":"a"} {}`, false, http.StatusBadRequest},
+		{"unknown field allowed", 64, false, `{"name":"a","extra":1}`, false, http.StatusNoContent},
+		{"unknown field strict", 64, true, `{"name":"a","extra":1}`, false, http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := serve(t, tt.limit, tt.strict, tt.body, tt.chunked)
+			if rec.Code != tt.want {
+				t.Fatalf("status = %d, want %d (body %q)", rec.Code, tt.want, rec.Body.String())
+			}
+		})
+	}
+}
@@ -0,0 +1,134 @@
+// Package lifecycle starts a server's components in order and stops them in
+// reverse, so each one shuts down while the components it depends on are
+// still running: the HTTP server finishes its requests before the log
+// ingester drains, which drains before the workers stop, which stop before
+// pub/sub closes. Streams ends the long-lived responses that would
+// otherwise hold the HTTP server's shutdown open.
+package lifecycle
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// ErrStopTimeout is returned by Run when components are still running once
+// the shutdown timeout has passed.
+var ErrStopTimeout = errors.New("shutdown timed out")
+
+// Hook is one component of a Manager.
+type Hook struct {
+	Name string
+
+	// Start, if set, starts the component and returns once it is running.
+	// Work that runs until the component stops belongs in Manager.Go.
+	Start func(ctx context.Context) error
+
+	// Stop, if set, stops the component, returning once it has stopped or
+	// ctx is done.
+	Stop func(ctx context.Context) error
+}
+
+// Manager runs hooks. Add them in start order with Append.
+type Manager struct {
+	// ShutdownTimeout bounds stopping all the hooks together. Keep it under
+	// the grace period the process gets between SIGTERM and SIGKILL.
+	ShutdownTimeout time.Duration
+
+	hooks  []Hook
+	wg     sync.WaitGroup
+	failed chan error
+}
+
+// New returns a manager that allows shutdownTimeout for stopping.
+func New(shutdownTimeout time.Duration) *Manager {
+	return &Manager{
+		ShutdownTimeout: shutdownTimeout,
+		failed:          make(chan error, 1),
+	}
+}
+
+// Append adds h after the hooks already added: it starts after them and
+// stops before them.
+func (m *Manager) Append(h Hook) {
+	m.hooks = append(m.hooks, h)
+}
+
+// Go runs fn in the background until a hook's Stop makes it return. Run
+// waits for it before returning, and an error from it shuts the manager
+// down.
+func (m *Manager) Go(fn func() error) {
+	m.wg.Add(1)
+	go func() {
+		defer m.wg.Done()
+		if err := fn(); err != nil {
+			select {
+			case m.failed <- err:
+			default:
+			}
+		}
+	}()
+}
+
+// Run starts the hooks in order and waits until ctx is done or a function
+// started with Go fails. It then stops the hooks that started, in reverse
+// order, and waits for the functions started with Go to return. It returns
+// the error that caused the shutdown, if any, joined with those from
+// stopping.
+func (m *Manager) Run(ctx context.Context) error {
+	var cause error
+	started := 0
+	for _, h := range m.hooks {
+		if h.Start != nil {
+			if err := h.Start(ctx); err != nil {
+				cause = fmt.Errorf("starting %s: %w", h.Name, err)
+				break
+			}
+		}
+		started++
+	}
+
+	if cause == nil {
+		select {
+		case <-ctx.Done():
+		case cause = <-m.failed:
+		}
+	}
+
+	stopCtx, cancel := context.WithTimeout(context.Background(), m.ShutdownTimeout)
+	defer cancel()
+
+	errs := []error{cause}
+	for i := started - 1; i >= 0; i-- {
+		h := m.hooks[i]
+		if h.Stop == nil {
+			continue
+		}
+		slog.DebugContext(stopCtx, "stopping", "component", h.Name)
+		if err := h.Stop(stopCtx); err != nil {
+			errs = append(errs, fmt.Errorf("stopping %s: %w", h.Name, err))
+		}
+	}
+
+	done := make(chan struct{})
+	go func() {
+		m.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-stopCtx.Done():
+		errs = append(errs, ErrStopTimeout)
+	}
+
+	// A function may fail while stopping, after the shutdown began.
+	select {
+	case err := <-m.failed:
+		errs = append(errs, err)
+	default:
+	}
+	return errors.Join(errs...)
+}
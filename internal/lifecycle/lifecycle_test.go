@@ -0,0 +1,99 @@
+package lifecycle
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestManager_StopsInReverse(t *testing.T) {
+	var calls []string
+	hook := func(name string) Hook {
+		return Hook{
+			Name:  name,
+			Start: func(context.Context) error { calls = append(calls, "start "+name); return nil },
+			Stop:  func(context.Context) error { calls = append(calls, "stop "+name); return nil },
+		}
+	}
+
+	m := New(time.Second)
+	m.Append(hook("pubsub"))
+	m.Append(Hook{Name: "no hooks"})
+	m.Append(hook("server"))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := m.Run(ctx); err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+	want := []string{"start pubsub", "start server", "stop server", "stop pubsub"}
+	if !slices.Equal(calls, want) {
+		t.Fatalf("calls = %v, want %v", calls, want)
+	}
+}
+
+func TestManager_StartFailure(t *testing.T) {
+	var stopped []string
+	m := New(time.Second)
+	m.Append(Hook{Name: "a", Stop: func(context.Context) error { stopped = append(stopped, "a"); return nil }})
+	m.Append(Hook{Name: "b", Start: func(context.Context) error { return errors.New("port in use") }})
+	m.Append(Hook{Name: "c", Stop: func(context.Context) error { stopped = append(stopped, "c"); return nil }})
+
+	err := m.Run(context.Background())
+	if err == nil || err.Error() != "starting b: port in use" {
+		t.Fatalf("Run err = %v", err)
+	}
+	if !slices.Equal(stopped, []string{"a"}) {
+		t.Fatalf("stopped = %v, want only the hook that started", stopped)
+	}
+}
+
+func TestManager_GoFailureShutsDown(t *testing.T) {
+	m := New(time.Second)
+	serving := make(chan struct{})
+	m.Append(Hook{
+		Name: "server",
+		Start: func(context.Context) error {
+			m.Go(func() error {
+				<-serving
+				return nil
+			})
+			m.Go(func() error { return errors.New("listener closed") })
+			return nil
+		},
+		Stop: func(context.Context) error {
+			close(serving)
+			return nil
+		},
+	})
+
+	done := make(chan error, 1)
+	go func() { done <- m.Run(context.Background()) }()
+	select {
+	case err := <-done:
+		if err == nil || err.Error() != "listener closed" {
+			t.Fatalf("Run err = %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Run did not return after a background failure")
+	}
+}
+
+func TestManager_ShutdownTimeout(t *testing.T) {
+	m := New(50 * time.Millisecond)
+	m.Append(Hook{Name: "stuck", Start: func(context.Context) error {
+		m.Go(func() error {
+			time.Sleep(time.Second)
+			return nil
+		})
+		return nil
+	}})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := m.Run(ctx); !errors.Is(err, ErrStopTimeout) {
+		t.Fatalf("Run err = %v, want ErrStopTimeout", err)
+	}
+}
@@ -0,0 +1,86 @@
+package lifecycle
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/cavenine/queryops/internal/wsstream"
+)
+
+// Streams tracks event streams and WebSockets, which http.Server.Shutdown
+// would wait on forever: a stream only ends when its client goes away.
+// Drain ends them so the server can shut down once its other requests have
+// finished, and the clients reconnect to another replica or the restarted
+// server.
+type Streams struct {
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	mu       sync.Mutex
+	draining bool
+	active   sync.WaitGroup
+}
+
+// NewStreams returns a Streams with no streams open.
+func NewStreams() *Streams {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &Streams{ctx: ctx, cancel: cancel}
+}
+
+// IsStream reports whether r opens a stream: a GET for text/event-stream or
+// a WebSocket upgrade.
+func IsStream(r *http.Request) bool {
+	return r.Method == http.MethodGet &&
+		(strings.Contains(r.Header.Get("Accept"), "text/event-stream") || wsstream.IsUpgrade(r))
+}
+
+// Middleware cancels the context of each stream when draining starts and
+// refuses new streams with 503 once it has. Other requests pass through.
+func (s *Streams) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !IsStream(r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		s.mu.Lock()
+		if s.draining {
+			s.mu.Unlock()
+			w.Header().Set("Connection", "close")
+			http.Error(w, "server is shutting down", http.StatusServiceUnavailable)
+			return
+		}
+		s.active.Add(1)
+		s.mu.Unlock()
+		defer s.active.Done()
+
+		ctx, cancel := context.WithCancel(r.Context())
+		defer cancel()
+		stop := context.AfterFunc(s.ctx, cancel)
+		defer stop()
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// Drain ends open streams and waits for their handlers to return, or for
+// ctx to be done.
+func (s *Streams) Drain(ctx context.Context) error {
+	s.mu.Lock()
+	s.draining = true
+	s.mu.Unlock()
+	s.cancel()
+
+	done := make(chan struct{})
+	go func() {
+		s.active.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,75 @@
+package lifecycle
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestStreams_Drain(t *testing.T) {
+	s := NewStreams()
+	open := make(chan struct{})
+	ended := make(chan struct{})
+	h := s.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !IsStream(r) {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		close(open)
+		<-r.Context().Done()
+		close(ended)
+	}))
+
+	stream := httptest.NewRequest(http.MethodGet, "/hosts/sse", nil)
+	stream.Header.Set("Accept", "text/event-stream, text/html, application/json")
+	go h.ServeHTTP(httptest.NewRecorder(), stream)
+	<-open
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := s.Drain(ctx); err != nil {
+		t.Fatalf("Drain: %v", err)
+	}
+	select {
+	case <-ended:
+	default:
+		t.Fatalf("Drain returned before the stream ended")
+	}
+
+	// New streams are refused; other requests still work.
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, stream)
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Fatalf("stream after drain status = %d, want 503", rec.Code)
+	}
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/api/v1/osquery/distributed_write", nil))
+	if rec.Code != http.StatusNoContent {
+		t.Fatalf("request after drain status = %d, want 204", rec.Code)
+	}
+}
+
+func TestStreams_DrainTimeout(t *testing.T) {
+	s := NewStreams()
+	open := make(chan struct{})
+	release := make(chan struct{})
+	defer close(release)
+	h := s.Middleware(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {
+		close(open)
+		<-release // ignores its context
+	}))
+
+	stream := httptest.NewRequest(http.MethodGet, "/ws", nil)
+	stream.Header.Set("Connection", "Upgrade")
+	stream.Header.Set("Upgrade", "websocket")
+	go h.ServeHTTP(httptest.NewRecorder(), stream)
+	<-open
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := s.Drain(ctx); err == nil {
+		t.Fatalf("Drain returned nil with a stream still open")
+	}
+}
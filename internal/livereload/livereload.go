@@ -0,0 +1,142 @@
+// Package livereload is the dev-mode channel between the asset build and open
+// pages. The build runs a Broker and publishes an event whenever its output
+// changes; the web server proxies /livereload to it, and the LiveReload
+// script in the layouts reacts.
+package livereload
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"os"
+	"sync"
+	"time"
+)
+
+// Events sent to pages.
+const (
+	// EventReload asks pages to reload, e.g. after scripts were rebuilt.
+	EventReload = "reload"
+	// EventCSS asks pages to re-fetch their stylesheets without reloading.
+	EventCSS = "css"
+)
+
+// Path is where the web server exposes the broker to pages.
+const Path = "/livereload"
+
+// retry is how long pages wait before reconnecting, so they notice a
+// restarted server quickly.
+const retry = 250 * time.Millisecond
+
+// Broker fans events out to every connected page as server-sent events.
+type Broker struct {
+	mu      sync.Mutex
+	clients map[chan string]struct{}
+}
+
+func NewBroker() *Broker {
+	return &Broker{clients: map[chan string]struct{}{}}
+}
+
+// Publish sends event to every connected page. Pages that are not keeping up
+// miss it.
+func (b *Broker) Publish(event string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for ch := range b.clients {
+		select {
+		case ch <- event:
+		default:
+		}
+	}
+}
+
+func (b *Broker) subscribe() chan string {
+	ch := make(chan string, 1)
+	b.mu.Lock()
+	b.clients[ch] = struct{}{}
+	b.mu.Unlock()
+	return ch
+}
+
+func (b *Broker) unsubscribe(ch chan string) {
+	b.mu.Lock()
+	delete(b.clients, ch)
+	b.mu.Unlock()
+}
+
+func (b *Broker) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+
+	ch := b.subscribe()
+	defer b.unsubscribe(ch)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	if _, err := fmt.Fprintf(w, "retry: %d\n\n", retry.Milliseconds()); err != nil {
+		return
+	}
+	flusher.Flush()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case event := <-ch:
+			if _, err := fmt.Fprintf(w, "event: %s\ndata: {}\n\n", event); err != nil {
+				return
+			}
+			flusher.Flush()
+		}
+	}
+}
+
+// Proxy forwards the page side of the channel to the broker listening on
+// addr.
+func Proxy(addr string) http.Handler {
+	target := &url.URL{Scheme: "http", Host: addr}
+	proxy := httputil.NewSingleHostReverseProxy(target)
+	proxy.ErrorHandler = func(w http.ResponseWriter, r *http.Request, err error) {
+		slog.DebugContext(r.Context(), "live reload unavailable; is the asset build watching?", "error", err)
+		w.WriteHeader(http.StatusBadGateway)
+	}
+	return proxy
+}
+
+// WatchFile calls fn whenever the modification time of path changes, until
+// ctx is done.
+func WatchFile(ctx context.Context, path string, interval time.Duration, fn func()) {
+	modTime := func() time.Time {
+		info, err := os.Stat(path)
+		if err != nil {
+			return time.Time{}
+		}
+		return info.ModTime()
+	}
+
+	last := modTime()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if m := modTime(); !m.Equal(last) {
+				last = m
+				if !m.IsZero() {
+					fn()
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,89 @@
+package livereload
+
+import (
+	"bufio"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestBrokerStreamsEvents(t *testing.T) {
+	broker := NewBroker()
+	srv := httptest.NewServer(broker)
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL)
+	if err != nil {
+		t.Fatalf("GET: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if got := resp.Header.Get("Content-Type"); got != "text/event-stream" {
+		t.Fatalf("Content-Type = %q", got)
+	}
+
+	r := bufio.NewReader(resp.Body)
+	line, err := r.ReadString('\n')
+	if err != nil {
+		t.Fatalf("reading retry: %v", err)
+	}
+	if !strings.HasPrefix(line, "retry: ") {
+		t.Fatalf("first line = %q, want retry", line)
+	}
+
+	// The stream is subscribed once the retry line has been written.
+	broker.Publish(EventCSS)
+
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			t.Fatalf("reading event: %v", err)
+		}
+		if strings.HasPrefix(line, "event: ") {
+			if got := strings.TrimSpace(strings.TrimPrefix(line, "event: ")); got != EventCSS {
+				t.Fatalf("event = %q, want %q", got, EventCSS)
+			}
+			return
+		}
+	}
+}
+
+func TestBrokerPublishWithoutClients(t *testing.T) {
+	// Must not block.
+	NewBroker().Publish(EventReload)
+}
+
+func TestWatchFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "index.css")
+	if err := os.WriteFile(path, []byte("a{}"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	changed := make(chan struct{}, 1)
+	go WatchFile(ctx, path, 10*time.Millisecond, func() {
+		select {
+		case changed <- struct{}{}:
+		default:
+		}
+	})
+
+	time.Sleep(50 * time.Millisecond)
+	later := time.Now().Add(time.Second)
+	if err := os.Chtimes(path, later, later); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case <-changed:
+	case <-time.After(2 * time.Second):
+		t.Fatal("change was not noticed")
+	}
+}
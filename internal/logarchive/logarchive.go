@@ -0,0 +1,372 @@
+// Package logarchive archives raw osquery /logger requests to object storage
+// and reads them back for replay.
+//
+// Requests are stored as gzipped NDJSON, one Record per line, in objects
+// partitioned by organization and the UTC hour they were received:
+//
+//	<prefix>org=<organization id>/date=2026-01-02/hour=15/<unix nanos>-<random>.ndjson.gz
+//
+// so a range can be listed without reading objects outside it, and so the
+// layout can be queried in place by tools that understand Hive-style
+// partitions.
+package logarchive
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"expvar"
+	"fmt"
+	"io"
+	"log/slog"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/cavenine/queryops/internal/objectstore"
+)
+
+const (
+	defaultQueueSize   = 1024
+	defaultObjectBytes = 16 << 20
+	defaultFlush       = 5 * time.Minute
+	uploadTimeout      = 2 * time.Minute
+	// uploadAttempts and uploadBackoff bound how long a failing upload holds
+	// up the archiver before its records are dropped.
+	uploadAttempts = 4
+	uploadBackoff  = time.Second
+)
+
+// metrics exposes archiving counters under /debug/vars:
+//
+//	enqueued  requests accepted for archiving
+//	overflow  requests not archived because the queue was full
+//	archived  requests uploaded
+//	uploaded  objects uploaded
+//	retried   failed uploads that were retried
+//	failed    requests dropped after every upload attempt failed
+var metrics = expvar.NewMap("osquery_log_archive")
+
+// Record is one archived /logger request: the log lines exactly as osquery
+// sent them, before redaction, and the host that sent them. The node key is
+// left out.
+type Record struct {
+	ReceivedAt     time.Time         `json:"received_at"`
+	HostID         uuid.UUID         `json:"host_id"`
+	HostIdentifier string            `json:"host_identifier"`
+	LogType        string            `json:"log_type"`
+	Data           []json.RawMessage `json:"data"`
+}
+
+// Uploader stores archive objects.
+type Uploader interface {
+	Put(ctx context.Context, key string, body []byte, contentType string) error
+}
+
+// Source lists and reads archive objects.
+type Source interface {
+	List(ctx context.Context, prefix, delimiter string) (*objectstore.Listing, error)
+	Get(ctx context.Context, key string) ([]byte, error)
+}
+
+// partitionPrefix is the key prefix of an organization's objects for the
+// hour starting at hour.
+func partitionPrefix(prefix string, org uuid.UUID, hour time.Time) string {
+	return datePrefix(prefix, org, hour) + "hour=" + hour.Format("15") + "/"
+}
+
+func datePrefix(prefix string, org uuid.UUID, day time.Time) string {
+	return prefix + "org=" + org.String() + "/date=" + day.Format("2006-01-02") + "/"
+}
+
+type entry struct {
+	org    uuid.UUID
+	record Record
+}
+
+type partitionKey struct {
+	org  uuid.UUID
+	hour time.Time
+}
+
+// partition collects one object's records.
+type partition struct {
+	buf     bytes.Buffer
+	gz      *gzip.Writer
+	size    int
+	records int
+}
+
+// Archiver uploads /logger requests in the background. Requests are queued,
+// collected per partition, and uploaded when a partition reaches its size
+// limit or the flush interval elapses.
+//
+// Archiving is best effort: a request that finds the queue full isn't
+// archived, an upload that keeps failing is retried with backoff and then
+// dropped, and a crash loses whatever hadn't been uploaded. All are counted.
+type Archiver struct {
+	store       Uploader
+	prefix      string
+	queue       chan entry
+	objectBytes int
+	flush       time.Duration
+	backoff     time.Duration
+
+	// mu guards closed against enqueues racing Close.
+	mu     sync.RWMutex
+	closed bool
+}
+
+// NewArchiver creates an archiver that uploads to store under prefix.
+// Non-positive sizes and intervals use defaults.
+func NewArchiver(store Uploader, prefix string, queueSize, objectBytes int, flush time.Duration) *Archiver {
+	if queueSize <= 0 {
+		queueSize = defaultQueueSize
+	}
+	if objectBytes <= 0 {
+		objectBytes = defaultObjectBytes
+	}
+	if flush <= 0 {
+		flush = defaultFlush
+	}
+	return &Archiver{
+		store:       store,
+		prefix:      prefix,
+		queue:       make(chan entry, queueSize),
+		objectBytes: objectBytes,
+		flush:       flush,
+		backoff:     uploadBackoff,
+	}
+}
+
+// Enqueue queues r, received for org, without blocking. It reports false
+// when the queue is full or the archiver is closed.
+func (a *Archiver) Enqueue(org uuid.UUID, r Record) bool {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	if a.closed {
+		metrics.Add("overflow", 1)
+		return false
+	}
+	select {
+	case a.queue <- entry{org: org, record: r}:
+		metrics.Add("enqueued", 1)
+		return true
+	default:
+		metrics.Add("overflow", 1)
+		return false
+	}
+}
+
+// Close stops accepting requests. Run returns once the requests already
+// queued are uploaded.
+func (a *Archiver) Close() {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if !a.closed {
+		a.closed = true
+		close(a.queue)
+	}
+}
+
+// Run uploads queued requests until Close is called and the queue is
+// drained.
+func (a *Archiver) Run() {
+	ticker := time.NewTicker(a.flush)
+	defer ticker.Stop()
+
+	partitions := make(map[partitionKey]*partition)
+	flushAll := func() {
+		for key, p := range partitions {
+			a.upload(key, p)
+		}
+		clear(partitions)
+	}
+	for {
+		select {
+		case e, ok := <-a.queue:
+			if !ok {
+				flushAll()
+				return
+			}
+			key := partitionKey{org: e.org, hour: e.record.ReceivedAt.UTC().Truncate(time.Hour)}
+			p := partitions[key]
+			if p == nil {
+				p = &partition{}
+				p.gz = gzip.NewWriter(&p.buf)
+				partitions[key] = p
+			}
+			if err := a.add(p, e.record); err != nil {
+				slog.Error("failed to encode archived log request", "error", err, "host_id", e.record.HostID)
+				continue
+			}
+			if p.size >= a.objectBytes {
+				a.upload(key, p)
+				delete(partitions, key)
+			}
+		case <-ticker.C:
+			flushAll()
+		}
+	}
+}
+
+func (a *Archiver) add(p *partition, r Record) error {
+	line, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	line = append(line, '\n')
+	if _, err := p.gz.Write(line); err != nil {
+		return err
+	}
+	p.size += len(line)
+	p.records++
+	return nil
+}
+
+// upload stores p, retrying with exponential backoff. Its records are
+// dropped only once every attempt has failed.
+func (a *Archiver) upload(key partitionKey, p *partition) {
+	if p.records == 0 {
+		return
+	}
+	if err := p.gz.Close(); err != nil {
+		metrics.Add("failed", int64(p.records))
+		slog.Error("dropping archived log requests; compressing failed", "error", err, "records", p.records)
+		return
+	}
+	objectKey := partitionPrefix(a.prefix, key.org, key.hour) + objectName()
+
+	backoff := a.backoff
+	var err error
+	for attempt := 1; attempt <= uploadAttempts; attempt++ {
+		// Use a fresh context so the final flush still runs during shutdown.
+		ctx, cancel := context.WithTimeout(context.Background(), uploadTimeout)
+		err = a.store.Put(ctx, objectKey, p.buf.Bytes(), "application/gzip")
+		cancel()
+		if err == nil {
+			metrics.Add("uploaded", 1)
+			metrics.Add("archived", int64(p.records))
+			return
+		}
+		if attempt < uploadAttempts {
+			metrics.Add("retried", 1)
+			slog.Warn("failed to upload log archive; retrying", "error", err, "key", objectKey, "attempt", attempt, "backoff", backoff)
+			time.Sleep(backoff)
+			backoff *= 2
+		}
+	}
+	metrics.Add("failed", int64(p.records))
+	slog.Error("dropping log archive after repeated upload failures", "error", err, "key", objectKey, "records", p.records)
+}
+
+// objectName is unique across instances uploading to the same partition,
+// and sorts in upload order.
+func objectName() string {
+	var b [4]byte
+	_, _ = rand.Read(b[:])
+	return fmt.Sprintf("%019d-%s.ndjson.gz", time.Now().UnixNano(), hex.EncodeToString(b[:]))
+}
+
+// Replay calls fn with every record archived in the hours from the one
+// containing from up to to, exclusive, one organization at a time and in
+// the order received within each. A nil org replays every organization. It
+// returns how many objects were read, and stops at the first error fn
+// returns.
+func Replay(ctx context.Context, src Source, prefix string, org *uuid.UUID, from, to time.Time, fn func(org uuid.UUID, r Record) error) (int, error) {
+	from = from.UTC().Truncate(time.Hour)
+	to = to.UTC()
+	if !from.Before(to) {
+		return 0, errors.New("replay range is empty")
+	}
+
+	var orgs []uuid.UUID
+	if org != nil {
+		orgs = []uuid.UUID{*org}
+	} else {
+		listing, err := src.List(ctx, prefix+"org=", "/")
+		if err != nil {
+			return 0, fmt.Errorf("listing archived organizations: %w", err)
+		}
+		for _, p := range listing.Prefixes {
+			id, err := uuid.Parse(strings.TrimSuffix(strings.TrimPrefix(p, prefix+"org="), "/"))
+			if err != nil {
+				continue
+			}
+			orgs = append(orgs, id)
+		}
+	}
+
+	objects := 0
+	for _, orgID := range orgs {
+		for day := from.Truncate(24 * time.Hour); day.Before(to); day = day.Add(24 * time.Hour) {
+			dayPrefix := datePrefix(prefix, orgID, day)
+			listing, err := src.List(ctx, dayPrefix, "")
+			if err != nil {
+				return objects, fmt.Errorf("listing archived logs: %w", err)
+			}
+			for _, key := range listing.Keys {
+				hour, ok := objectHour(day, strings.TrimPrefix(key, dayPrefix))
+				if !ok || hour.Before(from) || !hour.Before(to) {
+					continue
+				}
+				if err := replayObject(ctx, src, key, orgID, fn); err != nil {
+					return objects, err
+				}
+				objects++
+			}
+		}
+	}
+	return objects, nil
+}
+
+// objectHour parses the hour of an object from its key relative to its date
+// prefix, "hour=15/<name>".
+func objectHour(day time.Time, rel string) (time.Time, bool) {
+	dir, _, ok := strings.Cut(rel, "/")
+	if !ok {
+		return time.Time{}, false
+	}
+	h, ok := strings.CutPrefix(dir, "hour=")
+	if !ok {
+		return time.Time{}, false
+	}
+	n, err := strconv.Atoi(h)
+	if err != nil || n < 0 || n > 23 {
+		return time.Time{}, false
+	}
+	return day.Add(time.Duration(n) * time.Hour), true
+}
+
+func replayObject(ctx context.Context, src Source, key string, org uuid.UUID, fn func(uuid.UUID, Record) error) error {
+	body, err := src.Get(ctx, key)
+	if err != nil {
+		return fmt.Errorf("reading archived logs: %w", err)
+	}
+	gz, err := gzip.NewReader(bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("reading %s: %w", key, err)
+	}
+	defer gz.Close()
+
+	dec := json.NewDecoder(gz)
+	for {
+		var r Record
+		if err := dec.Decode(&r); err != nil {
+			if errors.Is(err, io.EOF) {
+				return nil
+			}
+			return fmt.Errorf("reading %s: %w", key, err)
+		}
+		if err := fn(org, r); err != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,179 @@
+package logarchive
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/cavenine/queryops/internal/objectstore"
+)
+
+// memStore is an in-memory bucket.
+type memStore struct {
+	mu      sync.Mutex
+	objects map[string][]byte
+	fail    int // Puts left to fail
+}
+
+func (s *memStore) Put(_ context.Context, key string, body []byte, _ string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.fail > 0 {
+		s.fail--
+		return errors.New("unavailable")
+	}
+	if s.objects == nil {
+		s.objects = make(map[string][]byte)
+	}
+	s.objects[key] = append([]byte(nil), body...)
+	return nil
+}
+
+func (s *memStore) Get(_ context.Context, key string) ([]byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	body, ok := s.objects[key]
+	if !ok {
+		return nil, objectstore.ErrNotFound
+	}
+	return body, nil
+}
+
+func (s *memStore) List(_ context.Context, prefix, delimiter string) (*objectstore.Listing, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var listing objectstore.Listing
+	seen := map[string]bool{}
+	for key := range s.objects {
+		rest, ok := strings.CutPrefix(key, prefix)
+		if !ok {
+			continue
+		}
+		if i := strings.Index(rest, delimiter); delimiter != "" && i >= 0 {
+			p := prefix + rest[:i+len(delimiter)]
+			if !seen[p] {
+				seen[p] = true
+				listing.Prefixes = append(listing.Prefixes, p)
+			}
+			continue
+		}
+		listing.Keys = append(listing.Keys, key)
+	}
+	sort.Strings(listing.Keys)
+	sort.Strings(listing.Prefixes)
+	return &listing, nil
+}
+
+func (s *memStore) keys() []string {
+	listing, _ := s.List(context.Background(), "", "")
+	return listing.Keys
+}
+
+func record(at time.Time, host uuid.UUID, line string) Record {
+	return Record{ReceivedAt: at, HostID: host, HostIdentifier: "web-1", LogType: "result", Data: []json.RawMessage{json.RawMessage(line)}}
+}
+
+func TestArchiveAndReplay(t *testing.T) {
+	store := &memStore{fail: 1}
+	a := NewArchiver(store, "logs/", 10, 0, time.Hour)
+	a.backoff = time.Millisecond
+	done := make(chan struct{})
+	go func() {
+		a.Run()
+		close(done)
+	}()
+
+	orgA, orgB := uuid.New(), uuid.New()
+	host := uuid.New()
+	base := time.Date(2026, 1, 2, 22, 30, 0, 0, time.UTC)
+	records := []struct {
+		org uuid.UUID
+		r   Record
+	}{
+		{orgA, record(base, host, `{"n":1}`)},
+		{orgA, record(base.Add(10*time.Minute), host, `{"n":2}`)},
+		{orgA, record(base.Add(2*time.Hour), host, `{"n":3}`)}, // 2026-01-03 00:30
+		{orgB, record(base, host, `{"n":4}`)},
+	}
+	for _, e := range records {
+		if !a.Enqueue(e.org, e.r) {
+			t.Fatal("Enqueue refused a record")
+		}
+	}
+	a.Close()
+	<-done
+	if a.Enqueue(orgA, records[0].r) {
+		t.Fatal("Enqueue accepted a record after Close")
+	}
+
+	keys := store.keys()
+	if len(keys) != 3 {
+		t.Fatalf("uploaded %d objects, want 3: %v", len(keys), keys)
+	}
+	wantPrefixes := map[string]bool{
+		"logs/org=" + orgA.String() + "/date=2026-01-02/hour=22/": true,
+		"logs/org=" + orgA.String() + "/date=2026-01-03/hour=00/": true,
+		"logs/org=" + orgB.String() + "/date=2026-01-02/hour=22/": true,
+	}
+	for _, key := range keys {
+		i := strings.LastIndex(key, "/")
+		if !wantPrefixes[key[:i+1]] || !strings.HasSuffix(key, ".ndjson.gz") {
+			t.Errorf("unexpected object key %s", key)
+		}
+	}
+
+	replay := func(org *uuid.UUID, from, to time.Time) []string {
+		t.Helper()
+		var got []string
+		_, err := Replay(context.Background(), store, "logs/", org, from, to, func(org uuid.UUID, r Record) error {
+			if r.HostID != host || r.LogType != "result" {
+				t.Errorf("replayed record = %+v", r)
+			}
+			got = append(got, org.String()[:4]+string(r.Data[0]))
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("Replay: %v", err)
+		}
+		sort.Strings(got)
+		return got
+	}
+
+	a4 := orgA.String()[:4]
+	if got, want := strings.Join(replay(&orgA, base, base.Add(3*time.Hour)), " "), a4+`{"n":1} `+a4+`{"n":2} `+a4+`{"n":3}`; got != want {
+		t.Fatalf("replayed %s, want %s", got, want)
+	}
+	// The range ends at midnight, before org A's 00:00 hour.
+	if got := replay(nil, base.Add(-time.Hour), base.Add(90*time.Minute)); len(got) != 3 {
+		t.Fatalf("replayed %v across organizations, want records 1, 2, and 4", got)
+	}
+	if got := replay(&orgA, base.Add(2*time.Hour), base.Add(3*time.Hour)); len(got) != 1 {
+		t.Fatalf("replayed %v for the next day, want record 3", got)
+	}
+}
+
+func TestArchiverSplitsLargePartitions(t *testing.T) {
+	store := &memStore{}
+	a := NewArchiver(store, "", 10, 1, time.Hour)
+	done := make(chan struct{})
+	go func() {
+		a.Run()
+		close(done)
+	}()
+	org, at := uuid.New(), time.Now()
+	for range 3 {
+		a.Enqueue(org, record(at, uuid.New(), `{}`))
+	}
+	a.Close()
+	<-done
+	if n := len(store.keys()); n != 3 {
+		t.Fatalf("uploaded %d objects, want one per record", n)
+	}
+}
@@ -0,0 +1,175 @@
+package notify
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// Alert severities, in PagerDuty's terms.
+const (
+	SeverityCritical = "critical"
+	SeverityError    = "error"
+	SeverityWarning  = "warning"
+	SeverityInfo     = "info"
+)
+
+const (
+	// DefaultPagerDutyURL is the PagerDuty Events API v2 endpoint.
+	DefaultPagerDutyURL = "https://events.pagerduty.com/v2/enqueue"
+	// DefaultOpsgenieURL is the Opsgenie API for US accounts.
+	DefaultOpsgenieURL = "https://api.opsgenie.com"
+	// OpsgenieEUURL is the Opsgenie API for EU accounts.
+	OpsgenieEUURL = "https://api.eu.opsgenie.com"
+)
+
+// Alert is an incident to open in a paging service. Alerts with the same
+// DedupKey are one incident: triggering again updates it rather than paging
+// twice, and resolving closes it.
+type Alert struct {
+	DedupKey string
+	Summary  string
+	// Source is what the alert is about, such as a host identifier.
+	Source   string
+	Severity string
+	Details  map[string]string
+	// URL links to the alert's page in QueryOps, if known.
+	URL string
+}
+
+// Alerter opens and resolves incidents in a paging service.
+type Alerter interface {
+	Trigger(ctx context.Context, a Alert) error
+	Resolve(ctx context.Context, dedupKey string) error
+}
+
+// PagerDuty sends alerts to one PagerDuty service through the Events API v2.
+type PagerDuty struct {
+	webhook    *Webhook
+	url        string
+	routingKey string
+}
+
+// NewPagerDuty creates an Alerter for the service with routingKey, its
+// integration key. An empty eventsURL means DefaultPagerDutyURL.
+func NewPagerDuty(webhook *Webhook, eventsURL, routingKey string) *PagerDuty {
+	if eventsURL == "" {
+		eventsURL = DefaultPagerDutyURL
+	}
+	return &PagerDuty{webhook: webhook, url: eventsURL, routingKey: routingKey}
+}
+
+type pagerDutyEvent struct {
+	RoutingKey  string            `json:"routing_key"`
+	EventAction string            `json:"event_action"`
+	DedupKey    string            `json:"dedup_key"`
+	Payload     *pagerDutyPayload `json:"payload,omitempty"`
+	Links       []pagerDutyLink   `json:"links,omitempty"`
+}
+
+type pagerDutyPayload struct {
+	Summary       string            `json:"summary"`
+	Source        string            `json:"source"`
+	Severity      string            `json:"severity"`
+	Component     string            `json:"component"`
+	CustomDetails map[string]string `json:"custom_details,omitempty"`
+}
+
+type pagerDutyLink struct {
+	Href string `json:"href"`
+	Text string `json:"text"`
+}
+
+func (p *PagerDuty) Trigger(ctx context.Context, a Alert) error {
+	event := pagerDutyEvent{
+		RoutingKey:  p.routingKey,
+		EventAction: "trigger",
+		DedupKey:    a.DedupKey,
+		Payload: &pagerDutyPayload{
+			Summary:       truncateRunes(a.Summary, 1024),
+			Source:        a.Source,
+			Severity:      a.Severity,
+			Component:     "queryops",
+			CustomDetails: a.Details,
+		},
+	}
+	if a.URL != "" {
+		event.Links = []pagerDutyLink{{Href: a.URL, Text: "Open in QueryOps"}}
+	}
+	return p.webhook.PostJSON(ctx, p.url, event)
+}
+
+func (p *PagerDuty) Resolve(ctx context.Context, dedupKey string) error {
+	return p.webhook.PostJSON(ctx, p.url, pagerDutyEvent{
+		RoutingKey:  p.routingKey,
+		EventAction: "resolve",
+		DedupKey:    dedupKey,
+	})
+}
+
+// Opsgenie sends alerts to Opsgenie through its Alert API, using the dedup
+// key as the alert's alias.
+type Opsgenie struct {
+	webhook *Webhook
+	url     string
+	apiKey  string
+}
+
+// NewOpsgenie creates an Alerter for the API integration with apiKey. An
+// empty apiURL means DefaultOpsgenieURL.
+func NewOpsgenie(webhook *Webhook, apiURL, apiKey string) *Opsgenie {
+	if apiURL == "" {
+		apiURL = DefaultOpsgenieURL
+	}
+	return &Opsgenie{webhook: webhook, url: strings.TrimSuffix(apiURL, "/"), apiKey: apiKey}
+}
+
+// opsgeniePriorities maps severities to Opsgenie priorities.
+var opsgeniePriorities = map[string]string{
+	SeverityCritical: "P1",
+	SeverityError:    "P2",
+	SeverityWarning:  "P3",
+	SeverityInfo:     "P5",
+}
+
+func (o *Opsgenie) Trigger(ctx context.Context, a Alert) error {
+	priority, ok := opsgeniePriorities[a.Severity]
+	if !ok {
+		priority = "P3"
+	}
+	description := a.Summary
+	if a.URL != "" {
+		description += "\n\n" + a.URL
+	}
+	payload := map[string]any{
+		"message":     truncateRunes(a.Summary, 130),
+		"alias":       a.DedupKey,
+		"description": truncateRunes(description, 15000),
+		"entity":      a.Source,
+		"source":      "QueryOps",
+		"priority":    priority,
+	}
+	if len(a.Details) > 0 {
+		payload["details"] = a.Details
+	}
+	return o.webhook.post(ctx, o.url+"/v2/alerts", payload, o.header(), nil)
+}
+
+func (o *Opsgenie) Resolve(ctx context.Context, dedupKey string) error {
+	endpoint := o.url + "/v2/alerts/" + url.PathEscape(dedupKey) + "/close?identifierType=alias"
+	return o.webhook.post(ctx, endpoint, map[string]string{"source": "QueryOps"}, o.header(), nil)
+}
+
+func (o *Opsgenie) header() http.Header {
+	return http.Header{"Authorization": {"GenieKey " + o.apiKey}}
+}
+
+// truncateRunes shortens s to at most n runes, the limits paging services
+// put on some fields.
+func truncateRunes(s string, n int) string {
+	if r := []rune(s); len(r) > n {
+		return string(r[:n-1]) + "…"
+	}
+	return s
+}
@@ -0,0 +1,107 @@
+package notify
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestPagerDuty(t *testing.T) {
+	var got []map[string]any
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var event map[string]any
+		if err := json.NewDecoder(r.Body).Decode(&event); err != nil {
+			t.Errorf("decoding body: %v", err)
+		}
+		got = append(got, event)
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer srv.Close()
+
+	pd := NewPagerDuty(NewWebhook(srv.Client()), srv.URL, "R0UT1NGKEY")
+	ctx := context.Background()
+	err := pd.Trigger(ctx, Alert{
+		DedupKey: "queryops:host-offline:1",
+		Summary:  "web-1 offline for 2 hours",
+		Source:   "web-1",
+		Severity: SeverityCritical,
+		URL:      "https://queryops.example.com/hosts/1",
+	})
+	if err != nil {
+		t.Fatalf("Trigger: %v", err)
+	}
+	if err := pd.Resolve(ctx, "queryops:host-offline:1"); err != nil {
+		t.Fatalf("Resolve: %v", err)
+	}
+
+	if len(got) != 2 {
+		t.Fatalf("got %d events, want 2", len(got))
+	}
+	trigger, resolve := got[0], got[1]
+	if trigger["routing_key"] != "R0UT1NGKEY" || trigger["event_action"] != "trigger" || trigger["dedup_key"] != "queryops:host-offline:1" {
+		t.Fatalf("trigger = %#v", trigger)
+	}
+	payload, _ := trigger["payload"].(map[string]any)
+	if payload["severity"] != "critical" || payload["source"] != "web-1" || payload["summary"] != "web-1 offline for 2 hours" {
+		t.Fatalf("trigger payload = %#v", payload)
+	}
+	if links, _ := trigger["links"].([]any); len(links) != 1 {
+		t.Fatalf("trigger links = %#v", trigger["links"])
+	}
+	if resolve["event_action"] != "resolve" || resolve["dedup_key"] != "queryops:host-offline:1" || resolve["payload"] != nil {
+		t.Fatalf("resolve = %#v", resolve)
+	}
+}
+
+func TestOpsgenie(t *testing.T) {
+	type request struct {
+		path, query, auth string
+		body              map[string]any
+	}
+	var got []request
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		req := request{path: r.URL.EscapedPath(), query: r.URL.RawQuery, auth: r.Header.Get("Authorization")}
+		if err := json.NewDecoder(r.Body).Decode(&req.body); err != nil {
+			t.Errorf("decoding body: %v", err)
+		}
+		got = append(got, req)
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer srv.Close()
+
+	og := NewOpsgenie(NewWebhook(srv.Client()), srv.URL+"/", "key-1")
+	ctx := context.Background()
+	err := og.Trigger(ctx, Alert{
+		DedupKey: "queryops:status-alert:7:a/b",
+		Summary:  strings.Repeat("x", 200),
+		Source:   "web-1",
+		Severity: SeverityWarning,
+		Details:  map[string]string{"rule": "disk"},
+	})
+	if err != nil {
+		t.Fatalf("Trigger: %v", err)
+	}
+	if err := og.Resolve(ctx, "queryops:status-alert:7:a/b"); err != nil {
+		t.Fatalf("Resolve: %v", err)
+	}
+
+	if len(got) != 2 {
+		t.Fatalf("got %d requests, want 2", len(got))
+	}
+	create, closeReq := got[0], got[1]
+	if create.path != "/v2/alerts" || create.auth != "GenieKey key-1" {
+		t.Fatalf("create request = %+v", create)
+	}
+	if create.body["alias"] != "queryops:status-alert:7:a/b" || create.body["priority"] != "P3" {
+		t.Fatalf("create body = %#v", create.body)
+	}
+	if msg, _ := create.body["message"].(string); len([]rune(msg)) != 130 {
+		t.Fatalf("message has %d runes, want 130", len([]rune(msg)))
+	}
+	if closeReq.path != "/v2/alerts/queryops:status-alert:7:a%2Fb/close" || closeReq.query != "identifierType=alias" {
+		t.Fatalf("close request = %+v", closeReq)
+	}
+}
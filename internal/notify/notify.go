@@ -0,0 +1,203 @@
+// Package notify delivers outbound notifications over email and webhooks.
+package notify
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/smtp"
+	"net/url"
+	"strings"
+	"syscall"
+	"time"
+)
+
+const defaultWebhookTimeout = 10 * time.Second
+
+// Mailer sends plain-text email.
+type Mailer interface {
+	Send(ctx context.Context, to []string, subject, body string) error
+}
+
+// SMTPConfig configures an SMTPMailer.
+type SMTPConfig struct {
+	// Addr is the SMTP server as host:port. If empty, NewMailer returns a
+	// LogMailer.
+	Addr     string
+	Username string
+	Password string
+	From     string
+}
+
+// NewMailer returns an SMTP mailer, or a LogMailer if no SMTP server is
+// configured.
+func NewMailer(cfg SMTPConfig) Mailer {
+	if cfg.Addr == "" {
+		return LogMailer{}
+	}
+	return &SMTPMailer{cfg: cfg}
+}
+
+// SMTPMailer sends email through an SMTP server using PLAIN auth when
+// credentials are configured.
+type SMTPMailer struct {
+	cfg SMTPConfig
+}
+
+func (m *SMTPMailer) Send(_ context.Context, to []string, subject, body string) error {
+	if len(to) == 0 {
+		return nil
+	}
+
+	var auth smtp.Auth
+	if m.cfg.Username != "" {
+		host, _, err := net.SplitHostPort(m.cfg.Addr)
+		if err != nil {
+			return fmt.Errorf("parsing smtp address: %w", err)
+		}
+		auth = smtp.PlainAuth("", m.cfg.Username, m.cfg.Password, host)
+	}
+
+	var msg bytes.Buffer
+	fmt.Fprintf(&msg, "From: %s\r\n", m.cfg.From)
+	fmt.Fprintf(&msg, "To: %s\r\n", strings.Join(to, ", "))
+	fmt.Fprintf(&msg, "Subject: %s\r\n", subject)
+	msg.WriteString("MIME-Version: 1.0\r\n")
+	msg.WriteString("Content-Type: text/plain; charset=UTF-8\r\n\r\n")
+	msg.WriteString(body)
+
+	if err := smtp.SendMail(m.cfg.Addr, auth, m.cfg.From, to, msg.Bytes()); err != nil {
+		return fmt.Errorf("sending mail: %w", err)
+	}
+	return nil
+}
+
+// LogMailer logs messages instead of sending them. It is used in development
+// and whenever SMTP is not configured.
+type LogMailer struct{}
+
+func (LogMailer) Send(ctx context.Context, to []string, subject, body string) error {
+	slog.InfoContext(ctx, "mail not sent: smtp not configured", "to", to, "subject", subject, "body", body)
+	return nil
+}
+
+var (
+	// ErrInvalidWebhookURL is returned for webhook URLs that are not absolute
+	// http or https URLs.
+	ErrInvalidWebhookURL = errors.New("webhook must be an http or https URL")
+	// ErrDisallowedWebhookAddress is returned for webhooks that point at
+	// loopback, link-local, private, or otherwise non-public addresses.
+	ErrDisallowedWebhookAddress = errors.New("webhook must not point at a private or local address")
+)
+
+// ValidateWebhookURL checks that raw is an http or https URL whose host is
+// not obviously internal. Hostnames are only resolved when the webhook is
+// posted, where NewWebhook's dialer checks the address actually connected
+// to.
+func ValidateWebhookURL(raw string) error {
+	u, err := url.Parse(raw)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Hostname() == "" {
+		return ErrInvalidWebhookURL
+	}
+	host := strings.TrimSuffix(strings.ToLower(u.Hostname()), ".")
+	if host == "localhost" || strings.HasSuffix(host, ".localhost") {
+		return ErrDisallowedWebhookAddress
+	}
+	if addr, err := netip.ParseAddr(host); err == nil && !publicAddr(addr) {
+		return ErrDisallowedWebhookAddress
+	}
+	return nil
+}
+
+// publicAddr reports whether addr is a globally routable unicast address.
+func publicAddr(addr netip.Addr) bool {
+	addr = addr.Unmap()
+	return addr.IsGlobalUnicast() && !addr.IsPrivate() &&
+		!cgnatPrefix.Contains(addr) && !addr.IsLinkLocalUnicast()
+}
+
+// cgnatPrefix is the RFC 6598 shared address space, which IsPrivate omits.
+var cgnatPrefix = netip.MustParsePrefix("100.64.0.0/10")
+
+// guardDial refuses connections to non-public addresses. It runs after DNS
+// resolution, so it also covers hostnames that resolve to internal
+// addresses and redirects to them.
+func guardDial(_, address string, _ syscall.RawConn) error {
+	addrPort, err := netip.ParseAddrPort(address)
+	if err != nil {
+		return fmt.Errorf("parsing webhook address %q: %w", address, err)
+	}
+	if !publicAddr(addrPort.Addr()) {
+		return fmt.Errorf("dialing %s: %w", address, ErrDisallowedWebhookAddress)
+	}
+	return nil
+}
+
+// Webhook posts JSON payloads to arbitrary URLs.
+type Webhook struct {
+	client *http.Client
+}
+
+// NewWebhook creates a Webhook. If client is nil, a client with a short
+// timeout is used that refuses to connect to loopback, link-local, and
+// private addresses, since webhook URLs are entered by organization members.
+func NewWebhook(client *http.Client) *Webhook {
+	if client == nil {
+		dialer := &net.Dialer{Timeout: defaultWebhookTimeout, Control: guardDial}
+		transport := http.DefaultTransport.(*http.Transport).Clone()
+		transport.Proxy = nil
+		transport.DialContext = dialer.DialContext
+		client = &http.Client{Timeout: defaultWebhookTimeout, Transport: transport}
+	}
+	return &Webhook{client: client}
+}
+
+// PostJSON sends payload to url and fails on any non-2xx response.
+func (w *Webhook) PostJSON(ctx context.Context, url string, payload any) error {
+	return w.post(ctx, url, payload, nil, nil)
+}
+
+// post sends payload to url as JSON with any extra header, fails on a non-2xx
+// response, and decodes the response body into out unless it's nil.
+func (w *Webhook) post(ctx context.Context, url string, payload any, header http.Header, out any) error {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return fmt.Errorf("encoding webhook payload: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("creating webhook request: %w", err)
+	}
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "QueryOps-Webhook/1")
+
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("posting webhook: %w", err)
+	}
+	defer resp.Body.Close()
+	respBody := io.LimitReader(resp.Body, 64<<10)
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		_, _ = io.Copy(io.Discard, respBody)
+		return fmt.Errorf("posting webhook: unexpected status %d", resp.StatusCode)
+	}
+	if out != nil {
+		if err := json.NewDecoder(respBody).Decode(out); err != nil {
+			return fmt.Errorf("decoding webhook response: %w", err)
+		}
+	}
+	_, _ = io.Copy(io.Discard, respBody)
+	return nil
+}
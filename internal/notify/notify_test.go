@@ -0,0 +1,119 @@
+package notify
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestWebhook_PostJSON(t *testing.T) {
+	var got map[string]string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if ct := r.Header.Get("Content-Type"); ct != "application/json" {
+			t.Errorf("Content-Type = %q, want application/json", ct)
+		}
+		if err := json.NewDecoder(r.Body).Decode(&got); err != nil {
+			t.Errorf("decoding body: %v", err)
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer srv.Close()
+
+	wh := NewWebhook(srv.Client())
+	if err := wh.PostJSON(context.Background(), srv.URL, map[string]string{"hello": "world"}); err != nil {
+		t.Fatalf("PostJSON: %v", err)
+	}
+	if got["hello"] != "world" {
+		t.Fatalf("payload = %#v", got)
+	}
+}
+
+func TestWebhook_PostJSON_ErrorStatus(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer srv.Close()
+
+	wh := NewWebhook(srv.Client())
+	if err := wh.PostJSON(context.Background(), srv.URL, struct{}{}); err == nil {
+		t.Fatalf("expected error for 502 response")
+	}
+}
+
+func TestWebhook_PostJSON_RefusesLocalAddresses(t *testing.T) {
+	var called bool
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		called = true
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer srv.Close()
+
+	// The default client guards its dialer; srv listens on loopback.
+	err := NewWebhook(nil).PostJSON(context.Background(), srv.URL, struct{}{})
+	if !errors.Is(err, ErrDisallowedWebhookAddress) {
+		t.Fatalf("PostJSON error = %v, want ErrDisallowedWebhookAddress", err)
+	}
+	if called {
+		t.Fatalf("webhook reached a loopback server")
+	}
+}
+
+func TestValidateWebhookURL(t *testing.T) {
+	tests := []struct {
+		url  string
+		want error
+	}{
+		{"https://hooks.example.com/queryops", nil},
+		{"http://93.184.216.34:8080/hook", nil},
+		{"ftp://example.com/hook", ErrInvalidWebhookURL},
+		{"example.com/hook", ErrInvalidWebhookURL},
+		{"https://", ErrInvalidWebhookURL},
+		{"http://localhost:8080/hook", ErrDisallowedWebhookAddress},
+		{"http://api.localhost/hook", ErrDisallowedWebhookAddress},
+		{"http://127.0.0.1/hook", ErrDisallowedWebhookAddress},
+		{"http://[::1]/hook", ErrDisallowedWebhookAddress},
+		{"http://169.254.169.254/latest/meta-data", ErrDisallowedWebhookAddress},
+		{"http://10.0.0.5/hook", ErrDisallowedWebhookAddress},
+		{"http://172.16.3.4/hook", ErrDisallowedWebhookAddress},
+		{"http://192.168.1.1/hook", ErrDisallowedWebhookAddress},
+		{"http://100.64.0.1/hook", ErrDisallowedWebhookAddress},
+		{"http://0.0.0.0/hook", ErrDisallowedWebhookAddress},
+		{"http://[::ffff:127.0.0.1]/hook", ErrDisallowedWebhookAddress},
+		{"http://[fd00::1]/hook", ErrDisallowedWebhookAddress},
+	}
+	for _, tt := range tests {
+		if err := ValidateWebhookURL(tt.url); !errors.Is(err, tt.want) {
+			t.Errorf("ValidateWebhookURL(%q) = %v, want %v", tt.url, err, tt.want)
+		}
+	}
+}
+
+func TestGuardDial(t *testing.T) {
+	tests := []struct {
+		address string
+		allowed bool
+	}{
+		{"93.184.216.34:443", true},
+		{"[2606:2800:220:1:248:1893:25c8:1946]:443", true},
+		{"127.0.0.1:80", false},
+		{"169.254.169.254:80", false},
+		{"10.1.2.3:443", false},
+		{"[::1]:443", false},
+		{"[fe80::1]:443", false},
+	}
+	for _, tt := range tests {
+		err := guardDial("tcp", tt.address, nil)
+		if got := err == nil; got != tt.allowed {
+			t.Errorf("guardDial(%q) = %v, want allowed=%v", tt.address, err, tt.allowed)
+		}
+	}
+}
+
+func TestNewMailer_DefaultsToLogMailer(t *testing.T) {
+	if _, ok := NewMailer(SMTPConfig{}).(LogMailer); !ok {
+		t.Fatalf("NewMailer without Addr should return LogMailer")
+	}
+}
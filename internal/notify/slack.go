@@ -0,0 +1,64 @@
+package notify
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// DefaultSlackAPIURL is the Slack Web API that bot tokens post through.
+const DefaultSlackAPIURL = "https://slack.com/api"
+
+// ErrInvalidSlackToken is returned for bot tokens that aren't Slack bot
+// tokens.
+var ErrInvalidSlackToken = errors.New("bot token must start with xoxb-")
+
+// ValidateSlackToken checks that token looks like a Slack bot token.
+func ValidateSlackToken(token string) error {
+	if !strings.HasPrefix(token, "xoxb-") || strings.ContainsAny(token, " \t\r\n") {
+		return ErrInvalidSlackToken
+	}
+	return nil
+}
+
+// Slack posts messages to Slack, through an incoming webhook or, with a bot
+// token, to any channel the bot has been added to.
+type Slack struct {
+	webhook *Webhook
+	apiURL  string
+}
+
+// NewSlack creates a Slack client that posts through webhook. An empty apiURL
+// means DefaultSlackAPIURL.
+func NewSlack(webhook *Webhook, apiURL string) *Slack {
+	if apiURL == "" {
+		apiURL = DefaultSlackAPIURL
+	}
+	return &Slack{webhook: webhook, apiURL: strings.TrimSuffix(apiURL, "/")}
+}
+
+// PostWebhook posts text to an incoming webhook's channel.
+func (s *Slack) PostWebhook(ctx context.Context, url, text string) error {
+	return s.webhook.PostJSON(ctx, url, map[string]string{"text": text})
+}
+
+// PostMessage posts text to channel, a name like #alerts or an ID, with a bot
+// token. Slack reports most failures, such as a bot missing from the
+// channel, in a 200 response, and those are returned as errors too.
+func (s *Slack) PostMessage(ctx context.Context, token, channel, text string) error {
+	var resp struct {
+		OK    bool   `json:"ok"`
+		Error string `json:"error"`
+	}
+	header := http.Header{"Authorization": {"Bearer " + token}}
+	payload := map[string]string{"channel": channel, "text": text}
+	if err := s.webhook.post(ctx, s.apiURL+"/chat.postMessage", payload, header, &resp); err != nil {
+		return err
+	}
+	if !resp.OK {
+		return fmt.Errorf("posting slack message to %s: %s", channel, resp.Error)
+	}
+	return nil
+}
@@ -0,0 +1,51 @@
+package objectstore
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// Dir stores objects as files under a local directory, for deployments that
+// write exports to disk or a mounted volume instead of a bucket. Keys are
+// slash-separated paths relative to the directory.
+type Dir struct {
+	root string
+}
+
+// NewDir returns a Dir rooted at root, which is created on first Put if it
+// doesn't exist.
+func NewDir(root string) *Dir {
+	return &Dir{root: root}
+}
+
+// Put stores body under key, replacing any file already there. The file is
+// written in full before it appears under its name.
+func (d *Dir) Put(_ context.Context, key string, body []byte, _ string) error {
+	rel := filepath.FromSlash(key)
+	if !filepath.IsLocal(rel) {
+		return fmt.Errorf("invalid object key %q", key)
+	}
+	path := filepath.Join(d.root, rel)
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return fmt.Errorf("putting %s: %w", key, err)
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*")
+	if err != nil {
+		return fmt.Errorf("putting %s: %w", key, err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(body); err != nil {
+		tmp.Close()
+		return fmt.Errorf("putting %s: %w", key, err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("putting %s: %w", key, err)
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return fmt.Errorf("putting %s: %w", key, err)
+	}
+	return nil
+}
@@ -0,0 +1,211 @@
+// Package osqueryinstall renders, per platform, the files that point an
+// osquery install at this server: a flags file, a service definition, and an
+// install script that writes them along with the enroll secret, so a single
+// download enrolls a host. Templates are bundled in templates/.
+package osqueryinstall
+
+import (
+	"embed"
+	"errors"
+	"fmt"
+	"path"
+	"slices"
+	"strings"
+	"text/template"
+)
+
+//go:embed templates/*.tmpl
+var files embed.FS
+
+// Platforms lists the platforms install files are rendered for, named as in
+// the osquery schema.
+var Platforms = []string{"darwin", "linux", "windows"}
+
+var (
+	// ErrUnknownPlatform is returned for a platform not in Platforms.
+	ErrUnknownPlatform = errors.New("unknown platform")
+	// ErrUnknownFile is returned for a file not rendered for a platform.
+	ErrUnknownFile = errors.New("unknown install file")
+	// ErrInvalidParams is returned for values that can't be written safely
+	// into a flags file or script.
+	ErrInvalidParams = errors.New("invalid install parameters")
+)
+
+// Suggested intervals, in seconds. Config refresh also paces node key
+// rotation; see the osquery docs.
+const (
+	DefaultConfigRefresh       = 300
+	DefaultLoggerPeriod        = 30
+	DefaultDistributedInterval = 30
+)
+
+// Params are the values substituted into an organization's install files.
+type Params struct {
+	// Hostname is the server's TLS host[:port] as osquery reaches it.
+	Hostname            string
+	EnrollSecret        string
+	ConfigRefresh       int
+	LoggerPeriod        int
+	DistributedInterval int
+}
+
+// NewParams returns Params with the suggested intervals.
+func NewParams(hostname, enrollSecret string) Params {
+	return Params{
+		Hostname:            hostname,
+		EnrollSecret:        enrollSecret,
+		ConfigRefresh:       DefaultConfigRefresh,
+		LoggerPeriod:        DefaultLoggerPeriod,
+		DistributedInterval: DefaultDistributedInterval,
+	}
+}
+
+// File is a rendered install file.
+type File struct {
+	Name string
+	// Path is where the install script writes the file on the host.
+	Path        string
+	Description string
+	Content     []byte
+}
+
+type paths struct {
+	Flags   string
+	Secret  string
+	Service string
+}
+
+type platform struct {
+	paths paths
+	// files are the platform's files in display order.
+	files []platformFile
+}
+
+type platformFile struct {
+	name        string
+	template    string
+	description string
+	path        func(paths) string
+}
+
+var platforms = map[string]platform{
+	"linux": {
+		paths: paths{
+			Flags:   "/etc/osquery/osquery.flags",
+			Secret:  "/etc/osquery/enroll_secret",
+			Service: "/etc/systemd/system/osqueryd.service",
+		},
+		files: []platformFile{
+			{"install.sh", "linux/install.sh", "Writes the files below and starts osqueryd. Run as root after installing the osquery .deb or .rpm.", nil},
+			{"osquery.flags", "flags", "osqueryd flags pointing at this server.", func(p paths) string { return p.Flags }},
+			{"osqueryd.service", "osqueryd.service", "systemd unit that runs osqueryd with the flags file.", func(p paths) string { return p.Service }},
+		},
+	},
+	"darwin": {
+		paths: paths{
+			Flags:   "/var/osquery/osquery.flags",
+			Secret:  "/var/osquery/enroll_secret",
+			Service: "/Library/LaunchDaemons/io.osquery.agent.plist",
+		},
+		files: []platformFile{
+			{"install.sh", "darwin/install.sh", "Writes the files below and loads the launch daemon. Run with sudo after installing the osquery .pkg.", nil},
+			{"osquery.flags", "flags", "osqueryd flags pointing at this server.", func(p paths) string { return p.Flags }},
+			{"io.osquery.agent.plist", "io.osquery.agent.plist", "launchd daemon that runs osqueryd with the flags file.", func(p paths) string { return p.Service }},
+		},
+	},
+	"windows": {
+		paths: paths{
+			Flags:  `C:\Program Files\osquery\osquery.flags`,
+			Secret: `C:\Program Files\osquery\enroll_secret`,
+		},
+		files: []platformFile{
+			{"install.ps1", "windows/install.ps1", "Installs the osquery MSI silently if given one, writes the flags file, and restarts the osqueryd service. Run from an elevated PowerShell prompt.", nil},
+			{"osquery.flags", "flags", "osqueryd flags pointing at this server. The MSI's osqueryd service reads this path.", func(p paths) string { return p.Flags }},
+		},
+	},
+}
+
+var templates = template.Must(template.New("").Funcs(template.FuncMap{
+	"dir":     path.Dir,
+	"shquote": shellQuote,
+	"psquote": powershellQuote,
+}).ParseFS(files, "templates/*.tmpl"))
+
+// Files renders every install file for platform, install script first.
+func Files(platformName string, params Params) ([]File, error) {
+	p, ok := platforms[platformName]
+	if !ok {
+		return nil, fmt.Errorf("%w: %q", ErrUnknownPlatform, platformName)
+	}
+	if err := params.Validate(); err != nil {
+		return nil, err
+	}
+
+	data := struct {
+		Params
+		Paths paths
+	}{params, p.paths}
+
+	out := make([]File, 0, len(p.files))
+	for _, f := range p.files {
+		var b strings.Builder
+		if err := templates.ExecuteTemplate(&b, f.template, data); err != nil {
+			return nil, fmt.Errorf("rendering %s for %s: %w", f.name, platformName, err)
+		}
+		file := File{Name: f.name, Description: f.description, Content: []byte(b.String())}
+		if f.path != nil {
+			file.Path = f.path(p.paths)
+		}
+		out = append(out, file)
+	}
+	return out, nil
+}
+
+// FindFile renders a single install file by name.
+func FindFile(platformName, name string, params Params) (*File, error) {
+	all, err := Files(platformName, params)
+	if err != nil {
+		return nil, err
+	}
+	i := slices.IndexFunc(all, func(f File) bool { return f.Name == name })
+	if i < 0 {
+		return nil, fmt.Errorf("%w: %q", ErrUnknownFile, name)
+	}
+	return &all[i], nil
+}
+
+// SecretPath is where platform's flags file expects the enroll secret.
+func SecretPath(platformName string) (string, error) {
+	p, ok := platforms[platformName]
+	if !ok {
+		return "", fmt.Errorf("%w: %q", ErrUnknownPlatform, platformName)
+	}
+	return p.paths.Secret, nil
+}
+
+// Validate rejects values that would break out of a flag line or a quoted
+// script string. Files calls it.
+func (p Params) Validate() error {
+	if p.Hostname == "" || strings.ContainsAny(p.Hostname, " \t\r\n'\"`$\\/") {
+		return fmt.Errorf("%w: hostname %q", ErrInvalidParams, p.Hostname)
+	}
+	// PowerShell also treats typographic quotes as quotes; printable ASCII
+	// is simpler to quote for every shell.
+	if p.EnrollSecret == "" || strings.ContainsFunc(p.EnrollSecret, func(r rune) bool { return r < 0x20 || r > 0x7e }) {
+		return fmt.Errorf("%w: enroll secret must be non-empty printable ASCII", ErrInvalidParams)
+	}
+	if p.ConfigRefresh <= 0 || p.LoggerPeriod <= 0 || p.DistributedInterval <= 0 {
+		return fmt.Errorf("%w: intervals must be positive", ErrInvalidParams)
+	}
+	return nil
+}
+
+// shellQuote quotes s for a POSIX shell.
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+// powershellQuote quotes s as a PowerShell verbatim string.
+func powershellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", "''") + "'"
+}
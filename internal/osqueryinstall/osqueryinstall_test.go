@@ -0,0 +1,76 @@
+package osqueryinstall
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestFiles(t *testing.T) {
+	params := NewParams("queryops.example.com", "acme-0123456789abcdef")
+
+	for _, platform := range Platforms {
+		t.Run(platform, func(t *testing.T) {
+			files, err := Files(platform, params)
+			if err != nil {
+				t.Fatalf("Files: %v", err)
+			}
+			if !strings.HasPrefix(files[0].Name, "install.") {
+				t.Fatalf("first file = %q, want the install script", files[0].Name)
+			}
+
+			flags, err := FindFile(platform, "osquery.flags", params)
+			if err != nil {
+				t.Fatalf("FindFile: %v", err)
+			}
+			for _, want := range []string{
+				"--tls_hostname=queryops.example.com\n",
+				"--config_refresh=300\n",
+				"--enroll_tls_endpoint=/osquery/enroll\n",
+			} {
+				if !strings.Contains(string(flags.Content), want) {
+					t.Errorf("flags missing %q:\n%s", want, flags.Content)
+				}
+			}
+
+			script := string(files[0].Content)
+			if !strings.Contains(script, "'acme-0123456789abcdef'") {
+				t.Errorf("install script doesn't write the quoted secret:\n%s", script)
+			}
+			if !strings.Contains(script, string(flags.Content)) {
+				t.Errorf("install script doesn't inline the flags file:\n%s", script)
+			}
+		})
+	}
+}
+
+func TestFiles_Errors(t *testing.T) {
+	valid := NewParams("queryops.example.com", "secret")
+
+	if _, err := Files("plan9", valid); !errors.Is(err, ErrUnknownPlatform) {
+		t.Errorf("unknown platform: err = %v", err)
+	}
+	if _, err := FindFile("linux", "install.ps1", valid); !errors.Is(err, ErrUnknownFile) {
+		t.Errorf("unknown file: err = %v", err)
+	}
+
+	for name, params := range map[string]Params{
+		"empty hostname":    NewParams("", "secret"),
+		"hostname with url": NewParams("https://queryops.example.com", "secret"),
+		"multiline secret":  NewParams("queryops.example.com", "secret\n--enroll_always"),
+		"no interval":       {Hostname: "queryops.example.com", EnrollSecret: "secret"},
+	} {
+		if _, err := Files("linux", params); !errors.Is(err, ErrInvalidParams) {
+			t.Errorf("%s: err = %v, want ErrInvalidParams", name, err)
+		}
+	}
+}
+
+func TestQuoting(t *testing.T) {
+	if got := shellQuote(`it's`); got != `'it'\''s'` {
+		t.Errorf("shellQuote = %s", got)
+	}
+	if got := powershellQuote(`it's`); got != `'it''s'` {
+		t.Errorf("powershellQuote = %s", got)
+	}
+}
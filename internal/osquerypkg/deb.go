@@ -0,0 +1,146 @@
+package osquerypkg
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"maps"
+	"path"
+	"slices"
+	"time"
+
+	"github.com/cavenine/queryops/internal/osqueryinstall"
+)
+
+const debPostinst = `#!/bin/sh
+set -e
+if [ "$1" = configure ] && [ -d /run/systemd/system ]; then
+	systemctl daemon-reload
+	systemctl enable osqueryd
+	systemctl restart osqueryd
+fi
+`
+
+const debPostrm = `#!/bin/sh
+set -e
+if [ -d /run/systemd/system ]; then
+	systemctl daemon-reload
+fi
+`
+
+// buildDeb writes a Debian binary package: an ar archive of debian-binary,
+// control.tar.gz, and data.tar.gz.
+func buildDeb(_ context.Context, params osqueryinstall.Params, version string) ([]byte, error) {
+	files, err := installFiles("linux", params, "osqueryd.service")
+	if err != nil {
+		return nil, err
+	}
+	modTime := time.Now()
+
+	// Directories first, parents before children, as dpkg expects.
+	var installedSize int64
+	dirs := map[string]bool{}
+	for _, f := range files {
+		for dir := path.Dir(f.path); dir != "/"; dir = path.Dir(dir) {
+			dirs[dir] = true
+		}
+		installedSize += int64(len(f.content))
+	}
+	var data []tarEntry
+	for _, dir := range slices.Sorted(maps.Keys(dirs)) {
+		data = append(data, tarEntry{name: "." + dir + "/", mode: 0o755, dir: true})
+	}
+	for _, f := range files {
+		data = append(data, tarEntry{name: "." + f.path, mode: f.mode, content: f.content})
+	}
+	dataTar, err := tarGz(data, modTime)
+	if err != nil {
+		return nil, fmt.Errorf("writing data.tar.gz: %w", err)
+	}
+
+	control := fmt.Sprintf(`Package: queryops-enroll
+Version: %s
+Architecture: all
+Maintainer: QueryOps
+Depends: osquery
+Section: admin
+Priority: optional
+Installed-Size: %d
+Description: Enrolls this host with QueryOps at %s
+ Installs osquery flags and an enroll secret, and restarts osqueryd.
+`, version, (installedSize+1023)/1024, params.Hostname)
+	controlTar, err := tarGz([]tarEntry{
+		{name: "./control", mode: 0o644, content: []byte(control)},
+		{name: "./postinst", mode: 0o755, content: []byte(debPostinst)},
+		{name: "./postrm", mode: 0o755, content: []byte(debPostrm)},
+	}, modTime)
+	if err != nil {
+		return nil, fmt.Errorf("writing control.tar.gz: %w", err)
+	}
+
+	var ar bytes.Buffer
+	ar.WriteString("!<arch>\n")
+	for _, member := range []struct {
+		name    string
+		content []byte
+	}{
+		{"debian-binary", []byte("2.0\n")},
+		{"control.tar.gz", controlTar},
+		{"data.tar.gz", dataTar},
+	} {
+		fmt.Fprintf(&ar, "%-16s%-12d%-6d%-6d%-8o%-10d`\n", member.name, modTime.Unix(), 0, 0, 0o100644, len(member.content))
+		ar.Write(member.content)
+		if len(member.content)%2 == 1 {
+			ar.WriteByte('\n')
+		}
+	}
+	return ar.Bytes(), nil
+}
+
+type tarEntry struct {
+	name    string
+	mode    int64
+	dir     bool
+	content []byte
+}
+
+// tarGz writes entries in order, owned by root.
+func tarGz(entries []tarEntry, modTime time.Time) ([]byte, error) {
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gz)
+
+	for _, e := range entries {
+		hdr := &tar.Header{
+			Name:    e.name,
+			Mode:    e.mode,
+			ModTime: modTime,
+			Uname:   "root",
+			Gname:   "root",
+			Format:  tar.FormatGNU,
+		}
+		if e.dir {
+			hdr.Typeflag = tar.TypeDir
+		} else {
+			hdr.Typeflag = tar.TypeReg
+			hdr.Size = int64(len(e.content))
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			return nil, err
+		}
+		if !e.dir {
+			if _, err := tw.Write(e.content); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return nil, err
+	}
+	if err := gz.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
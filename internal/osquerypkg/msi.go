@@ -0,0 +1,95 @@
+package osquerypkg
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"text/template"
+
+	"github.com/cavenine/queryops/internal/osqueryinstall"
+)
+
+// msiUpgradeCode is shared by every enrollment MSI so that installing a newer
+// build replaces an older one.
+const msiUpgradeCode = "0780F7BD-AF3E-4F05-BBB8-C409311E8BE8"
+
+// msiSource installs into the osquery MSI's install directory and restarts
+// its osqueryd service.
+var msiSource = template.Must(template.New("wxs").Parse(`<?xml version="1.0" encoding="utf-8"?>
+<Wix xmlns="http://schemas.microsoft.com/wix/2006/wi">
+	<Product Id="*" Name="QueryOps Enrollment" Language="1033" Version="{{.Version}}" Manufacturer="QueryOps" UpgradeCode="{{.UpgradeCode}}">
+		<Package InstallerVersion="500" Compressed="yes" InstallScope="perMachine" Platform="x64" Description="Enrolls this host with QueryOps at {{html .Hostname}}"/>
+		<MajorUpgrade DowngradeErrorMessage="A newer QueryOps enrollment package is already installed."/>
+		<Media Id="1" Cabinet="enroll.cab" EmbedCab="yes"/>
+		<Directory Id="TARGETDIR" Name="SourceDir">
+			<Directory Id="ProgramFiles64Folder">
+				<Directory Id="INSTALLDIR" Name="osquery">
+					<Component Id="Flags" Guid="*" Win64="yes">
+						<File Id="OsqueryFlags" Name="osquery.flags" Source="osquery.flags" KeyPath="yes"/>
+						<ServiceControl Id="RestartOsqueryd" Name="osqueryd" Start="install" Stop="both" Wait="yes"/>
+					</Component>
+					<Component Id="EnrollSecret" Guid="*" Win64="yes">
+						<File Id="EnrollSecret" Name="enroll_secret" Source="enroll_secret" KeyPath="yes"/>
+					</Component>
+				</Directory>
+			</Directory>
+		</Directory>
+		<Feature Id="Enrollment" Level="1">
+			<ComponentRef Id="Flags"/>
+			<ComponentRef Id="EnrollSecret"/>
+		</Feature>
+	</Product>
+</Wix>
+`))
+
+func buildMSI(ctx context.Context, params osqueryinstall.Params, version string) ([]byte, error) {
+	files, err := installFiles("windows", params, "")
+	if err != nil {
+		return nil, err
+	}
+
+	dir, err := os.MkdirTemp("", "queryops-msi-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+
+	// The .wxs names sources by their base names.
+	for _, f := range files {
+		if err := os.WriteFile(filepath.Join(dir, windowsBase(f.path)), f.content, 0o600); err != nil {
+			return nil, err
+		}
+	}
+
+	var wxs bytes.Buffer
+	if err := msiSource.Execute(&wxs, struct {
+		Version     string
+		UpgradeCode string
+		Hostname    string
+	}{version, msiUpgradeCode, params.Hostname}); err != nil {
+		return nil, err
+	}
+	if err := os.WriteFile(filepath.Join(dir, "enroll.wxs"), wxs.Bytes(), 0o600); err != nil {
+		return nil, err
+	}
+
+	cmd := exec.CommandContext(ctx, "wixl", "--arch", "x64", "--output", "enroll.msi", "enroll.wxs")
+	cmd.Dir = dir
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return nil, fmt.Errorf("running wixl: %w: %s", err, out)
+	}
+	return os.ReadFile(filepath.Join(dir, "enroll.msi"))
+}
+
+// windowsBase returns the last element of a Windows path.
+func windowsBase(p string) string {
+	for i := len(p) - 1; i >= 0; i-- {
+		if p[i] == '\\' {
+			return p[i+1:]
+		}
+	}
+	return p
+}
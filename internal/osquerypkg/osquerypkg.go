@@ -0,0 +1,135 @@
+// Package osquerypkg builds enrollment packages: native packages that install
+// osqueryinstall's flags file, enroll secret, and service definition for one
+// organization and restart osqueryd, so installing one after osquery enrolls
+// the host. They don't bundle osquery itself.
+//
+// Debian packages are built in Go. MSI and macOS packages need wixl (from
+// msitools) and pkgbuild respectively on the building host; Available
+// reports which formats can be built here.
+package osquerypkg
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os/exec"
+
+	"github.com/cavenine/queryops/internal/osqueryinstall"
+)
+
+const (
+	FormatDeb = "deb"
+	FormatMSI = "msi"
+	FormatPkg = "pkg"
+)
+
+// Formats lists the package formats in display order.
+var Formats = []string{FormatDeb, FormatMSI, FormatPkg}
+
+// maxBuild is the largest build number an MSI version field can hold.
+const maxBuild = 65535
+
+var (
+	// ErrUnknownFormat is returned for a format not in Formats.
+	ErrUnknownFormat = errors.New("unknown package format")
+	// ErrFormatUnavailable is returned when the tool a format needs isn't
+	// installed.
+	ErrFormatUnavailable = errors.New("package format unavailable")
+	// ErrInvalidBuild is returned for a build number outside 1-65535.
+	ErrInvalidBuild = errors.New("invalid build number")
+)
+
+// Package is a built package.
+type Package struct {
+	Filename string
+	Content  []byte
+}
+
+// lookPath is replaced in tests.
+var lookPath = exec.LookPath
+
+// tools names the external tool each format needs, if any.
+var tools = map[string]string{
+	FormatMSI: "wixl",
+	FormatPkg: "pkgbuild",
+}
+
+// Available returns nil if packages of format can be built on this host.
+func Available(format string) error {
+	if _, ok := builders[format]; !ok {
+		return fmt.Errorf("%w: %q", ErrUnknownFormat, format)
+	}
+	tool, ok := tools[format]
+	if !ok {
+		return nil
+	}
+	if _, err := lookPath(tool); err != nil {
+		return fmt.Errorf("%w: %s needs %s", ErrFormatUnavailable, format, tool)
+	}
+	return nil
+}
+
+type builder func(ctx context.Context, params osqueryinstall.Params, version string) ([]byte, error)
+
+var builders = map[string]builder{
+	FormatDeb: buildDeb,
+	FormatMSI: buildMSI,
+	FormatPkg: buildPkg,
+}
+
+// Build builds a package of format. build numbers the package's version,
+// 1.0.<build>; a package with a higher build upgrades one with a lower.
+func Build(ctx context.Context, format string, params osqueryinstall.Params, build int) (*Package, error) {
+	if err := Available(format); err != nil {
+		return nil, err
+	}
+	if build < 1 || build > maxBuild {
+		return nil, fmt.Errorf("%w: %d", ErrInvalidBuild, build)
+	}
+	if err := params.Validate(); err != nil {
+		return nil, err
+	}
+
+	version := fmt.Sprintf("1.0.%d", build)
+	content, err := builders[format](ctx, params, version)
+	if err != nil {
+		return nil, fmt.Errorf("building %s package: %w", format, err)
+	}
+	return &Package{
+		Filename: fmt.Sprintf("queryops-enroll-%s.%s", version, format),
+		Content:  content,
+	}, nil
+}
+
+// installFile is a file a package installs.
+type installFile struct {
+	path    string
+	mode    int64
+	content []byte
+}
+
+// installFiles returns the files a package for platform installs: the flags
+// file, the enroll secret, and, where the platform has one, the service
+// definition.
+func installFiles(platform string, params osqueryinstall.Params, service string) ([]installFile, error) {
+	flags, err := osqueryinstall.FindFile(platform, "osquery.flags", params)
+	if err != nil {
+		return nil, err
+	}
+	secretPath, err := osqueryinstall.SecretPath(platform)
+	if err != nil {
+		return nil, err
+	}
+	files := []installFile{
+		{path: flags.Path, mode: 0o600, content: flags.Content},
+		{path: secretPath, mode: 0o600, content: []byte(params.EnrollSecret)},
+	}
+	if service != "" {
+		svc, err := osqueryinstall.FindFile(platform, service, params)
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, installFile{path: svc.Path, mode: 0o644, content: svc.Content})
+	}
+	return files, nil
+}
@@ -0,0 +1,140 @@
+package osquerypkg
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"errors"
+	"io"
+	"os/exec"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/cavenine/queryops/internal/osqueryinstall"
+)
+
+// readAr returns the members of an ar archive by name.
+func readAr(t *testing.T, b []byte) map[string][]byte {
+	t.Helper()
+	if !bytes.HasPrefix(b, []byte("!<arch>\n")) {
+		t.Fatal("missing ar magic")
+	}
+	b = b[8:]
+	members := map[string][]byte{}
+	for len(b) > 0 {
+		hdr := b[:60]
+		name := strings.TrimSpace(string(hdr[:16]))
+		size, err := strconv.Atoi(strings.TrimSpace(string(hdr[48:58])))
+		if err != nil {
+			t.Fatalf("member %q size: %v", name, err)
+		}
+		members[name] = b[60 : 60+size]
+		b = b[60+size+size%2:]
+	}
+	return members
+}
+
+// readTarGz returns the entries of a gzipped tarball by name.
+func readTarGz(t *testing.T, b []byte) map[string]*tar.Header {
+	t.Helper()
+	gz, err := gzip.NewReader(bytes.NewReader(b))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tr := tar.NewReader(gz)
+	entries := map[string]*tar.Header{}
+	for {
+		hdr, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			return entries
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		entries[hdr.Name] = hdr
+	}
+}
+
+func TestBuild_Deb(t *testing.T) {
+	params := osqueryinstall.NewParams("queryops.example.com", "acme-0123456789abcdef")
+
+	pkg, err := Build(context.Background(), FormatDeb, params, 7)
+	if err != nil {
+		t.Fatalf("Build: %v", err)
+	}
+	if pkg.Filename != "queryops-enroll-1.0.7.deb" {
+		t.Errorf("Filename = %q", pkg.Filename)
+	}
+
+	members := readAr(t, pkg.Content)
+	if string(members["debian-binary"]) != "2.0\n" {
+		t.Errorf("debian-binary = %q", members["debian-binary"])
+	}
+
+	control := readTarGz(t, members["control.tar.gz"])
+	for _, name := range []string{"./control", "./postinst", "./postrm"} {
+		if control[name] == nil {
+			t.Errorf("control.tar.gz missing %s", name)
+		}
+	}
+
+	data := readTarGz(t, members["data.tar.gz"])
+	for name, mode := range map[string]int64{
+		"./etc/osquery/":                        0o755,
+		"./etc/osquery/osquery.flags":           0o600,
+		"./etc/osquery/enroll_secret":           0o600,
+		"./etc/systemd/system/osqueryd.service": 0o644,
+	} {
+		hdr := data[name]
+		if hdr == nil {
+			t.Errorf("data.tar.gz missing %s", name)
+			continue
+		}
+		if hdr.Mode != mode {
+			t.Errorf("%s mode = %o, want %o", name, hdr.Mode, mode)
+		}
+	}
+	if size := data["./etc/osquery/enroll_secret"].Size; size != int64(len(params.EnrollSecret)) {
+		t.Errorf("enroll_secret size = %d", size)
+	}
+}
+
+func TestAvailable(t *testing.T) {
+	t.Cleanup(func() { lookPath = exec.LookPath })
+	lookPath = func(file string) (string, error) {
+		if file == "wixl" {
+			return "/usr/bin/wixl", nil
+		}
+		return "", exec.ErrNotFound
+	}
+
+	if err := Available(FormatDeb); err != nil {
+		t.Errorf("deb: %v", err)
+	}
+	if err := Available(FormatMSI); err != nil {
+		t.Errorf("msi: %v", err)
+	}
+	if err := Available(FormatPkg); !errors.Is(err, ErrFormatUnavailable) {
+		t.Errorf("pkg: err = %v, want ErrFormatUnavailable", err)
+	}
+	if err := Available("rpm"); !errors.Is(err, ErrUnknownFormat) {
+		t.Errorf("rpm: err = %v, want ErrUnknownFormat", err)
+	}
+}
+
+func TestBuild_Errors(t *testing.T) {
+	ctx := context.Background()
+	params := osqueryinstall.NewParams("queryops.example.com", "secret")
+
+	if _, err := Build(ctx, FormatDeb, params, 0); !errors.Is(err, ErrInvalidBuild) {
+		t.Errorf("build 0: err = %v", err)
+	}
+	if _, err := Build(ctx, FormatDeb, params, maxBuild+1); !errors.Is(err, ErrInvalidBuild) {
+		t.Errorf("build %d: err = %v", maxBuild+1, err)
+	}
+	if _, err := Build(ctx, FormatDeb, osqueryinstall.NewParams("queryops.example.com", ""), 1); !errors.Is(err, osqueryinstall.ErrInvalidParams) {
+		t.Errorf("no secret: err = %v", err)
+	}
+}
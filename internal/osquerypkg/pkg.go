@@ -0,0 +1,72 @@
+package osquerypkg
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/cavenine/queryops/internal/osqueryinstall"
+)
+
+const pkgIdentifier = "com.queryops.enroll"
+
+// pkgPostinstall fails the install if osquery is missing, so the admin
+// learns of it before the host silently never enrolls.
+const pkgPostinstall = `#!/bin/sh
+if [ ! -x /opt/osquery/lib/osquery.app/Contents/MacOS/osqueryd ]; then
+	echo "osqueryd not found; install the osquery package first" >&2
+	exit 1
+fi
+launchctl bootout system/io.osquery.agent 2>/dev/null || true
+launchctl bootstrap system /Library/LaunchDaemons/io.osquery.agent.plist
+exit 0
+`
+
+func buildPkg(ctx context.Context, params osqueryinstall.Params, version string) ([]byte, error) {
+	files, err := installFiles("darwin", params, "io.osquery.agent.plist")
+	if err != nil {
+		return nil, err
+	}
+
+	dir, err := os.MkdirTemp("", "queryops-pkg-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+
+	root := filepath.Join(dir, "root")
+	for _, f := range files {
+		dest := filepath.Join(root, filepath.FromSlash(strings.TrimPrefix(f.path, "/")))
+		if err := os.MkdirAll(filepath.Dir(dest), 0o755); err != nil {
+			return nil, err
+		}
+		if err := os.WriteFile(dest, f.content, os.FileMode(f.mode)); err != nil {
+			return nil, err
+		}
+	}
+	scripts := filepath.Join(dir, "scripts")
+	if err := os.MkdirAll(scripts, 0o755); err != nil {
+		return nil, err
+	}
+	if err := os.WriteFile(filepath.Join(scripts, "postinstall"), []byte(pkgPostinstall), 0o755); err != nil {
+		return nil, err
+	}
+
+	out := filepath.Join(dir, "enroll.pkg")
+	cmd := exec.CommandContext(ctx, "pkgbuild",
+		"--root", root,
+		"--scripts", scripts,
+		"--identifier", pkgIdentifier,
+		"--version", version,
+		"--install-location", "/",
+		"--ownership", "recommended",
+		out,
+	)
+	if output, err := cmd.CombinedOutput(); err != nil {
+		return nil, fmt.Errorf("running pkgbuild: %w: %s", err, output)
+	}
+	return os.ReadFile(out)
+}
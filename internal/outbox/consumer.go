@@ -0,0 +1,247 @@
+package outbox
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ThreeDotsLabs/watermill/message"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+var ErrConsumerGroupClosed = errors.New("consumer group closed")
+
+// TopicMetadata is the metadata key ConsumerGroup sets to the topic each
+// message was written to, which a wildcard subscription doesn't say.
+const TopicMetadata = "outbox_topic"
+
+// ConsumerGroup is a durable watermill subscriber over the outbox. Unlike the
+// ephemeral NATS subscribers in internal/pubsub, every event is delivered to
+// one member of the group, and the group's position survives restarts.
+//
+// Members sharing a name take turns: whichever holds the offset row lock
+// consumes the next batch. The offset advances only when a message is acked,
+// in the same transaction that holds the lock, so an event is redelivered
+// only if the consumer dies before committing its batch.
+type ConsumerGroup struct {
+	pool      *pgxpool.Pool
+	name      string
+	interval  time.Duration
+	batchSize int
+
+	closing   chan struct{}
+	closeOnce sync.Once
+	wg        sync.WaitGroup
+}
+
+// NewConsumerGroup creates a subscriber for the named group. Groups start at
+// the current end of the outbox the first time they subscribe to a topic.
+func NewConsumerGroup(pool *pgxpool.Pool, name string) *ConsumerGroup {
+	return &ConsumerGroup{
+		pool:      pool,
+		name:      name,
+		interval:  defaultPollInterval,
+		batchSize: defaultBatchSize,
+		closing:   make(chan struct{}),
+	}
+}
+
+// Subscribe consumes events on topic. A trailing "*" subscribes to every
+// topic with that prefix, e.g. "campaign:*" for all campaigns.
+func (g *ConsumerGroup) Subscribe(ctx context.Context, topic string) (<-chan *message.Message, error) {
+	select {
+	case <-g.closing:
+		return nil, ErrConsumerGroupClosed
+	default:
+	}
+
+	_, err := g.pool.Exec(ctx, `
+		INSERT INTO pubsub_consumer_offsets (group_name, topic, last_txid, last_id)
+		VALUES ($1, $2, pg_snapshot_xmin(pg_current_snapshot()), 0)
+		ON CONFLICT (group_name, topic) DO NOTHING
+	`, g.name, topic)
+	if err != nil {
+		return nil, fmt.Errorf("registering consumer group %q: %w", g.name, err)
+	}
+
+	out := make(chan *message.Message)
+	g.wg.Add(1)
+	go func() {
+		defer g.wg.Done()
+		defer close(out)
+		g.consume(ctx, topic, out)
+	}()
+	return out, nil
+}
+
+// Close stops all subscriptions and waits for in-flight batches to finish.
+func (g *ConsumerGroup) Close() error {
+	g.closeOnce.Do(func() { close(g.closing) })
+	g.wg.Wait()
+	return nil
+}
+
+func (g *ConsumerGroup) consume(ctx context.Context, topic string, out chan<- *message.Message) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	go func() {
+		select {
+		case <-g.closing:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	ticker := time.NewTicker(g.interval)
+	defer ticker.Stop()
+
+	for {
+		for {
+			n, err := g.consumeBatch(ctx, topic, out)
+			if err != nil {
+				if ctx.Err() == nil {
+					slog.ErrorContext(ctx, "outbox consumer failed", "error", err, "group", g.name, "topic", topic)
+				}
+				break
+			}
+			if n < g.batchSize {
+				break
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// consumeBatch delivers up to batchSize events past the group's offset and
+// returns how many were acked. It returns 0 if another member holds the lock.
+func (g *ConsumerGroup) consumeBatch(ctx context.Context, topic string, out chan<- *message.Message) (int, error) {
+	tx, err := g.pool.Begin(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("beginning consumer batch: %w", err)
+	}
+	defer tx.Rollback(context.WithoutCancel(ctx))
+
+	var lastTxid string
+	var lastID int64
+	err = tx.QueryRow(ctx, `
+		SELECT last_txid::text, last_id
+		FROM pubsub_consumer_offsets
+		WHERE group_name = $1 AND topic = $2
+		FOR UPDATE SKIP LOCKED
+	`, g.name, topic).Scan(&lastTxid, &lastID)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, fmt.Errorf("locking consumer offset: %w", err)
+	}
+
+	// Only read rows written by transactions older than every transaction
+	// still running; anything newer could still be joined by a row that sorts
+	// before it.
+	rows, err := tx.Query(ctx, `
+		SELECT id, txid::text, topic, message_id, payload, metadata
+		FROM pubsub_outbox
+		WHERE topic LIKE $1
+		  AND (txid, id) > ($2::xid8, $3)
+		  AND txid < pg_snapshot_xmin(pg_current_snapshot())
+		ORDER BY txid, id
+		LIMIT $4
+	`, topicPattern(topic), lastTxid, lastID, g.batchSize)
+	if err != nil {
+		return 0, fmt.Errorf("reading outbox: %w", err)
+	}
+
+	var batch []consumedEvent
+	for rows.Next() {
+		var (
+			p        consumedEvent
+			msgTopic string
+			msgID    string
+			payload  []byte
+			metadata map[string]string
+		)
+		if err := rows.Scan(&p.id, &p.txid, &msgTopic, &msgID, &payload, &metadata); err != nil {
+			rows.Close()
+			return 0, fmt.Errorf("scanning outbox event: %w", err)
+		}
+		p.msg = message.NewMessage(msgID, payload)
+		for k, v := range metadata {
+			p.msg.Metadata.Set(k, v)
+		}
+		p.msg.Metadata.Set(TopicMetadata, msgTopic)
+		batch = append(batch, p)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, fmt.Errorf("reading outbox: %w", err)
+	}
+
+	acked := 0
+deliver:
+	for _, p := range batch {
+		p.msg.SetContext(ctx)
+
+		select {
+		case out <- p.msg:
+		case <-ctx.Done():
+			return 0, ctx.Err()
+		}
+
+		select {
+		case <-p.msg.Acked():
+			acked++
+		case <-p.msg.Nacked():
+			// Keep what was acked; the nacked event is redelivered next poll.
+			break deliver
+		case <-ctx.Done():
+			return 0, ctx.Err()
+		}
+	}
+
+	if acked == 0 {
+		return 0, nil
+	}
+	last := batch[acked-1]
+	if _, err := tx.Exec(ctx, `
+		UPDATE pubsub_consumer_offsets
+		SET last_txid = $3::xid8, last_id = $4, updated_at = NOW()
+		WHERE group_name = $1 AND topic = $2
+	`, g.name, topic, last.txid, last.id); err != nil {
+		return 0, fmt.Errorf("advancing consumer offset: %w", err)
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return 0, fmt.Errorf("committing consumer offset: %w", err)
+	}
+	if acked < len(batch) {
+		// Back off until the next poll rather than redelivering immediately.
+		return 0, nil
+	}
+	return acked, nil
+}
+
+type consumedEvent struct {
+	id   int64
+	txid string
+	msg  *message.Message
+}
+
+// topicPattern converts a subscription topic to a LIKE pattern.
+func topicPattern(topic string) string {
+	prefix, wildcard := strings.CutSuffix(topic, "*")
+	escaped := strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(prefix)
+	if wildcard {
+		return escaped + "%"
+	}
+	return escaped
+}
@@ -0,0 +1,172 @@
+package outbox
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"time"
+
+	"github.com/ThreeDotsLabs/watermill/message"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+var ErrDeadLetterNotFound = errors.New("dead letter not found")
+
+// DeadLetter is a message a consumer gave up handling.
+type DeadLetter struct {
+	ID        int64
+	Consumer  string
+	Topic     string
+	MessageID string
+	Payload   []byte
+	Metadata  map[string]string
+	// Error is why handling last failed, and Attempts how many times the
+	// message was dead-lettered, counting redeliveries after a requeue.
+	Error     string
+	Attempts  int
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
+
+// DeadLetters stores dead-lettered messages in Postgres. It implements
+// pubsub.DeadLetterStore, and requeues messages through the outbox.
+type DeadLetters struct {
+	pool *pgxpool.Pool
+}
+
+func NewDeadLetters(pool *pgxpool.Pool) *DeadLetters {
+	return &DeadLetters{pool: pool}
+}
+
+// DeadLetter stores msg as given up on by consumer. Messages from a
+// ConsumerGroup are stored under the topic they were written to rather than
+// the subscribed one. A message dead-lettered again by the same consumer
+// updates its row.
+func (d *DeadLetters) DeadLetter(ctx context.Context, consumer, topic string, msg *message.Message, cause error) error {
+	metadata := maps.Clone(map[string]string(msg.Metadata))
+	if t := metadata[TopicMetadata]; t != "" {
+		topic = t
+		delete(metadata, TopicMetadata)
+	}
+	encoded, err := json.Marshal(metadata)
+	if err != nil {
+		return fmt.Errorf("encoding dead letter metadata: %w", err)
+	}
+
+	_, err = d.pool.Exec(ctx, `
+		INSERT INTO pubsub_dead_letters (consumer, topic, message_id, payload, metadata, error)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		ON CONFLICT (consumer, message_id) DO UPDATE SET
+			topic = EXCLUDED.topic,
+			payload = EXCLUDED.payload,
+			metadata = EXCLUDED.metadata,
+			error = EXCLUDED.error,
+			attempts = pubsub_dead_letters.attempts + 1,
+			updated_at = NOW()
+	`, consumer, topic, msg.UUID, []byte(msg.Payload), encoded, cause.Error())
+	if err != nil {
+		return fmt.Errorf("storing dead letter: %w", err)
+	}
+	return nil
+}
+
+// List returns up to limit dead letters, most recently failed first. An
+// empty consumer lists every consumer's.
+func (d *DeadLetters) List(ctx context.Context, consumer string, limit int) ([]DeadLetter, error) {
+	rows, err := d.pool.Query(ctx, `
+		SELECT id, consumer, topic, message_id, payload, metadata, error, attempts, created_at, updated_at
+		FROM pubsub_dead_letters
+		WHERE $1 = '' OR consumer = $1
+		ORDER BY updated_at DESC, id DESC
+		LIMIT $2
+	`, consumer, limit)
+	if err != nil {
+		return nil, fmt.Errorf("listing dead letters: %w", err)
+	}
+	letters, err := pgx.CollectRows(rows, scanDeadLetter)
+	if err != nil {
+		return nil, fmt.Errorf("listing dead letters: %w", err)
+	}
+	return letters, nil
+}
+
+// Get returns the dead letter with id.
+func (d *DeadLetters) Get(ctx context.Context, id int64) (DeadLetter, error) {
+	rows, err := d.pool.Query(ctx, `
+		SELECT id, consumer, topic, message_id, payload, metadata, error, attempts, created_at, updated_at
+		FROM pubsub_dead_letters
+		WHERE id = $1
+	`, id)
+	if err != nil {
+		return DeadLetter{}, fmt.Errorf("getting dead letter: %w", err)
+	}
+	letter, err := pgx.CollectExactlyOneRow(rows, scanDeadLetter)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return DeadLetter{}, ErrDeadLetterNotFound
+	}
+	if err != nil {
+		return DeadLetter{}, fmt.Errorf("getting dead letter: %w", err)
+	}
+	return letter, nil
+}
+
+// Requeue removes the dead letter with id and writes its message back to
+// the outbox on its topic, with its original message ID. Every subscriber to
+// the topic receives it again, not only the consumer that gave up on it.
+func (d *DeadLetters) Requeue(ctx context.Context, id int64) error {
+	tx, err := d.pool.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("beginning requeue: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	var (
+		topic, messageID string
+		payload          []byte
+		metadata         map[string]string
+	)
+	err = tx.QueryRow(ctx, `
+		DELETE FROM pubsub_dead_letters
+		WHERE id = $1
+		RETURNING topic, message_id, payload, metadata
+	`, id).Scan(&topic, &messageID, &payload, &metadata)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return ErrDeadLetterNotFound
+	}
+	if err != nil {
+		return fmt.Errorf("removing dead letter: %w", err)
+	}
+
+	msg := message.NewMessage(messageID, payload)
+	for k, v := range metadata {
+		msg.Metadata.Set(k, v)
+	}
+	if err := Insert(ctx, tx, Event{Topic: topic, Message: msg}); err != nil {
+		return err
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return fmt.Errorf("committing requeue: %w", err)
+	}
+	return nil
+}
+
+// Delete discards the dead letter with id.
+func (d *DeadLetters) Delete(ctx context.Context, id int64) error {
+	tag, err := d.pool.Exec(ctx, `DELETE FROM pubsub_dead_letters WHERE id = $1`, id)
+	if err != nil {
+		return fmt.Errorf("deleting dead letter: %w", err)
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrDeadLetterNotFound
+	}
+	return nil
+}
+
+func scanDeadLetter(row pgx.CollectableRow) (DeadLetter, error) {
+	var l DeadLetter
+	err := row.Scan(&l.ID, &l.Consumer, &l.Topic, &l.MessageID, &l.Payload, &l.Metadata, &l.Error, &l.Attempts, &l.CreatedAt, &l.UpdatedAt)
+	return l, err
+}
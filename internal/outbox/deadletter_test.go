@@ -0,0 +1,81 @@
+package outbox
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/ThreeDotsLabs/watermill/message"
+
+	"github.com/cavenine/queryops/internal/testdb"
+)
+
+func TestDeadLetters(t *testing.T) {
+	tdb := testdb.SetupTestDB(t)
+	ctx := context.Background()
+	store := NewDeadLetters(tdb.Pool)
+
+	msg := message.NewMessage("m1", []byte(`{"bad"`))
+	msg.Metadata.Set("event_type", "host_activity")
+	msg.Metadata.Set(TopicMetadata, "host_activity:a")
+
+	// Stored under the topic the message was written to, twice in one row.
+	for _, cause := range []string{"first", "second"} {
+		if err := store.DeadLetter(ctx, "host_timeline", "host_activity:*", msg, errors.New(cause)); err != nil {
+			t.Fatalf("DeadLetter: %v", err)
+		}
+	}
+	if err := store.DeadLetter(ctx, "sse:hosts", "hosts:a", message.NewMessage("m2", []byte("{}")), errors.New("other")); err != nil {
+		t.Fatalf("DeadLetter: %v", err)
+	}
+
+	letters, err := store.List(ctx, "host_timeline", 10)
+	if err != nil {
+		t.Fatalf("List: %v", err)
+	}
+	if len(letters) != 1 {
+		t.Fatalf("letters = %d, want 1", len(letters))
+	}
+	l := letters[0]
+	if l.Topic != "host_activity:a" || l.MessageID != "m1" || l.Error != "second" || l.Attempts != 2 {
+		t.Fatalf("letter = %+v", l)
+	}
+	if _, ok := l.Metadata[TopicMetadata]; ok || l.Metadata["event_type"] != "host_activity" {
+		t.Fatalf("metadata = %v", l.Metadata)
+	}
+	if all, err := store.List(ctx, "", 10); err != nil || len(all) != 2 {
+		t.Fatalf("List all = %d, %v; want 2", len(all), err)
+	}
+
+	if err := store.Requeue(ctx, l.ID); err != nil {
+		t.Fatalf("Requeue: %v", err)
+	}
+	var topic, payload, eventType string
+	err = tdb.Pool.QueryRow(ctx, `
+		SELECT topic, convert_from(payload, 'UTF8'), metadata->>'event_type'
+		FROM pubsub_outbox WHERE message_id = 'm1' AND published_at IS NULL
+	`).Scan(&topic, &payload, &eventType)
+	if err != nil {
+		t.Fatalf("reading requeued event: %v", err)
+	}
+	if topic != "host_activity:a" || payload != `{"bad"` || eventType != "host_activity" {
+		t.Fatalf("requeued = %s %s %s", topic, payload, eventType)
+	}
+	if _, err := store.Get(ctx, l.ID); !errors.Is(err, ErrDeadLetterNotFound) {
+		t.Fatalf("Get after requeue: %v, want ErrDeadLetterNotFound", err)
+	}
+	if err := store.Requeue(ctx, l.ID); !errors.Is(err, ErrDeadLetterNotFound) {
+		t.Fatalf("Requeue again: %v, want ErrDeadLetterNotFound", err)
+	}
+
+	other, err := store.List(ctx, "sse:hosts", 10)
+	if err != nil || len(other) != 1 {
+		t.Fatalf("List sse:hosts = %d, %v", len(other), err)
+	}
+	if err := store.Delete(ctx, other[0].ID); err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+	if err := store.Delete(ctx, other[0].ID); !errors.Is(err, ErrDeadLetterNotFound) {
+		t.Fatalf("Delete again: %v, want ErrDeadLetterNotFound", err)
+	}
+}
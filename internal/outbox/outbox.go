@@ -0,0 +1,213 @@
+// Package outbox implements the transactional outbox pattern for pub/sub
+// events: events are written to Postgres in the same transaction as the state
+// change they describe, and a relay publishes them afterwards. Delivery is
+// at-least-once; subscribers must tolerate duplicates.
+package outbox
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/ThreeDotsLabs/watermill/message"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+const (
+	defaultPollInterval = time.Second
+	defaultBatchSize    = 100
+	publishedRetention  = 24 * time.Hour
+)
+
+// Event is a message destined for topic.
+type Event struct {
+	Topic   string
+	Message *message.Message
+}
+
+// Insert writes events to the outbox within tx. They are published once tx
+// commits and the relay picks them up.
+func Insert(ctx context.Context, tx pgx.Tx, events ...Event) error {
+	for _, e := range events {
+		metadata, err := json.Marshal(e.Message.Metadata)
+		if err != nil {
+			return fmt.Errorf("encoding outbox metadata: %w", err)
+		}
+		_, err = tx.Exec(ctx, `
+			INSERT INTO pubsub_outbox (topic, message_id, payload, metadata)
+			VALUES ($1, $2, $3, $4)
+		`, e.Topic, e.Message.UUID, []byte(e.Message.Payload), metadata)
+		if err != nil {
+			return fmt.Errorf("inserting outbox event: %w", err)
+		}
+	}
+	return nil
+}
+
+// Relay publishes outbox rows in insertion order and marks them published.
+// Multiple relays may run concurrently; rows are claimed with SKIP LOCKED.
+type Relay struct {
+	pool      *pgxpool.Pool
+	publisher message.Publisher
+	interval  time.Duration
+	batchSize int
+	wake      chan struct{}
+}
+
+func NewRelay(pool *pgxpool.Pool, publisher message.Publisher) *Relay {
+	return &Relay{
+		pool:      pool,
+		publisher: publisher,
+		interval:  defaultPollInterval,
+		batchSize: defaultBatchSize,
+		wake:      make(chan struct{}, 1),
+	}
+}
+
+// Notify asks the relay to run immediately instead of waiting for the next
+// poll. It never blocks.
+func (r *Relay) Notify() {
+	select {
+	case r.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Run relays events until ctx is cancelled.
+func (r *Relay) Run(ctx context.Context) {
+	ticker := time.NewTicker(r.interval)
+	defer ticker.Stop()
+
+	lastPrune := time.Now()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-r.wake:
+		}
+
+		for {
+			n, err := r.relayBatch(ctx)
+			if err != nil {
+				if ctx.Err() == nil {
+					slog.ErrorContext(ctx, "outbox relay failed", "error", err)
+				}
+				break
+			}
+			if n < r.batchSize {
+				break
+			}
+		}
+
+		if time.Since(lastPrune) > time.Hour {
+			lastPrune = time.Now()
+			if err := r.prune(ctx); err != nil && ctx.Err() == nil {
+				slog.WarnContext(ctx, "outbox prune failed", "error", err)
+			}
+		}
+	}
+}
+
+// relayBatch publishes up to batchSize pending events and returns how many
+// rows it claimed.
+func (r *Relay) relayBatch(ctx context.Context) (int, error) {
+	tx, err := r.pool.Begin(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("beginning outbox batch: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	rows, err := tx.Query(ctx, `
+		SELECT id, topic, message_id, payload, metadata
+		FROM pubsub_outbox
+		WHERE published_at IS NULL
+		ORDER BY id
+		LIMIT $1
+		FOR UPDATE SKIP LOCKED
+	`, r.batchSize)
+	if err != nil {
+		return 0, fmt.Errorf("claiming outbox events: %w", err)
+	}
+
+	type pending struct {
+		id    int64
+		topic string
+		msg   *message.Message
+	}
+	var batch []pending
+	for rows.Next() {
+		var (
+			p        pending
+			msgID    string
+			payload  []byte
+			metadata map[string]string
+		)
+		if err := rows.Scan(&p.id, &p.topic, &msgID, &payload, &metadata); err != nil {
+			rows.Close()
+			return 0, fmt.Errorf("scanning outbox event: %w", err)
+		}
+		p.msg = message.NewMessage(msgID, payload)
+		for k, v := range metadata {
+			p.msg.Metadata.Set(k, v)
+		}
+		batch = append(batch, p)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, fmt.Errorf("claiming outbox events: %w", err)
+	}
+
+	var published []int64
+	for _, p := range batch {
+		if err := r.publisher.Publish(p.topic, p.msg); err != nil {
+			// Stop at the first failure to preserve ordering; the rest are
+			// retried on the next run.
+			if _, uerr := tx.Exec(ctx, `
+				UPDATE pubsub_outbox SET attempts = attempts + 1, last_error = $2 WHERE id = $1
+			`, p.id, err.Error()); uerr != nil {
+				return 0, fmt.Errorf("recording outbox failure: %w", uerr)
+			}
+			slog.WarnContext(ctx, "failed to publish outbox event", "error", err, "topic", p.topic, "id", p.id)
+			break
+		}
+		published = append(published, p.id)
+	}
+
+	if len(published) > 0 {
+		if _, err := tx.Exec(ctx, `
+			UPDATE pubsub_outbox SET published_at = NOW(), attempts = attempts + 1 WHERE id = ANY($1)
+		`, published); err != nil {
+			return 0, fmt.Errorf("marking outbox events published: %w", err)
+		}
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return 0, fmt.Errorf("committing outbox batch: %w", err)
+	}
+	if len(published) < len(batch) {
+		// Don't spin on a failing publisher; wait for the next tick.
+		return 0, nil
+	}
+	return len(batch), nil
+}
+
+// prune deletes published events past retention that every consumer group
+// has already consumed.
+func (r *Relay) prune(ctx context.Context) error {
+	_, err := r.pool.Exec(ctx, `
+		DELETE FROM pubsub_outbox e
+		WHERE e.published_at IS NOT NULL AND e.published_at < $1
+		  AND NOT EXISTS (
+			SELECT 1 FROM pubsub_consumer_offsets o
+			WHERE (o.last_txid, o.last_id) < (e.txid, e.id)
+		  )
+	`, time.Now().Add(-publishedRetention))
+	if err != nil {
+		return fmt.Errorf("pruning outbox: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,284 @@
+package outbox
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/ThreeDotsLabs/watermill"
+	"github.com/ThreeDotsLabs/watermill/message"
+
+	"github.com/cavenine/queryops/internal/testdb"
+)
+
+type recordingPublisher struct {
+	mu   sync.Mutex
+	fail bool
+	sent []string
+}
+
+func (p *recordingPublisher) Publish(topic string, msgs ...*message.Message) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.fail {
+		return errors.New("broker unavailable")
+	}
+	for _, m := range msgs {
+		p.sent = append(p.sent, topic+":"+string(m.Payload)+":"+m.Metadata.Get("event_type"))
+	}
+	return nil
+}
+
+func (p *recordingPublisher) Close() error { return nil }
+
+func TestRelay_PublishesCommittedEventsOnly(t *testing.T) {
+	tdb := testdb.SetupTestDB(t)
+	ctx := context.Background()
+
+	newEvent := func(topic, payload string) Event {
+		msg := message.NewMessage("", []byte(payload))
+		msg.UUID = topic + payload
+		msg.Metadata.Set("event_type", "test")
+		return Event{Topic: topic, Message: msg}
+	}
+
+	// Rolled back: must never be published.
+	tx, err := tdb.Pool.Begin(ctx)
+	if err != nil {
+		t.Fatalf("begin: %v", err)
+	}
+	if err := Insert(ctx, tx, newEvent("a", "lost")); err != nil {
+		t.Fatalf("Insert: %v", err)
+	}
+	if err := tx.Rollback(ctx); err != nil {
+		t.Fatalf("rollback: %v", err)
+	}
+
+	tx, err = tdb.Pool.Begin(ctx)
+	if err != nil {
+		t.Fatalf("begin: %v", err)
+	}
+	if err := Insert(ctx, tx, newEvent("a", "1"), newEvent("b", "2")); err != nil {
+		t.Fatalf("Insert: %v", err)
+	}
+	if err := tx.Commit(ctx); err != nil {
+		t.Fatalf("commit: %v", err)
+	}
+
+	pub := &recordingPublisher{fail: true}
+	relay := NewRelay(tdb.Pool, pub)
+
+	if _, err := relay.relayBatch(ctx); err != nil {
+		t.Fatalf("relayBatch (failing publisher): %v", err)
+	}
+	if len(pub.sent) != 0 {
+		t.Fatalf("sent = %v, want none", pub.sent)
+	}
+
+	pub.fail = false
+	n, err := relay.relayBatch(ctx)
+	if err != nil {
+		t.Fatalf("relayBatch: %v", err)
+	}
+	if n != 2 {
+		t.Fatalf("relayed %d, want 2", n)
+	}
+	want := []string{"a:1:test", "b:2:test"}
+	if len(pub.sent) != len(want) || pub.sent[0] != want[0] || pub.sent[1] != want[1] {
+		t.Fatalf("sent = %v, want %v", pub.sent, want)
+	}
+
+	// Published rows aren't sent again.
+	if n, err := relay.relayBatch(ctx); err != nil || n != 0 {
+		t.Fatalf("second relayBatch = %d, %v; want 0, nil", n, err)
+	}
+}
+
+func TestConsumerGroup_DeliversOncePerGroup(t *testing.T) {
+	tdb := testdb.SetupTestDB(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	insert := func(topic, payload string) {
+		t.Helper()
+		tx, err := tdb.Pool.Begin(ctx)
+		if err != nil {
+			t.Fatalf("begin: %v", err)
+		}
+		if err := Insert(ctx, tx, Event{Topic: topic, Message: message.NewMessage(payload, []byte(payload))}); err != nil {
+			t.Fatalf("Insert: %v", err)
+		}
+		if err := tx.Commit(ctx); err != nil {
+			t.Fatalf("commit: %v", err)
+		}
+	}
+
+	// Written before the group existed: not delivered.
+	insert("campaign:old", "old")
+
+	members := []*ConsumerGroup{NewConsumerGroup(tdb.Pool, "webhooks"), NewConsumerGroup(tdb.Pool, "webhooks")}
+	var channels []<-chan *message.Message
+	for _, g := range members {
+		g.interval = 10 * time.Millisecond
+		defer g.Close()
+		ch, err := g.Subscribe(ctx, "campaign:*")
+		if err != nil {
+			t.Fatalf("Subscribe: %v", err)
+		}
+		channels = append(channels, ch)
+	}
+
+	insert("campaign:a", "1")
+	insert("query_results:a", "ignored")
+	insert("campaign:b", "2")
+
+	received := make(chan string, 10)
+	var nacked atomic.Bool
+	for _, ch := range channels {
+		go func() {
+			for msg := range ch {
+				if string(msg.Payload) == "1" && nacked.CompareAndSwap(false, true) {
+					msg.Nack()
+					continue
+				}
+				received <- string(msg.Payload)
+				msg.Ack()
+			}
+		}()
+	}
+
+	var got []string
+	timeout := time.After(5 * time.Second)
+	for len(got) < 2 {
+		select {
+		case p := <-received:
+			got = append(got, p)
+		case <-timeout:
+			t.Fatalf("received %v, want [1 2]", got)
+		}
+	}
+	if got[0] != "1" || got[1] != "2" {
+		t.Fatalf("received %v, want [1 2]", got)
+	}
+
+	select {
+	case p := <-received:
+		t.Fatalf("unexpected redelivery of %q", p)
+	case <-time.After(200 * time.Millisecond):
+	}
+}
+
+func TestTopicPattern(t *testing.T) {
+	tests := map[string]string{
+		"campaign:*":        `campaign:%`,
+		"query_results:abc": `query\_results:abc`,
+		"host_enrollments":  `host\_enrollments`,
+		"weird%topic*":      `weird\%topic%`,
+	}
+	for topic, want := range tests {
+		if got := topicPattern(topic); got != want {
+			t.Errorf("topicPattern(%q) = %q, want %q", topic, got, want)
+		}
+	}
+}
+
+type discardPublisher struct{}
+
+func (discardPublisher) Publish(string, ...*message.Message) error { return nil }
+func (discardPublisher) Close() error                              { return nil }
+
+// BenchmarkOutbox measures each leg of an event through the Postgres outbox:
+// writing it with the state change, relaying it to the broker, and a durable
+// consumer group reading it back.
+func BenchmarkOutbox(b *testing.B) {
+	tdb := testdb.SetupTestDB(b)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	insert := func(b *testing.B, n int) {
+		b.Helper()
+		tx, err := tdb.Pool.Begin(ctx)
+		if err != nil {
+			b.Fatalf("begin: %v", err)
+		}
+		events := make([]Event, n)
+		for i := range events {
+			msg := message.NewMessage(watermill.NewUUID(), []byte(`{"host_id":"3f1d7c9a-2b4e-4c8f-9a6d-5e0b1c2d3e4f","status":"completed","row_count":12}`))
+			msg.Metadata.Set("event_type", "query_result")
+			events[i] = Event{Topic: "campaign:bench", Message: msg}
+		}
+		if err := Insert(ctx, tx, events...); err != nil {
+			b.Fatalf("Insert: %v", err)
+		}
+		if err := tx.Commit(ctx); err != nil {
+			b.Fatalf("commit: %v", err)
+		}
+	}
+
+	for _, n := range []int{1, 10, 100} {
+		b.Run(fmt.Sprintf("Insert/events=%d", n), func(b *testing.B) {
+			for b.Loop() {
+				insert(b, n)
+			}
+			b.ReportMetric(float64(n)*float64(b.N)/b.Elapsed().Seconds(), "events/s")
+		})
+	}
+
+	const backlog = 1000
+
+	b.Run("Relay", func(b *testing.B) {
+		relay := NewRelay(tdb.Pool, discardPublisher{})
+		// Events from the insert benchmarks are relayed first, untimed.
+		for {
+			n, err := relay.relayBatch(ctx)
+			if err != nil {
+				b.Fatalf("relayBatch: %v", err)
+			}
+			if n == 0 {
+				break
+			}
+		}
+		for b.Loop() {
+			b.StopTimer()
+			insert(b, backlog)
+			b.StartTimer()
+
+			for relayed := 0; relayed < backlog; {
+				n, err := relay.relayBatch(ctx)
+				if err != nil {
+					b.Fatalf("relayBatch: %v", err)
+				}
+				relayed += n
+			}
+		}
+		b.ReportMetric(backlog*float64(b.N)/b.Elapsed().Seconds(), "events/s")
+	})
+
+	b.Run("ConsumerGroup", func(b *testing.B) {
+		g := NewConsumerGroup(tdb.Pool, "bench")
+		g.interval = 10 * time.Millisecond
+		defer g.Close()
+		messages, err := g.Subscribe(ctx, "campaign:*")
+		if err != nil {
+			b.Fatalf("Subscribe: %v", err)
+		}
+		for b.Loop() {
+			b.StopTimer()
+			insert(b, backlog)
+			b.StartTimer()
+
+			for range backlog {
+				msg, ok := <-messages
+				if !ok {
+					b.Fatal("subscription closed")
+				}
+				msg.Ack()
+			}
+		}
+		b.ReportMetric(backlog*float64(b.N)/b.Elapsed().Seconds(), "events/s")
+	})
+}
@@ -0,0 +1,222 @@
+package parquet
+
+import (
+	"bytes"
+	"encoding/binary"
+)
+
+// Values from the Parquet format's Thrift definitions.
+const (
+	typeInt64     = 2
+	typeByteArray = 6
+
+	repetitionRequired = 0
+	repetitionOptional = 1
+
+	convertedUTF8            = 0
+	convertedTimestampMicros = 10
+	convertedJSON            = 19
+
+	encodingPlain = 0
+	encodingRLE   = 3
+
+	codecGzip = 2
+
+	pageTypeData = 0
+)
+
+// columnMeta is what the footer records about a written column chunk.
+type columnMeta struct {
+	column           Column
+	numValues        int64
+	uncompressedSize int64
+	compressedSize   int64
+	dataPageOffset   int64
+}
+
+func physicalType(t Type) int32 {
+	if t == Int64 || t == Timestamp {
+		return typeInt64
+	}
+	return typeByteArray
+}
+
+func convertedType(t Type) (int32, bool) {
+	switch t {
+	case String:
+		return convertedUTF8, true
+	case JSON:
+		return convertedJSON, true
+	case Timestamp:
+		return convertedTimestampMicros, true
+	}
+	return 0, false
+}
+
+// pageHeader encodes the PageHeader of a data page of numValues values.
+func pageHeader(numValues, uncompressed, compressed int) []byte {
+	var t thriftWriter
+	t.i32(1, pageTypeData)
+	t.i32(2, int32(uncompressed))
+	t.i32(3, int32(compressed))
+	t.beginStruct(5) // DataPageHeader
+	t.i32(1, int32(numValues))
+	t.i32(2, encodingPlain)
+	t.i32(3, encodingRLE)
+	t.i32(4, encodingRLE)
+	t.endStruct()
+	t.stop()
+	return t.buf.Bytes()
+}
+
+// fileMetadata encodes the footer's FileMetaData for one row group.
+func fileMetadata(columns []Column, metas []columnMeta, numRows, totalSize int64) []byte {
+	var t thriftWriter
+	t.i32(1, 1) // version
+
+	t.list(2, thriftStruct, len(columns)+1) // schema
+	t.beginElement()
+	t.binary(4, "schema")
+	t.i32(5, int32(len(columns)))
+	t.endStruct()
+	for _, col := range columns {
+		t.beginElement()
+		t.i32(1, physicalType(col.Type))
+		if col.Optional {
+			t.i32(3, repetitionOptional)
+		} else {
+			t.i32(3, repetitionRequired)
+		}
+		t.binary(4, col.Name)
+		if ct, ok := convertedType(col.Type); ok {
+			t.i32(6, ct)
+		}
+		t.endStruct()
+	}
+
+	t.i64(3, numRows)
+
+	t.list(4, thriftStruct, 1) // row_groups
+	t.beginElement()
+	t.list(1, thriftStruct, len(metas)) // columns
+	for _, m := range metas {
+		t.beginElement()
+		t.i64(2, m.dataPageOffset) // file_offset
+		t.beginStruct(3)           // ColumnMetaData
+		t.i32(1, physicalType(m.column.Type))
+		t.list(2, thriftI32, 2)
+		t.elementI32(encodingPlain)
+		t.elementI32(encodingRLE)
+		t.list(3, thriftBinary, 1)
+		t.elementBinary(m.column.Name)
+		t.i32(4, codecGzip)
+		t.i64(5, m.numValues)
+		t.i64(6, m.uncompressedSize)
+		t.i64(7, m.compressedSize)
+		t.i64(9, m.dataPageOffset)
+		t.endStruct()
+		t.endStruct()
+	}
+	t.i64(2, totalSize)
+	t.i64(3, numRows)
+	t.endStruct()
+
+	t.binary(6, createdBy)
+	t.stop()
+	return t.buf.Bytes()
+}
+
+// Thrift compact protocol type codes.
+const (
+	thriftI32    = 5
+	thriftI64    = 6
+	thriftBinary = 8
+	thriftList   = 9
+	thriftStruct = 12
+)
+
+// thriftWriter encodes Thrift structs with the compact protocol, which
+// Parquet uses for its page headers and footer. Field IDs are written as
+// deltas from the previous field of the same struct.
+type thriftWriter struct {
+	buf    bytes.Buffer
+	lastID int16
+	// outer holds the last field ID of each enclosing struct.
+	outer []int16
+}
+
+func (t *thriftWriter) field(id int16, typ byte) {
+	if delta := id - t.lastID; delta > 0 && delta <= 15 {
+		t.buf.WriteByte(byte(delta)<<4 | typ)
+	} else {
+		t.buf.WriteByte(typ)
+		t.varint(int64(id))
+	}
+	t.lastID = id
+}
+
+func (t *thriftWriter) varint(n int64) {
+	t.buf.Write(binary.AppendVarint(nil, n))
+}
+
+func (t *thriftWriter) uvarint(n uint64) {
+	t.buf.Write(binary.AppendUvarint(nil, n))
+}
+
+func (t *thriftWriter) i32(id int16, v int32) {
+	t.field(id, thriftI32)
+	t.varint(int64(v))
+}
+
+func (t *thriftWriter) i64(id int16, v int64) {
+	t.field(id, thriftI64)
+	t.varint(v)
+}
+
+func (t *thriftWriter) binary(id int16, s string) {
+	t.field(id, thriftBinary)
+	t.elementBinary(s)
+}
+
+// list writes the header of a list field of n elements.
+func (t *thriftWriter) list(id int16, elemType byte, n int) {
+	t.field(id, thriftList)
+	if n < 15 {
+		t.buf.WriteByte(byte(n)<<4 | elemType)
+		return
+	}
+	t.buf.WriteByte(0xf0 | elemType)
+	t.uvarint(uint64(n))
+}
+
+func (t *thriftWriter) elementI32(v int32) {
+	t.varint(int64(v))
+}
+
+func (t *thriftWriter) elementBinary(s string) {
+	t.uvarint(uint64(len(s)))
+	t.buf.WriteString(s)
+}
+
+// beginStruct starts a struct field; beginElement starts a struct list
+// element. Either is closed with endStruct.
+func (t *thriftWriter) beginStruct(id int16) {
+	t.field(id, thriftStruct)
+	t.beginElement()
+}
+
+func (t *thriftWriter) beginElement() {
+	t.outer = append(t.outer, t.lastID)
+	t.lastID = 0
+}
+
+func (t *thriftWriter) endStruct() {
+	t.stop()
+	t.lastID = t.outer[len(t.outer)-1]
+	t.outer = t.outer[:len(t.outer)-1]
+}
+
+// stop ends the current struct.
+func (t *thriftWriter) stop() {
+	t.buf.WriteByte(0)
+}
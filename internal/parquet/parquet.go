@@ -0,0 +1,262 @@
+// Package parquet writes Apache Parquet files, covering what QueryOps exports
+// need: flat schemas of string, JSON, integer, and timestamp columns, any of
+// which may be optional. Each file is one row group with one gzip-compressed,
+// PLAIN-encoded data page per column, which DuckDB, Athena, Spark, and
+// pyarrow all read.
+//
+// Rows are buffered in memory until Bytes is called, so callers bound file
+// size by starting a new Writer every so many rows.
+package parquet
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// magic opens and closes every Parquet file.
+const magic = "PAR1"
+
+// createdBy is recorded in the file footer.
+const createdBy = "queryops"
+
+// Type is a column's type.
+type Type int
+
+const (
+	// String is UTF-8 text.
+	String Type = iota
+	// JSON is a JSON document, stored as text.
+	JSON
+	// Int64 is a signed 64-bit integer.
+	Int64
+	// Timestamp is an instant, stored as microseconds since the Unix epoch
+	// in UTC.
+	Timestamp
+)
+
+// Column describes a column. Optional columns accept nil values.
+type Column struct {
+	Name     string
+	Type     Type
+	Optional bool
+}
+
+// ErrColumnCount is returned by Append for a row with the wrong number of
+// values.
+var ErrColumnCount = errors.New("parquet: wrong number of values")
+
+// Writer collects rows and encodes them as a Parquet file.
+type Writer struct {
+	columns []Column
+	chunks  []columnChunk
+	rows    int
+}
+
+// columnChunk accumulates one column's values and definition levels.
+type columnChunk struct {
+	values bytes.Buffer
+	// defined has one entry per row of an optional column: whether the
+	// row's value is non-null.
+	defined []bool
+}
+
+// NewWriter returns a Writer for rows of columns.
+func NewWriter(columns []Column) *Writer {
+	return &Writer{
+		columns: columns,
+		chunks:  make([]columnChunk, len(columns)),
+	}
+}
+
+// Rows returns how many rows have been appended.
+func (w *Writer) Rows() int {
+	return w.rows
+}
+
+// Size returns roughly how many bytes the appended values take up before
+// compression.
+func (w *Writer) Size() int {
+	var n int
+	for i := range w.chunks {
+		n += w.chunks[i].values.Len()
+	}
+	return n
+}
+
+// Append adds a row, one value per column in order. String and JSON columns
+// take a string, []byte, or *string; Int64 columns an int, int64, or *int64;
+// Timestamp columns a time.Time or *time.Time. Optional columns also take
+// nil, and nil pointers are null.
+func (w *Writer) Append(values ...any) error {
+	if len(values) != len(w.columns) {
+		return fmt.Errorf("%w: got %d, want %d", ErrColumnCount, len(values), len(w.columns))
+	}
+	// Validate the whole row before writing any of it, so a bad value
+	// doesn't leave the columns with different row counts.
+	encoded := make([][]byte, len(values))
+	for i, v := range values {
+		b, err := encodeValue(w.columns[i], v)
+		if err != nil {
+			return err
+		}
+		encoded[i] = b
+	}
+	for i, b := range encoded {
+		c := &w.chunks[i]
+		if w.columns[i].Optional {
+			c.defined = append(c.defined, b != nil)
+		}
+		c.values.Write(b)
+	}
+	w.rows++
+	return nil
+}
+
+// encodeValue PLAIN-encodes v for col, or returns nil for a null.
+func encodeValue(col Column, v any) ([]byte, error) {
+	v = deref(v)
+	if v == nil {
+		if !col.Optional {
+			return nil, fmt.Errorf("parquet: column %q: null in a required column", col.Name)
+		}
+		return nil, nil
+	}
+
+	switch col.Type {
+	case String, JSON:
+		var s []byte
+		switch v := v.(type) {
+		case string:
+			s = []byte(v)
+		case []byte:
+			s = v
+		default:
+			return nil, fmt.Errorf("parquet: column %q: %T is not a string", col.Name, v)
+		}
+		b := binary.LittleEndian.AppendUint32(nil, uint32(len(s)))
+		return append(b, s...), nil
+	case Int64:
+		var n int64
+		switch v := v.(type) {
+		case int:
+			n = int64(v)
+		case int64:
+			n = v
+		default:
+			return nil, fmt.Errorf("parquet: column %q: %T is not an integer", col.Name, v)
+		}
+		return binary.LittleEndian.AppendUint64(nil, uint64(n)), nil
+	case Timestamp:
+		t, ok := v.(time.Time)
+		if !ok {
+			return nil, fmt.Errorf("parquet: column %q: %T is not a time", col.Name, v)
+		}
+		return binary.LittleEndian.AppendUint64(nil, uint64(t.UnixMicro())), nil
+	default:
+		return nil, fmt.Errorf("parquet: column %q: unknown type %d", col.Name, col.Type)
+	}
+}
+
+// deref returns what v points to, or nil for a nil pointer.
+func deref(v any) any {
+	switch v := v.(type) {
+	case *string:
+		if v == nil {
+			return nil
+		}
+		return *v
+	case *int64:
+		if v == nil {
+			return nil
+		}
+		return *v
+	case *time.Time:
+		if v == nil {
+			return nil
+		}
+		return *v
+	}
+	return v
+}
+
+// Bytes encodes the appended rows as a Parquet file.
+func (w *Writer) Bytes() ([]byte, error) {
+	var file bytes.Buffer
+	file.WriteString(magic)
+
+	metas := make([]columnMeta, len(w.columns))
+	var totalSize int64
+	for i, col := range w.columns {
+		offset := int64(file.Len())
+		page, uncompressed, err := w.page(i)
+		if err != nil {
+			return nil, fmt.Errorf("parquet: column %q: %w", col.Name, err)
+		}
+		header := pageHeader(w.rows, uncompressed, len(page))
+		file.Write(header)
+		file.Write(page)
+
+		metas[i] = columnMeta{
+			column:           col,
+			numValues:        int64(w.rows),
+			uncompressedSize: int64(len(header) + uncompressed),
+			compressedSize:   int64(len(header) + len(page)),
+			dataPageOffset:   offset,
+		}
+		totalSize += metas[i].uncompressedSize
+	}
+
+	footer := fileMetadata(w.columns, metas, int64(w.rows), totalSize)
+	file.Write(footer)
+	file.Write(binary.LittleEndian.AppendUint32(nil, uint32(len(footer))))
+	file.WriteString(magic)
+	return file.Bytes(), nil
+}
+
+// page returns column i's data page, compressed, and its uncompressed size:
+// the definition levels of an optional column, then its non-null values.
+func (w *Writer) page(i int) ([]byte, int, error) {
+	c := &w.chunks[i]
+
+	var raw bytes.Buffer
+	if w.columns[i].Optional {
+		levels := encodeLevels(c.defined)
+		raw.Write(binary.LittleEndian.AppendUint32(nil, uint32(len(levels))))
+		raw.Write(levels)
+	}
+	raw.Write(c.values.Bytes())
+
+	var compressed bytes.Buffer
+	zw := gzip.NewWriter(&compressed)
+	if _, err := zw.Write(raw.Bytes()); err != nil {
+		return nil, 0, err
+	}
+	if err := zw.Close(); err != nil {
+		return nil, 0, err
+	}
+	return compressed.Bytes(), raw.Len(), nil
+}
+
+// encodeLevels encodes definition levels of bit width 1 with the RLE
+// encoding, one run per stretch of equal levels.
+func encodeLevels(defined []bool) []byte {
+	var b []byte
+	for i := 0; i < len(defined); {
+		j := i
+		for j < len(defined) && defined[j] == defined[i] {
+			j++
+		}
+		b = binary.AppendUvarint(b, uint64(j-i)<<1)
+		if defined[i] {
+			b = append(b, 1)
+		} else {
+			b = append(b, 0)
+		}
+		i = j
+	}
+	return b
+}
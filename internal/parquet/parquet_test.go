@@ -0,0 +1,178 @@
+package parquet
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"errors"
+	"io"
+	"testing"
+	"time"
+)
+
+func TestWriter_Bytes(t *testing.T) {
+	columns := []Column{
+		{Name: "name", Type: String},
+		{Name: "columns", Type: JSON},
+		{Name: "count", Type: Int64},
+		{Name: "timestamp", Type: Timestamp, Optional: true},
+	}
+	at := time.Date(2026, 2, 9, 12, 30, 0, 0, time.UTC)
+
+	w := NewWriter(columns)
+	if err := w.Append("users", []byte(`{"uid":"0"}`), 3, at); err != nil {
+		t.Fatalf("Append: %v", err)
+	}
+	if err := w.Append("groups", `{}`, int64(-1), (*time.Time)(nil)); err != nil {
+		t.Fatalf("Append: %v", err)
+	}
+	if err := w.Append("users", `{}`, 1, nil); err != nil {
+		t.Fatalf("Append: %v", err)
+	}
+	if err := w.Append("x", `{}`); !errors.Is(err, ErrColumnCount) {
+		t.Fatalf("Append(short row) = %v, want ErrColumnCount", err)
+	}
+	if err := w.Append(nil, `{}`, 1, at); err == nil {
+		t.Fatalf("Append accepted a null in a required column")
+	}
+	if w.Rows() != 3 {
+		t.Fatalf("Rows = %d, want 3", w.Rows())
+	}
+
+	file, err := w.Bytes()
+	if err != nil {
+		t.Fatalf("Bytes: %v", err)
+	}
+	if !bytes.HasPrefix(file, []byte(magic)) || !bytes.HasSuffix(file, []byte(magic)) {
+		t.Fatalf("file is not framed by %q", magic)
+	}
+	footerLen := int(binary.LittleEndian.Uint32(file[len(file)-8:]))
+	footer := file[len(file)-8-footerLen : len(file)-8]
+	meta := readStruct(t, bytes.NewReader(footer))
+
+	if got := meta[3]; got != int64(3) {
+		t.Fatalf("num_rows = %v, want 3", got)
+	}
+	schema := meta[2].([]any)
+	if len(schema) != len(columns)+1 {
+		t.Fatalf("schema has %d elements, want %d", len(schema), len(columns)+1)
+	}
+	for i, col := range columns {
+		el := schema[i+1].(map[int16]any)
+		if string(el[4].([]byte)) != col.Name {
+			t.Fatalf("schema[%d] name = %s, want %s", i+1, el[4], col.Name)
+		}
+	}
+
+	rowGroup := meta[4].([]any)[0].(map[int16]any)
+	chunks := rowGroup[1].([]any)
+	pages := make([][]byte, len(chunks))
+	for i, c := range chunks {
+		md := c.(map[int16]any)[3].(map[int16]any)
+		offset := md[9].(int64)
+		r := bytes.NewReader(file[offset:])
+		header := readStruct(t, r)
+		compressed := make([]byte, header[3].(int64))
+		if _, err := io.ReadFull(r, compressed); err != nil {
+			t.Fatalf("reading page %d: %v", i, err)
+		}
+		zr, err := gzip.NewReader(bytes.NewReader(compressed))
+		if err != nil {
+			t.Fatalf("decompressing page %d: %v", i, err)
+		}
+		page, err := io.ReadAll(zr)
+		if err != nil {
+			t.Fatalf("decompressing page %d: %v", i, err)
+		}
+		if int64(len(page)) != header[2].(int64) {
+			t.Fatalf("page %d is %d bytes, header says %d", i, len(page), header[2])
+		}
+		pages[i] = page
+	}
+
+	wantNames := []byte("\x05\x00\x00\x00users\x06\x00\x00\x00groups\x05\x00\x00\x00users")
+	if !bytes.Equal(pages[0], wantNames) {
+		t.Fatalf("name page = %q, want %q", pages[0], wantNames)
+	}
+	if got := int64(binary.LittleEndian.Uint64(pages[2][8:])); got != -1 {
+		t.Fatalf("second count = %d, want -1", got)
+	}
+	// Definition levels: one defined value, then two nulls, then the value.
+	levels := []byte{1 << 1, 1, 2 << 1, 0}
+	wantTimestamp := binary.LittleEndian.AppendUint32(nil, uint32(len(levels)))
+	wantTimestamp = append(wantTimestamp, levels...)
+	wantTimestamp = binary.LittleEndian.AppendUint64(wantTimestamp, uint64(at.UnixMicro()))
+	if !bytes.Equal(pages[3], wantTimestamp) {
+		t.Fatalf("timestamp page = %x, want %x", pages[3], wantTimestamp)
+	}
+}
+
+// readStruct decodes a compact protocol struct into its fields by ID, with
+// integers as int64, binaries as []byte, lists as []any, and structs as
+// map[int16]any.
+func readStruct(t *testing.T, r *bytes.Reader) map[int16]any {
+	t.Helper()
+	fields := map[int16]any{}
+	var last int16
+	for {
+		b, err := r.ReadByte()
+		if err != nil {
+			t.Fatalf("reading field header: %v", err)
+		}
+		if b == 0 {
+			return fields
+		}
+		id := last + int16(b>>4)
+		if b>>4 == 0 {
+			n, err := binary.ReadVarint(r)
+			if err != nil {
+				t.Fatalf("reading field id: %v", err)
+			}
+			id = int16(n)
+		}
+		fields[id] = readValue(t, r, b&0x0f)
+		last = id
+	}
+}
+
+func readValue(t *testing.T, r *bytes.Reader, typ byte) any {
+	t.Helper()
+	switch typ {
+	case thriftI32, thriftI64:
+		n, err := binary.ReadVarint(r)
+		if err != nil {
+			t.Fatalf("reading integer: %v", err)
+		}
+		return n
+	case thriftBinary:
+		n, err := binary.ReadUvarint(r)
+		if err != nil {
+			t.Fatalf("reading binary length: %v", err)
+		}
+		b := make([]byte, n)
+		if _, err := io.ReadFull(r, b); err != nil {
+			t.Fatalf("reading binary: %v", err)
+		}
+		return b
+	case thriftList:
+		h, err := r.ReadByte()
+		if err != nil {
+			t.Fatalf("reading list header: %v", err)
+		}
+		n := uint64(h >> 4)
+		if n == 15 {
+			if n, err = binary.ReadUvarint(r); err != nil {
+				t.Fatalf("reading list size: %v", err)
+			}
+		}
+		list := make([]any, n)
+		for i := range list {
+			list[i] = readValue(t, r, h&0x0f)
+		}
+		return list
+	case thriftStruct:
+		return readStruct(t, r)
+	}
+	t.Fatalf("unexpected thrift type %d", typ)
+	return nil
+}
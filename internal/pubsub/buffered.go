@@ -0,0 +1,200 @@
+package pubsub
+
+import (
+	"context"
+	"errors"
+	"expvar"
+	"log/slog"
+	"math/rand/v2"
+	"sync"
+	"time"
+
+	"github.com/ThreeDotsLabs/watermill/message"
+)
+
+const (
+	defaultPublishBuffer = 1024
+	// publishInitialBackoff and publishMaxBackoff bound the jittered wait
+	// between attempts to publish an event.
+	publishInitialBackoff = 100 * time.Millisecond
+	publishMaxBackoff     = 5 * time.Second
+	// publishMaxAge is how long an event is retried before it's dropped.
+	// Live updates reload what changed, so the next event to get through
+	// catches clients up on anything dropped.
+	publishMaxAge = time.Minute
+	// publishAlertAfter is how long publishing fails before it's logged as
+	// an outage.
+	publishAlertAfter = 30 * time.Second
+	// publishFlushTimeout bounds how long Close waits for buffered events.
+	publishFlushTimeout = 5 * time.Second
+)
+
+// publishMetrics exposes publishing counters under /debug/vars:
+//
+//	enqueued  events accepted for publishing
+//	overflow  events not published because the buffer was full
+//	published events published
+//	retried   failed attempts that were retried
+//	dropped   events given up on, after publishMaxAge or at shutdown
+//	outages   times publishing failed for longer than publishAlertAfter
+//	failing   1 while publishing has failed for longer than that, else 0
+var publishMetrics = expvar.NewMap("pubsub_publish")
+
+var publishFailing = new(expvar.Int)
+
+func init() {
+	publishMetrics.Set("failing", publishFailing)
+}
+
+var (
+	// ErrPublishBufferFull is returned by BufferedPublisher.Publish when
+	// events are arriving faster than they can be published.
+	ErrPublishBufferFull = errors.New("publish buffer full")
+	ErrPublisherClosed   = errors.New("publisher closed")
+)
+
+// BufferedPublisher publishes events in the background, in the order they
+// were published, retrying failures with jittered exponential backoff, so a
+// NATS restart delays live updates instead of losing them.
+//
+// Buffering is bounded: events are dropped, and counted, when the buffer is
+// full or they've been retried for publishMaxAge. A failure lasting longer
+// than publishAlertAfter is logged as an error once, and again when it ends.
+type BufferedPublisher struct {
+	next  message.Publisher
+	queue chan bufferedEvent
+
+	initialBackoff time.Duration
+	maxBackoff     time.Duration
+	maxAge         time.Duration
+	alertAfter     time.Duration
+	flushTimeout   time.Duration
+
+	// ctx is cancelled once Close has waited flushTimeout.
+	ctx    context.Context
+	cancel context.CancelFunc
+	done   chan struct{}
+
+	// mu guards closed against publishes racing Close.
+	mu     sync.RWMutex
+	closed bool
+}
+
+type bufferedEvent struct {
+	topic    string
+	messages []*message.Message
+	queuedAt time.Time
+}
+
+// NewBufferedPublisher wraps next, buffering up to bufferSize publishes; a
+// non-positive size uses the default. Close it to flush the buffer.
+func NewBufferedPublisher(next message.Publisher, bufferSize int) *BufferedPublisher {
+	if bufferSize <= 0 {
+		bufferSize = defaultPublishBuffer
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	p := &BufferedPublisher{
+		next:           next,
+		queue:          make(chan bufferedEvent, bufferSize),
+		initialBackoff: publishInitialBackoff,
+		maxBackoff:     publishMaxBackoff,
+		maxAge:         publishMaxAge,
+		alertAfter:     publishAlertAfter,
+		flushTimeout:   publishFlushTimeout,
+		ctx:            ctx,
+		cancel:         cancel,
+		done:           make(chan struct{}),
+	}
+	go p.run()
+	return p
+}
+
+// Publish queues messages for topic without blocking. It returns an error
+// only if they won't be published at all: when the buffer is full or the
+// publisher is closed.
+func (p *BufferedPublisher) Publish(topic string, messages ...*message.Message) error {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	if p.closed {
+		return ErrPublisherClosed
+	}
+	select {
+	case p.queue <- bufferedEvent{topic: topic, messages: messages, queuedAt: time.Now()}:
+		publishMetrics.Add("enqueued", int64(len(messages)))
+		return nil
+	default:
+		publishMetrics.Add("overflow", int64(len(messages)))
+		return ErrPublishBufferFull
+	}
+}
+
+// Close stops accepting events and waits for those buffered to be
+// published, for up to publishFlushTimeout. It doesn't close the wrapped
+// publisher.
+func (p *BufferedPublisher) Close() error {
+	p.mu.Lock()
+	if !p.closed {
+		p.closed = true
+		close(p.queue)
+	}
+	p.mu.Unlock()
+
+	timer := time.AfterFunc(p.flushTimeout, p.cancel)
+	defer timer.Stop()
+	<-p.done
+	p.cancel()
+	return nil
+}
+
+func (p *BufferedPublisher) run() {
+	defer close(p.done)
+
+	var failingSince time.Time
+	alerted := false
+	defer func() {
+		if alerted {
+			publishFailing.Set(0)
+		}
+	}()
+	for e := range p.queue {
+		backoff := p.initialBackoff
+		for {
+			err := p.next.Publish(e.topic, e.messages...)
+			if err == nil {
+				publishMetrics.Add("published", int64(len(e.messages)))
+				if alerted {
+					publishFailing.Set(0)
+					slog.Info("publishing events recovered", "failed_for", time.Since(failingSince).Round(time.Second))
+				}
+				failingSince, alerted = time.Time{}, false
+				break
+			}
+
+			if failingSince.IsZero() {
+				failingSince = time.Now()
+			}
+			if !alerted && time.Since(failingSince) >= p.alertAfter {
+				alerted = true
+				publishFailing.Set(1)
+				publishMetrics.Add("outages", 1)
+				slog.Error("publishing events has failed for too long; live updates are delayed", "error", err, "failing_for", time.Since(failingSince).Round(time.Second))
+			}
+
+			if time.Since(e.queuedAt) >= p.maxAge || p.ctx.Err() != nil {
+				publishMetrics.Add("dropped", int64(len(e.messages)))
+				slog.Warn("dropping event after failed publishes", "error", err, "topic", e.topic)
+				break
+			}
+
+			publishMetrics.Add("retried", 1)
+			// Full jitter keeps replicas from retrying in step after an
+			// outage they all saw.
+			wait := rand.N(backoff) + 1
+			select {
+			case <-time.After(wait):
+			case <-p.ctx.Done():
+			}
+			backoff = min(backoff*2, p.maxBackoff)
+		}
+	}
+}
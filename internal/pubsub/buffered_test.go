@@ -0,0 +1,155 @@
+package pubsub
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ThreeDotsLabs/watermill/message"
+)
+
+// flakyPublisher fails publishes while down, and records the rest.
+type flakyPublisher struct {
+	mu       sync.Mutex
+	down     bool
+	attempts int
+	sent     []string
+}
+
+func (p *flakyPublisher) Publish(topic string, msgs ...*message.Message) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.attempts++
+	if p.down {
+		return errors.New("nats: connection closed")
+	}
+	for _, m := range msgs {
+		p.sent = append(p.sent, topic+":"+m.UUID)
+	}
+	return nil
+}
+
+func (p *flakyPublisher) Close() error { return nil }
+
+func (p *flakyPublisher) setDown(down bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.down = down
+}
+
+func (p *flakyPublisher) snapshot() (int, []string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.attempts, append([]string(nil), p.sent...)
+}
+
+func newTestBufferedPublisher(next message.Publisher, size int) *BufferedPublisher {
+	p := NewBufferedPublisher(next, size)
+	p.initialBackoff = time.Millisecond
+	p.maxBackoff = 5 * time.Millisecond
+	p.alertAfter = 20 * time.Millisecond
+	return p
+}
+
+func TestBufferedPublisher_RetriesInOrder(t *testing.T) {
+	next := &flakyPublisher{down: true}
+	p := newTestBufferedPublisher(next, 0)
+	defer p.Close()
+
+	for _, id := range []string{"1", "2", "3"} {
+		if err := p.Publish("hosts:a", message.NewMessage(id, nil)); err != nil {
+			t.Fatalf("Publish: %v", err)
+		}
+	}
+
+	// Outlast alertAfter, then recover.
+	deadline := time.Now().Add(2 * time.Second)
+	for publishFailing.Value() != 1 {
+		if time.Now().After(deadline) {
+			t.Fatal("sustained failure not flagged")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	next.setDown(false)
+
+	for {
+		attempts, sent := next.snapshot()
+		if len(sent) == 3 {
+			if sent[0] != "hosts:a:1" || sent[1] != "hosts:a:2" || sent[2] != "hosts:a:3" {
+				t.Fatalf("sent = %v, want 1, 2, 3 in order", sent)
+			}
+			if attempts <= 3 {
+				t.Fatalf("attempts = %d, want retries", attempts)
+			}
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("sent = %v after recovery", sent)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	if publishFailing.Value() != 0 {
+		t.Fatal("recovery not flagged")
+	}
+}
+
+func TestBufferedPublisher_DropsExpiredAndOverflow(t *testing.T) {
+	next := &flakyPublisher{down: true}
+	p := newTestBufferedPublisher(next, 1)
+	p.maxAge = 10 * time.Millisecond
+
+	// The first is taken off the buffer and retried; the second fills it.
+	if err := p.Publish("hosts:a", message.NewMessage("1", nil)); err != nil {
+		t.Fatalf("Publish: %v", err)
+	}
+	deadline := time.Now().Add(2 * time.Second)
+	for len(p.queue) != 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("first event never taken off the buffer")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if err := p.Publish("hosts:a", message.NewMessage("2", nil)); err != nil {
+		t.Fatalf("Publish: %v", err)
+	}
+	if err := p.Publish("hosts:a", message.NewMessage("3", nil)); !errors.Is(err, ErrPublishBufferFull) {
+		t.Fatalf("Publish to a full buffer: err = %v, want ErrPublishBufferFull", err)
+	}
+
+	// Both expire while NATS is down; later events still go out.
+	time.Sleep(50 * time.Millisecond)
+	next.setDown(false)
+	if err := p.Publish("hosts:a", message.NewMessage("4", nil)); err != nil {
+		t.Fatalf("Publish: %v", err)
+	}
+	if err := p.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	if _, sent := next.snapshot(); len(sent) != 1 || sent[0] != "hosts:a:4" {
+		t.Fatalf("sent = %v, want only 4", sent)
+	}
+	if err := p.Publish("hosts:a", message.NewMessage("5", nil)); !errors.Is(err, ErrPublisherClosed) {
+		t.Fatalf("Publish after Close: err = %v, want ErrPublisherClosed", err)
+	}
+}
+
+func TestBufferedPublisher_CloseGivesUpAfterFlushTimeout(t *testing.T) {
+	next := &flakyPublisher{down: true}
+	p := newTestBufferedPublisher(next, 0)
+	p.flushTimeout = 20 * time.Millisecond
+
+	if err := p.Publish("hosts:a", message.NewMessage("1", nil)); err != nil {
+		t.Fatalf("Publish: %v", err)
+	}
+	closed := make(chan struct{})
+	go func() {
+		_ = p.Close()
+		close(closed)
+	}()
+	select {
+	case <-closed:
+	case <-time.After(2 * time.Second):
+		t.Fatal("Close did not give up on an unpublishable event")
+	}
+}
@@ -1,8 +1,8 @@
 package pubsub
 
 import (
-	"encoding/json"
 	"fmt"
+	"strconv"
 	"time"
 
 	"github.com/ThreeDotsLabs/watermill/message"
@@ -14,6 +14,38 @@ const (
 	QueryResultStatusFailed    = "failed"
 )
 
+// Event types, the event_type metadata of each event's messages.
+const (
+	EventTypeQueryResult        = "query_result"
+	EventTypeCampaignResult     = "campaign_result"
+	EventTypeHostEnrolled       = "host_enrolled"
+	EventTypeHostDeleted        = "host_deleted"
+	EventTypeHostEvent          = "host_event"
+	EventTypeHostActivity       = "host_activity"
+	EventTypeNotification       = "notification"
+	EventTypeFeatureFlagChanged = "feature_flag_changed"
+	EventTypeIncident           = "incident"
+)
+
+// Every event is at version 1. Add to an event only what subscribers can
+// ignore, and bump its version with it; see Schema.
+func init() {
+	for _, eventType := range []string{
+		EventTypeQueryResult,
+		EventTypeCampaignResult,
+		EventTypeResultAnnotation,
+		EventTypeHostEnrolled,
+		EventTypeHostDeleted,
+		EventTypeHostEvent,
+		EventTypeHostActivity,
+		EventTypeNotification,
+		EventTypeFeatureFlagChanged,
+		EventTypeIncident,
+	} {
+		Events.Register(Schema{EventType: eventType})
+	}
+}
+
 // TopicQueryResults returns the topic name for a host's query results.
 //
 // Deprecated for new functionality; kept for backward compatibility with the
@@ -50,13 +82,7 @@ type QueryResultEvent struct {
 
 // ToMessage converts the event to a Watermill message.
 func (e QueryResultEvent) ToMessage() *message.Message {
-	payload, err := json.Marshal(e)
-	if err != nil {
-		payload = []byte("{}")
-	}
-
-	msg := message.NewMessage(uuid.NewString(), payload)
-	msg.Metadata.Set("event_type", "query_result")
+	msg := Events.newMessage(EventTypeQueryResult, e)
 	msg.Metadata.Set("host_id", e.HostID.String())
 	msg.Metadata.Set("query_id", e.QueryID.String())
 	return msg
@@ -65,7 +91,7 @@ func (e QueryResultEvent) ToMessage() *message.Message {
 // ParseQueryResultEvent parses a Watermill message into a QueryResultEvent.
 func ParseQueryResultEvent(msg *message.Message) (QueryResultEvent, error) {
 	var event QueryResultEvent
-	if err := json.Unmarshal(msg.Payload, &event); err != nil {
+	if err := Events.decode(msg, EventTypeQueryResult, &event); err != nil {
 		return event, fmt.Errorf("parsing query result event: %w", err)
 	}
 	return event, nil
@@ -90,13 +116,7 @@ type CampaignResultEvent struct {
 
 // ToMessage converts the event to a Watermill message.
 func (e CampaignResultEvent) ToMessage() *message.Message {
-	payload, err := json.Marshal(e)
-	if err != nil {
-		payload = []byte("{}")
-	}
-
-	msg := message.NewMessage(uuid.NewString(), payload)
-	msg.Metadata.Set("event_type", "campaign_result")
+	msg := Events.newMessage(EventTypeCampaignResult, e)
 	msg.Metadata.Set("campaign_id", e.CampaignID.String())
 	msg.Metadata.Set("host_id", e.HostID.String())
 	return msg
@@ -105,8 +125,306 @@ func (e CampaignResultEvent) ToMessage() *message.Message {
 // ParseCampaignResultEvent parses a Watermill message into a CampaignResultEvent.
 func ParseCampaignResultEvent(msg *message.Message) (CampaignResultEvent, error) {
 	var event CampaignResultEvent
-	if err := json.Unmarshal(msg.Payload, &event); err != nil {
+	if err := Events.decode(msg, EventTypeCampaignResult, &event); err != nil {
 		return event, fmt.Errorf("parsing campaign result event: %w", err)
 	}
 	return event, nil
 }
+
+// EventTypeResultAnnotation is the event_type of a ResultAnnotationEvent,
+// which shares TopicCampaign with CampaignResultEvent.
+const EventTypeResultAnnotation = "result_annotation"
+
+// ResultAnnotationEvent is published on TopicCampaign when an analyst
+// triages one of the campaign's result rows or hosts.
+type ResultAnnotationEvent struct {
+	CampaignID uuid.UUID `json:"campaign_id"`
+	HostID     uuid.UUID `json:"host_id"`
+
+	// RowKey is the row annotated, or empty for the host's results as a
+	// whole.
+	RowKey string `json:"row_key,omitempty"`
+
+	// State is the triage state, or empty if the annotation was removed.
+	State string `json:"state,omitempty"`
+
+	// OccurredAt is when the annotation was saved.
+	OccurredAt time.Time `json:"occurred_at"`
+}
+
+// ToMessage converts the event to a Watermill message.
+func (e ResultAnnotationEvent) ToMessage() *message.Message {
+	msg := Events.newMessage(EventTypeResultAnnotation, e)
+	msg.Metadata.Set("campaign_id", e.CampaignID.String())
+	msg.Metadata.Set("host_id", e.HostID.String())
+	return msg
+}
+
+// ParseResultAnnotationEvent parses a Watermill message into a
+// ResultAnnotationEvent.
+func ParseResultAnnotationEvent(msg *message.Message) (ResultAnnotationEvent, error) {
+	var event ResultAnnotationEvent
+	if err := Events.decode(msg, EventTypeResultAnnotation, &event); err != nil {
+		return event, fmt.Errorf("parsing result annotation event: %w", err)
+	}
+	return event, nil
+}
+
+// TopicHostEnrollments is the topic for host enrollment events.
+const TopicHostEnrollments = "host_enrollments"
+
+// HostEnrolledEvent is published when a host enrolls or re-enrolls and is
+// issued a new node key. Subscribers use it to drop cached host lookups.
+type HostEnrolledEvent struct {
+	OrganizationID uuid.UUID `json:"organization_id"`
+	HostIdentifier string    `json:"host_identifier"`
+
+	// OccurredAt is when the enrollment was saved.
+	OccurredAt time.Time `json:"occurred_at"`
+}
+
+// ToMessage converts the event to a Watermill message.
+func (e HostEnrolledEvent) ToMessage() *message.Message {
+	msg := Events.newMessage(EventTypeHostEnrolled, e)
+	msg.Metadata.Set("organization_id", e.OrganizationID.String())
+	return msg
+}
+
+// ParseHostEnrolledEvent parses a Watermill message into a HostEnrolledEvent.
+func ParseHostEnrolledEvent(msg *message.Message) (HostEnrolledEvent, error) {
+	var event HostEnrolledEvent
+	if err := Events.decode(msg, EventTypeHostEnrolled, &event); err != nil {
+		return event, fmt.Errorf("parsing host enrolled event: %w", err)
+	}
+	return event, nil
+}
+
+// TopicHostDeletions is the topic for host deletion events.
+const TopicHostDeletions = "host_deletions"
+
+// HostDeletedEvent is published when hosts are deleted. Subscribers use it to
+// drop cached host lookups so the deleted hosts' node keys stop
+// authenticating at once.
+type HostDeletedEvent struct {
+	OrganizationID uuid.UUID   `json:"organization_id"`
+	HostIDs        []uuid.UUID `json:"host_ids"`
+
+	// OccurredAt is when the hosts were deleted.
+	OccurredAt time.Time `json:"occurred_at"`
+}
+
+// ToMessage converts the event to a Watermill message.
+func (e HostDeletedEvent) ToMessage() *message.Message {
+	msg := Events.newMessage(EventTypeHostDeleted, e)
+	msg.Metadata.Set("organization_id", e.OrganizationID.String())
+	return msg
+}
+
+// ParseHostDeletedEvent parses a Watermill message into a HostDeletedEvent.
+func ParseHostDeletedEvent(msg *message.Message) (HostDeletedEvent, error) {
+	var event HostDeletedEvent
+	if err := Events.decode(msg, EventTypeHostDeleted, &event); err != nil {
+		return event, fmt.Errorf("parsing host deleted event: %w", err)
+	}
+	return event, nil
+}
+
+// TopicHosts returns the topic name for an organization's host events.
+func TopicHosts(organizationID uuid.UUID) string {
+	return fmt.Sprintf("hosts:%s", organizationID.String())
+}
+
+// Host event types.
+const (
+	HostEventEnrolled  = "enrolled"
+	HostEventCheckedIn = "checked_in"
+	// HostEventStatusChanged is a check-in from a host that was offline.
+	// Hosts going offline produce no event; clients derive that from the
+	// last check-in time.
+	HostEventStatusChanged = "status_changed"
+)
+
+// HostEvent is published on TopicHosts when a host's row on the hosts page
+// changes.
+type HostEvent struct {
+	OrganizationID uuid.UUID `json:"organization_id"`
+
+	// HostID is uuid.Nil for enrollments, which only know the identifier.
+	HostID         uuid.UUID `json:"host_id"`
+	HostIdentifier string    `json:"host_identifier"`
+
+	Type string `json:"type"`
+
+	// OccurredAt is when the change was saved.
+	OccurredAt time.Time `json:"occurred_at"`
+}
+
+// ToMessage converts the event to a Watermill message.
+func (e HostEvent) ToMessage() *message.Message {
+	msg := Events.newMessage(EventTypeHostEvent, e)
+	msg.Metadata.Set("organization_id", e.OrganizationID.String())
+	return msg
+}
+
+// ParseHostEvent parses a Watermill message into a HostEvent.
+func ParseHostEvent(msg *message.Message) (HostEvent, error) {
+	var event HostEvent
+	if err := Events.decode(msg, EventTypeHostEvent, &event); err != nil {
+		return event, fmt.Errorf("parsing host event: %w", err)
+	}
+	return event, nil
+}
+
+// TopicHostActivity returns the topic name for a host's lifecycle events.
+// They are only written to the outbox, and recorded in the host's timeline
+// by a consumer group subscribed to TopicAllHostActivity.
+func TopicHostActivity(hostID uuid.UUID) string {
+	return fmt.Sprintf("host_activity:%s", hostID.String())
+}
+
+// TopicAllHostActivity subscribes an outbox consumer group to every host's
+// lifecycle events.
+const TopicAllHostActivity = "host_activity:*"
+
+// Host activity event types.
+const (
+	HostActivityEnrolled      = "enrolled"
+	HostActivityConfigFetched = "config_fetched"
+	// HostActivityQuerySent and HostActivityResultsReturned carry the
+	// campaign.
+	HostActivityQuerySent       = "query_sent"
+	HostActivityResultsReturned = "results_returned"
+	HostActivityWentOffline     = "went_offline"
+	// HostActivityQuarantined carries the reason given.
+	HostActivityQuarantined = "quarantined"
+	HostActivityReleased    = "released"
+)
+
+// HostActivityEvent is a step in a host's lifecycle.
+type HostActivityEvent struct {
+	HostID uuid.UUID `json:"host_id"`
+	Type   string    `json:"type"`
+
+	// CampaignID is set for queries sent and results returned.
+	CampaignID *uuid.UUID `json:"campaign_id,omitempty"`
+
+	// Detail is a short description, such as a result's status and row
+	// count.
+	Detail string `json:"detail,omitempty"`
+
+	// OccurredAt is when the step was saved.
+	OccurredAt time.Time `json:"occurred_at"`
+}
+
+// ToMessage converts the event to a Watermill message.
+func (e HostActivityEvent) ToMessage() *message.Message {
+	msg := Events.newMessage(EventTypeHostActivity, e)
+	msg.Metadata.Set("host_id", e.HostID.String())
+	return msg
+}
+
+// ParseHostActivityEvent parses a Watermill message into a
+// HostActivityEvent.
+func ParseHostActivityEvent(msg *message.Message) (HostActivityEvent, error) {
+	var event HostActivityEvent
+	if err := Events.decode(msg, EventTypeHostActivity, &event); err != nil {
+		return event, fmt.Errorf("parsing host activity event: %w", err)
+	}
+	return event, nil
+}
+
+// TopicNotifications returns the topic name for a user's notifications.
+func TopicNotifications(userID int) string {
+	return fmt.Sprintf("notifications:%d", userID)
+}
+
+// NotificationEvent is published when a notification is created for a user.
+type NotificationEvent struct {
+	UserID         int       `json:"user_id"`
+	NotificationID uuid.UUID `json:"notification_id"`
+
+	// OccurredAt is when the notification was created.
+	OccurredAt time.Time `json:"occurred_at"`
+}
+
+// ToMessage converts the event to a Watermill message.
+func (e NotificationEvent) ToMessage() *message.Message {
+	msg := Events.newMessage(EventTypeNotification, e)
+	msg.Metadata.Set("user_id", strconv.Itoa(e.UserID))
+	return msg
+}
+
+// ParseNotificationEvent parses a Watermill message into a NotificationEvent.
+func ParseNotificationEvent(msg *message.Message) (NotificationEvent, error) {
+	var event NotificationEvent
+	if err := Events.decode(msg, EventTypeNotification, &event); err != nil {
+		return event, fmt.Errorf("parsing notification event: %w", err)
+	}
+	return event, nil
+}
+
+// TopicFeatureFlags is the topic for feature flag changes.
+const TopicFeatureFlags = "feature_flags"
+
+// FeatureFlagChangedEvent is published when a flag's default or one of its
+// organization overrides changes. Subscribers use it to drop cached flags.
+type FeatureFlagChangedEvent struct {
+	Flag string `json:"flag"`
+	// OrganizationID is the organization whose override changed, or uuid.Nil
+	// when the default did.
+	OrganizationID uuid.UUID `json:"organization_id"`
+
+	// OccurredAt is when the change was saved.
+	OccurredAt time.Time `json:"occurred_at"`
+}
+
+// ToMessage converts the event to a Watermill message.
+func (e FeatureFlagChangedEvent) ToMessage() *message.Message {
+	msg := Events.newMessage(EventTypeFeatureFlagChanged, e)
+	msg.Metadata.Set("flag", e.Flag)
+	return msg
+}
+
+// ParseFeatureFlagChangedEvent parses a Watermill message into a
+// FeatureFlagChangedEvent.
+func ParseFeatureFlagChangedEvent(msg *message.Message) (FeatureFlagChangedEvent, error) {
+	var event FeatureFlagChangedEvent
+	if err := Events.decode(msg, EventTypeFeatureFlagChanged, &event); err != nil {
+		return event, fmt.Errorf("parsing feature flag changed event: %w", err)
+	}
+	return event, nil
+}
+
+// TopicIncident returns the topic name for an incident's activity.
+func TopicIncident(incidentID uuid.UUID) string {
+	return fmt.Sprintf("incident:%s", incidentID.String())
+}
+
+// IncidentEvent is published when a note is added to an incident or a
+// responder changes it.
+type IncidentEvent struct {
+	IncidentID uuid.UUID `json:"incident_id"`
+	Kind       string    `json:"kind"`
+
+	// CampaignID is the campaign pinned or unpinned, if any.
+	CampaignID *uuid.UUID `json:"campaign_id,omitempty"`
+
+	// OccurredAt is when the change was saved.
+	OccurredAt time.Time `json:"occurred_at"`
+}
+
+// ToMessage converts the event to a Watermill message.
+func (e IncidentEvent) ToMessage() *message.Message {
+	msg := Events.newMessage(EventTypeIncident, e)
+	msg.Metadata.Set("incident_id", e.IncidentID.String())
+	return msg
+}
+
+// ParseIncidentEvent parses a Watermill message into an IncidentEvent.
+func ParseIncidentEvent(msg *message.Message) (IncidentEvent, error) {
+	var event IncidentEvent
+	if err := Events.decode(msg, EventTypeIncident, &event); err != nil {
+		return event, fmt.Errorf("parsing incident event: %w", err)
+	}
+	return event, nil
+}
@@ -105,3 +105,235 @@ func TestCampaignResultEvent_SerializationRoundTrip(t *testing.T) {
 		t.Fatalf("Error = %q, want %q", *parsed.Error, *original.Error)
 	}
 }
+
+func TestHostEnrolledEvent_SerializationRoundTrip(t *testing.T) {
+	original := HostEnrolledEvent{
+		OrganizationID: uuid.New(),
+		HostIdentifier: "host-123",
+		OccurredAt:     time.Now().UTC().Truncate(time.Second),
+	}
+
+	msg := original.ToMessage()
+	if got := msg.Metadata.Get("event_type"); got != "host_enrolled" {
+		t.Fatalf("event_type = %q, want host_enrolled", got)
+	}
+	if got := msg.Metadata.Get("organization_id"); got != original.OrganizationID.String() {
+		t.Fatalf("organization_id = %q, want %q", got, original.OrganizationID.String())
+	}
+
+	parsed, err := ParseHostEnrolledEvent(msg)
+	if err != nil {
+		t.Fatalf("ParseHostEnrolledEvent error = %v", err)
+	}
+	if parsed.OrganizationID != original.OrganizationID {
+		t.Fatalf("OrganizationID = %v, want %v", parsed.OrganizationID, original.OrganizationID)
+	}
+	if parsed.HostIdentifier != original.HostIdentifier {
+		t.Fatalf("HostIdentifier = %q, want %q", parsed.HostIdentifier, original.HostIdentifier)
+	}
+	if !parsed.OccurredAt.Equal(original.OccurredAt) {
+		t.Fatalf("OccurredAt = %v, want %v", parsed.OccurredAt, original.OccurredAt)
+	}
+}
+
+func TestHostDeletedEvent_SerializationRoundTrip(t *testing.T) {
+	original := HostDeletedEvent{
+		OrganizationID: uuid.New(),
+		HostIDs:        []uuid.UUID{uuid.New(), uuid.New()},
+		OccurredAt:     time.Now().UTC().Truncate(time.Second),
+	}
+
+	msg := original.ToMessage()
+	if got := msg.Metadata.Get("event_type"); got != "host_deleted" {
+		t.Fatalf("event_type = %q, want host_deleted", got)
+	}
+
+	parsed, err := ParseHostDeletedEvent(msg)
+	if err != nil {
+		t.Fatalf("ParseHostDeletedEvent error = %v", err)
+	}
+	if parsed.OrganizationID != original.OrganizationID {
+		t.Fatalf("OrganizationID = %v, want %v", parsed.OrganizationID, original.OrganizationID)
+	}
+	if len(parsed.HostIDs) != 2 || parsed.HostIDs[0] != original.HostIDs[0] || parsed.HostIDs[1] != original.HostIDs[1] {
+		t.Fatalf("HostIDs = %v, want %v", parsed.HostIDs, original.HostIDs)
+	}
+}
+
+func TestHostEvent_SerializationRoundTrip(t *testing.T) {
+	original := HostEvent{
+		OrganizationID: uuid.New(),
+		HostID:         uuid.New(),
+		HostIdentifier: "host-123",
+		Type:           HostEventStatusChanged,
+		OccurredAt:     time.Now().UTC().Truncate(time.Second),
+	}
+
+	msg := original.ToMessage()
+	if got := msg.Metadata.Get("event_type"); got != "host_event" {
+		t.Fatalf("event_type = %q, want host_event", got)
+	}
+	if got := msg.Metadata.Get("organization_id"); got != original.OrganizationID.String() {
+		t.Fatalf("organization_id = %q, want %q", got, original.OrganizationID.String())
+	}
+
+	parsed, err := ParseHostEvent(msg)
+	if err != nil {
+		t.Fatalf("ParseHostEvent error = %v", err)
+	}
+	if parsed.HostID != original.HostID || parsed.HostIdentifier != original.HostIdentifier || parsed.Type != original.Type {
+		t.Fatalf("parsed = %+v, want %+v", parsed, original)
+	}
+	if !parsed.OccurredAt.Equal(original.OccurredAt) {
+		t.Fatalf("OccurredAt = %v, want %v", parsed.OccurredAt, original.OccurredAt)
+	}
+}
+
+func TestNotificationEvent_SerializationRoundTrip(t *testing.T) {
+	original := NotificationEvent{
+		UserID:         42,
+		NotificationID: uuid.New(),
+		OccurredAt:     time.Now().UTC().Truncate(time.Second),
+	}
+
+	msg := original.ToMessage()
+	if got := msg.Metadata.Get("event_type"); got != "notification" {
+		t.Fatalf("event_type = %q, want notification", got)
+	}
+	if got := msg.Metadata.Get("user_id"); got != "42" {
+		t.Fatalf("user_id = %q, want 42", got)
+	}
+
+	parsed, err := ParseNotificationEvent(msg)
+	if err != nil {
+		t.Fatalf("ParseNotificationEvent error = %v", err)
+	}
+	if parsed.UserID != original.UserID || parsed.NotificationID != original.NotificationID {
+		t.Fatalf("parsed = %+v, want %+v", parsed, original)
+	}
+	if !parsed.OccurredAt.Equal(original.OccurredAt) {
+		t.Fatalf("OccurredAt = %v, want %v", parsed.OccurredAt, original.OccurredAt)
+	}
+	if got := TopicNotifications(42); got != "notifications:42" {
+		t.Fatalf("TopicNotifications = %q", got)
+	}
+}
+
+func TestHostActivityEvent_SerializationRoundTrip(t *testing.T) {
+	campaignID := uuid.New()
+	original := HostActivityEvent{
+		HostID:     uuid.New(),
+		Type:       HostActivityResultsReturned,
+		CampaignID: &campaignID,
+		Detail:     "completed, 3 rows",
+		OccurredAt: time.Now().UTC().Truncate(time.Second),
+	}
+
+	msg := original.ToMessage()
+	if got := msg.Metadata.Get("event_type"); got != "host_activity" {
+		t.Fatalf("event_type = %q, want host_activity", got)
+	}
+	if got := msg.Metadata.Get("host_id"); got != original.HostID.String() {
+		t.Fatalf("host_id = %q, want %q", got, original.HostID.String())
+	}
+
+	parsed, err := ParseHostActivityEvent(msg)
+	if err != nil {
+		t.Fatalf("ParseHostActivityEvent error = %v", err)
+	}
+	if parsed.HostID != original.HostID || parsed.Type != original.Type || parsed.Detail != original.Detail {
+		t.Fatalf("parsed = %+v, want %+v", parsed, original)
+	}
+	if parsed.CampaignID == nil || *parsed.CampaignID != campaignID {
+		t.Fatalf("CampaignID = %v, want %v", parsed.CampaignID, campaignID)
+	}
+	if !parsed.OccurredAt.Equal(original.OccurredAt) {
+		t.Fatalf("OccurredAt = %v, want %v", parsed.OccurredAt, original.OccurredAt)
+	}
+}
+
+func TestFeatureFlagChangedEvent_SerializationRoundTrip(t *testing.T) {
+	original := FeatureFlagChangedEvent{
+		Flag:           "new_results_ui",
+		OrganizationID: uuid.New(),
+		OccurredAt:     time.Now().UTC().Truncate(time.Second),
+	}
+
+	msg := original.ToMessage()
+	if got := msg.Metadata.Get("event_type"); got != "feature_flag_changed" {
+		t.Fatalf("event_type = %q, want feature_flag_changed", got)
+	}
+
+	parsed, err := ParseFeatureFlagChangedEvent(msg)
+	if err != nil {
+		t.Fatalf("ParseFeatureFlagChangedEvent error = %v", err)
+	}
+	if parsed.Flag != original.Flag || parsed.OrganizationID != original.OrganizationID {
+		t.Fatalf("parsed = %+v, want %+v", parsed, original)
+	}
+	if !parsed.OccurredAt.Equal(original.OccurredAt) {
+		t.Fatalf("OccurredAt = %v, want %v", parsed.OccurredAt, original.OccurredAt)
+	}
+}
+
+func TestIncidentEvent_SerializationRoundTrip(t *testing.T) {
+	campaignID := uuid.New()
+	original := IncidentEvent{
+		IncidentID: uuid.New(),
+		Kind:       "campaign_pinned",
+		CampaignID: &campaignID,
+		OccurredAt: time.Now().UTC().Truncate(time.Second),
+	}
+
+	msg := original.ToMessage()
+	if got := msg.Metadata.Get("event_type"); got != "incident" {
+		t.Fatalf("event_type = %q, want incident", got)
+	}
+	if got := msg.Metadata.Get("incident_id"); got != original.IncidentID.String() {
+		t.Fatalf("incident_id = %q, want %q", got, original.IncidentID.String())
+	}
+
+	parsed, err := ParseIncidentEvent(msg)
+	if err != nil {
+		t.Fatalf("ParseIncidentEvent error = %v", err)
+	}
+	if parsed.IncidentID != original.IncidentID || parsed.Kind != original.Kind {
+		t.Fatalf("parsed = %+v, want %+v", parsed, original)
+	}
+	if parsed.CampaignID == nil || *parsed.CampaignID != campaignID {
+		t.Fatalf("CampaignID = %v, want %v", parsed.CampaignID, campaignID)
+	}
+	if !parsed.OccurredAt.Equal(original.OccurredAt) {
+		t.Fatalf("OccurredAt = %v, want %v", parsed.OccurredAt, original.OccurredAt)
+	}
+}
+
+func TestResultAnnotationEvent_SerializationRoundTrip(t *testing.T) {
+	original := ResultAnnotationEvent{
+		CampaignID: uuid.New(),
+		HostID:     uuid.New(),
+		RowKey:     "0cc175b9c0f1b6a831c399e269772661",
+		State:      "suspicious",
+		OccurredAt: time.Now().UTC().Truncate(time.Second),
+	}
+
+	msg := original.ToMessage()
+	if got := msg.Metadata.Get("event_type"); got != EventTypeResultAnnotation {
+		t.Fatalf("event_type = %q, want %q", got, EventTypeResultAnnotation)
+	}
+	if got := msg.Metadata.Get("campaign_id"); got != original.CampaignID.String() {
+		t.Fatalf("campaign_id = %q, want %q", got, original.CampaignID.String())
+	}
+
+	parsed, err := ParseResultAnnotationEvent(msg)
+	if err != nil {
+		t.Fatalf("ParseResultAnnotationEvent error = %v", err)
+	}
+	if parsed.CampaignID != original.CampaignID || parsed.HostID != original.HostID ||
+		parsed.RowKey != original.RowKey || parsed.State != original.State {
+		t.Fatalf("parsed = %+v, want %+v", parsed, original)
+	}
+	if !parsed.OccurredAt.Equal(original.OccurredAt) {
+		t.Fatalf("OccurredAt = %v, want %v", parsed.OccurredAt, original.OccurredAt)
+	}
+}
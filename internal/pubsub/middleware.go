@@ -0,0 +1,128 @@
+package pubsub
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/ThreeDotsLabs/watermill/message"
+)
+
+// Middleware wraps a message handler, like watermill's router middleware,
+// for subscribers that consume their channel directly.
+type Middleware func(message.NoPublishHandlerFunc) message.NoPublishHandlerFunc
+
+// Chain wraps h in mw, the first outermost.
+func Chain(h message.NoPublishHandlerFunc, mw ...Middleware) message.NoPublishHandlerFunc {
+	for i := len(mw) - 1; i >= 0; i-- {
+		h = mw[i](h)
+	}
+	return h
+}
+
+// Handle runs h on msg, acking it if h succeeds and nacking it otherwise.
+func Handle(msg *message.Message, h message.NoPublishHandlerFunc) error {
+	if err := h(msg); err != nil {
+		msg.Nack()
+		return err
+	}
+	msg.Ack()
+	return nil
+}
+
+// ErrPoison marks a message no retry can handle, such as one that doesn't
+// parse. Retry gives up on it at once.
+var ErrPoison = errors.New("poison message")
+
+// Poison marks err as unrecoverable for the message being handled.
+func Poison(err error) error {
+	return fmt.Errorf("%w: %w", ErrPoison, err)
+}
+
+// Retry retries failed handling with exponential backoff.
+type Retry struct {
+	// MaxRetries is how many times handling is retried after it first fails.
+	MaxRetries int
+	// InitialInterval is the wait before the first retry, doubled for each
+	// retry after it up to MaxInterval.
+	InitialInterval time.Duration
+	MaxInterval     time.Duration
+}
+
+// DefaultRetry is the retry policy PubSub.Handler applies. It's short, since
+// subscribers handle messages one at a time and retrying holds up the rest.
+var DefaultRetry = Retry{
+	MaxRetries:      3,
+	InitialInterval: 100 * time.Millisecond,
+	MaxInterval:     2 * time.Second,
+}
+
+// Middleware retries h until it succeeds or the retries run out. Poison
+// messages aren't retried, nor is anything once the message's context ends.
+func (r Retry) Middleware(h message.NoPublishHandlerFunc) message.NoPublishHandlerFunc {
+	return func(msg *message.Message) error {
+		wait := r.InitialInterval
+		err := h(msg)
+		for attempt := 0; err != nil && attempt < r.MaxRetries; attempt++ {
+			if errors.Is(err, ErrPoison) {
+				return err
+			}
+			timer := time.NewTimer(wait)
+			select {
+			case <-msg.Context().Done():
+				timer.Stop()
+				return errors.Join(err, msg.Context().Err())
+			case <-timer.C:
+			}
+			wait = min(wait*2, r.MaxInterval)
+			err = h(msg)
+		}
+		return err
+	}
+}
+
+// DeadLetterStore keeps messages a consumer gave up on, for inspection and
+// requeueing. outbox.DeadLetters stores them in Postgres.
+type DeadLetterStore interface {
+	DeadLetter(ctx context.Context, consumer, topic string, msg *message.Message, cause error) error
+}
+
+// DeadLetterQueue moves messages h fails to handle to store, so they're
+// acked instead of blocking or silently dropping out of the subscription.
+// consumer names the subscriber in the store and topic is what it subscribed
+// to. With a nil store failures are only logged. Handling cut short by the
+// message's context ending is returned as is.
+func DeadLetterQueue(store DeadLetterStore, consumer, topic string) Middleware {
+	return func(h message.NoPublishHandlerFunc) message.NoPublishHandlerFunc {
+		return func(msg *message.Message) error {
+			cause := h(msg)
+			if cause == nil {
+				return nil
+			}
+			ctx := msg.Context()
+			if ctx.Err() != nil {
+				return cause
+			}
+
+			attrs := []any{"error", cause, "consumer", consumer, "topic", topic, "message_id", msg.UUID}
+			if store == nil {
+				slog.ErrorContext(ctx, "dropping message after failed handling", attrs...)
+				return nil
+			}
+			if err := store.DeadLetter(ctx, consumer, topic, msg, cause); err != nil {
+				slog.ErrorContext(ctx, "failed to dead-letter message", append(attrs, "store_error", err)...)
+				return errors.Join(cause, err)
+			}
+			slog.WarnContext(ctx, "moved message to dead letters", attrs...)
+			return nil
+		}
+	}
+}
+
+// Handler wraps h, consumer's handler for messages on topic, in DefaultRetry
+// and a DeadLetterQueue on the store in Config.DeadLetters.
+func (ps *PubSub) Handler(consumer, topic string, h message.NoPublishHandlerFunc) message.NoPublishHandlerFunc {
+	return Chain(h, DeadLetterQueue(ps.deadLetters, consumer, topic), DefaultRetry.Middleware)
+}
@@ -0,0 +1,133 @@
+package pubsub
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/ThreeDotsLabs/watermill/message"
+)
+
+type recordingDeadLetters struct {
+	fail    error
+	letters []string
+}
+
+func (s *recordingDeadLetters) DeadLetter(_ context.Context, consumer, topic string, msg *message.Message, cause error) error {
+	if s.fail != nil {
+		return s.fail
+	}
+	s.letters = append(s.letters, consumer+":"+topic+":"+msg.UUID+":"+cause.Error())
+	return nil
+}
+
+func TestRetry(t *testing.T) {
+	errHandler := errors.New("handler failed")
+	retry := Retry{MaxRetries: 3, InitialInterval: time.Millisecond, MaxInterval: 2 * time.Millisecond}
+
+	tests := []struct {
+		name      string
+		failures  int
+		err       error
+		wantCalls int
+		wantErr   error
+	}{
+		{"succeeds", 0, errHandler, 1, nil},
+		{"succeeds on a retry", 2, errHandler, 3, nil},
+		{"retries run out", 10, errHandler, 4, errHandler},
+		{"poison", 10, Poison(errHandler), 1, ErrPoison},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			calls := 0
+			h := retry.Middleware(func(*message.Message) error {
+				calls++
+				if calls <= tt.failures {
+					return tt.err
+				}
+				return nil
+			})
+			if err := h(message.NewMessage("1", nil)); !errors.Is(err, tt.wantErr) {
+				t.Fatalf("err = %v, want %v", err, tt.wantErr)
+			}
+			if calls != tt.wantCalls {
+				t.Fatalf("calls = %d, want %d", calls, tt.wantCalls)
+			}
+		})
+	}
+}
+
+func TestRetry_StopsWhenMessageContextEnds(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	msg := message.NewMessage("1", nil)
+	msg.SetContext(ctx)
+
+	calls := 0
+	h := Retry{MaxRetries: 5, InitialInterval: time.Hour, MaxInterval: time.Hour}.Middleware(func(*message.Message) error {
+		calls++
+		cancel()
+		return errors.New("handler failed")
+	})
+	if err := h(msg); !errors.Is(err, context.Canceled) {
+		t.Fatalf("err = %v, want context.Canceled", err)
+	}
+	if calls != 1 {
+		t.Fatalf("calls = %d, want 1", calls)
+	}
+}
+
+func TestDeadLetterQueue(t *testing.T) {
+	store := &recordingDeadLetters{}
+	failing := func(*message.Message) error { return Poison(errors.New("bad payload")) }
+
+	h := Chain(failing, DeadLetterQueue(store, "sse:hosts", "hosts:1"), DefaultRetry.Middleware)
+	msg := message.NewMessage("m1", nil)
+	if err := Handle(msg, h); err != nil {
+		t.Fatalf("Handle: %v", err)
+	}
+	select {
+	case <-msg.Acked():
+	default:
+		t.Fatal("dead-lettered message was not acked")
+	}
+	if want := "sse:hosts:hosts:1:m1:poison message: bad payload"; len(store.letters) != 1 || store.letters[0] != want {
+		t.Fatalf("letters = %v, want [%s]", store.letters, want)
+	}
+
+	// A message the store can't keep is nacked for redelivery.
+	store.fail = errors.New("database down")
+	msg = message.NewMessage("m2", nil)
+	if err := Handle(msg, h); err == nil {
+		t.Fatal("Handle succeeded with the store failing")
+	}
+	select {
+	case <-msg.Nacked():
+	default:
+		t.Fatal("message was not nacked")
+	}
+
+	// Without a store, failures are dropped.
+	msg = message.NewMessage("m3", nil)
+	if err := Handle(msg, Chain(failing, DeadLetterQueue(nil, "sse:hosts", "hosts:1"))); err != nil {
+		t.Fatalf("Handle without a store: %v", err)
+	}
+}
+
+func TestDeadLetterQueue_KeepsCancelledHandling(t *testing.T) {
+	store := &recordingDeadLetters{}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	msg := message.NewMessage("m1", nil)
+	msg.SetContext(ctx)
+
+	h := DeadLetterQueue(store, "host_timeline", "host_activity:*")(func(*message.Message) error {
+		return context.Canceled
+	})
+	if err := h(msg); !errors.Is(err, context.Canceled) {
+		t.Fatalf("err = %v, want context.Canceled", err)
+	}
+	if len(store.letters) != 0 {
+		t.Fatalf("letters = %v, want none", store.letters)
+	}
+}
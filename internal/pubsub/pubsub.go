@@ -2,6 +2,7 @@ package pubsub
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log/slog"
 
@@ -17,11 +18,19 @@ import (
 // If no NATS URL is provided, an embedded NATS server is started automatically.
 // This allows the application to run standalone on a single VPS without external
 // dependencies, while still supporting external NATS for scaled deployments.
+//
+// Events only reach subscribers connected to the same NATS server, so every
+// web replica must share an external one: an event published by the replica
+// that received a host's request then reaches SSE clients on all of them,
+// without sticky sessions.
 type PubSub struct {
 	conn      *nc.Conn
 	embedded  *EmbeddedServer // nil if using external NATS
 	publisher message.Publisher
+	buffered  *BufferedPublisher
 	logger    watermill.LoggerAdapter
+
+	deadLetters DeadLetterStore
 }
 
 // Config holds configuration for the pub/sub system.
@@ -29,16 +38,33 @@ type Config struct {
 	// NATSUrl is the URL of the NATS server to connect to.
 	// If empty, an embedded NATS server will be started.
 	NATSUrl string
+
+	// Replicas is how many web instances the deployment runs. An embedded
+	// server is private to its process, so New refuses to start one for
+	// more than one replica.
+	Replicas int
+
+	// DeadLetters, when set, keeps the messages handlers from Handler give
+	// up on. Without it they're logged and dropped.
+	DeadLetters DeadLetterStore
 }
 
+// ErrEmbeddedReplicas is returned by New when an embedded NATS server is
+// asked for with more than one replica configured.
+var ErrEmbeddedReplicas = errors.New("embedded NATS server only serves one replica; set NATS_URL to a NATS server the replicas share")
+
 // New creates a new PubSub instance backed by NATS.
 //
-// If cfg.NATSUrl is empty, starts an embedded NATS server.
-// Otherwise, connects to the external NATS server at the provided URL.
+// If cfg.NATSUrl is empty, starts an embedded NATS server, unless
+// cfg.Replicas is more than one. Otherwise, connects to the external NATS
+// server at the provided URL.
 func New(ctx context.Context, cfg *Config) (*PubSub, error) {
 	if cfg == nil {
 		cfg = &Config{}
 	}
+	if cfg.NATSUrl == "" && cfg.Replicas > 1 {
+		return nil, ErrEmbeddedReplicas
+	}
 
 	conn, embedded, err := Connect(ctx, cfg.NATSUrl)
 	if err != nil {
@@ -65,15 +91,24 @@ func New(ctx context.Context, cfg *Config) (*PubSub, error) {
 	}
 
 	return &PubSub{
-		conn:      conn,
-		embedded:  embedded,
-		publisher: publisher,
-		logger:    logger,
+		conn:        conn,
+		embedded:    embedded,
+		publisher:   publisher,
+		buffered:    NewBufferedPublisher(publisher, 0),
+		logger:      logger,
+		deadLetters: cfg.DeadLetters,
 	}, nil
 }
 
-// Publisher returns the Watermill publisher for sending messages.
+// Publisher returns the Watermill publisher for sending messages. It
+// buffers them and retries failures; see BufferedPublisher.
 func (ps *PubSub) Publisher() message.Publisher {
+	return ps.buffered
+}
+
+// DirectPublisher returns the publisher Publisher buffers for, for callers
+// that retry failures themselves, like the outbox relay.
+func (ps *PubSub) DirectPublisher() message.Publisher {
 	return ps.publisher
 }
 
@@ -81,7 +116,8 @@ func (ps *PubSub) Publisher() message.Publisher {
 //
 // Each SSE connection should create its own subscriber to receive all messages
 // (fan-out pattern). Subscribers are ephemeral and should be closed when the
-// SSE connection ends.
+// SSE connection ends. Background processors that need each event handled
+// once across instances should use outbox.ConsumerGroup instead.
 func (ps *PubSub) NewSubscriber(_ context.Context) (message.Subscriber, error) {
 	// Create subscriber using existing connection
 	// JetStream is disabled for core NATS pub/sub
@@ -104,10 +140,15 @@ func (ps *PubSub) NewSubscriber(_ context.Context) (message.Subscriber, error) {
 
 // Close shuts down the pub/sub system.
 //
-// Closes the publisher, NATS connection, and embedded server (if running).
+// Flushes buffered messages, then closes the publisher, NATS connection, and
+// embedded server (if running).
 func (ps *PubSub) Close() error {
 	var errs []error
 
+	if ps.buffered != nil {
+		_ = ps.buffered.Close()
+	}
+
 	if ps.publisher != nil {
 		if err := ps.publisher.Close(); err != nil {
 			errs = append(errs, fmt.Errorf("closing publisher: %w", err))
@@ -2,6 +2,7 @@ package pubsub
 
 import (
 	"context"
+	"errors"
 	"testing"
 	"time"
 
@@ -205,3 +206,93 @@ func TestPubSub_TopicIsolation(t *testing.T) {
 		// ok - no message received, which is expected
 	}
 }
+
+// TestPubSub_AcrossReplicas runs two instances against one NATS server, as
+// web replicas behind a load balancer do: a host event published by the
+// replica that handled the check-in reaches SSE clients on both.
+func TestPubSub_AcrossReplicas(t *testing.T) {
+	ctx := context.Background()
+
+	// Stands in for the external server the replicas share.
+	server, url, err := StartEmbedded(ctx)
+	if err != nil {
+		t.Fatalf("starting NATS server: %v", err)
+	}
+	defer server.Shutdown()
+
+	replicas := make([]*PubSub, 2)
+	for i := range replicas {
+		ps, err := New(ctx, &Config{NATSUrl: url, Replicas: len(replicas)})
+		if err != nil {
+			t.Fatalf("creating replica %d: %v", i, err)
+		}
+		defer func() {
+			_ = ps.Close()
+		}()
+		replicas[i] = ps
+	}
+
+	subCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	event := HostEvent{
+		OrganizationID: uuid.New(),
+		HostID:         uuid.New(),
+		HostIdentifier: "host-a",
+		Type:           HostEventCheckedIn,
+		OccurredAt:     time.Now().UTC().Truncate(time.Second),
+	}
+	topic := TopicHosts(event.OrganizationID)
+
+	var streams []<-chan *message.Message
+	for i, ps := range replicas {
+		sub, err := ps.NewSubscriber(ctx)
+		if err != nil {
+			t.Fatalf("creating subscriber on replica %d: %v", i, err)
+		}
+		defer func() {
+			_ = sub.Close()
+		}()
+		messages, err := sub.Subscribe(subCtx, topic)
+		if err != nil {
+			t.Fatalf("subscribing on replica %d: %v", i, err)
+		}
+		streams = append(streams, messages)
+	}
+
+	// Give subscribers time to be ready
+	time.Sleep(50 * time.Millisecond)
+
+	if err := replicas[0].Publisher().Publish(topic, event.ToMessage()); err != nil {
+		t.Fatalf("publishing: %v", err)
+	}
+
+	for i, messages := range streams {
+		select {
+		case msg := <-messages:
+			if msg == nil {
+				t.Fatalf("replica %d received nil message", i)
+			}
+			received, err := ParseHostEvent(msg)
+			if err != nil {
+				t.Fatalf("parsing event on replica %d: %v", i, err)
+			}
+			if received.HostID != event.HostID {
+				t.Fatalf("replica %d HostID = %v, want %v", i, received.HostID, event.HostID)
+			}
+			msg.Ack()
+		case <-time.After(5 * time.Second):
+			t.Fatalf("timeout waiting for message on replica %d", i)
+		}
+	}
+}
+
+func TestNew_EmbeddedRefusesReplicas(t *testing.T) {
+	ps, err := New(context.Background(), &Config{Replicas: 2})
+	if !errors.Is(err, ErrEmbeddedReplicas) {
+		if ps != nil {
+			_ = ps.Close()
+		}
+		t.Fatalf("New(embedded, 2 replicas) err = %v, want ErrEmbeddedReplicas", err)
+	}
+}
@@ -0,0 +1,156 @@
+package pubsub
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"sync"
+
+	"github.com/ThreeDotsLabs/watermill/message"
+	"github.com/google/uuid"
+)
+
+// MetadataSchemaVersion is the metadata key carrying the version of an
+// event's payload. Messages published before events were versioned don't
+// have it, and are version 1.
+const MetadataSchemaVersion = "schema_version"
+
+// ErrSchemaVersion is returned parsing a message whose schema version isn't
+// a positive integer.
+var ErrSchemaVersion = errors.New("invalid schema version")
+
+// Schema describes the payload versions of an event type, so subscribers
+// running an older or newer build during a rolling deploy can still read it.
+//
+// Versions may only add fields: a subscriber reading a newer version ignores
+// the fields it doesn't know. A change that renames, removes, or re-purposes
+// a field also needs an upgrade that rewrites older payloads to match. A
+// change older subscribers can't read without an upgrade needs a new event
+// type instead.
+type Schema struct {
+	// EventType is the event_type metadata of the event's messages.
+	EventType string
+
+	// Version is the version ToMessage publishes; zero is 1.
+	Version int
+
+	// Upgrades rewrite a payload's fields from one version to the next:
+	// Upgrades[0] from 1 to 2, and so on, up to Version. A nil upgrade is a
+	// version that only added fields.
+	Upgrades []func(fields map[string]json.RawMessage) error
+}
+
+// Schemas is a registry of event schemas.
+type Schemas struct {
+	mu      sync.RWMutex
+	schemas map[string]Schema
+}
+
+// Events is the registry the events in this package publish and parse
+// with. Each event type registers its schema from an init function.
+var Events = &Schemas{}
+
+// Register adds schema to the registry. It panics on a schema without an
+// event type, with the wrong number of upgrades for its version, or
+// registered twice, since all are programming errors.
+func (r *Schemas) Register(schema Schema) {
+	if schema.EventType == "" {
+		panic("pubsub: schema requires an event type")
+	}
+	if schema.Version == 0 {
+		schema.Version = 1
+	}
+	if len(schema.Upgrades) != schema.Version-1 {
+		panic(fmt.Sprintf("pubsub: schema %q version %d needs %d upgrades, has %d", schema.EventType, schema.Version, schema.Version-1, len(schema.Upgrades)))
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.schemas[schema.EventType]; ok {
+		panic(fmt.Sprintf("pubsub: schema %q registered twice", schema.EventType))
+	}
+	if r.schemas == nil {
+		r.schemas = make(map[string]Schema)
+	}
+	r.schemas[schema.EventType] = schema
+}
+
+// Schema returns the registered schema for eventType.
+func (r *Schemas) Schema(eventType string) (Schema, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	schema, ok := r.schemas[eventType]
+	return schema, ok
+}
+
+// SchemaVersion returns the schema version of msg's payload.
+func SchemaVersion(msg *message.Message) (int, error) {
+	v := msg.Metadata.Get(MetadataSchemaVersion)
+	if v == "" {
+		return 1, nil
+	}
+	version, err := strconv.Atoi(v)
+	if err != nil || version < 1 {
+		return 0, fmt.Errorf("%w: %q", ErrSchemaVersion, v)
+	}
+	return version, nil
+}
+
+// newMessage encodes an event of eventType as a message, stamped with its
+// event type and the version of its registered schema.
+func (r *Schemas) newMessage(eventType string, event any) *message.Message {
+	payload, err := json.Marshal(event)
+	if err != nil {
+		payload = []byte("{}")
+	}
+
+	msg := message.NewMessage(uuid.NewString(), payload)
+	msg.Metadata.Set("event_type", eventType)
+	if schema, ok := r.Schema(eventType); ok {
+		msg.Metadata.Set(MetadataSchemaVersion, strconv.Itoa(schema.Version))
+	}
+	return msg
+}
+
+// decode parses msg into event, upgrading payloads older than eventType's
+// registered schema first. Newer payloads are parsed as they are.
+func (r *Schemas) decode(msg *message.Message, eventType string, event any) error {
+	version, err := SchemaVersion(msg)
+	if err != nil {
+		return err
+	}
+
+	payload := []byte(msg.Payload)
+	if schema, ok := r.Schema(eventType); ok && version < schema.Version {
+		payload, err = upgradePayload(payload, schema.Upgrades[version-1:])
+		if err != nil {
+			return fmt.Errorf("upgrading from version %d: %w", version, err)
+		}
+	}
+	return json.Unmarshal(payload, event)
+}
+
+func upgradePayload(payload []byte, upgrades []func(map[string]json.RawMessage) error) ([]byte, error) {
+	var fields map[string]json.RawMessage
+	for _, upgrade := range upgrades {
+		if upgrade == nil {
+			continue
+		}
+		if fields == nil {
+			if err := json.Unmarshal(payload, &fields); err != nil {
+				return nil, err
+			}
+			if fields == nil {
+				fields = make(map[string]json.RawMessage)
+			}
+		}
+		if err := upgrade(fields); err != nil {
+			return nil, err
+		}
+	}
+	if fields == nil {
+		return payload, nil
+	}
+	return json.Marshal(fields)
+}
@@ -0,0 +1,103 @@
+package pubsub
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/ThreeDotsLabs/watermill/message"
+	"github.com/google/uuid"
+)
+
+func TestEvents_StampSchemaVersion(t *testing.T) {
+	msg := QueryResultEvent{HostID: uuid.New()}.ToMessage()
+	if got := msg.Metadata.Get(MetadataSchemaVersion); got != "1" {
+		t.Fatalf("schema_version = %q, want 1", got)
+	}
+
+	// Published before events were versioned.
+	msg.Metadata.Set(MetadataSchemaVersion, "")
+	if _, err := ParseQueryResultEvent(msg); err != nil {
+		t.Fatalf("unversioned: %v", err)
+	}
+
+	msg.Metadata.Set(MetadataSchemaVersion, "v2")
+	if _, err := ParseQueryResultEvent(msg); !errors.Is(err, ErrSchemaVersion) {
+		t.Fatalf("invalid version: err = %v, want ErrSchemaVersion", err)
+	}
+}
+
+func TestSchemas_Decode(t *testing.T) {
+	type resultV3 struct {
+		HostID    string `json:"host_id"`
+		Rows      int    `json:"rows"`
+		Truncated bool   `json:"truncated"`
+	}
+
+	registry := &Schemas{}
+	registry.Register(Schema{
+		EventType: "result",
+		Version:   3,
+		Upgrades: []func(map[string]json.RawMessage) error{
+			// Version 2 renamed row_count to rows.
+			func(fields map[string]json.RawMessage) error {
+				if v, ok := fields["row_count"]; ok {
+					fields["rows"] = v
+					delete(fields, "row_count")
+				}
+				return nil
+			},
+			// Version 3 added truncated.
+			nil,
+		},
+	})
+
+	tests := []struct {
+		name    string
+		version string
+		payload string
+		want    resultV3
+	}{
+		{"unversioned", "", `{"host_id":"a","row_count":5}`, resultV3{HostID: "a", Rows: 5}},
+		{"version 1", "1", `{"host_id":"a","row_count":5}`, resultV3{HostID: "a", Rows: 5}},
+		{"version 2", "2", `{"host_id":"a","rows":5}`, resultV3{HostID: "a", Rows: 5}},
+		{"current", "3", `{"host_id":"a","rows":5,"truncated":true}`, resultV3{HostID: "a", Rows: 5, Truncated: true}},
+		{"newer", "4", `{"host_id":"a","rows":5,"truncated":true,"result_ref":"s3://r"}`, resultV3{HostID: "a", Rows: 5, Truncated: true}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			msg := message.NewMessage("1", []byte(tt.payload))
+			msg.Metadata.Set(MetadataSchemaVersion, tt.version)
+			var got resultV3
+			if err := registry.decode(msg, "result", &got); err != nil {
+				t.Fatalf("decode: %v", err)
+			}
+			if got != tt.want {
+				t.Fatalf("decoded = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSchemas_Register(t *testing.T) {
+	registry := &Schemas{}
+	registry.Register(Schema{EventType: "a"})
+	if schema, ok := registry.Schema("a"); !ok || schema.Version != 1 {
+		t.Fatalf("schema = %+v, %v; want version 1", schema, ok)
+	}
+
+	for name, schema := range map[string]Schema{
+		"duplicate":        {EventType: "a"},
+		"no event type":    {Version: 1},
+		"missing upgrades": {EventType: "b", Version: 2},
+	} {
+		t.Run(name, func(t *testing.T) {
+			defer func() {
+				if recover() == nil {
+					t.Fatal("Register did not panic")
+				}
+			}()
+			registry.Register(schema)
+		})
+	}
+}
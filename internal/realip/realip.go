@@ -0,0 +1,135 @@
+// Package realip resolves the address of the client behind any trusted
+// reverse proxies. Middleware does it once per request; everything that
+// needs the client address (antibot, enrollment network rules, request
+// logging) reads it back with FromRequest.
+package realip
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/netip"
+	"strings"
+)
+
+type ctxKey struct{}
+
+// ParseTrusted parses a comma-separated list of proxy addresses and CIDRs,
+// e.g. "10.0.0.0/8, 127.0.0.1".
+func ParseTrusted(s string) ([]netip.Prefix, error) {
+	var trusted []netip.Prefix
+	for _, part := range strings.Split(s, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		if !strings.Contains(part, "/") {
+			addr, err := netip.ParseAddr(part)
+			if err != nil {
+				return nil, fmt.Errorf("parsing trusted proxy %q: %w", part, err)
+			}
+			addr = addr.Unmap()
+			trusted = append(trusted, netip.PrefixFrom(addr, addr.BitLen()))
+			continue
+		}
+		prefix, err := netip.ParsePrefix(part)
+		if err != nil {
+			return nil, fmt.Errorf("parsing trusted proxy %q: %w", part, err)
+		}
+		trusted = append(trusted, prefix.Masked())
+	}
+	return trusted, nil
+}
+
+// Middleware resolves the client address with Resolve and stores it in the
+// request context. It also sets RemoteAddr to it, so handlers and loggers
+// that read RemoteAddr directly (chi's middleware.Logger) see the client
+// rather than the proxy; install it before them.
+func Middleware(trusted []netip.Prefix) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			addr := Resolve(r, trusted)
+			if addr.IsValid() {
+				r = r.WithContext(context.WithValue(r.Context(), ctxKey{}, addr))
+				r.RemoteAddr = addr.String()
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// Resolve returns the client address of r. Forwarding headers are only
+// believed when the connection comes from a trusted proxy; X-Forwarded-For
+// is then read right to left, skipping trusted hops, so a client can't
+// choose its address by sending the header itself.
+func Resolve(r *http.Request, trusted []netip.Prefix) netip.Addr {
+	addr := parseAddr(r.RemoteAddr)
+	if !isTrusted(addr, trusted) {
+		return addr
+	}
+
+	var hops []string
+	for _, v := range r.Header.Values("X-Forwarded-For") {
+		hops = append(hops, strings.Split(v, ",")...)
+	}
+	if len(hops) == 0 {
+		if real := parseAddr(strings.TrimSpace(r.Header.Get("X-Real-IP"))); real.IsValid() {
+			return real
+		}
+		return addr
+	}
+
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop := parseAddr(strings.TrimSpace(hops[i]))
+		if !hop.IsValid() {
+			// A trusted proxy forwarded garbage; stop at the last hop we
+			// could read.
+			return addr
+		}
+		addr = hop
+		if !isTrusted(addr, trusted) {
+			return addr
+		}
+	}
+	return addr
+}
+
+// FromContext returns the client address stored by Middleware.
+func FromContext(ctx context.Context) (netip.Addr, bool) {
+	addr, ok := ctx.Value(ctxKey{}).(netip.Addr)
+	return addr, ok
+}
+
+// FromRequest returns the client address stored by Middleware, or the
+// connection's peer address if the middleware did not run. The zero Addr
+// means it could not be parsed.
+func FromRequest(r *http.Request) netip.Addr {
+	if addr, ok := FromContext(r.Context()); ok {
+		return addr
+	}
+	return parseAddr(r.RemoteAddr)
+}
+
+// parseAddr parses "ip" or "ip:port", unmapping IPv4-mapped IPv6 addresses.
+func parseAddr(s string) netip.Addr {
+	if ap, err := netip.ParseAddrPort(s); err == nil {
+		return ap.Addr().Unmap()
+	}
+	addr, err := netip.ParseAddr(s)
+	if err != nil {
+		return netip.Addr{}
+	}
+	return addr.Unmap()
+}
+
+func isTrusted(addr netip.Addr, trusted []netip.Prefix) bool {
+	if !addr.IsValid() {
+		return false
+	}
+	for _, p := range trusted {
+		if p.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,102 @@
+package realip
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"testing"
+)
+
+func TestParseTrusted(t *testing.T) {
+	trusted, err := ParseTrusted(" 10.1.2.3/8, 127.0.0.1 ,::1,")
+	if err != nil {
+		t.Fatalf("ParseTrusted: %v", err)
+	}
+	want := []string{"10.0.0.0/8", "127.0.0.1/32", "::1/128"}
+	if len(trusted) != len(want) {
+		t.Fatalf("trusted = %v, want %v", trusted, want)
+	}
+	for i := range want {
+		if trusted[i].String() != want[i] {
+			t.Fatalf("trusted[%d] = %s, want %s", i, trusted[i], want[i])
+		}
+	}
+
+	if _, err := ParseTrusted("proxy.internal"); err == nil {
+		t.Fatalf("expected an error for a hostname")
+	}
+}
+
+func TestResolve(t *testing.T) {
+	trusted, err := ParseTrusted("10.0.0.0/8")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name       string
+		remoteAddr string
+		xff        []string
+		realIP     string
+		want       string
+	}{
+		{"direct", "203.0.113.9:4000", nil, "", "203.0.113.9"},
+		{"spoofed header from untrusted peer", "203.0.113.9:4000", []string{"198.51.100.1"}, "", "203.0.113.9"},
+		{"trusted proxy", "10.0.0.2:4000", []string{"198.51.100.1"}, "", "198.51.100.1"},
+		{"client-supplied prefix is skipped", "10.0.0.2:4000", []string{"1.1.1.1, 198.51.100.1"}, "", "198.51.100.1"},
+		{"chain of trusted proxies", "10.0.0.2:4000", []string{"198.51.100.1, 10.0.0.3", "10.0.0.4"}, "", "198.51.100.1"},
+		{"all hops trusted", "10.0.0.2:4000", []string{"10.0.0.3"}, "", "10.0.0.3"},
+		{"garbage hop", "10.0.0.2:4000", []string{"198.51.100.1, nonsense"}, "", "10.0.0.2"},
+		{"x-real-ip", "10.0.0.2:4000", nil, "198.51.100.7", "198.51.100.7"},
+		{"mapped address", "[::ffff:203.0.113.9]:4000", nil, "", "203.0.113.9"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			r.RemoteAddr = tt.remoteAddr
+			for _, v := range tt.xff {
+				r.Header.Add("X-Forwarded-For", v)
+			}
+			if tt.realIP != "" {
+				r.Header.Set("X-Real-IP", tt.realIP)
+			}
+
+			if got := Resolve(r, trusted); got != netip.MustParseAddr(tt.want) {
+				t.Fatalf("Resolve = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMiddleware(t *testing.T) {
+	trusted, err := ParseTrusted("10.0.0.0/8")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var gotAddr netip.Addr
+	var gotRemote string
+	h := Middleware(trusted)(http.HandlerFunc(func(_ http.ResponseWriter, r *http.Request) {
+		gotAddr = FromRequest(r)
+		gotRemote = r.RemoteAddr
+	}))
+
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.RemoteAddr = "10.0.0.2:4000"
+	r.Header.Set("X-Forwarded-For", "198.51.100.1")
+	h.ServeHTTP(httptest.NewRecorder(), r)
+
+	if gotAddr != netip.MustParseAddr("198.51.100.1") || gotRemote != "198.51.100.1" {
+		t.Fatalf("FromRequest = %s, RemoteAddr = %q", gotAddr, gotRemote)
+	}
+}
+
+func TestFromRequestWithoutMiddleware(t *testing.T) {
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.RemoteAddr = "203.0.113.9:4000"
+	r.Header.Set("X-Forwarded-For", "198.51.100.1")
+
+	if got := FromRequest(r); got != netip.MustParseAddr("203.0.113.9") {
+		t.Fatalf("FromRequest = %s", got)
+	}
+}
@@ -0,0 +1,53 @@
+package requestsign
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+func TestSignVerify(t *testing.T) {
+	now := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	hostID := uuid.New()
+	salt := []byte("0123456789abcdef")
+	keys := NewKeys("secret")
+	key := keys.HostKey(hostID, salt)
+	body := []byte(`{"node_key":"abc"}`)
+
+	header := Sign(key, now, "/osquery/logger", body)
+	if err := Verify(key, header, "/osquery/logger", body, now.Add(MaxSkew)); err != nil {
+		t.Fatalf("Verify: %v", err)
+	}
+
+	for name, tc := range map[string]struct {
+		key    []byte
+		header string
+		path   string
+		body   []byte
+		now    time.Time
+		want   error
+	}{
+		"unsigned":     {key, "", "/osquery/logger", body, now, ErrMissing},
+		"other body":   {key, header, "/osquery/logger", []byte(`{"node_key":"abd"}`), now, ErrInvalid},
+		"other path":   {key, header, "/osquery/distributed_write", body, now, ErrInvalid},
+		"other salt":   {keys.HostKey(hostID, []byte("fedcba9876543210")), header, "/osquery/logger", body, now, ErrInvalid},
+		"other host":   {keys.HostKey(uuid.New(), salt), header, "/osquery/logger", body, now, ErrInvalid},
+		"other secret": {NewKeys("other").HostKey(hostID, salt), header, "/osquery/logger", body, now, ErrInvalid},
+		"too old":      {key, header, "/osquery/logger", body, now.Add(MaxSkew + time.Second), ErrStale},
+		"too new":      {key, header, "/osquery/logger", body, now.Add(-MaxSkew - time.Second), ErrStale},
+		"garbled":      {key, "t=x,v1=zz", "/osquery/logger", body, now, ErrInvalid},
+	} {
+		if err := Verify(tc.key, tc.header, tc.path, tc.body, tc.now); !errors.Is(err, tc.want) {
+			t.Errorf("%s: err = %v, want %v", name, err, tc.want)
+		}
+	}
+
+	// Changing the timestamp invalidates the signature.
+	forged := bytes.Replace([]byte(header), []byte("t=1"), []byte("t=2"), 1)
+	if err := Verify(key, string(forged), "/osquery/logger", body, now); !errors.Is(err, ErrInvalid) {
+		t.Fatalf("Verify(forged timestamp) err = %v, want ErrInvalid", err)
+	}
+}
@@ -0,0 +1,72 @@
+package security
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/a-h/templ"
+)
+
+func TestHeaders(t *testing.T) {
+	var nonce string
+	handler := Headers(DefaultConfig())(http.HandlerFunc(func(_ http.ResponseWriter, r *http.Request) {
+		nonce = templ.GetNonce(r.Context())
+	}))
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+
+	if nonce == "" {
+		t.Fatalf("no nonce in request context")
+	}
+	csp := rec.Header().Get("Content-Security-Policy")
+	if !strings.Contains(csp, "'nonce-"+nonce+"'") {
+		t.Fatalf("CSP %q does not allow the request nonce", csp)
+	}
+	if !strings.Contains(csp, "frame-ancestors 'none'") {
+		t.Fatalf("CSP %q missing frame-ancestors", csp)
+	}
+
+	want := map[string]string{
+		"X-Content-Type-Options":    "nosniff",
+		"Referrer-Policy":           "strict-origin-when-cross-origin",
+		"Strict-Transport-Security": "max-age=63072000; includeSubDomains",
+		"X-Frame-Options":           "DENY",
+	}
+	for header, value := range want {
+		if got := rec.Header().Get(header); got != value {
+			t.Errorf("%s = %q, want %q", header, got, value)
+		}
+	}
+
+	first := nonce
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+	if nonce == first {
+		t.Fatalf("nonce reused across requests")
+	}
+}
+
+func TestHeaders_Configured(t *testing.T) {
+	handler := Headers(Config{
+		CSPEnabled:     true,
+		CSPReportOnly:  true,
+		FrameAncestors: "'self'",
+	})(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {}))
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+
+	if rec.Header().Get("Content-Security-Policy") != "" {
+		t.Fatalf("enforced CSP sent in report-only mode")
+	}
+	if csp := rec.Header().Get("Content-Security-Policy-Report-Only"); !strings.Contains(csp, "frame-ancestors 'self'") {
+		t.Fatalf("report-only CSP = %q", csp)
+	}
+	for _, header := range []string{"Strict-Transport-Security", "X-Frame-Options", "Referrer-Policy"} {
+		if got := rec.Header().Get(header); got != "" {
+			t.Errorf("%s = %q, want unset", header, got)
+		}
+	}
+}
@@ -0,0 +1,48 @@
+package sharelink
+
+import (
+	"encoding/base64"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+func TestSigner(t *testing.T) {
+	now := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	link := Link{ID: uuid.New(), OrganizationID: uuid.New(), ExpiresAt: now.Add(time.Hour)}
+	s := NewSigner("secret", "result-view")
+
+	token := s.Sign(link)
+	got, err := s.Verify(token, now)
+	if err != nil {
+		t.Fatalf("Verify: %v", err)
+	}
+	if got.ID != link.ID || got.OrganizationID != link.OrganizationID || !got.ExpiresAt.Equal(link.ExpiresAt) {
+		t.Fatalf("Verify = %+v, want %+v", got, link)
+	}
+
+	if _, err := s.Verify(token, link.ExpiresAt); !errors.Is(err, ErrExpired) {
+		t.Fatalf("Verify(at expiry) err = %v, want ErrExpired", err)
+	}
+	if _, err := NewSigner("other", "result-view").Verify(token, now); !errors.Is(err, ErrInvalid) {
+		t.Fatalf("Verify(other secret) err = %v, want ErrInvalid", err)
+	}
+	if _, err := NewSigner("secret", "other").Verify(token, now); !errors.Is(err, ErrInvalid) {
+		t.Fatalf("Verify(other purpose) err = %v, want ErrInvalid", err)
+	}
+
+	// Extending the expiry invalidates the signature.
+	b, _ := base64.RawURLEncoding.DecodeString(token)
+	b[payloadSize-1]++
+	if _, err := s.Verify(base64.RawURLEncoding.EncodeToString(b), now); !errors.Is(err, ErrInvalid) {
+		t.Fatalf("Verify(tampered) err = %v, want ErrInvalid", err)
+	}
+
+	for _, bad := range []string{"", "not base64!", token[:len(token)-4]} {
+		if _, err := s.Verify(bad, now); !errors.Is(err, ErrInvalid) {
+			t.Errorf("Verify(%q) err = %v, want ErrInvalid", bad, err)
+		}
+	}
+}
@@ -0,0 +1,96 @@
+package simulator
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/cavenine/queryops/features/osquery"
+)
+
+// fakeServer implements just enough of the osquery TLS API to exercise agents.
+type fakeServer struct {
+	mu       sync.Mutex
+	enrolled map[string]string // node key -> host identifier
+	written  map[string]int    // host identifier -> distributed writes
+}
+
+func (s *fakeServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	switch r.URL.Path {
+	case "/osquery/enroll":
+		var req osquery.EnrollmentRequest
+		_ = json.NewDecoder(r.Body).Decode(&req)
+		if req.EnrollSecret != "secret" {
+			_ = json.NewEncoder(w).Encode(osquery.EnrollmentResponse{NodeInvalid: true})
+			return
+		}
+		key := "key-" + req.HostIdentifier
+		s.enrolled[key] = req.HostIdentifier
+		_ = json.NewEncoder(w).Encode(osquery.EnrollmentResponse{NodeKey: key})
+	case "/osquery/config":
+		_ = json.NewEncoder(w).Encode(osquery.ConfigResponse{})
+	case "/osquery/logger":
+		_ = json.NewEncoder(w).Encode(osquery.LoggerResponse{})
+	case "/osquery/distributed_read":
+		_ = json.NewEncoder(w).Encode(osquery.DistributedReadResponse{
+			Queries: map[string]string{"q1": "SELECT * FROM processes"},
+		})
+	case "/osquery/distributed_write":
+		var req osquery.DistributedWriteRequest
+		_ = json.NewDecoder(r.Body).Decode(&req)
+		if len(req.Queries["q1"]) > 0 && req.Statuses["q1"] == 0 {
+			s.written[s.enrolled[req.NodeKey]]++
+		}
+		_ = json.NewEncoder(w).Encode(osquery.DistributedWriteResponse{})
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func TestRun(t *testing.T) {
+	fake := &fakeServer{enrolled: map[string]string{}, written: map[string]int{}}
+	srv := httptest.NewServer(fake)
+	defer srv.Close()
+
+	cfg := DefaultConfig()
+	cfg.ServerURL = srv.URL
+	cfg.EnrollSecret = "secret"
+	cfg.Hosts = 5
+	cfg.RampUp = 0
+	cfg.DistributedInterval = 20 * time.Millisecond
+	cfg.StatsInterval = 0
+
+	ctx, cancel := context.WithTimeout(context.Background(), 300*time.Millisecond)
+	defer cancel()
+
+	stats, err := Run(ctx, cfg)
+	if err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+
+	if got := stats.Enrolled.Load(); got != 5 {
+		t.Fatalf("Enrolled = %d, want 5", got)
+	}
+	if stats.QueriesAnswered.Load() == 0 {
+		t.Fatal("QueriesAnswered = 0")
+	}
+
+	fake.mu.Lock()
+	defer fake.mu.Unlock()
+	if len(fake.written) != 5 {
+		t.Fatalf("hosts that answered = %d, want 5", len(fake.written))
+	}
+}
+
+func TestRun_ValidatesConfig(t *testing.T) {
+	if _, err := Run(context.Background(), Config{ServerURL: "http://x", Hosts: 1}); err == nil {
+		t.Fatal("Run without enroll secret: want error")
+	}
+}
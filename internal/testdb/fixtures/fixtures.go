@@ -0,0 +1,163 @@
+// Package fixtures inserts common rows for database tests. Every helper fails
+// the test on error, so callers can use the returned values directly.
+package fixtures
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+// DefaultPasswordHash is the password_hash stored by CreateUser.
+const DefaultPasswordHash = "hash"
+
+// Querier is satisfied by *pgxpool.Pool and pgx.Tx.
+type Querier interface {
+	QueryRow(ctx context.Context, sql string, args ...any) pgx.Row
+	Exec(ctx context.Context, sql string, args ...any) (pgconn.CommandTag, error)
+}
+
+type User struct {
+	ID    int
+	Email string
+}
+
+// CreateUser inserts a user with DefaultPasswordHash.
+func CreateUser(t testing.TB, db Querier, email string) User {
+	t.Helper()
+
+	u := User{Email: email}
+	err := db.QueryRow(context.Background(), `
+		INSERT INTO users (email, password_hash) VALUES ($1, $2) RETURNING id
+	`, email, DefaultPasswordHash).Scan(&u.ID)
+	if err != nil {
+		t.Fatalf("fixtures: creating user %q: %v", email, err)
+	}
+	return u
+}
+
+type Org struct {
+	ID   uuid.UUID
+	Name string
+}
+
+// CreateOrg inserts an organization with no members.
+func CreateOrg(t testing.TB, db Querier, name string) Org {
+	t.Helper()
+
+	o := Org{Name: name}
+	err := db.QueryRow(context.Background(), `
+		INSERT INTO organizations (name) VALUES ($1) RETURNING id
+	`, name).Scan(&o.ID)
+	if err != nil {
+		t.Fatalf("fixtures: creating organization %q: %v", name, err)
+	}
+	return o
+}
+
+// AddMember adds userID to the organization with role "owner", "admin", or
+// "member".
+func AddMember(t testing.TB, db Querier, orgID uuid.UUID, userID int, role string) {
+	t.Helper()
+
+	_, err := db.Exec(context.Background(), `
+		INSERT INTO organization_members (user_id, organization_id, role) VALUES ($1, $2, $3)
+	`, userID, orgID, role)
+	if err != nil {
+		t.Fatalf("fixtures: adding user %d to organization %s: %v", userID, orgID, err)
+	}
+}
+
+// CreateEnrollSecret registers an active enroll secret for the organization.
+// It is stored in plaintext, as rows from before encryption are, so no
+// keyring is needed.
+func CreateEnrollSecret(t testing.TB, db Querier, orgID uuid.UUID, secret string) {
+	t.Helper()
+
+	_, err := db.Exec(context.Background(), `
+		INSERT INTO organization_enroll_secrets (secret, secret_hash, organization_id)
+		VALUES ($1, sha256(convert_to($1, 'UTF8')), $2)
+	`, secret, orgID)
+	if err != nil {
+		t.Fatalf("fixtures: creating enroll secret for organization %s: %v", orgID, err)
+	}
+}
+
+type Host struct {
+	ID             uuid.UUID
+	OrganizationID uuid.UUID
+	HostIdentifier string
+	NodeKey        string
+}
+
+type hostParams struct {
+	enrolledAt time.Time
+}
+
+// HostOption customizes CreateHost.
+type HostOption func(*hostParams)
+
+// EnrolledAt sets the host's last_enrollment_at (default now).
+func EnrolledAt(at time.Time) HostOption {
+	return func(p *hostParams) { p.enrolledAt = at }
+}
+
+// CreateHost inserts an enrolled host with a random node key.
+func CreateHost(t testing.TB, db Querier, orgID uuid.UUID, hostIdentifier string, opts ...HostOption) Host {
+	t.Helper()
+
+	p := hostParams{enrolledAt: time.Now()}
+	for _, opt := range opts {
+		opt(&p)
+	}
+
+	h := Host{OrganizationID: orgID, HostIdentifier: hostIdentifier, NodeKey: uuid.NewString()}
+	err := db.QueryRow(context.Background(), `
+		INSERT INTO hosts (organization_id, host_identifier, node_key_hash, node_key_issued_at, last_enrollment_at)
+		VALUES ($1, $2, sha256(convert_to($3, 'UTF8')), $4, $4)
+		RETURNING id
+	`, orgID, hostIdentifier, h.NodeKey, p.enrolledAt).Scan(&h.ID)
+	if err != nil {
+		t.Fatalf("fixtures: creating host %q: %v", hostIdentifier, err)
+	}
+	return h
+}
+
+type Campaign struct {
+	ID             uuid.UUID
+	OrganizationID uuid.UUID
+	Query          string
+	HostIDs        []uuid.UUID
+}
+
+// CreateCampaign inserts a pending campaign with a pending target per host,
+// as if queued but not yet picked up by any host.
+func CreateCampaign(t testing.TB, db Querier, orgID uuid.UUID, query string, hostIDs ...uuid.UUID) Campaign {
+	t.Helper()
+	ctx := context.Background()
+
+	c := Campaign{OrganizationID: orgID, Query: query, HostIDs: hostIDs}
+	err := db.QueryRow(ctx, `
+		INSERT INTO campaigns (organization_id, query, target_count)
+		VALUES ($1, $2, $3)
+		RETURNING id
+	`, orgID, query, len(hostIDs)).Scan(&c.ID)
+	if err != nil {
+		t.Fatalf("fixtures: creating campaign: %v", err)
+	}
+
+	if len(hostIDs) > 0 {
+		_, err = db.Exec(ctx, `
+			INSERT INTO campaign_targets (campaign_id, host_id)
+			SELECT $1, unnest($2::uuid[])
+		`, c.ID, hostIDs)
+		if err != nil {
+			t.Fatalf("fixtures: creating campaign targets: %v", err)
+		}
+	}
+	return c
+}
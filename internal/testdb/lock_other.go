@@ -0,0 +1,12 @@
+//go:build !unix
+
+package testdb
+
+import "os"
+
+// Without flock, parallel test processes may race to create the shared
+// container or template; the in-process mutex still serializes tests within
+// one package.
+func lockFile(*os.File) error { return nil }
+
+func unlockFile(*os.File) error { return nil }
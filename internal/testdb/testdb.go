@@ -2,8 +2,12 @@ package testdb
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
+	"io/fs"
 	"os"
+	"path/filepath"
 	"strings"
 	"sync"
 	"testing"
@@ -28,11 +32,12 @@ const (
 
 	reuseEnvVar        = "QUERYOPS_TESTDB_REUSE"
 	reuseContainerName = "queryops-testdb-postgres" // used by WithReuseByName
-	snapshotName       = "queryops_migrated_template"
+	templatePrefix     = "queryops_migrated_"
 )
 
-var snapshotMu sync.Mutex
-var snapshotReady bool
+// sharedServer is set by RunWithPostgres; when nil, each SetupTestDB call
+// starts its own container (or the reused one, with QUERYOPS_TESTDB_REUSE).
+var sharedServer *server
 
 func reuseEnabled() bool {
 	v := strings.TrimSpace(os.Getenv(reuseEnvVar))
@@ -69,14 +74,90 @@ type TestDB struct {
 	Password string
 }
 
-func SetupTestDB(t *testing.T) *TestDB {
+// RunWithPostgres runs the package's tests against one Postgres container
+// shared by every test package in the same `go test` invocation. Use it from
+// TestMain:
+//
+//	func TestMain(m *testing.M) {
+//		testdb.RunWithPostgres(m)
+//	}
+//
+// Each SetupTestDB call still gets its own database, cloned from a migrated
+// template, so tests may run in parallel. If Docker is unavailable, tests run
+// anyway and SetupTestDB skips.
+func RunWithPostgres(m *testing.M) int {
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Minute)
+
+	// Test binaries for each package are children of the same go command, so
+	// the parent PID names the container for this run. The testcontainers
+	// reaper removes it when the run ends.
+	name := fmt.Sprintf("queryops-testdb-%d", os.Getppid())
+	if reuseEnabled() {
+		name = reuseContainerName
+	}
+
+	srv, err := startServer(ctx, name)
+	cancel()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "testdb: postgres unavailable, database tests will skip: %v\n", err)
+	} else {
+		sharedServer = srv
+	}
+
+	return m.Run()
+}
+
+func SetupTestDB(t testing.TB) *TestDB {
 	t.Helper()
 
 	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Minute)
 	defer cancel()
 
-	reuse := reuseEnabled()
+	srv := sharedServer
+	if srv == nil {
+		name := ""
+		if reuseEnabled() {
+			// Experimental: keep container around across `go test` runs.
+			name = reuseContainerName
+		}
+
+		var err error
+		srv, err = startServer(ctx, name)
+		if err != nil {
+			// Common when Docker isn't available (some CI/dev environments).
+			t.Skipf("starting postgres testcontainer: %v", err)
+			return nil
+		}
+		if name == "" {
+			t.Cleanup(func() {
+				termCtx, termCancel := context.WithTimeout(context.Background(), 30*time.Second)
+				defer termCancel()
+				_ = srv.container.Terminate(termCtx)
+			})
+		}
+	}
+
+	tdb, err := srv.newTestDB(ctx, t)
+	if err != nil {
+		t.Fatalf("setting up test database: %v", err)
+	}
+	return tdb
+}
+
+// server is a running Postgres container holding a migrated template.
+type server struct {
+	container *postgres.PostgresContainer
+	host      string
+	port      string
 
+	templateMu    sync.Mutex
+	templateReady bool
+}
+
+// startServer starts a Postgres container. A non-empty name reuses the
+// container with that name if it exists, including one started by another
+// test process.
+func startServer(ctx context.Context, name string) (*server, error) {
 	containerOpts := []testcontainers.ContainerCustomizer{
 		postgres.WithDatabase(defaultDatabase),
 		postgres.WithUsername(defaultUser),
@@ -88,242 +169,268 @@ func SetupTestDB(t *testing.T) *TestDB {
 			wait.ForLog("database system is ready to accept connections").WithOccurrence(2),
 		),
 	}
-	if reuse {
-		// Experimental: keep container around across `go test` runs.
+
+	var container *postgres.PostgresContainer
+	run := func() error {
+		var err error
+		container, err = postgres.Run(ctx, defaultImage, containerOpts...)
+		return err
+	}
+
+	if name != "" {
 		containerOpts = append(containerOpts,
-			testcontainers.WithReuseByName(reuseContainerName),
+			testcontainers.WithReuseByName(name),
 			postgres.WithSQLDriver("pgx/v5"),
 		)
+		// Parallel test processes would otherwise race to create the same
+		// named container.
+		if err := withFileLock(name, run); err != nil {
+			return nil, err
+		}
+	} else if err := run(); err != nil {
+		return nil, err
 	}
 
-	container, err := postgres.Run(ctx, defaultImage, containerOpts...)
+	host, err := container.Host(ctx)
 	if err != nil {
-		// Common when Docker isn't available (some CI/dev environments).
-		t.Skipf("starting postgres testcontainer: %v", err)
-		return nil
+		return nil, fmt.Errorf("getting container host: %w", err)
 	}
-
-	if !reuse {
-		t.Cleanup(func() {
-			termCtx, termCancel := context.WithTimeout(context.Background(), 30*time.Second)
-			defer termCancel()
-			_ = container.Terminate(termCtx)
-		})
+	mappedPort, err := container.MappedPort(ctx, "5432/tcp")
+	if err != nil {
+		return nil, fmt.Errorf("getting mapped port: %w", err)
 	}
 
-	host, err := container.Host(ctx)
+	return &server{container: container, host: host, port: mappedPort.Port()}, nil
+}
+
+func (s *server) dsn(database string) string {
+	return fmt.Sprintf("postgres://%s:%s@%s:%s/%s?sslmode=disable", defaultUser, defaultPassword, s.host, s.port, database)
+}
+
+func (s *server) adminPool(ctx context.Context) (*pgxpool.Pool, error) {
+	cfg, err := pgxpool.ParseConfig(s.dsn("postgres"))
 	if err != nil {
-		t.Fatalf("getting container host: %v", err)
+		return nil, fmt.Errorf("parsing admin pool config: %w", err)
 	}
-	mappedPort, err := container.MappedPort(ctx, "5432/tcp")
+	cfg.MinConns = 0
+	cfg.MaxConns = 1
+
+	pool, err := pgxpool.NewWithConfig(ctx, cfg)
 	if err != nil {
-		t.Fatalf("getting mapped port: %v", err)
+		return nil, fmt.Errorf("creating admin pool: %w", err)
 	}
+	return pool, nil
+}
 
-	port := mappedPort.Port()
-	baseDSN := fmt.Sprintf("postgres://%s:%s@%s:%s/%s?sslmode=disable", defaultUser, defaultPassword, host, port, defaultDatabase)
-	dsn := baseDSN
-	dbName := defaultDatabase
-
-	if reuse {
-		ensureMigratedSnapshot(t, ctx, container, baseDSN)
+// newTestDB clones the migrated template into a fresh database that is
+// dropped when the test ends.
+func (s *server) newTestDB(ctx context.Context, t testing.TB) (*TestDB, error) {
+	template, err := s.ensureTemplate(ctx)
+	if err != nil {
+		return nil, err
+	}
 
-		dbName = newTestDatabaseName()
+	adminPool, err := s.adminPool(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer adminPool.Close()
 
-		adminCfg, err := pgxpool.ParseConfig(baseDSN)
-		if err != nil {
-			t.Fatalf("parsing admin pool config: %v", err)
+	dbName := newTestDatabaseName()
+	createStmt := fmt.Sprintf(
+		"CREATE DATABASE %s WITH TEMPLATE %s OWNER %s",
+		quoteIdent(dbName),
+		quoteIdent(template),
+		quoteIdent(defaultUser),
+	)
+	// Cloning fails while another process briefly connects to the template.
+	for attempt := 1; ; attempt++ {
+		_, err = adminPool.Exec(ctx, createStmt)
+		if err == nil || attempt == 5 || !strings.Contains(err.Error(), "being accessed by other users") {
+			break
 		}
-		adminCfg.ConnConfig.Database = "postgres"
-		adminCfg.MinConns = 0
-		adminCfg.MaxConns = 1
+		time.Sleep(200 * time.Millisecond)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("creating test db %q from template: %w", dbName, err)
+	}
+
+	// Drop the per-test database but keep the container.
+	// Register before pool.Close so it runs after.
+	t.Cleanup(func() {
+		dropCtx, dropCancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer dropCancel()
 
-		adminPool, err := pgxpool.NewWithConfig(ctx, adminCfg)
+		p, err := s.adminPool(dropCtx)
 		if err != nil {
-			t.Fatalf("creating admin pool: %v", err)
+			return
 		}
-		defer adminPool.Close()
+		defer p.Close()
 
-		createStmt := fmt.Sprintf(
-			"CREATE DATABASE %s WITH TEMPLATE %s OWNER %s",
-			quoteIdent(dbName),
-			quoteIdent(snapshotName),
-			quoteIdent(defaultUser),
-		)
-		if _, err := adminPool.Exec(ctx, createStmt); err != nil {
-			t.Fatalf("creating test db %q from template: %v", dbName, err)
+		dropStmt := "DROP DATABASE IF EXISTS " + quoteIdent(dbName) + " WITH (FORCE)"
+		if _, err := p.Exec(dropCtx, dropStmt); err != nil {
+			_, _ = p.Exec(dropCtx, "DROP DATABASE IF EXISTS "+quoteIdent(dbName))
 		}
+	})
 
-		dsn = fmt.Sprintf("postgres://%s:%s@%s:%s/%s?sslmode=disable", defaultUser, defaultPassword, host, port, dbName)
-
-		// Drop the per-test database but keep the reused container.
-		// Register before pool.Close so it runs after.
-		t.Cleanup(func() {
-			dropCtx, dropCancel := context.WithTimeout(context.Background(), 30*time.Second)
-			defer dropCancel()
-
-			cfg, err := pgxpool.ParseConfig(baseDSN)
-			if err != nil {
-				return
-			}
-			cfg.ConnConfig.Database = "postgres"
-			cfg.MinConns = 0
-			cfg.MaxConns = 1
-
-			p, err := pgxpool.NewWithConfig(dropCtx, cfg)
-			if err != nil {
-				return
-			}
-			defer p.Close()
-
-			dropStmt := "DROP DATABASE IF EXISTS " + quoteIdent(dbName) + " WITH (FORCE)"
-			if _, err := p.Exec(dropCtx, dropStmt); err != nil {
-				_, _ = p.Exec(dropCtx, "DROP DATABASE IF EXISTS "+quoteIdent(dbName))
-			}
-		})
-	}
-
+	dsn := s.dsn(dbName)
 	poolCfg, err := pgxpool.ParseConfig(dsn)
 	if err != nil {
-		t.Fatalf("parsing pgx pool config: %v", err)
+		return nil, fmt.Errorf("parsing pgx pool config: %w", err)
 	}
 	poolCfg.MinConns = 0
 	poolCfg.MaxConns = 4
 
 	pool, err := pgxpool.NewWithConfig(ctx, poolCfg)
 	if err != nil {
-		t.Fatalf("creating pgx pool: %v", err)
+		return nil, fmt.Errorf("creating pgx pool: %w", err)
 	}
 	if err := pool.Ping(ctx); err != nil {
 		pool.Close()
-		t.Fatalf("pinging db: %v", err)
+		return nil, fmt.Errorf("pinging db: %w", err)
 	}
-
 	t.Cleanup(pool.Close)
 
-	if !reuse {
-		if err := migrations.Up(dsn); err != nil {
-			t.Fatalf("applying migrations: %v", err)
-		}
-
-		migrator, err := rivermigrate.New(riverpgxv5.New(pool), nil)
-		if err != nil {
-			t.Fatalf("creating river migrator: %v", err)
-		}
-		if _, err := migrator.Migrate(ctx, rivermigrate.DirectionUp, nil); err != nil {
-			t.Fatalf("running river migrations: %v", err)
-		}
-	}
-
 	return &TestDB{
-		Container: container,
+		Container: s.container,
 		Pool:      pool,
 		DSN:       dsn,
-		Host:      host,
-		Port:      port,
+		Host:      s.host,
+		Port:      s.port,
 		Database:  dbName,
 		User:      defaultUser,
 		Password:  defaultPassword,
-	}
+	}, nil
 }
 
-func ensureMigratedSnapshot(t *testing.T, ctx context.Context, container *postgres.PostgresContainer, dsn string) {
-	t.Helper()
+// ensureTemplate creates the migrated template database if needed and
+// returns its name. The name includes a hash of the migrations, so a reused
+// container never serves a stale schema.
+func (s *server) ensureTemplate(ctx context.Context) (string, error) {
+	name, err := templateName()
+	if err != nil {
+		return "", err
+	}
 
-	snapshotMu.Lock()
-	defer snapshotMu.Unlock()
-	if snapshotReady {
-		return
+	s.templateMu.Lock()
+	defer s.templateMu.Unlock()
+	if s.templateReady {
+		return name, nil
 	}
 
-	// Snapshot/restore uses a template database. Detect it by checking pg_database
-	// from the system database.
-	adminCfg, err := pgxpool.ParseConfig(dsn)
+	err = withFileLock(s.host+"-"+s.port, func() error {
+		return s.buildTemplate(ctx, name)
+	})
 	if err != nil {
-		t.Fatalf("parsing admin pool config: %v", err)
+		return "", err
 	}
-	adminCfg.ConnConfig.Database = "postgres"
-	adminCfg.MinConns = 0
-	adminCfg.MaxConns = 1
+	s.templateReady = true
+	return name, nil
+}
 
-	adminPool, err := pgxpool.NewWithConfig(ctx, adminCfg)
+// buildTemplate migrates a scratch database and renames it into place, so
+// other processes only ever see a complete template.
+func (s *server) buildTemplate(ctx context.Context, name string) error {
+	adminPool, err := s.adminPool(ctx)
 	if err != nil {
-		t.Fatalf("creating admin pool: %v", err)
+		return err
 	}
 	defer adminPool.Close()
 
-	if err := adminPool.Ping(ctx); err != nil {
-		t.Fatalf("pinging admin db: %v", err)
+	var exists bool
+	if err := adminPool.QueryRow(ctx, `SELECT EXISTS (SELECT 1 FROM pg_database WHERE datname = $1)`, name).Scan(&exists); err != nil {
+		return fmt.Errorf("checking template db exists: %w", err)
 	}
-
-	var baseDBExists bool
-	if err := adminPool.QueryRow(ctx, `SELECT EXISTS (SELECT 1 FROM pg_database WHERE datname = $1)`, defaultDatabase).Scan(&baseDBExists); err != nil {
-		t.Fatalf("checking base db exists: %v", err)
-	}
-	if !baseDBExists {
-		if _, err := adminPool.Exec(ctx, "CREATE DATABASE "+defaultDatabase); err != nil {
-			t.Fatalf("creating base db %q: %v", defaultDatabase, err)
-		}
+	if exists {
+		return nil
 	}
 
-	var snapshotExists bool
-	if err := adminPool.QueryRow(ctx, `SELECT EXISTS (SELECT 1 FROM pg_database WHERE datname = $1)`, snapshotName).Scan(&snapshotExists); err != nil {
-		t.Fatalf("checking snapshot db exists: %v", err)
+	scratch := name + "_build"
+	if _, err := adminPool.Exec(ctx, "DROP DATABASE IF EXISTS "+quoteIdent(scratch)+" WITH (FORCE)"); err != nil {
+		return fmt.Errorf("dropping stale template build: %w", err)
 	}
-	if snapshotExists {
-		snapshotReady = true
-		return
+	if _, err := adminPool.Exec(ctx, "CREATE DATABASE "+quoteIdent(scratch)+" OWNER "+quoteIdent(defaultUser)); err != nil {
+		return fmt.Errorf("creating template build db: %w", err)
 	}
 
-	poolCfg, err := pgxpool.ParseConfig(dsn)
-	if err != nil {
-		t.Fatalf("parsing pgx pool config for snapshot init: %v", err)
+	if err := migrateDatabase(ctx, s.dsn(scratch)); err != nil {
+		return err
 	}
-	poolCfg.MinConns = 0
-	poolCfg.MaxConns = 4
 
-	pool, err := pgxpool.NewWithConfig(ctx, poolCfg)
-	if err != nil {
-		t.Fatalf("creating pgx pool for snapshot init: %v", err)
+	if _, err := adminPool.Exec(ctx, "ALTER DATABASE "+quoteIdent(scratch)+" RENAME TO "+quoteIdent(name)); err != nil {
+		return fmt.Errorf("renaming template db: %w", err)
 	}
-
-	if err := pool.Ping(ctx); err != nil {
-		pool.Close()
-		t.Fatalf("pinging db for snapshot init: %v", err)
+	if _, err := adminPool.Exec(ctx, "ALTER DATABASE "+quoteIdent(name)+" WITH IS_TEMPLATE true"); err != nil {
+		return fmt.Errorf("marking template db: %w", err)
 	}
+	return nil
+}
 
+func migrateDatabase(ctx context.Context, dsn string) error {
 	if err := migrations.Up(dsn); err != nil {
-		t.Fatalf("applying migrations for snapshot init: %v", err)
+		return fmt.Errorf("applying migrations: %w", err)
+	}
+
+	pool, err := pgxpool.New(ctx, dsn)
+	if err != nil {
+		return fmt.Errorf("creating pgx pool for river migrations: %w", err)
 	}
+	// The template cannot have open connections when cloned.
+	defer pool.Close()
 
 	migrator, err := rivermigrate.New(riverpgxv5.New(pool), nil)
 	if err != nil {
-		t.Fatalf("creating river migrator for snapshot init: %v", err)
+		return fmt.Errorf("creating river migrator: %w", err)
 	}
 	if _, err := migrator.Migrate(ctx, rivermigrate.DirectionUp, nil); err != nil {
-		t.Fatalf("running river migrations for snapshot init: %v", err)
+		return fmt.Errorf("running river migrations: %w", err)
 	}
+	return nil
+}
 
-	// Important: Snapshot uses the source DB as a TEMPLATE; it cannot have active connections.
-	pool.Close()
-
-	snapOpts := []postgres.SnapshotOption{postgres.WithSnapshotName(snapshotName)}
-
-	for attempt := 1; attempt <= 5; attempt++ {
-		err = container.Snapshot(ctx, snapOpts...)
-		if err == nil {
-			snapshotReady = true
-			return
+// templateName derives the template database name from the embedded
+// migration files.
+func templateName() (string, error) {
+	h := sha256.New()
+	err := fs.WalkDir(migrations.Files, ".", func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
 		}
-		// Transient when connections are still draining.
-		if strings.Contains(err.Error(), "being accessed by other users") {
-			time.Sleep(200 * time.Millisecond)
-			continue
+		b, err := fs.ReadFile(migrations.Files, path)
+		if err != nil {
+			return err
 		}
-		break
+		fmt.Fprintf(h, "%s\x00%d\x00", path, len(b))
+		h.Write(b)
+		return nil
+	})
+	if err != nil {
+		return "", fmt.Errorf("hashing migrations: %w", err)
 	}
+	return templatePrefix + hex.EncodeToString(h.Sum(nil))[:16], nil
+}
+
+// withFileLock runs fn while holding an exclusive lock shared by all test
+// processes on this machine.
+func withFileLock(name string, fn func() error) error {
+	safe := strings.Map(func(r rune) rune {
+		if r == '/' || r == ':' || r == '\\' {
+			return '_'
+		}
+		return r
+	}, name)
+	path := filepath.Join(os.TempDir(), "queryops-testdb-"+safe+".lock")
+
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR, 0o600)
 	if err != nil {
-		t.Fatalf("creating snapshot: %v", err)
+		return fmt.Errorf("opening lock file: %w", err)
 	}
-	snapshotReady = true
+	defer f.Close()
+
+	if err := lockFile(f); err != nil {
+		return fmt.Errorf("locking %s: %w", path, err)
+	}
+	defer func() { _ = unlockFile(f) }()
+
+	return fn()
 }
@@ -9,6 +9,10 @@ import (
 	"github.com/jackc/pgx/v5/pgxpool"
 )
 
+func TestMain(m *testing.M) {
+	RunWithPostgres(m)
+}
+
 func TestSetupTestDB_Select1(t *testing.T) {
 	tdb := SetupTestDB(t)
 	if tdb == nil {
@@ -28,10 +32,6 @@ func TestSetupTestDB_Select1(t *testing.T) {
 }
 
 func TestSetupTestDB_ParallelReuse(t *testing.T) {
-	if !reuseEnabled() {
-		t.Skip("set QUERYOPS_TESTDB_REUSE=1 to run")
-	}
-
 	for i := range 3 {
 		i := i
 		t.Run(fmt.Sprintf("case_%d", i), func(t *testing.T) {
@@ -57,10 +57,6 @@ func TestSetupTestDB_ParallelReuse(t *testing.T) {
 }
 
 func TestSetupTestDB_IsolationBetweenDatabases(t *testing.T) {
-	if !reuseEnabled() {
-		t.Skip("set QUERYOPS_TESTDB_REUSE=1 to run")
-	}
-
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
 
@@ -96,10 +92,6 @@ func TestSetupTestDB_IsolationBetweenDatabases(t *testing.T) {
 }
 
 func TestSetupTestDB_DropsDatabaseOnCleanup(t *testing.T) {
-	if !reuseEnabled() {
-		t.Skip("set QUERYOPS_TESTDB_REUSE=1 to run")
-	}
-
 	var host, port, user, password, dbName string
 
 	t.Run("create_db", func(t *testing.T) {
@@ -113,6 +105,10 @@ func TestSetupTestDB_DropsDatabaseOnCleanup(t *testing.T) {
 		}
 	})
 
+	if dbName == "" {
+		t.Skip("postgres unavailable")
+	}
+
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
 
@@ -138,3 +134,20 @@ func TestSetupTestDB_DropsDatabaseOnCleanup(t *testing.T) {
 		t.Fatalf("expected database %q to be dropped", dbName)
 	}
 }
+
+func TestTemplateName_IsStable(t *testing.T) {
+	a, err := templateName()
+	if err != nil {
+		t.Fatalf("templateName: %v", err)
+	}
+	b, err := templateName()
+	if err != nil {
+		t.Fatalf("templateName: %v", err)
+	}
+	if a != b {
+		t.Fatalf("templateName changed between calls: %q != %q", a, b)
+	}
+	if len(a) > 63 {
+		t.Fatalf("templateName %q exceeds Postgres identifier limit", a)
+	}
+}
@@ -0,0 +1,180 @@
+// Package validate checks form and JSON input field by field. Handlers and
+// services collect problems in an Errors keyed by the field name the client
+// sent. Errors is an error, so services can return it and handlers find it
+// with errors.As. APIs answer with WriteJSON; pages re-render their form and
+// show each message beside its input with components.FieldError.
+package validate
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/mail"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// Messages shared by the rules, for handlers that check values themselves.
+const (
+	MsgRequired    = "Required"
+	MsgEmail       = "Enter a valid email address"
+	MsgNonNegative = "Must be a whole number, 0 or more"
+)
+
+// Errors maps a field name to the first problem found with it.
+type Errors map[string]string
+
+// Add records msg for field unless the field already has a problem, so the
+// first check to fail is the one reported.
+func (e Errors) Add(field, msg string) {
+	if _, ok := e[field]; !ok {
+		e[field] = msg
+	}
+}
+
+// Check records msg for field if ok is false.
+func (e Errors) Check(ok bool, field, msg string) {
+	if !ok {
+		e.Add(field, msg)
+	}
+}
+
+// Field runs rules against value in order and records the first failure.
+func (e Errors) Field(field, value string, rules ...Rule) {
+	for _, rule := range rules {
+		if msg := rule(value); msg != "" {
+			e.Add(field, msg)
+			return
+		}
+	}
+}
+
+// NonNegativeInt parses an optional whole-number field, as sent by a number
+// input: blank is 0, and anything else that isn't a whole number of 0 or more
+// is recorded as a problem.
+func (e Errors) NonNegativeInt(field, value string) int {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return 0
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil || n < 0 {
+		e.Add(field, MsgNonNegative)
+		return 0
+	}
+	return n
+}
+
+// Has reports whether field has a problem.
+func (e Errors) Has(field string) bool {
+	_, ok := e[field]
+	return ok
+}
+
+// Get returns field's problem, or "" if it has none.
+func (e Errors) Get(field string) string {
+	return e[field]
+}
+
+// Err returns e, or nil if it records no problems.
+func (e Errors) Err() error {
+	if len(e) == 0 {
+		return nil
+	}
+	return e
+}
+
+// Error lists the problems by field name, e.g. "email: Required".
+func (e Errors) Error() string {
+	fields := make([]string, 0, len(e))
+	for field := range e {
+		fields = append(fields, field)
+	}
+	sort.Strings(fields)
+
+	parts := make([]string, 0, len(fields))
+	for _, field := range fields {
+		parts = append(parts, field+": "+e[field])
+	}
+	return strings.Join(parts, "; ")
+}
+
+// As returns the Errors in err's chain, if any.
+func As(err error) (Errors, bool) {
+	var errs Errors
+	if errors.As(err, &errs) {
+		return errs, true
+	}
+	return nil, false
+}
+
+// Rule checks one value, returning a message when it is invalid and "" when
+// it is fine.
+type Rule func(value string) string
+
+// Required rejects the empty string. Trim values first if whitespace alone
+// shouldn't count.
+func Required() Rule {
+	return func(value string) string {
+		if value == "" {
+			return MsgRequired
+		}
+		return ""
+	}
+}
+
+// MaxLength rejects values longer than n characters.
+func MaxLength(n int) Rule {
+	return func(value string) string {
+		if utf8.RuneCountInString(value) > n {
+			return fmt.Sprintf("Must be at most %d characters", n)
+		}
+		return ""
+	}
+}
+
+// MinLength rejects non-empty values shorter than n characters; combine it
+// with Required if the field can't be blank.
+func MinLength(n int) Rule {
+	return func(value string) string {
+		if value != "" && utf8.RuneCountInString(value) < n {
+			return fmt.Sprintf("Must be at least %d characters", n)
+		}
+		return ""
+	}
+}
+
+// Email rejects non-empty values that aren't a bare address like
+// "ops@example.com".
+func Email() Rule {
+	return func(value string) string {
+		if value == "" {
+			return ""
+		}
+		addr, err := mail.ParseAddress(value)
+		if err != nil || addr.Address != value {
+			return MsgEmail
+		}
+		return ""
+	}
+}
+
+// Response is the JSON body WriteJSON sends.
+type Response struct {
+	Error  string `json:"error"`
+	Fields Errors `json:"fields"`
+}
+
+// WriteJSON answers an API request whose input failed validation with 400 and
+// a Response naming each invalid field.
+func WriteJSON(w http.ResponseWriter, errs Errors) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusBadRequest)
+	if err := json.NewEncoder(w).Encode(Response{Error: "invalid request", Fields: errs}); err != nil {
+		slog.Error("failed to encode validation errors", "error", err)
+	}
+}
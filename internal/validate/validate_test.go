@@ -0,0 +1,108 @@
+package validate
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestErrors_Field(t *testing.T) {
+	tests := []struct {
+		name  string
+		value string
+		rules []Rule
+		want  string
+	}{
+		{"required blank", "", []Rule{Required()}, MsgRequired},
+		{"required set", "x", []Rule{Required()}, ""},
+		{"first failure wins", "", []Rule{Required(), Email()}, MsgRequired},
+		{"email", "not-an-email", []Rule{Email()}, MsgEmail},
+		{"email with name", "Ops <ops@example.com>", []Rule{Email()}, MsgEmail},
+		{"email ok", "ops@example.com", []Rule{Required(), Email()}, ""},
+		{"email blank is optional", "", []Rule{Email()}, ""},
+		{"max length counts runes", "héllo", []Rule{MaxLength(5)}, ""},
+		{"too long", "hello!", []Rule{MaxLength(5)}, "Must be at most 5 characters"},
+		{"too short", "pass", []Rule{MinLength(8)}, "Must be at least 8 characters"},
+		{"min length blank is optional", "", []Rule{MinLength(8)}, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			errs := Errors{}
+			errs.Field("f", tt.value, tt.rules...)
+			if got := errs.Get("f"); got != tt.want {
+				t.Fatalf("Get = %q, want %q", got, tt.want)
+			}
+			if errs.Has("f") != (tt.want != "") {
+				t.Fatalf("Has = %v", errs.Has("f"))
+			}
+		})
+	}
+}
+
+func TestErrors_AddKeepsFirst(t *testing.T) {
+	errs := Errors{}
+	errs.Add("name", "first")
+	errs.Check(false, "name", "second")
+	errs.Check(true, "other", "ignored")
+
+	if errs.Get("name") != "first" || errs.Has("other") {
+		t.Fatalf("errs = %v", errs)
+	}
+}
+
+func TestErrors_NonNegativeInt(t *testing.T) {
+	errs := Errors{}
+	if n := errs.NonNegativeInt("a", " 12 "); n != 12 {
+		t.Fatalf("NonNegativeInt(12) = %d", n)
+	}
+	if n := errs.NonNegativeInt("b", ""); n != 0 {
+		t.Fatalf("NonNegativeInt(blank) = %d", n)
+	}
+	errs.NonNegativeInt("c", "-1")
+	errs.NonNegativeInt("d", "1.5")
+
+	if errs.Has("a") || errs.Has("b") || errs.Get("c") != MsgNonNegative || errs.Get("d") != MsgNonNegative {
+		t.Fatalf("errs = %v", errs)
+	}
+}
+
+func TestErrors_AsError(t *testing.T) {
+	if err := (Errors{}).Err(); err != nil {
+		t.Fatalf("empty Errors.Err() = %v, want nil", err)
+	}
+
+	errs := Errors{"password": MsgRequired, "email": MsgEmail}
+	wrapped := fmt.Errorf("registering: %w", errs.Err())
+	if got := wrapped.Error(); got != "registering: email: "+MsgEmail+"; password: "+MsgRequired {
+		t.Fatalf("Error() = %q", got)
+	}
+
+	got, ok := As(wrapped)
+	if !ok || got.Get("email") != MsgEmail {
+		t.Fatalf("As = %v, %v", got, ok)
+	}
+	if _, ok := As(fmt.Errorf("plain")); ok {
+		t.Fatalf("As matched a plain error")
+	}
+}
+
+func TestWriteJSON(t *testing.T) {
+	rec := httptest.NewRecorder()
+	WriteJSON(rec, Errors{"query": MsgRequired})
+
+	if rec.Code != http.StatusBadRequest {
+		t.Fatalf("status = %d, want 400", rec.Code)
+	}
+	if ct := rec.Header().Get("Content-Type"); ct != "application/json" {
+		t.Fatalf("Content-Type = %q", ct)
+	}
+	var resp Response
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("decoding body: %v", err)
+	}
+	if resp.Error == "" || resp.Fields.Get("query") != MsgRequired {
+		t.Fatalf("response = %+v", resp)
+	}
+}
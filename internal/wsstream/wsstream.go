@@ -0,0 +1,170 @@
+// Package wsstream serves one-way event streams over WebSocket, for browsers
+// behind proxies that buffer text/event-stream responses until they end.
+// Accept upgrades a request; the resulting Conn sends each Write as one text
+// message. The protocol is github.com/coder/websocket's; this package adds
+// the stream's policy on top: same-origin handshakes only, keepalive pings,
+// bounded writes, and a close code that tells the client whether to
+// reconnect. Streams don't expect messages from the client, so one ends the
+// connection.
+package wsstream
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/coder/websocket"
+)
+
+const (
+	// pingInterval is how often a Conn pings the client, so proxies that
+	// drop quiet connections keep the stream open.
+	pingInterval = 30 * time.Second
+
+	// writeTimeout bounds each write and ping, so a client that stops
+	// reading can't hold its handler forever.
+	writeTimeout = 10 * time.Second
+)
+
+// ErrClosed is returned by Write after the connection has closed.
+var ErrClosed = errors.New("websocket closed")
+
+// IsUpgrade reports whether r asks to switch to the WebSocket protocol.
+func IsUpgrade(r *http.Request) bool {
+	return headerContainsToken(r.Header, "Connection", "upgrade") &&
+		headerContainsToken(r.Header, "Upgrade", "websocket")
+}
+
+// Conn is the server end of an accepted WebSocket.
+type Conn struct {
+	ws *websocket.Conn
+
+	// parent is the request context; Close reports "going away" rather than
+	// a normal end when it is done, so clients reconnect after a restart.
+	parent context.Context
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	closeOnce sync.Once
+}
+
+// Accept completes the WebSocket handshake for r and takes over its
+// connection. Cross-origin requests are refused, since the browser sends the
+// session cookie with them. On failure Accept has already written an HTTP
+// error response.
+func Accept(w http.ResponseWriter, r *http.Request) (*Conn, error) {
+	// With no OriginPatterns, the handshake is refused with 403 unless
+	// Origin is absent or names r.Host.
+	ws, err := websocket.Accept(w, r, &websocket.AcceptOptions{
+		CompressionMode: websocket.CompressionDisabled,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("accepting websocket: %w", err)
+	}
+
+	// CloseRead answers pings and close frames in the background; its
+	// context ends when the client goes away or sends a message. It isn't
+	// given the request context, whose end would drop the connection without
+	// the close frame Close sends.
+	ctx, cancel := context.WithCancel(r.Context())
+	context.AfterFunc(ws.CloseRead(context.Background()), cancel)
+	c := &Conn{
+		ws:     ws,
+		parent: r.Context(),
+		ctx:    ctx,
+		cancel: cancel,
+	}
+	go c.pingLoop()
+	return c, nil
+}
+
+// Context is done when the client disconnects, the request context is
+// cancelled, or the Conn is closed. Hijacked connections don't cancel the
+// request context on disconnect, so stream loops must watch this instead.
+func (c *Conn) Context() context.Context {
+	return c.ctx
+}
+
+// Write sends p as one text message.
+func (c *Conn) Write(p []byte) (int, error) {
+	if c.ctx.Err() != nil {
+		return 0, ErrClosed
+	}
+	ctx, cancel := context.WithTimeout(c.ctx, writeTimeout)
+	defer cancel()
+	if err := c.ws.Write(ctx, websocket.MessageText, p); err != nil {
+		c.cancel()
+		return 0, fmt.Errorf("writing websocket message: %w", err)
+	}
+	return len(p), nil
+}
+
+// Close sends a close frame and closes the connection. It reports a normal
+// end, which tells the client not to reconnect, unless the request context
+// is done, e.g. because the server is shutting down. Closing twice is safe.
+func (c *Conn) Close() error {
+	var err error
+	c.closeOnce.Do(func() {
+		code := websocket.StatusNormalClosure
+		if c.parent.Err() != nil {
+			code = websocket.StatusGoingAway
+		}
+		err = c.ws.Close(code, "")
+		c.cancel()
+	})
+	return err
+}
+
+// ResponseWriter adapts c for writers that expect an HTTP response, such as
+// a datastar event generator: each Write becomes one message, and headers
+// are discarded.
+func (c *Conn) ResponseWriter() http.ResponseWriter {
+	return &responseWriter{conn: c, header: make(http.Header)}
+}
+
+type responseWriter struct {
+	conn   *Conn
+	header http.Header
+}
+
+func (w *responseWriter) Header() http.Header         { return w.header }
+func (w *responseWriter) Write(p []byte) (int, error) { return w.conn.Write(p) }
+func (w *responseWriter) WriteHeader(int)             {}
+
+// Flush is a no-op; every message is written as soon as it is sent.
+func (w *responseWriter) Flush() {}
+
+func (c *Conn) pingLoop() {
+	ticker := time.NewTicker(pingInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-c.ctx.Done():
+			return
+		case <-ticker.C:
+			ctx, cancel := context.WithTimeout(c.ctx, writeTimeout)
+			err := c.ws.Ping(ctx)
+			cancel()
+			if err != nil {
+				c.cancel()
+				return
+			}
+		}
+	}
+}
+
+func headerContainsToken(h http.Header, name, token string) bool {
+	for _, v := range h.Values(name) {
+		for _, part := range strings.Split(v, ",") {
+			if strings.EqualFold(strings.TrimSpace(part), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
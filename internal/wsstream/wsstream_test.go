@@ -0,0 +1,158 @@
+package wsstream
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/coder/websocket"
+)
+
+func TestIsUpgrade(t *testing.T) {
+	r := httptest.NewRequest(http.MethodGet, "/hosts/live", nil)
+	if IsUpgrade(r) {
+		t.Fatalf("plain request reported as upgrade")
+	}
+	r.Header.Set("Connection", "keep-alive, Upgrade")
+	r.Header.Set("Upgrade", "WebSocket")
+	if !IsUpgrade(r) {
+		t.Fatalf("upgrade request not recognized")
+	}
+}
+
+func TestAccept_RejectsCrossOrigin(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if conn, err := Accept(w, r); err == nil {
+			t.Errorf("Accept succeeded for a cross-origin request")
+			_ = conn.Close()
+		}
+	}))
+	defer srv.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	_, resp, err := websocket.Dial(ctx, srv.URL, &websocket.DialOptions{
+		HTTPHeader: http.Header{"Origin": {"https://evil.example"}},
+	})
+	if err == nil {
+		t.Fatalf("Dial succeeded for a cross-origin request")
+	}
+	if resp == nil || resp.StatusCode != http.StatusForbidden {
+		t.Fatalf("response = %v, want %d", resp, http.StatusForbidden)
+	}
+}
+
+func TestConn_SendsMessagesAndEndsOnClientClose(t *testing.T) {
+	done := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := Accept(w, r)
+		if err != nil {
+			t.Errorf("Accept: %v", err)
+			return
+		}
+		defer func() { _ = conn.Close() }()
+
+		if _, err := conn.ResponseWriter().Write([]byte("event: datastar-patch-elements\n\n")); err != nil {
+			t.Errorf("Write: %v", err)
+			return
+		}
+		<-conn.Context().Done()
+		close(done)
+	}))
+	defer srv.Close()
+
+	client := dial(t, srv)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	typ, payload, err := client.Read(ctx)
+	if err != nil {
+		t.Fatalf("Read: %v", err)
+	}
+	if typ != websocket.MessageText || string(payload) != "event: datastar-patch-elements\n\n" {
+		t.Fatalf("message = %v %q", typ, payload)
+	}
+
+	// Ping needs a reader to receive the pong.
+	pingCtx := client.CloseRead(ctx)
+	if err := client.Ping(pingCtx); err != nil {
+		t.Fatalf("Ping: %v", err)
+	}
+
+	if err := client.Close(websocket.StatusNormalClosure, ""); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Conn context not done after client closed")
+	}
+}
+
+func TestConn_CloseIsNormal(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := Accept(w, r)
+		if err != nil {
+			t.Errorf("Accept: %v", err)
+			return
+		}
+		_ = conn.Close()
+		_ = conn.Close()
+	}))
+	defer srv.Close()
+
+	client := dial(t, srv)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	_, _, err := client.Read(ctx)
+	if got := websocket.CloseStatus(err); got != websocket.StatusNormalClosure {
+		t.Fatalf("close status = %v (%v), want normal", got, err)
+	}
+}
+
+func TestConn_CloseIsGoingAwayWhenRequestEnds(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx, cancel := context.WithCancel(r.Context())
+		defer cancel()
+		conn, err := Accept(w, r.WithContext(ctx))
+		if err != nil {
+			t.Errorf("Accept: %v", err)
+			return
+		}
+		cancel()
+		_ = conn.Close()
+	}))
+	defer srv.Close()
+
+	client := dial(t, srv)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	_, _, err := client.Read(ctx)
+	if got := websocket.CloseStatus(err); got != websocket.StatusGoingAway {
+		t.Fatalf("close status = %v (%v), want going away", got, err)
+	}
+}
+
+// dial opens a same-origin WebSocket to srv.
+func dial(t *testing.T, srv *httptest.Server) *websocket.Conn {
+	t.Helper()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	conn, resp, err := websocket.Dial(ctx, strings.Replace(srv.URL, "http", "ws", 1)+"/stream", &websocket.DialOptions{
+		HTTPHeader: http.Header{"Origin": {srv.URL}},
+	})
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		t.Fatalf("status = %d, want 101", resp.StatusCode)
+	}
+	t.Cleanup(func() { _ = conn.CloseNow() })
+	return conn
+}
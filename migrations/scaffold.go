@@ -0,0 +1,167 @@
+package migrations
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// VersionFormat is the layout of migration versions: a UTC timestamp.
+const VersionFormat = "20060102150405"
+
+var (
+	migrationFileRe = regexp.MustCompile(`^(\d+)_([a-z0-9_]+)\.(up|down)\.sql$`)
+	migrationNameRe = regexp.MustCompile(`^[a-z][a-z0-9_]*$`)
+)
+
+// Migration is one versioned pair of up/down files.
+type Migration struct {
+	Version uint64
+	Name    string
+	Up      string
+	Down    string
+}
+
+// List reads and validates the migrations in fsys: every file must follow
+// the naming pattern, versions must be unique, and each up file needs a down
+// file. Migrations are returned in version order.
+func List(fsys fs.FS) ([]Migration, error) {
+	entries, err := fs.ReadDir(fsys, ".")
+	if err != nil {
+		return nil, fmt.Errorf("reading migrations: %w", err)
+	}
+
+	byVersion := make(map[uint64]*Migration)
+	var errs []error
+	for _, e := range entries {
+		if e.IsDir() {
+			continue
+		}
+		m := migrationFileRe.FindStringSubmatch(e.Name())
+		if m == nil {
+			errs = append(errs, fmt.Errorf("%s: want {version}_{name}.up.sql or .down.sql", e.Name()))
+			continue
+		}
+		version, err := strconv.ParseUint(m[1], 10, 64)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: invalid version: %w", e.Name(), err))
+			continue
+		}
+
+		mig, ok := byVersion[version]
+		if !ok {
+			mig = &Migration{Version: version, Name: m[2]}
+			byVersion[version] = mig
+		} else if mig.Name != m[2] {
+			errs = append(errs, fmt.Errorf("version %d used by both %q and %q", version, mig.Name, m[2]))
+			continue
+		}
+		if m[3] == "up" {
+			mig.Up = e.Name()
+		} else {
+			mig.Down = e.Name()
+		}
+	}
+
+	out := make([]Migration, 0, len(byVersion))
+	for _, mig := range byVersion {
+		if mig.Up == "" {
+			errs = append(errs, fmt.Errorf("version %d (%s): missing .up.sql", mig.Version, mig.Name))
+		}
+		if mig.Down == "" {
+			errs = append(errs, fmt.Errorf("version %d (%s): missing .down.sql", mig.Version, mig.Name))
+		}
+		out = append(out, *mig)
+	}
+	if len(errs) > 0 {
+		return nil, errors.Join(errs...)
+	}
+
+	sort.Slice(out, func(i, j int) bool { return out[i].Version < out[j].Version })
+	return out, nil
+}
+
+// Embedded returns the migrations compiled into the binary.
+func Embedded() ([]Migration, error) {
+	sub, err := fs.Sub(Files, "sql")
+	if err != nil {
+		return nil, fmt.Errorf("reading embedded migrations: %w", err)
+	}
+	return List(sub)
+}
+
+// UpSQL returns the contents of an embedded migration's up file.
+func UpSQL(m Migration) (string, error) {
+	b, err := fs.ReadFile(Files, "sql/"+m.Up)
+	if err != nil {
+		return "", fmt.Errorf("reading %s: %w", m.Up, err)
+	}
+	return string(b), nil
+}
+
+// Create writes empty up/down files for a new migration named name in dir
+// and returns their paths. The version is now in UTC, bumped past the latest
+// existing version so new files always sort last.
+func Create(dir, name string, now time.Time) (upPath, downPath string, err error) {
+	if !migrationNameRe.MatchString(name) {
+		return "", "", fmt.Errorf("invalid migration name %q: use snake_case letters, digits, and underscores", name)
+	}
+
+	existing, err := List(os.DirFS(dir))
+	if err != nil {
+		return "", "", fmt.Errorf("existing migrations are invalid: %w", err)
+	}
+
+	version, err := strconv.ParseUint(now.UTC().Format(VersionFormat), 10, 64)
+	if err != nil {
+		return "", "", fmt.Errorf("formatting version: %w", err)
+	}
+	for _, m := range existing {
+		if m.Name == name {
+			return "", "", fmt.Errorf("migration %q already exists as version %d", name, m.Version)
+		}
+		if m.Version >= version {
+			version = nextVersion(m.Version)
+		}
+	}
+
+	base := fmt.Sprintf("%d_%s", version, name)
+	upPath = filepath.Join(dir, base+".up.sql")
+	downPath = filepath.Join(dir, base+".down.sql")
+
+	if err := writeNew(upPath); err != nil {
+		return "", "", err
+	}
+	if err := writeNew(downPath); err != nil {
+		_ = os.Remove(upPath)
+		return "", "", err
+	}
+	return upPath, downPath, nil
+}
+
+// nextVersion returns the timestamp one second after v.
+func nextVersion(v uint64) uint64 {
+	t, err := time.Parse(VersionFormat, strconv.FormatUint(v, 10))
+	if err != nil {
+		return v + 1
+	}
+	next, _ := strconv.ParseUint(t.Add(time.Second).Format(VersionFormat), 10, 64)
+	return next
+}
+
+func writeNew(path string) error {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o644)
+	if err != nil {
+		return fmt.Errorf("creating %s: %w", path, err)
+	}
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("creating %s: %w", path, err)
+	}
+	return nil
+}
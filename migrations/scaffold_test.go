@@ -0,0 +1,84 @@
+package migrations
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"testing/fstest"
+	"time"
+)
+
+func TestList_EmbeddedMigrationsAreValid(t *testing.T) {
+	migs, err := Embedded()
+	if err != nil {
+		t.Fatalf("Embedded: %v", err)
+	}
+	if len(migs) == 0 {
+		t.Fatal("no migrations found")
+	}
+	if _, err := UpSQL(migs[len(migs)-1]); err != nil {
+		t.Fatalf("UpSQL: %v", err)
+	}
+}
+
+func TestList_RejectsInvalidSets(t *testing.T) {
+	tests := map[string]fstest.MapFS{
+		"missing down": {
+			"20260101000000_a.up.sql": {},
+		},
+		"version collision": {
+			"20260101000000_a.up.sql":   {},
+			"20260101000000_a.down.sql": {},
+			"20260101000000_b.up.sql":   {},
+			"20260101000000_b.down.sql": {},
+		},
+		"bad name": {
+			"20260101000000_Add-Users.up.sql":   {},
+			"20260101000000_Add-Users.down.sql": {},
+		},
+	}
+	for name, fsys := range tests {
+		t.Run(name, func(t *testing.T) {
+			if _, err := List(fsys); err == nil {
+				t.Fatal("List: want error")
+			}
+		})
+	}
+}
+
+func TestCreate(t *testing.T) {
+	dir := t.TempDir()
+	for _, f := range []string{"20260101120000_users.up.sql", "20260101120000_users.down.sql"} {
+		if err := os.WriteFile(filepath.Join(dir, f), nil, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// A clock behind the latest migration still sorts after it.
+	up, down, err := Create(dir, "add_teams", time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC))
+	if err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	if got, want := filepath.Base(up), "20260101120001_add_teams.up.sql"; got != want {
+		t.Fatalf("up = %q, want %q", got, want)
+	}
+	if !strings.HasSuffix(down, "20260101120001_add_teams.down.sql") {
+		t.Fatalf("down = %q", down)
+	}
+
+	up, _, err = Create(dir, "add_roles", time.Date(2026, 2, 3, 4, 5, 6, 0, time.UTC))
+	if err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	if got, want := filepath.Base(up), "20260203040506_add_roles.up.sql"; got != want {
+		t.Fatalf("up = %q, want %q", got, want)
+	}
+
+	if _, _, err := Create(dir, "add_teams", time.Now()); err == nil {
+		t.Fatal("Create duplicate name: want error")
+	}
+	if _, _, err := Create(dir, "Add Teams", time.Now()); err == nil {
+		t.Fatal("Create invalid name: want error")
+	}
+}
@@ -0,0 +1,12 @@
+CREATE TABLE IF NOT EXISTS organization_digest_settings (
+    organization_id UUID PRIMARY KEY REFERENCES organizations(id) ON DELETE CASCADE,
+    frequency TEXT NOT NULL DEFAULT 'off',
+    email TEXT,
+    webhook_url TEXT,
+    last_sent_at TIMESTAMPTZ,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    CONSTRAINT organization_digest_settings_frequency_check CHECK (frequency IN ('off', 'daily', 'weekly'))
+);
+
+CREATE INDEX IF NOT EXISTS idx_organization_digest_settings_frequency ON organization_digest_settings(frequency) WHERE frequency <> 'off';
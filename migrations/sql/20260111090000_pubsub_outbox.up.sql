@@ -0,0 +1,14 @@
+CREATE TABLE IF NOT EXISTS pubsub_outbox (
+    id BIGSERIAL PRIMARY KEY,
+    topic TEXT NOT NULL,
+    message_id TEXT NOT NULL,
+    payload BYTEA NOT NULL,
+    metadata JSONB NOT NULL DEFAULT '{}',
+    attempts INTEGER NOT NULL DEFAULT 0,
+    last_error TEXT,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    published_at TIMESTAMPTZ
+);
+
+CREATE INDEX IF NOT EXISTS idx_pubsub_outbox_unpublished ON pubsub_outbox(id) WHERE published_at IS NULL;
+CREATE INDEX IF NOT EXISTS idx_pubsub_outbox_published_at ON pubsub_outbox(published_at) WHERE published_at IS NOT NULL;
@@ -0,0 +1,5 @@
+DROP TABLE IF EXISTS pubsub_consumer_offsets;
+
+DROP INDEX IF EXISTS idx_pubsub_outbox_txid_id;
+
+ALTER TABLE pubsub_outbox DROP COLUMN IF EXISTS txid;
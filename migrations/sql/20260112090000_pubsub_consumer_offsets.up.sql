@@ -0,0 +1,16 @@
+-- Record the writing transaction so consumers can read the outbox in commit-safe
+-- order: rows from transactions older than every running transaction can no
+-- longer be joined by rows that sort before them.
+ALTER TABLE pubsub_outbox ADD COLUMN IF NOT EXISTS txid xid8 NOT NULL DEFAULT pg_current_xact_id();
+
+CREATE INDEX IF NOT EXISTS idx_pubsub_outbox_txid_id ON pubsub_outbox(txid, id);
+
+CREATE TABLE IF NOT EXISTS pubsub_consumer_offsets (
+    group_name TEXT NOT NULL,
+    topic TEXT NOT NULL,
+    last_txid xid8 NOT NULL,
+    last_id BIGINT NOT NULL DEFAULT 0,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (group_name, topic)
+);
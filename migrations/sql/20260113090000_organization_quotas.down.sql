@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS idx_campaigns_organization_created_at;
+DROP TABLE IF EXISTS organization_usage_daily;
+DROP TABLE IF EXISTS organization_quotas;
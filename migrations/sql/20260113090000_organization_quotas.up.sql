@@ -0,0 +1,25 @@
+-- Per-organization overrides of the configured default quotas. NULL falls
+-- back to the default; 0 means unlimited.
+CREATE TABLE IF NOT EXISTS organization_quotas (
+    organization_id UUID PRIMARY KEY REFERENCES organizations(id) ON DELETE CASCADE,
+    max_hosts INTEGER,
+    max_campaigns_per_day INTEGER,
+    max_result_log_bytes_per_day BIGINT,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    CONSTRAINT organization_quotas_non_negative CHECK (
+        COALESCE(max_hosts, 0) >= 0
+        AND COALESCE(max_campaigns_per_day, 0) >= 0
+        AND COALESCE(max_result_log_bytes_per_day, 0) >= 0
+    )
+);
+
+-- Metered usage that can't be cheaply derived from other tables, per UTC day.
+CREATE TABLE IF NOT EXISTS organization_usage_daily (
+    organization_id UUID NOT NULL REFERENCES organizations(id) ON DELETE CASCADE,
+    day DATE NOT NULL,
+    result_log_bytes BIGINT NOT NULL DEFAULT 0,
+    PRIMARY KEY (organization_id, day)
+);
+
+CREATE INDEX IF NOT EXISTS idx_campaigns_organization_created_at ON campaigns(organization_id, created_at);
@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS idx_campaigns_previous_campaign_id;
+ALTER TABLE campaign_targets DROP COLUMN IF EXISTS diff;
+ALTER TABLE campaigns DROP COLUMN IF EXISTS previous_campaign_id;
@@ -0,0 +1,6 @@
+-- A re-run campaign points at the campaign it repeats; each of its targets
+-- stores the rows added and removed since that host's previous results.
+ALTER TABLE campaigns ADD COLUMN IF NOT EXISTS previous_campaign_id UUID REFERENCES campaigns(id) ON DELETE SET NULL;
+ALTER TABLE campaign_targets ADD COLUMN IF NOT EXISTS diff JSONB;
+
+CREATE INDEX IF NOT EXISTS idx_campaigns_previous_campaign_id ON campaigns(previous_campaign_id) WHERE previous_campaign_id IS NOT NULL;
@@ -0,0 +1,4 @@
+DROP INDEX IF EXISTS idx_campaign_targets_results_fts;
+DROP INDEX IF EXISTS idx_osquery_results_columns_fts;
+DROP INDEX IF EXISTS idx_campaign_targets_results;
+DROP INDEX IF EXISTS idx_osquery_results_columns;
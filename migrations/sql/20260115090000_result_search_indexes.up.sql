@@ -0,0 +1,7 @@
+-- Containment (@>) lookups for exact column matches.
+CREATE INDEX IF NOT EXISTS idx_osquery_results_columns ON osquery_results USING GIN (columns jsonb_path_ops);
+CREATE INDEX IF NOT EXISTS idx_campaign_targets_results ON campaign_targets USING GIN (results jsonb_path_ops);
+
+-- Full-text search over JSON string values.
+CREATE INDEX IF NOT EXISTS idx_osquery_results_columns_fts ON osquery_results USING GIN (to_tsvector('simple', columns));
+CREATE INDEX IF NOT EXISTS idx_campaign_targets_results_fts ON campaign_targets USING GIN (to_tsvector('simple', results));
@@ -0,0 +1 @@
+DROP INDEX IF EXISTS idx_osquery_results_host_name_timestamp;
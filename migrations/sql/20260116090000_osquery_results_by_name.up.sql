@@ -0,0 +1,3 @@
+-- Serves the host details scheduled results browser: one query's rows for a
+-- host over a time range.
+CREATE INDEX IF NOT EXISTS idx_osquery_results_host_name_timestamp ON osquery_results(host_id, name, timestamp DESC);
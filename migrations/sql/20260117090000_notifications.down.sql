@@ -0,0 +1,4 @@
+DROP INDEX IF EXISTS idx_campaigns_unnotified;
+ALTER TABLE hosts DROP COLUMN IF EXISTS offline_notified_at;
+ALTER TABLE campaigns DROP COLUMN IF EXISTS notified_at;
+DROP TABLE IF EXISTS notifications;
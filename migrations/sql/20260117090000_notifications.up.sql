@@ -0,0 +1,24 @@
+CREATE TABLE IF NOT EXISTS notifications (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    organization_id UUID REFERENCES organizations(id) ON DELETE CASCADE,
+    kind TEXT NOT NULL,
+    title TEXT NOT NULL,
+    body TEXT NOT NULL DEFAULT '',
+    link TEXT,
+    read_at TIMESTAMPTZ,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_notifications_user_created ON notifications(user_id, created_at DESC);
+CREATE INDEX IF NOT EXISTS idx_notifications_user_unread ON notifications(user_id) WHERE read_at IS NULL;
+
+-- Finished campaigns and offline hosts are notified once; these record when.
+-- Existing rows are marked so the first sweep doesn't notify about history.
+ALTER TABLE campaigns ADD COLUMN IF NOT EXISTS notified_at TIMESTAMPTZ;
+ALTER TABLE hosts ADD COLUMN IF NOT EXISTS offline_notified_at TIMESTAMPTZ;
+
+UPDATE campaigns SET notified_at = NOW() WHERE status IN ('completed', 'failed');
+UPDATE hosts SET offline_notified_at = NOW();
+
+CREATE INDEX IF NOT EXISTS idx_campaigns_unnotified ON campaigns(status) WHERE notified_at IS NULL;
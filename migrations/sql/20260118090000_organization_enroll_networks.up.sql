@@ -0,0 +1,12 @@
+-- Networks osquery enrollment requests may (or may not) come from, per
+-- organization. With no allow rules every network is allowed; deny rules
+-- always win.
+CREATE TABLE IF NOT EXISTS organization_enroll_networks (
+    id BIGSERIAL PRIMARY KEY,
+    organization_id UUID NOT NULL REFERENCES organizations(id) ON DELETE CASCADE,
+    network CIDR NOT NULL,
+    action TEXT NOT NULL CHECK (action IN ('allow', 'deny')),
+    description TEXT NOT NULL DEFAULT '',
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    UNIQUE (organization_id, network, action)
+);
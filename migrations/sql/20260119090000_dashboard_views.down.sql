@@ -0,0 +1,3 @@
+DROP MATERIALIZED VIEW IF EXISTS dashboard_failing_queries;
+DROP MATERIALIZED VIEW IF EXISTS dashboard_campaigns_daily;
+DROP MATERIALIZED VIEW IF EXISTS dashboard_host_platforms;
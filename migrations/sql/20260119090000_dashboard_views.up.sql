@@ -0,0 +1,49 @@
+-- Dashboard aggregates for organizations too large to summarize per page
+-- view. Refreshed by the refresh_dashboard_views job; refreshed_at records
+-- when. Each view needs a unique index for REFRESH ... CONCURRENTLY.
+
+CREATE MATERIALIZED VIEW IF NOT EXISTS dashboard_host_platforms AS
+SELECT
+    organization_id,
+    COALESCE(NULLIF(os_version->>'platform', ''), 'unknown') AS platform,
+    COALESCE(NULLIF(TRIM(CONCAT_WS(' ', os_version->>'name', os_version->>'version')), ''), 'unknown') AS os_version,
+    COUNT(*)::int AS hosts,
+    NOW() AS refreshed_at
+FROM hosts
+GROUP BY 1, 2, 3;
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_dashboard_host_platforms
+    ON dashboard_host_platforms(organization_id, platform, os_version);
+
+CREATE MATERIALIZED VIEW IF NOT EXISTS dashboard_campaigns_daily AS
+SELECT
+    c.organization_id,
+    (c.created_at AT TIME ZONE 'UTC')::date AS day,
+    COUNT(DISTINCT c.id)::int AS campaigns,
+    COUNT(t.host_id) FILTER (WHERE t.status = 'failed')::int AS failed_targets,
+    NOW() AS refreshed_at
+FROM campaigns c
+LEFT JOIN campaign_targets t ON t.campaign_id = c.id
+WHERE c.created_at >= date_trunc('day', NOW() AT TIME ZONE 'UTC') AT TIME ZONE 'UTC' - INTERVAL '29 days'
+GROUP BY 1, 2;
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_dashboard_campaigns_daily
+    ON dashboard_campaigns_daily(organization_id, day);
+
+CREATE MATERIALIZED VIEW IF NOT EXISTS dashboard_failing_queries AS
+SELECT
+    c.organization_id,
+    md5(c.query) AS query_hash,
+    c.query,
+    COUNT(*)::int AS failures,
+    COUNT(DISTINCT t.host_id)::int AS hosts,
+    MAX(t.updated_at) AS last_failed_at,
+    NOW() AS refreshed_at
+FROM campaign_targets t
+JOIN campaigns c ON c.id = t.campaign_id
+WHERE t.status = 'failed'
+  AND t.updated_at >= NOW() - INTERVAL '30 days'
+GROUP BY 1, 2, 3;
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_dashboard_failing_queries
+    ON dashboard_failing_queries(organization_id, query_hash);
@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS host_group_members;
+DROP TABLE IF EXISTS host_groups;
@@ -0,0 +1,19 @@
+-- Static host groups. Membership is explicit; hosts stay in a group until
+-- removed or deleted.
+CREATE TABLE IF NOT EXISTS host_groups (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    organization_id UUID NOT NULL REFERENCES organizations(id) ON DELETE CASCADE,
+    name TEXT NOT NULL,
+    description TEXT NOT NULL DEFAULT '',
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    UNIQUE (organization_id, name)
+);
+
+CREATE TABLE IF NOT EXISTS host_group_members (
+    group_id UUID NOT NULL REFERENCES host_groups(id) ON DELETE CASCADE,
+    host_id UUID NOT NULL REFERENCES hosts(id) ON DELETE CASCADE,
+    added_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (group_id, host_id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_host_group_members_host ON host_group_members(host_id);
@@ -0,0 +1 @@
+DROP TABLE IF EXISTS organization_redaction_rules;
@@ -0,0 +1,13 @@
+-- Redaction rules applied to query results and result logs before they are
+-- stored. Column rules blank out whole values of matching columns; value rules
+-- blank out matching substrings in any column. Patterns are regular
+-- expressions.
+CREATE TABLE IF NOT EXISTS organization_redaction_rules (
+    id BIGSERIAL PRIMARY KEY,
+    organization_id UUID NOT NULL REFERENCES organizations(id) ON DELETE CASCADE,
+    kind TEXT NOT NULL CHECK (kind IN ('column', 'value')),
+    pattern TEXT NOT NULL,
+    description TEXT NOT NULL DEFAULT '',
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    UNIQUE (organization_id, kind, pattern)
+);
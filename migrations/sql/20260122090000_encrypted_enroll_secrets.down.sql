@@ -0,0 +1,11 @@
+-- Encrypted secrets can't be decrypted in SQL. Rolling back drops them, so
+-- affected organizations need a new enroll secret before more hosts can enroll.
+DELETE FROM organization_enroll_secrets WHERE secret IS NULL;
+
+ALTER TABLE organization_enroll_secrets
+    DROP CONSTRAINT IF EXISTS organization_enroll_secrets_stored,
+    DROP CONSTRAINT IF EXISTS organization_enroll_secrets_pkey,
+    ALTER COLUMN secret SET NOT NULL,
+    ADD PRIMARY KEY (secret),
+    DROP COLUMN IF EXISTS secret_ciphertext,
+    DROP COLUMN IF EXISTS secret_hash;
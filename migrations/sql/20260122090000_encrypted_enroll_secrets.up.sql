@@ -0,0 +1,19 @@
+-- Enroll secrets are stored encrypted (secret_ciphertext, see internal/crypto)
+-- and looked up by their SHA-256 (secret_hash). Existing rows keep their
+-- plaintext secret until `queryops encryption rotate` encrypts them; the
+-- application reads either form.
+ALTER TABLE organization_enroll_secrets
+    ADD COLUMN IF NOT EXISTS secret_hash BYTEA,
+    ADD COLUMN IF NOT EXISTS secret_ciphertext TEXT;
+
+UPDATE organization_enroll_secrets
+SET secret_hash = sha256(convert_to(secret, 'UTF8'))
+WHERE secret_hash IS NULL;
+
+ALTER TABLE organization_enroll_secrets
+    DROP CONSTRAINT IF EXISTS organization_enroll_secrets_pkey,
+    ALTER COLUMN secret DROP NOT NULL,
+    ALTER COLUMN secret_hash SET NOT NULL,
+    ADD PRIMARY KEY (secret_hash),
+    ADD CONSTRAINT organization_enroll_secrets_stored
+        CHECK (secret IS NOT NULL OR secret_ciphertext IS NOT NULL);
@@ -0,0 +1,15 @@
+-- Hashed keys can't be recovered. Hosts get random keys their agents don't
+-- hold, so they are told their node key is invalid and re-enroll.
+ALTER TABLE hosts ADD COLUMN IF NOT EXISTS node_key TEXT;
+UPDATE hosts SET node_key = gen_random_uuid()::text;
+ALTER TABLE hosts
+    ALTER COLUMN node_key SET NOT NULL,
+    ADD CONSTRAINT hosts_node_key_key UNIQUE (node_key);
+CREATE INDEX IF NOT EXISTS idx_hosts_node_key ON hosts(node_key);
+
+DROP INDEX IF EXISTS idx_hosts_previous_node_key_hash;
+DROP INDEX IF EXISTS idx_hosts_node_key_hash;
+ALTER TABLE hosts
+    DROP COLUMN IF EXISTS node_key_issued_at,
+    DROP COLUMN IF EXISTS previous_node_key_hash,
+    DROP COLUMN IF EXISTS node_key_hash;
@@ -0,0 +1,23 @@
+-- Node keys are stored as SHA-256 hashes. previous_node_key_hash keeps the key
+-- a host held before it last enrolled usable for a short grace period, so
+-- requests already in flight when osquery re-enrolls aren't rejected.
+-- Existing agents keep working: their keys are hashed in place and count as
+-- issued now, so turning on rotation doesn't expire the whole fleet at once.
+ALTER TABLE hosts
+    ADD COLUMN IF NOT EXISTS node_key_hash BYTEA,
+    ADD COLUMN IF NOT EXISTS previous_node_key_hash BYTEA,
+    ADD COLUMN IF NOT EXISTS node_key_issued_at TIMESTAMPTZ;
+
+UPDATE hosts
+SET node_key_hash = sha256(convert_to(node_key, 'UTF8')),
+    node_key_issued_at = NOW();
+
+ALTER TABLE hosts
+    ALTER COLUMN node_key_hash SET NOT NULL,
+    ALTER COLUMN node_key_issued_at SET NOT NULL,
+    ALTER COLUMN node_key_issued_at SET DEFAULT NOW(),
+    DROP COLUMN node_key;
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_hosts_node_key_hash ON hosts(node_key_hash);
+CREATE INDEX IF NOT EXISTS idx_hosts_previous_node_key_hash ON hosts(previous_node_key_hash)
+WHERE previous_node_key_hash IS NOT NULL;
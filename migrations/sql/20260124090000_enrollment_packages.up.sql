@@ -0,0 +1,23 @@
+-- Enrollment packages: native packages (deb, msi, pkg) that configure osquery
+-- to enroll with an organization, built by a River job. The package embeds
+-- the enroll secret, so its content is stored encrypted like the secret
+-- itself. build numbers each organization's packages so newer ones upgrade
+-- older ones.
+CREATE TABLE IF NOT EXISTS enrollment_packages (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    organization_id UUID NOT NULL REFERENCES organizations(id) ON DELETE CASCADE,
+    format TEXT NOT NULL CHECK (format IN ('deb', 'msi', 'pkg')),
+    build INTEGER NOT NULL,
+    hostname TEXT NOT NULL,
+    status TEXT NOT NULL DEFAULT 'pending' CHECK (status IN ('pending', 'ready', 'failed')),
+    filename TEXT NOT NULL DEFAULT '',
+    content_ciphertext TEXT,
+    size INTEGER NOT NULL DEFAULT 0,
+    sha256 TEXT NOT NULL DEFAULT '',
+    error TEXT NOT NULL DEFAULT '',
+    requested_by INTEGER REFERENCES users(id) ON DELETE SET NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    completed_at TIMESTAMPTZ,
+    UNIQUE (organization_id, build),
+    CHECK (status <> 'ready' OR content_ciphertext IS NOT NULL)
+);
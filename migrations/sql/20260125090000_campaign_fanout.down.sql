@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS idx_campaign_targets_campaign_sent_at;
+ALTER TABLE campaigns DROP COLUMN IF EXISTS fanout_interval_seconds;
+ALTER TABLE campaigns DROP COLUMN IF EXISTS fanout_limit;
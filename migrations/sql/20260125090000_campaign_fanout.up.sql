@@ -0,0 +1,7 @@
+-- A throttled campaign releases its query to at most fanout_limit hosts per
+-- fanout_interval_seconds; the rest stay pending until a later poll.
+ALTER TABLE campaigns ADD COLUMN IF NOT EXISTS fanout_limit INTEGER CHECK (fanout_limit > 0);
+ALTER TABLE campaigns ADD COLUMN IF NOT EXISTS fanout_interval_seconds INTEGER NOT NULL DEFAULT 30 CHECK (fanout_interval_seconds > 0);
+
+-- Counting a campaign's recent sends.
+CREATE INDEX IF NOT EXISTS idx_campaign_targets_campaign_sent_at ON campaign_targets(campaign_id, sent_at) WHERE sent_at IS NOT NULL;
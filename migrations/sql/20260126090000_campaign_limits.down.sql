@@ -0,0 +1,5 @@
+DROP INDEX IF EXISTS idx_campaigns_open_timeout;
+ALTER TABLE campaign_targets DROP COLUMN IF EXISTS truncated;
+ALTER TABLE campaigns DROP COLUMN IF EXISTS timeout_seconds;
+ALTER TABLE campaigns DROP COLUMN IF EXISTS max_bytes_per_host;
+ALTER TABLE campaigns DROP COLUMN IF EXISTS max_rows_per_host;
@@ -0,0 +1,10 @@
+-- Per-campaign caps: results over max_rows_per_host or max_bytes_per_host
+-- are truncated when a host writes them, and targets still outstanding
+-- timeout_seconds after the campaign was created are failed.
+ALTER TABLE campaigns ADD COLUMN IF NOT EXISTS max_rows_per_host INTEGER CHECK (max_rows_per_host > 0);
+ALTER TABLE campaigns ADD COLUMN IF NOT EXISTS max_bytes_per_host BIGINT CHECK (max_bytes_per_host > 0);
+ALTER TABLE campaigns ADD COLUMN IF NOT EXISTS timeout_seconds INTEGER CHECK (timeout_seconds > 0);
+ALTER TABLE campaign_targets ADD COLUMN IF NOT EXISTS truncated BOOLEAN NOT NULL DEFAULT FALSE;
+
+-- The stale-target job's scan for open campaigns with a deadline.
+CREATE INDEX IF NOT EXISTS idx_campaigns_open_timeout ON campaigns(created_at) WHERE timeout_seconds IS NOT NULL AND status IN ('pending', 'running');
@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS admin_audit_log;
+ALTER TABLE organizations DROP COLUMN IF EXISTS disabled_at;
+ALTER TABLE users DROP COLUMN IF EXISTS is_superuser;
@@ -0,0 +1,20 @@
+-- Superusers can reach the /admin console. There is no UI to grant the flag;
+-- use `queryops admin grant-superuser`.
+ALTER TABLE users ADD COLUMN IF NOT EXISTS is_superuser BOOLEAN NOT NULL DEFAULT FALSE;
+
+-- A disabled organization is hidden from its members and refuses new
+-- enrollments until a superuser enables it again.
+ALTER TABLE organizations ADD COLUMN IF NOT EXISTS disabled_at TIMESTAMPTZ;
+
+-- Actions taken from the admin console. Rows outlive the user and
+-- organization they mention.
+CREATE TABLE IF NOT EXISTS admin_audit_log (
+    id BIGSERIAL PRIMARY KEY,
+    actor_user_id INTEGER REFERENCES users(id) ON DELETE SET NULL,
+    action TEXT NOT NULL,
+    organization_id UUID REFERENCES organizations(id) ON DELETE SET NULL,
+    details JSONB NOT NULL DEFAULT '{}',
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_admin_audit_log_created_at ON admin_audit_log(created_at DESC);
@@ -0,0 +1,3 @@
+-- While a superuser impersonates a user, audit log entries name both: the
+-- superuser as the actor and the user they were acting as.
+ALTER TABLE admin_audit_log ADD COLUMN IF NOT EXISTS impersonated_user_id INTEGER REFERENCES users(id) ON DELETE SET NULL;
@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS status_alert_cursor;
+DROP TABLE IF EXISTS status_alert_hosts;
+DROP TABLE IF EXISTS status_alert_rules;
@@ -0,0 +1,34 @@
+-- Status log alert rules notify an organization when a host logs a status
+-- message at or above min_severity (0 info, 1 warning, 2 error, 3 fatal)
+-- whose text matches pattern, a case-insensitive regular expression. An empty
+-- pattern matches every message.
+CREATE TABLE IF NOT EXISTS status_alert_rules (
+    id BIGSERIAL PRIMARY KEY,
+    organization_id UUID NOT NULL REFERENCES organizations(id) ON DELETE CASCADE,
+    min_severity INTEGER NOT NULL CHECK (min_severity BETWEEN 0 AND 3),
+    pattern TEXT NOT NULL DEFAULT '',
+    webhook_url TEXT,
+    description TEXT NOT NULL DEFAULT '',
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    UNIQUE (organization_id, min_severity, pattern)
+);
+
+-- When each rule last alerted about each host, so a looping agent alerts once
+-- per cooldown rather than on every sweep.
+CREATE TABLE IF NOT EXISTS status_alert_hosts (
+    rule_id BIGINT NOT NULL REFERENCES status_alert_rules(id) ON DELETE CASCADE,
+    host_id UUID NOT NULL REFERENCES hosts(id) ON DELETE CASCADE,
+    alerted_at TIMESTAMPTZ NOT NULL,
+    PRIMARY KEY (rule_id, host_id)
+);
+
+-- The last osquery_status_logs row the alert sweep has evaluated. It starts
+-- at the newest existing row so history doesn't alert.
+CREATE TABLE IF NOT EXISTS status_alert_cursor (
+    id BOOLEAN PRIMARY KEY DEFAULT TRUE CHECK (id),
+    last_log_id BIGINT NOT NULL
+);
+
+INSERT INTO status_alert_cursor (last_log_id)
+SELECT COALESCE(MAX(id), 0) FROM osquery_status_logs
+ON CONFLICT (id) DO NOTHING;
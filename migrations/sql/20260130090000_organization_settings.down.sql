@@ -0,0 +1,8 @@
+DROP INDEX IF EXISTS idx_osquery_status_logs_created_at;
+DROP INDEX IF EXISTS idx_osquery_results_created_at;
+DROP TABLE IF EXISTS organization_settings;
+
+UPDATE organization_members SET role = 'member' WHERE role = 'admin';
+ALTER TABLE organization_members DROP CONSTRAINT IF EXISTS organization_members_role_check;
+ALTER TABLE organization_members
+    ADD CONSTRAINT organization_members_role_check CHECK (role IN ('owner', 'member'));
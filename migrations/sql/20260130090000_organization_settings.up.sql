@@ -0,0 +1,21 @@
+-- Admins manage an organization's settings alongside its owners.
+ALTER TABLE organization_members DROP CONSTRAINT IF EXISTS organization_members_role_check;
+ALTER TABLE organization_members
+    ADD CONSTRAINT organization_members_role_check CHECK (role IN ('owner', 'admin', 'member'));
+
+-- Per-organization defaults. A NULL retention keeps results forever; a NULL
+-- interval leaves the osquery config's own value.
+CREATE TABLE IF NOT EXISTS organization_settings (
+    organization_id UUID PRIMARY KEY REFERENCES organizations(id) ON DELETE CASCADE,
+    result_retention_days INTEGER CHECK (result_retention_days BETWEEN 1 AND 3650),
+    distributed_interval INTEGER CHECK (distributed_interval BETWEEN 1 AND 86400),
+    config_refresh INTEGER CHECK (config_refresh BETWEEN 1 AND 86400),
+    logger_tls_period INTEGER CHECK (logger_tls_period BETWEEN 1 AND 86400),
+    muted_notification_kinds TEXT[] NOT NULL DEFAULT '{}',
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+-- The retention purge deletes by age.
+CREATE INDEX IF NOT EXISTS idx_osquery_results_created_at ON osquery_results(created_at);
+CREATE INDEX IF NOT EXISTS idx_osquery_status_logs_created_at ON osquery_status_logs(created_at);
@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS security_events;
+DROP TABLE IF EXISTS email_changes;
+ALTER TABLE users DROP COLUMN IF EXISTS sessions_valid_after;
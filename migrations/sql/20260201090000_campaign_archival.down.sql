@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS idx_campaigns_org_unarchived_created_at;
+ALTER TABLE organization_settings DROP COLUMN IF EXISTS campaign_archive_days;
+ALTER TABLE campaigns DROP COLUMN IF EXISTS archived_at;
@@ -0,0 +1,13 @@
+-- Archived campaigns are hidden from the campaigns list but kept, results and
+-- all, until they're unarchived.
+ALTER TABLE campaigns ADD COLUMN IF NOT EXISTS archived_at TIMESTAMPTZ;
+
+-- Finished campaigns older than this many days are archived automatically. A
+-- NULL keeps them in the list until someone archives them.
+ALTER TABLE organization_settings
+    ADD COLUMN IF NOT EXISTS campaign_archive_days INTEGER CHECK (campaign_archive_days BETWEEN 1 AND 3650);
+
+-- The campaigns list shows an organization's unarchived campaigns, newest first.
+CREATE INDEX IF NOT EXISTS idx_campaigns_org_unarchived_created_at
+    ON campaigns(organization_id, created_at DESC)
+    WHERE archived_at IS NULL;
@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS result_anomalies;
+DROP TABLE IF EXISTS result_baseline_rules;
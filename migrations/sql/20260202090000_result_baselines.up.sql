@@ -0,0 +1,38 @@
+-- Result baseline rules watch one scheduled query's results on every host of
+-- an organization. Each hour's row count is compared with the host's hourly
+-- counts over the previous week, and values of the listed columns that the
+-- host hasn't reported that week are flagged as new.
+CREATE TABLE IF NOT EXISTS result_baseline_rules (
+    id BIGSERIAL PRIMARY KEY,
+    organization_id UUID NOT NULL REFERENCES organizations(id) ON DELETE CASCADE,
+    query_name TEXT NOT NULL,
+    columns TEXT[] NOT NULL DEFAULT '{}',
+    -- threshold is how many standard deviations from the mean an hour's row
+    -- count must be to be flagged.
+    threshold DOUBLE PRECISION NOT NULL DEFAULT 3 CHECK (threshold > 0),
+    description TEXT NOT NULL DEFAULT '',
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    UNIQUE (organization_id, query_name)
+);
+
+-- Deviations found by the baseline job. kind is row_count, with observed and
+-- expected counts, or new_value, with the column and value. hour is the hour
+-- of results evaluated, so a rerun doesn't flag the same deviation twice.
+CREATE TABLE IF NOT EXISTS result_anomalies (
+    id BIGSERIAL PRIMARY KEY,
+    rule_id BIGINT NOT NULL REFERENCES result_baseline_rules(id) ON DELETE CASCADE,
+    organization_id UUID NOT NULL REFERENCES organizations(id) ON DELETE CASCADE,
+    host_id UUID NOT NULL REFERENCES hosts(id) ON DELETE CASCADE,
+    query_name TEXT NOT NULL,
+    kind TEXT NOT NULL CHECK (kind IN ('row_count', 'new_value')),
+    column_name TEXT NOT NULL DEFAULT '',
+    value TEXT NOT NULL DEFAULT '',
+    observed DOUBLE PRECISION,
+    expected DOUBLE PRECISION,
+    stddev DOUBLE PRECISION,
+    hour TIMESTAMPTZ NOT NULL,
+    detected_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    UNIQUE (rule_id, host_id, hour, kind, column_name, value)
+);
+
+CREATE INDEX IF NOT EXISTS idx_result_anomalies_org_detected_at ON result_anomalies(organization_id, detected_at DESC);
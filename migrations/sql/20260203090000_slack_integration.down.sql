@@ -0,0 +1,5 @@
+DROP INDEX IF EXISTS idx_hosts_enroll_unannounced;
+ALTER TABLE hosts DROP COLUMN IF EXISTS enroll_announced_at;
+DROP TABLE IF EXISTS slack_messages;
+DROP TABLE IF EXISTS slack_routes;
+DROP TABLE IF EXISTS slack_integrations;
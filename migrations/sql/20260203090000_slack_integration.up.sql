@@ -0,0 +1,47 @@
+-- An organization's Slack connection. An incoming webhook posts to the
+-- channel it was created for; a bot token, encrypted with ENCRYPTION_KEYS,
+-- posts to any channel the bot has been added to.
+CREATE TABLE IF NOT EXISTS slack_integrations (
+    organization_id UUID PRIMARY KEY REFERENCES organizations(id) ON DELETE CASCADE,
+    webhook_url TEXT,
+    bot_token_ciphertext TEXT,
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+-- Which events are posted where. An empty channel posts through the webhook.
+-- template is a Go text/template for the message; empty uses the default.
+CREATE TABLE IF NOT EXISTS slack_routes (
+    id BIGSERIAL PRIMARY KEY,
+    organization_id UUID NOT NULL REFERENCES organizations(id) ON DELETE CASCADE,
+    event TEXT NOT NULL,
+    channel TEXT NOT NULL DEFAULT '',
+    template TEXT NOT NULL DEFAULT '',
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    UNIQUE (organization_id, event, channel)
+);
+
+-- Messages queued for a route, posted by the delivery job. They're queued in
+-- the transaction that creates the event, and rendered when posted. A failed
+-- post is retried from next_attempt_at until attempts runs out.
+CREATE TABLE IF NOT EXISTS slack_messages (
+    id BIGSERIAL PRIMARY KEY,
+    route_id BIGINT NOT NULL REFERENCES slack_routes(id) ON DELETE CASCADE,
+    organization_id UUID NOT NULL REFERENCES organizations(id) ON DELETE CASCADE,
+    title TEXT NOT NULL,
+    body TEXT NOT NULL DEFAULT '',
+    link TEXT,
+    attempts INTEGER NOT NULL DEFAULT 0,
+    next_attempt_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    last_error TEXT,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    sent_at TIMESTAMPTZ
+);
+
+CREATE INDEX IF NOT EXISTS idx_slack_messages_pending ON slack_messages(next_attempt_at) WHERE sent_at IS NULL;
+
+-- When a new host's enrollment was announced. Hosts enrolled before this
+-- migration count as announced.
+ALTER TABLE hosts ADD COLUMN IF NOT EXISTS enroll_announced_at TIMESTAMPTZ;
+UPDATE hosts SET enroll_announced_at = created_at WHERE enroll_announced_at IS NULL;
+
+CREATE INDEX IF NOT EXISTS idx_hosts_enroll_unannounced ON hosts(created_at) WHERE enroll_announced_at IS NULL;
@@ -0,0 +1,47 @@
+-- Paging services an organization opens incidents in. key_ciphertext is the
+-- PagerDuty integration key or Opsgenie API key, encrypted with
+-- ENCRYPTION_KEYS. A destination pages for hosts offline longer than
+-- offline_hours (0 never) and, if status_alerts is set, for status log alert
+-- rule matches, limited to host_group_id's hosts when set.
+CREATE TABLE IF NOT EXISTS alert_destinations (
+    id BIGSERIAL PRIMARY KEY,
+    organization_id UUID NOT NULL REFERENCES organizations(id) ON DELETE CASCADE,
+    name TEXT NOT NULL,
+    provider TEXT NOT NULL CHECK (provider IN ('pagerduty', 'opsgenie', 'opsgenie_eu')),
+    key_ciphertext TEXT NOT NULL,
+    offline_hours INTEGER NOT NULL DEFAULT 0 CHECK (offline_hours >= 0),
+    status_alerts BOOLEAN NOT NULL DEFAULT FALSE,
+    host_group_id UUID REFERENCES host_groups(id) ON DELETE SET NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    UNIQUE (organization_id, name)
+);
+
+-- Incidents opened in a destination. Each is triggered, and later resolved,
+-- by the alerting job; the pending step is retried from next_attempt_at until
+-- attempts runs out. dedup_key identifies the condition, such as a host being
+-- offline, and only one incident per condition is open at a time. Providers
+-- see it prefixed with the incident's id, so a condition that recurs opens a
+-- new incident there too.
+CREATE TABLE IF NOT EXISTS alert_incidents (
+    id BIGSERIAL PRIMARY KEY,
+    destination_id BIGINT NOT NULL REFERENCES alert_destinations(id) ON DELETE CASCADE,
+    organization_id UUID NOT NULL REFERENCES organizations(id) ON DELETE CASCADE,
+    kind TEXT NOT NULL CHECK (kind IN ('host_offline', 'status_alert')),
+    dedup_key TEXT NOT NULL,
+    host_id UUID REFERENCES hosts(id) ON DELETE SET NULL,
+    rule_id BIGINT REFERENCES status_alert_rules(id) ON DELETE SET NULL,
+    severity TEXT NOT NULL,
+    summary TEXT NOT NULL,
+    source TEXT NOT NULL,
+    link TEXT,
+    triggered_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    trigger_sent_at TIMESTAMPTZ,
+    resolved_at TIMESTAMPTZ,
+    resolve_sent_at TIMESTAMPTZ,
+    attempts INTEGER NOT NULL DEFAULT 0,
+    next_attempt_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    last_error TEXT
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_alert_incidents_open ON alert_incidents(destination_id, dedup_key) WHERE resolved_at IS NULL;
+CREATE INDEX IF NOT EXISTS idx_alert_incidents_pending ON alert_incidents(next_attempt_at) WHERE resolve_sent_at IS NULL;
@@ -0,0 +1,11 @@
+DROP TABLE IF EXISTS host_enrollments;
+
+-- Split hosts share a host_identifier; the old index can't be restored until
+-- all but one of each are deleted.
+DROP INDEX IF EXISTS idx_hosts_org_host_identity;
+CREATE UNIQUE INDEX IF NOT EXISTS idx_hosts_org_host_identifier ON hosts(organization_id, host_identifier);
+
+ALTER TABLE hosts
+    DROP COLUMN IF EXISTS identity_conflict_at,
+    DROP COLUMN IF EXISTS identity_key,
+    DROP COLUMN IF EXISTS hardware_uuid;
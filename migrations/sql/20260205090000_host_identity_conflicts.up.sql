@@ -0,0 +1,24 @@
+-- Machines that share a host_identifier, such as cloned VMs, enroll as the
+-- same host and take its node key from each other. hardware_uuid is the
+-- system_info uuid the host last enrolled with, and host_enrollments keeps a
+-- week of enrollments to spot rapid re-enrollment or alternating hardware.
+-- A flagged host has identity_conflict_at set until it's split or dismissed.
+ALTER TABLE hosts
+    ADD COLUMN IF NOT EXISTS hardware_uuid TEXT NOT NULL DEFAULT '',
+    ADD COLUMN IF NOT EXISTS identity_key TEXT NOT NULL DEFAULT '',
+    ADD COLUMN IF NOT EXISTS identity_conflict_at TIMESTAMPTZ;
+
+-- Splitting a host gives each machine its own record, keyed by its hardware
+-- UUID in identity_key; hosts that were never split have an empty key.
+DROP INDEX IF EXISTS idx_hosts_org_host_identifier;
+CREATE UNIQUE INDEX IF NOT EXISTS idx_hosts_org_host_identity ON hosts(organization_id, host_identifier, identity_key);
+
+CREATE TABLE IF NOT EXISTS host_enrollments (
+    id BIGSERIAL PRIMARY KEY,
+    host_id UUID NOT NULL REFERENCES hosts(id) ON DELETE CASCADE,
+    hardware_uuid TEXT NOT NULL DEFAULT '',
+    hostname TEXT NOT NULL DEFAULT '',
+    enrolled_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_host_enrollments_host ON host_enrollments(host_id, enrolled_at);
@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS idx_hosts_org_identity_key;
+ALTER TABLE hosts DROP COLUMN IF EXISTS osquery_instance_id;
+ALTER TABLE organization_settings DROP COLUMN IF EXISTS host_identity;
@@ -0,0 +1,12 @@
+-- How an organization's enrolling hosts are matched to host records: by
+-- osquery's host_identifier, by hardware UUID, or by osquery instance ID.
+-- The latter two key hosts by hosts.identity_key, so a host keeps its record
+-- when its host_identifier changes.
+ALTER TABLE organization_settings
+    ADD COLUMN IF NOT EXISTS host_identity TEXT NOT NULL DEFAULT 'host_identifier'
+        CHECK (host_identity IN ('host_identifier', 'hardware_uuid', 'instance_id'));
+
+-- osquery_info's instance_id, generated when osquery first runs on a machine.
+ALTER TABLE hosts ADD COLUMN IF NOT EXISTS osquery_instance_id TEXT NOT NULL DEFAULT '';
+
+CREATE INDEX IF NOT EXISTS idx_hosts_org_identity_key ON hosts(organization_id, identity_key) WHERE identity_key <> '';
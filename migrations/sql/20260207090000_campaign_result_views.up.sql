@@ -0,0 +1,13 @@
+-- Named sorts, filters, and column picks of a campaign's results, saved to
+-- come back to or to share. view is the services.CampaignResultView as JSON.
+CREATE TABLE IF NOT EXISTS campaign_result_views (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    organization_id UUID NOT NULL REFERENCES organizations(id) ON DELETE CASCADE,
+    campaign_id UUID NOT NULL REFERENCES campaigns(id) ON DELETE CASCADE,
+    name TEXT NOT NULL,
+    view JSONB NOT NULL DEFAULT '{}',
+    created_by INTEGER REFERENCES users(id) ON DELETE SET NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_campaign_result_views_campaign ON campaign_result_views(campaign_id, created_at);
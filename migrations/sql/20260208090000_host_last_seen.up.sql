@@ -0,0 +1,3 @@
+-- When the host last called /osquery/ping. Hosts are online if they pinged
+-- or wrote logs recently, whichever was later.
+ALTER TABLE hosts ADD COLUMN IF NOT EXISTS last_seen_at TIMESTAMPTZ;
@@ -0,0 +1,15 @@
+-- A host's lifecycle: enrollments, config fetches, queries sent and answered,
+-- and going offline. Rows are copied from host_activity outbox events by a
+-- consumer group; message_id makes redelivered events a no-op.
+CREATE TABLE IF NOT EXISTS host_events (
+    id BIGSERIAL PRIMARY KEY,
+    message_id TEXT NOT NULL UNIQUE,
+    host_id UUID NOT NULL REFERENCES hosts(id) ON DELETE CASCADE,
+    type TEXT NOT NULL,
+    campaign_id UUID,
+    detail TEXT NOT NULL DEFAULT '',
+    occurred_at TIMESTAMPTZ NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_host_events_host_occurred ON host_events(host_id, occurred_at DESC, id DESC);
+CREATE INDEX IF NOT EXISTS idx_host_events_occurred ON host_events(occurred_at);
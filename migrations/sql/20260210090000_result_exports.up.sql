@@ -0,0 +1,20 @@
+-- Result exports: an organization's scheduled query results from a time
+-- range, written as Parquet files by a River job for analysis outside
+-- Postgres. destination is where the files went once the export completes.
+CREATE TABLE IF NOT EXISTS result_exports (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    organization_id UUID NOT NULL REFERENCES organizations(id) ON DELETE CASCADE,
+    range_start TIMESTAMPTZ NOT NULL,
+    range_end TIMESTAMPTZ NOT NULL,
+    status TEXT NOT NULL DEFAULT 'pending' CHECK (status IN ('pending', 'completed', 'failed')),
+    destination TEXT NOT NULL DEFAULT '',
+    files INTEGER NOT NULL DEFAULT 0,
+    row_count BIGINT NOT NULL DEFAULT 0,
+    error TEXT NOT NULL DEFAULT '',
+    requested_by INTEGER REFERENCES users(id) ON DELETE SET NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    completed_at TIMESTAMPTZ,
+    CHECK (range_start < range_end)
+);
+
+CREATE INDEX IF NOT EXISTS idx_result_exports_org_created ON result_exports(organization_id, created_at DESC);
@@ -0,0 +1,5 @@
+DROP MATERIALIZED VIEW IF EXISTS dashboard_osquery_versions;
+ALTER TABLE hosts
+    DROP COLUMN IF EXISTS osquery_extensions,
+    DROP COLUMN IF EXISTS osquery_config_hash,
+    DROP COLUMN IF EXISTS osquery_version;
@@ -0,0 +1,25 @@
+-- The version, config hash, and extensions status of the osquery each host
+-- runs, from osquery_info at enrollment and in the hourly
+-- queryops_osquery_info vitals. '' until the host reports them.
+ALTER TABLE hosts
+    ADD COLUMN IF NOT EXISTS osquery_version TEXT NOT NULL DEFAULT '',
+    ADD COLUMN IF NOT EXISTS osquery_config_hash TEXT NOT NULL DEFAULT '',
+    ADD COLUMN IF NOT EXISTS osquery_extensions TEXT NOT NULL DEFAULT '';
+
+UPDATE hosts SET
+    osquery_version = COALESCE(osquery_info->>'version', ''),
+    osquery_config_hash = COALESCE(osquery_info->>'config_hash', ''),
+    osquery_extensions = COALESCE(osquery_info->>'extensions', '')
+WHERE osquery_info IS NOT NULL;
+
+CREATE MATERIALIZED VIEW IF NOT EXISTS dashboard_osquery_versions AS
+SELECT
+    organization_id,
+    COALESCE(NULLIF(osquery_version, ''), 'unknown') AS osquery_version,
+    COUNT(*)::int AS hosts,
+    NOW() AS refreshed_at
+FROM hosts
+GROUP BY 1, 2;
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_dashboard_osquery_versions
+    ON dashboard_osquery_versions(organization_id, osquery_version);
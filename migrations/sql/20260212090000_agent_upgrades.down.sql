@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS agent_upgrade_hosts;
+DROP TABLE IF EXISTS agent_upgrades;
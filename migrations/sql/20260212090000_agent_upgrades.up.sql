@@ -0,0 +1,37 @@
+-- Agent upgrades roll an organization's hosts on older osquery versions to
+-- target_version in waves of wave_size hosts. Hosts in the first
+-- released_waves waves are told the target in their config; each host is
+-- completed once it reports running the target or newer. An organization
+-- has at most one active upgrade.
+CREATE TABLE IF NOT EXISTS agent_upgrades (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    organization_id UUID NOT NULL REFERENCES organizations(id) ON DELETE CASCADE,
+    target_version TEXT NOT NULL,
+    wave_size INTEGER NOT NULL CHECK (wave_size > 0),
+    waves INTEGER NOT NULL CHECK (waves > 0),
+    released_waves INTEGER NOT NULL DEFAULT 0 CHECK (released_waves BETWEEN 0 AND waves),
+    status TEXT NOT NULL DEFAULT 'active' CHECK (status IN ('active', 'completed', 'cancelled')),
+    created_by INTEGER REFERENCES users(id) ON DELETE SET NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_agent_upgrades_org_created ON agent_upgrades(organization_id, created_at DESC);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_agent_upgrades_org_active
+    ON agent_upgrades(organization_id) WHERE status = 'active';
+
+-- The hosts an upgrade covers. status is 'pending' until the host is first
+-- served the target, 'requested' until it reports running it, then
+-- 'completed'.
+CREATE TABLE IF NOT EXISTS agent_upgrade_hosts (
+    upgrade_id UUID NOT NULL REFERENCES agent_upgrades(id) ON DELETE CASCADE,
+    host_id UUID NOT NULL REFERENCES hosts(id) ON DELETE CASCADE,
+    wave INTEGER NOT NULL CHECK (wave >= 0),
+    from_version TEXT NOT NULL DEFAULT '',
+    status TEXT NOT NULL DEFAULT 'pending' CHECK (status IN ('pending', 'requested', 'completed')),
+    requested_at TIMESTAMPTZ,
+    completed_at TIMESTAMPTZ,
+    PRIMARY KEY (upgrade_id, host_id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_agent_upgrade_hosts_host ON agent_upgrade_hosts(host_id);
@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS api_usage_hourly;
+DROP TABLE IF EXISTS api_usage_samples;
@@ -0,0 +1,27 @@
+-- API requests as metered by the web process, one row per organization and
+-- user per flush. The rollup job folds these into api_usage_hourly and
+-- deletes them.
+CREATE TABLE IF NOT EXISTS api_usage_samples (
+    id BIGSERIAL PRIMARY KEY,
+    organization_id UUID NOT NULL REFERENCES organizations(id) ON DELETE CASCADE,
+    user_id INTEGER NOT NULL,
+    recorded_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    requests BIGINT NOT NULL CHECK (requests >= 0),
+    request_bytes BIGINT NOT NULL CHECK (request_bytes >= 0),
+    response_bytes BIGINT NOT NULL CHECK (response_bytes >= 0)
+);
+
+-- API usage per organization, user and UTC hour. user_id isn't a foreign key
+-- so an organization's history outlives the members who made the requests.
+CREATE TABLE IF NOT EXISTS api_usage_hourly (
+    organization_id UUID NOT NULL REFERENCES organizations(id) ON DELETE CASCADE,
+    user_id INTEGER NOT NULL,
+    hour TIMESTAMPTZ NOT NULL,
+    requests BIGINT NOT NULL DEFAULT 0,
+    request_bytes BIGINT NOT NULL DEFAULT 0,
+    response_bytes BIGINT NOT NULL DEFAULT 0,
+    PRIMARY KEY (organization_id, user_id, hour)
+);
+
+CREATE INDEX IF NOT EXISTS idx_api_usage_samples_org_recorded ON api_usage_samples(organization_id, recorded_at);
+CREATE INDEX IF NOT EXISTS idx_api_usage_hourly_hour ON api_usage_hourly(hour);
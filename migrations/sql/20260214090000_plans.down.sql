@@ -0,0 +1,5 @@
+DROP INDEX IF EXISTS idx_organizations_plan;
+ALTER TABLE organizations
+    DROP COLUMN IF EXISTS extra_features,
+    DROP COLUMN IF EXISTS plan_id;
+DROP TABLE IF EXISTS plans;
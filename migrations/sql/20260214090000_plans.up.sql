@@ -0,0 +1,26 @@
+-- Plans bundle the features an organization may use with its limits. NULL
+-- limits fall back to the configured default quotas; 0 means unlimited.
+-- rank orders plans from cheapest to most capable.
+CREATE TABLE IF NOT EXISTS plans (
+    id TEXT PRIMARY KEY,
+    name TEXT NOT NULL,
+    rank INTEGER NOT NULL UNIQUE,
+    features TEXT[] NOT NULL DEFAULT '{}',
+    max_hosts INTEGER CHECK (max_hosts >= 0),
+    max_campaigns_per_day INTEGER CHECK (max_campaigns_per_day >= 0),
+    max_result_log_bytes_per_day BIGINT CHECK (max_result_log_bytes_per_day >= 0)
+);
+
+INSERT INTO plans (id, name, rank, features, max_hosts, max_campaigns_per_day, max_result_log_bytes_per_day) VALUES
+    ('free', 'Free', 0, '{}', 10, 50, 104857600),
+    ('team', 'Team', 1, '{webhooks}', 500, 1000, 10737418240),
+    ('enterprise', 'Enterprise', 2, '{webhooks,result_exports}', NULL, NULL, NULL)
+ON CONFLICT (id) DO NOTHING;
+
+-- Existing organizations keep every feature and the configured quotas.
+-- extra_features are granted by a superuser on top of the plan's.
+ALTER TABLE organizations
+    ADD COLUMN IF NOT EXISTS plan_id TEXT NOT NULL DEFAULT 'enterprise' REFERENCES plans(id),
+    ADD COLUMN IF NOT EXISTS extra_features TEXT[] NOT NULL DEFAULT '{}';
+
+CREATE INDEX IF NOT EXISTS idx_organizations_plan ON organizations(plan_id);
@@ -0,0 +1,18 @@
+-- Feature flags gate risky features while they roll out. A flag's enabled is
+-- its default; an override turns it on or off for one organization. Flags
+-- without a row are off.
+CREATE TABLE IF NOT EXISTS feature_flags (
+    name TEXT PRIMARY KEY,
+    enabled BOOLEAN NOT NULL DEFAULT false,
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE TABLE IF NOT EXISTS feature_flag_overrides (
+    flag TEXT NOT NULL REFERENCES feature_flags(name) ON DELETE CASCADE,
+    organization_id UUID NOT NULL REFERENCES organizations(id) ON DELETE CASCADE,
+    enabled BOOLEAN NOT NULL,
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    PRIMARY KEY (flag, organization_id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_feature_flag_overrides_org ON feature_flag_overrides(organization_id);
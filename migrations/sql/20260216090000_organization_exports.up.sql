@@ -0,0 +1,23 @@
+-- Organization exports: a zip of an organization's hosts, campaigns, result
+-- metadata, settings, and audit log, built by a River job for members who
+-- need their data elsewhere. Ready exports expire; a periodic job deletes
+-- them.
+CREATE TABLE IF NOT EXISTS organization_exports (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    organization_id UUID NOT NULL REFERENCES organizations(id) ON DELETE CASCADE,
+    status TEXT NOT NULL DEFAULT 'pending' CHECK (status IN ('pending', 'ready', 'failed')),
+    filename TEXT NOT NULL DEFAULT '',
+    content BYTEA,
+    size BIGINT NOT NULL DEFAULT 0,
+    sha256 TEXT NOT NULL DEFAULT '',
+    error TEXT NOT NULL DEFAULT '',
+    requested_by INTEGER REFERENCES users(id) ON DELETE SET NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    completed_at TIMESTAMPTZ,
+    expires_at TIMESTAMPTZ,
+    CHECK (status <> 'ready' OR (content IS NOT NULL AND expires_at IS NOT NULL))
+);
+
+CREATE INDEX IF NOT EXISTS idx_organization_exports_org_created_at ON organization_exports(organization_id, created_at DESC);
+-- One export at a time per organization.
+CREATE UNIQUE INDEX IF NOT EXISTS idx_organization_exports_pending ON organization_exports(organization_id) WHERE status = 'pending';
@@ -0,0 +1,16 @@
+-- A user's request to delete their account. The link emailed to them sets
+-- confirmed_at and delete_after; a worker deletes the account once
+-- delete_after passes, unless they cancel first. Only the SHA-256 of the
+-- token is stored.
+CREATE TABLE IF NOT EXISTS account_deletions (
+    user_id INTEGER PRIMARY KEY REFERENCES users(id) ON DELETE CASCADE,
+    token_hash BYTEA NOT NULL UNIQUE,
+    token_expires_at TIMESTAMPTZ NOT NULL,
+    confirmed_at TIMESTAMPTZ,
+    delete_after TIMESTAMPTZ,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    CHECK ((confirmed_at IS NULL) = (delete_after IS NULL))
+);
+
+CREATE INDEX IF NOT EXISTS idx_account_deletions_delete_after ON account_deletions (delete_after)
+    WHERE delete_after IS NOT NULL;
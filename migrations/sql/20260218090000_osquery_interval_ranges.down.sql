@@ -0,0 +1,8 @@
+ALTER TABLE organization_settings
+    DROP CONSTRAINT IF EXISTS organization_settings_distributed_interval_check,
+    DROP CONSTRAINT IF EXISTS organization_settings_config_refresh_check,
+    DROP CONSTRAINT IF EXISTS organization_settings_logger_tls_period_check;
+ALTER TABLE organization_settings
+    ADD CONSTRAINT organization_settings_distributed_interval_check CHECK (distributed_interval BETWEEN 1 AND 86400),
+    ADD CONSTRAINT organization_settings_config_refresh_check CHECK (config_refresh BETWEEN 1 AND 86400),
+    ADD CONSTRAINT organization_settings_logger_tls_period_check CHECK (logger_tls_period BETWEEN 1 AND 86400);
@@ -0,0 +1,15 @@
+-- Each osquery interval gets its own range instead of 1 to 86400 seconds.
+-- Values saved outside the new ranges are moved to the nearest bound.
+UPDATE organization_settings
+SET distributed_interval = LEAST(GREATEST(distributed_interval, 5), 3600),
+    config_refresh = LEAST(GREATEST(config_refresh, 60), 86400),
+    logger_tls_period = LEAST(GREATEST(logger_tls_period, 2), 3600);
+
+ALTER TABLE organization_settings
+    DROP CONSTRAINT IF EXISTS organization_settings_distributed_interval_check,
+    DROP CONSTRAINT IF EXISTS organization_settings_config_refresh_check,
+    DROP CONSTRAINT IF EXISTS organization_settings_logger_tls_period_check;
+ALTER TABLE organization_settings
+    ADD CONSTRAINT organization_settings_distributed_interval_check CHECK (distributed_interval BETWEEN 5 AND 3600),
+    ADD CONSTRAINT organization_settings_config_refresh_check CHECK (config_refresh BETWEEN 60 AND 86400),
+    ADD CONSTRAINT organization_settings_logger_tls_period_check CHECK (logger_tls_period BETWEEN 2 AND 3600);
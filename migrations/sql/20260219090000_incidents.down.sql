@@ -0,0 +1,4 @@
+DROP TABLE IF EXISTS incident_events;
+DROP TABLE IF EXISTS incident_hosts;
+DROP TABLE IF EXISTS incident_campaigns;
+DROP TABLE IF EXISTS incidents;
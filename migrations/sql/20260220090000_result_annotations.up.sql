@@ -0,0 +1,14 @@
+-- Triage state and comments analysts put on a campaign's result rows, or on a
+-- host's results as a whole. row_key is the md5 of the row's JSON, or empty
+-- for the host.
+CREATE TABLE IF NOT EXISTS result_annotations (
+    organization_id UUID NOT NULL REFERENCES organizations(id) ON DELETE CASCADE,
+    campaign_id UUID NOT NULL REFERENCES campaigns(id) ON DELETE CASCADE,
+    host_id UUID NOT NULL REFERENCES hosts(id) ON DELETE CASCADE,
+    row_key TEXT NOT NULL DEFAULT '',
+    state TEXT NOT NULL CHECK (state IN ('triaged', 'suspicious', 'false_positive')),
+    comment TEXT NOT NULL DEFAULT '' CHECK (length(comment) <= 2000),
+    updated_by INTEGER REFERENCES users(id) ON DELETE SET NULL,
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (campaign_id, host_id, row_key)
+);
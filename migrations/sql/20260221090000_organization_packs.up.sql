@@ -0,0 +1,11 @@
+-- Packs from the query library an organization has enabled, copied as they
+-- were when enabled. They're added to the config every host in the
+-- organization is served.
+CREATE TABLE IF NOT EXISTS organization_packs (
+    organization_id UUID NOT NULL REFERENCES organizations(id) ON DELETE CASCADE,
+    name TEXT NOT NULL,
+    pack JSONB NOT NULL,
+    enabled_by INTEGER REFERENCES users(id) ON DELETE SET NULL,
+    enabled_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (organization_id, name)
+);
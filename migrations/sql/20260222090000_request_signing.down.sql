@@ -0,0 +1,5 @@
+ALTER TABLE hosts
+    DROP COLUMN IF EXISTS signed_request_at,
+    DROP COLUMN IF EXISTS signing_salt;
+
+ALTER TABLE organization_settings DROP COLUMN IF EXISTS request_signing;
@@ -0,0 +1,12 @@
+-- Whether an organization's hosts must sign their requests with the key
+-- they're given at enrollment: 'monitor' logs requests that aren't signed
+-- with it, and 'enforce' rejects them.
+ALTER TABLE organization_settings
+    ADD COLUMN IF NOT EXISTS request_signing TEXT NOT NULL DEFAULT 'off'
+        CHECK (request_signing IN ('off', 'monitor', 'enforce'));
+
+-- The salt a host's request signing key is derived from, issued when it
+-- first enrolls, and when it last sent a config request signed with it.
+ALTER TABLE hosts
+    ADD COLUMN IF NOT EXISTS signing_salt BYTEA,
+    ADD COLUMN IF NOT EXISTS signed_request_at TIMESTAMPTZ;
@@ -0,0 +1,15 @@
+ALTER TABLE campaign_targets DROP COLUMN IF EXISTS quarantine_id;
+ALTER TABLE osquery_results DROP COLUMN IF EXISTS quarantine_id;
+
+-- Release quarantined hosts back to the config they had.
+UPDATE hosts h
+SET config_id = q.previous_config_id
+FROM host_quarantines q
+WHERE q.id = h.quarantine_id;
+
+ALTER TABLE hosts DROP COLUMN IF EXISTS quarantine_id;
+DROP TABLE IF EXISTS host_quarantines;
+
+DELETE FROM osquery_configs c
+WHERE c.name = 'quarantine'
+    AND NOT EXISTS (SELECT 1 FROM hosts h WHERE h.config_id = c.id);
@@ -0,0 +1,86 @@
+-- Quarantining a host points it at the 'quarantine' osquery config and
+-- remembers the config it had, which releasing it restores. A host's open
+-- quarantine is hosts.quarantine_id.
+CREATE TABLE IF NOT EXISTS host_quarantines (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    organization_id UUID NOT NULL REFERENCES organizations(id) ON DELETE CASCADE,
+    host_id UUID NOT NULL REFERENCES hosts(id) ON DELETE CASCADE,
+    reason TEXT NOT NULL DEFAULT '',
+    previous_config_id INTEGER REFERENCES osquery_configs(id) ON DELETE SET NULL,
+    quarantined_by INTEGER REFERENCES users(id) ON DELETE SET NULL,
+    quarantined_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    released_by INTEGER REFERENCES users(id) ON DELETE SET NULL,
+    released_at TIMESTAMPTZ
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_host_quarantines_open ON host_quarantines(host_id) WHERE released_at IS NULL;
+CREATE INDEX IF NOT EXISTS idx_host_quarantines_host ON host_quarantines(host_id, quarantined_at DESC);
+
+ALTER TABLE hosts
+    ADD COLUMN IF NOT EXISTS quarantine_id UUID REFERENCES host_quarantines(id) ON DELETE SET NULL;
+
+-- Results a host returns while quarantined are tagged with the quarantine.
+-- There's no foreign key: the tags go away with the host, and checking one
+-- on every result log row would slow ingest.
+ALTER TABLE osquery_results ADD COLUMN IF NOT EXISTS quarantine_id UUID;
+ALTER TABLE campaign_targets ADD COLUMN IF NOT EXISTS quarantine_id UUID;
+
+-- The restricted profile quarantined hosts are served: frequent check-ins and
+-- log flushes, and a detection pack watching for persistence, remote access,
+-- and lateral movement. Superusers can change it like any other config.
+INSERT INTO osquery_configs (name, config) VALUES ('quarantine', '{
+    "options": {
+        "config_refresh": 60,
+        "distributed_interval": 10,
+        "logger_tls_period": 10,
+        "schedule_splay_percent": 0
+    },
+    "schedule": {
+        "uptime": {
+            "query": "SELECT * FROM uptime;",
+            "interval": 60
+        }
+    },
+    "packs": {
+        "quarantine-detection": {
+            "queries": {
+                "processes": {
+                    "query": "SELECT pid, parent, name, path, cmdline, uid, on_disk FROM processes;",
+                    "interval": 60
+                },
+                "listening_ports": {
+                    "query": "SELECT lp.pid, p.name, lp.port, lp.protocol, lp.address FROM listening_ports lp JOIN processes p USING (pid);",
+                    "interval": 60
+                },
+                "remote_connections": {
+                    "query": "SELECT s.pid, p.name, s.local_port, s.remote_address, s.remote_port FROM process_open_sockets s JOIN processes p USING (pid) WHERE s.remote_address NOT IN (''0.0.0.0'', ''::'', ''127.0.0.1'', ''::1'', '''');",
+                    "interval": 60
+                },
+                "logged_in_users": {
+                    "query": "SELECT type, user, tty, host, time FROM logged_in_users;",
+                    "interval": 60
+                },
+                "startup_items": {
+                    "query": "SELECT name, path, source, status, username FROM startup_items;",
+                    "interval": 300
+                },
+                "crontab": {
+                    "query": "SELECT command, path, minute, hour FROM crontab;",
+                    "interval": 300,
+                    "platform": "darwin,linux"
+                },
+                "kernel_modules": {
+                    "query": "SELECT name, size, status FROM kernel_modules;",
+                    "interval": 300,
+                    "platform": "linux"
+                },
+                "authorized_keys": {
+                    "query": "SELECT u.username, k.key_file, k.algorithm FROM users u JOIN authorized_keys k USING (uid);",
+                    "interval": 300,
+                    "platform": "darwin,linux"
+                }
+            }
+        }
+    }
+}'::jsonb)
+ON CONFLICT (name) DO NOTHING;
@@ -0,0 +1,3 @@
+ALTER TABLE osquery_results DROP COLUMN IF EXISTS decorations;
+ALTER TABLE organization_settings DROP COLUMN IF EXISTS decorator_tag;
+ALTER TABLE organization_settings DROP COLUMN IF EXISTS decorators;
@@ -0,0 +1,11 @@
+-- Decorators an organization adds to every config its hosts are served:
+-- the hostname, the hardware UUID, and decorator_tag as org_tag.
+ALTER TABLE organization_settings
+    ADD COLUMN IF NOT EXISTS decorators TEXT[] NOT NULL DEFAULT '{}'
+        CHECK (decorators <@ ARRAY['hostname', 'hardware_uuid', 'org_tag']),
+    ADD COLUMN IF NOT EXISTS decorator_tag TEXT NOT NULL DEFAULT ''
+        CHECK (decorator_tag ~ '^[A-Za-z0-9._:-]{0,64}$');
+
+-- The decorations osquery sent with each result log line, so exports carry
+-- the host context the line was logged with.
+ALTER TABLE osquery_results ADD COLUMN IF NOT EXISTS decorations JSONB;
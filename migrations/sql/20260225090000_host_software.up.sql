@@ -0,0 +1,13 @@
+-- Software installed on each host, replaced by each snapshot of the
+-- queryops_software_* ingest hook queries. source is the osquery table it's
+-- listed in, such as deb_packages or programs.
+CREATE TABLE IF NOT EXISTS host_software (
+    host_id UUID NOT NULL REFERENCES hosts(id) ON DELETE CASCADE,
+    source TEXT NOT NULL,
+    name TEXT NOT NULL,
+    version TEXT NOT NULL DEFAULT '',
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (host_id, source, name, version)
+);
+
+CREATE INDEX IF NOT EXISTS idx_host_software_name ON host_software(name, version);
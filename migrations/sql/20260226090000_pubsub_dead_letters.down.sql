@@ -0,0 +1 @@
+DROP TABLE IF EXISTS pubsub_dead_letters;
@@ -0,0 +1,15 @@
+CREATE TABLE IF NOT EXISTS pubsub_dead_letters (
+    id BIGSERIAL PRIMARY KEY,
+    consumer TEXT NOT NULL,
+    topic TEXT NOT NULL,
+    message_id TEXT NOT NULL,
+    payload BYTEA NOT NULL,
+    metadata JSONB NOT NULL DEFAULT '{}',
+    error TEXT NOT NULL,
+    attempts INTEGER NOT NULL DEFAULT 1,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    UNIQUE (consumer, message_id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_pubsub_dead_letters_created_at ON pubsub_dead_letters(created_at);
@@ -0,0 +1,14 @@
+-- Bearer tokens for the gRPC API, each scoped to one organization. Only the
+-- SHA-256 of a token is stored; its plaintext is shown once, when created.
+CREATE TABLE IF NOT EXISTS organization_api_tokens (
+    id BIGSERIAL PRIMARY KEY,
+    organization_id UUID NOT NULL REFERENCES organizations(id) ON DELETE CASCADE,
+    token_hash BYTEA NOT NULL UNIQUE,
+    name TEXT NOT NULL,
+    created_by INTEGER REFERENCES users(id) ON DELETE SET NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    last_used_at TIMESTAMPTZ,
+    revoked_at TIMESTAMPTZ
+);
+
+CREATE INDEX IF NOT EXISTS idx_organization_api_tokens_organization_id ON organization_api_tokens(organization_id);
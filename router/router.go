@@ -1,39 +1,41 @@
 package router
 
 import (
-	"context"
 	"errors"
 	"fmt"
 	"net/http"
-	"sync"
 
 	"github.com/cavenine/queryops/config"
-	accountFeature "github.com/cavenine/queryops/features/account"
 	authFeature "github.com/cavenine/queryops/features/auth"
 	counterFeature "github.com/cavenine/queryops/features/counter"
-	indexFeature "github.com/cavenine/queryops/features/index"
 	monitorFeature "github.com/cavenine/queryops/features/monitor"
 	organizationFeature "github.com/cavenine/queryops/features/organization"
-	osqueryFeature "github.com/cavenine/queryops/features/osquery"
 	reverseFeature "github.com/cavenine/queryops/features/reverse"
 	sortableFeature "github.com/cavenine/queryops/features/sortable"
-	"github.com/cavenine/queryops/internal/pubsub"
+	"github.com/cavenine/queryops/internal/app"
+	"github.com/cavenine/queryops/internal/httpbody"
+	"github.com/cavenine/queryops/internal/livereload"
 	"github.com/cavenine/queryops/web/resources"
 
-	"github.com/alexedwards/scs/v2"
 	"github.com/go-chi/chi/v5"
-	"github.com/jackc/pgx/v5/pgxpool"
-	"github.com/starfederation/datastar-go/datastar"
 )
 
-func SetupRoutes(_ context.Context, router chi.Router, sessionManager *scs.SessionManager, pool *pgxpool.Pool, ps *pubsub.PubSub) error {
+// SetupRoutes mounts a's features on router. The caller runs
+// a.Osquery.Logs() and a.Organizations.APIUsage() and closes them after the
+// HTTP server stops, so accepted /logger batches and metered API usage are
+// written before exit.
+func SetupRoutes(router chi.Router, a *app.App) error {
 	if config.Global.Environment == config.Dev {
 		setupReload(router)
 	}
 
 	// Healthcheck for kamal-proxy readiness.
 	router.Get("/up", func(w http.ResponseWriter, r *http.Request) {
-		if err := pool.Ping(r.Context()); err != nil {
+		if a.Breaker.Open() {
+			http.Error(w, "database not ready", http.StatusServiceUnavailable)
+			return
+		}
+		if err := a.Pool.Ping(r.Context()); err != nil {
 			http.Error(w, "database not ready", http.StatusServiceUnavailable)
 			return
 		}
@@ -44,51 +46,68 @@ func SetupRoutes(_ context.Context, router chi.Router, sessionManager *scs.Sessi
 	// Static assets (public)
 	router.Handle("/static/*", resources.Handler())
 
-	// Initialize Organization feature
-	orgFeature := organizationFeature.NewFeature(pool, sessionManager)
-	orgService := orgFeature.Service()
+	sessionManager := a.Sessions
+	orgService := a.Organizations.Service()
+	featureFlags := a.Flags.Middleware(organizationFeature.GetOrganizationIDFromContext)
 
 	// Osquery endpoints (public)
-	osqueryFeature.SetupRoutes(router, pool, orgService, ps)
+	a.Osquery.SetupRoutes(router)
 
-	// Initialize auth feature (creates services once)
-	auth, err := authFeature.NewAuthFeature(sessionManager, pool)
-	if err != nil {
-		return fmt.Errorf("initializing auth feature: %w", err)
-	}
-
-	// Auth routes (public) - wrapped with LoadAndSave for session access
+	// Auth routes (public) - wrapped with LoadAndSave for session access.
+	// Sessions are stored in the database, so degraded mode comes first.
 	router.Group(func(r chi.Router) {
+		r.Use(httpbody.Limit(config.Global.MaxAuthBodyBytes))
+		r.Use(a.Degraded.Middleware)
 		r.Use(sessionManager.LoadAndSave)
-		auth.SetupPublicRoutes(r)
+		a.Auth.SetupPublicRoutes(r)
 	})
 
 	// Protected routes - require authentication
 	var setupErr error
 	router.Group(func(r chi.Router) {
+		r.Use(httpbody.Limit(config.Global.MaxBodyBytes))
+		r.Use(a.Degraded.Middleware)
 		r.Use(sessionManager.LoadAndSave)
-		r.Use(authFeature.RequireAuth(auth.UserService(), sessionManager))
+		r.Use(authFeature.RequireAuth(a.Auth.UserService(), sessionManager))
+		r.Use(a.Admin.AuditImpersonation)
 
-		auth.SetupProtectedRoutes(r)
+		a.Auth.SetupProtectedRoutes(r)
+		a.Admin.SetupImpersonationRoutes(r)
+		a.Notifications.SetupRoutes(r)
 
 		// Account routes should have org context for the sidebar switcher,
 		// but should not force onboarding redirects.
 		r.Group(func(r chi.Router) {
 			r.Use(organizationFeature.LoadOrganizations(orgService, sessionManager))
-			accountFeature.SetupRoutes(r, auth.CredentialRepo())
+			r.Use(featureFlags)
+			a.Account.SetupRoutes(r)
+		})
+
+		// The admin console spans organizations, so it needs none active.
+		r.Group(func(r chi.Router) {
+			r.Use(organizationFeature.LoadOrganizations(orgService, sessionManager))
+			r.Use(authFeature.RequireSuperuser)
+			r.Use(featureFlags)
+			a.Admin.SetupRoutes(r)
+			a.Osquery.SetupAdminRoutes(r)
 		})
 
 		// Onboarding routes
-		orgFeature.SetupOnboardingRoutes(r)
+		a.Organizations.SetupOnboardingRoutes(r)
 
 		// Routes requiring an active organization
 		r.Group(func(r chi.Router) {
 			r.Use(organizationFeature.RequireOrganization(orgService, sessionManager))
+			r.Use(a.Organizations.APIUsage().Middleware)
+			r.Use(featureFlags)
 
-			osqueryFeature.SetupProtectedRoutes(r, pool, orgService, ps)
+			a.Osquery.SetupProtectedRoutes(r)
+			a.Organizations.SetupSettingsRoutes(r)
+			a.Dashboard.SetupRoutes(r)
+			a.Incidents.SetupRoutes(r)
 
 			if setupErr = errors.Join(
-				indexFeature.SetupRoutes(r, sessionManager, pool, orgService),
+				a.Index.SetupRoutes(r),
 				counterFeature.SetupRoutes(r, sessionManager),
 				monitorFeature.SetupRoutes(r),
 				sortableFeature.SetupRoutes(r),
@@ -103,35 +122,14 @@ func SetupRoutes(_ context.Context, router chi.Router, sessionManager *scs.Sessi
 		return fmt.Errorf("error setting up routes: %w", setupErr)
 	}
 
+	// Live streams are only known once every route is mounted.
+	if err := a.Admin.SkipLiveStreams(router); err != nil {
+		return fmt.Errorf("error finding live streams: %w", err)
+	}
+
 	return nil
 }
 
 func setupReload(router chi.Router) {
-	reloadChan := make(chan struct{}, 1)
-	var hotReloadOnce sync.Once
-
-	router.Get("/reload", func(w http.ResponseWriter, r *http.Request) {
-		sse := datastar.NewSSE(w, r)
-		reload := func() {
-			if err := sse.ExecuteScript("window.location.reload()"); err != nil {
-				// We can't do much here as SSE might be closed, but we should satisfy errcheck
-				return
-			}
-		}
-		hotReloadOnce.Do(reload)
-		select {
-		case <-reloadChan:
-			reload()
-		case <-r.Context().Done():
-		}
-	})
-
-	router.Get("/hotreload", func(w http.ResponseWriter, _ *http.Request) {
-		select {
-		case reloadChan <- struct{}{}:
-		default:
-		}
-		w.WriteHeader(http.StatusOK)
-		_, _ = w.Write([]byte("OK"))
-	})
+	router.Handle(livereload.Path, livereload.Proxy(config.Global.LiveReloadAddr))
 }
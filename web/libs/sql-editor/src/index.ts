@@ -0,0 +1,119 @@
+import { autocompletion, type Completion } from "@codemirror/autocomplete";
+import { defaultKeymap, history, historyKeymap } from "@codemirror/commands";
+import { SQLite, sql } from "@codemirror/lang-sql";
+import { defaultHighlightStyle, syntaxHighlighting } from "@codemirror/language";
+import { Compartment, EditorState } from "@codemirror/state";
+import { EditorView, keymap, placeholder } from "@codemirror/view";
+
+type Column = { name: string; type: string; description: string };
+type Table = { name: string; description: string; columns: Column[] };
+
+// Schemas are shared by every editor on the page, e.g. one per host dialog.
+const schemas = new Map<string, Promise<Table[]>>();
+
+function loadSchema(platform: string): Promise<Table[]> {
+  let schema = schemas.get(platform);
+  if (!schema) {
+    const params = platform ? `?platform=${encodeURIComponent(platform)}` : "";
+    schema = fetch(`/api/v1/schema${params}`, { credentials: "same-origin" }).then((resp) => {
+      if (!resp.ok) {
+        throw new Error(`loading osquery schema: ${resp.status}`);
+      }
+      return resp.json() as Promise<Table[]>;
+    });
+    schemas.set(platform, schema);
+  }
+  return schema;
+}
+
+function language(tables: Table[]) {
+  const schema: Record<string, Completion[]> = {};
+  for (const table of tables) {
+    schema[table.name] = table.columns.map((column) => ({
+      label: column.name,
+      type: "property",
+      detail: column.type.toLowerCase(),
+      info: column.description,
+    }));
+  }
+
+  return sql({
+    dialect: SQLite,
+    schema,
+    tables: tables.map((table) => ({ label: table.name, type: "type", info: table.description })),
+    upperCaseKeywords: true,
+  });
+}
+
+const theme = EditorView.theme({
+  "&": { height: "100%", fontSize: "inherit" },
+  "&.cm-focused": { outline: "none" },
+  ".cm-scroller": { fontFamily: "inherit", overflow: "auto" },
+  ".cm-content": { padding: "0" },
+});
+
+class SQLEditor extends HTMLElement {
+  private view?: EditorView;
+
+  connectedCallback() {
+    const textarea = this.querySelector("textarea");
+    if (!textarea || this.view) {
+      return;
+    }
+
+    const lang = new Compartment();
+    let syncing = false;
+    const container = document.createElement("div");
+    container.className = textarea.className;
+    textarea.after(container);
+    textarea.hidden = true;
+
+    const view = new EditorView({
+      parent: container,
+      state: EditorState.create({
+        doc: textarea.value,
+        extensions: [
+          history(),
+          keymap.of([...defaultKeymap, ...historyKeymap]),
+          lang.of(sql({ dialect: SQLite, upperCaseKeywords: true })),
+          syntaxHighlighting(defaultHighlightStyle, { fallback: true }),
+          autocompletion(),
+          placeholder(textarea.placeholder),
+          EditorView.lineWrapping,
+          theme,
+          EditorView.updateListener.of((update) => {
+            if (update.docChanged) {
+              syncing = true;
+              textarea.value = update.state.doc.toString();
+              syncing = false;
+              textarea.dispatchEvent(new Event("input", { bubbles: true }));
+            }
+          }),
+        ],
+      }),
+    });
+    this.view = view;
+
+    // Signals set the textarea's value directly, without an event; mirror
+    // those writes into the editor.
+    const proto = Object.getOwnPropertyDescriptor(HTMLTextAreaElement.prototype, "value")!;
+    Object.defineProperty(textarea, "value", {
+      configurable: true,
+      get() {
+        return proto.get!.call(this);
+      },
+      set(value: string) {
+        proto.set!.call(this, value);
+        if (!syncing && value !== view.state.doc.toString()) {
+          view.dispatch({ changes: { from: 0, to: view.state.doc.length, insert: value } });
+        }
+      },
+    });
+
+    loadSchema(this.getAttribute("platform") ?? "")
+      .then((tables) => view.dispatch({ effects: lang.reconfigure(language(tables)) }))
+      .catch((err) => console.warn(err));
+  }
+}
+
+customElements.define("sql-editor", SQLEditor);
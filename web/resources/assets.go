@@ -1,7 +1,147 @@
 package resources
 
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/fs"
+	"log/slog"
+	"net/http"
+	"path"
+	"strings"
+	"time"
+)
+
 const (
 	LibsDirectoryPath   = "web/libs"
 	StylesDirectoryPath = "web/resources/styles"
 	StaticDirectoryPath = "web/resources/static"
+
+	// ManifestFileName is the asset manifest written to the static directory
+	// by cmd/web/build.
+	ManifestFileName = "manifest.json"
 )
+
+// Manifest maps logical asset names, e.g. "libs/sql-editor.js", to their
+// content-hashed names, e.g. "libs/sql-editor-5XQ2MZ7B.js". Both are relative
+// to the static directory.
+type Manifest map[string]string
+
+// HashedName returns name with a hash of content inserted before the
+// extension: "index.css" becomes "index-1a2b3c4d.css".
+func HashedName(name string, content []byte) string {
+	sum := sha256.Sum256(content)
+	ext := path.Ext(name)
+	return strings.TrimSuffix(name, ext) + "-" + hex.EncodeToString(sum[:4]) + ext
+}
+
+// assetServer serves the static directory. Hashed names are served with
+// immutable cache headers; logical names must be revalidated.
+type assetServer struct {
+	fsys     fs.FS
+	manifest Manifest
+	// files maps each hashed name to the file it is served from: the name
+	// itself for outputs esbuild wrote hashed, or the logical name for files
+	// hashed only in the manifest.
+	files map[string]string
+	// etags maps every servable name to its entity tag.
+	etags map[string]string
+}
+
+func newAssetServer(fsys fs.FS) *assetServer {
+	s := &assetServer{fsys: fsys, manifest: Manifest{}, files: map[string]string{}, etags: map[string]string{}}
+
+	data, err := fs.ReadFile(fsys, ManifestFileName)
+	if err == nil {
+		err = json.Unmarshal(data, &s.manifest)
+	}
+	switch {
+	case errors.Is(err, fs.ErrNotExist):
+		slog.Warn("static asset manifest not found; assets are served unversioned", "manifest", ManifestFileName)
+	case err != nil:
+		slog.Error("failed to load static asset manifest; assets are served unversioned", "error", err)
+		s.manifest = Manifest{}
+	}
+
+	for logical, hashed := range s.manifest {
+		if _, err := fs.Stat(fsys, hashed); err == nil {
+			s.files[hashed] = hashed
+		} else {
+			s.files[hashed] = logical
+		}
+		// The hashed name doubles as the entity tag.
+		s.etags[logical] = hashed
+		s.etags[hashed] = hashed
+	}
+
+	// Files outside the manifest are tagged with a hash of their content.
+	err = fs.WalkDir(fsys, ".", func(name string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		if _, ok := s.etags[name]; ok {
+			return nil
+		}
+		content, err := fs.ReadFile(fsys, name)
+		if err != nil {
+			return err
+		}
+		s.etags[name] = HashedName(name, content)
+		return nil
+	})
+	if err != nil {
+		slog.Error("failed to hash static assets", "error", err)
+	}
+	return s
+}
+
+// path returns the URL path for a logical asset name.
+func (s *assetServer) path(name string) string {
+	if hashed, ok := s.manifest[name]; ok {
+		return "/static/" + hashed
+	}
+	return "/static/" + name
+}
+
+func (s *assetServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	name := strings.TrimPrefix(r.URL.Path, "/static/")
+
+	file, immutable := s.files[name]
+	if !immutable {
+		file = name
+		if hashed, ok := s.manifest[name]; ok {
+			file = s.files[hashed]
+		}
+	}
+	if etag, ok := s.etags[name]; ok {
+		// Weak, because the compression middleware may re-encode the body.
+		w.Header().Set("ETag", `W/"`+etag+`"`)
+	}
+
+	f, err := s.fsys.Open(file)
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil || info.IsDir() {
+		http.NotFound(w, r)
+		return
+	}
+	content, ok := f.(io.ReadSeeker)
+	if !ok {
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+
+	if immutable {
+		w.Header().Set("Cache-Control", "public, max-age=31536000, immutable")
+	} else {
+		w.Header().Set("Cache-Control", "no-cache")
+	}
+	http.ServeContent(w, r, name, time.Time{}, content)
+}
@@ -0,0 +1,117 @@
+package resources
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"testing/fstest"
+)
+
+func testAssets() *assetServer {
+	return newAssetServer(fstest.MapFS{
+		"manifest.json": {Data: []byte(`{
+			"libs/editor.js": "libs/editor-AAAA1111.js",
+			"index.css": "index-bbbb2222.css"
+		}`)},
+		"libs/editor-AAAA1111.js": {Data: []byte("editor()")},
+		"index.css":               {Data: []byte("body{}")},
+		"plain.js":                {Data: []byte("plain()")},
+	})
+}
+
+func TestHashedName(t *testing.T) {
+	got := HashedName("index.css", []byte("body{}"))
+	if got != HashedName("index.css", []byte("body{}")) {
+		t.Fatal("HashedName is not deterministic")
+	}
+	if got == HashedName("index.css", []byte("body{ }")) {
+		t.Fatal("HashedName ignores content")
+	}
+	if len(got) != len("index-12345678.css") {
+		t.Fatalf("HashedName = %q", got)
+	}
+}
+
+func TestAssetServerPath(t *testing.T) {
+	s := testAssets()
+
+	tests := map[string]string{
+		"libs/editor.js": "/static/libs/editor-AAAA1111.js",
+		"index.css":      "/static/index-bbbb2222.css",
+		"plain.js":       "/static/plain.js",
+	}
+	for name, want := range tests {
+		if got := s.path(name); got != want {
+			t.Errorf("path(%q) = %q, want %q", name, got, want)
+		}
+	}
+}
+
+func TestAssetServerServeHTTP(t *testing.T) {
+	s := testAssets()
+
+	tests := []struct {
+		path         string
+		status       int
+		body         string
+		cacheControl string
+		etag         string
+	}{
+		{path: "/static/libs/editor-AAAA1111.js", status: http.StatusOK, body: "editor()", cacheControl: "public, max-age=31536000, immutable", etag: `W/"libs/editor-AAAA1111.js"`},
+		{path: "/static/index-bbbb2222.css", status: http.StatusOK, body: "body{}", cacheControl: "public, max-age=31536000, immutable", etag: `W/"index-bbbb2222.css"`},
+		{path: "/static/libs/editor.js", status: http.StatusOK, body: "editor()", cacheControl: "no-cache", etag: `W/"libs/editor-AAAA1111.js"`},
+		{path: "/static/index.css", status: http.StatusOK, body: "body{}", cacheControl: "no-cache", etag: `W/"index-bbbb2222.css"`},
+		{path: "/static/plain.js", status: http.StatusOK, body: "plain()", cacheControl: "no-cache", etag: `W/"` + HashedName("plain.js", []byte("plain()")) + `"`},
+		{path: "/static/missing.js", status: http.StatusNotFound},
+		{path: "/static/libs", status: http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			s.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, tt.path, nil))
+
+			if rec.Code != tt.status {
+				t.Fatalf("status = %d, want %d", rec.Code, tt.status)
+			}
+			if tt.status != http.StatusOK {
+				return
+			}
+			if rec.Body.String() != tt.body {
+				t.Errorf("body = %q, want %q", rec.Body.String(), tt.body)
+			}
+			if got := rec.Header().Get("Cache-Control"); got != tt.cacheControl {
+				t.Errorf("Cache-Control = %q, want %q", got, tt.cacheControl)
+			}
+			if got := rec.Header().Get("ETag"); got != tt.etag {
+				t.Errorf("ETag = %q, want %q", got, tt.etag)
+			}
+		})
+	}
+}
+
+func TestAssetServerNotModified(t *testing.T) {
+	s := testAssets()
+
+	req := httptest.NewRequest(http.MethodGet, "/static/index.css", nil)
+	req.Header.Set("If-None-Match", `"index-bbbb2222.css"`)
+	rec := httptest.NewRecorder()
+	s.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusNotModified {
+		t.Fatalf("status = %d, want %d", rec.Code, http.StatusNotModified)
+	}
+}
+
+func TestAssetServerWithoutManifest(t *testing.T) {
+	s := newAssetServer(fstest.MapFS{"index.css": {Data: []byte("body{}")}})
+
+	if got := s.path("index.css"); got != "/static/index.css" {
+		t.Errorf("path = %q", got)
+	}
+
+	rec := httptest.NewRecorder()
+	s.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/static/index.css", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d", rec.Code)
+	}
+}
@@ -1,4 +1,44 @@
 (function () {
+  // Proof-of-work: find a nonce such that SHA-256(token + ":" + nonce) starts
+  // with `difficulty` zero bits. Must match antibot.solves on the server.
+  function leadingZeroBits(bytes) {
+    var bits = 0;
+    for (var i = 0; i < bytes.length; i++) {
+      var b = bytes[i];
+      if (b === 0) {
+        bits += 8;
+        continue;
+      }
+      while ((b & 0x80) === 0) {
+        bits++;
+        b <<= 1;
+      }
+      break;
+    }
+    return bits;
+  }
+
+  async function solve(token, difficulty) {
+    var encoder = new TextEncoder();
+    for (var n = 0; ; n++) {
+      var digest = await crypto.subtle.digest("SHA-256", encoder.encode(token + ":" + n));
+      if (leadingZeroBits(new Uint8Array(digest)) >= difficulty) {
+        return String(n);
+      }
+    }
+  }
+
+  // Solutions in progress, keyed by token.
+  var solutions = {};
+
+  function solution(el) {
+    var token = el.getAttribute("data-antibot-token");
+    var difficulty = parseInt(el.getAttribute("data-antibot-pow") || "0", 10);
+    if (!token || !(difficulty > 0) || !window.crypto || !crypto.subtle) return null;
+    if (!solutions[token]) solutions[token] = solve(token, difficulty);
+    return solutions[token];
+  }
+
   function applyAntibot(root) {
     var scope = root || document;
     var forms = scope.querySelectorAll("form[data-antibot-token]");
@@ -11,6 +51,20 @@
       var input = form.querySelector('input[name="js_token"]');
       if (input) input.value = token;
 
+      // Start solving as soon as the form renders so submit rarely waits.
+      var pending = solution(form);
+      if (pending) {
+        pending.then(
+          (function (f, t) {
+            return function (nonce) {
+              if (f.getAttribute("data-antibot-token") !== t) return;
+              var powInput = f.querySelector('input[name="pow_nonce"]');
+              if (powInput) powInput.value = nonce;
+            };
+          })(form, token)
+        );
+      }
+
       if (form.getAttribute("data-antibot-listener") !== "true") {
         form.setAttribute("data-antibot-listener", "true");
         form.addEventListener("submit", function (e) {
@@ -20,11 +74,49 @@
           if (!t) return;
           var i2 = f.querySelector('input[name="js_token"]');
           if (i2) i2.value = t;
+
+          var powInput = f.querySelector('input[name="pow_nonce"]');
+          var p = solution(f);
+          if (!p || !powInput || powInput.value) return;
+
+          // Not solved yet: hold the submit until it is.
+          e.preventDefault();
+          var buttons = f.querySelectorAll('button[type="submit"]');
+          for (var j = 0; j < buttons.length; j++) buttons[j].disabled = true;
+          p.then(function (nonce) {
+            powInput.value = nonce;
+            f.submit();
+          });
         });
       }
     }
   }
 
+  // For scripts calling a protected endpoint from an element carrying the
+  // antibot attributes (see antibot.Attrs):
+  //
+  //   fetch(url, { headers: await antibot.headers(el) })
+  //   antibot.update(el, response) // keep the fresh challenge for a retry
+  window.antibot = {
+    headers: async function (el) {
+      var headers = {};
+      var token = el && el.getAttribute("data-antibot-token");
+      if (!token) return headers;
+      headers["X-Antibot-Token"] = token;
+      var pending = solution(el);
+      if (pending) headers["X-Antibot-PoW"] = await pending;
+      return headers;
+    },
+    update: function (el, response) {
+      var token = response.headers.get("X-Antibot-Token");
+      if (!el || !token) return;
+      el.setAttribute("data-antibot-token", token);
+      el.setAttribute("data-antibot-pow", response.headers.get("X-Antibot-PoW-Difficulty") || "0");
+      // Start on the next solution straight away.
+      solution(el);
+    },
+  };
+
   document.addEventListener("DOMContentLoaded", function () {
     applyAntibot(document);
   });
@@ -0,0 +1,44 @@
+(function () {
+  // Keep in sync with timeSince and HostOnlineWindow in
+  // features/osquery/pages/hosts.templ.
+  var ONLINE_WINDOW_MS = 5 * 60 * 1000;
+  var REFRESH_MS = 30 * 1000;
+
+  function timeSince(t, now) {
+    var d = now - t;
+    if (d < 60 * 1000) return "Just now";
+    if (d < 60 * 60 * 1000) return Math.floor(d / 60000) + " mins ago";
+    if (d < 24 * 60 * 60 * 1000) return Math.floor(d / 3600000) + " hours ago";
+    return new Date(t).toISOString().slice(0, 10);
+  }
+
+  function refresh() {
+    var now = Date.now();
+    var cells = document.querySelectorAll("[data-last-seen]");
+
+    for (var i = 0; i < cells.length; i++) {
+      var cell = cells[i];
+      var value = cell.getAttribute("data-last-seen");
+      if (!value) continue;
+
+      var t = Date.parse(value);
+      if (isNaN(t)) continue;
+      cell.textContent = timeSince(t, now);
+
+      var row = cell.closest("tr");
+      var status = row && row.querySelector("[data-host-status]");
+      if (!status) continue;
+
+      var online = now - t < ONLINE_WINDOW_MS;
+      var dot = status.firstElementChild;
+      var label = status.lastElementChild;
+      if (dot) {
+        dot.classList.toggle("bg-success", online);
+        dot.classList.toggle("bg-error", !online);
+      }
+      if (label) label.textContent = online ? "Online" : "Offline";
+    }
+  }
+
+  setInterval(refresh, REFRESH_MS);
+})();
@@ -0,0 +1,136 @@
+// Live tables (hosts, host results, campaign results) stream over SSE with
+// Datastar's @get. Some corporate proxies buffer SSE until the response ends,
+// so nothing ever arrives. When a stream sends nothing within STALL_MS of
+// starting, this aborts it and reads the same events over a WebSocket
+// instead, handing each to Datastar as its own SSE reader would. The choice
+// is kept for the rest of the browser session.
+//
+// Pages start a stream with pages.LiveStream, which expands to
+//   queryopsLive.connect(el, url) ||
+//     @get(url, {requestCancellation: queryopsLive.watch(el, url)})
+(function () {
+  var STALL_MS = 5000;
+  var RETRY_MIN_MS = 1000;
+  var RETRY_MAX_MS = 30000;
+  var STORAGE_KEY = "queryops.live-transport";
+  // Sent by the server when a stream is finished, e.g. a completed campaign.
+  var NORMAL_CLOSURE = 1000;
+
+  var watched = new WeakMap();
+
+  function wantsWebSocket() {
+    try {
+      return sessionStorage.getItem(STORAGE_KEY) === "websocket";
+    } catch (e) {
+      return false;
+    }
+  }
+
+  function preferWebSocket() {
+    try {
+      sessionStorage.setItem(STORAGE_KEY, "websocket");
+    } catch (e) {
+      // Storage is unavailable; fall back again on the next page.
+    }
+  }
+
+  // dispatch hands one SSE-formatted event to Datastar.
+  function dispatch(el, message) {
+    var type = "";
+    var args = {};
+    var lines = message.split("\n");
+
+    for (var i = 0; i < lines.length; i++) {
+      var line = lines[i];
+      if (line.indexOf("event: ") === 0) {
+        type = line.slice(7);
+        continue;
+      }
+      if (line.indexOf("data: ") !== 0) continue;
+
+      var data = line.slice(6);
+      var space = data.indexOf(" ");
+      var key = data.slice(0, space);
+      var value = data.slice(space + 1);
+      args[key] = key in args ? args[key] + "\n" + value : value;
+    }
+    if (type.indexOf("datastar") !== 0) return;
+
+    document.dispatchEvent(
+      new CustomEvent("datastar-fetch", {
+        detail: { type: type, el: el, argsRaw: args },
+      }),
+    );
+  }
+
+  function open(el, url, retryMs) {
+    var scheme = location.protocol === "https:" ? "wss:" : "ws:";
+    var ws = new WebSocket(scheme + "//" + location.host + url);
+
+    ws.onmessage = function (e) {
+      if (!el.isConnected) {
+        ws.close();
+        return;
+      }
+      retryMs = RETRY_MIN_MS;
+      dispatch(el, e.data);
+    };
+    ws.onclose = function (e) {
+      if (e.code === NORMAL_CLOSURE || !el.isConnected) return;
+      setTimeout(function () {
+        if (el.isConnected) open(el, url, Math.min(retryMs * 2, RETRY_MAX_MS));
+      }, retryMs);
+    };
+  }
+
+  function stalled(el, w) {
+    if (document.hidden) {
+      // Datastar pauses hidden streams; wait until the page is looked at.
+      w.timer = setTimeout(function () {
+        stalled(el, w);
+      }, STALL_MS);
+      return;
+    }
+    watched.delete(el);
+    w.controller.abort();
+    preferWebSocket();
+    open(el, w.url, RETRY_MIN_MS);
+  }
+
+  document.addEventListener("datastar-fetch", function (e) {
+    var detail = e.detail;
+    var w = detail && detail.el && watched.get(detail.el);
+    if (!w) return;
+
+    if (detail.type === "started") {
+      clearTimeout(w.timer);
+      w.timer = setTimeout(function () {
+        stalled(detail.el, w);
+      }, STALL_MS);
+      return;
+    }
+    if (detail.type === "retrying") return;
+
+    // Any event, or the request ending on its own, means SSE gets through.
+    clearTimeout(w.timer);
+    watched.delete(detail.el);
+  });
+
+  window.queryopsLive = {
+    // connect streams url over a WebSocket if this session already found SSE
+    // buffered, and reports whether it did.
+    connect: function (el, url) {
+      if (!wantsWebSocket() || !("WebSocket" in window)) return false;
+      open(el, url, RETRY_MIN_MS);
+      return true;
+    },
+
+    // watch returns the AbortController for el's @get of url and aborts it
+    // in favour of a WebSocket if it stalls.
+    watch: function (el, url) {
+      var controller = new AbortController();
+      watched.set(el, { controller: controller, url: url, timer: null });
+      return controller;
+    },
+  };
+})();
@@ -4,23 +4,32 @@ package resources
 
 import (
 	"embed"
+	"io/fs"
 	"log/slog"
 	"net/http"
-
-	"github.com/benbjohnson/hashfs"
 )
 
 var (
 	//go:embed static
 	StaticDirectory embed.FS
-	StaticSys       = hashfs.NewFS(StaticDirectory)
+	assets          = newAssetServer(mustSub(StaticDirectory, "static"))
 )
 
 func Handler() http.Handler {
 	slog.Debug("static assets are embedded")
-	return hashfs.FileServer(StaticSys)
+	return assets
 }
 
+// StaticPath returns the URL path for a logical asset name, e.g.
+// "datastar/datastar.js", using its content-hashed name from the manifest.
 func StaticPath(path string) string {
-	return "/" + StaticSys.HashName("static/"+path)
+	return assets.path(path)
+}
+
+func mustSub(fsys fs.FS, dir string) fs.FS {
+	sub, err := fs.Sub(fsys, dir)
+	if err != nil {
+		panic(err)
+	}
+	return sub
 }